@@ -59,12 +59,17 @@ generate-clean: ## (Generate) Delete generated files that get checked in to Git
 # Build: artifacts that don't get checked in to Git
 # =================================================
 
-TELEPRESENCE_BASE_VERSION := $(firstword $(shell shasum base-image/Dockerfile))
+# The platforms that the manager/agent image is published for. They must match the
+# install.AgentArchitectures. Set it to a single platform to publish to a registry that can't be
+# reached by a multi-platform build, such as a local one.
+TELEPRESENCE_PLATFORMS ?= linux/amd64,linux/arm64
+
+TELEPRESENCE_BASE_VERSION := $(firstword $(shell (cat base-image/Dockerfile; echo '$(TELEPRESENCE_PLATFORMS)') | shasum))
 .PHONY: base-image
 base-image: base-image/Dockerfile # Intentionally not in 'make help'
-	if ! docker pull $(TELEPRESENCE_REGISTRY)/tel2-base:$(TELEPRESENCE_BASE_VERSION); then \
-	  cd base-image && docker build --pull -t $(TELEPRESENCE_REGISTRY)/tel2-base:$(TELEPRESENCE_BASE_VERSION) . && \
-	  docker push $(TELEPRESENCE_REGISTRY)/tel2-base:$(TELEPRESENCE_BASE_VERSION); \
+	if ! docker buildx imagetools inspect $(TELEPRESENCE_REGISTRY)/tel2-base:$(TELEPRESENCE_BASE_VERSION) >/dev/null 2>&1; then \
+	  cd base-image && docker buildx build --pull --platform=$(TELEPRESENCE_PLATFORMS) --push \
+	    -t $(TELEPRESENCE_REGISTRY)/tel2-base:$(TELEPRESENCE_BASE_VERSION) .; \
 	fi
 
 PKG_VERSION = $(shell go list ./pkg/version)
@@ -74,6 +79,20 @@ build: ## (Build) Build all the source code
 	mkdir -p $(BINDIR)
 	CGO_ENABLED=0 go build -trimpath -ldflags=-X=$(PKG_VERSION).Version=$(TELEPRESENCE_VERSION) -o $(BINDIR) ./cmd/...
 
+# GOEXPERIMENT=boringcrypto is understood by Go 1.19 and later. Older toolchains reject it, or ignore
+# it and silently build binaries that don't use BoringCrypto.
+FIPS_GO_MINOR = 19
+
+.PHONY: build-fips
+build-fips: ## (Build) Build all the source code in FIPS mode. Requires Go 1.19 or later, which has BoringCrypto
+	@minor=$$(go version | sed -n 's/.* go1\.\([0-9]*\).*/\1/p'); \
+	if [ -z "$$minor" ] || [ "$$minor" -lt $(FIPS_GO_MINOR) ]; then \
+	  echo "build-fips requires Go 1.$(FIPS_GO_MINOR) or later for GOEXPERIMENT=boringcrypto, but found: $$(go version)" >&2; \
+	  exit 1; \
+	fi
+	mkdir -p $(BINDIR)
+	GOEXPERIMENT=boringcrypto CGO_ENABLED=1 go build -tags fips -trimpath -ldflags=-X=$(PKG_VERSION).Version=$(TELEPRESENCE_VERSION) -o $(BINDIR) ./cmd/...
+
 .ko.yaml: .ko.yaml.in base-image
 	sed $(foreach v,TELEPRESENCE_REGISTRY TELEPRESENCE_BASE_VERSION, -e 's|@$v@|$($v)|g') <$< >$@
 .PHONY: image push-image
@@ -82,8 +101,20 @@ image: .ko.yaml $(tools/ko) ## (Build) Build/tag the manager/agent container ima
 	docker tag "$$localname" $(TELEPRESENCE_REGISTRY)/tel2:$(patsubst v%,%,$(TELEPRESENCE_VERSION))
 
 .PHONY: push-image
-push-image: image ## (Build) Push the manager/agent container image to $(TELEPRESENCE_REGISTRY)
-	docker push $(TELEPRESENCE_REGISTRY)/tel2:$(patsubst v%,%,$(TELEPRESENCE_VERSION))
+push-image: .ko.yaml $(tools/ko) ## (Build) Push the manager/agent container image for $(TELEPRESENCE_PLATFORMS) to $(TELEPRESENCE_REGISTRY)
+	GOFLAGS="-ldflags=-X=$(PKG_VERSION).Version=$(TELEPRESENCE_VERSION) -trimpath" KO_DOCKER_REPO=$(TELEPRESENCE_REGISTRY)/tel2 \
+	  ko publish --bare --platform=$(TELEPRESENCE_PLATFORMS) --tags=$(patsubst v%,%,$(TELEPRESENCE_VERSION)) ./cmd/traffic
+
+.PHONY: client-image
+client-image: ## (Build) Build/tag the client container image used by 'telepresence connect --docker'
+	mkdir -p $(BUILDDIR)/client-image
+	cp packaging/docker/Dockerfile.client $(BUILDDIR)/client-image/Dockerfile
+	CGO_ENABLED=0 GOOS=linux go build -trimpath -ldflags=-X=$(PKG_VERSION).Version=$(TELEPRESENCE_VERSION) -o $(BUILDDIR)/client-image/telepresence ./cmd/telepresence
+	docker build -t $(TELEPRESENCE_REGISTRY)/telepresence:$(patsubst v%,%,$(TELEPRESENCE_VERSION)) $(BUILDDIR)/client-image
+
+.PHONY: push-client-image
+push-client-image: client-image ## (Build) Push the client container image to $(TELEPRESENCE_REGISTRY)
+	docker push $(TELEPRESENCE_REGISTRY)/telepresence:$(patsubst v%,%,$(TELEPRESENCE_VERSION))
 
 .PHONY: clean
 clean: ## (Build) Remove all build artifacts
@@ -180,6 +211,10 @@ format: $(tools/golangci-lint) $(tools/protolint) ## (QA) Automatically fix lint
 check: $(tools/ko) $(tools/helm) ## (QA) Run the test suite
 	go test -timeout=15m ./...
 
+.PHONY: bench
+bench: ## (QA) Run the data path benchmarks against the cluster that telepresence is connected to, see pkg/benchmark
+	go test -run='^$$' -bench=. ./pkg/benchmark
+
 # Install
 # =======
 
@@ -63,6 +63,7 @@ helm.sh/chart: {{ include "telepresence.chart" . }}
 app.kubernetes.io/version: {{ .Chart.AppVersion | quote }}
 {{- end }}
 app.kubernetes.io/managed-by: {{ .Release.Service }}
+telepresence.getambassador.io/manager-namespace: {{ include "telepresence.namespace" . }}
 {{- end }}
 
 {{/*
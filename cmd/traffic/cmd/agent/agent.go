@@ -10,15 +10,18 @@ import (
 	"time"
 
 	"github.com/sethvargo/go-envconfig"
+	"google.golang.org/grpc"
 
 	"github.com/datawire/dlib/dexec"
 	"github.com/datawire/dlib/dgroup"
 	"github.com/datawire/dlib/dlog"
 	rpc "github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/managerutil"
 	"github.com/telepresenceio/telepresence/v2/pkg/connpool"
 	"github.com/telepresenceio/telepresence/v2/pkg/dpipe"
 	"github.com/telepresenceio/telepresence/v2/pkg/forwarder"
 	"github.com/telepresenceio/telepresence/v2/pkg/iputil"
+	"github.com/telepresenceio/telepresence/v2/pkg/tracing"
 	"github.com/telepresenceio/telepresence/v2/pkg/version"
 )
 
@@ -27,22 +30,46 @@ type Config struct {
 	Namespace   string `env:"AGENT_NAMESPACE,default="`
 	PodIP       string `env:"AGENT_POD_IP,default="`
 	AgentPort   int32  `env:"AGENT_PORT,default=9900"`
+	AppHost     string `env:"APP_HOST,default="`
 	AppMounts   string `env:"APP_MOUNTS,default=/tel_app_mounts"`
 	AppPort     int32  `env:"APP_PORT,required"`
+	AppProtocol string `env:"APP_PROTOCOL,default="`
 	ManagerHost string `env:"MANAGER_HOST,default=traffic-manager"`
 	ManagerPort int32  `env:"MANAGER_PORT,default=8081"`
+
+	TracingEndpoint string `env:"OTEL_EXPORTER_OTLP_ENDPOINT,default="`
+
+	// AppMountsReadOnly makes the sftp-server refuse all writes to the shared volumes
+	AppMountsReadOnly bool `env:"APP_MOUNTS_READ_ONLY,default=false"`
+
+	// The gRPC settings of the connection to the traffic-manager, as Kubernetes quantities such as
+	// "16Mi". Empty means the gRPC default.
+	GRPCMaxReceiveSize        string `env:"GRPC_MAX_RECEIVE_SIZE,default="`
+	GRPCMaxSendSize           string `env:"GRPC_MAX_SEND_SIZE,default="`
+	GRPCInitialWindowSize     string `env:"GRPC_INITIAL_WINDOW_SIZE,default="`
+	GRPCInitialConnWindowSize string `env:"GRPC_INITIAL_CONN_WINDOW_SIZE,default="`
+	GRPCWriteBufferSize       string `env:"GRPC_WRITE_BUFFER_SIZE,default="`
 }
 
 var skipKeys = map[string]bool{
 	// Keys found in the Config
-	"AGENT_NAME":      true,
-	"AGENT_NAMESPACE": true,
-	"AGENT_POD_IP":    true,
-	"AGENT_PORT":      true,
-	"APP_MOUNTS":      true,
-	"APP_PORT":        true,
-	"MANAGER_HOST":    true,
-	"MANAGER_PORT":    true,
+	"AGENT_NAME":           true,
+	"AGENT_NAMESPACE":      true,
+	"AGENT_POD_IP":         true,
+	"AGENT_PORT":           true,
+	"APP_HOST":             true,
+	"APP_MOUNTS":           true,
+	"APP_MOUNTS_READ_ONLY": true,
+	"APP_PORT":             true,
+	"APP_PROTOCOL":         true,
+	"MANAGER_HOST":         true,
+	"MANAGER_PORT":         true,
+
+	"GRPC_MAX_RECEIVE_SIZE":         true,
+	"GRPC_MAX_SEND_SIZE":            true,
+	"GRPC_INITIAL_WINDOW_SIZE":      true,
+	"GRPC_INITIAL_CONN_WINDOW_SIZE": true,
+	"GRPC_WRITE_BUFFER_SIZE":        true,
 
 	// Keys that aren't useful when running on the local machine
 	"HOME":     true,
@@ -109,6 +136,56 @@ func (cfg *Config) HasMounts(ctx context.Context, env map[string]string) (bool,
 	return true, nil
 }
 
+// serveSFTP serves the given connection using an sftp-server that is confined to the shared
+// volumes, and to the service account secrets that are linked into them.
+func (cfg *Config) serveSFTP(ctx context.Context, conn net.Conn) error {
+	roots := []string{cfg.AppMounts}
+	if stat, err := os.Stat(svcAccPath); err == nil && stat.IsDir() {
+		roots = append(roots, svcAccPath)
+	}
+	filter, err := newSFTPFilter(conn, cfg.AppMounts, roots)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	var args []string
+	if cfg.AppMountsReadOnly {
+		args = append(args, "-R")
+	}
+	cmd := dexec.CommandContext(ctx, "/usr/lib/ssh/sftp-server", args...)
+	cmd.Dir = cfg.AppMounts
+	return dpipe.DPipe(ctx, cmd, filter)
+}
+
+// grpcDialOptions returns the dial options that apply the gRPC settings of the config to the
+// connection to the traffic-manager.
+func (cfg *Config) grpcDialOptions() ([]grpc.DialOption, error) {
+	var opts []grpc.DialOption
+	var callOpts []grpc.CallOption
+	for _, s := range []struct {
+		name, value string
+		apply       func(int)
+	}{
+		{"GRPC_MAX_RECEIVE_SIZE", cfg.GRPCMaxReceiveSize, func(sz int) { callOpts = append(callOpts, grpc.MaxCallRecvMsgSize(sz)) }},
+		{"GRPC_MAX_SEND_SIZE", cfg.GRPCMaxSendSize, func(sz int) { callOpts = append(callOpts, grpc.MaxCallSendMsgSize(sz)) }},
+		{"GRPC_INITIAL_WINDOW_SIZE", cfg.GRPCInitialWindowSize, func(sz int) { opts = append(opts, grpc.WithInitialWindowSize(int32(sz))) }},
+		{"GRPC_INITIAL_CONN_WINDOW_SIZE", cfg.GRPCInitialConnWindowSize, func(sz int) { opts = append(opts, grpc.WithInitialConnWindowSize(int32(sz))) }},
+		{"GRPC_WRITE_BUFFER_SIZE", cfg.GRPCWriteBufferSize, func(sz int) { opts = append(opts, grpc.WithWriteBufferSize(sz)) }},
+	} {
+		sz, err := managerutil.ParseGRPCSize(s.name, s.value)
+		if err != nil {
+			return nil, err
+		}
+		if sz > 0 {
+			s.apply(sz)
+		}
+	}
+	if len(callOpts) > 0 {
+		opts = append(opts, grpc.WithDefaultCallOptions(callOpts...))
+	}
+	return opts, nil
+}
+
 func Main(ctx context.Context, args ...string) error {
 	dlog.Infof(ctx, "Traffic Agent %s [pid:%d]", version.Version, os.Getpid())
 
@@ -130,6 +207,13 @@ func Main(ctx context.Context, args ...string) error {
 		return err
 	}
 	dlog.Infof(ctx, "%+v", config)
+	if err := tracing.Init(ctx, "traffic-agent", "", config.TracingEndpoint); err != nil {
+		return err
+	}
+	grpcOpts, err := config.grpcDialOptions()
+	if err != nil {
+		return err
+	}
 
 	info := &rpc.AgentInfo{
 		Name:        config.Name,
@@ -194,8 +278,7 @@ func Main(ctx context.Context, args ...string) error {
 				}
 				go func() {
 					dlog.Debugf(ctx, "Serving sshfs connection from %s", conn.RemoteAddr())
-					err := dpipe.DPipe(ctx, dexec.CommandContext(ctx, "/usr/lib/ssh/sftp-server"), conn)
-					if err != nil {
+					if err := config.serveSFTP(ctx, conn); err != nil {
 						dlog.Error(ctx, err)
 					}
 				}()
@@ -217,7 +300,8 @@ func Main(ctx context.Context, args ...string) error {
 			return err
 		}
 
-		forwarder := forwarder.NewForwarder(lisAddr, "", config.AppPort)
+		forwarder := forwarder.NewForwarder(lisAddr, config.AppHost, config.AppPort)
+		forwarder.SetAppProtocol(config.AppProtocol)
 		forwarderChan <- forwarder
 
 		return forwarder.Serve(ctx)
@@ -240,7 +324,7 @@ func Main(ctx context.Context, args ...string) error {
 		state := NewState(forwarder, config.ManagerHost, config.Namespace, config.PodIP, sftpPort)
 
 		for {
-			if err := TalkToManager(ctx, gRPCAddress, info, state); err != nil {
+			if err := TalkToManager(ctx, gRPCAddress, info, state, grpcOpts...); err != nil {
 				dlog.Info(ctx, err)
 			}
 
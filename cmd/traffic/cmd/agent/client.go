@@ -2,24 +2,30 @@ package agent
 
 import (
 	"context"
+	"io/ioutil"
 	"net"
 	"os"
+	"strings"
 	"time"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/keepalive"
 	empty "google.golang.org/protobuf/types/known/emptypb"
 
 	"github.com/datawire/dlib/dcontext"
 	"github.com/datawire/dlib/dlog"
 	rpc "github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/managerutil"
 	"github.com/telepresenceio/telepresence/v2/pkg/iputil"
+	"github.com/telepresenceio/telepresence/v2/pkg/loglevel"
+	"github.com/telepresenceio/telepresence/v2/pkg/tracing"
 )
 
 func GetAmbassadorCloudConnectionInfo(ctx context.Context, address string) (*rpc.AmbassadorCloudConnection, error) {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
-	conn, err := grpc.DialContext(ctx, address, grpc.WithInsecure(), grpc.WithBlock())
+	conn, err := grpc.DialContext(ctx, address, append(tracing.DialOptions(), grpc.WithInsecure(), grpc.WithBlock())...)
 	if err != nil {
 		return &rpc.AmbassadorCloudConnection{}, err
 	}
@@ -33,11 +39,53 @@ func GetAmbassadorCloudConnectionInfo(ctx context.Context, address string) (*rpc
 	return cloudConnectInfo, nil
 }
 
-func TalkToManager(ctx context.Context, address string, info *rpc.AgentInfo, state State) error {
+// keepaliveInterval is how long the connection to the traffic-manager may stay idle before the
+// agent pings it, and keepaliveTimeout how long the agent waits for the reply before it closes the
+// connection and connects again. They are the defaults of the clients.
+const (
+	keepaliveInterval = 15 * time.Second
+	keepaliveTimeout  = 10 * time.Second
+)
+
+// serviceAccountTokenFile is the token of the ServiceAccount of the pod. The traffic-manager uses it
+// to verify the identity of the traffic-agent when it requires authentication.
+const serviceAccountTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+// serviceAccountToken passes the token of the ServiceAccount of the pod in the metadata of each call.
+// The token is read for each call because the kubelet rotates it.
+type serviceAccountToken string
+
+func (f serviceAccountToken) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	data, err := ioutil.ReadFile(string(f))
+	if err != nil {
+		if os.IsNotExist(err) {
+			// The pod doesn't automount the token, so the traffic-manager decides
+			return nil, nil
+		}
+		return nil, err
+	}
+	return map[string]string{managerutil.IdentityTokenHeader: strings.TrimSpace(string(data))}, nil
+}
+
+func (f serviceAccountToken) RequireTransportSecurity() bool {
+	return false
+}
+
+// TalkToManager connects to the traffic-manager at the given address, using the given dial options
+// in addition to its own, and serves it until the connection is lost.
+func TalkToManager(ctx context.Context, address string, info *rpc.AgentInfo, state State, opts ...grpc.DialOption) error {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
-	conn, err := grpc.DialContext(ctx, address, grpc.WithInsecure(), grpc.WithBlock())
+	conn, err := grpc.DialContext(ctx, address, append(append(tracing.DialOptions(), opts...),
+		grpc.WithInsecure(),
+		grpc.WithBlock(),
+		grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:                keepaliveInterval,
+			Timeout:             keepaliveTimeout,
+			PermitWithoutStream: true,
+		}),
+		grpc.WithPerRPCCredentials(serviceAccountToken(serviceAccountTokenFile)))...)
 	if err != nil {
 		return err
 	}
@@ -118,6 +166,7 @@ func TalkToManager(ctx context.Context, address string, info *rpc.AgentInfo, sta
 	}
 
 	go func() {
+		ctx := loglevel.WithSubsystem(ctx, loglevel.DNS)
 		for ctx.Err() == nil {
 			lr, err := lrStream.Recv()
 			if err != nil {
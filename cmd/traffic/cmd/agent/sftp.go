@@ -0,0 +1,425 @@
+package agent
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// SFTP packet types and status codes of draft-ietf-secsh-filexfer-02, which is the version of the
+// protocol that the OpenSSH sftp-server implements.
+const (
+	sshFxpOpen     = 3
+	sshFxpLstat    = 7
+	sshFxpSetstat  = 9
+	sshFxpOpendir  = 11
+	sshFxpRemove   = 13
+	sshFxpMkdir    = 14
+	sshFxpRmdir    = 15
+	sshFxpRealpath = 16
+	sshFxpStat     = 17
+	sshFxpRename   = 18
+	sshFxpReadlink = 19
+	sshFxpSymlink  = 20
+	sshFxpStatus   = 101
+	sshFxpExtended = 200
+
+	sshFxPermissionDenied = 3
+	sshFxOpUnsupported    = 8
+
+	// maxSFTPPacket is the largest packet accepted from a client, which is what OpenSSH accepts
+	maxSFTPPacket = 256 * 1024
+)
+
+type sftpDenied struct {
+	code uint32
+	msg  string
+}
+
+func (e *sftpDenied) Error() string {
+	return e.msg
+}
+
+// sftpFilter sits between a client and an sftp-server process and confines the client to the
+// given root directories. A request that names a path outside of the roots, after symlinks have
+// been resolved, is denied with a status reply and never reaches the sftp-server. The protocol has
+// no equivalent of O_NOFOLLOW, so the sftp-server gets the resolved paths instead of the ones that
+// the client sent.
+//
+// Requests that change the names in the file system, such as renames and symlinks, are handled by
+// the sftp-server before the filter reads the next request, so that the resolution of the paths
+// of the next request can't be outdated by requests that are still in flight.
+type sftpFilter struct {
+	conn  io.ReadWriteCloser
+	home  string
+	roots []string
+	done  chan struct{}
+	once  sync.Once
+
+	// pending are the bytes of an allowed request that the sftp-server hasn't read yet
+	pending []byte
+
+	mu sync.Mutex
+	// partial is the start of a reply from the sftp-server that hasn't been written in full yet
+	partial []byte
+	// awaited is closed when the reply with the awaitedID is written
+	awaited   chan struct{}
+	awaitedID uint32
+}
+
+// newSFTPFilter returns a filter that confines the client of the given connection to the given
+// roots. Relative paths are relative to home, which must be the working directory of the
+// sftp-server.
+func newSFTPFilter(conn io.ReadWriteCloser, home string, roots []string) (*sftpFilter, error) {
+	resolved := make([]string, len(roots))
+	for i, root := range roots {
+		r, err := filepath.EvalSymlinks(root)
+		if err != nil {
+			return nil, err
+		}
+		resolved[i] = r
+	}
+	return &sftpFilter{conn: conn, home: home, roots: resolved, done: make(chan struct{})}, nil
+}
+
+// Read returns the allowed requests of the client.
+func (f *sftpFilter) Read(p []byte) (int, error) {
+	if len(f.pending) == 0 {
+		f.mu.Lock()
+		awaited := f.awaited
+		f.mu.Unlock()
+		if awaited != nil {
+			select {
+			case <-awaited:
+			case <-f.done:
+				return 0, io.EOF
+			}
+		}
+	}
+	for len(f.pending) == 0 {
+		req, err := readSFTPPacket(f.conn)
+		if err != nil {
+			return 0, err
+		}
+		pkt, err := f.check(req)
+		if err != nil {
+			var denied *sftpDenied
+			if !errors.As(err, &denied) {
+				denied = &sftpDenied{code: sshFxPermissionDenied, msg: err.Error()}
+			}
+			if err = f.deny(req, denied); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		if changesNames(pkt) {
+			f.mu.Lock()
+			f.awaited = make(chan struct{})
+			f.awaitedID = binary.BigEndian.Uint32(pkt[5:])
+			f.mu.Unlock()
+		}
+		f.pending = pkt
+	}
+	n := copy(p, f.pending)
+	f.pending = f.pending[n:]
+	return n, nil
+}
+
+// Write writes the replies of the sftp-server to the client. Only complete replies are written,
+// so that a status reply for a denied request never ends up in the middle of another reply.
+func (f *sftpFilter) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.partial = append(f.partial, p...)
+	end := 0
+	for len(f.partial)-end >= 9 {
+		pktLen := 4 + int(binary.BigEndian.Uint32(f.partial[end:]))
+		if len(f.partial)-end < pktLen {
+			break
+		}
+		if f.awaited != nil && binary.BigEndian.Uint32(f.partial[end+5:]) == f.awaitedID {
+			close(f.awaited)
+			f.awaited = nil
+		}
+		end += pktLen
+	}
+	if end > 0 {
+		if _, err := f.conn.Write(f.partial[:end]); err != nil {
+			return 0, err
+		}
+		f.partial = append(f.partial[:0], f.partial[end:]...)
+	}
+	return len(p), nil
+}
+
+func (f *sftpFilter) Close() error {
+	f.once.Do(func() { close(f.done) })
+	return f.conn.Close()
+}
+
+// deny writes a status reply for the given request to the client.
+func (f *sftpFilter) deny(pkt []byte, denied *sftpDenied) error {
+	msg := denied.msg
+	reply := make([]byte, 0, 21+len(msg))
+	reply = appendUint32(reply, uint32(17+len(msg)))
+	reply = append(reply, sshFxpStatus)
+	reply = append(reply, pkt[5:9]...) // the request id
+	reply = appendUint32(reply, denied.code)
+	reply = appendUint32(reply, uint32(len(msg)))
+	reply = append(reply, msg...)
+	reply = appendUint32(reply, 0) // no language tag
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, err := f.conn.Write(reply)
+	return err
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	var n [4]byte
+	binary.BigEndian.PutUint32(n[:], v)
+	return append(b, n[:]...)
+}
+
+func appendString(b []byte, s string) []byte {
+	return append(appendUint32(b, uint32(len(s))), s...)
+}
+
+// check returns an error if the given request names a path outside of the roots of the filter.
+// Otherwise, it returns the request that the sftp-server gets, in which the paths are replaced by
+// the paths that they resolve to, so that the sftp-server doesn't follow any symlink that the
+// filter has checked. Requests that only use handles are always allowed, because handles are only
+// obtained by allowed requests.
+func (f *sftpFilter) check(pkt []byte) ([]byte, error) {
+	body := pkt[9:]
+	var err error
+	switch pkt[4] {
+	case sshFxpOpen, sshFxpOpendir, sshFxpSetstat, sshFxpMkdir, sshFxpRmdir, sshFxpRealpath, sshFxpStat:
+		body, err = f.checkPaths(body, 1, true)
+	case sshFxpLstat, sshFxpRemove, sshFxpReadlink:
+		body, err = f.checkPaths(body, 1, false)
+	case sshFxpRename:
+		body, err = f.checkPaths(body, 2, false)
+	case sshFxpSymlink:
+		body, err = f.checkSymlink(body)
+	case sshFxpExtended:
+		var name string
+		var rest []byte
+		if name, rest, err = sftpString(body); err != nil {
+			return nil, err
+		}
+		switch name {
+		case "posix-rename@openssh.com", "hardlink@openssh.com":
+			rest, err = f.checkPaths(rest, 2, false)
+		case "statvfs@openssh.com":
+			rest, err = f.checkPaths(rest, 1, true)
+		case "lsetstat@openssh.com":
+			rest, err = f.checkPaths(rest, 1, false)
+		case "fstatvfs@openssh.com", "fsync@openssh.com", "limits@openssh.com", "copy-data":
+		default:
+			return nil, &sftpDenied{code: sshFxOpUnsupported, msg: fmt.Sprintf("%s is not supported", name)}
+		}
+		body = append(appendString(nil, name), rest...)
+	}
+	if err != nil {
+		return nil, err
+	}
+	req := appendUint32(make([]byte, 0, 9+len(body)), uint32(5+len(body)))
+	req = append(req, pkt[4:9]...) // the type and the id
+	return append(req, body...), nil
+}
+
+// checkPaths checks the n paths at the start of the given request body, and returns the body with
+// the paths replaced by the paths that they resolve to. The last element of the paths is resolved
+// only if follow is true.
+func (f *sftpFilter) checkPaths(body []byte, n int, follow bool) ([]byte, error) {
+	var checked []byte
+	for i := 0; i < n; i++ {
+		var p string
+		var err error
+		if p, body, err = sftpString(body); err != nil {
+			return nil, err
+		}
+		if p, err = f.checkPath(p, follow); err != nil {
+			return nil, err
+		}
+		checked = appendString(checked, p)
+	}
+	return append(checked, body...), nil
+}
+
+// checkSymlink checks a symlink request. The OpenSSH sftp-server takes the target of the symlink
+// before the path of the symlink, contrary to the draft. A relative target is relative to the
+// directory of the symlink. The target is stored in the symlink as it is.
+func (f *sftpFilter) checkSymlink(body []byte) ([]byte, error) {
+	target, body, err := sftpString(body)
+	if err != nil {
+		return nil, err
+	}
+	link, body, err := sftpString(body)
+	if err != nil {
+		return nil, err
+	}
+	if link, err = f.checkPath(link, false); err != nil {
+		return nil, err
+	}
+	if hasDotDot(target) {
+		return nil, fmt.Errorf("%s contains \"..\"", target)
+	}
+	absTarget := target
+	if !filepath.IsAbs(absTarget) {
+		absTarget = filepath.Join(filepath.Dir(link), absTarget)
+	}
+	if _, err = f.checkPath(absTarget, true); err != nil {
+		return nil, err
+	}
+	return append(appendString(appendString(nil, target), link), body...), nil
+}
+
+// checkPath returns the path that the given path resolves to, or an error if it's outside of the
+// roots.
+func (f *sftpFilter) checkPath(p string, follow bool) (string, error) {
+	r, err := f.resolve(p, follow)
+	if err != nil {
+		return "", err
+	}
+	for _, root := range f.roots {
+		if r == root || strings.HasPrefix(r, root+"/") {
+			return r, nil
+		}
+	}
+	return "", fmt.Errorf("%s is outside of the shared volumes", p)
+}
+
+func (f *sftpFilter) abs(p string) string {
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(f.home, p)
+	}
+	return p
+}
+
+// maxSymlinks is the number of symlinks that a path may lead through, same as on Linux.
+const maxSymlinks = 40
+
+// resolve returns the absolute path that the given path refers to, with all symlinks resolved.
+// The last element isn't resolved unless follow is true. Paths with ".." elements are rejected,
+// because a ".." that follows a symlink can't be cleaned without resolving the symlink first.
+func (f *sftpFilter) resolve(p string, follow bool) (string, error) {
+	if hasDotDot(p) {
+		return "", fmt.Errorf("%s contains \"..\"", p)
+	}
+	p = filepath.Clean(f.abs(p))
+	last := ""
+	if !follow && p != "/" {
+		p, last = filepath.Dir(p), filepath.Base(p)
+	}
+	r, err := resolveSymlinks(p)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(r, last), nil
+}
+
+// resolveSymlinks returns the given absolute path with all symlinks resolved, one element at a
+// time. A symlink is resolved even when its target doesn't exist, because that's where a file is
+// created when the symlink is opened with SSH_FXF_CREAT. The elements that follow an element that
+// doesn't exist are kept as they are.
+func resolveSymlinks(p string) (string, error) {
+	resolved := "/"
+	todo := strings.Split(p, "/")
+	links := 0
+	for len(todo) > 0 {
+		elem := todo[0]
+		todo = todo[1:]
+		switch elem {
+		case "", ".":
+			continue
+		case "..":
+			// resolved has no symlinks, so its parent is found by removing the last element
+			resolved = filepath.Dir(resolved)
+			continue
+		}
+		next := filepath.Join(resolved, elem)
+		fi, err := os.Lstat(next)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return filepath.Join(append([]string{next}, todo...)...), nil
+			}
+			return "", err
+		}
+		if fi.Mode()&os.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+		if links++; links > maxSymlinks {
+			return "", fmt.Errorf("too many levels of symbolic links in %s", p)
+		}
+		target, err := os.Readlink(next)
+		if err != nil {
+			return "", err
+		}
+		if filepath.IsAbs(target) {
+			resolved = "/"
+		}
+		todo = append(strings.Split(target, "/"), todo...)
+	}
+	return resolved, nil
+}
+
+func hasDotDot(p string) bool {
+	for _, elem := range strings.Split(p, "/") {
+		if elem == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// changesNames returns true if the given request may change what a path refers to.
+func changesNames(pkt []byte) bool {
+	switch pkt[4] {
+	case sshFxpRename, sshFxpSymlink:
+		return true
+	case sshFxpExtended:
+		name, _, _ := sftpString(pkt[9:])
+		return name == "posix-rename@openssh.com" || name == "hardlink@openssh.com"
+	}
+	return false
+}
+
+// readSFTPPacket reads one packet, including its length, from the given reader. The packet has at
+// least a type and an id.
+func readSFTPPacket(r io.Reader) ([]byte, error) {
+	var hdr [4]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(hdr[:])
+	if n < 5 || n > maxSFTPPacket {
+		return nil, fmt.Errorf("invalid sftp packet length %d", n)
+	}
+	pkt := make([]byte, 4+n)
+	copy(pkt, hdr[:])
+	if _, err := io.ReadFull(r, pkt[4:]); err != nil {
+		return nil, err
+	}
+	return pkt, nil
+}
+
+// sftpString returns the string at the start of the given data and the data that follows it.
+func sftpString(data []byte) (string, []byte, error) {
+	if len(data) < 4 {
+		return "", nil, errors.New("malformed sftp request")
+	}
+	n := binary.BigEndian.Uint32(data)
+	data = data[4:]
+	if uint32(len(data)) < n {
+		return "", nil, errors.New("malformed sftp request")
+	}
+	return string(data[:n]), data[n:], nil
+}
@@ -0,0 +1,164 @@
+package agent
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func sftpRequest(typ byte, id uint32, paths ...string) []byte {
+	body := []byte{typ}
+	body = appendUint32(body, id)
+	for _, p := range paths {
+		body = appendUint32(body, uint32(len(p)))
+		body = append(body, p...)
+	}
+	return append(appendUint32(nil, uint32(len(body))), body...)
+}
+
+func testSFTPRoot(t *testing.T) (string, string) {
+	tmp, err := filepath.EvalSymlinks(t.TempDir())
+	require.NoError(t, err)
+	root := filepath.Join(tmp, "mounts")
+	outside := filepath.Join(tmp, "outside")
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "data"), 0700))
+	require.NoError(t, os.MkdirAll(outside, 0700))
+	require.NoError(t, os.Symlink(outside, filepath.Join(root, "escape")))
+	require.NoError(t, os.Symlink(filepath.Join(root, "data"), filepath.Join(root, "inside")))
+	require.NoError(t, os.Symlink(filepath.Join(outside, "new"), filepath.Join(root, "dangling")))
+	require.NoError(t, os.Symlink("../../outside/new", filepath.Join(root, "data", "relative")))
+	return root, outside
+}
+
+func TestSFTPFilter_checkPath(t *testing.T) {
+	root, outside := testSFTPRoot(t)
+	f, err := newSFTPFilter(nil, root, []string{root})
+	require.NoError(t, err)
+
+	tests := []struct {
+		path    string
+		follow  bool
+		allowed bool
+	}{
+		{".", true, true},
+		{root, true, true},
+		{filepath.Join(root, "data", "file"), true, true},
+		{"data/new/file", true, true},
+		{filepath.Join(root, "inside", "file"), true, true},
+		{filepath.Join(root, "escape"), false, true},
+		{filepath.Join(root, "escape"), true, false},
+		{filepath.Join(root, "escape", "file"), false, false},
+		{filepath.Join(root, "data", "..", "..", "outside"), true, false},
+		{filepath.Join(root, "dangling"), false, true},
+		{filepath.Join(root, "dangling"), true, false},
+		{filepath.Join(root, "data", "relative"), true, false},
+		{filepath.Join(root, "data", "relative", "file"), true, false},
+		{outside, true, false},
+		{"/", true, false},
+	}
+	for _, tt := range tests {
+		_, err := f.checkPath(tt.path, tt.follow)
+		if tt.allowed {
+			assert.NoError(t, err, tt.path)
+		} else {
+			assert.Error(t, err, tt.path)
+		}
+	}
+
+	// The target of a symlink is checked too. OpenSSH takes the target first.
+	_, err = f.check(sftpRequest(sshFxpSymlink, 1, "data", filepath.Join(root, "link")))
+	assert.NoError(t, err)
+	_, err = f.check(sftpRequest(sshFxpSymlink, 1, outside, filepath.Join(root, "link")))
+	assert.Error(t, err)
+	_, err = f.check(sftpRequest(sshFxpSymlink, 1, "../outside", filepath.Join(root, "link")))
+	assert.Error(t, err)
+}
+
+func TestSFTPFilter_checkRewrites(t *testing.T) {
+	root, _ := testSFTPRoot(t)
+	f, err := newSFTPFilter(nil, root, []string{root})
+	require.NoError(t, err)
+
+	// The sftp-server gets the paths with the symlinks resolved
+	pkt, err := f.check(sftpRequest(sshFxpStat, 1, "inside/file"))
+	require.NoError(t, err)
+	assert.Equal(t, sftpRequest(sshFxpStat, 1, filepath.Join(root, "data", "file")), pkt)
+
+	// The last element isn't resolved when the request doesn't follow it
+	pkt, err = f.check(sftpRequest(sshFxpLstat, 2, filepath.Join(root, "inside")))
+	require.NoError(t, err)
+	assert.Equal(t, sftpRequest(sshFxpLstat, 2, filepath.Join(root, "inside")), pkt)
+
+	// The target of a symlink is stored as it is
+	pkt, err = f.check(sftpRequest(sshFxpSymlink, 3, "file", "inside/link"))
+	require.NoError(t, err)
+	assert.Equal(t, sftpRequest(sshFxpSymlink, 3, "file", filepath.Join(root, "data", "link")), pkt)
+
+	// The data that follows the paths is kept
+	req := append(sftpRequest(sshFxpExtended, 4, "posix-rename@openssh.com", "inside/a", "inside/b"), 1, 2)
+	binary.BigEndian.PutUint32(req, uint32(len(req)-4))
+	pkt, err = f.check(req)
+	require.NoError(t, err)
+	want := append(sftpRequest(sshFxpExtended, 4, "posix-rename@openssh.com", filepath.Join(root, "data", "a"), filepath.Join(root, "data", "b")), 1, 2)
+	binary.BigEndian.PutUint32(want, uint32(len(want)-4))
+	assert.Equal(t, want, pkt)
+}
+
+func TestSFTPFilter_Read(t *testing.T) {
+	root, outside := testSFTPRoot(t)
+	client, server := net.Pipe()
+	defer client.Close()
+	f, err := newSFTPFilter(server, root, []string{root})
+	require.NoError(t, err)
+	defer f.Close()
+
+	go func() {
+		_, _ = client.Write(sftpRequest(sshFxpStat, 1, outside))
+		_, _ = client.Write(sftpRequest(sshFxpStat, 2, filepath.Join(root, "data")))
+	}()
+
+	replies := make(chan []byte, 1)
+	go func() {
+		pkt, err := readSFTPPacket(client)
+		if err == nil {
+			replies <- pkt
+		}
+		close(replies)
+	}()
+
+	// Only the allowed request reaches the sftp-server
+	buf := make([]byte, 1024)
+	n, err := io.ReadAtLeast(f, buf, 9)
+	require.NoError(t, err)
+	assert.Equal(t, sftpRequest(sshFxpStat, 2, filepath.Join(root, "data")), buf[:n])
+
+	// The denied request gets a status reply
+	reply := <-replies
+	require.NotNil(t, reply)
+	assert.Equal(t, byte(sshFxpStatus), reply[4])
+	assert.Equal(t, uint32(1), binary.BigEndian.Uint32(reply[5:]))
+	assert.Equal(t, uint32(sshFxPermissionDenied), binary.BigEndian.Uint32(reply[9:]))
+}
+
+func TestSFTPFilter_Write(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	f := &sftpFilter{conn: server, done: make(chan struct{})}
+	defer f.Close()
+
+	reply := sftpRequest(sshFxpStatus, 7, "ok")
+	go func() {
+		// A reply that is written in parts only reaches the client once it's complete
+		_, _ = f.Write(reply[:6])
+		_, _ = f.Write(reply[6:])
+	}()
+	pkt, err := readSFTPPacket(client)
+	require.NoError(t, err)
+	assert.Equal(t, reply, pkt)
+}
@@ -3,6 +3,7 @@ package agent
 import (
 	"context"
 	"fmt"
+	"strings"
 
 	"github.com/datawire/dlib/dlog"
 	"github.com/telepresenceio/telepresence/rpc/v2/manager"
@@ -97,12 +98,13 @@ func (s *state) HandleIntercepts(ctx context.Context, cepts []*manager.Intercept
 				// that just hasn't propagated yet.  But let's go ahead and tell the
 				// manager to mark it ACTIVE again anyway, just to be safe.
 				dlog.Infof(ctx, "Setting intercept %q as ACTIVE (again?)", cept.Id)
+				desc, _ := s.mechanismArgsDesc(ctx, cept)
 				reviews = append(reviews, &manager.ReviewInterceptRequest{
 					Id:                cept.Id,
 					Disposition:       manager.InterceptDispositionType_ACTIVE,
 					PodIp:             s.podIP,
 					SftpPort:          s.sftpPort,
-					MechanismArgsDesc: "all TCP connections",
+					MechanismArgsDesc: desc,
 				})
 			case chosenIntercept == nil:
 				// We don't have an intercept in play, so choose this one. All
@@ -110,6 +112,17 @@ func (s *state) HandleIntercepts(ctx context.Context, cepts []*manager.Intercept
 				// this will yield a consistent result. Note that the intercept
 				// will not become active at this time. That will happen later,
 				// once the manager assigns a port.
+				desc, err := s.mechanismArgsDesc(ctx, cept)
+				if err != nil {
+					dlog.Infof(ctx, "Setting intercept %q as AGENT_ERROR; %v", cept.Id, err)
+					reviews = append(reviews, &manager.ReviewInterceptRequest{
+						Id:                cept.Id,
+						Disposition:       manager.InterceptDispositionType_AGENT_ERROR,
+						Message:           err.Error(),
+						MechanismArgsDesc: "all TCP connections",
+					})
+					continue
+				}
 				dlog.Infof(ctx, "Setting intercept %q as ACTIVE", cept.Id)
 				s.chosenID = cept.Id
 				chosenIntercept = cept
@@ -118,7 +131,7 @@ func (s *state) HandleIntercepts(ctx context.Context, cepts []*manager.Intercept
 					Disposition:       manager.InterceptDispositionType_ACTIVE,
 					PodIp:             s.podIP,
 					SftpPort:          s.sftpPort,
-					MechanismArgsDesc: "all TCP connections",
+					MechanismArgsDesc: desc,
 				})
 			default:
 				// We already have an intercept in play, so reject this one.
@@ -142,6 +155,25 @@ func (s *state) HandleIntercepts(ctx context.Context, cepts []*manager.Intercept
 	return reviews
 }
 
+// mechanismArgsDesc describes how the agent serves the given intercept, including the faults and
+// caller identification that the mechanism arguments of the intercept ask for, and the arguments
+// that it ignores.
+func (s *state) mechanismArgsDesc(ctx context.Context, cept *manager.InterceptInfo) (string, error) {
+	args, ignored, err := s.forwarder.ParseMechanismArgs(cept.Spec.MechanismArgs)
+	if err != nil {
+		return "", err
+	}
+	desc := "all TCP connections"
+	if args != nil {
+		desc += ", with " + args.String()
+	}
+	if len(ignored) > 0 {
+		dlog.Warnf(ctx, "Intercept %q has unknown mechanism arguments %q", cept.Id, ignored)
+		desc += fmt.Sprintf(", ignoring %s", strings.Join(ignored, " "))
+	}
+	return desc, nil
+}
+
 func (s *state) Intercepting() bool {
 	return s.forwarder.Intercepting()
 }
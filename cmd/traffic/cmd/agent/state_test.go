@@ -137,3 +137,42 @@ func TestState_HandleIntercepts(t *testing.T) {
 	a.Len(reviews, 0)
 	a.False(f.Intercepting())
 }
+
+func TestState_MechanismArgs(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	a := assert.New(t)
+
+	lAddr, err := net.ResolveTCPAddr("tcp", "127.0.0.1:0")
+	a.NoError(err)
+	f := forwarder.NewForwarder(lAddr, appHost, appPort)
+	l, err := f.Listen(ctx)
+	a.NoError(err)
+	defer l.Close()
+	s := agent.NewState(f, mgrHost, "default", "xyz", 0)
+
+	cept := &rpc.InterceptInfo{
+		Spec: &rpc.InterceptSpec{
+			Name:          "cept1Name",
+			Client:        "user@host1",
+			Agent:         "agentName",
+			Mechanism:     "tcp",
+			MechanismArgs: []string{"--error-rate=2"},
+			Namespace:     "default",
+		},
+		Id:          "intercept-01",
+		Disposition: rpc.InterceptDispositionType_WAITING,
+	}
+
+	// A known argument with an invalid value is an error
+	reviews := s.HandleIntercepts(ctx, []*rpc.InterceptInfo{cept})
+	a.Len(reviews, 1)
+	a.Equal(rpc.InterceptDispositionType_AGENT_ERROR, reviews[0].Disposition)
+	a.Contains(reviews[0].Message, "--error-rate=2")
+
+	// Unknown arguments are ignored
+	cept.Spec.MechanismArgs = []string{"--latency=1s", "--match-query=user=me"}
+	reviews = s.HandleIntercepts(ctx, []*rpc.InterceptInfo{cept})
+	a.Len(reviews, 1)
+	a.Equal(rpc.InterceptDispositionType_ACTIVE, reviews[0].Disposition)
+	a.Equal("all TCP connections, with 1s latency, ignoring --match-query=user=me", reviews[0].MechanismArgsDesc)
+}
@@ -0,0 +1,199 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/datawire/ambassador/pkg/kates"
+	"github.com/datawire/dlib/dlog"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/internal/auth"
+	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/managerutil"
+	"github.com/telepresenceio/telepresence/v2/pkg/install"
+)
+
+// authorizeIntercept checks the intercept policy. Intercepts of workloads that the policy restricts
+// require that the client is identified, see identify.
+func (m *Manager) authorizeIntercept(ctx context.Context, spec *rpc.InterceptSpec) error {
+	p := m.policy.Policy(ctx)
+	if !p.Restricted(spec.Namespace, spec.Agent) {
+		return nil
+	}
+	user, err := m.identify(ctx)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return status.Errorf(codes.PermissionDenied,
+			"intercepts of %s.%s are restricted and the client did not provide an identity", spec.Agent, spec.Namespace)
+	}
+	if !p.Allowed(spec.Namespace, spec.Agent, user.Username, user.Groups) {
+		return status.Errorf(codes.PermissionDenied, "user %q is not allowed to intercept %s.%s", user.Username, spec.Agent, spec.Namespace)
+	}
+	dlog.Debugf(ctx, "user %q is allowed to intercept %s.%s", user.Username, spec.Agent, spec.Namespace)
+	return nil
+}
+
+// checkInterceptable refuses intercepts of workloads that are annotated as never interceptable, see
+// install.InterceptAnnotation. A workload that doesn't exist is left to the agent lookup, but the
+// intercepts of workloads that can't be read, e.g. because the traffic-manager has no RBAC for their
+// kind, are refused, because the clients can't be trusted to refuse them.
+func (m *Manager) checkInterceptable(ctx context.Context, spec *rpc.InterceptSpec) error {
+	client := managerutil.GetKatesClient(ctx)
+	if client == nil {
+		return nil
+	}
+	wl, err := managerutil.FindWorkload(ctx, client, spec.Namespace, spec.WorkloadKind, spec.Agent)
+	if err != nil {
+		if kates.IsNotFound(err) {
+			return nil
+		}
+		return status.Errorf(codes.PermissionDenied, "unable to check if %s.%s may be intercepted: %v", spec.Agent, spec.Namespace, err)
+	}
+	if install.InterceptsForbidden(wl) {
+		return status.Error(codes.PermissionDenied, install.InterceptsForbiddenError(wl).Error())
+	}
+	return nil
+}
+
+// authorizeOverride checks that the client is an admin according to the intercept policy. Only
+// admins may override the intercepts of other clients.
+func (m *Manager) authorizeOverride(ctx context.Context, spec *rpc.InterceptSpec) error {
+	user, err := m.authorizeAdmin(ctx, fmt.Sprintf("override the intercepts of %s.%s", spec.Agent, spec.Namespace))
+	if err != nil {
+		return err
+	}
+	dlog.Infof(ctx, "admin %q overrides the intercepts of %s.%s", user, spec.Agent, spec.Namespace)
+	return nil
+}
+
+// authorizeAdmin checks that the client is an admin according to the intercept policy, and returns
+// the name of the user. The given action describes what the client attempts to do.
+func (m *Manager) authorizeAdmin(ctx context.Context, action string) (string, error) {
+	user, err := m.identify(ctx)
+	if err != nil {
+		return "", err
+	}
+	if user == nil {
+		return "", status.Errorf(codes.PermissionDenied,
+			"only admins may %s, and the client did not provide an identity", action)
+	}
+	if !m.policy.Policy(ctx).IsAdmin(user.Username, user.Groups) {
+		return "", status.Errorf(codes.PermissionDenied, "user %q is not allowed to %s", user.Username, action)
+	}
+	return user.Username, nil
+}
+
+// identify returns the identity of the client of the current call. It's the identity of the token
+// that the client passes, or, when the certificate method is enabled and the client passes no token,
+// the identity of the certificate that it was verified with using mutual TLS. The identity is nil
+// when the client provides neither.
+func (m *Manager) identify(ctx context.Context) (*auth.Identity, error) {
+	if token := managerutil.GetIdentityToken(ctx); token != "" {
+		return m.authenticate(ctx, token)
+	}
+	if m.certAuth {
+		if cert := peerCertificate(ctx); cert != nil {
+			return auth.CertificateIdentity(cert), nil
+		}
+	}
+	return nil, nil
+}
+
+// authenticate returns the identity of the client that passed the given token.
+func (m *Manager) authenticate(ctx context.Context, token string) (*auth.Identity, error) {
+	if m.auth == nil {
+		return nil, status.Error(codes.Unauthenticated, "no authentication method is configured")
+	}
+	id, err := m.auth.Authenticate(ctx, token)
+	if err != nil {
+		dlog.Debugf(ctx, "authentication failed: %v", err)
+		return nil, status.Errorf(codes.Unauthenticated, "unable to verify the identity of the client: %v", err)
+	}
+	return id, nil
+}
+
+// unauthenticatedMethods are the methods that may be called without a token when clients must
+// authenticate. ArriveAsAgent authenticates the traffic-agent itself, see authenticateAgent.
+var unauthenticatedMethods = map[string]bool{
+	"/telepresence.manager.Manager/Version":       true,
+	"/telepresence.manager.Manager/ArriveAsAgent": true,
+	"/grpc.health.v1.Health/Check":                true,
+	"/grpc.health.v1.Health/Watch":                true,
+}
+
+// unaryAuthInterceptor refuses the unary calls that don't present a valid token when clients must
+// authenticate.
+func (m *Manager) unaryAuthInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if err := m.authenticateCall(ctx, info.FullMethod); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// streamAuthInterceptor refuses the streaming calls that don't present a valid token when clients
+// must authenticate.
+func (m *Manager) streamAuthInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := m.authenticateCall(ss.Context(), info.FullMethod); err != nil {
+		return err
+	}
+	return handler(srv, ss)
+}
+
+// authenticateCall checks that a call presents a token that the authentication methods accept, or
+// the token of the ServiceAccount of a traffic-agent.
+func (m *Manager) authenticateCall(ctx context.Context, method string) error {
+	if !authRequired(ctx) || unauthenticatedMethods[method] {
+		return nil
+	}
+	token := managerutil.GetIdentityToken(ctx)
+	if token == "" {
+		if m.certAuth && peerCertificate(ctx) != nil {
+			return nil
+		}
+		return status.Errorf(codes.Unauthenticated, "traffic-manager requires clients to authenticate")
+	}
+	if m.auth != nil {
+		if _, err := m.auth.Authenticate(ctx, token); err == nil {
+			return nil
+		}
+	}
+	if m.agentAuth != nil {
+		if _, err := m.agentAuth.Authenticate(ctx, token); err == nil {
+			return nil
+		}
+	}
+	_, err := m.authenticate(ctx, token)
+	return err
+}
+
+// authenticateAgent checks that a traffic-agent presents the token of a ServiceAccount of the
+// namespace that it claims to run in.
+func (m *Manager) authenticateAgent(ctx context.Context, agent *rpc.AgentInfo) error {
+	token := managerutil.GetIdentityToken(ctx)
+	if token == "" {
+		return status.Errorf(codes.Unauthenticated, "traffic-manager requires traffic-agents to present the token of their ServiceAccount")
+	}
+	if m.agentAuth == nil {
+		return status.Error(codes.Unauthenticated, "traffic-manager is unable to verify the tokens of ServiceAccounts")
+	}
+	id, err := m.agentAuth.Authenticate(ctx, token)
+	if err != nil {
+		dlog.Debugf(ctx, "authentication of traffic-agent %s.%s failed: %v", agent.Name, agent.Namespace, err)
+		return status.Errorf(codes.Unauthenticated, "unable to verify the identity of the traffic-agent: %v", err)
+	}
+	if ns, _, _ := auth.ServiceAccount(id); ns != agent.Namespace {
+		return status.Errorf(codes.PermissionDenied, "%s may not act as a traffic-agent in namespace %s", id.Username, agent.Namespace)
+	}
+	return nil
+}
+
+// authRequired returns true if clients must present a token that the authentication methods accept.
+func authRequired(ctx context.Context) bool {
+	env := managerutil.GetEnv(ctx)
+	return env != nil && env.AuthRequired
+}
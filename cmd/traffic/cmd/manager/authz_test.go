@@ -0,0 +1,176 @@
+package manager
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	empty "google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/datawire/dlib/dhttp"
+	"github.com/datawire/dlib/dlog"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/internal/auth"
+	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/internal/policy"
+	testdata "github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/internal/test"
+	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/managerutil"
+)
+
+// tokens is an Authenticator that knows a fixed set of tokens.
+type tokens map[string]*auth.Identity
+
+func (ts tokens) Authenticate(_ context.Context, token string) (*auth.Identity, error) {
+	if id, ok := ts[token]; ok {
+		return id, nil
+	}
+	return nil, errors.New("unknown token")
+}
+
+// authTestServer serves a traffic-manager that requires clients to authenticate, and returns it
+// along with a connection to it.
+func authTestServer(t *testing.T) (*Manager, *grpc.ClientConn) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, true))
+	ctx = managerutil.WithEnv(ctx, &managerutil.Env{AuthRequired: true})
+
+	m := NewManager(ctx)
+	m.auth = tokens{
+		"alice-token": {Username: "alice", Method: auth.MethodStatic},
+		"bob-token":   {Username: "bob", Method: auth.MethodStatic},
+	}
+	m.agentAuth = tokens{
+		"hello-token": {Username: "system:serviceaccount:default:hello", Method: auth.MethodTokenReview},
+		"other-token": {Username: "system:serviceaccount:other:hello", Method: auth.MethodTokenReview},
+	}
+	s := grpc.NewServer(grpc.UnaryInterceptor(m.unaryAuthInterceptor), grpc.StreamInterceptor(m.streamAuthInterceptor))
+	rpc.RegisterManagerServer(s, m)
+	rpc.RegisterSessionsServer(s, m)
+
+	lis := bufconn.Listen(64 * 1024)
+	errCh := make(chan error)
+	go func() {
+		sc := &dhttp.ServerConfig{Handler: s}
+		errCh <- sc.Serve(ctx, lis)
+		close(errCh)
+	}()
+	conn, err := grpc.DialContext(ctx, "bufnet", grpc.WithInsecure(),
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }))
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		conn.Close()
+		cancel()
+		if err := <-errCh; err != nil && err != ctx.Err() {
+			t.Error(err)
+		}
+	})
+	return m, conn
+}
+
+// authTestClient returns a client of a traffic-manager that requires clients to authenticate.
+func authTestClient(t *testing.T) rpc.ManagerClient {
+	_, conn := authTestServer(t)
+	return rpc.NewManagerClient(conn)
+}
+
+// policyFile returns the policy of a file with the given content.
+func policyFile(t *testing.T, content string) *policy.File {
+	file := filepath.Join(t.TempDir(), "policy.yaml")
+	require.NoError(t, ioutil.WriteFile(file, []byte(content), 0600))
+	pf, err := policy.NewFile(file)
+	require.NoError(t, err)
+	return pf
+}
+
+func withToken(ctx context.Context, token string) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, managerutil.IdentityTokenHeader, token)
+}
+
+func TestAuthInterceptors(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	client := authTestClient(t)
+	testClients := testdata.GetTestClients(t)
+
+	ver, err := client.Version(ctx, &empty.Empty{})
+	require.NoError(t, err, "the version is available to all")
+	assert.True(t, ver.WantsIdentity, "the clients are told to pass their identity")
+
+	_, err = client.GetCloudConfig(ctx, &empty.Empty{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = client.GetCloudConfig(withToken(ctx, "bad-token"), &empty.Empty{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = client.GetCloudConfig(withToken(ctx, "alice-token"), &empty.Empty{})
+	assert.NoError(t, err)
+	_, err = client.GetCloudConfig(withToken(ctx, "hello-token"), &empty.Empty{})
+	assert.NoError(t, err, "traffic-agents are authenticated by their ServiceAccount")
+
+	_, err = client.ArriveAsClient(ctx, testClients["alice"])
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	sess, err := client.ArriveAsClient(withToken(ctx, "alice-token"), testClients["alice"])
+	require.NoError(t, err)
+
+	// The streaming calls are checked too
+	wa, err := client.WatchAgents(ctx, sess)
+	require.NoError(t, err)
+	_, err = wa.Recv()
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	wa, err = client.WatchAgents(withToken(ctx, "alice-token"), sess)
+	require.NoError(t, err)
+	_, err = wa.Recv()
+	assert.NoError(t, err)
+}
+
+func TestArriveAsAgentAuth(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	client := authTestClient(t)
+	hello := testdata.GetTestAgents(t)["hello"]
+
+	_, err := client.ArriveAsAgent(ctx, hello)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = client.ArriveAsAgent(withToken(ctx, "alice-token"), hello)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err), "a client can't act as a traffic-agent")
+	_, err = client.ArriveAsAgent(withToken(ctx, "other-token"), hello)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err), "a ServiceAccount of another namespace is refused")
+	sess, err := client.ArriveAsAgent(withToken(ctx, "hello-token"), hello)
+	require.NoError(t, err)
+	assert.NotEmpty(t, sess.SessionId)
+}
+
+func TestSessions(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	m, conn := authTestServer(t)
+	m.policy = policyFile(t, "admins:\n  users: [alice]\n")
+	client := rpc.NewManagerClient(conn)
+	sessions := rpc.NewSessionsClient(conn)
+	alice := testdata.GetTestClients(t)["alice"]
+
+	sess, err := client.ArriveAsClient(withToken(ctx, "alice-token"), alice)
+	require.NoError(t, err)
+
+	_, err = sessions.ListSessions(withToken(ctx, "bob-token"), &empty.Empty{})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err), "only admins may list the sessions")
+	css, err := sessions.ListSessions(withToken(ctx, "alice-token"), &empty.Empty{})
+	require.NoError(t, err)
+	require.Len(t, css.Sessions, 1)
+	assert.Equal(t, sess.SessionId, css.Sessions[0].SessionId)
+	assert.Equal(t, alice.Name, css.Sessions[0].Name)
+	assert.Equal(t, "alice", css.Sessions[0].User)
+
+	_, err = sessions.KickSession(withToken(ctx, "bob-token"), sess)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err), "only admins may kick a session")
+	_, err = sessions.KickSession(withToken(ctx, "alice-token"), &rpc.SessionInfo{SessionId: "nosuch"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	_, err = sessions.KickSession(withToken(ctx, "alice-token"), sess)
+	require.NoError(t, err)
+	css, err = sessions.ListSessions(withToken(ctx, "alice-token"), &empty.Empty{})
+	require.NoError(t, err)
+	assert.Empty(t, css.Sessions)
+}
@@ -0,0 +1,250 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/datawire/ambassador/pkg/kates"
+	"github.com/datawire/dlib/dcontext"
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/managerutil"
+	"github.com/telepresenceio/telepresence/v2/pkg/install"
+)
+
+// callbackSessionLabel is set on the Services and Endpoints that make a client reachable from the
+// cluster. Its value is the ID of the client session.
+const callbackSessionLabel = install.DomainPrefix + "callback-session"
+
+// serveClientCallbacks makes each client reachable from the cluster under a DNS name of its own,
+// so that in-cluster services and intercepted peers can call back to services on the laptop. A
+// client session gets a Service without a selector in the namespace of the traffic-manager, named
+// after the client. Its Endpoints point at listeners of the traffic-manager, and the connections
+// that those listeners accept are forwarded to the loopback interface of the client through its
+// tunnel. Only the configured ports that the client opted into, in the callback_ports of its
+// ClientInfo, are exposed, so a client that lists none isn't reachable at all.
+//
+// The Services are ordinary ClusterIP Services, so every pod in the cluster, in any namespace, can
+// reach the exposed loopback ports of the clients. Nothing authenticates those connections.
+func (m *Manager) serveClientCallbacks(ctx context.Context) error {
+	env := managerutil.GetEnv(ctx)
+	if len(env.ClientCallbackPorts) == 0 || managerutil.GetKatesClient(ctx) == nil {
+		return nil
+	}
+	if env.PodIP == "" {
+		dlog.Error(ctx, "POD_IP is not set, so clients can't be made reachable from the cluster")
+		return nil
+	}
+
+	// Services that were left behind by an earlier traffic-manager refer to sessions that are gone
+	deleteCallbackServices(ctx, "")
+
+	callbacks := make(map[string]context.CancelFunc)
+	defer func() {
+		for _, cancel := range callbacks {
+			cancel()
+		}
+	}()
+	for snapshot := range m.state.WatchClients(ctx, nil) {
+		for sessionID, client := range snapshot.State {
+			if _, ok := callbacks[sessionID]; !ok {
+				cbCtx, cancel := context.WithCancel(ctx)
+				callbacks[sessionID] = cancel
+				go m.serveClientCallback(cbCtx, sessionID, client.Name)
+			}
+		}
+		for sessionID, cancel := range callbacks {
+			if _, ok := snapshot.State[sessionID]; !ok {
+				cancel()
+				delete(callbacks, sessionID)
+			}
+		}
+	}
+	return nil
+}
+
+// serveClientCallback serves the Service of one client session until the given context is done.
+func (m *Manager) serveClientCallback(ctx context.Context, sessionID, clientName string) {
+	env := managerutil.GetEnv(ctx)
+	client := managerutil.GetKatesClient(ctx)
+	ports := m.state.GetClientCallbackPorts(sessionID)
+	if len(ports) == 0 {
+		return
+	}
+	name := callbackServiceName(clientName)
+	labels := map[string]string{callbackSessionLabel: sessionID}
+
+	svc := &kates.Service{
+		TypeMeta:   metav1.TypeMeta{Kind: "Service"},
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: env.ManagerNamespace, Labels: labels},
+	}
+	ep := &corev1.Endpoints{
+		TypeMeta:   metav1.TypeMeta{Kind: "Endpoints", APIVersion: "v1"},
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: env.ManagerNamespace, Labels: labels},
+		Subsets:    []corev1.EndpointSubset{{Addresses: []corev1.EndpointAddress{{IP: env.PodIP}}}},
+	}
+	for _, port := range ports {
+		l, err := net.Listen("tcp", ":0")
+		if err != nil {
+			dlog.Errorf(ctx, "unable to listen for callbacks to client %s: %v", clientName, err)
+			return
+		}
+		defer l.Close()
+		go m.acceptCallbacks(ctx, l, sessionID, clientName, uint16(port))
+
+		portName := fmt.Sprintf("port-%d", port)
+		svc.Spec.Ports = append(svc.Spec.Ports, corev1.ServicePort{Name: portName, Port: port, Protocol: corev1.ProtocolTCP})
+		ep.Subsets[0].Ports = append(ep.Subsets[0].Ports, corev1.EndpointPort{
+			Name:     portName,
+			Port:     int32(l.Addr().(*net.TCPAddr).Port),
+			Protocol: corev1.ProtocolTCP,
+		})
+	}
+
+	// The Service of an earlier session of the same client is replaced. That session is either
+	// gone or about to expire. Different client names may give the same Service name though, and
+	// the Service of another live session is left alone.
+	if owner, ok := callbackServiceOwner(ctx, name); ok {
+		if owner == "" {
+			dlog.Errorf(ctx, "unable to make client %s reachable from the cluster: Service %s.%s already exists", clientName, name, env.ManagerNamespace)
+			return
+		}
+		if other := m.state.GetClient(owner); other != nil && owner != sessionID {
+			dlog.Errorf(ctx, "unable to make client %s reachable from the cluster: Service %s.%s belongs to client %s",
+				clientName, name, env.ManagerNamespace, other.Name)
+			return
+		}
+		deleteCallbackService(ctx, name)
+	}
+	if err := client.Create(ctx, svc, nil); err != nil {
+		dlog.Errorf(ctx, "unable to create Service %s.%s for client %s: %v", name, env.ManagerNamespace, clientName, err)
+		return
+	}
+	defer deleteCallbackServices(ctx, sessionID)
+	if err := client.Create(ctx, ep, nil); err != nil {
+		dlog.Errorf(ctx, "unable to create Endpoints %s.%s for client %s: %v", name, env.ManagerNamespace, clientName, err)
+		return
+	}
+	dlog.Infof(ctx, "Client %s is reachable from the cluster as %s.%s", clientName, name, env.ManagerNamespace)
+	<-ctx.Done()
+}
+
+// acceptCallbacks forwards the connections that the given listener accepts to the given port on the
+// loopback interface of a client.
+func (m *Manager) acceptCallbacks(ctx context.Context, l net.Listener, sessionID, clientName string, port uint16) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() == nil {
+				dlog.Errorf(ctx, "unable to accept callbacks to client %s: %v", clientName, err)
+			}
+			return
+		}
+		if err = m.state.ForwardToClient(ctx, sessionID, conn, port); err != nil {
+			dlog.Errorf(ctx, "unable to forward callback to port %d of client %s: %v", port, clientName, err)
+			_ = conn.Close()
+		}
+	}
+}
+
+// deleteCallbackServices deletes the Services and Endpoints of the given client session, or of all
+// client sessions when sessionID is empty. It's used when the context is done, so it doesn't use
+// the cancellation of the context.
+func deleteCallbackServices(ctx context.Context, sessionID string) {
+	ctx, cancel := context.WithTimeout(dcontext.WithoutCancel(ctx), 10*time.Second)
+	defer cancel()
+	selector := callbackSessionLabel
+	if sessionID != "" {
+		selector += "=" + sessionID
+	}
+	var svcs []*kates.Service
+	err := managerutil.GetKatesClient(ctx).List(ctx, kates.Query{
+		Kind:          "Service",
+		Namespace:     managerutil.GetEnv(ctx).ManagerNamespace,
+		LabelSelector: selector,
+	}, &svcs)
+	if err != nil {
+		dlog.Errorf(ctx, "unable to list the Services of clients: %v", err)
+		return
+	}
+	for _, svc := range svcs {
+		deleteCallbackService(ctx, svc.Name)
+	}
+}
+
+// callbackServiceOwner returns the session ID of the client that the Service with the given name
+// belongs to, and false when there's no such Service. An empty session ID means that the Service
+// doesn't belong to any client.
+func callbackServiceOwner(ctx context.Context, name string) (string, bool) {
+	svc := &kates.Service{
+		TypeMeta:   metav1.TypeMeta{Kind: "Service"},
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: managerutil.GetEnv(ctx).ManagerNamespace},
+	}
+	if err := managerutil.GetKatesClient(ctx).Get(ctx, svc, svc); err != nil {
+		if !kates.IsNotFound(err) {
+			dlog.Errorf(ctx, "unable to get Service %s.%s: %v", name, svc.Namespace, err)
+		}
+		return "", false
+	}
+	return svc.Labels[callbackSessionLabel], true
+}
+
+// callbackPorts returns the configured ports that a client opted into.
+func callbackPorts(configured, declared []int32) []int32 {
+	var ports []int32
+	for _, c := range configured {
+		for _, d := range declared {
+			if c == d {
+				ports = append(ports, c)
+				break
+			}
+		}
+	}
+	return ports
+}
+
+// deleteCallbackService deletes the Service and Endpoints with the given name.
+func deleteCallbackService(ctx context.Context, name string) {
+	client := managerutil.GetKatesClient(ctx)
+	meta := metav1.ObjectMeta{Name: name, Namespace: managerutil.GetEnv(ctx).ManagerNamespace}
+	for _, obj := range []interface{}{
+		&corev1.Endpoints{TypeMeta: metav1.TypeMeta{Kind: "Endpoints", APIVersion: "v1"}, ObjectMeta: meta},
+		&kates.Service{TypeMeta: metav1.TypeMeta{Kind: "Service"}, ObjectMeta: meta},
+	} {
+		if err := client.Delete(ctx, obj, nil); err != nil && !kates.IsNotFound(err) {
+			dlog.Errorf(ctx, "unable to delete %s.%s: %v", name, meta.Namespace, err)
+		}
+	}
+}
+
+// callbackServiceName returns the name of the Service of the client with the given name. The
+// name is made a valid DNS label, so "alice@laptop" becomes "alice-laptop".
+func callbackServiceName(clientName string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r + 'a' - 'A'
+		default:
+			return '-'
+		}
+	}, clientName)
+	name = strings.Trim(name, "-")
+	switch {
+	case name == "":
+		name = "client"
+	case name[0] < 'a':
+		// A Service name must start with a letter
+		name = "client-" + name
+	}
+	if len(name) > 63 {
+		name = strings.TrimRight(name[:63], "-")
+	}
+	return name
+}
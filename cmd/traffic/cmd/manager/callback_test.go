@@ -0,0 +1,50 @@
+package manager
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/managerutil"
+)
+
+func TestCallbackServiceName(t *testing.T) {
+	for clientName, expected := range map[string]string{
+		"alice@laptop":                 "alice-laptop",
+		"Bob@Work.example.com":         "bob-work-example-com",
+		"@@":                           "client",
+		"42@host":                      "client-42-host",
+		strings.Repeat("a", 62) + "@b": strings.Repeat("a", 62),
+	} {
+		assert.Equal(t, expected, callbackServiceName(clientName), clientName)
+	}
+}
+
+func TestCallbackPorts(t *testing.T) {
+	assert.Equal(t, []int32{8080}, callbackPorts([]int32{8080, 9090}, []int32{22, 8080}))
+	assert.Empty(t, callbackPorts([]int32{8080, 9090}, nil), "a client that declares no ports isn't reachable")
+	assert.Empty(t, callbackPorts(nil, []int32{8080}))
+}
+
+func TestArriveAsClient_callbackPorts(t *testing.T) {
+	ctx := managerutil.WithEnv(dlog.NewTestContext(t, false), &managerutil.Env{ClientCallbackPorts: []int32{8080, 9090}})
+	m := NewManager(ctx)
+	arrive := func(name string, ports []int32) string {
+		si, err := m.ArriveAsClient(ctx, &rpc.ClientInfo{
+			Name:          name,
+			InstallId:     "xyz",
+			Product:       "telepresence",
+			Version:       "v2.3.6",
+			CallbackPorts: ports,
+		})
+		require.NoError(t, err)
+		return si.SessionId
+	}
+	assert.Equal(t, []int32{8080}, m.state.GetClientCallbackPorts(arrive("alice@laptop", []int32{22, 8080})),
+		"only the configured ports that the client opted into are served")
+	assert.Empty(t, m.state.GetClientCallbackPorts(arrive("bob@laptop", nil)))
+}
@@ -0,0 +1,76 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/datawire/dlib/dlog"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/internal/events"
+)
+
+// resolveConflicts ensures that no other client holds the workload of the given spec before the
+// client with the given session creates its intercept. By default, the intercept is refused with an
+// error that tells who holds the workload. A client that asked to be queued is blocked until it has
+// had its turn in the queue of the workload and the workload is released, and is told the names of
+// the clients that it waited for. A client that asked for an override, and is an admin, has the
+// intercepts of the other clients removed.
+//
+// The returned function must be called once the intercept has been created, or has failed.
+func (m *Manager) resolveConflicts(ctx context.Context, sessionID, clientName string, ciReq *rpc.CreateInterceptRequest) (func(), []string, error) {
+	noop := func() {}
+	spec := ciReq.InterceptSpec
+	holders := m.state.InterceptConflicts(sessionID, spec)
+	switch {
+	case len(holders) > 0 && ciReq.Override:
+		if err := m.authorizeOverride(ctx, spec); err != nil {
+			return nil, nil, err
+		}
+		for _, holder := range holders {
+			if m.state.RemoveIntercept(holder.Id) {
+				events.Emit(ctx, spec.Namespace, spec.WorkloadKind, spec.Agent, events.InterceptOverridden,
+					"Intercept %q of %s was removed in favor of an intercept by %s", holder.Spec.Name, holder.Spec.Client, clientName)
+			}
+		}
+		return noop, nil, nil
+	case ciReq.Queue:
+		if len(holders) > 0 {
+			dlog.Infof(ctx, "Intercept %q is queued: %s", spec.Name, describeHolders(spec, holders))
+		}
+		release, waitedFor, err := m.state.WaitForRelease(ctx, sessionID, spec)
+		if err != nil {
+			return nil, nil, err
+		}
+		var releasedBy []string
+		for _, holder := range waitedFor {
+			releasedBy = append(releasedBy, holder.Spec.Client)
+		}
+		return release, releasedBy, nil
+	case len(holders) > 0:
+		return nil, nil, status.Errorf(codes.FailedPrecondition,
+			"%s. The intercept can be queued until the workload is released, or an admin can override it", describeHolders(spec, holders))
+	default:
+		return noop, nil, nil
+	}
+}
+
+// describeHolders describes who holds the workload of the given spec.
+func describeHolders(spec *rpc.InterceptSpec, holders []*rpc.InterceptInfo) string {
+	sb := strings.Builder{}
+	fmt.Fprintf(&sb, "%s.%s is intercepted by", spec.Agent, spec.Namespace)
+	for i, holder := range holders {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		state := "active"
+		if holder.Disposition != rpc.InterceptDispositionType_ACTIVE {
+			state = "waiting"
+		}
+		fmt.Fprintf(&sb, " %s (intercept %q, %s)", holder.Spec.Client, holder.Spec.Name, state)
+	}
+	return sb.String()
+}
@@ -67,6 +67,8 @@ func validateIntercept(spec *rpc.InterceptSpec) string {
 		return "namespace must not be empty"
 	case spec.Mechanism == "":
 		return "mechanism must not be empty"
+	case spec.Duration != nil && (spec.Duration.CheckValid() != nil || spec.Duration.AsDuration() < 0):
+		return "duration must not be negative"
 	}
 
 	return ""
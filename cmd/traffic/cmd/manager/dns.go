@@ -0,0 +1,17 @@
+package manager
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// serveDNSStats responds with the counters of the DNS resolver that serves the lookups of clients
+func (m *Manager) serveDNSStats(w http.ResponseWriter, r *http.Request) {
+	data, err := json.Marshal(m.dns.Stats())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(data)
+}
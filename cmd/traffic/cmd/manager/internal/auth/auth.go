@@ -0,0 +1,154 @@
+// Package auth implements the authentication of the clients of the traffic-manager.
+//
+// A client presents a token in the metadata of its gRPC calls, see managerutil.IdentityTokenHeader.
+// The traffic-manager validates the token using one or more methods, tried in the configured order,
+// and the first method that accepts the token determines the identity of the client. With the
+// tokenreview method, the token is a Kubernetes bearer token, e.g. the token of a ServiceAccount,
+// that the API server validates in a TokenReview. With the oidc method, it's an ID token that an
+// OpenID Connect provider has issued to a configured client. With the static method, it's listed
+// together with the identity in a file that an admin maintains.
+//
+// A client that connects using mutual TLS can instead be identified by its certificate, see
+// CertificateIdentity. That's the certificate method, which doesn't involve tokens.
+//
+// The identity is what the intercept policy is evaluated against.
+package auth
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"strings"
+
+	"k8s.io/client-go/kubernetes"
+)
+
+// The authentication methods
+const (
+	MethodTokenReview = "tokenreview"
+	MethodOIDC        = "oidc"
+	MethodStatic      = "static"
+	MethodCertificate = "certificate"
+)
+
+// Identity is the identity of an authenticated client.
+type Identity struct {
+	Username string
+	Groups   []string
+
+	// Method is the method that authenticated the client
+	Method string
+}
+
+// Authenticator validates the token that a client presents.
+type Authenticator interface {
+	// Authenticate returns the identity of the client that presented the given token, or an error
+	// if the token isn't valid.
+	Authenticate(ctx context.Context, token string) (*Identity, error)
+}
+
+// Config configures the authentication methods.
+type Config struct {
+	// Methods are the names of the methods to use, in the order they are tried.
+	Methods []string
+
+	// StaticTokenFile is the file of the static method. It uses the format of the static token
+	// file of the Kubernetes API server, i.e. CSV with the columns token, user, uid, and an
+	// optional comma separated list of groups.
+	StaticTokenFile string
+
+	// TokenAudiences are the audiences that the tokens of the tokenreview method must be issued
+	// for. The audiences of the API server are used when none are configured.
+	TokenAudiences []string
+
+	// OIDCIssuer is the URL of the OpenID Connect provider of the oidc method, and OIDCClientID the
+	// client ID that the ID tokens must be issued for.
+	OIDCIssuer   string
+	OIDCClientID string
+
+	// OIDCCAFile is the file with the certificates of the authorities that the certificate of the
+	// provider is verified against. The system's certificates are used when it's empty.
+	OIDCCAFile string
+
+	// OIDCUsernameClaim and OIDCGroupsClaim are the claims of the ID token that hold the user name
+	// and the groups.
+	OIDCUsernameClaim string
+	OIDCGroupsClaim   string
+
+	// Clientset is used by the tokenreview method. It's created from the in-cluster config when
+	// it's nil.
+	Clientset kubernetes.Interface
+}
+
+// New returns an Authenticator that tries the configured token methods in order. The certificate
+// method is left out, see UsesCertificates.
+func New(ctx context.Context, cfg *Config) (Authenticator, error) {
+	var ch chain
+	for _, method := range cfg.Methods {
+		var a Authenticator
+		var err error
+		switch method = strings.ToLower(strings.TrimSpace(method)); method {
+		case MethodTokenReview:
+			if cfg.Clientset == nil {
+				if cfg.Clientset, err = InClusterClientset(); err != nil {
+					break
+				}
+			}
+			a = &tokenReview{cs: cfg.Clientset, audiences: cfg.TokenAudiences}
+		case MethodOIDC:
+			a, err = newOIDC(cfg.OIDCIssuer, cfg.OIDCClientID, cfg.OIDCCAFile, cfg.OIDCUsernameClaim, cfg.OIDCGroupsClaim)
+		case MethodStatic:
+			a, err = newStatic(cfg.StaticTokenFile)
+		case MethodCertificate, "":
+			continue
+		default:
+			return nil, fmt.Errorf("unknown authentication method %q, expected %s, %s, %s, or %s",
+				method, MethodTokenReview, MethodOIDC, MethodStatic, MethodCertificate)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to set up authentication method %s: %w", method, err)
+		}
+		ch = append(ch, a)
+	}
+	return newCache(ch, cacheTTL), nil
+}
+
+// chain is an Authenticator that tries several authenticators in order.
+type chain []Authenticator
+
+func (ch chain) Authenticate(ctx context.Context, token string) (*Identity, error) {
+	if len(ch) == 0 {
+		return nil, errors.New("no authentication method is configured")
+	}
+	var msgs []string
+	for _, a := range ch {
+		id, err := a.Authenticate(ctx, token)
+		if err == nil {
+			return id, nil
+		}
+		msgs = append(msgs, err.Error())
+	}
+	return nil, errors.New(strings.Join(msgs, "; "))
+}
+
+// UsesCertificates returns true if the given methods include the certificate method.
+func UsesCertificates(methods []string) bool {
+	for _, method := range methods {
+		if strings.ToLower(strings.TrimSpace(method)) == MethodCertificate {
+			return true
+		}
+	}
+	return false
+}
+
+// CertificateIdentity returns the identity of a client that was verified using mutual TLS with the
+// given certificate. As with the client certificates of the Kubernetes API server, the common name
+// of the subject is the user name, and its organizations are the groups.
+func CertificateIdentity(cert *x509.Certificate) *Identity {
+	return &Identity{
+		Username: cert.Subject.CommonName,
+		Groups:   cert.Subject.Organization,
+		Method:   MethodCertificate,
+	}
+}
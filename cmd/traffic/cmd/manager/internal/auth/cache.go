@@ -0,0 +1,65 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"sync"
+	"time"
+)
+
+// cacheTTL is how long an identity is remembered. Every call of a client is authenticated when
+// authentication is required, and a token that is revoked is refused again after this long.
+const cacheTTL = 30 * time.Second
+
+// cache is an Authenticator that remembers the identities that another Authenticator returned, so
+// that a client that makes many calls doesn't cause a TokenReview, or a verification of an ID token,
+// for each one of them. Failures aren't remembered, so that a token is accepted as soon as e.g. the
+// API server can be reached again. The tokens are stored as hashes.
+type cache struct {
+	a   Authenticator
+	ttl time.Duration
+	now func() time.Time
+
+	mu      sync.Mutex
+	entries map[[sha256.Size]byte]cacheEntry
+}
+
+type cacheEntry struct {
+	id      *Identity
+	expires time.Time
+}
+
+func newCache(a Authenticator, ttl time.Duration) *cache {
+	return &cache{
+		a:       a,
+		ttl:     ttl,
+		now:     time.Now,
+		entries: make(map[[sha256.Size]byte]cacheEntry),
+	}
+}
+
+func (c *cache) Authenticate(ctx context.Context, token string) (*Identity, error) {
+	key := sha256.Sum256([]byte(token))
+	c.mu.Lock()
+	e, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok && c.now().Before(e.expires) {
+		return e.id, nil
+	}
+
+	id, err := c.a.Authenticate(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	now := c.now()
+	c.mu.Lock()
+	for k, e := range c.entries {
+		if !now.Before(e.expires) {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = cacheEntry{id: id, expires: now.Add(c.ttl)}
+	c.mu.Unlock()
+	return id, nil
+}
@@ -0,0 +1,62 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"k8s.io/apiserver/plugin/pkg/authenticator/token/oidc"
+)
+
+// oidcSigningAlgs are the algorithms that the signature of an ID token may use. The Kubernetes API
+// server accepts only RS256 unless told otherwise, but providers commonly use the other asymmetric
+// algorithms too.
+var oidcSigningAlgs = []string{
+	"RS256", "RS384", "RS512",
+	"ES256", "ES384", "ES512",
+	"PS256", "PS384", "PS512",
+}
+
+// oidcAuth validates ID tokens issued by an OpenID Connect provider. It's the authenticator that the
+// Kubernetes API server uses for its --oidc-* flags, so the claims are checked the same way, and
+// the signing keys are fetched from the provider, as announced by its discovery document, and
+// fetched again when the provider starts to use a new key.
+type oidcAuth struct {
+	a *oidc.Authenticator
+}
+
+func newOIDC(issuer, clientID, caFile, usernameClaim, groupsClaim string) (Authenticator, error) {
+	if issuer == "" || clientID == "" {
+		return nil, errors.New("the issuer and the client ID must be configured")
+	}
+	if usernameClaim == "" {
+		usernameClaim = "sub"
+	}
+	a, err := oidc.New(oidc.Options{
+		IssuerURL:            issuer,
+		ClientID:             clientID,
+		CAFile:               caFile,
+		UsernameClaim:        usernameClaim,
+		GroupsClaim:          groupsClaim,
+		SupportedSigningAlgs: oidcSigningAlgs,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &oidcAuth{a: a}, nil
+}
+
+func (o *oidcAuth) Authenticate(ctx context.Context, token string) (*Identity, error) {
+	rsp, ok, err := o.a.AuthenticateToken(ctx, token)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", MethodOIDC, err)
+	}
+	if !ok {
+		return nil, fmt.Errorf("%s: token is not authenticated", MethodOIDC)
+	}
+	return &Identity{
+		Username: rsp.User.GetName(),
+		Groups:   rsp.User.GetGroups(),
+		Method:   MethodOIDC,
+	}, nil
+}
@@ -0,0 +1,147 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func signJWT(t *testing.T, alg, kid string, key crypto.Signer, claims map[string]interface{}) string {
+	hdr, err := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	require.NoError(t, err)
+	payload, err := json.Marshal(claims)
+	require.NoError(t, err)
+	signed := b64(hdr) + "." + b64(payload)
+	digest := sha256.Sum256([]byte(signed))
+	var sig []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+		require.NoError(t, err)
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		require.NoError(t, err)
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	}
+	return signed + "." + b64(sig)
+}
+
+func TestOIDC(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	var issuer string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{"issuer": issuer, "jwks_uri": issuer + "/keys"})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{
+			{"kid": "r1", "kty": "RSA", "use": "sig", "n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+			{"kid": "e1", "kty": "EC", "crv": "P-256", "x": b64(ecKey.X.Bytes()), "y": b64(ecKey.Y.Bytes())},
+		}})
+	})
+	srv := httptest.NewTLSServer(mux)
+	defer srv.Close()
+	issuer = srv.URL
+	caFile := filepath.Join(t.TempDir(), "ca.crt")
+	require.NoError(t, ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0600))
+
+	a, err := newOIDC(issuer, "telepresence", caFile, "email", "groups")
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	now := time.Now()
+	claims := func() map[string]interface{} {
+		return map[string]interface{}{
+			"iss":            issuer,
+			"aud":            []string{"telepresence", "other"},
+			"exp":            now.Add(time.Hour).Unix(),
+			"email":          "alice@example.com",
+			"email_verified": true,
+			"groups":         []string{"sre"},
+		}
+	}
+
+	// The provider is contacted in the background, ten seconds after the start, and tokens are
+	// refused until then
+	var id *Identity
+	require.Eventually(t, func() bool {
+		id, err = a.Authenticate(ctx, signJWT(t, "RS256", "r1", rsaKey, claims()))
+		return err == nil
+	}, 20*time.Second, 100*time.Millisecond)
+	assert.Equal(t, &Identity{Username: "alice@example.com", Groups: []string{"sre"}, Method: MethodOIDC}, id)
+
+	id, err = a.Authenticate(ctx, signJWT(t, "ES256", "e1", ecKey, claims()))
+	require.NoError(t, err)
+	assert.Equal(t, "alice@example.com", id.Username)
+
+	// A token that claims another algorithm than the one of its key
+	_, err = a.Authenticate(ctx, signJWT(t, "ES256", "r1", rsaKey, claims()))
+	assert.Error(t, err)
+
+	// A token signed with another key
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	_, err = a.Authenticate(ctx, signJWT(t, "RS256", "r1", otherKey, claims()))
+	assert.Error(t, err)
+
+	c := claims()
+	c["exp"] = now.Add(-time.Hour).Unix()
+	_, err = a.Authenticate(ctx, signJWT(t, "RS256", "r1", rsaKey, c))
+	assert.Error(t, err)
+
+	c = claims()
+	c["aud"] = "other"
+	_, err = a.Authenticate(ctx, signJWT(t, "RS256", "r1", rsaKey, c))
+	assert.Error(t, err)
+
+	c = claims()
+	c["email_verified"] = false
+	_, err = a.Authenticate(ctx, signJWT(t, "RS256", "r1", rsaKey, c))
+	assert.Error(t, err)
+
+	c = claims()
+	c["iss"] = "https://accounts.example.com"
+	_, err = a.Authenticate(ctx, signJWT(t, "RS256", "r1", rsaKey, c))
+	assert.Error(t, err)
+
+	_, err = a.Authenticate(ctx, "not-a-jwt")
+	assert.Error(t, err)
+
+	// An unsigned token is never accepted
+	parts := strings.Split(signJWT(t, "RS256", "r1", rsaKey, claims()), ".")
+	hdr, err := json.Marshal(map[string]string{"alg": "none", "kid": "r1"})
+	require.NoError(t, err)
+	_, err = a.Authenticate(ctx, b64(hdr)+"."+parts[1]+".")
+	assert.Error(t, err)
+
+	_, err = newOIDC("http://accounts.example.com", "telepresence", "", "", "")
+	assert.Error(t, err, "the issuer must use https")
+}
@@ -0,0 +1,114 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// static validates tokens that an admin has issued by listing them in a file. The file is read
+// again when it changes, so that tokens can be added and revoked without a restart of the
+// traffic-manager, e.g. by updating the Secret that the file is mounted from.
+type static struct {
+	file string
+
+	mu      sync.Mutex
+	modTime time.Time
+	tokens  map[string]*Identity
+}
+
+func newStatic(file string) (Authenticator, error) {
+	s := &static{file: file}
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *static) Authenticate(_ context.Context, token string) (*Identity, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.reload(); err != nil {
+		// Keep using the tokens that were read last
+		if s.tokens == nil {
+			return nil, fmt.Errorf("%s: %w", MethodStatic, err)
+		}
+	}
+	// Compare all the tokens in constant time, so that the time it takes doesn't reveal anything
+	var found *Identity
+	for t, id := range s.tokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			found = id
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("%s: unknown token", MethodStatic)
+	}
+	return found, nil
+}
+
+// reload reads the file if it has changed since it was read last.
+func (s *static) reload() error {
+	st, err := os.Stat(s.file)
+	if err != nil {
+		return err
+	}
+	if s.tokens != nil && st.ModTime().Equal(s.modTime) {
+		return nil
+	}
+	f, err := os.Open(s.file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	tokens, err := parseStaticTokens(f)
+	if err != nil {
+		return fmt.Errorf("invalid token file %s: %w", s.file, err)
+	}
+	s.tokens = tokens
+	s.modTime = st.ModTime()
+	return nil
+}
+
+// parseStaticTokens parses the CSV format of the static token file of the Kubernetes API server.
+func parseStaticTokens(r io.Reader) (map[string]*Identity, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.Comment = '#'
+	cr.TrimLeadingSpace = true
+	tokens := make(map[string]*Identity)
+	for entry := 1; ; entry++ {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			return tokens, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(rec) < 3 {
+			return nil, fmt.Errorf("entry %d: expected token, user, uid, and optionally groups", entry)
+		}
+		token, user := strings.TrimSpace(rec[0]), strings.TrimSpace(rec[1])
+		if token == "" || user == "" {
+			return nil, fmt.Errorf("entry %d: the token and the user must not be empty", entry)
+		}
+		if _, dup := tokens[token]; dup {
+			return nil, fmt.Errorf("entry %d: duplicate token", entry)
+		}
+		id := &Identity{Username: user, Method: MethodStatic}
+		if len(rec) > 3 {
+			for _, g := range strings.Split(rec[3], ",") {
+				if g = strings.TrimSpace(g); g != "" {
+					id.Groups = append(id.Groups, g)
+				}
+			}
+		}
+		tokens[token] = id
+	}
+}
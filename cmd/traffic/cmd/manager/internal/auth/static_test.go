@@ -0,0 +1,85 @@
+package auth
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseStaticTokens(t *testing.T) {
+	tokens, err := parseStaticTokens(strings.NewReader(`# token,user,uid,groups
+s3cr3t,alice,1001,"sre, dev"
+t0ken,bob,1002
+`))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]*Identity{
+		"s3cr3t": {Username: "alice", Groups: []string{"sre", "dev"}, Method: MethodStatic},
+		"t0ken":  {Username: "bob", Method: MethodStatic},
+	}, tokens)
+
+	_, err = parseStaticTokens(strings.NewReader("s3cr3t,alice\n"))
+	assert.Error(t, err)
+	_, err = parseStaticTokens(strings.NewReader("s3cr3t,alice,1\ns3cr3t,bob,2\n"))
+	assert.EqualError(t, err, "entry 2: duplicate token")
+}
+
+func TestStatic(t *testing.T) {
+	ctx := context.Background()
+	file := filepath.Join(t.TempDir(), "tokens.csv")
+	_, err := newStatic(file)
+	assert.Error(t, err, "the file must exist")
+
+	require.NoError(t, ioutil.WriteFile(file, []byte("s3cr3t,alice,1001,sre\n"), 0600))
+	a, err := newStatic(file)
+	require.NoError(t, err)
+	id, err := a.Authenticate(ctx, "s3cr3t")
+	require.NoError(t, err)
+	assert.Equal(t, "alice", id.Username)
+	_, err = a.Authenticate(ctx, "t0ken")
+	assert.EqualError(t, err, "static: unknown token")
+
+	// The file is read again when it changes
+	require.NoError(t, ioutil.WriteFile(file, []byte("t0ken,bob,1002\n"), 0600))
+	later := time.Now().Add(time.Second)
+	require.NoError(t, os.Chtimes(file, later, later))
+	id, err = a.Authenticate(ctx, "t0ken")
+	require.NoError(t, err)
+	assert.Equal(t, "bob", id.Username)
+	_, err = a.Authenticate(ctx, "s3cr3t")
+	assert.Error(t, err, "revoked tokens are rejected")
+}
+
+func TestChain(t *testing.T) {
+	ctx := context.Background()
+	file := filepath.Join(t.TempDir(), "tokens.csv")
+	require.NoError(t, ioutil.WriteFile(file, []byte("s3cr3t,alice,1001\n"), 0600))
+	a, err := New(ctx, &Config{Methods: []string{"static"}, StaticTokenFile: file})
+	require.NoError(t, err)
+	id, err := a.Authenticate(ctx, "s3cr3t")
+	require.NoError(t, err)
+	assert.Equal(t, MethodStatic, id.Method)
+
+	_, err = New(ctx, &Config{Methods: []string{"ldap"}})
+	assert.Error(t, err)
+
+	// The certificate method doesn't validate tokens
+	a, err = New(ctx, &Config{Methods: []string{"certificate", "static"}, StaticTokenFile: file})
+	require.NoError(t, err)
+	id, err = a.Authenticate(ctx, "s3cr3t")
+	require.NoError(t, err)
+	assert.Equal(t, MethodStatic, id.Method)
+	assert.True(t, UsesCertificates([]string{"tokenreview", " Certificate"}))
+	assert.False(t, UsesCertificates([]string{"tokenreview"}))
+
+	a, err = New(ctx, &Config{})
+	require.NoError(t, err)
+	_, err = a.Authenticate(ctx, "s3cr3t")
+	assert.EqualError(t, err, "no authentication method is configured")
+}
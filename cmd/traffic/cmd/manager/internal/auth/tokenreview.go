@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	authv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// serviceAccountPrefix is the prefix of the user names of ServiceAccounts, see ServiceAccount.
+const serviceAccountPrefix = "system:serviceaccount:"
+
+// tokenReview validates Kubernetes bearer tokens using TokenReviews.
+type tokenReview struct {
+	cs kubernetes.Interface
+
+	// audiences are the audiences that the tokens must be issued for. The API server checks the
+	// tokens against its own audiences when there are none.
+	audiences []string
+}
+
+// NewServiceAccountReview returns an Authenticator that validates the tokens of the ServiceAccounts
+// of the cluster, such as the ones that the traffic-agents present, using TokenReviews made with the
+// given clientset. Tokens of other users are refused. Use ServiceAccount to tell which
+// ServiceAccount a token belongs to.
+func NewServiceAccountReview(cs kubernetes.Interface) Authenticator {
+	return newCache(&serviceAccountReview{tokenReview{cs: cs}}, cacheTTL)
+}
+
+// InClusterClientset returns a clientset that uses the in-cluster config.
+func InClusterClientset() (kubernetes.Interface, error) {
+	cfg, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, err
+	}
+	return kubernetes.NewForConfig(cfg)
+}
+
+func (r *tokenReview) Authenticate(ctx context.Context, token string) (*Identity, error) {
+	tr, err := r.cs.AuthenticationV1().TokenReviews().Create(ctx, &authv1.TokenReview{
+		Spec: authv1.TokenReviewSpec{Token: token, Audiences: r.audiences},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", MethodTokenReview, err)
+	}
+	if !tr.Status.Authenticated {
+		if tr.Status.Error != "" {
+			return nil, fmt.Errorf("%s: %s", MethodTokenReview, tr.Status.Error)
+		}
+		return nil, fmt.Errorf("%s: token is not authenticated", MethodTokenReview)
+	}
+	if len(r.audiences) > 0 && !intersects(r.audiences, tr.Status.Audiences) {
+		// An authenticator that doesn't support audiences may ignore them, so the API server only
+		// vouches for the audiences that it returns.
+		return nil, fmt.Errorf("%s: token is not issued for any of the audiences %s", MethodTokenReview, strings.Join(r.audiences, ", "))
+	}
+	return &Identity{
+		Username: tr.Status.User.Username,
+		Groups:   tr.Status.User.Groups,
+		Method:   MethodTokenReview,
+	}, nil
+}
+
+// serviceAccountReview is a tokenReview that only accepts the tokens of ServiceAccounts.
+type serviceAccountReview struct {
+	tokenReview
+}
+
+func (r *serviceAccountReview) Authenticate(ctx context.Context, token string) (*Identity, error) {
+	id, err := r.tokenReview.Authenticate(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	if _, _, ok := ServiceAccount(id); !ok {
+		return nil, fmt.Errorf("%s: user %q is not a ServiceAccount", MethodTokenReview, id.Username)
+	}
+	return id, nil
+}
+
+// ServiceAccount returns the namespace and the name of the ServiceAccount that the given identity
+// is, and false if the identity isn't a ServiceAccount.
+func ServiceAccount(id *Identity) (namespace, name string, ok bool) {
+	if id.Method != MethodTokenReview || !strings.HasPrefix(id.Username, serviceAccountPrefix) {
+		return "", "", false
+	}
+	parts := strings.Split(strings.TrimPrefix(id.Username, serviceAccountPrefix), ":")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+func intersects(as, bs []string) bool {
+	for _, a := range as {
+		for _, b := range bs {
+			if a == b {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,178 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	authv1 "k8s.io/api/authentication/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// fakeTokenReviews returns a clientset that authenticates the given tokens as the given users, for
+// the given audiences. A review that names no audiences gets the audience "https://kubernetes".
+func fakeTokenReviews(users map[string]string, audiences map[string][]string) (*fake.Clientset, *int) {
+	cs := fake.NewSimpleClientset()
+	reviews := 0
+	cs.PrependReactor("create", "tokenreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		reviews++
+		tr := action.(k8stesting.CreateAction).GetObject().(*authv1.TokenReview).DeepCopy()
+		user, ok := users[tr.Spec.Token]
+		if !ok {
+			tr.Status.Error = "invalid bearer token"
+			return true, tr, nil
+		}
+		want := tr.Spec.Audiences
+		if len(want) == 0 {
+			want = []string{"https://kubernetes"}
+		}
+		for _, a := range audiences[tr.Spec.Token] {
+			for _, w := range want {
+				if a == w {
+					tr.Status.Audiences = append(tr.Status.Audiences, a)
+				}
+			}
+		}
+		if len(tr.Status.Audiences) == 0 {
+			tr.Status.Error = "token audiences are invalid"
+			return true, tr, nil
+		}
+		tr.Status.Authenticated = true
+		tr.Status.User.Username = user
+		return true, tr, nil
+	})
+	return cs, &reviews
+}
+
+func TestTokenReview(t *testing.T) {
+	ctx := context.Background()
+	cs, _ := fakeTokenReviews(
+		map[string]string{"sa": "system:serviceaccount:default:hello", "tp": "alice"},
+		map[string][]string{"sa": {"https://kubernetes"}, "tp": {"telepresence"}},
+	)
+
+	r := &tokenReview{cs: cs}
+	id, err := r.Authenticate(ctx, "sa")
+	require.NoError(t, err)
+	assert.Equal(t, &Identity{Username: "system:serviceaccount:default:hello", Method: MethodTokenReview}, id)
+	_, err = r.Authenticate(ctx, "tp")
+	assert.EqualError(t, err, "tokenreview: token audiences are invalid")
+	_, err = r.Authenticate(ctx, "nope")
+	assert.EqualError(t, err, "tokenreview: invalid bearer token")
+
+	r = &tokenReview{cs: cs, audiences: []string{"telepresence"}}
+	id, err = r.Authenticate(ctx, "tp")
+	require.NoError(t, err)
+	assert.Equal(t, "alice", id.Username)
+	_, err = r.Authenticate(ctx, "sa")
+	assert.Error(t, err, "a token of the API server is refused")
+}
+
+func TestTokenReviewIgnoredAudiences(t *testing.T) {
+	// An API server that authenticates the token but doesn't vouch for the requested audiences
+	cs := fake.NewSimpleClientset()
+	cs.PrependReactor("create", "tokenreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		tr := action.(k8stesting.CreateAction).GetObject().(*authv1.TokenReview).DeepCopy()
+		tr.Status.Authenticated = true
+		tr.Status.User.Username = "alice"
+		return true, tr, nil
+	})
+	r := &tokenReview{cs: cs, audiences: []string{"telepresence"}}
+	_, err := r.Authenticate(context.Background(), "tp")
+	assert.EqualError(t, err, "tokenreview: token is not issued for any of the audiences telepresence")
+}
+
+func TestServiceAccountReview(t *testing.T) {
+	ctx := context.Background()
+	cs, reviews := fakeTokenReviews(
+		map[string]string{"sa": "system:serviceaccount:default:hello", "user": "alice"},
+		map[string][]string{"sa": {"https://kubernetes"}, "user": {"https://kubernetes"}},
+	)
+	r := NewServiceAccountReview(cs)
+
+	id, err := r.Authenticate(ctx, "sa")
+	require.NoError(t, err)
+	ns, name, ok := ServiceAccount(id)
+	assert.True(t, ok)
+	assert.Equal(t, "default", ns)
+	assert.Equal(t, "hello", name)
+
+	_, err = r.Authenticate(ctx, "user")
+	assert.EqualError(t, err, `tokenreview: user "alice" is not a ServiceAccount`)
+
+	// The ServiceAccount is remembered
+	*reviews = 0
+	_, err = r.Authenticate(ctx, "sa")
+	require.NoError(t, err)
+	assert.Equal(t, 0, *reviews)
+
+	_, _, ok = ServiceAccount(&Identity{Username: "system:serviceaccount:default", Method: MethodTokenReview})
+	assert.False(t, ok)
+	_, _, ok = ServiceAccount(&Identity{Username: "system:serviceaccount:default:hello", Method: MethodStatic})
+	assert.False(t, ok, "only the API server can vouch for a ServiceAccount")
+}
+
+func TestSharedClientset(t *testing.T) {
+	ctx := context.Background()
+	cs, reviews := fakeTokenReviews(
+		map[string]string{"sa": "system:serviceaccount:default:hello", "user": "alice"},
+		map[string][]string{"sa": {"https://kubernetes"}, "user": {"https://kubernetes"}},
+	)
+	a, err := New(ctx, &Config{Methods: []string{"tokenreview"}, Clientset: cs})
+	require.NoError(t, err)
+	id, err := a.Authenticate(ctx, "user")
+	require.NoError(t, err)
+	assert.Equal(t, "alice", id.Username)
+	_, err = NewServiceAccountReview(cs).Authenticate(ctx, "sa")
+	require.NoError(t, err)
+	assert.Equal(t, 2, *reviews, "both reviews use the given clientset")
+}
+
+type countingAuth struct {
+	calls int
+	err   error
+}
+
+func (a *countingAuth) Authenticate(_ context.Context, token string) (*Identity, error) {
+	a.calls++
+	if a.err != nil {
+		return nil, a.err
+	}
+	return &Identity{Username: token}, nil
+}
+
+func TestCache(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	a := &countingAuth{}
+	c := newCache(a, time.Minute)
+	c.now = func() time.Time { return now }
+
+	id, err := c.Authenticate(ctx, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, "alice", id.Username)
+	_, err = c.Authenticate(ctx, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, 1, a.calls)
+
+	// Failures aren't remembered
+	a.err = errors.New("unreachable")
+	_, err = c.Authenticate(ctx, "bob")
+	assert.Error(t, err)
+	a.err = nil
+	id, err = c.Authenticate(ctx, "bob")
+	require.NoError(t, err)
+	assert.Equal(t, "bob", id.Username)
+	assert.Equal(t, 3, a.calls)
+
+	// The identities expire
+	now = now.Add(time.Minute)
+	a.err = errors.New("revoked")
+	_, err = c.Authenticate(ctx, "alice")
+	assert.Error(t, err)
+}
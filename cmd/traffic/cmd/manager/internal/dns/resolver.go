@@ -0,0 +1,251 @@
+// Package dns implements the cluster side DNS resolution that the traffic-manager does on behalf of
+// its clients.
+//
+// All clients that are connected to a traffic-manager resolve the names of the cluster through it,
+// so the same names are looked up over and over again. The Resolver caches the results for a short
+// while, and concurrent lookups of the same name share one request to the cluster DNS. Names that
+// can't be resolved are cached too, but for a shorter time, because a client that gets NXDOMAIN
+// for a name in one search path domain will try the next one.
+//
+// Overrides make a name resolve to fixed addresses for all clients, or for the clients whose names
+// match glob patterns, without touching the cluster DNS.
+package dns
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/iputil"
+)
+
+// Override makes the given hosts resolve to the given addresses for the clients that it selects
+type Override struct {
+	// Clients are glob patterns matching client names, e.g. "alice@*". An empty list matches all
+	// clients.
+	Clients []string `json:"clients,omitempty"`
+
+	// Hosts are the addresses of each host
+	Hosts map[string][]string `json:"hosts"`
+}
+
+// ParseOverrides parses a JSON list of overrides. An empty string means no overrides.
+func ParseOverrides(data string) ([]Override, error) {
+	if strings.TrimSpace(data) == "" {
+		return nil, nil
+	}
+	var overrides []Override
+	if err := json.Unmarshal([]byte(data), &overrides); err != nil {
+		return nil, err
+	}
+	for i := range overrides {
+		o := &overrides[i]
+		for _, p := range o.Clients {
+			if _, err := path.Match(p, ""); err != nil {
+				return nil, fmt.Errorf("override %d: bad pattern %q: %w", i, p, err)
+			}
+		}
+		hosts := make(map[string][]string, len(o.Hosts))
+		for host, addrs := range o.Hosts {
+			for _, addr := range addrs {
+				if net.ParseIP(addr) == nil {
+					return nil, fmt.Errorf("override %d: %q is not an IP address", i, addr)
+				}
+			}
+			hosts[canonicalHost(host)] = addrs
+		}
+		o.Hosts = hosts
+	}
+	return overrides, nil
+}
+
+func (o *Override) selects(client string) bool {
+	if len(o.Clients) == 0 {
+		return true
+	}
+	for _, p := range o.Clients {
+		if ok, _ := path.Match(p, client); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Stats are the counters of a Resolver
+type Stats struct {
+	// Overridden is the number of lookups that were answered by an override
+	Overridden uint64 `json:"overridden"`
+
+	// Lookups is the number of names that were looked up, not counting the overridden ones
+	Lookups uint64 `json:"lookups"`
+
+	// CacheHits is the number of lookups that were answered by the cache, including those that
+	// waited for a concurrent lookup of the same name
+	CacheHits uint64 `json:"cacheHits"`
+
+	// Resolved is the number of lookups that were sent to the cluster DNS and found addresses
+	Resolved uint64 `json:"resolved"`
+
+	// NotFound is the number of lookups that were sent to the cluster DNS and found nothing
+	NotFound uint64 `json:"notFound"`
+
+	// CacheSize is the number of names in the cache
+	CacheSize int `json:"cacheSize"`
+}
+
+type entry struct {
+	done    chan struct{} // closed when the lookup is done
+	ips     iputil.IPs
+	expires time.Time
+}
+
+// Resolver resolves names using the DNS of the cluster, with a cache and overrides
+type Resolver struct {
+	ttl         time.Duration
+	negativeTTL time.Duration
+	overrides   []Override
+	now         func() time.Time
+	lookup      func(context.Context, string) ([]string, error)
+
+	mu      sync.Mutex
+	cache   map[string]*entry
+	stats   Stats
+	cleaned time.Time
+}
+
+// NewResolver returns a Resolver that caches the addresses that it finds for ttl, and that caches
+// names that can't be resolved for negativeTTL. Nothing is cached when a TTL is zero.
+func NewResolver(ttl, negativeTTL time.Duration, overrides []Override, now func() time.Time) *Resolver {
+	return &Resolver{
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+		overrides:   overrides,
+		now:         now,
+		lookup:      net.DefaultResolver.LookupHost,
+		cache:       make(map[string]*entry),
+		cleaned:     now(),
+	}
+}
+
+func canonicalHost(host string) string {
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
+
+// Override returns the addresses that an override gives the given host for the client with the
+// given name, and true, or false when no override applies.
+func (r *Resolver) Override(client, host string) (iputil.IPs, bool) {
+	host = canonicalHost(host)
+	for i := range r.overrides {
+		o := &r.overrides[i]
+		if addrs, ok := o.Hosts[host]; ok && o.selects(client) {
+			r.mu.Lock()
+			r.stats.Overridden++
+			r.mu.Unlock()
+			return parseIPs(addrs), true
+		}
+	}
+	return nil, false
+}
+
+// LookupHost returns the addresses of the given host. An empty list is returned when the host can't
+// be resolved.
+func (r *Resolver) LookupHost(ctx context.Context, host string) iputil.IPs {
+	host = canonicalHost(host)
+	r.mu.Lock()
+	r.stats.Lookups++
+	now := r.now()
+	r.removeExpired(now)
+	if e, ok := r.cache[host]; ok {
+		select {
+		case <-e.done:
+			if now.Before(e.expires) {
+				r.stats.CacheHits++
+				r.mu.Unlock()
+				return e.ips
+			}
+		default:
+			// Another lookup of the same host is in progress, so wait for it
+			r.stats.CacheHits++
+			r.mu.Unlock()
+			select {
+			case <-e.done:
+				return e.ips
+			case <-ctx.Done():
+				return iputil.IPs{}
+			}
+		}
+	}
+	e := &entry{done: make(chan struct{})}
+	r.cache[host] = e
+	r.mu.Unlock()
+
+	addrs, err := r.lookup(ctx, host)
+	ips := iputil.IPs{}
+	if err == nil {
+		ips = parseIPs(addrs)
+	}
+
+	r.mu.Lock()
+	e.ips = ips
+	ttl := r.ttl
+	if len(ips) == 0 {
+		r.stats.NotFound++
+		ttl = r.negativeTTL
+	} else {
+		r.stats.Resolved++
+	}
+	if ctx.Err() != nil {
+		// A lookup that was cancelled says nothing about the host
+		ttl = 0
+	}
+	if ttl > 0 {
+		e.expires = r.now().Add(ttl)
+	} else if r.cache[host] == e {
+		delete(r.cache, host)
+	}
+	close(e.done)
+	r.mu.Unlock()
+	return ips
+}
+
+// removeExpired removes the expired entries from the cache. It's called with the mutex locked, and
+// does nothing unless a while has passed since it last did it.
+func (r *Resolver) removeExpired(now time.Time) {
+	if now.Sub(r.cleaned) < r.ttl {
+		return
+	}
+	r.cleaned = now
+	for host, e := range r.cache {
+		select {
+		case <-e.done:
+			if !now.Before(e.expires) {
+				delete(r.cache, host)
+			}
+		default:
+		}
+	}
+}
+
+// Stats returns the current counters of the resolver
+func (r *Resolver) Stats() Stats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := r.stats
+	s.CacheSize = len(r.cache)
+	return s
+}
+
+func parseIPs(addrs []string) iputil.IPs {
+	ips := make(iputil.IPs, 0, len(addrs))
+	for _, addr := range addrs {
+		if ip := iputil.Parse(addr); ip != nil {
+			ips = append(ips, ip)
+		}
+	}
+	return ips
+}
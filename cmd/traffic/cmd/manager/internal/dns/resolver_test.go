@@ -0,0 +1,129 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/iputil"
+)
+
+type fakeDNS struct {
+	sync.Mutex
+	now     time.Time
+	lookups int
+	hosts   map[string][]string
+	release chan struct{}
+}
+
+func (f *fakeDNS) Now() time.Time {
+	f.Lock()
+	defer f.Unlock()
+	return f.now
+}
+
+func (f *fakeDNS) advance(d time.Duration) {
+	f.Lock()
+	f.now = f.now.Add(d)
+	f.Unlock()
+}
+
+func (f *fakeDNS) lookup(_ context.Context, host string) ([]string, error) {
+	if f.release != nil {
+		<-f.release
+	}
+	f.Lock()
+	defer f.Unlock()
+	f.lookups++
+	if addrs, ok := f.hosts[host]; ok {
+		return addrs, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+}
+
+func newTestResolver(f *fakeDNS, overrides []Override) *Resolver {
+	r := NewResolver(30*time.Second, 5*time.Second, overrides, f.Now)
+	r.lookup = f.lookup
+	return r
+}
+
+func TestResolverCache(t *testing.T) {
+	ctx := context.Background()
+	f := &fakeDNS{now: time.Unix(1600000000, 0), hosts: map[string][]string{"echo.default": {"10.0.0.1"}}}
+	r := newTestResolver(f, nil)
+
+	assert.Equal(t, iputil.IPs{iputil.Parse("10.0.0.1")}, r.LookupHost(ctx, "echo.default."))
+	assert.Equal(t, iputil.IPs{iputil.Parse("10.0.0.1")}, r.LookupHost(ctx, "ECHO.default"))
+	assert.Equal(t, 1, f.lookups)
+
+	assert.Empty(t, r.LookupHost(ctx, "missing.default"))
+	assert.Empty(t, r.LookupHost(ctx, "missing.default"))
+	assert.Equal(t, 2, f.lookups)
+
+	// The negative entry expires first
+	f.advance(10 * time.Second)
+	assert.Empty(t, r.LookupHost(ctx, "missing.default"))
+	r.LookupHost(ctx, "echo.default")
+	assert.Equal(t, 3, f.lookups)
+
+	f.advance(30 * time.Second)
+	r.LookupHost(ctx, "echo.default")
+	assert.Equal(t, 4, f.lookups)
+
+	s := r.Stats()
+	assert.Equal(t, uint64(7), s.Lookups)
+	assert.Equal(t, uint64(3), s.CacheHits)
+	assert.Equal(t, uint64(2), s.Resolved)
+	assert.Equal(t, uint64(2), s.NotFound)
+}
+
+func TestResolverSharesConcurrentLookups(t *testing.T) {
+	ctx := context.Background()
+	f := &fakeDNS{now: time.Unix(1600000000, 0), hosts: map[string][]string{"echo.default": {"10.0.0.1"}}, release: make(chan struct{})}
+	r := newTestResolver(f, nil)
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.Len(t, r.LookupHost(ctx, "echo.default"), 1)
+		}()
+	}
+	// Let the lookups start before the first one is answered
+	require.Eventually(t, func() bool { return r.Stats().Lookups == 5 }, time.Second, time.Millisecond)
+	close(f.release)
+	wg.Wait()
+	assert.Equal(t, 1, f.lookups)
+}
+
+func TestResolverOverrides(t *testing.T) {
+	overrides, err := ParseOverrides(`[
+		{"clients": ["alice@*"], "hosts": {"db.prod.": ["10.1.0.1"]}},
+		{"hosts": {"db.prod": ["10.2.0.1"]}}
+	]`)
+	require.NoError(t, err)
+	r := newTestResolver(&fakeDNS{}, overrides)
+
+	ips, ok := r.Override("alice@laptop", "DB.prod")
+	assert.True(t, ok)
+	assert.Equal(t, iputil.IPs{iputil.Parse("10.1.0.1")}, ips)
+
+	ips, ok = r.Override("bob@laptop", "db.prod")
+	assert.True(t, ok)
+	assert.Equal(t, iputil.IPs{iputil.Parse("10.2.0.1")}, ips)
+
+	_, ok = r.Override("alice@laptop", "cache.prod")
+	assert.False(t, ok)
+	assert.Equal(t, uint64(2), r.Stats().Overridden)
+
+	_, err = ParseOverrides(`[{"hosts": {"db.prod": ["not-an-ip"]}}]`)
+	assert.Error(t, err)
+	_, err = ParseOverrides(`[{"clients": ["["], "hosts": {}}]`)
+	assert.Error(t, err)
+}
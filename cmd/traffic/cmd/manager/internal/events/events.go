@@ -0,0 +1,126 @@
+// Package events records Kubernetes Events on the workloads that the traffic-manager modifies or
+// routes traffic for, so that the reason shows up in "kubectl describe".
+package events
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/managerutil"
+)
+
+// The reasons of the events that the traffic-manager records
+const (
+	// InterceptCreated is recorded when a client creates an intercept of the workload
+	InterceptCreated = "InterceptCreated"
+
+	// AgentInjected is recorded when the agent-injector adds a traffic-agent to a pod of the workload
+	AgentInjected = "AgentInjected"
+
+	// InterceptExpired is recorded when an intercept is removed because its client stopped sending
+	// heartbeats
+	InterceptExpired = "InterceptExpired"
+
+	// AgentRemoved is recorded when the last traffic-agent of the workload is gone
+	AgentRemoved = "AgentRemoved"
+
+	// InterceptOverridden is recorded when an admin takes over a workload that another client is
+	// intercepting
+	InterceptOverridden = "InterceptOverridden"
+
+	// InterceptTimedOut is recorded when an intercept is removed because it reached the end of the
+	// duration that its client asked for
+	InterceptTimedOut = "InterceptTimedOut"
+
+	// InterceptKicked is recorded when an intercept is removed because an admin removed the session
+	// of its client
+	InterceptKicked = "InterceptKicked"
+)
+
+const component = "traffic-manager"
+
+// queueSize is the number of events that can wait to be recorded. Events that are emitted while
+// the queue is full are dropped.
+const queueSize = 256
+
+type event struct {
+	namespace, kind, name, reason, message string
+}
+
+var queue = make(chan *event, queueSize)
+
+// record records the given event. Tests replace it.
+var record = recordEvent
+
+// Emit queues an Event with the given reason on the workload with the given namespace, kind, and
+// name, so that Run records it. Emit never blocks, because the API calls that recording an Event
+// needs must not delay the action that it describes. The event is dropped if the queue is full.
+func Emit(ctx context.Context, namespace, kind, name, reason, format string, args ...interface{}) {
+	ev := &event{namespace: namespace, kind: kind, name: name, reason: reason, message: fmt.Sprintf(format, args...)}
+	select {
+	case queue <- ev:
+	default:
+		dlog.Errorf(ctx, "unable to record %s event for %s.%s: the queue is full", reason, name, namespace)
+	}
+}
+
+// Run records the events that Emit queues, one at a time, until the given context is cancelled.
+func Run(ctx context.Context) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case ev := <-queue:
+			record(ctx, ev)
+		}
+	}
+}
+
+// recordEvent records the given event on its workload, which is found using
+// managerutil.FindWorkload. Errors are logged and never returned. An Event is informational and
+// must never stop the action that it describes.
+func recordEvent(ctx context.Context, e *event) {
+	client := managerutil.GetKatesClient(ctx)
+	if client == nil {
+		return
+	}
+	namespace, name, reason := e.namespace, e.name, e.reason
+	wl, err := managerutil.FindWorkload(ctx, client, namespace, e.kind, name)
+	if err != nil {
+		dlog.Debugf(ctx, "unable to record %s event for %s.%s: %v", reason, name, namespace, err)
+		return
+	}
+	now := metav1.Now()
+	ev := &corev1.Event{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Event",
+			APIVersion: "v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s.%x", wl.GetName(), now.UnixNano()),
+			Namespace: namespace,
+		},
+		InvolvedObject: corev1.ObjectReference{
+			Kind:            wl.GetKind(),
+			APIVersion:      wl.GetAPIVersion(),
+			Namespace:       namespace,
+			Name:            wl.GetName(),
+			UID:             wl.GetUID(),
+			ResourceVersion: wl.GetResourceVersion(),
+		},
+		Reason:         reason,
+		Message:        e.message,
+		Source:         corev1.EventSource{Component: component},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+		Type:           corev1.EventTypeNormal,
+	}
+	if err = client.Create(ctx, ev, nil); err != nil {
+		dlog.Errorf(ctx, "unable to record %s event for %s %s.%s: %v", reason, wl.GetKind(), name, namespace, err)
+	}
+}
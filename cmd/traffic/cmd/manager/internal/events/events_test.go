@@ -0,0 +1,62 @@
+package events
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+)
+
+func TestEmit(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	taken := make(chan struct{}, queueSize+1)
+	release := make(chan struct{})
+	recorded := make(chan *event, queueSize+1)
+	defer func() { record = recordEvent }()
+	record = func(_ context.Context, e *event) {
+		taken <- struct{}{}
+		<-release
+		recorded <- e
+	}
+
+	runCtx, cancel := context.WithCancel(ctx)
+	done := make(chan error)
+	go func() { done <- Run(runCtx) }()
+
+	// Emit doesn't wait for the events to be recorded, and drops the events that don't fit in the
+	// queue. The first event is taken off the queue by Run and waits for release.
+	start := time.Now()
+	Emit(ctx, "default", "Deployment", "echo", InterceptCreated, "Intercept %d", 0)
+	<-taken
+	for i := 1; i <= queueSize+10; i++ {
+		Emit(ctx, "default", "Deployment", "echo", InterceptCreated, "Intercept %d", i)
+	}
+	assert.Less(t, int64(time.Since(start)), int64(time.Second))
+
+	close(release)
+	var msgs []string
+	for len(msgs) < queueSize+1 {
+		select {
+		case e := <-recorded:
+			assert.Equal(t, "echo", e.name)
+			assert.Equal(t, InterceptCreated, e.reason)
+			msgs = append(msgs, e.message)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("only %d events were recorded", len(msgs))
+		}
+	}
+	assert.Equal(t, "Intercept 0", msgs[0])
+	assert.Equal(t, "Intercept 1", msgs[1])
+
+	cancel()
+	require.NoError(t, <-done)
+	select {
+	case e := <-recorded:
+		t.Fatalf("event %q was recorded after the queue was full", e.message)
+	default:
+	}
+}
@@ -5,21 +5,131 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	admission "k8s.io/api/admission/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/rest"
 
+	"github.com/datawire/ambassador/pkg/kates"
 	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/internal/events"
 	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/managerutil"
 	"github.com/telepresenceio/telepresence/v2/pkg/install"
 )
 
 var podResource = metav1.GroupVersionResource{Version: "v1", Group: "", Resource: "pods"}
 var findMatchingService = install.FindMatchingService
+var isOpenShift = inClusterIsOpenShift
+var managerClusterIP = inClusterManagerIP
+var podWorkload = inClusterPodWorkload
+var clusterNodes = inClusterNodes
 
-func agentInjector(ctx context.Context, req *admission.AdmissionRequest) ([]patchOperation, error) {
+var inClusterConfig = rest.InClusterConfig
+
+var openShiftMu sync.Mutex
+var openShift *bool
+
+// inClusterIsOpenShift returns true if the traffic-manager runs in an OpenShift cluster. Only a
+// successful check is cached, so a check that fails is made again on the next call.
+func inClusterIsOpenShift(ctx context.Context) bool {
+	openShiftMu.Lock()
+	defer openShiftMu.Unlock()
+	if openShift == nil {
+		config, err := inClusterConfig()
+		var is bool
+		if err == nil {
+			is, err = install.IsOpenShift(config)
+		}
+		if err != nil {
+			dlog.Errorf(ctx, "unable to determine if cluster is OpenShift: %v", err)
+			return false
+		}
+		openShift = &is
+	}
+	return *openShift
+}
+
+var managerIPMu sync.Mutex
+var managerIP string
+
+// inClusterManagerIP returns the cluster IP of the traffic-manager service, or an empty string if
+// it can't be found. Only a successful lookup is cached.
+func inClusterManagerIP(ctx context.Context) string {
+	managerIPMu.Lock()
+	defer managerIPMu.Unlock()
+	if managerIP == "" {
+		svc := &kates.Service{
+			TypeMeta:   kates.TypeMeta{Kind: "Service"},
+			ObjectMeta: kates.ObjectMeta{Name: install.ManagerAppName, Namespace: managerutil.GetEnv(ctx).ManagerNamespace},
+		}
+		if err := managerutil.GetKatesClient(ctx).Get(ctx, svc, svc); err != nil {
+			dlog.Errorf(ctx, "unable to get the %s service: %v", install.ManagerAppName, err)
+			return ""
+		}
+		if svc.Spec.ClusterIP != "None" {
+			managerIP = svc.Spec.ClusterIP
+		}
+	}
+	return managerIP
+}
+
+// nodesTTL is how long the list of the nodes of the cluster is cached
+const nodesTTL = time.Minute
+
+var nodesMu sync.Mutex
+var nodes []corev1.Node
+var nodesExpire time.Time
+
+// inClusterNodes returns the nodes of the cluster, or nil if they can't be listed, e.g. because the
+// traffic-manager is namespaced. The list is cached for the nodesTTL, so that the injection of many
+// pods doesn't list the nodes for each one of them.
+func inClusterNodes(ctx context.Context) []corev1.Node {
+	client := managerutil.GetKatesClient(ctx)
+	if client == nil {
+		return nil
+	}
+	nodesMu.Lock()
+	defer nodesMu.Unlock()
+	if now := time.Now(); now.After(nodesExpire) {
+		var ns []corev1.Node
+		if err := client.List(ctx, kates.Query{Kind: "Node"}, &ns); err != nil {
+			dlog.Debugf(ctx, "unable to list the nodes: %v", err)
+			ns = nil
+		}
+		nodes, nodesExpire = ns, now.Add(nodesTTL)
+	}
+	return nodes
+}
+
+// inClusterPodWorkload returns the workload that the given pod belongs to, or nil if the pod isn't
+// controlled by a workload that can be intercepted. The annotations of the pod template of such a
+// workload are copied to the pod, but the annotations of the workload itself are not.
+func inClusterPodWorkload(ctx context.Context, pod *corev1.Pod, namespace string) (*kates.Unstructured, error) {
+	client := managerutil.GetKatesClient(ctx)
+	if client == nil {
+		return nil, nil
+	}
+	var kind, name string
+	if ksvcName, ok := pod.Labels[install.KnativeServiceLabel]; ok {
+		kind, name = "KnativeService", ksvcName
+	} else if owner := metav1.GetControllerOf(pod); owner != nil {
+		switch owner.Kind {
+		case "ReplicaSet", "StatefulSet", "ReplicationController":
+			kind, name = owner.Kind, owner.Name
+		default:
+			return nil, nil
+		}
+	} else {
+		return nil, nil
+	}
+	return managerutil.FindWorkload(ctx, client, namespace, kind, name)
+}
+
+func agentInjector(ctx context.Context, req *admission.AdmissionRequest) (patches []patchOperation, err error) {
 	// This handler should only get called on Pod objects as per the MutatingWebhookConfiguration in the YAML file.
 	// Pod objects are immutable, hence we only care about the CREATE event.
 	// Applying patches to Pods instead of Deployments means we don't have side effects on
@@ -60,10 +170,44 @@ func agentInjector(ctx context.Context, req *admission.AdmissionRequest) ([]patc
 		return nil, nil
 	}
 
-	if pod.Annotations[install.InjectAnnotation] != "enabled" {
-		dlog.Infof(ctx, `The %s pod has not enabled %s container injection through %q annotation; skipping`,
+	if pod.Annotations[install.InterceptAnnotation] == install.InterceptForbidden {
+		dlog.Infof(ctx, `The %s pod has forbidden intercepts through %q annotation; skipping`,
+			refPodName, install.InterceptAnnotation)
+		return nil, nil
+	}
+
+	env := managerutil.GetEnv(ctx)
+	switch pod.Annotations[install.InjectAnnotation] {
+	case "enabled":
+	case "disabled":
+		dlog.Infof(ctx, `The %s pod has disabled %s container injection through %q annotation; skipping`,
 			refPodName, install.AgentContainerName, install.InjectAnnotation)
 		return nil, nil
+	default:
+		if env.AgentInjectPolicy != managerutil.InjectPolicyOptOut {
+			dlog.Infof(ctx, `The %s pod has not enabled %s container injection through %q annotation; skipping`,
+				refPodName, install.AgentContainerName, install.InjectAnnotation)
+			return nil, nil
+		}
+		if podNamespace == env.ManagerNamespace {
+			return nil, nil
+		}
+		for _, ns := range env.AgentInjectExcludedNamespaces {
+			if podNamespace == ns {
+				dlog.Debugf(ctx, `The %s pod is in the excluded namespace %s and has not enabled %s container injection; skipping`,
+					refPodName, ns, install.AgentContainerName)
+				return nil, nil
+			}
+		}
+
+		// A pod that didn't ask for an agent is admitted without one when the agent can't be
+		// injected, instead of being denied.
+		defer func() {
+			if err != nil {
+				dlog.Infof(ctx, "Not injecting %s into the %s pod: %v", install.AgentContainerName, refPodName, err)
+				patches, err = nil, nil
+			}
+		}()
 	}
 
 	for _, container := range pod.Spec.Containers {
@@ -73,6 +217,25 @@ func agentInjector(ctx context.Context, req *admission.AdmissionRequest) ([]patc
 		}
 	}
 
+	// A workload may forbid intercepts in its own annotations, which aren't copied to its pods. The
+	// agent isn't injected unless it's known that the workload doesn't.
+	wl, err := podWorkload(ctx, &pod, podNamespace)
+	if err != nil && !kates.IsNotFound(err) {
+		dlog.Errorf(ctx, "Not injecting %s into the %s pod: unable to check if its workload may be intercepted: %v",
+			install.AgentContainerName, refPodName, err)
+		return nil, nil
+	}
+	if wl != nil && install.InterceptsForbidden(wl) {
+		dlog.Infof(ctx, `The workload of the %s pod has forbidden intercepts through %q annotation; skipping`,
+			refPodName, install.InterceptAnnotation)
+		return nil, nil
+	}
+
+	overrides, err := install.GetAgentOverrides(pod.Annotations)
+	if err != nil {
+		return nil, fmt.Errorf("unable to intercept pod %s: %w", refPodName, err)
+	}
+
 	svc, err := findMatchingService(ctx, managerutil.GetKatesClient(ctx), "", "", podNamespace, pod.Labels)
 	if err != nil {
 		dlog.Error(ctx, err)
@@ -87,40 +250,122 @@ func agentInjector(ctx context.Context, req *admission.AdmissionRequest) ([]patc
 		return nil, nil
 	}
 
-	env := managerutil.GetEnv(ctx)
+	agentPort := env.AgentPort
+	if overrides.Port != 0 {
+		agentPort = overrides.Port
+	}
 	ports := appContainer.Ports
 	for i := range ports {
-		if ports[i].ContainerPort == env.AgentPort {
+		if ports[i].ContainerPort == agentPort {
 			dlog.Infof(ctx, "the %s pod container is exposing the same port (%d) as the %s sidecar; skipping",
-				refPodName, env.AgentPort, install.AgentContainerName)
+				refPodName, agentPort, install.AgentContainerName)
 			return nil, nil
 		}
 	}
 
+	if err = install.CheckAgentPlatform(&pod.Spec, env.AgentArchitectures(), clusterNodes(ctx)); err != nil {
+		return nil, fmt.Errorf("unable to intercept pod %s: %w", refPodName, err)
+	}
+
 	if svc.Spec.ClusterIP == "None" {
 		return nil, fmt.Errorf("intercepts of headless service: %s.%s won't work "+
 			"see https://github.com/telepresenceio/telepresence/issues/1632",
 			svc.Name, svc.Namespace)
 	}
 
-	if servicePort.TargetPort.Type == intstr.Int {
-		return nil, fmt.Errorf("intercepts of service %s.%s won't work because it has an integer targetPort",
-			svc.Name, svc.Namespace)
+	// A numeric targetPort can't be taken over by renaming the container port. The agent port is
+	// given the install.NumericPortName instead, and the connector makes the service refer to it
+	// when the workload is intercepted.
+	numericTargetPort := servicePort.TargetPort.Type == intstr.Int
+
+	var appPort corev1.ContainerPort
+	if containerPortIndex >= 0 {
+		appPort = appContainer.Ports[containerPortIndex]
+	} else {
+		// The container doesn't declare the port, so it's inferred from the service
+		appPort.ContainerPort = servicePort.TargetPort.IntVal
+		if appPort.ContainerPort == 0 {
+			appPort.ContainerPort = servicePort.Port
+		}
+		appPort.Protocol = servicePort.Protocol
+		if appPort.ContainerPort == agentPort {
+			dlog.Infof(ctx, "the %s pod container is using the same port (%d) as the %s sidecar; skipping",
+				refPodName, agentPort, install.AgentContainerName)
+			return nil, nil
+		}
 	}
 
-	appPort := appContainer.Ports[containerPortIndex]
+	// The private service of a Knative revision targets the port of the queue-proxy, which
+	// forwards to the port of the user container that its USER_PORT names. The agent intercepts
+	// that port instead, and the queue-proxy is made to forward to the agent.
+	envContainer := appContainer
+	userPortPath := ""
+	if _, ok := pod.Labels[install.KnativeServiceLabel]; ok && appContainer.Name == install.KnativeQueueProxyName {
+		if envContainer, appPort, userPortPath, err = knativeUserPort(&pod); err != nil {
+			return nil, fmt.Errorf("unable to intercept pod %s: %w", refPodName, err)
+		}
+		if appPort.ContainerPort == agentPort {
+			dlog.Infof(ctx, "the %s pod container is using the same port (%d) as the %s sidecar; skipping",
+				refPodName, agentPort, install.AgentContainerName)
+			return nil, nil
+		}
+	}
 
 	// Create patch operations to add the traffic-agent sidecar
-	var patches []patchOperation
-	patches, err = addAgentContainer(ctx, svc, servicePort, appContainer, &appPort, podName, podNamespace, patches)
+	patches, err = addAgentContainer(ctx, &pod, svc, servicePort, envContainer, &appPort, overrides, podName, podNamespace, patches)
 	if err != nil {
 		return nil, err
 	}
-	patches = hidePorts(&pod, appContainer, servicePort.TargetPort.StrVal, patches)
+	if userPortPath != "" {
+		patches = append(patches, patchOperation{
+			Op:    "replace",
+			Path:  userPortPath,
+			Value: strconv.Itoa(int(agentPort)),
+		})
+	} else if !numericTargetPort {
+		patches = hidePorts(&pod, appContainer, servicePort.TargetPort.StrVal, patches)
+	}
 	patches = addAgentVolume(patches)
+
+	// The event is recorded on the pod's controller
+	if owner := metav1.GetControllerOf(&pod); owner != nil {
+		events.Emit(ctx, podNamespace, owner.Kind, owner.Name, events.AgentInjected,
+			"Injected %s into pod %s, routing the traffic of service %s through it", install.AgentContainerName, podName, svc.Name)
+	}
 	return patches, nil
 }
 
+// knativeUserPort returns the user container of the given Knative pod, the port that its
+// queue-proxy forwards to, and the path of the value of the USER_PORT of the queue-proxy.
+func knativeUserPort(pod *corev1.Pod) (*corev1.Container, corev1.ContainerPort, string, error) {
+	var userContainer *corev1.Container
+	path := ""
+	port := corev1.ContainerPort{Protocol: corev1.ProtocolTCP}
+	for i := range pod.Spec.Containers {
+		cn := &pod.Spec.Containers[i]
+		if cn.Name != install.KnativeQueueProxyName {
+			if userContainer == nil {
+				userContainer = cn
+			}
+			continue
+		}
+		for j, e := range cn.Env {
+			if e.Name == "USER_PORT" {
+				n, err := strconv.Atoi(e.Value)
+				if err != nil {
+					return nil, port, "", fmt.Errorf("invalid USER_PORT %q of the %s", e.Value, install.KnativeQueueProxyName)
+				}
+				port.ContainerPort = int32(n)
+				path = fmt.Sprintf("/spec/containers/%d/env/%d/value", i, j)
+			}
+		}
+	}
+	if userContainer == nil || path == "" {
+		return nil, port, "", fmt.Errorf("the %s has no USER_PORT or there's no user container", install.KnativeQueueProxyName)
+	}
+	return userContainer, port, path, nil
+}
+
 func addAgentVolume(patches []patchOperation) []patchOperation {
 	return append(patches, patchOperation{
 		Op:    "add",
@@ -132,10 +377,12 @@ func addAgentVolume(patches []patchOperation) []patchOperation {
 // addAgentContainer creates a patch operation to add the traffic-agent container
 func addAgentContainer(
 	ctx context.Context,
+	pod *corev1.Pod,
 	svc *corev1.Service,
 	svcPort *corev1.ServicePort,
 	appContainer *corev1.Container,
 	appPort *corev1.ContainerPort,
+	overrides *install.AgentOverrides,
 	podName, namespace string,
 	patches []patchOperation) ([]patchOperation, error) {
 	env := managerutil.GetEnv(ctx)
@@ -156,25 +403,55 @@ func addAgentContainer(
 		tokens := strings.Split(podName, "-")
 		agentName = strings.Join(tokens[:len(tokens)-2], "-")
 	}
+	if ksvcName, ok := pod.Labels[install.KnativeServiceLabel]; ok {
+		// The pods of all revisions of a Knative Service share one agent name, so that the
+		// intercepts of the Knative Service survive its revision rollovers.
+		agentName = ksvcName
+	}
 
 	proto := svcPort.Protocol
 	if proto == "" {
 		proto = appPort.Protocol
 	}
+	portName := svcPort.TargetPort.StrVal
+	if svcPort.TargetPort.Type == intstr.Int {
+		portName = install.NumericPortName(appPort.ContainerPort)
+	}
+	agentImage := install.AgentImageForPod(&pod.Spec, env.AgentImage, env.AgentImages, clusterNodes(ctx))
+	agentContainer := install.AgentContainer(
+		agentName,
+		agentImage,
+		overrides.AppContainer(appContainer),
+		corev1.ContainerPort{
+			Name:          portName,
+			Protocol:      proto,
+			ContainerPort: env.AgentPort,
+		},
+		int(appPort.ContainerPort),
+		env.ManagerNamespace)
+	install.SetAgentAppProtocol(&agentContainer, svcPort)
+	overrides.Apply(&agentContainer)
+
+	managerIP := ""
+	if !install.ResolvesClusterNames(&pod.Spec) {
+		managerIP = managerClusterIP(ctx)
+	}
+	if err := install.AdaptAgentToPodNetwork(&agentContainer, agentName, pod.Annotations, &pod.Spec, managerIP); err != nil {
+		return nil, fmt.Errorf("unable to intercept pod %s: %w", refPodName, err)
+	}
+	if env.AgentMountsReadOnly {
+		install.MakeAgentMountsReadOnly(&agentContainer)
+	}
+	agentContainer.Env = append(agentContainer.Env, env.AgentGRPCEnv()...)
+	if isOpenShift(ctx) {
+		// The restricted SCCs reject pods unless the agent has a compatible security context
+		agentContainer.SecurityContext = install.RestrictedSecurityContext()
+	}
 	patches = append(patches, patchOperation{
-		Op:   "add",
-		Path: "/spec/containers/-",
-		Value: install.AgentContainer(
-			agentName,
-			env.AgentImage,
-			appContainer,
-			corev1.ContainerPort{
-				Name:          svcPort.TargetPort.StrVal,
-				Protocol:      proto,
-				ContainerPort: env.AgentPort,
-			},
-			int(appPort.ContainerPort),
-			env.ManagerNamespace)})
+		Op:    "add",
+		Path:  "/spec/containers/-",
+		Value: agentContainer,
+	})
 
 	return patches, nil
 }
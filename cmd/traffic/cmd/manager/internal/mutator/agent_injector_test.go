@@ -3,15 +3,22 @@ package mutator
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	admission "k8s.io/api/admission/v1"
 	corev1 "k8s.io/api/core/v1"
+	errors2 "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/rest"
 
 	"github.com/datawire/ambassador/pkg/kates"
 	"github.com/datawire/dlib/dlog"
@@ -49,10 +56,13 @@ func findMatchingServiceForTest(c context.Context, client *kates.Client, portNam
 
 func TestTrafficAgentInjector(t *testing.T) {
 	fms := findMatchingService
+	ios := isOpenShift
 	defer func() {
 		findMatchingService = fms
+		isOpenShift = ios
 	}()
 	findMatchingService = findMatchingServiceForTest
+	isOpenShift = func(context.Context) bool { return false }
 
 	env := &managerutil.Env{
 		User:        "",
@@ -190,6 +200,32 @@ func TestTrafficAgentInjector(t *testing.T) {
 			"",
 			"",
 		},
+		{
+			"Error Precondition: Windows node",
+			toAdmissionRequest(podResource, corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: map[string]string{
+						install.InjectAnnotation: "enabled",
+					},
+					Labels: map[string]string{
+						"service": "some-name",
+					},
+					Namespace: "some-ns",
+					Name:      "some-name"},
+				Spec: corev1.PodSpec{
+					NodeSelector: map[string]string{install.NodeOSLabel: "windows"},
+					Containers: []corev1.Container{{
+						Name:  "some-app-name",
+						Image: "some-app-image",
+						Ports: []corev1.ContainerPort{{
+							Name: "http", ContainerPort: 8888},
+						}},
+					},
+				},
+			}),
+			"",
+			"the pod can only run on windows nodes",
+		},
 		{
 			"Apply Patch: Named port",
 			toAdmissionRequest(podResource, corev1.Pod{
@@ -312,6 +348,346 @@ func TestTrafficAgentInjector(t *testing.T) {
 	}
 }
 
+func TestTrafficAgentInjectorNumericTargetPort(t *testing.T) {
+	fms := findMatchingService
+	ios := isOpenShift
+	defer func() {
+		findMatchingService = fms
+		isOpenShift = ios
+	}()
+	targetPort := intstr.FromInt(8080)
+	findMatchingService = func(c context.Context, client *kates.Client, portNameOrNumber, svcName, namespace string, labels map[string]string) (*kates.Service, error) {
+		svc, err := findMatchingServiceForTest(c, client, portNameOrNumber, svcName, namespace, labels)
+		if err == nil {
+			svc.Spec.Ports[0].TargetPort = targetPort
+		}
+		return svc, err
+	}
+	isOpenShift = func(context.Context) bool { return true }
+
+	ctx := dlog.NewTestContext(t, false)
+	ctx = managerutil.WithEnv(ctx, &managerutil.Env{
+		ManagerNamespace: "default",
+		AgentImage:       "docker.io/datawire/tel2:2.3.1",
+		AgentPort:        9900,
+	})
+
+	// The container declares no ports, so the app port is inferred from the service
+	pod := corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{install.InjectAnnotation: "enabled"},
+			Labels:      map[string]string{"service": "some-name"},
+			Namespace:   "some-ns",
+			Name:        "some-name",
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{Name: "some-app-name", Image: "some-app-image"}},
+		},
+	}
+	check := func() {
+		t.Helper()
+		patches, err := agentInjector(ctx, toAdmissionRequest(podResource, pod))
+		require.NoError(t, err)
+		paths := make([]string, len(patches))
+		for i, p := range patches {
+			paths[i] = p.Path
+		}
+		// Nothing but the agent is added, so the pod needs no privileges, not even on OpenShift
+		assert.Equal(t, []string{"/spec/containers/-", "/spec/volumes/-"}, paths)
+
+		agent := patches[0].Value.(corev1.Container)
+		assert.Equal(t, corev1.ContainerPort{Name: "tx-8080", Protocol: corev1.ProtocolTCP, ContainerPort: 9900}, agent.Ports[0])
+		assert.Contains(t, agent.Env, corev1.EnvVar{Name: "APP_PORT", Value: "8080"})
+	}
+	check()
+
+	// Once the connector made the service refer to the agent port, the pods of a new rollout
+	// get the same agent
+	targetPort = intstr.FromString("tx-8080")
+	check()
+}
+
+func TestTrafficAgentInjectorOptOut(t *testing.T) {
+	fms := findMatchingService
+	ios := isOpenShift
+	defer func() {
+		findMatchingService = fms
+		isOpenShift = ios
+	}()
+	findMatchingService = findMatchingServiceForTest
+	isOpenShift = func(context.Context) bool { return false }
+
+	ctx := dlog.NewTestContext(t, false)
+	ctx = managerutil.WithEnv(ctx, &managerutil.Env{
+		ManagerNamespace:              "default",
+		AgentImage:                    "docker.io/datawire/tel2:2.3.1",
+		AgentPort:                     9900,
+		AgentInjectPolicy:             managerutil.InjectPolicyOptOut,
+		AgentInjectExcludedNamespaces: []string{"kube-system"},
+	})
+
+	pod := corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Labels:    map[string]string{"service": "some-name"},
+			Namespace: "some-ns",
+			Name:      "some-name",
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{
+				Name:  "some-app-name",
+				Image: "some-app-image",
+				Ports: []corev1.ContainerPort{{Name: "http", ContainerPort: 8888}},
+			}},
+		},
+	}
+
+	// A pod without the annotation gets the agent
+	patches, err := agentInjector(ctx, toAdmissionRequest(podResource, pod))
+	require.NoError(t, err)
+	assert.NotEmpty(t, patches)
+
+	// A pod that the agent can't be injected into is admitted without it
+	pod.Spec.NodeSelector = map[string]string{install.NodeOSLabel: "windows"}
+	patches, err = agentInjector(ctx, toAdmissionRequest(podResource, pod))
+	require.NoError(t, err)
+	assert.Empty(t, patches)
+
+	// Unless it asked for it
+	pod.Annotations = map[string]string{install.InjectAnnotation: "enabled"}
+	_, err = agentInjector(ctx, toAdmissionRequest(podResource, pod))
+	assertContains(t, err, "the pod can only run on windows nodes")
+
+	// A pod can opt out
+	pod.Spec.NodeSelector = nil
+	pod.Annotations = map[string]string{install.InjectAnnotation: "disabled"}
+	patches, err = agentInjector(ctx, toAdmissionRequest(podResource, pod))
+	require.NoError(t, err)
+	assert.Empty(t, patches)
+
+	// A pod that forbids intercepts doesn't get the agent, even when it asks for it
+	pod.Annotations = map[string]string{install.InjectAnnotation: "enabled", install.InterceptAnnotation: install.InterceptForbidden}
+	patches, err = agentInjector(ctx, toAdmissionRequest(podResource, pod))
+	require.NoError(t, err)
+	assert.Empty(t, patches)
+
+	// The pods of the traffic-manager's namespace don't get the agent
+	pod.Annotations = nil
+	pod.Namespace = "default"
+	patches, err = agentInjector(ctx, toAdmissionRequest(podResource, pod))
+	require.NoError(t, err)
+	assert.Empty(t, patches)
+
+	// Nor do the pods of the excluded namespaces, unless they ask for it
+	pod.Namespace = "kube-system"
+	patches, err = agentInjector(ctx, toAdmissionRequest(podResource, pod))
+	require.NoError(t, err)
+	assert.Empty(t, patches)
+	pod.Annotations = map[string]string{install.InjectAnnotation: "enabled"}
+	patches, err = agentInjector(ctx, toAdmissionRequest(podResource, pod))
+	require.NoError(t, err)
+	assert.NotEmpty(t, patches)
+}
+
+func TestTrafficAgentInjectorOverrides(t *testing.T) {
+	fms := findMatchingService
+	ios := isOpenShift
+	defer func() {
+		findMatchingService = fms
+		isOpenShift = ios
+	}()
+	findMatchingService = findMatchingServiceForTest
+	isOpenShift = func(context.Context) bool { return false }
+
+	ctx := dlog.NewTestContext(t, false)
+	ctx = managerutil.WithEnv(ctx, &managerutil.Env{
+		ManagerNamespace: "default",
+		AgentImage:       "docker.io/datawire/tel2:2.3.1",
+		AgentPort:        9900,
+	})
+
+	// The app uses the default port of the agent, so the agent is told to use another one
+	pod := corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{
+				install.InjectAnnotation:        "enabled",
+				install.AgentPortAnnotation:     "9901",
+				install.AgentLogLevelAnnotation: "info",
+			},
+			Labels:    map[string]string{"service": "some-name"},
+			Namespace: "some-ns",
+			Name:      "some-name",
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{
+				Name:  "some-app-name",
+				Image: "some-app-image",
+				Ports: []corev1.ContainerPort{{Name: "http", ContainerPort: 8888}, {Name: "metrics", ContainerPort: 9900}},
+			}},
+		},
+	}
+	patches, err := agentInjector(ctx, toAdmissionRequest(podResource, pod))
+	require.NoError(t, err)
+	require.NotEmpty(t, patches)
+	agent := patches[0].Value.(corev1.Container)
+	assert.Equal(t, int32(9901), agent.Ports[0].ContainerPort)
+	assert.Contains(t, agent.Env, corev1.EnvVar{Name: "AGENT_PORT", Value: "9901"})
+	assert.Contains(t, agent.Env, corev1.EnvVar{Name: "LOG_LEVEL", Value: "info"})
+
+	pod.Annotations[install.AgentPortAnnotation] = "http"
+	_, err = agentInjector(ctx, toAdmissionRequest(podResource, pod))
+	assertContains(t, err, "is not a valid port number")
+}
+
+func TestTrafficAgentInjectorKnative(t *testing.T) {
+	fms := findMatchingService
+	ios := isOpenShift
+	defer func() {
+		findMatchingService = fms
+		isOpenShift = ios
+	}()
+	findMatchingService = func(c context.Context, client *kates.Client, portNameOrNumber, svcName, namespace string, labels map[string]string) (*kates.Service, error) {
+		svc, err := findMatchingServiceForTest(c, client, portNameOrNumber, svcName, namespace, labels)
+		if err == nil {
+			// The private service of a revision targets the port of the queue-proxy
+			svc.Name = "hello-00001-private"
+			svc.Spec.Ports[0].Name = "http"
+			svc.Spec.Ports[0].TargetPort = intstr.FromInt(8012)
+		}
+		return svc, err
+	}
+	isOpenShift = func(context.Context) bool { return false }
+
+	ctx := dlog.NewTestContext(t, false)
+	ctx = managerutil.WithEnv(ctx, &managerutil.Env{
+		ManagerNamespace: "default",
+		AgentImage:       "docker.io/datawire/tel2:2.3.1",
+		AgentPort:        9900,
+	})
+
+	pod := corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{
+				install.InjectAnnotation:      "enabled",
+				install.ServicePortAnnotation: "http",
+			},
+			Labels: map[string]string{
+				install.KnativeServiceLabel:    "hello",
+				"serving.knative.dev/revision": "hello-00001",
+			},
+			Namespace:    "some-ns",
+			GenerateName: "hello-00001-deployment-6f7b9c6b7d-",
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{
+					Name:  "user-container",
+					Image: "hello",
+					Ports: []corev1.ContainerPort{{Name: "user-port", ContainerPort: 8080}},
+					Env:   []corev1.EnvVar{{Name: "TARGET", Value: "World"}},
+				},
+				{
+					Name:  install.KnativeQueueProxyName,
+					Image: "queue",
+					Ports: []corev1.ContainerPort{{Name: "queue-port", ContainerPort: 8012}},
+					Env:   []corev1.EnvVar{{Name: "SERVING_NAMESPACE", Value: "some-ns"}, {Name: "USER_PORT", Value: "8080"}},
+				},
+			},
+		},
+	}
+	patches, err := agentInjector(ctx, toAdmissionRequest(podResource, pod))
+	require.NoError(t, err)
+	require.NotEmpty(t, patches)
+	agent := patches[0].Value.(corev1.Container)
+
+	// The agent is named after the Knative Service, intercepts the port of the user container, and
+	// has its environment. The queue-proxy forwards to the agent instead.
+	assert.Contains(t, agent.Env, corev1.EnvVar{Name: "AGENT_NAME", Value: "hello"})
+	assert.Contains(t, agent.Env, corev1.EnvVar{Name: "APP_PORT", Value: "8080"})
+	assert.Contains(t, agent.Env, corev1.EnvVar{Name: "TEL_APP_TARGET", Value: "World"})
+	assert.Contains(t, agent.Env, corev1.EnvVar{Name: "TELEPRESENCE_CONTAINER", Value: "user-container"})
+	assert.Equal(t, patchOperation{Op: "replace", Path: "/spec/containers/1/env/1/value", Value: "9900"}, patches[1])
+
+	pod.Spec.Containers[1].Env = nil
+	_, err = agentInjector(ctx, toAdmissionRequest(podResource, pod))
+	assertContains(t, err, "has no USER_PORT")
+}
+
+func TestTrafficAgentInjectorForbiddenWorkload(t *testing.T) {
+	fms := findMatchingService
+	ios := isOpenShift
+	pwl := podWorkload
+	defer func() {
+		findMatchingService = fms
+		isOpenShift = ios
+		podWorkload = pwl
+	}()
+	findMatchingService = findMatchingServiceForTest
+	isOpenShift = func(context.Context) bool { return false }
+
+	ctx := dlog.NewTestContext(t, false)
+	ctx = managerutil.WithEnv(ctx, &managerutil.Env{
+		ManagerNamespace:  "default",
+		AgentImage:        "docker.io/datawire/tel2:2.3.1",
+		AgentPort:         9900,
+		AgentInjectPolicy: managerutil.InjectPolicyOptOut,
+	})
+
+	pod := corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Labels:       map[string]string{"service": "some-name"},
+			Namespace:    "some-ns",
+			GenerateName: "some-name-6f7b9c6b7d-",
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{
+				Name:  "some-app-name",
+				Image: "some-app-image",
+				Ports: []corev1.ContainerPort{{Name: "http", ContainerPort: 8888}},
+			}},
+		},
+	}
+	wl := &kates.Unstructured{}
+	wl.SetKind("Deployment")
+	wl.SetNamespace("some-ns")
+	wl.SetName("some-name")
+	var wlErr error
+	podWorkload = func(context.Context, *corev1.Pod, string) (*kates.Unstructured, error) {
+		if wlErr != nil {
+			return nil, wlErr
+		}
+		return wl, nil
+	}
+
+	patches, err := agentInjector(ctx, toAdmissionRequest(podResource, pod))
+	require.NoError(t, err)
+	assert.NotEmpty(t, patches)
+
+	// The annotation of the workload itself isn't copied to the pod
+	wl.SetAnnotations(map[string]string{install.InterceptAnnotation: install.InterceptForbidden})
+	patches, err = agentInjector(ctx, toAdmissionRequest(podResource, pod))
+	require.NoError(t, err)
+	assert.Empty(t, patches)
+
+	// Nor does a pod that asks for the agent get it
+	pod.Annotations = map[string]string{install.InjectAnnotation: "enabled"}
+	patches, err = agentInjector(ctx, toAdmissionRequest(podResource, pod))
+	require.NoError(t, err)
+	assert.Empty(t, patches)
+
+	// A workload that can't be read doesn't get the agent
+	wl.SetAnnotations(nil)
+	wlErr = errors.New(`deployments.apps "some-name" is forbidden`)
+	patches, err = agentInjector(ctx, toAdmissionRequest(podResource, pod))
+	require.NoError(t, err)
+	assert.Empty(t, patches)
+
+	// But a pod whose workload is gone does
+	wlErr = errors2.NewNotFound(schema.GroupResource{Group: "apps", Resource: "deployments"}, "some-name")
+	patches, err = agentInjector(ctx, toAdmissionRequest(podResource, pod))
+	require.NoError(t, err)
+	assert.NotEmpty(t, patches)
+}
+
 func assertContains(t *testing.T, err error, expected string) {
 	if expected == "" {
 		assert.NoError(t, err)
@@ -332,3 +708,31 @@ func toAdmissionRequest(resource metav1.GroupVersionResource, object interface{}
 		Namespace: "default",
 	}
 }
+
+func TestInClusterIsOpenShift(t *testing.T) {
+	// The API server fails the first check, and serves the OpenShift API after that
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(&metav1.APIResourceList{GroupVersion: install.DeploymentConfigAPIVersion})
+	}))
+	defer srv.Close()
+
+	icc := inClusterConfig
+	defer func() {
+		inClusterConfig = icc
+		openShift = nil
+	}()
+	inClusterConfig = func() (*rest.Config, error) { return &rest.Config{Host: srv.URL}, nil }
+	openShift = nil
+
+	ctx := dlog.NewTestContext(t, false)
+	assert.False(t, inClusterIsOpenShift(ctx))
+	assert.True(t, inClusterIsOpenShift(ctx), "a failed check is made again")
+	assert.True(t, inClusterIsOpenShift(ctx))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&requests), "a successful check is cached")
+}
@@ -2,6 +2,7 @@ package mutator
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -19,6 +20,7 @@ import (
 
 	"github.com/datawire/dlib/dhttp"
 	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/managerutil"
 	"github.com/telepresenceio/telepresence/v2/pkg/install"
 )
 
@@ -79,10 +81,16 @@ func ServeMutator(ctx context.Context) error {
 		w.WriteHeader(http.StatusOK)
 	})
 
-	server := &dhttp.ServerConfig{Handler: mux}
+	policy, err := managerutil.GetEnv(ctx).TLSPolicy()
+	if err != nil {
+		return err
+	}
+	tlsConfig := &tls.Config{}
+	policy.Apply(tlsConfig)
+	server := &dhttp.ServerConfig{Handler: mux, TLSConfig: tlsConfig}
 	addr := ":" + strconv.Itoa(install.MutatorWebhookPortHTTPS)
 	dlog.Infof(ctx, "Mutating webhook service is listening on %v", addr)
-	err := server.ListenAndServeTLS(ctx, addr, certPath, keyPath)
+	err = server.ListenAndServeTLS(ctx, addr, certPath, keyPath)
 	if err != nil {
 		err = fmt.Errorf("mutating webhook service stopped. %w", err)
 		return err
@@ -0,0 +1,219 @@
+// Package policy implements the intercept authorization policy of the traffic-manager.
+//
+// A policy is a list of rules. Each rule selects namespaces and workloads using glob patterns and
+// lists the users and groups that are allowed to intercept them. A workload that isn't selected by
+// any rule can be intercepted by anyone, so a policy only needs to mention what it protects. A
+// workload that is selected by one or more rules can only be intercepted by a user that matches at
+// least one of them.
+//
+// A policy can also list admins. An admin may take over a workload that another client is
+// intercepting.
+package policy
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"sigs.k8s.io/yaml"
+
+	"github.com/datawire/dlib/dlog"
+)
+
+type Rule struct {
+	// Namespaces are glob patterns matching namespace names. An empty list matches all namespaces.
+	Namespaces []string `json:"namespaces,omitempty"`
+
+	// Workloads are glob patterns matching workload names. An empty list matches all workloads.
+	Workloads []string `json:"workloads,omitempty"`
+
+	// Users are the Kubernetes user names that are allowed to intercept what this rule selects.
+	Users []string `json:"users,omitempty"`
+
+	// Groups are the Kubernetes groups that are allowed to intercept what this rule selects.
+	Groups []string `json:"groups,omitempty"`
+}
+
+// Admins are the users and groups that may override the intercepts of other clients.
+type Admins struct {
+	Users  []string `json:"users,omitempty"`
+	Groups []string `json:"groups,omitempty"`
+}
+
+type Policy struct {
+	Rules  []Rule  `json:"rules,omitempty"`
+	Admins *Admins `json:"admins,omitempty"`
+}
+
+// File is the policy of a file. The file is read again when it changes, so that the policy can be
+// changed without a restart of the traffic-manager, e.g. by updating the ConfigMap that the file is
+// mounted from.
+type File struct {
+	file string
+
+	mu      sync.Mutex
+	modTime time.Time
+	policy  *Policy
+}
+
+// NewFile returns the policy of the given file, or an error if the file can't be loaded.
+func NewFile(file string) (*File, error) {
+	f := &File{file: file}
+	if err := f.reload(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Policy returns the current policy of the file, which is nil when the file doesn't exist. A policy
+// that fails to load is logged, and the policy that was read last stays in effect. The policy of a
+// nil File is nil.
+func (f *File) Policy(ctx context.Context) *Policy {
+	if f == nil {
+		return nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.reload(); err != nil {
+		dlog.Errorf(ctx, "keeping the intercept policy that was loaded last: %v", err)
+	}
+	return f.policy
+}
+
+// reload loads the file if it has changed since it was loaded last.
+func (f *File) reload() error {
+	var modTime time.Time
+	if st, err := os.Stat(f.file); err == nil {
+		modTime = st.ModTime()
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	if modTime.Equal(f.modTime) && !modTime.IsZero() {
+		return nil
+	}
+	// A file that fails to load isn't loaded again until it changes
+	f.modTime = modTime
+	p, err := Load(f.file)
+	if err != nil {
+		return err
+	}
+	f.policy = p
+	return nil
+}
+
+// Load reads the policy from the given file. A nil policy is returned when the file doesn't exist.
+func Load(file string) (*Policy, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	p := &Policy{}
+	if err = yaml.UnmarshalStrict(data, p); err != nil {
+		return nil, fmt.Errorf("invalid intercept policy in %s: %w", file, err)
+	}
+	for i := range p.Rules {
+		if err = p.Rules[i].validate(); err != nil {
+			return nil, fmt.Errorf("invalid intercept policy in %s, rule %d: %w", file, i, err)
+		}
+	}
+	return p, nil
+}
+
+func (r *Rule) validate() error {
+	for _, ps := range [][]string{r.Namespaces, r.Workloads} {
+		for _, p := range ps {
+			if _, err := path.Match(p, ""); err != nil {
+				return fmt.Errorf("bad pattern %q: %w", p, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Restricted returns true if the policy has at least one rule that selects the given workload. An
+// intercept of a restricted workload requires a known user identity.
+func (p *Policy) Restricted(namespace, workload string) bool {
+	if p == nil {
+		return false
+	}
+	for i := range p.Rules {
+		if p.Rules[i].selects(namespace, workload) {
+			return true
+		}
+	}
+	return false
+}
+
+// Allowed returns true if the given user, with the given groups, is allowed to intercept the workload.
+func (p *Policy) Allowed(namespace, workload, user string, groups []string) bool {
+	if p == nil {
+		return true
+	}
+	restricted := false
+	for i := range p.Rules {
+		r := &p.Rules[i]
+		if !r.selects(namespace, workload) {
+			continue
+		}
+		restricted = true
+		if r.admits(user, groups) {
+			return true
+		}
+	}
+	return !restricted
+}
+
+// IsAdmin returns true if the given user, with the given groups, may override the intercepts of
+// other clients. Nobody is an admin unless the policy says so.
+func (p *Policy) IsAdmin(user string, groups []string) bool {
+	if p == nil || p.Admins == nil {
+		return false
+	}
+	return admits(p.Admins.Users, p.Admins.Groups, user, groups)
+}
+
+func (r *Rule) selects(namespace, workload string) bool {
+	return matchAny(r.Namespaces, namespace) && matchAny(r.Workloads, workload)
+}
+
+func (r *Rule) admits(user string, groups []string) bool {
+	return admits(r.Users, r.Groups, user, groups)
+}
+
+// admits returns true if the given user is one of the users, or has one of the groups.
+func admits(users, groups []string, user string, userGroups []string) bool {
+	if user != "" {
+		for _, u := range users {
+			if u == user {
+				return true
+			}
+		}
+	}
+	for _, rg := range groups {
+		for _, g := range userGroups {
+			if rg == g {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func matchAny(patterns []string, name string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,109 @@
+package policy
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPolicy_Allowed(t *testing.T) {
+	p := &Policy{Rules: []Rule{
+		{Namespaces: []string{"prod-*"}, Users: []string{"alice"}, Groups: []string{"sre"}},
+		{Namespaces: []string{"staging"}, Workloads: []string{"payments*"}, Users: []string{"bob"}},
+	}}
+
+	assert.True(t, p.Allowed("default", "echo", "", nil), "unselected workloads are not restricted")
+	assert.True(t, p.Allowed("prod-eu", "echo", "alice", nil))
+	assert.True(t, p.Allowed("prod-eu", "echo", "carol", []string{"dev", "sre"}))
+	assert.False(t, p.Allowed("prod-eu", "echo", "bob", []string{"dev"}))
+	assert.False(t, p.Allowed("prod-eu", "echo", "", nil))
+	assert.True(t, p.Allowed("staging", "echo", "carol", nil))
+	assert.True(t, p.Allowed("staging", "payments-api", "bob", nil))
+	assert.False(t, p.Allowed("staging", "payments-api", "alice", nil))
+
+	assert.True(t, p.Restricted("prod-us", "x"))
+	assert.False(t, p.Restricted("staging", "x"))
+
+	var none *Policy
+	assert.True(t, none.Allowed("prod-eu", "echo", "", nil))
+	assert.False(t, none.Restricted("prod-eu", "echo"))
+}
+
+func TestPolicy_IsAdmin(t *testing.T) {
+	p := &Policy{Admins: &Admins{Users: []string{"alice"}, Groups: []string{"sre"}}}
+	assert.True(t, p.IsAdmin("alice", nil))
+	assert.True(t, p.IsAdmin("carol", []string{"dev", "sre"}))
+	assert.False(t, p.IsAdmin("bob", []string{"dev"}))
+	assert.False(t, p.IsAdmin("", nil))
+
+	assert.False(t, (&Policy{}).IsAdmin("alice", []string{"sre"}), "nobody is an admin by default")
+	var none *Policy
+	assert.False(t, none.IsAdmin("alice", []string{"sre"}))
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	p, err := Load(filepath.Join(dir, "missing.yaml"))
+	require.NoError(t, err)
+	assert.Nil(t, p)
+
+	file := filepath.Join(dir, "policy.yaml")
+	require.NoError(t, ioutil.WriteFile(file, []byte(`
+rules:
+- namespaces: ["prod-*"]
+  groups: ["sre"]
+admins:
+  users: ["alice"]
+`), 0600))
+	p, err = Load(file)
+	require.NoError(t, err)
+	assert.Equal(t, &Policy{
+		Rules:  []Rule{{Namespaces: []string{"prod-*"}, Groups: []string{"sre"}}},
+		Admins: &Admins{Users: []string{"alice"}},
+	}, p)
+
+	require.NoError(t, ioutil.WriteFile(file, []byte(`
+rules:
+- namespaces: ["prod-["]
+`), 0600))
+	_, err = Load(file)
+	assert.Error(t, err)
+}
+
+func TestFile(t *testing.T) {
+	ctx := context.Background()
+	file := filepath.Join(t.TempDir(), "policy.yaml")
+	f, err := NewFile(file)
+	require.NoError(t, err)
+	assert.Nil(t, f.Policy(ctx), "a file that doesn't exist has no policy")
+
+	write := func(content string, age time.Duration) {
+		require.NoError(t, ioutil.WriteFile(file, []byte(content), 0600))
+		mtime := time.Now().Add(-age)
+		require.NoError(t, os.Chtimes(file, mtime, mtime))
+	}
+	write("admins:\n  users: [alice]\n", 2*time.Hour)
+	assert.True(t, f.Policy(ctx).IsAdmin("alice", nil), "the policy is loaded when the file is created")
+
+	write("admins:\n  users: [bob]\n", time.Hour)
+	assert.True(t, f.Policy(ctx).IsAdmin("bob", nil), "the policy is reloaded when the file changes")
+
+	write("rules: [", 0)
+	assert.True(t, f.Policy(ctx).IsAdmin("bob", nil), "an invalid policy is ignored")
+
+	require.NoError(t, os.Remove(file))
+	assert.Nil(t, f.Policy(ctx), "the policy is gone with the file")
+
+	write("rules: [", 0)
+	_, err = NewFile(file)
+	assert.Error(t, err, "the initial policy must be valid")
+
+	var none *File
+	assert.Nil(t, none.Policy(ctx))
+}
@@ -0,0 +1,112 @@
+package state_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/datawire/dlib/dlog"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/internal/state"
+	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/managerutil"
+	"github.com/telepresenceio/telepresence/v2/pkg/connpool"
+)
+
+// clientTunnel is the server side of the ClientTunnel of a client that never sends anything.
+type clientTunnel struct {
+	grpc.ServerStream
+	ctx  context.Context
+	sent chan *rpc.ConnMessage
+}
+
+func (ct *clientTunnel) Context() context.Context {
+	return ct.ctx
+}
+
+func (ct *clientTunnel) Send(msg *rpc.ConnMessage) error {
+	ct.sent <- msg
+	return nil
+}
+
+func (ct *clientTunnel) Recv() (*rpc.ConnMessage, error) {
+	<-ct.ctx.Done()
+	return nil, io.EOF
+}
+
+// acceptedConn returns the server side of a TCP connection on the loopback interface.
+func acceptedConn(t *testing.T) net.Conn {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	cc, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = cc.Close() })
+	sc, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = sc.Close() })
+	return sc
+}
+
+func TestForwardToClient(t *testing.T) {
+	ctx, cancel := context.WithTimeout(dlog.NewTestContext(t, false), 10*time.Second)
+	defer cancel()
+	a := assertNew(t)
+
+	s := state.NewState(ctx)
+	now := time.Now()
+	alice := s.AddClientWithCallbackPorts(&rpc.ClientInfo{Name: "alice@host"}, now, []int32{8080})
+	bob := s.AddClient(&rpc.ClientInfo{Name: "bob@host"}, now)
+	a.Equal([]int32{8080}, s.GetClientCallbackPorts(alice))
+	a.Empty(s.GetClientCallbackPorts(bob))
+
+	err := s.ForwardToClient(ctx, "nope", acceptedConn(t), 8080)
+	a.Equal(codes.NotFound, status.Code(err))
+	err = s.ForwardToClient(ctx, alice, acceptedConn(t), 8080)
+	a.Equal(codes.Unavailable, status.Code(err), "alice has no tunnel yet")
+
+	ct := &clientTunnel{
+		ctx:  managerutil.WithSessionInfo(ctx, &rpc.SessionInfo{SessionId: alice}),
+		sent: make(chan *rpc.ConnMessage, 10),
+	}
+	go func() {
+		_ = s.ClientTunnel(ct.ctx, ct)
+	}()
+
+	// Only the ports that the client declared are forwarded
+	err = s.ForwardToClient(ctx, alice, acceptedConn(t), 22)
+	a.Equal(codes.PermissionDenied, status.Code(err))
+	err = s.ForwardToClient(ctx, bob, acceptedConn(t), 8080)
+	a.Equal(codes.PermissionDenied, status.Code(err))
+
+	conn := acceptedConn(t)
+	a.Eventually(func() bool {
+		err = s.ForwardToClient(ctx, alice, conn, 8080)
+		return status.Code(err) != codes.Unavailable
+	}, 5*time.Second, 10*time.Millisecond)
+	a.NoError(err)
+
+	// The client is told to connect to the port on its loopback interface
+	select {
+	case msg := <-ct.sent:
+		ctrl, ok := connpool.FromConnMessage(msg).(connpool.Control)
+		if a.True(ok) {
+			a.Equal(connpool.Connect, ctrl.Code())
+			a.Equal(uint16(8080), ctrl.ID().DestinationPort())
+			a.True(ctrl.ID().Destination().IsLoopback())
+		}
+	case <-ctx.Done():
+		t.Fatal("no Connect was sent to the client")
+	}
+}
@@ -0,0 +1,188 @@
+package state
+
+import (
+	"context"
+	"encoding/csv"
+	"sort"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/manager"
+)
+
+// queueTicket is a place in the queue of the clients that wait for a workload to be released. The
+// tickets of a workload form a chain, where each ticket waits for the one before it.
+type queueTicket struct {
+	prev *queueTicket
+	done chan struct{}
+}
+
+// release closes the ticket once the ticket before it is closed, so that a client that gives up
+// its place doesn't let the clients behind it overtake the clients in front of it.
+func (t *queueTicket) release() {
+	if t.prev == nil {
+		close(t.done)
+		return
+	}
+	go func() {
+		<-t.prev.done
+		close(t.done)
+	}()
+}
+
+func workloadKey(spec *rpc.InterceptSpec) string {
+	return spec.Agent + "." + spec.Namespace
+}
+
+// holdsWorkload returns true if the given intercept is one that the agent of its workload has
+// accepted, or may accept.
+func holdsWorkload(cept *rpc.InterceptInfo) bool {
+	switch cept.Disposition {
+	case rpc.InterceptDispositionType_WAITING, rpc.InterceptDispositionType_ACTIVE:
+		return true
+	default:
+		return false
+	}
+}
+
+// exclusive returns true if an intercept with the given spec takes all the traffic of its
+// workload, which is what the tcp mechanism does.
+func exclusive(spec *rpc.InterceptSpec) bool {
+	return spec.Mechanism == "" || spec.Mechanism == "tcp"
+}
+
+// matchers returns the header, cookie, and query matchers of an intercept with the given ID and
+// spec, as given by the --match arguments of its mechanism. An "auto" match selects a header that
+// is unique to the intercept, and an "all" match selects nothing in particular.
+func matchers(id string, spec *rpc.InterceptSpec) map[string]struct{} {
+	ms := make(map[string]struct{})
+	for _, arg := range spec.MechanismArgs {
+		if !strings.HasPrefix(arg, "--") {
+			continue
+		}
+		eq := strings.IndexByte(arg, '=')
+		if eq < 0 {
+			continue
+		}
+		name := strings.TrimPrefix(arg[2:eq], "http-")
+		if name != "match" && !strings.HasPrefix(name, "match-") {
+			continue
+		}
+		values, err := csv.NewReader(strings.NewReader(arg[eq+1:])).Read()
+		if err != nil {
+			values = []string{arg[eq+1:]}
+		}
+		for _, value := range values {
+			switch value {
+			case "", "all":
+			case "auto":
+				ms[name+":auto:"+id] = struct{}{}
+			default:
+				ms[name+":"+value] = struct{}{}
+			}
+		}
+	}
+	return ms
+}
+
+// overlaps returns true if the agent might be asked to send the same request to intercepts with
+// the given specs. That's the case when one of them is exclusive, when they use different
+// mechanisms, or when all the matchers of one of them are matchers of the other, so that every
+// request that the other one selects is selected by it too.
+func overlaps(id string, spec *rpc.InterceptSpec, otherID string, other *rpc.InterceptSpec) bool {
+	if exclusive(spec) || exclusive(other) || spec.Mechanism != other.Mechanism {
+		return true
+	}
+	subset := func(a, b map[string]struct{}) bool {
+		for m := range a {
+			if _, ok := b[m]; !ok {
+				return false
+			}
+		}
+		return true
+	}
+	ms, oms := matchers(id, spec), matchers(otherID, other)
+	return subset(ms, oms) || subset(oms, ms)
+}
+
+// InterceptConflicts returns the intercepts, sorted by ID, that clients other than the one with
+// the given session ID hold on the workload of the given spec, and that overlap with it.
+func (s *State) InterceptConflicts(sessionID string, spec *rpc.InterceptSpec) []*rpc.InterceptInfo {
+	return conflicts(s.intercepts.LoadAll(), sessionID, spec)
+}
+
+func conflicts(cepts map[string]*rpc.InterceptInfo, sessionID string, spec *rpc.InterceptSpec) []*rpc.InterceptInfo {
+	var found []*rpc.InterceptInfo
+	for _, cept := range cepts {
+		if cept.ClientSession.GetSessionId() != sessionID &&
+			cept.Spec.Namespace == spec.Namespace && cept.Spec.Agent == spec.Agent && holdsWorkload(cept) &&
+			overlaps("", spec, cept.Id, cept.Spec) {
+			found = append(found, cept)
+		}
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].Id < found[j].Id })
+	return found
+}
+
+// WaitForRelease queues the client with the given session ID for the workload of the given spec,
+// and blocks until all clients that were queued before it have had their turn and no other client
+// holds the workload. The returned function must be called once the client has created its
+// intercept, or has given up, so that the next client in the queue gets its turn. The intercepts
+// that held the workload when the client was queued are returned too, so that the client can be
+// told who it waited for.
+func (s *State) WaitForRelease(ctx context.Context, sessionID string, spec *rpc.InterceptSpec) (func(), []*rpc.InterceptInfo, error) {
+	key := workloadKey(spec)
+	s.mu.Lock()
+	t := &queueTicket{prev: s.queueTails[key], done: make(chan struct{})}
+	s.queueTails[key] = t
+	s.mu.Unlock()
+
+	release := func() {
+		s.mu.Lock()
+		if s.queueTails[key] == t {
+			delete(s.queueTails, key)
+		}
+		s.mu.Unlock()
+		t.release()
+	}
+
+	holders := s.InterceptConflicts(sessionID, spec)
+	sessionDone := s.SessionDone(sessionID)
+	if t.prev != nil {
+		select {
+		case <-t.prev.done:
+		case <-ctx.Done():
+			release()
+			return nil, nil, ctx.Err()
+		case <-sessionDone:
+			release()
+			return nil, nil, status.Errorf(codes.NotFound, "Client session %q not found", sessionID)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	snapshotCh := s.intercepts.SubscribeSubset(ctx, func(_ string, cept *rpc.InterceptInfo) bool {
+		return cept.Spec.Namespace == spec.Namespace && cept.Spec.Agent == spec.Agent
+	})
+	for {
+		select {
+		case snapshot, ok := <-snapshotCh:
+			if !ok {
+				release()
+				if err := ctx.Err(); err != nil {
+					return nil, nil, err
+				}
+				return nil, nil, status.Error(codes.Unavailable, "the traffic-manager is shutting down")
+			}
+			if len(conflicts(snapshot.State, sessionID, spec)) == 0 {
+				return release, holders, nil
+			}
+		case <-sessionDone:
+			release()
+			return nil, nil, status.Errorf(codes.NotFound, "Client session %q not found", sessionID)
+		}
+	}
+}
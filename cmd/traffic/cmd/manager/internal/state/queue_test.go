@@ -0,0 +1,143 @@
+package state_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/datawire/dlib/dlog"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/internal/state"
+	testdata "github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/internal/test"
+)
+
+func TestWaitForRelease(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	a := assertNew(t)
+
+	s := state.NewState(ctx)
+	now := time.Now()
+	s.AddAgent(testdata.GetTestAgents(t)["hello"], now)
+	alice := s.AddClient(&rpc.ClientInfo{Name: "alice@host"}, now)
+	bob := s.AddClient(&rpc.ClientInfo{Name: "bob@host"}, now)
+	carol := s.AddClient(&rpc.ClientInfo{Name: "carol@host"}, now)
+
+	spec := func(client string) *rpc.InterceptSpec {
+		return &rpc.InterceptSpec{Name: "hello", Client: client, Agent: "hello", Namespace: "default", Mechanism: "tcp"}
+	}
+
+	aliceCept, err := s.AddIntercept(alice, "", spec("alice@host"))
+	a.NoError(err)
+	a.Empty(s.InterceptConflicts(alice, spec("alice@host")), "a client doesn't conflict with itself")
+	holders := s.InterceptConflicts(bob, spec("bob@host"))
+	a.Len(holders, 1)
+	a.Equal(aliceCept.Id, holders[0].Id)
+
+	type result struct {
+		release func()
+		holders []*rpc.InterceptInfo
+		err     error
+	}
+	wait := func(sessionID, client string) <-chan result {
+		ch := make(chan result, 1)
+		go func() {
+			release, holders, err := s.WaitForRelease(ctx, sessionID, spec(client))
+			ch <- result{release, holders, err}
+		}()
+		return ch
+	}
+
+	bobCh := wait(bob, "bob@host")
+	time.Sleep(100 * time.Millisecond)
+	carolCh := wait(carol, "carol@host")
+
+	select {
+	case <-bobCh:
+		t.Fatal("bob got the workload while alice holds it")
+	case <-carolCh:
+		t.Fatal("carol got the workload while alice holds it")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	// Bob is first in line when alice releases the workload, and carol waits for bob's intercept
+	a.True(s.RemoveIntercept(aliceCept.Id))
+	r := <-bobCh
+	a.NoError(r.err)
+	a.Len(r.holders, 1)
+	_, err = s.AddIntercept(bob, "", spec("bob@host"))
+	a.NoError(err)
+	r.release()
+
+	select {
+	case <-carolCh:
+		t.Fatal("carol got the workload while bob holds it")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	s.RemoveSession(bob)
+	r = <-carolCh
+	a.NoError(r.err)
+	r.release()
+}
+
+func TestWaitForRelease_giveUp(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	a := assertNew(t)
+
+	s := state.NewState(ctx)
+	now := time.Now()
+	s.AddAgent(testdata.GetTestAgents(t)["hello"], now)
+	alice := s.AddClient(&rpc.ClientInfo{Name: "alice@host"}, now)
+	bob := s.AddClient(&rpc.ClientInfo{Name: "bob@host"}, now)
+
+	spec := &rpc.InterceptSpec{Name: "hello", Agent: "hello", Namespace: "default", Mechanism: "tcp"}
+	_, err := s.AddIntercept(alice, "", spec)
+	a.NoError(err)
+
+	waitCtx, waitCancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer waitCancel()
+	_, _, err = s.WaitForRelease(waitCtx, bob, spec)
+	a.Error(err)
+
+	// The ticket that bob gave up doesn't block the next client
+	s.RemoveSession(alice)
+	release, holders, err := s.WaitForRelease(ctx, bob, spec)
+	a.NoError(err)
+	a.Empty(holders)
+	release()
+}
+
+func TestInterceptConflicts_matchers(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	a := assertNew(t)
+
+	s := state.NewState(ctx)
+	now := time.Now()
+	s.AddAgent(testdata.GetTestAgents(t)["helloPro"], now)
+	alice := s.AddClient(&rpc.ClientInfo{Name: "alice@host"}, now)
+	bob := s.AddClient(&rpc.ClientInfo{Name: "bob@host"}, now)
+
+	spec := func(name, mechanism string, args ...string) *rpc.InterceptSpec {
+		return &rpc.InterceptSpec{Name: name, Agent: "hello-pro", Namespace: "default", Mechanism: mechanism, MechanismArgs: args}
+	}
+
+	_, err := s.AddIntercept(alice, "", spec("alice", "http", "--match=x-dev=alice"))
+	a.NoError(err)
+
+	a.Empty(s.InterceptConflicts(bob, spec("bob", "http", "--match=x-dev=bob")),
+		"http intercepts with different headers don't conflict")
+	a.Empty(s.InterceptConflicts(bob, spec("bob", "http", "--match=auto")),
+		"an auto match is unique to the intercept")
+	a.Len(s.InterceptConflicts(bob, spec("bob", "http", "--match=x-dev=alice")), 1,
+		"http intercepts with the same header conflict")
+	a.Len(s.InterceptConflicts(bob, spec("bob", "http", "--match=all")), 1,
+		"an http intercept that matches all requests conflicts")
+	a.Len(s.InterceptConflicts(bob, spec("bob", "http", "--match=x-dev=alice,x-app=web")), 1,
+		"an http intercept that selects a subset of the requests of another conflicts")
+	a.Len(s.InterceptConflicts(bob, spec("bob", "tcp")), 1,
+		"a tcp intercept conflicts with all intercepts")
+}
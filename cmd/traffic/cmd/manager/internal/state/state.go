@@ -3,23 +3,26 @@ package state
 import (
 	"context"
 	"fmt"
+	"net"
+	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
-	"golang.org/x/sys/unix"
 	"google.golang.org/grpc/codes"
 	grpcCodes "google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	grpcStatus "google.golang.org/grpc/status"
 	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
 
 	"github.com/datawire/dlib/dlog"
 	rpc "github.com/telepresenceio/telepresence/rpc/v2/manager"
 	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/internal/watchable"
 	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/managerutil"
 	"github.com/telepresenceio/telepresence/v2/pkg/connpool"
+	"github.com/telepresenceio/telepresence/v2/pkg/ipproto"
 	"github.com/telepresenceio/telepresence/v2/pkg/iputil"
 )
 
@@ -55,19 +58,37 @@ func (ss *sessionState) SetLastMarked(lastMarked time.Time) {
 type agentTunnel struct {
 	name      string
 	namespace string
-	tunnel    rpc.Manager_AgentTunnelServer
+	tunnel    *connpool.Stream
 }
 
 type clientSessionState struct {
 	sessionState
-	name               string
-	pool               *connpool.Pool
-	ClientTunnelServer rpc.Manager_ClientTunnelServer
-	agentTunnelsMu     sync.Mutex
-	agentTunnels       map[string]*agentTunnel
+	name           string
+	since          time.Time
+	user           string
+	callbackPorts  []int32
+	pool           *connpool.Pool
+	clientTunnelMu sync.Mutex
+	clientTunnel   *connpool.Stream
+	agentTunnelsMu sync.Mutex
+	agentTunnels   map[string]*agentTunnel
 }
 
-func (cs *clientSessionState) addAgentTunnel(agentSessionID, name, namespace string, tunnel rpc.Manager_AgentTunnelServer) {
+// setClientTunnel sets the tunnel of the client. A nil tunnel means that the client has none.
+func (cs *clientSessionState) setClientTunnel(tunnel *connpool.Stream) {
+	cs.clientTunnelMu.Lock()
+	cs.clientTunnel = tunnel
+	cs.clientTunnelMu.Unlock()
+}
+
+// getClientTunnel returns the tunnel of the client, or nil if the client has no tunnel.
+func (cs *clientSessionState) getClientTunnel() *connpool.Stream {
+	cs.clientTunnelMu.Lock()
+	defer cs.clientTunnelMu.Unlock()
+	return cs.clientTunnel
+}
+
+func (cs *clientSessionState) addAgentTunnel(agentSessionID, name, namespace string, tunnel *connpool.Stream) {
 	cs.agentTunnelsMu.Lock()
 	cs.agentTunnels[agentSessionID] = &agentTunnel{
 		name:      name,
@@ -159,6 +180,8 @@ type State struct {
 	interceptAPIKeys map[string]string                    // InterceptIDs mapped to the APIKey used to create them
 	listeners        map[string]connpool.Handler          // listeners for all intercepts
 	agentsByName     map[string]map[string]*rpc.AgentInfo // indexed copy of `agents`
+	queueTails       map[string]*queueTicket              // last ticket of each workload queue
+	interceptExpiry  map[string]time.Time                 // InterceptIDs mapped to when they expire
 }
 
 func NewState(ctx context.Context) *State {
@@ -168,6 +191,8 @@ func NewState(ctx context.Context) *State {
 		interceptAPIKeys: make(map[string]string),
 		agentsByName:     make(map[string]map[string]*rpc.AgentInfo),
 		listeners:        make(map[string]connpool.Handler),
+		queueTails:       make(map[string]*queueTicket),
+		interceptExpiry:  make(map[string]time.Time),
 	}
 }
 
@@ -258,15 +283,24 @@ func (s *State) MarkSession(req *rpc.RemainRequest, now time.Time) (ok bool) {
 	return false
 }
 
+// RemovedSession describes what was removed together with a session.
+type RemovedSession struct {
+	// Intercepts are the intercepts that were owned by the session
+	Intercepts []*rpc.InterceptInfo
+
+	// Agent is set when the session was the last one of the agent's workload
+	Agent *rpc.AgentInfo
+}
+
 // Remove a session from the set of present session IDs.
-func (s *State) RemoveSession(sessionID string) {
+func (s *State) RemoveSession(sessionID string) RemovedSession {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	s.unlockedRemoveSession(sessionID)
+	return s.unlockedRemoveSession(sessionID)
 }
 
-func (s *State) unlockedRemoveSession(sessionID string) {
+func (s *State) unlockedRemoveSession(sessionID string) (removed RemovedSession) {
 	if sess, ok := s.sessions[sessionID]; ok {
 		// kill the session
 		sess.Cancel()
@@ -276,6 +310,7 @@ func (s *State) unlockedRemoveSession(sessionID string) {
 			delete(s.agentsByName[agent.Name], sessionID)
 			if len(s.agentsByName[agent.Name]) == 0 {
 				delete(s.agentsByName, agent.Name)
+				removed.Agent = agent
 			}
 		}
 
@@ -292,6 +327,7 @@ func (s *State) unlockedRemoveSession(sessionID string) {
 				// Client went away:
 				// Delete it.
 				s.intercepts.Delete(interceptID)
+				removed.Intercepts = append(removed.Intercepts, intercept)
 			} else if errCode, errMsg := s.unlockedCheckAgentsForIntercept(intercept); errCode != 0 {
 				// Refcount went to zero:
 				// Tell the client, so that the client can tell us to delete it.
@@ -301,19 +337,26 @@ func (s *State) unlockedRemoveSession(sessionID string) {
 			}
 		}
 	}
+	return removed
 }
 
 // ExpireSessions prunes any sessions that haven't had a MarkSession heartbeat since the given
-// 'moment'.
-func (s *State) ExpireSessions(moment time.Time) {
+// 'moment' and returns what was removed together with them.
+func (s *State) ExpireSessions(clientMoment, agentMoment time.Time) []RemovedSession {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	var expired []RemovedSession
 	for id, sess := range s.sessions {
+		moment := agentMoment
+		if _, ok := sess.(*clientSessionState); ok {
+			moment = clientMoment
+		}
 		if sess.LastMarked().Before(moment) {
-			s.unlockedRemoveSession(id)
+			expired = append(expired, s.unlockedRemoveSession(id))
 		}
 	}
+	return expired
 }
 
 // SessionDone returns a channel that is closed when the session with the given ID terminates.  If
@@ -339,11 +382,19 @@ func (s *State) AddClient(client *rpc.ClientInfo, now time.Time) string {
 	// (to both humans and computers) if the manager restarts and those existing session IDs
 	// suddenly refer to different sessions.
 	sessionID := uuid.New().String()
-	return s.addClient(sessionID, client, now)
+	return s.addClient(sessionID, client, now, nil)
+}
+
+// AddClientWithCallbackPorts is like AddClient, but also records the ports on the loopback
+// interface of the client that the cluster may connect to, see ForwardToClient. Those are the
+// callback ports of the client that the traffic-manager serves. They're recorded together with the
+// session, so that a watcher of the clients never sees a session without them.
+func (s *State) AddClientWithCallbackPorts(client *rpc.ClientInfo, now time.Time, callbackPorts []int32) string {
+	return s.addClient(uuid.New().String(), client, now, callbackPorts)
 }
 
 // addClient is like AddClient, but takes a sessionID, for testing purposes
-func (s *State) addClient(sessionID string, client *rpc.ClientInfo, now time.Time) string {
+func (s *State) addClient(sessionID string, client *rpc.ClientInfo, now time.Time, callbackPorts []int32) string {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -357,9 +408,11 @@ func (s *State) addClient(sessionID string, client *rpc.ClientInfo, now time.Tim
 			cancel:     cancel,
 			lastMarked: now,
 		},
-		name:         client.Name,
-		pool:         connpool.NewPool(),
-		agentTunnels: make(map[string]*agentTunnel),
+		name:          client.Name,
+		since:         now,
+		callbackPorts: callbackPorts,
+		pool:          connpool.NewPool(),
+		agentTunnels:  make(map[string]*agentTunnel),
 	}
 	return sessionID
 }
@@ -373,6 +426,75 @@ func (s *State) GetAllClients() map[string]*rpc.ClientInfo {
 	return s.clients.LoadAll()
 }
 
+// SetClientUser records the Kubernetes user of the client with the given session ID. Returns
+// false if no such client session exists.
+func (s *State) SetClientUser(sessionID, user string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cs, ok := s.sessions[sessionID].(*clientSessionState)
+	if ok {
+		cs.user = user
+	}
+	return ok
+}
+
+// GetClientCallbackPorts returns the ports that the client with the given session ID lets the
+// cluster connect to.
+func (s *State) GetClientCallbackPorts(sessionID string) []int32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if cs, ok := s.sessions[sessionID].(*clientSessionState); ok {
+		return cs.callbackPorts
+	}
+	return nil
+}
+
+// ClientSession describes a client session along with the intercepts that it owns.
+type ClientSession struct {
+	SessionID  string
+	Client     *rpc.ClientInfo
+	User       string
+	Since      time.Time
+	Intercepts []*rpc.InterceptInfo
+}
+
+// GetClientSessions returns all client sessions, sorted by the time when the client arrived.
+func (s *State) GetClientSessions() []*ClientSession {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var result []*ClientSession
+	bySession := make(map[string]*ClientSession)
+	for id, ss := range s.sessions {
+		cs, ok := ss.(*clientSessionState)
+		if !ok {
+			continue
+		}
+		client, ok := s.clients.Load(id)
+		if !ok {
+			continue
+		}
+		session := &ClientSession{SessionID: id, Client: client, User: cs.user, Since: cs.since}
+		bySession[id] = session
+		result = append(result, session)
+	}
+	for _, cept := range s.intercepts.LoadAll() {
+		if session, ok := bySession[cept.ClientSession.GetSessionId()]; ok {
+			session.Intercepts = append(session.Intercepts, cept)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Since.Equal(result[j].Since) {
+			return result[i].SessionID < result[j].SessionID
+		}
+		return result[i].Since.Before(result[j].Since)
+	})
+	for _, session := range result {
+		sort.Slice(session.Intercepts, func(i, j int) bool { return session.Intercepts[i].Spec.Name < session.Intercepts[j].Spec.Name })
+	}
+	return result
+}
+
 func (s *State) WatchClients(
 	ctx context.Context,
 	filter func(sessionID string, client *rpc.ClientInfo) bool,
@@ -471,6 +593,7 @@ func (s *State) AddIntercept(sessionID, apiKey string, spec *rpc.InterceptSpec)
 
 	interceptID := fmt.Sprintf("%s:%s", sessionID, spec.Name)
 	s.interceptAPIKeys[interceptID] = apiKey
+	delete(s.interceptExpiry, interceptID)
 	cept := &rpc.InterceptInfo{
 		Spec:        spec,
 		Disposition: rpc.InterceptDispositionType_WAITING,
@@ -543,6 +666,7 @@ func (s *State) RemoveIntercept(interceptID string) bool {
 	_, didDelete := s.intercepts.LoadAndDelete(interceptID)
 	if didDelete {
 		s.mu.Lock()
+		delete(s.interceptExpiry, interceptID)
 		l, ok := s.listeners[interceptID]
 		if ok {
 			delete(s.listeners, interceptID)
@@ -555,6 +679,47 @@ func (s *State) RemoveIntercept(interceptID string) bool {
 	return didDelete
 }
 
+// SetInterceptExpiry makes ExpireIntercepts remove the intercept with the given ID once the given
+// time has passed, and records that time in the intercept, which is returned. Nil is returned when
+// the intercept doesn't exist.
+func (s *State) SetInterceptExpiry(interceptID string, expires time.Time) *rpc.InterceptInfo {
+	ii := s.UpdateIntercept(interceptID, func(ii *rpc.InterceptInfo) {
+		ii.ExpiresAt = timestamppb.New(expires)
+	})
+	if ii != nil {
+		s.mu.Lock()
+		s.interceptExpiry[interceptID] = expires
+		s.mu.Unlock()
+	}
+	return ii
+}
+
+// ExpireIntercepts removes the intercepts that expire before the given moment, and returns what
+// was removed.
+func (s *State) ExpireIntercepts(moment time.Time) []*rpc.InterceptInfo {
+	s.mu.Lock()
+	var ids []string
+	for id, expires := range s.interceptExpiry {
+		if expires.Before(moment) {
+			ids = append(ids, id)
+		}
+	}
+	s.mu.Unlock()
+
+	var expired []*rpc.InterceptInfo
+	for _, id := range ids {
+		if cept, ok := s.intercepts.Load(id); ok && s.RemoveIntercept(id) {
+			expired = append(expired, cept)
+		} else {
+			// Removed together with its session
+			s.mu.Lock()
+			delete(s.interceptExpiry, id)
+			s.mu.Unlock()
+		}
+	}
+	return expired
+}
+
 // GetInterceptAPIKey returns the first non-empty apiKey associated with an intercept IDs.
 // We use this fuction as a last resort if we need to garbage collect intercepts when
 // there are no active sessions.
@@ -610,10 +775,15 @@ func (s *State) ClientTunnel(ctx context.Context, server rpc.Manager_ClientTunne
 	}
 	dlog.Debug(ctx, "Established TCP tunnel")
 	pool := cs.pool // must have one pool per client
-	cs.ClientTunnelServer = server
-	defer pool.CloseAll(ctx)
+
+	stream := connpool.NewStream(server)
+	cs.setClientTunnel(stream)
+	defer func() {
+		cs.setClientTunnel(nil)
+		pool.CloseAll(ctx)
+	}()
 	closing := int32(0)
-	msgCh, errCh := connpool.NewStream(server).ReadLoop(ctx, &closing)
+	msgCh, errCh := stream.ReadLoop(ctx, &closing)
 	for {
 		select {
 		case <-ctx.Done():
@@ -630,13 +800,13 @@ func (s *State) ClientTunnel(ctx context.Context, server rpc.Manager_ClientTunne
 			// Retrieve the connection that is tracked for the given id. Create a new one if necessary
 			h, _, err := pool.Get(ctx, id, func(ctx context.Context, release func()) (connpool.Handler, error) {
 				switch id.Protocol() {
-				case unix.IPPROTO_TCP, unix.IPPROTO_UDP:
+				case ipproto.TCP, ipproto.UDP:
 					if agentTunnel := cs.getRandomAgentTunnel(); agentTunnel != nil {
 						// Dispatch directly to agent and let the dial happen there
 						dlog.Debugf(ctx, "|| FRWD %s forwarding client connection to agent %s.%s", id, agentTunnel.name, agentTunnel.namespace)
 						return newConnForward(release, agentTunnel.tunnel), nil
 					}
-					return connpool.NewDialer(id, cs.ClientTunnelServer, release), nil
+					return connpool.NewDialer(id, stream, release), nil
 				default:
 					return nil, fmt.Errorf("unhadled L4 protocol: %d", id.Protocol())
 				}
@@ -649,6 +819,52 @@ func (s *State) ClientTunnel(ctx context.Context, server rpc.Manager_ClientTunne
 	}
 }
 
+// ForwardToClient forwards a connection that was accepted on behalf of the client with the given
+// session ID to the given port on the loopback interface of the client. The client dials the port
+// when it receives the Connect on its tunnel, just like it does for intercepted connections. Only
+// the ports that the client declared when it arrived are forwarded.
+func (s *State) ForwardToClient(ctx context.Context, sessionID string, conn net.Conn, port uint16) error {
+	s.mu.Lock()
+	cs, ok := s.sessions[sessionID].(*clientSessionState)
+	s.mu.Unlock()
+	if !ok {
+		return status.Errorf(codes.NotFound, "client session %q not found", sessionID)
+	}
+	allowed := false
+	for _, p := range cs.callbackPorts {
+		if p == int32(port) {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return status.Errorf(codes.PermissionDenied, "client %s doesn't accept connections to port %d", cs.name, port)
+	}
+	srcIP, srcPort, err := iputil.SplitToIPPort(conn.RemoteAddr())
+	if err != nil {
+		return fmt.Errorf("failed to parse source address %s: %w", conn.RemoteAddr(), err)
+	}
+	dstIP := net.IPv4(127, 0, 0, 1)
+	if srcIP.To4() == nil {
+		dstIP = net.IPv6loopback
+	}
+	id := connpool.NewConnID(ipproto.TCP, srcIP, dstIP, srcPort, port)
+	stream := cs.getClientTunnel()
+	if stream == nil {
+		return status.Errorf(codes.Unavailable, "client %s has no tunnel", cs.name)
+	}
+	_, found, err := cs.pool.Get(ctx, id, func(ctx context.Context, release func()) (connpool.Handler, error) {
+		return connpool.HandlerFromConn(id, stream, release, conn), nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create tunnel connection for %s: %w", id, err)
+	}
+	if found {
+		return fmt.Errorf("multiple connections for %s", id)
+	}
+	return nil
+}
+
 type connForward struct {
 	release  func()
 	toStream connpool.TunnelStream
@@ -697,11 +913,11 @@ func (s *State) AgentTunnel(ctx context.Context, clientSessionInfo *rpc.SessionI
 
 	// During intercept, all requests that are made to this pool, are forwarded to the intercepted
 	// agent(s)
-	cs.addAgentTunnel(agentSessionID, as.agent.Name, as.agent.Namespace, server)
+	stream := connpool.NewStream(server)
+	cs.addAgentTunnel(agentSessionID, as.agent.Name, as.agent.Namespace, stream)
 	defer cs.deleteAgentTunnel(agentSessionID)
 
 	pool := cs.pool
-	stream := connpool.NewStream(server)
 	closing := int32(0)
 	msgCh, errCh := stream.ReadLoop(ctx, &closing)
 	for {
@@ -716,24 +932,27 @@ func (s *State) AgentTunnel(ctx context.Context, clientSessionInfo *rpc.SessionI
 				return nil
 			}
 			id := msg.ID()
-			conn, found, err := pool.Get(ctx, id, func(ctx context.Context, release func()) (connpool.Handler, error) {
-				return newConnForward(release, server), nil
-			})
+			createForward := func(ctx context.Context, release func()) (connpool.Handler, error) {
+				return newConnForward(release, stream), nil
+			}
+			conn, found, err := pool.Get(ctx, id, createForward)
 			if found {
 				if _, ok := conn.(*connForward); !ok {
 					// lingering, non intercepted outbound connection to agent. Close it and create a new forward
 					conn.Close(ctx)
-					_, _, err = pool.Get(ctx, id, func(ctx context.Context, release func()) (connpool.Handler, error) {
-						return newConnForward(release, server), nil
-					})
+					_, _, err = pool.Get(ctx, id, createForward)
 				}
 			}
 			if err != nil {
 				dlog.Error(ctx, err)
 				return status.Error(codes.Internal, err.Error())
 			}
+			clientStream := cs.getClientTunnel()
+			if clientStream == nil {
+				return status.Errorf(codes.Unavailable, "client %s has no tunnel", cs.name)
+			}
 			dlog.Debugf(ctx, ">> FRWD %s to client", id)
-			if err = cs.ClientTunnelServer.Send(msg.TunnelMessage()); err != nil {
+			if err = clientStream.Send(msg.TunnelMessage()); err != nil {
 				dlog.Errorf(ctx, "Send to client failed: %v", err)
 				return err
 			}
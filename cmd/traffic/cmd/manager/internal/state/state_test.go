@@ -5,6 +5,7 @@ import (
 	"testing"
 	"time"
 
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/manager"
 	manager "github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/internal/state"
 	testdata "github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/internal/test"
 )
@@ -94,7 +95,7 @@ func TestStateInternal(topT *testing.T) {
 		a.True(state.Mark(c2, clock.Now()))
 		a.False(state.Mark("asdf", clock.Now()))
 
-		state.ExpireSessions(epoch.Add(5 * time.Second))
+		state.ExpireSessions(epoch.Add(5 * time.Second), epoch.Add(5 * time.Second))
 
 		a.True(state.HasClient(c1))
 		a.True(state.HasClient(c2))
@@ -106,7 +107,7 @@ func TestStateInternal(topT *testing.T) {
 		a.True(state.Mark(c2, clock.Now()))
 		a.False(state.Mark(c3, clock.Now()))
 
-		state.ExpireSessions(epoch.Add(5 * time.Second))
+		state.ExpireSessions(epoch.Add(5 * time.Second), epoch.Add(5 * time.Second))
 
 		a.True(state.HasClient(c1))
 		a.True(state.HasClient(c2))
@@ -122,4 +123,127 @@ func TestStateInternal(topT *testing.T) {
 		a.False(state.Mark(c2, clock.Now()))
 		a.False(state.Mark(c3, clock.Now()))
 	})
+
+	topT.Run("presence-ttl", func(t *testing.T) {
+		a := assertNew(t)
+
+		clock := &FakeClock{}
+		epoch := clock.Now()
+		state := manager.NewState(ctx)
+
+		c1 := state.AddClient(testClients["alice"], clock.Now())
+		d1 := state.AddAgent(testAgents["demo1"], clock.Now())
+
+		// Clients survive longer without a heartbeat than agents do
+		clock.When = 10
+		state.ExpireSessions(epoch, epoch.Add(5*time.Second))
+		a.True(state.HasClient(c1))
+		a.Nil(state.GetAgent(d1))
+
+		state.ExpireSessions(epoch.Add(5*time.Second), epoch.Add(5*time.Second))
+		a.False(state.HasClient(c1))
+	})
+
+	topT.Run("removed-session", func(t *testing.T) {
+		a := assertNew(t)
+
+		clock := &FakeClock{}
+		epoch := clock.Now()
+		state := manager.NewState(ctx)
+
+		d1 := state.AddAgent(testAgents["demo1"], clock.Now())
+		d2 := state.AddAgent(testAgents["demo2"], clock.Now())
+		c1 := state.AddClient(testClients["alice"], clock.Now())
+		c2 := state.AddClient(testClients["bob"], clock.Now())
+
+		spec := &rpc.InterceptSpec{Name: "demo", Client: testClients["alice"].Name, Agent: "demo", Namespace: "default"}
+		ii, err := state.AddIntercept(c1, "", spec)
+		a.NoError(err)
+
+		// The workload still has an agent
+		removed := state.RemoveSession(d1)
+		a.Nil(removed.Agent)
+		a.Len(removed.Intercepts, 0)
+
+		removed = state.RemoveSession(d2)
+		a.Equal(testAgents["demo2"], removed.Agent)
+
+		clock.When = 10
+		a.True(state.Mark(c2, clock.Now()))
+		expired := state.ExpireSessions(epoch.Add(5 * time.Second), epoch.Add(5 * time.Second))
+		a.Len(expired, 1)
+		a.Nil(expired[0].Agent)
+		a.Len(expired[0].Intercepts, 1)
+		a.Equal(ii.Id, expired[0].Intercepts[0].Id)
+
+		// The intercept lost its agents before it expired
+		a.Equal(rpc.InterceptDispositionType_NO_AGENT, expired[0].Intercepts[0].Disposition)
+	})
+
+	topT.Run("expired-intercepts", func(t *testing.T) {
+		a := assertNew(t)
+
+		clock := &FakeClock{}
+		epoch := clock.Now()
+		state := manager.NewState(ctx)
+
+		c1 := state.AddClient(testClients["alice"], clock.Now())
+		spec := &rpc.InterceptSpec{Name: "demo", Client: testClients["alice"].Name, Agent: "demo", Namespace: "default"}
+		ii, err := state.AddIntercept(c1, "", spec)
+		a.NoError(err)
+		expiring := state.SetInterceptExpiry(ii.Id, epoch.Add(10*time.Second))
+		if a.NotNil(expiring) {
+			a.True(epoch.Add(10 * time.Second).Equal(expiring.ExpiresAt.AsTime()))
+		}
+		a.NotNil(state.GetIntercept(ii.Id).ExpiresAt, "the expiry is visible to everyone that sees the intercept")
+		a.Nil(state.SetInterceptExpiry("unknown", epoch))
+
+		a.Len(state.ExpireIntercepts(epoch.Add(5*time.Second)), 0)
+		expired := state.ExpireIntercepts(epoch.Add(15 * time.Second))
+		a.Len(expired, 1)
+		a.Equal(ii.Id, expired[0].Id)
+		a.Nil(state.GetIntercept(ii.Id))
+
+		// An intercept that is created again with the same name doesn't inherit the expiry
+		ii, err = state.AddIntercept(c1, "", spec)
+		a.NoError(err)
+		state.SetInterceptExpiry(ii.Id, epoch.Add(10*time.Second))
+		a.True(state.RemoveIntercept(ii.Id))
+		_, err = state.AddIntercept(c1, "", spec)
+		a.NoError(err)
+		a.Len(state.ExpireIntercepts(epoch.Add(15*time.Second)), 0)
+		a.NotNil(state.GetIntercept(ii.Id))
+	})
+
+	topT.Run("client-sessions", func(t *testing.T) {
+		a := assertNew(t)
+
+		clock := &FakeClock{}
+		state := manager.NewState(ctx)
+
+		c1 := state.AddClient(testClients["alice"], clock.Now())
+		clock.When = 10
+		c2 := state.AddClient(testClients["bob"], clock.Now())
+		a.True(state.SetClientUser(c1, "alice"))
+		a.False(state.SetClientUser("unknown", "nobody"))
+
+		spec := &rpc.InterceptSpec{Name: "demo", Client: testClients["alice"].Name, Agent: "demo", Namespace: "default"}
+		_, err := state.AddIntercept(c1, "", spec)
+		a.NoError(err)
+
+		sessions := state.GetClientSessions()
+		a.Len(sessions, 2)
+		a.Equal(c1, sessions[0].SessionID)
+		a.Equal("alice", sessions[0].User)
+		a.Len(sessions[0].Intercepts, 1)
+		a.Equal("demo", sessions[0].Intercepts[0].Spec.Name)
+		a.Equal(c2, sessions[1].SessionID)
+		a.Equal(clock.Now(), sessions[1].Since)
+		a.Empty(sessions[1].Intercepts)
+
+		// Kicking a client removes its session and its intercepts
+		removed := state.RemoveSession(c1)
+		a.Len(removed.Intercepts, 1)
+		a.Len(state.GetClientSessions(), 1)
+	})
 }
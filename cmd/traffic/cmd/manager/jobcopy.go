@@ -0,0 +1,135 @@
+package manager
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/datawire/ambassador/pkg/kates"
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/managerutil"
+	"github.com/telepresenceio/telepresence/v2/pkg/install"
+)
+
+// jobCopyReapInterval is how often the job copies of ended sessions are looked for
+const jobCopyReapInterval = 30 * time.Second
+
+// runJobCopyReaper removes the copies of Jobs and CronJobs that "telepresence intercept-job"
+// created in sessions that have ended. A copy is normally removed by the command that created it,
+// but that command may have been killed.
+//
+// The copies are only looked for in the namespaces where the traffic-manager is allowed to remove
+// them, which are its managed namespaces, or when it isn't namespaced, the JobCopyNamespaces.
+func (m *Manager) runJobCopyReaper(ctx context.Context) error {
+	env := managerutil.GetEnv(ctx)
+	namespaces := env.ManagedNamespaces
+	if len(namespaces) == 0 {
+		namespaces = env.JobCopyNamespaces
+	}
+	if len(namespaces) == 0 {
+		dlog.Info(ctx, "No namespaces are given where the copies of jobs may be removed")
+		return nil
+	}
+	ticker := time.NewTicker(jobCopyReapInterval)
+	defer ticker.Stop()
+	gone := make(sessionsGone)
+	for {
+		select {
+		case <-ticker.C:
+			m.reapJobCopies(ctx, namespaces, gone)
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// sessionsGone holds the time when the sessions of job copies were first found to be missing.
+// Sessions are only held in memory, so a session that is missing may still be arriving, or may be
+// on its way back after the traffic-manager restarted.
+type sessionsGone map[string]time.Time
+
+// expired returns true when the given session has been missing for longer than ttl.
+func (g sessionsGone) expired(sessionID string, present bool, now time.Time, ttl time.Duration) bool {
+	if present {
+		delete(g, sessionID)
+		return false
+	}
+	since, ok := g[sessionID]
+	if !ok {
+		g[sessionID] = now
+		return false
+	}
+	return now.Sub(since) > ttl
+}
+
+// retain forgets the sessions that no longer have job copies.
+func (g sessionsGone) retain(sessionIDs map[string]struct{}) {
+	for id := range g {
+		if _, ok := sessionIDs[id]; !ok {
+			delete(g, id)
+		}
+	}
+}
+
+func (m *Manager) reapJobCopies(ctx context.Context, namespaces []string, gone sessionsGone) {
+	env := managerutil.GetEnv(ctx)
+	client := managerutil.GetKatesClient(ctx)
+	now := m.clock.Now()
+	seen := make(map[string]struct{})
+	for _, ns := range namespaces {
+		var deps []*kates.Deployment
+		err := client.List(ctx, kates.Query{
+			Kind:          "Deployment",
+			Namespace:     ns,
+			LabelSelector: install.JobCopyLabel + "," + install.ManagerNamespaceLabel + "=" + env.ManagerNamespace,
+		}, &deps)
+		if err != nil {
+			dlog.Errorf(ctx, "unable to list the copies of jobs: %v", err)
+			// Keep what's known about the sessions of the copies that couldn't be listed
+			for id := range gone {
+				seen[id] = struct{}{}
+			}
+			continue
+		}
+		for _, dep := range deps {
+			sessionID := dep.Annotations[install.JobCopySessionAnnotation]
+			seen[sessionID] = struct{}{}
+			if gone.expired(sessionID, m.state.GetClient(sessionID) != nil, now, env.ClientSessionTTL) {
+				reapJobCopy(ctx, dep)
+			}
+		}
+	}
+	gone.retain(seen)
+}
+
+// reapJobCopy deletes the given job copy, whose Service is deleted with it by the garbage collector
+// of the cluster, and resumes the CronJob that was suspended for it.
+func reapJobCopy(ctx context.Context, dep *kates.Deployment) {
+	client := managerutil.GetKatesClient(ctx)
+	copyOf := dep.Annotations[install.JobCopyOfAnnotation]
+	dlog.Infof(ctx, "Removing %s.%s, a copy of %s whose session has ended", dep.Name, dep.Namespace, copyOf)
+	if err := client.Delete(ctx, dep, nil); err != nil && !kates.IsNotFound(err) {
+		dlog.Errorf(ctx, "unable to delete %s.%s: %v", dep.Name, dep.Namespace, err)
+		return
+	}
+	if name := resumedCronJob(dep); name != "" {
+		cj := &kates.Unstructured{}
+		cj.SetAPIVersion("batch/v1beta1")
+		cj.SetKind("CronJob")
+		cj.SetName(name)
+		cj.SetNamespace(dep.Namespace)
+		if err := client.Patch(ctx, cj, kates.MergePatchType, []byte(`{"spec":{"suspend":false}}`), cj); err != nil && !kates.IsNotFound(err) {
+			dlog.Errorf(ctx, "unable to resume CronJob %s.%s: %v", name, dep.Namespace, err)
+		}
+	}
+}
+
+// resumedCronJob returns the name of the CronJob that must be resumed when the given job copy is
+// removed, or an empty string when there's none.
+func resumedCronJob(dep *kates.Deployment) string {
+	copyOf := dep.Annotations[install.JobCopyOfAnnotation]
+	if dep.Annotations[install.JobCopyResumeAnnotation] != "true" || !strings.HasPrefix(copyOf, "CronJob/") {
+		return ""
+	}
+	return strings.TrimPrefix(copyOf, "CronJob/")
+}
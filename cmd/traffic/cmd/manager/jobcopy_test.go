@@ -0,0 +1,43 @@
+package manager
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/datawire/ambassador/pkg/kates"
+	"github.com/telepresenceio/telepresence/v2/pkg/install"
+)
+
+func TestResumedCronJob(t *testing.T) {
+	dep := func(copyOf string, resume bool) *kates.Deployment {
+		d := &kates.Deployment{}
+		d.Annotations = map[string]string{install.JobCopyOfAnnotation: copyOf}
+		if resume {
+			d.Annotations[install.JobCopyResumeAnnotation] = "true"
+		}
+		return d
+	}
+	assert.Equal(t, "report", resumedCronJob(dep("CronJob/report", true)))
+	assert.Empty(t, resumedCronJob(dep("CronJob/report", false)), "the CronJob wasn't suspended for the copy")
+	assert.Empty(t, resumedCronJob(dep("Job/report", true)))
+}
+
+func TestSessionsGone(t *testing.T) {
+	const ttl = 2 * time.Minute
+	gone := make(sessionsGone)
+	start := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	assert.False(t, gone.expired("a", false, start, ttl), "a session that was just found missing may still be arriving")
+	assert.False(t, gone.expired("a", false, start.Add(ttl), ttl))
+	assert.True(t, gone.expired("a", false, start.Add(ttl+time.Second), ttl))
+
+	assert.False(t, gone.expired("b", false, start, ttl))
+	assert.False(t, gone.expired("b", true, start.Add(time.Minute), ttl))
+	assert.False(t, gone.expired("b", false, start.Add(ttl+time.Second), ttl), "the session came back, so the grace period starts over")
+
+	gone.retain(map[string]struct{}{"b": {}})
+	assert.NotContains(t, gone, "a")
+	assert.Contains(t, gone, "b")
+}
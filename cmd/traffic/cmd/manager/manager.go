@@ -3,8 +3,10 @@ package manager
 import (
 	"context"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -17,9 +19,15 @@ import (
 	"github.com/datawire/dlib/dlog"
 	rpc "github.com/telepresenceio/telepresence/rpc/v2/manager"
 	"github.com/telepresenceio/telepresence/rpc/v2/systema"
+	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/internal/auth"
+	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/internal/events"
 	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/internal/mutator"
+	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/internal/policy"
 	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/internal/watchable"
 	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/managerutil"
+	"github.com/telepresenceio/telepresence/v2/pkg/dnet"
+	"github.com/telepresenceio/telepresence/v2/pkg/install"
+	"github.com/telepresenceio/telepresence/v2/pkg/tracing"
 	"github.com/telepresenceio/telepresence/v2/pkg/version"
 )
 
@@ -30,6 +38,9 @@ func Main(ctx context.Context, args ...string) error {
 	if err != nil {
 		return err
 	}
+	if err = tracing.Init(ctx, "traffic-manager", "", managerutil.GetEnv(ctx).TracingEndpoint); err != nil {
+		return err
+	}
 
 	// Make the kates client available in the context
 	client, err := kates.NewClient(kates.ClientConfig{})
@@ -42,35 +53,103 @@ func Main(ctx context.Context, args ...string) error {
 		EnableSignalHandling: true,
 	})
 	mgr := NewManager(ctx)
+	if mgr.policy, err = policy.NewFile(managerutil.GetEnv(ctx).InterceptPolicyFile); err != nil {
+		return err
+	}
+	if p := mgr.policy.Policy(ctx); p != nil {
+		dlog.Infof(ctx, "Intercept policy with %d rules loaded", len(p.Rules))
+	}
+
+	// The TokenReviews of the clients and the traffic-agents share one clientset
+	cs, err := auth.InClusterClientset()
+	if err != nil {
+		return err
+	}
+	env := managerutil.GetEnv(ctx)
+	mgr.certAuth = auth.UsesCertificates(env.AuthMethods)
+	if mgr.auth, err = auth.New(ctx, &auth.Config{
+		Methods:           env.AuthMethods,
+		StaticTokenFile:   env.AuthStaticTokenFile,
+		TokenAudiences:    env.AuthTokenAudiences,
+		OIDCIssuer:        env.AuthOIDCIssuer,
+		OIDCClientID:      env.AuthOIDCClientID,
+		OIDCCAFile:        env.AuthOIDCCAFile,
+		OIDCUsernameClaim: env.AuthOIDCUsernameClaim,
+		OIDCGroupsClaim:   env.AuthOIDCGroupsClaim,
+		Clientset:         cs,
+	}); err != nil {
+		return err
+	}
+	if env.AuthRequired {
+		dlog.Infof(ctx, "Clients must authenticate using %s", strings.Join(env.AuthMethods, ", "))
+	}
+	mgr.requireMTLS = mtlsEnabled()
+	if env.AuthRequired || mgr.requireMTLS {
+		mgr.agentAuth = auth.NewServiceAccountReview(cs)
+	}
+
+	grpcOpts, err := managerutil.GetEnv(ctx).GRPCServerOptions()
+	if err != nil {
+		return err
+	}
+	grpcOpts = append(grpcOpts,
+		grpc.ChainUnaryInterceptor(mgr.unaryMTLSInterceptor, mgr.unaryAuthInterceptor),
+		grpc.ChainStreamInterceptor(mgr.streamMTLSInterceptor, mgr.streamAuthInterceptor))
+	grpcHandler := grpc.NewServer(append(tracing.ServerOptions(), grpcOpts...)...)
+	rpc.RegisterManagerServer(grpcHandler, mgr)
+	rpc.RegisterSessionsServer(grpcHandler, mgr)
+	grpc_health_v1.RegisterHealthServer(grpcHandler, &HealthChecker{})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/usage", mgr.serveUsage)
+	mux.HandleFunc("/dns", mgr.serveDNSStats)
+	mux.Handle(dnet.WebSocketTunnelPath, dnet.WebSocketTunnelHandler(func(r *http.Request) string {
+		// Tunnels of clients that use mutual TLS lead to the TLS port, so that the handshake is end to end
+		if r.URL.Query().Get("mtls") == "true" {
+			return net.JoinHostPort(env.ServerHost, strconv.Itoa(install.ManagerPortHTTPS))
+		}
+		return net.JoinHostPort(env.ServerHost, env.ServerPort)
+	}))
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "Hello World from: %s\n", r.URL.Path)
+	})
+	httpHandler := http.Handler(mux)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor == 2 && strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+			grpcHandler.ServeHTTP(w, r)
+		} else {
+			httpHandler.ServeHTTP(w, r)
+		}
+	})
 
 	// Serve HTTP (including gRPC)
 	g.Go("httpd", func(ctx context.Context) error {
 		env := managerutil.GetEnv(ctx)
-		host := env.ServerHost
-		port := env.ServerPort
-
-		grpcHandler := grpc.NewServer()
-		httpHandler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			fmt.Fprintf(w, "Hello World from: %s\n", r.URL.Path)
-		}))
-		sc := &dhttp.ServerConfig{
-			Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-				if r.ProtoMajor == 2 && strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
-					grpcHandler.ServeHTTP(w, r)
-				} else {
-					httpHandler.ServeHTTP(w, r)
-				}
-			}),
-		}
+		sc := &dhttp.ServerConfig{Handler: handler}
+		return sc.ListenAndServe(ctx, env.ServerHost+":"+env.ServerPort)
+	})
 
-		rpc.RegisterManagerServer(grpcHandler, mgr)
-		grpc_health_v1.RegisterHealthServer(grpcHandler, &HealthChecker{})
+	// Serve the same handler using mutual TLS when the certificates are present
+	g.Go("httpd-tls", func(ctx context.Context) error {
+		return serveMTLS(ctx, managerutil.GetEnv(ctx).ServerHost, handler)
+	})
 
-		return sc.ListenAndServe(ctx, host+":"+port)
+	// Serve QUIC tunnels to the mutual TLS port when a UDP port is configured
+	g.Go("quic", func(ctx context.Context) error {
+		env := managerutil.GetEnv(ctx)
+		return serveQUIC(ctx, env.ServerHost, env.QUICPort)
 	})
 
 	g.Go("agent-injector", mutator.ServeMutator)
 
+	g.Go("events", events.Run)
+
+	g.Go("usage", mgr.runUsage)
+
+	g.Go("client-callback", mgr.serveClientCallbacks)
+
+	g.Go("job-copy-gc", mgr.runJobCopyReaper)
+
 	g.Go("intercept-gc", func(ctx context.Context) error {
 		// Loop calling Expire
 		ticker := time.NewTicker(5 * time.Second)
@@ -2,13 +2,27 @@ package managerutil
 
 import (
 	"context"
+	"fmt"
+	"math"
 	"strings"
+	"time"
 
 	"github.com/sethvargo/go-envconfig"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/keepalive"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 
+	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/internal/dns"
+	"github.com/telepresenceio/telepresence/v2/pkg/install"
+	"github.com/telepresenceio/telepresence/v2/pkg/tlspolicy"
 	"github.com/telepresenceio/telepresence/v2/pkg/version"
 )
 
+// minKeepaliveInterval is the shortest interval between two pings that the traffic-manager accepts.
+// gRPC never pings more often than every 10s.
+const minKeepaliveInterval = 5 * time.Second
+
 type Env struct {
 	User        string `env:"USER,default="`
 	ServerHost  string `env:"SERVER_HOST,default="`
@@ -18,11 +32,104 @@ type Env struct {
 	ClusterID   string `env:"CLUSTER_ID,default="`
 
 	ManagerNamespace string `env:"MANAGER_NAMESPACE,default="`
+	PodIP            string `env:"POD_IP,default="`
 	AgentRegistry    string `env:"TELEPRESENCE_REGISTRY,default=docker.io/datawire"`
 	AgentImage       string `env:"TELEPRESENCE_AGENT_IMAGE,default="`
 	AgentPort        int32  `env:"TELEPRESENCE_AGENT_PORT,default=9900"`
+
+	// AgentImageVariants are "<arch>=<image>" pairs that name the agent image to use for pods that
+	// can only run on nodes with the given architecture. AgentImages holds them by architecture,
+	// with the registry prepended to the images.
+	AgentImageVariants []string `env:"TELEPRESENCE_AGENT_IMAGE_VARIANTS"`
+	AgentImages        map[string]string
+
+	// AgentInjectPolicy is InjectPolicyOptIn or InjectPolicyOptOut
+	AgentInjectPolicy string `env:"AGENT_INJECT_POLICY,default=OptIn"`
+
+	// AgentInjectExcludedNamespaces are the namespaces whose pods InjectPolicyOptOut doesn't inject
+	// the traffic-agent into unless they enable it with the inject annotation, which by default are
+	// the namespaces of the system.
+	AgentInjectExcludedNamespaces []string `env:"AGENT_INJECT_EXCLUDED_NAMESPACES,default=kube-system,kube-public,kube-node-lease"`
+
+	// ManagedNamespaces are the namespaces that a namespaced traffic-manager has access to. It's
+	// empty when the traffic-manager has access to all namespaces.
+	ManagedNamespaces []string `env:"MANAGED_NAMESPACES"`
+
+	// JobCopyNamespaces are the namespaces where a traffic-manager that isn't namespaced may remove
+	// the copies of jobs that clients left behind. A namespaced traffic-manager removes them in its
+	// ManagedNamespaces.
+	JobCopyNamespaces []string `env:"JOB_COPY_NAMESPACES"`
+
+	// AgentMountsReadOnly makes the injected agents share the volumes of the app read-only
+	AgentMountsReadOnly bool `env:"AGENT_MOUNTS_READ_ONLY,default=false"`
+
+	// ClientCallbackPorts are the ports of the Service that makes each client reachable by name
+	// from the cluster. No such Services are created when the list is empty.
+	ClientCallbackPorts []int32 `env:"CLIENT_CALLBACK_PORTS"`
+
+	// QUICPort is the UDP port where the traffic-manager serves QUIC tunnels to its mutual TLS
+	// port. No QUIC tunnels are served when it's empty.
+	QUICPort string `env:"QUIC_PORT,default="`
+
+	InterceptPolicyFile string `env:"INTERCEPT_POLICY_FILE,default=/etc/traffic-manager/intercept-policy.yaml"`
+
+	// ClientSessionTTL is how long the session of a client, and its intercepts, survive without a
+	// heartbeat. It's longer than the TTL of the sessions of the traffic-agents, because the
+	// heartbeats of a client pass through the API server, which may be unreachable for a while.
+	ClientSessionTTL time.Duration `env:"CLIENT_SESSION_TTL,default=2m"`
+
+	// AuthMethods are the methods, in the order they're tried, that validate the tokens that
+	// clients present, and optionally the certificate method, see the auth package. AuthRequired makes the traffic-manager refuse clients
+	// that don't present a valid token. AuthTokenAudiences are the audiences that the tokens of
+	// the tokenreview method must be issued for.
+	AuthMethods           []string `env:"AUTH_METHODS,default=tokenreview"`
+	AuthRequired          bool     `env:"AUTH_REQUIRED,default=false"`
+	AuthStaticTokenFile   string   `env:"AUTH_STATIC_TOKEN_FILE,default=/var/run/secrets/auth-tokens/tokens.csv"`
+	AuthTokenAudiences    []string `env:"AUTH_TOKEN_AUDIENCES"`
+	AuthOIDCIssuer        string   `env:"AUTH_OIDC_ISSUER,default="`
+	AuthOIDCClientID      string   `env:"AUTH_OIDC_CLIENT_ID,default="`
+	AuthOIDCCAFile        string   `env:"AUTH_OIDC_CA_FILE,default="`
+	AuthOIDCUsernameClaim string   `env:"AUTH_OIDC_USERNAME_CLAIM,default=sub"`
+	AuthOIDCGroupsClaim   string   `env:"AUTH_OIDC_GROUPS_CLAIM,default=groups"`
+
+	// DNSCacheTTL is how long the addresses that are resolved for clients are cached, and
+	// DNSNegativeCacheTTL how long it's cached that a name can't be resolved. Zero disables the
+	// caching.
+	DNSCacheTTL         time.Duration `env:"DNS_CACHE_TTL,default=30s"`
+	DNSNegativeCacheTTL time.Duration `env:"DNS_NEGATIVE_CACHE_TTL,default=5s"`
+
+	// DNSOverridesJSON is a JSON list of overrides that make names resolve to fixed addresses for
+	// all clients or for some of them. DNSOverrides holds them parsed.
+	DNSOverridesJSON string `env:"DNS_OVERRIDES,default="`
+	DNSOverrides     []dns.Override
+
+	TLSMinVersion   string   `env:"TLS_MIN_VERSION,default="`
+	TLSCipherSuites []string `env:"TLS_CIPHER_SUITES"`
+	TLSFIPS         bool     `env:"TLS_FIPS,default=false"`
+
+	TracingEndpoint string `env:"OTEL_EXPORTER_OTLP_ENDPOINT,default="`
+
+	GRPCMaxReceiveSize string `env:"GRPC_MAX_RECEIVE_SIZE,default="`
+	GRPCMaxSendSize    string `env:"GRPC_MAX_SEND_SIZE,default="`
+
+	// The gRPC settings of the connections of the injected agents to the traffic-manager. They're
+	// passed on to the agents as their GRPC_* environment variables, see AgentGRPCEnv.
+	AgentGRPCMaxReceiveSize        string `env:"AGENT_GRPC_MAX_RECEIVE_SIZE,default="`
+	AgentGRPCMaxSendSize           string `env:"AGENT_GRPC_MAX_SEND_SIZE,default="`
+	AgentGRPCInitialWindowSize     string `env:"AGENT_GRPC_INITIAL_WINDOW_SIZE,default="`
+	AgentGRPCInitialConnWindowSize string `env:"AGENT_GRPC_INITIAL_CONN_WINDOW_SIZE,default="`
+	AgentGRPCWriteBufferSize       string `env:"AGENT_GRPC_WRITE_BUFFER_SIZE,default="`
 }
 
+// The policies that decide which pods the agent injector injects the traffic-agent into. With
+// InjectPolicyOptIn, only pods that enable it with the inject annotation get it. With
+// InjectPolicyOptOut, all pods that the webhook is called for get it, unless the annotation
+// disables it.
+const (
+	InjectPolicyOptIn  = "OptIn"
+	InjectPolicyOptOut = "OptOut"
+)
+
 type envKey struct{}
 
 func LoadEnv(ctx context.Context) (context.Context, error) {
@@ -35,9 +142,106 @@ func LoadEnv(ctx context.Context) (context.Context, error) {
 	} else {
 		env.AgentImage = env.AgentRegistry + "/" + env.AgentImage
 	}
+	switch env.AgentInjectPolicy {
+	case InjectPolicyOptIn, InjectPolicyOptOut:
+	default:
+		return ctx, fmt.Errorf("invalid AGENT_INJECT_POLICY %q, expected %s or %s", env.AgentInjectPolicy, InjectPolicyOptIn, InjectPolicyOptOut)
+	}
+	overrides, err := dns.ParseOverrides(env.DNSOverridesJSON)
+	if err != nil {
+		return ctx, fmt.Errorf("invalid DNS_OVERRIDES: %w", err)
+	}
+	env.DNSOverrides = overrides
+	if env.AgentImages, err = install.ParseAgentImageVariants(env.AgentRegistry, env.AgentImageVariants); err != nil {
+		return ctx, err
+	}
+	if _, err = env.GRPCServerOptions(); err != nil {
+		return ctx, err
+	}
+	for _, ev := range env.AgentGRPCEnv() {
+		if _, err = ParseGRPCSize("AGENT_"+ev.Name, ev.Value); err != nil {
+			return ctx, err
+		}
+	}
 	return WithEnv(ctx, &env), nil
 }
 
+// AgentArchitectures returns the node architectures that an agent image is available for
+func (e *Env) AgentArchitectures() []string {
+	return install.AgentImageArchitectures(e.AgentImages)
+}
+
+// TLSPolicy returns the TLS policy that the traffic-manager applies to its TLS servers, and to the
+// TLS connections that it makes to Ambassador Cloud and to the ingresses of intercepts
+func (e *Env) TLSPolicy() (*tlspolicy.Policy, error) {
+	return tlspolicy.Parse(e.TLSMinVersion, e.TLSCipherSuites, e.TLSFIPS)
+}
+
+// ParseGRPCSize parses the value of the environment variable with the given name, which is a size in
+// bytes in the form of a Kubernetes quantity, such as "16Mi". Zero is returned for an empty value.
+// gRPC uses 32-bit sizes, so larger sizes are rejected.
+func ParseGRPCSize(name, value string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+	q, err := resource.ParseQuantity(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %w", name, value, err)
+	}
+	sz, ok := q.AsInt64()
+	if !ok || sz < 1 || sz > math.MaxInt32 {
+		return 0, fmt.Errorf("invalid %s %q: must be between 1 and %d bytes", name, value, math.MaxInt32)
+	}
+	return int(sz), nil
+}
+
+// GRPCServerOptions returns the gRPC server options that apply the message size limits of the
+// environment. The flow control windows of the server are those of the HTTP/2 server that serves
+// the gRPC handler.
+func (e *Env) GRPCServerOptions() ([]grpc.ServerOption, error) {
+	var opts []grpc.ServerOption
+	sz, err := ParseGRPCSize("GRPC_MAX_RECEIVE_SIZE", e.GRPCMaxReceiveSize)
+	if err != nil {
+		return nil, err
+	}
+	if sz > 0 {
+		opts = append(opts, grpc.MaxRecvMsgSize(sz))
+	}
+	if sz, err = ParseGRPCSize("GRPC_MAX_SEND_SIZE", e.GRPCMaxSendSize); err != nil {
+		return nil, err
+	}
+	if sz > 0 {
+		opts = append(opts, grpc.MaxSendMsgSize(sz))
+	}
+
+	// Permit the pings of the clients and the traffic-agents, which ping every 15s by default. A
+	// gRPC server closes the connections of clients that ping more often than every 5 minutes
+	// unless it's told otherwise.
+	opts = append(opts, grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+		MinTime:             minKeepaliveInterval,
+		PermitWithoutStream: true,
+	}))
+	return opts, nil
+}
+
+// AgentGRPCEnv returns the environment variables that pass the gRPC settings of the agents on to
+// the injected agents.
+func (e *Env) AgentGRPCEnv() []corev1.EnvVar {
+	var env []corev1.EnvVar
+	for _, ev := range []corev1.EnvVar{
+		{Name: "GRPC_MAX_RECEIVE_SIZE", Value: e.AgentGRPCMaxReceiveSize},
+		{Name: "GRPC_MAX_SEND_SIZE", Value: e.AgentGRPCMaxSendSize},
+		{Name: "GRPC_INITIAL_WINDOW_SIZE", Value: e.AgentGRPCInitialWindowSize},
+		{Name: "GRPC_INITIAL_CONN_WINDOW_SIZE", Value: e.AgentGRPCInitialConnWindowSize},
+		{Name: "GRPC_WRITE_BUFFER_SIZE", Value: e.AgentGRPCWriteBufferSize},
+	} {
+		if ev.Value != "" {
+			env = append(env, ev)
+		}
+	}
+	return env
+}
+
 func WithEnv(ctx context.Context, env *Env) context.Context {
 	return context.WithValue(ctx, envKey{}, env)
 }
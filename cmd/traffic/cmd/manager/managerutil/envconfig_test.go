@@ -5,9 +5,11 @@ import (
 	"os"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
+	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/internal/dns"
 	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/managerutil"
 	"github.com/telepresenceio/telepresence/v2/pkg/version"
 )
@@ -36,6 +38,19 @@ func TestEnvconfig(t *testing.T) {
 		AgentRegistry: "docker.io/datawire",
 		AgentImage:    "docker.io/datawire/tel2:" + strings.TrimPrefix(version.Version, "v"),
 		AgentPort:     9900,
+
+		AgentInjectPolicy:             managerutil.InjectPolicyOptIn,
+		AgentInjectExcludedNamespaces: []string{"kube-system", "kube-public", "kube-node-lease"},
+		InterceptPolicyFile:           "/etc/traffic-manager/intercept-policy.yaml",
+		ClientSessionTTL:              2 * time.Minute,
+
+		AuthMethods:           []string{"tokenreview"},
+		AuthStaticTokenFile:   "/var/run/secrets/auth-tokens/tokens.csv",
+		AuthOIDCUsernameClaim: "sub",
+		AuthOIDCGroupsClaim:   "groups",
+
+		DNSCacheTTL:         30 * time.Second,
+		DNSNegativeCacheTTL: 5 * time.Second,
 	}
 
 	testcases := map[string]struct {
@@ -54,6 +69,73 @@ func TestEnvconfig(t *testing.T) {
 				e.SystemAHost = "app.getambassador.io"
 			},
 		},
+		"grpc": {
+			Input: map[string]string{
+				"GRPC_MAX_RECEIVE_SIZE": "16Mi",
+				"GRPC_MAX_SEND_SIZE":    "32Mi",
+			},
+			Output: func(e *managerutil.Env) {
+				e.GRPCMaxReceiveSize = "16Mi"
+				e.GRPCMaxSendSize = "32Mi"
+			},
+		},
+		"agent grpc": {
+			Input: map[string]string{
+				"AGENT_GRPC_MAX_RECEIVE_SIZE":         "16Mi",
+				"AGENT_GRPC_INITIAL_CONN_WINDOW_SIZE": "1Mi",
+			},
+			Output: func(e *managerutil.Env) {
+				e.AgentGRPCMaxReceiveSize = "16Mi"
+				e.AgentGRPCInitialConnWindowSize = "1Mi"
+			},
+		},
+		"inject policy": {
+			Input: map[string]string{
+				"AGENT_INJECT_POLICY":              "OptOut",
+				"AGENT_INJECT_EXCLUDED_NAMESPACES": "kube-system,monitoring",
+			},
+			Output: func(e *managerutil.Env) {
+				e.AgentInjectPolicy = managerutil.InjectPolicyOptOut
+				e.AgentInjectExcludedNamespaces = []string{"kube-system", "monitoring"}
+			},
+		},
+		"dns": {
+			Input: map[string]string{
+				"DNS_CACHE_TTL":          "1m",
+				"DNS_NEGATIVE_CACHE_TTL": "0s",
+				"DNS_OVERRIDES":          `[{"clients":["alice@*"],"hosts":{"db.prod":["10.1.0.1"]}}]`,
+			},
+			Output: func(e *managerutil.Env) {
+				e.DNSCacheTTL = time.Minute
+				e.DNSNegativeCacheTTL = 0
+				e.DNSOverridesJSON = `[{"clients":["alice@*"],"hosts":{"db.prod":["10.1.0.1"]}}]`
+				e.DNSOverrides = []dns.Override{{Clients: []string{"alice@*"}, Hosts: map[string][]string{"db.prod": {"10.1.0.1"}}}}
+			},
+		},
+		"tls": {
+			Input: map[string]string{
+				"TLS_MIN_VERSION":   "1.3",
+				"TLS_CIPHER_SUITES": "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384",
+				"TLS_FIPS":          "true",
+			},
+			Output: func(e *managerutil.Env) {
+				e.TLSMinVersion = "1.3"
+				e.TLSCipherSuites = []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", "TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384"}
+				e.TLSFIPS = true
+			},
+		},
+		"image variants": {
+			Input: map[string]string{
+				"TELEPRESENCE_AGENT_IMAGE_VARIANTS": "arm64=tel2-arm64:2.3.6,s390x=tel2-s390x:2.3.6",
+			},
+			Output: func(e *managerutil.Env) {
+				e.AgentImageVariants = []string{"arm64=tel2-arm64:2.3.6", "s390x=tel2-s390x:2.3.6"}
+				e.AgentImages = map[string]string{
+					"arm64": "docker.io/datawire/tel2-arm64:2.3.6",
+					"s390x": "docker.io/datawire/tel2-s390x:2.3.6",
+				}
+			},
+		},
 	}
 
 	for tcName, tc := range testcases {
@@ -79,3 +161,18 @@ func TestEnvconfig(t *testing.T) {
 		})
 	}
 }
+
+func TestParseGRPCSize(t *testing.T) {
+	sz, err := managerutil.ParseGRPCSize("GRPC_MAX_SEND_SIZE", "")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, sz)
+
+	sz, err = managerutil.ParseGRPCSize("GRPC_MAX_SEND_SIZE", "16Mi")
+	assert.NoError(t, err)
+	assert.Equal(t, 16*1024*1024, sz)
+
+	for _, v := range []string{"3Gi", "0", "-1Mi", "lots"} {
+		_, err = managerutil.ParseGRPCSize("GRPC_MAX_SEND_SIZE", v)
+		assert.Error(t, err, v)
+	}
+}
@@ -0,0 +1,26 @@
+package managerutil
+
+import (
+	"context"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// IdentityTokenHeader is the gRPC metadata key used when a client passes a token to the
+// traffic-manager so that the traffic-manager can determine the client's identity. The token is the
+// Kubernetes bearer token of the client unless the client is configured to present another one,
+// e.g. an OpenID Connect ID token.
+const IdentityTokenHeader = "x-telepresence-k8s-token"
+
+// GetIdentityToken returns the token passed by the client in the current gRPC call,
+// or an empty string if no token was passed.
+func GetIdentityToken(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	if vs := md.Get(IdentityTokenHeader); len(vs) > 0 {
+		return vs[0]
+	}
+	return ""
+}
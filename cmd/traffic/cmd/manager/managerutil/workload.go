@@ -0,0 +1,69 @@
+package managerutil
+
+import (
+	"context"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/datawire/ambassador/pkg/kates"
+	"github.com/telepresenceio/telepresence/v2/pkg/install"
+)
+
+// workloadKinds are the kinds that are tried, in order, when the kind of a workload is unknown
+var workloadKinds = []string{"Deployment", "StatefulSet", "ReplicaSet"}
+
+// controllerKinds are the kinds of the workloads that replace the ReplicaSets and the
+// ReplicationControllers that they control.
+var controllerKinds = map[string][]string{
+	"ReplicaSet":            {"Deployment", "Rollout"},
+	"ReplicationController": {"DeploymentConfig"},
+}
+
+// FindWorkload returns the workload with the given namespace, kind, and name. All workloadKinds are
+// tried when kind is empty, and a ReplicaSet that is controlled by a Deployment or an Argo Rollout,
+// or a ReplicationController that is controlled by a DeploymentConfig, is replaced by its
+// controller. The kind of a Knative Service is "KnativeService".
+//
+// An error that isn't a NotFound error takes precedence over the NotFound errors of the other kinds,
+// so that e.g. a workload that the caller isn't allowed to read isn't mistaken for one that doesn't
+// exist.
+func FindWorkload(ctx context.Context, client *kates.Client, namespace, kind, name string) (*kates.Unstructured, error) {
+	kinds := workloadKinds
+	if kind != "" {
+		kinds = []string{kind}
+	}
+	var err error
+	for _, k := range kinds {
+		wl := &kates.Unstructured{}
+		switch k {
+		case "DeploymentConfig":
+			wl.SetAPIVersion(install.DeploymentConfigAPIVersion)
+			wl.SetKind(k)
+		case "Rollout":
+			wl.SetAPIVersion(install.ArgoRolloutAPIVersion)
+			wl.SetKind(k)
+		case "KnativeService":
+			wl.SetAPIVersion(install.KnativeServiceAPIVersion)
+			wl.SetKind("Service")
+		default:
+			wl.SetKind(k)
+		}
+		wl.SetNamespace(namespace)
+		wl.SetName(name)
+		if getErr := client.Get(ctx, wl, wl); getErr != nil {
+			if err == nil || kates.IsNotFound(err) {
+				err = getErr
+			}
+			continue
+		}
+		if owner := metav1.GetControllerOf(wl); owner != nil {
+			for _, ck := range controllerKinds[k] {
+				if owner.Kind == ck {
+					return FindWorkload(ctx, client, namespace, owner.Kind, owner.Name)
+				}
+			}
+		}
+		return wl, nil
+	}
+	return nil, err
+}
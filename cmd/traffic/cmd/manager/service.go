@@ -10,21 +10,36 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/pkg/errors"
+	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 	empty "google.golang.org/protobuf/types/known/emptypb"
 
 	"github.com/datawire/dlib/dlog"
 	rpc "github.com/telepresenceio/telepresence/rpc/v2/manager"
 	"github.com/telepresenceio/telepresence/rpc/v2/systema"
+	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/internal/auth"
 	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/internal/cluster"
+	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/internal/dns"
+	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/internal/events"
+	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/internal/policy"
 	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/internal/state"
 	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/managerutil"
+	"github.com/telepresenceio/telepresence/v2/pkg/compress"
 	"github.com/telepresenceio/telepresence/v2/pkg/connpool"
+	"github.com/telepresenceio/telepresence/v2/pkg/install"
 	"github.com/telepresenceio/telepresence/v2/pkg/iputil"
+	"github.com/telepresenceio/telepresence/v2/pkg/loglevel"
+	"github.com/telepresenceio/telepresence/v2/pkg/usage"
 	"github.com/telepresenceio/telepresence/v2/pkg/version"
 )
 
+// agentSessionTTL is how long the session of a traffic-agent survives without a heartbeat. The
+// sessions of clients survive for the ClientSessionTTL of the environment, but never shorter.
+const agentSessionTTL = 15 * time.Second
+
 // Clock is the mechanism used by the Manager state to get the current time.
 type Clock interface {
 	Now() time.Time
@@ -37,11 +52,25 @@ type Manager struct {
 	state       *state.State
 	systema     *systemaPool
 	clusterInfo cluster.Info
+	policy      *policy.File
+	auth        auth.Authenticator
+	agentAuth   auth.Authenticator
+	usage       *usage.Tracker
+	dns         *dns.Resolver
+
+	// requireMTLS is true when clients must connect using mutual TLS, see unaryMTLSInterceptor
+	requireMTLS bool
+
+	// certAuth is true when the certificates of clients that connect using mutual TLS identify
+	// them, see identify
+	certAuth bool
 
 	rpc.UnsafeManagerServer
+	rpc.UnsafeSessionsServer
 }
 
 var _ rpc.ManagerServer = &Manager{}
+var _ rpc.SessionsServer = &Manager{}
 
 type wall struct{}
 
@@ -55,15 +84,25 @@ func NewManager(ctx context.Context) *Manager {
 		clock:       wall{},
 		ID:          uuid.New().String(),
 		state:       state.NewState(ctx),
-		clusterInfo: cluster.NewInfo(ctx),
+		clusterInfo: cluster.NewInfo(loglevel.WithSubsystem(ctx, loglevel.K8sWatch)),
+	}
+	ret.usage = usage.NewTracker(ret.clock.Now())
+	if env := managerutil.GetEnv(ctx); env != nil {
+		ret.dns = dns.NewResolver(env.DNSCacheTTL, env.DNSNegativeCacheTTL, env.DNSOverrides, ret.clock.Now)
+	} else {
+		ret.dns = dns.NewResolver(0, 0, nil, ret.clock.Now)
 	}
 	ret.systema = NewSystemAPool(ret)
 	return ret
 }
 
-// Version returns the version information of the Manager.
-func (*Manager) Version(context.Context, *empty.Empty) (*rpc.VersionInfo2, error) {
-	return &rpc.VersionInfo2{Version: version.Version}, nil
+// Version returns the version information of the Manager, and whether it uses the identity of
+// the clients.
+func (m *Manager) Version(ctx context.Context, _ *empty.Empty) (*rpc.VersionInfo2, error) {
+	return &rpc.VersionInfo2{
+		Version:       version.Version,
+		WantsIdentity: authRequired(ctx) || m.policy.Policy(ctx) != nil,
+	}, nil
 }
 
 // GetLicense returns the license for the cluster. This directory is mounted
@@ -113,7 +152,47 @@ func (m *Manager) ArriveAsClient(ctx context.Context, client *rpc.ClientInfo) (*
 		return nil, status.Errorf(codes.InvalidArgument, val)
 	}
 
-	sessionID := m.state.AddClient(client, m.clock.Now())
+	// The identity has been verified by the auth interceptor, and is cached, when clients must
+	// authenticate. The identity of a certificate needs no verification.
+	var user string
+	token := managerutil.GetIdentityToken(ctx)
+	if authRequired(ctx) || token == "" {
+		id, err := m.identify(ctx)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case id != nil:
+			user = id.Username
+		case authRequired(ctx):
+			return nil, status.Errorf(codes.Unauthenticated, "traffic-manager requires clients to authenticate")
+		}
+	}
+
+	// The cluster may only call back to the ports that the client opted into, and that the
+	// traffic-manager is configured to serve
+	var ports []int32
+	if env := managerutil.GetEnv(ctx); env != nil {
+		ports = callbackPorts(env.ClientCallbackPorts, client.CallbackPorts)
+	}
+	sessionID := m.state.AddClientWithCallbackPorts(client, m.clock.Now(), ports)
+
+	// Remember who the user is, so that admins can tell who holds a session. The identity is only
+	// informational when clients needn't authenticate, so the arrival doesn't wait for it to be
+	// verified.
+	switch {
+	case user != "":
+		m.state.SetClientUser(sessionID, user)
+	case token != "":
+		go func() {
+			if id, err := m.authenticate(m.ctx, token); err == nil {
+				m.state.SetClientUser(sessionID, id.Username)
+			}
+		}()
+	}
+
+	// Tell the client that it may compress its traffic
+	_ = grpc.SetHeader(ctx, metadata.Pairs(compress.AcceptHeader, compress.Name))
 
 	return &rpc.SessionInfo{
 		SessionId: sessionID,
@@ -128,6 +207,14 @@ func (m *Manager) ArriveAsAgent(ctx context.Context, agent *rpc.AgentInfo) (*rpc
 		return nil, status.Errorf(codes.InvalidArgument, val)
 	}
 
+	// When mutual TLS is required, the traffic-agents are the only peers without a certificate, so
+	// anyone who claims to be one must prove it.
+	if authRequired(ctx) || m.requireMTLS {
+		if err := m.authenticateAgent(ctx, agent); err != nil {
+			return nil, err
+		}
+	}
+
 	sessionID := m.state.AddAgent(agent, m.clock.Now())
 
 	return &rpc.SessionInfo{SessionId: sessionID}, nil
@@ -150,7 +237,7 @@ func (m *Manager) Depart(ctx context.Context, session *rpc.SessionInfo) (*empty.
 	ctx = managerutil.WithSessionInfo(ctx, session)
 	dlog.Debug(ctx, "Depart called")
 
-	m.state.RemoveSession(session.GetSessionId())
+	m.recordRemoval(ctx, m.state.RemoveSession(session.GetSessionId()), false)
 
 	return &empty.Empty{}, nil
 }
@@ -276,7 +363,8 @@ func (m *Manager) CreateIntercept(ctx context.Context, ciReq *rpc.CreateIntercep
 	apiKey := ciReq.GetApiKey()
 	dlog.Debug(ctx, "CreateIntercept called")
 
-	if m.state.GetClient(sessionID) == nil {
+	client := m.state.GetClient(sessionID)
+	if client == nil {
 		return nil, status.Errorf(codes.NotFound, "Client session %q not found", sessionID)
 	}
 
@@ -284,7 +372,37 @@ func (m *Manager) CreateIntercept(ctx context.Context, ciReq *rpc.CreateIntercep
 		return nil, status.Errorf(codes.InvalidArgument, val)
 	}
 
-	return m.state.AddIntercept(sessionID, apiKey, spec)
+	if err := m.checkInterceptable(ctx, spec); err != nil {
+		return nil, err
+	}
+
+	if err := m.authorizeIntercept(ctx, spec); err != nil {
+		return nil, err
+	}
+
+	release, releasedBy, err := m.resolveConflicts(ctx, sessionID, client.Name, ciReq)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	ii, err := m.state.AddIntercept(sessionID, apiKey, spec)
+	if err == nil {
+		m.usage.AddIntercept(m.clock.Now(), client.Name, spec.Namespace, spec.Agent)
+		events.Emit(ctx, spec.Namespace, spec.WorkloadKind, spec.Agent, events.InterceptCreated,
+			"Intercept %q created by %s, routing %s traffic to %s:%d", spec.Name, client.Name, spec.Mechanism, spec.TargetHost, spec.TargetPort)
+		if duration := spec.GetDuration().AsDuration(); duration > 0 {
+			if expiring := m.state.SetInterceptExpiry(ii.Id, m.clock.Now().Add(duration)); expiring != nil {
+				ii = expiring
+			}
+		}
+		if len(releasedBy) > 0 {
+			// The intercept is shared with the state, so it's only the response that tells who was waited for
+			ii = proto.Clone(ii).(*rpc.InterceptInfo)
+			ii.ReleasedBy = releasedBy
+		}
+	}
+	return ii, err
 }
 
 func (m *Manager) UpdateIntercept(ctx context.Context, req *rpc.UpdateInterceptRequest) (*rpc.InterceptInfo, error) {
@@ -466,7 +584,13 @@ func (m *Manager) ClientTunnel(server rpc.Manager_ClientTunnelServer) error {
 	if err != nil {
 		return err
 	}
-	return m.state.ClientTunnel(managerutil.WithSessionInfo(server.Context(), sessionInfo), server)
+	ctx := loglevel.WithSubsystem(server.Context(), loglevel.Tunnel)
+	if client := m.state.GetClient(sessionInfo.SessionId); client != nil {
+		counter := m.usage.NewCounter(client.Name)
+		defer func() { m.usage.CloseCounter(m.clock.Now(), counter) }()
+		server = &countingTunnel{Manager_ClientTunnelServer: server, counter: counter}
+	}
+	return m.state.ClientTunnel(managerutil.WithSessionInfo(ctx, sessionInfo), server)
 }
 
 func (m *Manager) AgentTunnel(server rpc.Manager_AgentTunnelServer) error {
@@ -478,7 +602,8 @@ func (m *Manager) AgentTunnel(server rpc.Manager_AgentTunnelServer) error {
 	if err != nil {
 		return err
 	}
-	return m.state.AgentTunnel(managerutil.WithSessionInfo(server.Context(), agentSessionInfo), clientSessionInfo, server)
+	ctx := loglevel.WithSubsystem(server.Context(), loglevel.Tunnel)
+	return m.state.AgentTunnel(managerutil.WithSessionInfo(ctx, agentSessionInfo), clientSessionInfo, server)
 }
 
 func readTunnelSessionID(server connpool.TunnelStream) (*rpc.SessionInfo, error) {
@@ -497,10 +622,15 @@ func readTunnelSessionID(server connpool.TunnelStream) (*rpc.SessionInfo, error)
 }
 
 func (m *Manager) LookupHost(ctx context.Context, request *rpc.LookupHostRequest) (*rpc.LookupHostResponse, error) {
-	ctx = managerutil.WithSessionInfo(ctx, request.GetSession())
+	ctx = managerutil.WithSessionInfo(loglevel.WithSubsystem(ctx, loglevel.DNS), request.GetSession())
 	dlog.Debugf(ctx, "LookupHost called %s", request.Host)
 	sessionID := request.GetSession().GetSessionId()
 	response := &rpc.LookupHostResponse{}
+	if ips, ok := m.dns.Override(m.state.GetClient(sessionID).GetName(), request.Host); ok {
+		dlog.Debugf(ctx, "LookupHost response from override %s -> %s", request.Host, ips)
+		response.Ips = ips.BytesSlice()
+		return response, nil
+	}
 	ips, err := m.state.AgentsLookup(ctx, sessionID, request)
 	if err != nil {
 		return nil, err
@@ -513,17 +643,11 @@ func (m *Manager) LookupHost(ctx context.Context, request *rpc.LookupHostRequest
 
 	// Either we aren't intercepting any agents, or none of them was able to find the given host. Let's
 	// try from the manager too.
-	addrs, err := net.LookupHost(request.Host)
-	if err != nil {
-		response.Ips = [][]byte{}
+	if ips = m.dns.LookupHost(ctx, request.Host); len(ips) == 0 {
 		dlog.Debugf(ctx, "LookupHost response %s -> NOT FOUND", request.Host)
-		return response, nil
-	}
-	ips = make(iputil.IPs, len(addrs))
-	for i, addr := range addrs {
-		ips[i] = iputil.Parse(addr)
+	} else {
+		dlog.Debugf(ctx, "LookupHost response from manager %s -> %s", request.Host, ips)
 	}
-	dlog.Debugf(ctx, "LookupHost response from agents %s -> %s", request.Host, ips)
 	response.Ips = ips.BytesSlice()
 	return response, nil
 }
@@ -561,7 +685,37 @@ func (m *Manager) WatchClusterInfo(session *rpc.SessionInfo, stream rpc.Manager_
 	return m.clusterInfo.Watch(ctx, stream)
 }
 
-// expire removes stale sessions.
+// expire removes stale sessions and intercepts that have reached the end of their duration.
 func (m *Manager) expire() {
-	m.state.ExpireSessions(m.clock.Now().Add(-15 * time.Second))
+	now := m.clock.Now()
+	clientTTL := managerutil.GetEnv(m.ctx).ClientSessionTTL
+	if clientTTL < agentSessionTTL {
+		clientTTL = agentSessionTTL
+	}
+	for _, removed := range m.state.ExpireSessions(now.Add(-clientTTL), now.Add(-agentSessionTTL)) {
+		m.recordRemoval(m.ctx, removed, true)
+	}
+	for _, ii := range m.state.ExpireIntercepts(now) {
+		spec := ii.Spec
+		dlog.Infof(m.ctx, "Intercept %q of %s has reached the end of its duration", spec.Name, spec.Client)
+		events.Emit(m.ctx, spec.Namespace, spec.WorkloadKind, spec.Agent, events.InterceptTimedOut,
+			"Intercept %q of %s was removed because it reached the end of its duration", spec.Name, spec.Client)
+	}
+}
+
+// recordRemoval records Events on the workloads that were affected by the removal of a session.
+// Intercepts that are removed because the client departed are already known to that client, so
+// they are only recorded when the session expired.
+func (m *Manager) recordRemoval(ctx context.Context, removed state.RemovedSession, expired bool) {
+	if expired {
+		for _, ii := range removed.Intercepts {
+			spec := ii.Spec
+			events.Emit(ctx, spec.Namespace, spec.WorkloadKind, spec.Agent, events.InterceptExpired,
+				"Intercept %q expired because its client %s stopped sending heartbeats", spec.Name, spec.Client)
+		}
+	}
+	if agent := removed.Agent; agent != nil {
+		events.Emit(ctx, agent.Namespace, "", agent.Name, events.AgentRemoved,
+			"The last %s of %s has departed", install.AgentContainerName, agent.Name)
+	}
 }
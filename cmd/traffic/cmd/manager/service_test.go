@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"net"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"google.golang.org/grpc"
@@ -43,6 +44,7 @@ func TestConnect(t *testing.T) {
 	ver, err := client.Version(ctx, &empty.Empty{})
 	a.NoError(err)
 	a.Equal(version.Version, ver.Version)
+	a.False(ver.WantsIdentity, "the clients keep their credentials when there's neither auth nor a policy")
 
 	// Alice arrives and departs
 
@@ -271,6 +273,86 @@ func TestConnect(t *testing.T) {
 	a.Error(err)
 }
 
+func TestQueuedIntercept(t *testing.T) {
+	dlog.SetFallbackLogger(dlog.WrapTB(t, false))
+	ctx := dlog.NewTestContext(t, true)
+	a := assert.New(t)
+
+	testClients := testdata.GetTestClients(t)
+	testAgents := testdata.GetTestAgents(t)
+
+	conn := getTestClientConn(t)
+	defer conn.Close()
+	client := rpc.NewManagerClient(conn)
+
+	_, err := client.ArriveAsAgent(ctx, testAgents["hello"])
+	a.NoError(err)
+	aliceSess, err := client.ArriveAsClient(ctx, testClients["alice"])
+	a.NoError(err)
+	bobSess, err := client.ArriveAsClient(ctx, testClients["bob"])
+	a.NoError(err)
+
+	spec := func(name, clientName string) *rpc.InterceptSpec {
+		return &rpc.InterceptSpec{
+			Name:       name,
+			Namespace:  "default",
+			Client:     clientName,
+			Agent:      testAgents["hello"].Name,
+			Mechanism:  "tcp",
+			TargetHost: "asdf",
+			TargetPort: 9876,
+		}
+	}
+
+	_, err = client.CreateIntercept(ctx, &rpc.CreateInterceptRequest{
+		Session:       aliceSess,
+		InterceptSpec: spec("alice", testClients["alice"].Name),
+	})
+	a.NoError(err)
+
+	// Bob is refused unless he asks to be queued
+	_, err = client.CreateIntercept(ctx, &rpc.CreateInterceptRequest{
+		Session:       bobSess,
+		InterceptSpec: spec("bob", testClients["bob"].Name),
+	})
+	a.Error(err)
+
+	type result struct {
+		ii  *rpc.InterceptInfo
+		err error
+	}
+	bobCh := make(chan result, 1)
+	go func() {
+		ii, err := client.CreateIntercept(ctx, &rpc.CreateInterceptRequest{
+			Session:       bobSess,
+			InterceptSpec: spec("bob", testClients["bob"].Name),
+			Queue:         true,
+		})
+		bobCh <- result{ii, err}
+	}()
+
+	select {
+	case <-bobCh:
+		t.Fatal("the queued intercept was created while the workload was held")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	_, err = client.RemoveIntercept(ctx, &rpc.RemoveInterceptRequest2{
+		Session: aliceSess,
+		Name:    "alice",
+	})
+	a.NoError(err)
+
+	select {
+	case r := <-bobCh:
+		if a.NoError(r.err) {
+			a.Equal([]string{testClients["alice"].Name}, r.ii.ReleasedBy)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the queued intercept wasn't created when the workload was released")
+	}
+}
+
 func getTestClientConn(t *testing.T) *grpc.ClientConn {
 	const bufsize = 64 * 1024
 	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, true))
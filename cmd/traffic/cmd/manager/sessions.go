@@ -0,0 +1,63 @@
+package manager
+
+import (
+	"context"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	empty "google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/datawire/dlib/dlog"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/internal/events"
+)
+
+// ListSessions lists the connected clients, who they are, when they connected, and what they
+// intercept. Only admins may list the sessions.
+func (m *Manager) ListSessions(ctx context.Context, _ *empty.Empty) (*rpc.ClientSessions, error) {
+	if _, err := m.authorizeAdmin(ctx, "list the client sessions"); err != nil {
+		return nil, err
+	}
+	css := m.state.GetClientSessions()
+	sessions := make([]*rpc.ClientSession, len(css))
+	for i, cs := range css {
+		cepts := make([]string, len(cs.Intercepts))
+		for j, cept := range cs.Intercepts {
+			cepts[j] = cept.Spec.Name
+		}
+		sessions[i] = &rpc.ClientSession{
+			SessionId:  cs.SessionID,
+			Name:       cs.Client.Name,
+			User:       cs.User,
+			Version:    cs.Client.Version,
+			Connected:  timestamppb.New(cs.Since),
+			Intercepts: cepts,
+		}
+	}
+	return &rpc.ClientSessions{Sessions: sessions}, nil
+}
+
+// KickSession removes the session of a client along with its intercepts, so that a stale client
+// can't keep holding a workload. The client will find that its session is gone the next time it
+// calls the traffic-manager. Only admins may kick a session.
+func (m *Manager) KickSession(ctx context.Context, session *rpc.SessionInfo) (*empty.Empty, error) {
+	admin, err := m.authorizeAdmin(ctx, "kick client sessions")
+	if err != nil {
+		return nil, err
+	}
+	sessionID := session.GetSessionId()
+	client := m.state.GetClient(sessionID)
+	if client == nil {
+		return nil, status.Errorf(codes.NotFound, "Client session %q not found", sessionID)
+	}
+	dlog.Infof(ctx, "admin %q kicks the session of %s", admin, client.Name)
+	removed := m.state.RemoveSession(sessionID)
+	for _, ii := range removed.Intercepts {
+		spec := ii.Spec
+		events.Emit(ctx, spec.Namespace, spec.WorkloadKind, spec.Agent, events.InterceptKicked,
+			"Intercept %q was removed because %s kicked the session of its client %s", spec.Name, admin, spec.Client)
+	}
+	m.recordRemoval(ctx, removed, false)
+	return &empty.Empty{}, nil
+}
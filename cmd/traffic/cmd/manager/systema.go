@@ -73,12 +73,17 @@ func (m *Manager) DialIntercept(ctx context.Context, interceptID string) (net.Co
 
 	dialAddr := fmt.Sprintf("%s:%d", ingressInfo.Host, ingressInfo.Port)
 	if ingressInfo.UseTls {
+		policy, err := managerutil.GetEnv(ctx).TLSPolicy()
+		if err != nil {
+			return nil, err
+		}
 		dialer := &tls.Dialer{
 			Config: &tls.Config{
 				InsecureSkipVerify: true,
 				ServerName:         ingressInfo.L5Host,
 			},
 		}
+		policy.Apply(dialer.Config)
 		dlog.Debugf(ctx, "HandleConnection: dialing intercept %s using TLS on %s", interceptID, dialAddr)
 		return dialer.DialContext(ctx, "tcp", dialAddr)
 	}
@@ -113,11 +118,17 @@ func (p *systemaPool) Get() (systemarpc.SystemACRUDClient, error) {
 		env := managerutil.GetEnv(p.mgr.ctx)
 		host := env.SystemAHost
 		port := env.SystemAPort
+		policy, err := env.TLSPolicy()
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig := &tls.Config{ServerName: host}
+		policy.Apply(tlsConfig)
 
 		ctx, cancel := context.WithCancel(dgroup.WithGoroutineName(p.mgr.ctx, "/systema"))
 		client, wait, err := systema.ConnectToSystemA(
 			ctx, p.mgr, net.JoinHostPort(host, port),
-			grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{ServerName: host})),
+			grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)),
 			grpc.WithPerRPCCredentials(&systemaCredentials{p.mgr}))
 		if err != nil {
 			cancel()
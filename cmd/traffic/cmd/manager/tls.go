@@ -0,0 +1,226 @@
+package manager
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/datawire/dlib/dhttp"
+	"github.com/datawire/dlib/dlog"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/managerutil"
+	"github.com/telepresenceio/telepresence/v2/pkg/connpool"
+	"github.com/telepresenceio/telepresence/v2/pkg/dnet"
+	"github.com/telepresenceio/telepresence/v2/pkg/install"
+	"github.com/telepresenceio/telepresence/v2/pkg/tlspolicy"
+)
+
+// The secret install.ManagerTLSName is mounted here when it exists. It uses the same keys as
+// a cert-manager issued certificate.
+const (
+	mtlsDir      = `/var/run/secrets/manager-tls`
+	mtlsCAFile   = `ca.crt`
+	mtlsCertFile = `tls.crt`
+	mtlsKeyFile  = `tls.key`
+)
+
+// mtlsEnabled returns true when the files needed to serve gRPC using mutual TLS are present.
+func mtlsEnabled() bool {
+	for _, f := range []string{mtlsCAFile, mtlsCertFile, mtlsKeyFile} {
+		if _, err := os.Stat(filepath.Join(mtlsDir, f)); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// mtlsServerConfig returns the TLS config of a server that requires that clients present a
+// certificate signed by the mounted CA.
+func mtlsServerConfig(ctx context.Context) (*tls.Config, error) {
+	caPem, err := ioutil.ReadFile(filepath.Join(mtlsDir, mtlsCAFile))
+	if err != nil {
+		return nil, err
+	}
+	caPool := x509.NewCertPool()
+	if !caPool.AppendCertsFromPEM(caPem) {
+		return nil, fmt.Errorf("no valid certificates found in %s", filepath.Join(mtlsDir, mtlsCAFile))
+	}
+	policy, err := managerutil.GetEnv(ctx).TLSPolicy()
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  caPool,
+	}
+	policy.Apply(tlsConfig)
+	return tlsConfig, nil
+}
+
+// serveMTLS serves the given handler on install.ManagerPortHTTPS using TLS, requiring that
+// clients present a certificate signed by the mounted CA.
+func serveMTLS(ctx context.Context, host string, handler http.Handler) error {
+	if !mtlsEnabled() {
+		dlog.Infof(ctx, "%s is not present so mutual TLS is disabled", mtlsDir)
+		return nil
+	}
+	tlsConfig, err := mtlsServerConfig(ctx)
+	if err != nil {
+		return err
+	}
+	sc := &dhttp.ServerConfig{
+		Handler:   handler,
+		TLSConfig: tlsConfig,
+	}
+	addr := host + ":" + strconv.Itoa(install.ManagerPortHTTPS)
+	dlog.Infof(ctx, "Serving gRPC with mutual TLS on %s", addr)
+	return sc.ListenAndServeTLS(ctx, addr, filepath.Join(mtlsDir, mtlsCertFile), filepath.Join(mtlsDir, mtlsKeyFile))
+}
+
+// serveQUIC serves QUIC tunnels to install.ManagerPortHTTPS on the given UDP port, so that the
+// TLS handshake of the mutual TLS port is made end to end, just like it is through the WebSocket
+// tunnels. The QUIC handshake uses the same certificates, so only clients that can use mutual TLS
+// can open a tunnel. Nothing is served when the port is empty, when mutual TLS is disabled, or in
+// FIPS mode, because the TLS of QUIC isn't the FIPS validated TLS of the Go runtime.
+func serveQUIC(ctx context.Context, host, port string) error {
+	if port == "" {
+		return nil
+	}
+	if !mtlsEnabled() {
+		dlog.Infof(ctx, "%s is not present so QUIC tunnels are disabled", mtlsDir)
+		return nil
+	}
+	if env := managerutil.GetEnv(ctx); env.TLSFIPS || tlspolicy.FIPSBuild {
+		dlog.Info(ctx, "QUIC tunnels are disabled in FIPS mode")
+		return nil
+	}
+	tlsConfig, err := mtlsServerConfig(ctx)
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(filepath.Join(mtlsDir, mtlsCertFile), filepath.Join(mtlsDir, mtlsKeyFile))
+	if err != nil {
+		return err
+	}
+	tlsConfig.Certificates = []tls.Certificate{cert}
+	addr := net.JoinHostPort(host, port)
+	dlog.Infof(ctx, "Serving QUIC tunnels on %s", addr)
+	return dnet.ServeQUICTunnel(ctx, addr, tlsConfig, net.JoinHostPort(host, strconv.Itoa(install.ManagerPortHTTPS)))
+}
+
+// isMTLSPeer returns true if the caller of the current gRPC call was verified using mutual TLS.
+func isMTLSPeer(ctx context.Context) bool {
+	return peerCertificate(ctx) != nil
+}
+
+// peerCertificate returns the certificate that the caller of the current gRPC call was verified
+// with using mutual TLS, or nil if it wasn't.
+func peerCertificate(ctx context.Context) *x509.Certificate {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	ti, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(ti.State.VerifiedChains) == 0 || len(ti.State.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return ti.State.VerifiedChains[0][0]
+}
+
+// agentMethods are the methods that the traffic-agents call. The traffic-agents don't use mutual
+// TLS, so when it's enabled, these and the unauthenticatedMethods are the only methods that may be
+// called by peers that weren't verified using mutual TLS, and only with the session of an agent.
+// Such sessions are only given to traffic-agents that present the token of their ServiceAccount.
+var agentMethods = map[string]bool{
+	"/telepresence.manager.Manager/CanConnectAmbassadorCloud": true,
+	"/telepresence.manager.Manager/Remain":                    true,
+	"/telepresence.manager.Manager/Depart":                    true,
+	"/telepresence.manager.Manager/WatchIntercepts":           true,
+	"/telepresence.manager.Manager/ReviewIntercept":           true,
+	"/telepresence.manager.Manager/WatchLookupHost":           true,
+	"/telepresence.manager.Manager/AgentLookupHostResponse":   true,
+	"/telepresence.manager.Manager/AgentTunnel":               true,
+}
+
+// errMTLSRequired is returned to clients that call methods that require mutual TLS without it
+var errMTLSRequired = status.Error(codes.Unauthenticated, "traffic-manager requires clients to connect using mutual TLS")
+
+// unaryMTLSInterceptor refuses the unary calls of clients that weren't verified using mutual TLS
+// when mutual TLS is enabled.
+func (m *Manager) unaryMTLSInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if m.requireMTLS && !isMTLSPeer(ctx) {
+		if !unauthenticatedMethods[info.FullMethod] && !agentMethods[info.FullMethod] {
+			return nil, errMTLSRequired
+		}
+		if err := m.checkAgentSession(req); err != nil {
+			return nil, err
+		}
+	}
+	return handler(ctx, req)
+}
+
+// streamMTLSInterceptor refuses the streaming calls of clients that weren't verified using mutual
+// TLS when mutual TLS is enabled.
+func (m *Manager) streamMTLSInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if m.requireMTLS && !isMTLSPeer(ss.Context()) {
+		if !unauthenticatedMethods[info.FullMethod] && !agentMethods[info.FullMethod] {
+			return errMTLSRequired
+		}
+		ss = &agentStream{ServerStream: ss, m: m}
+	}
+	return handler(srv, ss)
+}
+
+// checkAgentSession checks that the session of the given message, if any, is the session of a
+// traffic-agent.
+func (m *Manager) checkAgentSession(msg interface{}) error {
+	var session *rpc.SessionInfo
+	switch msg := msg.(type) {
+	case *rpc.SessionInfo:
+		session = msg
+	case interface{ GetSession() *rpc.SessionInfo }:
+		session = msg.GetSession()
+	case *rpc.ConnMessage:
+		if ctrl, ok := connpool.FromConnMessage(msg).(connpool.Control); ok {
+			session = ctrl.SessionInfo()
+		}
+	default:
+		return nil
+	}
+	if m.state.GetAgent(session.GetSessionId()) == nil {
+		return errMTLSRequired
+	}
+	return nil
+}
+
+// agentStream is a stream of a peer that wasn't verified using mutual TLS. It checks that the first
+// message that it receives has the session of a traffic-agent.
+type agentStream struct {
+	grpc.ServerStream
+	m        *Manager
+	received bool
+}
+
+func (s *agentStream) RecvMsg(msg interface{}) error {
+	if err := s.ServerStream.RecvMsg(msg); err != nil {
+		return err
+	}
+	if !s.received {
+		s.received = true
+		return s.m.checkAgentSession(msg)
+	}
+	return nil
+}
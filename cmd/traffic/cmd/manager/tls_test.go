@@ -0,0 +1,171 @@
+package manager
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	empty "google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/datawire/dlib/dhttp"
+	"github.com/datawire/dlib/dlog"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/internal/auth"
+	testdata "github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/internal/test"
+	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/managerutil"
+)
+
+const mtlsHeader = "x-test-mtls"
+
+// asMTLSPeer makes the callers that pass the mtlsHeader look like peers that were verified using
+// mutual TLS, with a certificate whose common name is the value of the header.
+func asMTLSPeer(ctx context.Context) context.Context {
+	if md, ok := metadata.FromIncomingContext(ctx); ok && len(md.Get(mtlsHeader)) > 0 {
+		cert := &x509.Certificate{Subject: pkix.Name{CommonName: md.Get(mtlsHeader)[0], Organization: []string{"developers"}}}
+		state := tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+		ctx = peer.NewContext(ctx, &peer.Peer{AuthInfo: credentials.TLSInfo{State: state}})
+	}
+	return ctx
+}
+
+func withMTLS(ctx context.Context, commonName string) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, mtlsHeader, commonName)
+}
+
+type mtlsStream struct {
+	grpc.ServerStream
+}
+
+func (s mtlsStream) Context() context.Context {
+	return asMTLSPeer(s.ServerStream.Context())
+}
+
+// mtlsTestServer serves a traffic-manager that requires mutual TLS, and returns it along with a
+// connection to it.
+func mtlsTestServer(t *testing.T) (*Manager, *grpc.ClientConn) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, true))
+	ctx = managerutil.WithEnv(ctx, &managerutil.Env{})
+
+	m := NewManager(ctx)
+	m.requireMTLS = true
+	m.agentAuth = tokens{
+		"hello-token": {Username: "system:serviceaccount:default:hello", Method: auth.MethodTokenReview},
+	}
+	s := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(
+			func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+				return handler(asMTLSPeer(ctx), req)
+			},
+			m.unaryMTLSInterceptor, m.unaryAuthInterceptor),
+		grpc.ChainStreamInterceptor(
+			func(srv interface{}, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+				return handler(srv, mtlsStream{ss})
+			},
+			m.streamMTLSInterceptor, m.streamAuthInterceptor))
+	rpc.RegisterManagerServer(s, m)
+	rpc.RegisterSessionsServer(s, m)
+
+	lis := bufconn.Listen(64 * 1024)
+	errCh := make(chan error)
+	go func() {
+		sc := &dhttp.ServerConfig{Handler: s}
+		errCh <- sc.Serve(ctx, lis)
+		close(errCh)
+	}()
+	conn, err := grpc.DialContext(ctx, "bufnet", grpc.WithInsecure(),
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }))
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		conn.Close()
+		cancel()
+		if err := <-errCh; err != nil && err != ctx.Err() {
+			t.Error(err)
+		}
+	})
+	return m, conn
+}
+
+func TestMTLSInterceptors(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	_, conn := mtlsTestServer(t)
+	client := rpc.NewManagerClient(conn)
+	mtlsCtx := withMTLS(ctx, "telepresence-client")
+	alice := testdata.GetTestClients(t)["alice"]
+
+	_, err := client.Version(ctx, &empty.Empty{})
+	require.NoError(t, err, "the version is available to all")
+
+	_, err = client.ArriveAsClient(ctx, alice)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = client.GetCloudConfig(ctx, &empty.Empty{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err), "all the calls of clients require mutual TLS")
+	clientSess, err := client.ArriveAsClient(mtlsCtx, alice)
+	require.NoError(t, err)
+	_, err = client.GetCloudConfig(mtlsCtx, &empty.Empty{})
+	assert.NoError(t, err)
+
+	// The traffic-agents don't use mutual TLS, so they must present the token of their
+	// ServiceAccount, and may only use the sessions of agents
+	_, err = client.ArriveAsAgent(ctx, testdata.GetTestAgents(t)["hello"])
+	assert.Equal(t, codes.Unauthenticated, status.Code(err), "a peer without a certificate can't pose as an agent")
+	agentSess, err := client.ArriveAsAgent(withToken(ctx, "hello-token"), testdata.GetTestAgents(t)["hello"])
+	require.NoError(t, err)
+	_, err = client.Remain(ctx, &rpc.RemainRequest{Session: agentSess})
+	assert.NoError(t, err)
+	_, err = client.Remain(ctx, &rpc.RemainRequest{Session: clientSess})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = client.Remain(mtlsCtx, &rpc.RemainRequest{Session: clientSess})
+	assert.NoError(t, err)
+
+	// The streaming calls are checked too
+	wi, err := client.WatchIntercepts(ctx, clientSess)
+	require.NoError(t, err)
+	_, err = wi.Recv()
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	wi, err = client.WatchIntercepts(ctx, agentSess)
+	require.NoError(t, err)
+	_, err = wi.Recv()
+	assert.NoError(t, err)
+	wa, err := client.WatchAgents(ctx, clientSess)
+	require.NoError(t, err)
+	_, err = wa.Recv()
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	wa, err = client.WatchAgents(mtlsCtx, clientSess)
+	require.NoError(t, err)
+	_, err = wa.Recv()
+	assert.NoError(t, err)
+}
+
+func TestCertificateIdentity(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	m, conn := mtlsTestServer(t)
+	m.certAuth = true
+	m.policy = policyFile(t, "admins:\n  users: [alice]\n")
+	sessions := rpc.NewSessionsClient(conn)
+
+	_, err := sessions.ListSessions(withMTLS(ctx, "alice"), &empty.Empty{})
+	assert.NoError(t, err, "the common name of the certificate is the user")
+	_, err = sessions.ListSessions(withMTLS(ctx, "bob"), &empty.Empty{})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	m.policy = policyFile(t, "admins:\n  groups: [developers]\n")
+	_, err = sessions.ListSessions(withMTLS(ctx, "bob"), &empty.Empty{})
+	assert.NoError(t, err, "the organizations of the certificate are the groups")
+
+	// Certificates only identify clients when the certificate method is enabled
+	m.certAuth = false
+	_, err = sessions.ListSessions(withMTLS(ctx, "bob"), &empty.Empty{})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
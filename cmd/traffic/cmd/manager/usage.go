@@ -0,0 +1,138 @@
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/datawire/ambassador/pkg/kates"
+	"github.com/datawire/dlib/dcontext"
+	"github.com/datawire/dlib/dlog"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/managerutil"
+	"github.com/telepresenceio/telepresence/v2/pkg/usage"
+)
+
+// usageConfigMap is the name of the ConfigMap in the namespace of the traffic-manager where the
+// usage is saved, so that it's retained when the traffic-manager restarts.
+const usageConfigMap = "traffic-manager-usage"
+
+// usageSaveInterval is how often the usage is saved.
+const usageSaveInterval = time.Minute
+
+// serveUsage responds with the usage report. The optional "since" query parameter is a duration
+// that limits the report to recent usage. Only admins according to the intercept policy may get the
+// report. They identify themselves using the same token as in the gRPC calls, passed in the
+// managerutil.IdentityTokenHeader header.
+func (m *Manager) serveUsage(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if token := r.Header.Get(managerutil.IdentityTokenHeader); token != "" {
+		ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(managerutil.IdentityTokenHeader, token))
+	}
+	if _, err := m.authorizeAdmin(ctx, "get the usage report"); err != nil {
+		code := http.StatusForbidden
+		if status.Code(err) == codes.Unauthenticated {
+			code = http.StatusUnauthorized
+		}
+		http.Error(w, status.Convert(err).Message(), code)
+		return
+	}
+
+	now := m.clock.Now()
+	var since time.Time
+	if s := r.URL.Query().Get("since"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		since = now.Add(-d)
+	}
+	data, err := json.Marshal(m.usage.Report(now, since))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(data)
+}
+
+// countingTunnel counts the volume of a client's tunnel for the usage tracker.
+type countingTunnel struct {
+	rpc.Manager_ClientTunnelServer
+	counter *usage.Counter
+}
+
+func (t *countingTunnel) Send(msg *rpc.ConnMessage) error {
+	err := t.Manager_ClientTunnelServer.Send(msg)
+	if err == nil {
+		t.counter.AddReceived(len(msg.Payload))
+	}
+	return err
+}
+
+func (t *countingTunnel) Recv() (*rpc.ConnMessage, error) {
+	msg, err := t.Manager_ClientTunnelServer.Recv()
+	if err == nil {
+		t.counter.AddSent(len(msg.Payload))
+	}
+	return msg, err
+}
+
+// runUsage loads the usage that an earlier traffic-manager saved, and then prunes and saves the
+// usage periodically until the given context is cancelled.
+func (m *Manager) runUsage(ctx context.Context) error {
+	client := managerutil.GetKatesClient(ctx)
+	if client == nil {
+		return nil
+	}
+	ns := managerutil.GetEnv(ctx).ManagerNamespace
+	cm := &kates.ConfigMap{
+		TypeMeta:   kates.TypeMeta{Kind: "ConfigMap"},
+		ObjectMeta: kates.ObjectMeta{Name: usageConfigMap, Namespace: ns},
+	}
+	exists := false
+	if err := client.Get(ctx, cm, cm); err == nil {
+		exists = true
+		if err = m.usage.Load([]byte(cm.Data["usage"])); err != nil {
+			dlog.Errorf(ctx, "unable to load the usage from ConfigMap %s.%s: %v", usageConfigMap, ns, err)
+		}
+	} else if !kates.IsNotFound(err) {
+		dlog.Errorf(ctx, "unable to load the usage from ConfigMap %s.%s: %v", usageConfigMap, ns, err)
+	}
+
+	save := func(ctx context.Context) {
+		now := m.clock.Now()
+		m.usage.Prune(now)
+		data, err := m.usage.Save(now)
+		if err != nil || exists && string(data) == cm.Data["usage"] {
+			return
+		}
+		cm.Data = map[string]string{"usage": string(data)}
+		if exists {
+			err = client.Update(ctx, cm, cm)
+		} else if err = client.Create(ctx, cm, cm); err == nil {
+			exists = true
+		}
+		if err != nil {
+			dlog.Errorf(ctx, "unable to save the usage in ConfigMap %s.%s: %v", usageConfigMap, ns, err)
+		}
+	}
+
+	ticker := time.NewTicker(usageSaveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			save(ctx)
+		case <-ctx.Done():
+			save(dcontext.WithoutCancel(ctx))
+			return nil
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package manager
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/managerutil"
+	"github.com/telepresenceio/telepresence/v2/pkg/usage"
+)
+
+func TestServeUsage(t *testing.T) {
+	m, _ := authTestServer(t)
+	m.policy = policyFile(t, "admins:\n  users: [alice]\n")
+	m.usage.AddIntercept(m.clock.Now(), "bob", "default", "echo")
+
+	get := func(token string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/usage?since=1h", nil)
+		if token != "" {
+			r.Header.Set(managerutil.IdentityTokenHeader, token)
+		}
+		w := httptest.NewRecorder()
+		m.serveUsage(w, r)
+		return w
+	}
+
+	assert.Equal(t, http.StatusForbidden, get("").Code, "the report requires an identity")
+	assert.Equal(t, http.StatusUnauthorized, get("bad-token").Code)
+	assert.Equal(t, http.StatusForbidden, get("bob-token").Code, "only admins may get the report")
+
+	w := get("alice-token")
+	require.Equal(t, http.StatusOK, w.Code)
+	var r usage.Report
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &r))
+	assert.Equal(t, []usage.InterceptCount{{User: "bob", Namespace: "default", Workload: "echo", Count: 1}}, r.Intercepts)
+}
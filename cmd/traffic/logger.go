@@ -7,15 +7,22 @@ import (
 	"github.com/sirupsen/logrus"
 
 	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/loglevel"
 )
 
 func makeBaseLogger() dlog.Logger {
 	logrusLogger := logrus.New()
-	logrusFormatter := &logrus.TextFormatter{
-		TimestampFormat: "2006-01-02 15:04:05.0000",
-		FullTimestamp:   true,
+	var logrusFormatter logrus.Formatter
+	if os.Getenv("LOG_FORMAT") == "json" {
+		logrusFormatter = &logrus.JSONFormatter{
+			TimestampFormat: "2006-01-02T15:04:05.000000Z07:00",
+		}
+	} else {
+		logrusFormatter = &logrus.TextFormatter{
+			TimestampFormat: "2006-01-02 15:04:05.0000",
+			FullTimestamp:   true,
+		}
 	}
-	logrusLogger.SetFormatter(logrusFormatter)
 	logrusLogger.SetReportCaller(true)
 
 	const defaultLogLevel = logrus.InfoLevel
@@ -35,7 +42,14 @@ func makeBaseLogger() dlog.Logger {
 		logLevelMessage += fmt.Sprintf(" (LOG_LEVEL=%q)", logLevelStr)
 	}
 
-	logrusLogger.SetLevel(logLevel)
+	// LOG_LEVELS overrides the level of individual subsystems, e.g. "dns=debug,tunnel=warn"
+	subsystems, err := loglevel.Parse(os.Getenv("LOG_LEVELS"))
+	if err != nil {
+		logLevelMessage += fmt.Sprintf(" (LOG_LEVELS ignored: %v)", err)
+	}
+	ff := &loglevel.FilteringFormatter{Formatter: logrusFormatter, Level: logLevel, Subsystems: subsystems}
+	logrusLogger.SetFormatter(ff)
+	logrusLogger.SetLevel(ff.MaxLevel())
 	logrusLogger.Log(logLevel, logLevelMessage)
 
 	return dlog.WrapLogrus(logrusLogger)
@@ -6,11 +6,6 @@ class Telepresence < Formula
   url "https://app.getambassador.io/download/tel2/darwin/amd64/__NEW_VERSION__/telepresence"
   sha256 "__TARBALL_HASH__"
 
-  # macfuse is a cask and formula can't depend on casks, so we can't actually
-  # do this. This is probably fine since you don't _need_ macfuse to run
-  # the cli, just to do mounts 
-  #depends_on "macfuse"
-
   def install
     bin.install "telepresence"
   end
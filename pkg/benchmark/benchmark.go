@@ -0,0 +1,289 @@
+// Package benchmark measures the data path of a connected Telepresence session, i.e. the
+// throughput of the TUN device, the latency of the tunnel and of DNS lookups, and the rate of
+// requests that an intercepted service can serve. The measurements can be compared to a baseline
+// so that a change that makes the data path slower can be detected.
+package benchmark
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Config tells what to measure. A measurement is skipped when the target that it needs is empty.
+type Config struct {
+	// Host is a name that is resolved by the DNS lookup measurement
+	Host string
+
+	// Addr is a host:port that the tunnel latency measurement connects to
+	Addr string
+
+	// URL is fetched repeatedly by the throughput measurement. It should return a large body.
+	URL string
+
+	// InterceptURL is requested by the request rate measurement. It should be served by an
+	// intercepted service.
+	InterceptURL string
+
+	// Samples is the number of DNS lookups and connects to make
+	Samples int
+
+	// Duration is how long the throughput and request rate are measured
+	Duration time.Duration
+
+	// Concurrency is the number of concurrent requests that the request rate measurement makes
+	Concurrency int
+}
+
+// Result is one measured value.
+type Result struct {
+	Name           string  `json:"name"`
+	Unit           string  `json:"unit"`
+	Value          float64 `json:"value"`
+	HigherIsBetter bool    `json:"higherIsBetter,omitempty"`
+}
+
+// Latency is a summary of a number of samples.
+type Latency struct {
+	Min  time.Duration
+	Mean time.Duration
+	P50  time.Duration
+	P99  time.Duration
+	Max  time.Duration
+}
+
+func latencyOf(samples []time.Duration) Latency {
+	if len(samples) == 0 {
+		return Latency{}
+	}
+	sorted := make([]time.Duration, len(samples))
+	copy(sorted, samples)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	var sum time.Duration
+	for _, s := range sorted {
+		sum += s
+	}
+	percentile := func(p int) time.Duration {
+		return sorted[(len(sorted)-1)*p/100]
+	}
+	return Latency{
+		Min:  sorted[0],
+		Mean: sum / time.Duration(len(sorted)),
+		P50:  percentile(50),
+		P99:  percentile(99),
+		Max:  sorted[len(sorted)-1],
+	}
+}
+
+func (l *Latency) results(name string) []Result {
+	ms := func(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+	return []Result{
+		{Name: name + ".p50", Unit: "ms", Value: ms(l.P50)},
+		{Name: name + ".p99", Unit: "ms", Value: ms(l.P99)},
+	}
+}
+
+// Run performs the measurements that the given config has targets for.
+func Run(ctx context.Context, cfg *Config) ([]Result, error) {
+	var results []Result
+	if cfg.Host != "" {
+		l, err := DNSLookup(ctx, cfg.Host, cfg.Samples)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, l.results("dns.lookup")...)
+	}
+	if cfg.Addr != "" {
+		l, err := Dial(ctx, cfg.Addr, cfg.Samples)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, l.results("tunnel.connect")...)
+	}
+	if cfg.URL != "" {
+		bps, err := Throughput(ctx, cfg.URL, cfg.Duration)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, Result{Name: "tun.throughput", Unit: "MiB/s", Value: bps / (1024 * 1024), HigherIsBetter: true})
+	}
+	if cfg.InterceptURL != "" {
+		rps, err := RequestRate(ctx, cfg.InterceptURL, cfg.Concurrency, cfg.Duration)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, Result{Name: "intercept.rate", Unit: "req/s", Value: rps, HigherIsBetter: true})
+	}
+	if len(results) == 0 {
+		return nil, errors.New("nothing to measure")
+	}
+	return results, nil
+}
+
+// DNSLookup resolves the given host n times and returns the latency of the lookups.
+func DNSLookup(ctx context.Context, host string, n int) (Latency, error) {
+	samples := make([]time.Duration, n)
+	for i := range samples {
+		start := time.Now()
+		if _, err := net.DefaultResolver.LookupHost(ctx, host); err != nil {
+			return Latency{}, fmt.Errorf("lookup of %s failed: %w", host, err)
+		}
+		samples[i] = time.Since(start)
+	}
+	return latencyOf(samples), nil
+}
+
+// Dial connects to the given address n times and returns the latency of the connects. A connect to
+// a cluster address is a round trip through the tunnel to the traffic-manager, which in turn
+// connects to the address.
+func Dial(ctx context.Context, addr string, n int) (Latency, error) {
+	samples := make([]time.Duration, n)
+	dialer := net.Dialer{}
+	for i := range samples {
+		start := time.Now()
+		conn, err := dialer.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return Latency{}, fmt.Errorf("connect to %s failed: %w", addr, err)
+		}
+		samples[i] = time.Since(start)
+		_ = conn.Close()
+	}
+	return latencyOf(samples), nil
+}
+
+// newHTTPClient returns a client that doesn't share connections with other clients, and that
+// doesn't ask for compressed responses since that would make them smaller than what's tunneled.
+func newHTTPClient(concurrency int) *http.Client {
+	return &http.Client{Transport: &http.Transport{
+		DisableCompression:  true,
+		MaxIdleConnsPerHost: concurrency,
+	}}
+}
+
+// Throughput fetches the given URL repeatedly during the given duration and returns the number of
+// bytes per second that were received.
+func Throughput(ctx context.Context, url string, d time.Duration) (float64, error) {
+	hc := newHTTPClient(1)
+	defer hc.CloseIdleConnections()
+	ctx, cancel := context.WithTimeout(ctx, d)
+	defer cancel()
+
+	var total int64
+	start := time.Now()
+	for ctx.Err() == nil {
+		n, err := fetch(ctx, hc, url)
+		total += n
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			return 0, err
+		}
+	}
+	return float64(total) / time.Since(start).Seconds(), nil
+}
+
+// RequestRate requests the given URL using the given number of concurrent requests during the given
+// duration, and returns the number of successful requests per second.
+func RequestRate(ctx context.Context, url string, concurrency int, d time.Duration) (float64, error) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	hc := newHTTPClient(concurrency)
+	defer hc.CloseIdleConnections()
+	ctx, cancel := context.WithTimeout(ctx, d)
+	defer cancel()
+
+	var count int64
+	var firstErr error
+	var errOnce sync.Once
+	wg := sync.WaitGroup{}
+	wg.Add(concurrency)
+	start := time.Now()
+	for i := 0; i < concurrency; i++ {
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				if _, err := fetch(ctx, hc, url); err != nil {
+					if ctx.Err() == nil {
+						errOnce.Do(func() { firstErr = err })
+						cancel()
+					}
+					return
+				}
+				atomic.AddInt64(&count, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return 0, firstErr
+	}
+	return float64(count) / time.Since(start).Seconds(), nil
+}
+
+// fetch performs a GET request and discards the body. The number of bytes in the body is returned.
+func fetch(ctx context.Context, hc *http.Client, url string) (int64, error) {
+	rq, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := hc.Do(rq)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(ioutil.Discard, resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		return n, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return n, fmt.Errorf("%s responded with %s", url, resp.Status)
+	}
+	return n, nil
+}
+
+// Regression is a result that is worse than its baseline by more than the tolerance.
+type Regression struct {
+	Baseline Result
+	Current  Result
+}
+
+func (r *Regression) String() string {
+	return fmt.Sprintf("%s regressed from %.2f %s to %.2f %s", r.Current.Name, r.Baseline.Value, r.Baseline.Unit, r.Current.Value, r.Current.Unit)
+}
+
+// Compare returns the current results that are worse than the baseline result with the same name by
+// more than the given tolerance, which is a fraction of the baseline value. Results that lack a
+// baseline are ignored.
+func Compare(baseline, current []Result, tolerance float64) []Regression {
+	bm := make(map[string]Result, len(baseline))
+	for _, b := range baseline {
+		bm[b.Name] = b
+	}
+	var regressions []Regression
+	for _, c := range current {
+		b, ok := bm[c.Name]
+		if !ok || b.Unit != c.Unit {
+			continue
+		}
+		var worse bool
+		if c.HigherIsBetter {
+			worse = c.Value < b.Value*(1-tolerance)
+		} else {
+			worse = c.Value > b.Value*(1+tolerance)
+		}
+		if worse {
+			regressions = append(regressions, Regression{Baseline: b, Current: c})
+		}
+	}
+	return regressions
+}
@@ -0,0 +1,129 @@
+package benchmark
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The benchmarks measure the data path of a session that is connected to a cluster, e.g. a kind
+// cluster. Each benchmark is skipped unless the environment variable that names its target is set:
+//
+//	TELEPRESENCE_BENCHMARK_HOST           a name to resolve, e.g. "echo.default"
+//	TELEPRESENCE_BENCHMARK_ADDR           a host:port to connect to, e.g. "echo.default:80"
+//	TELEPRESENCE_BENCHMARK_URL            a URL that returns a large body
+//	TELEPRESENCE_BENCHMARK_INTERCEPT_URL  a URL that is served by an intercepted service
+func benchmarkTarget(b *testing.B, env string) string {
+	target := os.Getenv(env)
+	if target == "" {
+		b.Skipf("%s is not set", env)
+	}
+	return target
+}
+
+func BenchmarkDNSLookup(b *testing.B) {
+	host := benchmarkTarget(b, "TELEPRESENCE_BENCHMARK_HOST")
+	_, err := DNSLookup(context.Background(), host, b.N)
+	require.NoError(b, err)
+}
+
+func BenchmarkTunnelConnect(b *testing.B) {
+	addr := benchmarkTarget(b, "TELEPRESENCE_BENCHMARK_ADDR")
+	_, err := Dial(context.Background(), addr, b.N)
+	require.NoError(b, err)
+}
+
+func BenchmarkTUNThroughput(b *testing.B) {
+	url := benchmarkTarget(b, "TELEPRESENCE_BENCHMARK_URL")
+	ctx := context.Background()
+	hc := newHTTPClient(1)
+	defer hc.CloseIdleConnections()
+	n, err := fetch(ctx, hc, url)
+	require.NoError(b, err)
+	b.SetBytes(n)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err = fetch(ctx, hc, url)
+		require.NoError(b, err)
+	}
+}
+
+func BenchmarkInterceptRequest(b *testing.B) {
+	url := benchmarkTarget(b, "TELEPRESENCE_BENCHMARK_INTERCEPT_URL")
+	ctx := context.Background()
+	hc := newHTTPClient(8)
+	defer hc.CloseIdleConnections()
+	b.SetParallelism(8)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := fetch(ctx, hc, url); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+func TestLatencyOf(t *testing.T) {
+	var samples []time.Duration
+	for i := 100; i > 0; i-- {
+		samples = append(samples, time.Duration(i)*time.Millisecond)
+	}
+	l := latencyOf(samples)
+	assert.Equal(t, time.Millisecond, l.Min)
+	assert.Equal(t, 100*time.Millisecond, l.Max)
+	assert.Equal(t, 50*time.Millisecond, l.P50)
+	assert.Equal(t, 99*time.Millisecond, l.P99)
+	assert.Equal(t, 50500*time.Microsecond, l.Mean)
+	assert.Equal(t, Latency{}, latencyOf(nil))
+}
+
+func TestCompare(t *testing.T) {
+	baseline := []Result{
+		{Name: "dns.lookup.p50", Unit: "ms", Value: 10},
+		{Name: "tunnel.connect.p50", Unit: "ms", Value: 20},
+		{Name: "tun.throughput", Unit: "MiB/s", Value: 100, HigherIsBetter: true},
+		{Name: "intercept.rate", Unit: "req/s", Value: 500, HigherIsBetter: true},
+	}
+	current := []Result{
+		{Name: "dns.lookup.p50", Unit: "ms", Value: 11},
+		{Name: "tunnel.connect.p50", Unit: "ms", Value: 30},
+		{Name: "tun.throughput", Unit: "MiB/s", Value: 70, HigherIsBetter: true},
+		{Name: "intercept.rate", Unit: "req/s", Value: 900, HigherIsBetter: true},
+		{Name: "tunnel.connect.p99", Unit: "ms", Value: 1000},
+	}
+	regressions := Compare(baseline, current, 0.2)
+	require.Len(t, regressions, 2)
+	assert.Equal(t, "tunnel.connect.p50", regressions[0].Current.Name)
+	assert.Equal(t, "tun.throughput", regressions[1].Current.Name)
+	assert.Empty(t, Compare(baseline, current, 0.5))
+}
+
+func TestRequestRate(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write(make([]byte, 1024))
+	}))
+	defer srv.Close()
+	ctx := context.Background()
+
+	rps, err := RequestRate(ctx, srv.URL, 4, 200*time.Millisecond)
+	require.NoError(t, err)
+	assert.Greater(t, rps, 0.0)
+
+	bps, err := Throughput(ctx, srv.URL, 200*time.Millisecond)
+	require.NoError(t, err)
+	assert.Greater(t, bps, 0.0)
+
+	_, err = RequestRate(ctx, srv.URL+"/fail", 4, 200*time.Millisecond)
+	assert.Error(t, err)
+}
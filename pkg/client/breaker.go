@@ -0,0 +1,117 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/datawire/dlib/dlog"
+)
+
+const breakerWindow = time.Minute
+
+// Breaker limits how often the streams and watches that depend on one server are re-established
+// after they fail. Each stream waits for its own, jittered and exponentially growing, delay between
+// its attempts, but when the network flaps, all the streams fail over and over again, and
+// together they may reconnect hundreds of times per minute and trip the rate limits of the server.
+// The breaker therefore counts the reconnects of all the streams, and when more than
+// Reconnect.MaxPerMinute of them happen within a minute, it opens and holds all reconnects back
+// until the Reconnect.Cooldown has passed.
+//
+// A nil Breaker never holds anything back.
+type Breaker struct {
+	ctx          context.Context
+	server       string
+	maxPerMinute int
+	cooldown     time.Duration
+
+	mu         sync.Mutex
+	reconnects []time.Time // the reconnects within the last minute, oldest first
+	openUntil  time.Time
+}
+
+// NewBreaker returns a Breaker for the reconnects to the given server, configured by the
+// Reconnect of the config.yml.
+func NewBreaker(ctx context.Context, server string) *Breaker {
+	rc := GetConfig(ctx).Reconnect
+	return &Breaker{
+		ctx:          ctx,
+		server:       server,
+		maxPerMinute: rc.MaxPerMinute,
+		cooldown:     rc.Cooldown,
+	}
+}
+
+// Wait counts a reconnect and returns when it may proceed, which is right away unless the breaker
+// is open. The reconnects that are held back are released at random times during the first
+// quarter of the cooldown that follows, so that they don't all happen at once.
+func (b *Breaker) Wait(ctx context.Context) error {
+	if b == nil {
+		return nil
+	}
+	for {
+		d := b.reserve(time.Now())
+		if d == 0 {
+			return nil
+		}
+		timer := time.NewTimer(d + time.Duration(rand.Int63n(int64(b.cooldown/4)+1)))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// reserve counts a reconnect at the given time and returns zero, or, if the breaker is open, how
+// long it stays open.
+func (b *Breaker) reserve(now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.openUntil.IsZero() {
+		if now.Before(b.openUntil) {
+			return b.openUntil.Sub(now)
+		}
+		b.openUntil = time.Time{}
+		dlog.Infof(b.ctx, "Reconnects to %s are no longer held back", b.server)
+	}
+
+	i := 0
+	for i < len(b.reconnects) && now.Sub(b.reconnects[i]) >= breakerWindow {
+		i++
+	}
+	b.reconnects = b.reconnects[i:]
+	if b.maxPerMinute <= 0 || len(b.reconnects) < b.maxPerMinute {
+		b.reconnects = append(b.reconnects, now)
+		return 0
+	}
+	dlog.Warnf(b.ctx, "%d reconnects to %s within a minute, holding reconnects back for %s",
+		len(b.reconnects), b.server, b.cooldown)
+	b.reconnects = nil
+	b.openUntil = now.Add(b.cooldown)
+	return b.cooldown
+}
+
+// OpenReason returns an empty string unless the breaker is open, in which case it returns why.
+func (b *Breaker) OpenReason() string {
+	if b == nil {
+		return ""
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	d := time.Until(b.openUntil)
+	if d <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("too many reconnects to %s, reconnects are held back for another %s",
+		b.server, d.Round(time.Second))
+}
+
+// Jitter returns a random duration between half the given duration and the given duration.
+func Jitter(d time.Duration) time.Duration {
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(d-half)+1))
+}
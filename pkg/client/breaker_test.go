@@ -0,0 +1,53 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/datawire/dlib/dlog"
+)
+
+func TestBreaker(t *testing.T) {
+	b := &Breaker{
+		ctx:          dlog.NewTestContext(t, false),
+		server:       "the traffic-manager",
+		maxPerMinute: 3,
+		cooldown:     30 * time.Second,
+	}
+	now := time.Now()
+
+	// Three reconnects within a minute are let through, the fourth opens the breaker
+	for i := 0; i < 3; i++ {
+		assert.Zero(t, b.reserve(now.Add(time.Duration(i)*time.Second)))
+	}
+	assert.Equal(t, 30*time.Second, b.reserve(now.Add(3*time.Second)))
+	assert.Equal(t, 20*time.Second, b.reserve(now.Add(13*time.Second)))
+
+	// The breaker closes when the cooldown has passed, and counts anew
+	now = now.Add(33 * time.Second)
+	for i := 0; i < 3; i++ {
+		assert.Zero(t, b.reserve(now))
+	}
+
+	// Reconnects that are older than a minute don't count
+	now = now.Add(time.Minute)
+	for i := 0; i < 3; i++ {
+		assert.Zero(t, b.reserve(now))
+	}
+
+	// A nil breaker never holds anything back
+	var nb *Breaker
+	assert.NoError(t, nb.Wait(b.ctx))
+	assert.Empty(t, nb.OpenReason())
+}
+
+func TestJitter(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		d := Jitter(time.Second)
+		assert.GreaterOrEqual(t, int64(d), int64(500*time.Millisecond))
+		assert.LessOrEqual(t, int64(d), int64(time.Second))
+	}
+	assert.Zero(t, Jitter(0))
+}
@@ -0,0 +1,36 @@
+package cache
+
+import (
+	"context"
+	"os"
+)
+
+const proxyFile = "proxy.json"
+
+type proxyInfo struct {
+	SOCKSAddress string `json:"socksAddress"`
+}
+
+// SaveProxyAddressToUserCache records the address of the SOCKS5 proxy of a connector that runs in
+// proxy mode, so that the CLI can direct the commands that it starts to it.
+func SaveProxyAddressToUserCache(ctx context.Context, addr string) error {
+	return SaveToUserCache(ctx, &proxyInfo{SOCKSAddress: addr}, proxyFile)
+}
+
+// LoadProxyAddressFromUserCache returns the address of the SOCKS5 proxy, or an empty string when
+// no proxy address is recorded.
+func LoadProxyAddressFromUserCache(ctx context.Context) (string, error) {
+	var pi proxyInfo
+	if err := LoadFromUserCache(ctx, &pi, proxyFile); err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return "", err
+	}
+	return pi.SOCKSAddress, nil
+}
+
+// DeleteProxyAddressFromUserCache removes the recorded address of the SOCKS5 proxy, if any.
+func DeleteProxyAddressFromUserCache(ctx context.Context) error {
+	return DeleteFromUserCache(ctx, proxyFile)
+}
@@ -0,0 +1,79 @@
+package cache
+
+import (
+	"context"
+	"os"
+	"time"
+)
+
+const sessionFile = "session.json"
+
+// SessionState is a snapshot of the session that the user daemon has with the traffic-manager. The
+// user daemon keeps it up to date in the user cache so that "telepresence status" and scripts can
+// tell what the session was, even when the user daemon can't be reached. The state is kept with
+// Ended set when the session ends normally, so a state that hasn't ended while the user daemon
+// can't be reached means that the user daemon died.
+type SessionState struct {
+	// PID is the process ID of the user daemon that owns the session
+	PID            int              `json:"pid"`
+	SessionID      string           `json:"sessionId"`
+	ClusterID      string           `json:"clusterId,omitempty"`
+	ClusterServer  string           `json:"clusterServer,omitempty"`
+	ClusterContext string           `json:"clusterContext,omitempty"`
+	Connected      time.Time        `json:"connected"`
+	Updated        time.Time        `json:"updated"`
+	Ended          bool             `json:"ended,omitempty"`
+	Intercepts     []InterceptState `json:"intercepts,omitempty"`
+}
+
+// InterceptState is an intercept of a SessionState
+type InterceptState struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace,omitempty"`
+	Workload  string `json:"workload,omitempty"`
+	Port      string `json:"port,omitempty"`
+
+	// MountPoint and Mount are set when the intercept mounts the remote volumes
+	MountPoint string      `json:"mountPoint,omitempty"`
+	Mount      *MountState `json:"mount,omitempty"`
+}
+
+// The health of a remote volume mount
+const (
+	MountMounting     = "mounting"
+	MountHealthy      = "healthy"
+	MountUnresponsive = "unresponsive"
+	MountDisconnected = "disconnected"
+)
+
+// MountState is the health of the remote volume mount of an intercept, as last seen by the user
+// daemon that monitors it.
+type MountState struct {
+	Health string    `json:"health"`
+	Since  time.Time `json:"since"`
+
+	// Error is the reason why the mount isn't healthy
+	Error string `json:"error,omitempty"`
+
+	// Remounts is the number of attempts to mount the volumes again after the mount was lost or
+	// couldn't be made
+	Remounts int `json:"remounts,omitempty"`
+}
+
+// SaveSessionToUserCache saves the given session state to the user cache.
+func SaveSessionToUserCache(ctx context.Context, ss *SessionState) error {
+	return SaveToUserCache(ctx, ss, sessionFile)
+}
+
+// LoadSessionFromUserCache loads the session state from the user cache. Nil is returned if the
+// user daemon has never saved one.
+func LoadSessionFromUserCache(ctx context.Context) (*SessionState, error) {
+	var ss SessionState
+	if err := LoadFromUserCache(ctx, &ss, sessionFile); err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return nil, err
+	}
+	return &ss, nil
+}
@@ -8,7 +8,6 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
-	"syscall"
 	"time"
 
 	//nolint:depguard // Because we won't ever .Wait() for the process and we'd turn off
@@ -29,15 +28,17 @@ import (
 
 var ErrNoConnector = errors.New("telepresence user daemon is not running")
 
-func launchConnector() error {
+func launchConnector(ctx context.Context) error {
+	if client.HasSocketDialer(ctx) {
+		return fmt.Errorf("the socket dialer doesn't serve %s, and no connector is launched for it", client.ConnectorSocketName)
+	}
 	args := []string{client.GetExe(), "connector-foreground"}
+	args = append(args, daemonFlags(ctx)...)
 
 	cmd := exec.Command(args[0], args[1:]...)
 	// Process must live in a process group of its own to prevent
 	// getting affected by <ctrl-c> in the terminal
-	cmd.SysProcAttr = &syscall.SysProcAttr{
-		Setpgid: true,
-	}
+	cmd.SysProcAttr = NewProcessGroupAttr()
 
 	if err := cmd.Start(); err != nil {
 		return fmt.Errorf("%s: %w", logging.ShellString(args[0], args[1:]), err)
@@ -67,6 +68,14 @@ func WithStartedConnector(ctx context.Context, fn func(context.Context, connecto
 	return withConnector(ctx, false, fn)
 }
 
+// WithConnectorConn is like WithConnector but passes the connection to the connector, for the
+// services of the connector other than the Connector service.
+func WithConnectorConn(ctx context.Context, fn func(context.Context, grpc.ClientConnInterface) error) error {
+	return WithConnector(ctx, func(ctx context.Context, _ connector.ConnectorClient) error {
+		return fn(ctx, ctx.Value(connectorConnCtxKey{}).(*grpc.ClientConn))
+	})
+}
+
 type connectorConnCtxKey struct{}
 type connectorStartedCtxKey struct{}
 
@@ -88,7 +97,7 @@ func withConnector(ctx context.Context, maybeStart bool, fn func(context.Context
 		if errors.Is(err, os.ErrNotExist) {
 			err = ErrNoConnector
 			if maybeStart {
-				if err := launchConnector(); err != nil {
+				if err := launchConnector(ctx); err != nil {
 					return fmt.Errorf("failed to launch the connector service: %w", err)
 				}
 
@@ -6,7 +6,6 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
-	"syscall"
 	"time"
 
 	//nolint:depguard // Because we won't ever .Wait() for the process and we'd turn off
@@ -16,7 +15,6 @@ import (
 	"google.golang.org/grpc"
 	empty "google.golang.org/protobuf/types/known/emptypb"
 
-	"github.com/datawire/dlib/dexec"
 	"github.com/telepresenceio/telepresence/rpc/v2/daemon"
 	"github.com/telepresenceio/telepresence/v2/pkg/client"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/logging"
@@ -25,7 +23,37 @@ import (
 
 var ErrNoDaemon = errors.New("telepresence root daemon is not running")
 
+type debugCtxKey struct{}
+
+// WithDebug returns a context that makes the daemons launched using it serve pprof and a dump of
+// their internal state on their debug sockets.
+func WithDebug(ctx context.Context) context.Context {
+	return context.WithValue(ctx, debugCtxKey{}, true)
+}
+
+func debugEnabled(ctx context.Context) bool {
+	enabled, _ := ctx.Value(debugCtxKey{}).(bool)
+	return enabled
+}
+
+// daemonFlags returns the flags that make a launched daemon use the debug setting and the timeout
+// overrides of this process. The overrides are passed as flags, because sudo may not preserve the
+// environment.
+func daemonFlags(ctx context.Context) []string {
+	var flags []string
+	if debugEnabled(ctx) {
+		flags = append(flags, "--debug")
+	}
+	for _, override := range client.GetConfig(ctx).Timeouts.Overrides() {
+		flags = append(flags, "--timeout="+override)
+	}
+	return flags
+}
+
 func launchDaemon(ctx context.Context, dnsIP string) error {
+	if client.HasSocketDialer(ctx) {
+		return fmt.Errorf("the socket dialer doesn't serve %s, and no daemon is launched for it", client.DaemonSocketName)
+	}
 	fmt.Println("Launching Telepresence Daemon", client.DisplayVersion())
 
 	// Ensure that the logfile is present before the daemon starts so that it isn't created with
@@ -55,42 +83,15 @@ func launchDaemon(ctx context.Context, dnsIP string) error {
 	}
 
 	args := []string{client.GetExe(), "daemon-foreground", logDir, configDir, dnsIP}
-	if os.Geteuid() != 0 {
-		// If we're going to be prompting for the `sudo` password, we want to first provide
-		// the user with some info about exactly what we're prompting for.  We don't want to
-		// use `sudo`'s `--prompt` flag for this because (1) we don't want it to be
-		// re-displayed if they typo their password, and (2) it might be ignored anyway
-		// depending on `passprompt_override` in `/etc/sudoers`.  So we'll do a pre-flight
-		// `sudo --non-interactive true` to decide whether to display it.
-		//
-		// Note: Using `sudo --non-interactive --validate` does not work well in situations
-		// where the user has configured `myuser ALL=(ALL:ALL) NOPASSWD: ALL` in the sudoers
-		// file. Hence the use of `sudo --non-interactive true`. A plausible cause can be
-		// found in the first comment here:
-		// https://unix.stackexchange.com/questions/50584/why-sudo-timestamp-is-not-updated-when-nopasswd-is-set
-		needPwCmd := dexec.CommandContext(ctx, "sudo", "--non-interactive", "true")
-		needPwCmd.DisableLogging = true
-		if err := needPwCmd.Run(); err != nil {
-			fmt.Printf("Need root privileges to run: %s\n", logging.ShellString(args[0], args[1:]))
-			// `sudo` won't be able to read the password from the terminal when we run
-			// it with Setpgid=true, so do a pre-flight `sudo --validate` to read the
-			// password, and then enforce that being re-used by passing
-			// `--non-interactive`.
-			pwCmd := dexec.CommandContext(ctx, "sudo", "--validate")
-			pwCmd.DisableLogging = true
-			if err := pwCmd.Run(); err != nil {
-				return err
-			}
-		}
-		args = append([]string{"sudo", "--non-interactive", "--preserve-env"}, args...)
+	args = append(args, daemonFlags(ctx)...)
+	if args, err = rootDaemonArgs(ctx, args); err != nil {
+		return err
 	}
 
 	cmd := exec.Command(args[0], args[1:]...)
 	// Process must live in a process group of its own to prevent
 	// getting affected by <ctrl-c> in the terminal
-	cmd.SysProcAttr = &syscall.SysProcAttr{
-		Setpgid: true,
-	}
+	cmd.SysProcAttr = NewProcessGroupAttr()
 
 	if err := cmd.Start(); err != nil {
 		return fmt.Errorf("%s: %w", logging.ShellString(args[0], args[1:]), err)
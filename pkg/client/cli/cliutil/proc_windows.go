@@ -0,0 +1,23 @@
+package cliutil
+
+import (
+	"context"
+	"errors"
+	"syscall"
+
+	"golang.org/x/sys/windows"
+)
+
+// NewProcessGroupAttr returns the attributes that make a launched process live in a process group
+// of its own, detached from the console, so that it isn't affected by <ctrl-c> in the terminal.
+func NewProcessGroupAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{
+		CreationFlags: windows.CREATE_NEW_PROCESS_GROUP | windows.DETACHED_PROCESS,
+	}
+}
+
+// rootDaemonArgs returns an error, because there's no root daemon on Windows. The user daemon
+// reaches the cluster through the proxy of the daemon container instead.
+func rootDaemonArgs(_ context.Context, _ []string) ([]string, error) {
+	return nil, errors.New("the root daemon isn't available on windows, use \"telepresence connect --proxy-via-container\"")
+}
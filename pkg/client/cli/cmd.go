@@ -11,7 +11,6 @@ import (
 
 	"github.com/datawire/ambassador/pkg/kates"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/connector"
-	"github.com/telepresenceio/telepresence/v2/pkg/client/daemon"
 )
 
 var help = `Telepresence can connect to a cluster and route all outbound traffic from your
@@ -30,12 +29,19 @@ Telepresence uses background processes to manage the cluster session. One of
 the processes runs with superuser privileges because it modifies the network.
 Unless the daemons are already started, an attempt will be made to start them.
 This will involve a call to sudo unless this command is run as root (not
-recommended) which in turn may result in a password prompt.`
+recommended) which in turn may result in a password prompt.
+
+A command that isn't one of the commands listed below is run as a plugin, i.e.
+"telepresence foo" runs an executable named "telepresence-foo" that is found in
+the PATH.`
 
 // TODO: Provide a link in the help text to more info about telepresence
 
 // global options
 var dnsIP string
+var debugDaemons bool
+var timeouts timeoutOverrides
+var noReport bool
 var mappedNamespaces []string
 var kubeFlags *pflag.FlagSet
 var kubeConfig *kates.ConfigFlags
@@ -56,6 +62,9 @@ func OnlySubcommands(cmd *cobra.Command, args []string) error {
 		}
 	}
 	if len(args) != 0 {
+		if !cmd.HasParent() && findPlugin(args[0]) != "" {
+			return nil
+		}
 		err := fmt.Errorf("invalid subcommand %q", args[0])
 
 		if cmd.SuggestionsMinimumDistance <= 0 {
@@ -75,6 +84,12 @@ func OnlySubcommands(cmd *cobra.Command, args []string) error {
 // run, because otherwise cobra will treat that as "success", and it shouldn't be "success" if the
 // user typos a command and types something invalid.
 func RunSubcommands(cmd *cobra.Command, args []string) error {
+	// A plugin gets all of the arguments that follow its name, including --help.
+	if len(args) > 0 && !cmd.HasParent() {
+		if path := findPlugin(args[0]); path != "" {
+			return runPlugin(cmd, path, args[1:])
+		}
+	}
 	cmd.SetOut(cmd.ErrOrStderr())
 
 	// determine if --help was explicitly asked for
@@ -135,15 +150,18 @@ func Command(ctx context.Context) *cobra.Command {
 
 	// Hidden/internal commands. These are called by Telepresence itself from
 	// the correct context and execute in-place immediately.
-	rootCmd.AddCommand(daemon.Command())
+	addRootDaemonCommands(rootCmd)
 	rootCmd.AddCommand(connector.Command())
+	rootCmd.AddCommand(dockerGatewayCommand())
+	rootCmd.AddCommand(kubeAuthForegroundCommand())
+	rootCmd.AddCommand(kubeAuthCommand())
 
 	globalFlagGroups = []FlagGroup{
 		{
 			Name: "Kubernetes flags",
 			Flags: func() *pflag.FlagSet {
 				kubeFlags = pflag.NewFlagSet("", 0)
-				kubeConfig := kates.NewConfigFlags(false)
+				kubeConfig = kates.NewConfigFlags(false)
 				kubeConfig.Namespace = nil // some of the subcommands, like "connect", don't take --namespace
 				kubeConfig.AddFlags(kubeFlags)
 				return kubeFlags
@@ -175,9 +193,18 @@ func Command(ctx context.Context) *cobra.Command {
 		Name: "other Telepresence flags",
 		Flags: func() *pflag.FlagSet {
 			flags := pflag.NewFlagSet("", 0)
-			flags.Bool(
+			flags.BoolVar(&noReport,
 				"no-report", false,
-				"turn off anonymous crash reports and log submission on failure",
+				"turn off the upload of crash reports to the crashReports.uploadURL of the config",
+			)
+			flags.BoolVar(&debugDaemons,
+				"debug", false,
+				"make daemons started by this command serve pprof and a dump of their state on their debug sockets",
+			)
+			timeouts = timeoutOverrides{ctx: ctx}
+			flags.Var(&timeouts,
+				"timeout",
+				"override a timeout of the config for this command and the daemons that it starts, e.g. --timeout intercept=30s",
 			)
 			return flags
 		}(),
@@ -191,13 +218,14 @@ func Command(ctx context.Context) *cobra.Command {
 		},
 		{
 			Name:     "Traffic Commands",
-			Commands: []*cobra.Command{listCommand(), interceptCommand(ctx), leaveCommand(), previewCommand()},
+			Commands: []*cobra.Command{listCommand(), interceptCommand(ctx), interceptJobCommand(ctx), installCommand(ctx), leaveCommand(), previewCommand(), runSpecCommand(), runCommand(), curlCommand(), forwardCommand(), envCommand(), restartCommand(), propagationCommand()},
 		},
 		{
 			Name:     "Other Commands",
-			Commands: []*cobra.Command{versionCommand(), uninstallCommand(), dashboardCommand(), ClusterIdCommand()},
+			Commands: []*cobra.Command{versionCommand(), diagnoseCommand(), logsCommand(), debugCommand(), explainRouteCommand(), gatherLogsCommand(), gatherTracesCommand(), uninstallCommand(), dashboardCommand(), ClusterIdCommand(), rbacCommand(), sessionsCommand(), manifestsCommand(), configCommand(), usageCommand()},
 		},
 	})
+	rootCmd.AddCommand(benchmarkCommand())
 	for _, group := range globalFlagGroups {
 		rootCmd.PersistentFlags().AddFlagSet(group.Flags)
 	}
@@ -0,0 +1,94 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/benchmark"
+)
+
+func benchmarkCommand() *cobra.Command {
+	cfg := benchmark.Config{}
+	var save, baseline string
+	var tolerance float64
+	cmd := &cobra.Command{
+		Use:    "benchmark",
+		Args:   cobra.NoArgs,
+		Hidden: true,
+
+		Short: "Measure the performance of the connection to the cluster",
+		Long: `Measure the latency of DNS lookups and of connects through the tunnel, the
+throughput of the TUN device, and the request rate of an intercepted service.
+Telepresence must be connected, and each measurement is skipped unless its
+target is given.
+
+The results can be saved using --save and later compared to using --baseline.
+The command fails when a result is worse than its baseline by more than the
+tolerance, so it can be used as a performance regression gate.`,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			results, err := benchmark.Run(cmd.Context(), &cfg)
+			if err != nil {
+				return err
+			}
+			printBenchmarkResults(cmd.OutOrStdout(), results)
+			if save != "" {
+				data, err := json.MarshalIndent(results, "", "  ")
+				if err != nil {
+					return err
+				}
+				if err = ioutil.WriteFile(save, data, 0644); err != nil {
+					return err
+				}
+			}
+			if baseline == "" {
+				return nil
+			}
+			data, err := ioutil.ReadFile(baseline)
+			if err != nil {
+				return err
+			}
+			var baseResults []benchmark.Result
+			if err = json.Unmarshal(data, &baseResults); err != nil {
+				return fmt.Errorf("unable to parse baseline %s: %w", baseline, err)
+			}
+			regressions := benchmark.Compare(baseResults, results, tolerance)
+			if len(regressions) == 0 {
+				return nil
+			}
+			msgs := make([]string, len(regressions))
+			for i := range regressions {
+				msgs[i] = regressions[i].String()
+			}
+			return errors.New(strings.Join(msgs, "\n"))
+		},
+	}
+	flags := cmd.Flags()
+	flags.StringVar(&cfg.Host, "host", "", "A cluster name to resolve, e.g. echo.default")
+	flags.StringVar(&cfg.Addr, "addr", "", "A cluster host:port to connect to, e.g. echo.default:80")
+	flags.StringVar(&cfg.URL, "url", "", "A cluster URL that returns a large body, used to measure throughput")
+	flags.StringVar(&cfg.InterceptURL, "intercept-url", "", "A URL of an intercepted service, used to measure the request rate")
+	flags.IntVar(&cfg.Samples, "samples", 50, "The number of DNS lookups and connects to make")
+	flags.DurationVar(&cfg.Duration, "duration", 10*time.Second, "How long to measure throughput and request rate")
+	flags.IntVar(&cfg.Concurrency, "concurrency", 8, "The number of concurrent requests to the intercepted service")
+	flags.StringVar(&save, "save", "", "Save the results as JSON to the given file")
+	flags.StringVar(&baseline, "baseline", "", "Compare the results to those saved in the given file")
+	flags.Float64Var(&tolerance, "tolerance", 0.2, "How much worse than its baseline a result may be, as a fraction of the baseline")
+	return cmd
+}
+
+func printBenchmarkResults(out io.Writer, results []benchmark.Result) {
+	tw := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "MEASUREMENT\tVALUE")
+	for _, r := range results {
+		fmt.Fprintf(tw, "%s\t%.2f %s\n", r.Name, r.Value, r.Unit)
+	}
+	_ = tw.Flush()
+}
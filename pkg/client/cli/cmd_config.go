@@ -0,0 +1,70 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+)
+
+func configCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:  "config",
+		Args: cobra.NoArgs,
+
+		Short: "Inspect the telepresence config",
+	}
+	cmd.AddCommand(configValidateCommand())
+	return cmd
+}
+
+func configValidateCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:  "validate [<file> ...]",
+		Args: cobra.ArbitraryArgs,
+
+		Short: "Report unknown keys and invalid values in config files",
+		Long: "Report unknown keys and invalid values in the given config files, or in the config " +
+			"files that telepresence reads when no file is given. Telepresence ignores such keys and " +
+			"values when it loads the config. The command fails when a problem is found.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+			files := args
+			if len(files) == 0 {
+				var err error
+				if files, err = client.ConfigFiles(ctx); err != nil {
+					return err
+				}
+				if len(files) == 0 {
+					fmt.Fprintf(cmd.OutOrStdout(), "No config file found, %s is used when it exists\n", client.GetConfigFile(ctx))
+					return nil
+				}
+			}
+			out := cmd.OutOrStdout()
+			problems := 0
+			for _, file := range files {
+				issues, err := client.ValidateConfigFile(ctx, file)
+				for _, issue := range issues {
+					fmt.Fprintln(out, issue)
+				}
+				problems += len(issues)
+				if err != nil {
+					fmt.Fprintln(out, err)
+					problems++
+				} else if len(issues) == 0 {
+					fmt.Fprintf(out, "file %s: OK\n", file)
+				}
+			}
+			switch problems {
+			case 0:
+				return nil
+			case 1:
+				return errors.New("found 1 problem")
+			default:
+				return fmt.Errorf("found %d problems", problems)
+			}
+		},
+	}
+}
@@ -0,0 +1,63 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/fake"
+	"github.com/telepresenceio/telepresence/v2/pkg/filelocation"
+)
+
+func fakeContext(t *testing.T, conn *fake.Connector, dmn *fake.Daemon) context.Context {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	t.Cleanup(cancel)
+	ctx = filelocation.WithAppUserConfigDir(ctx, t.TempDir())
+	ctx = filelocation.WithAppUserLogDir(ctx, t.TempDir())
+	return fake.WithDaemons(ctx, conn, dmn)
+}
+
+func execute(ctx context.Context, args ...string) (string, error) {
+	cmd := Command(ctx)
+	out := &bytes.Buffer{}
+	cmd.SetOut(out)
+	cmd.SetErr(out)
+	cmd.SetArgs(args)
+	err := cmd.ExecuteContext(ctx)
+	return out.String(), err
+}
+
+func TestConnectAndList(t *testing.T) {
+	conn := &fake.Connector{
+		ClusterContext: "test",
+		Workloads: map[string][]*connector.WorkloadInfo{
+			"default": {
+				{Name: "echo", WorkloadResourceType: "Deployment"},
+			},
+		},
+	}
+	ctx := fakeContext(t, conn, &fake.Daemon{})
+
+	out, err := execute(ctx, "connect")
+	require.NoError(t, err)
+	assert.Contains(t, out, "Connected to context test")
+	assert.True(t, conn.Connected())
+
+	out, err = execute(ctx, "list")
+	require.NoError(t, err)
+	assert.Contains(t, out, "echo")
+	assert.Contains(t, conn.Calls(), "List")
+}
+
+func TestConnectWithoutDaemons(t *testing.T) {
+	// No daemon processes are launched for the sockets that the fakes don't serve
+	ctx := fakeContext(t, nil, nil)
+	_, err := execute(ctx, "connect")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to launch the daemon service")
+}
@@ -0,0 +1,62 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/debug"
+)
+
+func debugCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:  "debug <connector|daemon> [state|pprof/<profile>]",
+		Args: cobra.RangeArgs(1, 2),
+
+		Short: "Fetch a state dump or a profile from a daemon started with --debug",
+		Long: `Fetch a state dump or a profile from a daemon started with --debug and write it to
+stdout. The state dump, which is the default, shows the routed subnets, DNS
+configuration, active tunnels, session, and intercepts. Profiles are fetched
+from the endpoints of net/http/pprof, e.g.
+
+    telepresence debug daemon pprof/goroutine?debug=2
+    telepresence debug connector pprof/profile?seconds=30 > cpu.pprof
+    go tool pprof cpu.pprof`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var socketName string
+			switch args[0] {
+			case "connector":
+				socketName = client.ConnectorDebugSocketName
+			case "daemon":
+				socketName = client.DaemonDebugSocketName
+			default:
+				return fmt.Errorf("unknown daemon %q, expected connector or daemon", args[0])
+			}
+			if !client.SocketExists(socketName) {
+				return fmt.Errorf("the %s doesn't serve debug information. Quit it and reconnect using --debug", args[0])
+			}
+			endpoint := "state"
+			if len(args) > 1 {
+				endpoint = strings.TrimPrefix(args[1], "/")
+			}
+			rq, err := http.NewRequestWithContext(cmd.Context(), http.MethodGet, "http://"+args[0]+"/debug/"+endpoint, nil)
+			if err != nil {
+				return err
+			}
+			resp, err := debug.Client(socketName).Do(rq)
+			if err != nil {
+				return err
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				return fmt.Errorf("%s responded with %s", args[0], resp.Status)
+			}
+			_, err = io.Copy(cmd.OutOrStdout(), resp.Body)
+			return err
+		},
+	}
+}
@@ -0,0 +1,46 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/spf13/cobra"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/conflicts"
+)
+
+func diagnoseCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:  "diagnose",
+		Args: cobra.NoArgs,
+
+		Short: "Detect local software known to conflict with Telepresence",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			out := cmd.OutOrStdout()
+			if printConflicts(cmd.Context(), out) == 0 {
+				fmt.Fprintln(out, "No conflicting software detected")
+			}
+			return nil
+		},
+	}
+}
+
+// printConflicts prints the conflicting software found on this workstation together with advice on
+// how to remedy the problems that it might cause, and returns the number of conflicts found.
+func printConflicts(ctx context.Context, out io.Writer) int {
+	found := conflicts.Detect(ctx)
+	for _, c := range found {
+		fmt.Fprintf(out, "%s\n", c)
+	}
+	return len(found)
+}
+
+// warnConflicts prints the conflicting software to stderr unless the daemon is already running, in
+// which case the user has seen the warnings already.
+func warnConflicts(cmd *cobra.Command) {
+	if !client.SocketExistsContext(cmd.Context(), client.DaemonSocketName) {
+		printConflicts(cmd.Context(), cmd.ErrOrStderr())
+	}
+}
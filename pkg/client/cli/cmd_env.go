@@ -0,0 +1,421 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+)
+
+func envCommand() *cobra.Command {
+	var namespace, container, output string
+	cmd := &cobra.Command{
+		Use:  "env [flags] <workload>",
+		Args: cobra.ExactArgs(1),
+
+		Short: "Show the environment of a workload without intercepting it",
+		Long: `Show the environment of a container of a workload, as the container would see it, without
+connecting, intercepting, or injecting a traffic-agent. Values that are given by ConfigMaps and
+Secrets are read using the Kubernetes API, so they are subject to the RBAC of the user. A value
+that can't be read is left out and reported on stderr. Values that are only known to a running
+pod, such as its name or IP, are taken from a pod of the workload when one can be found.
+
+The workload is a Deployment, ReplicaSet, StatefulSet, or Pod. The kind can be given as a
+prefix, as in "statefulset/db", and is looked up in that order when it isn't.`,
+		Example: `  telepresence env echo-server > echo-server.env
+  telepresence env deploy/echo-server --container app --output json`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if output != "env" && output != "json" {
+				return fmt.Errorf(`invalid --output %q, must be "env" or "json"`, output)
+			}
+			cfg, err := kubeConfig.ToRESTConfig()
+			if err != nil {
+				return err
+			}
+			cs, err := kubernetes.NewForConfig(cfg)
+			if err != nil {
+				return err
+			}
+			if namespace == "" {
+				if namespace, _, err = kubeConfig.ToRawKubeConfigLoader().Namespace(); err != nil {
+					return err
+				}
+			}
+			ctx := cmd.Context()
+			we := &workloadEnv{cs: cs, namespace: namespace, warn: func(format string, args ...interface{}) {
+				fmt.Fprintf(cmd.ErrOrStderr(), "warning: "+format+"\n", args...)
+			}}
+			kind, name := parseWorkloadRef(args[0])
+			env, err := we.resolve(ctx, kind, name, container)
+			if err != nil {
+				return err
+			}
+			// The cluster domain isn't detected, because that requires access to the namespace
+			// of the cluster DNS.
+			clusterDomain := client.GetConfig(ctx).Cluster.ClusterDomain
+			if clusterDomain == "" {
+				clusterDomain = client.DefaultClusterDomain
+			}
+			if env, err = transformEnv(env, &client.GetConfig(ctx).Intercept.Env, clusterDomain); err != nil {
+				return err
+			}
+			if env, err = redactedEnv(ctx, env); err != nil {
+				return err
+			}
+			return printEnv(cmd.OutOrStdout(), env, output)
+		},
+	}
+	flags := cmd.Flags()
+	flags.StringVarP(&namespace, "namespace", "n", "", "If present, the namespace scope for this CLI request")
+	flags.StringVarP(&container, "container", "c", "", "Container of the workload to use. Defaults to the first container")
+	flags.StringVarP(&output, "output", "o", "env", `Output format, "env" for Docker Compose env-file format or "json"`)
+	return cmd
+}
+
+func printEnv(out io.Writer, env map[string]string, output string) error {
+	if output == "json" {
+		data, err := json.MarshalIndent(env, "", "  ")
+		if err != nil {
+			// Creating JSON from a map[string]string should never fail
+			panic(err)
+		}
+		_, err = fmt.Fprintln(out, string(data))
+		return err
+	}
+	return writeEnv(out, env)
+}
+
+// parseWorkloadRef splits an optional kind prefix, such as "deploy/", from the name.
+func parseWorkloadRef(ref string) (string, string) {
+	if i := strings.IndexByte(ref, '/'); i > 0 {
+		switch kind := strings.ToLower(ref[:i]); kind {
+		case "deploy", "deployment", "deployments":
+			return "Deployment", ref[i+1:]
+		case "rs", "replicaset", "replicasets":
+			return "ReplicaSet", ref[i+1:]
+		case "sts", "statefulset", "statefulsets":
+			return "StatefulSet", ref[i+1:]
+		case "po", "pod", "pods":
+			return "Pod", ref[i+1:]
+		}
+	}
+	return "", ref
+}
+
+// workloadEnv resolves the environment of a container of a workload
+type workloadEnv struct {
+	cs        kubernetes.Interface
+	namespace string
+	warn      func(format string, args ...interface{})
+
+	// cached ConfigMaps and Secrets, or the errors that prevented reading them
+	configMaps map[string]map[string]string
+	secrets    map[string]map[string]string
+	errs       map[string]error
+}
+
+func (we *workloadEnv) resolve(ctx context.Context, kind, name, containerName string) (map[string]string, error) {
+	tpl, pod, err := we.podTemplate(ctx, kind, name)
+	if err != nil {
+		return nil, err
+	}
+	var cn *corev1.Container
+	for i := range tpl.Spec.Containers {
+		if containerName == "" || tpl.Spec.Containers[i].Name == containerName {
+			cn = &tpl.Spec.Containers[i]
+			break
+		}
+	}
+	if cn == nil {
+		return nil, fmt.Errorf("%s has no container named %q", name, containerName)
+	}
+	return we.containerEnv(ctx, tpl, pod, cn), nil
+}
+
+// podTemplate returns the pod template of the given workload, and a pod of the workload if one
+// is found.
+func (we *workloadEnv) podTemplate(ctx context.Context, kind, name string) (*corev1.PodTemplateSpec, *corev1.Pod, error) {
+	var tpl *corev1.PodTemplateSpec
+	var selector *metav1.LabelSelector
+	for _, k := range []string{"Deployment", "ReplicaSet", "StatefulSet", "Pod"} {
+		if kind != "" && kind != k {
+			continue
+		}
+		var err error
+		switch k {
+		case "Deployment":
+			if dep, e := we.cs.AppsV1().Deployments(we.namespace).Get(ctx, name, metav1.GetOptions{}); e == nil {
+				tpl, selector = &dep.Spec.Template, dep.Spec.Selector
+			} else {
+				err = e
+			}
+		case "ReplicaSet":
+			if rs, e := we.cs.AppsV1().ReplicaSets(we.namespace).Get(ctx, name, metav1.GetOptions{}); e == nil {
+				tpl, selector = &rs.Spec.Template, rs.Spec.Selector
+			} else {
+				err = e
+			}
+		case "StatefulSet":
+			if sts, e := we.cs.AppsV1().StatefulSets(we.namespace).Get(ctx, name, metav1.GetOptions{}); e == nil {
+				tpl, selector = &sts.Spec.Template, sts.Spec.Selector
+			} else {
+				err = e
+			}
+		case "Pod":
+			pod, e := we.cs.CoreV1().Pods(we.namespace).Get(ctx, name, metav1.GetOptions{})
+			if e != nil {
+				err = e
+				break
+			}
+			return &corev1.PodTemplateSpec{ObjectMeta: pod.ObjectMeta, Spec: pod.Spec}, pod, nil
+		}
+		if err == nil {
+			break
+		}
+		if kind != "" || !k8serrors.IsNotFound(err) {
+			return nil, nil, err
+		}
+	}
+	if tpl == nil {
+		return nil, nil, fmt.Errorf("no Deployment, ReplicaSet, StatefulSet, or Pod named %s found in namespace %s", name, we.namespace)
+	}
+	return tpl, we.findPod(ctx, selector), nil
+}
+
+// findPod returns a running pod that is selected by the given selector, or nil if there is none.
+func (we *workloadEnv) findPod(ctx context.Context, selector *metav1.LabelSelector) *corev1.Pod {
+	if selector == nil {
+		return nil
+	}
+	sel, err := metav1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		return nil
+	}
+	pods, err := we.cs.CoreV1().Pods(we.namespace).List(ctx, metav1.ListOptions{LabelSelector: sel.String()})
+	if err != nil {
+		return nil
+	}
+	for i := range pods.Items {
+		if pods.Items[i].Status.Phase == corev1.PodRunning {
+			return &pods.Items[i]
+		}
+	}
+	return nil
+}
+
+// containerEnv returns the environment of the given container. The variables of envFrom are
+// added first, so that the variables of env take precedence, just like they do in a container.
+func (we *workloadEnv) containerEnv(ctx context.Context, tpl *corev1.PodTemplateSpec, pod *corev1.Pod, cn *corev1.Container) map[string]string {
+	env := make(map[string]string)
+	for _, ef := range cn.EnvFrom {
+		var data map[string]string
+		var err error
+		var optional bool
+		switch {
+		case ef.ConfigMapRef != nil:
+			data, err = we.configMap(ctx, ef.ConfigMapRef.Name)
+			optional = ef.ConfigMapRef.Optional != nil && *ef.ConfigMapRef.Optional
+		case ef.SecretRef != nil:
+			data, err = we.secret(ctx, ef.SecretRef.Name)
+			optional = ef.SecretRef.Optional != nil && *ef.SecretRef.Optional
+		}
+		if err != nil {
+			if !(optional && k8serrors.IsNotFound(err)) {
+				we.warn("%v", err)
+			}
+			continue
+		}
+		for k, v := range data {
+			k = ef.Prefix + k
+			if len(validation.IsEnvVarName(k)) == 0 {
+				env[k] = v
+			}
+		}
+	}
+	for _, ev := range cn.Env {
+		if ev.ValueFrom == nil {
+			env[ev.Name] = expandEnvRefs(ev.Value, env)
+			continue
+		}
+		v, ok, err := we.valueFrom(ctx, tpl, pod, ev.ValueFrom)
+		if err != nil {
+			we.warn("%s is left out: %v", ev.Name, err)
+			continue
+		}
+		if ok {
+			env[ev.Name] = v
+		}
+	}
+	return env
+}
+
+// valueFrom returns the value of the given source. False is returned when the source is optional
+// and doesn't exist.
+func (we *workloadEnv) valueFrom(ctx context.Context, tpl *corev1.PodTemplateSpec, pod *corev1.Pod, vf *corev1.EnvVarSource) (string, bool, error) {
+	switch {
+	case vf.ConfigMapKeyRef != nil:
+		ref := vf.ConfigMapKeyRef
+		data, err := we.configMap(ctx, ref.Name)
+		return keyValue(data, err, ref.Key, "ConfigMap "+ref.Name, ref.Optional)
+	case vf.SecretKeyRef != nil:
+		ref := vf.SecretKeyRef
+		data, err := we.secret(ctx, ref.Name)
+		return keyValue(data, err, ref.Key, "Secret "+ref.Name, ref.Optional)
+	case vf.FieldRef != nil:
+		v, err := fieldValue(vf.FieldRef.FieldPath, we.namespace, tpl, pod)
+		return v, err == nil, err
+	case vf.ResourceFieldRef != nil:
+		return "", false, fmt.Errorf("resource field %s isn't supported", vf.ResourceFieldRef.Resource)
+	}
+	return "", false, nil
+}
+
+func keyValue(data map[string]string, err error, key, what string, optional *bool) (string, bool, error) {
+	opt := optional != nil && *optional
+	if err != nil {
+		if opt && k8serrors.IsNotFound(err) {
+			err = nil
+		}
+		return "", false, err
+	}
+	v, ok := data[key]
+	if !ok && !opt {
+		return "", false, fmt.Errorf("%s has no key %q", what, key)
+	}
+	return v, ok, nil
+}
+
+// fieldValue returns the value of a downward API field. Fields that only a pod has are taken
+// from the given pod, which is nil when no pod of the workload is running.
+func fieldValue(path, namespace string, tpl *corev1.PodTemplateSpec, pod *corev1.Pod) (string, error) {
+	meta := &tpl.ObjectMeta
+	if pod != nil {
+		meta = &pod.ObjectMeta
+	}
+	if strings.HasPrefix(path, "metadata.labels['") && strings.HasSuffix(path, "']") {
+		return meta.Labels[path[len("metadata.labels['"):len(path)-2]], nil
+	}
+	if strings.HasPrefix(path, "metadata.annotations['") && strings.HasSuffix(path, "']") {
+		return meta.Annotations[path[len("metadata.annotations['"):len(path)-2]], nil
+	}
+	switch path {
+	case "metadata.namespace":
+		return namespace, nil
+	case "spec.serviceAccountName":
+		return tpl.Spec.ServiceAccountName, nil
+	}
+	if pod == nil {
+		return "", fmt.Errorf("field %s is only known to a running pod, and there is none", path)
+	}
+	switch path {
+	case "metadata.name":
+		return pod.Name, nil
+	case "metadata.uid":
+		return string(pod.UID), nil
+	case "spec.nodeName":
+		return pod.Spec.NodeName, nil
+	case "status.hostIP":
+		return pod.Status.HostIP, nil
+	case "status.podIP":
+		return pod.Status.PodIP, nil
+	}
+	return "", fmt.Errorf("field %s isn't supported", path)
+}
+
+func (we *workloadEnv) configMap(ctx context.Context, name string) (map[string]string, error) {
+	key := "ConfigMap " + name
+	if data, ok := we.configMaps[name]; ok {
+		return data, nil
+	}
+	if err, ok := we.errs[key]; ok {
+		return nil, err
+	}
+	cm, err := we.cs.CoreV1().ConfigMaps(we.namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, we.cacheErr(key, err)
+	}
+	if we.configMaps == nil {
+		we.configMaps = make(map[string]map[string]string)
+	}
+	we.configMaps[name] = cm.Data
+	return cm.Data, nil
+}
+
+func (we *workloadEnv) secret(ctx context.Context, name string) (map[string]string, error) {
+	key := "Secret " + name
+	if data, ok := we.secrets[name]; ok {
+		return data, nil
+	}
+	if err, ok := we.errs[key]; ok {
+		return nil, err
+	}
+	sec, err := we.cs.CoreV1().Secrets(we.namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, we.cacheErr(key, err)
+	}
+	data := make(map[string]string, len(sec.Data)+len(sec.StringData))
+	for k, v := range sec.Data {
+		data[k] = string(v)
+	}
+	for k, v := range sec.StringData {
+		data[k] = v
+	}
+	if we.secrets == nil {
+		we.secrets = make(map[string]map[string]string)
+	}
+	we.secrets[name] = data
+	return data, nil
+}
+
+func (we *workloadEnv) cacheErr(key string, err error) error {
+	if we.errs == nil {
+		we.errs = make(map[string]error)
+	}
+	we.errs[key] = err
+	return err
+}
+
+// expandEnvRefs expands the $(VAR) references of the given value to the variables that are
+// already defined, the way Kubernetes does. References to undefined variables are kept as they
+// are, and $$ is an escaped $.
+func expandEnvRefs(value string, env map[string]string) string {
+	sb := strings.Builder{}
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c != '$' || i+1 == len(value) {
+			sb.WriteByte(c)
+			continue
+		}
+		switch value[i+1] {
+		case '$':
+			sb.WriteByte('$')
+			i++
+		case '(':
+			end := strings.IndexByte(value[i+2:], ')')
+			if end < 0 {
+				sb.WriteString(value[i:])
+				return sb.String()
+			}
+			name := value[i+2 : i+2+end]
+			if v, ok := env[name]; ok {
+				sb.WriteString(v)
+			} else {
+				sb.WriteString(value[i : i+3+end])
+			}
+			i += 2 + end
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String()
+}
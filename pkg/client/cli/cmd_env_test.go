@@ -0,0 +1,101 @@
+package cli
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestParseWorkloadRef(t *testing.T) {
+	for ref, expected := range map[string][2]string{
+		"echo":             {"", "echo"},
+		"deploy/echo":      {"Deployment", "echo"},
+		"rs/echo-5d9f8c":   {"ReplicaSet", "echo-5d9f8c"},
+		"statefulset/db":   {"StatefulSet", "db"},
+		"pod/echo-5d9f8c1": {"Pod", "echo-5d9f8c1"},
+	} {
+		kind, name := parseWorkloadRef(ref)
+		assert.Equal(t, expected, [2]string{kind, name}, ref)
+	}
+}
+
+func TestExpandEnvRefs(t *testing.T) {
+	env := map[string]string{"HOST": "db", "PORT": "5432"}
+	for value, expected := range map[string]string{
+		"plain":                 "plain",
+		"$(HOST):$(PORT)":       "db:5432",
+		"$(UNDEFINED)":          "$(UNDEFINED)",
+		"$$(HOST)":              "$(HOST)",
+		"cost: $5":              "cost: $5",
+		"$(HOST":                "$(HOST",
+		"postgres://$(HOST)/x$": "postgres://db/x$",
+	} {
+		assert.Equal(t, expected, expandEnvRefs(value, env), value)
+	}
+}
+
+func TestWorkloadEnv(t *testing.T) {
+	yes := true
+	cs := fake.NewSimpleClientset(
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "echo", Namespace: "default"},
+			Spec: appsv1.DeploymentSpec{
+				Template: corev1.PodTemplateSpec{
+					ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "echo"}},
+					Spec: corev1.PodSpec{Containers: []corev1.Container{{
+						Name: "echo",
+						EnvFrom: []corev1.EnvFromSource{
+							{ConfigMapRef: &corev1.ConfigMapEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "echo-config"}}},
+							{Prefix: "OPT_", ConfigMapRef: &corev1.ConfigMapEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "missing"}, Optional: &yes}},
+						},
+						Env: []corev1.EnvVar{
+							{Name: "LOG_LEVEL", Value: "info"},
+							{Name: "DB_URL", Value: "postgres://$(DB_HOST)/echo"},
+							{Name: "DB_PASSWORD", ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{
+								LocalObjectReference: corev1.LocalObjectReference{Name: "echo-secret"}, Key: "password",
+							}}},
+							{Name: "NAMESPACE", ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.namespace"}}},
+							{Name: "POD_IP", ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{FieldPath: "status.podIP"}}},
+						},
+					}}},
+				},
+			},
+		},
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "echo-config", Namespace: "default"},
+			Data:       map[string]string{"DB_HOST": "db.prod", "LOG_LEVEL": "debug"},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "echo-secret", Namespace: "default"},
+			Data:       map[string][]byte{"password": []byte("s3cret")},
+		},
+	)
+
+	var warnings []string
+	we := &workloadEnv{cs: cs, namespace: "default", warn: func(format string, args ...interface{}) {
+		warnings = append(warnings, format)
+	}}
+	env, err := we.resolve(context.Background(), "", "echo", "")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"DB_HOST":     "db.prod",
+		"LOG_LEVEL":   "info",
+		"DB_URL":      "postgres://db.prod/echo",
+		"DB_PASSWORD": "s3cret",
+		"NAMESPACE":   "default",
+	}, env)
+
+	// The pod IP is only known to a running pod, and there is none
+	assert.Len(t, warnings, 1)
+
+	_, err = we.resolve(context.Background(), "StatefulSet", "echo", "")
+	assert.Error(t, err)
+	_, err = we.resolve(context.Background(), "", "echo", "sidecar")
+	assert.Error(t, err)
+}
@@ -0,0 +1,129 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/rpc/v2/daemon"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+)
+
+func explainRouteCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:  "explain-route <ip>[:<port>]",
+		Args: cobra.ExactArgs(1),
+
+		Short: "Explain how the traffic to an address is routed",
+		Long: `Explain how the daemon handles the traffic to the given address, much like
+"ip route get" does for the routing table of the workstation. The output shows
+the most specific subnet that contains the address and where that subnet comes
+from (the cluster, the also-proxy config, or the external node and load
+balancer subnets), whether a never-proxy subnet makes the address bypass the
+cluster, and whether the traffic is tunneled to the traffic-manager or, when
+there are active intercepts, dialed from one of their traffic-agents.`,
+		Example: `  telepresence explain-route 10.42.3.7:5432
+  telepresence explain-route [fd00:10:96::a]:53`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return withConnector(cmd, true, func(ctx context.Context, connectorClient connector.ConnectorClient, _ *connector.ConnectInfo) error {
+				if !client.SocketExistsContext(ctx, client.DaemonSocketName) {
+					return errors.New("there's no daemon that routes traffic, because the connector runs in proxy mode. " +
+						"Only the traffic of the clients of its SOCKS5 proxy reaches the cluster")
+				}
+				conn, err := client.DialSocket(ctx, client.DaemonSocketName)
+				if err != nil {
+					return err
+				}
+				defer conn.Close()
+				re, err := daemon.NewDaemonClient(conn).ExplainRoute(ctx, &daemon.RouteDestination{Destination: args[0]})
+				if err != nil {
+					return err
+				}
+				var agents []string
+				if re.Routed && !re.Dns {
+					if agents, err = interceptingAgents(ctx, connectorClient); err != nil {
+						return err
+					}
+				}
+				writeRouteExplanation(cmd.OutOrStdout(), re, agents)
+				return nil
+			})
+		},
+	}
+}
+
+// interceptingAgents returns the names of the traffic-agents of the active intercepts. The
+// traffic-manager dials the outbound connections of a client that has active intercepts from the
+// traffic-agents of those intercepts, so that they originate from the intercepted namespaces.
+func interceptingAgents(ctx context.Context, connectorClient connector.ConnectorClient) ([]string, error) {
+	r, err := connectorClient.List(ctx, &connector.ListRequest{Filter: connector.ListRequest_INTERCEPTS})
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]struct{})
+	var agents []string
+	for _, w := range r.Workloads {
+		ii := w.InterceptInfo
+		if ii == nil || ii.Disposition != manager.InterceptDispositionType_ACTIVE {
+			continue
+		}
+		name := ii.Spec.Agent + "." + ii.Spec.Namespace
+		if _, ok := seen[name]; !ok {
+			seen[name] = struct{}{}
+			agents = append(agents, name)
+		}
+	}
+	sort.Strings(agents)
+	return agents, nil
+}
+
+// writeRouteExplanation writes the explanation in the style of "ip route get": one line with the
+// destination followed by key value pairs, and then the reason.
+func writeRouteExplanation(out io.Writer, re *daemon.RouteExplanation, agents []string) {
+	sb := strings.Builder{}
+	sb.WriteString(re.Ip)
+	if re.Port != 0 {
+		sb.WriteString(" port ")
+		sb.WriteString(strconv.Itoa(int(re.Port)))
+	}
+	if re.Routed || re.Rejected {
+		fmt.Fprintf(&sb, " dev %s", re.Device)
+	}
+	if re.Subnet != "" {
+		fmt.Fprintf(&sb, " subnet %s", re.Subnet)
+		if re.Rule != "" {
+			fmt.Fprintf(&sb, " rule %s", re.Rule)
+		}
+	}
+	if re.NeverProxy != "" {
+		fmt.Fprintf(&sb, " never-proxy %s", re.NeverProxy)
+	}
+	switch {
+	case re.Dns:
+		sb.WriteString(" via daemon-dns")
+	case re.Rejected:
+		sb.WriteString(" rejected")
+	case !re.Routed:
+		sb.WriteString(" via workstation")
+	case len(agents) > 0:
+		fmt.Fprintf(&sb, " via traffic-manager agent %s", strings.Join(agents, ","))
+	default:
+		sb.WriteString(" via traffic-manager")
+	}
+	fmt.Fprintf(&sb, "\n    %s\n", re.Reason)
+	if re.Routed && !re.Dns && len(agents) > 0 {
+		sb.WriteString("    the traffic-manager dials the destination from the traffic-agent of one of the active intercepts\n")
+	}
+	if re.Routed && re.ProcessScoped {
+		sb.WriteString("    only the traffic of processes started with \"telepresence run\" takes this route\n")
+	}
+	_, _ = io.WriteString(out, sb.String())
+}
@@ -0,0 +1,54 @@
+package cli
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/telepresenceio/telepresence/rpc/v2/daemon"
+	daemonsvc "github.com/telepresenceio/telepresence/v2/pkg/client/daemon"
+)
+
+func TestWriteRouteExplanation(t *testing.T) {
+	re := &daemon.RouteExplanation{
+		Ip:     "10.42.3.7",
+		Port:   5432,
+		Device: "tel0",
+		Subnet: "10.42.0.0/16",
+		Rule:   daemonsvc.RuleCluster,
+		Routed: true,
+		Reason: "subnet 10.42.0.0/16 is routed to the TUN device, which tunnels the traffic to the traffic-manager",
+	}
+	sb := strings.Builder{}
+	writeRouteExplanation(&sb, re, nil)
+	assert.Equal(t, "10.42.3.7 port 5432 dev tel0 subnet 10.42.0.0/16 rule cluster via traffic-manager\n    "+re.Reason+"\n", sb.String())
+
+	sb.Reset()
+	writeRouteExplanation(&sb, re, []string{"echo.default"})
+	assert.True(t, strings.HasPrefix(sb.String(), "10.42.3.7 port 5432 dev tel0 subnet 10.42.0.0/16 rule cluster via traffic-manager agent echo.default\n"))
+
+	re = &daemon.RouteExplanation{
+		Ip:         "192.168.10.5",
+		Subnet:     "192.168.10.0/24",
+		Rule:       daemonsvc.RuleAlsoProxy,
+		NeverProxy: "192.168.0.0/16",
+		Reason:     "also-proxy subnet 192.168.10.0/24 is covered by never-proxy subnet 192.168.0.0/16, so it's not routed to the cluster",
+	}
+	sb.Reset()
+	writeRouteExplanation(&sb, re, nil)
+	assert.Equal(t, "192.168.10.5 subnet 192.168.10.0/24 rule also-proxy never-proxy 192.168.0.0/16 via workstation\n    "+re.Reason+"\n", sb.String())
+
+	re = &daemon.RouteExplanation{
+		Ip:         "10.43.200.1",
+		Device:     "tel0",
+		Subnet:     "10.43.0.0/16",
+		Rule:       daemonsvc.RuleCluster,
+		NeverProxy: "10.43.128.0/17",
+		Rejected:   true,
+		Reason:     "routed subnet 10.43.0.0/16 covers never-proxy subnet 10.43.128.0/17, so the daemon rejects the traffic that the TUN device receives for the destination",
+	}
+	sb.Reset()
+	writeRouteExplanation(&sb, re, nil)
+	assert.Equal(t, "10.43.200.1 dev tel0 subnet 10.43.0.0/16 rule cluster never-proxy 10.43.128.0/17 rejected\n    "+re.Reason+"\n", sb.String())
+}
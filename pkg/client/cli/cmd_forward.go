@@ -0,0 +1,148 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc"
+
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/cliutil"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/connector/userd_forward"
+)
+
+func forwardCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:  "forward <service>[.<namespace>]:<local port>[:<service port>]...",
+		Args: cobra.MinimumNArgs(1),
+
+		Short: "Forward ports on localhost to services in the cluster",
+		Long: `Forward ports on localhost to services in the cluster, in the manner of
+"kubectl port-forward svc/<service>", for users who prefer explicit forwards over
+the routing of the root daemon. The service port is a number or a name, and
+defaults to the local port.
+
+The forwards are managed by the user daemon and remain until they are removed
+using "telepresence forward remove" or until the user daemon quits. The pod of
+a service is looked up for each connection, so a forward keeps working when the
+pods of the service are replaced.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			fws := make([]*userd_forward.Forward, len(args))
+			for i, arg := range args {
+				var err error
+				if fws[i], err = parseForward(arg); err != nil {
+					return err
+				}
+			}
+			return withForwards(cmd, func(ctx context.Context, conn grpc.ClientConnInterface) error {
+				for _, fw := range fws {
+					if err := userd_forward.AddForward(ctx, conn, fw); err != nil {
+						return err
+					}
+					fmt.Fprintf(cmd.OutOrStdout(), "Forwarding localhost:%d to %s\n", fw.LocalPort, fw.Service)
+				}
+				return nil
+			})
+		},
+	}
+	cmd.AddCommand(&cobra.Command{
+		Use:  "list",
+		Args: cobra.NoArgs,
+
+		Short: "List the forwards of the user daemon",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return withForwards(cmd, func(ctx context.Context, conn grpc.ClientConnInterface) error {
+				fws, err := userd_forward.ListForwards(ctx, conn)
+				if err != nil {
+					return err
+				}
+				printForwards(cmd.OutOrStdout(), fws)
+				return nil
+			})
+		},
+	}, &cobra.Command{
+		Use:  "remove <local port>...",
+		Args: cobra.MinimumNArgs(1),
+
+		Short: "Remove forwards of the user daemon",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ports := make([]uint16, len(args))
+			for i, arg := range args {
+				var err error
+				if ports[i], err = parseLocalPort(arg); err != nil {
+					return err
+				}
+			}
+			return withForwards(cmd, func(ctx context.Context, conn grpc.ClientConnInterface) error {
+				for _, port := range ports {
+					if err := userd_forward.RemoveForward(ctx, conn, port); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+		},
+	})
+	return cmd
+}
+
+func withForwards(cmd *cobra.Command, fn func(context.Context, grpc.ClientConnInterface) error) error {
+	return withConnector(cmd, true, func(ctx context.Context, _ connector.ConnectorClient, _ *connector.ConnectInfo) error {
+		return cliutil.WithConnectorConn(ctx, fn)
+	})
+}
+
+// parseForward parses a "<service>[.<namespace>]:<local port>[:<service port>]" argument
+func parseForward(arg string) (*userd_forward.Forward, error) {
+	parts := strings.Split(arg, ":")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" {
+		return nil, fmt.Errorf("invalid forward %q, expected <service>[.<namespace>]:<local port>[:<service port>]", arg)
+	}
+	fw := &userd_forward.Forward{Service: parts[0]}
+	if dot := strings.IndexByte(fw.Service, '.'); dot > 0 {
+		fw.Service, fw.Namespace = fw.Service[:dot], fw.Service[dot+1:]
+	}
+	port, err := parseLocalPort(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid forward %q: %w", arg, err)
+	}
+	fw.LocalPort = port
+	if len(parts) == 3 {
+		if parts[2] == "" {
+			return nil, fmt.Errorf("invalid service port in forward %q", arg)
+		}
+		fw.Port = parts[2]
+	}
+	return fw, nil
+}
+
+func parseLocalPort(s string) (uint16, error) {
+	port, err := strconv.ParseUint(s, 10, 16)
+	if err != nil || port == 0 {
+		return 0, fmt.Errorf("port numbers must be a valid, positive int, you gave: %q", s)
+	}
+	return uint16(port), nil
+}
+
+func printForwards(out io.Writer, fws []*userd_forward.Forward) {
+	if len(fws) == 0 {
+		fmt.Fprintln(out, "No forwards")
+		return
+	}
+	tw := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "LOCAL PORT\tSERVICE\tNAMESPACE\tPORT\tCONNECTIONS\tLAST ERROR")
+	for _, fw := range fws {
+		lastError := fw.LastError
+		if lastError == "" {
+			lastError = "-"
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\n",
+			fw.LocalPort, fw.Service, fw.Namespace, fw.Port, strconv.FormatUint(fw.Connections, 10), lastError)
+	}
+	_ = tw.Flush()
+}
@@ -0,0 +1,25 @@
+package cli
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/client/connector/userd_forward"
+)
+
+func TestParseForward(t *testing.T) {
+	fw, err := parseForward("redis:6379")
+	require.NoError(t, err)
+	assert.Equal(t, &userd_forward.Forward{Service: "redis", LocalPort: 6379}, fw)
+
+	fw, err = parseForward("postgres.db:5433:pg")
+	require.NoError(t, err)
+	assert.Equal(t, &userd_forward.Forward{Service: "postgres", Namespace: "db", LocalPort: 5433, Port: "pg"}, fw)
+
+	for _, arg := range []string{"redis", ":6379", "redis:0", "redis:x", "redis:6379:", "a:1:2:3"} {
+		_, err = parseForward(arg)
+		assert.Error(t, err, arg)
+	}
+}
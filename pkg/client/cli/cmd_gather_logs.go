@@ -0,0 +1,193 @@
+package cli
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/yaml"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/cliutil"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/logging"
+	"github.com/telepresenceio/telepresence/v2/pkg/filelocation"
+	"github.com/telepresenceio/telepresence/v2/pkg/redact"
+)
+
+func gatherLogsCommand() *cobra.Command {
+	var output string
+	var anonymize bool
+	cmd := &cobra.Command{
+		Use:  "gather-logs",
+		Args: cobra.NoArgs,
+
+		Short: "Write the logs of the daemons, with secrets redacted, to a zip file",
+		Long: `Write the logs of the daemons, including rotated logs, and the configuration to a zip
+file that can be attached to an issue. Secrets are redacted using the same rules as the
+logs themselves.
+
+With --anonymize, the names of namespaces, workloads, intercepts, kubeconfig contexts,
+clusters, and users, and the hosts of URLs are also replaced with placeholders. The same
+name always gets the same placeholder within a bundle, so the logs can still be followed.
+The names are found in the kubeconfig and, if the user daemon is connected, in the
+workloads and intercepts of the cluster.`,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			ctx := cmd.Context()
+			var a *redact.Anonymizer
+			if anonymize {
+				a = redact.NewAnonymizer(nil)
+				addKubeconfigNames(ctx, a)
+				addClusterNames(ctx, a)
+			}
+			count, err := gatherLogs(ctx, output, a)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Wrote %d files to %s\n", count, output)
+			return nil
+		},
+	}
+	flags := cmd.Flags()
+	flags.StringVarP(&output, "output-file", "o", "telepresence-logs.zip", "The zip file to write the logs to")
+	flags.BoolVar(&anonymize, "anonymize", false, "Replace the names of namespaces, workloads, clusters, and hosts with placeholders")
+	return cmd
+}
+
+// gatherLogs writes the logs and the configuration, with secrets redacted and, if a is not nil,
+// anonymized, to a zip file. The number of files that were written is returned.
+func gatherLogs(ctx context.Context, output string, a *redact.Anonymizer) (int, error) {
+	logDir, err := filelocation.AppUserLogDir(ctx)
+	if err != nil {
+		return 0, err
+	}
+	cfg := client.GetConfig(ctx)
+	r, err := cfg.Redact.Redactor()
+	if err != nil {
+		return 0, err
+	}
+
+	f, err := os.OpenFile(output, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return 0, err
+	}
+	zw := zip.NewWriter(f)
+	count := 0
+	addFile := func(name string, data []byte) error {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: time.Now()})
+		if err != nil {
+			return err
+		}
+		if a == nil {
+			err = r.Copy(w, bytes.NewReader(data))
+		} else {
+			buf := bytes.Buffer{}
+			if err = r.Copy(&buf, bytes.NewReader(data)); err == nil {
+				err = a.Copy(w, &buf)
+			}
+		}
+		if err == nil {
+			count++
+		}
+		return err
+	}
+
+	err = func() error {
+		data, err := yaml.Marshal(cfg)
+		if err != nil {
+			return err
+		}
+		if err = addFile("config.yml", data); err != nil {
+			return err
+		}
+		for _, name := range logNames {
+			paths, err := logging.RotatedLogFiles(logDir, name, time.Time{})
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			paths = append(paths, filepath.Join(logDir, name+".log"))
+			for _, path := range paths {
+				data, err := logging.ReadLogFile(path)
+				if err != nil {
+					if os.IsNotExist(err) {
+						continue
+					}
+					return err
+				}
+				if err = addFile(filepath.Base(path), data); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}()
+	if cerr := zw.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(output)
+		return 0, err
+	}
+	return count, nil
+}
+
+// addKubeconfigNames adds the names of the contexts, clusters, users, and namespaces found in the
+// kubeconfig, and the hosts of its cluster servers, to the given anonymizer.
+func addKubeconfigNames(ctx context.Context, a *redact.Anonymizer) {
+	kc, err := clientcmd.NewDefaultClientConfigLoadingRules().Load()
+	if err != nil {
+		dlog.Debugf(ctx, "unable to load kubeconfig: %v", err)
+		return
+	}
+	for name, kctx := range kc.Contexts {
+		a.AddName("context", name)
+		a.AddName("cluster", kctx.Cluster)
+		a.AddName("user", kctx.AuthInfo)
+		a.AddName("namespace", kctx.Namespace)
+	}
+	for name, cluster := range kc.Clusters {
+		a.AddName("cluster", name)
+		if u, err := url.Parse(cluster.Server); err == nil {
+			a.AddName("host", u.Hostname())
+		}
+	}
+	for name := range kc.AuthInfos {
+		a.AddName("user", name)
+	}
+}
+
+// addClusterNames adds the names and namespaces of the workloads and intercepts of the cluster to
+// the given anonymizer. Nothing is added unless the user daemon is connected.
+func addClusterNames(ctx context.Context, a *redact.Anonymizer) {
+	err := cliutil.WithStartedConnector(ctx, func(ctx context.Context, connectorClient connector.ConnectorClient) error {
+		r, err := connectorClient.List(ctx, &connector.ListRequest{Filter: connector.ListRequest_EVERYTHING})
+		if err != nil {
+			return err
+		}
+		for _, wl := range r.Workloads {
+			a.AddName("workload", wl.Name)
+			if ai := wl.AgentInfo; ai != nil {
+				a.AddName("namespace", ai.Namespace)
+			}
+			if ii := wl.InterceptInfo; ii != nil {
+				a.AddName("intercept", ii.Spec.Name)
+				a.AddName("namespace", ii.Spec.Namespace)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		dlog.Debugf(ctx, "unable to list the workloads of the cluster: %v", err)
+	}
+}
@@ -0,0 +1,76 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/filelocation"
+	"github.com/telepresenceio/telepresence/v2/pkg/tracing"
+)
+
+func gatherTracesCommand() *cobra.Command {
+	var output string
+	cmd := &cobra.Command{
+		Use:  "gather-traces",
+		Args: cobra.NoArgs,
+
+		Short: "Write the trace spans recorded by the CLI and the daemons to a file",
+		Long: `Write the trace spans recorded by the CLI and the daemons to a file. The file is an
+OTLP JSON document that can be imported using the otlpjsonfile receiver of an
+OpenTelemetry collector. Spans from the traffic-manager and the traffic-agents are
+only available in the collector given by the OTEL_EXPORTER_OTLP_ENDPOINT of those
+components.`,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			dir, err := filelocation.AppUserLogDir(cmd.Context())
+			if err != nil {
+				return err
+			}
+			f, err := os.OpenFile(output, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+			if err != nil {
+				return err
+			}
+			count, err := tracing.Gather(dir, f, func(err error) {
+				fmt.Fprintf(cmd.ErrOrStderr(), "Warning: skipping spans: %v\n", err)
+			})
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Wrote %d spans to %s\n", count, output)
+			return nil
+		},
+	}
+	cmd.Flags().StringVarP(&output, "output-file", "o", "telepresence-traces.json", "The file to write the spans to")
+	return cmd
+}
+
+// traceCLI starts the recording of the spans of this CLI invocation. The returned function must be
+// called before the command returns so that the spans are written.
+func traceCLI(cmd *cobra.Command) func() {
+	ctx := cmd.Context()
+	dir, err := filelocation.AppUserLogDir(ctx)
+	if err == nil {
+		err = os.MkdirAll(dir, 0700)
+	}
+	tc, cancel := context.WithCancel(ctx)
+	if err == nil {
+		err = tracing.Init(tc, "cli", dir, client.GetConfig(ctx).Tracing.Endpoint)
+	}
+	if err != nil {
+		dlog.Debugf(ctx, "unable to record traces: %v", err)
+		cancel()
+		return func() {}
+	}
+	return func() {
+		cancel()
+		tracing.Wait(time.Second)
+	}
+}
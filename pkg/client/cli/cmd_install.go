@@ -0,0 +1,83 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc"
+
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/cliutil"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/extensions"
+)
+
+func installCommand(ctx context.Context) *cobra.Command {
+	var namespace string
+	cmd := &cobra.Command{
+		Use:  "install [flags] <workloads...>",
+		Args: cobra.MinimumNArgs(1),
+
+		Short: "Install the traffic-agent in workloads ahead of intercepting them",
+		Long: `Install the traffic-agent in workloads the way an intercept of each of them would, and wait
+until the agents are ready, so that intercepting them later doesn't wait for their rollouts. The
+workloads are changed, and their rollouts awaited, in parallel, so preparing many workloads takes
+about as long as preparing the slowest of them. The traffic-agent image is chosen using the same
+flags as "telepresence intercept".`,
+	}
+	flags := cmd.Flags()
+	flags.StringVarP(&namespace, "namespace", "n", "", "If present, the namespace scope for this CLI request")
+	extState, extErr := extensions.LoadExtensions(ctx, flags)
+
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		if extErr != nil {
+			return extErr
+		}
+		return withConnector(cmd, true, func(ctx context.Context, _ connector.ConnectorClient, _ *connector.ConnectInfo) error {
+			env, err := client.LoadEnv(ctx)
+			if err != nil {
+				return err
+			}
+			req := &connector.InstallAgentsRequest{Names: args, Namespace: namespace}
+			if req.AgentImage, err = extState.AgentImage(ctx, env); err != nil {
+				return err
+			}
+			return cliutil.WithConnectorConn(ctx, func(ctx context.Context, conn grpc.ClientConnInterface) error {
+				return installAgents(ctx, connector.NewWorkloadsClient(conn), req, cmd.OutOrStdout())
+			})
+		})
+	}
+	return cmd
+}
+
+// installAgents makes the given request and prints the progress as the installs complete. An
+// error is returned when any install failed.
+func installAgents(ctx context.Context, wc connector.WorkloadsClient, req *connector.InstallAgentsRequest, out io.Writer) error {
+	fmt.Fprintf(out, "Installing the traffic-agent in %d workloads...\n", len(req.Names))
+	stream, err := wc.InstallAgents(ctx, req)
+	if err != nil {
+		return err
+	}
+	failed := 0
+	for {
+		p, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if p.ErrorText != "" {
+			failed++
+			fmt.Fprintf(out, "[%d/%d] %s.%s failed: %s\n", p.Done, p.Total, p.Name, p.Namespace, p.ErrorText)
+		} else {
+			fmt.Fprintf(out, "[%d/%d] %s %s.%s is ready\n", p.Done, p.Total, p.WorkloadKind, p.Name, p.Namespace)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("the traffic-agent could not be installed in %d of %d workloads", failed, len(req.Names))
+	}
+	return nil
+}
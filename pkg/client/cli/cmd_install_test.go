@@ -0,0 +1,59 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+)
+
+type fakeInstallStream struct {
+	connector.Workloads_InstallAgentsClient
+	progress []*connector.AgentInstallProgress
+}
+
+func (s *fakeInstallStream) Recv() (*connector.AgentInstallProgress, error) {
+	if len(s.progress) == 0 {
+		return nil, io.EOF
+	}
+	p := s.progress[0]
+	s.progress = s.progress[1:]
+	return p, nil
+}
+
+type fakeWorkloadsClient struct {
+	connector.WorkloadsClient
+	stream *fakeInstallStream
+}
+
+func (c *fakeWorkloadsClient) InstallAgents(context.Context, *connector.InstallAgentsRequest, ...grpc.CallOption) (connector.Workloads_InstallAgentsClient, error) {
+	return c.stream, nil
+}
+
+func TestInstallAgents(t *testing.T) {
+	req := &connector.InstallAgentsRequest{Names: []string{"echo", "db"}, Namespace: "default"}
+	wc := &fakeWorkloadsClient{stream: &fakeInstallStream{progress: []*connector.AgentInstallProgress{
+		{Name: "db", Namespace: "default", WorkloadKind: "StatefulSet", Done: 1, Total: 2},
+		{Name: "echo", Namespace: "default", WorkloadKind: "Deployment", Done: 2, Total: 2},
+	}}}
+	out := &bytes.Buffer{}
+	assert.NoError(t, installAgents(context.Background(), wc, req, out))
+	assert.Equal(t, `Installing the traffic-agent in 2 workloads...
+[1/2] StatefulSet db.default is ready
+[2/2] Deployment echo.default is ready
+`, out.String())
+
+	wc.stream.progress = []*connector.AgentInstallProgress{
+		{Name: "db", Namespace: "default", ErrorText: "no such agent", Done: 1, Total: 2},
+		{Name: "echo", Namespace: "default", WorkloadKind: "Deployment", Done: 2, Total: 2},
+	}
+	out.Reset()
+	err := installAgents(context.Background(), wc, req, out)
+	assert.EqualError(t, err, "the traffic-agent could not be installed in 1 of 2 workloads")
+	assert.Contains(t, out.String(), "[1/2] db.default failed: no such agent\n")
+}
@@ -0,0 +1,345 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/datawire/dlib/dcontext"
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/cliutil"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/extensions"
+	"github.com/telepresenceio/telepresence/v2/pkg/install"
+)
+
+const (
+	// jobCopyPortName is the name of the port that the service of a job copy targets
+	jobCopyPortName = "tp-job"
+
+	defaultPlaceholderImage = "k8s.gcr.io/pause:3.2"
+)
+
+func interceptJobCommand(ctx context.Context) *cobra.Command {
+	var jc jobCopy
+	cmd := &cobra.Command{
+		Use:  "intercept-job [flags] <job|cronjob> [-- <command with arguments...>]",
+		Args: cobra.MinimumNArgs(1),
+
+		Short: "Run a Job or CronJob locally with the environment and mounts of its pods",
+		Long: `Create a copy of a Job, or of the job template of a CronJob, that can be intercepted, and run a
+command, or a shell when no command is given, with the environment and volume mounts of that copy.
+The copy consists of a Deployment and a Service. The Deployment has a single pod, in which the
+container of the job is replaced by a placeholder that does nothing but keep the environment
+and the volumes of the job available. Init containers and other containers of the job are not
+copied. The copy is removed when the command ends, or by the traffic-manager when the session of
+the connector that created it ends.
+
+The schedule of a CronJob is not changed, unless --suspend is used, in which case it's suspended
+while the command runs and then restored to what it was before, so that a CronJob that was
+suspended remains suspended.`,
+		Example: `  telepresence intercept-job nightly-report -- ./report --dry-run
+  telepresence intercept-job cronjob/cleanup --suspend`,
+		PreRunE: updateCheckIfDue,
+	}
+	args := interceptArgs{}
+	flags := cmd.Flags()
+	flags.StringVarP(&args.namespace, "namespace", "n", "", "If present, the namespace scope for this CLI request")
+	flags.StringVarP(&jc.container, "container", "c", "", "Container of the job to use. Defaults to the first container")
+	flags.BoolVar(&jc.suspend, "suspend", false, "Suspend the schedule of the CronJob while the command runs")
+	flags.StringVar(&jc.image, "placeholder-image", defaultPlaceholderImage, "Image of the container that replaces the container of the job")
+	flags.StringVarP(&args.port, "port", "p", "8080", "Local port that receives the traffic of the copy, which is normally none")
+	flags.StringVarP(&args.envFile, "env-file", "e", "", "Also emit the remote environment to an env file in Docker Compose format")
+	flags.StringVarP(&args.envJSON, "env-json", "j", "", "Also emit the remote environment to a file as a JSON blob")
+	flags.StringVarP(&args.mount, "mount", "", "true", ``+
+		`The absolute path for the root directory where volumes will be mounted, $TELEPRESENCE_ROOT. Use "true" to `+
+		`have Telepresence pick a random mount point (default). Use "false" to disable filesystem mounting entirely.`)
+	args.previewSpec = &manager.PreviewSpec{}
+
+	var extErr error
+	args.extState, extErr = extensions.LoadExtensions(ctx, flags)
+
+	cmd.RunE = func(cmd *cobra.Command, positional []string) error {
+		if extErr != nil {
+			return extErr
+		}
+		jc.kind, jc.name = parseJobRef(positional[0])
+		args.cmdline = positional[1:]
+		if len(args.cmdline) == 0 {
+			shell := os.Getenv("SHELL")
+			if shell == "" {
+				shell = "/bin/sh"
+			}
+			args.cmdline = []string{shell}
+		}
+		args.mountSet = cmd.Flag("mount").Changed
+
+		cfg, err := kubeConfig.ToRESTConfig()
+		if err != nil {
+			return err
+		}
+		if jc.cs, err = kubernetes.NewForConfig(cfg); err != nil {
+			return err
+		}
+		jc.namespace = args.namespace
+		if jc.namespace == "" {
+			if jc.namespace, _, err = kubeConfig.ToRawKubeConfigLoader().Namespace(); err != nil {
+				return err
+			}
+		}
+		env, err := client.LoadEnv(cmd.Context())
+		if err != nil {
+			return err
+		}
+		if jc.managerNamespace = env.ManagerNamespace; jc.managerNamespace == "" {
+			if jc.managerNamespace = client.GetConfig(cmd.Context()).Cluster.ManagerNamespace; jc.managerNamespace == "" {
+				jc.managerNamespace = client.DefaultManagerNamespace
+			}
+		}
+		jc.copyName = jobCopyName(jc.name)
+		args.name = jc.copyName
+		args.agentName = jc.copyName
+		args.serviceName = jc.copyName
+		return interceptJob(cmd, &jc, args)
+	}
+	return cmd
+}
+
+// interceptJob creates the job copy, intercepts it, runs the command, and then removes the
+// intercept and the copy.
+func interceptJob(cmd *cobra.Command, jc *jobCopy, args interceptArgs) error {
+	return withConnector(cmd, false, func(ctx context.Context, connectorClient connector.ConnectorClient, connInfo *connector.ConnectInfo) error {
+		// The traffic-manager removes the copy when this session ends, so that it doesn't remain
+		// when this command is killed.
+		jc.sessionID = connInfo.GetSessionInfo().GetSessionId()
+		return client.WithEnsuredState(ctx, jc, false, func() error {
+			return cliutil.WithManager(ctx, func(ctx context.Context, managerClient manager.ManagerClient) error {
+				is := newInterceptState(ctx, safeCobraCommandImpl{cmd}, args, connectorClient, managerClient, connInfo)
+				return client.WithEnsuredState(ctx, is, false, func() error {
+					return start(ctx, args.cmdline[0], args.cmdline[1:], true,
+						cmd.InOrStdin(), cmd.OutOrStdout(), cmd.ErrOrStderr(),
+						envPairs(is.env)...)
+				})
+			})
+		})
+	})
+}
+
+// parseJobRef splits an optional "job/" or "cronjob/" prefix from the name.
+func parseJobRef(ref string) (string, string) {
+	if i := strings.IndexByte(ref, '/'); i > 0 {
+		switch kind := strings.ToLower(ref[:i]); kind {
+		case "job", "jobs":
+			return "Job", ref[i+1:]
+		case "cronjob", "cronjobs", "cj":
+			return "CronJob", ref[i+1:]
+		}
+	}
+	return "", ref
+}
+
+// jobCopyName returns a name for a copy of the named job that is unique enough to not collide
+// with the copies that other users create, and short enough to be used as a service name.
+func jobCopyName(name string) string {
+	const suffixLen = len("-tp-") + 5
+	if len(name) > 63-suffixLen {
+		name = strings.TrimRight(name[:63-suffixLen], "-")
+	}
+	return name + "-tp-" + rand.String(5)
+}
+
+// jobCopy is the EnsuredState of the Deployment and Service that make up an interceptable copy of
+// a Job or CronJob.
+type jobCopy struct {
+	cs               kubernetes.Interface
+	kind             string // "Job", "CronJob", or empty when any of them will do
+	name             string
+	namespace        string
+	container        string
+	image            string
+	suspend          bool
+	managerNamespace string
+	sessionID        string
+
+	copyName string
+	copyOf   string // kind/name of the job that was found
+	resume   bool   // resume the CronJob when the copy is removed
+}
+
+func (jc *jobCopy) EnsureState(ctx context.Context) (bool, error) {
+	tpl, err := jc.podTemplate(ctx)
+	if err != nil {
+		return false, err
+	}
+	jc.suspend = jc.suspend && strings.HasPrefix(jc.copyOf, "CronJob/")
+	dep, svc, err := jc.objects(tpl)
+	if err != nil {
+		return false, err
+	}
+	if dep, err = jc.cs.AppsV1().Deployments(jc.namespace).Create(ctx, dep, metav1.CreateOptions{}); err != nil {
+		return false, fmt.Errorf("unable to create deployment %s.%s: %w", jc.copyName, jc.namespace, err)
+	}
+	// The service is owned by the deployment, so that it's garbage collected with it
+	svc.OwnerReferences = []metav1.OwnerReference{*metav1.NewControllerRef(dep, appsv1.SchemeGroupVersion.WithKind("Deployment"))}
+	if _, err = jc.cs.CoreV1().Services(jc.namespace).Create(ctx, svc, metav1.CreateOptions{}); err != nil {
+		return true, fmt.Errorf("unable to create service %s.%s: %w", jc.copyName, jc.namespace, err)
+	}
+	dlog.Infof(ctx, "Created %s.%s, a copy of %s", jc.copyName, jc.namespace, jc.copyOf)
+
+	if jc.suspend {
+		if err = jc.setSuspended(ctx, true); err != nil {
+			return true, err
+		}
+	}
+	return true, nil
+}
+
+func (jc *jobCopy) DeactivateState(ctx context.Context) error {
+	// The copy must be removed also when the command was interrupted
+	ctx = dcontext.WithoutCancel(ctx)
+	var errs []string
+	propagation := metav1.DeletePropagationBackground
+	opts := metav1.DeleteOptions{PropagationPolicy: &propagation}
+	if err := jc.cs.AppsV1().Deployments(jc.namespace).Delete(ctx, jc.copyName, opts); err != nil && !k8serrors.IsNotFound(err) {
+		errs = append(errs, err.Error())
+	}
+	if err := jc.cs.CoreV1().Services(jc.namespace).Delete(ctx, jc.copyName, opts); err != nil && !k8serrors.IsNotFound(err) {
+		errs = append(errs, err.Error())
+	}
+	if jc.resume {
+		if err := jc.setSuspended(ctx, false); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("unable to remove the copy of %s: %s", jc.copyOf, strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// podTemplate finds the Job or CronJob and returns its pod template.
+func (jc *jobCopy) podTemplate(ctx context.Context) (*corev1.PodTemplateSpec, error) {
+	if jc.kind != "CronJob" {
+		job, err := jc.cs.BatchV1().Jobs(jc.namespace).Get(ctx, jc.name, metav1.GetOptions{})
+		switch {
+		case err == nil:
+			jc.copyOf = "Job/" + jc.name
+			return &job.Spec.Template, nil
+		case jc.kind == "Job" || !k8serrors.IsNotFound(err):
+			return nil, err
+		}
+	}
+	cj, err := jc.cs.BatchV1beta1().CronJobs(jc.namespace).Get(ctx, jc.name, metav1.GetOptions{})
+	if err != nil {
+		if k8serrors.IsNotFound(err) && jc.kind == "" {
+			return nil, fmt.Errorf("no Job or CronJob named %s found in namespace %s", jc.name, jc.namespace)
+		}
+		return nil, err
+	}
+	jc.copyOf = "CronJob/" + jc.name
+	if s := cj.Spec.Suspend; s != nil && *s {
+		// Already suspended. Leave it that way when the copy is removed.
+		jc.suspend = false
+	}
+	return &cj.Spec.JobTemplate.Spec.Template, nil
+}
+
+func (jc *jobCopy) setSuspended(ctx context.Context, suspend bool) error {
+	patch := fmt.Sprintf(`{"spec":{"suspend":%t}}`, suspend)
+	if _, err := jc.cs.BatchV1beta1().CronJobs(jc.namespace).Patch(ctx, jc.name, types.MergePatchType, []byte(patch), metav1.PatchOptions{}); err != nil {
+		return fmt.Errorf("unable to set suspend to %t for CronJob %s.%s: %w", suspend, jc.name, jc.namespace, err)
+	}
+	jc.resume = suspend
+	return nil
+}
+
+// objects returns the Deployment and the Service of the copy of a job with the given pod template.
+func (jc *jobCopy) objects(tpl *corev1.PodTemplateSpec) (*appsv1.Deployment, *corev1.Service, error) {
+	var app *corev1.Container
+	containerName := jc.container
+	cns := tpl.Spec.Containers
+	for i := range cns {
+		if containerName == "" || cns[i].Name == containerName {
+			app = cns[i].DeepCopy()
+			break
+		}
+	}
+	if app == nil {
+		if containerName == "" {
+			return nil, nil, errors.New("the job has no containers")
+		}
+		return nil, nil, fmt.Errorf("the job has no container named %q", containerName)
+	}
+
+	// Keep what makes up the environment and the volumes of the container, but don't run the job
+	app.Image = jc.image
+	app.Command = nil
+	app.Args = nil
+	app.WorkingDir = ""
+	app.LivenessProbe = nil
+	app.ReadinessProbe = nil
+	app.StartupProbe = nil
+	app.Lifecycle = nil
+	app.Ports = []corev1.ContainerPort{{Name: jobCopyPortName, ContainerPort: 8080, Protocol: corev1.ProtocolTCP}}
+
+	spec := tpl.Spec.DeepCopy()
+	spec.InitContainers = nil
+	spec.Containers = []corev1.Container{*app}
+	spec.RestartPolicy = corev1.RestartPolicyAlways
+	spec.ActiveDeadlineSeconds = nil
+
+	selector := map[string]string{install.JobCopyLabel: jc.copyName}
+	meta := metav1.ObjectMeta{
+		Name:      jc.copyName,
+		Namespace: jc.namespace,
+		Labels:    install.OwnerLabels(jc.managerNamespace, selector),
+		Annotations: map[string]string{
+			install.JobCopyOfAnnotation:      jc.copyOf,
+			install.JobCopySessionAnnotation: jc.sessionID,
+		},
+	}
+	if jc.suspend {
+		meta.Annotations[install.JobCopyResumeAnnotation] = "true"
+	}
+	replicas := int32(1)
+	dep := &appsv1.Deployment{
+		TypeMeta:   metav1.TypeMeta{Kind: "Deployment", APIVersion: "apps/v1"},
+		ObjectMeta: meta,
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{MatchLabels: selector},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: selector, Annotations: tpl.Annotations},
+				Spec:       *spec,
+			},
+		},
+	}
+	svc := &corev1.Service{
+		TypeMeta:   metav1.TypeMeta{Kind: "Service", APIVersion: "v1"},
+		ObjectMeta: *meta.DeepCopy(),
+		Spec: corev1.ServiceSpec{
+			Selector: selector,
+			Ports: []corev1.ServicePort{{
+				Name:       jobCopyPortName,
+				Port:       80,
+				Protocol:   corev1.ProtocolTCP,
+				TargetPort: intstr.FromString(jobCopyPortName),
+			}},
+		},
+	}
+	return dep, svc, nil
+}
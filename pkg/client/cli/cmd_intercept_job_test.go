@@ -0,0 +1,104 @@
+package cli
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/install"
+)
+
+func TestParseJobRef(t *testing.T) {
+	for ref, expected := range map[string][2]string{
+		"report":         {"", "report"},
+		"job/report":     {"Job", "report"},
+		"cronjob/report": {"CronJob", "report"},
+		"cj/report":      {"CronJob", "report"},
+	} {
+		kind, name := parseJobRef(ref)
+		assert.Equal(t, expected, [2]string{kind, name}, ref)
+	}
+}
+
+func TestJobCopyName(t *testing.T) {
+	name := jobCopyName("report")
+	assert.True(t, strings.HasPrefix(name, "report-tp-"))
+	assert.Len(t, name, len("report-tp-")+5)
+	assert.NotEqual(t, name, jobCopyName("report"))
+	assert.Len(t, jobCopyName(strings.Repeat("x", 80)), 63)
+}
+
+func TestJobCopyObjects(t *testing.T) {
+	tpl := &corev1.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{
+			Labels: map[string]string{"app": "report", "job-name": "report", "controller-uid": "1234"},
+		},
+		Spec: corev1.PodSpec{
+			RestartPolicy:  corev1.RestartPolicyNever,
+			InitContainers: []corev1.Container{{Name: "migrate"}},
+			Containers: []corev1.Container{
+				{
+					Name:         "report",
+					Image:        "report:1.0",
+					Command:      []string{"/report"},
+					Env:          []corev1.EnvVar{{Name: "DB_HOST", Value: "db"}},
+					VolumeMounts: []corev1.VolumeMount{{Name: "data", MountPath: "/data"}},
+				},
+				{Name: "sidecar", Image: "sidecar:1.0"},
+			},
+			Volumes: []corev1.Volume{{Name: "data"}},
+		},
+	}
+	jc := &jobCopy{
+		namespace:        "default",
+		image:            defaultPlaceholderImage,
+		suspend:          true,
+		managerNamespace: "ambassador",
+		sessionID:        "session-1",
+		copyName:         "report-tp-abcde",
+		copyOf:           "CronJob/report",
+	}
+	dep, svc, err := jc.objects(tpl)
+	require.NoError(t, err)
+
+	labels := map[string]string{install.JobCopyLabel: "report-tp-abcde"}
+	assert.Equal(t, labels, dep.Spec.Template.Labels, "the labels of the job must not be copied")
+	assert.Equal(t, labels, dep.Spec.Selector.MatchLabels)
+	assert.Equal(t, labels, svc.Spec.Selector)
+	assert.Equal(t, "ambassador", dep.Labels[install.ManagerNamespaceLabel])
+	assert.Equal(t, map[string]string{
+		install.JobCopyOfAnnotation:      "CronJob/report",
+		install.JobCopySessionAnnotation: "session-1",
+		install.JobCopyResumeAnnotation:  "true",
+	}, dep.Annotations, "the traffic-manager must be able to remove the copy and resume the CronJob")
+	assert.Equal(t, dep.ObjectMeta, svc.ObjectMeta)
+
+	spec := dep.Spec.Template.Spec
+	assert.Equal(t, corev1.RestartPolicyAlways, spec.RestartPolicy)
+	assert.Empty(t, spec.InitContainers)
+	require.Len(t, spec.Containers, 1)
+	app := spec.Containers[0]
+	assert.Equal(t, "report", app.Name)
+	assert.Equal(t, defaultPlaceholderImage, app.Image)
+	assert.Empty(t, app.Command)
+	assert.Equal(t, tpl.Spec.Containers[0].Env, app.Env)
+	assert.Equal(t, tpl.Spec.Containers[0].VolumeMounts, app.VolumeMounts)
+	assert.Equal(t, tpl.Spec.Volumes, spec.Volumes)
+	assert.Equal(t, jobCopyPortName, app.Ports[0].Name)
+	assert.Equal(t, jobCopyPortName, svc.Spec.Ports[0].TargetPort.StrVal)
+
+	// The template is left untouched
+	assert.Equal(t, "report:1.0", tpl.Spec.Containers[0].Image)
+
+	jc.copyOf, jc.suspend, jc.container = "Job/report", false, "sidecar"
+	dep, _, err = jc.objects(tpl)
+	require.NoError(t, err)
+	assert.NotContains(t, dep.Annotations, install.JobCopyResumeAnnotation)
+	jc.container = "nope"
+	_, _, err = jc.objects(tpl)
+	assert.Error(t, err)
+}
@@ -13,6 +13,7 @@ import (
 	"k8s.io/apimachinery/pkg/runtime/serializer/json"
 
 	"github.com/datawire/ambassador/pkg/kates"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/cliutil"
 )
 
@@ -98,7 +99,7 @@ func createSecretFromLicense(ctx context.Context, writer io.Writer, license, hos
 			APIVersion: "v1",
 		},
 		ObjectMeta: kates.ObjectMeta{
-			Namespace: "ambassador",
+			Namespace: client.DefaultManagerNamespace,
 			Name:      "systema-license",
 		},
 		Data: map[string][]byte{
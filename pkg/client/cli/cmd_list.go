@@ -80,7 +80,7 @@ func (s *listInfo) list(cmd *cobra.Command, _ []string) error {
 
 	state := func(workload *connector.WorkloadInfo) string {
 		if ii := workload.InterceptInfo; ii != nil {
-			return DescribeIntercept(ii, nil, s.debug)
+			return DescribeIntercept(ii, s.debug)
 		}
 		ai := workload.AgentInfo
 		if ai != nil {
@@ -104,7 +104,7 @@ func (s *listInfo) list(cmd *cobra.Command, _ []string) error {
 	return nil
 }
 
-func DescribeIntercept(ii *manager.InterceptInfo, volumeMountsPrevented error, debug bool) string {
+func DescribeIntercept(ii *manager.InterceptInfo, debug bool) string {
 	msg := "intercepted"
 
 	type kv struct {
@@ -145,8 +145,6 @@ func DescribeIntercept(ii *manager.InterceptInfo, volumeMountsPrevented error, d
 
 	if ii.Spec.MountPoint != "" {
 		fields = append(fields, kv{"Volume Mount Point", ii.Spec.MountPoint})
-	} else if volumeMountsPrevented != nil {
-		fields = append(fields, kv{"Volume Mount Error", volumeMountsPrevented.Error()})
 	}
 
 	fields = append(fields, kv{"Intercepting", func() string {
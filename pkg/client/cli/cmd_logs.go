@@ -0,0 +1,262 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/client/logging"
+	"github.com/telepresenceio/telepresence/v2/pkg/filelocation"
+)
+
+// logNames are the names of the logs that the logs command can show
+var logNames = []string{"connector", "daemon"}
+
+// followInterval is how often the logs are checked for new lines when they are followed
+const followInterval = 250 * time.Millisecond
+
+func logsCommand() *cobra.Command {
+	var since time.Duration
+	var follow bool
+	cmd := &cobra.Command{
+		Use:       "logs [connector|daemon]",
+		Args:      cobra.MaximumNArgs(1),
+		ValidArgs: logNames,
+
+		Short: "Show the logs of the daemons",
+		Long: `Show the logs of the daemons. The logs of both the user daemon (connector) and the
+root daemon are shown unless one of them is given as an argument. Rotated logs are
+included when --since is used.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			names := logNames
+			if len(args) > 0 {
+				if !stringSliceContains(logNames, args[0]) {
+					return fmt.Errorf("unknown log %q, expected one of %s", args[0], strings.Join(logNames, ", "))
+				}
+				names = args
+			}
+			dir, err := filelocation.AppUserLogDir(cmd.Context())
+			if err != nil {
+				return err
+			}
+			var cutoff time.Time
+			if since > 0 {
+				cutoff = time.Now().Add(-since)
+			}
+			return showLogs(cmd.Context(), cmd.OutOrStdout(), dir, names, cutoff, follow)
+		},
+	}
+	flags := cmd.Flags()
+	flags.DurationVar(&since, "since", 0, "Only show lines logged within the given duration, e.g. 10m or 2h")
+	flags.BoolVarP(&follow, "follow", "f", false, "Keep showing new lines as they are logged")
+	return cmd
+}
+
+func stringSliceContains(ss []string, s string) bool {
+	for _, x := range ss {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+func showLogs(ctx context.Context, out io.Writer, dir string, names []string, cutoff time.Time, follow bool) error {
+	prefixWidth := 0
+	if len(names) > 1 {
+		for _, name := range names {
+			if len(name) > prefixWidth {
+				prefixWidth = len(name)
+			}
+		}
+	}
+
+	var lines []logLine
+	var sources []*logSource
+	defer func() {
+		for _, src := range sources {
+			src.close()
+		}
+	}()
+	for _, name := range names {
+		src := &logSource{path: filepath.Join(dir, name+".log")}
+		if prefixWidth > 0 {
+			src.prefix = fmt.Sprintf("%-*s | ", prefixWidth, name)
+		}
+		sources = append(sources, src)
+		if !cutoff.IsZero() {
+			rotated, err := logging.RotatedLogFiles(dir, name, cutoff)
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			for _, path := range rotated {
+				data, err := logging.ReadLogFile(path)
+				if err != nil {
+					return err
+				}
+				lines = src.appendLines(lines, data, true)
+			}
+		}
+		var err error
+		if lines, err = src.poll(lines); err != nil {
+			return err
+		}
+	}
+	if !follow && len(lines) == 0 {
+		found := false
+		for _, src := range sources {
+			if src.file != nil {
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("no logs found in %s", dir)
+		}
+	}
+
+	// Lines without a timestamp inherit the one of the preceding line, so a stable sort keeps them
+	// together with the line that they belong to.
+	sort.SliceStable(lines, func(i, j int) bool { return lines[i].time.Before(lines[j].time) })
+	printLines(out, lines, cutoff)
+	if !follow {
+		return nil
+	}
+
+	ticker := time.NewTicker(followInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		lines = lines[:0]
+		for _, src := range sources {
+			var err error
+			if lines, err = src.poll(lines); err != nil {
+				return err
+			}
+		}
+		printLines(out, lines, cutoff)
+	}
+}
+
+func printLines(out io.Writer, lines []logLine, cutoff time.Time) {
+	for _, l := range lines {
+		if !l.time.Before(cutoff) {
+			fmt.Fprintln(out, l.text)
+		}
+	}
+}
+
+type logLine struct {
+	time time.Time
+	text string
+}
+
+// logSource reads a log file and remembers how far it has been read, so that it can be followed
+// across rotations.
+type logSource struct {
+	path   string
+	prefix string
+	file   *os.File
+
+	// partial is the last line read, if it wasn't terminated by a newline yet
+	partial []byte
+
+	// last is the timestamp of the last line read
+	last time.Time
+}
+
+// poll appends the lines that have been added to the log since the last call. The file is opened on
+// the first call and reopened when it has been rotated.
+func (s *logSource) poll(lines []logLine) ([]logLine, error) {
+	st, err := os.Stat(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return lines, nil
+		}
+		return lines, err
+	}
+	if s.file != nil {
+		if fst, err := s.file.Stat(); err == nil && os.SameFile(fst, st) {
+			return s.readNew(lines)
+		}
+		// The file has been rotated. Drain the old one before switching over.
+		lines, _ = s.readNew(lines)
+		lines = s.appendLines(lines, nil, true)
+		s.close()
+	}
+	if s.file, err = os.Open(s.path); err != nil {
+		return lines, err
+	}
+	return s.readNew(lines)
+}
+
+func (s *logSource) readNew(lines []logLine) ([]logLine, error) {
+	data, err := ioutil.ReadAll(s.file)
+	return s.appendLines(lines, data, false), err
+}
+
+func (s *logSource) close() {
+	if s.file != nil {
+		_ = s.file.Close()
+		s.file = nil
+	}
+}
+
+// appendLines appends the lines found in data. An unterminated last line is retained until more
+// data arrives unless final is true.
+func (s *logSource) appendLines(lines []logLine, data []byte, final bool) []logLine {
+	if len(s.partial) > 0 {
+		data = append(s.partial, data...)
+		s.partial = nil
+	}
+	for len(data) > 0 {
+		eol := bytes.IndexByte(data, '\n')
+		var line []byte
+		if eol < 0 {
+			if !final {
+				s.partial = append([]byte(nil), data...)
+				break
+			}
+			line, data = data, nil
+		} else {
+			line, data = data[:eol], data[eol+1:]
+		}
+		if ts, ok := lineTime(line); ok {
+			s.last = ts
+		}
+		lines = append(lines, logLine{time: s.last, text: s.prefix + string(line)})
+	}
+	return lines
+}
+
+// lineTime returns the timestamp of a line written using either the text or the JSON format
+func lineTime(line []byte) (time.Time, bool) {
+	if len(line) > 0 && line[0] == '{' {
+		var entry struct {
+			Time string `json:"time"`
+		}
+		if json.Unmarshal(line, &entry) != nil {
+			return time.Time{}, false
+		}
+		ts, err := time.Parse(time.RFC3339Nano, entry.Time)
+		return ts, err == nil
+	}
+	if len(line) < len(logging.FileTimeFormat) {
+		return time.Time{}, false
+	}
+	ts, err := time.ParseInLocation(logging.FileTimeFormat, string(line[:len(logging.FileTimeFormat)]), time.Local)
+	return ts, err == nil
+}
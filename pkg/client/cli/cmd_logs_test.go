@@ -0,0 +1,42 @@
+package cli
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogSourceAppendLines(t *testing.T) {
+	src := &logSource{prefix: "connector | "}
+	lines := src.appendLines(nil, []byte("2021/06/01 10:00:00.0000 info    first\ncontinued\n2021/06/01 10:00:01.0000 info    sec"), false)
+	require.Len(t, lines, 2)
+	first := time.Date(2021, 6, 1, 10, 0, 0, 0, time.Local)
+	assert.Equal(t, logLine{time: first, text: "connector | 2021/06/01 10:00:00.0000 info    first"}, lines[0])
+	assert.Equal(t, logLine{time: first, text: "connector | continued"}, lines[1])
+
+	// The partial line is completed by the next read
+	lines = src.appendLines(nil, []byte("ond\n"), false)
+	require.Len(t, lines, 1)
+	assert.Equal(t, first.Add(time.Second), lines[0].time)
+	assert.Equal(t, "connector | 2021/06/01 10:00:01.0000 info    second", lines[0].text)
+
+	// An unterminated line is only emitted when the data is final
+	assert.Empty(t, src.appendLines(nil, []byte("third"), false))
+	lines = src.appendLines(nil, nil, true)
+	require.Len(t, lines, 1)
+	assert.Equal(t, "connector | third", lines[0].text)
+}
+
+func TestLineTime(t *testing.T) {
+	ts, ok := lineTime([]byte(`{"level":"info","msg":"hello","time":"2021-06-01T10:00:00.5Z"}`))
+	require.True(t, ok)
+	assert.True(t, ts.Equal(time.Date(2021, 6, 1, 10, 0, 0, 500000000, time.UTC)))
+
+	_, ok = lineTime([]byte("goroutine 1 [running]:"))
+	assert.False(t, ok)
+
+	_, ok = lineTime([]byte(`{"msg":"no time"}`))
+	assert.False(t, ok)
+}
@@ -0,0 +1,135 @@
+package cli
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"sigs.k8s.io/yaml"
+
+	"github.com/datawire/ambassador/pkg/kates"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/install/resource"
+)
+
+type manifestsInfo struct {
+	managerNamespace string
+	clusterID        string
+	outputDir        string
+}
+
+func manifestsCommand() *cobra.Command {
+	mi := &manifestsInfo{}
+	cmd := &cobra.Command{
+		Use:  "manifests [flags]",
+		Args: cobra.NoArgs,
+
+		Short: "Print the manifests of the traffic-manager and its agent injector webhook",
+		Long: "Print the manifests of the traffic-manager and its agent injector webhook, i.e. the " +
+			"resources that telepresence creates when it installs the traffic-manager. The cluster isn't " +
+			"contacted, so the manifests can be applied by other tools.\n\n" +
+			"With --output-dir, each resource is written to its own file in the given directory together " +
+			"with a kustomization.yaml that lists them. The keys and certificates of the secrets that are " +
+			"found in the directory are reused, so writing to the same directory again yields the same " +
+			"manifests.",
+		RunE: mi.run,
+	}
+	flags := cmd.Flags()
+	flags.StringVar(&mi.managerNamespace, "manager-namespace", client.DefaultManagerNamespace, "namespace of the traffic-manager")
+	flags.StringVar(&mi.clusterID, "cluster-id", "", "ID of the cluster, see \"telepresence current-cluster-id\"")
+	flags.StringVar(&mi.outputDir, "output-dir", "", "write the manifests and a kustomization.yaml to this directory")
+	return cmd
+}
+
+// manifestFileName returns the name of the file that the given object is written to.
+func manifestFileName(obj kates.Object) string {
+	return strings.ToLower(obj.GetObjectKind().GroupVersionKind().Kind + "-" + obj.GetName() + ".yaml")
+}
+
+// previousSecrets returns the data of the secrets that are found in the YAML files of the given
+// directory, by secret name.
+func previousSecrets(dir string) (map[string]map[string][]byte, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "secret-*.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	secrets := make(map[string]map[string][]byte)
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		var sec kates.Secret
+		if err = yaml.Unmarshal(data, &sec); err != nil {
+			return nil, fmt.Errorf("unable to parse %s: %w", file, err)
+		}
+		if sec.Kind == "Secret" && len(sec.Data) > 0 {
+			secrets[sec.Name] = sec.Data
+		}
+	}
+	return secrets, nil
+}
+
+func (mi *manifestsInfo) run(cmd *cobra.Command, _ []string) error {
+	ctx := cmd.Context()
+	env, err := client.LoadEnv(ctx)
+	if err != nil {
+		return err
+	}
+	opts := &resource.RenderOptions{
+		Namespace: mi.managerNamespace,
+		ClusterID: mi.clusterID,
+	}
+	if mi.outputDir != "" {
+		if opts.PreviousSecrets, err = previousSecrets(mi.outputDir); err != nil {
+			return err
+		}
+	}
+	objs, err := resource.RenderTrafficManager(ctx, opts, &env)
+	if err != nil {
+		return err
+	}
+
+	if mi.outputDir == "" {
+		out := cmd.OutOrStdout()
+		for _, obj := range objs {
+			data, err := yaml.Marshal(obj)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(out, "---\n%s", data)
+		}
+		return nil
+	}
+
+	if err = os.MkdirAll(mi.outputDir, 0755); err != nil {
+		return err
+	}
+	names := make([]string, len(objs))
+	for i, obj := range objs {
+		data, err := yaml.Marshal(obj)
+		if err != nil {
+			return err
+		}
+		names[i] = manifestFileName(obj)
+		perm := os.FileMode(0644)
+		if _, ok := obj.(*kates.Secret); ok {
+			perm = 0600
+		}
+		if err = ioutil.WriteFile(filepath.Join(mi.outputDir, names[i]), data, perm); err != nil {
+			return err
+		}
+	}
+	kustomization, err := yaml.Marshal(map[string]interface{}{
+		"apiVersion": "kustomize.config.k8s.io/v1beta1",
+		"kind":       "Kustomization",
+		"resources":  names,
+	})
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(mi.outputDir, "kustomization.yaml"), kustomization, 0644)
+}
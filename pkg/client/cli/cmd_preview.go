@@ -56,7 +56,7 @@ func previewCommand() *cobra.Command {
 					if err != nil {
 						return err
 					}
-					fmt.Println(DescribeIntercept(intercept, nil, false))
+					fmt.Println(DescribeIntercept(intercept, false))
 					return nil
 				})
 			})
@@ -82,7 +82,7 @@ func previewCommand() *cobra.Command {
 					if err != nil {
 						return err
 					}
-					fmt.Println(DescribeIntercept(intercept, nil, false))
+					fmt.Println(DescribeIntercept(intercept, false))
 					return nil
 				})
 			})
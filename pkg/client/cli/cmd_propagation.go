@@ -0,0 +1,132 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/propagation"
+)
+
+func propagationCommand() *cobra.Command {
+	var output string
+	cmd := &cobra.Command{
+		Use:  "propagation [flags] [<intercept name>]",
+		Args: cobra.MaximumNArgs(1),
+
+		Short: "Generate docs on how to propagate the headers of intercepts",
+		Long: `Generate Markdown that describes the headers that the handlers of the current
+intercepts, or of the given intercept, must propagate to the downstream calls that
+they make, so that a request that is routed to one intercept keeps being routed
+to the intercepts of the same developer along a chain of services. The docs show
+how to do it with the Go package ` + "`github.com/telepresenceio/telepresence/v2/pkg/propagation`" + `,
+and what to do in other languages.`,
+		Example: `  telepresence propagation
+  telepresence propagation echo --output PROPAGATION.md`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var iis []*manager.InterceptInfo
+			err := withConnector(cmd, true, func(ctx context.Context, connectorClient connector.ConnectorClient, _ *connector.ConnectInfo) error {
+				r, err := connectorClient.List(ctx, &connector.ListRequest{Filter: connector.ListRequest_INTERCEPTS})
+				if err != nil {
+					return err
+				}
+				for _, w := range r.Workloads {
+					if ii := w.InterceptInfo; ii != nil && (len(args) == 0 || ii.Spec.Name == args[0]) {
+						iis = append(iis, ii)
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			if len(iis) == 0 {
+				if len(args) > 0 {
+					return fmt.Errorf("no intercept named %q", args[0])
+				}
+				return fmt.Errorf("there are no intercepts")
+			}
+			out := cmd.OutOrStdout()
+			if output != "" && output != "-" {
+				f, err := os.Create(output)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				out = f
+			}
+			return writePropagationDocs(out, iis)
+		},
+	}
+	cmd.Flags().StringVarP(&output, "output", "o", "", "Write the docs to this file instead of stdout")
+	return cmd
+}
+
+// propagatedHeaders returns the names of the headers that the handler of the given intercept must
+// propagate.
+func propagatedHeaders(ii *manager.InterceptInfo) []string {
+	headers := []string{propagation.DefaultHeader}
+	for h := range matchedHeaders(ii.Spec.MechanismArgs) {
+		if h = strings.ToLower(h); h != propagation.DefaultHeader {
+			headers = append(headers, h)
+		}
+	}
+	sort.Strings(headers[1:])
+	return headers
+}
+
+func writePropagationDocs(out io.Writer, iis []*manager.InterceptInfo) error {
+	sb := strings.Builder{}
+	sb.WriteString("# Propagating intercept headers\n\n")
+	sb.WriteString("A personal intercept only routes the requests that carry its headers to the local handler. ")
+	sb.WriteString("When a request travels through a chain of services, each service must copy these headers ")
+	sb.WriteString("from the request that it serves to the requests that it makes, or else the requests further ")
+	sb.WriteString("down the chain aren't routed to the other intercepts.\n")
+	for _, ii := range iis {
+		fmt.Fprintf(&sb, "\n## Intercept %s\n\n", ii.Spec.Name)
+		fmt.Fprintf(&sb, "Workload `%s` in namespace `%s`", ii.Spec.Agent, ii.Spec.Namespace)
+		if ii.MechanismArgsDesc != "" {
+			fmt.Fprintf(&sb, ", intercepting %s", ii.MechanismArgsDesc)
+		}
+		sb.WriteString(".\n\nHeaders to propagate:\n\n")
+		for _, h := range propagatedHeaders(ii) {
+			fmt.Fprintf(&sb, "- `%s`\n", h)
+		}
+	}
+	sb.WriteString(`
+## Go
+
+The handler that runs under ` + "`telepresence intercept`" + ` finds the headers of its intercept using
+the metadata endpoint in ` + "`$" + propagation.MetadataURLEnv + "`" + `:
+
+` + "```go" + `
+import "github.com/telepresenceio/telepresence/v2/pkg/propagation"
+
+p, err := propagation.FromEnvironment(ctx)
+if err != nil {
+	return err
+}
+http.Handle("/", p.Middleware(handler))
+client := &http.Client{Transport: p.Transport(nil)}
+` + "```" + `
+
+The handler must pass the context of the request that it serves to the requests that it makes with
+the client, e.g. using ` + "`http.NewRequestWithContext(r.Context(), ...)`" + `. The same must be done
+by all the services of the chain, so a service that doesn't run under an intercept can use
+` + "`propagation.New(headers...)`" + ` with the headers listed above.
+
+## Other languages
+
+Copy each of the headers listed above from the incoming request to all outgoing requests that are
+made on its behalf. Tracing libraries that support "baggage" can often be configured to do this.
+`)
+	_, err := io.WriteString(out, sb.String())
+	return err
+}
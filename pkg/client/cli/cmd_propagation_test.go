@@ -0,0 +1,32 @@
+package cli
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+)
+
+func TestWritePropagationDocs(t *testing.T) {
+	ii := &manager.InterceptInfo{
+		Spec: &manager.InterceptSpec{
+			Name:          "echo",
+			Agent:         "echo",
+			Namespace:     "default",
+			MechanismArgs: []string{"--http-match=X-User=alice", "--http-match=auto"},
+		},
+		MechanismArgsDesc: "HTTP requests that match all of: header(\"x-user\") ~ regexp(\"alice\")",
+	}
+	assert.Equal(t, []string{"x-telepresence-intercept-id", "x-user"}, propagatedHeaders(ii))
+
+	sb := strings.Builder{}
+	require.NoError(t, writePropagationDocs(&sb, []*manager.InterceptInfo{ii}))
+	docs := sb.String()
+	assert.Contains(t, docs, "## Intercept echo\n")
+	assert.Contains(t, docs, "Workload `echo` in namespace `default`, intercepting HTTP requests")
+	assert.Contains(t, docs, "- `x-telepresence-intercept-id`\n- `x-user`\n")
+	assert.Contains(t, docs, "$TELEPRESENCE_METADATA_URL")
+}
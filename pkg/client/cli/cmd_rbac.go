@@ -0,0 +1,78 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"sigs.k8s.io/yaml"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/install/resource"
+)
+
+type rbacPrintInfo struct {
+	managerNamespace string
+	namespaces       []string
+	jobCopies        []string
+	only             string
+}
+
+func rbacCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:  "rbac",
+		Args: cobra.NoArgs,
+
+		Short: "Show the RBAC needed by telepresence",
+	}
+	cmd.AddCommand(rbacPrintCommand())
+	return cmd
+}
+
+func rbacPrintCommand() *cobra.Command {
+	ri := &rbacPrintInfo{}
+	cmd := &cobra.Command{
+		Use:  "print [flags]",
+		Args: cobra.NoArgs,
+
+		Short: "Print the minimal Roles and ClusterRoles needed by developers and the traffic-manager",
+		Long: "Print the minimal Roles and ClusterRoles needed by developers and the traffic-manager. " +
+			"Cluster wide roles are printed unless one or more namespaces are given, in which case only " +
+			"namespaced roles are printed. Bindings are not printed since their subjects are site specific.",
+		RunE: ri.run,
+	}
+	flags := cmd.Flags()
+	flags.StringVar(&ri.managerNamespace, "manager-namespace", client.DefaultManagerNamespace, "namespace of the traffic-manager")
+	flags.StringSliceVarP(&ri.namespaces, "namespace", "n", nil, "restrict the roles to the given namespaces")
+	flags.StringSliceVar(&ri.jobCopies, "job-copy-namespace", nil,
+		"namespaces where a traffic-manager that isn't namespaced may remove the copies of jobs that clients left behind")
+	flags.StringVar(&ri.only, "only", "", `print only the roles for "developer" or "manager"`)
+	return cmd
+}
+
+func (ri *rbacPrintInfo) run(cmd *cobra.Command, _ []string) error {
+	opts := &resource.RBACOptions{
+		ManagerNamespace:  ri.managerNamespace,
+		Namespaces:        ri.namespaces,
+		JobCopyNamespaces: ri.jobCopies,
+	}
+	switch ri.only {
+	case "":
+		opts.Developer = true
+		opts.Manager = true
+	case "developer":
+		opts.Developer = true
+	case "manager":
+		opts.Manager = true
+	default:
+		return fmt.Errorf(`--only must be "developer" or "manager", not %q`, ri.only)
+	}
+	out := cmd.OutOrStdout()
+	for _, obj := range resource.RBACObjects(opts) {
+		data, err := yaml.Marshal(obj)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "---\n%s", data)
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc"
+
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/cliutil"
+)
+
+func restartCommand() *cobra.Command {
+	var namespace string
+	cmd := &cobra.Command{
+		Use:  "restart [flags] <workload>",
+		Args: cobra.ExactArgs(1),
+
+		Short: "Restart the pods of a workload and wait until they're ready",
+		Long: `Restart the pods of a Deployment or StatefulSet, the way "kubectl rollout restart" does,
+or delete the pods of a ReplicaSet, and wait until the new pods are ready. The restart is made by
+the user daemon, so it uses the Kubernetes context and namespace of the current connection. This
+is handy when the remote side of an intercept needs a bounce. The intercepts of the workload
+remain, and are served by the new pods once they're ready.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			w := &connector.Workload{Name: args[0], Namespace: namespace}
+			return withConnector(cmd, true, func(ctx context.Context, _ connector.ConnectorClient, _ *connector.ConnectInfo) error {
+				return cliutil.WithConnectorConn(ctx, func(ctx context.Context, conn grpc.ClientConnInterface) error {
+					fmt.Fprintf(cmd.OutOrStdout(), "Restarting %s...\n", w.Name)
+					if _, err := connector.NewWorkloadsClient(conn).RestartWorkload(ctx, w); err != nil {
+						return err
+					}
+					fmt.Fprintf(cmd.OutOrStdout(), "Restarted %s\n", w.Name)
+					return nil
+				})
+			})
+		},
+	}
+	cmd.Flags().StringVarP(&namespace, "namespace", "n", "", "If present, the namespace scope for this CLI request")
+	return cmd
+}
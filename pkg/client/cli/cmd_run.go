@@ -0,0 +1,105 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	grpccodes "google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/rpc/v2/daemon"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/cache"
+)
+
+func runCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:  "run [-- <command with arguments...>]",
+		Args: cobra.ArbitraryArgs,
+
+		Short: "Run a command whose traffic is routed to the cluster",
+		Long: "Run a command, or a shell when no command is given, whose traffic to the cluster is routed " +
+			"through telepresence. When process scoped routing is enabled with routing.processScoped in " +
+			"the config, the traffic of other processes on the workstation never reaches the cluster. " +
+			"Process scoped routing is only supported on Linux. When connected using --proxy-via-container, " +
+			"the command is directed to the SOCKS5 proxy using the ALL_PROXY environment variables.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 {
+				shell := os.Getenv("SHELL")
+				if shell == "" {
+					shell = "/bin/sh"
+				}
+				args = []string{shell}
+			}
+			return runRouted(cmd, args)
+		},
+	}
+}
+
+func curlCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:  "curl [<curl arguments...>] <url>",
+		Args: cobra.MinimumNArgs(1),
+
+		Short:              "Run curl with its traffic routed to the cluster",
+		Long:               "Run curl, like \"telepresence run -- curl\", to verify that a service in the cluster can be reached.",
+		Example:            "  telepresence curl http://myservice.ns:8080/health",
+		DisableFlagParsing: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runRouted(cmd, append([]string{"curl"}, args...))
+		},
+	}
+}
+
+// runRouted connects and then runs the given command with its traffic routed to the cluster.
+func runRouted(cmd *cobra.Command, args []string) error {
+	return withConnector(cmd, false, func(ctx context.Context, _ connector.ConnectorClient, _ *connector.ConnectInfo) error {
+		if !client.SocketExistsContext(ctx, client.DaemonSocketName) {
+			// Proxy mode. Only a client that uses the SOCKS5 proxy reaches the cluster.
+			env, err := proxyEnv(ctx)
+			if err != nil {
+				return err
+			}
+			return start(ctx, args[0], args[1:], true, cmd.InOrStdin(), cmd.OutOrStdout(), cmd.ErrOrStderr(), env...)
+		}
+
+		conn, err := client.DialSocket(ctx, client.DaemonSocketName)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+
+		// The command inherits the cgroup of this process. The traffic of all processes is
+		// routed unless the daemon uses process scoped routing.
+		_, err = daemon.NewDaemonClient(conn).AddRoutedProcess(ctx, &daemon.RoutedProcess{Pid: int32(os.Getpid())})
+		if err != nil && grpcstatus.Code(err) != grpccodes.FailedPrecondition {
+			return err
+		}
+		return start(ctx, args[0], args[1:], true, cmd.InOrStdin(), cmd.OutOrStdout(), cmd.ErrOrStderr())
+	})
+}
+
+// proxyEnv returns the environment that directs a command to the SOCKS5 proxy of a connector that
+// runs in proxy mode. The socks5h scheme makes the proxy resolve the names, so that the names of
+// the cluster resolve too.
+func proxyEnv(ctx context.Context) ([]string, error) {
+	addr, err := cache.LoadProxyAddressFromUserCache(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if addr == "" {
+		return nil, errProxyAddressUnknown
+	}
+	proxy := "socks5h://" + addr
+	var env []string
+	for _, name := range []string{"ALL_PROXY", "HTTP_PROXY", "HTTPS_PROXY"} {
+		env = append(env, name+"="+proxy, strings.ToLower(name)+"="+proxy)
+	}
+	return env, nil
+}
+
+var errProxyAddressUnknown = errors.New("the address of the SOCKS5 proxy is unknown, reconnect using --proxy-via-container")
@@ -0,0 +1,227 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/clientapi"
+)
+
+// runSpec is the content of the file given to the run-spec command.
+type runSpec struct {
+	Connection clientapi.ConnectRequest     `yaml:"connection,omitempty"`
+	Intercepts []clientapi.InterceptRequest `yaml:"intercepts,omitempty"`
+
+	// Forwards are the services that the intercepted processes depend on, forwarded to ports on
+	// localhost.
+	Forwards []clientapi.Forward `yaml:"forwards,omitempty"`
+}
+
+func parseRunSpec(data []byte) (*runSpec, error) {
+	spec := &runSpec{}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(spec); err != nil && err != io.EOF {
+		return nil, err
+	}
+	for i := range spec.Intercepts {
+		if spec.Intercepts[i].Name == "" {
+			return nil, fmt.Errorf("intercept %d has no name", i+1)
+		}
+	}
+	for i := range spec.Forwards {
+		if fw := &spec.Forwards[i]; fw.Service == "" || fw.LocalPort == 0 {
+			return nil, fmt.Errorf("forward %d needs a service and a localPort", i+1)
+		}
+	}
+	return spec, nil
+}
+
+// runSpecEvent is a lifecycle event of the run-spec command.
+type runSpecEvent struct {
+	Event     string `json:"event"`
+	Context   string `json:"context,omitempty"`
+	Intercept string `json:"intercept,omitempty"`
+	Service   string `json:"service,omitempty"`
+	Port      uint16 `json:"port,omitempty"`
+	Message   string `json:"message,omitempty"`
+}
+
+type runSpecInfo struct {
+	cmd        *cobra.Command
+	wait       bool
+	jsonEvents bool
+}
+
+func runSpecCommand() *cobra.Command {
+	ri := runSpecInfo{}
+	cmd := &cobra.Command{
+		Use:  "run-spec <file>",
+		Args: cobra.ExactArgs(1),
+
+		Short: "Connect and create the intercepts of a spec file",
+		Long: `Connect and create the intercepts of a spec file, or of stdin when the file is "-".
+The file is YAML, e.g.:
+
+  connection:
+    kubeFlags:
+      context: dev
+    mappedNamespaces: [default]
+  intercepts:
+  - name: echo
+    namespace: default
+    port: 8080
+  forwards:
+  - service: redis
+    localPort: 6379
+
+The forwards are created before the intercepts, and are removed together with
+them. The intercepts are left when one of them can't be created. Unless --wait is
+used, the command returns once all intercepts are created and they remain
+until removed using "telepresence leave". With --wait, the command instead
+waits until it's interrupted or terminated and then leaves the intercepts.
+
+With --json-events, progress is reported as one JSON object per line on stdout,
+with an "event" that is one of "connecting", "connected", "forwarded",
+"intercepting", "intercepted", "ready", "leaving", "done", and "error". This makes the command
+suitable for Skaffold custom actions and Tilt local resources.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ri.cmd = cmd
+			return ri.run(args[0])
+		},
+	}
+	flags := cmd.Flags()
+	flags.BoolVar(&ri.wait, "wait", false, "Wait until interrupted, then leave the intercepts")
+	flags.BoolVar(&ri.jsonEvents, "json-events", false, "Report progress as JSON lines on stdout")
+	return cmd
+}
+
+func (ri *runSpecInfo) emit(ev *runSpecEvent) {
+	out := ri.cmd.OutOrStdout()
+	if ri.jsonEvents {
+		data, _ := json.Marshal(ev)
+		fmt.Fprintf(out, "%s\n", data)
+		return
+	}
+	switch ev.Event {
+	case "connected":
+		fmt.Fprintf(out, "Connected to context %s\n", ev.Context)
+	case "forwarded":
+		fmt.Fprintf(out, "Forwarding localhost:%d to %s\n", ev.Port, ev.Service)
+	case "intercepted":
+		fmt.Fprintf(out, "Intercept %s routes traffic to port %d\n", ev.Intercept, ev.Port)
+	case "ready":
+		fmt.Fprintln(out, "All intercepts are ready")
+	case "leaving":
+		fmt.Fprintf(out, "Leaving intercept %s\n", ev.Intercept)
+	}
+}
+
+func (ri *runSpecInfo) run(file string) (err error) {
+	defer func() {
+		if err != nil {
+			ri.emit(&runSpecEvent{Event: "error", Message: err.Error()})
+		}
+	}()
+
+	var data []byte
+	if file == "-" {
+		data, err = ioutil.ReadAll(ri.cmd.InOrStdin())
+	} else {
+		data, err = ioutil.ReadFile(file)
+	}
+	if err != nil {
+		return err
+	}
+	spec, err := parseRunSpec(data)
+	if err != nil {
+		return fmt.Errorf("invalid spec %s: %w", file, err)
+	}
+
+	// Flags given on the command line apply unless the spec overrides them.
+	cr := spec.Connection
+	kf := kubeFlagMap()
+	for k, v := range cr.KubeFlags {
+		kf[k] = v
+	}
+	cr.KubeFlags = kf
+	if len(cr.MappedNamespaces) == 0 {
+		cr.MappedNamespaces = mappedNamespaces
+	}
+	if cr.DNSIP == "" {
+		cr.DNSIP = dnsIP
+	}
+
+	// The daemons are started using this executable rather than the one in the PATH.
+	clientapi.SetExecutable(client.GetExe())
+
+	ctx := ri.cmd.Context()
+	ri.emit(&runSpecEvent{Event: "connecting"})
+	info, err := clientapi.Connect(ctx, &cr)
+	if err != nil {
+		return err
+	}
+	ri.emit(&runSpecEvent{Event: "connected", Context: info.ClusterContext})
+
+	var created []string
+	var forwarded []uint16
+	leave := func() error {
+		var leaveErr error
+		for _, name := range created {
+			ri.emit(&runSpecEvent{Event: "leaving", Intercept: name})
+			if err := clientapi.Leave(ctx, name); err != nil && leaveErr == nil {
+				leaveErr = err
+			}
+		}
+		for _, port := range forwarded {
+			if err := clientapi.RemoveForward(ctx, port); err != nil && leaveErr == nil {
+				leaveErr = err
+			}
+		}
+		return leaveErr
+	}
+	for i := range spec.Forwards {
+		fw := &spec.Forwards[i]
+		if err := clientapi.AddForward(ctx, fw); err != nil {
+			_ = leave()
+			return err
+		}
+		forwarded = append(forwarded, fw.LocalPort)
+		ri.emit(&runSpecEvent{Event: "forwarded", Service: fw.Service, Port: fw.LocalPort})
+	}
+	for i := range spec.Intercepts {
+		rq := &spec.Intercepts[i]
+		ri.emit(&runSpecEvent{Event: "intercepting", Intercept: rq.Name})
+		icept, err := clientapi.CreateIntercept(ctx, rq)
+		if err != nil {
+			_ = leave()
+			return err
+		}
+		created = append(created, rq.Name)
+		ri.emit(&runSpecEvent{Event: "intercepted", Intercept: rq.Name, Port: uint16(icept.Info.Spec.TargetPort)})
+	}
+	ri.emit(&runSpecEvent{Event: "ready"})
+	if !ri.wait {
+		return nil
+	}
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigCh)
+	<-sigCh
+	if err = leave(); err != nil {
+		return err
+	}
+	ri.emit(&runSpecEvent{Event: "done"})
+	return nil
+}
@@ -0,0 +1,52 @@
+package cli
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/clientapi"
+)
+
+func TestParseRunSpec(t *testing.T) {
+	spec, err := parseRunSpec([]byte(`
+connection:
+  kubeFlags:
+    context: dev
+  mappedNamespaces: [default, other]
+intercepts:
+- name: echo
+  namespace: default
+  servicePort: http
+  port: 8081
+  extraPorts: [9000]
+- name: web
+forwards:
+- service: redis
+  namespace: cache
+  localPort: 6380
+  port: "6379"
+`))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"context": "dev"}, spec.Connection.KubeFlags)
+	assert.Equal(t, []string{"default", "other"}, spec.Connection.MappedNamespaces)
+	require.Len(t, spec.Intercepts, 2)
+	assert.Equal(t, "echo", spec.Intercepts[0].Name)
+	assert.Equal(t, "http", spec.Intercepts[0].ServicePort)
+	assert.Equal(t, uint16(8081), spec.Intercepts[0].Port)
+	assert.Equal(t, []uint16{9000}, spec.Intercepts[0].ExtraPorts)
+	assert.Equal(t, "web", spec.Intercepts[1].Name)
+	assert.Equal(t, []clientapi.Forward{{Service: "redis", Namespace: "cache", LocalPort: 6380, Port: "6379"}}, spec.Forwards)
+
+	spec, err = parseRunSpec(nil)
+	require.NoError(t, err)
+	assert.Empty(t, spec.Intercepts)
+
+	_, err = parseRunSpec([]byte("intercepts:\n- port: 8080\n"))
+	assert.Error(t, err)
+	_, err = parseRunSpec([]byte("intercepts:\n- name: echo\n  prot: 8080\n"))
+	assert.Error(t, err)
+	_, err = parseRunSpec([]byte("forwards:\n- service: redis\n"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,86 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc"
+	empty "google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/cliutil"
+)
+
+func sessionsCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:  "sessions",
+		Args: cobra.NoArgs,
+
+		Short: "List the clients that are connected to the traffic-manager",
+		Long: `List the clients that are connected to the traffic-manager, along with the
+Kubernetes user of each client, when it connected, and what it intercepts. Only
+users and groups listed as admins in the intercept policy of the traffic-manager
+may list the sessions.`,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			return withSessions(cmd, func(ctx context.Context, sessionsClient manager.SessionsClient) error {
+				sessions, err := sessionsClient.ListSessions(ctx, &empty.Empty{})
+				if err != nil {
+					return err
+				}
+				printSessions(cmd.OutOrStdout(), sessions.Sessions)
+				return nil
+			})
+		},
+	}
+	cmd.AddCommand(&cobra.Command{
+		Use:  "kick <session id>",
+		Args: cobra.ExactArgs(1),
+
+		Short: "Remove a client session and its intercepts from the traffic-manager",
+		Long: `Remove a client session, along with its intercepts, from the traffic-manager. Use
+this when a stale client holds an intercept that others need. Only admins may
+kick a session.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return withSessions(cmd, func(ctx context.Context, sessionsClient manager.SessionsClient) error {
+				if _, err := sessionsClient.KickSession(ctx, &manager.SessionInfo{SessionId: args[0]}); err != nil {
+					return err
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "Session %s was removed\n", args[0])
+				return nil
+			})
+		},
+	})
+	return cmd
+}
+
+func withSessions(cmd *cobra.Command, fn func(context.Context, manager.SessionsClient) error) error {
+	return withConnector(cmd, true, func(ctx context.Context, _ connector.ConnectorClient, _ *connector.ConnectInfo) error {
+		return cliutil.WithConnectorConn(ctx, func(ctx context.Context, conn grpc.ClientConnInterface) error {
+			return fn(ctx, manager.NewSessionsClient(conn))
+		})
+	})
+}
+
+func printSessions(out io.Writer, sessions []*manager.ClientSession) {
+	if len(sessions) == 0 {
+		fmt.Fprintln(out, "No sessions")
+		return
+	}
+	tw := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tCLIENT\tUSER\tVERSION\tCONNECTED\tINTERCEPTS")
+	for _, s := range sessions {
+		user := s.User
+		if user == "" {
+			user = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			s.SessionId, s.Name, user, s.Version, s.Connected.AsTime().Local().Format(time.RFC822), strings.Join(s.Intercepts, ","))
+	}
+	_ = tw.Flush()
+}
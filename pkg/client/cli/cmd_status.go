@@ -4,14 +4,18 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"net"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/spf13/cobra"
 	empty "google.golang.org/protobuf/types/known/emptypb"
 
 	"github.com/telepresenceio/telepresence/rpc/v2/connector"
 	"github.com/telepresenceio/telepresence/rpc/v2/daemon"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/cache"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/cliutil"
 	"github.com/telepresenceio/telepresence/v2/pkg/iputil"
 )
@@ -81,28 +85,14 @@ func daemonStatus(cmd *cobra.Command) error {
 func connectorStatus(cmd *cobra.Command) error {
 	out := cmd.OutOrStdout()
 
+	connected := false
 	err := cliutil.WithStartedConnector(cmd.Context(), func(ctx context.Context, connectorClient connector.ConnectorClient) error {
+		connected = true
 		fmt.Fprintln(out, "User Daemon: Running")
 
-		type kv struct {
-			Key   string
-			Value string
-		}
 		var fields []kv
 		defer func() {
-			klen := 0
-			for _, kv := range fields {
-				if len(kv.Key) > klen {
-					klen = len(kv.Key)
-				}
-			}
-			for _, kv := range fields {
-				vlines := strings.Split(strings.TrimSpace(kv.Value), "\n")
-				fmt.Fprintf(out, "  %-*s: %s\n", klen, kv.Key, vlines[0])
-				for _, vline := range vlines[1:] {
-					fmt.Fprintf(out, "    %s\n", vline)
-				}
-			}
+			printFields(out, fields)
 		}()
 
 		version, err := connectorClient.Version(ctx, &empty.Empty{})
@@ -146,23 +136,145 @@ func connectorStatus(cmd *cobra.Command) error {
 		fields = append(fields, kv{"Kubernetes context", status.ClusterContext})
 		if status.BridgeOk {
 			fields = append(fields, kv{"Telepresence proxy", "ON (networking to the cluster is enabled)"})
+		} else if status.ErrorText != "" {
+			fields = append(fields, kv{"Telepresence proxy", fmt.Sprintf("DEGRADED (%s)", status.ErrorText)})
 		} else {
 			fields = append(fields, kv{"Telepresence proxy", "OFF (attempting to connect...)"})
 		}
+		// The health of the mounts is only known to the user daemon, which keeps it in the session state
+		var mounts map[string]*cache.MountState
+		if ss, _ := cache.LoadSessionFromUserCache(ctx); ss != nil {
+			mounts = make(map[string]*cache.MountState, len(ss.Intercepts))
+			for _, ic := range ss.Intercepts {
+				mounts[ic.Name] = ic.Mount
+			}
+		}
 		intercepts := fmt.Sprintf("%d total\n", len(status.GetIntercepts().GetIntercepts()))
 		for _, icept := range status.GetIntercepts().GetIntercepts() {
 			intercepts += fmt.Sprintf("%s: %s\n", icept.Spec.Name, icept.Spec.Client)
+			if icept.Spec.MountPoint != "" {
+				intercepts += fmt.Sprintf("  mount %s: %s\n", icept.Spec.MountPoint, mountHealth(mounts[icept.Spec.Name]))
+			}
 		}
 		fields = append(fields, kv{"Intercepts", intercepts})
 
 		return nil
 	})
-	if err != nil {
-		if errors.Is(err, cliutil.ErrNoConnector) {
-			fmt.Fprintln(out, "User Daemon: Not running")
-			return nil
+	if err != nil && !connected {
+		switch {
+		case errors.Is(err, cliutil.ErrNoConnector):
+			return lastSessionStatus(cmd, daemonNotRunning)
+		case errors.Is(err, syscall.ECONNREFUSED):
+			return lastSessionStatus(cmd, daemonSocketStale)
+		case errors.Is(err, context.DeadlineExceeded):
+			return lastSessionStatus(cmd, daemonUnresponsive)
 		}
+	}
+	return err
+}
+
+// The reasons why the user daemon can't be reached
+const (
+	// daemonNotRunning means that there's no socket
+	daemonNotRunning = iota
+	// daemonSocketStale means that the socket refuses connections, so the daemon is dead
+	daemonSocketStale
+	// daemonUnresponsive means that the daemon accepts the connection but doesn't answer in time
+	daemonUnresponsive
+)
+
+const (
+	stateNeverConnected     = "Never connected"
+	stateDisconnected       = "Disconnected"
+	stateDaemonDead         = "Daemon dead"
+	stateDaemonUnresponsive = "Daemon unresponsive"
+)
+
+// offlineState returns the state of the connection when the user daemon can't be reached for the
+// given reason. The daemon is dead if its socket is stale, or if the last session that it saved
+// didn't end while there's no socket.
+func offlineState(reason int, ss *cache.SessionState) string {
+	switch {
+	case reason == daemonSocketStale:
+		return stateDaemonDead
+	case reason == daemonUnresponsive:
+		return stateDaemonUnresponsive
+	case ss == nil:
+		return stateNeverConnected
+	case ss.Ended:
+		return stateDisconnected
+	default:
+		return stateDaemonDead
+	}
+}
+
+// lastSessionStatus prints the status of the user daemon when it can't be reached, using the
+// session state that it saved in the user cache.
+func lastSessionStatus(cmd *cobra.Command, reason int) error {
+	out := cmd.OutOrStdout()
+	ss, err := cache.LoadSessionFromUserCache(cmd.Context())
+	if err != nil {
 		return err
 	}
+	switch reason {
+	case daemonSocketStale:
+		fmt.Fprintln(out, "User Daemon: Not running (the socket is stale)")
+	case daemonUnresponsive:
+		fmt.Fprintln(out, "User Daemon: Not responding")
+	default:
+		fmt.Fprintln(out, "User Daemon: Not running")
+	}
+	fields := []kv{{"Status", offlineState(reason, ss)}}
+	if ss != nil {
+		fields = append(fields,
+			kv{"Last session", fmt.Sprintf("%s (pid %d)", ss.SessionID, ss.PID)},
+			kv{"Last updated", ss.Updated.Format(time.RFC3339)},
+			kv{"Kubernetes server", ss.ClusterServer},
+			kv{"Kubernetes context", ss.ClusterContext})
+		if !ss.Ended {
+			intercepts := fmt.Sprintf("%d total\n", len(ss.Intercepts))
+			for _, ic := range ss.Intercepts {
+				intercepts += fmt.Sprintf("%s: %s/%s\n", ic.Name, ic.Namespace, ic.Workload)
+			}
+			fields = append(fields, kv{"Intercepts", intercepts})
+		}
+	}
+	printFields(out, fields)
 	return nil
 }
+
+// mountHealth describes the given mount state for "telepresence status".
+func mountHealth(ms *cache.MountState) string {
+	if ms == nil {
+		return "unknown"
+	}
+	health := ms.Health
+	if ms.Error != "" {
+		health += " (" + ms.Error + ")"
+	}
+	if ms.Remounts > 0 {
+		health += fmt.Sprintf(", remounted %d times", ms.Remounts)
+	}
+	return health
+}
+
+type kv struct {
+	Key   string
+	Value string
+}
+
+func printFields(out io.Writer, fields []kv) {
+	klen := 0
+	for _, kv := range fields {
+		if len(kv.Key) > klen {
+			klen = len(kv.Key)
+		}
+	}
+	for _, kv := range fields {
+		vlines := strings.Split(strings.TrimSpace(kv.Value), "\n")
+		fmt.Fprintf(out, "  %-*s: %s\n", klen, kv.Key, vlines[0])
+		for _, vline := range vlines[1:] {
+			fmt.Fprintf(out, "    %s\n", vline)
+		}
+	}
+}
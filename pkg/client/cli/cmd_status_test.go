@@ -0,0 +1,26 @@
+package cli
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/client/cache"
+)
+
+func TestOfflineState(t *testing.T) {
+	assert.Equal(t, stateNeverConnected, offlineState(daemonNotRunning, nil))
+	assert.Equal(t, stateDisconnected, offlineState(daemonNotRunning, &cache.SessionState{Ended: true}))
+	assert.Equal(t, stateDaemonDead, offlineState(daemonNotRunning, &cache.SessionState{}))
+	assert.Equal(t, stateDaemonDead, offlineState(daemonSocketStale, nil))
+	assert.Equal(t, stateDaemonDead, offlineState(daemonSocketStale, &cache.SessionState{Ended: true}))
+	assert.Equal(t, stateDaemonUnresponsive, offlineState(daemonUnresponsive, nil))
+	assert.Equal(t, stateDaemonUnresponsive, offlineState(daemonUnresponsive, &cache.SessionState{}))
+}
+
+func TestMountHealth(t *testing.T) {
+	assert.Equal(t, "unknown", mountHealth(nil))
+	assert.Equal(t, "healthy", mountHealth(&cache.MountState{Health: cache.MountHealthy}))
+	assert.Equal(t, "healthy, remounted 2 times", mountHealth(&cache.MountState{Health: cache.MountHealthy, Remounts: 2}))
+	assert.Equal(t, "disconnected (sftp connection failed: EOF)", mountHealth(&cache.MountState{Health: cache.MountDisconnected, Error: "sftp connection failed: EOF"}))
+}
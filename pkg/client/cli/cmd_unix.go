@@ -0,0 +1,16 @@
+// +build linux darwin
+
+package cli
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/client/daemon"
+	"github.com/telepresenceio/telepresence/v2/pkg/privhelper"
+)
+
+// addRootDaemonCommands adds the hidden commands of the root daemon and the privileged helper.
+func addRootDaemonCommands(rootCmd *cobra.Command) {
+	rootCmd.AddCommand(daemon.Command())
+	rootCmd.AddCommand(privhelper.Command())
+}
@@ -0,0 +1,170 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/net"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/managerutil"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/connector/userd_trafficmgr"
+	"github.com/telepresenceio/telepresence/v2/pkg/install"
+	"github.com/telepresenceio/telepresence/v2/pkg/usage"
+)
+
+func usageCommand() *cobra.Command {
+	var since string
+	cmd := &cobra.Command{
+		Use:  "usage",
+		Args: cobra.NoArgs,
+
+		Short: "Show how the traffic-manager of the cluster is used",
+		Long: `Show the number of intercepts per user, namespace, and workload, and the volume of
+the traffic that each user has tunneled to and from the cluster. The report is
+aggregated by the traffic-manager, which retains it for 30 days, also when it
+restarts. Only admins according to the intercept policy of the traffic-manager
+may get the report. They're identified like when they connect. The traffic-manager
+is reached through the Kubernetes API server, so this command doesn't require a
+connection, but it requires permission to get services/proxy in the namespace of
+the traffic-manager.`,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			params := map[string]string{}
+			if since != "" {
+				d, err := parseSince(since)
+				if err != nil {
+					return err
+				}
+				params["since"] = d.String()
+			}
+			env, err := client.LoadEnv(cmd.Context())
+			if err != nil {
+				return err
+			}
+			cfg, err := kubeConfig.ToRESTConfig()
+			if err != nil {
+				return err
+			}
+			cs, err := kubernetes.NewForConfig(cfg)
+			if err != nil {
+				return err
+			}
+			ns := env.ManagerNamespace
+			if ns == "" {
+				if ns = client.GetConfig(cmd.Context()).Cluster.ManagerNamespace; ns == "" {
+					ns = client.DefaultManagerNamespace
+				}
+			}
+			bearerToken := cfg.BearerToken
+			if bearerToken == "" && cfg.BearerTokenFile != "" {
+				if data, err := ioutil.ReadFile(cfg.BearerTokenFile); err == nil {
+					bearerToken = strings.TrimSpace(string(data))
+				}
+			}
+			token, err := userd_trafficmgr.IdentityToken(cmd.Context(), bearerToken, false)
+			if err != nil {
+				return fmt.Errorf("unable to read the cluster.managerToken: %w", err)
+			}
+			getUsage := func(token string) ([]byte, error) {
+				req := cs.CoreV1().RESTClient().Get().
+					Namespace(ns).
+					Resource("services").
+					Name(net.JoinSchemeNamePort("http", install.ManagerAppName, "api")).
+					SubResource("proxy").
+					Suffix("usage")
+				for k, v := range params {
+					req = req.Param(k, v)
+				}
+				if token != "" {
+					req = req.SetHeader(managerutil.IdentityTokenHeader, token)
+				}
+				return req.DoRaw(cmd.Context())
+			}
+			data, err := getUsage(token)
+			if err != nil && token == "" && bearerToken != "" && (errors.IsUnauthorized(err) || errors.IsForbidden(err)) {
+				// The traffic-manager wants to know who the user is, so the bearer token of the
+				// kubeconfig is passed, like when the traffic-manager says so during a connect
+				data, err = getUsage(bearerToken)
+			}
+			if err != nil {
+				return fmt.Errorf("unable to get the usage report from the traffic-manager in namespace %s: %w", ns, err)
+			}
+			var r usage.Report
+			if err = json.Unmarshal(data, &r); err != nil {
+				return fmt.Errorf("unable to parse the usage report: %w", err)
+			}
+			printUsage(cmd.OutOrStdout(), &r)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&since, "since", "",
+		`Only report usage within the given duration, e.g. 7d or 12h. The default is everything that the traffic-manager retains`)
+	return cmd
+}
+
+// parseSince parses a duration that, in addition to what time.ParseDuration accepts, can be a
+// number of days, e.g. "7d".
+func parseSince(s string) (time.Duration, error) {
+	if ds := strings.TrimSuffix(s, "d"); ds != s {
+		days, err := strconv.Atoi(ds)
+		if err != nil || days < 0 {
+			return 0, fmt.Errorf("invalid number of days %q", s)
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("invalid negative duration %q", s)
+	}
+	return d, nil
+}
+
+func printUsage(out io.Writer, r *usage.Report) {
+	fmt.Fprintf(out, "Usage since %s\n\n", r.Since.Local().Format(time.RFC1123))
+	if len(r.Intercepts) == 0 {
+		fmt.Fprintln(out, "No intercepts")
+	} else {
+		tw := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(tw, "USER\tNAMESPACE\tWORKLOAD\tINTERCEPTS")
+		for _, ic := range r.Intercepts {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%d\n", ic.User, ic.Namespace, ic.Workload, ic.Count)
+		}
+		_ = tw.Flush()
+	}
+	fmt.Fprintln(out)
+	if len(r.Tunnels) == 0 {
+		fmt.Fprintln(out, "No tunneled traffic")
+	} else {
+		tw := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(tw, "USER\tSENT\tRECEIVED")
+		for _, tv := range r.Tunnels {
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", tv.User, formatBytes(tv.Sent), formatBytes(tv.Received))
+		}
+		_ = tw.Flush()
+	}
+}
+
+func formatBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,44 @@
+package cli
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+)
+
+// The commands that talk to the API server directly, like usage, use the kubeConfig that the
+// Kubernetes flags are parsed into.
+func TestKubeConfigFlags(t *testing.T) {
+	cmd := Command(dlog.NewTestContext(t, false))
+	require.NoError(t, cmd.PersistentFlags().Parse([]string{"--context", "other"}))
+	require.NotNil(t, kubeConfig)
+	require.NotNil(t, kubeConfig.Context)
+	assert.Equal(t, "other", *kubeConfig.Context)
+}
+
+func TestParseSince(t *testing.T) {
+	d, err := parseSince("7d")
+	require.NoError(t, err)
+	assert.Equal(t, 7*24*time.Hour, d)
+
+	d, err = parseSince("90m")
+	require.NoError(t, err)
+	assert.Equal(t, 90*time.Minute, d)
+
+	for _, s := range []string{"d", "1.5d", "-2d", "-1h", "week"} {
+		_, err = parseSince(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	assert.Equal(t, "0 B", formatBytes(0))
+	assert.Equal(t, "1023 B", formatBytes(1023))
+	assert.Equal(t, "1.0 KiB", formatBytes(1024))
+	assert.Equal(t, "1.5 MiB", formatBytes(3*512*1024))
+	assert.Equal(t, "2.0 GiB", formatBytes(2<<30))
+}
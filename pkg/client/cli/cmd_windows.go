@@ -0,0 +1,10 @@
+package cli
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// addRootDaemonCommands adds nothing, because there's no root daemon on Windows. The user daemon
+// reaches the cluster through the proxy of the daemon container, see --proxy-via-container.
+func addRootDaemonCommands(_ *cobra.Command) {
+}
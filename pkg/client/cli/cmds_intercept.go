@@ -2,7 +2,6 @@ package cli
 
 import (
 	"bufio"
-	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
@@ -10,23 +9,27 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"reflect"
 	"regexp"
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 	empty "google.golang.org/protobuf/types/known/emptypb"
 
-	"github.com/datawire/dlib/dcontext"
-	"github.com/datawire/dlib/dexec"
 	"github.com/telepresenceio/telepresence/rpc/v2/connector"
 	"github.com/telepresenceio/telepresence/rpc/v2/manager"
 	"github.com/telepresenceio/telepresence/v2/pkg/client"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/cache"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/cliutil"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/extensions"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/mount"
+	"github.com/telepresenceio/telepresence/v2/pkg/clientapi"
+	"github.com/telepresenceio/telepresence/v2/pkg/propagation"
+	"github.com/telepresenceio/telepresence/v2/pkg/tracing"
 )
 
 type interceptArgs struct {
@@ -46,6 +49,13 @@ type interceptArgs struct {
 	mountSet bool     // whether --mount was passed
 	toPod    []string // --to-pod
 
+	debugPort uint16 // --debug-port
+
+	queue    bool          // --queue // only valid if !localOnly
+	force    bool          // --force // only valid if !localOnly
+	duration time.Duration // --duration // only valid if !localOnly
+	dryRun   bool          // --dry-run // only valid if !localOnly
+
 	dockerRun   bool   // --docker-run
 	dockerMount string // --docker-mount // where to mount in a docker container. Defaults to mount unless mount is "true" or "false".
 
@@ -86,9 +96,11 @@ type interceptState struct {
 
 	// set later ///////////////////////////////////////////////////////////
 
+	intercept  *manager.InterceptInfo
 	env        map[string]string
-	mountPoint string // if non-empty, this the final mount point of a successful mount
-	localPort  uint16 // the parsed <local port>
+	mountPoint string    // if non-empty, this the final mount point of a successful mount
+	localPort  uint16    // the parsed <local port>
+	expires    time.Time // if non-zero, when the traffic-manager removes the intercept
 
 	dockerPort uint16
 }
@@ -137,6 +149,11 @@ func interceptCommand(ctx context.Context) *cobra.Command {
 		`An additional port to forward from the intercepted pod, will be made available at localhost:PORT `+
 		`Use this to, for example, access proxy/helper sidecars in the intercepted pod.`)
 
+	flags.Uint16Var(&args.debugPort, "debug-port", 0, ``+
+		`Make the intercepted process listen for a debugger on this port by adding JAVA_TOOL_OPTIONS and NODE_OPTIONS `+
+		`to its environment. With --docker-run, the port is also published by the container. `+
+		`More variables can be added using the intercept.debugEnv config.`)
+
 	flags.BoolVarP(&args.dockerRun, "docker-run", "", false, ``+
 		`Run a Docker container with intercepted environment, volume mount, by passing arguments after -- to 'docker run', `+
 		`e.g. '--docker-run -- -it --rm ubuntu:20.04 /bin/bash'`)
@@ -146,6 +163,23 @@ func interceptCommand(ctx context.Context) *cobra.Command {
 
 	flags.StringVarP(&args.namespace, "namespace", "n", "", "If present, the namespace scope for this CLI request")
 
+	flags.BoolVarP(&args.queue, "queue", "", false, ``+
+		`If another client is intercepting the workload, wait until it's released instead of failing. `+
+		`Clients that wait for the same workload get it in the order that they asked for it.`)
+
+	flags.BoolVarP(&args.force, "force", "", false, ``+
+		`If another client is intercepting the workload, remove its intercept in favor of this one. `+
+		`Only users that are admins according to the intercept policy of the traffic-manager may do this.`)
+
+	flags.DurationVarP(&args.duration, "duration", "", 0, ``+
+		`How long the intercept lasts, e.g. "2h". The traffic-manager removes the intercept when the duration has passed, `+
+		`even if this workstation is no longer connected. Zero means until it's removed using "telepresence leave".`)
+
+	flags.BoolVarP(&args.dryRun, "dry-run", "", false, ``+
+		`Report what the intercept would change instead of creating it: how the traffic-agent is installed, `+
+		`what changes in the workload and its service, whether you're allowed to make those changes, and `+
+		`what conflicts with the intercept on this workstation.`)
+
 	var extErr error
 	args.extState, extErr = extensions.LoadExtensions(ctx, flags)
 
@@ -178,6 +212,18 @@ func interceptCommand(ctx context.Context) *cobra.Command {
 			if cmd.Flag("preview-url").Changed && args.previewEnabled {
 				return errors.New("a local-only intercept cannot be previewed")
 			}
+			if args.queue || args.force {
+				return errors.New("a local-only intercept cannot be queued or forced")
+			}
+			if args.duration != 0 {
+				return errors.New("a local-only intercept cannot have a duration")
+			}
+			if args.debugPort != 0 {
+				return errors.New("a local-only intercept cannot have a debug port")
+			}
+			if args.dryRun {
+				return errors.New("a local-only intercept doesn't change the cluster, so it cannot be a dry-run")
+			}
 		} else { //nolint:gocritic
 			// Actually intercepting something
 			if args.agentName == "" {
@@ -188,11 +234,34 @@ func interceptCommand(ctx context.Context) *cobra.Command {
 			}
 		}
 		args.mountSet = cmd.Flag("mount").Changed
+
+		// Flags that aren't given default to the intercept config
+		icfg := client.GetConfig(cmd.Context()).Intercept
+		if !args.mountSet && icfg.DefaultMount != "" {
+			args.mount = icfg.DefaultMount
+		}
+		if !cmd.Flag("to-pod").Changed {
+			for _, port := range icfg.ToPod {
+				args.toPod = append(args.toPod, strconv.Itoa(int(port)))
+			}
+		}
+		if args.envFile == "" && icfg.EnvFileDir != "" {
+			if err := os.MkdirAll(icfg.EnvFileDir, 0700); err != nil {
+				return err
+			}
+			args.envFile = filepath.Join(icfg.EnvFileDir, args.name+".env")
+		}
 		if args.dockerRun {
 			if err := validateDockerArgs(args.cmdline); err != nil {
 				return err
 			}
 		}
+		if args.queue && args.force {
+			return errors.New("--queue and --force are mutually exclusive")
+		}
+		if args.duration < 0 {
+			return errors.New("--duration cannot be negative")
+		}
 		// run
 		return intercept(cmd, args)
 	}
@@ -207,7 +276,7 @@ func leaveCommand() *cobra.Command {
 
 		Short: "Remove existing intercept",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return removeIntercept(cmd.Context(), strings.TrimSpace(args[0]))
+			return clientapi.Leave(cmd.Context(), strings.TrimSpace(args[0]))
 		},
 	}
 }
@@ -238,6 +307,13 @@ func loginIfNeeded(ctx context.Context, args interceptArgs) error {
 }
 
 func intercept(cmd *cobra.Command, args interceptArgs) error {
+	if args.dryRun {
+		// report what the intercept would do, without logging in or running anything
+		return withConnector(cmd, true, func(ctx context.Context, connectorClient connector.ConnectorClient, connInfo *connector.ConnectInfo) error {
+			is := newInterceptState(ctx, safeCobraCommandImpl{cmd}, args, connectorClient, nil, connInfo)
+			return is.dryRun(ctx)
+		})
+	}
 	if len(args.cmdline) == 0 && !args.dockerRun {
 		// start and retain the intercept
 		return withConnector(cmd, true, func(ctx context.Context, connectorClient connector.ConnectorClient, connInfo *connector.ConnectInfo) error {
@@ -259,12 +335,27 @@ func intercept(cmd *cobra.Command, args interceptArgs) error {
 		return cliutil.WithManager(ctx, func(ctx context.Context, managerClient manager.ManagerClient) error {
 			is := newInterceptState(ctx, safeCobraCommandImpl{cmd}, args, connectorClient, managerClient, connInfo)
 			return client.WithEnsuredState(ctx, is, false, func() error {
+				defer is.warnBeforeExpiry(ctx)()
 				if args.dockerRun {
 					return is.runInDocker(ctx, is.cmd, args.cmdline)
 				}
+				env := is.env
+				if is.intercept != nil {
+					mdCtx, cancel := context.WithCancel(ctx)
+					defer cancel()
+					url, err := serveInterceptMetadata(mdCtx, newInterceptMetadata(is.intercept, is.env))
+					if err != nil {
+						return fmt.Errorf("unable to serve the intercept metadata: %w", err)
+					}
+					env = make(map[string]string, len(is.env)+1)
+					for k, v := range is.env {
+						env[k] = v
+					}
+					env[propagation.MetadataURLEnv] = url
+				}
 				return start(ctx, args.cmdline[0], args.cmdline[1:], true,
 					cmd.InOrStdin(), cmd.OutOrStdout(), cmd.ErrOrStderr(),
-					envPairs(is.env)...)
+					envPairs(env)...)
 			})
 		})
 	})
@@ -290,96 +381,23 @@ func newInterceptState(
 	}
 }
 
-func interceptMessage(r *connector.InterceptResult) string {
-	msg := ""
-	switch r.Error {
-	case connector.InterceptError_UNSPECIFIED:
-		msg = "No error"
-	case connector.InterceptError_NO_CONNECTION:
-		msg = "Local network is not connected to the cluster"
-	case connector.InterceptError_NO_TRAFFIC_MANAGER:
-		msg = "Intercept unavailable: no traffic manager"
-	case connector.InterceptError_TRAFFIC_MANAGER_CONNECTING:
-		msg = "Connecting to traffic manager..."
-	case connector.InterceptError_TRAFFIC_MANAGER_ERROR:
-		msg = r.ErrorText
-	case connector.InterceptError_ALREADY_EXISTS:
-		msg = fmt.Sprintf("Intercept with name %q already exists", r.ErrorText)
-	case connector.InterceptError_LOCAL_TARGET_IN_USE:
-		spec := r.InterceptInfo.Spec
-		msg = fmt.Sprintf("Port %s:%d is already in use by intercept %s",
-			spec.TargetHost, spec.TargetPort, r.ErrorText)
-	case connector.InterceptError_NO_ACCEPTABLE_WORKLOAD:
-		msg = fmt.Sprintf("No interceptable deployment or replicaset matching %s found", r.ErrorText)
-	case connector.InterceptError_AMBIGUOUS_MATCH:
-		var matches []manager.AgentInfo
-		err := json.Unmarshal([]byte(r.ErrorText), &matches)
-		if err != nil {
-			msg = fmt.Sprintf("Unable to unmarshal JSON: %v", err)
-			break
-		}
-		st := &strings.Builder{}
-		fmt.Fprintf(st, "Found more than one possible match:")
-		for idx := range matches {
-			match := &matches[idx]
-			fmt.Fprintf(st, "\n%4d: %s.%s", idx+1, match.Name, match.Namespace)
-		}
-		msg = st.String()
-	case connector.InterceptError_FAILED_TO_ESTABLISH:
-		msg = fmt.Sprintf("Failed to establish intercept: %s", r.ErrorText)
-	case connector.InterceptError_NOT_FOUND:
-		msg = fmt.Sprintf("Intercept named %q not found", r.ErrorText)
-	case connector.InterceptError_MOUNT_POINT_BUSY:
-		msg = fmt.Sprintf("Mount point already in use by intercept %q", r.ErrorText)
-	default:
-		msg = fmt.Sprintf("Unknown error code %d", r.Error)
-	}
-	if id := r.GetInterceptInfo().GetId(); id != "" {
-		return fmt.Sprintf("Intercept %q: %s", id, msg)
-	}
-	return fmt.Sprintf("Intercept: %s", msg)
-}
-
-func checkMountCapability(ctx context.Context) error {
-	// Use CombinedOutput to include stderr which has information about whether they
-	// need to upgrade to a newer version of macFUSE or not
-	cmd := dexec.CommandContext(ctx, "sshfs", "-V")
-	cmd.DisableLogging = true
-	out, err := cmd.CombinedOutput()
-	if err != nil {
-		return errors.New("sshfs is not installed on your local machine")
-	}
-
-	// OSXFUSE changed to macFUSE and we've noticed that older versions of OSXFUSE
-	// can cause browsers to hang + kernel crashes, so we add an error to prevent
-	// our users from running into this problem.
-	// OSXFUSE isn't included in the output of sshfs -V in versions of 4.0.0 so
-	// we check for that as a proxy for if they have the right version or not.
-	if bytes.Contains(out, []byte("OSXFUSE")) {
-		return errors.New(`macFUSE 4.0.5 or higher is required on your local machine`)
-	}
-	return nil
-}
-
 func (is *interceptState) createRequest(ctx context.Context) (*connector.CreateInterceptRequest, error) {
-	spec := &manager.InterceptSpec{
-		Name:      is.args.name,
-		Namespace: is.args.namespace,
-	}
-	ir := &connector.CreateInterceptRequest{Spec: spec}
-
 	if is.args.agentName == "" {
 		// local-only
-		return ir, nil
+		return &connector.CreateInterceptRequest{Spec: &manager.InterceptSpec{
+			Name:      is.args.name,
+			Namespace: is.args.namespace,
+		}}, nil
 	}
 
-	if is.args.serviceName != "" {
-		spec.ServiceName = is.args.serviceName
+	rq := &clientapi.InterceptRequest{
+		Name:      is.args.name,
+		Namespace: is.args.namespace,
+		Workload:  is.args.agentName,
+		Service:   is.args.serviceName,
+		Duration:  is.args.duration,
 	}
 
-	spec.Agent = is.args.agentName
-	spec.TargetHost = "127.0.0.1"
-
 	// Parse port into spec based on how it's formatted
 	portMapping := strings.Split(is.args.port, ":")
 	portError := func() error {
@@ -402,7 +420,7 @@ func (is *interceptState) createRequest(ctx context.Context) (*connector.CreateI
 		return nil, err
 	}
 	is.localPort = port
-	spec.TargetPort = int32(port)
+	rq.Port = port
 
 	switch len(portMapping) {
 	case 1:
@@ -410,7 +428,7 @@ func (is *interceptState) createRequest(ctx context.Context) (*connector.CreateI
 		if port, err = parsePort(portMapping[1]); err == nil && is.args.dockerRun {
 			is.dockerPort = port
 		} else {
-			spec.ServicePortIdentifier = portMapping[1]
+			rq.ServicePort = portMapping[1]
 		}
 	case 3:
 		if !is.args.dockerRun {
@@ -420,7 +438,7 @@ func (is *interceptState) createRequest(ctx context.Context) (*connector.CreateI
 			return nil, err
 		}
 		is.dockerPort = port
-		spec.ServicePortIdentifier = portMapping[2]
+		rq.ServicePort = portMapping[2]
 	default:
 		return nil, portError()
 	}
@@ -429,34 +447,15 @@ func (is *interceptState) createRequest(ctx context.Context) (*connector.CreateI
 		is.dockerPort = is.localPort
 	}
 
-	doMount := false
-	err = checkMountCapability(ctx)
-	if err == nil {
-		mountPoint := ""
-		doMount, err = strconv.ParseBool(is.args.mount)
-		if err != nil {
-			mountPoint = is.args.mount
-			doMount = len(mountPoint) > 0
-		}
-
-		if doMount {
-			if mountPoint == "" {
-				if mountPoint, err = ioutil.TempDir("", "telfs-"); err != nil {
-					return nil, err
-				}
-			} else {
-				if err = os.MkdirAll(mountPoint, 0700); err != nil {
-					return nil, err
-				}
-			}
-			ir.MountPoint = mountPoint
-		}
-	} else if is.args.mountSet {
-		var boolErr error
-		doMount, boolErr = strconv.ParseBool(is.args.mount)
-		if boolErr != nil || doMount {
-			// not --mount=false, so refuse.
-			return nil, fmt.Errorf("remote volume mounts are disabled: %w", err)
+	mountPoint := ""
+	doMount, err := strconv.ParseBool(is.args.mount)
+	if err != nil {
+		mountPoint = is.args.mount
+		doMount = len(mountPoint) > 0
+	}
+	if doMount {
+		if rq.MountPoint, err = mount.PrepareMountPoint(mountPoint); err != nil {
+			return nil, err
 		}
 	}
 
@@ -465,7 +464,7 @@ func (is *interceptState) createRequest(ctx context.Context) (*connector.CreateI
 		if err != nil {
 			return nil, fmt.Errorf("Unable to parse port %s: %w", toPod, err)
 		}
-		spec.ExtraPorts = append(spec.ExtraPorts, int32(port))
+		rq.ExtraPorts = append(rq.ExtraPorts, port)
 	}
 
 	if is.args.dockerMount != "" {
@@ -476,26 +475,7 @@ func (is *interceptState) createRequest(ctx context.Context) (*connector.CreateI
 			return nil, errors.New("--docker-mount cannot be used with --mount=false")
 		}
 	}
-
-	spec.Mechanism, err = is.args.extState.Mechanism()
-	if err != nil {
-		return nil, err
-	}
-	spec.MechanismArgs, err = is.args.extState.MechanismArgs()
-	if err != nil {
-		return nil, err
-	}
-
-	var env client.Env
-	env, err = client.LoadEnv(ctx)
-	if err != nil {
-		return nil, err
-	}
-	ir.AgentImage, err = is.args.extState.AgentImage(ctx, env)
-	if err != nil {
-		return nil, err
-	}
-	return ir, nil
+	return clientapi.NewCreateInterceptRequest(ctx, rq, is.args.extState)
 }
 
 func (is *interceptState) EnsureState(ctx context.Context) (acquired bool, err error) {
@@ -523,11 +503,29 @@ func (is *interceptState) EnsureState(ctx context.Context) (acquired bool, err e
 		is.mountPoint = ir.MountPoint
 	}
 
-	// Submit the request
-	r, err := is.connectorClient.CreateIntercept(ctx, ir)
+	// Submit the request. The span covers everything up to the point where the intercept is usable.
+	sc, span := tracing.Start(ctx, "intercept.create", "intercept.name", ir.Spec.Name)
+	defer func() {
+		span.SetError(err)
+		span.End()
+	}()
+	switch {
+	case is.args.queue:
+		fmt.Fprintf(is.cmd.OutOrStdout(), "Waiting for %s to be available...\n", is.args.agentName)
+		ir.Queue = true
+	case is.args.force:
+		ir.Override = true
+	}
+	r, err := is.connectorClient.CreateIntercept(sc, ir)
 	if err != nil {
 		return false, fmt.Errorf("connector.CreateIntercept: %w", err)
 	}
+	if releasedBy := r.GetInterceptInfo().GetReleasedBy(); len(releasedBy) > 0 {
+		fmt.Fprintf(is.cmd.OutOrStdout(), "%s was released by %s\n", is.args.agentName, strings.Join(releasedBy, ", "))
+	}
+	if expiresAt := r.GetInterceptInfo().GetExpiresAt(); expiresAt != nil {
+		is.expires = expiresAt.AsTime()
+	}
 
 	switch r.Error {
 	case connector.InterceptError_UNSPECIFIED:
@@ -574,29 +572,48 @@ func (is *interceptState) EnsureState(ctx context.Context) (acquired bool, err e
 			intercept = r.InterceptInfo
 		}
 		is.Scout.SetMetadatum("intercept_id", intercept.Id)
+		is.intercept = intercept
 
-		is.env = r.Environment
+		// The connector tells the domain of the cluster in the environment
+		clusterDomain := r.Environment["TELEPRESENCE_CLUSTER_DOMAIN"]
+		if is.env, err = transformEnv(r.Environment, &client.GetConfig(ctx).Intercept.Env, clusterDomain); err != nil {
+			return true, err
+		}
+		if is.args.debugPort != 0 {
+			if is.env == nil {
+				is.env = make(map[string]string)
+			}
+			// A process in a container must listen on all interfaces for the published port to reach it
+			addr := debugAddr{Host: "127.0.0.1", Port: is.args.debugPort}
+			if is.args.dockerRun {
+				addr.Host = "0.0.0.0"
+			}
+			if err = addDebugEnv(is.env, addr, client.GetConfig(ctx).Intercept.DebugEnv); err != nil {
+				return true, err
+			}
+		}
 		if is.args.envFile != "" {
-			if err = is.writeEnvFile(); err != nil {
+			if err = is.writeEnvFile(ctx); err != nil {
 				return true, err
 			}
 		}
 		if is.args.envJSON != "" {
-			if err = is.writeEnvJSON(); err != nil {
+			if err = is.writeEnvJSON(ctx); err != nil {
 				return true, err
 			}
 		}
 
-		var volumeMountProblem error
-		doMount, err := strconv.ParseBool(is.args.mount)
-		if doMount || err != nil {
-			volumeMountProblem = checkMountCapability(ctx)
+		fmt.Fprintln(is.cmd.OutOrStdout(), DescribeIntercept(intercept, false))
+		if is.args.debugPort != 0 {
+			fmt.Fprintf(is.cmd.OutOrStdout(), "Attach your debugger to localhost:%d\n", is.args.debugPort)
+		}
+		if !is.expires.IsZero() {
+			fmt.Fprintf(is.cmd.OutOrStdout(), "The intercept will be removed at %s\n", is.expires.Local().Format(time.Kitchen))
 		}
-		fmt.Fprintln(is.cmd.OutOrStdout(), DescribeIntercept(intercept, volumeMountProblem, false))
 		_ = is.Scout.Report(ctx, "intercept_success")
 		return true, nil
 	case connector.InterceptError_ALREADY_EXISTS:
-		fmt.Fprintln(is.cmd.OutOrStdout(), interceptMessage(r))
+		fmt.Fprintln(is.cmd.OutOrStdout(), clientapi.InterceptMessage(r))
 		return false, nil
 	default:
 		if r.GetInterceptInfo().GetDisposition() == manager.InterceptDispositionType_BAD_ARGS {
@@ -604,27 +621,43 @@ func (is *interceptState) EnsureState(ctx context.Context) (acquired bool, err e
 			_ = is.cmd.FlagError(errors.New(r.InterceptInfo.Message))
 			panic("not reached; FlagErrorFunc should call os.Exit()")
 		}
-		return false, errors.New(interceptMessage(r))
+		return false, errors.New(clientapi.InterceptMessage(r))
 	}
 }
 
-func (is *interceptState) DeactivateState(ctx context.Context) error {
-	return removeIntercept(ctx, strings.TrimSpace(is.args.name))
-}
-
-func removeIntercept(ctx context.Context, name string) error {
-	return cliutil.WithStartedConnector(ctx, func(ctx context.Context, connectorClient connector.ConnectorClient) error {
-		var r *connector.InterceptResult
-		var err error
-		r, err = connectorClient.RemoveIntercept(dcontext.WithoutCancel(ctx), &manager.RemoveInterceptRequest2{Name: name})
-		if err != nil {
-			return err
+// expiryWarning is how long before the traffic-manager removes an intercept that has a duration
+// that the user is warned about it.
+const expiryWarning = 5 * time.Minute
+
+// warnBeforeExpiry prints a warning shortly before the traffic-manager removes the intercept, and
+// another one when it has been removed, for as long as the intercepted command runs. The returned
+// function stops the warnings.
+func (is *interceptState) warnBeforeExpiry(ctx context.Context) func() {
+	if is.expires.IsZero() {
+		return func() {}
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		if d := time.Until(is.expires.Add(-expiryWarning)); d > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(d):
+			}
+			fmt.Fprintf(is.cmd.ErrOrStderr(), "Warning: intercept %s will be removed in %s\n", is.args.name, expiryWarning)
 		}
-		if r.Error != connector.InterceptError_UNSPECIFIED {
-			return errors.New(interceptMessage(r))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(is.expires)):
 		}
-		return nil
-	})
+		fmt.Fprintf(is.cmd.ErrOrStderr(), "Warning: intercept %s has reached the end of its duration and was removed\n", is.args.name)
+	}()
+	return cancel
+}
+
+func (is *interceptState) DeactivateState(ctx context.Context) error {
+	return clientapi.Leave(ctx, strings.TrimSpace(is.args.name))
 }
 
 func validateDockerArgs(args []string) error {
@@ -638,14 +671,15 @@ func validateDockerArgs(args []string) error {
 
 func (is *interceptState) runInDocker(ctx context.Context, cmd safeCobraCommand, args []string) error {
 	envFile := is.args.envFile
-	if envFile == "" {
+	if envFile == "" || client.GetConfig(ctx).Redact.EnvFile {
+		// The container must see the real values, so a file with masked values cannot be used here
 		file, err := ioutil.TempFile("", "tel-*.env")
 		if err != nil {
 			return fmt.Errorf("failed to create temporary environment file. %w", err)
 		}
 		defer os.Remove(file.Name())
 
-		if err = is.writeEnvToFileAndClose(file); err != nil {
+		if err = writeEnvToFileAndClose(file, is.env); err != nil {
 			return err
 		}
 		envFile = file.Name()
@@ -671,6 +705,9 @@ func (is *interceptState) runInDocker(ctx context.Context, cmd safeCobraCommand,
 	if is.dockerPort != 0 {
 		ourArgs = append(ourArgs, "-p", fmt.Sprintf("%d:%d", is.localPort, is.dockerPort))
 	}
+	if is.args.debugPort != 0 {
+		ourArgs = append(ourArgs, "-p", fmt.Sprintf("%d:%d", is.args.debugPort, is.args.debugPort))
+	}
 
 	dockerMount := ""
 	if is.mountPoint != "" { // do we have a mount point at all?
@@ -684,21 +721,50 @@ func (is *interceptState) runInDocker(ctx context.Context, cmd safeCobraCommand,
 	return start(ctx, "docker", append(ourArgs, args...), true, cmd.InOrStdin(), cmd.OutOrStdout(), cmd.ErrOrStderr())
 }
 
-func (is *interceptState) writeEnvFile() error {
+// outputEnv returns the environment that is written to the files given by --env-file and
+// --env-json. Secret values are masked when the redact.envFile config is enabled.
+func (is *interceptState) outputEnv(ctx context.Context) (map[string]string, error) {
+	return redactedEnv(ctx, is.env)
+}
+
+// redactedEnv returns the given environment with secret values masked when the redact.envFile
+// config is enabled.
+func redactedEnv(ctx context.Context, env map[string]string) (map[string]string, error) {
+	rc := client.GetConfig(ctx).Redact
+	if !rc.EnvFile {
+		return env, nil
+	}
+	r, err := rc.Redactor()
+	if err != nil {
+		return nil, err
+	}
+	return r.Env(env), nil
+}
+
+func (is *interceptState) writeEnvFile(ctx context.Context) error {
+	env, err := is.outputEnv(ctx)
+	if err != nil {
+		return err
+	}
 	file, err := os.Create(is.args.envFile)
 	if err != nil {
 		return fmt.Errorf("failed to create environment file %q: %w", is.args.envFile, err)
 	}
-	return is.writeEnvToFileAndClose(file)
+	return writeEnvToFileAndClose(file, env)
 }
 
-func (is *interceptState) writeEnvToFileAndClose(file *os.File) (err error) {
+func writeEnvToFileAndClose(file *os.File, env map[string]string) (err error) {
 	defer file.Close()
-	w := bufio.NewWriter(file)
+	return writeEnv(file, env)
+}
 
-	keys := make([]string, len(is.env))
+// writeEnv writes the given environment in Docker Compose env-file format, sorted by key.
+func writeEnv(out io.Writer, env map[string]string) (err error) {
+	w := bufio.NewWriter(out)
+
+	keys := make([]string, len(env))
 	i := 0
-	for k := range is.env {
+	for k := range env {
 		keys[i] = k
 		i++
 	}
@@ -711,7 +777,7 @@ func (is *interceptState) writeEnvToFileAndClose(file *os.File) (err error) {
 		if err = w.WriteByte('='); err != nil {
 			return err
 		}
-		if _, err = w.WriteString(is.env[k]); err != nil {
+		if _, err = w.WriteString(env[k]); err != nil {
 			return err
 		}
 		if err = w.WriteByte('\n'); err != nil {
@@ -721,8 +787,12 @@ func (is *interceptState) writeEnvToFileAndClose(file *os.File) (err error) {
 	return w.Flush()
 }
 
-func (is *interceptState) writeEnvJSON() error {
-	data, err := json.MarshalIndent(is.env, "", "  ")
+func (is *interceptState) writeEnvJSON(ctx context.Context) error {
+	env, err := is.outputEnv(ctx)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(env, "", "  ")
 	if err != nil {
 		// Creating JSON from a map[string]string should never fail
 		panic(err)
@@ -2,6 +2,7 @@ package cli
 
 import (
 	"context"
+	"errors"
 	"fmt"
 
 	"github.com/pkg/browser"
@@ -40,12 +41,33 @@ func ClusterIdCommand() *cobra.Command {
 }
 
 func connectCommand() *cobra.Command {
-	return &cobra.Command{
+	var docker bool
+	var proxy proxyInfo
+	cmd := &cobra.Command{
 		Use:  "connect [flags] [-- <command to run while connected>]",
 		Args: cobra.ArbitraryArgs,
 
 		Short: "Connect to a cluster",
 		RunE: func(cmd *cobra.Command, args []string) error {
+			if docker {
+				if len(args) > 0 {
+					return errors.New("a command cannot be combined with --docker. Use docker run --network " + dockerNetwork)
+				}
+				return connectInDocker(cmd)
+			}
+			if proxy.mock {
+				if len(args) > 0 {
+					return errors.New("a command cannot be combined with --mock-cluster")
+				}
+				return proxy.connectToMockCluster(cmd)
+			}
+			if proxy.enabled {
+				if len(args) > 0 {
+					return errors.New("a command cannot be combined with --proxy-via-container")
+				}
+				return proxy.connectViaProxy(cmd)
+			}
+			warnConflicts(cmd)
 			if len(args) == 0 {
 				return withConnector(cmd, true, func(_ context.Context, _ connector.ConnectorClient, _ *connector.ConnectInfo) error {
 					return nil
@@ -56,6 +78,11 @@ func connectCommand() *cobra.Command {
 			})
 		},
 	}
+	cmd.Flags().BoolVar(&docker, "docker", false, ``+
+		`Run the daemons in a container of their own. Nothing privileged is done on the host, and other `+
+		`containers reach the cluster by using --network `+dockerNetwork)
+	proxy.addFlags(cmd)
+	return cmd
 }
 
 func dashboardCommand() *cobra.Command {
@@ -90,13 +117,19 @@ func dashboardCommand() *cobra.Command {
 }
 
 func quitCommand() *cobra.Command {
-	return &cobra.Command{
+	var docker bool
+	cmd := &cobra.Command{
 		Use:  "quit",
 		Args: cobra.NoArgs,
 
 		Short: "Tell telepresence daemon to quit",
 		RunE: func(cmd *cobra.Command, _ []string) error {
+			if docker {
+				return quitDocker(cmd.Context())
+			}
 			return quit(cmd.Context())
 		},
 	}
+	cmd.Flags().BoolVar(&docker, "docker", false, "Stop the daemons that were started using connect --docker")
+	return cmd
 }
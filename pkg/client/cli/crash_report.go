@@ -0,0 +1,88 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/logging"
+	"github.com/telepresenceio/telepresence/v2/pkg/filelocation"
+)
+
+// crashesReportedFile is the file in the crash directory whose modification time tells when crash
+// bundles were last reported
+const crashesReportedFile = ".reported"
+
+// reportCrashes tells the user about the crash bundles that the daemons have written since the
+// last time it was called, and uploads them when an upload URL is configured.
+func reportCrashes(cmd *cobra.Command) {
+	ctx := cmd.Context()
+	logDir, err := filelocation.AppUserLogDir(ctx)
+	if err != nil {
+		return
+	}
+	marker := filepath.Join(logDir, logging.CrashDir, crashesReportedFile)
+	var since time.Time
+	if st, err := os.Stat(marker); err == nil {
+		since = st.ModTime()
+	}
+	bundles, err := logging.CrashBundles(ctx, since)
+	if err != nil || len(bundles) == 0 {
+		return
+	}
+
+	now := time.Now()
+	if err = ioutil.WriteFile(marker, nil, 0600); err == nil {
+		err = os.Chtimes(marker, now, now)
+	}
+	if err != nil {
+		dlog.Errorf(ctx, "unable to record that crashes have been reported: %v", err)
+	}
+
+	stderr := cmd.ErrOrStderr()
+	uploadURL := client.GetConfig(ctx).CrashReports.UploadURL
+	for _, b := range bundles {
+		fmt.Fprintf(stderr, "The %s crashed at %s. A crash report was written to %s\n",
+			b.Process, b.Time.Format(time.RFC1123), b.Path)
+		if uploadURL != "" && !noReport {
+			if err = uploadCrashBundle(ctx, uploadURL, b.Path); err != nil {
+				fmt.Fprintf(stderr, "Unable to upload the crash report: %v\n", err)
+			}
+		}
+	}
+}
+
+func uploadCrashBundle(ctx context.Context, url, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	rq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, f)
+	if err != nil {
+		return err
+	}
+	rq.Header.Set("Content-Type", "application/zip")
+	rq.Header.Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filepath.Base(path)))
+	resp, err := http.DefaultClient.Do(rq)
+	if err != nil {
+		return err
+	}
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	_ = resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s responded with %s", url, resp.Status)
+	}
+	return nil
+}
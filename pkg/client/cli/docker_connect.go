@@ -0,0 +1,283 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/clientcmd/api"
+
+	"github.com/datawire/dlib/dexec"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/filelocation"
+)
+
+const (
+	// dockerDaemonContainer is the name of the container that runs the daemons when connecting
+	// using --docker. Other containers access the cluster either through the dockerNetwork or by
+	// using its network namespace, i.e. `docker run --network container:telepresence-daemon ...`.
+	dockerDaemonContainer = "telepresence-daemon"
+
+	// dockerNetwork is the network that the daemon container is the gateway of. Containers that
+	// are attached to it, i.e. `docker run --network telepresence ...`, reach the cluster through
+	// the daemon container.
+	dockerNetwork = "telepresence"
+
+	// dockerInhibitIPv4 is the option that prevents Docker from assigning the gateway address of
+	// the dockerNetwork to the bridge on the host, so that the daemon container can claim it.
+	dockerInhibitIPv4 = "com.docker.network.bridge.inhibit_ipv4"
+
+	// dockerConnectedFile is created in the daemon container once the connect has succeeded.
+	dockerConnectedFile = "/tmp/telepresence-connected"
+)
+
+func clientImage(ctx context.Context) string {
+	images := client.GetConfig(ctx).Images
+	if images.ClientImage != "" {
+		return images.ClientImage
+	}
+	return fmt.Sprintf("%s/telepresence:%s", images.Registry, strings.TrimPrefix(client.Version(), "v"))
+}
+
+func dockerOutput(ctx context.Context, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := dexec.CommandContext(ctx, "docker", args...)
+	cmd.DisableLogging = true
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("docker %s: %s", args[0], msg)
+		}
+		return "", fmt.Errorf("docker %s: %w", args[0], err)
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// dockerContainerState returns the state, e.g. "running" or "exited", of the daemon container, or
+// an empty string if no such container exists.
+func dockerContainerState(ctx context.Context) string {
+	state, err := dockerOutput(ctx, "container", "inspect", "--format", "{{.State.Status}}", dockerDaemonContainer)
+	if err != nil {
+		return ""
+	}
+	return state
+}
+
+// loadDockerKubeConfig returns a flattened kubeconfig that contains the current context and
+// everything it refers to.
+func loadDockerKubeConfig(flags map[string]string) (*api.Config, error) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	if kc, ok := flags["kubeconfig"]; ok {
+		// Like KUBECONFIG, the flag may list several files
+		rules.Precedence = filepath.SplitList(kc)
+	}
+	overrides := &clientcmd.ConfigOverrides{}
+	if kctx, ok := flags["context"]; ok {
+		overrides.CurrentContext = kctx
+	}
+	raw, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides).RawConfig()
+	if err != nil {
+		return nil, err
+	}
+	if overrides.CurrentContext != "" {
+		raw.CurrentContext = overrides.CurrentContext
+	}
+	if err = api.MinifyConfig(&raw); err != nil {
+		return nil, err
+	}
+	if err = api.FlattenConfig(&raw); err != nil {
+		return nil, err
+	}
+	return &raw, nil
+}
+
+// writeDockerKubeConfig writes the given kubeconfig so that it can be mounted into the daemon
+// container, and returns the path of the file.
+func writeDockerKubeConfig(ctx context.Context, cfg *api.Config) (string, error) {
+	cacheDir, err := filelocation.AppUserCacheDir(ctx)
+	if err != nil {
+		return "", err
+	}
+	if err = os.MkdirAll(cacheDir, 0700); err != nil {
+		return "", err
+	}
+	path := filepath.Join(cacheDir, "docker-kubeconfig")
+	if err = clientcmd.WriteToFile(*cfg, path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// connectInDocker starts the root daemon and the connector in a container of their own. The TUN
+// device and the DNS resolver live in the network namespace of that container, so nothing on the
+// host is modified.
+func connectInDocker(cmd *cobra.Command) error {
+	ctx := cmd.Context()
+	out := cmd.OutOrStdout()
+
+	switch dockerContainerState(ctx) {
+	case "":
+	case "running":
+		fmt.Fprintf(out, "Already connected in container %s\n", dockerDaemonContainer)
+		return nil
+	default:
+		if _, err := dockerOutput(ctx, "rm", "--force", dockerDaemonContainer); err != nil {
+			return err
+		}
+	}
+
+	subnet, gateway, err := ensureDockerNetwork(ctx)
+	if err != nil {
+		return err
+	}
+	egressGateway, err := dockerOutput(ctx, "network", "inspect", "--format", "{{(index .IPAM.Config 0).Gateway}}", "bridge")
+	if err != nil {
+		return err
+	}
+
+	flags := kubeFlagMap()
+	kubeConfig, err := loadDockerKubeConfig(flags)
+	if err != nil {
+		return fmt.Errorf("unable to create kubeconfig for the daemon container: %w", err)
+	}
+	kubeContext := kubeConfig.CurrentContext
+	// The exec plugin of the kubeconfig runs on the host, where its binary and the credentials it
+	// needs are, and the daemon container gets the credentials from the kubeauth service.
+	var kubeAuthArgs []string
+	if usesExec(kubeConfig) {
+		address, secret, err := startKubeAuth(ctx, kubeContext, egressGateway)
+		if err != nil {
+			return err
+		}
+		replaceExec(kubeConfig, address, secret)
+		_, port, _ := net.SplitHostPort(address)
+		kubeAuthArgs = []string{"--add-host", kubeAuthHost + ":host-gateway", "--label", kubeAuthLabel + "=" + port}
+	}
+	kubeConfigFile, err := writeDockerKubeConfig(ctx, kubeConfig)
+	if err != nil {
+		return fmt.Errorf("unable to create kubeconfig for the daemon container: %w", err)
+	}
+
+	args := []string{
+		"create", "--init",
+		"--name", dockerDaemonContainer,
+		"--network", "bridge",
+		"--cap-add", "NET_ADMIN",
+		"--sysctl", "net.ipv4.ip_forward=1",
+		"--device", "/dev/net/tun:/dev/net/tun",
+		"--volume", kubeConfigFile + ":/root/.kube/config:ro",
+	}
+	args = append(args, kubeAuthArgs...)
+	args = append(args,
+		clientImage(ctx),
+		"docker-gateway", "--subnet", subnet, "--gateway", gateway, "--egress-gateway", egressGateway, "--",
+		"telepresence", "connect",
+	)
+	for k, v := range flags {
+		if k != "kubeconfig" && k != "KUBECONFIG" && k != "context" {
+			args = append(args, "--"+k+"="+v)
+		}
+	}
+	if len(mappedNamespaces) > 0 {
+		args = append(args, "--mapped-namespaces="+strings.Join(mappedNamespaces, ","))
+	}
+	args = append(args, "--", "sh", "-c", "touch "+dockerConnectedFile+" && exec sleep infinity")
+
+	fmt.Fprintf(out, "Launching Telepresence in container %s\n", dockerDaemonContainer)
+	if _, err = dockerOutput(ctx, args...); err != nil {
+		return err
+	}
+	if _, err = dockerOutput(ctx, "network", "connect", dockerNetwork, dockerDaemonContainer); err != nil {
+		return err
+	}
+	if _, err = dockerOutput(ctx, "start", dockerDaemonContainer); err != nil {
+		return err
+	}
+
+	timeout := client.GetConfig(ctx).Timeouts.PrivateClusterConnect + client.GetConfig(ctx).Timeouts.PrivateTrafficManagerConnect
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if state := dockerContainerState(ctx); state != "running" {
+			logs, _ := dockerOutput(ctx, "logs", dockerDaemonContainer)
+			return fmt.Errorf("container %s is %s:\n%s", dockerDaemonContainer, state, logs)
+		}
+		if _, err = dockerOutput(ctx, "exec", dockerDaemonContainer, "test", "-f", dockerConnectedFile); err == nil {
+			networkFlags := "--network " + dockerNetwork
+			if rc, err := dockerOutput(ctx, "exec", dockerDaemonContainer, "cat", "/etc/resolv.conf"); err == nil && forwardsDNSFromHost(rc) {
+				networkFlags += " --dns " + gateway
+			}
+			fmt.Fprintf(out, "Connected to context %s. Use %s to give other containers access to the cluster\n",
+				kubeContext, networkFlags)
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+	return fmt.Errorf("timeout waiting for Telepresence to connect in container %s (see `docker logs %s`)", dockerDaemonContainer, dockerDaemonContainer)
+}
+
+// ensureDockerNetwork creates the dockerNetwork unless it exists, and returns its subnet and its
+// gateway address. A network that lacks the dockerInhibitIPv4 option, e.g. one created by an
+// earlier version, is recreated.
+func ensureDockerNetwork(ctx context.Context) (string, string, error) {
+	inhibit, err := dockerOutput(ctx, "network", "inspect", "--format", `{{index .Options "`+dockerInhibitIPv4+`"}}`, dockerNetwork)
+	if err == nil && inhibit != "true" {
+		if _, err = dockerOutput(ctx, "network", "rm", dockerNetwork); err != nil {
+			return "", "", fmt.Errorf("unable to recreate the %s network: %w", dockerNetwork, err)
+		}
+	}
+	if err != nil || inhibit != "true" {
+		if _, err = dockerOutput(ctx, "network", "create", "--opt", dockerInhibitIPv4+"=true", dockerNetwork); err != nil {
+			return "", "", err
+		}
+	}
+	cfg, err := dockerOutput(ctx, "network", "inspect", "--format",
+		"{{(index .IPAM.Config 0).Subnet}} {{(index .IPAM.Config 0).Gateway}}", dockerNetwork)
+	if err != nil {
+		return "", "", err
+	}
+	fields := strings.Fields(cfg)
+	if len(fields) != 2 {
+		return "", "", fmt.Errorf("unable to determine the subnet and gateway of the %s network", dockerNetwork)
+	}
+	return fields[0], fields[1], nil
+}
+
+// forwardsDNSFromHost returns true if the given resolv.conf of a container that is attached to the
+// dockerNetwork shows that Docker's embedded DNS forwards the queries that it can't answer to a
+// loopback nameserver of the host. Docker sends those queries from the network namespace of the
+// host, so they never reach the gateway, and the containers must use the gateway as their
+// nameserver to resolve cluster names.
+func forwardsDNSFromHost(resolvConf string) bool {
+	for _, line := range strings.Split(resolvConf, "\n") {
+		// Docker 26 and later lists the upstream nameservers as, e.g., "# ExtServers: [host(127.0.0.53)]"
+		if line = strings.TrimSpace(line); strings.HasPrefix(line, "# ExtServers:") && strings.Contains(line, "host(") {
+			return true
+		}
+	}
+	return false
+}
+
+// quitDocker stops and removes the daemon container.
+func quitDocker(ctx context.Context) error {
+	if dockerContainerState(ctx) == "" {
+		return nil
+	}
+	fmt.Printf("Telepresence container %s quitting...", dockerDaemonContainer)
+	if _, err := dockerOutput(ctx, "rm", "--force", dockerDaemonContainer); err != nil {
+		return err
+	}
+	fmt.Println("done")
+	return nil
+}
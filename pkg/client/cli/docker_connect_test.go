@@ -0,0 +1,31 @@
+package cli
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestForwardsDNSFromHost(t *testing.T) {
+	assert.True(t, forwardsDNSFromHost(`# Generated by Docker Engine.
+# This file can be edited; Docker Engine will not make further changes once it
+# has been modified.
+
+nameserver 127.0.0.11
+options ndots:0
+
+# Based on host file: '/etc/resolv.conf' (internal resolver)
+# ExtServers: [host(127.0.0.53)]
+# Overrides: []
+# Option ndots from: internal
+`))
+	assert.False(t, forwardsDNSFromHost(`nameserver 127.0.0.11
+options ndots:0
+
+# Based on host file: '/run/systemd/resolve/resolv.conf' (internal resolver)
+# ExtServers: [192.168.1.1]
+# Overrides: []
+`))
+	// Docker versions before 26 never forward from the host
+	assert.False(t, forwardsDNSFromHost("nameserver 127.0.0.11\noptions ndots:0\n"))
+}
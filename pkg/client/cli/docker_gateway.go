@@ -0,0 +1,161 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/spf13/cobra"
+
+	"github.com/datawire/dlib/dexec"
+	"github.com/datawire/dlib/dgroup"
+	"github.com/datawire/dlib/dlog"
+)
+
+// dockerGatewayCommand returns the hidden command that the daemon container runs. It makes the
+// container the default gateway and the DNS server of the containers that are attached to the
+// dockerNetwork, and then runs the given command, i.e. the connect.
+func dockerGatewayCommand() *cobra.Command {
+	var subnet, gateway, egressGateway string
+	cmd := &cobra.Command{
+		Use:    "docker-gateway -- <command>",
+		Args:   cobra.MinimumNArgs(1),
+		Hidden: true,
+
+		Short: "Route the traffic of the telepresence docker network (used in the daemon container)",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			_, ipNet, err := net.ParseCIDR(subnet)
+			if err != nil {
+				return err
+			}
+			gw := net.ParseIP(gateway)
+			if gw == nil || !ipNet.Contains(gw) {
+				return fmt.Errorf("gateway %q is not an address in subnet %s", gateway, subnet)
+			}
+			egress := net.ParseIP(egressGateway)
+			if egress == nil {
+				return fmt.Errorf("invalid egress gateway %q", egressGateway)
+			}
+			return runDockerGateway(cmd, ipNet, gw, egress, args)
+		},
+	}
+	flags := cmd.Flags()
+	flags.StringVar(&subnet, "subnet", "", "The subnet of the docker network")
+	flags.StringVar(&gateway, "gateway", "", "The gateway address of the docker network")
+	flags.StringVar(&egressGateway, "egress-gateway", "", "The gateway that traffic that isn't destined for the cluster is sent to")
+	return cmd
+}
+
+// gatewayInterface returns the name of the interface that has an address in the given subnet.
+func gatewayInterface(subnet *net.IPNet) (string, error) {
+	ifs, err := net.Interfaces()
+	if err != nil {
+		return "", err
+	}
+	for _, iface := range ifs {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ipn, ok := addr.(*net.IPNet); ok && subnet.Contains(ipn.IP) {
+				return iface.Name, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no interface is attached to %s", subnet)
+}
+
+func runDockerGateway(cmd *cobra.Command, subnet *net.IPNet, gateway, egressGateway net.IP, args []string) error {
+	ctx := cmd.Context()
+	iface, err := gatewayInterface(subnet)
+	if err != nil {
+		return err
+	}
+	ones, _ := subnet.Mask.Size()
+
+	// The network is created with an unassigned gateway address, so it's added to this
+	// container's interface. Traffic that the attached containers send to their default gateway
+	// then reaches this container, where it's routed to the TUN device when it's destined for the
+	// cluster, and masqueraded and sent to the egress gateway otherwise. DNS queries are
+	// redirected to the relay. Docker may have made the gateway address the default gateway of
+	// this container too, so the default route is replaced before the connect starts.
+	cmds := [][]string{
+		{"ip", "addr", "add", fmt.Sprintf("%s/%d", gateway, ones), "dev", iface},
+		{"ip", "route", "replace", "default", "via", egressGateway.String()},
+		{"iptables", "-t", "nat", "-A", "POSTROUTING", "-s", subnet.String(), "!", "-o", iface, "-j", "MASQUERADE"},
+		{"iptables", "-t", "nat", "-A", "PREROUTING", "-i", iface, "-p", "udp", "--dport", "53", "-j", "REDIRECT", "--to-ports", "53"},
+	}
+	for _, c := range cmds {
+		if err = dexec.CommandContext(ctx, c[0], c[1:]...).Run(); err != nil {
+			return err
+		}
+	}
+
+	cfg, err := dns.ClientConfigFromFile("/etc/resolv.conf")
+	if err != nil {
+		return err
+	}
+	if len(cfg.Servers) == 0 {
+		return errors.New("no nameserver found in /etc/resolv.conf")
+	}
+	pc, err := net.ListenPacket("udp", ":53")
+	if err != nil {
+		return err
+	}
+	dlog.Infof(ctx, "Routing the traffic of %s through %s", subnet, gateway)
+
+	g := dgroup.NewGroup(ctx, dgroup.GroupConfig{ShutdownOnNonError: true})
+	g.Go("dns-relay", func(ctx context.Context) error {
+		return relayDNS(ctx, pc, net.JoinHostPort(cfg.Servers[0], cfg.Port))
+	})
+	g.Go("command", func(ctx context.Context) error {
+		return start(ctx, args[0], args[1:], true, cmd.InOrStdin(), cmd.OutOrStdout(), cmd.ErrOrStderr())
+	})
+	return g.Wait()
+}
+
+// relayDNS sends the queries that arrive on the given connection to the given nameserver, and
+// the replies back to where the queries came from. The queries are sent from this container, so
+// the DNS resolver of the root daemon answers them as it answers the container's own queries.
+func relayDNS(ctx context.Context, pc net.PacketConn, nameserver string) error {
+	go func() {
+		<-ctx.Done()
+		_ = pc.Close()
+	}()
+	buf := make([]byte, 0x10000)
+	for {
+		n, from, err := pc.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		query := make([]byte, n)
+		copy(query, buf[:n])
+		go func() {
+			conn, err := net.Dial("udp", nameserver)
+			if err != nil {
+				dlog.Errorf(ctx, "DNS relay: %v", err)
+				return
+			}
+			defer conn.Close()
+			_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+			if _, err = conn.Write(query); err != nil {
+				dlog.Errorf(ctx, "DNS relay: %v", err)
+				return
+			}
+			reply := make([]byte, 0x10000)
+			n, err := conn.Read(reply)
+			if err != nil {
+				dlog.Debugf(ctx, "DNS relay: %v", err)
+				return
+			}
+			_, _ = pc.WriteTo(reply[:n], from)
+		}()
+	}
+}
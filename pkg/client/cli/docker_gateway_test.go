@@ -0,0 +1,52 @@
+package cli
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+)
+
+func TestRelayDNS(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+
+	// The nameserver answers all A queries with 10.0.0.1
+	nsConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	ns := &dns.Server{PacketConn: nsConn, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, q *dns.Msg) {
+		r := new(dns.Msg)
+		r.SetReply(q)
+		r.Answer = append(r.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: q.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+			A:   net.IP{10, 0, 0, 1},
+		})
+		_ = w.WriteMsg(r)
+	})}
+	go func() { _ = ns.ActivateAndServe() }()
+	defer func() { _ = ns.Shutdown() }()
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	done := make(chan error, 1)
+	go func() {
+		done <- relayDNS(ctx, pc, nsConn.LocalAddr().String())
+	}()
+
+	c := dns.Client{Timeout: 5 * time.Second}
+	q := new(dns.Msg)
+	q.SetQuestion("echo.default.svc.cluster.local.", dns.TypeA)
+	r, _, err := c.Exchange(q, pc.LocalAddr().String())
+	require.NoError(t, err)
+	require.Len(t, r.Answer, 1)
+	assert.Equal(t, "10.0.0.1", r.Answer[0].(*dns.A).A.String())
+
+	cancel()
+	assert.NoError(t, <-done)
+}
@@ -0,0 +1,306 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	//nolint:depguard // Because we won't ever .Wait() for the process and we'd turn off
+	// logging, using dexec would just be extra overhead.
+	"os/exec"
+
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/tools/clientcmd/api"
+
+	"github.com/datawire/dlib/dexec"
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/cliutil"
+)
+
+const (
+	// kubeAuthSecretEnv is the environment variable with the secret that the kubeauth service
+	// requires from its clients.
+	kubeAuthSecretEnv = "TELEPRESENCE_KUBEAUTH_SECRET"
+
+	// kubeAuthLabel is the label of the daemon container with the port of its kubeauth service.
+	// The service exits when the daemon container no longer has the label.
+	kubeAuthLabel = "io.telepresence.kubeauth"
+
+	// kubeAuthHost is the name of the host in the daemon container.
+	kubeAuthHost = "host.docker.internal"
+
+	// execInfoEnv is the environment variable that client-go passes to exec plugins.
+	execInfoEnv = "KUBERNETES_EXEC_INFO"
+)
+
+// usesExec returns true if the user of the current context of the given config gets its
+// credentials from an exec plugin, e.g. "aws eks get-token".
+func usesExec(cfg *api.Config) bool {
+	_, ok := execAuthInfo(cfg)
+	return ok
+}
+
+func execAuthInfo(cfg *api.Config) (*api.ExecConfig, bool) {
+	kctx, ok := cfg.Contexts[cfg.CurrentContext]
+	if !ok {
+		return nil, false
+	}
+	ai, ok := cfg.AuthInfos[kctx.AuthInfo]
+	if !ok || ai.Exec == nil {
+		return nil, false
+	}
+	return ai.Exec, true
+}
+
+// replaceExec makes the exec plugin of the user of the current context ask the kubeauth service at
+// the given address for credentials. The plugin, and the files and the cloud credentials it needs,
+// are on the host, so it can't run in the daemon container.
+func replaceExec(cfg *api.Config, address, secret string) {
+	ec, ok := execAuthInfo(cfg)
+	if !ok {
+		return
+	}
+	ec.Command = "telepresence"
+	ec.Args = []string{"kubeauth", "--address", address}
+	ec.Env = []api.ExecEnvVar{{Name: kubeAuthSecretEnv, Value: secret}}
+	ec.InstallHint = ""
+}
+
+// startKubeAuth starts the kubeauth service in a process of its own on the host, and returns the
+// address that the daemon container reaches it on, and its secret.
+func startKubeAuth(ctx context.Context, kubeContext, egressGateway string) (string, string, error) {
+	secretBytes := make([]byte, 32)
+	if _, err := rand.Read(secretBytes); err != nil {
+		return "", "", err
+	}
+	secret := hex.EncodeToString(secretBytes)
+
+	// Docker Desktop forwards host.docker.internal to the loopback of the host. On Linux, it's the
+	// gateway of the bridge network, which the daemon container is attached to.
+	host := "127.0.0.1"
+	if runtime.GOOS == "linux" {
+		host = egressGateway
+	}
+	l, err := net.Listen("tcp", net.JoinHostPort(host, "0"))
+	if err != nil {
+		return "", "", fmt.Errorf("unable to listen for the kubeauth service: %w", err)
+	}
+	addr := l.Addr().String()
+	port := strconv.Itoa(l.Addr().(*net.TCPAddr).Port)
+	_ = l.Close()
+
+	args := []string{client.GetExe(), "kubeauth-foreground", "--address", addr, "--context=" + kubeContext}
+	for k, v := range kubeFlagMap() {
+		if k != "context" && k != "KUBECONFIG" {
+			args = append(args, "--"+k+"="+v)
+		}
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Env = append(os.Environ(), kubeAuthSecretEnv+"="+secret)
+	// Process must live in a process group of its own to prevent
+	// getting affected by <ctrl-c> in the terminal
+	cmd.SysProcAttr = cliutil.NewProcessGroupAttr()
+	if err = cmd.Start(); err != nil {
+		return "", "", fmt.Errorf("unable to start the kubeauth service: %w", err)
+	}
+	_ = cmd.Process.Release()
+
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		conn, err := net.DialTimeout("tcp", addr, time.Second)
+		if err == nil {
+			_ = conn.Close()
+			return net.JoinHostPort(kubeAuthHost, port), secret, nil
+		}
+		if time.Now().After(deadline) {
+			return "", "", fmt.Errorf("the kubeauth service didn't start: %w", err)
+		}
+		select {
+		case <-ctx.Done():
+			return "", "", ctx.Err()
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+// kubeAuthServer runs the exec plugin of the kubeconfig of the host on behalf of the daemon
+// container.
+type kubeAuthServer struct {
+	secret string
+	flags  map[string]string
+}
+
+func (s *kubeAuthServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+s.secret)) != 1 {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	execInfo, err := ioutil.ReadAll(io.LimitReader(r.Body, 64*1024))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	cred, err := s.credentials(r.Context(), string(execInfo))
+	if err != nil {
+		dlog.Error(r.Context(), err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(cred)
+}
+
+// credentials runs the exec plugin of the current context and returns its output, i.e. an
+// ExecCredential. The kubeconfig is read on each call, so that changes to it are seen.
+func (s *kubeAuthServer) credentials(ctx context.Context, execInfo string) ([]byte, error) {
+	cfg, err := loadDockerKubeConfig(s.flags)
+	if err != nil {
+		return nil, err
+	}
+	ec, ok := execAuthInfo(cfg)
+	if !ok {
+		return nil, fmt.Errorf("the user of context %q has no exec plugin", cfg.CurrentContext)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := dexec.CommandContext(ctx, ec.Command, ec.Args...)
+	cmd.DisableLogging = true
+	cmd.Env = os.Environ()
+	for _, ev := range ec.Env {
+		cmd.Env = append(cmd.Env, ev.Name+"="+ev.Value)
+	}
+	if execInfo != "" {
+		cmd.Env = append(cmd.Env, execInfoEnv+"="+execInfo)
+	}
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err = cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%s: %s", ec.Command, msg)
+		}
+		return nil, fmt.Errorf("%s: %w", ec.Command, err)
+	}
+	return stdout.Bytes(), nil
+}
+
+// kubeAuthForegroundCommand returns the hidden command that runs the kubeauth service on the host.
+// It exits once the daemon container is gone.
+func kubeAuthForegroundCommand() *cobra.Command {
+	var address string
+	cmd := &cobra.Command{
+		Use:    "kubeauth-foreground",
+		Args:   cobra.NoArgs,
+		Hidden: true,
+
+		Short: "Serve the credentials of the kubeconfig of the host to the daemon container",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			ctx := cmd.Context()
+			secret := os.Getenv(kubeAuthSecretEnv)
+			if secret == "" {
+				return fmt.Errorf("%s is not set", kubeAuthSecretEnv)
+			}
+			_, port, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			l, err := net.Listen("tcp", address)
+			if err != nil {
+				return err
+			}
+			srv := &http.Server{Handler: &kubeAuthServer{secret: secret, flags: kubeFlagMap()}}
+			go func() {
+				awaitKubeAuthContainer(ctx, port)
+				_ = srv.Close()
+			}()
+			if err = srv.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				return err
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&address, "address", "", "The address to listen to")
+	return cmd
+}
+
+// awaitKubeAuthContainer returns when the daemon container no longer has the kubeAuthLabel with
+// the given port, or when it hasn't got it within a minute.
+func awaitKubeAuthContainer(ctx context.Context, port string) {
+	seen := false
+	start := time.Now()
+	for {
+		label, _ := dockerOutput(ctx, "container", "inspect", "--format", `{{index .Config.Labels "`+kubeAuthLabel+`"}}`, dockerDaemonContainer)
+		switch {
+		case label == port:
+			seen = true
+		case seen || time.Since(start) > time.Minute:
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+// fetchKubeCredentials asks the kubeauth service at the given address for the credentials of the
+// host.
+func fetchKubeCredentials(ctx context.Context, address, secret, execInfo string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://"+address+"/credentials", strings.NewReader(execInfo))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+secret)
+	rsp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer rsp.Body.Close()
+	body, err := ioutil.ReadAll(rsp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if rsp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("kubeauth service: %s", strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
+
+// kubeAuthCommand returns the hidden command that replaces the exec plugin in the kubeconfig of
+// the daemon container. It prints the ExecCredential that the kubeauth service of the host returns.
+func kubeAuthCommand() *cobra.Command {
+	var address string
+	cmd := &cobra.Command{
+		Use:    "kubeauth",
+		Args:   cobra.NoArgs,
+		Hidden: true,
+
+		Short: "Get credentials from the kubeauth service of the host (used in the daemon container)",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			cred, err := fetchKubeCredentials(cmd.Context(), address, os.Getenv(kubeAuthSecretEnv), os.Getenv(execInfoEnv))
+			if err != nil {
+				return err
+			}
+			_, err = cmd.OutOrStdout().Write(cred)
+			return err
+		},
+	}
+	cmd.Flags().StringVar(&address, "address", "", "The address of the kubeauth service")
+	return cmd
+}
@@ -0,0 +1,91 @@
+package cli
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/clientcmd/api"
+
+	"github.com/datawire/dlib/dlog"
+)
+
+func execKubeConfig(command string) *api.Config {
+	return &api.Config{
+		CurrentContext: "eks",
+		Contexts:       map[string]*api.Context{"eks": {Cluster: "eks", AuthInfo: "eks-user"}},
+		Clusters:       map[string]*api.Cluster{"eks": {Server: "https://eks.example.com"}},
+		AuthInfos: map[string]*api.AuthInfo{"eks-user": {Exec: &api.ExecConfig{
+			APIVersion: "client.authentication.k8s.io/v1beta1",
+			Command:    command,
+			Args:       []string{"eks", "get-token"},
+			Env:        []api.ExecEnvVar{{Name: "TOKEN", Value: "s3cr3t"}},
+		}}},
+	}
+}
+
+func TestReplaceExec(t *testing.T) {
+	cfg := execKubeConfig("aws")
+	require.True(t, usesExec(cfg))
+	replaceExec(cfg, "host.docker.internal:4711", "secret")
+	ec := cfg.AuthInfos["eks-user"].Exec
+	assert.Equal(t, "telepresence", ec.Command)
+	assert.Equal(t, []string{"kubeauth", "--address", "host.docker.internal:4711"}, ec.Args)
+	assert.Equal(t, []api.ExecEnvVar{{Name: kubeAuthSecretEnv, Value: "secret"}}, ec.Env)
+	assert.Equal(t, "client.authentication.k8s.io/v1beta1", ec.APIVersion)
+
+	cfg.AuthInfos["eks-user"] = &api.AuthInfo{Token: "token"}
+	assert.False(t, usesExec(cfg))
+}
+
+func TestKubeAuthServer(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the exec plugin of the test is a shell script")
+	}
+	dir := t.TempDir()
+	plugin := filepath.Join(dir, "plugin")
+	require.NoError(t, ioutil.WriteFile(plugin, []byte(`#!/bin/sh
+if [ "$1" != eks ]; then
+  echo "unknown command $1" >&2
+  exit 1
+fi
+printf '{"token":"%s","info":%s}' "$TOKEN" "$KUBERNETES_EXEC_INFO"
+`), 0700))
+	cfg := execKubeConfig(plugin)
+	cfg.AuthInfos["broken-user"] = &api.AuthInfo{Exec: &api.ExecConfig{Command: plugin, Args: []string{"sts"}}}
+	cfg.Contexts["broken"] = &api.Context{Cluster: "eks", AuthInfo: "broken-user"}
+	cfg.AuthInfos["token-user"] = &api.AuthInfo{Token: "token"}
+	cfg.Contexts["token"] = &api.Context{Cluster: "eks", AuthInfo: "token-user"}
+	kubeConfig := filepath.Join(dir, "config")
+	require.NoError(t, clientcmd.WriteToFile(*cfg, kubeConfig))
+
+	ctx := dlog.NewTestContext(t, false)
+	serve := func(kubeContext string) string {
+		srv := httptest.NewServer(&kubeAuthServer{
+			secret: "secret",
+			flags:  map[string]string{"kubeconfig": kubeConfig, "context": kubeContext},
+		})
+		t.Cleanup(srv.Close)
+		return strings.TrimPrefix(srv.URL, "http://")
+	}
+
+	addr := serve("eks")
+	cred, err := fetchKubeCredentials(ctx, addr, "secret", `{"kind":"ExecCredential"}`)
+	require.NoError(t, err)
+	assert.Equal(t, `{"token":"s3cr3t","info":{"kind":"ExecCredential"}}`, string(cred))
+
+	_, err = fetchKubeCredentials(ctx, addr, "guess", "")
+	assert.EqualError(t, err, "kubeauth service: unauthorized")
+
+	_, err = fetchKubeCredentials(ctx, serve("broken"), "secret", "")
+	assert.EqualError(t, err, "kubeauth service: "+plugin+": unknown command sts")
+
+	_, err = fetchKubeCredentials(ctx, serve("token"), "secret", "")
+	assert.EqualError(t, err, `kubeauth service: the user of context "token" has no exec plugin`)
+}
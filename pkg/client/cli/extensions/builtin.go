@@ -15,13 +15,40 @@ func builtinExtensions(ctx context.Context) map[string]ExtensionInfo {
 	registry := client.GetConfig(ctx).Images.Registry
 	version := strings.TrimPrefix(client.Version(), "v")
 	image := fmt.Sprintf("%s/tel2:%s", registry, version)
+	httpMatch := json.RawMessage(`["auto"]`)
+	if dm := client.GetConfig(ctx).Intercept.DefaultHTTPMatch; len(dm) > 0 {
+		httpMatch, _ = json.Marshal(dm)
+	}
 	return map[string]ExtensionInfo{
 		// Real extensions won't have a "/" in the extname, by putting one builtin extension names
 		// we can avoid clashes.
 		"/builtin/telepresence": {
 			Image: image,
 			Mechanisms: map[string]MechanismInfo{
-				"tcp": {},
+				"tcp": {
+					Flags: map[string]FlagInfo{
+						"latency": {
+							Type:  "duration",
+							Usage: `Add latency to the intercepted requests before they reach the local handler, e.g. "--tcp-latency=200ms"`,
+						},
+						"error-rate": {
+							Type:  "float64",
+							Usage: `Answer this fraction of the intercepted connections with an HTTP 503 response instead of forwarding them, or reset them if they don't start with an HTTP/1 request, e.g. "--tcp-error-rate=0.1"`,
+						},
+						"reset-rate": {
+							Type:  "float64",
+							Usage: `Reset this fraction of the intercepted connections instead of forwarding them, e.g. "--tcp-reset-rate=0.05"`,
+						},
+						"forwarded-headers": {
+							Type:  "bool",
+							Usage: `Add X-Forwarded-For, X-Real-IP, and Forwarded headers that identify the in-cluster caller to the intercepted HTTP/1 requests`,
+						},
+						"proxy-protocol": {
+							Type:  "bool",
+							Usage: `Send a PROXY protocol v1 header that identifies the in-cluster caller at the start of each intercepted connection`,
+						},
+					},
+				},
 			},
 		},
 		// FIXME(lukeshu): We shouldn't compile in the info about the Ambassador Smart Agent
@@ -36,7 +63,7 @@ func builtinExtensions(ctx context.Context) map[string]ExtensionInfo {
 					Flags: map[string]FlagInfo{
 						"match": {
 							Type:    "string-array",
-							Default: json.RawMessage(`["auto"]`),
+							Default: httpMatch,
 							Usage: `` +
 								`Rather than intercepting all traffic service, only intercept traffic that matches this "HTTP2_HEADER=REGEXP" specifier. ` +
 								`Instead of a "--http-match=HTTP2_HEADER=REGEXP" pair, you may say "--http-match=auto", which will automatically select a unique matcher for your intercept. ` +
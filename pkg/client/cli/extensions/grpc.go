@@ -41,10 +41,16 @@ func systemaGetPreferredAgentImageName(ctx context.Context, urlStr string) (stri
 		return "", fmt.Errorf("getting Ambassador Cloud preferred agent image: login error: %w", err)
 	}
 	creds := systemaCredentials(apikey)
+	policy, err := client.GetConfig(ctx).TLS.Policy()
+	if err != nil {
+		return "", err
+	}
+	tlsConfig := &tls.Config{ServerName: u.Hostname()}
+	policy.Apply(tlsConfig)
 
 	conn, err := grpc.DialContext(ctx,
 		(&url.URL{Scheme: "dns", Path: "/" + u.Host}).String(), // https://github.com/grpc/grpc/blob/master/doc/naming.md
-		grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{ServerName: u.Hostname()})),
+		grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)),
 		grpc.WithPerRPCCredentials(creds))
 	if err != nil {
 		return "", fmt.Errorf("getting Ambassador Cloud preferred agent image: dial error: %w", err)
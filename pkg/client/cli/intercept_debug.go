@@ -0,0 +1,59 @@
+package cli
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// defaultDebugEnv are the environment variables that make common runtimes listen for a debugger
+// when --debug-port is given. The JVM reads JAVA_TOOL_OPTIONS and Node.js reads NODE_OPTIONS, so
+// setting both is harmless.
+var defaultDebugEnv = map[string]string{
+	"JAVA_TOOL_OPTIONS": "-agentlib:jdwp=transport=dt_socket,server=y,suspend=n,address={{.Host}}:{{.Port}}",
+	"NODE_OPTIONS":      "--inspect={{.Host}}:{{.Port}}",
+}
+
+// debugAddr is the data that the debug environment templates are executed with
+type debugAddr struct {
+	Host string
+	Port uint16
+}
+
+// addDebugEnv adds the variables that make the intercepted process listen for a debugger at the
+// given address to env. The built-in templates are merged with the given ones, and a value is
+// appended to the value that the intercepted container has, so that other options, e.g. the heap
+// settings in JAVA_TOOL_OPTIONS, are kept.
+func addDebugEnv(env map[string]string, addr debugAddr, templates map[string]string) error {
+	all := make(map[string]string, len(defaultDebugEnv)+len(templates))
+	for k, v := range defaultDebugEnv {
+		all[k] = v
+	}
+	for k, v := range templates {
+		all[k] = v
+	}
+	keys := make([]string, 0, len(all))
+	for k, v := range all {
+		if v != "" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		tpl, err := template.New(k).Parse(all[k])
+		if err != nil {
+			return fmt.Errorf("invalid debug environment template for %s: %w", k, err)
+		}
+		sb := strings.Builder{}
+		if err = tpl.Execute(&sb, addr); err != nil {
+			return fmt.Errorf("invalid debug environment template for %s: %w", k, err)
+		}
+		if prior := env[k]; prior != "" {
+			env[k] = prior + " " + sb.String()
+		} else {
+			env[k] = sb.String()
+		}
+	}
+	return nil
+}
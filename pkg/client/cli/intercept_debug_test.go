@@ -0,0 +1,23 @@
+package cli
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddDebugEnv(t *testing.T) {
+	env := map[string]string{"JAVA_TOOL_OPTIONS": "-Xmx512m", "HOME": "/root"}
+	require.NoError(t, addDebugEnv(env, debugAddr{Host: "127.0.0.1", Port: 5005}, map[string]string{
+		"NODE_OPTIONS": "",
+		"DEBUG_ADDR":   "{{.Host}}:{{.Port}}",
+	}))
+	assert.Equal(t, map[string]string{
+		"JAVA_TOOL_OPTIONS": "-Xmx512m -agentlib:jdwp=transport=dt_socket,server=y,suspend=n,address=127.0.0.1:5005",
+		"DEBUG_ADDR":        "127.0.0.1:5005",
+		"HOME":              "/root",
+	}, env)
+
+	assert.Error(t, addDebugEnv(map[string]string{}, debugAddr{Port: 5005}, map[string]string{"X": "{{.Nope}}"}))
+}
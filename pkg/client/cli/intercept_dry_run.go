@@ -0,0 +1,100 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"google.golang.org/grpc"
+
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/cliutil"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/connector/userd_intercept"
+)
+
+// dryRun prints what the intercept would do instead of creating it.
+func (is *interceptState) dryRun(ctx context.Context) error {
+	ir, err := is.createRequest(ctx)
+	if err != nil {
+		return err
+	}
+	if ir.MountPoint != "" {
+		// createRequest prepared a mount point that won't be used
+		defer os.Remove(ir.MountPoint)
+	}
+	var plan *connector.InterceptPlan
+	err = cliutil.WithConnectorConn(ctx, func(ctx context.Context, conn grpc.ClientConnInterface) (err error) {
+		plan, err = connector.NewInterceptPlansClient(conn).PlanIntercept(ctx, ir)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	writeInterceptPlan(is.cmd.OutOrStdout(), plan)
+	if userd_intercept.Blocked(plan) {
+		return errors.New("the intercept would fail")
+	}
+	return nil
+}
+
+// writeInterceptPlan writes the given plan in the style of "telepresence status".
+func writeInterceptPlan(out io.Writer, plan *connector.InterceptPlan) {
+	fmt.Fprintf(out, "Intercept %s of %s %s.%s (dry-run, nothing was changed)\n", plan.Name, plan.Kind, plan.Workload, plan.Namespace)
+	var agent string
+	switch plan.Agent {
+	case userd_intercept.AgentUpToDate:
+		agent = "already installed and up to date"
+	case userd_intercept.AgentAdd:
+		agent = "added to the pod template"
+	case userd_intercept.AgentUpgrade:
+		agent = "upgraded"
+	case userd_intercept.AgentInjected:
+		agent = "injected by the traffic-manager"
+	case userd_intercept.AgentEnableInjection:
+		agent = "injected by the traffic-manager into a new revision"
+	default:
+		agent = plan.Agent
+	}
+	if plan.Rollout {
+		agent += ", the pods are rolled out"
+	}
+	fmt.Fprintf(out, "  Agent      : %s\n", agent)
+	if plan.Service != "" {
+		if plan.ServicePort != "" {
+			fmt.Fprintf(out, "  Service    : %s, port %s\n", plan.Service, plan.ServicePort)
+		} else {
+			fmt.Fprintf(out, "  Service    : %s\n", plan.Service)
+		}
+	}
+	fmt.Fprintf(out, "  Changes    : (%d)\n", len(plan.Changes))
+	for _, c := range plan.Changes {
+		fmt.Fprintf(out, "    - %s\n", c)
+	}
+	fmt.Fprintf(out, "  Permissions: (%d)\n", len(plan.Permissions))
+	for _, pm := range plan.Permissions {
+		optional := ""
+		if pm.Optional {
+			optional = " (optional)"
+		}
+		switch {
+		case pm.Allowed:
+			fmt.Fprintf(out, "    - %s %s%s: allowed\n", pm.Verb, pm.Resource, optional)
+		case pm.Error != "":
+			fmt.Fprintf(out, "    - %s %s%s: unknown, %s\n", pm.Verb, pm.Resource, optional, pm.Error)
+		default:
+			fmt.Fprintf(out, "    - %s %s%s: denied\n", pm.Verb, pm.Resource, optional)
+		}
+	}
+	fmt.Fprintf(out, "  Conflicts  : (%d)\n", len(plan.Conflicts))
+	for _, c := range plan.Conflicts {
+		fmt.Fprintf(out, "    - %s\n", c)
+	}
+	if len(plan.Warnings) > 0 {
+		fmt.Fprintf(out, "  Warnings   : (%d)\n", len(plan.Warnings))
+		for _, w := range plan.Warnings {
+			fmt.Fprintf(out, "    - %s\n", w)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package cli
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/connector/userd_intercept"
+)
+
+func TestWriteInterceptPlan(t *testing.T) {
+	plan := &connector.InterceptPlan{
+		Name:        "echo",
+		Workload:    "echo",
+		Namespace:   "default",
+		Kind:        "Deployment",
+		Agent:       userd_intercept.AgentAdd,
+		Rollout:     true,
+		Service:     "echo",
+		ServicePort: "http",
+		Changes: []string{
+			"In Deployment echo, add traffic-agent container with image tel2:2.3.6",
+			`In Service echo, make service port http symbolic with name "tx-8080"`,
+		},
+		Permissions: []*connector.InterceptPlan_Permission{
+			{Verb: "update", Resource: "deployments.apps", Allowed: true},
+			{Verb: "update", Resource: "services"},
+			{Verb: "update", Resource: "horizontalpodautoscalers.autoscaling", Optional: true},
+		},
+		Warnings: []string{"the rollout may make 2 pods unavailable, but PodDisruptionBudget echo only allows 1 disruption"},
+	}
+	sb := strings.Builder{}
+	writeInterceptPlan(&sb, plan)
+	assert.Equal(t, `Intercept echo of Deployment echo.default (dry-run, nothing was changed)
+  Agent      : added to the pod template, the pods are rolled out
+  Service    : echo, port http
+  Changes    : (2)
+    - In Deployment echo, add traffic-agent container with image tel2:2.3.6
+    - In Service echo, make service port http symbolic with name "tx-8080"
+  Permissions: (3)
+    - update deployments.apps: allowed
+    - update services: denied
+    - update horizontalpodautoscalers.autoscaling (optional): denied
+  Conflicts  : (0)
+  Warnings   : (1)
+    - the rollout may make 2 pods unavailable, but PodDisruptionBudget echo only allows 1 disruption
+`, sb.String())
+	assert.True(t, userd_intercept.Blocked(plan))
+
+	plan = &connector.InterceptPlan{
+		Name:      "echo",
+		Workload:  "echo",
+		Namespace: "default",
+		Kind:      "Deployment",
+		Agent:     userd_intercept.AgentUpToDate,
+		Conflicts: []string{"127.0.0.1:8080 is already the target of intercept other"},
+	}
+	sb.Reset()
+	writeInterceptPlan(&sb, plan)
+	assert.Contains(t, sb.String(), "  Agent      : already installed and up to date\n")
+	assert.Contains(t, sb.String(), "  Conflicts  : (1)\n    - 127.0.0.1:8080 is already the target of intercept other\n")
+	assert.True(t, userd_intercept.Blocked(plan))
+
+	plan.Conflicts = nil
+	assert.False(t, userd_intercept.Blocked(plan))
+
+	// A missing permission that is optional doesn't block the intercept
+	plan.Permissions = []*connector.InterceptPlan_Permission{{Verb: "update", Resource: "horizontalpodautoscalers.autoscaling", Optional: true}}
+	assert.False(t, userd_intercept.Blocked(plan))
+}
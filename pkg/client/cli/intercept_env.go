@@ -0,0 +1,154 @@
+package cli
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+)
+
+// transformEnv returns the environment of an intercepted container transformed according to the
+// given config. Variables are dropped first, then renamed, then hosts in the values are replaced,
+// and finally the variables of et.Set are added. The clusterDomain is the DNS domain of the cluster
+// that the hosts of et.Hosts that end with ".svc" are qualified with.
+func transformEnv(env map[string]string, et *client.EnvTransform, clusterDomain string) (map[string]string, error) {
+	if et.IsZero() {
+		return env, nil
+	}
+	result := make(map[string]string, len(env)+len(et.Set))
+	for k, v := range env {
+		if dropEnv(k, et.Drop) {
+			continue
+		}
+		if nk, ok := et.Rename[k]; ok {
+			k = nk
+		}
+		result[k] = v
+	}
+
+	if len(et.Hosts) > 0 {
+		rs, err := hostReplacers(et.Hosts, clusterDomain)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range result {
+			for _, r := range rs {
+				v = r.replace(v)
+			}
+			result[k] = v
+		}
+	}
+
+	keys := make([]string, 0, len(et.Set))
+	for k := range et.Set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	values := make(map[string]string, len(keys))
+	for _, k := range keys {
+		tpl, err := template.New(k).Option("missingkey=zero").Parse(et.Set[k])
+		if err != nil {
+			return nil, fmt.Errorf("invalid environment template for %s: %w", k, err)
+		}
+		sb := strings.Builder{}
+		if err = tpl.Execute(&sb, result); err != nil {
+			return nil, fmt.Errorf("invalid environment template for %s: %w", k, err)
+		}
+		values[k] = sb.String()
+	}
+	// All templates see the same environment, regardless of the order that they're executed in
+	for k, v := range values {
+		result[k] = v
+	}
+	return result, nil
+}
+
+func dropEnv(name string, patterns []string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+type hostReplacer struct {
+	rx   *regexp.Regexp
+	repl string
+}
+
+// isHostChar returns true if c can be part of a host name. A host only matches where it's not
+// preceded or followed by such a character, so that "db.prod" matches neither in "mydb.prod" nor
+// in "db.production".
+func isHostChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '.' || c == '-'
+}
+
+func (r *hostReplacer) replace(s string) string {
+	sb := strings.Builder{}
+	last := 0
+	for _, m := range r.rx.FindAllStringIndex(s, -1) {
+		if m[0] > 0 && isHostChar(s[m[0]-1]) || m[1] < len(s) && isHostChar(s[m[1]]) {
+			continue
+		}
+		sb.WriteString(s[last:m[0]])
+		sb.WriteString(r.repl)
+		last = m[1]
+	}
+	if last == 0 {
+		return s
+	}
+	sb.WriteString(s[last:])
+	return sb.String()
+}
+
+// hostReplacers returns a replacer for each of the given hosts, longest first, so that a
+// "host:port" is replaced before the "host" on its own is. A host that ends with ".svc", e.g.
+// "redis.prod.svc", also gets a replacer for its fully qualified name in the given cluster domain,
+// e.g. "redis.prod.svc.cluster.local", so that the same config works for all clusters.
+func hostReplacers(hosts map[string]string, clusterDomain string) ([]*hostReplacer, error) {
+	if clusterDomain != "" {
+		qualified := make(map[string]string, len(hosts))
+		for k, v := range hosts {
+			qualified[k] = v
+			host, port := k, ""
+			if i := strings.LastIndexByte(k, ':'); i > 0 {
+				host, port = k[:i], k[i:]
+			}
+			if strings.HasSuffix(host, ".svc") {
+				fq := host + "." + clusterDomain + port
+				if _, ok := hosts[fq]; !ok {
+					qualified[fq] = v
+				}
+			}
+		}
+		hosts = qualified
+	}
+	keys := make([]string, 0, len(hosts))
+	for k := range hosts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if len(keys[i]) != len(keys[j]) {
+			return len(keys[i]) > len(keys[j])
+		}
+		return keys[i] < keys[j]
+	})
+	rs := make([]*hostReplacer, len(keys))
+	for i, k := range keys {
+		expr := regexp.QuoteMeta(k)
+		if strings.HasPrefix(k, "*.") {
+			expr = `[A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)*` + regexp.QuoteMeta(k[1:])
+		}
+		rx, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid host %q: %w", k, err)
+		}
+		rs[i] = &hostReplacer{rx: rx, repl: hosts[k]}
+	}
+	return rs, nil
+}
@@ -0,0 +1,74 @@
+package cli
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+)
+
+func TestTransformEnv(t *testing.T) {
+	env := map[string]string{
+		"KUBERNETES_SERVICE_HOST": "10.0.0.1",
+		"KUBERNETES_PORT":         "tcp://10.0.0.1:443",
+		"REDIS_URL":               "redis://redis.prod.svc.cluster.local:6379/0",
+		"DB_HOST":                 "db.prod.svc.cluster.local",
+		"PEERS":                   "api.prod.svc.cluster.local:8080,myredis.prod.svc.cluster.local:6379",
+		"LOG_LEVEL":               "info",
+	}
+	result, err := transformEnv(env, &client.EnvTransform{
+		Drop:   []string{"KUBERNETES_*"},
+		Rename: map[string]string{"LOG_LEVEL": "APP_LOG_LEVEL"},
+		Hosts: map[string]string{
+			"redis.prod.svc.cluster.local:6379": "localhost:6380",
+			"*.prod.svc.cluster.local":          "localhost",
+		},
+		Set: map[string]string{
+			"DB_URL":    "postgres://{{.DB_HOST}}:5432/app",
+			"LOG_LEVEL": "{{.APP_LOG_LEVEL}}",
+		},
+	}, "cluster.local")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"REDIS_URL":     "redis://localhost:6380/0",
+		"DB_HOST":       "localhost",
+		"PEERS":         "localhost:8080,localhost:6379",
+		"APP_LOG_LEVEL": "info",
+		"LOG_LEVEL":     "info",
+		"DB_URL":        "postgres://localhost:5432/app",
+	}, result)
+
+	// Hosts only match as a whole
+	result, err = transformEnv(map[string]string{"A": "mydb.prod,db.production,db.prod"}, &client.EnvTransform{
+		Hosts: map[string]string{"db.prod": "localhost"},
+	}, "cluster.local")
+	require.NoError(t, err)
+	assert.Equal(t, "mydb.prod,db.production,localhost", result["A"])
+
+	// No transform leaves the environment as is
+	result, err = transformEnv(env, &client.EnvTransform{}, "cluster.local")
+	require.NoError(t, err)
+	assert.Equal(t, env, result)
+
+	// Hosts that end with .svc also match their fully qualified names in the cluster domain
+	result, err = transformEnv(map[string]string{
+		"A": "redis.prod.svc:6379",
+		"B": "redis.prod.svc.corp.internal:6379",
+		"C": "api.prod.svc.corp.internal",
+		"D": "redis.prod.svc.cluster.local:6379",
+	}, &client.EnvTransform{
+		Hosts: map[string]string{
+			"redis.prod.svc:6379": "localhost:6380",
+			"*.prod.svc":          "localhost",
+		},
+	}, "corp.internal")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"A": "localhost:6380",
+		"B": "localhost:6380",
+		"C": "localhost",
+		"D": "redis.prod.svc.cluster.local:6379",
+	}, result)
+}
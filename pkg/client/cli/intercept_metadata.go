@@ -0,0 +1,108 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+)
+
+// interceptMetadata is what an intercept handler gets from the metadata endpoint, so that it can
+// adapt its behavior when it runs under an intercept, e.g. by tagging its telemetry. The same
+// information, except for the headers, is found in the TELEPRESENCE_INTERCEPT_* environment
+// variables of the handler.
+type interceptMetadata struct {
+	ID               string            `json:"id"`
+	Name             string            `json:"name"`
+	Namespace        string            `json:"namespace"`
+	Workload         string            `json:"workload"`
+	Pod              string            `json:"pod,omitempty"`
+	ClusterDomain    string            `json:"clusterDomain,omitempty"`
+	Headers          map[string]string `json:"headers,omitempty"`
+	MatchDescription string            `json:"matchDescription,omitempty"`
+}
+
+func newInterceptMetadata(ii *manager.InterceptInfo, env map[string]string) *interceptMetadata {
+	return &interceptMetadata{
+		ID:               ii.Id,
+		Name:             ii.Spec.Name,
+		Namespace:        ii.Spec.Namespace,
+		Workload:         ii.Spec.Agent,
+		Pod:              env["TELEPRESENCE_INTERCEPT_POD"],
+		ClusterDomain:    env["TELEPRESENCE_CLUSTER_DOMAIN"],
+		Headers:          matchedHeaders(ii.Spec.MechanismArgs),
+		MatchDescription: ii.MechanismArgsDesc,
+	}
+}
+
+// matchedHeaders returns the headers of the "--http-match=NAME=REGEXP" arguments of an intercept
+// mechanism. The "auto" and "all" matches don't name a header, so they're left out.
+func matchedHeaders(mechanismArgs []string) map[string]string {
+	var headers map[string]string
+	for i := 0; i < len(mechanismArgs); i++ {
+		var match string
+		switch arg := mechanismArgs[i]; {
+		case strings.HasPrefix(arg, "--http-match="):
+			match = strings.TrimPrefix(arg, "--http-match=")
+		case arg == "--http-match" && i+1 < len(mechanismArgs):
+			i++
+			match = mechanismArgs[i]
+		default:
+			continue
+		}
+		if name, value := splitMatch(match); name != "" {
+			if headers == nil {
+				headers = make(map[string]string)
+			}
+			headers[name] = value
+		}
+	}
+	return headers
+}
+
+func splitMatch(match string) (string, string) {
+	eq := strings.IndexByte(match, '=')
+	if eq <= 0 {
+		// "auto", "all", or a malformed match
+		return "", ""
+	}
+	return match[:eq], match[eq+1:]
+}
+
+func (md *interceptMetadata) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == http.MethodGet {
+		_ = json.NewEncoder(w).Encode(md)
+	}
+}
+
+// serveInterceptMetadata serves the given metadata on the loopback interface until the given
+// context is done, and returns its URL. The URL is passed to the intercept handler in the
+// propagation.MetadataURLEnv variable.
+func serveInterceptMetadata(ctx context.Context, md *interceptMetadata) (string, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	srv := &http.Server{Handler: md, ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		<-ctx.Done()
+		_ = srv.Close()
+	}()
+	go func() {
+		if err := srv.Serve(l); err != nil && err != http.ErrServerClosed {
+			dlog.Errorf(ctx, "intercept metadata endpoint failed: %v", err)
+		}
+	}()
+	return "http://" + l.Addr().String() + "/", nil
+}
@@ -0,0 +1,49 @@
+package cli
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatchedHeaders(t *testing.T) {
+	assert.Nil(t, matchedHeaders(nil))
+	assert.Nil(t, matchedHeaders([]string{"--http-match=auto"}))
+	assert.Nil(t, matchedHeaders([]string{"--http-match=all", "--http-path-prefix=/api"}))
+	assert.Equal(t, map[string]string{
+		"x-user":  "alice",
+		"x-trace": "a=b",
+	}, matchedHeaders([]string{"--http-match=x-user=alice", "--http-match", "x-trace=a=b"}))
+}
+
+func TestInterceptMetadataHandler(t *testing.T) {
+	md := &interceptMetadata{
+		ID:            "abc:echo",
+		Name:          "echo",
+		Namespace:     "default",
+		Workload:      "echo",
+		Pod:           "echo-5f7d8c-x2kqz",
+		ClusterDomain: "cluster.local",
+		Headers:       map[string]string{"x-user": "alice"},
+	}
+	srv := httptest.NewServer(md)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+	var got interceptMetadata
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&got))
+	assert.Equal(t, *md, got)
+
+	resp, err = http.Post(srv.URL, "text/plain", nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+}
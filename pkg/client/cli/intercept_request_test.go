@@ -0,0 +1,67 @@
+package cli
+
+import (
+	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/extensions"
+)
+
+func TestInterceptState_createRequest(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	es, err := extensions.LoadExtensions(ctx, pflag.NewFlagSet("", pflag.ContinueOnError))
+	require.NoError(t, err)
+	newState := func(port string, dockerRun bool) *interceptState {
+		return &interceptState{args: interceptArgs{
+			name:      "echo-default",
+			agentName: "echo",
+			namespace: "default",
+			port:      port,
+			mount:     "false",
+			toPod:     []string{"9090"},
+			dockerRun: dockerRun,
+			extState:  es,
+		}}
+	}
+
+	is := newState("8081:http", false)
+	is.args.duration = 2 * time.Hour
+	ir, err := is.createRequest(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2*time.Hour, ir.Spec.Duration.AsDuration())
+	assert.Equal(t, "echo-default", ir.Spec.Name)
+	assert.Equal(t, "echo", ir.Spec.Agent)
+	assert.Equal(t, "http", ir.Spec.ServicePortIdentifier)
+	assert.Equal(t, int32(8081), ir.Spec.TargetPort)
+	assert.Equal(t, []int32{9090}, ir.Spec.ExtraPorts)
+	assert.Equal(t, "tcp", ir.Spec.Mechanism)
+	assert.Empty(t, ir.MountPoint)
+	assert.Equal(t, uint16(8081), is.localPort)
+
+	is = newState("8081:80:http", true)
+	ir, err = is.createRequest(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "http", ir.Spec.ServicePortIdentifier)
+	assert.Equal(t, uint16(80), is.dockerPort)
+
+	is = newState("8081", true)
+	_, err = is.createRequest(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, uint16(8081), is.dockerPort)
+
+	_, err = newState("8081:80:http", false).createRequest(ctx)
+	assert.EqualError(t, err, "ports must be of the format --ports <local-port>[:<svcPortIdentifier>]")
+
+	_, err = newState("http", false).createRequest(ctx)
+	assert.EqualError(t, err, `port numbers must be a valid, positive int, you gave: "http"`)
+
+	ir, err = (&interceptState{args: interceptArgs{name: "local", namespace: "default"}}).createRequest(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "local", ir.Spec.Name)
+	assert.Empty(t, ir.Spec.Agent)
+}
@@ -0,0 +1,42 @@
+package cli
+
+import (
+	"os"
+	"strings"
+
+	//nolint:depguard // Only exec.LookPath is used.
+	"os/exec"
+
+	"github.com/spf13/cobra"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/clientapi"
+)
+
+// pluginPrefix is the prefix of the executables that provide plugin subcommands, i.e. the command
+// "telepresence foo" is provided by an executable named "telepresence-foo" that is found in the
+// PATH.
+const pluginPrefix = "telepresence-"
+
+// findPlugin returns the path of the executable that provides the plugin subcommand with the given
+// name, or an empty string if there is no such executable.
+func findPlugin(name string) string {
+	if name == "" || strings.HasPrefix(name, "-") || strings.ContainsAny(name, `/\`) {
+		return ""
+	}
+	path, err := exec.LookPath(pluginPrefix + name)
+	if err != nil {
+		return ""
+	}
+	return path
+}
+
+// runPlugin runs the given plugin executable with the given arguments. The plugin inherits the
+// standard streams of this command and is told the path of this executable, see
+// clientapi.RunPlugin.
+func runPlugin(cmd *cobra.Command, path string, args []string) error {
+	var env []string
+	if exe, err := os.Executable(); err == nil {
+		env = append(env, clientapi.ExecutableEnv+"="+exe)
+	}
+	return start(cmd.Context(), path, args, true, cmd.InOrStdin(), cmd.OutOrStdout(), cmd.ErrOrStderr(), env...)
+}
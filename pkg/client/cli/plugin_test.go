@@ -0,0 +1,50 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlugin(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("plugin test uses a shell script")
+	}
+	dir := t.TempDir()
+	script := "#!/bin/sh\necho \"$@\"\n[ -n \"$TELEPRESENCE_EXECUTABLE\" ] && echo has-executable\nexit 0\n"
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, pluginPrefix+"hello"), []byte(script), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, pluginPrefix+"noexec"), []byte(script), 0644))
+	path := os.Getenv("PATH")
+	defer func() {
+		_ = os.Setenv("PATH", path)
+	}()
+	require.NoError(t, os.Setenv("PATH", dir+string(os.PathListSeparator)+path))
+
+	assert.Equal(t, filepath.Join(dir, pluginPrefix+"hello"), findPlugin("hello"))
+	assert.Empty(t, findPlugin("noexec"))
+	assert.Empty(t, findPlugin("missing"))
+	assert.Empty(t, findPlugin("--hello"))
+	assert.Empty(t, findPlugin("../hello"))
+
+	cmd := &cobra.Command{
+		DisableFlagParsing: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runPlugin(cmd, findPlugin("hello"), args)
+		},
+	}
+	out := &bytes.Buffer{}
+	cmd.SetOut(out)
+	cmd.SetErr(out)
+	cmd.SetArgs([]string{"a", "--help"})
+	require.NoError(t, cmd.ExecuteContext(context.Background()))
+	assert.Equal(t, []string{"a --help", "has-executable"}, strings.Split(strings.TrimSpace(out.String()), "\n"))
+}
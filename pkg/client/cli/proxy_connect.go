@@ -0,0 +1,115 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/cliutil"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/connector/userd_mock"
+)
+
+type proxyInfo struct {
+	enabled    bool   // --proxy-via-container
+	address    string // --proxy-address
+	dnsAddress string // --proxy-dns
+	mock       bool   // --mock-cluster
+}
+
+func (pi *proxyInfo) addFlags(cmd *cobra.Command) {
+	flags := cmd.Flags()
+	flags.BoolVar(&pi.enabled, "proxy-via-container", false, ``+
+		`Connect without a root daemon or TUN device. The cluster is instead reached through a SOCKS5 proxy, `+
+		`which makes it possible to use Telepresence in unprivileged containers such as devcontainers`)
+	flags.StringVar(&pi.address, "proxy-address", "127.0.0.1:1080", ``+
+		`Address of the SOCKS5 proxy used with --proxy-via-container`)
+	flags.StringVar(&pi.dnsAddress, "proxy-dns", "", ``+
+		`Address of a DNS stub that resolves cluster names, used with --proxy-via-container. Not started unless set`)
+	flags.BoolVar(&pi.mock, "mock-cluster", false, ``+
+		`Connect to a mock cluster with a fixed set of namespaces, services, and workloads instead of a real one. `+
+		`The services are served by local stubs that the root daemon routes to, or that are reached through the `+
+		`SOCKS5 proxy when combined with --proxy-via-container. Intended for demos, tutorials, and UI development`)
+}
+
+// connectViaProxy starts the connector in proxy mode and connects it. The root daemon isn't used.
+func (pi *proxyInfo) connectViaProxy(cmd *cobra.Command) error {
+	// The connector inherits the environment of this process when it's launched
+	os.Setenv("TELEPRESENCE_PROXY_ADDRESS", pi.address)
+	os.Setenv("TELEPRESENCE_PROXY_DNS_ADDRESS", pi.dnsAddress)
+
+	return cliutil.WithConnector(cmd.Context(), func(ctx context.Context, _ connector.ConnectorClient) error {
+		if !cliutil.DidLaunchConnector(ctx) {
+			fmt.Fprintln(cmd.OutOrStdout(), "The user daemon was already running. Quit telepresence first if it wasn't started using --proxy-via-container")
+		}
+		if _, err := setConnectInfo(ctx, cmd.OutOrStdout()); err != nil {
+			return err
+		}
+		out := cmd.OutOrStdout()
+		fmt.Fprintf(out, "Cluster access is provided by a SOCKS5 proxy. Configure clients using:\n")
+		fmt.Fprintf(out, "  export ALL_PROXY=socks5h://%s\n", pi.address)
+		if pi.dnsAddress != "" {
+			fmt.Fprintf(out, "Cluster names can also be resolved using the DNS stub at %s\n", pi.dnsAddress)
+		}
+		fmt.Fprintf(out, "Other containers can use this session with the telepresence CLI by mounting the connector socket:\n")
+		fmt.Fprintf(out, "  --volume %s:%s\n", client.ConnectorSocketName, client.ConnectorSocketName)
+		return nil
+	})
+}
+
+// connectToMockCluster starts the connector with a mock cluster and connects it. No cluster is used.
+// The services of the mock cluster are reached through the root daemon, or through the SOCKS5
+// proxy when --proxy-via-container is given too.
+func (pi *proxyInfo) connectToMockCluster(cmd *cobra.Command) error {
+	ctx := cmd.Context()
+	if client.SocketExistsContext(ctx, client.ConnectorSocketName) {
+		// A connector that connects to a real cluster must not be mistaken for a mock cluster
+		err := cliutil.WithStartedConnector(ctx, func(ctx context.Context, cc connector.ConnectorClient) error {
+			ci, err := cc.Status(ctx, &connector.ConnectRequest{})
+			if err != nil {
+				return err
+			}
+			if ci.ClusterId != userd_mock.ClusterID {
+				return errors.New("the user daemon is already running and doesn't serve a mock cluster. Quit telepresence first")
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// The connector inherits the environment of this process when it's launched
+	os.Setenv("TELEPRESENCE_MOCK_CLUSTER", "true")
+	out := cmd.OutOrStdout()
+	printHelp := func(curl string) {
+		fmt.Fprintf(out, "This is a mock cluster. Its services are served by local stubs, try:\n")
+		fmt.Fprintf(out, "  telepresence list --namespace blog\n")
+		fmt.Fprintf(out, "  %s http://echo.default:8080\n", curl)
+	}
+	if !pi.enabled {
+		return withConnector(cmd, true, func(context.Context, connector.ConnectorClient, *connector.ConnectInfo) error {
+			printHelp("curl")
+			return nil
+		})
+	}
+
+	os.Setenv("TELEPRESENCE_PROXY_ADDRESS", pi.address)
+	os.Setenv("TELEPRESENCE_PROXY_DNS_ADDRESS", pi.dnsAddress)
+	return cliutil.WithConnector(ctx, func(ctx context.Context, _ connector.ConnectorClient) error {
+		if _, err := setConnectInfo(ctx, out); err != nil {
+			return err
+		}
+		printHelp("telepresence curl")
+		fmt.Fprintf(out, "Other clients reach the services are reached using the SOCKS5 proxy:\n")
+		fmt.Fprintf(out, "  export ALL_PROXY=socks5h://%s\n", pi.address)
+		if pi.dnsAddress != "" {
+			fmt.Fprintf(out, "The names of the services can also be resolved using the DNS stub at %s\n", pi.dnsAddress)
+		}
+		return nil
+	})
+}
@@ -3,7 +3,6 @@ package cli_test
 import (
 	"bufio"
 	"context"
-	"encoding/base64"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -32,6 +31,7 @@ import (
 	"github.com/telepresenceio/telepresence/v2/pkg/client"
 	_ "github.com/telepresenceio/telepresence/v2/pkg/client/cli"
 	"github.com/telepresenceio/telepresence/v2/pkg/filelocation"
+	"github.com/telepresenceio/telepresence/v2/pkg/itest"
 	"github.com/telepresenceio/telepresence/v2/pkg/version"
 )
 
@@ -48,22 +48,14 @@ func TestTelepresence(t *testing.T) {
 
 type telepresenceSuite struct {
 	suite.Suite
+	h                    *itest.Harness
 	testVersion          string
 	namespace            string
 	managerTestNamespace string
 }
 
 func (ts *telepresenceSuite) SetupSuite() {
-	// Check that the "ko" program exists, and adjust PATH as necessary.
-	if info, err := os.Stat("../../../tools/bin/ko"); err != nil || !info.Mode().IsRegular() || (info.Mode().Perm()&0100) == 0 {
-		ts.FailNow("it looks like the ./tools/bin/ko executable wasn't built; be sure to build it with `make` before running `go test`!")
-	}
 	require := ts.Require()
-	toolbindir, err := filepath.Abs("../../../tools/bin")
-	require.NoError(err)
-	_ = os.Chdir("../../..")
-
-	os.Setenv("PATH", toolbindir+":"+os.Getenv("PATH"))
 
 	// Remove very verbose output from DTEST initialization
 	log.SetOutput(ioutil.Discard)
@@ -76,38 +68,26 @@ func (ts *telepresenceSuite) SetupSuite() {
 
 	ctx := dlog.NewTestContext(ts.T(), false)
 
-	wg := sync.WaitGroup{}
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		executable, err := ts.buildExecutable(ctx)
-		ts.NoError(err)
-		client.SetExe(executable)
-	}()
-
 	_ = os.Remove(client.ConnectorSocketName)
-	err = run(ctx, "sudo", "true")
+	err := run(ctx, "sudo", "true")
 	require.NoError(err, "acquire privileges")
 
-	registry := dtest.DockerRegistry(ctx)
-	os.Setenv("KO_DOCKER_REPO", registry)
-	os.Setenv("TELEPRESENCE_REGISTRY", registry)
-	os.Setenv("TELEPRESENCE_MANAGER_NAMESPACE", ts.managerTestNamespace)
+	ts.h, err = itest.New(ctx, &itest.Options{RootDir: "../../..", Version: ts.testVersion})
+	require.NoError(err)
+	client.SetExe(ts.h.Executable)
+	_ = os.Chdir(ts.h.RootDir)
 
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		err := ts.publishManager()
-		ts.NoError(err)
-	}()
+	os.Setenv("KO_DOCKER_REPO", ts.h.Registry)
+	os.Setenv("TELEPRESENCE_REGISTRY", ts.h.Registry)
+	os.Setenv("TELEPRESENCE_MANAGER_NAMESPACE", ts.managerTestNamespace)
 
-	wg.Wait()
+	require.NoError(ts.h.CreateNamespace(ctx, ts.namespace))
 
-	// We do this after the above goroutines are finished, instead of in parallel with them,
-	// because there seems to be a bug where buildExecutable sometimes modifies the kubeconfig and
-	// removes the telepresence-test-user that is created in this function.
-	ts.setupKubeConfig(ctx)
+	// We start with the default context, and will switch to the
+	// telepresence-test-developer user later in the tests
+	require.NoError(ts.h.SetupDeveloperUser(ctx))
 
+	wg := sync.WaitGroup{}
 	wg.Add(serviceCount)
 	for i := 0; i < serviceCount; i++ {
 		i := i
@@ -162,9 +142,8 @@ func (ts *telepresenceSuite) TearDownSuite() {
 	_ = run(ctx, "kubectl", "delete", "mutatingwebhookconfiguration", "agent-injector-webhook-"+ts.managerTestNamespace)
 	_ = run(ctx, "kubectl", "delete", "namespace", ts.managerTestNamespace)
 	// Undo RBAC things
-	_ = run(ctx, "kubectl", "delete", "-f", "k8s/client_rbac.yaml")
-	_ = run(ctx, "kubectl", "config", "delete-context", "telepresence-test-developer")
-	_ = run(ctx, "kubectl", "config", "delete-user", "telepresence-test-developer")
+	ts.h.TeardownDeveloperUser(ctx)
+	ts.h.Close(ctx)
 }
 
 func (ts *telepresenceSuite) TestA_WithNoDaemonRunning() {
@@ -220,7 +199,7 @@ func (ts *telepresenceSuite) TestA_WithNoDaemonRunning() {
 		configDir := t.TempDir()
 
 		ctx := dlog.NewTestContext(t, false)
-		registry := dtest.DockerRegistry(ctx)
+		registry := os.Getenv("TELEPRESENCE_REGISTRY")
 		configYml := fmt.Sprintf("logLevels:\n  rootDaemon: debug\nimages:\n  registry: %s\n", registry)
 		ctx, err := setConfig(ctx, configDir, configYml)
 		require.NoError(err)
@@ -249,12 +228,12 @@ func (ts *telepresenceSuite) TestA_WithNoDaemonRunning() {
 		require := ts.Require()
 
 		tmpDir := t.TempDir()
-		origKubeconfigFileName := os.Getenv("DTEST_KUBECONFIG")
+		origKubeconfigFileName := ts.h.Kubeconfig
 		kubeconfigFileName := filepath.Join(tmpDir, "kubeconfig")
 
 		var cfg *api.Config
 		cfg, err := clientcmd.LoadFromFile(origKubeconfigFileName)
-		require.NoError(err, "Unable to read DTEST_KUBECONFIG")
+		require.NoError(err, "Unable to read the kubeconfig")
 		require.NoError(err, api.MinifyConfig(cfg), "unable to minify config")
 		var cluster *api.Cluster
 		for _, c := range cfg.Clusters {
@@ -269,7 +248,7 @@ func (ts *telepresenceSuite) TestA_WithNoDaemonRunning() {
 		require.NoError(clientcmd.WriteToFile(*cfg, kubeconfigFileName), "unable to write modified kubeconfig")
 
 		ctx := dlog.NewTestContext(t, false)
-		registry := dtest.DockerRegistry(ctx)
+		registry := os.Getenv("TELEPRESENCE_REGISTRY")
 		configYml := fmt.Sprintf("logLevels:\n  rootDaemon: debug\nimages:\n  registry: %s\n", registry)
 		ctx, err = setConfig(ctx, tmpDir, configYml)
 		require.NoError(err)
@@ -315,7 +294,7 @@ func (ts *telepresenceSuite) TestA_WithNoDaemonRunning() {
 		// Use a config with agentImage and webhookAgentImage to validate that its the
 		// latter that is used in the traffic-manager
 		ctx := dlog.NewTestContext(t, false)
-		registry := dtest.DockerRegistry(ctx)
+		registry := os.Getenv("TELEPRESENCE_REGISTRY")
 		configYml := fmt.Sprintf("images:\n  registry: %s\n  agentImage: notUsed:0.0.1\n  webhookAgentImage: imageFromConfig:0.0.1\n  webhookRegistry: %s", registry, registry)
 		ctx, err := setConfig(ctx, configDir, configYml)
 		require.NoError(err)
@@ -1274,21 +1253,7 @@ func (hs *helmSuite) TestZ_Uninstall() {
 }
 
 func (hs *helmSuite) helmInstall(ctx context.Context, managerNamespace string, appNamespaces ...string) error {
-	clusterID, err := hs.tpSuite.kubectlOut(ctx, "get", "ns", hs.appNamespace1, "-o", "jsonpath={.metadata.uid}")
-	if err != nil {
-		return err
-	}
-	helmValues := "pkg/client/cli/testdata/test-values.yaml"
-	helmChart := "charts/telepresence"
-	return run(ctx, "helm", "install", "traffic-manager",
-		"-n", managerNamespace, helmChart,
-		"--set", fmt.Sprintf("clusterId=%s", clusterID),
-		"--set", fmt.Sprintf("image.registry=%s", dtest.DockerRegistry(ctx)),
-		"--set", fmt.Sprintf("image.tag=%s", hs.tpSuite.testVersion[1:]),
-		"--set", fmt.Sprintf("clientRbac.namespaces={%s}", strings.Join(append(appNamespaces, managerNamespace), ",")),
-		"--set", fmt.Sprintf("managerRbac.namespaces={%s}", strings.Join(append(appNamespaces, managerNamespace), ",")),
-		"-f", helmValues,
-	)
+	return hs.tpSuite.h.InstallManager(ctx, managerNamespace, appNamespaces, "-f", "pkg/client/cli/testdata/test-values.yaml")
 }
 
 func (hs *helmSuite) TearDownSuite() {
@@ -1300,128 +1265,27 @@ func (hs *helmSuite) TearDownSuite() {
 }
 
 func (ts *telepresenceSuite) applyApp(c context.Context, name, svcName string, port int) error {
-	err := ts.kubectl(c, "apply", "-f", fmt.Sprintf("k8s/%s.yaml", name), "--context", "default")
-	if err != nil {
-		return fmt.Errorf("failed to deploy %s: %w", name, err)
-	}
-	return ts.waitForService(c, svcName, port)
+	return ts.h.ApplyApp(c, ts.namespace, name, svcName, port)
 }
 
 func (ts *telepresenceSuite) applyEchoService(c context.Context, name string) error {
-	err := ts.kubectl(c, "create", "deploy", name, "--image", "jmalloc/echo-server:0.1.0")
-	if err != nil {
-		return fmt.Errorf("failed to create deployment %s: %w", name, err)
-	}
-	err = ts.kubectl(c, "expose", "deploy", name, "--port", "80", "--target-port", "8080")
-	if err != nil {
-		return fmt.Errorf("failed to expose deployment %s: %w", name, err)
-	}
-	return ts.waitForService(c, name, 80)
-}
-
-func (ts *telepresenceSuite) waitForService(c context.Context, name string, port int) error {
-	c, cancel := context.WithTimeout(c, 120*time.Second)
-	defer cancel()
-
-	// Since this function can be called multiple times in parallel
-	// we add the name of the service to the title of the pod so they
-	// can run at the same time. We strip out any characters that we
-	// can't use in a name in k8s.
-	reg := regexp.MustCompile("[^a-zA-Z0-9-]+")
-	k8sSafeName := reg.ReplaceAllString(name, "")
-	containerName := fmt.Sprintf("curl-%s-from-cluster", k8sSafeName)
-	for c.Err() == nil {
-		time.Sleep(time.Second)
-		err := ts.kubectl(c, "run", containerName, "--context", "default", "--rm", "-it",
-			"--image=docker.io/pstauffer/curl", "--restart=Never", "--",
-			"curl", "--silent", "--output", "/dev/null",
-			fmt.Sprintf("http://%s.%s:%d", name, ts.namespace, port),
-		)
-		if err == nil {
-			return nil
-		}
-	}
-	return fmt.Errorf("timed out waiting for %s service", name)
+	return ts.h.ApplyEchoService(c, ts.namespace, name)
 }
 
 func (ts *telepresenceSuite) kubectl(c context.Context, args ...string) error {
-	return run(c, append([]string{"kubectl", "--namespace", ts.namespace}, args...)...)
+	return ts.h.Kubectl(c, ts.namespace, args...)
 }
 
 func (ts *telepresenceSuite) kubectlOut(ctx context.Context, args ...string) (string, error) {
-	return output(ctx, append([]string{"kubectl", "--namespace", ts.namespace}, args...)...)
-}
-
-func (ts *telepresenceSuite) publishManager() error {
-	ctx := dlog.NewTestContext(ts.T(), true)
-	cmd := dexec.CommandContext(ctx, "make", "push-image")
-
-	// Go sets a lot of variables that we don't want to pass on to the ko executable. If we do,
-	// then it builds for the platform indicated by those variables.
-	cmd.Env = []string{
-		"TELEPRESENCE_VERSION=" + ts.testVersion,
-		"TELEPRESENCE_REGISTRY=" + dtest.DockerRegistry(ctx),
-	}
-	includeEnv := []string{"KO_DOCKER_REPO=", "HOME=", "PATH=", "LOGNAME=", "TMPDIR=", "MAKELEVEL="}
-	for _, env := range os.Environ() {
-		for _, incl := range includeEnv {
-			if strings.HasPrefix(env, incl) {
-				cmd.Env = append(cmd.Env, env)
-				break
-			}
-		}
-	}
-	if err := cmd.Run(); err != nil {
-		return client.RunError(err)
-	}
-	return nil
-}
-
-func (ts *telepresenceSuite) buildExecutable(c context.Context) (string, error) {
-	executable := filepath.Join("build-output", "bin", "/telepresence")
-	return executable, run(c, "go", "build", "-ldflags",
-		fmt.Sprintf("-X=github.com/telepresenceio/telepresence/v2/pkg/version.Version=%s", ts.testVersion),
-		"-o", executable, "./cmd/telepresence")
-}
-
-func (ts *telepresenceSuite) setupKubeConfig(ctx context.Context) {
-	kubeconfig := dtest.Kubeconfig(ctx)
-	os.Setenv("DTEST_KUBECONFIG", kubeconfig)
-	os.Setenv("KUBECONFIG", kubeconfig)
-	err := run(ctx, "kubectl", "create", "namespace", ts.namespace)
-	ts.NoError(err)
-	err = run(ctx, "kubectl", "apply", "-f", "k8s/client_rbac.yaml")
-	ts.NoError(err)
-
-	// This is how we create a user that has their rbac restricted to what we have in
-	// k8s/client_rbac.yaml. We do this by creating a service account and then getting
-	// the token from said service account and storing it in our kubeconfig.
-	secret, err := output(ctx, "kubectl", "get", "sa", "telepresence-test-developer", "-o", "jsonpath={.secrets[0].name}")
-	ts.NoError(err)
-	encSecret, err := output(ctx, "kubectl", "get", "secret", secret, "-o", "jsonpath={.data.token}")
-	ts.NoError(err)
-	token, err := base64.StdEncoding.DecodeString(encSecret)
-	ts.NoError(err)
-	err = run(ctx, "kubectl", "config", "set-credentials", "telepresence-test-developer", "--token", string(token))
-	ts.NoError(err)
-	err = run(ctx, "kubectl", "config", "set-context", "telepresence-test-developer", "--user", "telepresence-test-developer", "--cluster", "default")
-	ts.NoError(err)
-
-	// We start with the default context, and will switch to the
-	// telepresence-test-developer user later in the tests
-	err = run(ctx, "kubectl", "config", "use-context", "default")
-	ts.NoError(err)
+	return ts.h.KubectlOut(ctx, ts.namespace, args...)
 }
 
 func run(c context.Context, args ...string) error {
-	return client.RunError(dexec.CommandContext(c, args[0], args[1:]...).Run())
+	return itest.Run(c, args...)
 }
 
 func output(ctx context.Context, args ...string) (string, error) {
-	cmd := dexec.CommandContext(ctx, args[0], args[1:]...)
-	cmd.DisableLogging = true
-	out, err := cmd.Output()
-	return string(out), client.RunError(err)
+	return itest.Output(ctx, args...)
 }
 
 // telepresence executes the CLI command in-process
@@ -1462,7 +1326,7 @@ func telepresenceContext(ctx context.Context, args ...string) (string, string) {
 // This ensures that the config on the machine of whatever is running the test,
 // isn't used, which could cause conflict with the tests.
 func setDefaultConfig(ctx context.Context, configDir string) (context.Context, error) {
-	registry := dtest.DockerRegistry(ctx)
+	registry := os.Getenv("TELEPRESENCE_REGISTRY")
 	configYml := fmt.Sprintf("images:\n  registry: %s\n  webhookRegistry: %s\n", registry, registry)
 	return setConfig(ctx, configDir, configYml)
 }
@@ -1470,18 +1334,5 @@ func setDefaultConfig(ctx context.Context, configDir string) (context.Context, e
 // setConfig clears the config and creates one from the configYml provided. Use this
 // if you are testing components of the config.yml, otherwise you can use setDefaultConfig.
 func setConfig(ctx context.Context, configDir, configYml string) (context.Context, error) {
-	client.ResetConfig(ctx)
-	config, err := os.Create(filepath.Join(configDir, "config.yml"))
-	if err != nil {
-		return ctx, err
-	}
-
-	_, err = config.WriteString(configYml)
-	if err != nil {
-		return ctx, err
-	}
-	config.Close()
-
-	ctx = filelocation.WithAppUserConfigDir(ctx, configDir)
-	return ctx, nil
+	return itest.SetConfig(ctx, configDir, configYml)
 }
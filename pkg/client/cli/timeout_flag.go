@@ -0,0 +1,33 @@
+package cli
+
+import (
+	"context"
+	"strings"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+)
+
+// timeoutOverrides is the value of the --timeout flag. The overrides are applied to the timeouts of
+// the config as soon as the flag is parsed, and the daemons that the command launches are given
+// them using their own --timeout flag.
+type timeoutOverrides struct {
+	ctx       context.Context
+	overrides []string
+}
+
+func (t *timeoutOverrides) String() string {
+	return strings.Join(t.overrides, ",")
+}
+
+func (t *timeoutOverrides) Set(s string) error {
+	overrides := strings.Split(s, ",")
+	if err := client.GetConfig(t.ctx).Timeouts.ApplyOverrides(overrides); err != nil {
+		return err
+	}
+	t.overrides = append(t.overrides, overrides...)
+	return nil
+}
+
+func (t *timeoutOverrides) Type() string {
+	return "name=duration"
+}
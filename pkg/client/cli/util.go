@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"os"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
@@ -11,23 +12,15 @@ import (
 	"github.com/datawire/dlib/dcontext"
 	"github.com/telepresenceio/telepresence/rpc/v2/connector"
 	"github.com/telepresenceio/telepresence/rpc/v2/daemon"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/cliutil"
+	"github.com/telepresenceio/telepresence/v2/pkg/clientapi"
+	"github.com/telepresenceio/telepresence/v2/pkg/tracing"
 )
 
 // quit sends the quit message to the daemon and waits for it to exit.
 func quit(ctx context.Context) error {
-	// When the daemon shuts down, it will tell the connector to shut down.
-	if err := cliutil.QuitDaemon(ctx); err != nil {
-		return err
-	}
-
-	// But also do that ourselves; to ensure the connector is killed even if daemon isn't
-	// running.  If the daemon already shut down the connector, then this is a no-op.
-	if err := cliutil.QuitConnector(ctx); err != nil {
-		return err
-	}
-
-	return nil
+	return clientapi.Quit(ctx)
 }
 
 func kubeFlagMap() map[string]string {
@@ -37,6 +30,13 @@ func kubeFlagMap() map[string]string {
 			kubeFlagMap[flag.Name] = flag.Value.String()
 		}
 	})
+	if _, ok := kubeFlagMap["kubeconfig"]; !ok {
+		// The user daemon outlives the shell that started it, so it must be told what
+		// kubeconfig files the user sees.
+		if kc := os.Getenv("KUBECONFIG"); kc != "" {
+			kubeFlagMap["KUBECONFIG"] = kc
+		}
+	}
 	return kubeFlagMap
 }
 
@@ -49,7 +49,23 @@ func kubeFlagMap() map[string]string {
 //
 //  - Makes the connector.Connect gRPC call to set up networking
 func withConnector(cmd *cobra.Command, retain bool, f func(context.Context, connector.ConnectorClient, *connector.ConnectInfo) error) error {
-	return cliutil.WithDaemon(cmd.Context(), dnsIP, func(ctx context.Context, daemonClient daemon.DaemonClient) (err error) {
+	defer traceCLI(cmd)()
+	reportCrashes(cmd)
+	ctx := cmd.Context()
+	if debugDaemons {
+		ctx = cliutil.WithDebug(ctx)
+	}
+	if client.SocketExistsContext(ctx, client.ConnectorSocketName) && !client.SocketExistsContext(ctx, client.DaemonSocketName) {
+		// A connector that runs without a root daemon was started using --proxy-via-container.
+		return cliutil.WithStartedConnector(ctx, func(ctx context.Context, connectorClient connector.ConnectorClient) error {
+			connInfo, err := setConnectInfo(ctx, cmd.OutOrStdout())
+			if err != nil {
+				return err
+			}
+			return f(ctx, connectorClient, connInfo)
+		})
+	}
+	return cliutil.WithDaemon(ctx, dnsIP, func(ctx context.Context, daemonClient daemon.DaemonClient) (err error) {
 		if cliutil.DidLaunchDaemon(ctx) {
 			defer func() {
 				if err != nil || !retain {
@@ -80,30 +96,22 @@ func withConnector(cmd *cobra.Command, retain bool, f func(context.Context, conn
 func setConnectInfo(ctx context.Context, stdout io.Writer) (*connector.ConnectInfo, error) {
 	var resp *connector.ConnectInfo
 	err := cliutil.WithStartedConnector(ctx, func(ctx context.Context, connectorClient connector.ConnectorClient) error {
+		ctx, span := tracing.Start(ctx, "connect")
+		defer span.End()
 		var err error
 		resp, err = connectorClient.Connect(ctx, &connector.ConnectRequest{
 			KubeFlags:        kubeFlagMap(),
 			MappedNamespaces: mappedNamespaces,
 		})
+		span.SetError(err)
 		if err != nil {
 			return err
 		}
 
-		var msg string
-		switch resp.Error {
-		case connector.ConnectInfo_UNSPECIFIED:
+		if resp.Error == connector.ConnectInfo_UNSPECIFIED {
 			fmt.Fprintf(stdout, "Connected to context %s (%s)\n", resp.ClusterContext, resp.ClusterServer)
-			return nil
-		case connector.ConnectInfo_ALREADY_CONNECTED:
-			return nil
-		case connector.ConnectInfo_DISCONNECTED:
-			msg = "Not connected"
-		case connector.ConnectInfo_MUST_RESTART:
-			msg = "Cluster configuration changed, please quit telepresence and reconnect"
-		case connector.ConnectInfo_TRAFFIC_MANAGER_FAILED, connector.ConnectInfo_CLUSTER_FAILED, connector.ConnectInfo_DAEMON_FAILED:
-			msg = resp.ErrorText
 		}
-		return fmt.Errorf("connector.Connect: %s", msg) // Return err != nil to ensure disconnect
+		return clientapi.CheckConnectInfo(resp) // Return err != nil to ensure disconnect
 	})
 	if err != nil {
 		return nil, err
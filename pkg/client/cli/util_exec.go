@@ -13,6 +13,7 @@ import (
 	"os/exec"
 
 	"github.com/datawire/dlib/dcontext"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/cliutil"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/logging"
 )
 
@@ -41,7 +42,7 @@ func start(ctx context.Context, exe string, args []string, wait bool, stdin io.R
 	if !wait {
 		// Process must live in a process group of its own to prevent
 		// getting affected by <ctrl-c> in the terminal
-		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+		cmd.SysProcAttr = cliutil.NewProcessGroupAttr()
 	}
 
 	var err error
@@ -63,15 +64,15 @@ func start(ctx context.Context, exe string, args []string, wait bool, stdin io.R
 		}
 		_ = cmd.Process.Signal(sig)
 	}()
-	s, err := cmd.Process.Wait()
+	// cmd.Wait, unlike cmd.Process.Wait, waits until all output has been copied to stdout and
+	// stderr when they aren't files.
+	err = cmd.Wait()
+	sigCh <- nil
 	if err != nil {
+		if ee, ok := err.(*exec.ExitError); ok {
+			return fmt.Errorf("%s %s: exited with %d", exe, strings.Join(args, " "), ee.ExitCode())
+		}
 		return fmt.Errorf("%s: %w", logging.ShellString(exe, args), err)
 	}
-
-	sigCh <- nil
-	exitCode := s.ExitCode()
-	if exitCode != 0 {
-		return fmt.Errorf("%s %s: exited with %d", exe, strings.Join(args, " "), exitCode)
-	}
 	return nil
 }
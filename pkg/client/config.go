@@ -5,37 +5,76 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"math"
+	"net"
 	"os"
+	"path"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"sync"
+	"text/template"
 	"time"
 
 	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/keepalive"
 	"gopkg.in/yaml.v3"
 	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/validation"
 
 	"github.com/datawire/dlib/dlog"
 	"github.com/telepresenceio/telepresence/v2/pkg/filelocation"
+	"github.com/telepresenceio/telepresence/v2/pkg/loglevel"
+	"github.com/telepresenceio/telepresence/v2/pkg/redact"
+	"github.com/telepresenceio/telepresence/v2/pkg/tlspolicy"
 )
 
 const configFile = "config.yml"
 
 type Config struct {
-	Timeouts  Timeouts  `json:"timeouts,omitempty"`
-	LogLevels LogLevels `json:"logLevels,omitempty"`
-	Images    Images    `json:"images,omitempty"`
-	Cloud     Cloud     `json:"cloud,omitempty"`
-	Grpc      Grpc      `json:"grpc,omitempty"`
+	Timeouts     Timeouts     `json:"timeouts,omitempty"`
+	LogLevels    LogLevels    `json:"logLevels,omitempty"`
+	LogFormat    string       `json:"logFormat,omitempty"`
+	LogRotation  LogRotation  `json:"logRotation,omitempty"`
+	Images       Images       `json:"images,omitempty"`
+	Cloud        Cloud        `json:"cloud,omitempty"`
+	Grpc         Grpc         `json:"grpc,omitempty"`
+	TLS          TLS          `json:"tls,omitempty"`
+	Redact       Redact       `json:"redact,omitempty"`
+	Tracing      Tracing      `json:"tracing,omitempty"`
+	Metrics      Metrics      `json:"metrics,omitempty"`
+	CrashReports CrashReports `json:"crashReports,omitempty"`
+	Cluster      Cluster      `json:"cluster,omitempty"`
+	LocalAPI     LocalAPI     `json:"localAPI,omitempty"`
+	Intercept    Intercept    `json:"intercept,omitempty"`
+	Routing      Routing      `json:"routing,omitempty"`
+	DNS          DNS          `json:"dns,omitempty"`
+	Reconnect    Reconnect    `json:"reconnect,omitempty"`
 }
 
 // merge merges this instance with the non-zero values of the given argument. The argument values take priority.
 func (c *Config) merge(o *Config) {
 	c.Timeouts.merge(&o.Timeouts)
 	c.LogLevels.merge(&o.LogLevels)
+	if o.LogFormat != "" {
+		c.LogFormat = o.LogFormat
+	}
+	c.LogRotation.merge(&o.LogRotation)
 	c.Images.merge(&o.Images)
 	c.Cloud.merge(&o.Cloud)
 	c.Grpc.merge(&o.Grpc)
+	c.TLS.merge(&o.TLS)
+	c.Redact.merge(&o.Redact)
+	c.Tracing.merge(&o.Tracing)
+	c.Metrics.merge(&o.Metrics)
+	c.CrashReports.merge(&o.CrashReports)
+	c.Cluster.merge(&o.Cluster)
+	c.LocalAPI.merge(&o.LocalAPI)
+	c.Intercept.merge(&o.Intercept)
+	c.Routing.merge(&o.Routing)
+	c.DNS.merge(&o.DNS)
+	c.Reconnect.merge(&o.Reconnect)
 }
 
 func stringKey(n *yaml.Node) (string, error) {
@@ -68,6 +107,18 @@ func (c *Config) UnmarshalYAML(node *yaml.Node) (err error) {
 			if err != nil {
 				return err
 			}
+		case kv == "logFormat":
+			switch v := ms[i+1].Value; v {
+			case "text", "json":
+				c.LogFormat = v
+			default:
+				return errors.New(withLoc(fmt.Sprintf("invalid log format %q, expected \"text\" or \"json\"", v), ms[i+1]))
+			}
+		case kv == "logRotation":
+			err := ms[i+1].Decode(&c.LogRotation)
+			if err != nil {
+				return err
+			}
 		case kv == "images":
 			err := ms[i+1].Decode(&c.Images)
 			if err != nil {
@@ -83,6 +134,61 @@ func (c *Config) UnmarshalYAML(node *yaml.Node) (err error) {
 			if err != nil {
 				return err
 			}
+		case kv == "tls":
+			err := ms[i+1].Decode(&c.TLS)
+			if err != nil {
+				return err
+			}
+		case kv == "redact":
+			err := ms[i+1].Decode(&c.Redact)
+			if err != nil {
+				return err
+			}
+		case kv == "tracing":
+			err := ms[i+1].Decode(&c.Tracing)
+			if err != nil {
+				return err
+			}
+		case kv == "metrics":
+			err := ms[i+1].Decode(&c.Metrics)
+			if err != nil {
+				return err
+			}
+		case kv == "crashReports":
+			err := ms[i+1].Decode(&c.CrashReports)
+			if err != nil {
+				return err
+			}
+		case kv == "cluster":
+			err := ms[i+1].Decode(&c.Cluster)
+			if err != nil {
+				return err
+			}
+		case kv == "localAPI":
+			err := ms[i+1].Decode(&c.LocalAPI)
+			if err != nil {
+				return err
+			}
+		case kv == "intercept":
+			err := ms[i+1].Decode(&c.Intercept)
+			if err != nil {
+				return err
+			}
+		case kv == "routing":
+			err := ms[i+1].Decode(&c.Routing)
+			if err != nil {
+				return err
+			}
+		case kv == "dns":
+			err := ms[i+1].Decode(&c.DNS)
+			if err != nil {
+				return err
+			}
+		case kv == "reconnect":
+			err := ms[i+1].Decode(&c.Reconnect)
+			if err != nil {
+				return err
+			}
 		case parseContext != nil:
 			dlog.Warn(parseContext, withLoc(fmt.Sprintf("unknown key %q", kv), ms[i]))
 		}
@@ -112,6 +218,11 @@ type Timeouts struct {
 	PrivateTrafficManagerAPI time.Duration `json:"trafficManagerAPI,omitempty"`
 	// PrivateTrafficManagerConnect is how long to wait for the initial port-forwards to the traffic-manager
 	PrivateTrafficManagerConnect time.Duration `json:"trafficManagerConnect,omitempty"`
+	// PrivateDaemonDial is how long to wait for the socket of the connector or root daemon to respond
+	PrivateDaemonDial time.Duration `json:"daemonDial,omitempty"`
+
+	// overrides are the <name>=<duration> overrides that have been applied, see ApplyOverrides
+	overrides []string
 }
 
 type TimeoutID int
@@ -124,8 +235,14 @@ const (
 	TimeoutProxyDial
 	TimeoutTrafficManagerAPI
 	TimeoutTrafficManagerConnect
+	TimeoutDaemonDial
 )
 
+// TimeoutsEnv is the environment variable that overrides the configured timeouts of the process and
+// of the daemons that it launches. Its value is a comma separated list of <name>=<duration>, where
+// the name is a key of the timeouts config, e.g. "intercept=30s,agentInstall=5m".
+const TimeoutsEnv = "TELEPRESENCE_TIMEOUTS"
+
 type timeoutContext struct {
 	context.Context
 	timeoutID  TimeoutID
@@ -162,6 +279,8 @@ func (cfg Timeouts) TimeoutContext(ctx context.Context, timeoutID TimeoutID) (co
 		timeoutVal = cfg.PrivateTrafficManagerAPI
 	case TimeoutTrafficManagerConnect:
 		timeoutVal = cfg.PrivateTrafficManagerConnect
+	case TimeoutDaemonDial:
+		timeoutVal = cfg.PrivateDaemonDial
 	default:
 		panic("should not happen")
 	}
@@ -205,11 +324,14 @@ func (e timeoutErr) Error() string {
 	case TimeoutTrafficManagerConnect:
 		yamlName = "trafficManagerConnect"
 		humanName = "port-forward connection to the traffic manager"
+	case TimeoutDaemonDial:
+		yamlName = "daemonDial"
+		humanName = "connection to the daemon"
 	default:
 		panic("should not happen")
 	}
-	return fmt.Sprintf("the %s timed out.  The current timeout %s can be configured as %q in %q",
-		humanName, e.timeoutVal, "timeouts."+yamlName, e.configFile)
+	return fmt.Sprintf("the %s timed out.  The current timeout %s can be configured as %q in %q, or for a single command using --timeout %s=<duration>",
+		humanName, e.timeoutVal, "timeouts."+yamlName, e.configFile, yamlName)
 }
 
 func (e timeoutErr) Unwrap() error {
@@ -235,23 +357,8 @@ func (d *Timeouts) UnmarshalYAML(node *yaml.Node) (err error) {
 		if err != nil {
 			return err
 		}
-		var dp *time.Duration
-		switch kv {
-		case "agentInstall":
-			dp = &d.PrivateAgentInstall
-		case "apply":
-			dp = &d.PrivateApply
-		case "clusterConnect":
-			dp = &d.PrivateClusterConnect
-		case "intercept":
-			dp = &d.PrivateIntercept
-		case "proxyDial":
-			dp = &d.PrivateProxyDial
-		case "trafficManagerAPI":
-			dp = &d.PrivateTrafficManagerAPI
-		case "trafficManagerConnect":
-			dp = &d.PrivateTrafficManagerConnect
-		default:
+		dp := d.byName(kv)
+		if dp == nil {
 			if parseContext != nil {
 				dlog.Warn(parseContext, withLoc(fmt.Sprintf("unknown key %q", kv), ms[i]))
 			}
@@ -272,11 +379,96 @@ func (d *Timeouts) UnmarshalYAML(node *yaml.Node) (err error) {
 			if *dp, err = time.ParseDuration(vv); err != nil {
 				return errors.New(withLoc(fmt.Sprintf("%q is not a valid duration", vv), v))
 			}
+		default:
+			if parseContext != nil {
+				dlog.Warn(parseContext, withLoc(fmt.Sprintf("duration expected for key %q", kv), v))
+			}
+		}
+	}
+	return nil
+}
+
+// byName returns the timeout with the given key in the timeouts config, or nil if no such timeout
+// exists.
+func (d *Timeouts) byName(name string) *time.Duration {
+	switch name {
+	case "agentInstall":
+		return &d.PrivateAgentInstall
+	case "apply":
+		return &d.PrivateApply
+	case "clusterConnect":
+		return &d.PrivateClusterConnect
+	case "intercept":
+		return &d.PrivateIntercept
+	case "proxyDial":
+		return &d.PrivateProxyDial
+	case "trafficManagerAPI":
+		return &d.PrivateTrafficManagerAPI
+	case "trafficManagerConnect":
+		return &d.PrivateTrafficManagerConnect
+	case "daemonDial":
+		return &d.PrivateDaemonDial
+	default:
+		return nil
+	}
+}
+
+// ParseTimeoutOverride parses a <name>=<duration> override of a timeout, as used in the value of
+// the TimeoutsEnv environment variable.
+func ParseTimeoutOverride(override string) (string, time.Duration, error) {
+	eq := strings.IndexByte(override, '=')
+	if eq < 0 {
+		return "", 0, fmt.Errorf("%q is not <name>=<duration>", override)
+	}
+	name := override[:eq]
+	if (&Timeouts{}).byName(name) == nil {
+		return "", 0, fmt.Errorf("%q is not the name of a timeout", name)
+	}
+	d, err := time.ParseDuration(override[eq+1:])
+	if err != nil || d <= 0 {
+		return "", 0, fmt.Errorf("%q is not a valid duration", override[eq+1:])
+	}
+	return name, d, nil
+}
+
+// applyOverrides applies the overrides in the given value of the TimeoutsEnv environment
+// variable. Invalid overrides are logged and ignored.
+func (d *Timeouts) applyOverrides(c context.Context, env string) {
+	if env == "" {
+		return
+	}
+	for _, override := range strings.Split(env, ",") {
+		if err := d.ApplyOverrides([]string{strings.TrimSpace(override)}); err != nil {
+			dlog.Warnf(c, "%s: %v", TimeoutsEnv, err)
+		}
+	}
+}
+
+// ApplyOverrides applies the given <name>=<duration> overrides, e.g. the ones of the --timeout flag,
+// and remembers them, so that they can be passed on to the daemons that this process launches. No
+// override is applied unless all of them are valid.
+func (d *Timeouts) ApplyOverrides(overrides []string) error {
+	names := make([]string, len(overrides))
+	vals := make([]time.Duration, len(overrides))
+	for i, override := range overrides {
+		var err error
+		if names[i], vals[i], err = ParseTimeoutOverride(override); err != nil {
+			return err
 		}
 	}
+	for i, name := range names {
+		*d.byName(name) = vals[i]
+	}
+	d.overrides = append(d.overrides, overrides...)
 	return nil
 }
 
+// Overrides returns the overrides that have been applied to these timeouts, in the order that
+// they were applied.
+func (d *Timeouts) Overrides() []string {
+	return d.overrides
+}
+
 // merge merges this instance with the non-zero values of the given argument. The argument values take priority.
 func (d *Timeouts) merge(o *Timeouts) {
 	if o.PrivateAgentInstall != 0 {
@@ -300,11 +492,22 @@ func (d *Timeouts) merge(o *Timeouts) {
 	if o.PrivateTrafficManagerConnect != 0 {
 		d.PrivateTrafficManagerConnect = o.PrivateTrafficManagerConnect
 	}
+	if o.PrivateDaemonDial != 0 {
+		d.PrivateDaemonDial = o.PrivateDaemonDial
+	}
 }
 
 type LogLevels struct {
 	UserDaemon logrus.Level `json:"userDaemon,omitempty"`
 	RootDaemon logrus.Level `json:"rootDaemon,omitempty"`
+
+	// SystemLog is the level of the entries that the daemons also send to the logging facility of
+	// the OS, i.e. the Windows Event Log or the macOS unified log. Their lifecycle events are always
+	// sent.
+	SystemLog logrus.Level `json:"systemLog,omitempty"`
+
+	// Subsystems are level overrides for the subsystems of the daemons, keyed by subsystem name
+	Subsystems map[string]logrus.Level `json:"subsystems,omitempty"`
 }
 
 // UnmarshalYAML parses the logrus log-levels
@@ -321,6 +524,12 @@ func (ll *LogLevels) UnmarshalYAML(node *yaml.Node) (err error) {
 			return err
 		}
 		v := ms[i+1]
+		if kv == "subsystems" {
+			if err := ll.unmarshalSubsystems(v); err != nil {
+				return err
+			}
+			continue
+		}
 		level, err := logrus.ParseLevel(v.Value)
 		if err != nil {
 			return errors.New(withLoc("invalid log-level", v))
@@ -330,6 +539,8 @@ func (ll *LogLevels) UnmarshalYAML(node *yaml.Node) (err error) {
 			ll.UserDaemon = level
 		case "rootDaemon":
 			ll.RootDaemon = level
+		case "systemLog":
+			ll.SystemLog = level
 		default:
 			if parseContext != nil {
 				dlog.Warn(parseContext, withLoc(fmt.Sprintf("unknown key %q", kv), ms[i]))
@@ -339,6 +550,33 @@ func (ll *LogLevels) UnmarshalYAML(node *yaml.Node) (err error) {
 	return nil
 }
 
+func (ll *LogLevels) unmarshalSubsystems(node *yaml.Node) error {
+	if node.Kind != yaml.MappingNode {
+		return errors.New(withLoc("subsystems must be an object", node))
+	}
+	ms := node.Content
+	top := len(ms)
+	ll.Subsystems = make(map[string]logrus.Level, top/2)
+	for i := 0; i < top; i += 2 {
+		kv, err := stringKey(ms[i])
+		if err != nil {
+			return err
+		}
+		if !loglevel.IsSubsystem(kv) {
+			if parseContext != nil {
+				dlog.Warn(parseContext, withLoc(fmt.Sprintf("unknown subsystem %q", kv), ms[i]))
+			}
+			continue
+		}
+		level, err := logrus.ParseLevel(ms[i+1].Value)
+		if err != nil {
+			return errors.New(withLoc("invalid log-level", ms[i+1]))
+		}
+		ll.Subsystems[kv] = level
+	}
+	return nil
+}
+
 func (ll *LogLevels) merge(o *LogLevels) {
 	if o.UserDaemon != 0 {
 		ll.UserDaemon = o.UserDaemon
@@ -346,6 +584,95 @@ func (ll *LogLevels) merge(o *LogLevels) {
 	if o.RootDaemon != 0 {
 		ll.RootDaemon = o.RootDaemon
 	}
+	if o.SystemLog != 0 {
+		ll.SystemLog = o.SystemLog
+	}
+	if len(o.Subsystems) > 0 {
+		ll.Subsystems = o.Subsystems
+	}
+}
+
+// LogRotation controls when the log files of the daemons are rotated and how many of the rotated
+// files that are retained.
+type LogRotation struct {
+	// MaxSize is the size in bytes that a log file may grow to before it is rotated. Zero means no limit.
+	MaxSize int64 `json:"maxSize,omitempty"`
+
+	// MaxAge is the age that a log file may reach before it is rotated. Zero means no limit.
+	MaxAge time.Duration `json:"maxAge,omitempty"`
+
+	// MaxFiles is the maximum number of files to retain for each log, including the current file.
+	MaxFiles uint16 `json:"maxFiles,omitempty"`
+
+	// Compress enables gzip compression of rotated files
+	Compress bool `json:"compress,omitempty"`
+}
+
+// UnmarshalYAML parses the log rotation YAML
+func (lr *LogRotation) UnmarshalYAML(node *yaml.Node) (err error) {
+	if node.Kind != yaml.MappingNode {
+		return errors.New(withLoc("logRotation must be an object", node))
+	}
+
+	ms := node.Content
+	top := len(ms)
+	for i := 0; i < top; i += 2 {
+		kv, err := stringKey(ms[i])
+		if err != nil {
+			return err
+		}
+		v := ms[i+1]
+		switch kv {
+		case "maxSize":
+			q, err := resource.ParseQuantity(v.Value)
+			if err != nil || q.Sign() < 0 {
+				dlog.Warn(parseContext, withLoc(fmt.Sprintf("size expected for key %q", kv), ms[i]))
+			} else {
+				lr.MaxSize = q.Value()
+			}
+		case "maxAge":
+			d, err := time.ParseDuration(v.Value)
+			if err != nil || d < 0 {
+				dlog.Warn(parseContext, withLoc(fmt.Sprintf("duration expected for key %q", kv), ms[i]))
+			} else {
+				lr.MaxAge = d
+			}
+		case "maxFiles":
+			n, err := strconv.ParseUint(v.Value, 10, 16)
+			if err != nil || n == 0 {
+				dlog.Warn(parseContext, withLoc(fmt.Sprintf("positive integer expected for key %q", kv), ms[i]))
+			} else {
+				lr.MaxFiles = uint16(n)
+			}
+		case "compress":
+			val, err := strconv.ParseBool(v.Value)
+			if err != nil {
+				dlog.Warn(parseContext, withLoc(fmt.Sprintf("bool expected for key %q", kv), ms[i]))
+			} else {
+				lr.Compress = val
+			}
+		default:
+			if parseContext != nil {
+				dlog.Warn(parseContext, withLoc(fmt.Sprintf("unknown key %q", kv), ms[i]))
+			}
+		}
+	}
+	return nil
+}
+
+func (lr *LogRotation) merge(o *LogRotation) {
+	if o.MaxSize != 0 {
+		lr.MaxSize = o.MaxSize
+	}
+	if o.MaxAge != 0 {
+		lr.MaxAge = o.MaxAge
+	}
+	if o.MaxFiles != 0 {
+		lr.MaxFiles = o.MaxFiles
+	}
+	if o.Compress {
+		lr.Compress = o.Compress
+	}
 }
 
 type Images struct {
@@ -353,6 +680,7 @@ type Images struct {
 	AgentImage        string `json:"agentImage,omitempty"`
 	WebhookRegistry   string `json:"webhookRegistry,omitempty"`
 	WebhookAgentImage string `json:"webhookAgentImage,omitempty"`
+	ClientImage       string `json:"clientImage,omitempty"`
 }
 
 // UnmarshalYAML parses the images YAML
@@ -378,6 +706,8 @@ func (img *Images) UnmarshalYAML(node *yaml.Node) (err error) {
 			img.WebhookRegistry = v.Value
 		case "webhookAgentImage":
 			img.WebhookAgentImage = v.Value
+		case "clientImage":
+			img.ClientImage = v.Value
 		default:
 			if parseContext != nil {
 				dlog.Warn(parseContext, withLoc(fmt.Sprintf("unknown key %q", kv), ms[i]))
@@ -400,6 +730,9 @@ func (i *Images) merge(o *Images) {
 	if o.WebhookRegistry != "" {
 		i.WebhookRegistry = o.WebhookRegistry
 	}
+	if o.ClientImage != "" {
+		i.ClientImage = o.ClientImage
+	}
 }
 
 type Cloud struct {
@@ -447,12 +780,124 @@ type Grpc struct {
 	// MaxReceiveSize is the maximum message size in bytes the client can receive in a gRPC call or stream message.
 	// Overrides the gRPC default of 4MB.
 	MaxReceiveSize *resource.Quantity `json:"maxReceiveSize,omitempty"`
+
+	// MaxSendSize is the maximum message size in bytes the client can send in a gRPC call or stream message.
+	// Overrides the gRPC default, which is unlimited.
+	MaxSendSize *resource.Quantity `json:"maxSendSize,omitempty"`
+
+	// InitialWindowSize is the flow control window that each stream from the traffic-manager starts
+	// with. Overrides the gRPC default of 64KiB. Values smaller than 64KiB are ignored.
+	InitialWindowSize *resource.Quantity `json:"initialWindowSize,omitempty"`
+
+	// InitialConnWindowSize is the flow control window that the connection to the traffic-manager
+	// starts with. Overrides the gRPC default of 64KiB. Values smaller than 64KiB are ignored.
+	InitialConnWindowSize *resource.Quantity `json:"initialConnWindowSize,omitempty"`
+
+	// WriteBufferSize is how many bytes that are written to the connection to the traffic-manager
+	// are buffered before they're flushed. Overrides the gRPC default of 32KiB.
+	WriteBufferSize *resource.Quantity `json:"writeBufferSize,omitempty"`
+
+	// TunnelCompression enables compression of the traffic between the client and the
+	// traffic-manager, provided that the traffic-manager supports it.
+	TunnelCompression bool `json:"tunnelCompression,omitempty"`
+
+	// KeepaliveInterval is how long the connection to the traffic-manager may stay idle before the
+	// connector pings it. Zero disables the pings. gRPC doesn't ping more often than every 10s.
+	KeepaliveInterval time.Duration `json:"keepaliveInterval,omitempty"`
+
+	// KeepaliveTimeout is how long the connector waits for the reply to a ping before it closes
+	// the connection and reconnects.
+	KeepaliveTimeout time.Duration `json:"keepaliveTimeout,omitempty"`
+
+	// SessionFailureThreshold is the number of consecutive failed attempts to keep the session
+	// with the traffic-manager alive after which the session is considered dead.
+	SessionFailureThreshold int `json:"sessionFailureThreshold,omitempty"`
 }
 
 func (g *Grpc) merge(o *Grpc) {
 	if o.MaxReceiveSize != nil {
 		g.MaxReceiveSize = o.MaxReceiveSize
 	}
+	if o.MaxSendSize != nil {
+		g.MaxSendSize = o.MaxSendSize
+	}
+	if o.InitialWindowSize != nil {
+		g.InitialWindowSize = o.InitialWindowSize
+	}
+	if o.InitialConnWindowSize != nil {
+		g.InitialConnWindowSize = o.InitialConnWindowSize
+	}
+	if o.WriteBufferSize != nil {
+		g.WriteBufferSize = o.WriteBufferSize
+	}
+	if o.TunnelCompression {
+		g.TunnelCompression = true
+	}
+	if o.KeepaliveInterval != 0 {
+		g.KeepaliveInterval = o.KeepaliveInterval
+	}
+	if o.KeepaliveTimeout != 0 {
+		g.KeepaliveTimeout = o.KeepaliveTimeout
+	}
+	if o.SessionFailureThreshold > 0 {
+		g.SessionFailureThreshold = o.SessionFailureThreshold
+	}
+}
+
+// DialOptions returns the dial options that apply the message size, flow control window, buffer,
+// and keepalive settings to a gRPC connection.
+func (g *Grpc) DialOptions() []grpc.DialOption {
+	var opts []grpc.DialOption
+	var callOpts []grpc.CallOption
+	if sz, ok := quantityInt64(g.MaxReceiveSize); ok {
+		callOpts = append(callOpts, grpc.MaxCallRecvMsgSize(int(sz)))
+	}
+	if sz, ok := quantityInt64(g.MaxSendSize); ok {
+		callOpts = append(callOpts, grpc.MaxCallSendMsgSize(int(sz)))
+	}
+	if len(callOpts) > 0 {
+		opts = append(opts, grpc.WithDefaultCallOptions(callOpts...))
+	}
+	if sz, ok := quantityInt64(g.InitialWindowSize); ok {
+		opts = append(opts, grpc.WithInitialWindowSize(int32(sz)))
+	}
+	if sz, ok := quantityInt64(g.InitialConnWindowSize); ok {
+		opts = append(opts, grpc.WithInitialConnWindowSize(int32(sz)))
+	}
+	if sz, ok := quantityInt64(g.WriteBufferSize); ok {
+		opts = append(opts, grpc.WithWriteBufferSize(int(sz)))
+	}
+	if g.KeepaliveInterval > 0 {
+		opts = append(opts, grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:                g.KeepaliveInterval,
+			Timeout:             g.KeepaliveTimeout,
+			PermitWithoutStream: true,
+		}))
+	}
+	return opts
+}
+
+// minKeepaliveInterval is the shortest interval between two pings that the gRPC servers of the
+// daemons accept. gRPC never pings more often than every 10s, so any keepaliveInterval is accepted.
+const minKeepaliveInterval = 5 * time.Second
+
+// KeepalivePolicy returns the server option that permits the pings that DialOptions configures. A
+// gRPC server closes the connections of clients that ping more often than every 5 minutes unless
+// it's told otherwise.
+func KeepalivePolicy() grpc.ServerOption {
+	return grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+		MinTime:             minKeepaliveInterval,
+		PermitWithoutStream: true,
+	})
+}
+
+// quantityInt64 returns the value of the given quantity. Quantities that don't fit in an int32 are
+// rejected with a warning when the config is parsed, so they never get here.
+func quantityInt64(q *resource.Quantity) (int64, bool) {
+	if q == nil {
+		return 0, false
+	}
+	return q.Value(), true
 }
 
 // UnmarshalYAML parses the images YAML
@@ -469,13 +914,48 @@ func (g *Grpc) UnmarshalYAML(node *yaml.Node) (err error) {
 			return err
 		}
 		v := ms[i+1]
-		switch kv {
-		case "maxReceiveSize":
+		quantity := func(dst **resource.Quantity) {
 			val, err := resource.ParseQuantity(v.Value)
 			if err != nil {
 				dlog.Warningf(parseContext, "unable to parse quantity %q: %v", v.Value, withLoc(err.Error(), ms[i]))
+				return
+			}
+			if sz, ok := val.AsInt64(); !ok || sz < 1 || sz > math.MaxInt32 {
+				dlog.Warningf(parseContext, "%s must be between 1 and %d bytes: %s", kv, math.MaxInt32, withLoc(v.Value, ms[i]))
+				return
+			}
+			*dst = &val
+		}
+		switch kv {
+		case "maxReceiveSize":
+			quantity(&g.MaxReceiveSize)
+		case "maxSendSize":
+			quantity(&g.MaxSendSize)
+		case "initialWindowSize":
+			quantity(&g.InitialWindowSize)
+		case "initialConnWindowSize":
+			quantity(&g.InitialConnWindowSize)
+		case "writeBufferSize":
+			quantity(&g.WriteBufferSize)
+		case "tunnelCompression":
+			if err := v.Decode(&g.TunnelCompression); err != nil {
+				dlog.Warningf(parseContext, "tunnelCompression must be a boolean: %s", withLoc(v.Value, ms[i]))
+			}
+		case "keepaliveInterval", "keepaliveTimeout":
+			d, err := time.ParseDuration(v.Value)
+			if err != nil || d < 0 {
+				dlog.Warn(parseContext, withLoc(fmt.Sprintf("duration expected for key %q", kv), ms[i]))
+			} else if kv == "keepaliveInterval" {
+				g.KeepaliveInterval = d
+			} else {
+				g.KeepaliveTimeout = d
+			}
+		case "sessionFailureThreshold":
+			var n int
+			if err := v.Decode(&n); err != nil || n < 1 {
+				dlog.Warn(parseContext, withLoc(fmt.Sprintf("positive integer expected for key %q", kv), ms[i]))
 			} else {
-				g.MaxReceiveSize = &val
+				g.SessionFailureThreshold = n
 			}
 		default:
 			if parseContext != nil {
@@ -486,52 +966,1106 @@ func (g *Grpc) UnmarshalYAML(node *yaml.Node) (err error) {
 	return nil
 }
 
-var defaultConfig = Config{
-	Timeouts: Timeouts{
-		PrivateAgentInstall:          120 * time.Second,
-		PrivateApply:                 1 * time.Minute,
-		PrivateClusterConnect:        20 * time.Second,
-		PrivateIntercept:             5 * time.Second,
-		PrivateProxyDial:             5 * time.Second,
-		PrivateTrafficManagerAPI:     15 * time.Second,
-		PrivateTrafficManagerConnect: 60 * time.Second,
-	},
-	LogLevels: LogLevels{
-		UserDaemon: logrus.DebugLevel,
-		RootDaemon: logrus.InfoLevel,
-	},
-	Images: Images{
-		Registry:          "docker.io/datawire",
-		WebhookRegistry:   "docker.io/datawire",
-		AgentImage:        "",
-		WebhookAgentImage: "",
-	},
-	Cloud: Cloud{
-		SkipLogin: false,
-	},
-	Grpc: Grpc{},
+type TLS struct {
+	// ManagerMTLS enables mutual TLS between the connector and the traffic-manager. The
+	// certificates are read from secrets in the traffic-manager namespace.
+	ManagerMTLS bool `json:"managerMTLS,omitempty"`
+
+	// MinVersion is the minimum TLS version, e.g. "1.2", used in the TLS connections of the client,
+	// such as those to the traffic-manager, to an HTTPS proxy, and to Ambassador Cloud.
+	MinVersion string `json:"minVersion,omitempty"`
+
+	// CipherSuites are the names of the TLS 1.2 cipher suites that may be used.
+	CipherSuites []string `json:"cipherSuites,omitempty"`
+
+	// FIPS restricts versions and cipher suites to those approved by FIPS 140-2. It also makes the
+	// traffic-manager enforce the same restrictions when it's installed by the client.
+	FIPS bool `json:"fips,omitempty"`
+
+	// ClientCert is the PEM encoded client certificate that is presented to a TLS manager endpoint,
+	// e.g. an ingress or gateway that enforces mutual TLS. It's either the path of a file, or a
+	// reference to an item in the keychain of the OS in the form "keychain:<name>".
+	ClientCert string `json:"clientCert,omitempty"`
+
+	// ClientKey is the PEM encoded private key of the ClientCert, in the same forms as the
+	// ClientCert. It may be omitted when the ClientCert contains the key.
+	ClientKey string `json:"clientKey,omitempty"`
+
+	// EndpointCA is the PEM encoded CA certificate that the certificate of a TLS manager endpoint
+	// is verified with, in the same forms as the ClientCert. The system's CA certificates are
+	// used when it's not set.
+	EndpointCA string `json:"endpointCA,omitempty"`
 }
 
-var config *Config
+func (t *TLS) merge(o *TLS) {
+	if o.ManagerMTLS {
+		t.ManagerMTLS = o.ManagerMTLS
+	}
+	if o.MinVersion != "" {
+		t.MinVersion = o.MinVersion
+	}
+	if len(o.CipherSuites) > 0 {
+		t.CipherSuites = o.CipherSuites
+	}
+	if o.FIPS {
+		t.FIPS = o.FIPS
+	}
+	if o.ClientCert != "" {
+		// The key belongs to the certificate, so a key from another file must not be kept
+		t.ClientCert = o.ClientCert
+		t.ClientKey = o.ClientKey
+	}
+	if o.EndpointCA != "" {
+		t.EndpointCA = o.EndpointCA
+	}
+}
 
-var configOnce = new(sync.Once)
+// Policy returns the TLS policy described by this configuration
+func (t *TLS) Policy() (*tlspolicy.Policy, error) {
+	return tlspolicy.Parse(t.MinVersion, t.CipherSuites, t.FIPS)
+}
 
-var parseContext context.Context
+// UnmarshalYAML parses the tls YAML
+func (t *TLS) UnmarshalYAML(node *yaml.Node) (err error) {
+	if node.Kind != yaml.MappingNode {
+		return errors.New(withLoc("tls must be an object", node))
+	}
 
-type parsedFile struct{}
+	ms := node.Content
+	top := len(ms)
+	for i := 0; i < top; i += 2 {
+		kv, err := stringKey(ms[i])
+		if err != nil {
+			return err
+		}
+		v := ms[i+1]
+		switch kv {
+		case "managerMTLS":
+			val, err := strconv.ParseBool(v.Value)
+			if err != nil {
+				dlog.Warn(parseContext, withLoc(fmt.Sprintf("bool expected for key %q", kv), ms[i]))
+			} else {
+				t.ManagerMTLS = val
+			}
+		case "minVersion":
+			t.MinVersion = v.Value
+		case "cipherSuites":
+			var suites []string
+			if err := v.Decode(&suites); err != nil {
+				dlog.Warn(parseContext, withLoc(fmt.Sprintf("list of strings expected for key %q", kv), ms[i]))
+			} else {
+				t.CipherSuites = suites
+			}
+		case "fips":
+			val, err := strconv.ParseBool(v.Value)
+			if err != nil {
+				dlog.Warn(parseContext, withLoc(fmt.Sprintf("bool expected for key %q", kv), ms[i]))
+			} else {
+				t.FIPS = val
+			}
+		case "clientCert":
+			t.ClientCert = v.Value
+		case "clientKey":
+			t.ClientKey = v.Value
+		case "endpointCA":
+			t.EndpointCA = v.Value
+		default:
+			if parseContext != nil {
+				dlog.Warn(parseContext, withLoc(fmt.Sprintf("unknown key %q", kv), ms[i]))
+			}
+		}
+	}
+	return nil
+}
+
+type Redact struct {
+	// EnvKeys are glob patterns, matched case-insensitively, for environment variable names
+	// whose values are secrets. They extend the built-in patterns.
+	EnvKeys []string `json:"envKeys,omitempty"`
+
+	// Patterns are regular expressions that match secrets in log output. They extend the
+	// built-in patterns.
+	Patterns []string `json:"patterns,omitempty"`
+
+	// EnvFile enables masking of secret values in files written using --env-file and --env-json.
+	EnvFile bool `json:"envFile,omitempty"`
+}
+
+func (r *Redact) merge(o *Redact) {
+	if len(o.EnvKeys) > 0 {
+		r.EnvKeys = o.EnvKeys
+	}
+	if len(o.Patterns) > 0 {
+		r.Patterns = o.Patterns
+	}
+	if o.EnvFile {
+		r.EnvFile = o.EnvFile
+	}
+}
+
+// Redactor returns a redactor that uses the built-in rules extended by this configuration
+func (r *Redact) Redactor() (*redact.Redactor, error) {
+	return redact.New(r.EnvKeys, r.Patterns)
+}
+
+// UnmarshalYAML parses the redact YAML
+func (r *Redact) UnmarshalYAML(node *yaml.Node) (err error) {
+	if node.Kind != yaml.MappingNode {
+		return errors.New(withLoc("redact must be an object", node))
+	}
+
+	ms := node.Content
+	top := len(ms)
+	for i := 0; i < top; i += 2 {
+		kv, err := stringKey(ms[i])
+		if err != nil {
+			return err
+		}
+		v := ms[i+1]
+		switch kv {
+		case "envKeys", "patterns":
+			var strs []string
+			if err := v.Decode(&strs); err != nil {
+				dlog.Warn(parseContext, withLoc(fmt.Sprintf("list of strings expected for key %q", kv), ms[i]))
+			} else if kv == "envKeys" {
+				r.EnvKeys = strs
+			} else {
+				r.Patterns = strs
+			}
+		case "envFile":
+			val, err := strconv.ParseBool(v.Value)
+			if err != nil {
+				dlog.Warn(parseContext, withLoc(fmt.Sprintf("bool expected for key %q", kv), ms[i]))
+			} else {
+				r.EnvFile = val
+			}
+		default:
+			if parseContext != nil {
+				dlog.Warn(parseContext, withLoc(fmt.Sprintf("unknown key %q", kv), ms[i]))
+			}
+		}
+	}
+	return nil
+}
+
+type Tracing struct {
+	// Endpoint is the base URL of an OpenTelemetry collector that accepts OTLP/HTTP, e.g.
+	// http://localhost:4318. Spans are always recorded locally, but only exported when this is set.
+	Endpoint string `json:"endpoint,omitempty"`
+}
+
+func (t *Tracing) merge(o *Tracing) {
+	if o.Endpoint != "" {
+		t.Endpoint = o.Endpoint
+	}
+}
+
+// UnmarshalYAML parses the tracing YAML
+func (t *Tracing) UnmarshalYAML(node *yaml.Node) (err error) {
+	if node.Kind != yaml.MappingNode {
+		return errors.New(withLoc("tracing must be an object", node))
+	}
+
+	ms := node.Content
+	top := len(ms)
+	for i := 0; i < top; i += 2 {
+		kv, err := stringKey(ms[i])
+		if err != nil {
+			return err
+		}
+		switch kv {
+		case "endpoint":
+			t.Endpoint = ms[i+1].Value
+		default:
+			if parseContext != nil {
+				dlog.Warn(parseContext, withLoc(fmt.Sprintf("unknown key %q", kv), ms[i]))
+			}
+		}
+	}
+	return nil
+}
+
+// Metrics configures the Prometheus metrics endpoints of the daemons. An endpoint is only enabled
+// when its port is set, and it only listens on localhost.
+type Metrics struct {
+	UserDaemonPort int `json:"userDaemonPort,omitempty"`
+	RootDaemonPort int `json:"rootDaemonPort,omitempty"`
+}
+
+func (m *Metrics) merge(o *Metrics) {
+	if o.UserDaemonPort != 0 {
+		m.UserDaemonPort = o.UserDaemonPort
+	}
+	if o.RootDaemonPort != 0 {
+		m.RootDaemonPort = o.RootDaemonPort
+	}
+}
+
+// UnmarshalYAML parses the metrics YAML
+func (m *Metrics) UnmarshalYAML(node *yaml.Node) (err error) {
+	if node.Kind != yaml.MappingNode {
+		return errors.New(withLoc("metrics must be an object", node))
+	}
+
+	ms := node.Content
+	top := len(ms)
+	for i := 0; i < top; i += 2 {
+		kv, err := stringKey(ms[i])
+		if err != nil {
+			return err
+		}
+		v := ms[i+1]
+		switch kv {
+		case "userDaemonPort", "rootDaemonPort":
+			port, err := strconv.ParseUint(v.Value, 10, 16)
+			if err != nil {
+				dlog.Warn(parseContext, withLoc(fmt.Sprintf("port number expected for key %q", kv), ms[i]))
+			} else if kv == "userDaemonPort" {
+				m.UserDaemonPort = int(port)
+			} else {
+				m.RootDaemonPort = int(port)
+			}
+		default:
+			if parseContext != nil {
+				dlog.Warn(parseContext, withLoc(fmt.Sprintf("unknown key %q", kv), ms[i]))
+			}
+		}
+	}
+	return nil
+}
+
+// CrashReports configures what is done with the crash bundles that the daemons write when they
+// panic. The bundles are always written to the "crashes" directory in the logs directory, and the
+// next CLI command mentions them.
+type CrashReports struct {
+	// UploadURL is an HTTP(S) endpoint that new bundles are POSTed to by the next CLI command,
+	// unless that command is run with --no-report. Bundles are only kept locally when it's empty.
+	UploadURL string `json:"uploadURL,omitempty"`
+}
+
+func (c *CrashReports) merge(o *CrashReports) {
+	if o.UploadURL != "" {
+		c.UploadURL = o.UploadURL
+	}
+}
+
+// UnmarshalYAML parses the crashReports YAML
+func (c *CrashReports) UnmarshalYAML(node *yaml.Node) (err error) {
+	if node.Kind != yaml.MappingNode {
+		return errors.New(withLoc("crashReports must be an object", node))
+	}
+
+	ms := node.Content
+	top := len(ms)
+	for i := 0; i < top; i += 2 {
+		kv, err := stringKey(ms[i])
+		if err != nil {
+			return err
+		}
+		switch kv {
+		case "uploadURL":
+			c.UploadURL = ms[i+1].Value
+		default:
+			if parseContext != nil {
+				dlog.Warn(parseContext, withLoc(fmt.Sprintf("unknown key %q", kv), ms[i]))
+			}
+		}
+	}
+	return nil
+}
+
+// Cluster configures how the user daemon tracks the namespaces of the cluster, and how it talks to
+// the API server.
+type Cluster struct {
+	// LazyNamespaceThreshold is the number of mapped namespaces above which the DNS entries and
+	// workload caches of a namespace aren't created until the namespace is first referenced.
+	LazyNamespaceThreshold int `json:"lazyNamespaceThreshold,omitempty"`
+
+	// AgentInjection is either AgentInjectionWebhook or AgentInjectionPatch. With the latter, the
+	// traffic-manager is installed without its mutating webhook and the connector adds the
+	// traffic-agent to the workload when it's first intercepted, and removes it again when the
+	// last intercept of the workload ends.
+	AgentInjection string `json:"agentInjection,omitempty"`
+
+	// ClusterDomain is the DNS domain of the cluster. It's detected from the cluster DNS
+	// configuration when it isn't set, and is DefaultClusterDomain when that fails.
+	ClusterDomain string `json:"clusterDomain,omitempty"`
+
+	// QPS and Burst limit the rate of the requests that the user daemon sends to the API server.
+	// The client-go defaults apply when they are zero.
+	QPS   float32 `json:"qps,omitempty"`
+	Burst int     `json:"burst,omitempty"`
+
+	// ManagerNamespace is the namespace of the traffic-manager. The TELEPRESENCE_MANAGER_NAMESPACE
+	// environment variable and the manager extension of the kubeconfig take precedence. The
+	// traffic-manager is looked up by its labels when none of them is set, and is installed in
+	// DefaultManagerNamespace when it can't be found.
+	ManagerNamespace string `json:"managerNamespace,omitempty"`
+
+	// Labels are added to the resources that the user daemon creates when it installs the
+	// traffic-manager, e.g. to tell which team an installation belongs to.
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// DisruptionBudgets is DisruptionBudgetsWarn, DisruptionBudgetsRespect, or
+	// DisruptionBudgetsIgnore. It decides what's done when the rollout that adds the
+	// traffic-agent to a workload may make more of its pods unavailable than its
+	// PodDisruptionBudgets allow.
+	DisruptionBudgets string `json:"disruptionBudgets,omitempty"`
+
+	// PauseAutoscalers makes the HorizontalPodAutoscalers of a workload keep its number of replicas
+	// while the traffic-agent is rolled out, so that they don't scale the workload in the middle
+	// of the rollout.
+	PauseAutoscalers bool `json:"pauseAutoscalers,omitempty"`
+
+	// ManagerToken is the token that the connector presents to the traffic-manager to identify the
+	// user, instead of the Kubernetes bearer token of the kubeconfig. It's either the path of a file
+	// that holds the token, e.g. an OpenID Connect ID token that a login helper keeps fresh, or a
+	// reference to an item in the keychain of the OS in the form "keychain:<name>".
+	ManagerToken string `json:"managerToken,omitempty"`
+
+	// CallbackPorts are the ports on localhost that the cluster may connect to through the Service
+	// that the traffic-manager creates for the client when it's configured to. No port is exposed
+	// unless it's listed here. Every pod in the cluster can reach the ports that are exposed.
+	CallbackPorts []uint16 `json:"callbackPorts,omitempty"`
+}
+
+const (
+	AgentInjectionWebhook = "webhook"
+	AgentInjectionPatch   = "patch"
+)
+
+const (
+	// DisruptionBudgetsWarn logs a warning and rolls out the traffic-agent anyway
+	DisruptionBudgetsWarn = "warn"
+
+	// DisruptionBudgetsRespect limits the pods that a rolling update of a Deployment makes
+	// unavailable to what the budgets allow, and refuses to add the traffic-agent to other
+	// workloads
+	DisruptionBudgetsRespect = "respect"
+
+	// DisruptionBudgetsIgnore doesn't look at the budgets at all
+	DisruptionBudgetsIgnore = "ignore"
+)
+
+// DefaultClusterDomain is the DNS domain of a cluster unless it's configured otherwise
+const DefaultClusterDomain = "cluster.local"
+
+// DefaultManagerNamespace is the namespace of the traffic-manager unless it's configured otherwise
+const DefaultManagerNamespace = "ambassador"
+
+func (c *Cluster) merge(o *Cluster) {
+	if o.LazyNamespaceThreshold != 0 {
+		c.LazyNamespaceThreshold = o.LazyNamespaceThreshold
+	}
+	if o.AgentInjection != "" {
+		c.AgentInjection = o.AgentInjection
+	}
+	if o.ClusterDomain != "" {
+		c.ClusterDomain = o.ClusterDomain
+	}
+	if o.QPS != 0 {
+		c.QPS = o.QPS
+	}
+	if o.Burst != 0 {
+		c.Burst = o.Burst
+	}
+	if o.ManagerNamespace != "" {
+		c.ManagerNamespace = o.ManagerNamespace
+	}
+	if o.DisruptionBudgets != "" {
+		c.DisruptionBudgets = o.DisruptionBudgets
+	}
+	if o.PauseAutoscalers {
+		c.PauseAutoscalers = o.PauseAutoscalers
+	}
+	if o.ManagerToken != "" {
+		c.ManagerToken = o.ManagerToken
+	}
+	if len(o.CallbackPorts) > 0 {
+		c.CallbackPorts = o.CallbackPorts
+	}
+	if len(o.Labels) > 0 {
+		if c.Labels == nil {
+			c.Labels = make(map[string]string, len(o.Labels))
+		}
+		for k, v := range o.Labels {
+			c.Labels[k] = v
+		}
+	}
+}
+
+// UnmarshalYAML parses the cluster YAML
+func (c *Cluster) UnmarshalYAML(node *yaml.Node) (err error) {
+	if node.Kind != yaml.MappingNode {
+		return errors.New(withLoc("cluster must be an object", node))
+	}
+
+	ms := node.Content
+	top := len(ms)
+	for i := 0; i < top; i += 2 {
+		kv, err := stringKey(ms[i])
+		if err != nil {
+			return err
+		}
+		v := ms[i+1]
+		switch kv {
+		case "lazyNamespaceThreshold":
+			n, err := strconv.ParseUint(v.Value, 10, 31)
+			if err != nil || n == 0 {
+				dlog.Warn(parseContext, withLoc(fmt.Sprintf("positive integer expected for key %q", kv), ms[i]))
+			} else {
+				c.LazyNamespaceThreshold = int(n)
+			}
+		case "agentInjection":
+			switch v.Value {
+			case AgentInjectionWebhook, AgentInjectionPatch:
+				c.AgentInjection = v.Value
+			default:
+				dlog.Warn(parseContext, withLoc(fmt.Sprintf("%q or %q expected for key %q", AgentInjectionWebhook, AgentInjectionPatch, kv), ms[i]))
+			}
+		case "clusterDomain":
+			if domain := strings.Trim(v.Value, "."); domain == "" || strings.ContainsAny(domain, " /:") {
+				dlog.Warn(parseContext, withLoc(fmt.Sprintf("domain name expected for key %q", kv), ms[i]))
+			} else {
+				c.ClusterDomain = strings.ToLower(domain)
+			}
+		case "qps":
+			n, err := strconv.ParseFloat(v.Value, 32)
+			if err != nil || n <= 0 {
+				dlog.Warn(parseContext, withLoc(fmt.Sprintf("positive number expected for key %q", kv), ms[i]))
+			} else {
+				c.QPS = float32(n)
+			}
+		case "burst":
+			n, err := strconv.ParseUint(v.Value, 10, 31)
+			if err != nil || n == 0 {
+				dlog.Warn(parseContext, withLoc(fmt.Sprintf("positive integer expected for key %q", kv), ms[i]))
+			} else {
+				c.Burst = int(n)
+			}
+		case "managerNamespace":
+			if errs := validation.IsDNS1123Label(v.Value); len(errs) > 0 {
+				dlog.Warn(parseContext, withLoc(fmt.Sprintf("namespace name expected for key %q", kv), ms[i]))
+			} else {
+				c.ManagerNamespace = v.Value
+			}
+		case "labels":
+			var labels map[string]string
+			if err := v.Decode(&labels); err != nil {
+				dlog.Warn(parseContext, withLoc(fmt.Sprintf("map of labels expected for key %q", kv), ms[i]))
+				continue
+			}
+			for lk, lv := range labels {
+				if len(validation.IsQualifiedName(lk)) > 0 || len(validation.IsValidLabelValue(lv)) > 0 {
+					dlog.Warn(parseContext, withLoc(fmt.Sprintf("invalid label %s=%s in key %q", lk, lv, kv), ms[i]))
+					continue
+				}
+				if c.Labels == nil {
+					c.Labels = make(map[string]string)
+				}
+				c.Labels[lk] = lv
+			}
+		case "disruptionBudgets":
+			switch v.Value {
+			case DisruptionBudgetsWarn, DisruptionBudgetsRespect, DisruptionBudgetsIgnore:
+				c.DisruptionBudgets = v.Value
+			default:
+				dlog.Warn(parseContext, withLoc(fmt.Sprintf("%q, %q, or %q expected for key %q",
+					DisruptionBudgetsWarn, DisruptionBudgetsRespect, DisruptionBudgetsIgnore, kv), ms[i]))
+			}
+		case "managerToken":
+			c.ManagerToken = v.Value
+		case "pauseAutoscalers":
+			val, err := strconv.ParseBool(v.Value)
+			if err != nil {
+				dlog.Warn(parseContext, withLoc(fmt.Sprintf("bool expected for key %q", kv), ms[i]))
+			} else {
+				c.PauseAutoscalers = val
+			}
+		case "callbackPorts":
+			var ports []uint16
+			if err := v.Decode(&ports); err != nil {
+				dlog.Warn(parseContext, withLoc(fmt.Sprintf("list of ports expected for key %q", kv), ms[i]))
+			} else {
+				c.CallbackPorts = ports
+			}
+		default:
+			if parseContext != nil {
+				dlog.Warn(parseContext, withLoc(fmt.Sprintf("unknown key %q", kv), ms[i]))
+			}
+		}
+	}
+	return nil
+}
+
+// LocalAPI configures the HTTP API that the user daemon serves for IDE integrations. The API is only
+// enabled when its port is set, and it only listens on localhost.
+type LocalAPI struct {
+	Port int `json:"port,omitempty"`
+}
+
+func (l *LocalAPI) merge(o *LocalAPI) {
+	if o.Port != 0 {
+		l.Port = o.Port
+	}
+}
+
+// UnmarshalYAML parses the localAPI YAML
+func (l *LocalAPI) UnmarshalYAML(node *yaml.Node) (err error) {
+	if node.Kind != yaml.MappingNode {
+		return errors.New(withLoc("localAPI must be an object", node))
+	}
+
+	ms := node.Content
+	top := len(ms)
+	for i := 0; i < top; i += 2 {
+		kv, err := stringKey(ms[i])
+		if err != nil {
+			return err
+		}
+		switch kv {
+		case "port":
+			port, err := strconv.ParseUint(ms[i+1].Value, 10, 16)
+			if err != nil {
+				dlog.Warn(parseContext, withLoc(fmt.Sprintf("port number expected for key %q", kv), ms[i]))
+			} else {
+				l.Port = int(port)
+			}
+		default:
+			if parseContext != nil {
+				dlog.Warn(parseContext, withLoc(fmt.Sprintf("unknown key %q", kv), ms[i]))
+			}
+		}
+	}
+	return nil
+}
+
+// Intercept configures the defaults of the flags of the intercept command.
+type Intercept struct {
+	// DefaultMount is the default of --mount, i.e. "true", "false", or a mount point
+	DefaultMount string `json:"defaultMount,omitempty"`
+
+	// DefaultHTTPMatch is the default of --http-match
+	DefaultHTTPMatch []string `json:"defaultHTTPMatch,omitempty"`
+
+	// EnvFileDir is a directory where the environment of an intercept is written to a file
+	// named <intercept name>.env unless --env-file is given
+	EnvFileDir string `json:"envFileDir,omitempty"`
+
+	// LocalAddress is the address that intercepted traffic is sent to, instead of 127.0.0.1
+	LocalAddress string `json:"localAddress,omitempty"`
+
+	// ToPod is the default of --to-pod
+	ToPod []uint16 `json:"toPod,omitempty"`
+
+	// DebugEnv are environment variables that are added when --debug-port is given, in addition
+	// to, or instead of, the built in ones. The values are templates where {{.Host}} and {{.Port}}
+	// is the address that the debugger attaches to. An empty value removes a built in variable.
+	DebugEnv map[string]string `json:"debugEnv,omitempty"`
+
+	// Env transforms the environment of the intercepted container before it's written to files
+	// or given to the command that the intercept runs.
+	Env EnvTransform `json:"env,omitempty"`
+}
+
+func (ic *Intercept) merge(o *Intercept) {
+	if o.DefaultMount != "" {
+		ic.DefaultMount = o.DefaultMount
+	}
+	if len(o.DefaultHTTPMatch) > 0 {
+		ic.DefaultHTTPMatch = o.DefaultHTTPMatch
+	}
+	if o.EnvFileDir != "" {
+		ic.EnvFileDir = o.EnvFileDir
+	}
+	if o.LocalAddress != "" {
+		ic.LocalAddress = o.LocalAddress
+	}
+	if len(o.ToPod) > 0 {
+		ic.ToPod = o.ToPod
+	}
+	for k, v := range o.DebugEnv {
+		if ic.DebugEnv == nil {
+			ic.DebugEnv = make(map[string]string)
+		}
+		ic.DebugEnv[k] = v
+	}
+	ic.Env.merge(&o.Env)
+}
+
+// UnmarshalYAML parses the intercept YAML
+func (ic *Intercept) UnmarshalYAML(node *yaml.Node) (err error) {
+	if node.Kind != yaml.MappingNode {
+		return errors.New(withLoc("intercept must be an object", node))
+	}
+
+	ms := node.Content
+	top := len(ms)
+	for i := 0; i < top; i += 2 {
+		kv, err := stringKey(ms[i])
+		if err != nil {
+			return err
+		}
+		v := ms[i+1]
+		switch kv {
+		case "defaultMount":
+			ic.DefaultMount = v.Value
+		case "defaultHTTPMatch":
+			var matches []string
+			if err := v.Decode(&matches); err != nil {
+				dlog.Warn(parseContext, withLoc(fmt.Sprintf("list of strings expected for key %q", kv), ms[i]))
+			} else {
+				ic.DefaultHTTPMatch = matches
+			}
+		case "envFileDir":
+			ic.EnvFileDir = v.Value
+		case "localAddress":
+			if net.ParseIP(v.Value) == nil {
+				dlog.Warn(parseContext, withLoc(fmt.Sprintf("IP address expected for key %q", kv), ms[i]))
+			} else {
+				ic.LocalAddress = v.Value
+			}
+		case "toPod":
+			var ports []uint16
+			if err := v.Decode(&ports); err != nil {
+				dlog.Warn(parseContext, withLoc(fmt.Sprintf("list of port numbers expected for key %q", kv), ms[i]))
+			} else {
+				ic.ToPod = ports
+			}
+		case "debugEnv":
+			var env map[string]string
+			if err := v.Decode(&env); err != nil {
+				dlog.Warn(parseContext, withLoc(fmt.Sprintf("map of strings expected for key %q", kv), ms[i]))
+			} else {
+				for k, tpl := range env {
+					if _, err := template.New(k).Parse(tpl); err != nil {
+						dlog.Warn(parseContext, withLoc(fmt.Sprintf("invalid template for %q: %v", k, err), v))
+						delete(env, k)
+					}
+				}
+				ic.DebugEnv = env
+			}
+		case "env":
+			err := v.Decode(&ic.Env)
+			if err != nil {
+				return err
+			}
+		default:
+			if parseContext != nil {
+				dlog.Warn(parseContext, withLoc(fmt.Sprintf("unknown key %q", kv), ms[i]))
+			}
+		}
+	}
+	return nil
+}
+
+// EnvTransform configures how the environment of an intercepted container is transformed. Local
+// processes often need a slightly different environment than the pod, e.g. because a service that
+// the pod reaches using its cluster DNS name is forwarded to a port on localhost.
+type EnvTransform struct {
+	// Drop are glob patterns, e.g. "KUBERNETES_*", of the names of variables that are removed
+	Drop []string `json:"drop,omitempty"`
+
+	// Rename maps the names of variables to new names
+	Rename map[string]string `json:"rename,omitempty"`
+
+	// Hosts maps a "host" or "host:port" in the values of variables to a replacement, e.g.
+	// "redis.prod.svc.cluster.local:6379" to "localhost:6380". A host may start with "*." to
+	// match all hosts with the given domain. The port is kept when the key has no port. A host
+	// that ends with ".svc" also matches its fully qualified name in the domain of the cluster.
+	Hosts map[string]string `json:"hosts,omitempty"`
+
+	// Set are variables that are added, or replaced, after the other transforms. The values are
+	// templates that are executed with the transformed environment, e.g. "{{.DB_HOST}}:5432".
+	Set map[string]string `json:"set,omitempty"`
+}
+
+// IsZero returns true if the transform doesn't change anything
+func (et *EnvTransform) IsZero() bool {
+	return len(et.Drop) == 0 && len(et.Rename) == 0 && len(et.Hosts) == 0 && len(et.Set) == 0
+}
+
+func (et *EnvTransform) merge(o *EnvTransform) {
+	if len(o.Drop) > 0 {
+		et.Drop = o.Drop
+	}
+	et.Rename = mergeStringMaps(et.Rename, o.Rename)
+	et.Hosts = mergeStringMaps(et.Hosts, o.Hosts)
+	et.Set = mergeStringMaps(et.Set, o.Set)
+}
+
+func mergeStringMaps(m, o map[string]string) map[string]string {
+	for k, v := range o {
+		if m == nil {
+			m = make(map[string]string, len(o))
+		}
+		m[k] = v
+	}
+	return m
+}
+
+// UnmarshalYAML parses the env transform YAML
+func (et *EnvTransform) UnmarshalYAML(node *yaml.Node) (err error) {
+	if node.Kind != yaml.MappingNode {
+		return errors.New(withLoc("env must be an object", node))
+	}
+
+	ms := node.Content
+	top := len(ms)
+	for i := 0; i < top; i += 2 {
+		kv, err := stringKey(ms[i])
+		if err != nil {
+			return err
+		}
+		v := ms[i+1]
+		switch kv {
+		case "drop":
+			var patterns []string
+			if err := v.Decode(&patterns); err != nil {
+				dlog.Warn(parseContext, withLoc(fmt.Sprintf("list of strings expected for key %q", kv), ms[i]))
+				continue
+			}
+			et.Drop = patterns[:0]
+			for _, p := range patterns {
+				if _, err := path.Match(p, ""); err != nil {
+					dlog.Warn(parseContext, withLoc(fmt.Sprintf("invalid pattern %q: %v", p, err), v))
+				} else {
+					et.Drop = append(et.Drop, p)
+				}
+			}
+		case "rename", "hosts":
+			var m map[string]string
+			if err := v.Decode(&m); err != nil {
+				dlog.Warn(parseContext, withLoc(fmt.Sprintf("map of strings expected for key %q", kv), ms[i]))
+			} else if kv == "rename" {
+				et.Rename = m
+			} else {
+				et.Hosts = m
+			}
+		case "set":
+			var env map[string]string
+			if err := v.Decode(&env); err != nil {
+				dlog.Warn(parseContext, withLoc(fmt.Sprintf("map of strings expected for key %q", kv), ms[i]))
+			} else {
+				for k, tpl := range env {
+					if _, err := template.New(k).Parse(tpl); err != nil {
+						dlog.Warn(parseContext, withLoc(fmt.Sprintf("invalid template for %q: %v", k, err), v))
+						delete(env, k)
+					}
+				}
+				et.Set = env
+			}
+		default:
+			if parseContext != nil {
+				dlog.Warn(parseContext, withLoc(fmt.Sprintf("unknown key %q", kv), ms[i]))
+			}
+		}
+	}
+	return nil
+}
+
+// Routing configures how the root daemon routes outbound traffic to the cluster.
+type Routing struct {
+	// ProcessScoped restricts the routing of the cluster subnets to the processes that are started
+	// with "telepresence run". All other traffic of the workstation bypasses the cluster. Only
+	// supported on Linux when the root daemon runs as root.
+	ProcessScoped bool `json:"processScoped,omitempty"`
+}
+
+func (r *Routing) merge(o *Routing) {
+	if o.ProcessScoped {
+		r.ProcessScoped = o.ProcessScoped
+	}
+}
+
+// UnmarshalYAML parses the routing YAML
+func (r *Routing) UnmarshalYAML(node *yaml.Node) (err error) {
+	if node.Kind != yaml.MappingNode {
+		return errors.New(withLoc("routing must be an object", node))
+	}
+
+	ms := node.Content
+	top := len(ms)
+	for i := 0; i < top; i += 2 {
+		kv, err := stringKey(ms[i])
+		if err != nil {
+			return err
+		}
+		v := ms[i+1]
+		switch kv {
+		case "processScoped":
+			val, err := strconv.ParseBool(v.Value)
+			if err != nil {
+				dlog.Warn(parseContext, withLoc(fmt.Sprintf("bool expected for key %q", kv), ms[i]))
+			} else {
+				r.ProcessScoped = val
+			}
+		default:
+			if parseContext != nil {
+				dlog.Warn(parseContext, withLoc(fmt.Sprintf("unknown key %q", kv), ms[i]))
+			}
+		}
+	}
+	return nil
+}
+
+// Values of dns.addressOrder
+const (
+	// AddressOrderAuto prefers the address family of the subnets that are routed to the cluster,
+	// and IPv4 when subnets of both families are routed
+	AddressOrderAuto = "auto"
+
+	// AddressOrderIPv4First answers AAAA queries for names that have IPv4 addresses with no
+	// addresses, so that clients don't try IPv6 first
+	AddressOrderIPv4First = "ipv4first"
+
+	// AddressOrderIPv6First answers A queries for names that have IPv6 addresses with no addresses
+	AddressOrderIPv6First = "ipv6first"
+
+	// AddressOrderBoth answers A and AAAA queries with all the addresses of their family, and
+	// leaves the choice to the client
+	AddressOrderBoth = "both"
+)
+
+// DNS configures the answers that the DNS server of the root daemon gives for the names of the
+// cluster.
+type DNS struct {
+	// TTL is the time to live of the answers
+	TTL time.Duration `json:"ttl,omitempty"`
+
+	// AddressOrder determines which address family is answered for names that have addresses
+	// of both families. One of the AddressOrder constants.
+	AddressOrder string `json:"addressOrder,omitempty"`
+}
+
+func (d *DNS) merge(o *DNS) {
+	if o.TTL != 0 {
+		d.TTL = o.TTL
+	}
+	if o.AddressOrder != "" {
+		d.AddressOrder = o.AddressOrder
+	}
+}
+
+// UnmarshalYAML parses the dns YAML
+func (d *DNS) UnmarshalYAML(node *yaml.Node) (err error) {
+	if node.Kind != yaml.MappingNode {
+		return errors.New(withLoc("dns must be an object", node))
+	}
+
+	ms := node.Content
+	top := len(ms)
+	for i := 0; i < top; i += 2 {
+		kv, err := stringKey(ms[i])
+		if err != nil {
+			return err
+		}
+		v := ms[i+1]
+		switch kv {
+		case "ttl":
+			ttl, err := time.ParseDuration(v.Value)
+			if err != nil || ttl < time.Second {
+				dlog.Warn(parseContext, withLoc(fmt.Sprintf("duration of at least one second expected for key %q", kv), ms[i]))
+			} else {
+				d.TTL = ttl
+			}
+		case "addressOrder":
+			switch v.Value {
+			case AddressOrderAuto, AddressOrderIPv4First, AddressOrderIPv6First, AddressOrderBoth:
+				d.AddressOrder = v.Value
+			default:
+				dlog.Warn(parseContext, withLoc(fmt.Sprintf("invalid address order %q, expected %q, %q, %q, or %q",
+					v.Value, AddressOrderAuto, AddressOrderIPv4First, AddressOrderIPv6First, AddressOrderBoth), v))
+			}
+		default:
+			if parseContext != nil {
+				dlog.Warn(parseContext, withLoc(fmt.Sprintf("unknown key %q", kv), ms[i]))
+			}
+		}
+	}
+	return nil
+}
+
+// Reconnect limits how often the connector re-establishes the streams to the traffic-manager and the
+// watches of the API server that fail, so that a flapping network doesn't make it reconnect
+// hundreds of times per minute.
+type Reconnect struct {
+	// MaxPerMinute is the number of reconnects to one server within a minute after which all
+	// reconnects to that server are held back for the Cooldown.
+	MaxPerMinute int `json:"maxPerMinute,omitempty"`
+
+	// Cooldown is how long the reconnects are held back.
+	Cooldown time.Duration `json:"cooldown,omitempty"`
+}
+
+func (r *Reconnect) merge(o *Reconnect) {
+	if o.MaxPerMinute > 0 {
+		r.MaxPerMinute = o.MaxPerMinute
+	}
+	if o.Cooldown != 0 {
+		r.Cooldown = o.Cooldown
+	}
+}
+
+// UnmarshalYAML parses the reconnect YAML
+func (r *Reconnect) UnmarshalYAML(node *yaml.Node) (err error) {
+	if node.Kind != yaml.MappingNode {
+		return errors.New(withLoc("reconnect must be an object", node))
+	}
+
+	ms := node.Content
+	top := len(ms)
+	for i := 0; i < top; i += 2 {
+		kv, err := stringKey(ms[i])
+		if err != nil {
+			return err
+		}
+		v := ms[i+1]
+		switch kv {
+		case "maxPerMinute":
+			var n int
+			if err := v.Decode(&n); err != nil || n < 1 {
+				dlog.Warn(parseContext, withLoc(fmt.Sprintf("positive integer expected for key %q", kv), ms[i]))
+			} else {
+				r.MaxPerMinute = n
+			}
+		case "cooldown":
+			d, err := time.ParseDuration(v.Value)
+			if err != nil || d < time.Second {
+				dlog.Warn(parseContext, withLoc(fmt.Sprintf("duration of at least one second expected for key %q", kv), ms[i]))
+			} else {
+				r.Cooldown = d
+			}
+		default:
+			if parseContext != nil {
+				dlog.Warn(parseContext, withLoc(fmt.Sprintf("unknown key %q", kv), ms[i]))
+			}
+		}
+	}
+	return nil
+}
+
+var defaultConfig = Config{
+	Timeouts: Timeouts{
+		PrivateAgentInstall:          120 * time.Second,
+		PrivateApply:                 1 * time.Minute,
+		PrivateClusterConnect:        20 * time.Second,
+		PrivateIntercept:             5 * time.Second,
+		PrivateProxyDial:             5 * time.Second,
+		PrivateTrafficManagerAPI:     15 * time.Second,
+		PrivateTrafficManagerConnect: 60 * time.Second,
+		PrivateDaemonDial:            5 * time.Second,
+	},
+	LogLevels: LogLevels{
+		UserDaemon: logrus.DebugLevel,
+		RootDaemon: logrus.InfoLevel,
+		SystemLog:  logrus.ErrorLevel,
+	},
+	LogRotation: LogRotation{
+		MaxSize:  50 * 1024 * 1024,
+		MaxFiles: 5,
+	},
+	Images: Images{
+		Registry:          "docker.io/datawire",
+		WebhookRegistry:   "docker.io/datawire",
+		AgentImage:        "",
+		WebhookAgentImage: "",
+		ClientImage:       "",
+	},
+	Cloud: Cloud{
+		SkipLogin: false,
+	},
+	Grpc: Grpc{
+		KeepaliveInterval:       15 * time.Second,
+		KeepaliveTimeout:        10 * time.Second,
+		SessionFailureThreshold: 3,
+	},
+	TLS:          TLS{},
+	Redact:       Redact{},
+	Tracing:      Tracing{},
+	Metrics:      Metrics{},
+	CrashReports: CrashReports{},
+	Cluster:      Cluster{LazyNamespaceThreshold: 100, AgentInjection: AgentInjectionWebhook},
+	DNS:          DNS{TTL: 60 * time.Second, AddressOrder: AddressOrderAuto},
+	Reconnect:    Reconnect{MaxPerMinute: 60, Cooldown: 30 * time.Second},
+}
+
+var config *Config
+
+var configOnce = new(sync.Once)
+
+var parseContext context.Context
+
+type parsedFile struct{}
 
 func withLoc(s string, n *yaml.Node) string {
 	if parseContext != nil {
 		if fileName, ok := parseContext.Value(parsedFile{}).(string); ok {
-			return fmt.Sprintf("file %s, line %d: %s", fileName, n.Line, s)
+			return fmt.Sprintf("file %s, line %d, column %d: %s", fileName, n.Line, n.Column, s)
+		}
+	}
+	return fmt.Sprintf("line %d, column %d: %s", n.Line, n.Column, s)
+}
+
+// issueCollector is a logrus hook that collects the warnings that are logged while a config file
+// is parsed.
+type issueCollector struct {
+	issues []string
+}
+
+func (ic *issueCollector) Levels() []logrus.Level {
+	return []logrus.Level{logrus.ErrorLevel, logrus.WarnLevel}
+}
+
+func (ic *issueCollector) Fire(e *logrus.Entry) error {
+	ic.issues = append(ic.issues, e.Message)
+	return nil
+}
+
+// ValidateConfigFile parses the given config file and returns the problems that loading it would
+// log and then ignore, such as unknown keys and values of the wrong type. The error is non-nil
+// when the file can't be read or isn't a valid config at all.
+func ValidateConfigFile(c context.Context, fileName string) ([]string, error) {
+	bs, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+	ic := &issueCollector{}
+	logger.AddHook(ic)
+	parseContext = context.WithValue(dlog.WithLogger(c, dlog.WrapLogrus(logger)), parsedFile{}, fileName)
+	defer func() {
+		parseContext = nil
+	}()
+	if err = yaml.Unmarshal(bs, &Config{}); err != nil {
+		return ic.issues, fmt.Errorf("file %s: %w", fileName, err)
+	}
+	return ic.issues, nil
+}
+
+// ConfigFiles returns the config files that GetConfig reads, in the order that they are merged.
+func ConfigFiles(c context.Context) ([]string, error) {
+	dirs, err := filelocation.AppSystemConfigDirs(c)
+	if err != nil {
+		return nil, err
+	}
+	appDir, err := filelocation.AppUserConfigDir(c)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, dir := range append(dirs, appDir) {
+		fileName := filepath.Join(dir, configFile)
+		if _, err := os.Stat(fileName); err == nil {
+			files = append(files, fileName)
 		}
 	}
-	return fmt.Sprintf("line %d: %s", n.Line, s)
+	return files, nil
 }
 
 // GetConfig returns the Telepresence configuration as stored in filelocation.AppUserConfigDir
 // or filelocation.AppSystemConfigDirs
-//
 func GetConfig(c context.Context) *Config {
 	configOnce.Do(func() {
 		var err error
@@ -600,5 +2134,6 @@ func loadConfig(c context.Context) (*Config, error) {
 	if err = readMerge(appDir); err != nil {
 		return nil, err
 	}
+	cfg.Timeouts.applyOverrides(c, os.Getenv(TimeoutsEnv))
 	return &cfg, nil
 }
@@ -11,6 +11,7 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
 
 	"github.com/datawire/dlib/dlog"
 	"github.com/telepresenceio/telepresence/v2/pkg/filelocation"
@@ -30,6 +31,17 @@ timeouts:
   apply: 33s
 logLevels:
   userDaemon: debug
+cluster:
+  qps: 50
+  burst: 10
+  disruptionBudgets: respect
+dns:
+  addressOrder: ipv6first
+reconnect:
+  maxPerMinute: 20
+metrics:
+  userDaemonPort: 9095
+  rootDaemonPort: 9096
 `,
 		/* user */ `
 timeouts:
@@ -37,10 +49,28 @@ timeouts:
   proxyDial: 17.0
 logLevels:
   rootDaemon: trace
+  systemLog: warning
 images:
   registry: testregistry.io
   agentImage: ambassador-telepresence-client-image:0.0.1
   webhookAgentImage: ambassador-telepresence-webhook-image:0.0.2
+cluster:
+  burst: 100
+  managerNamespace: team-a
+  pauseAutoscalers: true
+  callbackPorts: [8080, 9090]
+  labels:
+    team: a
+    "not a label": x
+dns:
+  ttl: 5s
+  addressOrder: sideways
+reconnect:
+  maxPerMinute: 0
+  cooldown: 10ms
+metrics:
+  rootDaemonPort: 9097
+  userDaemonPort: 70000
 `,
 	}
 
@@ -68,8 +98,141 @@ images:
 
 	assert.Equal(t, logrus.DebugLevel, cfg.LogLevels.UserDaemon) // from sys2
 	assert.Equal(t, logrus.TraceLevel, cfg.LogLevels.RootDaemon) // from user
+	assert.Equal(t, logrus.WarnLevel, cfg.LogLevels.SystemLog)   // from user
 
 	assert.Equal(t, "testregistry.io", cfg.Images.Registry)                                      // from user
 	assert.Equal(t, "ambassador-telepresence-client-image:0.0.1", cfg.Images.AgentImage)         // from user
 	assert.Equal(t, "ambassador-telepresence-webhook-image:0.0.2", cfg.Images.WebhookAgentImage) // from user
+
+	assert.Equal(t, float32(50), cfg.Cluster.QPS) // from sys2
+	assert.Equal(t, 100, cfg.Cluster.Burst)       // from user
+
+	assert.Equal(t, "team-a", cfg.Cluster.ManagerNamespace)             // from user
+	assert.Equal(t, map[string]string{"team": "a"}, cfg.Cluster.Labels) // from user, without the invalid label
+
+	assert.Equal(t, DisruptionBudgetsRespect, cfg.Cluster.DisruptionBudgets) // from sys2
+	assert.True(t, cfg.Cluster.PauseAutoscalers)                             // from user
+	assert.Equal(t, []uint16{8080, 9090}, cfg.Cluster.CallbackPorts)         // from user
+
+	assert.Equal(t, 5*time.Second, cfg.DNS.TTL)                  // from user
+	assert.Equal(t, AddressOrderIPv6First, cfg.DNS.AddressOrder) // from sys2, the user value is invalid
+
+	assert.Equal(t, 20, cfg.Reconnect.MaxPerMinute)                           // from sys2, the user value is invalid
+	assert.Equal(t, defaultConfig.Reconnect.Cooldown, cfg.Reconnect.Cooldown) // the user value is too short
+
+	assert.Equal(t, 9095, cfg.Metrics.UserDaemonPort) // from sys2, the user value is invalid
+	assert.Equal(t, 9097, cfg.Metrics.RootDaemonPort) // from user
+}
+
+func TestTimeoutOverrides(t *testing.T) {
+	c := dlog.NewTestContext(t, false)
+	to := defaultConfig.Timeouts
+	to.applyOverrides(c, "intercept=30s, agentInstall=5m,daemonDial=1.5s,bogus=3s,apply=soon,apply=2s")
+	assert.Equal(t, 30*time.Second, to.PrivateIntercept)
+	assert.Equal(t, 5*time.Minute, to.PrivateAgentInstall)
+	assert.Equal(t, 1500*time.Millisecond, to.PrivateDaemonDial)
+	assert.Equal(t, 2*time.Second, to.PrivateApply)
+	assert.Equal(t, defaultConfig.Timeouts.PrivateProxyDial, to.PrivateProxyDial)
+
+	// The overrides are remembered, so that they can be passed on to the daemons
+	assert.Equal(t, []string{"intercept=30s", "agentInstall=5m", "daemonDial=1.5s", "apply=2s"}, to.Overrides())
+	assert.Error(t, to.ApplyOverrides([]string{"proxyDial=3s", "bogus=3s"}))
+	assert.Equal(t, defaultConfig.Timeouts.PrivateProxyDial, to.PrivateProxyDial, "no override is applied unless all are valid")
+	assert.NoError(t, to.ApplyOverrides([]string{"proxyDial=3s"}))
+	assert.Equal(t, 3*time.Second, to.PrivateProxyDial)
+	assert.Len(t, to.Overrides(), 5)
+
+	_, _, err := ParseTimeoutOverride("intercept")
+	assert.Error(t, err)
+	_, _, err = ParseTimeoutOverride("intercept=-1s")
+	assert.Error(t, err)
+}
+
+func TestValidateConfigFile(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), configFile)
+	require.NoError(t, ioutil.WriteFile(fileName, []byte(`
+timeouts:
+  agentInstall: true
+  intercepts: 10s
+cloud:
+  skipLogin: maybe
+clusters:
+  lazyNamespaceThreshold: 10
+`), 0600))
+	issues, err := ValidateConfigFile(dlog.NewTestContext(t, false), fileName)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"file " + fileName + `, line 3, column 17: duration expected for key "agentInstall"`,
+		"file " + fileName + `, line 4, column 3: unknown key "intercepts"`,
+		"file " + fileName + `, line 6, column 3: bool expected for key "skipLogin"`,
+		"file " + fileName + `, line 7, column 1: unknown key "clusters"`,
+	}, issues)
+
+	require.NoError(t, ioutil.WriteFile(fileName, []byte(`
+grpc:
+  maxReceiveSize: 16Mi
+  initialWindowSize: 2Gi
+  writeBufferSize: 0
+`), 0600))
+	issues, err = ValidateConfigFile(dlog.NewTestContext(t, false), fileName)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"initialWindowSize must be between 1 and 2147483647 bytes: file " + fileName + ", line 4, column 3: 2Gi",
+		"writeBufferSize must be between 1 and 2147483647 bytes: file " + fileName + ", line 5, column 3: 0",
+	}, issues)
+
+	require.NoError(t, ioutil.WriteFile(fileName, []byte("timeouts:\n  apply: soon\n"), 0600))
+	_, err = ValidateConfigFile(dlog.NewTestContext(t, false), fileName)
+	assert.Error(t, err)
+}
+
+func TestInterceptConfig(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), configFile)
+	require.NoError(t, ioutil.WriteFile(fileName, []byte(`
+intercept:
+  defaultMount: "false"
+  defaultHTTPMatch: [x-user=me]
+  envFileDir: /tmp/envs
+  localAddress: localhost
+  toPod: [8081, 9090]
+  debugEnv:
+    DEBUG_ADDR: "{{.Host}}:{{.Port}}"
+    NODE_OPTIONS: ""
+  env:
+    drop: ["KUBERNETES_*", "[bad"]
+    rename:
+      LOG_LEVEL: APP_LOG_LEVEL
+    hosts:
+      redis.prod.svc.cluster.local:6379: localhost:6380
+    set:
+      DB_URL: "postgres://{{.DB_HOST}}:5432/app"
+`), 0600))
+	issues, err := ValidateConfigFile(dlog.NewTestContext(t, false), fileName)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"file " + fileName + `, line 6, column 3: IP address expected for key "localAddress"`,
+		"file " + fileName + `, line 12, column 11: invalid pattern "[bad": syntax error in pattern`,
+	}, issues)
+
+	cfg := Config{}
+	data, err := ioutil.ReadFile(fileName)
+	require.NoError(t, err)
+	parseContext = dlog.NewTestContext(t, false)
+	defer func() {
+		parseContext = nil
+	}()
+	require.NoError(t, yaml.Unmarshal(data, &cfg))
+	assert.Equal(t, Intercept{
+		DefaultMount:     "false",
+		DefaultHTTPMatch: []string{"x-user=me"},
+		EnvFileDir:       "/tmp/envs",
+		ToPod:            []uint16{8081, 9090},
+		DebugEnv:         map[string]string{"DEBUG_ADDR": "{{.Host}}:{{.Port}}", "NODE_OPTIONS": ""},
+		Env: EnvTransform{
+			Drop:   []string{"KUBERNETES_*"},
+			Rename: map[string]string{"LOG_LEVEL": "APP_LOG_LEVEL"},
+			Hosts:  map[string]string{"redis.prod.svc.cluster.local:6379": "localhost:6380"},
+			Set:    map[string]string{"DB_URL": "postgres://{{.DB_HOST}}:5432/app"},
+		},
+	}, cfg.Intercept)
 }
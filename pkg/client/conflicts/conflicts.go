@@ -0,0 +1,271 @@
+// Package conflicts detects software on the workstation that is known to interfere with the
+// routing or DNS setup that Telepresence performs, and provides remediation advice for it.
+package conflicts
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/subnet"
+)
+
+// Conflict describes a detected piece of conflicting software.
+type Conflict struct {
+	// Name is the name of the software, e.g. "Tailscale"
+	Name string
+
+	// Reason describes what made the detection trigger
+	Reason string
+
+	// Remediation is advice on how to resolve problems caused by the conflict
+	Remediation string
+
+	// NeverProxy are subnets that are owned by the conflicting software and that must never be
+	// routed to the cluster, even if a cluster subnet covers them.
+	NeverProxy []*net.IPNet
+}
+
+func (c *Conflict) String() string {
+	return fmt.Sprintf("%s detected (%s). %s", c.Name, c.Reason, c.Remediation)
+}
+
+// netInterface is the subset of an interface that the detection looks at
+type netInterface struct {
+	name  string
+	addrs []*net.IPNet
+}
+
+// probe is the state of the workstation that the detection is based on
+type probe struct {
+	goos        string
+	ifaces      []netInterface
+	nameservers []net.IP
+	exists      func(path string) bool
+}
+
+type detector func(p *probe) *Conflict
+
+var detectors = []detector{
+	detectDockerDesktop,
+	detectTailscale,
+	detectAnyConnect,
+	detectZscaler,
+	detectDNSMasq,
+}
+
+// Detect returns the conflicting software found on this workstation.
+func Detect(ctx context.Context) []*Conflict {
+	p := &probe{
+		goos:   runtime.GOOS,
+		exists: fileExists,
+	}
+	ifs, err := net.Interfaces()
+	if err != nil {
+		dlog.Errorf(ctx, "unable to list network interfaces: %v", err)
+	}
+	for i := range ifs {
+		ni := netInterface{name: ifs[i].Name}
+		if addrs, err := ifs[i].Addrs(); err == nil {
+			for _, addr := range addrs {
+				if ipn, ok := addr.(*net.IPNet); ok {
+					ni.addrs = append(ni.addrs, ipn)
+				}
+			}
+		}
+		p.ifaces = append(p.ifaces, ni)
+	}
+	if p.goos != "windows" {
+		if dat, err := ioutil.ReadFile("/etc/resolv.conf"); err == nil {
+			p.nameservers = parseNameservers(string(dat))
+		}
+	}
+	return detect(p)
+}
+
+// NeverProxySubnets returns the subnets owned by the conflicting software found on this workstation.
+func NeverProxySubnets(ctx context.Context) []*net.IPNet {
+	var subnets []*net.IPNet
+	for _, c := range Detect(ctx) {
+		subnets = append(subnets, c.NeverProxy...)
+	}
+	return subnet.Unique(subnets)
+}
+
+func detect(p *probe) []*Conflict {
+	var found []*Conflict
+	for _, d := range detectors {
+		if c := d(p); c != nil {
+			found = append(found, c)
+		}
+	}
+	return found
+}
+
+func parseNameservers(resolvConf string) []net.IP {
+	var ips []net.IP
+	for _, line := range strings.Split(resolvConf, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			if ip := net.ParseIP(fields[1]); ip != nil {
+				ips = append(ips, ip)
+			}
+		}
+	}
+	return ips
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func mustParseCIDR(s string) *net.IPNet {
+	_, ipn, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+	return ipn
+}
+
+var (
+	vpnkitSubnet    = mustParseCIDR("192.168.65.0/24")
+	cgnatSubnet     = mustParseCIDR("100.64.0.0/10")
+	tailscaleDNSNet = mustParseCIDR("100.100.100.100/32")
+)
+
+// findInterface returns the first interface whose name has one of the given prefixes and, when
+// inSubnet is non nil, also has an address in that subnet.
+func (p *probe) findInterface(inSubnet *net.IPNet, prefixes ...string) *netInterface {
+	for i := range p.ifaces {
+		ni := &p.ifaces[i]
+		if len(prefixes) > 0 {
+			match := false
+			for _, pfx := range prefixes {
+				if strings.HasPrefix(ni.name, pfx) {
+					match = true
+					break
+				}
+			}
+			if !match {
+				continue
+			}
+		}
+		if inSubnet == nil {
+			return ni
+		}
+		for _, addr := range ni.addrs {
+			if inSubnet.Contains(addr.IP) {
+				return ni
+			}
+		}
+	}
+	return nil
+}
+
+func (p *probe) findPath(paths ...string) string {
+	for _, path := range paths {
+		if p.exists(path) {
+			return path
+		}
+	}
+	return ""
+}
+
+func detectDockerDesktop(p *probe) *Conflict {
+	var reason string
+	if ni := p.findInterface(vpnkitSubnet); ni != nil {
+		reason = fmt.Sprintf("interface %s has an address in %s", ni.name, vpnkitSubnet)
+	} else if p.goos == "darwin" {
+		if path := p.findPath("/Applications/Docker.app"); path != "" {
+			reason = path + " exists"
+		}
+	}
+	if reason == "" {
+		return nil
+	}
+	return &Conflict{
+		Name:   "Docker Desktop",
+		Reason: reason,
+		Remediation: fmt.Sprintf("The vpnkit subnet %s is never proxied. If a cluster subnet overlaps with it, "+
+			"change the Docker Desktop subnet in its Resources > Network settings.", vpnkitSubnet),
+		NeverProxy: []*net.IPNet{vpnkitSubnet},
+	}
+}
+
+func detectTailscale(p *probe) *Conflict {
+	ni := p.findInterface(nil, "tailscale")
+	if ni == nil && p.goos == "darwin" {
+		ni = p.findInterface(cgnatSubnet, "utun")
+	}
+	if ni == nil {
+		return nil
+	}
+	return &Conflict{
+		Name:   "Tailscale",
+		Reason: "interface " + ni.name,
+		Remediation: fmt.Sprintf("Tailscale uses the CGNAT range %s and its DNS server at %s, which is never proxied. "+
+			"If the cluster uses subnets in the CGNAT range, disable 'accept-routes' or use narrower also-proxy "+
+			"subnets in your kubeconfig.", cgnatSubnet, tailscaleDNSNet.IP),
+		NeverProxy: []*net.IPNet{tailscaleDNSNet},
+	}
+}
+
+func detectAnyConnect(p *probe) *Conflict {
+	var reason string
+	if ni := p.findInterface(nil, "cscotun"); ni != nil {
+		reason = "interface " + ni.name
+	} else if path := p.findPath("/opt/cisco/anyconnect", "/opt/cisco/secureclient"); path != "" {
+		reason = path + " exists"
+	}
+	if reason == "" {
+		return nil
+	}
+	return &Conflict{
+		Name:   "Cisco AnyConnect",
+		Reason: reason,
+		Remediation: "When connected, AnyConnect may remove routes added by Telepresence. Enable " +
+			"'Allow local (LAN) access' or ask your network administrator to exclude the cluster subnets " +
+			"from the VPN tunnel.",
+	}
+}
+
+func detectZscaler(p *probe) *Conflict {
+	var reason string
+	if ni := p.findInterface(nil, "zcctun"); ni != nil {
+		reason = "interface " + ni.name
+	} else if path := p.findPath("/Applications/Zscaler", "/opt/zscaler"); path != "" {
+		reason = path + " exists"
+	}
+	if reason == "" {
+		return nil
+	}
+	return &Conflict{
+		Name:   "Zscaler",
+		Reason: reason,
+		Remediation: "Zscaler intercepts DNS and outbound traffic. Add the cluster domain and the " +
+			"cluster subnets to the Zscaler bypass list if cluster names fail to resolve or connections time out.",
+	}
+}
+
+func detectDNSMasq(p *probe) *Conflict {
+	if p.goos != "linux" || len(p.nameservers) == 0 {
+		return nil
+	}
+	ns := p.nameservers[0]
+	// 127.0.0.53 is systemd-resolved, which Telepresence integrates with
+	if !ns.IsLoopback() || ns.Equal(net.IPv4(127, 0, 0, 53)) {
+		return nil
+	}
+	return &Conflict{
+		Name:   "dnsmasq",
+		Reason: fmt.Sprintf("the first nameserver in /etc/resolv.conf is the local forwarder %s", ns),
+		Remediation: "If names in the cluster fail to resolve, use --dns to point Telepresence at the " +
+			"upstream nameserver that the forwarder uses.",
+	}
+}
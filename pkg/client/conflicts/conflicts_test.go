@@ -0,0 +1,89 @@
+package conflicts
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func ifaceWithAddr(t *testing.T, name, cidr string) netInterface {
+	ip, ipn, err := net.ParseCIDR(cidr)
+	require.NoError(t, err)
+	ipn.IP = ip
+	return netInterface{name: name, addrs: []*net.IPNet{ipn}}
+}
+
+func names(cs []*Conflict) []string {
+	ns := make([]string, len(cs))
+	for i, c := range cs {
+		ns[i] = c.Name
+	}
+	return ns
+}
+
+func TestDetect(t *testing.T) {
+	noFiles := func(string) bool { return false }
+	tests := []struct {
+		name     string
+		probe    *probe
+		expected []string
+	}{
+		{
+			"nothing",
+			&probe{goos: "linux", exists: noFiles, ifaces: []netInterface{ifaceWithAddr(t, "eth0", "10.0.0.2/24")}},
+			[]string{},
+		},
+		{
+			"tailscale on linux",
+			&probe{goos: "linux", exists: noFiles, ifaces: []netInterface{ifaceWithAddr(t, "tailscale0", "100.101.102.103/32")}},
+			[]string{"Tailscale"},
+		},
+		{
+			"tailscale on darwin",
+			&probe{goos: "darwin", exists: noFiles, ifaces: []netInterface{ifaceWithAddr(t, "utun3", "100.101.102.103/32")}},
+			[]string{"Tailscale"},
+		},
+		{
+			"utun on darwin outside CGNAT",
+			&probe{goos: "darwin", exists: noFiles, ifaces: []netInterface{ifaceWithAddr(t, "utun3", "10.8.0.3/32")}},
+			[]string{},
+		},
+		{
+			"docker desktop vpnkit",
+			&probe{goos: "linux", exists: noFiles, ifaces: []netInterface{ifaceWithAddr(t, "eth1", "192.168.65.3/24")}},
+			[]string{"Docker Desktop"},
+		},
+		{
+			"anyconnect and zscaler",
+			&probe{goos: "darwin", exists: func(path string) bool {
+				return path == "/opt/cisco/anyconnect" || path == "/Applications/Zscaler"
+			}},
+			[]string{"Cisco AnyConnect", "Zscaler"},
+		},
+		{
+			"dnsmasq",
+			&probe{goos: "linux", exists: noFiles, nameservers: []net.IP{net.ParseIP("127.0.1.1")}},
+			[]string{"dnsmasq"},
+		},
+		{
+			"systemd-resolved is not dnsmasq",
+			&probe{goos: "linux", exists: noFiles, nameservers: []net.IP{net.ParseIP("127.0.0.53")}},
+			[]string{},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, names(detect(tt.probe)))
+		})
+	}
+}
+
+func TestParseNameservers(t *testing.T) {
+	ips := parseNameservers("# comment\nsearch example.com\nnameserver 127.0.1.1\nnameserver  8.8.8.8\nnameserver bogus\n")
+	require.Len(t, ips, 2)
+	assert.True(t, ips[0].Equal(net.ParseIP("127.0.1.1")))
+	assert.True(t, ips[1].Equal(net.ParseIP("8.8.8.8")))
+}
@@ -25,12 +25,21 @@ import (
 	"github.com/telepresenceio/telepresence/v2/pkg/client"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/connector/internal/scout"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/connector/sharedstate"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/connector/userd_api"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/connector/userd_auth"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/connector/userd_forward"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/connector/userd_grpc"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/connector/userd_k8s"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/connector/userd_proxy"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/connector/userd_trafficmgr"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/connector/userd_workload"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/debug"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/logging"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/metrics"
 	"github.com/telepresenceio/telepresence/v2/pkg/filelocation"
+	"github.com/telepresenceio/telepresence/v2/pkg/iputil"
+	"github.com/telepresenceio/telepresence/v2/pkg/loglevel"
+	"github.com/telepresenceio/telepresence/v2/pkg/tracing"
 )
 
 const processName = "connector"
@@ -74,6 +83,8 @@ type service struct {
 
 // Command returns the CLI sub-command for "connector-foreground"
 func Command() *cobra.Command {
+	var debugEnabled bool
+	var timeouts []string
 	c := &cobra.Command{
 		Use:    processName + "-foreground",
 		Short:  "Launch Telepresence " + titleName + " in the foreground (debug)",
@@ -81,9 +92,11 @@ func Command() *cobra.Command {
 		Hidden: true,
 		Long:   help,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return run(cmd.Context())
+			return run(cmd.Context(), debugEnabled, timeouts)
 		},
 	}
+	c.Flags().BoolVar(&debugEnabled, "debug", false, "Serve pprof and a state dump on "+client.ConnectorDebugSocketName)
+	c.Flags().StringSliceVar(&timeouts, "timeout", nil, "Override a timeout of the config, e.g. --timeout intercept=30s")
 	return c
 }
 
@@ -154,6 +167,9 @@ func (s *service) connect(c context.Context, cr *rpc.ConnectRequest, dryRun bool
 
 func (s *service) connectWorker(c context.Context, cr *rpc.ConnectRequest, k8sConfig *userd_k8s.Config) *rpc.ConnectInfo {
 	mappedNamespaces := cr.MappedNamespaces
+	if len(mappedNamespaces) == 0 {
+		mappedNamespaces = k8sConfig.MappedNamespaces
+	}
 	if len(mappedNamespaces) == 1 && mappedNamespaces[0] == "all" {
 		mappedNamespaces = nil
 	}
@@ -163,20 +179,61 @@ func (s *service) connectWorker(c context.Context, cr *rpc.ConnectRequest, k8sCo
 		Action: "connect",
 	}
 
-	// establish a connection to the daemon gRPC service
-	dlog.Info(c, "Connecting to daemon...")
-	conn, err := client.DialSocket(c, client.DaemonSocketName)
-	if err != nil {
-		dlog.Errorf(c, "unable to connect to daemon: %+v", err)
-		s.cancel()
-		return &rpc.ConnectInfo{
-			Error:     rpc.ConnectInfo_DAEMON_FAILED,
-			ErrorText: err.Error(),
+	var setDNSSearchPath userd_k8s.SetDNSSearchPathFunc
+	var setOutboundInfo userd_trafficmgr.SetOutboundInfoFunc
+	var setClusterDomain func(context.Context, string) error
+	var setExternalRoutes userd_k8s.SetExternalRoutesFunc
+	if s.env.ProxyAddress != "" {
+		// Proxy mode. There's no root daemon, so cluster access is provided by a SOCKS5 proxy.
+		dlog.Infof(c, "Running in proxy mode, SOCKS5 address %s", s.env.ProxyAddress)
+		proxy := userd_proxy.New(s.env.ProxyAddress, s.env.ProxyDNSAddress)
+		setDNSSearchPath = proxy.SetDNSSearchPath
+		setOutboundInfo = proxy.SetOutboundInfo
+		setClusterDomain = func(context.Context, string) error {
+			// The proxy leaves the resolution of all names to the cluster
+			return nil
+		}
+		setExternalRoutes = proxy.SetExternalRoutes
+	} else {
+		// establish a connection to the daemon gRPC service
+		dlog.Info(c, "Connecting to daemon...")
+		conn, err := client.DialSocket(c, client.DaemonSocketName)
+		if err != nil {
+			dlog.Errorf(c, "unable to connect to daemon: %+v", err)
+			s.cancel()
+			return &rpc.ConnectInfo{
+				Error:     rpc.ConnectInfo_DAEMON_FAILED,
+				ErrorText: err.Error(),
+			}
+		}
+		// Don't bother calling 'conn.Close()', it should remain open until we shut down, and just
+		// prefer to let the OS close it when we exit.
+		daemonClient := daemon.NewDaemonClient(conn)
+		setDNSSearchPath = daemonClient.SetDnsSearchPath
+		setOutboundInfo = daemonClient.SetOutboundInfo
+		setClusterDomain = func(c context.Context, domain string) error {
+			_, err := daemonClient.SetClusterDomain(c, &daemon.ClusterDomain{Domain: domain})
+			return err
+		}
+		setExternalRoutes = func(c context.Context, subnets []*net.IPNet, hosts map[string][]net.IP) error {
+			routes := &daemon.ExternalRoutes{
+				Subnets: make([]*manager.IPNet, len(subnets)),
+				Hosts:   make(map[string]*daemon.IPAddresses, len(hosts)),
+			}
+			for i, sn := range subnets {
+				routes.Subnets[i] = iputil.IPNetToRPC(sn)
+			}
+			for host, ips := range hosts {
+				addrs := &daemon.IPAddresses{Ips: make([][]byte, len(ips))}
+				for i, ip := range ips {
+					addrs.Ips[i] = ip
+				}
+				routes.Hosts[host] = addrs
+			}
+			_, err := daemonClient.SetExternalRoutes(c, routes)
+			return err
 		}
 	}
-	// Don't bother calling 'conn.Close()', it should remain open until we shut down, and just
-	// prefer to let the OS close it when we exit.
-	daemonClient := daemon.NewDaemonClient(conn)
 
 	dlog.Info(c, "Connecting to k8s cluster...")
 	cluster, err := func() (*userd_k8s.Cluster, error) {
@@ -186,7 +243,8 @@ func (s *service) connectWorker(c context.Context, cr *rpc.ConnectRequest, k8sCo
 			k8sConfig,
 			mappedNamespaces,
 			userd_k8s.Callbacks{
-				SetDNSSearchPath: daemonClient.SetDnsSearchPath,
+				SetDNSSearchPath:  setDNSSearchPath,
+				SetExternalRoutes: setExternalRoutes,
 			},
 		)
 		if err != nil {
@@ -205,6 +263,16 @@ func (s *service) connectWorker(c context.Context, cr *rpc.ConnectRequest, k8sCo
 	s.sharedState.MaybeSetCluster(cluster)
 	dlog.Infof(c, "Connected to context %s (%s)", cluster.Context, cluster.Server)
 
+	// The names that the daemon's DNS resolver looks up reference the namespaces that they're in
+	s.managerProxy.SetLookupHostHook(func(host string) {
+		cluster.ReferenceHost(c, host)
+	})
+
+	// The daemon must know the cluster domain to route the names in it to the cluster
+	if err := setClusterDomain(c, cluster.ClusterDomain(c)); err != nil {
+		dlog.Errorf(c, "unable to set the cluster domain of the daemon: %v", err)
+	}
+
 	// Phone home with the information about the size of the cluster
 	s.scout <- func() ScoutReport {
 		report := ScoutReport{
@@ -227,7 +295,7 @@ func (s *service) connectWorker(c context.Context, cr *rpc.ConnectRequest, k8sCo
 		userd_trafficmgr.Callbacks{
 			GetAPIKey:       s.sharedState.GetCloudAPIKey,
 			SetClient:       s.managerProxy.SetClient,
-			SetOutboundInfo: daemonClient.SetOutboundInfo,
+			SetOutboundInfo: setOutboundInfo,
 		})
 	if err != nil {
 		dlog.Errorf(c, "Unable to connect to TrafficManager: %s", err)
@@ -292,17 +360,30 @@ func (s *service) connectWorker(c context.Context, cr *rpc.ConnectRequest, k8sCo
 }
 
 // run is the main function when executing as the connector
-func run(c context.Context) error {
+func run(c context.Context, debugEnabled bool, timeouts []string) error {
 	c, err := logging.InitContext(c, processName)
 	if err != nil {
 		return err
 	}
+	if err = client.GetConfig(c).Timeouts.ApplyOverrides(timeouts); err != nil {
+		return err
+	}
 	c = dgroup.WithGoroutineName(c, "/"+processName)
+	logDir, err := filelocation.AppUserLogDir(c)
+	if err != nil {
+		return err
+	}
+	if err = tracing.Init(c, processName, logDir, client.GetConfig(c).Tracing.Endpoint); err != nil {
+		return err
+	}
 
 	env, err := client.LoadEnv(c)
 	if err != nil {
 		return err
 	}
+	if env.MockCluster {
+		return runMock(c, env)
+	}
 
 	s := &service{
 		env:         env,
@@ -329,7 +410,7 @@ func run(c context.Context) error {
 	}()
 
 	dlog.Info(c, "---")
-	dlog.Infof(c, "Telepresence %s %s starting...", titleName, client.DisplayVersion())
+	logging.SystemEvent(c, "Telepresence %s %s starting...", titleName, client.DisplayVersion())
 	dlog.Infof(c, "PID is %d", os.Getpid())
 	dlog.Info(c, "")
 
@@ -340,10 +421,44 @@ func run(c context.Context) error {
 		}
 	}()
 
+	g.Go("server-metrics", func(c context.Context) error {
+		if err := metrics.Serve(c, client.GetConfig(c).Metrics.UserDaemonPort); err != nil {
+			dlog.Error(c, err)
+		}
+		return nil
+	})
+
+	connectorServer := userd_grpc.NewGRPCService(
+		userd_grpc.Callbacks{
+			InterceptStatus: s.interceptStatus,
+			Cancel:          s.cancel,
+			Connect:         s.connect,
+		},
+		s.sharedState,
+	)
+
+	g.Go("server-api", func(c context.Context) error {
+		if err := userd_api.Serve(c, client.GetConfig(c).LocalAPI.Port, connectorServer); err != nil {
+			dlog.Error(c, err)
+		}
+		return nil
+	})
+
+	if debugEnabled {
+		g.Go("server-debug", func(c context.Context) error {
+			if err := debug.Serve(c, client.ConnectorDebugSocketName, 0600, s.debugState); err != nil {
+				dlog.Error(c, err)
+			}
+			return nil
+		})
+	}
+
 	g.Go("server-grpc", func(c context.Context) (err error) {
+		c = loglevel.WithSubsystem(c, loglevel.IPC)
 		defer func() {
 			if perr := derror.PanicToError(recover()); perr != nil {
 				dlog.Error(c, perr)
+				logging.ReportCrash(c, processName, perr)
 			}
 
 			// Close s.connectRequest if it hasn't already been closed.
@@ -376,16 +491,13 @@ func run(c context.Context) error {
 			}
 		}()
 
-		svc := grpc.NewServer()
-		rpc.RegisterConnectorServer(svc, userd_grpc.NewGRPCService(
-			userd_grpc.Callbacks{
-				InterceptStatus: s.interceptStatus,
-				Cancel:          s.cancel,
-				Connect:         s.connect,
-			},
-			s.sharedState,
-		))
+		svc := grpc.NewServer(append(tracing.ServerOptions(), client.KeepalivePolicy())...)
+		rpc.RegisterConnectorServer(svc, connectorServer)
 		manager.RegisterManagerServer(svc, &s.managerProxy)
+		manager.RegisterSessionsServer(svc, userd_grpc.NewSessionsProxy(s.sharedState))
+		rpc.RegisterForwardsServer(svc, userd_forward.NewForwardsServer(c, s.sharedState))
+		rpc.RegisterWorkloadsServer(svc, userd_workload.NewWorkloadsServer(s.sharedState))
+		rpc.RegisterInterceptPlansServer(svc, userd_grpc.NewInterceptPlanner(s.sharedState))
 
 		sc := &dhttp.ServerConfig{
 			Handler: svc,
@@ -420,7 +532,24 @@ func run(c context.Context) error {
 		if cluster == nil {
 			return nil
 		}
-		return cluster.RunWatchers(c)
+		return cluster.RunWatchers(loglevel.WithSubsystem(c, loglevel.K8sWatch))
+	})
+
+	// background-external routes the addresses of the nodes and load balancers of the cluster to
+	// the cluster when the kubeconfig extension tells it to, once the traffic-manager is connected.
+	g.Go("background-external", func(c context.Context) error {
+		cluster, _ := s.sharedState.GetClusterBlocking(c)
+		if cluster == nil {
+			return nil
+		}
+		mgr, _ := s.sharedState.GetTrafficManagerBlocking(c)
+		if mgr == nil {
+			return nil
+		}
+		if _, err := mgr.GetClientBlocking(c); err != nil {
+			return nil
+		}
+		return cluster.RunExternalAddressWatcher(loglevel.WithSubsystem(c, loglevel.K8sWatch))
 	})
 
 	// background-manager (1) starts up with ensuring that the manager is installed and running,
@@ -469,5 +598,6 @@ func run(c context.Context) error {
 	if err != nil {
 		dlog.Error(c, err)
 	}
+	logging.SystemEvent(c, "Telepresence %s stopped", titleName)
 	return err
 }
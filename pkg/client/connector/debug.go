@@ -0,0 +1,50 @@
+package connector
+
+import (
+	"context"
+)
+
+// debugState is the internal state of the connector that is served on the debug socket
+type debugState struct {
+	Cluster    *clusterDebugState    `json:"cluster,omitempty"`
+	SessionID  string                `json:"sessionID,omitempty"`
+	Intercepts []interceptDebugState `json:"intercepts"`
+}
+
+type clusterDebugState struct {
+	Context   string `json:"context"`
+	Server    string `json:"server"`
+	Namespace string `json:"namespace"`
+}
+
+type interceptDebugState struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Disposition string `json:"disposition"`
+	Message     string `json:"message,omitempty"`
+}
+
+func (s *service) debugState(_ context.Context) interface{} {
+	ds := &debugState{}
+	if cluster := s.sharedState.GetClusterNonBlocking(); cluster != nil {
+		ds.Cluster = &clusterDebugState{
+			Context:   cluster.Config.Context,
+			Server:    cluster.Config.Server,
+			Namespace: cluster.Config.Namespace,
+		}
+	}
+	if tm := s.sharedState.GetTrafficManagerNonBlocking(); tm != nil {
+		if si := tm.SessionInfo(); si != nil {
+			ds.SessionID = si.SessionId
+		}
+		for _, ii := range tm.CurrentIntercepts() {
+			ds.Intercepts = append(ds.Intercepts, interceptDebugState{
+				ID:          ii.Id,
+				Name:        ii.Spec.Name,
+				Disposition: ii.Disposition.String(),
+				Message:     ii.Message,
+			})
+		}
+	}
+	return ds
+}
@@ -0,0 +1,69 @@
+package connector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"time"
+
+	"google.golang.org/grpc"
+
+	"github.com/datawire/dlib/dgroup"
+	"github.com/datawire/dlib/dhttp"
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/connector/userd_mock"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/logging"
+	"github.com/telepresenceio/telepresence/v2/pkg/tracing"
+)
+
+// runMock is the main function of the connector when it serves a mock cluster. The connector
+// service, and the traffic-manager service that the root daemon uses, are provided by the mock
+// cluster, which also serves the stubs of the services. The stubs are reached through a SOCKS5
+// proxy instead of the root daemon when a proxy address is given.
+func runMock(c context.Context, env client.Env) error {
+	dlog.Info(c, "---")
+	logging.SystemEvent(c, "Telepresence %s %s starting with a mock cluster...", titleName, client.DisplayVersion())
+	dlog.Infof(c, "PID is %d", os.Getpid())
+	dlog.Info(c, "")
+
+	g := dgroup.NewGroup(c, dgroup.GroupConfig{
+		SoftShutdownTimeout:  2 * time.Second,
+		EnableSignalHandling: true,
+		ShutdownOnNonError:   true,
+	})
+	mc := userd_mock.New(env.ProxyAddress, env.ProxyDNSAddress)
+	mc.OnQuit = func() { g.Go("quit", func(_ context.Context) error { return nil }) }
+
+	grpcListener, err := net.Listen("unix", client.ConnectorSocketName)
+	if err != nil {
+		if errors.Is(err, syscall.EADDRINUSE) {
+			return fmt.Errorf("socket %q exists so the %s is either already running or terminated ungracefully",
+				client.SocketURL(client.ConnectorSocketName), processName)
+		}
+		return err
+	}
+	// Don't have dhttp.ServerConfig.Serve unlink the socket; defer unlinking the socket
+	// until the process exits.
+	grpcListener.(*net.UnixListener).SetUnlinkOnClose(false)
+	defer func() {
+		_ = os.Remove(grpcListener.Addr().String())
+	}()
+
+	g.Go("mock-cluster", mc.Run)
+	g.Go("server-grpc", func(c context.Context) error {
+		svc := grpc.NewServer(append(tracing.ServerOptions(), client.KeepalivePolicy())...)
+		mc.Register(svc)
+		sc := &dhttp.ServerConfig{
+			Handler: svc,
+		}
+		dlog.Info(c, "gRPC server started")
+		return sc.Serve(c, grpcListener)
+	})
+	err = g.Wait()
+	logging.SystemEvent(c, "Telepresence %s stopped", titleName)
+	return err
+}
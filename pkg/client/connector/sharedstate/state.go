@@ -33,6 +33,30 @@ type TrafficManager interface {
 	WorkloadInfoSnapshot(context.Context, *connector.ListRequest) *connector.WorkloadInfoSnapshot
 	Uninstall(context.Context, *connector.UninstallRequest) (*connector.UninstallResult, error)
 	SetStatus(context.Context, *connector.ConnectInfo)
+
+	// SessionInfo returns the session established with the traffic-manager, or nil if
+	// communication is not yet established.
+	SessionInfo() *manager.SessionInfo
+
+	// CurrentIntercepts returns a copy of the latest intercept snapshot from the traffic-manager.
+	CurrentIntercepts() []*manager.InterceptInfo
+
+	// ListSessions returns the client sessions of the traffic-manager. Only admins may list them.
+	ListSessions(context.Context) (*manager.ClientSessions, error)
+
+	// KickSession removes a client session, along with its intercepts, from the traffic-manager.
+	// Only admins may kick a session.
+	KickSession(ctx context.Context, sessionID string) error
+
+	// RestartWorkload restarts the pods of a workload and waits until they're ready.
+	RestartWorkload(ctx context.Context, namespace, name string) error
+
+	// InstallAgents installs the traffic-agent in several workloads in parallel, and calls the
+	// progress function as each install completes.
+	InstallAgents(ctx context.Context, namespace string, names []string, agentImage string, progress func(*connector.AgentInstallProgress)) error
+
+	// PlanIntercept tells what AddIntercept would do with the given request, without doing it.
+	PlanIntercept(context.Context, *connector.CreateInterceptRequest) (*connector.InterceptPlan, error)
 }
 
 type State struct {
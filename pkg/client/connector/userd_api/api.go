@@ -0,0 +1,380 @@
+// Package userd_api serves a localhost HTTP API that mirrors the gRPC API of the user daemon, so
+// that IDE plugins and other tools that can't easily speak gRPC over a unix socket can manage the
+// session and its intercepts.
+//
+// The API is versioned by its path prefix. Requests and responses are the JSON form (see
+// https://developers.google.com/protocol-buffers/docs/proto3#json) of the messages of the
+// connector gRPC API:
+//
+//	GET    /v1/version              common.VersionInfo
+//	GET    /v1/session              connector.ConnectInfo of the current session
+//	POST   /v1/session              connector.ConnectRequest -> connector.ConnectInfo
+//	DELETE /v1/session              quits the user daemon
+//	GET    /v1/session/events       a stream of connector.ConnectInfo, one per line, sent when it changes
+//	GET    /v1/workloads            connector.WorkloadInfoSnapshot, "namespace" and "filter" query parameters
+//	GET    /v1/intercepts           manager.InterceptInfoSnapshot
+//	POST   /v1/intercepts           connector.CreateInterceptRequest -> connector.InterceptResult
+//	GET    /v1/intercepts/{name}    manager.InterceptInfo
+//	DELETE /v1/intercepts/{name}    connector.InterceptResult
+//
+// Every request must have an "Authorization: Bearer <token>" header. The URL of the API and the
+// token are written to the file returned by InfoFile when the API starts, and the file is only
+// readable by the user.
+package userd_api
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	empty "google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/datawire/dlib/dhttp"
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/filelocation"
+)
+
+const prefix = "/v1/"
+
+// eventsInterval is how often the session is checked for changes by the events stream.
+const eventsInterval = time.Second
+
+// Info is the content of the file returned by InfoFile.
+type Info struct {
+	URL   string `json:"url"`
+	Token string `json:"token"`
+}
+
+// InfoFile returns the path of the file that tells the URL of the API and its token.
+func InfoFile(ctx context.Context) (string, error) {
+	dir, err := filelocation.AppUserCacheDir(ctx)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "local-api.json"), nil
+}
+
+// Serve serves the API on localhost:<port> until the context is cancelled. It does nothing if port
+// is zero.
+func Serve(ctx context.Context, port int, svc connector.ConnectorServer) error {
+	if port == 0 {
+		return nil
+	}
+	infoFile, err := InfoFile(ctx)
+	if err != nil {
+		return err
+	}
+	token, err := newToken()
+	if err != nil {
+		return err
+	}
+	ln, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		return fmt.Errorf("unable to listen for local API requests: %w", err)
+	}
+	info := Info{URL: fmt.Sprintf("http://%s%s", ln.Addr(), prefix), Token: token}
+	data, err := json.Marshal(&info)
+	if err != nil {
+		_ = ln.Close()
+		return err
+	}
+	if err = ioutil.WriteFile(infoFile, data, 0600); err != nil {
+		_ = ln.Close()
+		return err
+	}
+	defer func() {
+		_ = os.Remove(infoFile)
+	}()
+
+	dlog.Infof(ctx, "Serving the local API on %s", info.URL)
+	sc := &dhttp.ServerConfig{
+		Handler: NewHandler(svc, token),
+	}
+	return sc.Serve(ctx, ln)
+}
+
+func newToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+type handler struct {
+	svc   connector.ConnectorServer
+	token []byte
+}
+
+// NewHandler returns the handler of the API. Requests that lack the given token are rejected.
+func NewHandler(svc connector.ConnectorServer, token string) http.Handler {
+	return &handler{svc: svc, token: []byte("Bearer " + token)}
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), h.token) != 1 {
+		http.Error(w, "missing or invalid token", http.StatusUnauthorized)
+		return
+	}
+	if !strings.HasPrefix(r.URL.Path, prefix) {
+		http.NotFound(w, r)
+		return
+	}
+	path := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, prefix), "/")
+	switch {
+	case path == "version":
+		h.version(w, r)
+	case path == "session":
+		h.session(w, r)
+	case path == "session/events":
+		h.sessionEvents(w, r)
+	case path == "workloads":
+		h.workloads(w, r)
+	case path == "intercepts":
+		h.intercepts(w, r)
+	case strings.HasPrefix(path, "intercepts/"):
+		h.intercept(w, r, strings.TrimPrefix(path, "intercepts/"))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (h *handler) version(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+	vi, err := h.svc.Version(r.Context(), &empty.Empty{})
+	respond(w, http.StatusOK, vi, err)
+}
+
+func (h *handler) session(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet, http.MethodPost, http.MethodDelete) {
+		return
+	}
+	ctx := r.Context()
+	switch r.Method {
+	case http.MethodGet:
+		ci, err := h.svc.Status(ctx, &connector.ConnectRequest{})
+		respond(w, http.StatusOK, ci, err)
+	case http.MethodPost:
+		cr := &connector.ConnectRequest{}
+		if !readBody(w, r, cr) {
+			return
+		}
+		ci, err := h.svc.Connect(ctx, cr)
+		status := http.StatusOK
+		if err == nil {
+			switch ci.Error {
+			case connector.ConnectInfo_UNSPECIFIED, connector.ConnectInfo_ALREADY_CONNECTED:
+			case connector.ConnectInfo_MUST_RESTART:
+				status = http.StatusConflict
+			default:
+				status = http.StatusBadGateway
+			}
+		}
+		respond(w, status, ci, err)
+	case http.MethodDelete:
+		_, err := h.svc.Quit(ctx, &empty.Empty{})
+		respond(w, http.StatusOK, &empty.Empty{}, err)
+	}
+}
+
+// sessionEvents streams the session as newline delimited JSON. The session is sent when the stream
+// starts and then each time that it changes, until the client goes away.
+func (h *handler) sessionEvents(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+
+	ticker := time.NewTicker(eventsInterval)
+	defer ticker.Stop()
+	var last *connector.ConnectInfo
+	for {
+		ci, err := h.svc.Status(ctx, &connector.ConnectRequest{})
+		if err != nil {
+			if ctx.Err() == nil {
+				dlog.Errorf(ctx, "local API session events: %v", err)
+			}
+			return
+		}
+		if last == nil || !proto.Equal(last, ci) {
+			data, err := protojson.Marshal(ci)
+			if err != nil {
+				dlog.Errorf(ctx, "local API session events: %v", err)
+				return
+			}
+			if _, err = w.Write(append(data, '\n')); err != nil {
+				return
+			}
+			flusher.Flush()
+			last = ci
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (h *handler) workloads(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet) {
+		return
+	}
+	q := r.URL.Query()
+	lr := &connector.ListRequest{Namespace: q.Get("namespace")}
+	if f := q.Get("filter"); f != "" {
+		v, ok := connector.ListRequest_Filter_value[strings.ToUpper(f)]
+		if !ok {
+			http.Error(w, fmt.Sprintf("invalid filter %q", f), http.StatusBadRequest)
+			return
+		}
+		lr.Filter = connector.ListRequest_Filter(v)
+	}
+	ws, err := h.svc.List(r.Context(), lr)
+	respond(w, http.StatusOK, ws, err)
+}
+
+func (h *handler) intercepts(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, http.MethodGet, http.MethodPost) {
+		return
+	}
+	ctx := r.Context()
+	if r.Method == http.MethodGet {
+		ci, err := h.svc.Status(ctx, &connector.ConnectRequest{})
+		if err != nil {
+			respond(w, 0, nil, err)
+			return
+		}
+		is := ci.Intercepts
+		if is == nil {
+			is = &manager.InterceptInfoSnapshot{}
+		}
+		respond(w, http.StatusOK, is, nil)
+		return
+	}
+
+	ir := &connector.CreateInterceptRequest{}
+	if !readBody(w, r, ir) {
+		return
+	}
+	result, err := h.svc.CreateIntercept(ctx, ir)
+	status := 0
+	if err == nil {
+		status = interceptStatus(result.Error, http.StatusCreated)
+	}
+	respond(w, status, result, err)
+}
+
+func (h *handler) intercept(w http.ResponseWriter, r *http.Request, name string) {
+	if !allowMethods(w, r, http.MethodGet, http.MethodDelete) {
+		return
+	}
+	ctx := r.Context()
+	if r.Method == http.MethodDelete {
+		result, err := h.svc.RemoveIntercept(ctx, &manager.RemoveInterceptRequest2{Name: name})
+		status := 0
+		if err == nil {
+			status = interceptStatus(result.Error, http.StatusOK)
+		}
+		respond(w, status, result, err)
+		return
+	}
+
+	ci, err := h.svc.Status(ctx, &connector.ConnectRequest{})
+	if err != nil {
+		respond(w, 0, nil, err)
+		return
+	}
+	for _, ii := range ci.GetIntercepts().GetIntercepts() {
+		if ii.Spec.Name == name {
+			respond(w, http.StatusOK, ii, nil)
+			return
+		}
+	}
+	http.Error(w, fmt.Sprintf("intercept %q not found", name), http.StatusNotFound)
+}
+
+// interceptStatus returns the HTTP status that corresponds to the given intercept error, or the
+// given okStatus if there's no error.
+func interceptStatus(ie connector.InterceptError, okStatus int) int {
+	switch ie {
+	case connector.InterceptError_UNSPECIFIED:
+		return okStatus
+	case connector.InterceptError_NOT_FOUND, connector.InterceptError_NO_ACCEPTABLE_WORKLOAD:
+		return http.StatusNotFound
+	case connector.InterceptError_ALREADY_EXISTS, connector.InterceptError_LOCAL_TARGET_IN_USE,
+		connector.InterceptError_MOUNT_POINT_BUSY, connector.InterceptError_AMBIGUOUS_MATCH:
+		return http.StatusConflict
+	case connector.InterceptError_NO_CONNECTION, connector.InterceptError_NO_TRAFFIC_MANAGER,
+		connector.InterceptError_TRAFFIC_MANAGER_CONNECTING:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusBadGateway
+	}
+}
+
+func allowMethods(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, m := range methods {
+		if r.Method == m {
+			return true
+		}
+	}
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	http.Error(w, fmt.Sprintf("method %s not allowed", r.Method), http.StatusMethodNotAllowed)
+	return false
+}
+
+func readBody(w http.ResponseWriter, r *http.Request, m proto.Message) bool {
+	data, err := ioutil.ReadAll(r.Body)
+	if err == nil {
+		err = protojson.Unmarshal(data, m)
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid request body: %v", err), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// respond writes the given message using the given status, or, if status is zero, using
+// http.StatusOK, unless err is non-nil, in which case the error is written.
+func respond(w http.ResponseWriter, status int, m proto.Message, err error) {
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	data, err := protojson.Marshal(m)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if status == 0 {
+		status = http.StatusOK
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(data)
+}
@@ -0,0 +1,124 @@
+package userd_api
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protojson"
+
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+)
+
+type fakeConnector struct {
+	connector.UnimplementedConnectorServer
+	intercepts []*manager.InterceptInfo
+	lastList   *connector.ListRequest
+}
+
+func (f *fakeConnector) Status(context.Context, *connector.ConnectRequest) (*connector.ConnectInfo, error) {
+	return &connector.ConnectInfo{
+		Error:      connector.ConnectInfo_ALREADY_CONNECTED,
+		Intercepts: &manager.InterceptInfoSnapshot{Intercepts: f.intercepts},
+	}, nil
+}
+
+func (f *fakeConnector) List(_ context.Context, lr *connector.ListRequest) (*connector.WorkloadInfoSnapshot, error) {
+	f.lastList = lr
+	return &connector.WorkloadInfoSnapshot{Workloads: []*connector.WorkloadInfo{{Name: "echo"}}}, nil
+}
+
+func (f *fakeConnector) CreateIntercept(_ context.Context, ir *connector.CreateInterceptRequest) (*connector.InterceptResult, error) {
+	for _, ii := range f.intercepts {
+		if ii.Spec.Name == ir.Spec.Name {
+			return &connector.InterceptResult{Error: connector.InterceptError_ALREADY_EXISTS, ErrorText: ir.Spec.Name}, nil
+		}
+	}
+	ii := &manager.InterceptInfo{Spec: ir.Spec, Id: "1:" + ir.Spec.Name}
+	f.intercepts = append(f.intercepts, ii)
+	return &connector.InterceptResult{InterceptInfo: ii}, nil
+}
+
+func (f *fakeConnector) RemoveIntercept(_ context.Context, rr *manager.RemoveInterceptRequest2) (*connector.InterceptResult, error) {
+	for i, ii := range f.intercepts {
+		if ii.Spec.Name == rr.Name {
+			f.intercepts = append(f.intercepts[:i], f.intercepts[i+1:]...)
+			return &connector.InterceptResult{}, nil
+		}
+	}
+	return &connector.InterceptResult{Error: connector.InterceptError_NOT_FOUND, ErrorText: rr.Name}, nil
+}
+
+func TestHandler(t *testing.T) {
+	fc := &fakeConnector{}
+	srv := httptest.NewServer(NewHandler(fc, "secret"))
+	defer srv.Close()
+
+	do := func(method, path, token, body string) (int, string) {
+		t.Helper()
+		rq, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		require.NoError(t, err)
+		if token != "" {
+			rq.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(rq)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		data, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp.StatusCode, string(data)
+	}
+
+	code, _ := do(http.MethodGet, "/v1/intercepts", "", "")
+	assert.Equal(t, http.StatusUnauthorized, code)
+	code, _ = do(http.MethodGet, "/v1/intercepts", "wrong", "")
+	assert.Equal(t, http.StatusUnauthorized, code)
+	code, _ = do(http.MethodGet, "/v1/nothing", "secret", "")
+	assert.Equal(t, http.StatusNotFound, code)
+	code, _ = do(http.MethodPut, "/v1/intercepts", "secret", "")
+	assert.Equal(t, http.StatusMethodNotAllowed, code)
+
+	code, _ = do(http.MethodPost, "/v1/intercepts", "secret", "{")
+	assert.Equal(t, http.StatusBadRequest, code)
+	create := `{"spec": {"name": "echo", "agent": "echo", "targetPort": 8080}}`
+	code, body := do(http.MethodPost, "/v1/intercepts", "secret", create)
+	require.Equal(t, http.StatusCreated, code)
+	result := &connector.InterceptResult{}
+	require.NoError(t, protojson.Unmarshal([]byte(body), result))
+	assert.Equal(t, "1:echo", result.InterceptInfo.Id)
+	code, _ = do(http.MethodPost, "/v1/intercepts", "secret", create)
+	assert.Equal(t, http.StatusConflict, code)
+
+	code, body = do(http.MethodGet, "/v1/intercepts/echo", "secret", "")
+	require.Equal(t, http.StatusOK, code)
+	ii := &manager.InterceptInfo{}
+	require.NoError(t, protojson.Unmarshal([]byte(body), ii))
+	assert.Equal(t, int32(8080), ii.Spec.TargetPort)
+
+	code, body = do(http.MethodGet, "/v1/intercepts", "secret", "")
+	require.Equal(t, http.StatusOK, code)
+	is := &manager.InterceptInfoSnapshot{}
+	require.NoError(t, protojson.Unmarshal([]byte(body), is))
+	assert.Len(t, is.Intercepts, 1)
+
+	code, _ = do(http.MethodDelete, "/v1/intercepts/echo", "secret", "")
+	assert.Equal(t, http.StatusOK, code)
+	code, _ = do(http.MethodDelete, "/v1/intercepts/echo", "secret", "")
+	assert.Equal(t, http.StatusNotFound, code)
+	code, _ = do(http.MethodGet, "/v1/intercepts/echo", "secret", "")
+	assert.Equal(t, http.StatusNotFound, code)
+
+	code, _ = do(http.MethodGet, "/v1/workloads?namespace=default&filter=interceptable", "secret", "")
+	assert.Equal(t, http.StatusOK, code)
+	require.NotNil(t, fc.lastList)
+	assert.Equal(t, "default", fc.lastList.Namespace)
+	assert.Equal(t, connector.ListRequest_INTERCEPTABLE, fc.lastList.Filter)
+	code, _ = do(http.MethodGet, "/v1/workloads?filter=bogus", "secret", "")
+	assert.Equal(t, http.StatusBadRequest, code)
+}
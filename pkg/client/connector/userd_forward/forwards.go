@@ -0,0 +1,236 @@
+package userd_forward
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	grpcCodes "google.golang.org/grpc/codes"
+	grpcStatus "google.golang.org/grpc/status"
+	empty "google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/datawire/dlib/dlog"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/connector/sharedstate"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/connector/userd_k8s"
+	"github.com/telepresenceio/telepresence/v2/pkg/dnet"
+)
+
+type dialFunc func(context.Context, string) (net.Conn, error)
+
+type forward struct {
+	Forward
+	listener    net.Listener
+	connections uint64
+	lastError   atomic.Value
+}
+
+type forwards struct {
+	rpc.UnimplementedForwardsServer
+	ctx         context.Context
+	sharedState *sharedstate.State
+
+	// dialer returns the dialer to use for a new connection
+	dialer func() (dialFunc, error)
+
+	mu       sync.Mutex
+	cluster  *userd_k8s.Cluster
+	dial     dialFunc
+	forwards map[uint16]*forward
+}
+
+// NewForwardsServer returns a server that forwards ports on localhost to the cluster using the
+// Kubernetes port-forward API, so that they work without the routing of the root daemon. The
+// dialer and the pod behind a service are looked up for each connection, so a forward keeps
+// working when the connector reconnects or the pods of the service are replaced. The forwards end
+// when the given context is cancelled.
+func NewForwardsServer(ctx context.Context, sharedState *sharedstate.State) rpc.ForwardsServer {
+	fs := &forwards{
+		ctx:         ctx,
+		sharedState: sharedState,
+		forwards:    make(map[uint16]*forward),
+	}
+	fs.dialer = fs.clusterDialer
+	return fs
+}
+
+// clusterDialer returns a port-forward dialer for the cluster that the connector is currently
+// connected to. The dialer is reused until the cluster changes.
+func (fs *forwards) clusterDialer() (dialFunc, error) {
+	cluster := fs.sharedState.GetClusterNonBlocking()
+	if cluster == nil {
+		return nil, errors.New("the userd is not connected to the cluster")
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.cluster != cluster {
+		dial, err := dnet.NewK8sPortForwardDialer(cluster.ConfigFlags, cluster.Client())
+		if err != nil {
+			return nil, err
+		}
+		fs.cluster = cluster
+		fs.dial = dial
+	}
+	return fs.dial, nil
+}
+
+func (fs *forwards) AddForward(ctx context.Context, rq *rpc.Forward) (*empty.Empty, error) {
+	if rq.Service == "" || rq.LocalPort <= 0 || rq.LocalPort > 0xffff {
+		return nil, grpcStatus.Error(grpcCodes.InvalidArgument, "a forward needs a service and a valid local port")
+	}
+	f := forwardFromRPC(rq)
+	f.Connections, f.LastError = 0, ""
+	cluster := fs.sharedState.GetClusterNonBlocking()
+	if cluster == nil {
+		return nil, grpcStatus.Error(grpcCodes.FailedPrecondition, "telepresence: the userd is not connected to the cluster")
+	}
+	if f.Namespace == "" {
+		f.Namespace = cluster.Namespace
+	}
+	if f.Port == "" {
+		f.Port = strconv.Itoa(int(f.LocalPort))
+	}
+	if err := fs.add(ctx, f); err != nil {
+		return nil, err
+	}
+	return &empty.Empty{}, nil
+}
+
+func (fs *forwards) add(ctx context.Context, f *Forward) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if old, ok := fs.forwards[f.LocalPort]; ok {
+		if old.Service == f.Service && old.Namespace == f.Namespace && old.Port == f.Port {
+			return nil
+		}
+		return grpcStatus.Errorf(grpcCodes.AlreadyExists, "port %d is already forwarded to %s.%s", f.LocalPort, old.Service, old.Namespace)
+	}
+	l, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(int(f.LocalPort))))
+	if err != nil {
+		return grpcStatus.Error(grpcCodes.Unavailable, err.Error())
+	}
+	fw := &forward{Forward: *f, listener: l}
+	fs.forwards[f.LocalPort] = fw
+	go fs.serve(fw)
+	dlog.Infof(ctx, "Forwarding %s", f)
+	return nil
+}
+
+func (fs *forwards) RemoveForward(ctx context.Context, rq *rpc.ForwardPort) (*empty.Empty, error) {
+	port := uint16(rq.LocalPort)
+	fs.mu.Lock()
+	fw, ok := fs.forwards[port]
+	delete(fs.forwards, port)
+	fs.mu.Unlock()
+	if !ok {
+		return nil, grpcStatus.Errorf(grpcCodes.NotFound, "port %d is not forwarded", rq.LocalPort)
+	}
+	_ = fw.listener.Close()
+	dlog.Infof(ctx, "Removed forward %s", &fw.Forward)
+	return &empty.Empty{}, nil
+}
+
+func (fs *forwards) ListForwards(_ context.Context, _ *empty.Empty) (*rpc.ForwardList, error) {
+	fs.mu.Lock()
+	list := make([]*rpc.Forward, 0, len(fs.forwards))
+	for _, fw := range fs.forwards {
+		f := fw.Forward
+		f.Connections = atomic.LoadUint64(&fw.connections)
+		f.LastError, _ = fw.lastError.Load().(string)
+		list = append(list, f.toRPC())
+	}
+	fs.mu.Unlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].LocalPort < list[j].LocalPort })
+	return &rpc.ForwardList{Forwards: list}, nil
+}
+
+// serve accepts connections until the listener of the forward is closed, i.e. until the forward
+// is removed or the server's context is cancelled.
+func (fs *forwards) serve(fw *forward) {
+	ctx := fs.ctx
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = fw.listener.Close()
+		case <-done:
+		}
+	}()
+	addr := net.JoinHostPort("svc/"+fw.Service+"."+fw.Namespace, fw.Port)
+	for {
+		conn, err := fw.listener.Accept()
+		if err != nil {
+			fs.mu.Lock()
+			removed := fs.forwards[fw.LocalPort] != fw
+			fs.mu.Unlock()
+			if ctx.Err() == nil && !removed {
+				dlog.Errorf(ctx, "forward %s: %v", &fw.Forward, err)
+			}
+			return
+		}
+		atomic.AddUint64(&fw.connections, 1)
+		go func() {
+			if err := fs.forwardConn(ctx, conn, addr); err != nil {
+				fw.lastError.Store(err.Error())
+				dlog.Errorf(ctx, "forward %s: %v", &fw.Forward, err)
+			}
+		}()
+	}
+}
+
+func (fs *forwards) forwardConn(ctx context.Context, conn net.Conn, addr string) error {
+	defer conn.Close()
+	dial, err := fs.dialer()
+	if err != nil {
+		return fmt.Errorf("unable to dial %s: %w", addr, err)
+	}
+	tc, cancel := client.GetConfig(ctx).Timeouts.TimeoutContext(ctx, client.TimeoutProxyDial)
+	remote, err := dial(tc, addr)
+	cancel()
+	if err != nil {
+		return fmt.Errorf("unable to dial %s: %w", addr, err)
+	}
+	defer remote.Close()
+	pipe(ctx, conn, remote)
+	return nil
+}
+
+// pipe copies data in both directions between the given connections until both directions are
+// done or the context is cancelled. A direction is done when its source reaches EOF, and the
+// write side of its destination is then closed, so that a peer that half-closes its connection
+// still receives the response. A destination that can't be half-closed is closed entirely, and so
+// are both connections when a copy fails.
+func pipe(ctx context.Context, a, b net.Conn) {
+	done := make(chan struct{}, 2)
+	cp := func(dst, src net.Conn) {
+		_, err := io.Copy(dst, src)
+		cw, ok := dst.(interface{ CloseWrite() error })
+		switch {
+		case err != nil:
+			_ = src.Close()
+			_ = dst.Close()
+		case ok:
+			_ = cw.CloseWrite()
+		default:
+			_ = dst.Close()
+		}
+		done <- struct{}{}
+	}
+	go cp(b, a)
+	go cp(a, b)
+	for i := 0; i < 2; i++ {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package userd_forward
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	empty "google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/datawire/dlib/dlog"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/connector"
+)
+
+// replyServer starts a server that reads each request until the client half-closes the connection,
+// and then replies with the given prefix and the request.
+func replyServer(t *testing.T, prefix string) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				data, err := ioutil.ReadAll(conn)
+				if err == nil {
+					_, _ = conn.Write(append([]byte(prefix), data...))
+				}
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+func freePort(t *testing.T) uint16 {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	return uint16(l.Addr().(*net.TCPAddr).Port)
+}
+
+func request(t *testing.T, port uint16, msg string) string {
+	conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(int(port))))
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte(msg))
+	require.NoError(t, err)
+	require.NoError(t, conn.(*net.TCPConn).CloseWrite())
+	reply, err := ioutil.ReadAll(conn)
+	require.NoError(t, err)
+	return string(reply)
+}
+
+func TestForwards(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+
+	// The dialer is resolved for each connection, so that a new dialer is used once the connector
+	// reconnects to the cluster.
+	targets := []string{replyServer(t, "first: "), replyServer(t, "second: ")}
+	var dialers int32
+	var dialed atomic.Value
+	fs := &forwards{ctx: ctx, forwards: make(map[uint16]*forward)}
+	fs.dialer = func() (dialFunc, error) {
+		target := targets[atomic.AddInt32(&dialers, 1)-1]
+		return func(ctx context.Context, addr string) (net.Conn, error) {
+			dialed.Store(addr)
+			return (&net.Dialer{}).DialContext(ctx, "tcp", target)
+		}, nil
+	}
+
+	port := freePort(t)
+	fw := &Forward{Service: "echo", Namespace: "default", Port: "http", LocalPort: port}
+	require.NoError(t, fs.add(ctx, fw))
+	require.NoError(t, fs.add(ctx, fw), "adding the same forward again is a no-op")
+	err := fs.add(ctx, &Forward{Service: "other", Namespace: "default", Port: "http", LocalPort: port})
+	assert.Error(t, err, "the port is forwarded elsewhere")
+
+	// The request is half-closed, so the reply is only received if the forward doesn't close the
+	// connection when the request ends.
+	assert.Equal(t, "first: hello", request(t, port, "hello"))
+	assert.Equal(t, "svc/echo.default:http", dialed.Load())
+	assert.Equal(t, "second: again", request(t, port, "again"))
+
+	list, err := fs.ListForwards(ctx, &empty.Empty{})
+	require.NoError(t, err)
+	require.Len(t, list.Forwards, 1)
+	assert.Equal(t, uint64(2), list.Forwards[0].Connections)
+	assert.Empty(t, list.Forwards[0].LastError)
+
+	_, err = fs.RemoveForward(ctx, &rpc.ForwardPort{LocalPort: int32(port)})
+	require.NoError(t, err)
+	_, err = fs.RemoveForward(ctx, &rpc.ForwardPort{LocalPort: int32(port)})
+	assert.Error(t, err, "the forward is already removed")
+	_, err = net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(int(port))))
+	assert.Error(t, err, "the port is no longer forwarded")
+}
+
+func TestForwardRPC(t *testing.T) {
+	fw := &Forward{Service: "postgres", Namespace: "db", Port: "pg", LocalPort: 5433, Connections: 3, LastError: "boom"}
+	assert.Equal(t, fw, forwardFromRPC(fw.toRPC()))
+}
@@ -0,0 +1,79 @@
+package userd_forward
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/grpc"
+	empty "google.golang.org/protobuf/types/known/emptypb"
+
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/connector"
+)
+
+// Forward is a port on localhost that the user daemon forwards to a port of a service in the
+// cluster, in the manner of "kubectl port-forward svc/<service>".
+type Forward struct {
+	Service   string `json:"service" yaml:"service"`
+	Namespace string `json:"namespace,omitempty" yaml:"namespace,omitempty"`
+
+	// Port is the number or name of the port of the service. The LocalPort is used when it's empty.
+	Port string `json:"port,omitempty" yaml:"port,omitempty"`
+
+	LocalPort uint16 `json:"localPort" yaml:"localPort"`
+
+	// Connections is the number of connections that have been forwarded, and LastError is the error
+	// of the last connection that couldn't be forwarded. They are only set by ListForwards.
+	Connections uint64 `json:"connections,omitempty" yaml:"-"`
+	LastError   string `json:"lastError,omitempty" yaml:"-"`
+}
+
+func (f *Forward) String() string {
+	return fmt.Sprintf("localhost:%d -> %s.%s:%s", f.LocalPort, f.Service, f.Namespace, f.Port)
+}
+
+func (f *Forward) toRPC() *rpc.Forward {
+	return &rpc.Forward{
+		Service:     f.Service,
+		Namespace:   f.Namespace,
+		Port:        f.Port,
+		LocalPort:   int32(f.LocalPort),
+		Connections: f.Connections,
+		LastError:   f.LastError,
+	}
+}
+
+func forwardFromRPC(f *rpc.Forward) *Forward {
+	return &Forward{
+		Service:     f.Service,
+		Namespace:   f.Namespace,
+		Port:        f.Port,
+		LocalPort:   uint16(f.LocalPort),
+		Connections: f.Connections,
+		LastError:   f.LastError,
+	}
+}
+
+// AddForward calls the AddForward method of the Forwards service using the given connection.
+func AddForward(ctx context.Context, conn grpc.ClientConnInterface, f *Forward, opts ...grpc.CallOption) error {
+	_, err := rpc.NewForwardsClient(conn).AddForward(ctx, f.toRPC(), opts...)
+	return err
+}
+
+// RemoveForward calls the RemoveForward method of the Forwards service using the given connection.
+func RemoveForward(ctx context.Context, conn grpc.ClientConnInterface, localPort uint16, opts ...grpc.CallOption) error {
+	_, err := rpc.NewForwardsClient(conn).RemoveForward(ctx, &rpc.ForwardPort{LocalPort: int32(localPort)}, opts...)
+	return err
+}
+
+// ListForwards calls the ListForwards method of the Forwards service using the given connection.
+func ListForwards(ctx context.Context, conn grpc.ClientConnInterface, opts ...grpc.CallOption) ([]*Forward, error) {
+	list, err := rpc.NewForwardsClient(conn).ListForwards(ctx, &empty.Empty{}, opts...)
+	if err != nil {
+		return nil, err
+	}
+	forwards := make([]*Forward, len(list.Forwards))
+	for i, f := range list.Forwards {
+		forwards[i] = forwardFromRPC(f)
+	}
+	return forwards, nil
+}
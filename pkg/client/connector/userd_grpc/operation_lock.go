@@ -0,0 +1,96 @@
+package userd_grpc
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	grpcCodes "google.golang.org/grpc/codes"
+	grpcStatus "google.golang.org/grpc/status"
+
+	"github.com/datawire/dlib/dlog"
+)
+
+// maxOperationWait is how long an operation waits for the one in progress before it gives up
+const maxOperationWait = 5 * time.Minute
+
+// operationLock serializes the operations that change the state of the session, so that commands
+// issued concurrently from several terminals, or by scripts that run telepresence in parallel,
+// are applied one at a time in the order that they arrive.
+type operationLock struct {
+	sem chan struct{}
+
+	mu      sync.Mutex
+	current string
+	since   time.Time
+}
+
+func newOperationLock() *operationLock {
+	return &operationLock{sem: make(chan struct{}, 1)}
+}
+
+// acquire waits until no other operation is in progress and returns the function that ends the
+// named operation. Goroutines that are blocked on a channel are woken in the order they blocked,
+// so waiting operations run in the order that they arrived. An Aborted error that names the
+// operation in progress is returned when the wait exceeds maxWait.
+func (ol *operationLock) acquire(c context.Context, name string, maxWait time.Duration) (func(), error) {
+	select {
+	case ol.sem <- struct{}{}:
+	default:
+		current, since := ol.inProgress()
+		dlog.Infof(c, "%s waits for %s, in progress since %s", name, current, since.Format(time.RFC3339))
+		timer := time.NewTimer(maxWait)
+		defer timer.Stop()
+		select {
+		case ol.sem <- struct{}{}:
+		case <-c.Done():
+			return nil, c.Err()
+		case <-timer.C:
+			current, since = ol.inProgress()
+			return nil, grpcStatus.Errorf(grpcCodes.Aborted,
+				"operation in progress: %s has been running for %s, try again later",
+				current, time.Since(since).Round(time.Second))
+		}
+	}
+	ol.mu.Lock()
+	ol.current = name
+	ol.since = time.Now()
+	ol.mu.Unlock()
+	return func() {
+		ol.mu.Lock()
+		ol.current = ""
+		ol.mu.Unlock()
+		<-ol.sem
+	}, nil
+}
+
+func (ol *operationLock) inProgress() (string, time.Time) {
+	ol.mu.Lock()
+	defer ol.mu.Unlock()
+	return ol.current, ol.since
+}
+
+// acquireYieldable is like acquire, but also returns the function that a queued intercept yields
+// the operation with, see userd_intercept.WithYield. The returned release function ends the
+// operation unless it's yielded and not resumed.
+func (ol *operationLock) acquireYieldable(c context.Context, name string, maxWait time.Duration) (func(), func() func(context.Context) error, error) {
+	release, err := ol.acquire(c, name, maxWait)
+	if err != nil {
+		return nil, nil, err
+	}
+	held := release
+	yield := func() func(context.Context) error {
+		dlog.Debugf(c, "%s yields while it's queued", name)
+		held()
+		held = func() {}
+		return func(c context.Context) error {
+			release, err := ol.acquire(c, name, maxWait)
+			if err != nil {
+				return err
+			}
+			held = release
+			return nil
+		}
+	}
+	return func() { held() }, yield, nil
+}
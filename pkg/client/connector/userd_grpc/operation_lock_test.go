@@ -0,0 +1,96 @@
+package userd_grpc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	grpcCodes "google.golang.org/grpc/codes"
+	grpcStatus "google.golang.org/grpc/status"
+
+	"github.com/datawire/dlib/dlog"
+)
+
+func TestOperationLock(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	ol := newOperationLock()
+
+	release, err := ol.acquire(ctx, "first", time.Second)
+	require.NoError(t, err)
+
+	// A concurrent operation gives up and names the one in progress
+	_, err = ol.acquire(ctx, "second", 10*time.Millisecond)
+	require.Error(t, err)
+	assert.Equal(t, grpcCodes.Aborted, grpcStatus.Code(err))
+	assert.Contains(t, err.Error(), "first")
+
+	// Waiting operations run in the order they arrived
+	order := make(chan string, 2)
+	for _, name := range []string{"second", "third"} {
+		name := name
+		go func() {
+			release, err := ol.acquire(ctx, name, time.Second)
+			if err == nil {
+				order <- name
+				release()
+			}
+		}()
+		time.Sleep(10 * time.Millisecond)
+	}
+	release()
+	assert.Equal(t, "second", <-order)
+	assert.Equal(t, "third", <-order)
+
+	// A cancelled context ends the wait
+	release, err = ol.acquire(ctx, "fourth", time.Second)
+	require.NoError(t, err)
+	defer release()
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = ol.acquire(cctx, "fifth", time.Second)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestOperationLockYield(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	ol := newOperationLock()
+
+	release, yield, err := ol.acquireYieldable(ctx, "create intercept", time.Second)
+	require.NoError(t, err)
+	_, err = ol.acquire(ctx, "remove intercept", 10*time.Millisecond)
+	require.Error(t, err)
+
+	// Other operations run while the intercept is queued
+	resume := yield()
+	removeRelease, err := ol.acquire(ctx, "remove intercept", 10*time.Millisecond)
+	require.NoError(t, err)
+
+	// The operation resumes once the other one is done
+	resumed := make(chan error, 1)
+	go func() { resumed <- resume(ctx) }()
+	select {
+	case <-resumed:
+		t.Fatal("resumed while another operation is in progress")
+	case <-time.After(20 * time.Millisecond):
+	}
+	removeRelease()
+	require.NoError(t, <-resumed)
+	current, _ := ol.inProgress()
+	assert.Equal(t, "create intercept", current)
+
+	release()
+	release, err = ol.acquire(ctx, "next", 10*time.Millisecond)
+	require.NoError(t, err)
+	release()
+
+	// A yielded operation that isn't resumed has nothing to release
+	release, yield, err = ol.acquireYieldable(ctx, "create intercept", time.Second)
+	require.NoError(t, err)
+	yield()
+	release()
+	release, err = ol.acquire(ctx, "next", 10*time.Millisecond)
+	require.NoError(t, err)
+	release()
+}
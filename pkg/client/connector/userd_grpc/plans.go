@@ -0,0 +1,36 @@
+package userd_grpc
+
+import (
+	"context"
+
+	grpcCodes "google.golang.org/grpc/codes"
+	grpcStatus "google.golang.org/grpc/status"
+
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/connector/sharedstate"
+)
+
+// interceptPlanner lets the CLI ask what an intercept would do, so that "telepresence intercept
+// --dry-run" can report it without changing anything.
+type interceptPlanner struct {
+	connector.UnimplementedInterceptPlansServer
+	sharedState *sharedstate.State
+}
+
+func NewInterceptPlanner(sharedState *sharedstate.State) connector.InterceptPlansServer {
+	return &interceptPlanner{sharedState: sharedState}
+}
+
+func (p *interceptPlanner) PlanIntercept(ctx context.Context, ir *connector.CreateInterceptRequest) (*connector.InterceptPlan, error) {
+	if ir.GetSpec().GetAgent() == "" {
+		return nil, grpcStatus.Error(grpcCodes.InvalidArgument, "only an intercept of a workload can be planned")
+	}
+	mgr, err := p.sharedState.GetTrafficManagerBlocking(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if mgr == nil {
+		return nil, grpcStatus.Error(grpcCodes.FailedPrecondition, "telepresence: the userd is not connected to the manager")
+	}
+	return mgr.PlanIntercept(ctx, ir)
+}
@@ -19,6 +19,8 @@ import (
 	"github.com/telepresenceio/telepresence/v2/pkg/client"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/connector/sharedstate"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/connector/userd_auth"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/connector/userd_intercept"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/logging"
 )
 
 type Callbacks struct {
@@ -33,6 +35,9 @@ type service struct {
 	callbacks   Callbacks
 	sharedState *sharedstate.State
 
+	// ops serializes the calls that change the state of the session
+	ops *operationLock
+
 	ucn int64
 }
 
@@ -43,12 +48,14 @@ func NewGRPCService(
 	return &service{
 		callbacks:   callbacks,
 		sharedState: sharedState,
+		ops:         newOperationLock(),
 	}
 }
 
 func callRecovery(c context.Context, r interface{}, err error) error {
 	perr := derror.PanicToError(r)
 	if perr != nil {
+		logging.ReportCrash(c, "connector", perr)
 		if err == nil {
 			err = perr
 		} else {
@@ -75,6 +82,11 @@ func (s *service) Version(_ context.Context, _ *empty.Empty) (*common.VersionInf
 func (s *service) Connect(c context.Context, cr *rpc.ConnectRequest) (ci *rpc.ConnectInfo, err error) {
 	c = s.callCtx(c, "Connect")
 	defer func() { err = callRecovery(c, recover(), err) }()
+	release, err := s.ops.acquire(c, "connect", maxOperationWait)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
 	return s.callbacks.Connect(c, cr, false), nil
 }
 
@@ -91,11 +103,16 @@ func (s *service) CreateIntercept(c context.Context, ir *rpc.CreateInterceptRequ
 	}
 	c = s.callCtx(c, "CreateIntercept")
 	defer func() { err = callRecovery(c, recover(), err) }()
+	release, yield, err := s.ops.acquireYieldable(c, fmt.Sprintf("create intercept %q", ir.GetSpec().GetName()), maxOperationWait)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
 	mgr, err := s.sharedState.GetTrafficManagerBlocking(c)
 	if mgr == nil {
 		return nil, err
 	}
-	return mgr.AddIntercept(c, ir)
+	return mgr.AddIntercept(userd_intercept.WithYield(c, yield), ir)
 }
 
 func (s *service) RemoveIntercept(c context.Context, rr *manager.RemoveInterceptRequest2) (result *rpc.InterceptResult, err error) {
@@ -105,6 +122,11 @@ func (s *service) RemoveIntercept(c context.Context, rr *manager.RemoveIntercept
 	}
 	c = s.callCtx(c, "RemoveIntercept")
 	defer func() { err = callRecovery(c, recover(), err) }()
+	release, err := s.ops.acquire(c, fmt.Sprintf("remove intercept %q", rr.Name), maxOperationWait)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
 	mgr, err := s.sharedState.GetTrafficManagerBlocking(c)
 	if mgr == nil {
 		return nil, err
@@ -130,6 +152,11 @@ func (s *service) List(ctx context.Context, lr *rpc.ListRequest) (*rpc.WorkloadI
 func (s *service) Uninstall(c context.Context, ur *rpc.UninstallRequest) (result *rpc.UninstallResult, err error) {
 	c = s.callCtx(c, "Uninstall")
 	defer func() { err = callRecovery(c, recover(), err) }()
+	release, err := s.ops.acquire(c, "uninstall", maxOperationWait)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
 	mgr, err := s.sharedState.GetTrafficManagerBlocking(c)
 	if mgr == nil {
 		return nil, err
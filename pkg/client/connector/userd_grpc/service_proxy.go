@@ -19,9 +19,10 @@ import (
 // Use the `SetClient` method safely adjust the client at runtime.  MgrProxy does not need
 // initialized; the zero value works fine.
 type MgrProxy struct {
-	mu          sync.RWMutex
-	client      managerrpc.ManagerClient
-	callOptions []grpc.CallOption
+	mu           sync.RWMutex
+	client       managerrpc.ManagerClient
+	callOptions  []grpc.CallOption
+	onLookupHost func(string)
 
 	managerrpc.UnsafeManagerServer
 }
@@ -32,6 +33,14 @@ func (p *MgrProxy) SetClient(client managerrpc.ManagerClient, callOptions ...grp
 	p.client, p.callOptions = client, callOptions
 }
 
+// SetLookupHostHook sets a function that is called, in a goroutine of its own, with the host of
+// each LookupHost request, i.e. with the names that the DNS resolver of the daemon looks up.
+func (p *MgrProxy) SetLookupHostHook(hook func(host string)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.onLookupHost = hook
+}
+
 func (p *MgrProxy) get() (managerrpc.ManagerClient, []grpc.CallOption, error) {
 	p.mu.RLock()
 	defer p.mu.RUnlock()
@@ -220,6 +229,12 @@ func (p *MgrProxy) LookupHost(ctx context.Context, arg *managerrpc.LookupHostReq
 	if err != nil {
 		return nil, err
 	}
+	p.mu.RLock()
+	hook := p.onLookupHost
+	p.mu.RUnlock()
+	if hook != nil {
+		go hook(arg.Host)
+	}
 	return client.LookupHost(ctx, arg, callOptions...)
 }
 
@@ -0,0 +1,49 @@
+package userd_grpc
+
+import (
+	"context"
+
+	grpcCodes "google.golang.org/grpc/codes"
+	grpcStatus "google.golang.org/grpc/status"
+	empty "google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/connector/sharedstate"
+)
+
+// sessionsProxy lets the CLI reach the Sessions service of the traffic-manager. The calls are made
+// using the Kubernetes identity of the user, so that the traffic-manager can tell if the user is an
+// admin.
+type sessionsProxy struct {
+	manager.UnimplementedSessionsServer
+	sharedState *sharedstate.State
+}
+
+func NewSessionsProxy(sharedState *sharedstate.State) manager.SessionsServer {
+	return &sessionsProxy{sharedState: sharedState}
+}
+
+func (p *sessionsProxy) ListSessions(ctx context.Context, _ *empty.Empty) (*manager.ClientSessions, error) {
+	mgr, err := p.sharedState.GetTrafficManagerBlocking(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if mgr == nil {
+		return nil, grpcStatus.Error(grpcCodes.FailedPrecondition, "telepresence: the userd is not connected to the manager")
+	}
+	return mgr.ListSessions(ctx)
+}
+
+func (p *sessionsProxy) KickSession(ctx context.Context, session *manager.SessionInfo) (*empty.Empty, error) {
+	mgr, err := p.sharedState.GetTrafficManagerBlocking(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if mgr == nil {
+		return nil, grpcStatus.Error(grpcCodes.FailedPrecondition, "telepresence: the userd is not connected to the manager")
+	}
+	if err = mgr.KickSession(ctx, session.GetSessionId()); err != nil {
+		return nil, err
+	}
+	return &empty.Empty{}, nil
+}
@@ -0,0 +1,39 @@
+package userd_intercept
+
+import (
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+)
+
+// What an intercept does to the traffic-agent of the intercepted workload.
+const (
+	// AgentUpToDate means that the workload already has an up-to-date traffic-agent.
+	AgentUpToDate = "up-to-date"
+
+	// AgentAdd means that the traffic-agent is added to the pod template of the workload.
+	AgentAdd = "add"
+
+	// AgentUpgrade means that the image of the traffic-agent of the workload is changed.
+	AgentUpgrade = "upgrade"
+
+	// AgentInjected means that the traffic-manager injects the traffic-agent when the pods are
+	// created, so the workload isn't changed.
+	AgentInjected = "injected"
+
+	// AgentEnableInjection means that the workload is annotated so that the traffic-manager injects
+	// the traffic-agent into the pods of its new revision.
+	AgentEnableInjection = "enable-injection"
+)
+
+// Blocked returns true if the given plan has a conflict, or if a permission that isn't optional is
+// missing.
+func Blocked(p *connector.InterceptPlan) bool {
+	if len(p.Conflicts) > 0 {
+		return true
+	}
+	for _, pm := range p.Permissions {
+		if !(pm.Allowed || pm.Optional) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,24 @@
+package userd_intercept
+
+import (
+	"context"
+)
+
+type yieldKey struct{}
+
+// WithYield returns a context that lets the creation of an intercept step aside while it's queued
+// behind the intercept of another client, so that other operations of the user daemon can run in
+// the meantime. The given function ends the operation that the creation is a part of, and returns
+// the function that resumes it once the intercept is granted.
+func WithYield(c context.Context, yield func() func(context.Context) error) context.Context {
+	return context.WithValue(c, yieldKey{}, yield)
+}
+
+// Yield ends the operation of the given context, if it can be ended, and returns the function that
+// resumes it.
+func Yield(c context.Context) func(context.Context) error {
+	if yield, ok := c.Value(yieldKey{}).(func() func(context.Context) error); ok {
+		return yield()
+	}
+	return func(context.Context) error { return nil }
+}
@@ -0,0 +1,41 @@
+package userd_k8s
+
+import (
+	"context"
+
+	authv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// CanI uses a SelfSubjectAccessReview to check if the current user is allowed to perform the given
+// verb on the given resource. An empty namespace means all namespaces.
+func (kc *Cluster) CanI(c context.Context, verb, group, resource, namespace string) (bool, error) {
+	cs, err := kubernetes.NewForConfig(kc.restConfig())
+	if err != nil {
+		return false, err
+	}
+	ar, err := cs.AuthorizationV1().SelfSubjectAccessReviews().Create(c, &authv1.SelfSubjectAccessReview{
+		Spec: authv1.SelfSubjectAccessReviewSpec{
+			ResourceAttributes: &authv1.ResourceAttributes{
+				Namespace: namespace,
+				Verb:      verb,
+				Group:     group,
+				Resource:  resource,
+			},
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return false, err
+	}
+	return ar.Status.Allowed, nil
+}
+
+// staticNamespaces returns the namespaces to use when the user isn't allowed to watch namespaces. These
+// are the mapped namespaces if any, or else the namespace of the current kubeconfig context.
+func (kc *Cluster) staticNamespaces() []string {
+	if len(kc.mappedNamespaces) > 0 {
+		return kc.mappedNamespaces
+	}
+	return []string{kc.Namespace}
+}
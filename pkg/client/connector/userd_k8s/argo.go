@@ -0,0 +1,46 @@
+package userd_k8s
+
+import (
+	"context"
+
+	"github.com/datawire/ambassador/pkg/kates"
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/install"
+)
+
+// argoRolloutKind is the kind of an Argo Rollout qualified with its group, so that it can't be
+// mistaken for a kind of another API.
+const argoRolloutKind = "Rollout.argoproj.io"
+
+// IsArgoRollouts returns true if Argo Rollouts is installed in the cluster. The result is cached.
+func (kc *Cluster) IsArgoRollouts(c context.Context) bool {
+	kc.argoRolloutsOnce.Do(func() {
+		var err error
+		if kc.argoRollouts, err = install.IsArgoRollouts(kc.restConfig()); err != nil {
+			dlog.Errorf(c, "unable to determine if Argo Rollouts is installed: %v", err)
+		}
+	})
+	return kc.argoRollouts
+}
+
+// ArgoRolloutNames returns the names of all Argo Rollouts found in the given Namespace. The result
+// is always empty when Argo Rollouts isn't installed.
+func (kc *Cluster) ArgoRolloutNames(c context.Context, namespace string) ([]string, error) {
+	if !kc.IsArgoRollouts(c) {
+		return nil, nil
+	}
+	return kc.kindNames(c, argoRolloutKind, namespace)
+}
+
+// FindArgoRollout returns the Argo Rollout with the given name in the given namespace.
+func (kc *Cluster) FindArgoRollout(c context.Context, namespace, name string) (*kates.Unstructured, error) {
+	ro := &kates.Unstructured{}
+	ro.SetAPIVersion(install.ArgoRolloutAPIVersion)
+	ro.SetKind("Rollout")
+	ro.SetNamespace(namespace)
+	ro.SetName(name)
+	if err := kc.Client().Get(c, ro, ro); err != nil {
+		return nil, err
+	}
+	return ro, nil
+}
@@ -0,0 +1,404 @@
+package userd_k8s
+
+import (
+	"context"
+	"sync"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	errors2 "k8s.io/apimachinery/pkg/api/errors"
+
+	"github.com/datawire/ambassador/pkg/kates"
+	"github.com/datawire/dlib/derror"
+	"github.com/datawire/dlib/dlog"
+	client2 "github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/install"
+)
+
+// workloadSnapshot contains the objects of a namespace that are needed when listing workloads. The
+// field names must match the names of the queries of the watch that updates it.
+type workloadSnapshot struct {
+	Services     []*kates.Service
+	Deployments  []*kates.Deployment
+	ReplicaSets  []*kates.ReplicaSet
+	StatefulSets []*kates.StatefulSet
+	Pods         []*kates.Pod
+}
+
+// names returns the names of the objects of the given kind, or false if the kind isn't cached
+func (s *workloadSnapshot) names(kind string) ([]string, bool) {
+	var names []string
+	switch kind {
+	case "Deployment":
+		names = make([]string, len(s.Deployments))
+		for i, o := range s.Deployments {
+			names[i] = o.Name
+		}
+	case "ReplicaSet":
+		names = make([]string, len(s.ReplicaSets))
+		for i, o := range s.ReplicaSets {
+			names[i] = o.Name
+		}
+	case "StatefulSet":
+		names = make([]string, len(s.StatefulSets))
+		for i, o := range s.StatefulSets {
+			names[i] = o.Name
+		}
+	case "Pod":
+		names = make([]string, len(s.Pods))
+		for i, o := range s.Pods {
+			names[i] = o.Name
+		}
+	default:
+		return nil, false
+	}
+	return names, true
+}
+
+// byNamespace splits the snapshot into one snapshot per namespace.
+func (s *workloadSnapshot) byNamespace() map[string]*workloadSnapshot {
+	m := make(map[string]*workloadSnapshot)
+	get := func(namespace string) *workloadSnapshot {
+		ns, ok := m[namespace]
+		if !ok {
+			ns = &workloadSnapshot{}
+			m[namespace] = ns
+		}
+		return ns
+	}
+	for _, o := range s.Services {
+		ns := get(o.Namespace)
+		ns.Services = append(ns.Services, o)
+	}
+	for _, o := range s.Deployments {
+		ns := get(o.Namespace)
+		ns.Deployments = append(ns.Deployments, o)
+	}
+	for _, o := range s.ReplicaSets {
+		ns := get(o.Namespace)
+		ns.ReplicaSets = append(ns.ReplicaSets, o)
+	}
+	for _, o := range s.StatefulSets {
+		ns := get(o.Namespace)
+		ns.StatefulSets = append(ns.StatefulSets, o)
+	}
+	for _, o := range s.Pods {
+		ns := get(o.Namespace)
+		ns.Pods = append(ns.Pods, o)
+	}
+	return m
+}
+
+// cachedResources are the resources that the user must be allowed to watch in a namespace for it
+// to be cached
+var cachedResources = []struct{ group, resource string }{
+	{"", "services"},
+	{"apps", "deployments"},
+	{"apps", "replicasets"},
+	{"apps", "statefulsets"},
+	{"", "pods"},
+}
+
+// nsCache is a workloadSnapshot of one namespace that a watch keeps current.
+type nsCache struct {
+	cancel   context.CancelFunc
+	lock     sync.RWMutex
+	synced   bool
+	snapshot workloadSnapshot
+}
+
+// clusterCache is a workloadSnapshot of all namespaces that one cluster-scoped watch keeps current.
+// It's used instead of one nsCache per namespace when the user is allowed to watch the cached
+// resources cluster-wide, so that the number of watches doesn't grow with the number of namespaces.
+type clusterCache struct {
+	lock       sync.RWMutex
+	synced     bool
+	all        workloadSnapshot
+	namespaces map[string]*workloadSnapshot
+}
+
+// runWatch keeps the given snapshot current with the cached resources of the given namespace, or
+// of all namespaces when it's empty, until the given context is cancelled. The given function is
+// called with the lock held after each update. A watch that fails, e.g. because the API server
+// couldn't be reached when it started, is restarted as often as the given breaker allows. The
+// snapshot is kept in the meantime.
+func runWatch(c context.Context, client func() *kates.Client, breaker *client2.Breaker, namespace string,
+	lock sync.Locker, snapshot *workloadSnapshot, updated func()) {
+	what := "watch of namespace " + namespace
+	if namespace == "" {
+		what = "cluster-scoped watch"
+	}
+	_ = client2.RetryWithBreaker(c, what, breaker, func(c context.Context) error {
+		// The client is obtained for each attempt, so that a watch that failed because the
+		// credentials expired is restarted with the reloaded ones.
+		return watch(c, client(), what, namespace, lock, snapshot, updated)
+	}, watchRetryDelay, watchMaxRetryDelay)
+}
+
+func watch(c context.Context, client *kates.Client, what, namespace string,
+	lock sync.Locker, snapshot *workloadSnapshot, updated func()) (err error) {
+	defer func() {
+		if err = derror.PanicToError(recover()); err != nil {
+			dlog.Errorf(c, "%s failed: %v", what, err)
+		}
+	}()
+
+	acc := client.Watch(c,
+		kates.Query{Name: "Services", Kind: "Service", Namespace: namespace},
+		kates.Query{Name: "Deployments", Kind: "Deployment", Namespace: namespace},
+		kates.Query{Name: "ReplicaSets", Kind: "ReplicaSet", Namespace: namespace},
+		kates.Query{Name: "StatefulSets", Kind: "StatefulSet", Namespace: namespace},
+		kates.Query{Name: "Pods", Kind: "Pod", Namespace: namespace},
+	)
+	for {
+		select {
+		case <-c.Done():
+			return nil
+		case <-acc.Changed():
+			lock.Lock()
+			acc.Update(snapshot)
+			updated()
+			lock.Unlock()
+		}
+	}
+}
+
+func (nc *nsCache) run(c context.Context, client func() *kates.Client, breaker *client2.Breaker, namespace string) {
+	runWatch(c, client, breaker, namespace, &nc.lock, &nc.snapshot, func() {
+		if !nc.synced {
+			nc.synced = true
+			dlog.Debugf(c, "namespace %s is cached", namespace)
+		}
+	})
+}
+
+func (cc *clusterCache) run(c context.Context, client func() *kates.Client, breaker *client2.Breaker) {
+	runWatch(c, client, breaker, "", &cc.lock, &cc.all, func() {
+		cc.namespaces = cc.all.byNamespace()
+		if !cc.synced {
+			cc.synced = true
+			dlog.Debug(c, "all namespaces are cached")
+		}
+	})
+}
+
+// runWorkloadCaches maintains the workload caches until the given context is cancelled. One
+// clusterCache is used when the user is allowed to watch the cached resources cluster-wide, and
+// otherwise one nsCache for each mapped namespace.
+func (kc *Cluster) runWorkloadCaches(c context.Context) error {
+	if kc.canCache(c, "") {
+		cc := &clusterCache{}
+		kc.cacheLock.Lock()
+		kc.clusterCache = cc
+		kc.cacheLock.Unlock()
+		defer func() {
+			kc.cacheLock.Lock()
+			kc.clusterCache = nil
+			kc.cacheLock.Unlock()
+		}()
+		cc.run(c, kc.Client, kc.watchBreaker())
+		return nil
+	}
+
+	defer func() {
+		kc.cacheLock.Lock()
+		for ns, nc := range kc.caches {
+			nc.cancel()
+			delete(kc.caches, ns)
+		}
+		kc.cacheLock.Unlock()
+	}()
+	for {
+		kc.refreshWorkloadCaches(c)
+		select {
+		case <-c.Done():
+			return nil
+		case <-kc.nsChanged:
+		}
+	}
+}
+
+func (kc *Cluster) refreshWorkloadCaches(c context.Context) {
+	kc.accLock.Lock()
+	namespaces := kc.activeNamespacesLocked(c)
+	kc.accLock.Unlock()
+
+	keep := make(map[string]struct{}, len(namespaces))
+	var added []string
+	kc.cacheLock.Lock()
+	for _, ns := range namespaces {
+		keep[ns] = struct{}{}
+		if _, ok := kc.caches[ns]; !ok {
+			added = append(added, ns)
+		}
+	}
+	for ns, nc := range kc.caches {
+		if _, ok := keep[ns]; !ok {
+			nc.cancel()
+			delete(kc.caches, ns)
+		}
+	}
+	kc.cacheLock.Unlock()
+
+	for _, ns := range added {
+		if !kc.canCache(c, ns) {
+			continue
+		}
+		cc, cancel := context.WithCancel(c)
+		nc := &nsCache{cancel: cancel}
+		go nc.run(cc, kc.Client, kc.watchBreaker(), ns)
+		kc.cacheLock.Lock()
+		kc.caches[ns] = nc
+		kc.cacheLock.Unlock()
+	}
+}
+
+// canCache returns true if the user is allowed to watch all cached resources in the given namespace,
+// or cluster-wide when it's empty
+func (kc *Cluster) canCache(c context.Context, namespace string) bool {
+	for _, r := range cachedResources {
+		if ok, err := kc.CanI(c, "watch", r.group, r.resource, namespace); err != nil || !ok {
+			if namespace == "" {
+				dlog.Debugf(c, "namespaces are cached one by one because the user isn't allowed to watch %s cluster-wide", r.resource)
+			} else {
+				dlog.Debugf(c, "namespace %s is not cached because the user isn't allowed to watch %s", namespace, r.resource)
+			}
+			return false
+		}
+	}
+	return true
+}
+
+// withCache calls f with the snapshot of the given namespace, and returns true, provided that the
+// namespace is cached and the cache has synced. The snapshot must not be modified or retained. The
+// namespace is considered referenced, so a namespace that wasn't cached because namespaces are
+// mapped lazily will be cached for subsequent calls.
+func (kc *Cluster) withCache(c context.Context, namespace string, f func(*workloadSnapshot)) bool {
+	kc.referenceNamespace(c, namespace)
+	kc.cacheLock.Lock()
+	cc := kc.clusterCache
+	nc := kc.caches[namespace]
+	kc.cacheLock.Unlock()
+	if cc != nil {
+		cc.lock.RLock()
+		defer cc.lock.RUnlock()
+		if !cc.synced {
+			return false
+		}
+		s, ok := cc.namespaces[namespace]
+		if !ok {
+			s = &workloadSnapshot{}
+		}
+		f(s)
+		return true
+	}
+	if nc == nil {
+		return false
+	}
+	nc.lock.RLock()
+	defer nc.lock.RUnlock()
+	if !nc.synced {
+		return false
+	}
+	f(&nc.snapshot)
+	return true
+}
+
+// LookupDeployment is like FindDeployment but uses the cache of the namespace when there is one. The
+// deployment may lag slightly behind the cluster, so it must not be used for updates.
+func (kc *Cluster) LookupDeployment(c context.Context, namespace, name string) (*kates.Deployment, error) {
+	var found *kates.Deployment
+	if kc.withCache(c, namespace, func(s *workloadSnapshot) {
+		for _, o := range s.Deployments {
+			if o.Name == name {
+				found = o.DeepCopy()
+				break
+			}
+		}
+	}) {
+		if found == nil {
+			return nil, errors2.NewNotFound(appsv1.Resource("deployments"), name)
+		}
+		return found, nil
+	}
+	return kc.FindDeployment(c, namespace, name)
+}
+
+// LookupReplicaSet is like FindReplicaSet but uses the cache of the namespace when there is one. The
+// replica set may lag slightly behind the cluster, so it must not be used for updates.
+func (kc *Cluster) LookupReplicaSet(c context.Context, namespace, name string) (*kates.ReplicaSet, error) {
+	var found *kates.ReplicaSet
+	if kc.withCache(c, namespace, func(s *workloadSnapshot) {
+		for _, o := range s.ReplicaSets {
+			if o.Name == name {
+				found = o.DeepCopy()
+				break
+			}
+		}
+	}) {
+		if found == nil {
+			return nil, errors2.NewNotFound(appsv1.Resource("replicasets"), name)
+		}
+		return found, nil
+	}
+	return kc.FindReplicaSet(c, namespace, name)
+}
+
+// LookupStatefulSet is like FindStatefulSet but uses the cache of the namespace when there is one.
+// The stateful set may lag slightly behind the cluster, so it must not be used for updates.
+func (kc *Cluster) LookupStatefulSet(c context.Context, namespace, name string) (*kates.StatefulSet, error) {
+	var found *kates.StatefulSet
+	if kc.withCache(c, namespace, func(s *workloadSnapshot) {
+		for _, o := range s.StatefulSets {
+			if o.Name == name {
+				found = o.DeepCopy()
+				break
+			}
+		}
+	}) {
+		if found == nil {
+			return nil, errors2.NewNotFound(appsv1.Resource("statefulsets"), name)
+		}
+		return found, nil
+	}
+	return kc.FindStatefulSet(c, namespace, name)
+}
+
+// MatchingServices returns the services in the given namespace with a selector that matches the
+// given labels, using the cache of the namespace when there is one.
+func (kc *Cluster) MatchingServices(c context.Context, namespace string, labels map[string]string) ([]*kates.Service, error) {
+	var svcs []*kates.Service
+	if kc.withCache(c, namespace, func(s *workloadSnapshot) {
+		svcs = install.FilterMatchingServices(s.Services, "", "", labels)
+		for i, svc := range svcs {
+			svcs[i] = svc.DeepCopy()
+		}
+	}) {
+		return svcs, nil
+	}
+	return install.FindMatchingServices(c, kc.Client(), "", "", namespace, labels)
+}
+
+// LookupPodName returns the name of the running pod in the given namespace that has the given IP,
+// using the cache of the namespace when there is one. An empty string is returned when there's no
+// such pod.
+func (kc *Cluster) LookupPodName(c context.Context, namespace, podIP string) string {
+	find := func(pods []*kates.Pod) string {
+		for _, pod := range pods {
+			if pod.Status.PodIP == podIP && pod.Status.Phase == corev1.PodRunning {
+				return pod.Name
+			}
+		}
+		return ""
+	}
+	var name string
+	if kc.withCache(c, namespace, func(s *workloadSnapshot) { name = find(s.Pods) }) {
+		return name
+	}
+	var pods []*kates.Pod
+	if err := kc.Client().List(c, kates.Query{Kind: "Pod", Namespace: namespace}, &pods); err != nil {
+		dlog.Errorf(c, "unable to list the pods of namespace %s: %v", namespace, err)
+		return ""
+	}
+	return find(pods)
+}
@@ -0,0 +1,54 @@
+package userd_k8s
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	errors2 "k8s.io/apimachinery/pkg/api/errors"
+
+	"github.com/datawire/ambassador/pkg/kates"
+	"github.com/datawire/dlib/dlog"
+)
+
+func TestClusterCache(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	meta := func(namespace, name string) kates.ObjectMeta {
+		return kates.ObjectMeta{Namespace: namespace, Name: name}
+	}
+	spec := kates.ServiceSpec{Selector: map[string]string{"app": "echo"}, Ports: []kates.ServicePort{{Port: 80}}}
+	cc := &clusterCache{synced: true}
+	cc.all = workloadSnapshot{
+		Services:    []*kates.Service{{ObjectMeta: meta("default", "echo"), Spec: spec}, {ObjectMeta: meta("other", "echo"), Spec: spec}},
+		Deployments: []*kates.Deployment{{ObjectMeta: meta("default", "echo")}, {ObjectMeta: meta("other", "web")}},
+		Pods:        []*kates.Pod{{ObjectMeta: meta("other", "web-1")}},
+	}
+	cc.namespaces = cc.all.byNamespace()
+	kc := &Cluster{
+		referencedNamespaces: map[string]struct{}{},
+		caches:               map[string]*nsCache{},
+		clusterCache:         cc,
+	}
+
+	// The snapshot of the cluster answers for each namespace
+	names, err := kc.kindNames(ctx, "Deployment", "default")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"echo"}, names)
+	names, err = kc.kindNames(ctx, "Pod", "other")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"web-1"}, names)
+	names, err = kc.kindNames(ctx, "Deployment", "empty")
+	require.NoError(t, err)
+	assert.Empty(t, names)
+
+	dep, err := kc.LookupDeployment(ctx, "other", "web")
+	require.NoError(t, err)
+	assert.Equal(t, "other", dep.Namespace)
+	_, err = kc.LookupDeployment(ctx, "other", "echo")
+	assert.True(t, errors2.IsNotFound(err))
+
+	svcs, err := kc.MatchingServices(ctx, "other", map[string]string{"app": "echo"})
+	require.NoError(t, err)
+	require.Len(t, svcs, 1)
+	assert.Equal(t, "other", svcs[0].Namespace)
+}
@@ -0,0 +1,108 @@
+package userd_k8s
+
+import (
+	"bufio"
+	"context"
+	"strings"
+
+	"github.com/datawire/ambassador/pkg/kates"
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+)
+
+// dnsConfigMaps are the config maps that hold the Corefile of the CoreDNS that serves the cluster
+var dnsConfigMaps = []struct{ namespace, name string }{
+	{"kube-system", "coredns"},
+	{"openshift-dns", "dns-default"},
+}
+
+// ClusterDomain returns the DNS domain of the cluster, e.g. "cluster.local". The domain set in the
+// config takes precedence. Otherwise, it's detected from the configuration of the cluster DNS,
+// and client.DefaultClusterDomain is returned when that fails. The result is cached.
+func (kc *Cluster) ClusterDomain(c context.Context) string {
+	kc.clusterDomainOnce.Do(func() {
+		if kc.clusterDomain = client.GetConfig(c).Cluster.ClusterDomain; kc.clusterDomain != "" {
+			return
+		}
+		if kc.clusterDomain = kc.detectClusterDomain(c); kc.clusterDomain != "" {
+			dlog.Infof(c, "Detected cluster domain %s", kc.clusterDomain)
+			return
+		}
+		dlog.Debugf(c, "Unable to detect the cluster domain, using %s", client.DefaultClusterDomain)
+		kc.clusterDomain = client.DefaultClusterDomain
+	})
+	return kc.clusterDomain
+}
+
+// detectClusterDomain returns the domain that the cluster DNS serves the services in, or an empty
+// string if it can't be found. Reading the DNS configuration requires access to the namespace of
+// the cluster DNS, which not all users have.
+func (kc *Cluster) detectClusterDomain(c context.Context) string {
+	for _, dcm := range dnsConfigMaps {
+		cm := &kates.ConfigMap{
+			TypeMeta:   kates.TypeMeta{Kind: "ConfigMap"},
+			ObjectMeta: kates.ObjectMeta{Name: dcm.name, Namespace: dcm.namespace},
+		}
+		if err := kc.Client().Get(c, cm, cm); err != nil {
+			dlog.Debugf(c, "unable to get config map %s.%s: %v", dcm.name, dcm.namespace, err)
+			continue
+		}
+		if domain := corefileClusterDomain(cm.Data["Corefile"]); domain != "" {
+			return domain
+		}
+	}
+
+	// The legacy kube-dns is configured using command line flags
+	dep, err := kc.FindDeployment(c, "kube-system", "kube-dns")
+	if err != nil {
+		dlog.Debugf(c, "unable to get deployment kube-dns.kube-system: %v", err)
+		return ""
+	}
+	for _, cn := range dep.Spec.Template.Spec.Containers {
+		if domain := kubeDNSClusterDomain(cn.Args); domain != "" {
+			return domain
+		}
+	}
+	return ""
+}
+
+// corefileClusterDomain returns the first zone of the kubernetes plugin in the given Corefile that
+// isn't a reverse zone, e.g. "cluster.local" for the line "kubernetes cluster.local in-addr.arpa {".
+func corefileClusterDomain(corefile string) string {
+	sc := bufio.NewScanner(strings.NewReader(corefile))
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 2 || fields[0] != "kubernetes" {
+			continue
+		}
+		for _, zone := range fields[1:] {
+			if zone == "{" || strings.HasPrefix(zone, "#") {
+				break
+			}
+			zone = strings.ToLower(strings.Trim(zone, "."))
+			if zone != "" && !strings.HasSuffix(zone, ".arpa") && zone != "arpa" {
+				return zone
+			}
+		}
+	}
+	return ""
+}
+
+// kubeDNSClusterDomain returns the value of the --domain flag among the given kube-dns arguments.
+func kubeDNSClusterDomain(args []string) string {
+	for i, arg := range args {
+		var domain string
+		switch {
+		case strings.HasPrefix(arg, "--domain="):
+			domain = strings.TrimPrefix(arg, "--domain=")
+		case arg == "--domain" && i+1 < len(args):
+			domain = args[i+1]
+		default:
+			continue
+		}
+		if domain = strings.ToLower(strings.Trim(domain, ".")); domain != "" {
+			return domain
+		}
+	}
+	return ""
+}
@@ -0,0 +1,36 @@
+package userd_k8s
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCorefileClusterDomain(t *testing.T) {
+	assert.Equal(t, "cluster.local", corefileClusterDomain(`.:53 {
+    errors
+    health
+    kubernetes cluster.local in-addr.arpa ip6.arpa {
+       pods insecure
+       fallthrough in-addr.arpa ip6.arpa
+    }
+    forward . /etc/resolv.conf
+}
+`))
+	assert.Equal(t, "example.internal", corefileClusterDomain(`.:53 {
+    kubernetes in-addr.arpa Example.Internal. {
+    }
+}
+`))
+	assert.Equal(t, "", corefileClusterDomain(`.:53 {
+    forward . /etc/resolv.conf
+}
+`))
+	assert.Equal(t, "", corefileClusterDomain(""))
+}
+
+func TestKubeDNSClusterDomain(t *testing.T) {
+	assert.Equal(t, "cluster.local", kubeDNSClusterDomain([]string{"--domain=cluster.local.", "--dns-port=10053"}))
+	assert.Equal(t, "my.domain", kubeDNSClusterDomain([]string{"--dns-port=10053", "--domain", "my.domain"}))
+	assert.Equal(t, "", kubeDNSClusterDomain([]string{"--dns-port=10053"}))
+}
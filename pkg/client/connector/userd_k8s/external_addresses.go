@@ -0,0 +1,271 @@
+package userd_k8s
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/datawire/ambassador/pkg/kates"
+	"github.com/datawire/dlib/derror"
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/iputil"
+	"github.com/telepresenceio/telepresence/v2/pkg/subnet"
+)
+
+// SetExternalRoutesFunc is the signature of daemon.SetExternalRoutes, bound to a connection
+type SetExternalRoutesFunc func(ctx context.Context, subnets []*net.IPNet, hosts map[string][]net.IP) error
+
+// externalSnapshot contains the objects that the addresses to route are extracted from. The field
+// names must match the names of the queries of the watch that updates it.
+type externalSnapshot struct {
+	Nodes    []*kates.Node
+	Services []*kates.Service
+}
+
+// RunExternalAddressWatcher watches the nodes and the services of type LoadBalancer of the cluster
+// when the proxy-node-ips or proxy-load-balancer-ips option of the kubeconfig extension is set, and
+// makes the daemon route their addresses to the cluster. This enables NodePorts and load balancers
+// to be reached from the workstation using the addresses that applications in the cluster's
+// network use, even when the workstation can't reach them on its own.
+func (kc *Cluster) RunExternalAddressWatcher(c context.Context) (err error) {
+	if !(kc.ProxyNodeIPs || kc.ProxyLoadBalancerIPs) || kc.callbacks.SetExternalRoutes == nil {
+		return nil
+	}
+	defer func() {
+		if r := derror.PanicToError(recover()); r != nil {
+			err = r
+		}
+	}()
+
+	var queries []kates.Query
+	if kc.ProxyNodeIPs {
+		if ok, err := kc.CanI(c, "watch", "", "nodes", ""); err == nil && !ok {
+			dlog.Warn(c, "The current user is not allowed to watch nodes. Their addresses will not be routed to the cluster")
+		} else {
+			queries = append(queries, kates.Query{Name: "Nodes", Kind: "Node"})
+		}
+	}
+	if kc.ProxyLoadBalancerIPs {
+		if ok, err := kc.CanI(c, "watch", "", "services", ""); err == nil && !ok {
+			dlog.Warn(c, "The current user is not allowed to watch services in all namespaces. "+
+				"The addresses of load balancers will not be routed to the cluster")
+		} else {
+			queries = append(queries, kates.Query{Name: "Services", Kind: "Service"})
+		}
+	}
+	if len(queries) == 0 {
+		return nil
+	}
+
+	exclude := kc.neverRouteExternal(c)
+	acc := kc.Client().Watch(c, queries...)
+	var snapshot externalSnapshot
+	var lastSubnets []*net.IPNet
+	var lastHosts map[string][]net.IP
+	for {
+		select {
+		case <-c.Done():
+			return nil
+		case <-acc.Changed():
+			if !acc.Update(&snapshot) {
+				continue
+			}
+			ips, hosts := externalAddresses(snapshot.Nodes, snapshot.Services, lookupFunc(c), exclude)
+			subnets := routedSubnets(c, ips, exclude)
+			if lastHosts != nil && subnetsEqual(subnets, lastSubnets) && hostsEqual(hosts, lastHosts) {
+				continue
+			}
+			if err := kc.callbacks.SetExternalRoutes(c, subnets, hosts); err != nil {
+				dlog.Errorf(c, "unable to route the addresses of nodes and load balancers: %v", err)
+				continue
+			}
+			lastSubnets, lastHosts = subnets, hosts
+		}
+	}
+}
+
+// lookupFunc returns a function that resolves a host name using the resolver of the workstation,
+// which is what applications on the workstation will use to resolve the host names of load
+// balancers.
+func lookupFunc(c context.Context) func(string) []net.IP {
+	return func(host string) []net.IP {
+		c, cancel := context.WithTimeout(c, 5*time.Second)
+		defer cancel()
+		addrs, err := net.DefaultResolver.LookupIPAddr(c, host)
+		if err != nil {
+			dlog.Debugf(c, "unable to resolve %s: %v", host, err)
+			return nil
+		}
+		ips := make([]net.IP, len(addrs))
+		for i, addr := range addrs {
+			ips[i] = addr.IP
+		}
+		return ips
+	}
+}
+
+// neverRouteExternal returns the subnets that the addresses of nodes and load balancers must not
+// be in. Routing the address of the API server, or of the endpoint that exposes the traffic-manager,
+// to the cluster would cut off the connection that the tunnel runs on, and there's no point in
+// routing addresses that the workstation can reach directly.
+func (kc *Cluster) neverRouteExternal(c context.Context) []*net.IPNet {
+	var hosts []string
+	if u, err := url.Parse(kc.Server); err == nil && u.Hostname() != "" {
+		hosts = append(hosts, u.Hostname())
+	}
+	if ep, _ := kc.GetManagerEndpoint(); ep != "" {
+		if host, _, err := net.SplitHostPort(ep); err == nil {
+			hosts = append(hosts, host)
+		}
+	}
+
+	var ips []net.IP
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			ips = append(ips, ip)
+		} else {
+			ips = append(ips, lookupFunc(c)(host)...)
+		}
+	}
+	subnets := make([]*net.IPNet, 0, len(ips))
+	for _, ip := range ips {
+		bits := len(ip) * 8
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 32
+		}
+		subnets = append(subnets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+	}
+
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, addr := range addrs {
+			if ipn, ok := addr.(*net.IPNet); ok && ipn.IP.IsGlobalUnicast() {
+				subnets = append(subnets, &net.IPNet{IP: ipn.IP.Mask(ipn.Mask), Mask: ipn.Mask})
+			}
+		}
+	}
+	return subnet.Unique(subnets)
+}
+
+// externalAddresses returns the addresses of the given nodes and of the load balancers of the given
+// services, except those in the excluded subnets, and a map of the host names of the nodes and load
+// balancers to those addresses. The given lookup function resolves the host names of load
+// balancers that have no IP.
+func externalAddresses(nodes []*kates.Node, svcs []*kates.Service, lookup func(string) []net.IP, exclude []*net.IPNet) (iputil.IPs, map[string][]net.IP) {
+	var all iputil.IPs
+	hosts := make(map[string][]net.IP)
+	add := func(names []string, ips []net.IP) {
+		var routed iputil.IPs
+		for _, ip := range ips {
+			if ip4 := ip.To4(); ip4 != nil {
+				ip = ip4
+			}
+			if !excluded(ip, exclude) {
+				routed = append(routed, ip)
+			}
+		}
+		if len(routed) == 0 {
+			return
+		}
+		routed = routed.UniqueSorted()
+		all = append(all, routed...)
+		for _, name := range names {
+			if name = strings.ToLower(name); name != "" {
+				hosts[name] = append(hosts[name], routed...)
+			}
+		}
+	}
+
+	for _, node := range nodes {
+		names := []string{node.Name}
+		var ips []net.IP
+		for _, addr := range node.Status.Addresses {
+			switch addr.Type {
+			case corev1.NodeInternalIP, corev1.NodeExternalIP:
+				if ip := iputil.Parse(addr.Address); ip != nil {
+					ips = append(ips, ip)
+				}
+			case corev1.NodeHostName, corev1.NodeInternalDNS, corev1.NodeExternalDNS:
+				names = append(names, addr.Address)
+			}
+		}
+		add(names, ips)
+	}
+
+	for _, svc := range svcs {
+		if svc.Spec.Type != corev1.ServiceTypeLoadBalancer {
+			continue
+		}
+		for _, ing := range svc.Status.LoadBalancer.Ingress {
+			switch {
+			case ing.IP != "":
+				if ip := iputil.Parse(ing.IP); ip != nil {
+					add(nil, []net.IP{ip})
+				}
+			case ing.Hostname != "":
+				add([]string{ing.Hostname}, lookup(ing.Hostname))
+			}
+		}
+	}
+
+	for name, ips := range hosts {
+		hosts[name] = iputil.IPs(ips).UniqueSorted()
+	}
+	return all.UniqueSorted(), hosts
+}
+
+// routedSubnets returns the subnets to route for the given addresses. Each address is routed using
+// a host route, i.e. a subnet with a 32 bit mask (128 bits for IPv6), so that no other address of
+// the workstation's networks is routed to the cluster. Addresses that are covered by an excluded
+// subnet are not routed.
+func routedSubnets(c context.Context, ips []net.IP, exclude []*net.IPNet) []*net.IPNet {
+	subnets := make([]*net.IPNet, 0, len(ips))
+	for _, ip := range ips {
+		bits := 128
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 32
+		}
+		if excluded(ip, exclude) {
+			dlog.Warnf(c, "Address %s is not routed to the cluster, because it's excluded", ip)
+			continue
+		}
+		subnets = append(subnets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+	}
+	return subnet.Unique(subnets)
+}
+
+func excluded(ip net.IP, exclude []*net.IPNet) bool {
+	for _, sn := range exclude {
+		if sn.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func subnetsEqual(a, b []*net.IPNet) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !subnet.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func hostsEqual(a, b map[string][]net.IP) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for name, ips := range a {
+		if iputil.IPs(ips).String() != iputil.IPs(b[name]).String() {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,89 @@
+package userd_k8s
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/datawire/ambassador/pkg/kates"
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/iputil"
+)
+
+func mustParseCIDR(t *testing.T, s string) *net.IPNet {
+	_, sn, err := net.ParseCIDR(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sn
+}
+
+func TestExternalAddresses(t *testing.T) {
+	nodes := []*kates.Node{{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-1"},
+		Status: corev1.NodeStatus{Addresses: []corev1.NodeAddress{
+			{Type: corev1.NodeInternalIP, Address: "10.0.1.5"},
+			{Type: corev1.NodeExternalIP, Address: "34.1.2.3"},
+			{Type: corev1.NodeHostName, Address: "Node-1.Example.Internal"},
+		}},
+	}, {
+		ObjectMeta: metav1.ObjectMeta{Name: "node-2"},
+		Status: corev1.NodeStatus{Addresses: []corev1.NodeAddress{
+			{Type: corev1.NodeInternalIP, Address: "192.168.1.7"},
+		}},
+	}}
+	svcs := []*kates.Service{{
+		Spec: corev1.ServiceSpec{Type: corev1.ServiceTypeLoadBalancer},
+		Status: corev1.ServiceStatus{LoadBalancer: corev1.LoadBalancerStatus{Ingress: []corev1.LoadBalancerIngress{
+			{IP: "35.0.0.1"},
+			{Hostname: "lb.example.com"},
+		}}},
+	}, {
+		Spec: corev1.ServiceSpec{Type: corev1.ServiceTypeClusterIP},
+		Status: corev1.ServiceStatus{LoadBalancer: corev1.LoadBalancerStatus{Ingress: []corev1.LoadBalancerIngress{
+			{IP: "36.0.0.1"},
+		}}},
+	}}
+	lookup := func(host string) []net.IP {
+		if host == "lb.example.com" {
+			return []net.IP{net.ParseIP("52.0.0.2"), net.ParseIP("52.0.0.1")}
+		}
+		return nil
+	}
+	exclude := []*net.IPNet{mustParseCIDR(t, "192.168.1.0/24")}
+
+	ips, hosts := externalAddresses(nodes, svcs, lookup, exclude)
+	assert.Equal(t, "10.0.1.5,34.1.2.3,35.0.0.1,52.0.0.1,52.0.0.2", ips.String())
+	assert.Len(t, hosts, 3, "node-2 has no routed addresses")
+	assert.Equal(t, "10.0.1.5,34.1.2.3", iputil.IPs(hosts["node-1"]).String())
+	assert.Equal(t, "10.0.1.5,34.1.2.3", iputil.IPs(hosts["node-1.example.internal"]).String())
+	assert.Equal(t, "52.0.0.1,52.0.0.2", iputil.IPs(hosts["lb.example.com"]).String())
+}
+
+func TestRoutedSubnets(t *testing.T) {
+	c := dlog.NewTestContext(t, false)
+	exclude := []*net.IPNet{mustParseCIDR(t, "34.1.5.10/32")}
+	ips := []net.IP{
+		net.ParseIP("10.0.3.7"),
+		net.ParseIP("10.0.3.9"),
+		net.ParseIP("34.1.5.20"),
+		net.ParseIP("10.1.0.0"),
+		net.ParseIP("2600::5"),
+	}
+	subnets := routedSubnets(c, ips, exclude)
+	cidrs := make([]string, len(subnets))
+	for i, sn := range subnets {
+		cidrs[i] = sn.String()
+	}
+	assert.ElementsMatch(t, []string{
+		"10.0.3.7/32",
+		"10.0.3.9/32",
+		"34.1.5.20/32",
+		"10.1.0.0/32",
+		"2600::5/128",
+	}, cidrs)
+	assert.Empty(t, routedSubnets(c, []net.IP{net.ParseIP("34.1.5.10")}, exclude), "the address is excluded")
+}
@@ -0,0 +1,73 @@
+package userd_k8s
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/datawire/dlib/dlog"
+)
+
+// Delays between the attempts to restart a watch that failed, e.g. because the API server couldn't
+// be reached when the watch started.
+const (
+	watchRetryDelay    = time.Second
+	watchMaxRetryDelay = 30 * time.Second
+)
+
+// apiHealth keeps track of whether the API server can be reached, based on the outcome of the
+// requests that the clients of a cluster send to it. The server is unreachable from the first
+// request that fails without a response until the next request that gets one.
+type apiHealth struct {
+	ctx          context.Context
+	mu           sync.Mutex
+	failingSince time.Time
+	err          error
+}
+
+func newAPIHealth(ctx context.Context) *apiHealth {
+	return &apiHealth{ctx: ctx}
+}
+
+// wrap returns a round tripper that records the outcome of the requests of the given round tripper.
+func (h *apiHealth) wrap(rt http.RoundTripper) http.RoundTripper {
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		resp, err := rt.RoundTrip(req)
+		if err == nil || req.Context().Err() == nil {
+			h.record(err)
+		}
+		return resp, err
+	})
+}
+
+func (h *apiHealth) record(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err == nil {
+		if !h.failingSince.IsZero() {
+			dlog.Infof(h.ctx, "The API server is reachable again after %s", time.Since(h.failingSince).Round(time.Second))
+			h.failingSince = time.Time{}
+			h.err = nil
+		}
+		return
+	}
+	if h.failingSince.IsZero() {
+		dlog.Warnf(h.ctx, "The API server is unreachable: %v", err)
+		h.failingSince = time.Now()
+	}
+	h.err = err
+}
+
+// unreachableReason returns an empty string when the API server is reachable, or else the reason
+// why it isn't.
+func (h *apiHealth) unreachableReason() string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.failingSince.IsZero() {
+		return ""
+	}
+	return fmt.Sprintf("the Kubernetes API server has been unreachable for %s: %v",
+		time.Since(h.failingSince).Round(time.Second), h.err)
+}
@@ -0,0 +1,50 @@
+package userd_k8s
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+)
+
+func TestAPIHealth(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	h := newAPIHealth(ctx)
+	var fail error
+	rt := h.wrap(roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		if fail != nil {
+			return nil, fail
+		}
+		return &http.Response{StatusCode: http.StatusOK}, nil
+	}))
+	get := func() {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://kubernetes.default", nil)
+		require.NoError(t, err)
+		_, _ = rt.RoundTrip(req)
+	}
+
+	get()
+	assert.Empty(t, h.unreachableReason())
+
+	fail = errors.New("connection refused")
+	get()
+	assert.Contains(t, h.unreachableReason(), "connection refused")
+
+	// The failure of a request that was cancelled says nothing about the API server
+	h2 := newAPIHealth(ctx)
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	req, err := http.NewRequestWithContext(cancelled, http.MethodGet, "https://kubernetes.default", nil)
+	require.NoError(t, err)
+	_, _ = h2.wrap(roundTripperFunc(func(*http.Request) (*http.Response, error) { return nil, cancelled.Err() })).RoundTrip(req)
+	assert.Empty(t, h2.unreachableReason())
+
+	fail = nil
+	get()
+	assert.Empty(t, h.unreachableReason())
+}
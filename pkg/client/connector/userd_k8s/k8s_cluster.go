@@ -3,12 +3,14 @@ package userd_k8s
 import (
 	"context"
 	"fmt"
+	"strings"
 	"sync"
 
 	"github.com/pkg/errors"
 	"google.golang.org/grpc"
 	empty "google.golang.org/protobuf/types/known/emptypb"
 	v1 "k8s.io/api/core/v1"
+	errors2 "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/client-go/discovery"
 
 	"github.com/datawire/ambassador/pkg/kates"
@@ -16,6 +18,7 @@ import (
 	"github.com/telepresenceio/telepresence/rpc/v2/daemon"
 	"github.com/telepresenceio/telepresence/v2/pkg/client"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/actions"
+	"github.com/telepresenceio/telepresence/v2/pkg/install"
 )
 
 type nameMeta struct {
@@ -26,8 +29,12 @@ type objName struct {
 	nameMeta `json:"metadata"`
 }
 
+// SetDNSSearchPathFunc is the signature of daemon.DaemonClient.SetDnsSearchPath
+type SetDNSSearchPathFunc func(ctx context.Context, in *daemon.Paths, opts ...grpc.CallOption) (*empty.Empty, error)
+
 type Callbacks struct {
-	SetDNSSearchPath func(ctx context.Context, in *daemon.Paths, opts ...grpc.CallOption) (*empty.Empty, error)
+	SetDNSSearchPath  SetDNSSearchPathFunc
+	SetExternalRoutes SetExternalRoutesFunc
 }
 
 // k8sCluster is a Kubernetes cluster reference
@@ -36,8 +43,10 @@ type Cluster struct {
 	mappedNamespaces []string
 
 	// Main
-	client    *kates.Client
-	callbacks Callbacks
+	clientLock    sync.Mutex
+	client        *kates.Client
+	clientReloads int
+	callbacks     Callbacks
 
 	lastNamespaces []string
 
@@ -49,12 +58,44 @@ type Cluster struct {
 
 	accLock         sync.Mutex
 	accWait         chan struct{}
+	accWaitOnce     sync.Once
 	LocalIntercepts map[string]string
 
 	// Current Namespace snapshot, get set by acc.Update().
 	curSnapshot struct {
 		Namespaces []*objName
 	}
+
+	openShiftLock sync.Mutex
+	openShift     *bool
+
+	knativeOnce sync.Once
+	knative     bool
+
+	argoRolloutsOnce sync.Once
+	argoRollouts     bool
+
+	clusterDomainOnce sync.Once
+	clusterDomain     string
+
+	// nsChanged is signalled when the set of mapped namespaces changes
+	nsChanged chan struct{}
+
+	// caches contain the workloads and services of each mapped namespace, unless clusterCache
+	// contains those of all namespaces
+	cacheLock    sync.Mutex
+	caches       map[string]*nsCache
+	clusterCache *clusterCache
+
+	// referencedNamespaces are the namespaces that have been referenced during the session. They are
+	// the only ones that get DNS entries and caches when namespaces are mapped lazily.
+	referencedNamespaces map[string]struct{}
+
+	// lazy is true when namespaces are mapped lazily
+	lazy bool
+
+	// serverVersion is the version of the cluster, or zero if it couldn't be parsed
+	serverVersion kubeVersion
 }
 
 func (kc *Cluster) ActualNamespace(namespace string) string {
@@ -73,7 +114,7 @@ func (kc *Cluster) check(c context.Context) error {
 	// context has no effect.
 	errCh := make(chan error)
 	go func() {
-		dc, err := discovery.NewDiscoveryClientForConfig(kc.config)
+		dc, err := discovery.NewDiscoveryClientForConfig(kc.restConfig())
 		if err != nil {
 			errCh <- err
 			return
@@ -84,6 +125,21 @@ func (kc *Cluster) check(c context.Context) error {
 			return
 		}
 		dlog.Infof(c, "Server version %s", info.GitVersion)
+		v, err := parseServerVersion(info)
+		if err != nil {
+			dlog.Warnf(c, "%v, the version won't be checked against the supported versions", err)
+			close(errCh)
+			return
+		}
+		warnings, err := checkVersion(v, &client.GetConfig(c).Cluster)
+		for _, w := range warnings {
+			dlog.Warnf(c, "%s", w)
+		}
+		if err != nil {
+			errCh <- err
+			return
+		}
+		kc.serverVersion = v
 		close(errCh)
 	}()
 
@@ -102,11 +158,16 @@ func (kc *Cluster) check(c context.Context) error {
 
 // kindNames returns the names of all objects of a specified Kind in a given Namespace
 func (kc *Cluster) kindNames(c context.Context, kind, namespace string) ([]string, error) {
+	var names []string
+	var cached bool
+	if kc.withCache(c, namespace, func(s *workloadSnapshot) { names, cached = s.names(kind) }) && cached {
+		return names, nil
+	}
 	var objNames []objName
-	if err := kc.client.List(c, kates.Query{Kind: kind, Namespace: namespace}, &objNames); err != nil {
+	if err := kc.Client().List(c, kates.Query{Kind: kind, Namespace: namespace}, &objNames); err != nil {
 		return nil, err
 	}
-	names := make([]string, len(objNames))
+	names = make([]string, len(objNames))
 	for i, n := range objNames {
 		names[i] = n.Name
 	}
@@ -140,7 +201,7 @@ func (kc *Cluster) FindDeployment(c context.Context, namespace, name string) (*k
 		TypeMeta:   kates.TypeMeta{Kind: "Deployment"},
 		ObjectMeta: kates.ObjectMeta{Name: name, Namespace: namespace},
 	}
-	if err := kc.client.Get(c, dep, dep); err != nil {
+	if err := kc.Client().Get(c, dep, dep); err != nil {
 		return nil, err
 	}
 	return dep, nil
@@ -153,7 +214,7 @@ func (kc *Cluster) FindStatefulSet(c context.Context, namespace, name string) (*
 		TypeMeta:   kates.TypeMeta{Kind: "StatefulSet"},
 		ObjectMeta: kates.ObjectMeta{Name: name, Namespace: namespace},
 	}
-	if err := kc.client.Get(c, statefulSet, statefulSet); err != nil {
+	if err := kc.Client().Get(c, statefulSet, statefulSet); err != nil {
 		return nil, err
 	}
 	return statefulSet, nil
@@ -166,7 +227,7 @@ func (kc *Cluster) FindReplicaSet(c context.Context, namespace, name string) (*k
 		TypeMeta:   kates.TypeMeta{Kind: "ReplicaSet"},
 		ObjectMeta: kates.ObjectMeta{Name: name, Namespace: namespace},
 	}
-	if err := kc.client.Get(c, rs, rs); err != nil {
+	if err := kc.Client().Get(c, rs, rs); err != nil {
 		return nil, err
 	}
 	return rs, nil
@@ -179,7 +240,7 @@ func (kc *Cluster) FindPod(c context.Context, namespace, name string) (*kates.Po
 		TypeMeta:   kates.TypeMeta{Kind: "Pod"},
 		ObjectMeta: kates.ObjectMeta{Name: name, Namespace: namespace},
 	}
-	if err := kc.client.Get(c, pod, pod); err != nil {
+	if err := kc.Client().Get(c, pod, pod); err != nil {
 		return nil, err
 	}
 	return pod, nil
@@ -190,6 +251,9 @@ func (kc *Cluster) FindPod(c context.Context, namespace, name string) (*kates.Po
 // 1. Deployments
 // 2. ReplicaSets
 // 3. StatefulSets
+// 4. DeploymentConfigs
+// 5. Argo Rollouts
+// 6. Knative Services
 // And return the kind as soon as we find one that matches
 func (kc *Cluster) FindObjectKind(c context.Context, namespace, name string) (string, error) {
 	depNames, err := kc.DeploymentNames(c, namespace)
@@ -225,6 +289,39 @@ func (kc *Cluster) FindObjectKind(c context.Context, namespace, name string) (st
 			return "StatefulSet", nil
 		}
 	}
+
+	// DeploymentConfigs are only found on OpenShift
+	dcNames, err := kc.DeploymentConfigNames(c, namespace)
+	if err != nil {
+		return "", err
+	}
+	for _, dcName := range dcNames {
+		if dcName == name {
+			return "DeploymentConfig", nil
+		}
+	}
+
+	// Argo Rollouts are only found when Argo Rollouts is installed
+	roNames, err := kc.ArgoRolloutNames(c, namespace)
+	if err != nil {
+		return "", err
+	}
+	for _, roName := range roNames {
+		if roName == name {
+			return "Rollout", nil
+		}
+	}
+
+	// Knative Services are only found when Knative Serving is installed
+	ksvcNames, err := kc.KnativeServiceNames(c, namespace)
+	if err != nil {
+		return "", err
+	}
+	for _, ksvcName := range ksvcNames {
+		if ksvcName == name {
+			return "KnativeService", nil
+		}
+	}
 	return "", errors.New("No supported Object Kind Found")
 }
 
@@ -235,7 +332,7 @@ func (kc *Cluster) FindSvc(c context.Context, namespace, name string) (*kates.Se
 		TypeMeta:   kates.TypeMeta{Kind: "Service"},
 		ObjectMeta: kates.ObjectMeta{Name: name, Namespace: namespace},
 	}
-	if err := kc.client.Get(c, rs, rs); err != nil {
+	if err := kc.Client().Get(c, rs, rs); err != nil {
 		return nil, err
 	}
 	return rs, nil
@@ -247,8 +344,26 @@ func (kc *Cluster) findAllSvcByType(c context.Context, svcType v1.ServiceType) (
 	// NOTE: This is expensive in terms of bandwidth on a large cluster. We currently only use this
 	// to retrieve ingress info and that task could be moved to the traffic-manager instead.
 	var svcs []*kates.Service
-	if err := kc.client.List(c, kates.Query{Kind: "Service"}, &svcs); err != nil {
-		return nil, err
+	if err := kc.Client().List(c, kates.Query{Kind: "Service"}, &svcs); err != nil {
+		if !errors2.IsForbidden(err) {
+			return nil, err
+		}
+		// Namespaced permissions only. Fall back to listing the services of each known namespace.
+		dlog.Debugf(c, "unable to list services cluster wide, using namespaced list: %v", err)
+		svcs = nil
+		kc.accLock.Lock()
+		namespaces := kc.lastNamespaces
+		kc.accLock.Unlock()
+		for _, ns := range namespaces {
+			var nsSvcs []*kates.Service
+			if err := kc.Client().List(c, kates.Query{Kind: "Service", Namespace: ns}, &nsSvcs); err != nil {
+				if errors2.IsForbidden(err) {
+					continue
+				}
+				return nil, err
+			}
+			svcs = append(svcs, nsSvcs...)
+		}
 	}
 	var typedSvcs []*kates.Service
 	for _, svc := range svcs {
@@ -273,6 +388,11 @@ func (kc *Cluster) namespaceExists(namespace string) (exists bool) {
 }
 
 func NewCluster(c context.Context, kubeFlags *Config, mappedNamespaces []string, callbacks Callbacks) (*Cluster, error) {
+	if err := kubeFlags.setConfigFlagsKubeconfig(c); err != nil {
+		return nil, err
+	}
+	kubeFlags.ConfigureTransport(c)
+
 	// TODO: Add constructor to kates that takes an additional restConfig argument to prevent that kates recreates it.
 	kc, err := kates.NewClientFromConfigFlags(kubeFlags.ConfigFlags)
 	if err != nil {
@@ -286,11 +406,18 @@ func NewCluster(c context.Context, kubeFlags *Config, mappedNamespaces []string,
 		callbacks:        callbacks,
 		LocalIntercepts:  map[string]string{},
 		accWait:          make(chan struct{}),
+		nsChanged:        make(chan struct{}, 1),
+		caches:           make(map[string]*nsCache),
+
+		referencedNamespaces: make(map[string]struct{}),
 	}
 
 	if err := ret.check(c); err != nil {
 		return nil, err
 	}
+	if err := ret.resolveManagerNamespace(c); err != nil {
+		return nil, err
+	}
 
 	dlog.Infof(c, "Context: %s", ret.Context)
 	dlog.Infof(c, "Server: %s", ret.Server)
@@ -298,6 +425,11 @@ func NewCluster(c context.Context, kubeFlags *Config, mappedNamespaces []string,
 	return ret, nil
 }
 
+// setReady closes accWait, which makes WaitUntilReady return. It may be called more than once.
+func (kc *Cluster) setReady() {
+	kc.accWaitOnce.Do(func() { close(kc.accWait) })
+}
+
 func (kc *Cluster) WaitUntilReady(ctx context.Context) error {
 	select {
 	case <-kc.accWait:
@@ -308,14 +440,81 @@ func (kc *Cluster) WaitUntilReady(ctx context.Context) error {
 }
 
 func (kc *Cluster) GetClusterId(ctx context.Context) string {
-	clusterID, _ := actions.GetClusterID(ctx, kc.client)
+	clusterID, _ := actions.GetClusterID(ctx, kc.Client())
 	return clusterID
 }
 
+// Client returns the kates client of the cluster. The client is recreated when the config has been
+// reloaded, because it keeps the credentials that it was created with.
 func (kc *Cluster) Client() *kates.Client {
+	reloads := kc.configReloads()
+	kc.clientLock.Lock()
+	defer kc.clientLock.Unlock()
+	if reloads != kc.clientReloads {
+		// The old client is kept, and the next call tries again, if a new one can't be created
+		if client, err := kates.NewClientFromConfigFlags(kc.ConfigFlags); err == nil {
+			kc.client = client
+			kc.clientReloads = reloads
+		}
+	}
 	return kc.client
 }
 
+// resolveManagerNamespace sets the namespace of the traffic-manager when neither the environment
+// nor the kubeconfig sets it. It's then taken from the config, or else from the Deployment of a
+// traffic-manager that is already installed in the cluster.
+func (kc *Cluster) resolveManagerNamespace(c context.Context) error {
+	mc := kc.kubeconfigExtension.Manager
+	if mc.Namespace != "" {
+		return nil
+	}
+	if mc.Namespace = client.GetConfig(c).Cluster.ManagerNamespace; mc.Namespace != "" {
+		return nil
+	}
+
+	var deps []*kates.Deployment
+	err := kc.Client().List(c, kates.Query{Kind: "Deployment", LabelSelector: install.ManagerSelector}, &deps)
+	if err != nil {
+		// Not being allowed to list deployments in all namespaces is common
+		dlog.Debugf(c, "unable to look up the traffic-manager: %v", err)
+		deps = nil
+	}
+	switch len(deps) {
+	case 0:
+		mc.Namespace = client.DefaultManagerNamespace
+	case 1:
+		mc.Namespace = deps[0].Namespace
+	default:
+		nss := make([]string, len(deps))
+		for i, dep := range deps {
+			if dep.Namespace == client.DefaultManagerNamespace {
+				mc.Namespace = dep.Namespace
+				break
+			}
+			nss[i] = dep.Namespace
+		}
+		if mc.Namespace == "" {
+			return fmt.Errorf("found traffic-managers in the namespaces %s; choose one with the "+
+				"managerNamespace of the cluster config", strings.Join(nss, ", "))
+		}
+	}
+	dlog.Infof(c, "Using the traffic-manager in namespace %s", mc.Namespace)
+	return nil
+}
+
 func (kc *Cluster) GetManagerNamespace() string {
 	return kc.kubeconfigExtension.Manager.Namespace
 }
+
+// GetManagerEndpoint returns the endpoint that exposes the traffic manager outside the cluster, or
+// an empty string when the traffic manager is reached using a port-forward. The bool is true when
+// the endpoint requires TLS.
+func (kc *Cluster) GetManagerEndpoint() (string, bool) {
+	return kc.kubeconfigExtension.Manager.Endpoint, kc.kubeconfigExtension.Manager.EndpointTLS
+}
+
+// GetManagerQUICEndpoint returns the endpoint that exposes the QUIC port of the traffic manager
+// outside the cluster, or an empty string when there's none.
+func (kc *Cluster) GetManagerQUICEndpoint() string {
+	return kc.kubeconfigExtension.Manager.QUICEndpoint
+}
@@ -1,18 +1,29 @@
 package userd_k8s
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
 	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/spf13/pflag"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/clientcmd/api"
 
 	"github.com/datawire/ambassador/pkg/kates"
 	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/filelocation"
 	"github.com/telepresenceio/telepresence/v2/pkg/iputil"
 )
 
@@ -44,13 +55,43 @@ type dnsConfig struct {
 type managerConfig struct {
 	// Namespace is the name of the namespace where the traffic manager is to be found
 	Namespace string `json:"namespace,omitempty"`
+
+	// Endpoint is the host:port of a LoadBalancer, Ingress, or Gateway that exposes the gRPC port
+	// of the traffic manager. The connector dials it directly instead of port-forwarding through
+	// the API server. The endpoint must authenticate the client, so the connector refuses it
+	// unless mutual TLS is enabled, or EndpointTLS is set along with a tls.clientCert.
+	Endpoint string `json:"endpoint,omitempty"`
+
+	// EndpointTLS makes the connector use TLS, verified with the system's CA certificates, when it
+	// dials the Endpoint, e.g. because an Ingress terminates TLS. Mutual TLS takes precedence when
+	// it's enabled in the config.
+	EndpointTLS bool `json:"endpoint-tls,omitempty"`
+
+	// QUICEndpoint is the host:port of a UDP LoadBalancer that exposes the QUIC port of the
+	// traffic manager. This is experimental. When it's set, and mutual TLS is enabled, the
+	// connector first tries to reach the traffic manager through a QUIC tunnel, and falls back to
+	// the Endpoint or a port-forward when it can't.
+	QUICEndpoint string `json:"quic-endpoint,omitempty"`
 }
 
-// kubeconfigExtension is an extension read from the selected kubeconfig Cluster.
+// kubeconfigExtension is an extension read from the selected kubeconfig Cluster and Context. The
+// values of the Context take precedence.
 type kubeconfigExtension struct {
 	DNS       *dnsConfig       `json:"dns,omitempty"`
 	AlsoProxy []*iputil.Subnet `json:"also-proxy,omitempty"`
 	Manager   *managerConfig   `json:"manager,omitempty"`
+
+	// MappedNamespaces are the namespaces that are mapped unless the connect request
+	// names them explicitly.
+	MappedNamespaces []string `json:"mapped-namespaces,omitempty"`
+
+	// ProxyNodeIPs makes the addresses of the nodes of the cluster routed to the cluster, so that
+	// NodePorts can be reached using them.
+	ProxyNodeIPs bool `json:"proxy-node-ips,omitempty"`
+
+	// ProxyLoadBalancerIPs makes the addresses of the load balancers of the services of type
+	// LoadBalancer routed to the cluster.
+	ProxyLoadBalancerIPs bool `json:"proxy-load-balancer-ips,omitempty"`
 }
 
 type Config struct {
@@ -61,11 +102,43 @@ type Config struct {
 	flagMap     map[string]string
 	flagArgs    []string
 	ConfigFlags *kates.ConfigFlags
-	config      *rest.Config
+
+	// kubeconfigFiles are the kubeconfig files that are merged, or empty when the default
+	// kubeconfig is used.
+	kubeconfigFiles []string
+	overrides       *clientcmd.ConfigOverrides
+
+	// configFlagsFile is the private file that the ConfigFlags load when they can't load the
+	// kubeconfig files themselves, see setConfigFlagsKubeconfig.
+	configFlagsFile string
+
+	configLock sync.Mutex
+	config     *rest.Config
+	fileTimes  map[string]time.Time
+
+	// reloads counts the times that the config has been reloaded, so that the clients that are
+	// created from it can tell when they must be recreated.
+	reloads int
+
+	// wrapConfig is applied to the config when it's reloaded
+	wrapConfig func(*rest.Config) *rest.Config
+	health     *apiHealth
+
+	// breaker limits how often the watches of the API server are restarted
+	breaker *client.Breaker
 }
 
 const configExtension = "telepresence.io"
 
+// KubeconfigEnvKey is the key of the kubectl flag map that holds the KUBECONFIG of the CLI. The
+// user daemon is long-lived, so its own KUBECONFIG is the one that was in effect when it started,
+// and not necessarily the one that the user sees.
+const KubeconfigEnvKey = "KUBECONFIG"
+
+// inClusterContext is the name of the context when the in-cluster config is used, i.e. when the
+// CLI runs in a pod and there is no kubeconfig.
+const inClusterContext = "in-cluster"
+
 func NewConfig(flagMap map[string]string, env client.Env) (*Config, error) {
 	// Namespace option will be passed only when explicitly needed. The k8Cluster is namespace agnostic with
 	// respect to this option.
@@ -75,64 +148,106 @@ func NewConfig(flagMap map[string]string, env client.Env) (*Config, error) {
 	configFlags := kates.NewConfigFlags(false)
 	flags := pflag.NewFlagSet("", 0)
 	configFlags.AddFlags(flags)
+
+	// The overrides are bound to flags with the same names as the kubectl flags so that they can
+	// be used with loading rules that merge several kubeconfig files, something that the
+	// ConfigFlags only do when the files are listed in the environment of this process.
+	overrides := &clientcmd.ConfigOverrides{ClusterDefaults: clientcmd.ClusterDefaults}
+	overrideFlags := pflag.NewFlagSet("", 0)
+	clientcmd.BindOverrideFlags(overrides, overrideFlags, clientcmd.RecommendedConfigOverrideFlags(""))
 	for k, v := range flagMap {
 		flagArgs = append(flagArgs, "--"+k+"="+v)
+		if k == KubeconfigEnvKey || k == "kubeconfig" {
+			continue
+		}
 		if err := flags.Set(k, v); err != nil {
 			return nil, fmt.Errorf("error processing kubectl flag --%s=%s: %w", k, v, err)
 		}
+		if overrideFlags.Lookup(k) != nil {
+			if err := overrideFlags.Set(k, v); err != nil {
+				return nil, fmt.Errorf("error processing kubectl flag --%s=%s: %w", k, v, err)
+			}
+		}
+	}
+
+	k := &Config{
+		flagMap:         flagMap,
+		flagArgs:        flagArgs,
+		ConfigFlags:     configFlags,
+		kubeconfigFiles: kubeconfigFiles(flagMap),
+		overrides:       overrides,
+	}
+	if len(k.kubeconfigFiles) == 1 {
+		configFlags.KubeConfig = &k.kubeconfigFiles[0]
 	}
 
-	configLoader := configFlags.ToRawKubeConfigLoader()
+	configLoader := k.configLoader()
 	config, err := configLoader.RawConfig()
 	if err != nil {
 		return nil, err
 	}
-
+	inCluster := false
 	if len(config.Contexts) == 0 {
-		return nil, errors.New("kubeconfig has no context definition")
+		if _, err := rest.InClusterConfig(); err != nil {
+			return nil, errors.New("kubeconfig has no context definition")
+		}
+		inCluster = true
 	}
 
-	ctxName := flagMap["context"]
-	if ctxName == "" {
-		ctxName = config.CurrentContext
+	fileTimes := k.kubeconfigFileTimes()
+	restConfig, err := configLoader.ClientConfig()
+	if err != nil {
+		return nil, err
 	}
+	k.config = restConfig
+	k.fileTimes = fileTimes
 
-	ctx, ok := config.Contexts[ctxName]
-	if !ok {
-		return nil, fmt.Errorf("context %q does not exist in the kubeconfig", ctxName)
-	}
+	var ctx *api.Context
+	var cluster *api.Cluster
+	if inCluster {
+		k.Context = inClusterContext
+		k.Server = restConfig.Host
+		ctx = &api.Context{}
+		cluster = &api.Cluster{}
+		if ctx.Namespace, _, err = configLoader.Namespace(); err != nil {
+			return nil, err
+		}
+	} else {
+		ctxName := flagMap["context"]
+		if ctxName == "" {
+			ctxName = config.CurrentContext
+		}
 
-	cluster, ok := config.Clusters[ctx.Cluster]
-	if !ok {
-		return nil, fmt.Errorf("cluster %q but no entry for that cluster exists in the kubeconfig", ctx.Cluster)
-	}
+		var ok bool
+		if ctx, ok = config.Contexts[ctxName]; !ok {
+			return nil, fmt.Errorf("context %q does not exist in the kubeconfig", ctxName)
+		}
 
-	restConfig, err := configLoader.ClientConfig()
-	if err != nil {
-		return nil, err
+		if cluster, ok = config.Clusters[ctx.Cluster]; !ok {
+			return nil, fmt.Errorf("cluster %q but no entry for that cluster exists in the kubeconfig", ctx.Cluster)
+		}
+		k.Context = ctxName
+		k.Server = cluster.Server
 	}
 
-	namespace := ctx.Namespace
-	if namespace == "" {
-		namespace = "default"
+	k.Namespace = ctx.Namespace
+	if k.Namespace == "" {
+		k.Namespace = "default"
 	}
 
 	// Sort for easy comparison
-	sort.Strings(flagArgs)
-
-	k := &Config{
-		Context:     ctxName,
-		Server:      cluster.Server,
-		Namespace:   namespace,
-		flagMap:     flagMap,
-		flagArgs:    flagArgs,
-		ConfigFlags: configFlags,
-		config:      restConfig,
-	}
+	sort.Strings(k.flagArgs)
 
+	// The extension of the context is decoded on top of the one of the cluster, so its values
+	// replace those of the cluster.
 	if ext, ok := cluster.Extensions[configExtension].(*runtime.Unknown); ok {
 		if err = json.Unmarshal(ext.Raw, &k.kubeconfigExtension); err != nil {
-			return nil, fmt.Errorf("unable to parse extension %s in kubeconfig: %w", configExtension, err)
+			return nil, fmt.Errorf("unable to parse extension %s of cluster %q in kubeconfig: %w", configExtension, ctx.Cluster, err)
+		}
+	}
+	if ext, ok := ctx.Extensions[configExtension].(*runtime.Unknown); ok {
+		if err = json.Unmarshal(ext.Raw, &k.kubeconfigExtension); err != nil {
+			return nil, fmt.Errorf("unable to parse extension %s of context %q in kubeconfig: %w", configExtension, k.Context, err)
 		}
 	}
 
@@ -143,6 +258,16 @@ func NewConfig(flagMap map[string]string, env client.Env) (*Config, error) {
 	if k.kubeconfigExtension.Manager.Namespace == "" {
 		k.kubeconfigExtension.Manager.Namespace = env.ManagerNamespace
 	}
+	if ep := k.kubeconfigExtension.Manager.Endpoint; ep != "" {
+		if _, _, err = net.SplitHostPort(ep); err != nil {
+			return nil, fmt.Errorf("invalid manager endpoint %q in extension %s: %w", ep, configExtension, err)
+		}
+	}
+	if ep := k.kubeconfigExtension.Manager.QUICEndpoint; ep != "" {
+		if _, _, err = net.SplitHostPort(ep); err != nil {
+			return nil, fmt.Errorf("invalid manager quic-endpoint %q in extension %s: %w", ep, configExtension, err)
+		}
+	}
 
 	return k, nil
 }
@@ -167,3 +292,216 @@ func sliceEqual(a, b []string) bool {
 	}
 	return true
 }
+
+// kubeconfigFiles returns the kubeconfig files that the flag map asks for. The --kubeconfig flag
+// takes precedence over the KUBECONFIG of the CLI, and both may list several files. The KUBECONFIG
+// of this process is never used, because it's the one of the shell that started the daemon. No
+// files means that the default kubeconfig is used.
+func kubeconfigFiles(flagMap map[string]string) []string {
+	list, ok := flagMap["kubeconfig"]
+	if !ok {
+		list = flagMap[KubeconfigEnvKey]
+	}
+	var files []string
+	for _, file := range filepath.SplitList(list) {
+		if file != "" {
+			files = append(files, file)
+		}
+	}
+	return files
+}
+
+// configLoader returns a new loader for the kubeconfig files of this config. It falls back to
+// the in-cluster config when there is no kubeconfig.
+func (kf *Config) configLoader() clientcmd.ClientConfig {
+	rules := &clientcmd.ClientConfigLoadingRules{
+		Precedence:          kf.kubeconfigFiles,
+		DefaultClientConfig: &clientcmd.DefaultClientConfig,
+	}
+	if len(rules.Precedence) == 0 {
+		rules.Precedence = []string{clientcmd.RecommendedHomeFile}
+		rules.MigrationRules = clientcmd.NewDefaultClientConfigLoadingRules().MigrationRules
+	}
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, kf.overrides)
+}
+
+// kubeconfigFileTimes returns the modification times of the kubeconfig files that exist.
+func (kf *Config) kubeconfigFileTimes() map[string]time.Time {
+	files := kf.kubeconfigFiles
+	if len(files) == 0 {
+		files = []string{clientcmd.RecommendedHomeFile}
+	}
+	times := make(map[string]time.Time, len(files))
+	for _, file := range files {
+		if fi, err := os.Stat(file); err == nil {
+			times[file] = fi.ModTime()
+		}
+	}
+	return times
+}
+
+// kubeconfigChanged returns true if a kubeconfig file has been added, removed, or modified since
+// the given times were taken.
+func (kf *Config) kubeconfigChanged(times map[string]time.Time) bool {
+	current := kf.kubeconfigFileTimes()
+	if len(current) != len(times) {
+		return true
+	}
+	for file, t := range current {
+		if pt, ok := times[file]; !ok || !pt.Equal(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// restConfig returns the REST config of the cluster. The config is reloaded when the kubeconfig
+// files have changed, e.g. because a credentials plugin or "gcloud" refreshed a token. A reloaded
+// config that no longer points to the same server is ignored, because that requires a new
+// connection.
+func (kf *Config) restConfig() *rest.Config {
+	kf.configLock.Lock()
+	defer kf.configLock.Unlock()
+	if kf.fileTimes == nil || !kf.kubeconfigChanged(kf.fileTimes) {
+		return kf.config
+	}
+	kf.fileTimes = kf.kubeconfigFileTimes()
+	if config, err := kf.configLoader().ClientConfig(); err == nil && config.Host == kf.config.Host {
+		if kf.wrapConfig != nil {
+			config = kf.wrapConfig(config)
+		}
+		if kf.configFlagsFile == "" || kf.writeConfigFlagsKubeconfig() == nil {
+			kf.config = config
+			kf.reloads++
+		}
+	}
+	return kf.config
+}
+
+// configReloads returns the number of times that the config has been reloaded. The kubeconfig
+// files are checked for changes first.
+func (kf *Config) configReloads() int {
+	kf.restConfig()
+	kf.configLock.Lock()
+	defer kf.configLock.Unlock()
+	return kf.reloads
+}
+
+// ConfigureTransport makes the clients that are created from this config honor the QPS and Burst of
+// the config.yml, back off when the API Priority and Fairness of the API server throttles them, and
+// keep track of whether the API server can be reached. All clients share one backoff, because the
+// API server throttles them all. It must be called once, before any clients are created. The
+// kates client is created from the ConfigFlags, which can't wrap the config in this version of
+// cli-runtime, so it only gets the QPS and Burst of the kubeconfig.
+func (kf *Config) ConfigureTransport(c context.Context) {
+	backoff := newAPFBackoff(c)
+	health := newAPIHealth(c)
+	wrap := func(config *rest.Config) *rest.Config {
+		config = configureRateLimits(c, config, backoff)
+		config.Wrap(health.wrap)
+		return config
+	}
+	kf.configLock.Lock()
+	kf.wrapConfig = wrap
+	kf.health = health
+	kf.breaker = client.NewBreaker(c, "the Kubernetes API server")
+	kf.config = wrap(kf.config)
+	kf.configLock.Unlock()
+}
+
+// UnreachableReason returns an empty string unless the latest request to the API server failed
+// because the server couldn't be reached, in which case it returns why.
+func (kf *Config) UnreachableReason() string {
+	kf.configLock.Lock()
+	health := kf.health
+	kf.configLock.Unlock()
+	if health == nil {
+		return ""
+	}
+	return health.unreachableReason()
+}
+
+// BreakerReason returns an empty string unless the restarts of the watches of the API server are
+// held back because they've been restarted too often, in which case it returns why.
+func (kf *Config) BreakerReason() string {
+	return kf.watchBreaker().OpenReason()
+}
+
+// watchBreaker returns the breaker that the restarts of the watches of the API server wait for.
+func (kf *Config) watchBreaker() *client.Breaker {
+	kf.configLock.Lock()
+	defer kf.configLock.Unlock()
+	return kf.breaker
+}
+
+// setConfigFlagsKubeconfig makes the ConfigFlags load the kubeconfig of this config. The ConfigFlags
+// only accept one explicit file, and read the KUBECONFIG of this process when they're given none, so
+// when several files are merged, or when the in-cluster config is used, the merged config is written
+// to a private file in the user's cache directory instead. That file is rewritten when the config is
+// reloaded. It must be called before the ConfigFlags are used.
+func (kf *Config) setConfigFlagsKubeconfig(c context.Context) error {
+	files := kf.kubeconfigFiles
+	if len(files) == 0 {
+		if _, err := os.Stat(clientcmd.RecommendedHomeFile); err == nil {
+			files = []string{clientcmd.RecommendedHomeFile}
+		}
+	}
+	if len(files) == 1 {
+		kf.ConfigFlags.KubeConfig = &files[0]
+		return nil
+	}
+
+	dir, err := filelocation.AppUserCacheDir(c)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	kf.configLock.Lock()
+	defer kf.configLock.Unlock()
+	kf.configFlagsFile = filepath.Join(dir, "kubeconfig")
+	if err = kf.writeConfigFlagsKubeconfig(); err != nil {
+		return err
+	}
+	kf.ConfigFlags.KubeConfig = &kf.configFlagsFile
+	return nil
+}
+
+// writeConfigFlagsKubeconfig writes the merged kubeconfig files, or a kubeconfig that uses the
+// credentials of the pod when there are none, to the configFlagsFile. The paths in the merged
+// config are absolute, because the loader resolves them relative to the files that they're read
+// from. The configLock must be held.
+func (kf *Config) writeConfigFlagsKubeconfig() error {
+	config, err := kf.configLoader().RawConfig()
+	if err != nil {
+		return err
+	}
+	if len(config.Contexts) == 0 {
+		rc, err := rest.InClusterConfig()
+		if err != nil {
+			return err
+		}
+		config = *api.NewConfig()
+		config.Clusters[inClusterContext] = &api.Cluster{Server: rc.Host, CertificateAuthority: rc.TLSClientConfig.CAFile}
+		config.AuthInfos[inClusterContext] = &api.AuthInfo{TokenFile: rc.BearerTokenFile}
+		config.Contexts[inClusterContext] = &api.Context{Cluster: inClusterContext, AuthInfo: inClusterContext}
+		config.CurrentContext = inClusterContext
+	}
+	return clientcmd.WriteToFile(config, kf.configFlagsFile)
+}
+
+// BearerToken returns the bearer token that the kubeconfig uses to authenticate with the API server,
+// or an empty string if it uses some other means of authentication.
+func (kf *Config) BearerToken() string {
+	config := kf.restConfig()
+	if config.BearerToken != "" {
+		return config.BearerToken
+	}
+	if config.BearerTokenFile != "" {
+		if data, err := ioutil.ReadFile(config.BearerTokenFile); err == nil {
+			return strings.TrimSpace(string(data))
+		}
+	}
+	return ""
+}
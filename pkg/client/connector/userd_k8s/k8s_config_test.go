@@ -0,0 +1,182 @@
+package userd_k8s
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/filelocation"
+)
+
+const testKubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: dev
+  cluster:
+    server: https://dev.example.com
+    extensions:
+    - name: telepresence.io
+      extension:
+        manager:
+          namespace: tel
+        also-proxy:
+        - 10.10.0.0/16
+        dns:
+          include-suffixes: [.dev]
+        mapped-namespaces: [a, b]
+contexts:
+- name: dev
+  context:
+    cluster: dev
+    user: dev
+- name: dev-team
+  context:
+    cluster: dev
+    user: dev
+    extensions:
+    - name: telepresence.io
+      extension:
+        dns:
+          exclude-suffixes: [.com]
+        manager:
+          endpoint: tm.example.com:443
+          endpoint-tls: true
+          quic-endpoint: tm-quic.example.com:8082
+        mapped-namespaces: [team]
+current-context: dev
+users:
+- name: dev
+  user:
+    token: abc
+`
+
+func TestNewConfigExtensions(t *testing.T) {
+	kubeconfig := filepath.Join(t.TempDir(), "config")
+	require.NoError(t, ioutil.WriteFile(kubeconfig, []byte(testKubeconfig), 0600))
+	env := client.Env{ManagerNamespace: "ambassador"}
+
+	cfg, err := NewConfig(map[string]string{"kubeconfig": kubeconfig}, env)
+	require.NoError(t, err)
+	assert.Equal(t, "tel", cfg.Manager.Namespace)
+	require.Len(t, cfg.AlsoProxy, 1)
+	assert.Equal(t, "10.10.0.0/16", (*net.IPNet)(cfg.AlsoProxy[0]).String())
+	assert.Equal(t, []string{".dev"}, cfg.DNS.IncludeSuffixes)
+	assert.Equal(t, []string{"a", "b"}, cfg.MappedNamespaces)
+	assert.Empty(t, cfg.Manager.Endpoint)
+	assert.Empty(t, cfg.Manager.QUICEndpoint)
+
+	// The extension of the context takes precedence over the one of the cluster
+	cfg, err = NewConfig(map[string]string{"kubeconfig": kubeconfig, "context": "dev-team"}, env)
+	require.NoError(t, err)
+	assert.Equal(t, "tel", cfg.Manager.Namespace)
+	assert.Equal(t, []string{".dev"}, cfg.DNS.IncludeSuffixes)
+	assert.Equal(t, []string{".com"}, cfg.DNS.ExcludeSuffixes)
+	assert.Equal(t, []string{"team"}, cfg.MappedNamespaces)
+	assert.Equal(t, "tm.example.com:443", cfg.Manager.Endpoint)
+	assert.True(t, cfg.Manager.EndpointTLS)
+	assert.Equal(t, "tm-quic.example.com:8082", cfg.Manager.QUICEndpoint)
+}
+
+const testKubeconfigProd = `apiVersion: v1
+kind: Config
+clusters:
+- name: prod
+  cluster:
+    server: https://prod.example.com
+contexts:
+- name: prod
+  context:
+    cluster: prod
+    user: prod
+    namespace: shop
+current-context: prod
+users:
+- name: prod
+  user:
+    token: def
+`
+
+func TestNewConfigKubeconfigList(t *testing.T) {
+	dir := t.TempDir()
+	dev := filepath.Join(dir, "dev")
+	prod := filepath.Join(dir, "prod")
+	require.NoError(t, ioutil.WriteFile(dev, []byte(testKubeconfig), 0600))
+	require.NoError(t, ioutil.WriteFile(prod, []byte(testKubeconfigProd), 0600))
+	list := dev + string(filepath.ListSeparator) + prod
+	env := client.Env{ManagerNamespace: "ambassador"}
+
+	// The KUBECONFIG of the CLI lists the files, and the first one decides the current context
+	cfg, err := NewConfig(map[string]string{KubeconfigEnvKey: list}, env)
+	require.NoError(t, err)
+	assert.Equal(t, "dev", cfg.Context)
+	assert.Equal(t, "https://dev.example.com", cfg.Server)
+	assert.Equal(t, "abc", cfg.BearerToken())
+
+	// Contexts of all files can be selected
+	cfg, err = NewConfig(map[string]string{KubeconfigEnvKey: list, "context": "prod"}, env)
+	require.NoError(t, err)
+	assert.Equal(t, "https://prod.example.com", cfg.Server)
+	assert.Equal(t, "shop", cfg.Namespace)
+	assert.Equal(t, "def", cfg.BearerToken())
+
+	// The --kubeconfig flag takes precedence over KUBECONFIG, and may list files too
+	pcfg, err := NewConfig(map[string]string{KubeconfigEnvKey: dev, "kubeconfig": prod + string(filepath.ListSeparator) + dev}, env)
+	require.NoError(t, err)
+	assert.Equal(t, "prod", pcfg.Context)
+	assert.False(t, pcfg.Equals(cfg))
+}
+
+func TestConfigReload(t *testing.T) {
+	kubeconfig := filepath.Join(t.TempDir(), "config")
+	require.NoError(t, ioutil.WriteFile(kubeconfig, []byte(testKubeconfig), 0600))
+	cfg, err := NewConfig(map[string]string{"kubeconfig": kubeconfig}, client.Env{})
+	require.NoError(t, err)
+	assert.Equal(t, "abc", cfg.BearerToken())
+
+	// A refreshed token is picked up
+	require.NoError(t, ioutil.WriteFile(kubeconfig, []byte(strings.Replace(testKubeconfig, "token: abc", "token: xyz", 1)), 0600))
+	require.NoError(t, os.Chtimes(kubeconfig, time.Now(), time.Now().Add(time.Second)))
+	assert.Equal(t, "xyz", cfg.BearerToken())
+
+	// A config that points to another server is not
+	require.NoError(t, ioutil.WriteFile(kubeconfig, []byte(testKubeconfigProd), 0600))
+	require.NoError(t, os.Chtimes(kubeconfig, time.Now(), time.Now().Add(2*time.Second)))
+	assert.Equal(t, "xyz", cfg.BearerToken())
+}
+
+func TestConfigFlagsKubeconfigList(t *testing.T) {
+	dir := t.TempDir()
+	dev := filepath.Join(dir, "dev")
+	prod := filepath.Join(dir, "prod")
+	require.NoError(t, ioutil.WriteFile(dev, []byte(testKubeconfig), 0600))
+	require.NoError(t, ioutil.WriteFile(prod, []byte(testKubeconfigProd), 0600))
+	list := dev + string(filepath.ListSeparator) + prod
+	ctx := filelocation.WithUserHomeDir(context.Background(), filepath.Join(dir, "home"))
+
+	// The ConfigFlags load the merged files without the KUBECONFIG of this process
+	cfg, err := NewConfig(map[string]string{KubeconfigEnvKey: list, "context": "prod"}, client.Env{})
+	require.NoError(t, err)
+	require.NoError(t, cfg.setConfigFlagsKubeconfig(ctx))
+	rc, err := cfg.ConfigFlags.ToRESTConfig()
+	require.NoError(t, err)
+	assert.Equal(t, "https://prod.example.com", rc.Host)
+	assert.Equal(t, "def", rc.BearerToken)
+	assert.Equal(t, 0, cfg.configReloads())
+
+	// The file is rewritten when the config is reloaded
+	require.NoError(t, ioutil.WriteFile(prod, []byte(strings.Replace(testKubeconfigProd, "token: def", "token: xyz", 1)), 0600))
+	require.NoError(t, os.Chtimes(prod, time.Now(), time.Now().Add(time.Second)))
+	assert.Equal(t, 1, cfg.configReloads())
+	rc, err = cfg.ConfigFlags.ToRESTConfig()
+	require.NoError(t, err)
+	assert.Equal(t, "xyz", rc.BearerToken)
+}
@@ -0,0 +1,162 @@
+package userd_k8s
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/version"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+)
+
+// The features of the support matrix
+const (
+	// FeatureWorkloads is what telepresence needs from any cluster, i.e. the apps/v1 workloads
+	// that the traffic-manager is installed as and that the traffic-agent is added to.
+	FeatureWorkloads = "workloads"
+
+	// FeatureAgentInjector is the mutating webhook that the traffic-manager injects the
+	// traffic-agent with, unless cluster.agentInjection is "patch".
+	FeatureAgentInjector = "agent-injector"
+)
+
+// kubeVersion is the major and minor version of a Kubernetes API server.
+type kubeVersion struct {
+	major int
+	minor int
+}
+
+func (v kubeVersion) String() string {
+	return fmt.Sprintf("%d.%d", v.major, v.minor)
+}
+
+func (v kubeVersion) isZero() bool {
+	return v.major == 0 && v.minor == 0
+}
+
+func (v kubeVersion) less(o kubeVersion) bool {
+	return v.major < o.major || v.major == o.major && v.minor < o.minor
+}
+
+// versionRequirement is an entry of the support matrix. It tells what versions of Kubernetes a
+// feature can be used with.
+type versionRequirement struct {
+	feature string
+
+	// min is the first version that supports the feature, and max, unless zero, the first version
+	// that no longer does.
+	min kubeVersion
+	max kubeVersion
+
+	// what is what the feature is called in messages, and reason what it needs from the API
+	// server.
+	what   string
+	reason string
+
+	// required makes an unsupported version fatal. Otherwise, the feature is disabled with a
+	// warning.
+	required bool
+
+	// hint is added to the message about an unsupported version.
+	hint string
+
+	// used tells if the feature is used with the given configuration
+	used func(cfg *client.Cluster) bool
+}
+
+// supportMatrix lists the features that depend on the version of the cluster.
+var supportMatrix = []versionRequirement{
+	{
+		feature:  FeatureWorkloads,
+		what:     "telepresence",
+		min:      kubeVersion{1, 9},
+		reason:   "the apps/v1 API of Deployments, ReplicaSets, and StatefulSets",
+		required: true,
+		used:     func(*client.Cluster) bool { return true },
+	},
+	{
+		feature:  FeatureAgentInjector,
+		what:     "the agent injector of the traffic-manager",
+		min:      kubeVersion{1, 16},
+		reason:   "admissionregistration.k8s.io/v1 MutatingWebhookConfigurations",
+		required: true,
+		hint: fmt.Sprintf("Set cluster.agentInjection to %q in the config.yml to have the traffic-agent added by telepresence instead",
+			client.AgentInjectionPatch),
+		used: func(cfg *client.Cluster) bool { return cfg.AgentInjection != client.AgentInjectionPatch },
+	},
+}
+
+func (r *versionRequirement) supports(v kubeVersion) bool {
+	return !v.less(r.min) && (r.max.isZero() || v.less(r.max))
+}
+
+// message describes why the given version doesn't support the requirement.
+func (r *versionRequirement) message(v kubeVersion) string {
+	var msg string
+	if v.less(r.min) {
+		msg = fmt.Sprintf("%s requires Kubernetes %s or newer because it uses %s", r.what, r.min, r.reason)
+	} else {
+		msg = fmt.Sprintf("%s isn't available with Kubernetes %s or newer because it uses %s", r.what, r.max, r.reason)
+	}
+	if r.hint != "" {
+		msg += ". " + r.hint
+	}
+	return msg
+}
+
+// checkVersion checks the given version against the support matrix. It returns an error when a
+// feature that is required with the given configuration is unsupported, and the warnings about
+// the features that are used but unavailable.
+func checkVersion(v kubeVersion, cfg *client.Cluster) (warnings []string, err error) {
+	var fatal []string
+	for i := range supportMatrix {
+		r := &supportMatrix[i]
+		if !r.used(cfg) || r.supports(v) {
+			continue
+		}
+		if r.required {
+			fatal = append(fatal, r.message(v))
+		} else {
+			warnings = append(warnings, r.message(v))
+		}
+	}
+	if len(fatal) > 0 {
+		return warnings, fmt.Errorf("Kubernetes %s is not supported: %s", v, strings.Join(fatal, "; "))
+	}
+	return warnings, nil
+}
+
+// Supports returns false if the version of the cluster is known to not support the given
+// feature of the support matrix.
+func (kc *Cluster) Supports(feature string) bool {
+	if kc.serverVersion.isZero() {
+		return true
+	}
+	for i := range supportMatrix {
+		if r := &supportMatrix[i]; r.feature == feature {
+			return r.supports(kc.serverVersion)
+		}
+	}
+	return true
+}
+
+var gitVersionRx = regexp.MustCompile(`^v?(\d+)\.(\d+)`)
+
+// parseServerVersion returns the major and minor version of the given server version info.
+// Providers sometimes add a suffix to the minor version, as in "18+", and sometimes leave the
+// major and minor version empty, in which case they are taken from the git version.
+func parseServerVersion(info *version.Info) (kubeVersion, error) {
+	major, errMajor := strconv.Atoi(strings.TrimRight(info.Major, "+"))
+	minor, errMinor := strconv.Atoi(strings.TrimRight(info.Minor, "+"))
+	if errMajor == nil && errMinor == nil {
+		return kubeVersion{major, minor}, nil
+	}
+	if m := gitVersionRx.FindStringSubmatch(info.GitVersion); m != nil {
+		major, _ = strconv.Atoi(m[1])
+		minor, _ = strconv.Atoi(m[2])
+		return kubeVersion{major, minor}, nil
+	}
+	return kubeVersion{}, fmt.Errorf("unable to parse server version %q", info.GitVersion)
+}
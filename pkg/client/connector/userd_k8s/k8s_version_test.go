@@ -0,0 +1,63 @@
+package userd_k8s
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/version"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+)
+
+func TestParseServerVersion(t *testing.T) {
+	v, err := parseServerVersion(&version.Info{Major: "1", Minor: "21", GitVersion: "v1.21.2"})
+	require.NoError(t, err)
+	assert.Equal(t, kubeVersion{1, 21}, v)
+
+	v, err = parseServerVersion(&version.Info{Major: "1", Minor: "18+", GitVersion: "v1.18.20-eks-8c579e"})
+	require.NoError(t, err)
+	assert.Equal(t, kubeVersion{1, 18}, v)
+
+	v, err = parseServerVersion(&version.Info{GitVersion: "v1.20.4+k3s1"})
+	require.NoError(t, err)
+	assert.Equal(t, kubeVersion{1, 20}, v)
+
+	_, err = parseServerVersion(&version.Info{GitVersion: "unknown"})
+	assert.Error(t, err)
+}
+
+func TestCheckVersion(t *testing.T) {
+	webhook := &client.Cluster{AgentInjection: client.AgentInjectionWebhook, DisruptionBudgets: client.DisruptionBudgetsWarn}
+	patch := &client.Cluster{AgentInjection: client.AgentInjectionPatch, DisruptionBudgets: client.DisruptionBudgetsIgnore}
+
+	warnings, err := checkVersion(kubeVersion{1, 21}, webhook)
+	assert.NoError(t, err)
+	assert.Empty(t, warnings)
+
+	_, err = checkVersion(kubeVersion{1, 15}, webhook)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Kubernetes 1.15 is not supported: the agent injector of the traffic-manager requires Kubernetes 1.16 or newer")
+
+	warnings, err = checkVersion(kubeVersion{1, 15}, patch)
+	assert.NoError(t, err)
+	assert.Empty(t, warnings)
+
+	_, err = checkVersion(kubeVersion{1, 8}, patch)
+	assert.Error(t, err)
+
+	// The PodDisruptionBudgets are checked using policy/v1 on newer clusters
+	warnings, err = checkVersion(kubeVersion{1, 26}, webhook)
+	assert.NoError(t, err)
+	assert.Empty(t, warnings)
+}
+
+func TestSupports(t *testing.T) {
+	kc := &Cluster{}
+	assert.True(t, kc.Supports(FeatureAgentInjector), "an unknown version supports everything")
+	kc.serverVersion = kubeVersion{1, 15}
+	assert.False(t, kc.Supports(FeatureAgentInjector))
+	assert.True(t, kc.Supports(FeatureWorkloads))
+	kc.serverVersion = kubeVersion{1, 26}
+	assert.True(t, kc.Supports(FeatureAgentInjector))
+}
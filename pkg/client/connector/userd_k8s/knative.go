@@ -0,0 +1,46 @@
+package userd_k8s
+
+import (
+	"context"
+
+	"github.com/datawire/ambassador/pkg/kates"
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/install"
+)
+
+// knativeServiceKind is the kind of a Knative Service qualified with its group, so that it isn't
+// mistaken for a Kubernetes Service.
+const knativeServiceKind = "Service.serving.knative.dev"
+
+// IsKnative returns true if Knative Serving is installed in the cluster. The result is cached.
+func (kc *Cluster) IsKnative(c context.Context) bool {
+	kc.knativeOnce.Do(func() {
+		var err error
+		if kc.knative, err = install.IsKnative(kc.restConfig()); err != nil {
+			dlog.Errorf(c, "unable to determine if Knative Serving is installed: %v", err)
+		}
+	})
+	return kc.knative
+}
+
+// KnativeServiceNames returns the names of all Knative Services found in the given Namespace. The
+// result is always empty when Knative Serving isn't installed.
+func (kc *Cluster) KnativeServiceNames(c context.Context, namespace string) ([]string, error) {
+	if !kc.IsKnative(c) {
+		return nil, nil
+	}
+	return kc.kindNames(c, knativeServiceKind, namespace)
+}
+
+// FindKnativeService returns the Knative Service with the given name in the given namespace.
+func (kc *Cluster) FindKnativeService(c context.Context, namespace, name string) (*kates.Unstructured, error) {
+	ksvc := &kates.Unstructured{}
+	ksvc.SetAPIVersion(install.KnativeServiceAPIVersion)
+	ksvc.SetKind("Service")
+	ksvc.SetNamespace(namespace)
+	ksvc.SetName(name)
+	if err := kc.Client().Get(c, ksvc, ksvc); err != nil {
+		return nil, err
+	}
+	return ksvc, nil
+}
@@ -0,0 +1,48 @@
+package userd_k8s
+
+import (
+	"context"
+
+	"github.com/datawire/ambassador/pkg/kates"
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/install"
+)
+
+// IsOpenShift returns true if the cluster is an OpenShift cluster. Only a successful check is
+// cached, so a check that fails is made again on the next call.
+func (kc *Cluster) IsOpenShift(c context.Context) bool {
+	kc.openShiftLock.Lock()
+	defer kc.openShiftLock.Unlock()
+	if kc.openShift == nil {
+		is, err := install.IsOpenShift(kc.restConfig())
+		if err != nil {
+			dlog.Errorf(c, "unable to determine if cluster is OpenShift: %v", err)
+			return false
+		}
+		kc.openShift = &is
+	}
+	return *kc.openShift
+}
+
+// DeploymentConfigNames returns the names of all OpenShift deployment configs found in the given
+// Namespace. The result is always empty when the cluster isn't an OpenShift cluster.
+func (kc *Cluster) DeploymentConfigNames(c context.Context, namespace string) ([]string, error) {
+	if !kc.IsOpenShift(c) {
+		return nil, nil
+	}
+	return kc.kindNames(c, "DeploymentConfig", namespace)
+}
+
+// FindDeploymentConfig returns the OpenShift deployment config with the given name in the given
+// namespace.
+func (kc *Cluster) FindDeploymentConfig(c context.Context, namespace, name string) (*kates.Unstructured, error) {
+	dc := &kates.Unstructured{}
+	dc.SetAPIVersion(install.DeploymentConfigAPIVersion)
+	dc.SetKind("DeploymentConfig")
+	dc.SetNamespace(namespace)
+	dc.SetName(name)
+	if err := kc.Client().Get(c, dc, dc); err != nil {
+		return nil, err
+	}
+	return dc, nil
+}
@@ -0,0 +1,37 @@
+package userd_k8s
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/install"
+)
+
+func TestIsOpenShift(t *testing.T) {
+	// The API server fails the first check, and serves the OpenShift API after that
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(&metav1.APIResourceList{GroupVersion: install.DeploymentConfigAPIVersion})
+	}))
+	defer srv.Close()
+
+	kc := &Cluster{Config: &Config{config: &rest.Config{Host: srv.URL}}}
+	ctx := dlog.NewTestContext(t, false)
+	assert.False(t, kc.IsOpenShift(ctx))
+	assert.True(t, kc.IsOpenShift(ctx), "a failed check is made again")
+	assert.True(t, kc.IsOpenShift(ctx))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&requests), "a successful check is cached")
+}
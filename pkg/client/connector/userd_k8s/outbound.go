@@ -3,12 +3,14 @@ package userd_k8s
 import (
 	"context"
 	"sort"
+	"strings"
 
 	"github.com/datawire/ambassador/pkg/kates"
 	"github.com/datawire/dlib/derror"
 	"github.com/datawire/dlib/dgroup"
 	"github.com/datawire/dlib/dlog"
 	"github.com/telepresenceio/telepresence/rpc/v2/daemon"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
 )
 
 // RunWatchers runs a set of Kubernetes watchers that provide information from the cluster which is
@@ -35,33 +37,59 @@ func (kc *Cluster) RunWatchers(c context.Context) (err error) {
 		}
 	}()
 
-	acc := kc.client.Watch(c,
-		kates.Query{
-			Name: "Namespaces",
-			Kind: "namespace",
-		})
+	if ok, err := kc.CanI(c, "watch", "", "namespaces", ""); err == nil && !ok {
+		// Namespaced permissions only. Use a fixed set of namespaces and don't watch.
+		names := kc.staticNamespaces()
+		dlog.Warnf(c, "The current user is not allowed to watch namespaces. Telepresence will be limited to namespaces %v. "+
+			"Use --mapped-namespaces to change this.", names)
+		kc.accLock.Lock()
+		kc.curSnapshot.Namespaces = make([]*objName, len(names))
+		for i, name := range names {
+			kc.curSnapshot.Namespaces[i] = &objName{nameMeta{Name: name}}
+		}
+		kc.accLock.Unlock()
+		kc.refreshNamespaces(c, kc.accWait)
+		kc.setReady()
+		return kc.runWorkloadCaches(c)
+	}
 
 	g := dgroup.NewGroup(c, dgroup.GroupConfig{})
 
+	g.Go("workload-caches", kc.runWorkloadCaches)
+
 	g.Go("namespaces", func(c context.Context) error {
-		accWait := kc.accWait
-		for {
-			select {
-			case <-c.Done():
-				return nil
-			case <-acc.Changed():
-				if kc.onNamespacesChange(c, acc, accWait) {
-					if accWait != nil {
-						close(accWait)
-						accWait = nil // accWait is one-shot
-					}
-				}
-			}
-		}
+		// A watch that fails, e.g. because the API server couldn't be reached when it started,
+		// is restarted. The namespaces of the last snapshot remain in effect in the meantime.
+		_ = client.RetryWithBreaker(c, "watch of namespaces", kc.watchBreaker(), kc.watchNamespaces, watchRetryDelay, watchMaxRetryDelay)
+		return nil
 	})
 	return g.Wait()
 }
 
+func (kc *Cluster) watchNamespaces(c context.Context) (err error) {
+	defer func() {
+		if err = derror.PanicToError(recover()); err != nil {
+			dlog.Errorf(c, "watch of namespaces failed: %v", err)
+		}
+	}()
+
+	acc := kc.Client().Watch(c,
+		kates.Query{
+			Name: "Namespaces",
+			Kind: "namespace",
+		})
+	for {
+		select {
+		case <-c.Done():
+			return nil
+		case <-acc.Changed():
+			if kc.onNamespacesChange(c, acc, kc.accWait) {
+				kc.setReady()
+			}
+		}
+	}
+}
+
 func (kc *Cluster) onNamespacesChange(c context.Context, acc *kates.Accumulator, accWait chan<- struct{}) bool {
 	changed := func() bool {
 		kc.accLock.Lock()
@@ -103,15 +131,100 @@ func (kc *Cluster) refreshNamespaces(c context.Context, accWait chan<- struct{})
 	}
 	if nsChange {
 		kc.lastNamespaces = namespaces
+		lazy := kc.isLazyLocked(c)
+		if lazy != kc.lazy {
+			kc.lazy = lazy
+			if lazy {
+				dlog.Infof(c, "There are %d mapped namespaces. The DNS entries and caches of a namespace will not "+
+					"be created until it's first referenced. Use --mapped-namespaces to change this.", len(namespaces))
+			}
+		}
 	}
 	kc.accLock.Unlock()
 
+	if nsChange {
+		select {
+		case kc.nsChanged <- struct{}{}:
+		default:
+		}
+	}
+
 	if nsChange {
 		kc.updateDaemonNamespaces(c)
 	}
 	return nsChange
 }
 
+// isLazyLocked returns true if the number of mapped namespaces exceeds the lazyNamespaceThreshold of
+// the config and the user didn't choose what namespaces to map.
+func (kc *Cluster) isLazyLocked(c context.Context) bool {
+	return len(kc.mappedNamespaces) == 0 && len(kc.lastNamespaces) > client.GetConfig(c).Cluster.LazyNamespaceThreshold
+}
+
+// activeNamespacesLocked returns the mapped namespaces that get DNS entries and caches. When
+// namespaces are mapped lazily, those are the ones that have been referenced and the namespace of
+// the current context.
+func (kc *Cluster) activeNamespacesLocked(c context.Context) []string {
+	if !kc.lazy {
+		return kc.lastNamespaces
+	}
+	var active []string
+	for _, ns := range kc.lastNamespaces {
+		if _, ok := kc.referencedNamespaces[ns]; ok || ns == kc.Namespace {
+			active = append(active, ns)
+		}
+	}
+	return active
+}
+
+// referenceNamespace records that the given namespace has been referenced. The daemon's DNS entries
+// and the caches are updated when that makes the namespace active.
+func (kc *Cluster) referenceNamespace(c context.Context, namespace string) {
+	if namespace == "" {
+		return
+	}
+	kc.accLock.Lock()
+	_, found := kc.referencedNamespaces[namespace]
+	if !found {
+		kc.referencedNamespaces[namespace] = struct{}{}
+	}
+	lazy := kc.lazy
+	kc.accLock.Unlock()
+	if found || !lazy {
+		return
+	}
+	dlog.Debugf(c, "namespace %s is referenced", namespace)
+	select {
+	case kc.nsChanged <- struct{}{}:
+	default:
+	}
+	kc.updateDaemonNamespaces(c)
+}
+
+// ReferenceHost records that the namespace that the given host name is in, if any, has been
+// referenced. It's called with the names that the daemon's DNS resolver looks up, so that e.g.
+// "svc.otherns" gets the DNS entries of namespace "otherns" when namespaces are mapped lazily.
+func (kc *Cluster) ReferenceHost(c context.Context, host string) {
+	if ns := hostNamespace(host); ns != "" && kc.namespaceExists(ns) {
+		kc.referenceNamespace(c, ns)
+	}
+}
+
+// hostNamespace returns the namespace of the given host name when it has the form
+// <name>.<namespace>, or <name>.<namespace>.svc or <name>.<namespace>.pod followed by the cluster
+// domain, and an empty string otherwise.
+func hostNamespace(host string) string {
+	labels := strings.Split(strings.TrimSuffix(host, "."), ".")
+	switch {
+	case len(labels) == 2:
+		return labels[1]
+	case len(labels) > 2 && (labels[2] == "svc" || labels[2] == "pod"):
+		return labels[1]
+	default:
+		return ""
+	}
+}
+
 func (kc *Cluster) shouldBeWatched(namespace string) bool {
 	// The "kube-system" namespace must be mapped when hijacking the IP of the
 	// kube-dns service in the daemon.
@@ -133,8 +246,6 @@ func (kc *Cluster) SetInterceptedNamespaces(c context.Context, interceptedNamesp
 	kc.updateDaemonNamespaces(c)
 }
 
-const clusterServerSuffix = ".svc.cluster.local"
-
 // updateDaemonNamespacesLocked will create a new DNS search path from the given namespaces and
 // send it to the DNS-resolver in the daemon.
 func (kc *Cluster) updateDaemonNamespaces(c context.Context) {
@@ -161,8 +272,9 @@ func (kc *Cluster) updateDaemonNamespaces(c context.Context) {
 
 	// Pass current mapped namespaces as plain names (no ending dot). The DNS-resolver will
 	// create special mapping for those, allowing names like myservice.mynamespace to be resolved
-	paths := make([]string, len(kc.lastNamespaces), len(kc.lastNamespaces)+len(namespaces))
-	copy(paths, kc.lastNamespaces)
+	active := kc.activeNamespacesLocked(c)
+	paths := make([]string, len(active), len(active)+len(namespaces))
+	copy(paths, active)
 
 	// Avoid being locked for the remainder of this function.
 	kc.accLock.Unlock()
@@ -170,7 +282,7 @@ func (kc *Cluster) updateDaemonNamespaces(c context.Context) {
 	// Provide direct access to intercepted namespaces
 	sort.Strings(namespaces)
 	for _, ns := range namespaces {
-		paths = append(paths, ns+clusterServerSuffix+".")
+		paths = append(paths, ns+".svc."+kc.ClusterDomain(c)+".")
 	}
 	dlog.Debugf(c, "posting search paths %v", paths)
 	if _, err := kc.callbacks.SetDNSSearchPath(c, &daemon.Paths{Paths: paths}); err != nil {
@@ -0,0 +1,68 @@
+package userd_k8s
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	empty "google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/rpc/v2/daemon"
+)
+
+func TestHostNamespace(t *testing.T) {
+	for host, ns := range map[string]string{
+		"svc.otherns":                          "otherns",
+		"svc.otherns.":                         "otherns",
+		"svc.otherns.svc.cluster.local":        "otherns",
+		"10-0-0-1.otherns.pod.cluster.local":   "otherns",
+		"svc":                                  "",
+		"www.example.com":                      "",
+		"svc.otherns.svc.cluster.local.extra.": "otherns",
+	} {
+		assert.Equal(t, ns, hostNamespace(host), host)
+	}
+}
+
+func TestReferenceHost(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	var paths []string
+	kc := &Cluster{
+		Config:         &Config{Namespace: "default"},
+		lastNamespaces: []string{"default", "otherns", "thirdns"},
+		lazy:           true,
+		callbacks: Callbacks{
+			SetDNSSearchPath: func(_ context.Context, in *daemon.Paths, _ ...grpc.CallOption) (*empty.Empty, error) {
+				paths = in.Paths
+				return &empty.Empty{}, nil
+			},
+		},
+		nsChanged:            make(chan struct{}, 1),
+		referencedNamespaces: make(map[string]struct{}),
+	}
+	kc.curSnapshot.Namespaces = []*objName{{nameMeta{Name: "default"}}, {nameMeta{Name: "otherns"}}, {nameMeta{Name: "thirdns"}}}
+
+	// A lookup of a name in another namespace makes that namespace active
+	kc.ReferenceHost(ctx, "svc.otherns")
+	assert.Equal(t, []string{"default", "otherns"}, paths)
+
+	// Names that aren't in a namespace of the cluster don't
+	paths = nil
+	kc.ReferenceHost(ctx, "www.example.com")
+	kc.ReferenceHost(ctx, "svc.nons")
+	assert.Nil(t, paths)
+}
+
+func TestSetReady(t *testing.T) {
+	kc := &Cluster{accWait: make(chan struct{})}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Error(t, kc.WaitUntilReady(ctx))
+
+	// The watch of the namespaces may find them ready again after a restart
+	kc.setReady()
+	kc.setReady()
+	assert.NoError(t, kc.WaitUntilReady(context.Background()))
+}
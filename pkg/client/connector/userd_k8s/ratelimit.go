@@ -0,0 +1,124 @@
+package userd_k8s
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"k8s.io/client-go/rest"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+)
+
+const (
+	minAPFBackoff = 250 * time.Millisecond
+	maxAPFBackoff = 10 * time.Second
+
+	// The headers that the API Priority and Fairness of the API server adds to its responses
+	apfPriorityLevelHeader = "X-Kubernetes-PF-PriorityLevel-UID"
+	apfFlowSchemaHeader    = "X-Kubernetes-PF-FlowSchema-UID"
+)
+
+// apfBackoff makes all the requests to an API server back off when the API Priority and Fairness of
+// that server rejects requests with "429 Too Many Requests". Client-go retries a rejected request
+// after the delay that the API server asks for, but the other requests keep coming at the full
+// rate, so a client that is throttled tends to stay throttled until its requests time out. The
+// backoff doubles with each rejection, up to maxAPFBackoff, and is halved by each request that
+// is accepted.
+type apfBackoff struct {
+	ctx   context.Context
+	mu    sync.Mutex
+	delay time.Duration
+	until time.Time
+}
+
+func newAPFBackoff(ctx context.Context) *apfBackoff {
+	return &apfBackoff{ctx: ctx}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// wrap returns a round tripper that delays the requests of the given round tripper while the
+// backoff is in effect.
+func (b *apfBackoff) wrap(rt http.RoundTripper) http.RoundTripper {
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		if err := b.wait(req.Context()); err != nil {
+			return nil, err
+		}
+		resp, err := rt.RoundTrip(req)
+		if err == nil {
+			b.update(resp)
+		}
+		return resp, err
+	})
+}
+
+func (b *apfBackoff) wait(ctx context.Context) error {
+	b.mu.Lock()
+	d := time.Until(b.until)
+	b.mu.Unlock()
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func (b *apfBackoff) update(resp *http.Response) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if resp.StatusCode != http.StatusTooManyRequests {
+		if b.delay > 0 {
+			if b.delay /= 2; b.delay < minAPFBackoff {
+				b.delay = 0
+				dlog.Info(b.ctx, "The API server no longer throttles requests")
+			}
+		}
+		return
+	}
+
+	first := b.delay == 0
+	if first {
+		b.delay = minAPFBackoff
+	} else if b.delay *= 2; b.delay > maxAPFBackoff {
+		b.delay = maxAPFBackoff
+	}
+	delay := b.delay
+	if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && time.Duration(secs)*time.Second > delay {
+		delay = time.Duration(secs) * time.Second
+	}
+	b.until = time.Now().Add(delay)
+	if first {
+		dlog.Warnf(b.ctx, "The API server throttles requests (priority level %q, flow schema %q), backing off",
+			resp.Header.Get(apfPriorityLevelHeader), resp.Header.Get(apfFlowSchemaHeader))
+	} else {
+		dlog.Debugf(b.ctx, "The API server throttles requests, backing off for %s", delay)
+	}
+}
+
+// configureRateLimits applies the QPS and Burst of the config.yml to the given REST config, and makes
+// its clients use the given backoff.
+func configureRateLimits(ctx context.Context, config *rest.Config, backoff *apfBackoff) *rest.Config {
+	cc := client.GetConfig(ctx).Cluster
+	if cc.QPS > 0 {
+		config.QPS = cc.QPS
+	}
+	if cc.Burst > 0 {
+		config.Burst = cc.Burst
+	}
+	config.Wrap(backoff.wrap)
+	return config
+}
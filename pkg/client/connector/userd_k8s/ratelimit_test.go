@@ -0,0 +1,55 @@
+package userd_k8s
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+)
+
+func TestAPFBackoff(t *testing.T) {
+	var rejects int32 = 2
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&rejects, -1) >= 0 {
+			w.Header().Set(apfPriorityLevelHeader, "workload-low")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	ctx := dlog.NewTestContext(t, false)
+	b := newAPFBackoff(ctx)
+	rt := b.wrap(http.DefaultTransport)
+	get := func() int {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+		require.NoError(t, err)
+		resp, err := rt.RoundTrip(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// The first rejection starts the backoff
+	assert.Equal(t, http.StatusTooManyRequests, get())
+	assert.Equal(t, minAPFBackoff, b.delay)
+
+	// The next request waits for the backoff, and another rejection doubles it
+	start := time.Now()
+	assert.Equal(t, http.StatusTooManyRequests, get())
+	assert.GreaterOrEqual(t, int64(time.Since(start)), int64(minAPFBackoff))
+	assert.Equal(t, 2*minAPFBackoff, b.delay)
+
+	// Accepted requests halve the backoff until it's gone
+	assert.Equal(t, http.StatusOK, get())
+	assert.Equal(t, minAPFBackoff, b.delay)
+	assert.Equal(t, http.StatusOK, get())
+	assert.Equal(t, time.Duration(0), b.delay)
+}
@@ -0,0 +1,109 @@
+// Package userd_mock provides the mock cluster that the connector serves when it's started using
+// "telepresence connect --mock-cluster". The mock cluster has a fixed set of namespaces, services,
+// and workloads. Each service is backed by a local stub server. The root daemon routes the cluster
+// IPs of the services to the stubs, and resolves their names, just like it does for a real cluster.
+// Without a root daemon, the stubs are reached through a SOCKS5 proxy and, optionally, a DNS stub,
+// just like the cluster is reached in proxy mode. No Kubernetes cluster is needed, which makes the
+// mock cluster suitable for demos, tutorials, and UI development.
+package userd_mock
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/connector"
+)
+
+const (
+	// ClusterContext and ClusterServer are reported as the context and server of the mock cluster
+	ClusterContext = "mock"
+	ClusterServer  = "https://mock.cluster.invalid"
+
+	// ClusterID is the ID of the mock cluster. It never changes, so that it's always the same
+	// cluster to the clients.
+	ClusterID = "00000000-0000-0000-0000-000000000000"
+
+	clusterDomain = "cluster.local"
+)
+
+// Workload is a workload of the mock cluster. A workload with a port has a service with the same
+// name that's backed by a stub server.
+type Workload struct {
+	Namespace string
+	Name      string
+	Kind      string
+
+	// IP is the cluster IP of the service, and Port its port. Port is zero when the workload has
+	// no service.
+	IP   net.IP
+	Port uint16
+}
+
+// Workloads returns the workloads of the mock cluster. They are the same every time.
+func Workloads() []*Workload {
+	return []*Workload{
+		{Namespace: "default", Name: "echo", Kind: "Deployment", IP: net.IPv4(10, 96, 0, 10), Port: 8080},
+		{Namespace: "default", Name: "web", Kind: "Deployment", IP: net.IPv4(10, 96, 0, 11), Port: 80},
+		{Namespace: "default", Name: "api", Kind: "StatefulSet", IP: net.IPv4(10, 96, 0, 12), Port: 8080},
+		{Namespace: "default", Name: "cleanup", Kind: "Deployment"},
+		{Namespace: "blog", Name: "frontend", Kind: "Deployment", IP: net.IPv4(10, 96, 1, 10), Port: 80},
+		{Namespace: "blog", Name: "backend", Kind: "Deployment", IP: net.IPv4(10, 96, 1, 11), Port: 8080},
+	}
+}
+
+// HasService returns true if the workload has a service.
+func (w *Workload) HasService() bool {
+	return w.Port != 0
+}
+
+// Host returns the name of the service of the workload, qualified with its namespace.
+func (w *Workload) Host() string {
+	return w.Name + "." + w.Namespace
+}
+
+func (w *Workload) workloadInfo() *rpc.WorkloadInfo {
+	wi := &rpc.WorkloadInfo{
+		Name:                 w.Name,
+		WorkloadResourceType: w.Kind,
+	}
+	if !w.HasService() {
+		wi.NotInterceptableReason = fmt.Sprintf("%s %s has no associated service", w.Kind, w.Name)
+	}
+	return wi
+}
+
+// findByName returns the workload with a service of the given name. The name is one of <svc>,
+// <svc>.<ns>, <svc>.<ns>.svc, or <svc>.<ns>.svc.cluster.local. A name without a namespace is
+// found in the "default" namespace.
+func findByName(wls []*Workload, name string) *Workload {
+	name = strings.TrimSuffix(strings.ToLower(name), ".")
+	name = strings.TrimSuffix(name, ".svc."+clusterDomain)
+	name = strings.TrimSuffix(name, ".svc")
+	parts := strings.Split(name, ".")
+	var ns string
+	switch len(parts) {
+	case 1:
+		ns = "default"
+	case 2:
+		ns = parts[1]
+	default:
+		return nil
+	}
+	for _, wl := range wls {
+		if wl.HasService() && wl.Name == parts[0] && wl.Namespace == ns {
+			return wl
+		}
+	}
+	return nil
+}
+
+// findByIP returns the workload with a service that has the given cluster IP.
+func findByIP(wls []*Workload, ip net.IP) *Workload {
+	for _, wl := range wls {
+		if wl.HasService() && wl.IP.Equal(ip) {
+			return wl
+		}
+	}
+	return nil
+}
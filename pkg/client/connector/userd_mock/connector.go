@@ -0,0 +1,216 @@
+package userd_mock
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	grpcCodes "google.golang.org/grpc/codes"
+	grpcStatus "google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	empty "google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/telepresenceio/telepresence/rpc/v2/common"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+)
+
+// sessionID is the ID of the session of the only client of the mock cluster
+const sessionID = "mock-session"
+
+// connector is the connector service of the mock cluster. Its intercepts decide where the
+// connections to the services are routed. The operations that need Ambassador Cloud, such as
+// Login, are left unimplemented.
+type connector struct {
+	rpc.UnimplementedConnectorServer
+	cluster *Cluster
+
+	mu         sync.Mutex
+	connected  bool
+	intercepts []*manager.InterceptInfo
+	nextID     int
+}
+
+func (c *connector) Version(context.Context, *empty.Empty) (*common.VersionInfo, error) {
+	return &common.VersionInfo{
+		ApiVersion: client.APIVersion,
+		Version:    client.Version(),
+	}, nil
+}
+
+// connectInfo returns the ConnectInfo of the mock cluster. The caller must hold the lock.
+func (c *connector) connectInfo(errType rpc.ConnectInfo_ErrType) *rpc.ConnectInfo {
+	ci := &rpc.ConnectInfo{
+		Error:          errType,
+		ClusterContext: ClusterContext,
+		ClusterServer:  ClusterServer,
+		ClusterId:      ClusterID,
+		BridgeOk:       true,
+		SessionInfo:    &manager.SessionInfo{SessionId: sessionID},
+		Intercepts:     &manager.InterceptInfoSnapshot{},
+	}
+	for _, ic := range c.intercepts {
+		ci.Intercepts.Intercepts = append(ci.Intercepts.Intercepts, proto.Clone(ic).(*manager.InterceptInfo))
+	}
+	return ci
+}
+
+// Connect connects the root daemon to the mock cluster, unless the mock cluster is reached through
+// the SOCKS5 proxy.
+func (c *connector) Connect(ctx context.Context, _ *rpc.ConnectRequest) (*rpc.ConnectInfo, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.connected {
+		return c.connectInfo(rpc.ConnectInfo_ALREADY_CONNECTED), nil
+	}
+	if c.cluster.socksAddr == "" {
+		if err := c.cluster.connectDaemon(ctx); err != nil {
+			return &rpc.ConnectInfo{Error: rpc.ConnectInfo_DAEMON_FAILED, ErrorText: err.Error()}, nil
+		}
+	}
+	c.connected = true
+	return c.connectInfo(rpc.ConnectInfo_UNSPECIFIED), nil
+}
+
+// Status reports the mock cluster even when it isn't connected yet, so that the CLI can tell the
+// connector of a mock cluster from one that connects to a real cluster.
+func (c *connector) Status(context.Context, *rpc.ConnectRequest) (*rpc.ConnectInfo, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.connected {
+		return c.connectInfo(rpc.ConnectInfo_DISCONNECTED), nil
+	}
+	return c.connectInfo(rpc.ConnectInfo_ALREADY_CONNECTED), nil
+}
+
+// workload returns the workload with the given name in the given namespace.
+func (c *connector) workload(namespace, name string) *Workload {
+	for _, wl := range c.cluster.workloads {
+		if wl.Namespace == namespace && wl.Name == name {
+			return wl
+		}
+	}
+	return nil
+}
+
+func namespaceOrDefault(namespace string) string {
+	if namespace == "" {
+		return "default"
+	}
+	return namespace
+}
+
+func (c *connector) CreateIntercept(_ context.Context, ir *rpc.CreateInterceptRequest) (*rpc.InterceptResult, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.connected {
+		return &rpc.InterceptResult{Error: rpc.InterceptError_NO_CONNECTION}, nil
+	}
+	if ir.GetSpec() == nil {
+		return nil, grpcStatus.Error(grpcCodes.InvalidArgument, "intercept spec is missing")
+	}
+	spec := proto.Clone(ir.GetSpec()).(*manager.InterceptSpec)
+	spec.Namespace = namespaceOrDefault(spec.Namespace)
+	for _, ic := range c.intercepts {
+		if ic.Spec.Name == spec.Name {
+			return &rpc.InterceptResult{Error: rpc.InterceptError_ALREADY_EXISTS, ErrorText: spec.Name}, nil
+		}
+	}
+	wl := c.workload(spec.Namespace, spec.Agent)
+	if wl == nil {
+		return &rpc.InterceptResult{Error: rpc.InterceptError_NO_ACCEPTABLE_WORKLOAD, ErrorText: spec.Agent}, nil
+	}
+	if wi := wl.workloadInfo(); wi.NotInterceptableReason != "" {
+		return &rpc.InterceptResult{Error: rpc.InterceptError_NO_ACCEPTABLE_WORKLOAD, ErrorText: wi.NotInterceptableReason}, nil
+	}
+	spec.WorkloadKind = wl.Kind
+	c.nextID++
+	ic := &manager.InterceptInfo{
+		Spec:          spec,
+		Id:            fmt.Sprintf("%s:%d", sessionID, c.nextID),
+		ClientSession: &manager.SessionInfo{SessionId: sessionID},
+		Disposition:   manager.InterceptDispositionType_ACTIVE,
+	}
+	c.intercepts = append(c.intercepts, ic)
+	return &rpc.InterceptResult{
+		InterceptInfo: proto.Clone(ic).(*manager.InterceptInfo),
+		WorkloadKind:  spec.WorkloadKind,
+		Environment:   map[string]string{},
+	}, nil
+}
+
+func (c *connector) RemoveIntercept(_ context.Context, rr *manager.RemoveInterceptRequest2) (*rpc.InterceptResult, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.connected {
+		return &rpc.InterceptResult{Error: rpc.InterceptError_NO_CONNECTION}, nil
+	}
+	for i, ic := range c.intercepts {
+		if ic.Spec.Name == rr.Name {
+			c.intercepts = append(c.intercepts[:i], c.intercepts[i+1:]...)
+			return &rpc.InterceptResult{}, nil
+		}
+	}
+	return &rpc.InterceptResult{Error: rpc.InterceptError_NOT_FOUND, ErrorText: rr.Name}, nil
+}
+
+// intercept returns the intercept of the given workload, or nil if it isn't intercepted.
+func (c *connector) intercept(wl *Workload) *manager.InterceptInfo {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, ic := range c.intercepts {
+		if ic.Spec.Namespace == wl.Namespace && ic.Spec.Agent == wl.Name {
+			return proto.Clone(ic).(*manager.InterceptInfo)
+		}
+	}
+	return nil
+}
+
+func (c *connector) List(_ context.Context, lr *rpc.ListRequest) (*rpc.WorkloadInfoSnapshot, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	snapshot := &rpc.WorkloadInfoSnapshot{}
+	if !c.connected {
+		return snapshot, nil
+	}
+	namespace := namespaceOrDefault(lr.Namespace)
+	for _, wl := range c.cluster.workloads {
+		if wl.Namespace != namespace {
+			continue
+		}
+		wi := wl.workloadInfo()
+		for _, ic := range c.intercepts {
+			if ic.Spec.Namespace == namespace && ic.Spec.Agent == wl.Name {
+				wi.InterceptInfo = proto.Clone(ic).(*manager.InterceptInfo)
+				break
+			}
+		}
+		switch lr.Filter {
+		case rpc.ListRequest_INTERCEPTS:
+			if wi.InterceptInfo == nil {
+				continue
+			}
+		case rpc.ListRequest_INSTALLED_AGENTS:
+			// The mock cluster has no agents
+			continue
+		case rpc.ListRequest_INTERCEPTABLE:
+			if wi.NotInterceptableReason != "" {
+				continue
+			}
+		}
+		snapshot.Workloads = append(snapshot.Workloads, wi)
+	}
+	return snapshot, nil
+}
+
+func (c *connector) Quit(context.Context, *empty.Empty) (*empty.Empty, error) {
+	c.mu.Lock()
+	c.connected = false
+	c.intercepts = nil
+	c.mu.Unlock()
+	if c.cluster.OnQuit != nil {
+		c.cluster.OnQuit()
+	}
+	return &empty.Empty{}, nil
+}
@@ -0,0 +1,140 @@
+package userd_mock
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync/atomic"
+
+	empty "google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/connpool"
+	"github.com/telepresenceio/telepresence/v2/pkg/iputil"
+)
+
+var (
+	// serviceSubnet is the subnet of the cluster IPs of the services, which the root daemon routes
+	serviceSubnet = &net.IPNet{IP: net.IPv4(10, 96, 0, 0).To4(), Mask: net.CIDRMask(16, 32)}
+
+	// dnsIP is the cluster IP of the cluster DNS. The root daemon answers the queries that are sent
+	// to it, using LookupHost.
+	dnsIP = net.IPv4(10, 96, 0, 2)
+)
+
+// mgr is the part of the traffic-manager service that the root daemon uses when it's connected to
+// the mock cluster. It's served on the connector socket, just like the real connector serves the
+// traffic-manager that it's connected to.
+type mgr struct {
+	manager.UnimplementedManagerServer
+	cluster *Cluster
+}
+
+func (m *mgr) Version(context.Context, *empty.Empty) (*manager.VersionInfo2, error) {
+	return &manager.VersionInfo2{Version: client.Version()}, nil
+}
+
+// WatchClusterInfo sends the subnet of the services and the IP of the cluster DNS, which never
+// change.
+func (m *mgr) WatchClusterInfo(_ *manager.SessionInfo, stream manager.Manager_WatchClusterInfoServer) error {
+	err := stream.Send(&manager.ClusterInfo{
+		KubeDnsIp:     dnsIP,
+		ServiceSubnet: iputil.IPNetToRPC(serviceSubnet),
+	})
+	if err != nil {
+		return err
+	}
+	<-stream.Context().Done()
+	return nil
+}
+
+// LookupHost resolves the names of the services to their cluster IPs.
+func (m *mgr) LookupHost(_ context.Context, req *manager.LookupHostRequest) (*manager.LookupHostResponse, error) {
+	rsp := &manager.LookupHostResponse{}
+	if wl := findByName(m.cluster.workloads, req.Host); wl != nil {
+		rsp.Ips = [][]byte{wl.IP.To4()}
+	}
+	return rsp, nil
+}
+
+// ClientTunnel dispatches the connections that the root daemon tunnels to the cluster IPs of the
+// services. Each connection is dialed to the target of the intercept of the service's workload if
+// there is one, and to its stub server otherwise.
+func (m *mgr) ClientTunnel(server manager.Manager_ClientTunnelServer) error {
+	ctx := server.Context()
+
+	// The initial message is the session info, and there's only one session
+	if _, err := server.Recv(); err != nil {
+		return err
+	}
+	stream := connpool.NewStream(server)
+	pool := connpool.NewPool()
+	defer pool.CloseAll(ctx)
+
+	closing := int32(0)
+	msgCh, errCh := stream.ReadLoop(ctx, &closing)
+	for {
+		select {
+		case <-ctx.Done():
+			atomic.StoreInt32(&closing, 2)
+			return nil
+		case err := <-errCh:
+			return err
+		case msg := <-msgCh:
+			if msg == nil {
+				return nil
+			}
+			id := msg.ID()
+			h, _, err := pool.Get(ctx, id, func(ctx context.Context, release func()) (connpool.Handler, error) {
+				if ctrl, ok := msg.(connpool.Control); !ok || ctrl.Code() != connpool.Connect {
+					return nil, nil
+				}
+				addr, err := m.target(ctx, id)
+				if err != nil {
+					dlog.Debugf(ctx, "%s: %v", id, err)
+					_ = stream.Send(connpool.NewControl(id, connpool.ConnectReject, nil).TunnelMessage())
+					return nil, nil
+				}
+				// The dialer dials the destination of its ID, and the flowStream gives its
+				// messages the ID that the root daemon knows the connection by.
+				dialID := connpool.NewConnID(id.Protocol(), id.Source(), addr.IP, id.SourcePort(), uint16(addr.Port))
+				return connpool.NewDialer(dialID, &flowStream{TunnelStream: stream, id: id}, release), nil
+			})
+			if err != nil {
+				return err
+			}
+			if h != nil {
+				h.HandleMessage(ctx, msg)
+			}
+		}
+	}
+}
+
+// target returns the address that a connection with the given ID is dialed to.
+func (m *mgr) target(ctx context.Context, id connpool.ConnID) (*net.TCPAddr, error) {
+	if id.ProtocolString() != "tcp4" {
+		return nil, fmt.Errorf("the services of the mock cluster don't serve %s", id.ProtocolString())
+	}
+	addr, err := m.cluster.target(ctx, "", id.Destination(), id.DestinationPort())
+	if err != nil {
+		return nil, err
+	}
+	return net.ResolveTCPAddr("tcp", addr)
+}
+
+// flowStream is the tunnel as used by the dialer of one connection. It replaces the ID of the
+// messages of the dialer, which identifies the stub or the intercept target, with the ID of the
+// connection.
+type flowStream struct {
+	connpool.TunnelStream
+	id connpool.ConnID
+}
+
+func (f *flowStream) Send(cm *manager.ConnMessage) error {
+	if ctrl, ok := connpool.FromConnMessage(cm).(connpool.Control); ok {
+		return f.TunnelStream.Send(connpool.NewControl(f.id, ctrl.Code(), ctrl.Payload()).TunnelMessage())
+	}
+	return f.TunnelStream.Send(connpool.NewMessage(f.id, cm.Payload).TunnelMessage())
+}
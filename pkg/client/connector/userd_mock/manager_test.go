@@ -0,0 +1,134 @@
+package userd_mock
+
+import (
+	"bufio"
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/connpool"
+	"github.com/telepresenceio/telepresence/v2/pkg/iputil"
+)
+
+// managerClient serves the given cluster on an in-memory socket and returns a client of its
+// traffic-manager service, as used by the root daemon.
+func managerClient(ctx context.Context, t *testing.T, c *Cluster) manager.ManagerClient {
+	lis := bufconn.Listen(64 * 1024)
+	srv := grpc.NewServer()
+	c.Register(srv)
+	go func() {
+		_ = srv.Serve(lis)
+	}()
+	t.Cleanup(srv.Stop)
+	conn, err := grpc.DialContext(ctx, "bufnet", grpc.WithInsecure(),
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	return manager.NewManagerClient(conn)
+}
+
+func TestManager(t *testing.T) {
+	// The handlers of the tunneled connections outlive the test, so they don't log to it
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c := New("", "")
+	go func() {
+		_ = c.Serve(ctx, nil, nil)
+	}()
+	require.Eventually(t, func() bool {
+		_, err := c.target(ctx, "api.default", nil, 8080)
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	mc := managerClient(ctx, t, c)
+
+	ci, err := mc.WatchClusterInfo(ctx, &manager.SessionInfo{SessionId: sessionID})
+	require.NoError(t, err)
+	info, err := ci.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, "10.96.0.0/16", iputil.IPNetFromRPC(info.ServiceSubnet).String())
+	assert.True(t, net.IP(info.KubeDnsIp).Equal(dnsIP))
+
+	rsp, err := mc.LookupHost(ctx, &manager.LookupHostRequest{Host: "frontend.blog.svc.cluster.local"})
+	require.NoError(t, err)
+	require.Len(t, rsp.Ips, 1)
+	assert.Equal(t, "10.96.1.10", net.IP(rsp.Ips[0]).String())
+	rsp, err = mc.LookupHost(ctx, &manager.LookupHostRequest{Host: "nosuch.blog"})
+	require.NoError(t, err)
+	assert.Empty(t, rsp.Ips)
+
+	// The root daemon's end of the tunnel
+	tunnel, err := mc.ClientTunnel(ctx)
+	require.NoError(t, err)
+	require.NoError(t, tunnel.Send(connpool.SessionInfoControl(&manager.SessionInfo{SessionId: sessionID}).TunnelMessage()))
+	stream := connpool.NewStream(tunnel)
+	pool := connpool.NewPool()
+	closing := int32(0)
+	defer atomic.StoreInt32(&closing, 1)
+	go func() {
+		_ = stream.DialLoop(ctx, &closing, pool)
+	}()
+
+	srcPort := uint16(40000)
+	get := func(ip net.IP, port uint16) (string, error) {
+		srcPort++
+		id := connpool.NewConnID(6, net.IPv4(127, 0, 0, 1), ip, srcPort, port) // 6 is TCP
+		local, remote := net.Pipe()
+		defer local.Close()
+		_, _, err := pool.Get(ctx, id, func(ctx context.Context, release func()) (connpool.Handler, error) {
+			return connpool.HandlerFromConn(id, stream, release, remote), nil
+		})
+		require.NoError(t, err)
+		_ = local.SetDeadline(time.Now().Add(5 * time.Second))
+		req, _ := http.NewRequest(http.MethodGet, "http://"+net.JoinHostPort(ip.String(), "80")+"/", nil)
+		req.Close = true
+		if err := req.Write(local); err != nil {
+			return "", err
+		}
+		r, err := http.ReadResponse(bufio.NewReader(local), req)
+		if err != nil {
+			return "", err
+		}
+		defer r.Body.Close()
+		body, err := ioutil.ReadAll(r.Body)
+		return string(body), err
+	}
+
+	body, err := get(net.IPv4(10, 96, 0, 10), 8080)
+	require.NoError(t, err)
+	assert.Contains(t, body, "Hello from Deployment echo.default in the mock cluster")
+
+	_, err = get(net.IPv4(10, 96, 0, 99), 8080)
+	assert.Error(t, err, "no such service")
+
+	// An intercepted service is routed to the target of the intercept
+	local := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("Hello from my laptop"))
+	}))
+	defer local.Close()
+	target := local.Listener.Addr().(*net.TCPAddr)
+	c.connector.connected = true // connecting would tell the root daemon about the cluster
+	ir, err := c.connector.CreateIntercept(ctx, &rpc.CreateInterceptRequest{Spec: &manager.InterceptSpec{
+		Name:       "echo",
+		Agent:      "echo",
+		TargetHost: "127.0.0.1",
+		TargetPort: int32(target.Port),
+	}})
+	require.NoError(t, err)
+	require.Equal(t, rpc.InterceptError_UNSPECIFIED, ir.Error)
+	body, err = get(net.IPv4(10, 96, 0, 10), 8080)
+	require.NoError(t, err)
+	assert.Equal(t, "Hello from my laptop", body)
+}
@@ -0,0 +1,227 @@
+package userd_mock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+
+	"google.golang.org/grpc"
+
+	"github.com/datawire/dlib/dcontext"
+	"github.com/datawire/dlib/dgroup"
+	"github.com/datawire/dlib/dhttp"
+	"github.com/datawire/dlib/dlog"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/rpc/v2/daemon"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/cache"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/connector/userd_proxy"
+	dnsproxy "github.com/telepresenceio/telepresence/v2/pkg/client/daemon/dns"
+)
+
+// Cluster is a mock cluster. The connector process serves its connector service in place of the
+// one that uses a real cluster. The services of the mock cluster are reached through the root
+// daemon, which routes their cluster IPs and resolves their names just like it does for a real
+// cluster, or, when there's no root daemon, through a SOCKS5 proxy.
+type Cluster struct {
+	// OnQuit, when set, is called when the connector is asked to quit
+	OnQuit func()
+
+	socksAddr string
+	dnsAddr   string
+	workloads []*Workload
+	connector *connector
+
+	// stubs are the addresses of the stub servers of the services. They are started by Serve.
+	stubsMu sync.Mutex
+	stubs   map[*Workload]string
+}
+
+// New returns a Cluster that is reached through the root daemon when socksAddr is empty. Otherwise,
+// it will listen for SOCKS5 connections on socksAddr, and for DNS requests on dnsAddr unless it's
+// empty.
+func New(socksAddr, dnsAddr string) *Cluster {
+	c := &Cluster{
+		socksAddr: socksAddr,
+		dnsAddr:   dnsAddr,
+		workloads: Workloads(),
+		stubs:     make(map[*Workload]string),
+	}
+	c.connector = &connector{cluster: c}
+	return c
+}
+
+// Register registers the connector service of the mock cluster, and the traffic-manager service
+// that the root daemon uses to reach it, with the given server.
+func (c *Cluster) Register(srv *grpc.Server) {
+	rpc.RegisterConnectorServer(srv, c.connector)
+	manager.RegisterManagerServer(srv, &mgr{cluster: c})
+}
+
+// Run listens on the addresses of the SOCKS5 proxy and the DNS stub, unless the mock cluster is
+// reached through the root daemon, and serves the mock cluster until the context is done. The
+// address of the SOCKS5 proxy is recorded in the user cache so that "telepresence run" and
+// "telepresence curl" use it.
+func (c *Cluster) Run(ctx context.Context) error {
+	if c.socksAddr == "" {
+		return c.Serve(ctx, nil, nil)
+	}
+	socksListener, err := net.Listen("tcp", c.socksAddr)
+	if err != nil {
+		return fmt.Errorf("unable to listen for SOCKS connections on %s: %w", c.socksAddr, err)
+	}
+	var dnsListener net.PacketConn
+	if c.dnsAddr != "" {
+		if dnsListener, err = net.ListenPacket("udp", c.dnsAddr); err != nil {
+			socksListener.Close()
+			return fmt.Errorf("unable to listen for DNS requests on %s: %w", c.dnsAddr, err)
+		}
+	}
+	if err = cache.SaveProxyAddressToUserCache(ctx, socksListener.Addr().String()); err != nil {
+		dlog.Errorf(ctx, "unable to record the SOCKS5 address: %v", err)
+	}
+	defer func() {
+		_ = cache.DeleteProxyAddressFromUserCache(ctx)
+	}()
+	dlog.Infof(ctx, "Mock cluster SOCKS5 proxy listening on %s", socksListener.Addr())
+	return c.Serve(ctx, socksListener, dnsListener)
+}
+
+// Serve starts the stub servers of the services, and then serves the SOCKS5 proxy using the given
+// listener, and the DNS stub using the given connection, unless they are nil, until the context is
+// done.
+func (c *Cluster) Serve(ctx context.Context, socksListener net.Listener, dnsListener net.PacketConn) error {
+	closeListeners := func() {
+		if socksListener != nil {
+			socksListener.Close()
+		}
+		if dnsListener != nil {
+			dnsListener.Close()
+		}
+	}
+	g := dgroup.NewGroup(ctx, dgroup.GroupConfig{})
+	for _, wl := range c.workloads {
+		if !wl.HasService() {
+			continue
+		}
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			closeListeners()
+			return fmt.Errorf("unable to start the stub server of %s: %w", wl.Host(), err)
+		}
+		c.stubsMu.Lock()
+		c.stubs[wl] = l.Addr().String()
+		c.stubsMu.Unlock()
+		sc := &dhttp.ServerConfig{Handler: stubHandler(wl)}
+		g.Go("stub-"+wl.Host(), func(ctx context.Context) error {
+			return sc.Serve(ctx, l)
+		})
+	}
+	if socksListener != nil {
+		g.Go("socks", func(ctx context.Context) error {
+			return userd_proxy.ServeSOCKS(ctx, socksListener, c.dial)
+		})
+	}
+	if dnsListener != nil {
+		g.Go("dns", func(ctx context.Context) error {
+			return dnsproxy.NewServer(ctx, []net.PacketConn{dnsListener}, nil, c.resolve).Run(ctx)
+		})
+	}
+	return g.Wait()
+}
+
+// connectDaemon tells the root daemon about the mock cluster. The daemon then uses the
+// traffic-manager service of the mock cluster to route the cluster IPs of the services and to
+// resolve their names.
+func (c *Cluster) connectDaemon(ctx context.Context) error {
+	conn, err := client.DialSocket(ctx, client.DaemonSocketName)
+	if err != nil {
+		return fmt.Errorf("unable to connect to the root daemon: %w", err)
+	}
+	// The connection remains open until the connector exits, just like the one of the connector
+	// of a real cluster.
+	daemonClient := daemon.NewDaemonClient(conn)
+	if _, err = daemonClient.SetOutboundInfo(ctx, &daemon.OutboundInfo{Session: &manager.SessionInfo{SessionId: sessionID}}); err != nil {
+		conn.Close()
+		return fmt.Errorf("daemon.SetOutboundInfo: %w", err)
+	}
+
+	// The namespaces are mapped, so that names like "echo.default" resolve, and the services of
+	// the default namespace can be found by their short names. The daemon doesn't accept the
+	// search path until it has configured its DNS, which happens once it has watched the cluster
+	// info.
+	paths := []string{"default.svc." + clusterDomain + "."}
+	seen := make(map[string]bool)
+	for _, wl := range c.workloads {
+		if !seen[wl.Namespace] {
+			seen[wl.Namespace] = true
+			paths = append(paths, wl.Namespace)
+		}
+	}
+	ctx = dcontext.WithoutCancel(ctx)
+	go func() {
+		if _, err := daemonClient.SetDnsSearchPath(ctx, &daemon.Paths{Paths: paths}); err != nil {
+			dlog.Errorf(ctx, "error posting search paths %v: %v", paths, err)
+		}
+	}()
+	return nil
+}
+
+// target returns the address that a connection to the service with the given name or cluster IP
+// is dialed to. It's the target of the intercept of the service's workload if there is one, and its
+// stub server otherwise.
+func (c *Cluster) target(ctx context.Context, host string, ip net.IP, port uint16) (string, error) {
+	if ip == nil {
+		ip = net.ParseIP(host)
+	}
+	var wl *Workload
+	if ip != nil {
+		wl = findByIP(c.workloads, ip)
+	} else {
+		wl = findByName(c.workloads, host)
+	}
+	if wl == nil {
+		return "", errors.New("no such service in the mock cluster")
+	}
+	if port != wl.Port {
+		return "", fmt.Errorf("service %s has no port %d", wl.Host(), port)
+	}
+	c.stubsMu.Lock()
+	addr, ok := c.stubs[wl]
+	c.stubsMu.Unlock()
+	if !ok {
+		return "", fmt.Errorf("the stub server of service %s isn't started", wl.Host())
+	}
+	if ic := c.connector.intercept(wl); ic != nil {
+		host := ic.Spec.TargetHost
+		if host == "" {
+			host = "127.0.0.1"
+		}
+		addr = net.JoinHostPort(host, strconv.Itoa(int(ic.Spec.TargetPort)))
+		dlog.Debugf(ctx, "%s is intercepted by %s, connecting to %s", wl.Host(), ic.Spec.Name, addr)
+	}
+	return addr, nil
+}
+
+// dial connects to the service with the given name or cluster IP.
+func (c *Cluster) dial(ctx context.Context, host string, ip net.IP, port uint16) (net.Conn, error) {
+	addr, err := c.target(ctx, host, ip, port)
+	if err != nil {
+		return nil, err
+	}
+	var d net.Dialer
+	return d.DialContext(ctx, "tcp", addr)
+}
+
+// resolve resolves the names of the services to their cluster IPs. The DNS stub answers the
+// queries for all other names with NXDOMAIN.
+func (c *Cluster) resolve(_ context.Context, _ uint16, domain string) []net.IP {
+	if wl := findByName(c.workloads, domain); wl != nil {
+		return []net.IP{wl.IP}
+	}
+	return nil
+}
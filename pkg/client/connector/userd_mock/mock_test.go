@@ -0,0 +1,127 @@
+package userd_mock
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+)
+
+func TestFindByName(t *testing.T) {
+	wls := Workloads()
+	for _, name := range []string{"echo", "echo.default", "ECHO.default.", "echo.default.svc", "echo.default.svc.cluster.local"} {
+		wl := findByName(wls, name)
+		if assert.NotNil(t, wl, name) {
+			assert.Equal(t, "echo.default", wl.Host())
+		}
+	}
+	assert.Equal(t, "frontend.blog", findByName(wls, "frontend.blog").Host())
+	assert.Nil(t, findByName(wls, "frontend"), "not in the default namespace")
+	assert.Nil(t, findByName(wls, "cleanup"), "has no service")
+	assert.Nil(t, findByName(wls, "echo.default.example.com"))
+	assert.Equal(t, "web.default", findByIP(wls, net.IPv4(10, 96, 0, 11)).Host())
+}
+
+func TestList(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	conn := New("127.0.0.1:0", "").connector
+	_, err := conn.Connect(ctx, &rpc.ConnectRequest{})
+	require.NoError(t, err)
+
+	snapshot, err := conn.List(ctx, &rpc.ListRequest{})
+	require.NoError(t, err)
+	wis := snapshot.Workloads
+	require.Len(t, wis, 4)
+	assert.Equal(t, "echo", wis[0].Name)
+	assert.Empty(t, wis[0].NotInterceptableReason)
+	assert.Equal(t, "Deployment cleanup has no associated service", wis[3].NotInterceptableReason)
+
+	snapshot, err = conn.List(ctx, &rpc.ListRequest{Namespace: "blog", Filter: rpc.ListRequest_INTERCEPTABLE})
+	require.NoError(t, err)
+	assert.Len(t, snapshot.Workloads, 2)
+}
+
+func TestServe(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+
+	c := New("127.0.0.1:0", "")
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		_ = c.Serve(ctx, l, nil)
+	}()
+
+	hc := &http.Client{Transport: &http.Transport{
+		Proxy: http.ProxyURL(&url.URL{Scheme: "socks5", Host: l.Addr().String()}),
+		// Each request must be routed anew, or it might reuse a connection to the stub of a
+		// service that has since been intercepted.
+		DisableKeepAlives: true,
+	}}
+	get := func(u string) (string, error) {
+		r, err := hc.Get(u)
+		if err != nil {
+			return "", err
+		}
+		defer r.Body.Close()
+		body, err := ioutil.ReadAll(r.Body)
+		return string(body), err
+	}
+
+	body, err := get("http://echo.default:8080/hello")
+	require.NoError(t, err)
+	assert.Contains(t, body, "Hello from Deployment echo.default in the mock cluster")
+	assert.Contains(t, body, "GET /hello HTTP/1.1")
+
+	body, err = get("http://10.96.1.10/")
+	require.NoError(t, err)
+	assert.Contains(t, body, "frontend.blog")
+
+	_, err = get("http://echo.default:9090/")
+	assert.Error(t, err, "wrong port")
+	_, err = get("http://nosuch.default/")
+	assert.Error(t, err, "unknown service")
+
+	// An intercepted service is routed to the target of the intercept
+	local := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("Hello from my laptop"))
+	}))
+	defer local.Close()
+	_, port, err := net.SplitHostPort(local.Listener.Addr().String())
+	require.NoError(t, err)
+	portNum, err := strconv.Atoi(port)
+	require.NoError(t, err)
+
+	conn := c.connector
+	_, err = conn.Connect(ctx, &rpc.ConnectRequest{})
+	require.NoError(t, err)
+	ir, err := conn.CreateIntercept(ctx, &rpc.CreateInterceptRequest{Spec: &manager.InterceptSpec{
+		Name:       "echo",
+		Agent:      "echo",
+		TargetHost: "127.0.0.1",
+		TargetPort: int32(portNum),
+	}})
+	require.NoError(t, err)
+	require.Equal(t, rpc.InterceptError_UNSPECIFIED, ir.Error)
+
+	body, err = get("http://echo.default:8080/")
+	require.NoError(t, err)
+	assert.Equal(t, "Hello from my laptop", body)
+
+	_, err = conn.RemoveIntercept(ctx, &manager.RemoveInterceptRequest2{Name: "echo"})
+	require.NoError(t, err)
+	body, err = get("http://echo.default:8080/")
+	require.NoError(t, err)
+	assert.Contains(t, body, "echo.default in the mock cluster")
+}
@@ -0,0 +1,30 @@
+package userd_mock
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+)
+
+// stubHandler returns the handler of the stub server of the given workload's service. It responds
+// to every request with the name of the service and a summary of the request, so that it's easy
+// to see where a request ended up.
+func stubHandler(wl *Workload) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("X-Telepresence-Mock-Service", wl.Host())
+		fmt.Fprintf(w, "Hello from %s %s in the mock cluster\n", wl.Kind, wl.Host())
+		fmt.Fprintf(w, "\n%s %s %s\n", r.Method, r.URL.RequestURI(), r.Proto)
+		fmt.Fprintf(w, "Host: %s\n", r.Host)
+		names := make([]string, 0, len(r.Header))
+		for name := range r.Header {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			for _, v := range r.Header[name] {
+				fmt.Fprintf(w, "%s: %s\n", name, v)
+			}
+		}
+	})
+}
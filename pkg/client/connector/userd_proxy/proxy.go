@@ -0,0 +1,256 @@
+// Package userd_proxy provides cluster access without a root daemon. Instead of a TUN device, it
+// offers a SOCKS5 proxy and, optionally, a DNS stub that both use the traffic-manager. This is
+// intended for devcontainers and other environments where privileged operations aren't allowed.
+package userd_proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+	"google.golang.org/grpc"
+	empty "google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/datawire/dlib/dgroup"
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/rpc/v2/daemon"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/cache"
+	dnsproxy "github.com/telepresenceio/telepresence/v2/pkg/client/daemon/dns"
+	"github.com/telepresenceio/telepresence/v2/pkg/connpool"
+	"github.com/telepresenceio/telepresence/v2/pkg/ipproto"
+)
+
+// Proxy replaces the root daemon when the connector runs in proxy mode. Its SetOutboundInfo and
+// SetDNSSearchPath methods have the same signatures as the corresponding daemon client methods so
+// that they can be used as connector callbacks.
+type Proxy struct {
+	socksAddr     string
+	dnsAddr       string
+	session       *manager.SessionInfo
+	managerClient manager.ManagerClient
+	handlers      *connpool.Pool
+	started       int32
+	closing       int32
+
+	// externalHosts maps the names of the nodes and load balancers of the cluster to their
+	// addresses. The names are in lower case and have no trailing dot.
+	externalHosts     map[string][]net.IP
+	externalHostsLock sync.RWMutex
+}
+
+// New returns a Proxy that will listen for SOCKS5 connections on socksAddr, and for DNS requests
+// on dnsAddr unless it's empty.
+func New(socksAddr, dnsAddr string) *Proxy {
+	return &Proxy{
+		socksAddr: socksAddr,
+		dnsAddr:   dnsAddr,
+		handlers:  connpool.NewPool(),
+	}
+}
+
+// SetDNSSearchPath is a no-op. Names are resolved by the traffic-manager, which uses the search
+// path of its own pod.
+func (p *Proxy) SetDNSSearchPath(_ context.Context, _ *daemon.Paths, _ ...grpc.CallOption) (*empty.Empty, error) {
+	return &empty.Empty{}, nil
+}
+
+// SetExternalRoutes makes the proxy resolve the names of the nodes and load balancers of the
+// cluster to the given addresses. The subnets are ignored, because the proxy sends all the
+// connections that it accepts to the cluster.
+func (p *Proxy) SetExternalRoutes(_ context.Context, _ []*net.IPNet, hosts map[string][]net.IP) error {
+	normalized := make(map[string][]net.IP, len(hosts))
+	for host, ips := range hosts {
+		normalized[strings.ToLower(strings.TrimSuffix(host, "."))] = ips
+	}
+	p.externalHostsLock.Lock()
+	p.externalHosts = normalized
+	p.externalHostsLock.Unlock()
+	return nil
+}
+
+// SetOutboundInfo starts the proxy the first time it's called.
+func (p *Proxy) SetOutboundInfo(ctx context.Context, info *daemon.OutboundInfo, _ ...grpc.CallOption) (*empty.Empty, error) {
+	if !atomic.CompareAndSwapInt32(&p.started, 0, 1) {
+		return &empty.Empty{}, nil
+	}
+
+	// Just like the root daemon, the proxy talks to the traffic-manager through the manager
+	// proxy in the connector.
+	conn, err := client.DialSocket(ctx, client.ConnectorSocketName)
+	if err != nil {
+		return nil, err
+	}
+	p.session = info.Session
+	p.managerClient = manager.NewManagerClient(conn)
+
+	socksListener, err := net.Listen("tcp", p.socksAddr)
+	if err != nil {
+		return nil, fmt.Errorf("unable to listen for SOCKS connections on %s: %w", p.socksAddr, err)
+	}
+	var dnsListener net.PacketConn
+	if p.dnsAddr != "" {
+		if dnsListener, err = net.ListenPacket("udp", p.dnsAddr); err != nil {
+			socksListener.Close()
+			return nil, fmt.Errorf("unable to listen for DNS requests on %s: %w", p.dnsAddr, err)
+		}
+	}
+
+	tunnel, err := p.managerClient.ClientTunnel(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err = tunnel.Send(connpool.SessionInfoControl(p.session).TunnelMessage()); err != nil {
+		return nil, err
+	}
+
+	g := dgroup.ParentGroup(ctx)
+	g.Go("proxy-tunnel", func(ctx context.Context) error {
+		err := connpool.NewStream(tunnel).DialLoop(ctx, &p.closing, p.handlers)
+		var recvErr *client.RecvEOF
+		if errors.As(err, &recvErr) {
+			<-ctx.Done()
+		}
+		return err
+	})
+	if err = cache.SaveProxyAddressToUserCache(ctx, socksListener.Addr().String()); err != nil {
+		dlog.Errorf(ctx, "unable to record the SOCKS5 address: %v", err)
+	}
+	g.Go("proxy-socks", func(ctx context.Context) error {
+		defer func() {
+			_ = cache.DeleteProxyAddressFromUserCache(ctx)
+		}()
+		return p.serveSOCKS(ctx, socksListener, tunnel)
+	})
+	if dnsListener != nil {
+		g.Go("proxy-dns", func(ctx context.Context) error {
+			return dnsproxy.NewServer(ctx, []net.PacketConn{dnsListener}, p.fallbackDNS(ctx), p.resolve).Run(ctx)
+		})
+	}
+	dlog.Infof(ctx, "SOCKS5 proxy listening on %s", socksListener.Addr())
+	return &empty.Empty{}, nil
+}
+
+func (p *Proxy) serveSOCKS(ctx context.Context, l net.Listener, tunnel connpool.TunnelStream) error {
+	go func() {
+		<-ctx.Done()
+		atomic.StoreInt32(&p.closing, 1)
+		l.Close()
+	}()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		go p.handleSOCKS(ctx, conn, tunnel)
+	}
+}
+
+func (p *Proxy) handleSOCKS(ctx context.Context, conn net.Conn, tunnel connpool.TunnelStream) {
+	_ = conn.SetDeadline(time.Now().Add(10 * time.Second))
+	req, err := socksHandshake(conn)
+	if err != nil {
+		dlog.Debugf(ctx, "SOCKS handshake failed: %v", err)
+		conn.Close()
+		return
+	}
+	ip := req.IP
+	if ip == nil {
+		if ip = p.lookup(ctx, req.Host); ip == nil {
+			dlog.Debugf(ctx, "SOCKS unable to resolve %s", req)
+			_ = socksReply(conn, socksHostUnreachable)
+			conn.Close()
+			return
+		}
+	}
+	_ = conn.SetDeadline(time.Time{})
+
+	src := conn.RemoteAddr().(*net.TCPAddr)
+	id := connpool.NewConnID(ipproto.TCP, src.IP, ip, uint16(src.Port), req.Port)
+	// The reply is sent once the traffic-manager has answered, and before anything is relayed
+	onConnect := func(err error) {
+		if err != nil {
+			dlog.Debugf(ctx, "SOCKS unable to connect to %s: %v", req, err)
+		}
+		_ = socksReply(conn, socksStatus(err))
+	}
+	_, found, err := p.handlers.Get(ctx, id, func(ctx context.Context, release func()) (connpool.Handler, error) {
+		return connpool.HandlerFromConnAwaitingPeer(id, tunnel, release, conn, onConnect), nil
+	})
+	if err != nil || found {
+		if err == nil {
+			err = fmt.Errorf("connection %s is already in use", id)
+		}
+		dlog.Error(ctx, err)
+		_ = socksReply(conn, socksGeneralFailure)
+		conn.Close()
+	}
+}
+
+// lookup resolves the given host using the traffic-manager. An IPv4 address is preferred.
+func (p *Proxy) lookup(ctx context.Context, host string) net.IP {
+	if ip := net.ParseIP(host); ip != nil {
+		return ip
+	}
+	ips := p.resolve(ctx, dns.TypeA, host)
+	for _, ip := range ips {
+		if ip4 := ip.To4(); ip4 != nil {
+			return ip4
+		}
+	}
+	if len(ips) > 0 {
+		return ips[0]
+	}
+	return nil
+}
+
+func (p *Proxy) resolve(ctx context.Context, _ uint16, domain string) []net.IP {
+	p.externalHostsLock.RLock()
+	ips, ok := p.externalHosts[strings.ToLower(strings.TrimSuffix(domain, "."))]
+	p.externalHostsLock.RUnlock()
+	if ok {
+		return ips
+	}
+
+	r, err := p.managerClient.LookupHost(ctx, &manager.LookupHostRequest{
+		Session: p.session,
+		Host:    strings.TrimSuffix(domain, "."),
+	})
+	if err != nil {
+		dlog.Error(ctx, client.CheckTimeout(ctx, err))
+		return nil
+	}
+	ips = make([]net.IP, len(r.Ips))
+	for i, ip := range r.Ips {
+		ips[i] = ip
+	}
+	return ips
+}
+
+// fallbackDNS returns a connection to the first nameserver in /etc/resolv.conf, used for names
+// that the cluster doesn't know about. No fallback is used when that nameserver is the DNS stub.
+func (p *Proxy) fallbackDNS(ctx context.Context) *dns.Conn {
+	cfg, err := dns.ClientConfigFromFile("/etc/resolv.conf")
+	if err != nil || len(cfg.Servers) == 0 {
+		return nil
+	}
+	if net.JoinHostPort(cfg.Servers[0], cfg.Port) == p.dnsAddr {
+		return nil
+	}
+	conn, err := dns.Dial("udp", net.JoinHostPort(cfg.Servers[0], cfg.Port))
+	if err != nil {
+		dlog.Warnf(ctx, "unable to use %s as fallback DNS: %v", cfg.Servers[0], err)
+		return nil
+	}
+	return conn
+}
@@ -0,0 +1,217 @@
+package userd_proxy
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/connpool"
+)
+
+// SOCKS5 constants as defined in RFC 1928
+const (
+	socksVersion = 5
+
+	socksNoAuth       = 0
+	socksNoAcceptable = 0xff
+
+	socksCmdConnect = 1
+
+	socksAtypIPv4   = 1
+	socksAtypDomain = 3
+	socksAtypIPv6   = 4
+
+	socksSucceeded          = 0
+	socksGeneralFailure     = 1
+	socksNetworkUnreachable = 3
+	socksHostUnreachable    = 4
+	socksConnectionRefused  = 5
+	socksTTLExpired         = 6
+	socksCmdNotSupported    = 7
+	socksAtypeNotSupported  = 8
+)
+
+// socksRequest is a parsed SOCKS5 CONNECT request. Exactly one of IP and Host is set.
+type socksRequest struct {
+	IP   net.IP
+	Host string
+	Port uint16
+}
+
+func (r *socksRequest) String() string {
+	host := r.Host
+	if host == "" {
+		host = r.IP.String()
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(r.Port)))
+}
+
+// socksHandshake performs the SOCKS5 method negotiation, accepting only "no authentication", and
+// then reads a CONNECT request.
+func socksHandshake(rw io.ReadWriter) (*socksRequest, error) {
+	hdr := make([]byte, 2)
+	if _, err := io.ReadFull(rw, hdr); err != nil {
+		return nil, err
+	}
+	if hdr[0] != socksVersion {
+		return nil, fmt.Errorf("unsupported SOCKS version %d", hdr[0])
+	}
+	methods := make([]byte, hdr[1])
+	if _, err := io.ReadFull(rw, methods); err != nil {
+		return nil, err
+	}
+	method := byte(socksNoAcceptable)
+	for _, m := range methods {
+		if m == socksNoAuth {
+			method = socksNoAuth
+			break
+		}
+	}
+	if _, err := rw.Write([]byte{socksVersion, method}); err != nil {
+		return nil, err
+	}
+	if method == socksNoAcceptable {
+		return nil, errors.New("SOCKS client requires authentication")
+	}
+
+	req := make([]byte, 4) // version, command, reserved, address type
+	if _, err := io.ReadFull(rw, req); err != nil {
+		return nil, err
+	}
+	if req[0] != socksVersion {
+		return nil, fmt.Errorf("unsupported SOCKS version %d", req[0])
+	}
+	if req[1] != socksCmdConnect {
+		_ = socksReply(rw, socksCmdNotSupported)
+		return nil, fmt.Errorf("unsupported SOCKS command %d", req[1])
+	}
+
+	sr := &socksRequest{}
+	switch req[3] {
+	case socksAtypIPv4:
+		sr.IP = make(net.IP, net.IPv4len)
+		if _, err := io.ReadFull(rw, sr.IP); err != nil {
+			return nil, err
+		}
+	case socksAtypIPv6:
+		sr.IP = make(net.IP, net.IPv6len)
+		if _, err := io.ReadFull(rw, sr.IP); err != nil {
+			return nil, err
+		}
+	case socksAtypDomain:
+		l := make([]byte, 1)
+		if _, err := io.ReadFull(rw, l); err != nil {
+			return nil, err
+		}
+		host := make([]byte, l[0])
+		if _, err := io.ReadFull(rw, host); err != nil {
+			return nil, err
+		}
+		sr.Host = string(host)
+	default:
+		_ = socksReply(rw, socksAtypeNotSupported)
+		return nil, fmt.Errorf("unsupported SOCKS address type %d", req[3])
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(rw, port); err != nil {
+		return nil, err
+	}
+	sr.Port = binary.BigEndian.Uint16(port)
+	return sr, nil
+}
+
+// socksReply writes a reply with the given status. The bound address is always reported as
+// 0.0.0.0:0 since it has no meaning when the connection is tunneled.
+func socksReply(w io.Writer, status byte) error {
+	_, err := w.Write([]byte{socksVersion, status, 0, socksAtypIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
+
+// socksStatus returns the reply status for the given error of a connect, which is either an error
+// of a dial or a *connpool.ConnectError. Failures for unknown reasons are reported as an
+// unreachable host.
+func socksStatus(err error) byte {
+	if err == nil {
+		return socksSucceeded
+	}
+	reason := connpool.RejectReasonOf(err)
+	var ce *connpool.ConnectError
+	if errors.As(err, &ce) {
+		reason = ce.Reason
+	}
+	switch reason {
+	case connpool.RejectRefused:
+		return socksConnectionRefused
+	case connpool.RejectNetUnreachable:
+		return socksNetworkUnreachable
+	case connpool.RejectTimeout:
+		return socksTTLExpired
+	default:
+		return socksHostUnreachable
+	}
+}
+
+// DialFunc dials the destination of a SOCKS5 CONNECT request. Exactly one of ip and host is set.
+type DialFunc func(ctx context.Context, host string, ip net.IP, port uint16) (net.Conn, error)
+
+// ServeSOCKS serves the SOCKS5 connections that the given listener accepts until the context is
+// done. Unlike the Proxy, which tunnels the connections to the traffic-manager, it connects each
+// one to the connection returned by dial. A failed dial is reported with the status that matches
+// its error.
+func ServeSOCKS(ctx context.Context, l net.Listener, dial DialFunc) error {
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		go handleDialedSOCKS(ctx, conn, dial)
+	}
+}
+
+func handleDialedSOCKS(ctx context.Context, conn net.Conn, dial DialFunc) {
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(10 * time.Second))
+	req, err := socksHandshake(conn)
+	if err != nil {
+		dlog.Debugf(ctx, "SOCKS handshake failed: %v", err)
+		return
+	}
+	dst, err := dial(ctx, req.Host, req.IP, req.Port)
+	if err != nil {
+		dlog.Debugf(ctx, "SOCKS unable to connect to %s: %v", req, err)
+		_ = socksReply(conn, socksStatus(err))
+		return
+	}
+	defer dst.Close()
+	if err = socksReply(conn, socksSucceeded); err != nil {
+		return
+	}
+	_ = conn.SetDeadline(time.Time{})
+
+	done := make(chan struct{}, 2)
+	go func() {
+		_, _ = io.Copy(dst, conn)
+		done <- struct{}{}
+	}()
+	go func() {
+		_, _ = io.Copy(conn, dst)
+		done <- struct{}{}
+	}()
+	select {
+	case <-ctx.Done():
+	case <-done:
+	}
+}
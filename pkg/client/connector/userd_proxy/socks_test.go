@@ -0,0 +1,68 @@
+package userd_proxy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/connpool"
+)
+
+type rw struct {
+	*bytes.Reader
+	out bytes.Buffer
+}
+
+func (r *rw) Write(p []byte) (int, error) {
+	return r.out.Write(p)
+}
+
+func TestSocksHandshake(t *testing.T) {
+	t.Run("domain", func(t *testing.T) {
+		c := &rw{Reader: bytes.NewReader([]byte{
+			5, 1, 0, // version, one method, no auth
+			5, 1, 0, 3, 11, 'e', 'c', 'h', 'o', '.', 'd', 'e', 'f', 'a', 'u', 'l', 0x1f, 0x90,
+		})}
+		req, err := socksHandshake(c)
+		require.NoError(t, err)
+		assert.Equal(t, "echo.defaul", req.Host)
+		assert.Equal(t, uint16(8080), req.Port)
+		assert.Equal(t, "echo.defaul:8080", req.String())
+		assert.Equal(t, []byte{5, 0}, c.out.Bytes())
+	})
+	t.Run("ipv4", func(t *testing.T) {
+		c := &rw{Reader: bytes.NewReader([]byte{5, 2, 2, 0, 5, 1, 0, 1, 10, 0, 0, 1, 0, 80})}
+		req, err := socksHandshake(c)
+		require.NoError(t, err)
+		assert.True(t, net.IPv4(10, 0, 0, 1).Equal(req.IP))
+		assert.Equal(t, uint16(80), req.Port)
+	})
+	t.Run("auth required", func(t *testing.T) {
+		c := &rw{Reader: bytes.NewReader([]byte{5, 1, 2})}
+		_, err := socksHandshake(c)
+		assert.Error(t, err)
+		assert.Equal(t, []byte{5, 0xff}, c.out.Bytes())
+	})
+	t.Run("bind not supported", func(t *testing.T) {
+		c := &rw{Reader: bytes.NewReader([]byte{5, 1, 0, 5, 2, 0, 1, 10, 0, 0, 1, 0, 80})}
+		_, err := socksHandshake(c)
+		assert.Error(t, err)
+		assert.Equal(t, byte(socksCmdNotSupported), c.out.Bytes()[3])
+	})
+}
+
+func TestSocksStatus(t *testing.T) {
+	assert.Equal(t, byte(socksSucceeded), socksStatus(nil))
+	assert.Equal(t, byte(socksConnectionRefused), socksStatus(&connpool.ConnectError{Reason: connpool.RejectRefused}))
+	assert.Equal(t, byte(socksNetworkUnreachable), socksStatus(&connpool.ConnectError{Reason: connpool.RejectNetUnreachable}))
+	assert.Equal(t, byte(socksTTLExpired), socksStatus(&connpool.ConnectError{Reason: connpool.RejectTimeout}))
+	assert.Equal(t, byte(socksHostUnreachable), socksStatus(&connpool.ConnectError{Reason: connpool.RejectUnknown}))
+	assert.Equal(t, byte(socksConnectionRefused), socksStatus(fmt.Errorf("dial: %w", syscall.ECONNREFUSED)))
+	assert.Equal(t, byte(socksHostUnreachable), socksStatus(errors.New("no route")))
+}
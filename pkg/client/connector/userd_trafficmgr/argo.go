@@ -0,0 +1,82 @@
+package userd_trafficmgr
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/datawire/ambassador/pkg/kates"
+	"github.com/telepresenceio/telepresence/v2/pkg/install"
+)
+
+// decideArgoRolloutAgent decides how the traffic-agent is installed in the Argo Rollout of the
+// given change, which doesn't enable its injection. Like for a DeploymentConfig, the injection is
+// enabled in its pod template, and the traffic-manager adds the agent to the pods of the rollout
+// that follows. The rollout goes through the canary or blue-green steps of the Rollout, including
+// its analysis.
+func (ki *installer) decideArgoRolloutAgent(c context.Context, ch *agentChange, svcName, portNameOrNumber string) error {
+	ro := ch.obj.(*kates.Unstructured)
+	if ref, ok, _ := unstructured.NestedStringMap(ro.Object, "spec", "workloadRef"); ok {
+		return install.ObjErrorf(ro, "references the pod template of %s %s, which can't be intercepted through the Rollout",
+			ref["kind"], ref["name"])
+	}
+	return ki.decideTemplateInjection(c, ch, svcName, portNameOrNumber)
+}
+
+// argoRolloutUpdated returns true when the latest rollout of the given Argo Rollout is done, which
+// includes passing its analysis and being promoted.
+func argoRolloutUpdated(ro *kates.Unstructured, origGeneration int64) bool {
+	status := func(field string) int64 {
+		v, _, _ := unstructured.NestedInt64(ro.Object, "status", field)
+		return v
+	}
+	replicas, found, _ := unstructured.NestedInt64(ro.Object, "spec", "replicas")
+	if !found {
+		replicas = 1
+	}
+	// The observedGeneration of a Rollout is a string, and releases before Argo Rollouts v1.0
+	// don't have a phase.
+	observed, _, _ := unstructured.NestedString(ro.Object, "status", "observedGeneration")
+	phase, _, _ := unstructured.NestedString(ro.Object, "status", "phase")
+	return ro.GetGeneration() >= origGeneration &&
+		observed == strconv.FormatInt(ro.GetGeneration(), 10) &&
+		(phase == "" || phase == "Healthy") &&
+		status("updatedReplicas") >= replicas &&
+		status("updatedReplicas") == status("replicas") &&
+		status("availableReplicas") == status("replicas")
+}
+
+// argoRolloutFailure returns an error when the given Argo Rollout was aborted, e.g. because its
+// analysis failed, or is degraded, e.g. because it exceeded its progress deadline. Argo Rollouts
+// scales the stable pods back up when that happens, but the Rollout stays that way until its pod
+// template changes again.
+func argoRolloutFailure(ro *kates.Unstructured) error {
+	aborted, _, _ := unstructured.NestedBool(ro.Object, "status", "abort")
+	phase, _, _ := unstructured.NestedString(ro.Object, "status", "phase")
+	if !aborted && phase != "Degraded" {
+		return nil
+	}
+	msg, _, _ := unstructured.NestedString(ro.Object, "status", "message")
+	if msg == "" {
+		msg = "no reason given"
+	}
+	if aborted {
+		return fmt.Errorf("rollout %s.%s was aborted: %s", ro.GetName(), ro.GetNamespace(), msg)
+	}
+	return fmt.Errorf("rollout %s.%s is degraded: %s", ro.GetName(), ro.GetNamespace(), msg)
+}
+
+// argoRolloutProgress returns a short description of how far the given Argo Rollout has come
+func argoRolloutProgress(ro *kates.Unstructured) string {
+	status := func(field string) int64 {
+		v, _, _ := unstructured.NestedInt64(ro.Object, "status", field)
+		return v
+	}
+	p := fmt.Sprintf("%d updated, %d available, of %d replicas", status("updatedReplicas"), status("availableReplicas"), status("replicas"))
+	if phase, _, _ := unstructured.NestedString(ro.Object, "status", "phase"); phase != "" {
+		p = phase + ", " + p
+	}
+	return p
+}
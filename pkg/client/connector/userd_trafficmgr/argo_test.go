@@ -0,0 +1,87 @@
+package userd_trafficmgr
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/ambassador/pkg/kates"
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/filelocation"
+	"github.com/telepresenceio/telepresence/v2/pkg/install"
+)
+
+func argoRollout(status map[string]interface{}) *kates.Unstructured {
+	return &kates.Unstructured{Object: map[string]interface{}{
+		"apiVersion": install.ArgoRolloutAPIVersion,
+		"kind":       "Rollout",
+		"metadata":   map[string]interface{}{"name": "hello", "namespace": "default", "generation": int64(3)},
+		"spec": map[string]interface{}{
+			"replicas": int64(2),
+			"template": map[string]interface{}{
+				"metadata": map[string]interface{}{"labels": map[string]interface{}{"app": "hello"}},
+				"spec": map[string]interface{}{
+					"containers": []interface{}{map[string]interface{}{"name": "hello", "image": "hello"}},
+				},
+			},
+		},
+		"status": status,
+	}}
+}
+
+func TestArgoRolloutUpdated(t *testing.T) {
+	withStatus := func(observedGeneration, phase string, replicas, updated, available int64) *kates.Unstructured {
+		return argoRollout(map[string]interface{}{
+			"observedGeneration": observedGeneration,
+			"phase":              phase,
+			"replicas":           replicas,
+			"updatedReplicas":    updated,
+			"availableReplicas":  available,
+		})
+	}
+	assert.True(t, argoRolloutUpdated(withStatus("3", "Healthy", 2, 2, 2), 3))
+	assert.True(t, argoRolloutUpdated(withStatus("3", "", 2, 2, 2), 3), "releases before v1.0 have no phase")
+	assert.False(t, argoRolloutUpdated(withStatus("3", "Healthy", 2, 2, 2), 4), "the new generation isn't seen yet")
+	assert.False(t, argoRolloutUpdated(withStatus("2", "Healthy", 2, 2, 2), 3), "the new generation isn't observed yet")
+	assert.False(t, argoRolloutUpdated(withStatus("3", "Paused", 2, 2, 2), 3), "the rollout isn't promoted yet")
+	assert.False(t, argoRolloutUpdated(withStatus("3", "Progressing", 3, 1, 3), 3), "the canary isn't complete")
+	assert.False(t, argoRolloutUpdated(withStatus("3", "Progressing", 2, 2, 1), 3), "new pods aren't available")
+}
+
+func TestArgoRolloutFailure(t *testing.T) {
+	assert.NoError(t, argoRolloutFailure(argoRollout(map[string]interface{}{"phase": "Progressing"})))
+
+	err := argoRolloutFailure(argoRollout(map[string]interface{}{
+		"phase":   "Degraded",
+		"abort":   true,
+		"message": `RolloutAborted: Metric "success-rate" assessed Failed due to failed (1) > failureLimit (0)`,
+	}))
+	require.Error(t, err)
+	assert.Equal(t, `rollout hello.default was aborted: RolloutAborted: Metric "success-rate" assessed Failed due to failed (1) > failureLimit (0)`, err.Error())
+
+	err = argoRolloutFailure(argoRollout(map[string]interface{}{
+		"phase":   "Degraded",
+		"message": "ProgressDeadlineExceeded: ReplicaSet \"hello-123\" has timed out progressing.",
+	}))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "is degraded: ProgressDeadlineExceeded")
+}
+
+func TestDecideArgoRolloutAgentWithWorkloadRef(t *testing.T) {
+	ctx := filelocation.WithAppUserConfigDir(dlog.NewTestContext(t, false), t.TempDir())
+	ctx = filelocation.WithAppSystemConfigDirs(ctx, nil)
+	client.ResetConfig(ctx)
+	defer client.ResetConfig(ctx)
+
+	ro := argoRollout(nil)
+	spec := ro.Object["spec"].(map[string]interface{})
+	delete(spec, "template")
+	spec["workloadRef"] = map[string]interface{}{"apiVersion": "apps/v1", "kind": "Deployment", "name": "hello"}
+	ch := &agentChange{kind: "Rollout", orig: ro.DeepCopy(), obj: ro}
+	err := (&installer{}).decideArgoRolloutAgent(ctx, ch, "", "")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "references the pod template of Deployment hello")
+	assert.Empty(t, ro.GetAnnotations(), "the Rollout isn't modified")
+}
@@ -0,0 +1,159 @@
+package userd_trafficmgr
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/connpool"
+	"github.com/telepresenceio/telepresence/v2/pkg/ipproto"
+)
+
+// auxTunnel carries the auxiliary connections of an intercept, i.e. the SFTP connection of its
+// remote mount and the connections to its extra ports, over a gRPC tunnel to the traffic-manager
+// that the connector opens itself. The connections therefore don't depend on the TUN device of the
+// root daemon, so they work in proxy mode too, and they need no other route to the cluster than the
+// one that the connector already has to the traffic-manager.
+type auxTunnel struct {
+	managerClient manager.ManagerClient
+	session       *manager.SessionInfo
+	handlers      *connpool.Pool
+	breaker       *client.Breaker
+	closing       int32
+
+	streamLock sync.Mutex
+	stream     *connpool.Stream
+	opened     bool
+}
+
+func newAuxTunnel(managerClient manager.ManagerClient, session *manager.SessionInfo, breaker *client.Breaker) *auxTunnel {
+	return &auxTunnel{
+		managerClient: managerClient,
+		session:       session,
+		handlers:      connpool.NewPool(),
+		breaker:       breaker,
+	}
+}
+
+// getStream returns the stream of the tunnel. The tunnel is opened by the first call, and opened
+// again by the first call after it was lost, as soon as the breaker allows. It's closed when the
+// given context is done.
+func (t *auxTunnel) getStream(ctx context.Context) (*connpool.Stream, error) {
+	t.streamLock.Lock()
+	defer t.streamLock.Unlock()
+	if t.stream != nil {
+		return t.stream, nil
+	}
+	if t.opened {
+		if err := t.breaker.Wait(ctx); err != nil {
+			return nil, err
+		}
+	}
+	t.opened = true
+	tunnel, err := t.managerClient.ClientTunnel(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open a tunnel to the traffic-manager: %w", err)
+	}
+	if err = tunnel.Send(connpool.SessionInfoControl(t.session).TunnelMessage()); err != nil {
+		return nil, fmt.Errorf("unable to open a tunnel to the traffic-manager: %w", err)
+	}
+	stream := connpool.NewStream(tunnel)
+	t.stream = stream
+	go func() {
+		go func() {
+			<-ctx.Done()
+			atomic.StoreInt32(&t.closing, 1)
+		}()
+		if err := stream.DialLoop(ctx, &t.closing, t.handlers); err != nil && ctx.Err() == nil {
+			dlog.Errorf(ctx, "auxiliary tunnel to the traffic-manager failed: %v", err)
+		}
+		t.handlers.CloseAll(ctx)
+		t.streamLock.Lock()
+		if t.stream == stream {
+			t.stream = nil
+		}
+		t.streamLock.Unlock()
+	}()
+	return stream, nil
+}
+
+// forward makes the traffic-manager connect the given connection to the given port of the given
+// pod. The source address of the connection identifies it in the tunnel, so it must be unique
+// among the connections of this client, which is true for connections accepted on loopback.
+func (t *auxTunnel) forward(ctx context.Context, conn net.Conn, podIP net.IP, port uint16) error {
+	stream, err := t.getStream(ctx)
+	if err != nil {
+		return err
+	}
+	src := conn.RemoteAddr().(*net.TCPAddr)
+	id := connpool.NewConnID(ipproto.TCP, src.IP, podIP, uint16(src.Port), port)
+	_, found, err := t.handlers.Get(ctx, id, func(ctx context.Context, release func()) (connpool.Handler, error) {
+		return connpool.HandlerFromConn(id, stream, release, conn), nil
+	})
+	if err == nil && found {
+		err = fmt.Errorf("connection %s is already in use", id)
+	}
+	return err
+}
+
+// dial returns a connection to the given port of the given pod. The returned connection is one end
+// of a loopback connection, and the other end is forwarded through the tunnel.
+func (t *auxTunnel) dial(ctx context.Context, podIP net.IP, port uint16) (net.Conn, error) {
+	l, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		return nil, err
+	}
+	defer l.Close()
+
+	type accepted struct {
+		conn net.Conn
+		err  error
+	}
+	acceptCh := make(chan accepted, 1)
+	go func() {
+		conn, err := l.AcceptTCP()
+		acceptCh <- accepted{conn, err}
+	}()
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", l.Addr().String())
+	if err != nil {
+		return nil, err
+	}
+	a := <-acceptCh
+	if a.err != nil {
+		conn.Close()
+		return nil, a.err
+	}
+	if err = t.forward(ctx, a.conn, podIP, port); err != nil {
+		a.conn.Close()
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// serve accepts connections on the given listener and forwards them to the given port of the
+// given pod until the context is done.
+func (t *auxTunnel) serve(ctx context.Context, l *net.TCPListener, podIP net.IP, port uint16) error {
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+	for {
+		conn, err := l.AcceptTCP()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		if err = t.forward(ctx, conn, podIP, port); err != nil {
+			dlog.Errorf(ctx, "unable to forward %s to %s: %v", conn.RemoteAddr(), net.JoinHostPort(podIP.String(), fmt.Sprint(port)), err)
+			conn.Close()
+		}
+	}
+}
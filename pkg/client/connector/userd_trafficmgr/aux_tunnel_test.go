@@ -0,0 +1,174 @@
+package userd_trafficmgr
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/connpool"
+	"github.com/telepresenceio/telepresence/v2/pkg/filelocation"
+)
+
+// tunnelManager is the part of the traffic-manager that serves the auxiliary tunnels. It dials the
+// destinations of the tunneled connections itself, and counts the tunnels that it has served. A
+// tunnel that it serves is dropped by a send on drop.
+type tunnelManager struct {
+	manager.UnimplementedManagerServer
+	tunnels int32
+	drop    chan struct{}
+}
+
+func (m *tunnelManager) ClientTunnel(server manager.Manager_ClientTunnelServer) error {
+	ctx := server.Context()
+
+	// The initial message is the session info
+	if _, err := server.Recv(); err != nil {
+		return err
+	}
+	atomic.AddInt32(&m.tunnels, 1)
+	stream := connpool.NewStream(server)
+	pool := connpool.NewPool()
+	defer pool.CloseAll(ctx)
+
+	closing := int32(0)
+	msgCh, errCh := stream.ReadLoop(ctx, &closing)
+	for {
+		select {
+		case <-ctx.Done():
+			atomic.StoreInt32(&closing, 2)
+			return nil
+		case <-m.drop:
+			return errors.New("tunnel dropped")
+		case err := <-errCh:
+			return err
+		case msg := <-msgCh:
+			if msg == nil {
+				return nil
+			}
+			id := msg.ID()
+			h, _, err := pool.Get(ctx, id, func(ctx context.Context, release func()) (connpool.Handler, error) {
+				if ctrl, ok := msg.(connpool.Control); !ok || ctrl.Code() != connpool.Connect {
+					return nil, nil
+				}
+				return connpool.NewDialer(id, stream, release), nil
+			})
+			if err != nil {
+				return err
+			}
+			if h != nil {
+				h.HandleMessage(ctx, msg)
+			}
+		}
+	}
+}
+
+// startTunnelManager serves the given manager on an in-memory socket and returns a client of it.
+func startTunnelManager(ctx context.Context, t *testing.T, m *tunnelManager) manager.ManagerClient {
+	lis := bufconn.Listen(64 * 1024)
+	srv := grpc.NewServer()
+	manager.RegisterManagerServer(srv, m)
+	go func() {
+		_ = srv.Serve(lis)
+	}()
+	t.Cleanup(srv.Stop)
+	conn, err := grpc.DialContext(ctx, "bufnet", grpc.WithInsecure(),
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	return manager.NewManagerClient(conn)
+}
+
+// startEcho starts a server that echoes what it reads, and returns its port.
+func startEcho(t *testing.T) uint16 {
+	l, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return uint16(l.Addr().(*net.TCPAddr).Port)
+}
+
+func echo(conn net.Conn, msg string) (string, error) {
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Write([]byte(msg)); err != nil {
+		return "", err
+	}
+	buf := make([]byte, len(msg))
+	_, err := io.ReadFull(conn, buf)
+	return string(buf), err
+}
+
+func TestAuxTunnel(t *testing.T) {
+	// The handlers of the tunneled connections outlive the test, so they don't log to it
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ctx = filelocation.WithAppUserConfigDir(ctx, t.TempDir())
+
+	m := &tunnelManager{drop: make(chan struct{})}
+	aux := newAuxTunnel(startTunnelManager(ctx, t, m), &manager.SessionInfo{SessionId: "session-01"},
+		client.NewBreaker(ctx, "the traffic-manager"))
+	localhost := net.IPv4(127, 0, 0, 1)
+	port := startEcho(t)
+
+	conn, err := aux.dial(ctx, localhost, port)
+	require.NoError(t, err)
+	defer conn.Close()
+	reply, err := echo(conn, "hello")
+	require.NoError(t, err)
+	assert.Equal(t, "hello", reply)
+
+	// The connections that serve accepts share the tunnel
+	l, err := net.ListenTCP("tcp", &net.TCPAddr{IP: localhost})
+	require.NoError(t, err)
+	serveCtx, stopServe := context.WithCancel(ctx)
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- aux.serve(serveCtx, l, localhost, port)
+	}()
+	for i := 0; i < 3; i++ {
+		fwd, err := net.Dial("tcp", l.Addr().String())
+		require.NoError(t, err)
+		reply, err = echo(fwd, "forwarded")
+		fwd.Close()
+		require.NoError(t, err)
+		assert.Equal(t, "forwarded", reply)
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&m.tunnels))
+
+	// The tunnel is opened again when it's lost
+	m.drop <- struct{}{}
+	assert.Eventually(t, func() bool {
+		conn, err := aux.dial(ctx, localhost, port)
+		if err != nil {
+			return false
+		}
+		defer conn.Close()
+		reply, err := echo(conn, "again")
+		return err == nil && reply == "again"
+	}, 10*time.Second, 100*time.Millisecond)
+	assert.Greater(t, atomic.LoadInt32(&m.tunnels), int32(1))
+
+	stopServe()
+	assert.NoError(t, <-serveErr)
+}
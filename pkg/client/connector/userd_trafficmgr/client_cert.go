@@ -0,0 +1,85 @@
+package userd_trafficmgr
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+)
+
+// keychainPrefix marks a reference to an item in the keychain of the OS, as opposed to a file path
+const keychainPrefix = "keychain:"
+
+var pemPrefix = []byte("-----BEGIN ")
+
+// applyEndpointTLSConfig adds the client certificate and the CA that are configured for TLS manager
+// endpoints to the given TLS config.
+func applyEndpointTLSConfig(c context.Context, cfg *client.TLS, tlsConfig *tls.Config) error {
+	if cfg.ClientCert != "" {
+		certPEM, err := readPEM(c, cfg.ClientCert)
+		if err != nil {
+			return fmt.Errorf("unable to read the tls.clientCert: %w", err)
+		}
+		keyPEM := certPEM
+		if cfg.ClientKey != "" {
+			if keyPEM, err = readPEM(c, cfg.ClientKey); err != nil {
+				return fmt.Errorf("unable to read the tls.clientKey: %w", err)
+			}
+		}
+		cert, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			return fmt.Errorf("invalid tls.clientCert or tls.clientKey: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	if cfg.EndpointCA != "" {
+		caPEM, err := readPEM(c, cfg.EndpointCA)
+		if err != nil {
+			return fmt.Errorf("unable to read the tls.endpointCA: %w", err)
+		}
+		caPool := x509.NewCertPool()
+		if !caPool.AppendCertsFromPEM(caPEM) {
+			return errors.New("no valid CA certificate found in the tls.endpointCA")
+		}
+		tlsConfig.RootCAs = caPool
+	}
+	return nil
+}
+
+// readPEM reads PEM encoded data from the file or the keychain item that the given reference
+// denotes.
+func readPEM(c context.Context, ref string) ([]byte, error) {
+	if name := strings.TrimPrefix(ref, keychainPrefix); name != ref {
+		data, err := readKeychainItem(c, name)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read %q from the keychain: %w", name, err)
+		}
+		return decodeKeychainPEM(data)
+	}
+	return ioutil.ReadFile(ref)
+}
+
+// decodeKeychainPEM returns the PEM encoded data that is stored in a keychain item. Keychain tools
+// print secrets that contain line breaks hex encoded, and some users store them base64 encoded to
+// avoid the line breaks, so both encodings are accepted too.
+func decodeKeychainPEM(data []byte) ([]byte, error) {
+	data = bytes.TrimSpace(data)
+	if bytes.HasPrefix(data, pemPrefix) {
+		return data, nil
+	}
+	if d, err := hex.DecodeString(string(data)); err == nil && bytes.HasPrefix(d, pemPrefix) {
+		return d, nil
+	}
+	if d, err := base64.StdEncoding.DecodeString(string(data)); err == nil && bytes.HasPrefix(d, pemPrefix) {
+		return d, nil
+	}
+	return nil, errors.New("the item doesn't contain PEM encoded data")
+}
@@ -0,0 +1,93 @@
+package userd_trafficmgr
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+)
+
+func selfSignedPEM(t *testing.T) (certPEM, keyPEM []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "developer"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func TestApplyEndpointTLSConfig(t *testing.T) {
+	ctx := context.Background()
+	certPEM, keyPEM := selfSignedPEM(t)
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "client.crt")
+	keyFile := filepath.Join(dir, "client.key")
+	bothFile := filepath.Join(dir, "client.pem")
+	require.NoError(t, ioutil.WriteFile(certFile, certPEM, 0600))
+	require.NoError(t, ioutil.WriteFile(keyFile, keyPEM, 0600))
+	require.NoError(t, ioutil.WriteFile(bothFile, append(append([]byte{}, certPEM...), keyPEM...), 0600))
+
+	cfg := &tls.Config{}
+	require.NoError(t, applyEndpointTLSConfig(ctx, &client.TLS{ClientCert: certFile, ClientKey: keyFile, EndpointCA: certFile}, cfg))
+	assert.Len(t, cfg.Certificates, 1)
+	assert.NotNil(t, cfg.RootCAs)
+
+	// The key may be in the same file as the certificate
+	cfg = &tls.Config{}
+	require.NoError(t, applyEndpointTLSConfig(ctx, &client.TLS{ClientCert: bothFile}, cfg))
+	assert.Len(t, cfg.Certificates, 1)
+	assert.Nil(t, cfg.RootCAs)
+
+	assert.Error(t, applyEndpointTLSConfig(ctx, &client.TLS{ClientCert: certFile}, &tls.Config{}))
+	assert.Error(t, applyEndpointTLSConfig(ctx, &client.TLS{EndpointCA: keyFile}, &tls.Config{}))
+	assert.Error(t, applyEndpointTLSConfig(ctx, &client.TLS{ClientCert: filepath.Join(dir, "missing")}, &tls.Config{}))
+}
+
+func TestDecodeKeychainPEM(t *testing.T) {
+	certPEM, _ := selfSignedPEM(t)
+	for _, data := range [][]byte{
+		append(certPEM, '\n'),
+		[]byte(hex.EncodeToString(certPEM) + "\n"),
+		[]byte(base64.StdEncoding.EncodeToString(certPEM)),
+	} {
+		d, err := decodeKeychainPEM(data)
+		require.NoError(t, err)
+		assert.Equal(t, string(bytes.TrimSpace(certPEM)), string(bytes.TrimSpace(d)))
+	}
+	_, err := decodeKeychainPEM([]byte("hunter2"))
+	assert.Error(t, err)
+}
+
+func TestCheckEndpointAuth(t *testing.T) {
+	assert.Equal(t, errPlaintextEndpoint, checkEndpointAuth(&client.TLS{}, false))
+	assert.Equal(t, errPlaintextEndpoint, checkEndpointAuth(&client.TLS{}, true))
+	assert.Equal(t, errPlaintextEndpoint, checkEndpointAuth(&client.TLS{ClientCert: "client.pem"}, false))
+	assert.NoError(t, checkEndpointAuth(&client.TLS{ClientCert: "client.pem"}, true))
+	assert.NoError(t, checkEndpointAuth(&client.TLS{ManagerMTLS: true}, false))
+}
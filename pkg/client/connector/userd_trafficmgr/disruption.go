@@ -0,0 +1,412 @@
+package userd_trafficmgr
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	"github.com/datawire/ambassador/pkg/kates"
+	"github.com/datawire/dlib/dcontext"
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/install"
+)
+
+// groupResource is the API group and resource of a kind of object.
+type groupResource struct {
+	group, resource string
+}
+
+// rolloutStep is a change that prepareRollout makes to the cluster before a workload is updated
+// with a pod template that has the traffic-agent.
+type rolloutStep struct {
+	// explanation tells what the step changes, in the same form as the explanation of an action
+	explanation string
+
+	// verb and the groupResource is the permission that the step needs. The step is optional when
+	// the rollout goes ahead without it.
+	verb string
+	groupResource
+	optional bool
+
+	// apply makes the change. It returns the function that restores it, or nil when there's nothing
+	// to restore.
+	apply func(context.Context) func(context.Context)
+}
+
+// rolloutPlan is what prepareRollout does for the rollout of a workload.
+type rolloutPlan struct {
+	steps    []*rolloutStep
+	warnings []string
+
+	// lists are the resources that are listed to decide on the steps
+	lists []groupResource
+}
+
+// planRollout decides what prepareRollout does for the rollout of the given workload. It checks the
+// rollout against the PodDisruptionBudgets of the workload, and pauses its HorizontalPodAutoscalers,
+// as configured by cluster.disruptionBudgets and cluster.pauseAutoscalers. Nothing is changed. An
+// error is returned when the rollout must not happen.
+func (ki *installer) planRollout(c context.Context, kind string, obj kates.Object) (*rolloutPlan, error) {
+	cfg := client.GetConfig(c).Cluster
+	rp := &rolloutPlan{}
+
+	if cfg.DisruptionBudgets != client.DisruptionBudgetsIgnore {
+		rp.lists = append(rp.lists, groupResource{"policy", "poddisruptionbudgets"})
+		pdbs, err := ki.listBudgets(c, obj.GetNamespace())
+		switch {
+		case err == nil:
+		case cfg.DisruptionBudgets == client.DisruptionBudgetsRespect:
+			return nil, install.ObjErrorf(obj, "unable to check its PodDisruptionBudgets: %v. "+
+				"Set cluster.disruptionBudgets to %q in the config.yml to add the %s anyway",
+				err, client.DisruptionBudgetsWarn, install.AgentContainerName)
+		default:
+			rp.warnings = append(rp.warnings, fmt.Sprintf("Unable to check the PodDisruptionBudgets of namespace %s: %v", obj.GetNamespace(), err))
+		}
+		if err == nil {
+			step, warning, err := ki.budgetStep(kind, obj, pdbs, cfg.DisruptionBudgets == client.DisruptionBudgetsRespect)
+			if err != nil {
+				return nil, err
+			}
+			if step != nil {
+				rp.steps = append(rp.steps, step)
+			}
+			if warning != "" {
+				rp.warnings = append(rp.warnings, warning)
+			}
+		}
+	}
+	if cfg.PauseAutoscalers {
+		rp.lists = append(rp.lists, groupResource{"autoscaling", "horizontalpodautoscalers"})
+		var hpas []*autoscalingv1.HorizontalPodAutoscaler
+		err := ki.Client().List(c, kates.Query{Kind: "HorizontalPodAutoscaler.v1.autoscaling", Namespace: obj.GetNamespace()}, &hpas)
+		if err != nil {
+			rp.warnings = append(rp.warnings, fmt.Sprintf("Unable to pause the HorizontalPodAutoscalers of namespace %s: %v", obj.GetNamespace(), err))
+		} else {
+			rp.steps = append(rp.steps, ki.autoscalerSteps(kind, obj, hpas)...)
+		}
+	}
+	return rp, nil
+}
+
+// listBudgets returns the PodDisruptionBudgets of the given namespace. They're listed using the
+// policy/v1 API, and the policy/v1beta1 API when the cluster is older than 1.21. Both versions
+// have the fields that the checks use.
+func (ki *installer) listBudgets(c context.Context, namespace string) ([]*policyv1beta1.PodDisruptionBudget, error) {
+	var pdbs []*policyv1beta1.PodDisruptionBudget
+	err := ki.Client().List(c, kates.Query{Kind: "PodDisruptionBudget.v1.policy", Namespace: namespace}, &pdbs)
+	if meta.IsNoMatchError(err) {
+		err = ki.Client().List(c, kates.Query{Kind: "PodDisruptionBudget.v1beta1.policy", Namespace: namespace}, &pdbs)
+	}
+	return pdbs, err
+}
+
+// prepareRollout is called before the given workload is updated with a pod template that has the
+// traffic-agent. It applies the steps of planRollout, and logs its warnings. The returned function
+// must be called when the rollout is done. It restores what was changed for the rollout.
+func (ki *installer) prepareRollout(c context.Context, kind string, obj kates.Object) (func(), error) {
+	rp, err := ki.planRollout(c, kind, obj)
+	if err != nil {
+		return nil, err
+	}
+	for _, w := range rp.warnings {
+		dlog.Warn(c, w)
+	}
+	var restores []func(context.Context)
+	for _, step := range rp.steps {
+		if r := step.apply(c); r != nil {
+			restores = append(restores, r)
+		}
+	}
+	return func() {
+		c := dcontext.WithoutCancel(c)
+		for i := len(restores) - 1; i >= 0; i-- {
+			restores[i](c)
+		}
+	}, nil
+}
+
+// budgetStep compares the number of pods that the rollout of the given workload may make
+// unavailable with the disruptions that the given PodDisruptionBudgets allow. When the rollout may
+// exceed them, a warning is returned, or, if respect is true, a step that limits the rolling update
+// of a Deployment to the allowed disruptions. Other workloads can't be limited, so an error is
+// returned for them.
+func (ki *installer) budgetStep(kind string, obj kates.Object, pdbs []*policyv1beta1.PodDisruptionBudget, respect bool) (*rolloutStep, string, error) {
+	podTemplate, err := install.GetPodTemplateFromObject(obj)
+	if err != nil {
+		return nil, "", err
+	}
+	pdb, allowed := tightestBudget(pdbs, podTemplate.Labels)
+	if pdb == nil {
+		return nil, "", nil
+	}
+	unavailable, limitable := rolloutUnavailability(obj)
+	if unavailable <= allowed {
+		return nil, "", nil
+	}
+	if !respect {
+		return nil, fmt.Sprintf("Adding the %s to %s %s.%s may make %d of its pods unavailable, but PodDisruptionBudget %s only allows %d disruptions",
+			install.AgentContainerName, kind, obj.GetName(), obj.GetNamespace(), unavailable, pdb.Name, allowed), nil
+	}
+	if !limitable {
+		return nil, "", install.ObjErrorf(obj, "adding the %s may make %d of its pods unavailable, but PodDisruptionBudget %s only allows %d disruptions. "+
+			"Set cluster.disruptionBudgets to %q in the config.yml to add it anyway",
+			install.AgentContainerName, unavailable, pdb.Name, allowed, client.DisruptionBudgetsWarn)
+	}
+
+	dep := obj.(*kates.Deployment)
+	pdbName := pdb.Name
+	return &rolloutStep{
+		explanation: fmt.Sprintf("In %s %s, limit the rollout to %d unavailable pods to respect PodDisruptionBudget %s",
+			kind, dep.Name, allowed, pdbName),
+		verb:          "update",
+		groupResource: workloadResource[kind],
+		apply: func(c context.Context) func(context.Context) {
+			origStrategy := dep.Spec.Strategy.DeepCopy()
+			limitRollingUpdate(dep, allowed)
+			dlog.Infof(c, "Limiting the rollout of %s %s.%s to %d unavailable pods to respect PodDisruptionBudget %s",
+				kind, dep.Name, dep.Namespace, allowed, pdbName)
+			return func(c context.Context) {
+				current := &kates.Deployment{
+					TypeMeta:   metav1.TypeMeta{Kind: "Deployment", APIVersion: "apps/v1"},
+					ObjectMeta: metav1.ObjectMeta{Name: dep.Name, Namespace: dep.Namespace},
+				}
+				if err := ki.Client().Get(c, current, current); err != nil {
+					dlog.Errorf(c, "unable to restore the update strategy of %s.%s: %v", dep.Name, dep.Namespace, err)
+					return
+				}
+				orig := current.DeepCopy()
+				current.Spec.Strategy = *origStrategy
+				if err := ki.updateObject(c, orig, current); err != nil {
+					dlog.Errorf(c, "unable to restore the update strategy of %s.%s: %v", dep.Name, dep.Namespace, err)
+				}
+			}
+		},
+	}, "", nil
+}
+
+// tightestBudget returns the PodDisruptionBudget that selects pods with the given labels and
+// allows the fewest disruptions, together with the number of disruptions that it allows, or nil
+// when no budget selects the pods.
+func tightestBudget(pdbs []*policyv1beta1.PodDisruptionBudget, podLabels map[string]string) (*policyv1beta1.PodDisruptionBudget, int32) {
+	var tightest *policyv1beta1.PodDisruptionBudget
+	var allowed int32
+	for _, pdb := range pdbs {
+		sel, err := metav1.LabelSelectorAsSelector(pdb.Spec.Selector)
+		if err != nil || !sel.Matches(labels.Set(podLabels)) {
+			continue
+		}
+		if tightest == nil || pdb.Status.DisruptionsAllowed < allowed {
+			tightest, allowed = pdb, pdb.Status.DisruptionsAllowed
+		}
+	}
+	return tightest, allowed
+}
+
+// rolloutUnavailability returns the number of pods that a rollout of the given workload may make
+// unavailable at the same time, and whether that number can be limited by changing the update
+// strategy of the workload.
+func rolloutUnavailability(obj kates.Object) (int32, bool) {
+	replicas := func(r *int32) int32 {
+		if r == nil {
+			return 1
+		}
+		return *r
+	}
+	switch w := obj.(type) {
+	case *kates.Deployment:
+		n := replicas(w.Spec.Replicas)
+		if w.Spec.Strategy.Type == appsv1.RecreateDeploymentStrategyType {
+			// Changing the strategy could break a workload that relies on the old pods being gone,
+			// e.g. because they use a volume that can only be mounted once
+			return n, false
+		}
+		maxUnavailable := intstr.FromString("25%")
+		if ru := w.Spec.Strategy.RollingUpdate; ru != nil && ru.MaxUnavailable != nil {
+			maxUnavailable = *ru.MaxUnavailable
+		}
+		u, err := intstr.GetValueFromIntOrPercent(&maxUnavailable, int(n), false)
+		if err != nil {
+			return n, true
+		}
+		return int32(u), true
+	case *kates.StatefulSet:
+		if w.Spec.UpdateStrategy.Type == appsv1.OnDeleteStatefulSetStrategyType || replicas(w.Spec.Replicas) == 0 {
+			return 0, false
+		}
+		// A rolling update of a StatefulSet replaces one pod at a time
+		return 1, false
+	case *kates.ReplicaSet:
+		// The pods of a ReplicaSet are all deleted, see refreshReplicaSet
+		return replicas(w.Spec.Replicas), false
+	default:
+		return 0, false
+	}
+}
+
+// limitRollingUpdate makes the rolling update of the given Deployment make at most the given number
+// of pods unavailable. A surge of at least one pod is needed for the rollout to progress when no
+// pod may be unavailable.
+func limitRollingUpdate(dep *kates.Deployment, maxUnavailable int32) {
+	mu := intstr.FromInt(int(maxUnavailable))
+	ru := &appsv1.RollingUpdateDeployment{MaxUnavailable: &mu}
+	if maxUnavailable == 0 {
+		surge := intstr.FromInt(1)
+		if orig := dep.Spec.Strategy.RollingUpdate; orig != nil && orig.MaxSurge != nil {
+			if s, err := intstr.GetValueFromIntOrPercent(orig.MaxSurge, 1, true); err == nil && s > 0 {
+				surge = *orig.MaxSurge
+			}
+		}
+		ru.MaxSurge = &surge
+	} else if orig := dep.Spec.Strategy.RollingUpdate; orig != nil {
+		ru.MaxSurge = orig.MaxSurge
+	}
+	dep.Spec.Strategy = appsv1.DeploymentStrategy{Type: appsv1.RollingUpdateDeploymentStrategyType, RollingUpdate: ru}
+}
+
+// autoscalerSteps returns the steps that make those of the given HorizontalPodAutoscalers that
+// scale the given workload keep its current number of replicas until the rollout is done. The
+// steps are optional, because the rollout goes ahead when an autoscaler can't be paused.
+func (ki *installer) autoscalerSteps(kind string, obj kates.Object, hpas []*autoscalingv1.HorizontalPodAutoscaler) []*rolloutStep {
+	var steps []*rolloutStep
+	for _, hpa := range hpas {
+		ref := hpa.Spec.ScaleTargetRef
+		if ref.Kind != kind || ref.Name != obj.GetName() {
+			continue
+		}
+		pinned := hpa.Status.CurrentReplicas
+		if pinned < 1 {
+			continue
+		}
+		hpa := hpa
+		steps = append(steps, &rolloutStep{
+			explanation: fmt.Sprintf("In HorizontalPodAutoscaler %s, keep the replicas at %d until the %s is rolled out",
+				hpa.Name, pinned, install.AgentContainerName),
+			verb:          "update",
+			groupResource: groupResource{"autoscaling", "horizontalpodautoscalers"},
+			optional:      true,
+			apply: func(c context.Context) func(context.Context) {
+				return ki.pauseAutoscaler(c, hpa, pinned)
+			},
+		})
+	}
+	return steps
+}
+
+// labelPausedBy is set on a HorizontalPodAutoscaler that is paused while the traffic-agent is
+// rolled out. Its value is the install ID of the client that paused it, and annPausedAutoscaler
+// holds the replicas that the autoscaler had before, so that the client can resume it when it
+// connects again after it crashed in the middle of the rollout.
+const (
+	labelPausedBy       = install.DomainPrefix + "paused-by"
+	annPausedAutoscaler = install.DomainPrefix + "paused-autoscaler"
+)
+
+// pausedReplicas is the value of the annPausedAutoscaler annotation.
+type pausedReplicas struct {
+	MinReplicas *int32 `json:"minReplicas,omitempty"`
+	MaxReplicas int32  `json:"maxReplicas"`
+}
+
+// pauseAutoscaler makes the given HorizontalPodAutoscaler keep the given number of replicas. The
+// returned function, if any, restores the autoscaler.
+func (ki *installer) pauseAutoscaler(c context.Context, hpa *autoscalingv1.HorizontalPodAutoscaler, pinned int32) func(context.Context) {
+	hpa.TypeMeta = metav1.TypeMeta{Kind: "HorizontalPodAutoscaler", APIVersion: "autoscaling/v1"}
+	orig := hpa.DeepCopy()
+	if _, ok := hpa.Labels[labelPausedBy]; ok {
+		// Another rollout has paused it, and that rollout resumes it
+		return nil
+	}
+	paused, err := json.Marshal(&pausedReplicas{MinReplicas: hpa.Spec.MinReplicas, MaxReplicas: hpa.Spec.MaxReplicas})
+	if err != nil {
+		dlog.Errorf(c, "unable to pause HorizontalPodAutoscaler %s.%s: %v", hpa.Name, hpa.Namespace, err)
+		return nil
+	}
+	if hpa.Labels == nil {
+		hpa.Labels = make(map[string]string)
+	}
+	hpa.Labels[labelPausedBy] = ki.installID
+	if hpa.Annotations == nil {
+		hpa.Annotations = make(map[string]string)
+	}
+	hpa.Annotations[annPausedAutoscaler] = string(paused)
+	hpa.Spec.MinReplicas = &pinned
+	hpa.Spec.MaxReplicas = pinned
+	if err := ki.updateObject(c, orig, hpa); err != nil {
+		dlog.Errorf(c, "unable to pause HorizontalPodAutoscaler %s.%s: %v", hpa.Name, hpa.Namespace, err)
+		return nil
+	}
+	dlog.Infof(c, "Pausing HorizontalPodAutoscaler %s.%s at %d replicas while the %s is rolled out",
+		hpa.Name, hpa.Namespace, pinned, install.AgentContainerName)
+	name, namespace := hpa.Name, hpa.Namespace
+	return func(c context.Context) {
+		current := &autoscalingv1.HorizontalPodAutoscaler{
+			TypeMeta:   metav1.TypeMeta{Kind: "HorizontalPodAutoscaler", APIVersion: "autoscaling/v1"},
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		}
+		if err := ki.Client().Get(c, current, current); err != nil {
+			dlog.Errorf(c, "unable to resume HorizontalPodAutoscaler %s.%s: %v", name, namespace, err)
+			return
+		}
+		ki.resumeAutoscaler(c, current)
+	}
+}
+
+// resumeAutoscaler restores the replicas that the given HorizontalPodAutoscaler had before
+// pauseAutoscaler paused it.
+func (ki *installer) resumeAutoscaler(c context.Context, hpa *autoscalingv1.HorizontalPodAutoscaler) {
+	ann, ok := hpa.Annotations[annPausedAutoscaler]
+	if !ok {
+		return
+	}
+	var paused pausedReplicas
+	if err := json.Unmarshal([]byte(ann), &paused); err != nil {
+		dlog.Errorf(c, "unable to resume HorizontalPodAutoscaler %s.%s: invalid annotation %s: %v", hpa.Name, hpa.Namespace, annPausedAutoscaler, err)
+		return
+	}
+	hpa.TypeMeta = metav1.TypeMeta{Kind: "HorizontalPodAutoscaler", APIVersion: "autoscaling/v1"}
+	orig := hpa.DeepCopy()
+	delete(hpa.Labels, labelPausedBy)
+	delete(hpa.Annotations, annPausedAutoscaler)
+	hpa.Spec.MinReplicas = paused.MinReplicas
+	hpa.Spec.MaxReplicas = paused.MaxReplicas
+	if err := ki.updateObject(c, orig, hpa); err != nil {
+		dlog.Errorf(c, "unable to resume HorizontalPodAutoscaler %s.%s: %v", hpa.Name, hpa.Namespace, err)
+	}
+}
+
+// resumeAutoscalers resumes the HorizontalPodAutoscalers that this client paused for a rollout that
+// it never finished, e.g. because it crashed.
+func (ki *installer) resumeAutoscalers(c context.Context) {
+	if ki.installID == "" {
+		return
+	}
+	var hpas []*autoscalingv1.HorizontalPodAutoscaler
+	err := ki.Client().List(c, kates.Query{
+		Kind:          "HorizontalPodAutoscaler.v1.autoscaling",
+		LabelSelector: labelPausedBy + "=" + ki.installID,
+	}, &hpas)
+	if err != nil {
+		if errors.IsForbidden(err) {
+			// The user isn't allowed to list them in all namespaces, and so can't have paused any
+			// that the list would return
+			dlog.Debugf(c, "unable to list paused HorizontalPodAutoscalers: %v", err)
+		} else {
+			dlog.Warnf(c, "unable to list paused HorizontalPodAutoscalers: %v", err)
+		}
+		return
+	}
+	for _, hpa := range hpas {
+		dlog.Infof(c, "Resuming HorizontalPodAutoscaler %s.%s that was paused by an earlier session", hpa.Name, hpa.Namespace)
+		ki.resumeAutoscaler(c, hpa)
+	}
+}
@@ -0,0 +1,169 @@
+package userd_trafficmgr
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	"github.com/datawire/ambassador/pkg/kates"
+	"github.com/datawire/dlib/dlog"
+)
+
+func TestTightestBudget(t *testing.T) {
+	pdb := func(name string, selector map[string]string, allowed int32) *policyv1beta1.PodDisruptionBudget {
+		p := &policyv1beta1.PodDisruptionBudget{ObjectMeta: metav1.ObjectMeta{Name: name}}
+		p.Spec.Selector = &metav1.LabelSelector{MatchLabels: selector}
+		p.Status.DisruptionsAllowed = allowed
+		return p
+	}
+	pdbs := []*policyv1beta1.PodDisruptionBudget{
+		pdb("other", map[string]string{"app": "other"}, 0),
+		pdb("loose", map[string]string{"app": "echo"}, 3),
+		pdb("tight", map[string]string{"tier": "web"}, 1),
+	}
+	p, allowed := tightestBudget(pdbs, map[string]string{"app": "echo", "tier": "web"})
+	require.NotNil(t, p)
+	assert.Equal(t, "tight", p.Name)
+	assert.Equal(t, int32(1), allowed)
+
+	p, _ = tightestBudget(pdbs, map[string]string{"app": "db"})
+	assert.Nil(t, p)
+}
+
+func TestRolloutUnavailability(t *testing.T) {
+	four := int32(4)
+	dep := &kates.Deployment{}
+	dep.Spec.Replicas = &four
+
+	// The default maxUnavailable is 25%
+	u, limitable := rolloutUnavailability(dep)
+	assert.Equal(t, int32(1), u)
+	assert.True(t, limitable)
+
+	mu := intstr.FromString("50%")
+	dep.Spec.Strategy.RollingUpdate = &appsv1.RollingUpdateDeployment{MaxUnavailable: &mu}
+	u, _ = rolloutUnavailability(dep)
+	assert.Equal(t, int32(2), u)
+
+	dep.Spec.Strategy = appsv1.DeploymentStrategy{Type: appsv1.RecreateDeploymentStrategyType}
+	u, limitable = rolloutUnavailability(dep)
+	assert.Equal(t, int32(4), u)
+	assert.False(t, limitable)
+
+	sts := &kates.StatefulSet{}
+	sts.Spec.Replicas = &four
+	u, limitable = rolloutUnavailability(sts)
+	assert.Equal(t, int32(1), u)
+	assert.False(t, limitable)
+
+	rs := &kates.ReplicaSet{}
+	rs.Spec.Replicas = &four
+	u, _ = rolloutUnavailability(rs)
+	assert.Equal(t, int32(4), u)
+}
+
+func TestLimitRollingUpdate(t *testing.T) {
+	dep := &kates.Deployment{}
+	surge := intstr.FromString("0%")
+	dep.Spec.Strategy.RollingUpdate = &appsv1.RollingUpdateDeployment{MaxSurge: &surge}
+	limitRollingUpdate(dep, 0)
+	ru := dep.Spec.Strategy.RollingUpdate
+	assert.Equal(t, appsv1.RollingUpdateDeploymentStrategyType, dep.Spec.Strategy.Type)
+	assert.Equal(t, intstr.FromInt(0), *ru.MaxUnavailable)
+	assert.Equal(t, intstr.FromInt(1), *ru.MaxSurge)
+
+	surge = intstr.FromString("50%")
+	dep.Spec.Strategy.RollingUpdate = &appsv1.RollingUpdateDeployment{MaxSurge: &surge}
+	limitRollingUpdate(dep, 2)
+	ru = dep.Spec.Strategy.RollingUpdate
+	assert.Equal(t, intstr.FromInt(2), *ru.MaxUnavailable)
+	assert.Equal(t, surge, *ru.MaxSurge)
+}
+
+func TestBudgetStep(t *testing.T) {
+	ki := &installer{}
+	four := int32(4)
+	newDep := func() *kates.Deployment {
+		dep := &kates.Deployment{
+			TypeMeta:   metav1.TypeMeta{Kind: "Deployment"},
+			ObjectMeta: metav1.ObjectMeta{Name: "echo", Namespace: "default"},
+		}
+		dep.Spec.Replicas = &four
+		dep.Spec.Template.Labels = map[string]string{"app": "echo"}
+		mu := intstr.FromInt(2)
+		dep.Spec.Strategy.RollingUpdate = &appsv1.RollingUpdateDeployment{MaxUnavailable: &mu}
+		return dep
+	}
+	pdb := &policyv1beta1.PodDisruptionBudget{ObjectMeta: metav1.ObjectMeta{Name: "echo-pdb"}}
+	pdb.Spec.Selector = &metav1.LabelSelector{MatchLabels: map[string]string{"app": "echo"}}
+	pdb.Status.DisruptionsAllowed = 1
+	pdbs := []*policyv1beta1.PodDisruptionBudget{pdb}
+
+	// Warn
+	step, warning, err := ki.budgetStep("Deployment", newDep(), pdbs, false)
+	require.NoError(t, err)
+	assert.Nil(t, step)
+	assert.Equal(t, "Adding the traffic-agent to Deployment echo.default may make 2 of its pods unavailable, "+
+		"but PodDisruptionBudget echo-pdb only allows 1 disruptions", warning)
+
+	// Respect, by limiting the rolling update when the step is applied
+	dep := newDep()
+	step, warning, err = ki.budgetStep("Deployment", dep, pdbs, true)
+	require.NoError(t, err)
+	assert.Empty(t, warning)
+	require.NotNil(t, step)
+	assert.Equal(t, "In Deployment echo, limit the rollout to 1 unavailable pods to respect PodDisruptionBudget echo-pdb", step.explanation)
+	assert.Equal(t, "update", step.verb)
+	assert.Equal(t, groupResource{"apps", "deployments"}, step.groupResource)
+	assert.False(t, step.optional)
+	assert.Equal(t, intstr.FromInt(2), *dep.Spec.Strategy.RollingUpdate.MaxUnavailable, "not changed until applied")
+	assert.NotNil(t, step.apply(dlog.NewTestContext(t, false)))
+	assert.Equal(t, intstr.FromInt(1), *dep.Spec.Strategy.RollingUpdate.MaxUnavailable)
+
+	// A StatefulSet can't be limited
+	sts := &kates.StatefulSet{
+		TypeMeta:   metav1.TypeMeta{Kind: "StatefulSet"},
+		ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "default"},
+	}
+	sts.Spec.Replicas = &four
+	sts.Spec.Template.Labels = map[string]string{"app": "echo"}
+	pdb.Status.DisruptionsAllowed = 0
+	_, _, err = ki.budgetStep("StatefulSet", sts, pdbs, true)
+	assert.Error(t, err)
+
+	// Within the budget
+	pdb.Status.DisruptionsAllowed = 2
+	step, warning, err = ki.budgetStep("Deployment", newDep(), pdbs, true)
+	require.NoError(t, err)
+	assert.Nil(t, step)
+	assert.Empty(t, warning)
+}
+
+func TestAutoscalerSteps(t *testing.T) {
+	ki := &installer{}
+	hpa := func(name, kind, target string, current int32) *autoscalingv1.HorizontalPodAutoscaler {
+		h := &autoscalingv1.HorizontalPodAutoscaler{ObjectMeta: metav1.ObjectMeta{Name: name}}
+		h.Spec.ScaleTargetRef = autoscalingv1.CrossVersionObjectReference{Kind: kind, Name: target}
+		h.Status.CurrentReplicas = current
+		return h
+	}
+	hpas := []*autoscalingv1.HorizontalPodAutoscaler{
+		hpa("echo", "Deployment", "echo", 3),
+		hpa("other", "Deployment", "other", 2),
+		hpa("sts", "StatefulSet", "echo", 2),
+		hpa("idle", "Deployment", "echo", 0),
+	}
+	dep := &kates.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "echo", Namespace: "default"}}
+	steps := ki.autoscalerSteps("Deployment", dep, hpas)
+	require.Len(t, steps, 1)
+	assert.Equal(t, "In HorizontalPodAutoscaler echo, keep the replicas at 3 until the traffic-agent is rolled out", steps[0].explanation)
+	assert.Equal(t, "update", steps[0].verb)
+	assert.Equal(t, groupResource{"autoscaling", "horizontalpodautoscalers"}, steps[0].groupResource)
+	assert.True(t, steps[0].optional)
+}
@@ -0,0 +1,84 @@
+package userd_trafficmgr
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"sync/atomic"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/managerutil"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+)
+
+// withIdentity returns a context that passes the identity of the user to the traffic-manager, see
+// IdentityToken. It's read anew for each call, so that a token that a login helper refreshes is
+// picked up.
+func (tm *trafficManager) withIdentity(c context.Context) context.Context {
+	if md, ok := metadata.FromOutgoingContext(c); ok && len(md.Get(managerutil.IdentityTokenHeader)) > 0 {
+		return c
+	}
+	token, err := IdentityToken(c, tm.BearerToken(), atomic.LoadInt32(&tm.wantsIdentity) == 1)
+	if err != nil {
+		dlog.Errorf(c, "unable to read the cluster.managerToken: %v", err)
+		return c
+	}
+	if token != "" {
+		c = metadata.AppendToOutgoingContext(c, managerutil.IdentityTokenHeader, token)
+	}
+	return c
+}
+
+// IdentityToken returns the token that identifies the user to the traffic-manager. It's the token
+// that the cluster.managerToken of the config.yml refers to when it's set. Otherwise, it's the given
+// bearer token of the kubeconfig, but only when the traffic-manager wants the identity of the user.
+// The bearer token is a credential of the cluster, so it's never handed to whatever answers on the
+// manager port by default.
+func IdentityToken(c context.Context, bearerToken string, wantsIdentity bool) (string, error) {
+	if ref := client.GetConfig(c).Cluster.ManagerToken; ref != "" {
+		return readToken(c, ref)
+	}
+	if wantsIdentity {
+		return bearerToken, nil
+	}
+	return "", nil
+}
+
+// identityDialOptions returns the dial options that pass the identity of the user in every call to
+// the traffic-manager, including the calls that the manager proxy forwards for the daemons. The
+// traffic-manager refuses calls without it when it requires clients to authenticate.
+func (tm *trafficManager) identityDialOptions() []grpc.DialOption {
+	return []grpc.DialOption{
+		grpc.WithChainUnaryInterceptor(func(c context.Context, method string, req, reply interface{},
+			cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+			return invoker(tm.withIdentity(c), method, req, reply, cc, opts...)
+		}),
+		grpc.WithChainStreamInterceptor(func(c context.Context, desc *grpc.StreamDesc,
+			cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+			return streamer(tm.withIdentity(c), desc, cc, method, opts...)
+		}),
+	}
+}
+
+// readToken reads a token from the file or the keychain item that the given reference denotes.
+func readToken(c context.Context, ref string) (string, error) {
+	var data []byte
+	var err error
+	if name := strings.TrimPrefix(ref, keychainPrefix); name != ref {
+		data, err = readKeychainItem(c, name)
+	} else {
+		data, err = ioutil.ReadFile(ref)
+	}
+	if err != nil {
+		return "", err
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", errors.New("the token is empty")
+	}
+	return token, nil
+}
@@ -0,0 +1,39 @@
+package userd_trafficmgr
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/filelocation"
+)
+
+func TestIdentityToken(t *testing.T) {
+	dir := t.TempDir()
+	ctx := filelocation.WithAppUserConfigDir(dlog.NewTestContext(t, false), dir)
+	ctx = filelocation.WithAppSystemConfigDirs(ctx, nil)
+	client.ResetConfig(ctx)
+	defer client.ResetConfig(ctx)
+
+	// The bearer token of the kubeconfig is only passed when the traffic-manager wants it
+	token, err := IdentityToken(ctx, "kube-token", false)
+	require.NoError(t, err)
+	assert.Empty(t, token)
+	token, err = IdentityToken(ctx, "kube-token", true)
+	require.NoError(t, err)
+	assert.Equal(t, "kube-token", token)
+
+	// The cluster.managerToken is always passed
+	tokenFile := filepath.Join(dir, "manager-token")
+	require.NoError(t, ioutil.WriteFile(tokenFile, []byte("manager-token\n"), 0600))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "config.yml"), []byte("cluster:\n  managerToken: "+tokenFile+"\n"), 0600))
+	client.ResetConfig(ctx)
+	token, err = IdentityToken(ctx, "kube-token", false)
+	require.NoError(t, err)
+	assert.Equal(t, "manager-token", token)
+}
@@ -0,0 +1,333 @@
+package userd_trafficmgr
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	"github.com/datawire/ambassador/pkg/kates"
+	"github.com/datawire/dlib/dcontext"
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/connector/userd_intercept"
+	"github.com/telepresenceio/telepresence/v2/pkg/install"
+)
+
+// annDeploymentConfigInjection is the annotation of a DeploymentConfig that tells that the injection
+// of the traffic-agent was enabled in its pod template. Its value holds the original values of the
+// template annotations that were changed, so that they can be restored.
+const annDeploymentConfigInjection = install.DomainPrefix + "deploymentconfig-injection"
+
+// annArgoRolloutInjection is the annotation of an Argo Rollout that tells that the injection of the
+// traffic-agent was enabled in its pod template, like the annDeploymentConfigInjection.
+const annArgoRolloutInjection = install.DomainPrefix + "rollout-injection"
+
+// injectionAnnotations are the annotations that tell that the injection of the traffic-agent was
+// enabled, by the kind of the unstructured workload that they annotate.
+var injectionAnnotations = map[string]string{
+	"DeploymentConfig": annDeploymentConfigInjection,
+	"Rollout":          annArgoRolloutInjection,
+}
+
+var templateAnnotationsPath = []string{"spec", "template", "metadata", "annotations"}
+
+// setTemplateAnnotations sets the given annotations of the pod template of the given workload, and
+// records their original values in the annotation of the workload with the given name, so that
+// restoreTemplateAnnotations can restore them. An annotation is left as it is when keep returns true
+// for its current value. It returns false if the annotation with the given name is already present.
+func setTemplateAnnotations(obj *kates.Unstructured, name string, values map[string]string, keep func(k, cur string) bool) (bool, error) {
+	ann := obj.GetAnnotations()
+	if _, ok := ann[name]; ok {
+		return false, nil
+	}
+	tplAnn, _, err := unstructured.NestedStringMap(obj.Object, templateAnnotationsPath...)
+	if err != nil {
+		return false, install.ObjErrorf(obj, "unable to get template annotations: %v", err)
+	}
+	if tplAnn == nil {
+		tplAnn = make(map[string]string)
+	}
+
+	// A nil value means that the annotation wasn't set
+	origValues := make(map[string]*string)
+	for k, v := range values {
+		cur, ok := tplAnn[k]
+		if ok {
+			if cur == v || keep != nil && keep(k, cur) {
+				continue
+			}
+			origValues[k] = &cur
+		} else {
+			origValues[k] = nil
+		}
+		tplAnn[k] = v
+	}
+	origJSON, err := json.Marshal(origValues)
+	if err != nil {
+		return false, err
+	}
+	if err = unstructured.SetNestedStringMap(obj.Object, tplAnn, templateAnnotationsPath...); err != nil {
+		return false, install.ObjErrorf(obj, "unable to set template annotations: %v", err)
+	}
+	if ann == nil {
+		ann = make(map[string]string)
+	}
+	ann[name] = string(origJSON)
+	obj.SetAnnotations(ann)
+	return true, nil
+}
+
+// restoreTemplateAnnotations restores the pod template annotations that setTemplateAnnotations
+// changed in the given workload. It returns false if the annotation with the given name isn't
+// present.
+func restoreTemplateAnnotations(obj *kates.Unstructured, name string) (bool, error) {
+	ann := obj.GetAnnotations()
+	origJSON, ok := ann[name]
+	if !ok {
+		return false, nil
+	}
+	var origValues map[string]*string
+	if err := json.Unmarshal([]byte(origJSON), &origValues); err != nil {
+		return false, install.ObjErrorf(obj, "annotations[%q]: unable to parse annotation: %q: %w", name, origJSON, err)
+	}
+	tplAnn, _, err := unstructured.NestedStringMap(obj.Object, templateAnnotationsPath...)
+	if err != nil {
+		return false, install.ObjErrorf(obj, "unable to get template annotations: %v", err)
+	}
+	for k, v := range origValues {
+		if v == nil {
+			delete(tplAnn, k)
+		} else {
+			if tplAnn == nil {
+				tplAnn = make(map[string]string)
+			}
+			tplAnn[k] = *v
+		}
+	}
+	if len(tplAnn) == 0 {
+		unstructured.RemoveNestedField(obj.Object, templateAnnotationsPath...)
+	} else if err = unstructured.SetNestedStringMap(obj.Object, tplAnn, templateAnnotationsPath...); err != nil {
+		return false, install.ObjErrorf(obj, "unable to set template annotations: %v", err)
+	}
+	delete(ann, name)
+	if len(ann) == 0 {
+		ann = nil
+	}
+	obj.SetAnnotations(ann)
+	return true, nil
+}
+
+// decideDeploymentConfigAgent decides how the traffic-agent is installed in the DeploymentConfig of
+// the given change, which doesn't enable its injection. The connector can't add the traffic-agent
+// to a DeploymentConfig, so the injection is enabled in its pod template instead, and the
+// traffic-manager adds the agent to the pods of the rollout that follows.
+func (ki *installer) decideDeploymentConfigAgent(c context.Context, ch *agentChange, svcName, portNameOrNumber string) error {
+	dc := ch.obj.(*kates.Unstructured)
+	if !deploymentConfigRollsOutOnChange(dc) {
+		return install.ObjErrorf(dc, "has no ConfigChange trigger, so its pods won't get the %s until it's rolled out. "+
+			"Add the annotation %s: enabled to its pod template and roll it out", install.AgentContainerName, install.InjectAnnotation)
+	}
+	return ki.decideTemplateInjection(c, ch, svcName, portNameOrNumber)
+}
+
+// decideTemplateInjection decides how the injection of the traffic-agent is enabled in the pod
+// template of the unstructured workload of the given change.
+func (ki *installer) decideTemplateInjection(c context.Context, ch *agentChange, svcName, portNameOrNumber string) error {
+	u := ch.obj.(*kates.Unstructured)
+	if client.GetConfig(c).Cluster.AgentInjection == client.AgentInjectionPatch {
+		return install.ObjErrorf(u, "can only be intercepted when the %s is injected by the traffic-manager, "+
+			"which it isn't when cluster.agentInjection is %q", install.AgentContainerName, client.AgentInjectionPatch)
+	}
+	podTemplate, err := install.GetPodTemplateFromObject(u)
+	if err != nil {
+		return err
+	}
+	if ch.svc, err = install.FindMatchingService(c, ki.Client(), portNameOrNumber, svcName, u.GetNamespace(), podTemplate.Labels); err != nil {
+		return err
+	}
+	if err = redirectNumericPort(ch, podTemplate.Spec.Containers, portNameOrNumber); err != nil {
+		return err
+	}
+	values := map[string]string{install.InjectAnnotation: "enabled"}
+	if portNameOrNumber != "" {
+		values[install.ServicePortAnnotation] = portNameOrNumber
+	}
+	modified, err := setTemplateAnnotations(u, injectionAnnotations[ch.kind], values, nil)
+	if err != nil {
+		return err
+	}
+	if modified {
+		ch.agent = userd_intercept.AgentEnableInjection
+	} else {
+		ch.agent = userd_intercept.AgentInjected
+	}
+	return nil
+}
+
+// deploymentConfigRollsOutOnChange returns true if the given DeploymentConfig is rolled out when its
+// pod template changes, which it is by default.
+func deploymentConfigRollsOutOnChange(dc *kates.Unstructured) bool {
+	triggers, found, _ := unstructured.NestedSlice(dc.Object, "spec", "triggers")
+	if !found {
+		return true
+	}
+	for _, t := range triggers {
+		if trigger, ok := t.(map[string]interface{}); ok && trigger["type"] == "ConfigChange" {
+			return true
+		}
+	}
+	return false
+}
+
+// ensureTemplateInjection enables the injection of the traffic-agent in the pod template of the
+// unstructured workload of the given change, and waits until its rollout is done. The UID of the
+// service of the change is returned.
+func (ki *installer) ensureTemplateInjection(c context.Context, ch *agentChange) (string, error) {
+	u := ch.obj.(*kates.Unstructured)
+	namespace, name := u.GetNamespace(), u.GetName()
+	dlog.Infof(c, "Enabling injection of the %s into %s %s.%s", install.AgentContainerName, ch.kind, name, namespace)
+	restore, err := ki.prepareRollout(c, ch.kind, u)
+	if err != nil {
+		return "", err
+	}
+	defer restore()
+	if err = ki.updateObject(c, ch.orig, u); err != nil {
+		return "", err
+	}
+	if err = ki.updateRedirectedService(c, ch); err != nil {
+		return "", err
+	}
+	if err = ki.waitForAgentRollout(c, namespace, name, u); err != nil {
+		// Don't leave the workload stuck in a rollout that won't complete
+		dlog.Errorf(c, "Rollout of %s %s.%s failed, disabling injection of the %s: %v", ch.kind, name, namespace, install.AgentContainerName, err)
+		if _, uerr := ki.removeTemplateInjection(dcontext.WithoutCancel(c), u); uerr != nil {
+			dlog.Errorf(c, "unable to disable injection of the %s into %s %s.%s: %v", install.AgentContainerName, ch.kind, name, namespace, uerr)
+		}
+		if ch.origSvc != nil {
+			if uerr := ki.undoServiceMods(dcontext.WithoutCancel(c), ch.svc); uerr != nil {
+				dlog.Errorf(c, "unable to restore service %s.%s: %v", ch.svc.Name, namespace, uerr)
+			}
+		}
+		return "", fmt.Errorf("rollout of %s %s.%s with the %s failed, the injection was disabled again: %w",
+			ch.kind, name, namespace, install.AgentContainerName, err)
+	}
+	return string(ch.svc.GetUID()), nil
+}
+
+// removeTemplateInjection disables the injection of the traffic-agent that ensureTemplateInjection
+// enabled in the given workload. It returns false if there was nothing to disable.
+func (ki *installer) removeTemplateInjection(c context.Context, u *kates.Unstructured) (bool, error) {
+	orig := u.DeepCopyObject().(kates.Object)
+	modified, err := restoreTemplateAnnotations(u, injectionAnnotations[u.GetKind()])
+	if err != nil || !modified {
+		return false, err
+	}
+	dlog.Infof(c, "Disabling injection of the %s into %s %s.%s", install.AgentContainerName, u.GetKind(), u.GetName(), u.GetNamespace())
+	if err = ki.updateObject(c, orig, u); err != nil {
+		return false, err
+	}
+	return true, ki.undoInjectedServiceMods(c, u)
+}
+
+// deploymentConfigUpdated returns true when the latest rollout of the given DeploymentConfig is
+// done.
+func deploymentConfigUpdated(dc *kates.Unstructured, origGeneration int64) bool {
+	status := func(field string) int64 {
+		v, _, _ := unstructured.NestedInt64(dc.Object, "status", field)
+		return v
+	}
+	replicas, found, _ := unstructured.NestedInt64(dc.Object, "spec", "replicas")
+	if !found {
+		replicas = 1
+	}
+	return dc.GetGeneration() >= origGeneration &&
+		status("observedGeneration") == dc.GetGeneration() &&
+		status("updatedReplicas") >= replicas &&
+		status("updatedReplicas") == status("replicas") &&
+		status("availableReplicas") == status("replicas")
+}
+
+// redirectNumericPort makes the service of the given change refer to the traffic-agent that the
+// traffic-manager injects into the pods with the given containers, when the service refers to the
+// app container by port number. A numeric port can't be taken over by renaming the container
+// port, so the agent port is given the install.NumericPortName of the app port instead, and the
+// service is made to refer to that name, just like when the agent is added by the connector. The
+// service is modified on a copy, and the original is kept in the origSvc of the change.
+func redirectNumericPort(ch *agentChange, cns []corev1.Container, portNameOrNumber string) error {
+	if _, ok := ch.svc.Annotations[annTelepresenceActions]; ok {
+		// Redirected already
+		return nil
+	}
+	servicePort, _, _, err := install.FindMatchingPort(cns, portNameOrNumber, ch.svc)
+	if err != nil {
+		return install.ObjErrorf(ch.obj, err.Error())
+	}
+	if servicePort.TargetPort.Type != intstr.Int {
+		return nil
+	}
+	sa := &svcActions{Version: client.Semver().String()}
+	if n := servicePort.TargetPort.IntVal; n != 0 {
+		sa.MakePortSymbolic = &makePortSymbolicAction{
+			PortName:     servicePort.Name,
+			TargetPort:   uint16(n),
+			SymbolicName: install.NumericPortName(n),
+		}
+	} else {
+		sa.AddSymbolicPort = &addSymbolicPortAction{makePortSymbolicAction{
+			PortName:     servicePort.Name,
+			TargetPort:   uint16(servicePort.Port),
+			SymbolicName: install.NumericPortName(servicePort.Port),
+		}}
+	}
+	svc := ch.svc.DeepCopy()
+	if err = sa.Do(svc); err != nil {
+		return err
+	}
+	if svc.Annotations == nil {
+		svc.Annotations = make(map[string]string)
+	}
+	if svc.Annotations[annTelepresenceActions], err = sa.MarshalAnnotation(); err != nil {
+		return err
+	}
+	ch.origSvc, ch.svc = ch.svc, svc
+	return nil
+}
+
+// updateRedirectedService updates the service of the given change when redirectNumericPort
+// modified it.
+func (ki *installer) updateRedirectedService(c context.Context, ch *agentChange) error {
+	if ch.origSvc == nil {
+		return nil
+	}
+	var sa svcActions
+	if _, err := getAnnotation(ch.svc, &sa); err != nil {
+		return err
+	}
+	explainDo(c, &sa, ch.svc)
+	return ki.updateObject(c, ch.origSvc, ch.svc)
+}
+
+// undoInjectedServiceMods undoes the changes that redirectNumericPort made to the services of the
+// given workload, which has an injected traffic-agent.
+func (ki *installer) undoInjectedServiceMods(c context.Context, obj kates.Object) error {
+	podTemplate, err := install.GetPodTemplateFromObject(obj)
+	if err != nil {
+		return err
+	}
+	svcs, err := ki.MatchingServices(c, obj.GetNamespace(), podTemplate.Labels)
+	if err != nil {
+		return err
+	}
+	for _, svc := range svcs {
+		if _, ok := svc.Annotations[annTelepresenceActions]; ok {
+			if err = ki.undoServiceMods(c, svc); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
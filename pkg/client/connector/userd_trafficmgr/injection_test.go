@@ -0,0 +1,143 @@
+package userd_trafficmgr
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	"github.com/datawire/ambassador/pkg/kates"
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/filelocation"
+	"github.com/telepresenceio/telepresence/v2/pkg/install"
+	"github.com/telepresenceio/telepresence/v2/pkg/version"
+)
+
+func deploymentConfig(tplAnnotations map[string]interface{}, triggers ...string) *kates.Unstructured {
+	tpl := map[string]interface{}{
+		"metadata": map[string]interface{}{"labels": map[string]interface{}{"app": "hello"}},
+		"spec": map[string]interface{}{
+			"containers": []interface{}{map[string]interface{}{"name": "hello", "image": "hello"}},
+		},
+	}
+	if tplAnnotations != nil {
+		tpl["metadata"].(map[string]interface{})["annotations"] = tplAnnotations
+	}
+	spec := map[string]interface{}{"replicas": int64(2), "template": tpl}
+	if triggers != nil {
+		ts := make([]interface{}, len(triggers))
+		for i, t := range triggers {
+			ts[i] = map[string]interface{}{"type": t}
+		}
+		spec["triggers"] = ts
+	}
+	return &kates.Unstructured{Object: map[string]interface{}{
+		"apiVersion": install.DeploymentConfigAPIVersion,
+		"kind":       "DeploymentConfig",
+		"metadata":   map[string]interface{}{"name": "hello", "namespace": "default", "generation": int64(3)},
+		"spec":       spec,
+	}}
+}
+
+func TestDeploymentConfigInjection(t *testing.T) {
+	dc := deploymentConfig(map[string]interface{}{install.InjectAnnotation: "disabled", "other": "x"})
+	orig := dc.DeepCopy()
+	values := map[string]string{install.InjectAnnotation: "enabled", install.ServicePortAnnotation: "http"}
+	modified, err := setTemplateAnnotations(dc, annDeploymentConfigInjection, values, nil)
+	require.NoError(t, err)
+	assert.True(t, modified)
+	ann, _, _ := unstructured.NestedStringMap(dc.Object, templateAnnotationsPath...)
+	assert.Equal(t, map[string]string{
+		install.InjectAnnotation:      "enabled",
+		install.ServicePortAnnotation: "http",
+		"other":                       "x",
+	}, ann)
+
+	// Enabling it again is a no-op
+	modified, err = setTemplateAnnotations(dc, annDeploymentConfigInjection, values, nil)
+	require.NoError(t, err)
+	assert.False(t, modified)
+
+	modified, err = restoreTemplateAnnotations(dc, annDeploymentConfigInjection)
+	require.NoError(t, err)
+	assert.True(t, modified)
+	assert.Equal(t, orig, dc)
+
+	modified, err = restoreTemplateAnnotations(dc, annDeploymentConfigInjection)
+	require.NoError(t, err)
+	assert.False(t, modified)
+}
+
+func TestDecideDeploymentConfigAgentWithoutConfigChange(t *testing.T) {
+	ctx := filelocation.WithAppUserConfigDir(dlog.NewTestContext(t, false), t.TempDir())
+	ctx = filelocation.WithAppSystemConfigDirs(ctx, nil)
+	client.ResetConfig(ctx)
+	defer client.ResetConfig(ctx)
+
+	dc := deploymentConfig(nil, "ImageChange")
+	ch := &agentChange{kind: "DeploymentConfig", orig: dc.DeepCopy(), obj: dc}
+	err := (&installer{}).decideDeploymentConfigAgent(ctx, ch, "", "")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "no ConfigChange trigger")
+	assert.Empty(t, dc.GetAnnotations(), "the DeploymentConfig isn't modified")
+}
+
+func TestDeploymentConfigRollsOutOnChange(t *testing.T) {
+	assert.True(t, deploymentConfigRollsOutOnChange(deploymentConfig(nil)))
+	assert.True(t, deploymentConfigRollsOutOnChange(deploymentConfig(nil, "ImageChange", "ConfigChange")))
+	assert.False(t, deploymentConfigRollsOutOnChange(deploymentConfig(nil, "ImageChange")))
+	assert.False(t, deploymentConfigRollsOutOnChange(deploymentConfig(nil, []string{}...)))
+}
+
+func TestDeploymentConfigUpdated(t *testing.T) {
+	withStatus := func(observedGeneration, replicas, updated, available int64) *kates.Unstructured {
+		dc := deploymentConfig(nil)
+		dc.Object["status"] = map[string]interface{}{
+			"observedGeneration": observedGeneration,
+			"replicas":           replicas,
+			"updatedReplicas":    updated,
+			"availableReplicas":  available,
+		}
+		return dc
+	}
+	assert.True(t, deploymentConfigUpdated(withStatus(3, 2, 2, 2), 3))
+	assert.False(t, deploymentConfigUpdated(withStatus(3, 2, 2, 2), 4), "the new generation isn't seen yet")
+	assert.False(t, deploymentConfigUpdated(withStatus(2, 2, 2, 2), 3), "the new generation isn't observed yet")
+	assert.False(t, deploymentConfigUpdated(withStatus(3, 3, 2, 3), 3), "old pods remain")
+	assert.False(t, deploymentConfigUpdated(withStatus(3, 2, 2, 1), 3), "new pods aren't available")
+	assert.False(t, deploymentConfigUpdated(withStatus(3, 1, 1, 1), 3), "not all replicas are updated")
+}
+
+func TestRedirectNumericPort(t *testing.T) {
+	defer func(v string) { version.Version = v }(version.Version)
+	version.Version = "v2.3.6"
+
+	cns := []corev1.Container{{Name: "hello", Ports: []corev1.ContainerPort{{ContainerPort: 8080}}}}
+	service := func(targetPort intstr.IntOrString) *kates.Service {
+		svc := &kates.Service{}
+		svc.Name = "hello"
+		svc.Spec.Ports = []kates.ServicePort{{Name: "http", Port: 80, TargetPort: targetPort}}
+		return svc
+	}
+
+	ch := &agentChange{kind: "Deployment", svc: service(intstr.FromInt(8080))}
+	require.NoError(t, redirectNumericPort(ch, cns, ""))
+	require.NotNil(t, ch.origSvc)
+	assert.Equal(t, intstr.FromInt(8080), ch.origSvc.Spec.Ports[0].TargetPort, "the original isn't modified")
+	assert.Equal(t, intstr.FromString("tx-8080"), ch.svc.Spec.Ports[0].TargetPort)
+	assert.Contains(t, ch.svc.Annotations, annTelepresenceActions)
+
+	// The redirect is undone on uninstall
+	require.NoError(t, undoServiceMods(dlog.NewTestContext(t, false), ch.svc))
+	assert.Equal(t, ch.origSvc.Spec, ch.svc.Spec)
+	assert.Empty(t, ch.svc.Annotations)
+
+	ch = &agentChange{kind: "Deployment", svc: service(intstr.FromString("http"))}
+	cns[0].Ports[0].Name = "http"
+	require.NoError(t, redirectNumericPort(ch, cns, ""))
+	assert.Nil(t, ch.origSvc, "a symbolic targetPort is taken over by the injector")
+}
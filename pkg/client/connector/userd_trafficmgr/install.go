@@ -7,6 +7,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/pkg/errors"
@@ -15,10 +16,12 @@ import (
 	"k8s.io/apimachinery/pkg/util/intstr"
 
 	"github.com/datawire/ambassador/pkg/kates"
+	"github.com/datawire/dlib/dcontext"
 	"github.com/datawire/dlib/dlog"
 	"github.com/datawire/dlib/dtime"
 	"github.com/telepresenceio/telepresence/rpc/v2/manager"
 	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/connector/userd_intercept"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/connector/userd_k8s"
 	"github.com/telepresenceio/telepresence/v2/pkg/install"
 	"github.com/telepresenceio/telepresence/v2/pkg/install/resource"
@@ -26,6 +29,9 @@ import (
 
 type installer struct {
 	*userd_k8s.Cluster
+
+	// installID identifies the client in the changes that it must undo, even after a crash
+	installID string
 }
 
 func newTrafficManagerInstaller(kc *userd_k8s.Cluster) (*installer, error) {
@@ -38,6 +44,32 @@ func managerImageName(ctx context.Context) string {
 	return fmt.Sprintf("%s/tel2:%s", client.GetConfig(ctx).Images.Registry, strings.TrimPrefix(client.Version(), "v"))
 }
 
+// maxParallelAgentChanges is the maximum number of workloads that agents are installed in, or
+// removed from, at the same time. Each change waits for the rollout of its workload, so doing them
+// in parallel saves a lot of time, but too many simultaneous rollouts can overwhelm a small
+// cluster.
+const maxParallelAgentChanges = 8
+
+// inParallel calls f with each index below n, making at most limit calls at the same time, and
+// returns when all calls have returned.
+func inParallel(n, limit int, f func(i int)) {
+	wg := sync.WaitGroup{}
+	wg.Add(n)
+	sem := make(chan struct{}, limit)
+	for i := 0; i < n; i++ {
+		i := i // pin it
+		go func() {
+			sem <- struct{}{}
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			f(i)
+		}()
+	}
+	wg.Wait()
+}
+
 // removeManager will remove the agent from all deployments listed in the given agents slice. Unless agentsOnly is true,
 // it will also remove the traffic-manager service and deployment.
 func (ki *installer) removeManagerAndAgents(c context.Context, agentsOnly bool, agents []*manager.AgentInfo, env *client.Env) error {
@@ -50,68 +82,115 @@ func (ki *installer) removeManagerAndAgents(c context.Context, agentsOnly bool,
 		errsLock.Unlock()
 	}
 
-	// Remove the agent from all deployments
-	wg := sync.WaitGroup{}
-	wg.Add(len(agents))
-	for _, ai := range agents {
-		ai := ai // pin it
-		go func() {
-			defer wg.Done()
-			kind, err := ki.FindObjectKind(c, ai.Namespace, ai.Name)
+	// Remove the agent from all deployments, and wait for all agents to be removed
+	done := int32(0)
+	inParallel(len(agents), maxParallelAgentChanges, func(i int) {
+		ai := agents[i]
+		defer func() {
+			dlog.Infof(c, "Agent removal done for %d of %d workloads", atomic.AddInt32(&done, 1), len(agents))
+		}()
+		kind, err := ki.FindObjectKind(c, ai.Namespace, ai.Name)
+		if err != nil {
+			addError(err)
+			return
+		}
+		var agent kates.Object
+		switch kind {
+		case "ReplicaSet":
+			agent, err = ki.FindReplicaSet(c, ai.Namespace, ai.Name)
 			if err != nil {
-				addError(err)
+				if !errors2.IsNotFound(err) {
+					addError(err)
+				}
 				return
 			}
-			var agent kates.Object
-			switch kind {
-			case "ReplicaSet":
-				agent, err = ki.FindReplicaSet(c, ai.Namespace, ai.Name)
-				if err != nil {
-					if !errors2.IsNotFound(err) {
-						addError(err)
-					}
-					return
-				}
-			case "Deployment":
-				agent, err = ki.FindDeployment(c, ai.Namespace, ai.Name)
-				if err != nil {
-					if !errors2.IsNotFound(err) {
-						addError(err)
-					}
-					return
+		case "Deployment":
+			agent, err = ki.FindDeployment(c, ai.Namespace, ai.Name)
+			if err != nil {
+				if !errors2.IsNotFound(err) {
+					addError(err)
 				}
-			case "StatefulSet":
-				agent, err = ki.FindStatefulSet(c, ai.Namespace, ai.Name)
-				if err != nil {
-					if !errors2.IsNotFound(err) {
-						addError(err)
-					}
-					return
+				return
+			}
+		case "StatefulSet":
+			agent, err = ki.FindStatefulSet(c, ai.Namespace, ai.Name)
+			if err != nil {
+				if !errors2.IsNotFound(err) {
+					addError(err)
 				}
-			default:
-				addError(fmt.Errorf("agent %q associated with unsupported workload kind %q, cannot be removed", ai.Name, kind))
 				return
 			}
-			// Assume that the agent was added using the mutating webhook when no actions
-			// annotation can be found in the workload.
-			ann := agent.GetAnnotations()
-			if ann == nil {
+		case "DeploymentConfig":
+			dc, err := ki.FindDeploymentConfig(c, ai.Namespace, ai.Name)
+			if err != nil {
+				if !errors2.IsNotFound(err) {
+					addError(err)
+				}
 				return
 			}
-			if _, ok := ann[annTelepresenceActions]; !ok {
+			removed, err := ki.removeTemplateInjection(c, dc)
+			if err != nil {
+				addError(err)
+			} else if removed {
+				if err = ki.waitForApply(c, ai.Namespace, ai.Name, dc); err != nil {
+					addError(err)
+				}
+			}
+			return
+		case "Rollout":
+			ro, err := ki.FindArgoRollout(c, ai.Namespace, ai.Name)
+			if err != nil {
+				if !errors2.IsNotFound(err) {
+					addError(err)
+				}
 				return
 			}
-			if err = ki.undoObjectMods(c, agent); err != nil {
+			removed, err := ki.removeTemplateInjection(c, ro)
+			if err != nil {
 				addError(err)
+			} else if removed {
+				if err = ki.waitForApply(c, ai.Namespace, ai.Name, ro); err != nil {
+					addError(err)
+				}
+			}
+			return
+		case "KnativeService":
+			ksvc, err := ki.FindKnativeService(c, ai.Namespace, ai.Name)
+			if err != nil {
+				if !errors2.IsNotFound(err) {
+					addError(err)
+				}
 				return
 			}
-			if err = ki.waitForApply(c, ai.Namespace, ai.Name, agent); err != nil {
+			removed, err := ki.removeKnativeInjection(c, ksvc)
+			if err != nil {
 				addError(err)
+			} else if removed {
+				if err = ki.waitForApply(c, ai.Namespace, ai.Name, ksvc); err != nil {
+					addError(err)
+				}
 			}
-		}()
-	}
-	// wait for all agents to be removed
-	wg.Wait()
+			return
+		default:
+			addError(fmt.Errorf("agent %q associated with unsupported workload kind %q, cannot be removed", ai.Name, kind))
+			return
+		}
+		// Assume that the agent was added using the mutating webhook when no actions
+		// annotation can be found in the workload.
+		if _, ok := agent.GetAnnotations()[annTelepresenceActions]; !ok {
+			if err = ki.undoInjectedServiceMods(c, agent); err != nil {
+				addError(err)
+			}
+			return
+		}
+		if err = ki.undoObjectMods(c, agent); err != nil {
+			addError(err)
+			return
+		}
+		if err = ki.waitForApply(c, ai.Namespace, ai.Name, agent); err != nil {
+			addError(err)
+		}
+	})
 
 	if !agentsOnly && len(errs) == 0 {
 		// agent removal succeeded. Remove the manager resources
@@ -135,6 +214,35 @@ func (ki *installer) removeManagerAndAgents(c context.Context, agentsOnly bool,
 	return nil
 }
 
+// findWorkload returns the workload with the given name and its kind.
+func (ki *installer) findWorkload(c context.Context, namespace, name string) (kates.Object, string, error) {
+	kind, err := ki.FindObjectKind(c, namespace, name)
+	if err != nil {
+		return nil, "", err
+	}
+	var obj kates.Object
+	switch kind {
+	case "ReplicaSet":
+		obj, err = ki.FindReplicaSet(c, namespace, name)
+	case "Deployment":
+		obj, err = ki.FindDeployment(c, namespace, name)
+	case "StatefulSet":
+		obj, err = ki.FindStatefulSet(c, namespace, name)
+	case "DeploymentConfig":
+		obj, err = ki.FindDeploymentConfig(c, namespace, name)
+	case "Rollout":
+		obj, err = ki.FindArgoRollout(c, namespace, name)
+	case "KnativeService":
+		obj, err = ki.FindKnativeService(c, namespace, name)
+	default:
+		return nil, "", fmt.Errorf("unsupported workload kind %q, cannot ensure agent", kind)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return obj, kind, nil
+}
+
 // Finds the Referenced Service in an objects' annotations
 func (ki *installer) getSvcFromObjAnnotation(c context.Context, obj kates.Object) (*kates.Service, error) {
 	var actions workloadActions
@@ -195,49 +303,86 @@ func checkSvcSame(c context.Context, obj kates.Object, svcName, portNameOrNumber
 
 var agentNotFound = errors.New("no such agent")
 
-// This does a lot of things but at a high level it ensures that the traffic agent
-// is installed alongside the proper workload. In doing that, it also ensures that
-// the workload is referenced by a service. Lastly, it returns the service UID
-// associated with the workload since this is where that correlation is made.
-func (ki *installer) ensureAgent(c context.Context, namespace, name, svcName, portNameOrNumber, agentImageName string) (string, string, error) {
-	kind, err := ki.FindObjectKind(c, namespace, name)
+// agentChange is how ensureAgent installs the traffic-agent in a workload. It's decided by
+// decideAgent, so that planAgent describes exactly what ensureAgent does.
+type agentChange struct {
+	kind string
+
+	// agent is what's done to the traffic-agent, one of the userd_intercept.Agent constants
+	agent string
+
+	// orig is the workload as it was found, and obj is the workload as it will be.
+	orig, obj kates.Object
+
+	// svc is the service that's modified along with the workload, and origSvc is that service as it
+	// was found. Both are nil when the service isn't modified. When the traffic-agent is injected,
+	// svc is the service of the intercept, and origSvc is nil unless the service refers to the app
+	// by port number, see redirectNumericPort.
+	origSvc, svc *kates.Service
+
+	// upgrade is the action of the traffic-agent, with the new image, when the agent is upgraded
+	upgrade *workloadActions
+
+	// oldImage and newImage are the images that the traffic-agent is upgraded from and to
+	oldImage, newImage string
+}
+
+// modified returns true if the change modifies the workload.
+func (ch *agentChange) modified() bool {
+	switch ch.agent {
+	case userd_intercept.AgentAdd, userd_intercept.AgentUpgrade, userd_intercept.AgentEnableInjection:
+		return true
+	default:
+		return false
+	}
+}
+
+// decideAgent finds the given workload and decides how the traffic-agent is installed in it. The
+// workload and its service are modified on copies, so nothing is changed in the cluster.
+func (ki *installer) decideAgent(c context.Context, namespace, name, svcName, portNameOrNumber, agentImageName string) (*agentChange, error) {
+	obj, kind, err := ki.findWorkload(c, namespace, name)
 	if err != nil {
-		return "", "", err
+		return nil, err
 	}
-	var obj kates.Object
-	switch kind {
-	case "ReplicaSet":
-		obj, err = ki.FindReplicaSet(c, namespace, name)
-		if err != nil {
-			return "", "", err
-		}
-	case "Deployment":
-		obj, err = ki.FindDeployment(c, namespace, name)
-		if err != nil {
-			return "", "", err
-		}
-	case "StatefulSet":
-		obj, err = ki.FindStatefulSet(c, namespace, name)
-		if err != nil {
-			return "", "", err
+	if install.InterceptsForbidden(obj) {
+		return nil, install.InterceptsForbiddenError(obj)
+	}
+	ch := &agentChange{kind: kind, orig: obj, obj: obj.DeepCopyObject().(kates.Object)}
+	obj = ch.obj
+	if kind == "KnativeService" {
+		if err := decideKnativeAgent(c, ch); err != nil {
+			return nil, err
 		}
-	default:
-		return "", "", fmt.Errorf("unsupported workload kind %q, cannot ensure agent", kind)
+		return ch, nil
 	}
 
 	podTemplate, err := install.GetPodTemplateFromObject(obj)
 	if err != nil {
-		return "", "", err
+		return nil, err
+	}
+	patchMode := client.GetConfig(c).Cluster.AgentInjection == client.AgentInjectionPatch
+	if (kind == "DeploymentConfig" || kind == "Rollout") && (patchMode || podTemplate.ObjectMeta.Annotations[install.InjectAnnotation] != "enabled") {
+		// The agent can't be added to these unstructured workloads, so its injection is enabled
+		decide := ki.decideDeploymentConfigAgent
+		if kind == "Rollout" {
+			decide = ki.decideArgoRolloutAgent
+		}
+		if err := decide(c, ch, svcName, portNameOrNumber); err != nil {
+			return nil, err
+		}
+		return ch, nil
 	}
 
-	var svc *kates.Service
-	if a := podTemplate.ObjectMeta.Annotations; a != nil && a[install.InjectAnnotation] == "enabled" {
+	if a := podTemplate.ObjectMeta.Annotations; !patchMode && a != nil && a[install.InjectAnnotation] == "enabled" {
 		// agent is injected using a mutating webhook. Get its service and skip the rest
-		svc, err = install.FindMatchingService(c, ki.Client(), portNameOrNumber, svcName, namespace, podTemplate.Labels)
-		if err != nil {
-			return "", "", err
+		if ch.svc, err = install.FindMatchingService(c, ki.Client(), portNameOrNumber, svcName, namespace, podTemplate.Labels); err != nil {
+			return nil, err
 		}
-		return string(svc.GetUID()), kind, nil
+		if err = redirectNumericPort(ch, podTemplate.Spec.Containers, portNameOrNumber); err != nil {
+			return nil, err
+		}
+		ch.agent = userd_intercept.AgentInjected
+		return ch, nil
 	}
 
 	var agentContainer *kates.Container
@@ -255,49 +400,161 @@ func (ki *installer) ensureAgent(c context.Context, namespace, name, svcName, po
 configuration. To intercept this with your new configuration, please use
 telepresence uninstall --agent %s This will cancel any intercepts that
 already exist for this service`, kind, obj.GetName())
-		return "", "", errors.Wrap(err, msg)
+		return nil, errors.Wrap(err, msg)
 	}
 
+	// The image of the agent depends on the architectures of the nodes that the pods can run on
+	variants, nodes := ki.agentPlatform(c)
+	agentImageName = install.AgentImageForPod(&podTemplate.Spec, agentImageName, variants, nodes)
+
 	switch {
 	case agentContainer == nil:
-		dlog.Infof(c, "no agent found for %s %s.%s", kind, name, namespace)
-		dlog.Infof(c, "Using port name or number %q", portNameOrNumber)
+		if err = install.CheckAgentPlatform(&podTemplate.Spec, install.AgentImageArchitectures(variants), nodes); err != nil {
+			return nil, install.ObjErrorf(obj, err.Error())
+		}
 		matchingSvc, err := install.FindMatchingService(c, ki.Client(), portNameOrNumber, svcName, namespace, podTemplate.Labels)
 		if err != nil {
-			return "", "", err
+			return nil, err
 		}
-		obj, svc, err = addAgentToWorkload(c, portNameOrNumber, agentImageName, ki.GetManagerNamespace(), obj, matchingSvc)
-		if err != nil {
-			return "", "", err
+		origSvc := matchingSvc.DeepCopy()
+		if obj, ch.svc, err = addAgentToWorkload(c, portNameOrNumber, agentImageName, ki.GetManagerNamespace(), obj, matchingSvc); err != nil {
+			return nil, err
+		}
+		if ch.svc != nil {
+			ch.origSvc = origSvc
 		}
+		ch.obj = obj
+		if podTemplate, err = install.GetPodTemplateFromObject(obj); err != nil {
+			return nil, err
+		}
+		if err = ki.adaptAgentToPodNetwork(c, obj.GetName(), podTemplate); err != nil {
+			return nil, install.ObjErrorf(obj, "unable to intercept: %v", err)
+		}
+		if ki.IsOpenShift(c) {
+			// The restricted SCCs will reject the pods unless the agent has a compatible security context
+			install.SetAgentSecurityContext(podTemplate)
+		}
+		ch.agent = userd_intercept.AgentAdd
 	case agentContainer.Image != agentImageName:
 		var actions workloadActions
 		ok, err := getAnnotation(obj, &actions)
 		if err != nil {
-			return "", "", err
+			return nil, err
 		} else if !ok {
 			// This can only happen if someone manually tampered with the annTelepresenceActions annotation
-			return "", "", install.ObjErrorf(obj, "annotations[%q]: annotation is not set", annTelepresenceActions)
+			return nil, install.ObjErrorf(obj, "annotations[%q]: annotation is not set", annTelepresenceActions)
 		}
-
-		dlog.Debugf(c, "Updating agent for %s %s.%s", kind, name, namespace)
-		aaa := &workloadActions{
+		ch.upgrade = &workloadActions{
 			Version:         actions.Version,
 			AddTrafficAgent: actions.AddTrafficAgent,
 		}
-		explainUndo(c, aaa, obj)
-		aaa.AddTrafficAgent.ImageName = agentImageName
+		ch.oldImage, ch.newImage = agentContainer.Image, agentImageName
 		agentContainer.Image = agentImageName
-		explainDo(c, aaa, obj)
+		ch.agent = userd_intercept.AgentUpgrade
+	default:
+		ch.agent = userd_intercept.AgentUpToDate
+	}
+	return ch, nil
+}
+
+// agentPlatform returns the images of the traffic-agent by architecture that the traffic-manager
+// is configured with, and the nodes of the cluster. Either is nil when it can't be read, e.g.
+// because the user isn't allowed to.
+func (ki *installer) agentPlatform(c context.Context) (map[string]string, []corev1.Node) {
+	var variants map[string]string
+	dep := &kates.Deployment{
+		TypeMeta:   kates.TypeMeta{Kind: "Deployment"},
+		ObjectMeta: kates.ObjectMeta{Name: install.ManagerAppName, Namespace: ki.GetManagerNamespace()},
+	}
+	if err := ki.Client().Get(c, dep, dep); err != nil {
+		dlog.Debugf(c, "unable to get the %s deployment: %v", install.ManagerAppName, err)
+	} else {
+		for i := range dep.Spec.Template.Spec.Containers {
+			if variants, err = managerImageVariants(&dep.Spec.Template.Spec.Containers[i]); err != nil {
+				dlog.Errorf(c, "ignoring the agent image variants of the %s: %v", install.ManagerAppName, err)
+			}
+			if variants != nil {
+				break
+			}
+		}
+	}
+	var nodes []corev1.Node
+	if err := ki.Client().List(c, kates.Query{Kind: "Node"}, &nodes); err != nil {
+		dlog.Debugf(c, "unable to list the nodes: %v", err)
+		nodes = nil
+	}
+	return variants, nodes
+}
+
+// managerImageVariants returns the agent image variants from the environment of the given
+// traffic-manager container, prefixed with its registry just like the traffic-manager does.
+func managerImageVariants(cn *corev1.Container) (map[string]string, error) {
+	registry := "docker.io/datawire"
+	var variants []string
+	for _, e := range cn.Env {
+		switch e.Name {
+		case "TELEPRESENCE_REGISTRY":
+			registry = e.Value
+		case install.AgentImageVariantsEnv:
+			if e.Value != "" {
+				variants = strings.Split(e.Value, ",")
+			}
+		}
+	}
+	return install.ParseAgentImageVariants(registry, variants)
+}
+
+// This does a lot of things but at a high level it ensures that the traffic agent
+// is installed alongside the proper workload. In doing that, it also ensures that
+// the workload is referenced by a service. Lastly, it returns the service UID
+// associated with the workload since this is where that correlation is made.
+func (ki *installer) ensureAgent(c context.Context, namespace, name, svcName, portNameOrNumber, agentImageName string) (string, string, error) {
+	ch, err := ki.decideAgent(c, namespace, name, svcName, portNameOrNumber, agentImageName)
+	if err != nil {
+		return "", "", err
+	}
+	kind, obj := ch.kind, ch.obj
+	if kind == "KnativeService" {
+		svcUID, err := ki.ensureKnativeAgent(c, ch)
+		return svcUID, kind, err
+	}
+	if (kind == "DeploymentConfig" || kind == "Rollout") && ch.agent == userd_intercept.AgentEnableInjection {
+		svcUID, err := ki.ensureTemplateInjection(c, ch)
+		return svcUID, kind, err
+	}
+
+	switch ch.agent {
+	case userd_intercept.AgentInjected:
+		if err := ki.updateRedirectedService(c, ch); err != nil {
+			return "", "", err
+		}
+		return string(ch.svc.GetUID()), kind, nil
+	case userd_intercept.AgentAdd:
+		dlog.Infof(c, "no agent found for %s %s.%s", kind, name, namespace)
+		dlog.Infof(c, "Using port name or number %q", portNameOrNumber)
+	case userd_intercept.AgentUpgrade:
+		dlog.Debugf(c, "Updating agent for %s %s.%s", kind, name, namespace)
+		explainUndo(c, ch.upgrade, ch.orig)
+		ch.upgrade.AddTrafficAgent.ImageName = ch.newImage
+		explainDo(c, ch.upgrade, obj)
 	default:
 		dlog.Debugf(c, "%s %s.%s already has an installed and up-to-date agent", kind, name, namespace)
 	}
 
-	if err := ki.Client().Update(c, obj, obj); err != nil {
+	modified := ch.modified()
+	if modified {
+		restore, err := ki.prepareRollout(c, kind, obj)
+		if err != nil {
+			return "", "", err
+		}
+		defer restore()
+	}
+	if err := ki.updateObject(c, ch.orig, obj); err != nil {
 		return "", "", err
 	}
+	svc := ch.svc
 	if svc != nil {
-		if err := ki.Client().Update(c, svc, svc); err != nil {
+		if err := ki.updateObject(c, ch.origSvc, svc); err != nil {
 			return "", "", err
 		}
 	} else {
@@ -309,12 +566,64 @@ already exist for this service`, kind, obj.GetName())
 		}
 	}
 
-	if err := ki.waitForApply(c, namespace, name, obj); err != nil {
-		return "", "", err
+	if !modified {
+		if err := ki.waitForApply(c, namespace, name, obj); err != nil {
+			return "", "", err
+		}
+		return string(svc.GetUID()), kind, nil
+	}
+	if err := ki.waitForAgentRollout(c, namespace, name, obj); err != nil {
+		// Don't leave the workload stuck in a rollout that won't complete
+		if ch.agent == userd_intercept.AgentUpgrade {
+			dlog.Errorf(c, "Rollout of %s %s.%s failed, reverting the %s to %s: %v", kind, name, namespace, install.AgentContainerName, ch.oldImage, err)
+			if uerr := ki.revertAgentUpgrade(dcontext.WithoutCancel(c), ch); uerr != nil {
+				dlog.Errorf(c, "unable to revert the %s of %s %s.%s to %s: %v", install.AgentContainerName, kind, name, namespace, ch.oldImage, uerr)
+			}
+			return "", "", fmt.Errorf("rollout of %s %s.%s with the %s %s failed, the %s was reverted to %s: %w",
+				kind, name, namespace, install.AgentContainerName, ch.newImage, install.AgentContainerName, ch.oldImage, err)
+		}
+		dlog.Errorf(c, "Rollout of %s %s.%s failed, removing the %s: %v", kind, name, namespace, install.AgentContainerName, err)
+		if uerr := ki.undoObjectMods(dcontext.WithoutCancel(c), obj); uerr != nil {
+			dlog.Errorf(c, "unable to remove the %s from %s %s.%s: %v", install.AgentContainerName, kind, name, namespace, uerr)
+		}
+		return "", "", fmt.Errorf("rollout of %s %s.%s with the %s failed, the %s was removed again: %w",
+			kind, name, namespace, install.AgentContainerName, install.AgentContainerName, err)
 	}
 	return string(svc.GetUID()), kind, nil
 }
 
+// adaptAgentToPodNetwork adapts the traffic-agent container of the given pod template to the
+// network of its pods, see install.AdaptAgentToPodNetwork.
+func (ki *installer) adaptAgentToPodNetwork(c context.Context, name string, podTemplate *kates.PodTemplateSpec) error {
+	spec := &podTemplate.Spec
+	var agent *kates.Container
+	for i := range spec.Containers {
+		if spec.Containers[i].Name == install.AgentContainerName {
+			agent = &spec.Containers[i]
+			break
+		}
+	}
+	if agent == nil {
+		return nil
+	}
+	managerIP := ""
+	if !install.ResolvesClusterNames(spec) {
+		if svc, err := ki.FindSvc(c, ki.GetManagerNamespace(), install.ManagerAppName); err != nil {
+			dlog.Errorf(c, "unable to get the %s service: %v", install.ManagerAppName, err)
+		} else if svc.Spec.ClusterIP != "None" {
+			managerIP = svc.Spec.ClusterIP
+		}
+	}
+	if spec.HostNetwork {
+		dlog.Infof(c, "Pods of %s use the network of their node", name)
+	}
+	if networks, ok := podTemplate.Annotations[install.MultusNetworksAnnotation]; ok {
+		dlog.Infof(c, "Pods of %s have the additional networks %q; only the traffic that arrives on the cluster network can be intercepted",
+			name, networks)
+	}
+	return install.AdaptAgentToPodNetwork(agent, name, podTemplate.Annotations, spec, managerIP)
+}
+
 // The following <workload>Updated functions all contain the logic for
 // determining if that specific workload type has successfully been updated
 // based on the object's metadata. We have separate ones for each object
@@ -415,6 +724,61 @@ func (ki *installer) waitForApply(c context.Context, namespace, name string, obj
 				return nil
 			}
 		}
+	case "DeploymentConfig":
+		for {
+			dtime.SleepWithContext(c, time.Second)
+			if err := c.Err(); err != nil {
+				return err
+			}
+
+			dc, err := ki.FindDeploymentConfig(c, namespace, name)
+			if err != nil {
+				return client.CheckTimeout(c, err)
+			}
+
+			if deploymentConfigUpdated(dc, origGeneration) {
+				dlog.Debugf(c, "DeploymentConfig %s.%s successfully applied", name, namespace)
+				return nil
+			}
+		}
+	case "Rollout":
+		for {
+			dtime.SleepWithContext(c, time.Second)
+			if err := c.Err(); err != nil {
+				return err
+			}
+
+			ro, err := ki.FindArgoRollout(c, namespace, name)
+			if err != nil {
+				return client.CheckTimeout(c, err)
+			}
+
+			if argoRolloutUpdated(ro, origGeneration) {
+				dlog.Debugf(c, "Rollout %s.%s successfully applied", name, namespace)
+				return nil
+			}
+		}
+	case "KnativeService":
+		for {
+			dtime.SleepWithContext(c, time.Second)
+			if err := c.Err(); err != nil {
+				return err
+			}
+
+			ksvc, err := ki.FindKnativeService(c, namespace, name)
+			if err != nil {
+				return client.CheckTimeout(c, err)
+			}
+
+			ready, err := knativeServiceReady(ksvc)
+			if err != nil {
+				return err
+			}
+			if ready {
+				dlog.Debugf(c, "Knative Service %s.%s successfully applied", name, namespace)
+				return nil
+			}
+		}
 
 	default:
 		return fmt.Errorf("unsupported workload kind %q, cannot wait for apply", kind)
@@ -498,6 +862,7 @@ func getAnnotation(obj kates.Object, data completeAction) (bool, error) {
 }
 
 func (ki *installer) undoObjectMods(c context.Context, obj kates.Object) error {
+	orig := obj.DeepCopyObject().(kates.Object)
 	referencedService, err := undoObjectMods(c, obj)
 	if err != nil {
 		return err
@@ -511,7 +876,7 @@ func (ki *installer) undoObjectMods(c context.Context, obj kates.Object) error {
 			return err
 		}
 	}
-	return ki.Client().Update(c, obj, obj)
+	return ki.updateObject(c, orig, obj)
 }
 
 func undoObjectMods(c context.Context, obj kates.Object) (string, error) {
@@ -537,10 +902,11 @@ func undoObjectMods(c context.Context, obj kates.Object) (string, error) {
 }
 
 func (ki *installer) undoServiceMods(c context.Context, svc *kates.Service) error {
+	orig := svc.DeepCopy()
 	if err := undoServiceMods(c, svc); err != nil {
 		return err
 	}
-	return ki.Client().Update(c, svc, svc)
+	return ki.updateObject(c, orig, svc)
 }
 
 func undoServiceMods(c context.Context, svc *kates.Service) error {
@@ -644,7 +1010,7 @@ func addAgentToWorkload(
 		return nil, nil, install.ObjErrorf(object, "unable to add: the container port cannot be determined")
 	}
 	if containerPort.Name == "" {
-		containerPort.Name = fmt.Sprintf("tx-%d", containerPort.Number)
+		containerPort.Name = install.NumericPortName(int32(containerPort.Number))
 	}
 
 	// Figure what modifications we need to make.
@@ -655,6 +1021,7 @@ func addAgentToWorkload(
 		ReferencedServicePortName: servicePort.Name,
 		AddTrafficAgent: &addTrafficAgentAction{
 			containerName:           container.Name,
+			servicePort:             servicePort,
 			trafficManagerNamespace: trafficManagerNamespace,
 			ContainerPortName:       containerPort.Name,
 			ContainerPortProto:      containerPort.Protocol,
@@ -335,6 +335,9 @@ type addTrafficAgentAction struct {
 	// The name of the app container. Not exported because its not needed for undo.
 	containerName string
 
+	// The service port that the agent will serve. Not exported because its not needed for undo.
+	servicePort *corev1.ServicePort
+
 	// The name of the namespace where the traffic manager that "owns" this agent is to be found.
 	trafficManagerNamespace string
 }
@@ -367,19 +370,28 @@ func (ata *addTrafficAgentAction) Do(obj kates.Object) error {
 	// We ignore the error from this since we don't care if the volume isn't already present
 	_ = ata.dropAgentAnnotationVolume(obj, tplSpec)
 
+	overrides, err := install.GetAgentOverrides(tplSpec.Annotations)
+	if err != nil {
+		return install.ObjErrorf(obj, "%v", err)
+	}
+	agentContainer := install.AgentContainer(
+		obj.GetName(),
+		ata.ImageName,
+		overrides.AppContainer(appContainer),
+		corev1.ContainerPort{
+			Name:          ata.ContainerPortName,
+			Protocol:      ata.ContainerPortProto,
+			ContainerPort: install.DefaultAgentPort,
+		},
+		int(ata.ContainerPortNumber),
+		ata.trafficManagerNamespace)
+	if ata.servicePort != nil {
+		install.SetAgentAppProtocol(&agentContainer, ata.servicePort)
+	}
+	overrides.Apply(&agentContainer)
+
 	tplSpec.Spec.Volumes = append(tplSpec.Spec.Volumes, install.AgentVolume())
-	tplSpec.Spec.Containers = append(tplSpec.Spec.Containers,
-		install.AgentContainer(
-			obj.GetName(),
-			ata.ImageName,
-			appContainer,
-			corev1.ContainerPort{
-				Name:          ata.ContainerPortName,
-				Protocol:      ata.ContainerPortProto,
-				ContainerPort: 9900,
-			},
-			int(ata.ContainerPortNumber),
-			ata.trafficManagerNamespace))
+	tplSpec.Spec.Containers = append(tplSpec.Spec.Containers, agentContainer)
 	return nil
 }
 
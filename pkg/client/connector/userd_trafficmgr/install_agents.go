@@ -0,0 +1,43 @@
+package userd_trafficmgr
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/datawire/dlib/dlog"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/connector"
+)
+
+// InstallAgents installs the traffic-agent in the given workloads, the way an intercept of each
+// workload would, and waits until the agents have arrived. The workloads are changed, and their
+// rollouts awaited, in parallel. The given progress function is called, one call at a time, as
+// each install completes.
+func (tm *trafficManager) InstallAgents(c context.Context, namespace string, names []string, agentImageName string, progress func(*rpc.AgentInstallProgress)) error {
+	ns := tm.ActualNamespace(namespace)
+	if ns == "" {
+		return fmt.Errorf("namespace %q doesn't exist or isn't mapped", namespace)
+	}
+	var mu sync.Mutex
+	done := 0
+	inParallel(len(names), maxParallelAgentChanges, func(i int) {
+		p := &rpc.AgentInstallProgress{Name: names[i], Namespace: ns, Total: int32(len(names))}
+		_, kind, err := tm.ensureAgent(c, ns, p.Name, "", "", agentImageName)
+		if err == nil {
+			p.WorkloadKind = kind
+			_, err = tm.waitForAgent(c, p.Name, ns)
+		}
+		if err != nil {
+			dlog.Errorf(c, "unable to install the agent in %s.%s: %v", p.Name, ns, err)
+			p.ErrorText = err.Error()
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		done++
+		p.Done = int32(done)
+		dlog.Infof(c, "Agent install done for %d of %d workloads", done, len(names))
+		progress(p)
+	})
+	return nil
+}
@@ -0,0 +1,35 @@
+package userd_trafficmgr
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInParallel(t *testing.T) {
+	var mu sync.Mutex
+	running, maxRunning := 0, 0
+	called := make([]bool, 20)
+	inParallel(len(called), 3, func(i int) {
+		mu.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		called[i] = true
+		mu.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+
+		mu.Lock()
+		running--
+		mu.Unlock()
+	})
+	assert.LessOrEqual(t, maxRunning, 3)
+	assert.Greater(t, maxRunning, 1, "the calls weren't made in parallel")
+	for i, c := range called {
+		assert.True(t, c, i)
+	}
+}
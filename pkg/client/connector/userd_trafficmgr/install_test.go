@@ -8,6 +8,7 @@ import (
 	"os"
 	"path/filepath"
 	"reflect"
+	"runtime"
 	"strings"
 	"testing"
 	"text/template"
@@ -15,7 +16,7 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/runtime"
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/client-go/tools/clientcmd/api"
 	"sigs.k8s.io/yaml"
@@ -42,6 +43,7 @@ func publishManager(t *testing.T) {
 	cmd.Env = []string{
 		"TELEPRESENCE_VERSION=" + version.Version,
 		"TELEPRESENCE_REGISTRY=" + dtest.DockerRegistry(ctx),
+		"TELEPRESENCE_PLATFORMS=linux/" + runtime.GOARCH,
 	}
 	includeEnv := []string{"KO_DOCKER_REPO=", "HOME=", "PATH=", "LOGNAME=", "TMPDIR=", "MAKELEVEL="}
 	for _, env := range os.Environ() {
@@ -188,7 +190,7 @@ func TestE2E(t *testing.T) {
 			if cluster == nil {
 				t.Fatal("Unable to get cluster from config")
 			}
-			cluster.Extensions = map[string]runtime.Object{"telepresence.io": &runtime.Unknown{
+			cluster.Extensions = map[string]k8sruntime.Object{"telepresence.io": &k8sruntime.Unknown{
 				Raw: []byte(fmt.Sprintf(`{"manager":{"namespace": "%s"}}`, customNamespace)),
 			}}
 			err = clientcmd.WriteToFile(*cfg, kubeconfig)
@@ -333,10 +335,6 @@ func TestAddAgentToWorkload(t *testing.T) {
 
 	// Part 2: Run the testcases in "install" mode /////////////////////////
 	ctx := dlog.NewTestContext(t, true)
-	env, err := client.LoadEnv(ctx)
-	if err != nil {
-		t.Fatal(err)
-	}
 
 	// We use the MachineLock here since we have to reset + set the config.yml
 	dtest.WithMachineLock(ctx, func(ctx context.Context) {
@@ -366,7 +364,7 @@ func TestAddAgentToWorkload(t *testing.T) {
 				actualWrk, actualSvc, actualErr := addAgentToWorkload(ctx,
 					tc.InputPortName,
 					managerImageName(ctx), // ignore extensions
-					env.ManagerNamespace,
+					client.DefaultManagerNamespace,
 					deepCopyObject(tc.InputWorkload),
 					tc.InputService.DeepCopy(),
 				)
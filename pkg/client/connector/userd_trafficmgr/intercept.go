@@ -2,15 +2,21 @@ package userd_trafficmgr
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
 	"os"
+	"strings"
 	"sync"
 	"time"
 
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/proto"
+	errors2 "k8s.io/apimachinery/pkg/api/errors"
 
-	"github.com/datawire/dlib/dexec"
+	"github.com/datawire/ambassador/pkg/kates"
+	"github.com/datawire/dlib/dcontext"
 	"github.com/datawire/dlib/dgroup"
 	"github.com/datawire/dlib/dlog"
 	"github.com/datawire/dlib/dtime"
@@ -18,8 +24,10 @@ import (
 	"github.com/telepresenceio/telepresence/rpc/v2/manager"
 	"github.com/telepresenceio/telepresence/v2/cmd/traffic/cmd/manager/managerutil"
 	"github.com/telepresenceio/telepresence/v2/pkg/client"
-	"github.com/telepresenceio/telepresence/v2/pkg/dpipe"
-	"github.com/telepresenceio/telepresence/v2/pkg/forwarder"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/cache"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/connector/userd_intercept"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/metrics"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/mount"
 	"github.com/telepresenceio/telepresence/v2/pkg/install"
 )
 
@@ -107,7 +115,14 @@ func (tm *trafficManager) reconcileMountPoints(ctx context.Context, existingInte
 	for _, key := range mountsToDelete {
 		if _, loaded := tm.mountPoints.LoadAndDelete(key); loaded {
 			mountPoint := key.(string)
-			if err := os.Remove(mountPoint); err != nil {
+			tm.mountHealth.Delete(mountPoint)
+			var err error
+			if v, ok := tm.mirrors.LoadAndDelete(mountPoint); ok {
+				err = v.(*mount.Mirror).Close()
+			} else {
+				err = os.Remove(mountPoint)
+			}
+			if err != nil {
 				dlog.Errorf(ctx, "Failed to remove mount point %q: %v", mountPoint, err)
 			}
 			dlog.Infof(ctx, "Removed file system mount %q", mountPoint)
@@ -147,6 +162,9 @@ func (tm *trafficManager) workerPortForwardIntercepts(ctx context.Context) error
 				intercepts = snapshot.Intercepts
 			}
 			tm.setCurrentIntercepts(intercepts)
+			if ctx.Err() == nil {
+				tm.saveSessionState(ctx, false)
+			}
 
 			// allNames contains the names of all intercepts, irrespective of their status
 			allNames := make(map[string]struct{})
@@ -190,7 +208,9 @@ func (tm *trafficManager) workerPortForwardIntercepts(ctx context.Context) error
 
 		if ctx.Err() == nil {
 			dlog.Errorf(ctx, "reading port-forwards from manager: %v", err)
-			dtime.SleepWithContext(ctx, backoff)
+			metrics.Reconnects.WithLabelValues("watch-intercepts").Inc()
+			dtime.SleepWithContext(ctx, client.Jitter(backoff))
+			_ = tm.breaker.Wait(ctx)
 			backoff *= 2
 			if backoff > 3*time.Second {
 				backoff = 3 * time.Second
@@ -200,9 +220,9 @@ func (tm *trafficManager) workerPortForwardIntercepts(ctx context.Context) error
 	return nil
 }
 
-// getCurrentIntercepts returns a copy of the current intercept snapshot amended with
+// CurrentIntercepts returns a copy of the current intercept snapshot amended with
 // the local filesystem mount point.
-func (tm *trafficManager) getCurrentIntercepts() []*manager.InterceptInfo {
+func (tm *trafficManager) CurrentIntercepts() []*manager.InterceptInfo {
 	// Copy the current snapshot
 	tm.currentInterceptsLock.Lock()
 	intercepts := make([]*manager.InterceptInfo, len(tm.currentIntercepts))
@@ -229,6 +249,14 @@ func (tm *trafficManager) setCurrentIntercepts(intercepts []*manager.InterceptIn
 	tm.currentInterceptsLock.Lock()
 	tm.currentIntercepts = intercepts
 	tm.currentInterceptsLock.Unlock()
+
+	active := 0
+	for _, intercept := range intercepts {
+		if intercept.Disposition == manager.InterceptDispositionType_ACTIVE {
+			active++
+		}
+	}
+	metrics.ActiveIntercepts.Set(float64(active))
 }
 
 // AddIntercept adds one intercept
@@ -251,7 +279,7 @@ func (tm *trafficManager) AddIntercept(c context.Context, ir *rpc.CreateIntercep
 	}
 
 	<-tm.startup
-	for _, iCept := range tm.getCurrentIntercepts() {
+	for _, iCept := range tm.CurrentIntercepts() {
 		if iCept.Spec.Name == spec.Name {
 			return &rpc.InterceptResult{
 				Error:     rpc.InterceptError_ALREADY_EXISTS,
@@ -314,8 +342,15 @@ func (tm *trafficManager) AddIntercept(c context.Context, ir *rpc.CreateIntercep
 	dlog.Debugf(c, "creating intercept %s", spec.Name)
 	tos := &client.GetConfig(c).Timeouts
 	dlog.Infof(c, "Here is the config: %#v", client.GetConfig(c))
-	c, cancel := tos.TimeoutContext(c, client.TimeoutIntercept)
-	defer cancel()
+
+	// A queued intercept waits for as long as it takes for the workload to be released, so the
+	// timeout only applies once the traffic-manager has created it.
+	queued := ir.Queue
+	cancel := func() {}
+	if !queued {
+		c, cancel = tos.TimeoutContext(c, client.TimeoutIntercept)
+	}
+	defer func() { cancel() }()
 	<-tm.startup
 
 	// The agent is in place and the traffic-manager has acknowledged the creation of the intercept. It
@@ -324,17 +359,51 @@ func (tm *trafficManager) AddIntercept(c context.Context, ir *rpc.CreateIntercep
 	tm.activeInterceptsWaiters.Store(spec.Name, waitCh)
 	defer tm.activeInterceptsWaiters.Delete(spec.Name)
 
-	ii, err := tm.managerClient.CreateIntercept(c, &manager.CreateInterceptRequest{
+	// The traffic-manager uses the identity of the user when authorizing the intercept
+	mc := tm.withIdentity(c)
+	resume := func(context.Context) error { return nil }
+	if queued {
+		// Other operations, such as the removal of the intercept that holds the workload, must be
+		// able to run while this one waits.
+		resume = userd_intercept.Yield(c)
+	}
+	ii, err := tm.managerClient.CreateIntercept(mc, &manager.CreateInterceptRequest{
 		Session:       tm.session(),
 		InterceptSpec: spec,
 		ApiKey:        apiKey,
+		Queue:         ir.Queue,
+		Override:      ir.Override,
 	})
 	if err != nil {
 		dlog.Debugf(c, "manager responded to CreateIntercept with error %v", err)
+		if st, ok := status.FromError(err); ok && st.Code() == codes.FailedPrecondition {
+			// Another client holds the workload, and the message tells who
+			return &rpc.InterceptResult{Error: rpc.InterceptError_TRAFFIC_MANAGER_ERROR, ErrorText: st.Message()}, nil
+		}
 		err = client.CheckTimeout(c, err)
 		return &rpc.InterceptResult{Error: rpc.InterceptError_TRAFFIC_MANAGER_ERROR, ErrorText: err.Error()}, nil
 	}
 	dlog.Debugf(c, "created intercept %s", ii.Spec.Name)
+	if len(ii.ReleasedBy) > 0 {
+		dlog.Infof(c, "intercept %s was queued until %s released the workload", ii.Spec.Name, strings.Join(ii.ReleasedBy, ", "))
+	}
+	if ii.ExpiresAt != nil {
+		dlog.Infof(c, "intercept %s will be removed by the traffic-manager at %s", ii.Spec.Name, ii.ExpiresAt.AsTime().Format(time.RFC3339))
+	}
+	if queued {
+		c, cancel = tos.TimeoutContext(c, client.TimeoutIntercept)
+		if err = resume(c); err != nil {
+			// The intercept can't be established without the operation, so don't leave it behind
+			if rerr := tm.RemoveIntercept(dcontext.WithoutCancel(c), ii.Spec.Name); rerr != nil {
+				dlog.Errorf(c, "unable to remove intercept %s: %v", ii.Spec.Name, rerr)
+			}
+			return &rpc.InterceptResult{
+				InterceptInfo: ii,
+				Error:         rpc.InterceptError_FAILED_TO_ESTABLISH,
+				ErrorText:     err.Error(),
+			}, nil
+		}
+	}
 
 	select {
 	case <-c.Done():
@@ -353,6 +422,7 @@ func (tm *trafficManager) AddIntercept(c context.Context, ir *rpc.CreateIntercep
 			}, nil
 		}
 		result.InterceptInfo = wr.intercept
+		result.Environment = tm.interceptEnv(c, result.Environment, ii)
 		if ir.MountPoint != "" && ii.SftpPort > 0 {
 			result.Environment["TELEPRESENCE_ROOT"] = ir.MountPoint
 			deleteMount = false // Mount-point is busy until intercept ends
@@ -362,6 +432,23 @@ func (tm *trafficManager) AddIntercept(c context.Context, ir *rpc.CreateIntercep
 	}
 }
 
+// interceptEnv returns a copy of the given environment of an intercept handler, with variables that
+// tell the handler about the intercept added.
+func (tm *trafficManager) interceptEnv(c context.Context, env map[string]string, ii *manager.InterceptInfo) map[string]string {
+	result := make(map[string]string, len(env)+5)
+	for k, v := range env {
+		result[k] = v
+	}
+	result["TELEPRESENCE_INTERCEPT_ID"] = ii.Id
+	result["TELEPRESENCE_INTERCEPT_NAME"] = ii.Spec.Name
+	result["TELEPRESENCE_INTERCEPT_NAMESPACE"] = ii.Spec.Namespace
+	if pod := tm.LookupPodName(c, ii.Spec.Namespace, ii.PodIp); pod != "" {
+		result["TELEPRESENCE_INTERCEPT_POD"] = pod
+	}
+	result["TELEPRESENCE_CLUSTER_DOMAIN"] = tm.ClusterDomain(c)
+	return result
+}
+
 func (tm *trafficManager) addAgent(c context.Context, namespace, agentName, svcName, svcPortIdentifier, agentImageName string) *rpc.InterceptResult {
 	svcUID, kind, err := tm.ensureAgent(c, namespace, agentName, svcName, svcPortIdentifier, agentImageName)
 	if err != nil {
@@ -434,38 +521,43 @@ func (tm *trafficManager) shouldForward(ii *manager.InterceptInfo) bool {
 // startForwards starts port forwards and mounts for the given forwardKey.
 // It assumes that the user has called shouldForward and is sure that something will be started.
 func (tm *trafficManager) startForwards(ctx context.Context, wg *sync.WaitGroup, fk forwardKey, sftpPort int32, extraPorts []int32) {
+	// The mount and the port forwards share one tunnel to the traffic-manager
+	aux := newAuxTunnel(tm.managerClient, tm.sessionInfo, tm.breaker)
 	if sftpPort > 0 {
 		// There's nothing to mount if the SftpPort is zero
 		mntCtx := dgroup.WithGoroutineName(ctx, fmt.Sprintf("/%s:%d", fk.PodIP, sftpPort))
 		wg.Add(1)
-		go tm.workerMountForwardIntercept(mntCtx, mountForward{fk, sftpPort}, wg)
+		go tm.workerMountForwardIntercept(mntCtx, aux, mountForward{fk, sftpPort}, wg)
 	}
 	for _, port := range extraPorts {
 		pfCtx := dgroup.WithGoroutineName(ctx, fmt.Sprintf("/%s:%d", fk.PodIP, port))
 		wg.Add(1)
-		go tm.workerPortForwardIntercept(pfCtx, portForward{fk, port}, wg)
+		go tm.workerPortForwardIntercept(pfCtx, aux, portForward{fk, port}, wg)
 	}
 }
 
-func (tm *trafficManager) workerPortForwardIntercept(ctx context.Context, pf portForward, wg *sync.WaitGroup) {
+func (tm *trafficManager) workerPortForwardIntercept(ctx context.Context, aux *auxTunnel, pf portForward, wg *sync.WaitGroup) {
 	defer wg.Done()
 	// Using kubectl port-forward here would require the pod name to be either fetched from the API server, or threaded
 	// all the way through from the intercept request to the agent and into the WatchIntercepts; it would also create
-	// additional connections that would have to be recovered in case of failure. Instead, we re-use the forwarder from
-	// the agent, and dial the pod's IP directly. This will keep all connections to the cluster going through the TUN
-	// device and the existing port-forward to the traffic manager.
-	addr := net.TCPAddr{
-		IP:   net.IPv4(127, 0, 0, 1),
-		Port: int(pf.Port),
-	}
-	f := forwarder.NewForwarder(&addr, pf.PodIP, pf.Port)
-	err := f.Serve(ctx)
+	// additional connections that would have to be recovered in case of failure. Instead, we let the traffic-manager
+	// dial the pod's IP over the auxiliary tunnel. This keeps all connections to the cluster going through the existing
+	// port-forward to the traffic manager, without depending on the TUN device of the root daemon.
+	podIP := net.ParseIP(pf.PodIP)
+	if podIP == nil {
+		dlog.Errorf(ctx, "invalid pod IP %q", pf.PodIP)
+		return
+	}
+	l, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: int(pf.Port)})
+	if err == nil {
+		err = aux.serve(ctx, l, podIP, uint16(pf.Port))
+	}
 	if err != nil && ctx.Err() == nil {
 		dlog.Errorf(ctx, "port-forwarder failed with %v", err)
 	}
 }
 
-func (tm *trafficManager) workerMountForwardIntercept(ctx context.Context, mf mountForward, wg *sync.WaitGroup) {
+func (tm *trafficManager) workerMountForwardIntercept(ctx context.Context, aux *auxTunnel, mf mountForward, wg *sync.WaitGroup) {
 	defer wg.Done()
 
 	var mountPoint string
@@ -481,34 +573,41 @@ func (tm *trafficManager) workerMountForwardIntercept(ctx context.Context, mf mo
 		return
 	}
 
+	podIP := net.ParseIP(mf.PodIP)
+	if podIP == nil {
+		dlog.Errorf(ctx, "Unable to mount file system for intercept %q: invalid pod IP %q", mf.Name, mf.PodIP)
+		return
+	}
+
 	dlog.Infof(ctx, "Mounting file system for intercept %q at %q", mf.Name, mountPoint)
 
-	// Retry mount in case it gets disconnected
-	err := client.Retry(ctx, "sshfs", func(ctx context.Context) error {
-		dl := &net.Dialer{Timeout: 3 * time.Second}
-		conn, err := dl.DialContext(ctx, "tcp", fmt.Sprintf("%s:%d", mf.PodIP, mf.SftpPort))
+	// The mirror outlives the worker when the intercept moves to another pod, so that the worker
+	// of the new pod carries on where this one left off.
+	v, _ := tm.mirrors.LoadOrStore(mountPoint, mount.NewMirror(install.TelAppMountPoint, mountPoint))
+	mirror := v.(*mount.Mirror)
+
+	// Retry in case the connection to the traffic-agent is lost or stops responding
+	err := client.RetryWithBreaker(ctx, "mount", tm.breaker, func(ctx context.Context) error {
+		tm.setMountHealth(ctx, mountPoint, cache.MountMounting, nil)
+		conn, err := aux.dial(ctx, podIP, uint16(mf.SftpPort))
 		if err != nil {
+			tm.setMountHealth(ctx, mountPoint, cache.MountDisconnected, err)
 			return err
 		}
 		defer conn.Close()
-		sshfsArgs := []string{
-			"-F", "none", // don't load the user's config file
-			"-f", // foreground operation
-
-			// connection settings
-			"-C", // compression
-			"-oConnectTimeout=10",
-			"-oStrictHostKeyChecking=no",     // don't bother checking the host key...
-			"-oUserKnownHostsFile=/dev/null", // and since we're not checking it, don't bother remembering it either
-			"-o", "slave",                    // Unencrypted via stdin/stdout
-
-			// mount directives
-			"-o", "follow_symlinks",
-			"-o", "allow_root", // needed to make --docker-run work as docker runs as root
-			"localhost:" + install.TelAppMountPoint, // what to mount
-			mountPoint,                              // where to mount it
+
+		err = mirror.Run(ctx, conn, func() {
+			tm.setMountHealth(ctx, mountPoint, cache.MountHealthy, nil)
+		})
+		switch {
+		case ctx.Err() != nil:
+			return nil
+		case errors.Is(err, os.ErrDeadlineExceeded):
+			tm.setMountHealth(ctx, mountPoint, cache.MountUnresponsive, err)
+		default:
+			tm.setMountHealth(ctx, mountPoint, cache.MountDisconnected, err)
 		}
-		return dpipe.DPipe(ctx, dexec.CommandContext(ctx, "sshfs", sshfsArgs...), conn)
+		return err
 	}, 3*time.Second, 6*time.Second)
 
 	if err != nil && ctx.Err() == nil {
@@ -523,17 +622,71 @@ func (tm *trafficManager) RemoveIntercept(c context.Context, name string) error
 	}
 	dlog.Debugf(c, "telling manager to remove intercept %s", name)
 	<-tm.startup
+	var spec *manager.InterceptSpec
+	for _, ii := range tm.CurrentIntercepts() {
+		if ii.Spec.Name == name {
+			spec = ii.Spec
+			break
+		}
+	}
 	_, err := tm.managerClient.RemoveIntercept(c, &manager.RemoveInterceptRequest2{
 		Session: tm.session(),
 		Name:    name,
 	})
+	if err == nil && spec != nil && client.GetConfig(c).Cluster.AgentInjection == client.AgentInjectionPatch {
+		err = tm.removeUnusedAgent(c, spec.Namespace, spec.Agent)
+	}
 	return err
 }
 
+// removeUnusedAgent restores the given workload to what it was before the traffic-agent was added
+// to it, unless it's still intercepted by this or another client.
+func (tm *trafficManager) removeUnusedAgent(c context.Context, namespace, name string) error {
+	wc, cancel := context.WithCancel(c)
+	defer cancel()
+	stream, err := tm.managerClient.WatchIntercepts(wc, &manager.SessionInfo{})
+	if err != nil {
+		return err
+	}
+	snapshot, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	for _, ii := range snapshot.Intercepts {
+		if ii.Spec.Namespace == namespace && ii.Spec.Agent == name {
+			return nil
+		}
+	}
+
+	obj, kind, err := tm.findWorkload(c, namespace, name)
+	if err != nil {
+		if errors2.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	if kind == "KnativeService" {
+		removed, err := tm.removeKnativeInjection(c, obj.(*kates.Unstructured))
+		if err != nil || !removed {
+			return err
+		}
+		return tm.waitForApply(c, namespace, name, obj)
+	}
+	if _, ok := obj.GetAnnotations()[annTelepresenceActions]; !ok {
+		// The agent wasn't added by a connector
+		return nil
+	}
+	dlog.Infof(c, "Removing the agent from %s %s.%s", kind, name, namespace)
+	if err = tm.undoObjectMods(c, obj); err != nil {
+		return err
+	}
+	return tm.waitForApply(c, namespace, name, obj)
+}
+
 // clearIntercepts removes all intercepts
 func (tm *trafficManager) clearIntercepts(c context.Context) error {
 	<-tm.startup
-	for _, cept := range tm.getCurrentIntercepts() {
+	for _, cept := range tm.CurrentIntercepts() {
 		err := tm.RemoveIntercept(c, cept.Spec.Name)
 		if err != nil {
 			return err
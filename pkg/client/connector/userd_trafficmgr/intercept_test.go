@@ -0,0 +1,25 @@
+package userd_trafficmgr
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/metrics"
+)
+
+func TestSetCurrentIntercepts(t *testing.T) {
+	tm := &trafficManager{}
+	tm.setCurrentIntercepts([]*manager.InterceptInfo{
+		{Id: "a", Disposition: manager.InterceptDispositionType_ACTIVE},
+		{Id: "b", Disposition: manager.InterceptDispositionType_WAITING},
+		{Id: "c", Disposition: manager.InterceptDispositionType_ACTIVE},
+	})
+	assert.Len(t, tm.currentIntercepts, 3)
+	assert.Equal(t, float64(2), testutil.ToFloat64(metrics.ActiveIntercepts))
+
+	tm.setCurrentIntercepts(nil)
+	assert.Equal(t, float64(0), testutil.ToFloat64(metrics.ActiveIntercepts))
+}
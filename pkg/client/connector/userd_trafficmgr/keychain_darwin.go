@@ -0,0 +1,18 @@
+package userd_trafficmgr
+
+import (
+	"context"
+
+	"github.com/datawire/dlib/dexec"
+)
+
+// readKeychainItem reads the generic password with the given service name from the keychain. Such
+// an item is created with e.g.
+//
+//	security add-generic-password -a telepresence -s <name> -w "$(cat client.pem)"
+func readKeychainItem(c context.Context, name string) ([]byte, error) {
+	cmd := dexec.CommandContext(c, "security", "find-generic-password", "-s", name, "-w")
+	// The output is a secret
+	cmd.DisableLogging = true
+	return cmd.Output()
+}
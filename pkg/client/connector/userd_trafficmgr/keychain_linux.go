@@ -0,0 +1,18 @@
+package userd_trafficmgr
+
+import (
+	"context"
+
+	"github.com/datawire/dlib/dexec"
+)
+
+// readKeychainItem reads the secret with the given name from the Secret Service, e.g. the GNOME
+// Keyring or KWallet. Such a secret is created with e.g.
+//
+//	secret-tool store --label="Telepresence client certificate" telepresence <name> < client.pem
+func readKeychainItem(c context.Context, name string) ([]byte, error) {
+	cmd := dexec.CommandContext(c, "secret-tool", "lookup", "telepresence", name)
+	// The output is a secret
+	cmd.DisableLogging = true
+	return cmd.Output()
+}
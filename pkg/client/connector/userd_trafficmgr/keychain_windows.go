@@ -0,0 +1,10 @@
+package userd_trafficmgr
+
+import (
+	"context"
+	"errors"
+)
+
+func readKeychainItem(_ context.Context, _ string) ([]byte, error) {
+	return nil, errors.New("keychain references are not supported on Windows, use the path of a file")
+}
@@ -0,0 +1,183 @@
+package userd_trafficmgr
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/datawire/ambassador/pkg/kates"
+	"github.com/datawire/dlib/dcontext"
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/connector/userd_intercept"
+	"github.com/telepresenceio/telepresence/v2/pkg/install"
+)
+
+// annKnativeInjection is the annotation of a Knative Service that tells that the injection of the
+// traffic-agent was enabled in its revision template. Its value holds the original values of the
+// template annotations that were changed, so that they can be restored.
+const annKnativeInjection = install.DomainPrefix + "knative-injection"
+
+// decideKnativeAgent decides if injection of the traffic-agent must be enabled in the Knative
+// Service of the given change.
+func decideKnativeAgent(c context.Context, ch *agentChange) error {
+	ksvc := ch.obj.(*kates.Unstructured)
+	if client.GetConfig(c).Cluster.AgentInjection == client.AgentInjectionPatch {
+		return install.ObjErrorf(ksvc, "can only be intercepted when the %s is injected by the traffic-manager, "+
+			"which it isn't when cluster.agentInjection is %q", install.AgentContainerName, client.AgentInjectionPatch)
+	}
+	if !knativeRoutesToLatestRevision(ksvc) {
+		return install.ObjErrorf(ksvc, "can only be intercepted when its traffic is routed to its latest revision")
+	}
+	modified, err := enableKnativeInjection(ksvc)
+	if err != nil {
+		return err
+	}
+	if modified {
+		ch.agent = userd_intercept.AgentEnableInjection
+	} else {
+		ch.agent = userd_intercept.AgentInjected
+	}
+	return nil
+}
+
+// ensureKnativeAgent makes the traffic-manager inject the traffic-agent into the pods of the
+// revisions of the Knative Service of the given change, and waits until the revision with the
+// agent is ready. Knative owns the deployments of its revisions and reverts all changes made to
+// them, so the agent can't be added the way it's added to other workloads.
+//
+// The UID of the Knative Service is returned. Unlike the services of its revisions, it remains the
+// same when Knative rolls over to a new revision.
+func (ki *installer) ensureKnativeAgent(c context.Context, ch *agentChange) (string, error) {
+	ksvc := ch.obj.(*kates.Unstructured)
+	namespace, name := ksvc.GetNamespace(), ksvc.GetName()
+	modified := ch.modified()
+	if modified {
+		dlog.Infof(c, "Enabling injection of the %s into the revisions of Knative Service %s.%s", install.AgentContainerName, name, namespace)
+		if err := ki.updateObject(c, ch.orig, ksvc); err != nil {
+			return "", err
+		}
+	}
+	if err := ki.waitForApply(c, namespace, name, ksvc); err != nil {
+		if !modified {
+			return "", err
+		}
+		// Don't leave the Knative Service with a revision that won't become ready
+		dlog.Errorf(c, "Rollout of Knative Service %s.%s failed, disabling injection of the %s: %v", name, namespace, install.AgentContainerName, err)
+		if _, uerr := ki.removeKnativeInjection(dcontext.WithoutCancel(c), ksvc); uerr != nil {
+			dlog.Errorf(c, "unable to disable injection of the %s into Knative Service %s.%s: %v", install.AgentContainerName, name, namespace, uerr)
+		}
+		return "", fmt.Errorf("rollout of Knative Service %s.%s with the %s failed, the injection was disabled again: %w",
+			name, namespace, install.AgentContainerName, err)
+	}
+	return string(ksvc.GetUID()), nil
+}
+
+// removeKnativeInjection disables the injection of the traffic-agent that ensureKnativeAgent enabled
+// in the given Knative Service. It returns false if there was nothing to disable.
+func (ki *installer) removeKnativeInjection(c context.Context, ksvc *kates.Unstructured) (bool, error) {
+	orig := ksvc.DeepCopyObject().(kates.Object)
+	modified, err := disableKnativeInjection(ksvc)
+	if err != nil || !modified {
+		return false, err
+	}
+	dlog.Infof(c, "Disabling injection of the %s into the revisions of Knative Service %s.%s",
+		install.AgentContainerName, ksvc.GetName(), ksvc.GetNamespace())
+	return true, ki.updateObject(c, orig, ksvc)
+}
+
+// knativeTemplateAnnotations returns the revision template annotations that make the traffic-manager
+// inject the traffic-agent into the pods of the revisions of the given Knative Service. The agent
+// intercepts the port of the queue-proxy in front of the user container, which is the port that the
+// private service of a revision names after the protocol of that container.
+func knativeTemplateAnnotations(ksvc *kates.Unstructured) map[string]string {
+	portName := "http"
+	cns, _, _ := unstructured.NestedSlice(ksvc.Object, "spec", "template", "spec", "containers")
+	if len(cns) > 0 {
+		if cn, ok := cns[0].(map[string]interface{}); ok {
+			ports, _, _ := unstructured.NestedSlice(cn, "ports")
+			if len(ports) > 0 {
+				if port, ok := ports[0].(map[string]interface{}); ok && port["name"] == "h2c" {
+					portName = "http2"
+				}
+			}
+		}
+	}
+	return map[string]string{
+		install.InjectAnnotation:      "enabled",
+		install.ServicePortAnnotation: portName,
+
+		// A revision that scales to zero has no agents, and the intercepts stall until a request
+		// arrives that makes it scale up again.
+		install.KnativeMinScaleAnnotation: "1",
+	}
+}
+
+// enableKnativeInjection enables the injection of the traffic-agent in the revision template of the
+// given Knative Service. It returns false if the injection already was enabled.
+func enableKnativeInjection(ksvc *kates.Unstructured) (bool, error) {
+	return setTemplateAnnotations(ksvc, annKnativeInjection, knativeTemplateAnnotations(ksvc), func(k, cur string) bool {
+		if k == install.KnativeMinScaleAnnotation {
+			n, err := strconv.Atoi(cur)
+			return err == nil && n >= 1
+		}
+		return false
+	})
+}
+
+// disableKnativeInjection restores the revision template annotations that enableKnativeInjection
+// changed in the given Knative Service. It returns false if the injection wasn't enabled.
+func disableKnativeInjection(ksvc *kates.Unstructured) (bool, error) {
+	return restoreTemplateAnnotations(ksvc, annKnativeInjection)
+}
+
+// knativeRoutesToLatestRevision returns true if the given Knative Service routes traffic to its
+// latest revision. The agent is only injected into new revisions, so an intercept of a Knative
+// Service that only routes to older revisions would never receive any traffic.
+func knativeRoutesToLatestRevision(ksvc *kates.Unstructured) bool {
+	targets, _, _ := unstructured.NestedSlice(ksvc.Object, "spec", "traffic")
+	if len(targets) == 0 {
+		// All traffic goes to the latest revision by default
+		return true
+	}
+	for _, t := range targets {
+		target, ok := t.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		latest, _, _ := unstructured.NestedBool(target, "latestRevision")
+		percent, _, _ := unstructured.NestedInt64(target, "percent")
+		if latest && percent > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// knativeServiceReady returns true when the latest revision of the given Knative Service is ready
+// to serve traffic. An error is returned when Knative reports that the revision failed.
+func knativeServiceReady(ksvc *kates.Unstructured) (bool, error) {
+	if observed, _, _ := unstructured.NestedInt64(ksvc.Object, "status", "observedGeneration"); observed < ksvc.GetGeneration() {
+		return false, nil
+	}
+	conditions, _, _ := unstructured.NestedSlice(ksvc.Object, "status", "conditions")
+	for _, c := range conditions {
+		cond, ok := c.(map[string]interface{})
+		if !ok || cond["type"] != "Ready" {
+			continue
+		}
+		switch cond["status"] {
+		case "True":
+			created, _, _ := unstructured.NestedString(ksvc.Object, "status", "latestCreatedRevisionName")
+			ready, _, _ := unstructured.NestedString(ksvc.Object, "status", "latestReadyRevisionName")
+			return created != "" && created == ready, nil
+		case "False":
+			reason, _ := cond["reason"].(string)
+			msg, _ := cond["message"].(string)
+			return false, install.ObjErrorf(ksvc, "not ready: %s: %s", reason, msg)
+		}
+	}
+	return false, nil
+}
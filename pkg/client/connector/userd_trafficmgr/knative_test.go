@@ -0,0 +1,152 @@
+package userd_trafficmgr
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/datawire/ambassador/pkg/kates"
+	"github.com/telepresenceio/telepresence/v2/pkg/install"
+)
+
+func knativeService(tplAnnotations map[string]interface{}) *kates.Unstructured {
+	tpl := map[string]interface{}{
+		"spec": map[string]interface{}{
+			"containers": []interface{}{
+				map[string]interface{}{
+					"image": "hello",
+					"ports": []interface{}{map[string]interface{}{"containerPort": int64(8080)}},
+				},
+			},
+		},
+	}
+	if tplAnnotations != nil {
+		tpl["metadata"] = map[string]interface{}{"annotations": tplAnnotations}
+	}
+	return &kates.Unstructured{Object: map[string]interface{}{
+		"apiVersion": install.KnativeServiceAPIVersion,
+		"kind":       "Service",
+		"metadata":   map[string]interface{}{"name": "hello", "namespace": "default"},
+		"spec":       map[string]interface{}{"template": tpl},
+	}}
+}
+
+func TestKnativeInjection(t *testing.T) {
+	t.Run("without annotations", func(t *testing.T) {
+		ksvc := knativeService(nil)
+		modified, err := enableKnativeInjection(ksvc)
+		require.NoError(t, err)
+		assert.True(t, modified)
+		ann, _, _ := unstructured.NestedStringMap(ksvc.Object, templateAnnotationsPath...)
+		assert.Equal(t, map[string]string{
+			install.InjectAnnotation:          "enabled",
+			install.ServicePortAnnotation:     "http",
+			install.KnativeMinScaleAnnotation: "1",
+		}, ann)
+
+		// Enabling it again is a no-op
+		modified, err = enableKnativeInjection(ksvc)
+		require.NoError(t, err)
+		assert.False(t, modified)
+
+		modified, err = disableKnativeInjection(ksvc)
+		require.NoError(t, err)
+		assert.True(t, modified)
+		_, found, _ := unstructured.NestedFieldNoCopy(ksvc.Object, templateAnnotationsPath...)
+		assert.False(t, found)
+		assert.Empty(t, ksvc.GetAnnotations())
+
+		modified, err = disableKnativeInjection(ksvc)
+		require.NoError(t, err)
+		assert.False(t, modified)
+	})
+
+	t.Run("with annotations", func(t *testing.T) {
+		ksvc := knativeService(map[string]interface{}{
+			install.KnativeMinScaleAnnotation: "0",
+			"autoscaling.knative.dev/target":  "10",
+		})
+		orig := ksvc.DeepCopy()
+		_, err := enableKnativeInjection(ksvc)
+		require.NoError(t, err)
+		ann, _, _ := unstructured.NestedStringMap(ksvc.Object, templateAnnotationsPath...)
+		assert.Equal(t, "1", ann[install.KnativeMinScaleAnnotation])
+		assert.Equal(t, "10", ann["autoscaling.knative.dev/target"])
+
+		_, err = disableKnativeInjection(ksvc)
+		require.NoError(t, err)
+		assert.Equal(t, orig, ksvc)
+	})
+
+	t.Run("min scale retained", func(t *testing.T) {
+		ksvc := knativeService(map[string]interface{}{install.KnativeMinScaleAnnotation: "3"})
+		_, err := enableKnativeInjection(ksvc)
+		require.NoError(t, err)
+		ann, _, _ := unstructured.NestedStringMap(ksvc.Object, templateAnnotationsPath...)
+		assert.Equal(t, "3", ann[install.KnativeMinScaleAnnotation])
+	})
+
+	t.Run("h2c", func(t *testing.T) {
+		ksvc := knativeService(nil)
+		require.NoError(t, unstructured.SetNestedSlice(ksvc.Object, []interface{}{
+			map[string]interface{}{
+				"image": "hello",
+				"ports": []interface{}{map[string]interface{}{"name": "h2c", "containerPort": int64(8080)}},
+			},
+		}, "spec", "template", "spec", "containers"))
+		assert.Equal(t, "http2", knativeTemplateAnnotations(ksvc)[install.ServicePortAnnotation])
+	})
+}
+
+func TestKnativeRoutesToLatestRevision(t *testing.T) {
+	ksvc := knativeService(nil)
+	assert.True(t, knativeRoutesToLatestRevision(ksvc))
+
+	setTraffic := func(targets ...interface{}) {
+		require.NoError(t, unstructured.SetNestedSlice(ksvc.Object, targets, "spec", "traffic"))
+	}
+	setTraffic(map[string]interface{}{"revisionName": "hello-00001", "percent": int64(100)})
+	assert.False(t, knativeRoutesToLatestRevision(ksvc))
+
+	setTraffic(
+		map[string]interface{}{"revisionName": "hello-00001", "percent": int64(90)},
+		map[string]interface{}{"latestRevision": true, "percent": int64(10)},
+	)
+	assert.True(t, knativeRoutesToLatestRevision(ksvc))
+}
+
+func TestKnativeServiceReady(t *testing.T) {
+	ksvc := knativeService(nil)
+	ksvc.SetGeneration(2)
+	setStatus := func(observed int64, created, ready, status string) {
+		ksvc.Object["status"] = map[string]interface{}{
+			"observedGeneration":        observed,
+			"latestCreatedRevisionName": created,
+			"latestReadyRevisionName":   ready,
+			"conditions": []interface{}{
+				map[string]interface{}{"type": "Ready", "status": status, "reason": "RevisionFailed", "message": "boom"},
+			},
+		}
+	}
+
+	setStatus(1, "hello-00001", "hello-00001", "True")
+	ready, err := knativeServiceReady(ksvc)
+	require.NoError(t, err)
+	assert.False(t, ready, "the generation isn't observed yet")
+
+	setStatus(2, "hello-00002", "hello-00001", "Unknown")
+	ready, err = knativeServiceReady(ksvc)
+	require.NoError(t, err)
+	assert.False(t, ready)
+
+	setStatus(2, "hello-00002", "hello-00002", "True")
+	ready, err = knativeServiceReady(ksvc)
+	require.NoError(t, err)
+	assert.True(t, ready)
+
+	setStatus(2, "hello-00002", "hello-00001", "False")
+	_, err = knativeServiceReady(ksvc)
+	assert.Error(t, err)
+}
@@ -0,0 +1,78 @@
+package userd_trafficmgr
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+
+	errors2 "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+
+	"github.com/datawire/ambassador/pkg/kates"
+	"github.com/datawire/dlib/dlog"
+)
+
+// maxMergeRetries is the number of times that an update that conflicts with a concurrent change is
+// merged and retried.
+const maxMergeRetries = 5
+
+// updateObject updates the given modified object in the cluster. The orig is the object as it was
+// read from the cluster before it was modified. When someone else changed the object in the
+// meantime, the modifications are instead merged with the current object using a three-way merge
+// so that the other change is retained. The given object reflects the result of the update.
+func (ki *installer) updateObject(c context.Context, orig, obj kates.Object) error {
+	for i := 0; ; i++ {
+		err := ki.Client().Update(c, obj, obj)
+		if err == nil || !errors2.IsConflict(err) || i == maxMergeRetries {
+			return err
+		}
+		if _, ok := obj.(*kates.Unstructured); ok {
+			// A strategic merge requires the type of the object
+			return err
+		}
+		dlog.Debugf(c, "Merging the changes to %s %s.%s with a concurrent change",
+			obj.GetObjectKind().GroupVersionKind().Kind, obj.GetName(), obj.GetNamespace())
+		current := obj.DeepCopyObject().(kates.Object)
+		if err = ki.Client().Get(c, current, current); err != nil {
+			return err
+		}
+		merged, err := threeWayMerge(orig, obj, current)
+		if err != nil {
+			return err
+		}
+
+		// The next conflict, if any, is relative to the current object
+		orig = current
+		modified := obj.DeepCopyObject().(kates.Object)
+		v := reflect.ValueOf(modified).Elem()
+		v.Set(reflect.Zero(v.Type()))
+		if err = json.Unmarshal(merged, modified); err != nil {
+			return err
+		}
+		reflect.ValueOf(obj).Elem().Set(v)
+	}
+}
+
+// threeWayMerge applies the changes between orig and modified to current, and returns the JSON of
+// the result. The changes of current that modified doesn't touch are retained.
+func threeWayMerge(orig, modified, current kates.Object) ([]byte, error) {
+	origJSON, err := json.Marshal(orig)
+	if err != nil {
+		return nil, err
+	}
+	modifiedJSON, err := json.Marshal(modified)
+	if err != nil {
+		return nil, err
+	}
+	currentJSON, err := json.Marshal(current)
+	if err != nil {
+		return nil, err
+	}
+	// A three-way patch of strategicpatch would make current equal to modified, and so revert the
+	// concurrent change. Only the changes between orig and modified are wanted.
+	patch, err := strategicpatch.CreateTwoWayMergePatch(origJSON, modifiedJSON, modified)
+	if err != nil {
+		return nil, err
+	}
+	return strategicpatch.StrategicMergePatch(currentJSON, patch, modified)
+}
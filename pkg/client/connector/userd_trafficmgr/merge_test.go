@@ -0,0 +1,57 @@
+package userd_trafficmgr
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/datawire/ambassador/pkg/kates"
+)
+
+func TestThreeWayMerge(t *testing.T) {
+	replicas := int32(1)
+	orig := &kates.Deployment{
+		TypeMeta:   kates.TypeMeta{Kind: "Deployment", APIVersion: "apps/v1"},
+		ObjectMeta: kates.ObjectMeta{Name: "echo", Namespace: "default", ResourceVersion: "1"},
+	}
+	orig.Spec.Replicas = &replicas
+	orig.Spec.Template.Spec.Containers = []corev1.Container{{Name: "echo", Image: "echo:1"}}
+
+	// The connector adds the agent
+	modified := orig.DeepCopy()
+	modified.Annotations = map[string]string{annTelepresenceActions: "{}"}
+	modified.Spec.Template.Spec.Containers = append(modified.Spec.Template.Spec.Containers,
+		corev1.Container{Name: "traffic-agent", Image: "tel2:2"})
+
+	// Someone else scales the deployment and changes the image in the meantime
+	current := orig.DeepCopy()
+	current.ResourceVersion = "2"
+	moreReplicas := int32(3)
+	current.Spec.Replicas = &moreReplicas
+	current.Spec.Template.Spec.Containers[0].Image = "echo:2"
+
+	data, err := threeWayMerge(orig, modified, current)
+	require.NoError(t, err)
+	merged := &kates.Deployment{}
+	require.NoError(t, json.Unmarshal(data, merged))
+
+	assert.Equal(t, "2", merged.ResourceVersion)
+	assert.Equal(t, int32(3), *merged.Spec.Replicas)
+	assert.Equal(t, "{}", merged.Annotations[annTelepresenceActions])
+	cns := merged.Spec.Template.Spec.Containers
+	require.Len(t, cns, 2)
+	assert.Equal(t, "echo:2", cns[0].Image)
+	assert.Equal(t, "traffic-agent", cns[1].Name)
+
+	// Removing the agent again retains the change too
+	data, err = threeWayMerge(modified, orig, merged)
+	require.NoError(t, err)
+	restored := &kates.Deployment{}
+	require.NoError(t, json.Unmarshal(data, restored))
+	assert.Empty(t, restored.Annotations)
+	require.Len(t, restored.Spec.Template.Spec.Containers, 1)
+	assert.Equal(t, "echo:2", restored.Spec.Template.Spec.Containers[0].Image)
+}
@@ -0,0 +1,41 @@
+package userd_trafficmgr
+
+import (
+	"context"
+	"time"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/cache"
+)
+
+// setMountHealth records the health of the given mount point, and saves the session state when
+// it changes so that "telepresence status" can report it.
+func (tm *trafficManager) setMountHealth(ctx context.Context, mountPoint, health string, err error) {
+	ms := &cache.MountState{Health: health, Since: time.Now()}
+	if err != nil {
+		ms.Error = err.Error()
+	}
+	if v, ok := tm.mountHealth.Load(mountPoint); ok {
+		old := v.(*cache.MountState)
+		if old.Health == ms.Health && old.Error == ms.Error {
+			return
+		}
+		ms.Remounts = old.Remounts
+		if health == cache.MountMounting {
+			ms.Remounts++
+		}
+	}
+	tm.mountHealth.Store(mountPoint, ms)
+	if health != cache.MountHealthy {
+		dlog.Infof(ctx, "mount point %q is %s", mountPoint, health)
+	}
+	tm.saveSessionState(ctx, false)
+}
+
+// getMountHealth returns the recorded health of the given mount point, or nil if there is none.
+func (tm *trafficManager) getMountHealth(mountPoint string) *cache.MountState {
+	if v, ok := tm.mountHealth.Load(mountPoint); ok {
+		return v.(*cache.MountState)
+	}
+	return nil
+}
@@ -0,0 +1,226 @@
+package userd_trafficmgr
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/datawire/ambassador/pkg/kates"
+	"github.com/datawire/dlib/dlog"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/connector/userd_intercept"
+	"github.com/telepresenceio/telepresence/v2/pkg/install"
+)
+
+// workloadResource is the API group and resource of each kind of workload that can be intercepted.
+var workloadResource = map[string]groupResource{
+	"Deployment":       {"apps", "deployments"},
+	"ReplicaSet":       {"apps", "replicasets"},
+	"StatefulSet":      {"apps", "statefulsets"},
+	"DeploymentConfig": {"apps.openshift.io", "deploymentconfigs"},
+	"Rollout":          {"argoproj.io", "rollouts"},
+	"KnativeService":   {"serving.knative.dev", "services"},
+}
+
+// PlanIntercept tells what AddIntercept would do with the given request: how the traffic-agent
+// would be installed, what would change in the workload and its service, if the user is allowed to
+// make those changes, and what would conflict with the intercept on this workstation. Nothing is
+// changed. Problems that AddIntercept would fail on before changing anything, such as a workload
+// or service that can't be found, are returned as errors.
+func (tm *trafficManager) PlanIntercept(c context.Context, ir *rpc.CreateInterceptRequest) (*rpc.InterceptPlan, error) {
+	spec := ir.Spec
+	namespace := tm.ActualNamespace(spec.Namespace)
+	if namespace == "" {
+		return nil, fmt.Errorf("namespace %q doesn't exist or isn't mapped", spec.Namespace)
+	}
+	plan := &rpc.InterceptPlan{
+		Name:      spec.Name,
+		Workload:  spec.Agent,
+		Namespace: namespace,
+	}
+	dlog.Debugf(c, "planning intercept %s of %s.%s", spec.Name, spec.Agent, namespace)
+
+	<-tm.startup
+	plan.Conflicts = interceptConflicts(ir, tm.CurrentIntercepts(), &tm.mountPoints)
+	if err := tm.planAgent(c, plan, spec.ServiceName, spec.ServicePortIdentifier, ir.AgentImage); err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// interceptConflicts returns what conflicts with the given request on this workstation: the current
+// intercepts, the mount points in use, and the local ports that the extra ports of the request are
+// forwarded from.
+func interceptConflicts(ir *rpc.CreateInterceptRequest, current []*manager.InterceptInfo, mountPoints *sync.Map) []string {
+	spec := ir.Spec
+	var conflicts []string
+	for _, ic := range current {
+		if ic.Spec.Name == spec.Name {
+			conflicts = append(conflicts, fmt.Sprintf("an intercept named %s already exists", spec.Name))
+		} else if ic.Spec.TargetPort == spec.TargetPort && ic.Spec.TargetHost == spec.TargetHost {
+			conflicts = append(conflicts, fmt.Sprintf("%s:%d is already the target of intercept %s", spec.TargetHost, spec.TargetPort, ic.Spec.Name))
+		}
+	}
+	if ir.MountPoint != "" {
+		if prev, busy := mountPoints.Load(ir.MountPoint); busy {
+			conflicts = append(conflicts, fmt.Sprintf("mount point %s is in use by intercept %s", ir.MountPoint, prev))
+		}
+	}
+	for _, port := range spec.ExtraPorts {
+		// The extra ports are forwarded from the same address as the one that workerPortForwardIntercept listens on
+		l, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: int(port)})
+		if err != nil {
+			conflicts = append(conflicts, fmt.Sprintf("port %d can't be forwarded from the pod: %v", port, err))
+			continue
+		}
+		l.Close()
+	}
+	return conflicts
+}
+
+// planAgent fills in how ensureAgent would install the traffic-agent, and checks that the user has
+// the permissions to do so. The decisions are those of decideAgent and planRollout, which
+// ensureAgent acts on.
+func (ki *installer) planAgent(c context.Context, plan *rpc.InterceptPlan, svcName, portNameOrNumber, agentImageName string) error {
+	ch, err := ki.decideAgent(c, plan.Namespace, plan.Workload, svcName, portNameOrNumber, agentImageName)
+	if err != nil {
+		return err
+	}
+	if err = describeAgentChange(plan, ch, portNameOrNumber); err != nil {
+		return err
+	}
+	if !plan.Rollout {
+		if ch.origSvc != nil {
+			// An injected agent that the service must be redirected to
+			ki.checkPermission(c, plan, "update", groupResource{"", "services"}, false)
+		}
+		return nil
+	}
+
+	wr := workloadResource[ch.kind]
+	ki.checkPermission(c, plan, "update", wr, false)
+	if ch.origSvc != nil {
+		ki.checkPermission(c, plan, "update", groupResource{"", "services"}, false)
+	}
+	if ch.kind == "KnativeService" {
+		return nil
+	}
+	if ch.kind == "ReplicaSet" {
+		// A ReplicaSet has no rollout, so its pods are deleted, see refreshReplicaSet
+		ki.checkPermission(c, plan, "delete", groupResource{"", "pods"}, false)
+	}
+	// The pods are listed to tell why a rollout doesn't complete
+	ki.checkPermission(c, plan, "list", groupResource{"", "pods"}, true)
+
+	rp, err := ki.planRollout(c, ch.kind, ch.obj)
+	if err != nil {
+		return err
+	}
+	for _, gr := range rp.lists {
+		ki.checkPermission(c, plan, "list", gr, true)
+	}
+	for _, step := range rp.steps {
+		plan.Changes = append(plan.Changes, step.explanation)
+		ki.checkPermission(c, plan, step.verb, step.groupResource, step.optional)
+	}
+	plan.Warnings = append(plan.Warnings, rp.warnings...)
+	return nil
+}
+
+// describeAgentChange fills in the agent, the changes, and the service of the plan from the given
+// change.
+func describeAgentChange(plan *rpc.InterceptPlan, ch *agentChange, portNameOrNumber string) error {
+	plan.Kind = ch.kind
+	plan.Agent = ch.agent
+	plan.Rollout = ch.modified()
+	name := ch.obj.GetName()
+	switch ch.agent {
+	case userd_intercept.AgentInjected:
+		if ch.svc != nil {
+			plan.Service = ch.svc.Name
+			plan.ServicePort = portNameOrNumber
+		}
+		return describeServiceChange(plan, ch)
+	case userd_intercept.AgentEnableInjection:
+		into := "new revisions"
+		if ch.kind == "DeploymentConfig" || ch.kind == "Rollout" {
+			into = "its pods"
+		}
+		plan.Changes = append(plan.Changes, fmt.Sprintf("In %s %s, enable injection of the %s into %s",
+			ch.kind, name, install.AgentContainerName, into))
+		if ch.svc != nil {
+			plan.Service = ch.svc.Name
+			plan.ServicePort = portNameOrNumber
+		}
+		return describeServiceChange(plan, ch)
+	case userd_intercept.AgentAdd:
+		var wa workloadActions
+		if _, err := getAnnotation(ch.obj, &wa); err != nil {
+			return err
+		}
+		plan.Changes = append(plan.Changes, explanation(&wa, ch.kind, ch.obj))
+		if err := describeServiceChange(plan, ch); err != nil {
+			return err
+		}
+	case userd_intercept.AgentUpgrade:
+		plan.Changes = append(plan.Changes, fmt.Sprintf("In %s %s, change the image of the %s from %s to %s",
+			ch.kind, name, install.AgentContainerName, ch.oldImage, ch.newImage))
+	}
+
+	var actions workloadActions
+	if ok, err := getAnnotation(ch.obj, &actions); err != nil {
+		return err
+	} else if ok {
+		plan.Service = actions.ReferencedService
+		plan.ServicePort = actions.ReferencedServicePortName
+		if plan.ServicePort == "" {
+			plan.ServicePort = actions.ReferencedServicePort
+		}
+	}
+	return nil
+}
+
+// describeServiceChange adds the explanation of the change to the service, if any, to the plan.
+func describeServiceChange(plan *rpc.InterceptPlan, ch *agentChange) error {
+	if ch.origSvc == nil {
+		return nil
+	}
+	var sa svcActions
+	if _, err := getAnnotation(ch.svc, &sa); err != nil {
+		return err
+	}
+	plan.Changes = append(plan.Changes, explanation(&sa, "Service", ch.svc))
+	return nil
+}
+
+// checkPermission adds the result of checking if the user may perform the given verb on the given
+// resource in the namespace of the plan. A permission that the plan already has isn't checked
+// again, but a required check makes it required.
+func (ki *installer) checkPermission(c context.Context, plan *rpc.InterceptPlan, verb string, gr groupResource, optional bool) {
+	resource := gr.resource
+	if gr.group != "" {
+		resource += "." + gr.group
+	}
+	for _, pm := range plan.Permissions {
+		if pm.Verb == verb && pm.Resource == resource {
+			pm.Optional = pm.Optional && optional
+			return
+		}
+	}
+	pm := &rpc.InterceptPlan_Permission{Verb: verb, Resource: resource, Optional: optional}
+	var err error
+	if pm.Allowed, err = ki.CanI(c, verb, gr.group, gr.resource, plan.Namespace); err != nil {
+		pm.Error = err.Error()
+	}
+	plan.Permissions = append(plan.Permissions, pm)
+}
+
+// explanation returns what explainDo logs for the given action.
+func explanation(a completeAction, kind string, obj kates.Object) string {
+	var buf strings.Builder
+	a.ExplainDo(obj, &buf)
+	return fmt.Sprintf("In %s %s, %s", kind, obj.GetName(), buf.String())
+}
@@ -0,0 +1,124 @@
+package userd_trafficmgr
+
+import (
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/ambassador/pkg/kates"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/connector/userd_intercept"
+	"github.com/telepresenceio/telepresence/v2/pkg/version"
+)
+
+func TestInterceptConflicts(t *testing.T) {
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer busy.Close()
+	busyPort := int32(busy.Addr().(*net.TCPAddr).Port)
+
+	var mountPoints sync.Map
+	mountPoints.Store("/tmp/mnt", "other")
+	current := []*manager.InterceptInfo{
+		{Spec: &manager.InterceptSpec{Name: "echo", TargetHost: "127.0.0.1", TargetPort: 9090}},
+		{Spec: &manager.InterceptSpec{Name: "web", TargetHost: "127.0.0.1", TargetPort: 8080}},
+	}
+	ir := &rpc.CreateInterceptRequest{
+		Spec: &manager.InterceptSpec{
+			Name:       "echo",
+			TargetHost: "127.0.0.1",
+			TargetPort: 8080,
+			ExtraPorts: []int32{busyPort},
+		},
+		MountPoint: "/tmp/mnt",
+	}
+	conflicts := interceptConflicts(ir, current, &mountPoints)
+	require.Len(t, conflicts, 4)
+	assert.Equal(t, "an intercept named echo already exists", conflicts[0])
+	assert.Equal(t, "127.0.0.1:8080 is already the target of intercept web", conflicts[1])
+	assert.Equal(t, "mount point /tmp/mnt is in use by intercept other", conflicts[2])
+	assert.Contains(t, conflicts[3], "can't be forwarded from the pod")
+
+	ir.Spec.Name = "other"
+	ir.Spec.TargetPort = 7070
+	ir.Spec.ExtraPorts = nil
+	ir.MountPoint = "/tmp/other"
+	assert.Empty(t, interceptConflicts(ir, current, &mountPoints))
+}
+
+func TestDescribeAgentChange(t *testing.T) {
+	const testVersion = "v2.3.6"
+	defer func(v string) { version.Version = v }(version.Version)
+	version.Version = testVersion
+	orig, origSvc, portName, err := loadFile("cur/deployment-tc-0.input.yaml", testVersion)
+	require.NoError(t, err)
+	obj, svc, _, err := loadFile("cur/deployment-tc-0.output.yaml", testVersion)
+	require.NoError(t, err)
+
+	t.Run("add", func(t *testing.T) {
+		plan := &rpc.InterceptPlan{}
+		ch := &agentChange{kind: "Deployment", agent: userd_intercept.AgentAdd, orig: orig, obj: obj, origSvc: origSvc, svc: svc}
+		require.NoError(t, describeAgentChange(plan, ch, portName))
+		assert.Equal(t, "Deployment", plan.Kind)
+		assert.Equal(t, userd_intercept.AgentAdd, plan.Agent)
+		assert.True(t, plan.Rollout)
+		require.Len(t, plan.Changes, 2)
+		assert.Contains(t, plan.Changes[0], "In Deployment hello-0, add traffic-agent container")
+		assert.Contains(t, plan.Changes[1], "In Service hello-0, ")
+		assert.Equal(t, "hello-0", plan.Service)
+		assert.Equal(t, "80", plan.ServicePort)
+	})
+
+	t.Run("upgrade", func(t *testing.T) {
+		plan := &rpc.InterceptPlan{}
+		ch := &agentChange{
+			kind:     "Deployment",
+			agent:    userd_intercept.AgentUpgrade,
+			orig:     obj,
+			obj:      obj,
+			upgrade:  &workloadActions{},
+			oldImage: "localhost:5000/tel2:2.3.0",
+			newImage: "localhost:5000/tel2:2.3.6",
+		}
+		require.NoError(t, describeAgentChange(plan, ch, portName))
+		assert.True(t, plan.Rollout)
+		assert.Equal(t, []string{"In Deployment hello-0, change the image of the traffic-agent from localhost:5000/tel2:2.3.0 to localhost:5000/tel2:2.3.6"},
+			plan.Changes)
+		assert.Equal(t, "hello-0", plan.Service)
+	})
+
+	t.Run("up-to-date", func(t *testing.T) {
+		plan := &rpc.InterceptPlan{}
+		ch := &agentChange{kind: "Deployment", agent: userd_intercept.AgentUpToDate, orig: obj, obj: obj}
+		require.NoError(t, describeAgentChange(plan, ch, portName))
+		assert.False(t, plan.Rollout)
+		assert.Empty(t, plan.Changes)
+		assert.Equal(t, "hello-0", plan.Service)
+		assert.Equal(t, "80", plan.ServicePort)
+	})
+
+	t.Run("injected", func(t *testing.T) {
+		plan := &rpc.InterceptPlan{}
+		ch := &agentChange{kind: "Deployment", agent: userd_intercept.AgentInjected, orig: orig, obj: orig, svc: origSvc}
+		require.NoError(t, describeAgentChange(plan, ch, "http"))
+		assert.False(t, plan.Rollout)
+		assert.Empty(t, plan.Changes)
+		assert.Equal(t, "hello-0", plan.Service)
+		assert.Equal(t, "http", plan.ServicePort)
+	})
+
+	t.Run("enable-injection", func(t *testing.T) {
+		plan := &rpc.InterceptPlan{}
+		ksvc := &kates.Unstructured{}
+		ksvc.SetName("hello")
+		ch := &agentChange{kind: "KnativeService", agent: userd_intercept.AgentEnableInjection, orig: ksvc, obj: ksvc}
+		require.NoError(t, describeAgentChange(plan, ch, ""))
+		assert.True(t, plan.Rollout)
+		assert.Equal(t, []string{"In KnativeService hello, enable injection of the traffic-agent into new revisions"}, plan.Changes)
+		assert.Empty(t, plan.Service)
+	})
+}
@@ -0,0 +1,59 @@
+package userd_trafficmgr
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/datawire/ambassador/pkg/kates"
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/install"
+)
+
+// restartedAtAnnotation is the pod template annotation that "kubectl rollout restart" sets
+const restartedAtAnnotation = "kubectl.kubernetes.io/restartedAt"
+
+// RestartWorkload restarts the pods of the given workload the way "kubectl rollout restart" does,
+// and waits until the rollout is done. The pods of a ReplicaSet, which has no rollout, are deleted
+// instead.
+func (tm *trafficManager) RestartWorkload(c context.Context, namespace, name string) error {
+	ns := tm.ActualNamespace(namespace)
+	if ns == "" {
+		return fmt.Errorf("namespace %q doesn't exist or isn't mapped", namespace)
+	}
+	obj, kind, err := tm.findWorkload(c, ns, name)
+	if err != nil {
+		return err
+	}
+	switch kind {
+	case "Deployment", "StatefulSet":
+		orig := obj.DeepCopyObject().(kates.Object)
+		if err = setRestartedAt(obj, time.Now()); err != nil {
+			return err
+		}
+		if err = tm.updateObject(c, orig, obj); err != nil {
+			return fmt.Errorf("unable to restart %s %s.%s: %w", kind, name, ns, err)
+		}
+	case "ReplicaSet":
+		// waitForAgentRollout deletes the pods of a ReplicaSet, because the waitForApply that it
+		// uses does
+	default:
+		return fmt.Errorf("unable to restart %s.%s: restarting a %s is not supported", name, ns, kind)
+	}
+	dlog.Infof(c, "Restarting %s %s.%s", kind, name, ns)
+	return tm.waitForAgentRollout(c, ns, name, obj)
+}
+
+// setRestartedAt sets the annotation of the pod template of the given workload that makes its
+// pods restart, like "kubectl rollout restart" does.
+func setRestartedAt(obj kates.Object, now time.Time) error {
+	tpl, err := install.GetPodTemplateFromObject(obj)
+	if err != nil {
+		return err
+	}
+	if tpl.Annotations == nil {
+		tpl.Annotations = make(map[string]string)
+	}
+	tpl.Annotations[restartedAtAnnotation] = now.Format(time.RFC3339)
+	return nil
+}
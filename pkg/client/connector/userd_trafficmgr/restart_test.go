@@ -0,0 +1,35 @@
+package userd_trafficmgr
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/ambassador/pkg/kates"
+)
+
+func TestSetRestartedAt(t *testing.T) {
+	now := time.Date(2021, 7, 1, 12, 0, 0, 0, time.UTC)
+	dep := &kates.Deployment{
+		TypeMeta:   kates.TypeMeta{Kind: "Deployment"},
+		ObjectMeta: kates.ObjectMeta{Name: "echo", Namespace: "default"},
+	}
+	require.NoError(t, setRestartedAt(dep, now))
+	assert.Equal(t, "2021-07-01T12:00:00Z", dep.Spec.Template.Annotations[restartedAtAnnotation])
+
+	sts := &kates.StatefulSet{
+		TypeMeta:   kates.TypeMeta{Kind: "StatefulSet"},
+		ObjectMeta: kates.ObjectMeta{Name: "db", Namespace: "default"},
+	}
+	sts.Spec.Template.Annotations = map[string]string{"other": "kept"}
+	require.NoError(t, setRestartedAt(sts, now.Add(time.Hour)))
+	assert.Equal(t, map[string]string{
+		"other":               "kept",
+		restartedAtAnnotation: "2021-07-01T13:00:00Z",
+	}, sts.Spec.Template.Annotations)
+
+	// The pods of the workload are changed, not the workload itself
+	assert.Empty(t, dep.Annotations)
+}
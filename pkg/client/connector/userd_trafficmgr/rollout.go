@@ -0,0 +1,187 @@
+package userd_trafficmgr
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/datawire/ambassador/pkg/kates"
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/install"
+)
+
+// rolloutPollInterval is how often the progress of a rollout that adds the agent is checked
+const rolloutPollInterval = 2 * time.Second
+
+// failedWaitingReasons are the reasons for a waiting container that won't go away by themselves
+var failedWaitingReasons = map[string]bool{
+	"CrashLoopBackOff":           true,
+	"ErrImagePull":               true,
+	"ImagePullBackOff":           true,
+	"InvalidImageName":           true,
+	"CreateContainerConfigError": true,
+	"CreateContainerError":       true,
+}
+
+// waitForAgentRollout is like waitForApply, but it also logs the progress of the rollout and stops
+// waiting as soon as the rollout can't succeed, e.g. because the pods with the agent crash or
+// their images can't be pulled, or because a deployment exceeded its progress deadline.
+func (ki *installer) waitForAgentRollout(c context.Context, namespace, name string, obj kates.Object) error {
+	c, cancel := context.WithCancel(c)
+	defer cancel()
+	failed := make(chan error, 1)
+	go func() {
+		ticker := time.NewTicker(rolloutPollInterval)
+		defer ticker.Stop()
+		progress := ""
+		for {
+			select {
+			case <-c.Done():
+				return
+			case <-ticker.C:
+			}
+			current, _, err := ki.findWorkload(c, namespace, name)
+			if err != nil {
+				continue
+			}
+			if p := rolloutProgress(current); p != progress {
+				progress = p
+				dlog.Infof(c, "Rollout of %s.%s: %s", name, namespace, p)
+			}
+			if err = ki.rolloutFailure(c, current); err != nil {
+				failed <- err
+				cancel()
+				return
+			}
+		}
+	}()
+	err := ki.waitForApply(c, namespace, name, obj)
+	if err == nil {
+		return nil
+	}
+	select {
+	case ferr := <-failed:
+		return ferr
+	default:
+		return err
+	}
+}
+
+// rolloutFailure returns an error that explains why the rollout of the given workload can't
+// succeed, or nil if it's still progressing or done.
+func (ki *installer) rolloutFailure(c context.Context, obj kates.Object) error {
+	switch w := obj.(type) {
+	case *kates.Deployment:
+		if err := deploymentFailure(w); err != nil {
+			return err
+		}
+	case *kates.Unstructured:
+		if w.GetKind() == "Rollout" {
+			if err := argoRolloutFailure(w); err != nil {
+				return err
+			}
+		}
+	}
+	podTemplate, err := install.GetPodTemplateFromObject(obj)
+	if err != nil {
+		return err
+	}
+	var pods []*kates.Pod
+	err = ki.Client().List(c, kates.Query{
+		Kind:          "Pod",
+		Namespace:     obj.GetNamespace(),
+		LabelSelector: labels.SelectorFromSet(podTemplate.Labels).String(),
+	}, &pods)
+	if err != nil {
+		return nil
+	}
+	return agentPodFailure(pods)
+}
+
+// deploymentFailure returns an error when the deployment exceeded its progress deadline
+func deploymentFailure(dep *kates.Deployment) error {
+	for _, cond := range dep.Status.Conditions {
+		if cond.Type == appsv1.DeploymentProgressing && cond.Status == corev1.ConditionFalse && cond.Reason == "ProgressDeadlineExceeded" {
+			return fmt.Errorf("deployment %s.%s exceeded its progress deadline: %s", dep.Name, dep.Namespace, cond.Message)
+		}
+	}
+	return nil
+}
+
+// agentPodFailure returns an error when a container of a pod that has the traffic-agent is stuck
+// in a state that it won't recover from by itself.
+func agentPodFailure(pods []*kates.Pod) error {
+	for _, pod := range pods {
+		hasAgent := false
+		for i := range pod.Spec.Containers {
+			if pod.Spec.Containers[i].Name == install.AgentContainerName {
+				hasAgent = true
+				break
+			}
+		}
+		if !hasAgent {
+			continue
+		}
+		for _, cs := range pod.Status.ContainerStatuses {
+			if w := cs.State.Waiting; w != nil && failedWaitingReasons[w.Reason] {
+				msg := w.Reason
+				if w.Message != "" {
+					msg += ": " + w.Message
+				}
+				return fmt.Errorf("container %s of pod %s.%s: %s", cs.Name, pod.Name, pod.Namespace, msg)
+			}
+		}
+	}
+	return nil
+}
+
+// rolloutProgress returns a short description of how far the rollout of the workload has come
+func rolloutProgress(obj kates.Object) string {
+	switch w := obj.(type) {
+	case *kates.Deployment:
+		return fmt.Sprintf("%d updated, %d available, of %d replicas", w.Status.UpdatedReplicas, w.Status.AvailableReplicas, w.Status.Replicas)
+	case *kates.StatefulSet:
+		return fmt.Sprintf("%d updated, %d ready, of %d replicas", w.Status.UpdatedReplicas, w.Status.ReadyReplicas, w.Status.Replicas)
+	case *kates.ReplicaSet:
+		return fmt.Sprintf("%d available of %d replicas", w.Status.AvailableReplicas, w.Status.Replicas)
+	case *kates.Unstructured:
+		if w.GetKind() == "Rollout" {
+			return argoRolloutProgress(w)
+		}
+		return ""
+	default:
+		return ""
+	}
+}
+
+// revertAgentUpgrade sets the image of the traffic-agent in the workload of the given upgrade back
+// to the image that it was upgraded from. The agent worked with that image, so it's kept rather
+// than removed.
+func (ki *installer) revertAgentUpgrade(c context.Context, ch *agentChange) error {
+	orig := ch.obj.DeepCopyObject().(kates.Object)
+	if err := setAgentImage(ch.obj, ch.oldImage); err != nil {
+		return err
+	}
+	return ki.updateObject(c, orig, ch.obj)
+}
+
+// setAgentImage sets the image of the traffic-agent container in the pod template of the given
+// workload.
+func setAgentImage(obj kates.Object, image string) error {
+	podTemplate, err := install.GetPodTemplateFromObject(obj)
+	if err != nil {
+		return err
+	}
+	cns := podTemplate.Spec.Containers
+	for i := range cns {
+		if cns[i].Name == install.AgentContainerName {
+			cns[i].Image = image
+			return nil
+		}
+	}
+	return install.ObjErrorf(obj, "has no %s container", install.AgentContainerName)
+}
@@ -0,0 +1,78 @@
+package userd_trafficmgr
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/datawire/ambassador/pkg/kates"
+	"github.com/telepresenceio/telepresence/v2/pkg/install"
+)
+
+func TestDeploymentFailure(t *testing.T) {
+	dep := &kates.Deployment{ObjectMeta: kates.ObjectMeta{Name: "echo", Namespace: "default"}}
+	dep.Status.Conditions = []appsv1.DeploymentCondition{{
+		Type:   appsv1.DeploymentProgressing,
+		Status: corev1.ConditionTrue,
+		Reason: "ReplicaSetUpdated",
+	}}
+	assert.NoError(t, deploymentFailure(dep))
+
+	dep.Status.Conditions[0].Status = corev1.ConditionFalse
+	dep.Status.Conditions[0].Reason = "ProgressDeadlineExceeded"
+	dep.Status.Conditions[0].Message = `ReplicaSet "echo-123" has timed out progressing.`
+	err := deploymentFailure(dep)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "has timed out progressing")
+}
+
+func TestAgentPodFailure(t *testing.T) {
+	pod := func(name string, withAgent bool, waitingReason string) *kates.Pod {
+		p := &kates.Pod{ObjectMeta: kates.ObjectMeta{Name: name, Namespace: "default"}}
+		p.Spec.Containers = []corev1.Container{{Name: "echo"}}
+		statuses := []corev1.ContainerStatus{{Name: "echo", State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}}}
+		if withAgent {
+			p.Spec.Containers = append(p.Spec.Containers, corev1.Container{Name: install.AgentContainerName})
+			statuses = append(statuses, corev1.ContainerStatus{
+				Name:  install.AgentContainerName,
+				State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: waitingReason, Message: "back-off"}},
+			})
+		}
+		p.Status.ContainerStatuses = statuses
+		return p
+	}
+
+	// Pods that are still starting, or that don't have the agent, are fine
+	assert.NoError(t, agentPodFailure([]*kates.Pod{
+		pod("old", false, ""),
+		pod("new", true, "ContainerCreating"),
+	}))
+
+	err := agentPodFailure([]*kates.Pod{
+		pod("old", false, ""),
+		pod("new", true, "ImagePullBackOff"),
+	})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "pod new.default")
+	assert.Contains(t, err.Error(), "ImagePullBackOff: back-off")
+}
+
+func TestSetAgentImage(t *testing.T) {
+	dep := &kates.Deployment{
+		TypeMeta:   kates.TypeMeta{Kind: "Deployment"},
+		ObjectMeta: kates.ObjectMeta{Name: "echo", Namespace: "default"},
+	}
+	dep.Spec.Template.Spec.Containers = []corev1.Container{
+		{Name: "echo", Image: "echo:1"},
+		{Name: install.AgentContainerName, Image: "tel2:2.3.6"},
+	}
+	require.NoError(t, setAgentImage(dep, "tel2:2.3.5"))
+	assert.Equal(t, "echo:1", dep.Spec.Template.Spec.Containers[0].Image)
+	assert.Equal(t, "tel2:2.3.5", dep.Spec.Template.Spec.Containers[1].Image)
+
+	dep.Spec.Template.Spec.Containers = dep.Spec.Template.Spec.Containers[:1]
+	assert.Error(t, setAgentImage(dep, "tel2:2.3.5"))
+}
@@ -0,0 +1,42 @@
+package userd_trafficmgr
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/cache"
+)
+
+// saveSessionState saves a snapshot of the session and its intercepts to the user cache, so that
+// the CLI can report on the session even when it can't reach this process. The snapshot is marked
+// as ended when the session has ended.
+func (tm *trafficManager) saveSessionState(ctx context.Context, ended bool) {
+	ss := &cache.SessionState{
+		PID:            os.Getpid(),
+		SessionID:      tm.session().SessionId,
+		ClusterID:      tm.clusterID,
+		ClusterServer:  tm.Config.Server,
+		ClusterContext: tm.Config.Context,
+		Connected:      tm.connectedAt,
+		Updated:        time.Now(),
+		Ended:          ended,
+	}
+	if !ended {
+		for _, ii := range tm.CurrentIntercepts() {
+			ss.Intercepts = append(ss.Intercepts, cache.InterceptState{
+				Name:      ii.Spec.Name,
+				Namespace: ii.Spec.Namespace,
+				Workload:  ii.Spec.Agent,
+				Port:      ii.Spec.ServicePortIdentifier,
+
+				MountPoint: ii.Spec.MountPoint,
+				Mount:      tm.getMountHealth(ii.Spec.MountPoint),
+			})
+		}
+	}
+	if err := cache.SaveSessionToUserCache(ctx, ss); err != nil {
+		dlog.Warnf(ctx, "unable to save the session state: %v", err)
+	}
+}
@@ -0,0 +1,33 @@
+package userd_trafficmgr
+
+import (
+	"context"
+
+	empty "google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+)
+
+// adminContext returns a context that passes the identity of the user to the traffic-manager, which
+// only lets admins manage the sessions of other clients.
+func (tm *trafficManager) adminContext(ctx context.Context) context.Context {
+	return tm.withIdentity(ctx)
+}
+
+// ListSessions returns the client sessions of the traffic-manager.
+func (tm *trafficManager) ListSessions(ctx context.Context) (*manager.ClientSessions, error) {
+	if _, err := tm.GetClientBlocking(ctx); err != nil {
+		return nil, err
+	}
+	return manager.NewSessionsClient(tm.managerConn).ListSessions(tm.adminContext(ctx), &empty.Empty{})
+}
+
+// KickSession removes the client session with the given ID, along with its intercepts, from the
+// traffic-manager.
+func (tm *trafficManager) KickSession(ctx context.Context, sessionID string) error {
+	if _, err := tm.GetClientBlocking(ctx); err != nil {
+		return err
+	}
+	_, err := manager.NewSessionsClient(tm.managerConn).KickSession(tm.adminContext(ctx), &manager.SessionInfo{SessionId: sessionID})
+	return err
+}
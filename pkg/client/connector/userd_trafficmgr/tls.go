@@ -0,0 +1,83 @@
+package userd_trafficmgr
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/datawire/ambassador/pkg/kates"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/install"
+)
+
+// mtlsConfig returns the TLS config of a connection to the traffic-manager that uses mutual TLS.
+// The client certificate and the CA used to verify the traffic-manager are read from the secret
+// named install.ManagerClientTLSName in the traffic-manager namespace.
+func (tm *trafficManager) mtlsConfig(c context.Context) (*tls.Config, error) {
+	ns := tm.GetManagerNamespace()
+	sec := &kates.Secret{
+		TypeMeta:   kates.TypeMeta{Kind: "Secret"},
+		ObjectMeta: kates.ObjectMeta{Name: install.ManagerClientTLSName, Namespace: ns},
+	}
+	if err := tm.Client().Get(c, sec, sec); err != nil {
+		return nil, fmt.Errorf("unable to get secret %s.%s: %w", install.ManagerClientTLSName, ns, err)
+	}
+	cert, err := tls.X509KeyPair(sec.Data["tls.crt"], sec.Data["tls.key"])
+	if err != nil {
+		return nil, fmt.Errorf("invalid key pair in secret %s.%s: %w", install.ManagerClientTLSName, ns, err)
+	}
+	caPool := x509.NewCertPool()
+	if !caPool.AppendCertsFromPEM(sec.Data["ca.crt"]) {
+		return nil, fmt.Errorf("no valid CA certificate found in secret %s.%s", install.ManagerClientTLSName, ns)
+	}
+	policy, err := client.GetConfig(c).TLS.Policy()
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      caPool,
+		ServerName:   install.ManagerAppName + "." + ns,
+	}
+	policy.Apply(cfg)
+	return cfg, nil
+}
+
+// endpointTLSConfig returns the TLS config of a connection to the given endpoint. The certificate of the endpoint is verified using the tls.endpointCA of the config, or
+// the system's CA certificates, and the tls.clientCert of the config is presented to endpoints that
+// require mutual TLS.
+func endpointTLSConfig(c context.Context, endpoint string) (*tls.Config, error) {
+	host, _, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	tlsCfg := &client.GetConfig(c).TLS
+	policy, err := tlsCfg.Policy()
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{ServerName: host}
+	if err = applyEndpointTLSConfig(c, tlsCfg, cfg); err != nil {
+		return nil, err
+	}
+	policy.Apply(cfg)
+	return cfg, nil
+}
+
+// errPlaintextEndpoint is returned when a manager endpoint would expose the API of the
+// traffic-manager without authenticating the client.
+var errPlaintextEndpoint = errors.New("the manager.endpoint requires mutual TLS: enable tls.managerMTLS, " +
+	"or set manager.endpoint-tls and a tls.clientCert")
+
+// checkEndpointAuth returns errPlaintextEndpoint unless the connection to a manager endpoint uses
+// TLS and presents a client certificate. The API of the traffic-manager has no other
+// authentication, so a plaintext endpoint would let anyone that can reach it use the cluster.
+func checkEndpointAuth(cfg *client.TLS, endpointTLS bool) error {
+	if cfg.ManagerMTLS || endpointTLS && cfg.ClientCert != "" {
+		return nil
+	}
+	return errPlaintextEndpoint
+}
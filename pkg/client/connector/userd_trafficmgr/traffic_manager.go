@@ -2,20 +2,29 @@ package userd_trafficmgr
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"net"
+	"net/http"
 	"os"
 	"os/user"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/telepresenceio/telepresence/v2/pkg/iputil"
 
 	"github.com/pkg/errors"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/durationpb"
 	empty "google.golang.org/protobuf/types/known/emptypb"
 	errors2 "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 
 	"github.com/datawire/ambassador/pkg/kates"
 	"github.com/datawire/dlib/dcontext"
@@ -27,14 +36,20 @@ import (
 	"github.com/telepresenceio/telepresence/v2/pkg/client"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/actions"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/connector/userd_k8s"
+	"github.com/telepresenceio/telepresence/v2/pkg/compress"
 	"github.com/telepresenceio/telepresence/v2/pkg/dnet"
 	"github.com/telepresenceio/telepresence/v2/pkg/install"
+	"github.com/telepresenceio/telepresence/v2/pkg/tlspolicy"
+	"github.com/telepresenceio/telepresence/v2/pkg/tracing"
 )
 
+// SetOutboundInfoFunc is the signature of daemon.DaemonClient.SetOutboundInfo
+type SetOutboundInfoFunc func(ctx context.Context, in *daemon.OutboundInfo, opts ...grpc.CallOption) (*empty.Empty, error)
+
 type Callbacks struct {
 	GetAPIKey       func(context.Context, string, bool) (string, error)
 	SetClient       func(client manager.ManagerClient, callOptions ...grpc.CallOption)
-	SetOutboundInfo func(ctx context.Context, in *daemon.OutboundInfo, opts ...grpc.CallOption) (*empty.Empty, error)
+	SetOutboundInfo SetOutboundInfoFunc
 }
 
 // trafficManager is a handle to access the Traffic Manager in a
@@ -49,6 +64,7 @@ type trafficManager struct {
 	userAndHost string // "laptop-username@laptop-hostname"
 
 	// manager client
+	managerConn   *grpc.ClientConn
 	managerClient manager.ManagerClient
 	managerErr    error         // if managerClient is nil, why it's nil
 	startup       chan struct{} // gets closed when managerClient is fully initialized (or managerErr is set)
@@ -57,10 +73,29 @@ type trafficManager struct {
 	// until .startup is closed, and it isn't safe to mutate them after .startup is closed.
 
 	sessionInfo *manager.SessionInfo // sessionInfo returned by the traffic-manager
+	clusterID   string               // ID of the cluster, recorded in the session state
+	connectedAt time.Time            // when the session was established
+
+	// wantsIdentity is 1 when the traffic-manager uses the identity of the clients, so that the
+	// bearer token of the kubeconfig may be passed to it. It's accessed using sync/atomic.
+	wantsIdentity int32
+
+	// degraded holds the reason, as a string, why the connection to the traffic-manager
+	// currently doesn't work, or an empty string when it works.
+	degraded atomic.Value
+
+	// breaker limits how often the streams to the traffic-manager are re-established
+	breaker *client.Breaker
 
 	// Map of desired mount points for intercepts
 	mountPoints sync.Map
 
+	// Map of *cache.MountState keyed by mount point, updated by the mount workers
+	mountHealth sync.Map
+
+	// Map of *mount.Mirror keyed by mount point
+	mirrors sync.Map
+
 	// currentIntercepts is the latest snapshot returned by the intercept watcher
 	currentIntercepts     []*manager.InterceptInfo
 	currentInterceptsLock sync.Mutex
@@ -77,7 +112,7 @@ type interceptResult struct {
 
 // New returns a TrafficManager resource for the given cluster if it has a Traffic Manager service.
 func New(
-	_ context.Context,
+	c context.Context,
 	env client.Env,
 	cluster *userd_k8s.Cluster,
 	installID string,
@@ -97,6 +132,7 @@ func New(
 	if err != nil {
 		return nil, errors.Wrap(err, "new installer")
 	}
+	ti.installID = installID
 	tm := &trafficManager{
 		installer:   ti,
 		env:         env,
@@ -104,11 +140,41 @@ func New(
 		startup:     make(chan struct{}),
 		userAndHost: fmt.Sprintf("%s@%s", userinfo.Username, host),
 		callbacks:   callbacks,
+		breaker:     client.NewBreaker(c, "the traffic-manager"),
 	}
 
 	return tm, nil
 }
 
+// dialQUIC dials the traffic-manager through a QUIC tunnel to the given endpoint, using mutual TLS
+// inside the tunnel just like a WebSocket tunnel does. It returns nil when QUIC can't be used, or
+// when the tunnel can't be opened, e.g. because a firewall blocks UDP, so that the caller can dial
+// the traffic-manager the usual way. Once connected, gRPC opens a new tunnel when the tunnel is
+// lost, e.g. because the laptop changed networks.
+func dialQUIC(c context.Context, endpoint string, mtlsConfig *tls.Config, opts []grpc.DialOption) *grpc.ClientConn {
+	clientConfig := client.GetConfig(c)
+	switch {
+	case mtlsConfig == nil:
+		dlog.Infof(c, "Not using the manager.quic-endpoint %s because it requires tls.managerMTLS", endpoint)
+		return nil
+	case clientConfig.TLS.FIPS || tlspolicy.FIPSBuild:
+		dlog.Infof(c, "Not using the manager.quic-endpoint %s in FIPS mode", endpoint)
+		return nil
+	}
+	dlog.Infof(c, "Connecting to the traffic-manager at %s using QUIC", endpoint)
+	tc, cancel := clientConfig.Timeouts.TimeoutContext(c, client.TimeoutTrafficManagerAPI)
+	defer cancel()
+	conn, err := grpc.DialContext(tc, endpoint, append(opts,
+		grpc.FailOnNonTempDialError(true),
+		grpc.WithContextDialer(dnet.NewQUICTunnelDialer(mtlsConfig)),
+		grpc.WithTransportCredentials(credentials.NewTLS(mtlsConfig)))...)
+	if err != nil {
+		dlog.Infof(c, "Connecting to the traffic-manager at %s using QUIC failed: %v", endpoint, err)
+		return nil
+	}
+	return conn
+}
+
 func (tm *trafficManager) Run(c context.Context) error {
 	err := tm.ensureManager(c, &tm.env)
 	if err != nil {
@@ -116,17 +182,41 @@ func (tm *trafficManager) Run(c context.Context) error {
 		close(tm.startup)
 		return err
 	}
-
-	grpcDialer, err := dnet.NewK8sPortForwardDialer(tm.ConfigFlags, tm.Client())
-	if err != nil {
-		return err
-	}
-	grpcAddr := net.JoinHostPort(
-		"svc/traffic-manager."+tm.GetManagerNamespace(),
-		fmt.Sprint(install.ManagerPortHTTP))
+	tm.resumeAutoscalers(c)
 
 	// First check. Establish connection
 	clientConfig := client.GetConfig(c)
+	endpoint, endpointTLS := tm.GetManagerEndpoint()
+	var grpcDialer func(context.Context, string) (net.Conn, error)
+	var grpcAddr string
+	var proxyPolicy *tlspolicy.Policy
+	if endpoint != "" {
+		if err = checkEndpointAuth(&clientConfig.TLS, endpointTLS); err != nil {
+			tm.managerErr = err
+			close(tm.startup)
+			return err
+		}
+		dlog.Infof(c, "Connecting to the traffic-manager at %s", endpoint)
+		grpcAddr = endpoint
+		// Falls back to a CONNECT tunnel through the HTTPS proxy of the environment when a
+		// firewall blocks the direct connection.
+		if proxyPolicy, err = clientConfig.TLS.Policy(); err != nil {
+			return err
+		}
+		grpcDialer = dnet.NewProxyFallbackDialer(http.ProxyFromEnvironment, proxyPolicy)
+	} else {
+		if grpcDialer, err = dnet.NewK8sPortForwardDialer(tm.ConfigFlags, tm.Client()); err != nil {
+			return err
+		}
+		port := install.ManagerPortHTTP
+		if clientConfig.TLS.ManagerMTLS {
+			port = install.ManagerPortHTTPS
+		}
+		grpcAddr = net.JoinHostPort(
+			"svc/traffic-manager."+tm.GetManagerNamespace(),
+			fmt.Sprint(port))
+	}
+
 	tos := &clientConfig.Timeouts
 	tc, cancel := tos.TimeoutContext(c, client.TimeoutTrafficManagerAPI)
 	defer cancel()
@@ -147,50 +237,113 @@ func (tm *trafficManager) Run(c context.Context) error {
 		}
 	}()
 
-	opts := []grpc.DialOption{grpc.WithContextDialer(grpcDialer),
-		grpc.WithInsecure(),
-		grpc.WithNoProxy(),
-		grpc.WithBlock()}
+	var mtlsConfig, endpointConfig *tls.Config
+	credOpt := grpc.WithInsecure()
+	if clientConfig.TLS.ManagerMTLS {
+		if mtlsConfig, err = tm.mtlsConfig(tc); err != nil {
+			return err
+		}
+		credOpt = grpc.WithTransportCredentials(credentials.NewTLS(mtlsConfig))
+	} else if endpointTLS {
+		if endpointConfig, err = endpointTLSConfig(tc, endpoint); err != nil {
+			return err
+		}
+		credOpt = grpc.WithTransportCredentials(credentials.NewTLS(endpointConfig))
+	}
 
-	if mxRecvSize := clientConfig.Grpc.MaxReceiveSize; mxRecvSize != nil {
-		if mz, ok := mxRecvSize.AsInt64(); ok {
-			opts = append(opts, grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(int(mz))))
+	opts := []grpc.DialOption{grpc.WithNoProxy(), grpc.WithBlock()}
+	opts = append(opts, clientConfig.Grpc.DialOptions()...)
+	opts = append(opts, tracing.DialOptions()...)
+	opts = append(opts, tm.identityDialOptions()...)
+	if quicEndpoint := tm.GetManagerQUICEndpoint(); quicEndpoint != "" {
+		conn = dialQUIC(c, quicEndpoint, mtlsConfig, opts)
+	}
+	if conn == nil {
+		conn, err = grpc.DialContext(tc, grpcAddr, append(opts, grpc.WithContextDialer(grpcDialer), credOpt)...)
+	}
+	if err != nil && endpoint != "" && c.Err() == nil {
+		// A proxy that refuses HTTP/2 may still let a WebSocket through. The WebSocket to an
+		// endpoint with TLS uses TLS, and so does the gRPC connection inside it when it uses
+		// mutual TLS.
+		dlog.Infof(c, "Connecting to the traffic-manager at %s failed: %v, trying a WebSocket tunnel", endpoint, err)
+		wsConfig := endpointConfig
+		wsCredOpt := grpc.WithInsecure()
+		if mtlsConfig != nil {
+			wsConfig = mtlsConfig
+			wsCredOpt = credOpt
 		}
+		wsDialer := dnet.NewWebSocketTunnelDialer(wsConfig, mtlsConfig != nil, http.ProxyFromEnvironment, proxyPolicy)
+		cancel()
+		tc, cancel = tos.TimeoutContext(c, client.TimeoutTrafficManagerAPI)
+		defer cancel()
+		conn, err = grpc.DialContext(tc, grpcAddr, append(opts, grpc.WithContextDialer(wsDialer), wsCredOpt)...)
 	}
-	conn, err = grpc.DialContext(tc, grpcAddr, opts...)
 	if err != nil {
 		return client.CheckTimeout(tc, fmt.Errorf("dial manager: %w", err))
 	}
 
 	mClient := manager.NewManagerClient(conn)
-	si, err := mClient.ArriveAsClient(tc, &manager.ClientInfo{
+	var header metadata.MD
+
+	// The bearer token of the kubeconfig is a credential of the cluster, so it's only passed to a
+	// traffic-manager that says that it uses the identity of the clients
+	if vi, err := mClient.Version(tc, &empty.Empty{}); err == nil && vi.WantsIdentity {
+		atomic.StoreInt32(&tm.wantsIdentity, 1)
+	}
+
+	// The traffic-manager records the identity of the user so that admins can tell who holds a
+	// session, and refuses the user when it requires clients to authenticate and can't verify it
+	ac := tm.withIdentity(tc)
+	si, err := mClient.ArriveAsClient(ac, &manager.ClientInfo{
 		Name:      tm.userAndHost,
 		InstallId: tm.installID,
 		Product:   "telepresence",
 		Version:   client.Version(),
 		ApiKey:    func() string { tok, _ := tm.callbacks.GetAPIKey(c, "manager", false); return tok }(),
-	})
+
+		// The cluster may only call back to the ports that the user lets it
+		CallbackPorts: callbackPorts(clientConfig.Cluster.CallbackPorts),
+	}, grpc.Header(&header))
 	if err != nil {
 		return client.CheckTimeout(tc, fmt.Errorf("manager.ArriveAsClient: %w", err))
 	}
+	tm.managerConn = conn
 	tm.managerClient = mClient
 	tm.sessionInfo = si
 
+	var callOptions []grpc.CallOption
+	if clientConfig.Grpc.TunnelCompression {
+		if compress.Accepted(header) {
+			dlog.Info(c, "Compressing the traffic to the traffic-manager")
+			callOptions = append(callOptions, grpc.UseCompressor(compress.Name))
+		} else {
+			dlog.Info(c, "Not compressing the traffic to the traffic-manager because it doesn't support compression")
+		}
+	}
+
 	// Gotta call mgrProxy.SetClient before we call daemon.SetOutboundInfo which tells the
 	// daemon to use the proxy.
-	tm.callbacks.SetClient(tm.managerClient)
+	tm.callbacks.SetClient(tm.managerClient, callOptions...)
 
 	// Tell daemon what it needs to know in order to establish outbound traffic to the cluster
 	if _, err := tm.callbacks.SetOutboundInfo(c, tm.getOutboundInfo()); err != nil {
+		tm.managerConn = nil
 		tm.managerClient = nil
 		tm.callbacks.SetClient(nil)
 		return fmt.Errorf("daemon.SetOutboundInfo: %w", err)
 	}
 
+	tm.clusterID = tm.GetClusterId(c)
+	tm.connectedAt = time.Now()
 	close(tm.startup)
+	tm.saveSessionState(c, false)
 
 	g := dgroup.NewGroup(c, dgroup.GroupConfig{})
 	g.Go("remain", tm.remain)
+	g.Go("watch-connection", func(c context.Context) error {
+		tm.watchConnection(c, conn)
+		return nil
+	})
 	g.Go("intercept-port-forward", tm.workerPortForwardIntercepts)
 	return g.Wait()
 }
@@ -199,6 +352,17 @@ func (tm *trafficManager) session() *manager.SessionInfo {
 	return tm.sessionInfo
 }
 
+// SessionInfo returns the session that was established with the traffic-manager, or nil if the
+// startup hasn't completed yet.
+func (tm *trafficManager) SessionInfo() *manager.SessionInfo {
+	select {
+	case <-tm.startup:
+		return tm.sessionInfo
+	default:
+		return nil
+	}
+}
+
 // hasOwner parses an object and determines whether the object has an
 // owner that is of a kind we prefer. Currently the only owner that we
 // prefer is a Deployment, but this may grow in the future
@@ -219,7 +383,7 @@ func (tm *trafficManager) getObjectAndLabels(ctx context.Context, objectKind, na
 	var reason string
 	switch objectKind {
 	case "Deployment":
-		dep, err := tm.FindDeployment(ctx, namespace, name)
+		dep, err := tm.LookupDeployment(ctx, namespace, name)
 		if err != nil {
 			// Removed from snapshot since the name slice was obtained
 			if !errors2.IsNotFound(err) {
@@ -236,7 +400,7 @@ func (tm *trafficManager) getObjectAndLabels(ctx context.Context, objectKind, na
 		labels = dep.Spec.Template.Labels
 
 	case "ReplicaSet":
-		rs, err := tm.FindReplicaSet(ctx, namespace, name)
+		rs, err := tm.LookupReplicaSet(ctx, namespace, name)
 		if err != nil {
 			// Removed from snapshot since the name slice was obtained
 			if !errors2.IsNotFound(err) {
@@ -252,7 +416,7 @@ func (tm *trafficManager) getObjectAndLabels(ctx context.Context, objectKind, na
 		labels = rs.Spec.Template.Labels
 
 	case "StatefulSet":
-		statefulSet, err := tm.FindStatefulSet(ctx, namespace, name)
+		statefulSet, err := tm.LookupStatefulSet(ctx, namespace, name)
 		if err != nil {
 			// Removed from snapshot since the name slice was obtained
 			if !errors2.IsNotFound(err) {
@@ -266,9 +430,66 @@ func (tm *trafficManager) getObjectAndLabels(ctx context.Context, objectKind, na
 		}
 		object = statefulSet
 		labels = statefulSet.Spec.Template.Labels
+
+	case "DeploymentConfig":
+		dc, err := tm.FindDeploymentConfig(ctx, namespace, name)
+		if err != nil {
+			// Removed from snapshot since the name slice was obtained
+			if !errors2.IsNotFound(err) {
+				dlog.Error(ctx, err)
+			}
+			return nil, nil, "", err
+		}
+		tpl, err := install.GetPodTemplateFromObject(dc)
+		if err != nil {
+			return nil, nil, "", err
+		}
+		if replicas, _, _ := unstructured.NestedInt64(dc.Object, "status", "replicas"); replicas == 0 {
+			reason = "Has 0 replicas"
+		}
+		object = dc
+		labels = tpl.Labels
+	case "Rollout":
+		ro, err := tm.FindArgoRollout(ctx, namespace, name)
+		if err != nil {
+			// Removed from snapshot since the name slice was obtained
+			if !errors2.IsNotFound(err) {
+				dlog.Error(ctx, err)
+			}
+			return nil, nil, "", err
+		}
+		tpl, err := install.GetPodTemplateFromObject(ro)
+		if err != nil {
+			return nil, nil, "", err
+		}
+		if _, ok, _ := unstructured.NestedMap(ro.Object, "spec", "workloadRef"); ok {
+			reason = "Argo Rollout that references the pod template of another workload"
+		} else if replicas, _, _ := unstructured.NestedInt64(ro.Object, "status", "replicas"); replicas == 0 {
+			reason = "Has 0 replicas"
+		}
+		object = ro
+		labels = tpl.Labels
+	case "KnativeService":
+		ksvc, err := tm.FindKnativeService(ctx, namespace, name)
+		if err != nil {
+			// Removed from snapshot since the name slice was obtained
+			if !errors2.IsNotFound(err) {
+				dlog.Error(ctx, err)
+			}
+			return nil, nil, "", err
+		}
+		if !knativeRoutesToLatestRevision(ksvc) {
+			reason = "Knative Service that doesn't route traffic to its latest revision"
+		}
+		// The services of a Knative Service select the pods of its revisions by the revision
+		// labels, so there are no labels to match them with.
+		object = ksvc
 	default:
 		reason = "No workload telepresence knows how to intercept"
 	}
+	if reason == "" && install.InterceptsForbidden(object) {
+		reason = "Intercepts forbidden by the " + install.InterceptAnnotation + " annotation"
+	}
 	return object, labels, reason, nil
 }
 
@@ -309,12 +530,14 @@ func (tm *trafficManager) getInfosForWorkload(
 					continue
 				}
 
-				matchingSvcs, err := install.FindMatchingServices(ctx, tm.Client(), "", "", namespace, labels)
-				if err != nil {
-					continue
-				}
-				if len(matchingSvcs) == 0 {
-					reason = "No service with matching selector"
+				if objectKind != "KnativeService" {
+					matchingSvcs, err := tm.MatchingServices(ctx, namespace, labels)
+					if err != nil {
+						continue
+					}
+					if len(matchingSvcs) == 0 {
+						reason = "No service with matching selector"
+					}
 				}
 			}
 
@@ -347,7 +570,7 @@ func (tm *trafficManager) WorkloadInfoSnapshot(ctx context.Context, rq *rpc.List
 	}
 
 	<-tm.startup
-	is := tm.getCurrentIntercepts()
+	is := tm.CurrentIntercepts()
 	iMap = make(map[string]*manager.InterceptInfo, len(is))
 	for _, i := range is {
 		if i.Spec.Namespace == namespace {
@@ -372,9 +595,12 @@ func (tm *trafficManager) WorkloadInfoSnapshot(ctx context.Context, rq *rpc.List
 	// These are all the workloads we care about and their associated function
 	// to get the names of those workloads
 	workloadsToGet := map[string]func(context.Context, string) ([]string, error){
-		"Deployment":  tm.DeploymentNames,
-		"ReplicaSet":  tm.ReplicaSetNames,
-		"StatefulSet": tm.StatefulSetNames,
+		"Deployment":       tm.DeploymentNames,
+		"ReplicaSet":       tm.ReplicaSetNames,
+		"StatefulSet":      tm.StatefulSetNames,
+		"DeploymentConfig": tm.DeploymentConfigNames,
+		"Rollout":          tm.ArgoRolloutNames,
+		"KnativeService":   tm.KnativeServiceNames,
 	}
 
 	for workloadKind, namesFunc := range workloadsToGet {
@@ -401,15 +627,45 @@ func (tm *trafficManager) WorkloadInfoSnapshot(ctx context.Context, rq *rpc.List
 	return &rpc.WorkloadInfoSnapshot{Workloads: workloadInfos}
 }
 
+// watchConnection keeps track of whether the connection to the traffic-manager works, so that the
+// status can tell when it's reconnecting.
+func (tm *trafficManager) watchConnection(c context.Context, conn *grpc.ClientConn) {
+	for {
+		state := conn.GetState()
+		switch state {
+		case connectivity.Ready:
+			tm.setDegraded("")
+		case connectivity.Connecting, connectivity.TransientFailure:
+			tm.setDegraded("reconnecting to the traffic-manager")
+		}
+		if !conn.WaitForStateChange(c, state) {
+			return
+		}
+		dlog.Debugf(c, "Connection to the traffic-manager is %s", conn.GetState())
+	}
+}
+
+func (tm *trafficManager) setDegraded(reason string) {
+	tm.degraded.Store(reason)
+}
+
+func (tm *trafficManager) degradedReason() string {
+	reason, _ := tm.degraded.Load().(string)
+	return reason
+}
+
 func (tm *trafficManager) remain(c context.Context) error {
 	<-tm.startup
 	ticker := time.NewTicker(5 * time.Second)
 	defer ticker.Stop()
+	threshold := client.GetConfig(c).Grpc.SessionFailureThreshold
+	failures := 0
 	for {
 		select {
 		case <-c.Done():
 			_ = tm.clearIntercepts(dcontext.WithoutCancel(c))
 			_, _ = tm.managerClient.Depart(dcontext.WithoutCancel(c), tm.session())
+			tm.saveSessionState(dcontext.WithoutCancel(c), true)
 			return nil
 		case <-ticker.C:
 			_, err := tm.managerClient.Remain(c, &manager.RemainRequest{
@@ -418,10 +674,31 @@ func (tm *trafficManager) remain(c context.Context) error {
 			})
 			if err != nil {
 				if c.Err() != nil {
-					err = nil
+					return nil
+				}
+				if status.Code(err) == codes.NotFound {
+					return err
+				}
+				if reason := tm.UnreachableReason(); reason != "" {
+					// The session can't be kept alive while the API server is unreachable, because
+					// the connection to the traffic-manager goes through it. Giving up on the session
+					// would end the intercepts for good, so it's kept until the traffic-manager says
+					// that it's gone.
+					dlog.Warnf(c, "Unable to keep the session alive: %v", err)
+					tm.setDegraded(reason)
+					continue
 				}
-				return err
+				failures++
+				if failures >= threshold {
+					return err
+				}
+				dlog.Warnf(c, "Unable to keep the session alive (attempt %d of %d): %v", failures, threshold, err)
+				tm.setDegraded(fmt.Sprintf("unable to keep the session alive: %v", err))
+				continue
 			}
+			// The session is alive, whatever made it degraded
+			failures = 0
+			tm.setDegraded("")
 		}
 	}
 }
@@ -441,9 +718,25 @@ func (tm *trafficManager) SetStatus(ctx context.Context, r *rpc.ConnectInfo) {
 	} else {
 		agents, _ := actions.ListAllAgents(ctx, tm.managerClient, tm.session().SessionId)
 		r.Agents = &manager.AgentInfoSnapshot{Agents: agents}
-		r.Intercepts = &manager.InterceptInfoSnapshot{Intercepts: tm.getCurrentIntercepts()}
+		r.Intercepts = &manager.InterceptInfoSnapshot{Intercepts: tm.CurrentIntercepts()}
 		r.SessionInfo = tm.session()
-		r.BridgeOk = true
+		if reason := tm.degradedReason(); reason != "" {
+			r.BridgeOk = false
+			r.ErrorText = reason
+		} else if reason = tm.breaker.OpenReason(); reason != "" {
+			r.BridgeOk = false
+			r.ErrorText = reason
+		} else if reason = tm.UnreachableReason(); reason != "" {
+			// The tunnel and the intercepts still work, but the cluster state may be outdated
+			r.BridgeOk = false
+			r.ErrorText = reason
+		} else if reason = tm.BreakerReason(); reason != "" {
+			// The cluster state may be outdated until the watches are restarted
+			r.BridgeOk = false
+			r.ErrorText = reason
+		} else {
+			r.BridgeOk = true
+		}
 	}
 }
 
@@ -561,3 +854,13 @@ func (tm *trafficManager) GetClientNonBlocking() (manager.ManagerClient, error)
 		return nil, nil
 	}
 }
+
+// callbackPorts returns the given callback ports of the client configuration as they're passed
+// to the traffic-manager.
+func callbackPorts(ports []uint16) []int32 {
+	var ps []int32
+	for _, port := range ports {
+		ps = append(ps, int32(port))
+	}
+	return ps
+}
@@ -0,0 +1,65 @@
+package userd_workload
+
+import (
+	"context"
+
+	grpcCodes "google.golang.org/grpc/codes"
+	grpcStatus "google.golang.org/grpc/status"
+	empty "google.golang.org/protobuf/types/known/emptypb"
+
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/connector/sharedstate"
+)
+
+type workloads struct {
+	rpc.UnimplementedWorkloadsServer
+	sharedState *sharedstate.State
+}
+
+// NewWorkloadsServer returns a server that manages workloads using the Kubernetes context of the
+// user daemon, so that the CLI can do so in the middle of a session without the user having to
+// switch to kubectl and make sure that it uses the same context.
+func NewWorkloadsServer(sharedState *sharedstate.State) rpc.WorkloadsServer {
+	return &workloads{sharedState: sharedState}
+}
+
+func (ws *workloads) RestartWorkload(ctx context.Context, w *rpc.Workload) (*empty.Empty, error) {
+	if w.Name == "" {
+		return nil, grpcStatus.Error(grpcCodes.InvalidArgument, "a workload needs a name")
+	}
+	mgr, err := ws.sharedState.GetTrafficManagerBlocking(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if mgr == nil {
+		return nil, grpcStatus.Error(grpcCodes.FailedPrecondition, "telepresence: the userd is not connected to the manager")
+	}
+	if err = mgr.RestartWorkload(ctx, w.Namespace, w.Name); err != nil {
+		return nil, err
+	}
+	return &empty.Empty{}, nil
+}
+
+func (ws *workloads) InstallAgents(req *rpc.InstallAgentsRequest, stream rpc.Workloads_InstallAgentsServer) error {
+	if len(req.Names) == 0 {
+		return grpcStatus.Error(grpcCodes.InvalidArgument, "at least one workload must be named")
+	}
+	ctx := stream.Context()
+	mgr, err := ws.sharedState.GetTrafficManagerBlocking(ctx)
+	if err != nil {
+		return err
+	}
+	if mgr == nil {
+		return grpcStatus.Error(grpcCodes.FailedPrecondition, "telepresence: the userd is not connected to the manager")
+	}
+	var sendErr error
+	err = mgr.InstallAgents(ctx, req.Namespace, req.Names, req.AgentImage, func(p *rpc.AgentInstallProgress) {
+		if sendErr == nil {
+			sendErr = stream.Send(p)
+		}
+	})
+	if err == nil {
+		err = sendErr
+	}
+	return err
+}
@@ -0,0 +1,25 @@
+package userd_workload
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/connector"
+)
+
+func TestRestartWorkloadNeedsName(t *testing.T) {
+	// The name is checked before the traffic-manager is awaited, so no shared state is needed
+	ws := NewWorkloadsServer(nil)
+	_, err := ws.RestartWorkload(context.Background(), &rpc.Workload{Namespace: "default"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestInstallAgentsNeedsNames(t *testing.T) {
+	ws := NewWorkloadsServer(nil)
+	err := ws.InstallAgents(&rpc.InstallAgentsRequest{Namespace: "default"}, nil)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
@@ -0,0 +1,72 @@
+package daemon
+
+import (
+	"context"
+	"sort"
+)
+
+// debugState is the internal state of the daemon that is served on the debug socket
+type debugState struct {
+	SessionID string        `json:"sessionID,omitempty"`
+	Subnets   []string      `json:"subnets"`
+	Tunnels   []string      `json:"tunnels"`
+	DNS       dnsDebugState `json:"dns"`
+}
+
+type dnsDebugState struct {
+	Namespaces []string `json:"namespaces"`
+	Domains    []string `json:"domains"`
+	Search     []string `json:"search"`
+	InProgress []string `json:"inProgress"`
+}
+
+func sortedKeys(m map[string]struct{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (d *service) debugState(_ context.Context) interface{} {
+	o := d.outbound
+	t := o.router
+	ds := &debugState{}
+
+	select {
+	case <-t.configured():
+		if t.session != nil {
+			ds.SessionID = t.session.SessionId
+		}
+	default:
+	}
+
+	routed := t.routedSubnets()
+	ds.Subnets = make([]string, len(routed))
+	for i, sn := range routed {
+		ds.Subnets[i] = sn.String()
+	}
+
+	ids := t.handlers.ConnIDs()
+	ds.Tunnels = make([]string, len(ids))
+	for i, id := range ids {
+		ds.Tunnels[i] = id.String()
+	}
+	sort.Strings(ds.Tunnels)
+
+	o.domainsLock.RLock()
+	ds.DNS.Namespaces = sortedKeys(o.namespaces)
+	ds.DNS.Domains = sortedKeys(o.domains)
+	ds.DNS.Search = append([]string(nil), o.search...)
+	o.domainsLock.RUnlock()
+
+	o.dnsQueriesLock.Lock()
+	ds.DNS.InProgress = make([]string, 0, len(o.dnsInProgress))
+	for query := range o.dnsInProgress {
+		ds.DNS.InProgress = append(ds.DNS.InProgress, query)
+	}
+	o.dnsQueriesLock.Unlock()
+	sort.Strings(ds.DNS.InProgress)
+	return ds
+}
@@ -4,7 +4,9 @@ import (
 	"context"
 	"net"
 	"strings"
+	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/miekg/dns"
 
@@ -15,13 +17,62 @@ import (
 
 type Resolver func(ctx context.Context, qType uint16, domain string) []net.IP
 
+// AddressOrder determines which addresses the Server answers with for a name that has both IPv4 and
+// IPv6 addresses. Clients that use "happy eyeballs" (RFC 8305) try IPv6 first, and only fall back to
+// IPv4 after a delay when the IPv6 address doesn't respond, so an answer with addresses that can't be
+// reached makes every new connection slow.
+type AddressOrder int
+
+const (
+	// BothFamilies answers A queries with the IPv4 addresses and AAAA queries with the IPv6 addresses
+	BothFamilies AddressOrder = iota
+
+	// IPv4First answers AAAA queries with no addresses when the name has IPv4 addresses
+	IPv4First
+
+	// IPv6First answers A queries with no addresses when the name has IPv6 addresses
+	IPv6First
+)
+
+// defaultTTL is the time to live of the answers unless SetAnswerPolicy says otherwise
+const defaultTTL = 60 * time.Second
+
+// maxConcurrentLookups is the maximum number of lookups that the Resolver of a Server will be asked
+// to perform concurrently
+const maxConcurrentLookups = 32
+
+// lookupKey identifies a question
+type lookupKey struct {
+	qType  uint16
+	domain string
+}
+
+// inflightLookup is a call to the Resolver that is in progress. The result is valid once done is closed.
+type inflightLookup struct {
+	done   chan struct{}
+	result []net.IP
+}
+
 // Server is a DNS server which implements the github.com/miekg/dns Handler interface
 type Server struct {
 	ctx          context.Context // necessary to make logging work in ServeDNS function
 	listeners    []net.PacketConn
 	fallback     *dns.Conn
+	fallbackLock sync.Mutex
 	resolve      Resolver
 	requestCount int64
+
+	// ttl is the time to live of the answers, in seconds
+	ttl uint32
+
+	// order returns the AddressOrder of the answers
+	order func() AddressOrder
+
+	// workers limits the number of concurrent calls to resolve
+	workers chan struct{}
+
+	lookupsLock sync.Mutex
+	lookups     map[lookupKey]*inflightLookup
 }
 
 // NewServer returns a new dns.Server
@@ -31,6 +82,22 @@ func NewServer(c context.Context, listeners []net.PacketConn, fallback *dns.Conn
 		listeners: listeners,
 		fallback:  fallback,
 		resolve:   resolve,
+		ttl:       uint32(defaultTTL / time.Second),
+		order:     func() AddressOrder { return BothFamilies },
+		workers:   make(chan struct{}, maxConcurrentLookups),
+		lookups:   make(map[lookupKey]*inflightLookup),
+	}
+}
+
+// SetAnswerPolicy sets the time to live of the answers of this server, and the function that it
+// calls to get the AddressOrder of an answer. The function is called for each answer, so that the
+// order can follow the subnets that are routed. It must be called before the server runs.
+func (s *Server) SetAnswerPolicy(ttl time.Duration, order func() AddressOrder) {
+	if ttl >= time.Second {
+		s.ttl = uint32(ttl / time.Second)
+	}
+	if order != nil {
+		s.order = order
 	}
 }
 
@@ -39,6 +106,56 @@ func (s *Server) RequestCount() int {
 	return int(atomic.LoadInt64(&s.requestCount))
 }
 
+// lookup calls the Resolver of this server and returns its result. Identical questions that arrive
+// while a call is in progress are coalesced and get the result of that call. Each request is served
+// by its own goroutine, but no more than maxConcurrentLookups calls are made at the same time.
+func (s *Server) lookup(c context.Context, qType uint16, domain string) []net.IP {
+	key := lookupKey{qType: qType, domain: domain}
+	s.lookupsLock.Lock()
+	l, inProgress := s.lookups[key]
+	if !inProgress {
+		l = &inflightLookup{done: make(chan struct{})}
+		s.lookups[key] = l
+	}
+	s.lookupsLock.Unlock()
+
+	if inProgress {
+		select {
+		case <-l.done:
+			return l.result
+		case <-c.Done():
+			return nil
+		}
+	}
+
+	defer func() {
+		s.lookupsLock.Lock()
+		delete(s.lookups, key)
+		s.lookupsLock.Unlock()
+		close(l.done)
+	}()
+
+	select {
+	case s.workers <- struct{}{}:
+	case <-c.Done():
+		return nil
+	}
+	defer func() { <-s.workers }()
+	l.result = s.resolve(c, qType, domain)
+	return l.result
+}
+
+// exchangeWithFallback sends the request to the fallback server and returns its reply. The
+// exchanges are serialized because they share one connection, and a concurrent read might
+// otherwise consume the reply to another request.
+func (s *Server) exchangeWithFallback(r *dns.Msg) (*dns.Msg, error) {
+	s.fallbackLock.Lock()
+	defer s.fallbackLock.Unlock()
+	client := dns.Client{Net: "udp"}
+	in, _, err := client.ExchangeWithConn(r, s.fallback)
+	return in, err
+}
+
 // ServeDNS is an implementation of github.com/miekg/dns Handler.ServeDNS.
 func (s *Server) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
 	c := s.ctx
@@ -54,7 +171,7 @@ func (s *Server) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
 	qType := r.Question[0].Qtype
 	switch qType {
 	case dns.TypeA, dns.TypeAAAA:
-		ips := s.resolve(s.ctx, qType, domain)
+		ips := s.lookup(c, qType, domain)
 		if len(ips) == 0 {
 			break
 		}
@@ -70,24 +187,17 @@ func (s *Server) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
 		// single dns server, this will prevent us
 		// from intercepting all queries
 		msg.RecursionAvailable = true
-		for _, ip := range ips {
-			if ip.To4() != nil {
-				if qType != dns.TypeA {
-					continue
-				}
-			} else {
-				if qType != dns.TypeAAAA {
-					continue
-				}
-			}
+		for _, ip := range answerAddresses(qType, ips, s.order()) {
 			dlog.Debugf(c, "QUERY[%v] %s -> %s", qType, domain, ip)
 			// if we don't give back the same domain
 			// requested, then mac dns seems to return an
 			// nxdomain
-			msg.Answer = append(msg.Answer, &dns.A{
-				Hdr: dns.RR_Header{Name: domain, Rrtype: qType, Class: dns.ClassINET, Ttl: 60},
-				A:   ip,
-			})
+			hdr := dns.RR_Header{Name: domain, Rrtype: qType, Class: dns.ClassINET, Ttl: s.ttl}
+			if qType == dns.TypeA {
+				msg.Answer = append(msg.Answer, &dns.A{Hdr: hdr, A: ip})
+			} else {
+				msg.Answer = append(msg.Answer, &dns.AAAA{Hdr: hdr, AAAA: ip})
+			}
 		}
 		if len(msg.Answer) == 0 {
 			dlog.Debugf(c, "QUERY[%v] %s -> EMPTY", qType, domain)
@@ -95,7 +205,7 @@ func (s *Server) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
 		_ = w.WriteMsg(&msg)
 		return
 	default:
-		ips := s.resolve(s.ctx, qType, domain)
+		ips := s.lookup(c, qType, domain)
 		if len(ips) > 0 {
 			dlog.Debugf(c, "QTYPE[%v] %s -> EMPTY", qType, domain)
 			msg := dns.Msg{}
@@ -108,8 +218,7 @@ func (s *Server) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
 	}
 	if s.fallback != nil {
 		dlog.Debugf(c, "QTYPE[%v] %s -> FALLBACK", qType, domain)
-		client := dns.Client{Net: "udp"}
-		in, _, err := client.ExchangeWithConn(r, s.fallback)
+		in, err := s.exchangeWithFallback(r)
 		if err != nil {
 			dlog.Error(c, err)
 			return
@@ -123,6 +232,27 @@ func (s *Server) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
 	}
 }
 
+// answerAddresses returns the addresses among the given ones that answer a query of the given type
+// when the given order applies.
+func answerAddresses(qType uint16, ips []net.IP, order AddressOrder) []net.IP {
+	var v4, v6 []net.IP
+	for _, ip := range ips {
+		if ip4 := ip.To4(); ip4 != nil {
+			v4 = append(v4, ip4)
+		} else {
+			v6 = append(v6, ip)
+		}
+	}
+	switch {
+	case qType == dns.TypeA && (order != IPv6First || len(v6) == 0):
+		return v4
+	case qType == dns.TypeAAAA && (order != IPv4First || len(v4) == 0):
+		return v6
+	default:
+		return nil
+	}
+}
+
 // Start starts the DNS server
 func (s *Server) Run(c context.Context) error {
 	g := dgroup.NewGroup(c, dgroup.GroupConfig{})
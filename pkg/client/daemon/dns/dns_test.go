@@ -0,0 +1,70 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/datawire/dlib/dlog"
+)
+
+func TestServer_lookup(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	var calls int32
+	release := make(chan struct{})
+	s := NewServer(ctx, nil, nil, func(ctx context.Context, qType uint16, domain string) []net.IP {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return []net.IP{{127, 0, 0, 1}}
+	})
+
+	const n = 20
+	wg := sync.WaitGroup{}
+	wg.Add(n)
+	results := make([][]net.IP, n)
+	for i := 0; i < n; i++ {
+		go func(i int) {
+			defer wg.Done()
+			results[i] = s.lookup(ctx, dns.TypeA, "example.com.")
+		}(i)
+	}
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&calls) == 1 }, time.Second, time.Millisecond)
+	other := make(chan []net.IP)
+	go func() { other <- s.lookup(ctx, dns.TypeAAAA, "example.com.") }()
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&calls) == 2 }, time.Second, time.Millisecond)
+	close(release)
+	wg.Wait()
+	<-other
+	for _, r := range results {
+		assert.Equal(t, []net.IP{{127, 0, 0, 1}}, r)
+	}
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+
+	// A question that is asked after the previous lookup completed is resolved again
+	s.lookup(ctx, dns.TypeA, "example.com.")
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+}
+
+func TestAnswerAddresses(t *testing.T) {
+	v4 := net.ParseIP("10.43.0.10")
+	v6 := net.ParseIP("fd00:10:96::a")
+	dual := []net.IP{v6, v4}
+
+	assert.Equal(t, []net.IP{v4.To4()}, answerAddresses(dns.TypeA, dual, BothFamilies))
+	assert.Equal(t, []net.IP{v6}, answerAddresses(dns.TypeAAAA, dual, BothFamilies))
+
+	// The other family is only suppressed when the name has addresses of the preferred one
+	assert.Equal(t, []net.IP{v4.To4()}, answerAddresses(dns.TypeA, dual, IPv4First))
+	assert.Empty(t, answerAddresses(dns.TypeAAAA, dual, IPv4First))
+	assert.Equal(t, []net.IP{v6}, answerAddresses(dns.TypeAAAA, []net.IP{v6}, IPv4First))
+
+	assert.Empty(t, answerAddresses(dns.TypeA, dual, IPv6First))
+	assert.Equal(t, []net.IP{v6}, answerAddresses(dns.TypeAAAA, dual, IPv6First))
+	assert.Equal(t, []net.IP{v4.To4()}, answerAddresses(dns.TypeA, []net.IP{v4}, IPv6First))
+}
@@ -0,0 +1,152 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+
+	grpccodes "google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/daemon"
+	"github.com/telepresenceio/telepresence/v2/pkg/subnet"
+)
+
+// Rules are the origins of the subnets that the daemon routes to the cluster
+const (
+	RuleCluster   = "cluster"    // the service and pod subnets reported by the traffic-manager
+	RuleAlsoProxy = "also-proxy" // the subnets of the also-proxy config of the kubeconfig
+	RuleExternal  = "external"   // the node and load balancer subnets sent by the connector
+)
+
+// ExplainRoute explains how the daemon handles the traffic to the given destination
+func (d *service) ExplainRoute(_ context.Context, destination *rpc.RouteDestination) (*rpc.RouteExplanation, error) {
+	ip, port, err := parseDestination(destination.Destination)
+	if err != nil {
+		return nil, grpcstatus.Error(grpccodes.InvalidArgument, err.Error())
+	}
+	return d.outbound.router.explainRoute(ip, port), nil
+}
+
+// parseDestination parses an IP address with an optional port, e.g. "10.42.3.7", "10.42.3.7:5432",
+// or "[fd00::1]:5432".
+func parseDestination(dest string) (net.IP, uint16, error) {
+	host, portStr, err := net.SplitHostPort(dest)
+	if err != nil {
+		host, portStr = dest, ""
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, 0, fmt.Errorf("%q is not an IP address", host)
+	}
+	var port uint16
+	if portStr != "" {
+		p, err := strconv.ParseUint(portStr, 10, 16)
+		if err != nil {
+			return nil, 0, fmt.Errorf("%q is not a port number", portStr)
+		}
+		port = uint16(p)
+	}
+	return ip, port, nil
+}
+
+// explainRoute explains how the router handles the traffic to the given destination
+func (t *tunRouter) explainRoute(ip net.IP, port uint16) *rpc.RouteExplanation {
+	t.curSubnetsLock.Lock()
+	rt := routeTable{
+		cluster:    t.clusterSubnets,
+		alsoProxy:  t.alsoProxySubnets,
+		external:   t.externalSubnets,
+		neverProxy: t.neverProxySubnets,
+		routed:     t.curSubnets,
+	}
+	re := rt.explain(ip)
+	t.curSubnetsLock.Unlock()
+
+	re.Device = t.dev.Name()
+	re.ProcessScoped = t.procRouting != nil
+	if port != 0 {
+		re.Port = int32(port)
+		if port == t.dnsPort && ip.Equal(t.dnsIP) {
+			re.Dns = true
+			re.Reason = "the destination is the DNS server of the TUN device, so the queries are answered by the daemon"
+		}
+	}
+	return re
+}
+
+// routeTable is a snapshot of the subnets of a tunRouter
+type routeTable struct {
+	cluster    []*net.IPNet
+	alsoProxy  []*net.IPNet
+	external   []*net.IPNet
+	neverProxy []*net.IPNet
+	routed     []*net.IPNet
+}
+
+// explain mirrors the decisions of refreshSubnets, of the routing table of the workstation, and of
+// handlePacket for the given address. Like in the routing table, the most specific subnet wins.
+func (rt *routeTable) explain(ip net.IP) *rpc.RouteExplanation {
+	re := &rpc.RouteExplanation{Ip: ip.String()}
+	var rule *net.IPNet
+	for _, r := range []struct {
+		name    string
+		subnets []*net.IPNet
+	}{{RuleCluster, rt.cluster}, {RuleAlsoProxy, rt.alsoProxy}, {RuleExternal, rt.external}} {
+		if sn := mostSpecific(ip, r.subnets); sn != nil && (rule == nil || prefixLen(sn) > prefixLen(rule)) {
+			rule = sn
+			re.Subnet, re.Rule = sn.String(), r.name
+		}
+	}
+	np := mostSpecific(ip, rt.neverProxy)
+	if np != nil {
+		re.NeverProxy = np.String()
+	}
+	routed := mostSpecific(ip, rt.routed)
+
+	switch {
+	case routed == nil && rule == nil:
+		re.Reason = "no subnet that is routed to the cluster contains the destination, so it's routed by the workstation as usual"
+	case routed == nil && np != nil && subnet.Covers(np, rule):
+		re.Reason = fmt.Sprintf("%s subnet %s is covered by never-proxy subnet %s, so it's not routed to the cluster", re.Rule, rule, np)
+	case routed == nil && np != nil:
+		re.Reason = fmt.Sprintf("%s subnet %s is routed without never-proxy subnet %s, so the destination is routed by the workstation as usual", re.Rule, rule, np)
+	case routed == nil:
+		re.Reason = fmt.Sprintf("%s subnet %s contains the destination but isn't routed to the TUN device yet", re.Rule, rule)
+	case np != nil:
+		// refreshSubnets excludes the never-proxy subnets from the subnets that it routes, so this
+		// isn't expected, but the daemon rejects the traffic that the TUN device receives for them.
+		re.Rejected = true
+		re.Reason = fmt.Sprintf("routed subnet %s covers never-proxy subnet %s, so the daemon rejects the traffic that the TUN device receives for the destination", routed, np)
+	default:
+		re.Routed = true
+		switch {
+		case rule == nil || !subnet.Covers(rule, routed):
+			// A subnet that the router still has although it's no longer desired
+			re.Subnet, re.Rule = routed.String(), ""
+		case !subnet.Equal(rule, routed):
+			// A part of the subnet that excludes a never-proxy subnet
+			re.Subnet = routed.String()
+		}
+		re.Reason = fmt.Sprintf("subnet %s is routed to the TUN device, which tunnels the traffic to the traffic-manager", routed)
+	}
+	return re
+}
+
+// mostSpecific returns the subnet with the longest prefix among the given subnets that contains
+// the given address, or nil if none of them contains it
+func mostSpecific(ip net.IP, subnets []*net.IPNet) *net.IPNet {
+	var best *net.IPNet
+	for _, sn := range subnets {
+		if sn.Contains(ip) && (best == nil || prefixLen(sn) > prefixLen(best)) {
+			best = sn
+		}
+	}
+	return best
+}
+
+func prefixLen(sn *net.IPNet) int {
+	ones, _ := sn.Mask.Size()
+	return ones
+}
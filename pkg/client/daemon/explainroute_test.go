@@ -0,0 +1,88 @@
+package daemon
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRouteTableExplain(t *testing.T) {
+	cidr := func(s string) *net.IPNet {
+		_, sn, err := net.ParseCIDR(s)
+		require.NoError(t, err)
+		return sn
+	}
+	pods := cidr("10.42.0.0/16")
+	svcs := cidr("10.43.0.0/16")
+	alsoProxy := cidr("10.42.3.0/24")
+	covered := cidr("192.168.10.0/24")
+	rt := routeTable{
+		cluster:    []*net.IPNet{pods, svcs},
+		alsoProxy:  []*net.IPNet{alsoProxy, covered},
+		neverProxy: []*net.IPNet{cidr("192.168.0.0/16"), cidr("10.43.128.0/17")},
+		routed:     []*net.IPNet{pods, cidr("10.43.0.0/17"), alsoProxy},
+	}
+
+	re := rt.explain(net.ParseIP("10.42.3.7"))
+	assert.True(t, re.Routed)
+	assert.Equal(t, "10.42.3.0/24", re.Subnet)
+	assert.Equal(t, RuleAlsoProxy, re.Rule)
+
+	re = rt.explain(net.ParseIP("10.42.4.7"))
+	assert.True(t, re.Routed)
+	assert.Equal(t, "10.42.0.0/16", re.Subnet)
+	assert.Equal(t, RuleCluster, re.Rule)
+
+	// The never-proxy subnet was excluded from the cluster subnet
+	re = rt.explain(net.ParseIP("10.43.200.1"))
+	assert.False(t, re.Routed)
+	assert.False(t, re.Rejected)
+	assert.Equal(t, "10.43.0.0/16", re.Subnet)
+	assert.Equal(t, "10.43.128.0/17", re.NeverProxy)
+
+	re = rt.explain(net.ParseIP("10.43.100.1"))
+	assert.True(t, re.Routed)
+	assert.Equal(t, "10.43.0.0/17", re.Subnet)
+	assert.Equal(t, RuleCluster, re.Rule)
+
+	// A routed subnet that covers a never-proxy subnet has its traffic rejected
+	rt.routed = []*net.IPNet{svcs}
+	re = rt.explain(net.ParseIP("10.43.200.1"))
+	assert.False(t, re.Routed)
+	assert.True(t, re.Rejected)
+
+	// The also-proxy subnet was dropped because a never-proxy subnet covers it
+	re = rt.explain(net.ParseIP("192.168.10.5"))
+	assert.False(t, re.Routed)
+	assert.False(t, re.Rejected)
+	assert.Equal(t, "192.168.10.0/24", re.Subnet)
+	assert.Equal(t, "192.168.0.0/16", re.NeverProxy)
+
+	re = rt.explain(net.ParseIP("8.8.8.8"))
+	assert.False(t, re.Routed)
+	assert.Empty(t, re.Subnet)
+}
+
+func TestParseDestination(t *testing.T) {
+	ip, port, err := parseDestination("10.42.3.7:5432")
+	require.NoError(t, err)
+	assert.Equal(t, "10.42.3.7", ip.String())
+	assert.Equal(t, uint16(5432), port)
+
+	ip, port, err = parseDestination("[fd00::1]:53")
+	require.NoError(t, err)
+	assert.Equal(t, "fd00::1", ip.String())
+	assert.Equal(t, uint16(53), port)
+
+	ip, port, err = parseDestination("10.42.3.7")
+	require.NoError(t, err)
+	assert.Equal(t, "10.42.3.7", ip.String())
+	assert.Zero(t, port)
+
+	_, _, err = parseDestination("db.default:5432")
+	assert.Error(t, err)
+	_, _, err = parseDestination("10.42.3.7:http")
+	assert.Error(t, err)
+}
@@ -0,0 +1,24 @@
+package daemon
+
+import (
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/metrics"
+)
+
+// countingTunnel counts the payload bytes that pass through the client tunnel
+type countingTunnel struct {
+	manager.Manager_ClientTunnelClient
+}
+
+func (t *countingTunnel) Send(msg *manager.ConnMessage) error {
+	metrics.TunnelBytes.WithLabelValues("out").Add(float64(len(msg.Payload)))
+	return t.Manager_ClientTunnelClient.Send(msg)
+}
+
+func (t *countingTunnel) Recv() (*manager.ConnMessage, error) {
+	msg, err := t.Manager_ClientTunnelClient.Recv()
+	if err == nil {
+		metrics.TunnelBytes.WithLabelValues("in").Add(float64(len(msg.Payload)))
+	}
+	return msg, err
+}
@@ -0,0 +1,45 @@
+package daemon
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/metrics"
+)
+
+type fakeTunnel struct {
+	manager.Manager_ClientTunnelClient
+	sent []*manager.ConnMessage
+	recv []*manager.ConnMessage
+}
+
+func (t *fakeTunnel) Send(msg *manager.ConnMessage) error {
+	t.sent = append(t.sent, msg)
+	return nil
+}
+
+func (t *fakeTunnel) Recv() (*manager.ConnMessage, error) {
+	msg := t.recv[0]
+	t.recv = t.recv[1:]
+	return msg, nil
+}
+
+func TestCountingTunnel(t *testing.T) {
+	out := testutil.ToFloat64(metrics.TunnelBytes.WithLabelValues("out"))
+	in := testutil.ToFloat64(metrics.TunnelBytes.WithLabelValues("in"))
+
+	ft := &fakeTunnel{recv: []*manager.ConnMessage{{Payload: make([]byte, 100)}, {Payload: make([]byte, 20)}}}
+	ct := &countingTunnel{Manager_ClientTunnelClient: ft}
+	require.NoError(t, ct.Send(&manager.ConnMessage{Payload: make([]byte, 30)}))
+	for i := 0; i < 2; i++ {
+		_, err := ct.Recv()
+		require.NoError(t, err)
+	}
+	assert.Len(t, ft.sent, 1)
+	assert.Equal(t, out+30, testutil.ToFloat64(metrics.TunnelBytes.WithLabelValues("out")))
+	assert.Equal(t, in+120, testutil.ToFloat64(metrics.TunnelBytes.WithLabelValues("in")))
+}
@@ -6,6 +6,7 @@ import (
 	"net"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	dns2 "github.com/miekg/dns"
@@ -16,11 +17,11 @@ import (
 	"github.com/telepresenceio/telepresence/rpc/v2/manager"
 	"github.com/telepresenceio/telepresence/v2/pkg/client"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/daemon/dns"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/metrics"
 	"github.com/telepresenceio/telepresence/v2/pkg/iputil"
+	"github.com/telepresenceio/telepresence/v2/pkg/tracing"
 )
 
-const kubernetesZone = "cluster.local"
-
 type awaitLookupResult struct {
 	done   chan struct{}
 	result iputil.IPs
@@ -51,6 +52,18 @@ type outbound struct {
 	// Lock preventing concurrent calls to setSearchPath
 	searchPathLock sync.Mutex
 
+	// searchPaths are the paths of the latest call to setSearchPath
+	searchPaths []string
+
+	// clusterDomain is the domain of the cluster, always stored as a string
+	clusterDomain atomic.Value
+
+	// externalHosts maps the names of the nodes and load balancers of the cluster to their
+	// addresses when they are routed to the cluster. The names are in lower case and have a
+	// trailing dot.
+	externalHosts     map[string][]net.IP
+	externalHostsLock sync.RWMutex
+
 	setSearchPathFunc func(c context.Context, paths []string)
 
 	work chan func(context.Context) error
@@ -100,12 +113,44 @@ func newOutbound(c context.Context, dnsIPStr string, noSearch bool) (*outbound,
 		kubeDNS:       make(chan net.IP, 1),
 	}
 
-	if ret.router, err = newTunRouter(); err != nil {
+	// The connector tells the domain that it detects, unless the config sets it
+	clusterDomain := client.GetConfig(c).Cluster.ClusterDomain
+	if clusterDomain == "" {
+		clusterDomain = client.DefaultClusterDomain
+	}
+	ret.clusterDomain.Store(clusterDomain)
+	if ret.router, err = newTunRouter(c); err != nil {
 		return nil, err
 	}
 	return ret, nil
 }
 
+// getClusterDomain returns the domain of the cluster, without a trailing dot.
+func (o *outbound) getClusterDomain() string {
+	return o.clusterDomain.Load().(string)
+}
+
+// dotClusterDomain returns the domain of the cluster with a leading and a trailing dot.
+func (o *outbound) dotClusterDomain() string {
+	return "." + o.getClusterDomain() + "."
+}
+
+// setClusterDomain sets the domain of the cluster. The DNS configuration is updated when the
+// domain changes after the search path was set.
+func (o *outbound) setClusterDomain(c context.Context, domain string) {
+	if domain == "" || domain == o.getClusterDomain() {
+		return
+	}
+	dlog.Infof(c, "Using cluster domain %s", domain)
+	o.clusterDomain.Store(domain)
+	o.searchPathLock.Lock()
+	paths := o.searchPaths
+	o.searchPathLock.Unlock()
+	if paths != nil {
+		o.setSearchPath(c, paths)
+	}
+}
+
 // routerServerWorker starts the TUN router and reads from the work queue of firewall config
 // changes that is written to by the 'Update' gRPC call.
 func (o *outbound) routerServerWorker(c context.Context) (err error) {
@@ -132,13 +177,12 @@ func (o *outbound) routerServerWorker(c context.Context) (err error) {
 // "<single label name>.tel2-search." will be resolved as "<single label name>." using the search path of this resolver.
 const tel2SubDomain = "tel2-search"
 const tel2SubDomainDot = tel2SubDomain + "."
-const dotKubernetesZone = "." + kubernetesZone + "."
 
 var localhostIPv6 = []net.IP{{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1}}
 var localhostIPv4 = []net.IP{{127, 0, 0, 1}}
 
 func (o *outbound) shouldDoClusterLookup(query string) bool {
-	if strings.HasSuffix(query, dotKubernetesZone) && strings.Count(query, ".") < 4 {
+	if dz := o.dotClusterDomain(); strings.HasSuffix(query, dz) && strings.Count(query, ".") <= strings.Count(dz, ".") {
 		return false
 	}
 
@@ -178,6 +222,14 @@ func (o *outbound) resolveInCluster(c context.Context, qType uint16, query strin
 		return localhostIPv4
 	}
 
+	// The addresses of routed nodes and load balancers are known up front
+	o.externalHostsLock.RLock()
+	ips, ok := o.externalHosts[query]
+	o.externalHostsLock.RUnlock()
+	if ok {
+		return ips
+	}
+
 	if !o.shouldDoClusterLookup(query) {
 		return nil
 	}
@@ -213,18 +265,25 @@ func (o *outbound) resolveInCluster(c context.Context, qType uint16, query strin
 
 	queryWithNoTrailingDot := query[:len(query)-1]
 	dlog.Debugf(c, "LookupHost %q", queryWithNoTrailingDot)
+	c, span := tracing.Start(c, "dns.lookup", "dns.question.name", queryWithNoTrailingDot)
+	defer span.End()
+	start := time.Now()
 	response, err := o.router.managerClient.LookupHost(c, &manager.LookupHostRequest{
 		Session: o.router.session,
 		Host:    queryWithNoTrailingDot,
 	})
+	span.SetError(err)
 	if err != nil {
+		metrics.DNSLookupDuration.WithLabelValues("error").Observe(time.Since(start).Seconds())
 		dlog.Error(c, client.CheckTimeout(c, err))
 		return nil
 	}
 	if len(response.Ips) == 0 {
+		metrics.DNSLookupDuration.WithLabelValues("not_found").Observe(time.Since(start).Seconds())
 		return nil
 	}
-	ips := make(iputil.IPs, len(response.Ips))
+	metrics.DNSLookupDuration.WithLabelValues("found").Observe(time.Since(start).Seconds())
+	ips = make(iputil.IPs, len(response.Ips))
 	for i, ip := range response.Ips {
 		ips[i] = ip
 	}
@@ -232,6 +291,41 @@ func (o *outbound) resolveInCluster(c context.Context, qType uint16, query strin
 	return ips
 }
 
+// newDNSServer returns a DNS server that answers the names of the cluster using the given resolver,
+// with the TTL and address order of the dns section of the config.
+func (o *outbound) newDNSServer(c context.Context, listeners []net.PacketConn, fallback *dns2.Conn, resolve dns.Resolver) *dns.Server {
+	cfg := client.GetConfig(c).DNS
+	var order func() dns.AddressOrder
+	switch cfg.AddressOrder {
+	case client.AddressOrderIPv4First:
+		order = func() dns.AddressOrder { return dns.IPv4First }
+	case client.AddressOrderIPv6First:
+		order = func() dns.AddressOrder { return dns.IPv6First }
+	case client.AddressOrderBoth:
+		order = func() dns.AddressOrder { return dns.BothFamilies }
+	default:
+		order = func() dns.AddressOrder { return addressOrderFor(o.router.routedSubnets()) }
+	}
+	srv := dns.NewServer(c, listeners, fallback, resolve)
+	srv.SetAnswerPolicy(cfg.TTL, order)
+	return srv
+}
+
+// addressOrderFor returns the address order that suits the given routed subnets. IPv4 is preferred
+// when IPv4 subnets are routed, because the router doesn't reassemble fragmented IPv6 packets, and
+// IPv6 is preferred when only IPv6 subnets are routed. Before any subnets are routed, there's nothing
+// to base a preference on.
+func addressOrderFor(subnets []*net.IPNet) dns.AddressOrder {
+	order := dns.BothFamilies
+	for _, sn := range subnets {
+		if sn.IP.To4() != nil {
+			return dns.IPv4First
+		}
+		order = dns.IPv6First
+	}
+	return order
+}
+
 func (o *outbound) setInfo(ctx context.Context, info *rpc.OutboundInfo) error {
 	if info.Dns == nil {
 		info.Dns = &rpc.DNSConfig{}
@@ -269,6 +363,16 @@ func (o *outbound) setInfo(ctx context.Context, info *rpc.OutboundInfo) error {
 	return o.router.setOutboundInfo(ctx, info, kubeDNS)
 }
 
+// setExternalRoutes makes the router route the given subnets to the cluster, and the DNS resolver
+// answer queries for the given hosts with the given addresses.
+func (o *outbound) setExternalRoutes(ctx context.Context, subnets []*net.IPNet, hosts map[string][]net.IP) error {
+	o.externalHostsLock.Lock()
+	o.externalHosts = hosts
+	o.externalHostsLock.Unlock()
+	dns.Flush(ctx)
+	return o.router.setExternalSubnets(ctx, subnets)
+}
+
 func (o *outbound) getInfo() *rpc.OutboundInfo {
 	return &rpc.OutboundInfo{
 		Dns: o.dnsConfig,
@@ -286,6 +390,7 @@ func (o *outbound) setSearchPath(c context.Context, paths []string) {
 	case <-o.dnsConfigured:
 		o.searchPathLock.Lock()
 		defer o.searchPathLock.Unlock()
+		o.searchPaths = append([]string(nil), paths...)
 		o.setSearchPathFunc(c, paths)
 		dns.Flush(c)
 	}
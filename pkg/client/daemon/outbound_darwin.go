@@ -16,8 +16,12 @@ import (
 	"github.com/datawire/dlib/dgroup"
 	"github.com/datawire/dlib/dlog"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/daemon/dns"
+	"github.com/telepresenceio/telepresence/v2/pkg/privhelper"
 )
 
+const resolverDirName = "/etc/resolver"
+const resolverFileName = "telepresence.local"
+
 type resolveFile struct {
 	port        int
 	domain      string
@@ -88,7 +92,9 @@ func readResolveFile(fileName string) (*resolveFile, error) {
 	return &rf, nil
 }
 
-func (r *resolveFile) write(fileName string) error {
+// write writes the resolver file with the given name to /etc/resolver. Only root may do that, so an
+// unprivileged daemon lets the privileged helper write it.
+func (r *resolveFile) write(c context.Context, fileName string) error {
 	buf := bytes.NewBufferString("# Generated by telepresence\n")
 	fmt.Fprintf(buf, "port %d\n", r.port)
 	if r.domain != "" {
@@ -106,7 +112,32 @@ func (r *resolveFile) write(fileName string) error {
 		}
 		buf.WriteByte('\n')
 	}
-	return ioutil.WriteFile(fileName, buf.Bytes(), 0644)
+	if os.Geteuid() != 0 {
+		return privhelper.NewClient().WriteResolver(c, fileName, buf.Bytes())
+	}
+	if err := os.MkdirAll(resolverDirName, 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(resolverDirName, fileName), buf.Bytes(), 0644)
+}
+
+// removeResolveFile removes a file that was written using resolveFile.write
+func removeResolveFile(c context.Context, fileName string) error {
+	if os.Geteuid() != 0 {
+		return privhelper.NewClient().RemoveResolver(c, fileName)
+	}
+	return os.Remove(filepath.Join(resolverDirName, fileName))
+}
+
+// flushDNS flushes the DNS cache, using the privileged helper when the daemon is unprivileged
+func flushDNS(c context.Context) {
+	if os.Geteuid() != 0 {
+		if err := privhelper.NewClient().FlushDNS(c); err != nil {
+			dlog.Error(c, err)
+		}
+		return
+	}
+	dns.Flush(c)
 }
 
 func (r *resolveFile) setSearchPaths(paths ...string) {
@@ -132,37 +163,29 @@ func (r *resolveFile) setSearchPaths(paths ...string) {
 //
 // or, if not on a Mac, follow this link: https://www.manpagez.com/man/5/resolver/
 func (o *outbound) dnsServerWorker(c context.Context) error {
-	resolverDirName := filepath.Join("/etc", "resolver")
-	resolverFileName := filepath.Join(resolverDirName, "telepresence.local")
-
 	dnsAddr, err := splitToUDPAddr(o.dnsListener.LocalAddr())
 	if err != nil {
 		return err
 	}
 
-	err = os.MkdirAll(resolverDirName, 0755)
-	if err != nil {
-		return err
-	}
-
 	rf := resolveFile{
 		port:        dnsAddr.Port,
-		domain:      kubernetesZone,
+		domain:      o.getClusterDomain(),
 		nameservers: []net.IP{dnsAddr.IP},
-		search:      []string{kubernetesZone},
+		search:      []string{o.getClusterDomain()},
 	}
-	if err = rf.write(resolverFileName); err != nil {
+	if err = rf.write(c, resolverFileName); err != nil {
 		return err
 	}
 	dlog.Infof(c, "Generated new %s", resolverFileName)
 
 	namespaceResolverFile := func(namespace string) string {
-		return filepath.Join(resolverDirName, "telepresence."+namespace+".local")
+		return "telepresence." + namespace + ".local"
 	}
 
 	o.setSearchPathFunc = func(c context.Context, paths []string) {
 		dlog.Infof(c, "setting search paths %s", strings.Join(paths, " "))
-		rf, err := readResolveFile(resolverFileName)
+		rf, err := readResolveFile(filepath.Join(resolverDirName, resolverFileName))
 		if err != nil {
 			dlog.Error(c, err)
 			return
@@ -211,7 +234,7 @@ func (o *outbound) dnsServerWorker(c context.Context) error {
 		for _, namespace := range removals {
 			nsFile := namespaceResolverFile(namespace)
 			dlog.Infof(c, "Removing %s", nsFile)
-			if err = os.Remove(nsFile); err != nil {
+			if err = removeResolveFile(c, nsFile); err != nil {
 				dlog.Error(c, err)
 			}
 		}
@@ -223,18 +246,19 @@ func (o *outbound) dnsServerWorker(c context.Context) error {
 			}
 			nsFile := namespaceResolverFile(namespace)
 			dlog.Infof(c, "Generated new %s", nsFile)
-			if err = df.write(nsFile); err != nil {
+			if err = df.write(c, nsFile); err != nil {
 				dlog.Error(c, err)
 			}
 		}
 
+		rf.domain = o.getClusterDomain()
 		rf.setSearchPaths(search...)
 
 		// Versions prior to Big Sur will not trigger an update unless the resolver file
 		// is removed and recreated.
-		_ = os.Remove(resolverFileName)
+		_ = removeResolveFile(c, resolverFileName)
 
-		if err = rf.write(resolverFileName); err != nil {
+		if err = rf.write(c, resolverFileName); err != nil {
 			dlog.Error(c, err)
 		}
 	}
@@ -244,13 +268,14 @@ func (o *outbound) dnsServerWorker(c context.Context) error {
 		defer o.searchPathLock.Unlock()
 
 		// Remove the main resolver file
-		_ = os.Remove(resolverFileName)
+		c := dcontext.HardContext(c)
+		_ = removeResolveFile(c, resolverFileName)
 
 		// Remove each namespace resolver file
 		for namespace := range o.domains {
-			_ = os.Remove(namespaceResolverFile(namespace))
+			_ = removeResolveFile(c, namespaceResolverFile(namespace))
 		}
-		dns.Flush(dcontext.HardContext(c))
+		flushDNS(c)
 	}()
 
 	// Start local DNS server
@@ -265,10 +290,10 @@ func (o *outbound) dnsServerWorker(c context.Context) error {
 			}
 		}
 		defer o.dnsListener.Close()
-		v := dns.NewServer(c, []net.PacketConn{o.dnsListener}, nil, o.resolveInCluster)
+		v := o.newDNSServer(c, []net.PacketConn{o.dnsListener}, nil, o.resolveInCluster)
 		return v.Run(c)
 	})
-	dns.Flush(c)
+	flushDNS(c)
 	close(o.dnsConfigured)
 	return g.Wait()
 }
@@ -43,7 +43,7 @@ func (o *outbound) shouldApplySearch(query string) bool {
 	}
 
 	// Don't apply search paths to the kubernetes zone
-	if strings.HasSuffix(query, dotKubernetesZone) {
+	if strings.HasSuffix(query, o.dotClusterDomain()) {
 		return false
 	}
 
@@ -150,7 +150,7 @@ func (o *outbound) runOverridingServer(c context.Context) error {
 		unrouteDNS(ncc)
 	}()
 	dns.Flush(c)
-	srv := dns.NewServer(c, listeners, conn, o.resolveInSearch)
+	srv := o.newDNSServer(c, listeners, conn, o.resolveInSearch)
 	close(o.dnsConfigured)
 	dlog.Debug(c, "Starting server")
 	err = srv.Run(c)
@@ -0,0 +1,33 @@
+package daemon
+
+import (
+	"context"
+	"net"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/peercred"
+)
+
+type peerUIDKey struct{}
+
+// withPeerUID is the ConnContext of the gRPC server. It adds the uid of the process at the other
+// end of a unix socket connection to the context of the calls that are made on that connection.
+func withPeerUID(ctx context.Context, conn net.Conn) context.Context {
+	uc, ok := conn.(*net.UnixConn)
+	if !ok {
+		return ctx
+	}
+	uid, err := peercred.PeerUID(uc)
+	if err != nil {
+		dlog.Debugf(ctx, "unable to get the credentials of the peer: %v", err)
+		return ctx
+	}
+	return context.WithValue(ctx, peerUIDKey{}, uid)
+}
+
+// callerUID returns the uid of the process that made the gRPC call of the given context, and
+// false if it's unknown.
+func callerUID(ctx context.Context) (int, bool) {
+	uid, ok := ctx.Value(peerUIDKey{}).(int)
+	return uid, ok
+}
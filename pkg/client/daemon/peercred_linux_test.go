@@ -0,0 +1,35 @@
+package daemon
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithPeerUID(t *testing.T) {
+	l, err := net.Listen("unix", filepath.Join(t.TempDir(), "socket"))
+	require.NoError(t, err)
+	defer l.Close()
+
+	go func() {
+		if conn, err := net.Dial("unix", l.Addr().String()); err == nil {
+			defer conn.Close()
+			_, _ = conn.Read(make([]byte, 1))
+		}
+	}()
+	conn, err := l.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+
+	uid, ok := callerUID(withPeerUID(context.Background(), conn))
+	assert.True(t, ok)
+	assert.Equal(t, os.Getuid(), uid)
+
+	_, ok = callerUID(context.Background())
+	assert.False(t, ok)
+}
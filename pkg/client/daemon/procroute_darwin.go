@@ -0,0 +1,22 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/tun"
+)
+
+// processRouting is only supported on Linux, where the traffic of a cgroup can be marked
+type processRouting struct{}
+
+func newProcessRouting(_ context.Context, _ *tun.Device) (*processRouting, error) {
+	return nil, errors.New("process scoped routing is only supported on Linux")
+}
+
+func (pr *processRouting) addProcess(_ context.Context, _, _ int) error {
+	return errors.New("process scoped routing is only supported on Linux")
+}
+
+func (pr *processRouting) close(_ context.Context) {
+}
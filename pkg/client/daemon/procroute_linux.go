@@ -0,0 +1,173 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/datawire/dlib/dexec"
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/tun"
+)
+
+const (
+	// processRoutingTable is the routing table that holds the routes of the cluster subnets, and
+	// also the priority of the rule that directs marked packets to it.
+	processRoutingTable = 7272
+
+	// processRoutingMark is the firewall mark of the packets sent by the routed processes
+	processRoutingMark = "0x7272"
+
+	// processRoutingClassID is the net_cls class of the routed processes when cgroup v1 is used
+	processRoutingClassID = "0x72720001"
+
+	cgroupRoot = "/sys/fs/cgroup"
+)
+
+// processRouting confines the routing of the cluster subnets to the processes of a cgroup. The
+// packets sent by those processes are marked by an iptables rule, and only marked packets are
+// routed using the table that holds the routes to the TUN device.
+type processRouting struct {
+	// cgroupDir is the directory of the cgroup of the routed processes
+	cgroupDir string
+
+	// match is the iptables match of the packets sent from the cgroup
+	match []string
+
+	// oif is the name of the TUN device when the rule for sockets bound to it has been added
+	oif string
+
+	// ipv6 is true when the rules for IPv6 could be added
+	ipv6 bool
+}
+
+func newProcessRouting(ctx context.Context, dev *tun.Device) (pr *processRouting, err error) {
+	if err = dev.SetRoutingTable(processRoutingTable); err != nil {
+		return nil, err
+	}
+	pr = &processRouting{}
+	defer func() {
+		if err != nil {
+			pr.close(ctx)
+		}
+	}()
+
+	if _, err = os.Stat(filepath.Join(cgroupRoot, "cgroup.controllers")); err == nil {
+		// The unified hierarchy of cgroup v2
+		pr.cgroupDir = filepath.Join(cgroupRoot, "telepresence")
+		if err = os.Mkdir(pr.cgroupDir, 0755); err != nil && !os.IsExist(err) {
+			return nil, err
+		}
+		pr.match = []string{"-m", "cgroup", "--path", "telepresence"}
+	} else {
+		pr.cgroupDir = filepath.Join(cgroupRoot, "net_cls", "telepresence")
+		if err = os.Mkdir(pr.cgroupDir, 0755); err != nil && !os.IsExist(err) {
+			return nil, fmt.Errorf("unable to create net_cls cgroup: %w", err)
+		}
+		if err = ioutil.WriteFile(filepath.Join(pr.cgroupDir, "net_cls.classid"), []byte(processRoutingClassID), 0644); err != nil {
+			return nil, err
+		}
+		pr.match = []string{"-m", "cgroup", "--cgroup", processRoutingClassID}
+	}
+
+	table := strconv.Itoa(processRoutingTable)
+	if err = pr.run(ctx, "ip", "-4", "rule", "add", "fwmark", processRoutingMark, "table", table, "priority", table); err != nil {
+		return nil, err
+	}
+	if err = pr.run(ctx, "iptables", pr.markRule("-A")...); err != nil {
+		return nil, err
+	}
+	// Sockets bound to the device, like the ones that systemd-resolved uses to reach the DNS
+	// server of the device, must also find the routes.
+	pr.oif = dev.Name()
+	if err = pr.run(ctx, "ip", "-4", "rule", "add", "oif", pr.oif, "table", table, "priority", table); err != nil {
+		return nil, err
+	}
+	if err := pr.run(ctx, "ip", "-6", "rule", "add", "fwmark", processRoutingMark, "table", table, "priority", table); err == nil {
+		if err = pr.run(ctx, "ip6tables", pr.markRule("-A")...); err == nil {
+			pr.ipv6 = true
+		} else {
+			_ = pr.run(ctx, "ip", "-6", "rule", "del", "fwmark", processRoutingMark, "table", table)
+		}
+	}
+	if !pr.ipv6 {
+		dlog.Warn(ctx, "IPv6 traffic will not be routed to the cluster")
+	}
+
+	// Replies from the cluster arrive on the TUN device although the main table has no route to
+	// the cluster subnets through it, so a strict reverse path filter would drop them.
+	rpFilter := filepath.Join("/proc/sys/net/ipv4/conf", dev.Name(), "rp_filter")
+	if err = ioutil.WriteFile(rpFilter, []byte("2"), 0644); err != nil {
+		return nil, err
+	}
+	dlog.Infof(ctx, "Routing to the cluster is limited to the processes of cgroup %s", pr.cgroupDir)
+	return pr, nil
+}
+
+func (pr *processRouting) markRule(op string) []string {
+	args := append([]string{"-t", "mangle", op, "OUTPUT"}, pr.match...)
+	return append(args, "-j", "MARK", "--set-mark", processRoutingMark)
+}
+
+func (pr *processRouting) run(ctx context.Context, exe string, args ...string) error {
+	out, err := dexec.CommandContext(ctx, exe, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s %s: %s: %w", exe, strings.Join(args, " "), strings.TrimSpace(string(out)), err)
+	}
+	return nil
+}
+
+// addProcess moves the process with the given pid, and all its threads, to the cgroup so that its
+// traffic to the cluster subnets is routed to the TUN device. Its future child processes inherit
+// the cgroup. The process must be owned by the user with the given uid, unless that's root.
+func (pr *processRouting) addProcess(ctx context.Context, pid, uid int) error {
+	if uid != 0 {
+		st, err := os.Stat(filepath.Join("/proc", strconv.Itoa(pid)))
+		if err != nil {
+			return err
+		}
+		if owner := int(st.Sys().(*syscall.Stat_t).Uid); owner != uid {
+			return fmt.Errorf("process %d isn't owned by uid %d", pid, uid)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(pr.cgroupDir, "cgroup.procs"), []byte(strconv.Itoa(pid)), 0644); err != nil {
+		return err
+	}
+	dlog.Infof(ctx, "Routing traffic of process %d to the cluster", pid)
+	return nil
+}
+
+// close removes the firewall and routing rules, and the cgroup. The processes that still remain in
+// the cgroup are moved to its parent.
+func (pr *processRouting) close(ctx context.Context) {
+	table := strconv.Itoa(processRoutingTable)
+	if pr.match != nil {
+		_ = pr.run(ctx, "iptables", pr.markRule("-D")...)
+		_ = pr.run(ctx, "ip", "-4", "rule", "del", "fwmark", processRoutingMark, "table", table)
+	}
+	if pr.oif != "" {
+		_ = pr.run(ctx, "ip", "-4", "rule", "del", "oif", pr.oif, "table", table)
+	}
+	if pr.ipv6 {
+		_ = pr.run(ctx, "ip6tables", pr.markRule("-D")...)
+		_ = pr.run(ctx, "ip", "-6", "rule", "del", "fwmark", processRoutingMark, "table", table)
+	}
+	if pr.cgroupDir == "" {
+		return
+	}
+	if procs, err := ioutil.ReadFile(filepath.Join(pr.cgroupDir, "cgroup.procs")); err == nil {
+		parentProcs := filepath.Join(filepath.Dir(pr.cgroupDir), "cgroup.procs")
+		for _, pid := range strings.Fields(string(procs)) {
+			// The kernel accepts only one pid per write
+			_ = ioutil.WriteFile(parentProcs, []byte(pid), 0644)
+		}
+	}
+	if err := os.Remove(pr.cgroupDir); err != nil {
+		dlog.Errorf(ctx, "unable to remove cgroup %s: %v", pr.cgroupDir, err)
+	}
+}
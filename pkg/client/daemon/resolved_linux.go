@@ -59,7 +59,7 @@ func (o *outbound) tryResolveD(c context.Context, dev *tun.Device) error {
 		for _, sfx := range o.dnsConfig.IncludeSuffixes {
 			paths = append(paths, "~"+strings.TrimPrefix(sfx, "."))
 		}
-		paths = append(paths, kubernetesZone+".")
+		paths = append(paths, o.getClusterDomain()+".")
 		namespaces[tel2SubDomain] = struct{}{}
 
 		o.domainsLock.Lock()
@@ -91,7 +91,7 @@ func (o *outbound) tryResolveD(c context.Context, dev *tun.Device) error {
 				initDone <- struct{}{}
 				return errResolveDNotConfigured
 			}
-			dnsServer = dns.NewServer(c, []net.PacketConn{dnsResolverListener}, nil, o.resolveInCluster)
+			dnsServer = o.newDNSServer(c, []net.PacketConn{dnsResolverListener}, nil, o.resolveInCluster)
 			if err = o.router.configureDNS(c, dnsIP, uint16(53), dnsResolverAddr); err != nil {
 				dlog.Error(c, err)
 				initDone <- struct{}{}
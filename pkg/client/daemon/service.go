@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 	"syscall"
 	"time"
 
@@ -15,6 +16,8 @@ import (
 	"github.com/spf13/cobra"
 	"golang.org/x/sys/unix"
 	"google.golang.org/grpc"
+	grpccodes "google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
 	empty "google.golang.org/protobuf/types/known/emptypb"
 
 	"github.com/datawire/dlib/derror"
@@ -25,8 +28,14 @@ import (
 	"github.com/telepresenceio/telepresence/rpc/v2/connector"
 	rpc "github.com/telepresenceio/telepresence/rpc/v2/daemon"
 	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/debug"
 	"github.com/telepresenceio/telepresence/v2/pkg/client/logging"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/metrics"
 	"github.com/telepresenceio/telepresence/v2/pkg/filelocation"
+	"github.com/telepresenceio/telepresence/v2/pkg/iputil"
+	"github.com/telepresenceio/telepresence/v2/pkg/loglevel"
+	"github.com/telepresenceio/telepresence/v2/pkg/privhelper"
+	"github.com/telepresenceio/telepresence/v2/pkg/tracing"
 )
 
 const processName = "daemon"
@@ -54,16 +63,21 @@ type service struct {
 
 // Command returns the telepresence sub-command "daemon-foreground"
 func Command() *cobra.Command {
-	return &cobra.Command{
+	var debugEnabled bool
+	var timeouts []string
+	cmd := &cobra.Command{
 		Use:    processName + "-foreground",
 		Short:  "Launch Telepresence " + titleName + " in the foreground (debug)",
 		Args:   cobra.ExactArgs(3),
 		Hidden: true,
 		Long:   help,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return run(cmd.Context(), args[0], args[1], args[2])
+			return run(cmd.Context(), args[0], args[1], args[2], debugEnabled, timeouts)
 		},
 	}
+	cmd.Flags().BoolVar(&debugEnabled, "debug", false, "Serve pprof and a state dump on "+client.DaemonDebugSocketName)
+	cmd.Flags().StringSliceVar(&timeouts, "timeout", nil, "Override a timeout of the config, e.g. --timeout intercept=30s")
+	return cmd
 }
 
 func (d *service) Version(_ context.Context, _ *empty.Empty) (*common.VersionInfo, error) {
@@ -95,10 +109,53 @@ func (d *service) SetOutboundInfo(ctx context.Context, info *rpc.OutboundInfo) (
 	return &empty.Empty{}, d.outbound.setInfo(ctx, info)
 }
 
+func (d *service) AddRoutedProcess(ctx context.Context, rp *rpc.RoutedProcess) (*empty.Empty, error) {
+	pr := d.outbound.router.procRouting
+	if pr == nil {
+		return nil, grpcstatus.Error(grpccodes.FailedPrecondition,
+			"process scoped routing is not enabled, set routing.processScoped to true in the config and restart the daemon")
+	}
+	uid, ok := callerUID(ctx)
+	if !ok {
+		return nil, grpcstatus.Error(grpccodes.PermissionDenied, "unable to determine the user that asks for the process to be routed")
+	}
+	if err := pr.addProcess(ctx, int(rp.Pid), uid); err != nil {
+		return nil, grpcstatus.Error(grpccodes.InvalidArgument, err.Error())
+	}
+	return &empty.Empty{}, nil
+}
+
+func (d *service) SetClusterDomain(ctx context.Context, domain *rpc.ClusterDomain) (*empty.Empty, error) {
+	d.outbound.setClusterDomain(ctx, strings.Trim(domain.Domain, "."))
+	return &empty.Empty{}, nil
+}
+
+func (d *service) SetExternalRoutes(ctx context.Context, routes *rpc.ExternalRoutes) (*empty.Empty, error) {
+	subnets := make([]*net.IPNet, len(routes.Subnets))
+	for i, sn := range routes.Subnets {
+		subnets[i] = iputil.IPNetFromRPC(sn)
+	}
+	// The host names are stored in lower case with a trailing dot, the way the DNS resolver looks
+	// them up.
+	hosts := make(map[string][]net.IP, len(routes.Hosts))
+	for host, addrs := range routes.Hosts {
+		ips := make([]net.IP, len(addrs.Ips))
+		for i, ip := range addrs.Ips {
+			ips[i] = ip
+		}
+		hosts[strings.ToLower(strings.TrimSuffix(host, "."))+"."] = ips
+	}
+	if err := d.outbound.setExternalRoutes(ctx, subnets, hosts); err != nil {
+		return nil, err
+	}
+	return &empty.Empty{}, nil
+}
+
 // run is the main function when executing as the daemon
-func run(c context.Context, loggingDir, configDir, dns string) error {
-	if os.Geteuid() != 0 {
-		return fmt.Errorf("telepresence %s must run as root", processName)
+func run(c context.Context, loggingDir, configDir, dns string, debugEnabled bool, timeouts []string) error {
+	unprivileged := os.Geteuid() != 0
+	if unprivileged && !privhelper.Available() {
+		return fmt.Errorf("telepresence %s must run as root unless the privileged helper is installed", processName)
 	}
 
 	// Spoof the AppUserLogDir and AppUserConfigDir so that they return the original user's
@@ -110,14 +167,27 @@ func run(c context.Context, loggingDir, configDir, dns string) error {
 	if err != nil {
 		return err
 	}
+	if err = client.GetConfig(c).Timeouts.ApplyOverrides(timeouts); err != nil {
+		return err
+	}
+	if err = tracing.Init(c, processName, loggingDir, client.GetConfig(c).Tracing.Endpoint); err != nil {
+		return err
+	}
+
+	policy, err := client.GetConfig(c).TLS.Policy()
+	if err != nil {
+		return err
+	}
+	// #nosec G402
+	tlsConfig := &tls.Config{InsecureSkipVerify: true}
+	policy.Apply(tlsConfig)
 
 	d := &service{
 		dns: dns,
 		hClient: &http.Client{
 			Timeout: 15 * time.Second,
 			Transport: &http.Transport{
-				// #nosec G402
-				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+				TLSClientConfig: tlsConfig,
 				Proxy:           nil,
 				DialContext: (&net.Dialer{
 					Timeout:   10 * time.Second,
@@ -145,7 +215,7 @@ func run(c context.Context, loggingDir, configDir, dns string) error {
 	d.cancel = func() { g.Go(processName+"-quit", d.quitAll) }
 
 	dlog.Info(c, "---")
-	dlog.Infof(c, "Telepresence %s %s starting...", processName, client.DisplayVersion())
+	logging.SystemEvent(c, "Telepresence %s %s starting...", processName, client.DisplayVersion())
 	dlog.Infof(c, "PID is %d", os.Getpid())
 	dlog.Info(c, "")
 
@@ -159,6 +229,7 @@ func run(c context.Context, loggingDir, configDir, dns string) error {
 	// server-dns runs a local DNS server that resolves *.cluster.local names.  Exactly where it
 	// listens varies by platform.
 	g.Go("server-dns", func(ctx context.Context) error {
+		ctx = loglevel.WithSubsystem(ctx, loglevel.DNS)
 		select {
 		case <-ctx.Done():
 			return nil
@@ -171,16 +242,38 @@ func run(c context.Context, loggingDir, configDir, dns string) error {
 	// server-router is the worker process starts the router and continuously configures
 	// it based on what is read from that work queue.
 	g.Go("server-router", func(ctx context.Context) error {
-		return d.outbound.routerServerWorker(ctx)
+		return d.outbound.routerServerWorker(loglevel.WithSubsystem(ctx, loglevel.Routing))
 	})
 
+	// server-metrics serves Prometheus metrics on localhost when enabled in the config.
+	g.Go("server-metrics", func(ctx context.Context) error {
+		if err := metrics.Serve(ctx, client.GetConfig(ctx).Metrics.RootDaemonPort); err != nil {
+			dlog.Error(ctx, err)
+		}
+		return nil
+	})
+
+	// server-debug serves pprof and a dump of the daemon's state when started with --debug.
+	if debugEnabled {
+		g.Go("server-debug", func(ctx context.Context) error {
+			// Unlike the gRPC socket, the socket is only accessible to the user that started the
+			// daemon, because the profiles and the state reveal what other users do.
+			if err := debug.Serve(ctx, client.DaemonDebugSocketName, 0600, d.debugState); err != nil {
+				dlog.Error(ctx, err)
+			}
+			return nil
+		})
+	}
+
 	// server-grpc listens on /var/run/telepresence-daemon.socket and services gRPC requests
 	// from the connector and from the CLI.
 	g.Go("server-grpc", func(c context.Context) (err error) {
+		c = loglevel.WithSubsystem(c, loglevel.IPC)
 		defer func() {
 			// Error recovery.
 			if perr := derror.PanicToError(recover()); perr != nil {
 				dlog.Error(c, perr)
+				logging.ReportCrash(c, processName, perr)
 			}
 
 			// Tell the firewall-configurator that we won't be sending it any more
@@ -190,9 +283,14 @@ func run(c context.Context, loggingDir, configDir, dns string) error {
 
 		// Listen on unix domain socket
 		dlog.Debug(c, "gRPC server starting")
-		origUmask := unix.Umask(0)
-		grpcListener, err = net.Listen("unix", client.DaemonSocketName)
-		unix.Umask(origUmask)
+		if unprivileged {
+			// Only root may create the socket, so let the privileged helper do that
+			grpcListener, err = privhelper.NewClient().ListenDaemon(c)
+		} else {
+			origUmask := unix.Umask(0)
+			grpcListener, err = net.Listen("unix", client.DaemonSocketName)
+			unix.Umask(origUmask)
+		}
 		if err != nil {
 			if errors.Is(err, syscall.EADDRINUSE) {
 				return fmt.Errorf("socket %q exists so the %s is either already running or terminated ungracefully",
@@ -213,11 +311,12 @@ func run(c context.Context, loggingDir, configDir, dns string) error {
 			}
 		}()
 
-		svc := grpc.NewServer()
+		svc := grpc.NewServer(append(tracing.ServerOptions(), grpc.ChainUnaryInterceptor(reportPanics), client.KeepalivePolicy())...)
 		rpc.RegisterDaemonServer(svc, d)
 
 		sc := &dhttp.ServerConfig{
-			Handler: svc,
+			Handler:     svc,
+			ConnContext: withPeerUID,
 		}
 		return sc.Serve(c, grpcListener)
 	})
@@ -226,9 +325,17 @@ func run(c context.Context, loggingDir, configDir, dns string) error {
 	if err != nil {
 		dlog.Error(c, err)
 	}
+	logging.SystemEvent(c, "Telepresence %s stopped", processName)
 	return err
 }
 
+// reportPanics writes a crash bundle when a gRPC handler panics. The panic still terminates the
+// daemon.
+func reportPanics(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	defer logging.RecoverAndReport(ctx, processName)
+	return handler(ctx, req)
+}
+
 // quitAll shuts down the router and calls quitConnector
 func (d *service) quitAll(c context.Context) error {
 	d.outbound.router.stop(c)
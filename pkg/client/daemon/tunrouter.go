@@ -6,11 +6,12 @@ import (
 	"fmt"
 	"math/rand"
 	"net"
+	"os"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	"golang.org/x/net/ipv4"
-	"golang.org/x/sys/unix"
 	"google.golang.org/grpc"
 
 	"github.com/datawire/dlib/dgroup"
@@ -18,8 +19,12 @@ import (
 	"github.com/telepresenceio/telepresence/rpc/v2/daemon"
 	"github.com/telepresenceio/telepresence/rpc/v2/manager"
 	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/conflicts"
 	"github.com/telepresenceio/telepresence/v2/pkg/connpool"
+	"github.com/telepresenceio/telepresence/v2/pkg/ipproto"
 	"github.com/telepresenceio/telepresence/v2/pkg/iputil"
+	"github.com/telepresenceio/telepresence/v2/pkg/loglevel"
+	"github.com/telepresenceio/telepresence/v2/pkg/privhelper"
 	"github.com/telepresenceio/telepresence/v2/pkg/subnet"
 	"github.com/telepresenceio/telepresence/v2/pkg/tun"
 	"github.com/telepresenceio/telepresence/v2/pkg/tun/buffer"
@@ -59,6 +64,10 @@ type tunRouter struct {
 	// dev is the TUN device that gets configured with the subnets found in the cluster
 	dev *tun.Device
 
+	// procRouting is non-nil when only the traffic of the processes started with "telepresence run"
+	// is routed to the device
+	procRouting *processRouting
+
 	// managerClient provides the gRPC tunnel to the traffic-manager
 	managerClient manager.ManagerClient
 
@@ -91,9 +100,17 @@ type tunRouter struct {
 	// Subnets configured by the user
 	alsoProxySubnets []*net.IPNet
 
-	// Subnets that the router is currently configured with. Managed, and only used in
-	// the refreshSubnets() method.
-	curSubnets []*net.IPNet
+	// Subnets of the nodes and load balancers of the cluster, sent by the connector. Protected by
+	// the curSubnetsLock.
+	externalSubnets []*net.IPNet
+
+	// Subnets owned by conflicting software on the workstation. They are never routed to the TUN device.
+	neverProxySubnets []*net.IPNet
+
+	// Subnets that the router is currently configured with. Managed by the refreshSubnets()
+	// method and protected by curSubnetsLock.
+	curSubnets     []*net.IPNet
+	curSubnetsLock sync.Mutex
 
 	// closing is set during shutdown and can have the values:
 	//   0 = running
@@ -108,22 +125,45 @@ type tunRouter struct {
 	// the traffic manager and the managerClient has been connected.
 	cfgComplete chan struct{}
 
+	// streamReady will be closed once the connStream has been established
+	streamReady chan struct{}
+
 	// rndSource is the source for the random number generator in the TCP handlers
 	rndSource rand.Source
 }
 
-func newTunRouter() (*tunRouter, error) {
-	td, err := tun.OpenTun()
+func newTunRouter(c context.Context) (*tunRouter, error) {
+	var td *tun.Device
+	var err error
+	if os.Geteuid() != 0 {
+		td, err = tun.OpenTunVia(c, privhelper.NewClient())
+	} else {
+		td, err = tun.OpenTun()
+	}
 	if err != nil {
 		return nil, err
 	}
+	var pr *processRouting
+	if client.GetConfig(c).Routing.ProcessScoped {
+		if pr, err = newProcessRouting(c, td); err != nil {
+			td.Close()
+			return nil, fmt.Errorf("unable to set up process scoped routing: %w", err)
+		}
+	}
+	neverProxy := conflicts.NeverProxySubnets(c)
+	for _, sn := range neverProxy {
+		dlog.Infof(c, "Never proxying subnet %s", sn)
+	}
 	return &tunRouter{
-		dev:         td,
-		handlers:    connpool.NewPool(),
-		toTunCh:     make(chan ip.Packet, 100),
-		cfgComplete: make(chan struct{}),
-		fragmentMap: make(map[uint16][]*buffer.Data),
-		rndSource:   rand.NewSource(time.Now().UnixNano()),
+		dev:               td,
+		procRouting:       pr,
+		handlers:          connpool.NewPool(),
+		toTunCh:           make(chan ip.Packet, 100),
+		cfgComplete:       make(chan struct{}),
+		streamReady:       make(chan struct{}),
+		fragmentMap:       make(map[uint16][]*buffer.Data),
+		rndSource:         rand.NewSource(time.Now().UnixNano()),
+		neverProxySubnets: neverProxy,
 	}, nil
 }
 
@@ -138,13 +178,53 @@ func (t *tunRouter) configureDNS(_ context.Context, dnsIP net.IP, dnsPort uint16
 	return nil
 }
 
+// routedSubnets returns a copy of the subnets that are currently routed to the TUN device
+func (t *tunRouter) routedSubnets() []*net.IPNet {
+	t.curSubnetsLock.Lock()
+	defer t.curSubnetsLock.Unlock()
+	return append([]*net.IPNet(nil), t.curSubnets...)
+}
+
+// setExternalSubnets routes the given subnets to the cluster, in addition to the cluster subnets
+// and also-proxy subnets, and stops routing the subnets of the previous call.
+func (t *tunRouter) setExternalSubnets(ctx context.Context, subnets []*net.IPNet) error {
+	t.curSubnetsLock.Lock()
+	t.externalSubnets = subnets
+	t.curSubnetsLock.Unlock()
+	for _, sn := range subnets {
+		dlog.Infof(ctx, "Adding node or load balancer subnet %s", sn)
+	}
+	return t.refreshSubnets(ctx)
+}
+
 func (t *tunRouter) refreshSubnets(ctx context.Context) error {
+	t.curSubnetsLock.Lock()
+	defer t.curSubnetsLock.Unlock()
+
 	// Create a unique slice of all desired subnets.
-	desired := make([]*net.IPNet, len(t.clusterSubnets)+len(t.alsoProxySubnets))
-	copy(desired, t.clusterSubnets)
-	copy(desired[len(t.clusterSubnets):], t.alsoProxySubnets)
+	desired := make([]*net.IPNet, 0, len(t.clusterSubnets)+len(t.alsoProxySubnets)+len(t.externalSubnets))
+	desired = append(desired, t.clusterSubnets...)
+	desired = append(desired, t.alsoProxySubnets...)
+	desired = append(desired, t.externalSubnets...)
 	desired = subnet.Unique(desired)
 
+	// Drop or split subnets that would hijack the traffic of conflicting software
+	for _, np := range t.neverProxySubnets {
+		var kept []*net.IPNet
+		for _, sn := range desired {
+			switch {
+			case subnet.Covers(np, sn):
+				dlog.Warnf(ctx, "Subnet %s is not proxied because it is covered by never-proxy subnet %s", sn, np)
+			case subnet.Covers(sn, np):
+				dlog.Warnf(ctx, "Subnet %s covers never-proxy subnet %s, so it's routed as smaller subnets that exclude it", sn, np)
+				kept = append(kept, subnet.Exclude(sn, np)...)
+			default:
+				kept = append(kept, sn)
+			}
+		}
+		desired = kept
+	}
+
 	// Remove all no longer desired subnets from the t.curSubnets
 	var removed []*net.IPNet
 	t.curSubnets, removed = subnet.Partition(t.curSubnets, func(_ int, sn *net.IPNet) bool {
@@ -170,19 +250,41 @@ func (t *tunRouter) refreshSubnets(ctx context.Context) error {
 	t.curSubnets = append(t.curSubnets, added...)
 
 	for _, sn := range removed {
-		if err := t.dev.RemoveSubnet(ctx, sn); err != nil {
+		if err := t.removeSubnet(ctx, sn); err != nil {
 			dlog.Errorf(ctx, "failed to remove subnet %s: %v", sn, err)
 		}
 	}
 
 	for _, sn := range added {
-		if err := t.dev.AddSubnet(ctx, sn); err != nil {
+		if err := t.addSubnet(ctx, sn); err != nil {
 			dlog.Errorf(ctx, "failed to add subnet %s: %v", sn, err)
 		}
 	}
 	return nil
 }
 
+// addSubnet routes the given subnet to the TUN device. A subnet with a single address, such as
+// the host routes of external addresses, is routed without assigning it to the device, because
+// its only address would then become an address of the workstation.
+func (t *tunRouter) addSubnet(ctx context.Context, sn *net.IPNet) error {
+	if isHostSubnet(sn) {
+		return t.dev.AddRoute(ctx, sn)
+	}
+	return t.dev.AddSubnet(ctx, sn)
+}
+
+func (t *tunRouter) removeSubnet(ctx context.Context, sn *net.IPNet) error {
+	if isHostSubnet(sn) {
+		return t.dev.RemoveRoute(ctx, sn)
+	}
+	return t.dev.RemoveSubnet(ctx, sn)
+}
+
+func isHostSubnet(sn *net.IPNet) bool {
+	ones, bits := sn.Mask.Size()
+	return ones == bits
+}
+
 func (t *tunRouter) setOutboundInfo(ctx context.Context, mi *daemon.OutboundInfo, kubeDNS chan<- net.IP) (err error) {
 	if t.managerClient == nil {
 		// First check. Establish connection
@@ -192,14 +294,7 @@ func (t *tunRouter) setOutboundInfo(ctx context.Context, mi *daemon.OutboundInfo
 		defer cancel()
 
 		var conn *grpc.ClientConn
-		var opts []grpc.DialOption
-		if mxRecvSize := clientConfig.Grpc.MaxReceiveSize; mxRecvSize != nil {
-			if mz, ok := mxRecvSize.AsInt64(); ok {
-				opts = append(opts, grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(int(mz))))
-			}
-		}
-
-		conn, err = client.DialSocket(tc, client.ConnectorSocketName, opts...)
+		conn, err = client.DialSocket(tc, client.ConnectorSocketName, clientConfig.Grpc.DialOptions()...)
 		if err != nil {
 			return client.CheckTimeout(tc, err)
 		}
@@ -268,7 +363,9 @@ func (t *tunRouter) watchClusterInfo(ctx context.Context, kubeDNS chan<- net.IP)
 			subnets = append(subnets, cidr)
 		}
 
+		t.curSubnetsLock.Lock()
 		t.clusterSubnets = subnets
+		t.curSubnetsLock.Unlock()
 		if err := t.refreshSubnets(ctx); err != nil {
 			dlog.Error(ctx, err)
 		}
@@ -293,6 +390,9 @@ func (t *tunRouter) stop(c context.Context) {
 		<-cc.Done()
 	}
 	if atomic.CompareAndSwapInt32(&t.closing, 1, 2) {
+		if t.procRouting != nil {
+			t.procRouting.close(c)
+		}
 		t.dev.Close()
 	}
 }
@@ -329,6 +429,7 @@ func (t *tunRouter) run(c context.Context) error {
 	})
 
 	g.Go("MGR stream", func(c context.Context) error {
+		c = loglevel.WithSubsystem(c, loglevel.Tunnel)
 		dlog.Debug(c, "Waiting until manager gRPC is configured")
 		select {
 		case <-c.Done():
@@ -343,7 +444,8 @@ func (t *tunRouter) run(c context.Context) error {
 		if err = tunnel.Send(connpool.SessionInfoControl(t.session).TunnelMessage()); err != nil {
 			return err
 		}
-		t.connStream = connpool.NewStream(tunnel)
+		t.connStream = connpool.NewStream(&countingTunnel{Manager_ClientTunnelClient: tunnel})
+		close(t.streamReady)
 		dlog.Debug(c, "MGR read loop starting")
 		err = t.connStream.DialLoop(c, &t.closing, t.handlers)
 		var recvErr *client.RecvEOF
@@ -354,11 +456,11 @@ func (t *tunRouter) run(c context.Context) error {
 	})
 
 	g.Go("TUN reader", func(c context.Context) error {
-		dlog.Debug(c, "Waiting until manager gRPC is configured")
+		dlog.Debug(c, "Waiting until manager gRPC stream is established")
 		select {
 		case <-c.Done():
 			return nil
-		case <-t.cfgComplete:
+		case <-t.streamReady:
 		}
 
 		dlog.Debug(c, "TUN read loop starting")
@@ -415,11 +517,17 @@ func (t *tunRouter) handlePacket(c context.Context, data *buffer.Data) {
 		}
 	} // TODO: similar for ipv6 using segments
 
+	if t.neverProxied(ipHdr.Destination()) {
+		// A routed subnet covers a never-proxy subnet, whose traffic must not reach the cluster
+		t.toTunCh <- icmp.DestinationUnreachablePacket(ipHdr, icmp.CommunicationProhibited)
+		return
+	}
+
 	switch ipHdr.L4Protocol() {
-	case unix.IPPROTO_TCP:
+	case ipproto.TCP:
 		t.tcp(c, tcp.PacketFromData(ipHdr, data))
 		data = nil
-	case unix.IPPROTO_UDP:
+	case ipproto.UDP:
 		dst := ipHdr.Destination()
 		if !dst.IsGlobalUnicast() {
 			// Just ignore at this point.
@@ -438,8 +546,8 @@ func (t *tunRouter) handlePacket(c context.Context, data *buffer.Data) {
 		}
 		data = nil
 		t.udp(c, dg)
-	case unix.IPPROTO_ICMP:
-	case unix.IPPROTO_ICMPV6:
+	case ipproto.ICMP:
+	case ipproto.ICMPV6:
 		pkt := icmp.PacketFromData(ipHdr, data)
 		dlog.Debugf(c, "<- TUN %s", pkt)
 	default:
@@ -448,6 +556,18 @@ func (t *tunRouter) handlePacket(c context.Context, data *buffer.Data) {
 	}
 }
 
+// neverProxied returns true if the given address is in a never-proxy subnet. The subnets that
+// refreshSubnets routes exclude such subnets, but the traffic to them can still arrive at the TUN
+// device, e.g. from a client that binds to its address.
+func (t *tunRouter) neverProxied(dst net.IP) bool {
+	for _, np := range t.neverProxySubnets {
+		if np.Contains(dst) {
+			return true
+		}
+	}
+	return false
+}
+
 func (t *tunRouter) tcp(c context.Context, pkt tcp.Packet) {
 	ipHdr := pkt.IPHeader()
 	tcpHdr := pkt.Header()
@@ -457,7 +577,7 @@ func (t *tunRouter) tcp(c context.Context, pkt tcp.Packet) {
 		return
 	}
 
-	connID := connpool.NewConnID(unix.IPPROTO_TCP, ipHdr.Source(), ipHdr.Destination(), tcpHdr.SourcePort(), tcpHdr.DestinationPort())
+	connID := connpool.NewConnID(ipproto.TCP, ipHdr.Source(), ipHdr.Destination(), tcpHdr.SourcePort(), tcpHdr.DestinationPort())
 	wf, _, err := t.handlers.Get(c, connID, func(c context.Context, remove func()) (connpool.Handler, error) {
 		return tcp.NewHandler(t.connStream, &t.closing, t.toTunCh, connID, remove, t.rndSource), nil
 	})
@@ -471,7 +591,7 @@ func (t *tunRouter) tcp(c context.Context, pkt tcp.Packet) {
 func (t *tunRouter) udp(c context.Context, dg udp.Datagram) {
 	ipHdr := dg.IPHeader()
 	udpHdr := dg.Header()
-	connID := connpool.NewConnID(unix.IPPROTO_UDP, ipHdr.Source(), ipHdr.Destination(), udpHdr.SourcePort(), udpHdr.DestinationPort())
+	connID := connpool.NewConnID(ipproto.UDP, ipHdr.Source(), ipHdr.Destination(), udpHdr.SourcePort(), udpHdr.DestinationPort())
 	uh, _, err := t.handlers.Get(c, connID, func(c context.Context, remove func()) (connpool.Handler, error) {
 		if udpHdr.DestinationPort() == t.dnsPort && ipHdr.Destination().Equal(t.dnsIP) {
 			return udp.NewDnsInterceptor(t.connStream, t.toTunCh, connID, remove, t.dnsLocalAddr)
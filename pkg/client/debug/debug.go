@@ -0,0 +1,87 @@
+// Package debug serves the profiling endpoints of net/http/pprof and a dump of the internal state
+// of a daemon over a unix socket, so that hangs and leaks can be investigated in a running daemon.
+package debug
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"time"
+
+	"github.com/datawire/dlib/dhttp"
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/peercred"
+)
+
+// StateFunc returns the internal state of a daemon. The returned value is encoded as JSON.
+type StateFunc func(ctx context.Context) interface{}
+
+// Serve serves the pprof endpoints under /debug/pprof/ and the value returned by state under
+// /debug/state on the unix socket at the given path until the context is cancelled. The
+// permissions of the socket are set to perm. A process that runs as root on behalf of a user, i.e.
+// that is started using sudo, gives the socket to that user, so that a socket that only its owner
+// may access can be reached by the user and by no one else.
+func Serve(ctx context.Context, socketPath string, perm os.FileMode, state StateFunc) error {
+	removeStaleSocket(socketPath)
+	ln, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return fmt.Errorf("unable to listen for debug requests: %w", err)
+	}
+	if err = os.Chmod(socketPath, perm); err == nil {
+		err = peercred.ChownToSudoUser(socketPath)
+	}
+	if err != nil {
+		_ = ln.Close()
+		return err
+	}
+	dlog.Infof(ctx, "Serving debug information on %s", socketPath)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("/debug/state", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(state(r.Context())); err != nil {
+			dlog.Errorf(ctx, "unable to encode debug state: %v", err)
+		}
+	})
+	sc := &dhttp.ServerConfig{
+		Handler: mux,
+	}
+	return sc.Serve(ctx, ln)
+}
+
+// removeStaleSocket removes a socket that was left behind by a process that terminated
+// ungracefully. A socket that something listens to is left alone.
+func removeStaleSocket(socketPath string) {
+	if _, err := os.Stat(socketPath); err != nil {
+		return
+	}
+	if conn, err := net.DialTimeout("unix", socketPath, time.Second); err == nil {
+		_ = conn.Close()
+		return
+	}
+	_ = os.Remove(socketPath)
+}
+
+// Client returns an HTTP client that sends all requests to the unix socket at the given path.
+func Client(socketPath string) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy: nil,
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socketPath)
+			},
+		},
+	}
+}
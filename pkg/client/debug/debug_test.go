@@ -0,0 +1,61 @@
+// +build !windows
+
+package debug
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+)
+
+func TestServe(t *testing.T) {
+	if os.Geteuid() == 0 {
+		defer os.Unsetenv("SUDO_UID")
+		defer os.Unsetenv("SUDO_GID")
+		os.Setenv("SUDO_UID", "4711")
+		os.Setenv("SUDO_GID", "4712")
+	}
+	socketPath := filepath.Join(t.TempDir(), "debug.socket")
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- Serve(ctx, socketPath, 0600, func(context.Context) interface{} { return map[string]int{"answer": 42} })
+	}()
+	defer func() {
+		cancel()
+		if err := <-errCh; err != ctx.Err() {
+			assert.NoError(t, err)
+		}
+	}()
+
+	var rsp []byte
+	require.Eventually(t, func() bool {
+		r, err := Client(socketPath).Get("http://debug/debug/state")
+		if err != nil {
+			return false
+		}
+		defer r.Body.Close()
+		rsp, err = ioutil.ReadAll(r.Body)
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.JSONEq(t, `{"answer": 42}`, string(rsp))
+
+	st, err := os.Stat(socketPath)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), st.Mode().Perm())
+	if os.Geteuid() == 0 {
+		// The user that used sudo to start the process owns the socket
+		sys := st.Sys().(*syscall.Stat_t)
+		assert.Equal(t, uint32(4711), sys.Uid)
+		assert.Equal(t, uint32(4712), sys.Gid)
+	}
+}
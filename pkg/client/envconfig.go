@@ -3,6 +3,7 @@ package client
 import (
 	"context"
 	"os"
+	"strconv"
 
 	"github.com/sethvargo/go-envconfig"
 )
@@ -19,10 +20,21 @@ type Env struct {
 	LoginClientID      string `env:"TELEPRESENCE_LOGIN_CLIENT_ID,default=telepresence-cli"`
 	UserInfoURL        string `env:"TELEPRESENCE_USER_INFO_URL,default=https://${TELEPRESENCE_LOGIN_DOMAIN}/api/userinfo"`
 
-	ManagerNamespace string `env:"TELEPRESENCE_MANAGER_NAMESPACE,default=ambassador"`
+	// ManagerNamespace is empty unless it's set explicitly, so that the namespace of the
+	// traffic-manager can be taken from the config or be discovered.
+	ManagerNamespace string `env:"TELEPRESENCE_MANAGER_NAMESPACE,default="`
 
 	SystemAHost string `env:"SYSTEMA_HOST,default=app.getambassador.io"`
 	SystemAPort string `env:"SYSTEMA_PORT,default=443"`
+
+	// ProxyAddress, when set, makes the connector run without a root daemon and instead provide
+	// a SOCKS5 proxy on this address. ProxyDNSAddress is the address of an optional DNS stub.
+	ProxyAddress    string `env:"TELEPRESENCE_PROXY_ADDRESS,default="`
+	ProxyDNSAddress string `env:"TELEPRESENCE_PROXY_DNS_ADDRESS,default="`
+
+	// MockCluster makes the connector serve a mock cluster instead of connecting to a real one.
+	// The services of the mock cluster are reached through the SOCKS5 proxy at ProxyAddress.
+	MockCluster bool `env:"TELEPRESENCE_MOCK_CLUSTER,default=false"`
 }
 
 func (env Env) Get(key string) string {
@@ -48,6 +60,13 @@ func (env Env) Get(key string) string {
 	case "SYSTEMA_PORT":
 		return env.SystemAPort
 
+	case "TELEPRESENCE_PROXY_ADDRESS":
+		return env.ProxyAddress
+	case "TELEPRESENCE_PROXY_DNS_ADDRESS":
+		return env.ProxyDNSAddress
+	case "TELEPRESENCE_MOCK_CLUSTER":
+		return strconv.FormatBool(env.MockCluster)
+
 	default:
 		return os.Getenv(key)
 	}
@@ -0,0 +1,364 @@
+package fake
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	grpcCodes "google.golang.org/grpc/codes"
+	grpcStatus "google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	empty "google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/telepresenceio/telepresence/rpc/v2/common"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+)
+
+// Connector is an in-memory connector. It's connected by Connect, and keeps the intercepts that
+// are created and removed in memory. The exported fields configure it, and must not be changed
+// once it's served.
+type Connector struct {
+	rpc.UnimplementedConnectorServer
+
+	// ClusterContext, ClusterServer, and ClusterID are reported by Connect and Status
+	ClusterContext string
+	ClusterServer  string
+	ClusterID      string
+
+	// ConnectError makes Connect fail with the given error and ConnectErrorText
+	ConnectError     rpc.ConnectInfo_ErrType
+	ConnectErrorText string
+
+	// Workloads are the workloads of each namespace. They are listed by List, and can be
+	// intercepted using their name as the agent of the intercept spec.
+	Workloads map[string][]*rpc.WorkloadInfo
+
+	// AccessToken is the token of a logged in user
+	AccessToken string
+
+	mu         sync.Mutex
+	connected  bool
+	loggedIn   bool
+	quit       bool
+	intercepts []*manager.InterceptInfo
+	nextID     int
+	calls      []string
+	listeners  []chan string
+}
+
+func (c *Connector) record(call string) {
+	c.mu.Lock()
+	c.calls = append(c.calls, call)
+	c.mu.Unlock()
+}
+
+// Calls returns the names of the gRPC calls that the connector has received, in order.
+func (c *Connector) Calls() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.calls...)
+}
+
+// Connected returns true if Connect has been called successfully, and Quit hasn't.
+func (c *Connector) Connected() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.connected
+}
+
+// QuitCalled returns true if Quit has been called.
+func (c *Connector) QuitCalled() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.quit
+}
+
+// Intercepts returns the current intercepts.
+func (c *Connector) Intercepts() []*manager.InterceptInfo {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ics := make([]*manager.InterceptInfo, len(c.intercepts))
+	for i, ic := range c.intercepts {
+		ics[i] = proto.Clone(ic).(*manager.InterceptInfo)
+	}
+	return ics
+}
+
+// Notify sends a message to the clients that listen to the UserNotifications of the connector.
+func (c *Connector) Notify(msg string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, l := range c.listeners {
+		select {
+		case l <- msg:
+		default:
+		}
+	}
+}
+
+func (c *Connector) Version(context.Context, *empty.Empty) (*common.VersionInfo, error) {
+	c.record("Version")
+	return &common.VersionInfo{
+		ApiVersion: client.APIVersion,
+		Version:    client.Version(),
+	}, nil
+}
+
+func (c *Connector) connectInfo(errType rpc.ConnectInfo_ErrType) *rpc.ConnectInfo {
+	ci := &rpc.ConnectInfo{
+		Error:          errType,
+		ClusterContext: c.ClusterContext,
+		ClusterServer:  c.ClusterServer,
+		ClusterId:      c.ClusterID,
+		BridgeOk:       true,
+		SessionInfo:    &manager.SessionInfo{SessionId: "fake-session"},
+		Intercepts:     &manager.InterceptInfoSnapshot{},
+	}
+	for _, ic := range c.intercepts {
+		ci.Intercepts.Intercepts = append(ci.Intercepts.Intercepts, proto.Clone(ic).(*manager.InterceptInfo))
+	}
+	return ci
+}
+
+func (c *Connector) Connect(context.Context, *rpc.ConnectRequest) (*rpc.ConnectInfo, error) {
+	c.record("Connect")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ConnectError != rpc.ConnectInfo_UNSPECIFIED {
+		return &rpc.ConnectInfo{Error: c.ConnectError, ErrorText: c.ConnectErrorText}, nil
+	}
+	if c.connected {
+		return c.connectInfo(rpc.ConnectInfo_ALREADY_CONNECTED), nil
+	}
+	c.connected = true
+	return c.connectInfo(rpc.ConnectInfo_UNSPECIFIED), nil
+}
+
+func (c *Connector) Status(context.Context, *rpc.ConnectRequest) (*rpc.ConnectInfo, error) {
+	c.record("Status")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.connected {
+		return &rpc.ConnectInfo{Error: rpc.ConnectInfo_DISCONNECTED}, nil
+	}
+	return c.connectInfo(rpc.ConnectInfo_ALREADY_CONNECTED), nil
+}
+
+// workload returns the workload with the given name in the given namespace. The caller must hold
+// the lock.
+func (c *Connector) workload(namespace, name string) *rpc.WorkloadInfo {
+	for _, wl := range c.Workloads[namespaceOrDefault(namespace)] {
+		if wl.Name == name {
+			return wl
+		}
+	}
+	return nil
+}
+
+func namespaceOrDefault(namespace string) string {
+	if namespace == "" {
+		return "default"
+	}
+	return namespace
+}
+
+func (c *Connector) CreateIntercept(_ context.Context, ir *rpc.CreateInterceptRequest) (*rpc.InterceptResult, error) {
+	c.record("CreateIntercept")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.connected {
+		return &rpc.InterceptResult{Error: rpc.InterceptError_NO_CONNECTION}, nil
+	}
+	if ir.GetSpec() == nil {
+		return nil, grpcStatus.Error(grpcCodes.InvalidArgument, "intercept spec is missing")
+	}
+	spec := proto.Clone(ir.GetSpec()).(*manager.InterceptSpec)
+	spec.Namespace = namespaceOrDefault(spec.Namespace)
+	for _, ic := range c.intercepts {
+		if ic.Spec.Name == spec.Name {
+			return &rpc.InterceptResult{Error: rpc.InterceptError_ALREADY_EXISTS, ErrorText: spec.Name}, nil
+		}
+	}
+	wl := c.workload(spec.Namespace, spec.Agent)
+	if wl == nil {
+		return &rpc.InterceptResult{Error: rpc.InterceptError_NO_ACCEPTABLE_WORKLOAD, ErrorText: spec.Agent}, nil
+	}
+	if wl.NotInterceptableReason != "" {
+		return &rpc.InterceptResult{Error: rpc.InterceptError_NO_ACCEPTABLE_WORKLOAD, ErrorText: wl.NotInterceptableReason}, nil
+	}
+	if spec.WorkloadKind == "" {
+		spec.WorkloadKind = wl.WorkloadResourceType
+	}
+	c.nextID++
+	ic := &manager.InterceptInfo{
+		Spec:          spec,
+		Id:            fmt.Sprintf("fake-session:%d", c.nextID),
+		ClientSession: &manager.SessionInfo{SessionId: "fake-session"},
+		Disposition:   manager.InterceptDispositionType_ACTIVE,
+	}
+	c.intercepts = append(c.intercepts, ic)
+	return &rpc.InterceptResult{
+		InterceptInfo: proto.Clone(ic).(*manager.InterceptInfo),
+		WorkloadKind:  spec.WorkloadKind,
+		Environment:   map[string]string{},
+	}, nil
+}
+
+func (c *Connector) RemoveIntercept(_ context.Context, rr *manager.RemoveInterceptRequest2) (*rpc.InterceptResult, error) {
+	c.record("RemoveIntercept")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.connected {
+		return &rpc.InterceptResult{Error: rpc.InterceptError_NO_CONNECTION}, nil
+	}
+	for i, ic := range c.intercepts {
+		if ic.Spec.Name == rr.Name {
+			c.intercepts = append(c.intercepts[:i], c.intercepts[i+1:]...)
+			return &rpc.InterceptResult{}, nil
+		}
+	}
+	return &rpc.InterceptResult{Error: rpc.InterceptError_NOT_FOUND, ErrorText: rr.Name}, nil
+}
+
+func (c *Connector) List(_ context.Context, lr *rpc.ListRequest) (*rpc.WorkloadInfoSnapshot, error) {
+	c.record("List")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.connected {
+		return &rpc.WorkloadInfoSnapshot{}, nil
+	}
+	namespace := namespaceOrDefault(lr.Namespace)
+	snapshot := &rpc.WorkloadInfoSnapshot{}
+	for _, wl := range c.Workloads[namespace] {
+		wl = proto.Clone(wl).(*rpc.WorkloadInfo)
+		for _, ic := range c.intercepts {
+			if ic.Spec.Namespace == namespace && ic.Spec.Agent == wl.Name {
+				wl.InterceptInfo = proto.Clone(ic).(*manager.InterceptInfo)
+				break
+			}
+		}
+		switch lr.Filter {
+		case rpc.ListRequest_INTERCEPTS:
+			if wl.InterceptInfo == nil {
+				continue
+			}
+		case rpc.ListRequest_INSTALLED_AGENTS:
+			if wl.AgentInfo == nil {
+				continue
+			}
+		case rpc.ListRequest_INTERCEPTABLE:
+			if wl.NotInterceptableReason != "" {
+				continue
+			}
+		}
+		snapshot.Workloads = append(snapshot.Workloads, wl)
+	}
+	return snapshot, nil
+}
+
+func (c *Connector) Uninstall(_ context.Context, ur *rpc.UninstallRequest) (*rpc.UninstallResult, error) {
+	c.record("Uninstall")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.connected {
+		return &rpc.UninstallResult{ErrorText: "not connected"}, nil
+	}
+	namespace := namespaceOrDefault(ur.Namespace)
+	named := make(map[string]bool, len(ur.Agents))
+	for _, a := range ur.Agents {
+		named[a] = true
+	}
+	uninstalled := func(ns, name string) bool {
+		return ur.UninstallType != rpc.UninstallRequest_NAMED_AGENTS || ns == namespace && named[name]
+	}
+	for ns, wls := range c.Workloads {
+		for _, wl := range wls {
+			if uninstalled(ns, wl.Name) {
+				wl.AgentInfo = nil
+			}
+		}
+	}
+	ics := c.intercepts[:0]
+	for _, ic := range c.intercepts {
+		if !uninstalled(ic.Spec.Namespace, ic.Spec.Agent) {
+			ics = append(ics, ic)
+		}
+	}
+	c.intercepts = ics
+	return &rpc.UninstallResult{}, nil
+}
+
+func (c *Connector) UserNotifications(_ *empty.Empty, stream rpc.Connector_UserNotificationsServer) error {
+	c.record("UserNotifications")
+	ch := make(chan string, 10)
+	c.mu.Lock()
+	c.listeners = append(c.listeners, ch)
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		for i, l := range c.listeners {
+			if l == ch {
+				c.listeners = append(c.listeners[:i], c.listeners[i+1:]...)
+				break
+			}
+		}
+		c.mu.Unlock()
+	}()
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case msg := <-ch:
+			if err := stream.Send(&rpc.Notification{Message: msg}); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (c *Connector) Login(context.Context, *empty.Empty) (*rpc.LoginResult, error) {
+	c.record("Login")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.loggedIn {
+		return &rpc.LoginResult{Code: rpc.LoginResult_OLD_LOGIN_REUSED}, nil
+	}
+	c.loggedIn = true
+	return &rpc.LoginResult{Code: rpc.LoginResult_NEW_LOGIN_SUCCEEDED}, nil
+}
+
+func (c *Connector) Logout(context.Context, *empty.Empty) (*empty.Empty, error) {
+	c.record("Logout")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.loggedIn {
+		return nil, grpcStatus.Error(grpcCodes.NotFound, "not logged in")
+	}
+	c.loggedIn = false
+	return &empty.Empty{}, nil
+}
+
+func (c *Connector) GetCloudAccessToken(_ context.Context, req *rpc.TokenReq) (*rpc.TokenData, error) {
+	c.record("GetCloudAccessToken")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.loggedIn {
+		if !req.AutoLogin {
+			return nil, grpcStatus.Error(grpcCodes.Unauthenticated, "not logged in")
+		}
+		c.loggedIn = true
+	}
+	return &rpc.TokenData{AccessToken: c.AccessToken}, nil
+}
+
+func (c *Connector) Quit(context.Context, *empty.Empty) (*empty.Empty, error) {
+	c.record("Quit")
+	c.mu.Lock()
+	c.quit = true
+	c.connected = false
+	c.intercepts = nil
+	c.mu.Unlock()
+	return &empty.Empty{}, nil
+}
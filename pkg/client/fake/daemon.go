@@ -0,0 +1,124 @@
+package fake
+
+import (
+	"context"
+	"sync"
+
+	"google.golang.org/protobuf/proto"
+	empty "google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/telepresenceio/telepresence/rpc/v2/common"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/daemon"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+)
+
+// Daemon is an in-memory root daemon. It keeps the outbound info and the DNS search path that it's
+// given, but doesn't change the network of the host.
+type Daemon struct {
+	rpc.UnimplementedDaemonServer
+
+	mu         sync.Mutex
+	outbound   *rpc.OutboundInfo
+	searchPath []string
+	pids       []int32
+	quit       bool
+	calls      []string
+}
+
+func (d *Daemon) record(call string) {
+	d.mu.Lock()
+	d.calls = append(d.calls, call)
+	d.mu.Unlock()
+}
+
+// Calls returns the names of the gRPC calls that the daemon has received, in order.
+func (d *Daemon) Calls() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]string(nil), d.calls...)
+}
+
+// OutboundInfo returns the outbound info that was set last, or nil if none has been set.
+func (d *Daemon) OutboundInfo() *rpc.OutboundInfo {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.outbound == nil {
+		return nil
+	}
+	return proto.Clone(d.outbound).(*rpc.OutboundInfo)
+}
+
+// DNSSearchPath returns the DNS search path that was set last.
+func (d *Daemon) DNSSearchPath() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]string(nil), d.searchPath...)
+}
+
+// RoutedProcesses returns the pids of the processes that have been added by AddRoutedProcess.
+func (d *Daemon) RoutedProcesses() []int32 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]int32(nil), d.pids...)
+}
+
+// QuitCalled returns true if Quit has been called.
+func (d *Daemon) QuitCalled() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.quit
+}
+
+func (d *Daemon) Version(context.Context, *empty.Empty) (*common.VersionInfo, error) {
+	d.record("Version")
+	return &common.VersionInfo{
+		ApiVersion: client.APIVersion,
+		Version:    client.Version(),
+	}, nil
+}
+
+func (d *Daemon) Status(context.Context, *empty.Empty) (*rpc.DaemonStatus, error) {
+	d.record("Status")
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	r := &rpc.DaemonStatus{}
+	if d.outbound != nil {
+		r.OutboundConfig = proto.Clone(d.outbound).(*rpc.OutboundInfo)
+	}
+	return r, nil
+}
+
+func (d *Daemon) Quit(context.Context, *empty.Empty) (*empty.Empty, error) {
+	d.record("Quit")
+	d.mu.Lock()
+	d.quit = true
+	d.outbound = nil
+	d.searchPath = nil
+	d.pids = nil
+	d.mu.Unlock()
+	return &empty.Empty{}, nil
+}
+
+func (d *Daemon) SetOutboundInfo(_ context.Context, info *rpc.OutboundInfo) (*empty.Empty, error) {
+	d.record("SetOutboundInfo")
+	d.mu.Lock()
+	d.outbound = proto.Clone(info).(*rpc.OutboundInfo)
+	d.mu.Unlock()
+	return &empty.Empty{}, nil
+}
+
+func (d *Daemon) SetDnsSearchPath(_ context.Context, paths *rpc.Paths) (*empty.Empty, error) {
+	d.record("SetDnsSearchPath")
+	d.mu.Lock()
+	d.searchPath = append([]string(nil), paths.Paths...)
+	d.mu.Unlock()
+	return &empty.Empty{}, nil
+}
+
+func (d *Daemon) AddRoutedProcess(_ context.Context, rp *rpc.RoutedProcess) (*empty.Empty, error) {
+	d.record("AddRoutedProcess")
+	d.mu.Lock()
+	d.pids = append(d.pids, rp.Pid)
+	d.mu.Unlock()
+	return &empty.Empty{}, nil
+}
@@ -0,0 +1,114 @@
+package fake_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	empty "google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/rpc/v2/daemon"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/fake"
+	"github.com/telepresenceio/telepresence/v2/pkg/filelocation"
+)
+
+func testContext(t *testing.T) context.Context {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	t.Cleanup(cancel)
+	return filelocation.WithAppUserConfigDir(ctx, t.TempDir())
+}
+
+func TestConnector(t *testing.T) {
+	conn := &fake.Connector{
+		ClusterContext: "test",
+		Workloads: map[string][]*connector.WorkloadInfo{
+			"default": {
+				{Name: "echo", WorkloadResourceType: "Deployment"},
+				{Name: "db", WorkloadResourceType: "StatefulSet", NotInterceptableReason: "no service"},
+			},
+		},
+	}
+	ctx := fake.WithDaemons(testContext(t), conn, &fake.Daemon{})
+	require.True(t, client.SocketExistsContext(ctx, client.ConnectorSocketName))
+
+	cc, err := client.DialSocket(ctx, client.ConnectorSocketName)
+	require.NoError(t, err)
+	defer cc.Close()
+	cl := connector.NewConnectorClient(cc)
+
+	ci, err := cl.Status(ctx, &connector.ConnectRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, connector.ConnectInfo_DISCONNECTED, ci.Error)
+
+	ci, err = cl.Connect(ctx, &connector.ConnectRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, connector.ConnectInfo_UNSPECIFIED, ci.Error)
+	assert.Equal(t, "test", ci.ClusterContext)
+
+	ci, err = cl.Connect(ctx, &connector.ConnectRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, connector.ConnectInfo_ALREADY_CONNECTED, ci.Error)
+
+	ir, err := cl.CreateIntercept(ctx, &connector.CreateInterceptRequest{Spec: &manager.InterceptSpec{Name: "echo", Agent: "echo"}})
+	require.NoError(t, err)
+	assert.Equal(t, connector.InterceptError_UNSPECIFIED, ir.Error)
+	assert.Equal(t, "Deployment", ir.WorkloadKind)
+
+	ir, err = cl.CreateIntercept(ctx, &connector.CreateInterceptRequest{Spec: &manager.InterceptSpec{Name: "echo", Agent: "echo"}})
+	require.NoError(t, err)
+	assert.Equal(t, connector.InterceptError_ALREADY_EXISTS, ir.Error)
+
+	ir, err = cl.CreateIntercept(ctx, &connector.CreateInterceptRequest{Spec: &manager.InterceptSpec{Name: "db", Agent: "db"}})
+	require.NoError(t, err)
+	assert.Equal(t, connector.InterceptError_NO_ACCEPTABLE_WORKLOAD, ir.Error)
+
+	wls, err := cl.List(ctx, &connector.ListRequest{Filter: connector.ListRequest_INTERCEPTS})
+	require.NoError(t, err)
+	require.Len(t, wls.Workloads, 1)
+	assert.Equal(t, "echo", wls.Workloads[0].InterceptInfo.Spec.Name)
+	require.Len(t, conn.Intercepts(), 1)
+
+	ir, err = cl.RemoveIntercept(ctx, &manager.RemoveInterceptRequest2{Name: "echo"})
+	require.NoError(t, err)
+	assert.Equal(t, connector.InterceptError_UNSPECIFIED, ir.Error)
+	assert.Empty(t, conn.Intercepts())
+
+	_, err = cl.Quit(ctx, &empty.Empty{})
+	require.NoError(t, err)
+	assert.True(t, conn.QuitCalled())
+	assert.False(t, conn.Connected())
+	assert.Equal(t, []string{"Status", "Connect", "Connect", "CreateIntercept", "CreateIntercept", "CreateIntercept", "List", "RemoveIntercept", "Quit"}, conn.Calls())
+}
+
+func TestDaemon(t *testing.T) {
+	dmn := &fake.Daemon{}
+	ctx := fake.WithDaemons(testContext(t), nil, dmn)
+	assert.False(t, client.SocketExistsContext(ctx, client.ConnectorSocketName))
+
+	_, err := client.DialSocket(ctx, client.ConnectorSocketName)
+	assert.Error(t, err, "the connector isn't served")
+
+	cc, err := client.DialSocket(ctx, client.DaemonSocketName)
+	require.NoError(t, err)
+	defer cc.Close()
+	cl := daemon.NewDaemonClient(cc)
+
+	_, err = cl.SetDnsSearchPath(ctx, &daemon.Paths{Paths: []string{"default", "kube-system"}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"default", "kube-system"}, dmn.DNSSearchPath())
+
+	_, err = cl.SetOutboundInfo(ctx, &daemon.OutboundInfo{Session: &manager.SessionInfo{SessionId: "s1"}})
+	require.NoError(t, err)
+	st, err := cl.Status(ctx, &empty.Empty{})
+	require.NoError(t, err)
+	assert.Equal(t, "s1", st.OutboundConfig.Session.SessionId)
+
+	_, err = cl.AddRoutedProcess(ctx, &daemon.RoutedProcess{Pid: 42})
+	require.NoError(t, err)
+	assert.Equal(t, []int32{42}, dmn.RoutedProcesses())
+}
@@ -0,0 +1,112 @@
+// Package fake contains in-memory implementations of the gRPC services of the connector and the
+// daemon. They let tests of the CLI, and of other clients of the daemons, run without root, a
+// cluster, or any daemon processes.
+//
+// The fakes are served on in-memory sockets that client.DialSocket dials when the context has
+// been prepared by WithDaemons. A test that runs a CLI command with a fake connector and daemon
+// looks like this:
+//
+//	conn := &fake.Connector{ClusterContext: "test"}
+//	ctx := fake.WithDaemons(dlog.NewTestContext(t, false), conn, &fake.Daemon{})
+//	cmd := cli.Command(ctx)
+//	cmd.SetArgs([]string{"connect"})
+//	err := cmd.ExecuteContext(ctx)
+//
+// The fakes keep their state in memory, so a test can inspect what a command did, e.g. by calling
+// Intercepts on the connector.
+package fake
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/rpc/v2/daemon"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+)
+
+const bufSize = 256 * 1024
+
+// Sockets are in-memory sockets that gRPC servers are served on. Sockets implements
+// client.SocketDialer.
+type Sockets struct {
+	mu        sync.Mutex
+	listeners map[string]*bufconn.Listener
+}
+
+// NewSockets returns Sockets that serve nothing yet.
+func NewSockets() *Sockets {
+	return &Sockets{listeners: make(map[string]*bufconn.Listener)}
+}
+
+// Serve serves a gRPC server on the socket with the given name until the context is done. The
+// services of the server are registered by the given function.
+func (s *Sockets) Serve(ctx context.Context, socketName string, register func(*grpc.Server)) {
+	lis := bufconn.Listen(bufSize)
+	srv := grpc.NewServer()
+	register(srv)
+
+	s.mu.Lock()
+	s.listeners[socketName] = lis
+	s.mu.Unlock()
+
+	go func() {
+		if err := srv.Serve(lis); err != nil {
+			dlog.Errorf(ctx, "fake server on %s failed: %v", socketName, err)
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		s.mu.Lock()
+		delete(s.listeners, socketName)
+		s.mu.Unlock()
+		srv.Stop()
+	}()
+}
+
+// Serves returns true if a server is served on the socket with the given name.
+func (s *Sockets) Serves(socketName string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.listeners[socketName]
+	return ok
+}
+
+// Dial dials the socket with the given name.
+func (s *Sockets) Dial(_ context.Context, socketName string) (net.Conn, error) {
+	s.mu.Lock()
+	lis, ok := s.listeners[socketName]
+	s.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", socketName, os.ErrNotExist)
+	}
+	return lis.Dial()
+}
+
+// WithDaemons serves the given connector and daemon on in-memory sockets until the context is
+// done, and returns a context in which client.DialSocket dials them. Either may be nil, e.g. a nil
+// daemon gives a connector that runs without a root daemon, as with --proxy-via-container.
+//
+// The CLI doesn't launch any daemon processes when the context has a socket dialer, so a command
+// that needs a daemon that isn't given fails.
+func WithDaemons(ctx context.Context, conn *Connector, dmn *Daemon) context.Context {
+	s := NewSockets()
+	if conn != nil {
+		s.Serve(ctx, client.ConnectorSocketName, func(srv *grpc.Server) {
+			connector.RegisterConnectorServer(srv, conn)
+		})
+	}
+	if dmn != nil {
+		s.Serve(ctx, client.DaemonSocketName, func(srv *grpc.Server) {
+			daemon.RegisterDaemonServer(srv, dmn)
+		})
+	}
+	return client.WithSocketDialer(ctx, s)
+}
@@ -0,0 +1,210 @@
+package logging
+
+import (
+	"archive/zip"
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"sigs.k8s.io/yaml"
+
+	"github.com/datawire/dlib/derror"
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/filelocation"
+	"github.com/telepresenceio/telepresence/v2/pkg/redact"
+)
+
+// CrashDir is the name of the directory in the logs directory that crash bundles are written to
+const CrashDir = "crashes"
+
+const crashBundleExt = ".zip"
+
+// crashLogTail is how many bytes from the end of the log of the crashed process a bundle includes
+const crashLogTail = 512 * 1024
+
+// CrashInfo is the description of a crash that a bundle contains as crash.json.
+type CrashInfo struct {
+	Process    string    `json:"process"`
+	Version    string    `json:"version"`
+	Time       time.Time `json:"time"`
+	OS         string    `json:"os"`
+	Arch       string    `json:"arch"`
+	Error      string    `json:"error"`
+	Stack      string    `json:"stack"`
+	Goroutines string    `json:"goroutines"`
+}
+
+// CrashBundle is a crash bundle found in the crash directory
+type CrashBundle struct {
+	Path    string
+	Process string
+	Time    time.Time
+}
+
+// RecoverAndReport is deferred by functions whose panics would otherwise go unnoticed. It writes
+// a crash bundle for the panic and then continues panicking.
+func RecoverAndReport(ctx context.Context, process string) {
+	if r := recover(); r != nil {
+		ReportCrash(ctx, process, derror.PanicToError(r))
+		panic(r)
+	}
+}
+
+// ReportCrash writes a crash bundle for a panic that has been recovered and converted into an error
+// using derror.PanicToError. Errors are logged.
+func ReportCrash(ctx context.Context, process string, perr error) {
+	if path, err := WriteCrashBundle(ctx, process, perr); err != nil {
+		dlog.Errorf(ctx, "unable to write crash bundle: %v", err)
+	} else {
+		dlog.Errorf(ctx, "crash bundle written to %s", path)
+	}
+}
+
+// WriteCrashBundle writes a zip archive containing a description of the given panic, the end of the
+// log of the given process, and the configuration, all with secrets redacted, to the CrashDir of
+// the logs directory. The path of the archive is returned.
+func WriteCrashBundle(ctx context.Context, process string, perr error) (string, error) {
+	logDir, err := filelocation.AppUserLogDir(ctx)
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(logDir, CrashDir)
+	if err = os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	path := filepath.Join(dir, process+"-"+now.Format(rotatedTimeFormat)+crashBundleExt)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return "", err
+	}
+
+	cfg := client.GetConfig(ctx)
+	r, err := cfg.Redact.Redactor()
+	if err != nil {
+		r = redact.Default()
+	}
+	goroutines := make([]byte, 1024*1024)
+	goroutines = goroutines[:runtime.Stack(goroutines, true)]
+	info := CrashInfo{
+		Process:    process,
+		Version:    client.Version(),
+		Time:       now,
+		OS:         runtime.GOOS,
+		Arch:       runtime.GOARCH,
+		Error:      r.String(perr.Error()),
+		Stack:      r.String(fmt.Sprintf("%+v", perr)),
+		Goroutines: string(goroutines),
+	}
+
+	zw := zip.NewWriter(f)
+	err = writeCrashBundle(zw, r, &info, cfg, filepath.Join(logDir, process+".log"))
+	if cerr := zw.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(path)
+		return "", err
+	}
+
+	// The root daemon writes the bundle, but the user that owns the logs directory is the one that
+	// must be able to read and remove it.
+	if st, err := os.Stat(logDir); err == nil {
+		si := getSysInfo(st)
+		_ = si.setOwnerAndGroup(dir)
+		_ = si.setOwnerAndGroup(path)
+	}
+	return path, nil
+}
+
+func writeCrashBundle(zw *zip.Writer, r *redact.Redactor, info *CrashInfo, cfg *client.Config, logFile string) error {
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return err
+	}
+	w, err := zw.Create("crash.json")
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(data); err != nil {
+		return err
+	}
+
+	if data, err = yaml.Marshal(cfg); err != nil {
+		return err
+	}
+	if w, err = zw.Create("config.yml"); err != nil {
+		return err
+	}
+	if _, err = io.WriteString(w, r.String(string(data))); err != nil {
+		return err
+	}
+
+	lf, err := os.Open(logFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer lf.Close()
+	src := bufio.NewReader(lf)
+	if st, err := lf.Stat(); err == nil && st.Size() > crashLogTail {
+		if _, err = lf.Seek(-crashLogTail, io.SeekEnd); err != nil {
+			return err
+		}
+		// Skip the partial first line
+		_, _ = src.ReadString('\n')
+	}
+	if w, err = zw.Create(filepath.Base(logFile)); err != nil {
+		return err
+	}
+	return r.Copy(w, src)
+}
+
+// CrashBundles returns the crash bundles found in the CrashDir of the logs directory that were
+// written at or after the given time, oldest first. The resolution of the time of a bundle is one
+// second.
+func CrashBundles(ctx context.Context, since time.Time) ([]CrashBundle, error) {
+	logDir, err := filelocation.AppUserLogDir(ctx)
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Join(logDir, CrashDir)
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return nil, err
+	}
+	var bundles []CrashBundle
+	for _, file := range files {
+		fn := file.Name()
+		dash := strings.LastIndexByte(fn, '-')
+		if dash < 0 || !strings.HasSuffix(fn, crashBundleExt) {
+			continue
+		}
+		ts, err := time.ParseInLocation(rotatedTimeFormat, strings.TrimSuffix(fn[dash+1:], crashBundleExt), time.Local)
+		if err != nil || ts.Before(since.Truncate(time.Second)) {
+			continue
+		}
+		bundles = append(bundles, CrashBundle{Path: filepath.Join(dir, fn), Process: fn[:dash], Time: ts})
+	}
+	sort.Slice(bundles, func(i, j int) bool { return bundles[i].Time.Before(bundles[j].Time) })
+	return bundles, nil
+}
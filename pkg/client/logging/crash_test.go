@@ -0,0 +1,57 @@
+package logging
+
+import (
+	"archive/zip"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/derror"
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/filelocation"
+)
+
+func TestWriteCrashBundle(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	logDir := t.TempDir()
+	ctx = filelocation.WithAppUserLogDir(ctx, logDir)
+	ctx = filelocation.WithAppUserConfigDir(ctx, t.TempDir())
+	require.NoError(t, ioutil.WriteFile(filepath.Join(logDir, "daemon.log"),
+		[]byte("2021/06/01 10:00:00.0000 info    Authorization: Bearer abcdefghijk\n"), 0600))
+
+	path, err := WriteCrashBundle(ctx, "daemon", derror.PanicToError("boom"))
+	require.NoError(t, err)
+
+	bundles, err := CrashBundles(ctx, time.Time{})
+	require.NoError(t, err)
+	require.Len(t, bundles, 1)
+	assert.Equal(t, path, bundles[0].Path)
+	assert.Equal(t, "daemon", bundles[0].Process)
+
+	bundles, err = CrashBundles(ctx, time.Now().Add(2*time.Second))
+	require.NoError(t, err)
+	assert.Empty(t, bundles)
+
+	zr, err := zip.OpenReader(path)
+	require.NoError(t, err)
+	defer zr.Close()
+	contents := make(map[string]string)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		require.NoError(t, err)
+		data, err := ioutil.ReadAll(rc)
+		_ = rc.Close()
+		require.NoError(t, err)
+		contents[f.Name] = string(data)
+	}
+	require.Contains(t, contents, "crash.json")
+	require.Contains(t, contents, "config.yml")
+	require.Contains(t, contents, "daemon.log")
+	assert.Contains(t, contents["crash.json"], "boom")
+	assert.Contains(t, contents["daemon.log"], "Authorization: [REDACTED]")
+	assert.NotContains(t, contents["daemon.log"], "abcdefghijk")
+}
@@ -0,0 +1,17 @@
+package logging
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// dupToStd ensures that anything written to the standard output and error handles used by
+// internal functions such as panic and println will end up in the given file.
+func dupToStd(file *os.File) (err error) {
+	h := windows.Handle(file.Fd())
+	if err = windows.SetStdHandle(windows.STD_OUTPUT_HANDLE, h); err == nil {
+		err = windows.SetStdHandle(windows.STD_ERROR_HANDLE, h)
+	}
+	return err
+}
@@ -2,11 +2,15 @@ package logging
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/sirupsen/logrus"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/redact"
 )
 
 const thisModule = "github.com/telepresenceio/telepresence/v2"
@@ -14,10 +18,22 @@ const thisModule = "github.com/telepresenceio/telepresence/v2"
 // Formatter formats log messages for Telepresence client
 type Formatter struct {
 	timestampFormat string
+	redactor        *redact.Redactor
+	json            bool
 }
 
 func NewFormatter(timestampFormat string) *Formatter {
-	return &Formatter{timestampFormat: timestampFormat}
+	return &Formatter{timestampFormat: timestampFormat, redactor: redact.Default()}
+}
+
+// SetRedactor sets the redactor that is applied to messages and data values
+func (f *Formatter) SetRedactor(r *redact.Redactor) {
+	f.redactor = r
+}
+
+// SetJSON makes the formatter output one JSON object per entry instead of plain text
+func (f *Formatter) SetJSON(json bool) {
+	f.json = json
 }
 
 // Format implements logrus.Formatter
@@ -34,12 +50,17 @@ func (f *Formatter) Format(entry *logrus.Entry) ([]byte, error) {
 	}
 	goroutine, _ := data["THREAD"].(string)
 	delete(data, "THREAD")
+	delete(data, systemEventField)
+
+	if f.json {
+		return f.formatJSON(b, entry, goroutine, data)
+	}
 
 	fmt.Fprintf(b, "%s %-*s %s : %s",
 		entry.Time.Format(f.timestampFormat),
 		len("warning"), entry.Level,
 		strings.TrimPrefix(goroutine, "/"),
-		entry.Message)
+		f.redactor.String(entry.Message))
 
 	if len(data) > 0 {
 		b.WriteString(" :")
@@ -62,7 +83,7 @@ func (f *Formatter) Format(entry *logrus.Entry) ([]byte, error) {
 			return keys[i] < keys[j]
 		})
 		for _, key := range keys {
-			val := fmt.Sprintf("%+v", data[key])
+			val := f.redactor.Value(key, f.redactor.String(fmt.Sprintf("%+v", data[key])))
 			fmt.Fprintf(b, " %s=%q", key, val)
 		}
 	}
@@ -75,3 +96,32 @@ func (f *Formatter) Format(entry *logrus.Entry) ([]byte, error) {
 
 	return b.Bytes(), nil
 }
+
+func (f *Formatter) formatJSON(b *bytes.Buffer, entry *logrus.Entry, goroutine string, data logrus.Fields) ([]byte, error) {
+	obj := make(map[string]interface{}, len(data)+5)
+	for key, v := range data {
+		if err, ok := v.(error); ok {
+			v = err.Error()
+		}
+		if s, ok := v.(string); ok {
+			obj[key] = f.redactor.Value(key, f.redactor.String(s))
+		} else {
+			obj[key] = v
+		}
+	}
+	obj["time"] = entry.Time.Format(time.RFC3339Nano)
+	obj["level"] = entry.Level.String()
+	obj["msg"] = f.redactor.String(entry.Message)
+	if goroutine != "" {
+		obj["thread"] = strings.TrimPrefix(goroutine, "/")
+	}
+	if entry.HasCaller() && strings.HasPrefix(entry.Caller.File, thisModule+"/") {
+		obj["caller"] = fmt.Sprintf("%s:%d", strings.TrimPrefix(entry.Caller.File, thisModule+"/"), entry.Caller.Line)
+	}
+	enc := json.NewEncoder(b)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(obj); err != nil {
+		return nil, fmt.Errorf("failed to marshal log entry to JSON: %w", err)
+	}
+	return b.Bytes(), nil
+}
@@ -12,6 +12,7 @@ import (
 
 	"github.com/datawire/dlib/dlog"
 	"github.com/telepresenceio/telepresence/v2/pkg/filelocation"
+	"github.com/telepresenceio/telepresence/v2/pkg/loglevel"
 )
 
 // IsTerminal returns whether the given file descriptor is a terminal
@@ -29,28 +30,75 @@ func InitContext(ctx context.Context, name string) (context.Context, error) {
 	logger.SetLevel(logrus.DebugLevel)
 	logger.ReportCaller = true
 
+	var formatter *Formatter
+	var rf *RotatingFile
+	rotateOnce := NewRotateOnce()
 	if IsTerminal(int(os.Stdout.Fd())) {
-		logger.Formatter = NewFormatter("15:04:05.0000")
+		formatter = NewFormatter("15:04:05.0000")
+		logger.Formatter = formatter
 	} else {
-		logger.Formatter = NewFormatter("2006/01/02 15:04:05.0000")
+		formatter = NewFormatter(FileTimeFormat)
+		logger.Formatter = formatter
 		dir, err := filelocation.AppUserLogDir(ctx)
 		if err != nil {
 			return ctx, err
 		}
-		rf, err := OpenRotatingFile(filepath.Join(dir, name+".log"), "20060102T150405", true, true, 0600, NewRotateOnce(), 5)
-		if err != nil {
+		if rf, err = OpenRotatingFile(filepath.Join(dir, name+".log"), rotatedTimeFormat, true, true, 0600, rotateOnce, 5); err != nil {
 			return ctx, err
 		}
 		logger.SetOutput(rf)
 	}
 	ctx = dlog.WithLogger(ctx, dlog.WrapLogrus(logger))
 
-	// Read the config and set the configured level.
-	logLevels := client.GetConfig(ctx).LogLevels
+	// Read the config and set the configured format, levels, rotation and redaction rules.
+	cfg := client.GetConfig(ctx)
+	if rf != nil {
+		lr := &cfg.LogRotation
+		strategies := []RotationStrategy{rotateOnce}
+		if lr.MaxSize > 0 {
+			strategies = append(strategies, RotateBySize(lr.MaxSize))
+		}
+		if lr.MaxAge > 0 {
+			strategies = append(strategies, RotateByAge(lr.MaxAge))
+		}
+		rf.Configure(RotateAny(strategies...), lr.MaxFiles, lr.Compress)
+	}
+	formatter.SetJSON(cfg.LogFormat == "json")
+	logLevels := cfg.LogLevels
+	ff := &loglevel.FilteringFormatter{
+		Formatter:  formatter,
+		Level:      logger.Level,
+		Subsystems: logLevels.Subsystems,
+	}
 	if name == "daemon" {
-		logger.SetLevel(logLevels.RootDaemon)
+		ff.Level = logLevels.RootDaemon
 	} else if name == "connector" {
-		logger.SetLevel(logLevels.UserDaemon)
+		ff.Level = logLevels.UserDaemon
+	}
+	logger.Formatter = ff
+	logger.SetLevel(ff.MaxLevel())
+	if r, err := cfg.Redact.Redactor(); err != nil {
+		dlog.Errorf(ctx, "invalid redact configuration: %v", err)
+	} else {
+		formatter.SetRedactor(r)
+	}
+
+	// Lifecycle events and errors are also sent to the system log, so that they are seen by
+	// the log viewers of the OS and by the tools that collect what's logged there.
+	if sl, err := openSystemLog(name); err != nil {
+		dlog.Warnf(ctx, "unable to open the system log: %v", err)
+	} else if sl != nil {
+		logger.AddHook(newSystemLogHook(name, logLevels.SystemLog, formatter, sl))
+
+		// The lifecycle events are logged at info level, and must reach the hook even when
+		// less than that is written to the log files.
+		level := logLevels.SystemLog
+		if level < logrus.InfoLevel {
+			level = logrus.InfoLevel
+		}
+		if level > logger.Level {
+			logger.SetLevel(level)
+		}
 	}
 	return ctx, nil
 }
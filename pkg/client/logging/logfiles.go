@@ -0,0 +1,72 @@
+package logging
+
+import (
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// FileTimeFormat is the format of the timestamp that starts each line of a log file that is
+// written using the text format
+const FileTimeFormat = "2006/01/02 15:04:05.0000"
+
+// rotatedTimeFormat is the format of the timestamp that is added to the name of a rotated log file
+const rotatedTimeFormat = "20060102T150405"
+
+// RotatedLogFiles returns the paths of the rotated files of the log with the given name that are
+// found in dir and were rotated at or after the given time, oldest first.
+func RotatedLogFiles(dir, name string, since time.Time) ([]string, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	pfx := name + "-"
+	type rotated struct {
+		path string
+		ts   time.Time
+	}
+	var found []rotated
+	for _, file := range files {
+		fn := file.Name()
+		if !strings.HasPrefix(fn, pfx) {
+			continue
+		}
+		tsStr := strings.TrimSuffix(strings.TrimSuffix(fn[len(pfx):], gzExt), ".log")
+		ts, err := time.ParseInLocation(rotatedTimeFormat, tsStr, time.Local)
+		if err != nil || ts.Before(since) {
+			continue
+		}
+		found = append(found, rotated{path: filepath.Join(dir, fn), ts: ts})
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].ts.Before(found[j].ts) })
+	paths := make([]string, len(found))
+	for i := range found {
+		paths[i] = found[i].path
+	}
+	return paths, nil
+}
+
+// ReadLogFile returns the contents of the given log file, decompressing it first if it is a
+// compressed rotated file.
+func ReadLogFile(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if strings.HasSuffix(path, gzExt) {
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		r = zr
+	}
+	return ioutil.ReadAll(r)
+}
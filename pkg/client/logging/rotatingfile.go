@@ -1,7 +1,9 @@
 package logging
 
 import (
+	"compress/gzip"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -60,6 +62,50 @@ func (rotateDaily) RotateNow(rf *RotatingFile, _ int) bool {
 	return dtime.Now().In(bt.Location()).Day() != rf.BirthTime().Day()
 }
 
+type rotateBySize int64
+
+// RotateBySize returns a strategy that rotates the file when a write would make it grow beyond
+// maxSize bytes.
+func RotateBySize(maxSize int64) RotationStrategy {
+	return rotateBySize(maxSize)
+}
+
+func (r rotateBySize) RotateNow(rf *RotatingFile, writeSize int) bool {
+	sz := rf.Size()
+	return sz > 0 && sz+int64(writeSize) > int64(r)
+}
+
+type rotateByAge time.Duration
+
+// RotateByAge returns a strategy that rotates the file when it is of non zero size and older
+// than maxAge when a call to Write() arrives.
+func RotateByAge(maxAge time.Duration) RotationStrategy {
+	return rotateByAge(maxAge)
+}
+
+func (r rotateByAge) RotateNow(rf *RotatingFile, _ int) bool {
+	return rf.Size() > 0 && dtime.Now().Sub(rf.BirthTime()) > time.Duration(r)
+}
+
+type rotateAny []RotationStrategy
+
+// RotateAny returns a strategy that rotates the file when at least one of the given strategies
+// wants to. All strategies are asked on every write, so stateful strategies such as the one
+// returned by NewRotateOnce behave the same as when used alone.
+func RotateAny(strategies ...RotationStrategy) RotationStrategy {
+	return rotateAny(strategies)
+}
+
+func (r rotateAny) RotateNow(rf *RotatingFile, writeSize int) bool {
+	rotate := false
+	for _, s := range r {
+		if s.RotateNow(rf, writeSize) {
+			rotate = true
+		}
+	}
+	return rotate
+}
+
 type RotatingFile struct {
 	fileMode    os.FileMode
 	dirName     string
@@ -67,6 +113,7 @@ type RotatingFile struct {
 	timeFormat  string
 	localTime   bool
 	captureStd  bool
+	compress    bool
 	maxFiles    uint16
 	strategy    RotationStrategy
 	mutex       sync.Mutex
@@ -163,6 +210,20 @@ func (rf *RotatingFile) BirthTime() time.Time {
 	return bt
 }
 
+// Configure replaces the rotation strategy and the maximum number of files given to
+// OpenRotatingFile. When compress is true, rotated files are compressed using gzip.
+func (rf *RotatingFile) Configure(strategy RotationStrategy, maxFiles uint16, compress bool) {
+	rf.mutex.Lock()
+	rf.strategy = strategy
+	rf.mutex.Unlock()
+
+	rf.removeMutex.Lock()
+	rf.maxFiles = maxFiles
+	rf.compress = compress
+	rf.removeMutex.Unlock()
+	go rf.removeOldFiles()
+}
+
 // Close implements io.Closer
 func (rf *RotatingFile) Close() error {
 	return rf.file.Close()
@@ -186,7 +247,11 @@ func (rf *RotatingFile) Size() int64 {
 
 // Write implements io.Writer
 func (rf *RotatingFile) Write(data []byte) (int, error) {
-	rotateNow := rf.strategy.RotateNow(rf, len(data))
+	rf.mutex.Lock()
+	strategy := rf.strategy
+	rf.mutex.Unlock()
+
+	rotateNow := strategy.RotateNow(rf, len(data))
 	rf.mutex.Lock()
 	defer rf.mutex.Unlock()
 
@@ -291,7 +356,8 @@ func (rf *RotatingFile) openNew() (err error) {
 
 // removeOldFiles checks how many files that currently exists (backups + current log file) with the same
 // name as this RotatingFile and then, as long as the number of files exceed the maxFiles given to  the
-// constructor, it will continuously remove the oldest file.
+// constructor, it will continuously remove the oldest file. Backups that haven't been compressed are
+// compressed first when compression is enabled.
 //
 // This function should typically run in it's own goroutine
 func (rf *RotatingFile) removeOldFiles() {
@@ -315,20 +381,33 @@ func (rf *RotatingFile) removeOldFiles() {
 		fn := file.Name()
 
 		// Skip files that doesn't start with the prefix and end with the suffix.
-		if !(strings.HasPrefix(fn, pfx) && strings.HasSuffix(fn, ext)) {
+		compressed := strings.HasSuffix(fn, ext+gzExt)
+		if !(strings.HasPrefix(fn, pfx) && (compressed || strings.HasSuffix(fn, ext))) {
 			continue
 		}
+		end := len(fn) - len(ext)
+		if compressed {
+			end -= len(gzExt)
+		}
 		// Parse the timestamp from the file name
 		var ts time.Time
-		if ts, err = time.Parse(rf.timeFormat, fn[len(pfx):len(fn)-len(ext)]); err != nil {
+		if ts, err = time.Parse(rf.timeFormat, fn[len(pfx):end]); err != nil {
 			continue
 		}
+		if rf.compress && !compressed {
+			if err = rf.compressFile(filepath.Join(rf.dirName, fn)); err != nil {
+				continue
+			}
+			fn += gzExt
+		}
 		key := ts.UnixNano()
-		keys = append(keys, key)
+		if _, dup := names[key]; !dup {
+			keys = append(keys, key)
+		}
 		names[key] = fn
 	}
 	mx := int(rf.maxFiles) - 1 // -1 to account for the current log file
-	if len(keys) <= mx {
+	if rf.maxFiles == 0 || len(keys) <= mx {
 		return
 	}
 	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
@@ -349,3 +428,56 @@ func (rf *RotatingFile) rotate() (err error) {
 	}
 	return rf.openNew()
 }
+
+const gzExt = ".gz"
+
+// compressFile replaces the file at the given path with a gzip compressed file that has the
+// same name with a ".gz" suffix added
+func (rf *RotatingFile) compressFile(path string) (err error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	inStat, err := in.Stat()
+	if err != nil {
+		_ = in.Close()
+		return err
+	}
+
+	gzPath := path + gzExt
+	out, err := os.OpenFile(gzPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, rf.fileMode)
+	if err != nil {
+		_ = in.Close()
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = os.Remove(gzPath)
+		}
+	}()
+
+	zw := gzip.NewWriter(out)
+	_, err = io.Copy(zw, in)
+	_ = in.Close()
+	if cerr := zw.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	outStat, err := os.Stat(gzPath)
+	if err != nil {
+		return err
+	}
+	inInfo := getSysInfo(inStat)
+	if !inInfo.haveSameOwnerAndGroup(getSysInfo(outStat)) {
+		if err = inInfo.setOwnerAndGroup(gzPath); err != nil {
+			return err
+		}
+	}
+	return os.Remove(path)
+}
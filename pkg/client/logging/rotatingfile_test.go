@@ -0,0 +1,53 @@
+package logging
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dtime"
+)
+
+func TestRotateBySize(t *testing.T) {
+	ft := dtime.NewFakeTime()
+	dtime.SetNow(ft.Now)
+	defer dtime.SetNow(time.Now)
+
+	dir := t.TempDir()
+	rf, err := OpenRotatingFile(filepath.Join(dir, "test.log"), rotatedTimeFormat, true, false, 0600, RotateNever, 5)
+	require.NoError(t, err)
+	rf.Configure(RotateAny(NewRotateOnce(), RotateBySize(10)), 3, true)
+	defer rf.Close()
+
+	for i := 0; i < 5; i++ {
+		_, err = rf.Write([]byte("1234567\n"))
+		require.NoError(t, err)
+		ft.Step(time.Second)
+	}
+
+	// The current file plus two compressed backups are retained
+	require.Eventually(t, func() bool {
+		files, err := ioutil.ReadDir(dir)
+		if err != nil || len(files) != 3 {
+			return false
+		}
+		for _, file := range files {
+			if file.Name() != "test.log" && !strings.HasSuffix(file.Name(), ".log"+gzExt) {
+				return false
+			}
+		}
+		return true
+	}, 5*time.Second, 10*time.Millisecond)
+
+	rotated, err := RotatedLogFiles(dir, "test", time.Time{})
+	require.NoError(t, err)
+	require.Len(t, rotated, 2)
+	data, err := ReadLogFile(rotated[1])
+	require.NoError(t, err)
+	assert.Equal(t, "1234567\n", string(data))
+}
@@ -0,0 +1,27 @@
+package logging
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+type windowsSysInfo syscall.Win32FileAttributeData
+
+func getSysInfo(info os.FileInfo) sysinfo {
+	return (*windowsSysInfo)(info.Sys().(*syscall.Win32FileAttributeData))
+}
+
+func (w *windowsSysInfo) birthtime() time.Time {
+	return time.Unix(0, w.CreationTime.Nanoseconds())
+}
+
+// setOwnerAndGroup is a no-op on Windows, where a file that is created gets the owner of the
+// process that creates it.
+func (w *windowsSysInfo) setOwnerAndGroup(_ string) error {
+	return nil
+}
+
+func (w *windowsSysInfo) haveSameOwnerAndGroup(_ sysinfo) bool {
+	return true
+}
@@ -0,0 +1,103 @@
+package logging
+
+import (
+	"context"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/datawire/dlib/dlog"
+)
+
+// systemEventField is the field that marks an entry as a lifecycle event of a daemon. It's never
+// written to the log files.
+const systemEventField = "system_event"
+
+// systemLogQueueSize is the number of entries that can wait to be written to the system log. More
+// entries are dropped, so that a slow system log never holds up the daemon.
+const systemLogQueueSize = 100
+
+// systemLogger writes to the logging facility of the OS. It's implemented for each platform that
+// has one, and must be safe for concurrent use.
+type systemLogger interface {
+	Info(msg string) error
+	Warning(msg string) error
+	Error(msg string) error
+}
+
+// SystemEvent logs a lifecycle event of a daemon, such as its start or its end, at info level.
+// The event is also sent to the logging facility of the OS, i.e. the Windows Event Log or the macOS
+// unified log, regardless of the level configured for it.
+func SystemEvent(ctx context.Context, format string, args ...interface{}) {
+	dlog.Infof(dlog.WithField(ctx, systemEventField, true), format, args...)
+}
+
+type systemLogEntry struct {
+	level logrus.Level
+	msg   string
+}
+
+// systemLogHook is a logrus.Hook that sends the lifecycle events, and the entries at or above its
+// level, to a systemLogger. The lifecycle events are written right away, so that the last one is
+// written before the daemon exits. The other entries are written by a goroutine of their own.
+type systemLogHook struct {
+	name      string
+	level     logrus.Level
+	formatter *Formatter
+	logger    systemLogger
+	queue     chan systemLogEntry
+}
+
+func newSystemLogHook(name string, level logrus.Level, formatter *Formatter, sl systemLogger) *systemLogHook {
+	h := &systemLogHook{
+		name:      name,
+		level:     level,
+		formatter: formatter,
+		logger:    sl,
+		queue:     make(chan systemLogEntry, systemLogQueueSize),
+	}
+	go h.writeLoop()
+	return h
+}
+
+// Levels implements logrus.Hook
+func (h *systemLogHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire implements logrus.Hook
+func (h *systemLogHook) Fire(entry *logrus.Entry) error {
+	_, event := entry.Data[systemEventField]
+	if !event && entry.Level > h.level {
+		return nil
+	}
+	e := systemLogEntry{
+		level: entry.Level,
+		msg:   h.name + ": " + h.formatter.redactor.String(entry.Message),
+	}
+	if event {
+		_ = h.write(e)
+		return nil
+	}
+	select {
+	case h.queue <- e:
+	default:
+	}
+	return nil
+}
+
+func (h *systemLogHook) writeLoop() {
+	for e := range h.queue {
+		_ = h.write(e)
+	}
+}
+
+func (h *systemLogHook) write(e systemLogEntry) error {
+	switch {
+	case e.level <= logrus.ErrorLevel:
+		return h.logger.Error(e.msg)
+	case e.level == logrus.WarnLevel:
+		return h.logger.Warning(e.msg)
+	default:
+		return h.logger.Info(e.msg)
+	}
+}
@@ -0,0 +1,40 @@
+package logging
+
+import (
+	"os/exec"
+)
+
+// loggerCmd is the command that writes to the unified log. It uses syslog(3), which macOS routes to
+// the unified log. Using os_log directly would require cgo.
+const loggerCmd = "/usr/bin/logger"
+
+// loggerTag is the tag of the entries, which is how they're found in the unified log, e.g. using
+// log show --predicate 'eventMessage CONTAINS "telepresence"'.
+const loggerTag = "telepresence"
+
+type unifiedLog struct{}
+
+// openSystemLog returns a systemLogger that writes to the macOS unified log. The name of the daemon
+// is the start of each message.
+func openSystemLog(_ string) (systemLogger, error) {
+	if _, err := exec.LookPath(loggerCmd); err != nil {
+		return nil, err
+	}
+	return unifiedLog{}, nil
+}
+
+func (unifiedLog) log(priority, msg string) error {
+	return exec.Command(loggerCmd, "-t", loggerTag, "-p", "user."+priority, "--", msg).Run()
+}
+
+func (u unifiedLog) Info(msg string) error {
+	return u.log("info", msg)
+}
+
+func (u unifiedLog) Warning(msg string) error {
+	return u.log("warning", msg)
+}
+
+func (u unifiedLog) Error(msg string) error {
+	return u.log("err", msg)
+}
@@ -0,0 +1,9 @@
+// +build !darwin,!windows
+
+package logging
+
+// openSystemLog returns nil, because the daemons only write to the system logs of Windows and
+// macOS. On Linux, the log files are where users and tools look.
+func openSystemLog(_ string) (systemLogger, error) {
+	return nil, nil
+}
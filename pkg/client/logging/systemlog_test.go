@@ -0,0 +1,74 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+)
+
+type recordingSystemLog struct {
+	mu      sync.Mutex
+	entries []string
+}
+
+func (r *recordingSystemLog) add(level, msg string) error {
+	r.mu.Lock()
+	r.entries = append(r.entries, level+" "+msg)
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *recordingSystemLog) get() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.entries...)
+}
+
+func (r *recordingSystemLog) Info(msg string) error {
+	return r.add("info", msg)
+}
+
+func (r *recordingSystemLog) Warning(msg string) error {
+	return r.add("warning", msg)
+}
+
+func (r *recordingSystemLog) Error(msg string) error {
+	return r.add("error", msg)
+}
+
+func TestSystemLogHook(t *testing.T) {
+	sl := &recordingSystemLog{}
+	out := &bytes.Buffer{}
+	formatter := NewFormatter(FileTimeFormat)
+	logger := logrus.New()
+	logger.SetLevel(logrus.InfoLevel)
+	logger.SetOutput(out)
+	logger.Formatter = formatter
+	logger.AddHook(newSystemLogHook("connector", logrus.WarnLevel, formatter, sl))
+	ctx := dlog.WithLogger(context.Background(), dlog.WrapLogrus(logger))
+
+	SystemEvent(ctx, "starting")
+	dlog.Info(ctx, "not for the system log")
+	dlog.Warn(ctx, "careful")
+	dlog.Error(ctx, "broken")
+
+	require.Eventually(t, func() bool { return len(sl.get()) == 3 }, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{
+		"info connector: starting",
+		"warning connector: careful",
+		"error connector: broken",
+	}, sl.get())
+
+	// All entries are written to the log file, but without the field that marks the event
+	assert.Contains(t, out.String(), "starting")
+	assert.Contains(t, out.String(), "not for the system log")
+	assert.NotContains(t, out.String(), systemEventField)
+}
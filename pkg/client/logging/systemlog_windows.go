@@ -0,0 +1,43 @@
+package logging
+
+import (
+	"golang.org/x/sys/windows/svc/eventlog"
+)
+
+// eventSource is the source of the events that the daemons write to the Windows Event Log
+const eventSource = "Telepresence"
+
+// eventID is the ID of all events. The messages aren't taken from a message file, so there's just
+// the one ID.
+const eventID = 1
+
+type windowsEventLog struct {
+	log *eventlog.Log
+}
+
+// openSystemLog returns a systemLogger that writes to the Application log of the Windows Event Log.
+// The event source is registered unless it already is. That requires administrator privileges, so
+// it's done by the root daemon. The name of the daemon is the start of each message.
+func openSystemLog(_ string) (systemLogger, error) {
+	// An error means that the source is registered already, or that the privileges are lacking.
+	// Events are logged in either case, but are shown with a note about a missing description
+	// when the source isn't registered.
+	_ = eventlog.InstallAsEventCreate(eventSource, eventlog.Error|eventlog.Warning|eventlog.Info)
+	log, err := eventlog.Open(eventSource)
+	if err != nil {
+		return nil, err
+	}
+	return &windowsEventLog{log: log}, nil
+}
+
+func (w *windowsEventLog) Info(msg string) error {
+	return w.log.Info(eventID, msg)
+}
+
+func (w *windowsEventLog) Warning(msg string) error {
+	return w.log.Warning(eventID, msg)
+}
+
+func (w *windowsEventLog) Error(msg string) error {
+	return w.log.Error(eventID, msg)
+}
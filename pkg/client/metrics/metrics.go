@@ -0,0 +1,84 @@
+// Package metrics contains the Prometheus metrics of the connector and the root daemon, and the
+// HTTP endpoint that exposes them.
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/datawire/dlib/dhttp"
+	"github.com/datawire/dlib/dlog"
+)
+
+const namespace = "telepresence"
+
+var (
+	// TunnelBytes counts the payload bytes sent to ("out") and received from ("in") the cluster
+	// through the tunnel. Populated by the root daemon.
+	TunnelBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tunnel_bytes_total",
+		Help:      "Payload bytes sent and received through the tunnel to the cluster",
+	}, []string{"direction"})
+
+	// DNSLookupDuration observes the duration of DNS lookups made in the cluster. Populated by the
+	// root daemon.
+	DNSLookupDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "dns_lookup_duration_seconds",
+		Help:      "Duration of DNS lookups made in the cluster",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 2, 14),
+	}, []string{"result"})
+
+	// ActiveIntercepts is the number of currently active intercepts. Populated by the connector.
+	ActiveIntercepts = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "active_intercepts",
+		Help:      "Number of currently active intercepts",
+	})
+
+	// Reconnects counts the number of times that a stream to the traffic-manager has been
+	// re-established after a failure. Populated by the connector.
+	Reconnects = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "reconnects_total",
+		Help:      "Number of times that a stream to the traffic-manager was re-established",
+	}, []string{"stream"})
+)
+
+var registry = prometheus.NewRegistry()
+
+func init() {
+	registry.MustRegister(
+		TunnelBytes,
+		DNSLookupDuration,
+		ActiveIntercepts,
+		Reconnects,
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+	)
+}
+
+// Serve serves the metrics on http://localhost:<port>/metrics until the context is cancelled. It
+// does nothing if port is zero.
+func Serve(ctx context.Context, port int) error {
+	if port == 0 {
+		return nil
+	}
+	ln, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		return fmt.Errorf("unable to listen for metrics requests: %w", err)
+	}
+	dlog.Infof(ctx, "Serving metrics on http://%s/metrics", ln.Addr())
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	sc := &dhttp.ServerConfig{
+		Handler: mux,
+	}
+	return sc.Serve(ctx, ln)
+}
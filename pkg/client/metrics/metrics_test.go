@@ -0,0 +1,64 @@
+package metrics
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dcontext"
+	"github.com/datawire/dlib/dlog"
+)
+
+func TestServe(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	assert.NoError(t, Serve(ctx, 0), "the endpoint is disabled when the port is zero")
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	port := l.Addr().(*net.TCPAddr).Port
+	_ = l.Close()
+
+	// Like in the daemons, the server is shut down by cancelling a soft context
+	ctx, cancel := context.WithCancel(dcontext.WithSoftness(ctx))
+	done := make(chan error, 1)
+	go func() {
+		done <- Serve(ctx, port)
+	}()
+
+	TunnelBytes.Reset()
+	DNSLookupDuration.Reset()
+	Reconnects.Reset()
+	TunnelBytes.WithLabelValues("in").Add(42)
+	DNSLookupDuration.WithLabelValues("found").Observe(0.003)
+	ActiveIntercepts.Set(2)
+	Reconnects.WithLabelValues("watch-intercepts").Inc()
+
+	url := "http://" + l.Addr().String() + "/metrics"
+	var body string
+	require.Eventually(t, func() bool {
+		rsp, err := http.Get(url)
+		if err != nil {
+			return false
+		}
+		defer rsp.Body.Close()
+		data, err := ioutil.ReadAll(rsp.Body)
+		body = string(data)
+		return err == nil && rsp.StatusCode == http.StatusOK
+	}, 5*time.Second, 50*time.Millisecond)
+	assert.Contains(t, body, `telepresence_tunnel_bytes_total{direction="in"} 42`)
+	assert.Contains(t, body, `telepresence_dns_lookup_duration_seconds_count{result="found"} 1`)
+	assert.Contains(t, body, `telepresence_active_intercepts 2`)
+	assert.Contains(t, body, `telepresence_reconnects_total{stream="watch-intercepts"} 1`)
+	assert.Contains(t, body, `go_goroutines`)
+
+	assert.Error(t, Serve(ctx, port), "the port is in use")
+
+	cancel()
+	assert.NoError(t, <-done)
+}
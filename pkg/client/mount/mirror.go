@@ -0,0 +1,424 @@
+package mount
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/datawire/dlib/dlog"
+)
+
+// syncInterval is how often the mirror compares the local and the remote files
+var syncInterval = 2 * time.Second
+
+const (
+	// sftpTimeout is how long the mirror waits for a reply from the traffic-agent
+	sftpTimeout = 30 * time.Second
+
+	// maxDepth is how deep the remote directories are walked, which stops symlink cycles
+	maxDepth = 32
+)
+
+// fileState is what the mirror compares to tell whether a file has changed since it was synced.
+// Only the kind is compared for directories. The mode is only recorded for remote files, because
+// it's what the local copies get.
+type fileState struct {
+	dir   bool
+	size  int64
+	mtime int64
+	mode  os.FileMode
+}
+
+// syncState is the state of both sides of a file when it was last synced.
+type syncState struct {
+	remote fileState
+	local  fileState
+}
+
+// Mirror keeps a local directory in sync with a remote directory that it reads and writes over
+// SFTP, which is how the remote volumes of an intercept are made available without a FUSE file
+// system. The remote files are copied to the local directory, local changes are copied back, and
+// the remote side wins when a file has changed on both sides. Changes that the remote side refuses,
+// e.g. because the volume is read-only, are reverted locally.
+//
+// The state of the last sync is kept across connections, so that a mirror whose connection is lost
+// can carry on where it left off.
+type Mirror struct {
+	remote     string
+	mountPoint string
+	dir        string
+	synced     map[string]*syncState
+
+	// busy is held by Run and Close, so that a mirror that is replaced by a mirror of the same mount
+	// point is done before the new one starts
+	busy chan struct{}
+}
+
+// NewMirror returns a mirror of the given remote directory at the given mount point.
+func NewMirror(remoteDir, mountPoint string) *Mirror {
+	return &Mirror{
+		remote:     remoteDir,
+		mountPoint: mountPoint,
+		busy:       make(chan struct{}, 1),
+	}
+}
+
+// Run syncs the mirror over the given SFTP connection until the context is done or the connection
+// fails. The given function is called after each sync. The mount point must be empty when the
+// mirror is first run.
+func (m *Mirror) Run(ctx context.Context, conn net.Conn, synced func()) error {
+	select {
+	case m.busy <- struct{}{}:
+	case <-ctx.Done():
+		return nil
+	}
+	defer func() { <-m.busy }()
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	if m.synced == nil {
+		dir, err := mirrorDir(m.mountPoint)
+		if err != nil {
+			return err
+		}
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		if len(files) > 0 {
+			return fmt.Errorf("mount point %s is not empty", m.mountPoint)
+		}
+		m.dir = dir
+		m.synced = make(map[string]*syncState)
+	}
+
+	c, err := newSFTPClient(conn, sftpTimeout)
+	if err != nil {
+		return fmt.Errorf("unable to start an sftp session: %w", err)
+	}
+	ticker := time.NewTicker(syncInterval)
+	defer ticker.Stop()
+	for {
+		if err := m.sync(ctx, c); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		synced()
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Close removes the local copies of the remote files, and the mount point.
+func (m *Mirror) Close() error {
+	m.busy <- struct{}{}
+	defer func() { <-m.busy }()
+	if m.synced == nil {
+		return os.Remove(m.mountPoint)
+	}
+	paths := make([]string, 0, len(m.synced))
+	for p := range m.synced {
+		paths = append(paths, p)
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(paths)))
+	for _, p := range paths {
+		_ = os.Remove(m.localPath(p))
+	}
+	m.synced = nil
+	return releaseDir(m.mountPoint, m.dir)
+}
+
+func (m *Mirror) localPath(p string) string {
+	return filepath.Join(m.dir, filepath.FromSlash(p))
+}
+
+func (m *Mirror) remotePath(p string) string {
+	return path.Join(m.remote, p)
+}
+
+// sync compares the remote and the local files with the state of the last sync, and copies what
+// has changed. The slash separated paths are relative to the remote directory and the mount point.
+// Deletions are made deepest first, and then files are copied, parents first.
+func (m *Mirror) sync(ctx context.Context, c *sftpClient) error {
+	remote := make(map[string]fileState)
+	if err := m.walkRemote(ctx, c, "", 0, remote); err != nil {
+		return err
+	}
+	local, err := m.walkLocal()
+	if err != nil {
+		return err
+	}
+
+	all := make(map[string]struct{}, len(remote))
+	for p := range remote {
+		all[p] = struct{}{}
+	}
+	for p := range local {
+		all[p] = struct{}{}
+	}
+	for p := range m.synced {
+		all[p] = struct{}{}
+	}
+	paths := make([]string, 0, len(all))
+	for p := range all {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	type change struct {
+		pull bool // pull from remote, or else push to remote
+		del  bool
+	}
+	changes := make(map[string]change)
+	for _, p := range paths {
+		r, inRemote := remote[p]
+		l, inLocal := local[p]
+		s, known := m.synced[p]
+		switch {
+		case !inRemote && !inLocal:
+			delete(m.synced, p)
+		case known != inRemote || inRemote && r != s.remote:
+			changes[p] = change{pull: true, del: !inRemote}
+		case known != inLocal || inLocal && l != s.local:
+			changes[p] = change{pull: false, del: !inLocal}
+		}
+	}
+
+	for i := len(paths) - 1; i >= 0; i-- {
+		p := paths[i]
+		if ch, ok := changes[p]; ok && ch.del {
+			if ch.pull {
+				err = os.Remove(m.localPath(p))
+			} else if err = m.removeRemote(c, p, local[p].dir); err != nil && c.err == nil {
+				dlog.Warnf(ctx, "unable to remove %s: %v", m.remotePath(p), err)
+				err = m.pull(c, p, remote[p])
+			}
+			if err == nil {
+				delete(m.synced, p)
+			} else if c.err != nil {
+				return c.err
+			} else {
+				dlog.Warnf(ctx, "unable to sync %s: %v", m.localPath(p), err)
+			}
+		}
+	}
+	for _, p := range paths {
+		if ch, ok := changes[p]; ok && !ch.del {
+			if ch.pull {
+				err = m.pull(c, p, remote[p])
+			} else if err = m.push(c, p, local[p], remote); err != nil && c.err == nil {
+				dlog.Warnf(ctx, "unable to write %s: %v", m.remotePath(p), err)
+				if r, ok := remote[p]; ok {
+					err = m.pull(c, p, r)
+				} else if err = os.RemoveAll(m.localPath(p)); err == nil {
+					delete(m.synced, p)
+				}
+			}
+			if c.err != nil {
+				return c.err
+			}
+			if err != nil {
+				dlog.Debugf(ctx, "unable to sync %s: %v", m.localPath(p), err)
+			}
+		}
+	}
+	return nil
+}
+
+// walkRemote records the state of the files in the given remote directory, following symlinks. The
+// files that can't be read are left out, and so are symlinks that can't be followed and files that
+// are neither regular files nor directories.
+func (m *Mirror) walkRemote(ctx context.Context, c *sftpClient, dir string, depth int, files map[string]fileState) error {
+	if dir == "" {
+		a, err := c.stat(m.remote)
+		if err != nil {
+			return err
+		}
+		if !a.isDir() {
+			return fmt.Errorf("%s is not a directory", m.remote)
+		}
+	}
+	entries, err := c.readDir(m.remotePath(dir))
+	if err != nil {
+		if dir != "" && c.err == nil {
+			dlog.Debugf(ctx, "unable to read %s: %v", m.remotePath(dir), err)
+			return nil
+		}
+		return err
+	}
+	for _, e := range entries {
+		p := path.Join(dir, e.name)
+		a := &e.attrs
+		if a.mode&0170000 == 0120000 {
+			if a, err = c.stat(m.remotePath(p)); err != nil {
+				if c.err != nil {
+					return c.err
+				}
+				continue
+			}
+		}
+		switch {
+		case a.isDir():
+			files[p] = fileState{dir: true}
+			if depth < maxDepth {
+				if err = m.walkRemote(ctx, c, p, depth+1, files); err != nil {
+					return err
+				}
+			}
+		case a.isRegular():
+			files[p] = fileState{size: a.size, mtime: a.mtime, mode: os.FileMode(a.mode & 0777)}
+		}
+	}
+	return nil
+}
+
+// walkLocal returns the state of the local files. Files that are neither regular files nor
+// directories are left out.
+func (m *Mirror) walkLocal() (map[string]fileState, error) {
+	if _, err := os.Stat(m.dir); err != nil {
+		return nil, fmt.Errorf("mount point %s is gone: %w", m.mountPoint, err)
+	}
+	files := make(map[string]fileState)
+	err := filepath.Walk(m.dir, func(lp string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if lp == m.dir {
+			return nil
+		}
+		rel, err := filepath.Rel(m.dir, lp)
+		if err != nil {
+			return err
+		}
+		switch {
+		case info.IsDir():
+			files[filepath.ToSlash(rel)] = fileState{dir: true}
+		case info.Mode().IsRegular():
+			files[filepath.ToSlash(rel)] = localState(info)
+		}
+		return nil
+	})
+	return files, err
+}
+
+func localState(info os.FileInfo) fileState {
+	if info.IsDir() {
+		return fileState{dir: true}
+	}
+	return fileState{size: info.Size(), mtime: info.ModTime().UnixNano()}
+}
+
+// pull copies the given remote file or directory to the mount point. Files are written to a
+// temporary file that is then renamed, so that they're never seen half written.
+func (m *Mirror) pull(c *sftpClient, p string, r fileState) error {
+	lp := m.localPath(p)
+	if info, err := os.Lstat(lp); err == nil && info.IsDir() != r.dir {
+		if err = os.RemoveAll(lp); err != nil {
+			return err
+		}
+	}
+	if r.dir {
+		if err := os.MkdirAll(lp, 0700); err != nil {
+			return err
+		}
+	} else {
+		if err := os.MkdirAll(filepath.Dir(lp), 0700); err != nil {
+			return err
+		}
+		f, err := ioutil.TempFile(filepath.Dir(lp), ".telfs-")
+		if err != nil {
+			return err
+		}
+		err = c.readFile(m.remotePath(p), f)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err == nil {
+			err = os.Chmod(f.Name(), r.mode|0600)
+		}
+		if err == nil {
+			err = os.Rename(f.Name(), lp)
+		}
+		if err != nil {
+			_ = os.Remove(f.Name())
+			return err
+		}
+		mtime := time.Unix(r.mtime, 0)
+		if err = os.Chtimes(lp, mtime, mtime); err != nil {
+			return err
+		}
+	}
+	info, err := os.Stat(lp)
+	if err != nil {
+		return err
+	}
+	m.synced[p] = &syncState{remote: r, local: localState(info)}
+	return nil
+}
+
+// push copies the given local file or directory to the remote directory, replacing the remote file
+// of the given remote files, if any.
+func (m *Mirror) push(c *sftpClient, p string, l fileState, remote map[string]fileState) error {
+	rp := m.remotePath(p)
+	r, inRemote := remote[p]
+	if inRemote && r.dir != l.dir {
+		if err := m.removeRemote(c, p, r.dir); err != nil {
+			return err
+		}
+	}
+	if l.dir {
+		if inRemote && r.dir {
+			m.synced[p] = &syncState{remote: r, local: l}
+			return nil
+		}
+		if err := c.mkdir(rp, 0755); err != nil {
+			return err
+		}
+	} else {
+		f, err := os.Open(m.localPath(p))
+		if err != nil {
+			return err
+		}
+		err = c.writeFile(rp, f, 0644)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	a, err := c.stat(rp)
+	if err != nil {
+		return err
+	}
+	r = fileState{dir: true}
+	if !a.isDir() {
+		r = fileState{size: a.size, mtime: a.mtime, mode: os.FileMode(a.mode & 0777)}
+	}
+	m.synced[p] = &syncState{remote: r, local: l}
+	return nil
+}
+
+func (m *Mirror) removeRemote(c *sftpClient, p string, dir bool) error {
+	if dir {
+		return c.rmdir(m.remotePath(p))
+	}
+	return c.remove(m.remotePath(p))
+}
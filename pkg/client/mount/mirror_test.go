@@ -0,0 +1,154 @@
+package mount
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+)
+
+// runMirror runs the given mirror over a connection to a server of the given directory, and returns
+// a function that waits for a sync that starts after it's called, and a channel that receives the
+// result of Run.
+func runMirror(ctx context.Context, t *testing.T, m *Mirror, root string, readOnly bool) (func(), <-chan error) {
+	synced := make(chan struct{})
+	errCh := make(chan error, 1)
+	conn := startSFTPServer(t, root, readOnly)
+	go func() {
+		errCh <- m.Run(ctx, conn, func() {
+			select {
+			case synced <- struct{}{}:
+			case <-ctx.Done():
+			}
+		})
+	}()
+	wait := func() {
+		t.Helper()
+		for i := 0; i < 2; i++ {
+			select {
+			case <-synced:
+			case err := <-errCh:
+				require.NoError(t, err)
+				t.Fatal("the mirror stopped")
+			case <-time.After(5 * time.Second):
+				t.Fatal("timed out waiting for a sync")
+			}
+		}
+	}
+	return wait, errCh
+}
+
+func setSyncInterval(t *testing.T, d time.Duration) {
+	saved := syncInterval
+	syncInterval = d
+	t.Cleanup(func() { syncInterval = saved })
+}
+
+func writeFile(t *testing.T, path, content string) {
+	require.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+}
+
+func assertContent(t *testing.T, path, content string) {
+	t.Helper()
+	data, err := ioutil.ReadFile(path)
+	if assert.NoError(t, err) {
+		assert.Equal(t, content, string(data))
+	}
+}
+
+func TestMirror(t *testing.T) {
+	setSyncInterval(t, 10*time.Millisecond)
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+
+	root := t.TempDir()
+	remote := filepath.Join(root, "tel_app_mounts")
+	require.NoError(t, os.MkdirAll(filepath.Join(remote, "sub"), 0755))
+	writeFile(t, filepath.Join(remote, "a.txt"), "a")
+	writeFile(t, filepath.Join(remote, "sub", "b.txt"), "b")
+	require.NoError(t, os.Symlink("sub", filepath.Join(remote, "link")))
+	mountPoint := filepath.Join(t.TempDir(), "mnt")
+	require.NoError(t, os.Mkdir(mountPoint, 0700))
+
+	m := NewMirror("/tel_app_mounts", mountPoint)
+	wait, errCh := runMirror(ctx, t, m, root, false)
+	wait()
+	assertContent(t, filepath.Join(mountPoint, "a.txt"), "a")
+	assertContent(t, filepath.Join(mountPoint, "sub", "b.txt"), "b")
+	assertContent(t, filepath.Join(mountPoint, "link", "b.txt"), "b")
+
+	// Changes on either side are copied to the other side
+	writeFile(t, filepath.Join(mountPoint, "sub", "c.txt"), "local")
+	require.NoError(t, os.Mkdir(filepath.Join(mountPoint, "dir"), 0700))
+	writeFile(t, filepath.Join(remote, "a.txt"), "remote change")
+	require.NoError(t, os.Remove(filepath.Join(remote, "sub", "b.txt")))
+	wait()
+	assertContent(t, filepath.Join(remote, "sub", "c.txt"), "local")
+	assert.DirExists(t, filepath.Join(remote, "dir"))
+	assertContent(t, filepath.Join(mountPoint, "a.txt"), "remote change")
+	assert.NoFileExists(t, filepath.Join(mountPoint, "sub", "b.txt"))
+	assert.NoFileExists(t, filepath.Join(mountPoint, "link", "b.txt"))
+
+	require.NoError(t, os.Remove(filepath.Join(mountPoint, "a.txt")))
+	wait()
+	assert.NoFileExists(t, filepath.Join(remote, "a.txt"))
+
+	// The mirror carries on over a new connection, without copying what's unchanged
+	cancel()
+	assert.NoError(t, <-errCh)
+	ctx, cancel = context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+	writeFile(t, filepath.Join(remote, "d.txt"), "d")
+	wait, errCh = runMirror(ctx, t, m, root, false)
+	wait()
+	assertContent(t, filepath.Join(mountPoint, "d.txt"), "d")
+	assertContent(t, filepath.Join(mountPoint, "sub", "c.txt"), "local")
+
+	// Close removes the mount point along with the copies
+	cancel()
+	assert.NoError(t, <-errCh)
+	require.NoError(t, m.Close())
+	assert.NoDirExists(t, mountPoint)
+	assertContent(t, filepath.Join(remote, "d.txt"), "d")
+}
+
+func TestMirrorReadOnly(t *testing.T) {
+	setSyncInterval(t, 10*time.Millisecond)
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "a.txt"), "a")
+	mountPoint := t.TempDir()
+
+	m := NewMirror("/", mountPoint)
+	wait, _ := runMirror(ctx, t, m, root, true)
+	wait()
+
+	// The changes that the remote side refuses are reverted
+	writeFile(t, filepath.Join(mountPoint, "a.txt"), "changed")
+	writeFile(t, filepath.Join(mountPoint, "new.txt"), "new")
+	wait()
+	assertContent(t, filepath.Join(mountPoint, "a.txt"), "a")
+	assert.NoFileExists(t, filepath.Join(mountPoint, "new.txt"))
+	assertContent(t, filepath.Join(root, "a.txt"), "a")
+
+	require.NoError(t, os.Remove(filepath.Join(mountPoint, "a.txt")))
+	wait()
+	assertContent(t, filepath.Join(mountPoint, "a.txt"), "a")
+}
+
+func TestMirrorNotEmpty(t *testing.T) {
+	mountPoint := t.TempDir()
+	writeFile(t, filepath.Join(mountPoint, "file"), "")
+	m := NewMirror("/", mountPoint)
+	err := m.Run(dlog.NewTestContext(t, false), startSFTPServer(t, t.TempDir(), false), func() {})
+	assert.Error(t, err)
+}
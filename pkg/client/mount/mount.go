@@ -0,0 +1,27 @@
+// Package mount contains the remote volume mounts of intercepts. The remote volumes are mirrored
+// to the mount point by a Mirror, which talks SFTP to the traffic-agent over a gRPC tunnel that the
+// user daemon opens to the traffic-manager. No external programs, such as sshfs, and no FUSE file
+// system are involved, and the mounts don't depend on the TUN device of the root daemon. On
+// Windows, the mount point may be a drive letter, which is then mapped to a temporary directory.
+package mount
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+// prepareDir creates the given directory unless it exists, and returns an error if it isn't empty.
+func prepareDir(dir string) (string, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+	if len(files) > 0 {
+		return "", fmt.Errorf("mount point %s is not empty", dir)
+	}
+	return dir, nil
+}
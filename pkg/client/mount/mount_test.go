@@ -0,0 +1,31 @@
+package mount
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrepareMountPoint(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "mnt")
+	mountPoint, err := PrepareMountPoint(dir)
+	require.NoError(t, err)
+	assert.Equal(t, dir, mountPoint)
+	assert.DirExists(t, dir)
+
+	// A directory that exists is used if it's empty
+	_, err = PrepareMountPoint(dir)
+	assert.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "file"), nil, 0600))
+	_, err = PrepareMountPoint(dir)
+	assert.Error(t, err)
+
+	mountPoint, err = PrepareMountPoint("")
+	require.NoError(t, err)
+	assert.NotEmpty(t, mountPoint)
+	_ = os.Remove(mountPoint)
+}
@@ -0,0 +1,27 @@
+// +build !windows
+
+package mount
+
+import (
+	"io/ioutil"
+	"os"
+)
+
+// PrepareMountPoint creates the given mount point, or a temporary directory if it's empty, and
+// returns it. The mount point must be empty.
+func PrepareMountPoint(mountPoint string) (string, error) {
+	if mountPoint == "" {
+		return ioutil.TempDir("", "telfs-")
+	}
+	return prepareDir(mountPoint)
+}
+
+// mirrorDir returns the directory that the files are mirrored to, which is the mount point itself.
+func mirrorDir(mountPoint string) (string, error) {
+	return mountPoint, nil
+}
+
+// releaseDir removes the mount point.
+func releaseDir(mountPoint, _ string) error {
+	return os.Remove(mountPoint)
+}
@@ -0,0 +1,71 @@
+package mount
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+
+	"golang.org/x/sys/windows"
+)
+
+var driveRx = regexp.MustCompile(`^[A-Za-z]:$`)
+
+// PrepareMountPoint returns the mount point, which is either a drive letter, e.g. "T:", or a
+// directory, which is created unless it exists and must be empty. A free drive letter is used when
+// the mount point is empty.
+func PrepareMountPoint(mountPoint string) (string, error) {
+	if mountPoint == "" {
+		return freeDrive()
+	}
+	if driveRx.MatchString(mountPoint) {
+		if _, err := os.Stat(mountPoint + `\`); err == nil {
+			return "", fmt.Errorf("drive %s is in use", mountPoint)
+		}
+		return mountPoint, nil
+	}
+	return prepareDir(mountPoint)
+}
+
+// freeDrive returns the first drive letter that isn't in use, trying T (for Telepresence) through Z first,
+// and then S down to G.
+func freeDrive() (string, error) {
+	for _, c := range "TUVWXYZSRQPONMLKJIHG" {
+		drive := string(c) + ":"
+		if _, err := os.Stat(drive + `\`); err != nil {
+			return drive, nil
+		}
+	}
+	return "", errors.New("there's no free drive letter to mount the remote volumes on")
+}
+
+// mirrorDir returns the directory that the files are mirrored to. A drive letter is mapped to a new
+// temporary directory, like the subst command does, and other mount points are used as they are.
+func mirrorDir(mountPoint string) (string, error) {
+	if !driveRx.MatchString(mountPoint) {
+		return mountPoint, nil
+	}
+	dir, err := ioutil.TempDir("", "telfs-")
+	if err != nil {
+		return "", err
+	}
+	if err = windows.DefineDosDevice(0, windows.StringToUTF16Ptr(mountPoint), windows.StringToUTF16Ptr(dir)); err != nil {
+		_ = os.Remove(dir)
+		return "", fmt.Errorf("unable to map drive %s to %s: %w", mountPoint, dir, err)
+	}
+	return dir, nil
+}
+
+// releaseDir removes the mount point, or the mapping of its drive letter and the directory that it
+// was mapped to.
+func releaseDir(mountPoint, dir string) error {
+	if !driveRx.MatchString(mountPoint) {
+		return os.Remove(mountPoint)
+	}
+	err := windows.DefineDosDevice(windows.DDD_REMOVE_DEFINITION, windows.StringToUTF16Ptr(mountPoint), nil)
+	if rerr := os.Remove(dir); err == nil {
+		err = rerr
+	}
+	return err
+}
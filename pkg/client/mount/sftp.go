@@ -0,0 +1,421 @@
+package mount
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"time"
+)
+
+// SFTP packet types, flags, and status codes of draft-ietf-secsh-filexfer-02, which is the version
+// of the protocol that the OpenSSH sftp-server of the traffic-agent implements.
+const (
+	sshFxpInit    = 1
+	sshFxpVersion = 2
+	sshFxpOpen    = 3
+	sshFxpClose   = 4
+	sshFxpRead    = 5
+	sshFxpWrite   = 6
+	sshFxpOpendir = 11
+	sshFxpReaddir = 12
+	sshFxpRemove  = 13
+	sshFxpMkdir   = 14
+	sshFxpRmdir   = 15
+	sshFxpStat    = 17
+	sshFxpStatus  = 101
+	sshFxpHandle  = 102
+	sshFxpData    = 103
+	sshFxpName    = 104
+	sshFxpAttrs   = 105
+
+	sshFxfRead  = 0x01
+	sshFxfWrite = 0x02
+	sshFxfCreat = 0x08
+	sshFxfTrunc = 0x10
+
+	sshFxOK         = 0
+	sshFxEOF        = 1
+	sshFxNoSuchFile = 2
+
+	sshFileXferAttrSize        = 0x01
+	sshFileXferAttrUIDGID      = 0x02
+	sshFileXferAttrPermissions = 0x04
+	sshFileXferAttrACModTime   = 0x08
+	sshFileXferAttrExtended    = 0x80000000
+
+	// sftpChunk is the size of the reads and writes, which is what OpenSSH uses
+	sftpChunk = 32 * 1024
+
+	// maxSFTPPacket is the largest packet accepted from the server, which is what OpenSSH accepts
+	maxSFTPPacket = 256 * 1024
+)
+
+// sftpStatus is the error of a request that the server answered with a status other than OK.
+type sftpStatus struct {
+	code uint32
+	msg  string
+}
+
+func (e *sftpStatus) Error() string {
+	return e.msg
+}
+
+// Is makes errors.Is(err, os.ErrNotExist) true for the status of a file that doesn't exist.
+func (e *sftpStatus) Is(target error) bool {
+	return target == os.ErrNotExist && e.code == sshFxNoSuchFile
+}
+
+// sftpAttrs are the attributes of a remote file that the mirror uses.
+type sftpAttrs struct {
+	size  int64
+	mode  uint32
+	mtime int64
+}
+
+func (a *sftpAttrs) isDir() bool {
+	return a.mode&0170000 == 0040000
+}
+
+func (a *sftpAttrs) isRegular() bool {
+	return a.mode&0170000 == 0100000
+}
+
+// sftpEntry is one entry of a remote directory. Its attributes are those of the entry itself, not
+// of the file that it links to.
+type sftpEntry struct {
+	name  string
+	attrs sftpAttrs
+}
+
+// sftpClient is a client of an SFTP server that makes one request at a time over the given
+// connection. A request that isn't answered within the timeout fails, and so does every request
+// that follows it, because the connection is then closed and its error is kept in err.
+type sftpClient struct {
+	conn    net.Conn
+	timeout time.Duration
+	lastID  uint32
+	err     error
+}
+
+// newSFTPClient negotiates version 3 of the protocol over the given connection.
+func newSFTPClient(conn net.Conn, timeout time.Duration) (*sftpClient, error) {
+	c := &sftpClient{conn: conn, timeout: timeout}
+	_ = conn.SetDeadline(time.Now().Add(timeout))
+	pkt := append(appendUint32(nil, 5), sshFxpInit)
+	if _, err := conn.Write(appendUint32(pkt, 3)); err != nil {
+		return nil, err
+	}
+	pkt, err := readSFTPPacket(conn)
+	if err != nil {
+		return nil, err
+	}
+	if pkt[0] != sshFxpVersion {
+		return nil, fmt.Errorf("unexpected sftp packet type %d", pkt[0])
+	}
+	return c, nil
+}
+
+// request sends a request of the given type with the given data, and returns the type and the data
+// of the reply. A status reply is returned as an error, unless it's OK.
+func (c *sftpClient) request(typ byte, data []byte) (byte, []byte, error) {
+	if c.err != nil {
+		return 0, nil, c.err
+	}
+	c.lastID++
+	id := c.lastID
+	pkt := make([]byte, 0, 9+len(data))
+	pkt = appendUint32(pkt, uint32(5+len(data)))
+	pkt = append(pkt, typ)
+	pkt = appendUint32(pkt, id)
+	pkt = append(pkt, data...)
+
+	_ = c.conn.SetDeadline(time.Now().Add(c.timeout))
+	_, err := c.conn.Write(pkt)
+	var reply []byte
+	if err == nil {
+		reply, err = readSFTPPacket(c.conn)
+	}
+	if err == nil && (len(reply) < 5 || binary.BigEndian.Uint32(reply[1:]) != id) {
+		err = errMalformed
+	}
+	if err != nil {
+		c.err = fmt.Errorf("sftp connection failed: %w", err)
+		c.conn.Close()
+		return 0, nil, c.err
+	}
+	typ, data = reply[0], reply[5:]
+	if typ != sshFxpStatus {
+		return typ, data, nil
+	}
+	code, data, err := sftpUint32(data)
+	if err != nil {
+		return 0, nil, err
+	}
+	if code == sshFxOK {
+		return typ, nil, nil
+	}
+	msg, _, _ := sftpString(data)
+	if msg == "" {
+		msg = fmt.Sprintf("sftp status %d", code)
+	}
+	return 0, nil, &sftpStatus{code: code, msg: msg}
+}
+
+// expect makes the given request and returns the data of the reply, which must have the given type.
+func (c *sftpClient) expect(replyType, typ byte, data []byte) ([]byte, error) {
+	t, data, err := c.request(typ, data)
+	if err != nil {
+		return nil, err
+	}
+	if t != replyType {
+		return nil, fmt.Errorf("unexpected sftp reply type %d", t)
+	}
+	return data, nil
+}
+
+// pathOp makes a request that names one path and expects a status reply.
+func (c *sftpClient) pathOp(op string, typ byte, path string, extra ...byte) error {
+	_, err := c.expect(sshFxpStatus, typ, append(appendString(nil, path), extra...))
+	if err != nil {
+		return &os.PathError{Op: op, Path: path, Err: err}
+	}
+	return nil
+}
+
+// stat returns the attributes of the given remote path, following symlinks.
+func (c *sftpClient) stat(path string) (*sftpAttrs, error) {
+	data, err := c.expect(sshFxpAttrs, sshFxpStat, appendString(nil, path))
+	if err != nil {
+		return nil, &os.PathError{Op: "stat", Path: path, Err: err}
+	}
+	a, _, err := sftpReadAttrs(data)
+	return a, err
+}
+
+// readDir returns the entries of the given remote directory, except "." and "..".
+func (c *sftpClient) readDir(path string) ([]sftpEntry, error) {
+	handle, err := c.expect(sshFxpHandle, sshFxpOpendir, appendString(nil, path))
+	if err != nil {
+		return nil, &os.PathError{Op: "opendir", Path: path, Err: err}
+	}
+	defer c.close(handle)
+
+	var entries []sftpEntry
+	for {
+		data, err := c.expect(sshFxpName, sshFxpReaddir, handle)
+		if err != nil {
+			if s, ok := err.(*sftpStatus); ok && s.code == sshFxEOF {
+				return entries, nil
+			}
+			return nil, &os.PathError{Op: "readdir", Path: path, Err: err}
+		}
+		var count uint32
+		if count, data, err = sftpUint32(data); err != nil {
+			return nil, err
+		}
+		for ; count > 0; count-- {
+			var e sftpEntry
+			if e.name, data, err = sftpString(data); err != nil {
+				return nil, err
+			}
+			if _, data, err = sftpString(data); err != nil { // the long name
+				return nil, err
+			}
+			var a *sftpAttrs
+			if a, data, err = sftpReadAttrs(data); err != nil {
+				return nil, err
+			}
+			e.attrs = *a
+			if e.name != "." && e.name != ".." {
+				entries = append(entries, e)
+			}
+		}
+	}
+}
+
+// handleRequest returns the data of a read or write request for the given handle and offset. The
+// handles are kept as they are encoded in the replies, i.e. as strings.
+func handleRequest(handle []byte, offset uint64, n uint32) []byte {
+	req := make([]byte, 0, len(handle)+12)
+	req = append(req, handle...)
+	req = appendUint32(appendUint32(req, uint32(offset>>32)), uint32(offset))
+	return appendUint32(req, n)
+}
+
+// open opens the given remote file with the given flags, and returns its handle.
+func (c *sftpClient) open(path string, pflags, perm uint32) ([]byte, error) {
+	data := appendUint32(appendString(nil, path), pflags)
+	data = appendUint32(appendUint32(data, sshFileXferAttrPermissions), perm)
+	handle, err := c.expect(sshFxpHandle, sshFxpOpen, data)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	return handle, nil
+}
+
+func (c *sftpClient) close(handle []byte) {
+	_, _, _ = c.request(sshFxpClose, handle)
+}
+
+// readFile copies the content of the given remote file to the given writer.
+func (c *sftpClient) readFile(path string, w io.Writer) error {
+	handle, err := c.open(path, sshFxfRead, 0)
+	if err != nil {
+		return err
+	}
+	defer c.close(handle)
+	for offset := uint64(0); ; {
+		data, err := c.expect(sshFxpData, sshFxpRead, handleRequest(handle, offset, sftpChunk))
+		if err != nil {
+			if s, ok := err.(*sftpStatus); ok && s.code == sshFxEOF {
+				return nil
+			}
+			return &os.PathError{Op: "read", Path: path, Err: err}
+		}
+		chunk, _, err := sftpString(data)
+		if err != nil {
+			return err
+		}
+		if _, err = io.WriteString(w, chunk); err != nil {
+			return err
+		}
+		offset += uint64(len(chunk))
+	}
+}
+
+// writeFile replaces the content of the given remote file, which is created with the given
+// permissions if it doesn't exist, with the content of the given reader.
+func (c *sftpClient) writeFile(path string, r io.Reader, perm uint32) error {
+	handle, err := c.open(path, sshFxfWrite|sshFxfCreat|sshFxfTrunc, perm)
+	if err != nil {
+		return err
+	}
+	defer c.close(handle)
+	buf := make([]byte, sftpChunk)
+	for offset := uint64(0); ; {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if _, err := c.expect(sshFxpStatus, sshFxpWrite, append(handleRequest(handle, offset, uint32(n)), buf[:n]...)); err != nil {
+				return &os.PathError{Op: "write", Path: path, Err: err}
+			}
+			offset += uint64(n)
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (c *sftpClient) remove(path string) error {
+	return c.pathOp("remove", sshFxpRemove, path)
+}
+
+func (c *sftpClient) mkdir(path string, perm uint32) error {
+	return c.pathOp("mkdir", sshFxpMkdir, path, appendUint32(appendUint32(nil, sshFileXferAttrPermissions), perm)...)
+}
+
+func (c *sftpClient) rmdir(path string) error {
+	return c.pathOp("rmdir", sshFxpRmdir, path)
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	var n [4]byte
+	binary.BigEndian.PutUint32(n[:], v)
+	return append(b, n[:]...)
+}
+
+func appendString(b []byte, s string) []byte {
+	return append(appendUint32(b, uint32(len(s))), s...)
+}
+
+// readSFTPPacket reads one packet from the given reader, and returns it without its length. The
+// packet has at least a type.
+func readSFTPPacket(r io.Reader) ([]byte, error) {
+	var hdr [4]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(hdr[:])
+	if n < 1 || n > maxSFTPPacket {
+		return nil, fmt.Errorf("invalid sftp packet length %d", n)
+	}
+	pkt := make([]byte, n)
+	if _, err := io.ReadFull(r, pkt); err != nil {
+		return nil, err
+	}
+	return pkt, nil
+}
+
+var errMalformed = errors.New("malformed sftp reply")
+
+// sftpUint32 returns the uint32 at the start of the given data and the data that follows it.
+func sftpUint32(data []byte) (uint32, []byte, error) {
+	if len(data) < 4 {
+		return 0, nil, errMalformed
+	}
+	return binary.BigEndian.Uint32(data), data[4:], nil
+}
+
+// sftpString returns the string at the start of the given data and the data that follows it.
+func sftpString(data []byte) (string, []byte, error) {
+	n, data, err := sftpUint32(data)
+	if err != nil || uint32(len(data)) < n {
+		return "", nil, errMalformed
+	}
+	return string(data[:n]), data[n:], nil
+}
+
+// sftpReadAttrs returns the attributes at the start of the given data and the data that follows them.
+func sftpReadAttrs(data []byte) (*sftpAttrs, []byte, error) {
+	flags, data, err := sftpUint32(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	a := &sftpAttrs{}
+	var hi, lo uint32
+	if flags&sshFileXferAttrSize != 0 {
+		if hi, data, err = sftpUint32(data); err != nil {
+			return nil, nil, err
+		}
+		if lo, data, err = sftpUint32(data); err != nil {
+			return nil, nil, err
+		}
+		a.size = int64(hi)<<32 | int64(lo)
+	}
+	if flags&sshFileXferAttrUIDGID != 0 {
+		if len(data) < 8 {
+			return nil, nil, errMalformed
+		}
+		data = data[8:]
+	}
+	if flags&sshFileXferAttrPermissions != 0 {
+		if a.mode, data, err = sftpUint32(data); err != nil {
+			return nil, nil, err
+		}
+	}
+	if flags&sshFileXferAttrACModTime != 0 {
+		if len(data) < 8 {
+			return nil, nil, errMalformed
+		}
+		a.mtime = int64(binary.BigEndian.Uint32(data[4:]))
+		data = data[8:]
+	}
+	if flags&sshFileXferAttrExtended != 0 {
+		var count uint32
+		if count, data, err = sftpUint32(data); err != nil {
+			return nil, nil, err
+		}
+		for i := uint32(0); i < 2*count; i++ {
+			if _, data, err = sftpString(data); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	return a, data, nil
+}
@@ -0,0 +1,232 @@
+package mount
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const sshFxFailure = 4
+
+// sftpServer serves the requests that the mirror makes from the files in its root directory, which
+// the absolute paths of the requests are relative to. Writes are refused when it's read-only.
+type sftpServer struct {
+	conn     net.Conn
+	root     string
+	readOnly bool
+	handles  map[string]interface{} // *os.File, or the []os.FileInfo of a directory
+	nextID   int
+}
+
+// startSFTPServer serves the given directory on one end of a pipe, and returns the other end.
+func startSFTPServer(t *testing.T, root string, readOnly bool) net.Conn {
+	client, server := net.Pipe()
+	t.Cleanup(func() { _ = client.Close() })
+	s := &sftpServer{conn: server, root: root, readOnly: readOnly, handles: make(map[string]interface{})}
+	go s.serve()
+	return client
+}
+
+func (s *sftpServer) serve() {
+	defer s.conn.Close()
+	for {
+		pkt, err := readSFTPPacket(s.conn)
+		if err != nil {
+			return
+		}
+		if pkt[0] == sshFxpInit {
+			_, _ = s.conn.Write(append(append(appendUint32(nil, 5), sshFxpVersion), 0, 0, 0, 3))
+			continue
+		}
+		id := binary.BigEndian.Uint32(pkt[1:])
+		typ, reply := s.handle(pkt[0], pkt[5:])
+		out := appendUint32(nil, uint32(5+len(reply)))
+		out = append(out, typ)
+		out = appendUint32(out, id)
+		if _, err = s.conn.Write(append(out, reply...)); err != nil {
+			return
+		}
+	}
+}
+
+func status(code uint32, msg string) (byte, []byte) {
+	return sshFxpStatus, appendString(appendString(appendUint32(nil, code), msg), "")
+}
+
+func errStatus(err error) (byte, []byte) {
+	switch {
+	case os.IsNotExist(err):
+		return status(sshFxNoSuchFile, err.Error())
+	default:
+		return status(sshFxFailure, err.Error())
+	}
+}
+
+func appendAttrs(b []byte, info os.FileInfo) []byte {
+	mode := uint32(info.Mode().Perm())
+	switch {
+	case info.IsDir():
+		mode |= 0040000
+	case info.Mode()&os.ModeSymlink != 0:
+		mode |= 0120000
+	default:
+		mode |= 0100000
+	}
+	b = appendUint32(b, sshFileXferAttrSize|sshFileXferAttrPermissions|sshFileXferAttrACModTime)
+	b = appendUint32(appendUint32(b, uint32(info.Size()>>32)), uint32(info.Size()))
+	b = appendUint32(b, mode)
+	mtime := uint32(info.ModTime().Unix())
+	return appendUint32(appendUint32(b, mtime), mtime)
+}
+
+func (s *sftpServer) newHandle(v interface{}) (byte, []byte) {
+	s.nextID++
+	h := strconv.Itoa(s.nextID)
+	s.handles[h] = v
+	return sshFxpHandle, appendString(nil, h)
+}
+
+func (s *sftpServer) handle(typ byte, data []byte) (byte, []byte) {
+	str, data, err := sftpString(data)
+	if err != nil {
+		return status(sshFxFailure, err.Error())
+	}
+	path := filepath.Join(s.root, filepath.FromSlash(str))
+	switch typ {
+	case sshFxpStat:
+		info, err := os.Stat(path)
+		if err != nil {
+			return errStatus(err)
+		}
+		return sshFxpAttrs, appendAttrs(nil, info)
+	case sshFxpOpendir:
+		entries, err := ioutil.ReadDir(path)
+		if err != nil {
+			return errStatus(err)
+		}
+		return s.newHandle(entries)
+	case sshFxpReaddir:
+		entries, ok := s.handles[str].([]os.FileInfo)
+		if !ok || len(entries) == 0 {
+			return status(sshFxEOF, "EOF")
+		}
+		s.handles[str] = []os.FileInfo(nil)
+		reply := appendUint32(nil, uint32(len(entries)))
+		for _, info := range entries {
+			reply = appendAttrs(appendString(appendString(reply, info.Name()), info.Name()), info)
+		}
+		return sshFxpName, reply
+	case sshFxpOpen:
+		pflags := binary.BigEndian.Uint32(data)
+		flags := os.O_RDONLY
+		if pflags&sshFxfWrite != 0 {
+			if s.readOnly {
+				return status(sshFxFailure, "read-only file system")
+			}
+			flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		}
+		f, err := os.OpenFile(path, flags, 0644)
+		if err != nil {
+			return errStatus(err)
+		}
+		return s.newHandle(f)
+	case sshFxpRead, sshFxpWrite:
+		f, ok := s.handles[str].(*os.File)
+		if !ok {
+			return status(sshFxFailure, "invalid handle")
+		}
+		offset := int64(binary.BigEndian.Uint64(data))
+		if typ == sshFxpWrite {
+			chunk, _, _ := sftpString(data[8:])
+			if _, err := f.WriteAt([]byte(chunk), offset); err != nil {
+				return errStatus(err)
+			}
+			return status(sshFxOK, "")
+		}
+		buf := make([]byte, binary.BigEndian.Uint32(data[8:]))
+		n, err := f.ReadAt(buf, offset)
+		if n == 0 && err == io.EOF {
+			return status(sshFxEOF, "EOF")
+		}
+		return sshFxpData, appendString(nil, string(buf[:n]))
+	case sshFxpClose:
+		if f, ok := s.handles[str].(*os.File); ok {
+			f.Close()
+		}
+		delete(s.handles, str)
+		return status(sshFxOK, "")
+	case sshFxpRemove, sshFxpMkdir, sshFxpRmdir:
+		if s.readOnly {
+			return status(sshFxFailure, "read-only file system")
+		}
+		switch typ {
+		case sshFxpMkdir:
+			err = os.Mkdir(path, 0755)
+		default:
+			err = os.Remove(path)
+		}
+		if err != nil {
+			return errStatus(err)
+		}
+		return status(sshFxOK, "")
+	}
+	return status(sshFxFailure, "unsupported")
+}
+
+func TestSFTPClient(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(root, "dir"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(root, "dir", "file"), []byte("hello"), 0644))
+	c, err := newSFTPClient(startSFTPServer(t, root, false), 5*time.Second)
+	require.NoError(t, err)
+
+	a, err := c.stat("/dir")
+	require.NoError(t, err)
+	assert.True(t, a.isDir())
+	_, err = c.stat("/missing")
+	assert.True(t, errors.Is(err, os.ErrNotExist))
+	assert.NoError(t, c.err, "a status reply doesn't fail the connection")
+
+	entries, err := c.readDir("/dir")
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "file", entries[0].name)
+	assert.True(t, entries[0].attrs.isRegular())
+	assert.Equal(t, int64(5), entries[0].attrs.size)
+
+	// A file that is larger than a chunk
+	big := make([]byte, 3*sftpChunk+10)
+	for i := range big {
+		big[i] = byte(i)
+	}
+	require.NoError(t, c.writeFile("/dir/big", bytes.NewReader(big), 0644))
+	got, err := ioutil.ReadFile(filepath.Join(root, "dir", "big"))
+	require.NoError(t, err)
+	assert.Equal(t, big, got)
+	buf := &bytes.Buffer{}
+	require.NoError(t, c.readFile("/dir/big", buf))
+	assert.Equal(t, big, buf.Bytes())
+
+	require.NoError(t, c.mkdir("/dir/sub", 0755))
+	assert.DirExists(t, filepath.Join(root, "dir", "sub"))
+	require.NoError(t, c.rmdir("/dir/sub"))
+	require.NoError(t, c.remove("/dir/big"))
+	assert.NoFileExists(t, filepath.Join(root, "dir", "big"))
+
+	// Every request fails once the connection is lost
+	c.conn.Close()
+	_, err = c.stat("/dir")
+	assert.Error(t, err)
+	assert.Error(t, c.err)
+}
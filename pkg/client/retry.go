@@ -11,11 +11,17 @@ const defaultRetryDelay = 100 * time.Millisecond
 const defaultMaxDelay = 3 * time.Second
 
 // Retry will run the given function repeatedly with an increasing delay until it returns without error.
+// Each delay is jittered, i.e. somewhere between half of its nominal value and its nominal value.
 //
 // The function takes 0 to 2 durations with the following meaning
 //  Delay - initial delay, i.e. the delay between the first and the second call.
 //  MaxDelay - maximum delay between calling the functions (delay will never grow beyond this value)
 func Retry(c context.Context, text string, f func(context.Context) error, durations ...time.Duration) error {
+	return RetryWithBreaker(c, text, nil, f, durations...)
+}
+
+// RetryWithBreaker is like Retry, but each call after the first one also waits for the given breaker.
+func RetryWithBreaker(c context.Context, text string, b *Breaker, f func(context.Context) error, durations ...time.Duration) error {
 	delay := defaultRetryDelay
 	maxDelay := defaultMaxDelay
 
@@ -45,12 +51,19 @@ func Retry(c context.Context, text string, f func(context.Context) error, durati
 		}
 
 		// Logging at higher log levels should be done in the called function
-		dlog.Debugf(c, "%s waiting %s before retrying after error: %v", text, delay.String(), err)
+		jd := Jitter(delay)
+		dlog.Debugf(c, "%s waiting %s before retrying after error: %v", text, jd.String(), err)
 
 		select {
 		case <-c.Done():
 			return err
-		case <-time.After(delay):
+		case <-time.After(jd):
+		}
+		if reason := b.OpenReason(); reason != "" {
+			dlog.Debugf(c, "%s waiting before retrying: %s", text, reason)
+		}
+		if b.Wait(c) != nil {
+			return err
 		}
 		delay *= 2
 		if delay > maxDelay {
@@ -10,6 +10,8 @@ import (
 	"time"
 
 	"google.golang.org/grpc"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/tracing"
 )
 
 const (
@@ -18,6 +20,14 @@ const (
 
 	// DaemonSocketName is the path used when communicating to the daemon process
 	DaemonSocketName = "/var/run/telepresence-daemon.socket"
+
+	// ConnectorDebugSocketName is the path of the socket that the connector serves debug
+	// information on when started with --debug
+	ConnectorDebugSocketName = "/tmp/telepresence-connector-debug.socket"
+
+	// DaemonDebugSocketName is the path of the socket that the daemon serves debug information
+	// on when started with --debug
+	DaemonDebugSocketName = "/var/run/telepresence-daemon-debug.socket"
 )
 
 // SocketExists returns true if a socket is found at the given path
@@ -26,6 +36,44 @@ func SocketExists(path string) bool {
 	return err == nil && s.Mode()&os.ModeSocket != 0
 }
 
+// SocketDialer provides the sockets of the daemons instead of the file system, e.g. in-memory
+// sockets that serve fake daemons in tests.
+type SocketDialer interface {
+	// Serves returns true if the dialer provides the socket with the given name
+	Serves(socketName string) bool
+
+	// Dial dials the socket with the given name
+	Dial(ctx context.Context, socketName string) (net.Conn, error)
+}
+
+type socketDialerKey struct{}
+
+// WithSocketDialer returns a context that makes DialSocket and SocketExistsContext use the given
+// dialer instead of the file system.
+func WithSocketDialer(ctx context.Context, d SocketDialer) context.Context {
+	return context.WithValue(ctx, socketDialerKey{}, d)
+}
+
+func getSocketDialer(ctx context.Context) SocketDialer {
+	d, _ := ctx.Value(socketDialerKey{}).(SocketDialer)
+	return d
+}
+
+// HasSocketDialer returns true if the context has a SocketDialer, in which case the sockets of the
+// daemons are provided by it and not by daemon processes.
+func HasSocketDialer(ctx context.Context) bool {
+	return getSocketDialer(ctx) != nil
+}
+
+// SocketExistsContext is like SocketExists, but asks the SocketDialer of the context, if any,
+// instead of the file system.
+func SocketExistsContext(ctx context.Context, path string) bool {
+	if d := getSocketDialer(ctx); d != nil {
+		return d.Serves(path)
+	}
+	return SocketExists(path)
+}
+
 // WaitUntilSocketVanishes waits until the socket at the given path is removed
 // and returns when that happens. The wait will be max ttw (time to wait) long.
 // An error is returned if that time is exceeded before the socket is removed.
@@ -68,18 +116,34 @@ func SocketURL(socket string) string {
 	return "unix:" + socket
 }
 
-// DialSocket dials the given unix socket and returns the resulting connection
+// DialSocket dials the given unix socket and returns the resulting connection. The socket is
+// dialed using the SocketDialer of the context, if any.
 func DialSocket(ctx context.Context, socketName string, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
-	ctx, cancel := context.WithTimeout(ctx, 5*time.Second) // FIXME(lukeshu): Make this configurable
+	target := SocketURL(socketName)
+	if d := getSocketDialer(ctx); d != nil {
+		if !d.Serves(socketName) {
+			return nil, &net.OpError{
+				Op:   "dial",
+				Net:  "unix",
+				Addr: &net.UnixAddr{Name: socketName, Net: "unix"},
+				Err:  fmt.Errorf("%w; this usually means that the process is not running", os.ErrNotExist),
+			}
+		}
+		target = "passthrough:///" + socketName
+		opts = append(opts, grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return d.Dial(ctx, socketName)
+		}))
+	}
+	ctx, cancel := GetConfig(ctx).Timeouts.TimeoutContext(ctx, TimeoutDaemonDial)
 	defer cancel()
-	conn, err := grpc.DialContext(ctx, SocketURL(socketName), append([]grpc.DialOption{
+	conn, err := grpc.DialContext(ctx, target, append(append([]grpc.DialOption{
 		grpc.WithInsecure(),
 		grpc.WithNoProxy(),
 		grpc.WithBlock(),
 		grpc.FailOnNonTempDialError(true),
-	}, opts...)...)
+	}, tracing.DialOptions()...), opts...)...)
 	if err != nil {
-		if err == context.DeadlineExceeded {
+		if errors.Is(err, context.DeadlineExceeded) {
 			// grpc.DialContext doesn't wrap context.DeadlineExceeded with any useful
 			// information at all.  Fix that.
 			err = &net.OpError{
@@ -89,7 +153,7 @@ func DialSocket(ctx context.Context, socketName string, opts ...grpc.DialOption)
 					Name: socketName,
 					Net:  "unix",
 				},
-				Err: fmt.Errorf("socket exists but is not responding: %w", err),
+				Err: fmt.Errorf("socket exists but is not responding: %w", CheckTimeout(ctx, err)),
 			}
 		}
 		// Add some Telepresence-specific commentary on what specific common errors mean.
@@ -0,0 +1,174 @@
+// Package clientapi lets Go programs control Telepresence the way the telepresence CLI does, but
+// without running the CLI and parsing its output. It talks to the daemons over their gRPC sockets
+// and starts them when needed, so the program must be able to run the telepresence executable,
+// and the root daemon is started using sudo.
+//
+// A typical session is:
+//
+//	info, err := clientapi.Connect(ctx, &clientapi.ConnectRequest{})
+//	...
+//	icept, err := clientapi.CreateIntercept(ctx, &clientapi.InterceptRequest{Name: "echo", Port: 8080})
+//	...
+//	err = clientapi.Leave(ctx, icept.Info.Spec.Name)
+//	...
+//	err = clientapi.Quit(ctx)
+//
+// Errors that the daemons report are returned as a *ConnectError or an *InterceptError.
+//
+// Plugins, i.e. executables that provide subcommands of the telepresence CLI, can use RunPlugin to
+// get the state of the session that they are run in.
+package clientapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	//nolint:depguard // Only exec.LookPath is used.
+	"os/exec"
+
+	empty "google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/rpc/v2/daemon"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/cliutil"
+)
+
+var exeOnce sync.Once
+var exeErr error
+
+// SetExecutable sets the path of the telepresence executable that is used when the daemons must be
+// started. The telepresence executable found in the PATH is used unless this function is called
+// before any other function in this package.
+func SetExecutable(path string) {
+	exeOnce.Do(func() {})
+	client.SetExe(path)
+}
+
+func ensureExecutable() error {
+	exeOnce.Do(func() {
+		var path string
+		if path, exeErr = exec.LookPath("telepresence"); exeErr == nil {
+			client.SetExe(path)
+		} else {
+			exeErr = fmt.Errorf("unable to find the telepresence executable: %w", exeErr)
+		}
+	})
+	return exeErr
+}
+
+// ConnectRequest tells how to connect to the cluster.
+type ConnectRequest struct {
+	// KubeFlags are kubectl flags, e.g. "context" or "kubeconfig", that select the cluster. The
+	// current context of the default kubeconfig is used when it's empty.
+	KubeFlags map[string]string `yaml:"kubeFlags,omitempty"`
+
+	// MappedNamespaces limits the namespaces that are considered by the DNS resolver and the
+	// outbound connectivity. All namespaces are considered when it's empty.
+	MappedNamespaces []string `yaml:"mappedNamespaces,omitempty"`
+
+	// DNSIP is the IP of the DNS server that the root daemon intercepts, on platforms where that's
+	// applicable. The default is the first nameserver of /etc/resolv.conf.
+	DNSIP string `yaml:"dnsIP,omitempty"`
+}
+
+// ConnectError is the error returned when the user daemon is unable to connect.
+type ConnectError struct {
+	Code connector.ConnectInfo_ErrType
+	Text string
+}
+
+func (e *ConnectError) Error() string {
+	return "connector.Connect: " + e.Text
+}
+
+// CheckConnectInfo returns a *ConnectError if the given info is the result of a failed connect.
+func CheckConnectInfo(info *connector.ConnectInfo) error {
+	var msg string
+	switch info.Error {
+	case connector.ConnectInfo_UNSPECIFIED, connector.ConnectInfo_ALREADY_CONNECTED:
+		return nil
+	case connector.ConnectInfo_DISCONNECTED:
+		msg = "Not connected"
+	case connector.ConnectInfo_MUST_RESTART:
+		msg = "Cluster configuration changed, please quit telepresence and reconnect"
+	default:
+		msg = info.ErrorText
+	}
+	return &ConnectError{Code: info.Error, Text: msg}
+}
+
+// Connect starts the daemons unless they are running, and connects them to the cluster unless
+// they are connected. The daemons keep running after Connect returns. The Error of the returned
+// info is connector.ConnectInfo_ALREADY_CONNECTED when the daemons were already connected.
+func Connect(ctx context.Context, rq *ConnectRequest) (*connector.ConnectInfo, error) {
+	if err := ensureExecutable(); err != nil {
+		return nil, err
+	}
+	var info *connector.ConnectInfo
+	err := cliutil.WithDaemon(ctx, rq.DNSIP, func(ctx context.Context, _ daemon.DaemonClient) error {
+		return cliutil.WithConnector(ctx, func(ctx context.Context, connectorClient connector.ConnectorClient) error {
+			var err error
+			info, err = connectorClient.Connect(ctx, &connector.ConnectRequest{
+				KubeFlags:        rq.KubeFlags,
+				MappedNamespaces: rq.MappedNamespaces,
+			})
+			if err != nil {
+				return err
+			}
+			return CheckConnectInfo(info)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// Status is the state of the daemons.
+type Status struct {
+	RootDaemonRunning bool
+	UserDaemonRunning bool
+
+	// Connection is the state of the user daemon's connection to the cluster. It's nil unless the
+	// user daemon is running and its Error is connector.ConnectInfo_DISCONNECTED unless it's
+	// connected. The Intercepts of a connection are the active intercepts.
+	Connection *connector.ConnectInfo
+}
+
+// GetStatus returns the state of the daemons. It never starts them.
+func GetStatus(ctx context.Context) (*Status, error) {
+	st := &Status{}
+	err := cliutil.WithStartedDaemon(ctx, func(ctx context.Context, daemonClient daemon.DaemonClient) error {
+		_, err := daemonClient.Status(ctx, &empty.Empty{})
+		st.RootDaemonRunning = err == nil
+		return err
+	})
+	if err != nil && !errors.Is(err, cliutil.ErrNoDaemon) {
+		return nil, err
+	}
+	err = cliutil.WithStartedConnector(ctx, func(ctx context.Context, connectorClient connector.ConnectorClient) error {
+		var err error
+		if st.Connection, err = connectorClient.Status(ctx, &connector.ConnectRequest{}); err != nil {
+			return err
+		}
+		st.UserDaemonRunning = true
+		return nil
+	})
+	if err != nil && !errors.Is(err, cliutil.ErrNoConnector) {
+		return nil, err
+	}
+	return st, nil
+}
+
+// Quit stops the daemons. Active intercepts are removed.
+func Quit(ctx context.Context) error {
+	// When the daemon shuts down, it will tell the connector to shut down, but the connector might
+	// be running without a daemon.
+	if err := cliutil.QuitDaemon(ctx); err != nil {
+		return err
+	}
+	return cliutil.QuitConnector(ctx)
+}
@@ -0,0 +1,39 @@
+package clientapi
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/cliutil"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/connector/userd_forward"
+)
+
+// Forward is a port on localhost that the user daemon forwards to a port of a service in the
+// cluster. Only the Service and the LocalPort are required.
+type Forward = userd_forward.Forward
+
+// AddForward makes the user daemon forward a port on localhost to a service in the cluster. The
+// forward remains until it's removed or the user daemon quits. Connect must be called first.
+func AddForward(ctx context.Context, f *Forward) error {
+	return cliutil.WithConnectorConn(ctx, func(ctx context.Context, conn grpc.ClientConnInterface) error {
+		return userd_forward.AddForward(ctx, conn, f)
+	})
+}
+
+// RemoveForward removes the forward of the given local port.
+func RemoveForward(ctx context.Context, localPort uint16) error {
+	return cliutil.WithConnectorConn(ctx, func(ctx context.Context, conn grpc.ClientConnInterface) error {
+		return userd_forward.RemoveForward(ctx, conn, localPort)
+	})
+}
+
+// ListForwards returns the forwards of the user daemon.
+func ListForwards(ctx context.Context) ([]*Forward, error) {
+	var fws []*Forward
+	err := cliutil.WithConnectorConn(ctx, func(ctx context.Context, conn grpc.ClientConnInterface) (err error) {
+		fws, err = userd_forward.ListForwards(ctx, conn)
+		return err
+	})
+	return fws, err
+}
@@ -0,0 +1,250 @@
+package clientapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/datawire/dlib/dcontext"
+	"github.com/telepresenceio/telepresence/rpc/v2/connector"
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/cliutil"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/extensions"
+)
+
+// InterceptRequest describes an intercept. Only the Name is required.
+type InterceptRequest struct {
+	// Name is the name of the intercept
+	Name string `yaml:"name,omitempty"`
+
+	// Namespace is the namespace of the workload. The namespace of the current context is used
+	// when it's empty.
+	Namespace string `yaml:"namespace,omitempty"`
+
+	// Workload is the name of the intercepted workload. It defaults to the Name.
+	Workload string `yaml:"workload,omitempty"`
+
+	// Service is the name of the service to intercept. It must be given when more than one
+	// service targets the workload.
+	Service string `yaml:"service,omitempty"`
+
+	// ServicePort is the name or number of the service port to intercept. It must be given when
+	// the service has more than one port.
+	ServicePort string `yaml:"servicePort,omitempty"`
+
+	// Port is the local port that intercepted traffic is sent to. It defaults to 8080.
+	Port uint16 `yaml:"port,omitempty"`
+
+	// Mechanism is the intercept mechanism, e.g. "tcp". The CLI's default is used when it's empty.
+	Mechanism string `yaml:"mechanism,omitempty"`
+
+	// MountPoint is where the volumes of the intercepted pod are mounted. They aren't mounted when
+	// it's empty.
+	MountPoint string `yaml:"mountPoint,omitempty"`
+
+	// ExtraPorts are ports of the intercepted pod that are forwarded to localhost.
+	ExtraPorts []uint16 `yaml:"extraPorts,omitempty"`
+
+	// Duration is how long the intercept lasts before the traffic-manager removes it, e.g. "2h".
+	// The intercept lasts until it's removed when it's zero.
+	Duration time.Duration `yaml:"duration,omitempty"`
+}
+
+// Intercept is an established intercept.
+type Intercept struct {
+	Info *manager.InterceptInfo
+
+	// WorkloadKind is the kind of the intercepted workload, e.g. "Deployment"
+	WorkloadKind string
+
+	// Environment is the environment of the intercepted container
+	Environment map[string]string
+}
+
+// InterceptError is the error returned when the user daemon fails to create or remove an
+// intercept.
+type InterceptError struct {
+	Result *connector.InterceptResult
+}
+
+func (e *InterceptError) Error() string {
+	return InterceptMessage(e.Result)
+}
+
+// Code returns the code of the error reported by the user daemon.
+func (e *InterceptError) Code() connector.InterceptError {
+	return e.Result.Error
+}
+
+// InterceptMessage returns a message that describes the error of the given result.
+func InterceptMessage(r *connector.InterceptResult) string {
+	msg := ""
+	switch r.Error {
+	case connector.InterceptError_UNSPECIFIED:
+		msg = "No error"
+	case connector.InterceptError_NO_CONNECTION:
+		msg = "Local network is not connected to the cluster"
+	case connector.InterceptError_NO_TRAFFIC_MANAGER:
+		msg = "Intercept unavailable: no traffic manager"
+	case connector.InterceptError_TRAFFIC_MANAGER_CONNECTING:
+		msg = "Connecting to traffic manager..."
+	case connector.InterceptError_TRAFFIC_MANAGER_ERROR:
+		msg = r.ErrorText
+	case connector.InterceptError_ALREADY_EXISTS:
+		msg = fmt.Sprintf("Intercept with name %q already exists", r.ErrorText)
+	case connector.InterceptError_LOCAL_TARGET_IN_USE:
+		spec := r.InterceptInfo.Spec
+		msg = fmt.Sprintf("Port %s:%d is already in use by intercept %s",
+			spec.TargetHost, spec.TargetPort, r.ErrorText)
+	case connector.InterceptError_NO_ACCEPTABLE_WORKLOAD:
+		msg = fmt.Sprintf("No interceptable deployment or replicaset matching %s found", r.ErrorText)
+	case connector.InterceptError_AMBIGUOUS_MATCH:
+		var matches []manager.AgentInfo
+		err := json.Unmarshal([]byte(r.ErrorText), &matches)
+		if err != nil {
+			msg = fmt.Sprintf("Unable to unmarshal JSON: %v", err)
+			break
+		}
+		st := &strings.Builder{}
+		fmt.Fprintf(st, "Found more than one possible match:")
+		for idx := range matches {
+			match := &matches[idx]
+			fmt.Fprintf(st, "\n%4d: %s.%s", idx+1, match.Name, match.Namespace)
+		}
+		msg = st.String()
+	case connector.InterceptError_FAILED_TO_ESTABLISH:
+		msg = fmt.Sprintf("Failed to establish intercept: %s", r.ErrorText)
+	case connector.InterceptError_NOT_FOUND:
+		msg = fmt.Sprintf("Intercept named %q not found", r.ErrorText)
+	case connector.InterceptError_MOUNT_POINT_BUSY:
+		msg = fmt.Sprintf("Mount point already in use by intercept %q", r.ErrorText)
+	default:
+		msg = fmt.Sprintf("Unknown error code %d", r.Error)
+	}
+	if id := r.GetInterceptInfo().GetId(); id != "" {
+		return fmt.Sprintf("Intercept %q: %s", id, msg)
+	}
+	return fmt.Sprintf("Intercept: %s", msg)
+}
+
+// CreateIntercept creates an intercept. The daemons must be connected, see Connect. The intercept
+// remains until it's removed using Leave or the daemons quit.
+func CreateIntercept(ctx context.Context, rq *InterceptRequest) (*Intercept, error) {
+	ir, err := createRequest(ctx, rq)
+	if err != nil {
+		return nil, err
+	}
+	var icept *Intercept
+	err = cliutil.WithStartedConnector(ctx, func(ctx context.Context, connectorClient connector.ConnectorClient) error {
+		r, err := connectorClient.CreateIntercept(ctx, ir)
+		if err != nil {
+			return fmt.Errorf("connector.CreateIntercept: %w", err)
+		}
+		if r.Error != connector.InterceptError_UNSPECIFIED {
+			return &InterceptError{Result: r}
+		}
+		icept = &Intercept{Info: r.InterceptInfo, WorkloadKind: r.WorkloadKind, Environment: r.Environment}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return icept, nil
+}
+
+func createRequest(ctx context.Context, rq *InterceptRequest) (*connector.CreateInterceptRequest, error) {
+	// The mechanisms, and the agent images that they need, are provided by the same extensions
+	// that the CLI uses.
+	flags := pflag.NewFlagSet("", pflag.ContinueOnError)
+	es, err := extensions.LoadExtensions(ctx, flags)
+	if err != nil {
+		return nil, err
+	}
+	if rq.Mechanism != "" {
+		if err = flags.Set("mechanism", rq.Mechanism); err != nil {
+			return nil, err
+		}
+	}
+	if needLogin, err := es.RequiresAPIKeyOrLicense(); err != nil {
+		return nil, err
+	} else if needLogin && !client.GetConfig(ctx).Cloud.SkipLogin && !cliutil.HasLoggedIn(ctx) {
+		mechanism, _ := es.Mechanism()
+		return nil, fmt.Errorf("the %s mechanism requires that you log in using \"telepresence login\"", mechanism)
+	}
+	return NewCreateInterceptRequest(ctx, rq, es)
+}
+
+// NewCreateInterceptRequest returns the request that the user daemon gets to create the described
+// intercept. The mechanism, its arguments, and the agent image come from the given extensions,
+// whose flags select the mechanism. The Mechanism of the InterceptRequest isn't used. It's the
+// CLI's job to ensure that the user has logged in when the mechanism requires it.
+func NewCreateInterceptRequest(ctx context.Context, rq *InterceptRequest, es *extensions.ExtensionsState) (*connector.CreateInterceptRequest, error) {
+	if rq.Name == "" {
+		return nil, errors.New("an intercept must have a name")
+	}
+	if rq.Duration < 0 {
+		return nil, errors.New("the duration of an intercept cannot be negative")
+	}
+	spec := &manager.InterceptSpec{
+		Name:                  rq.Name,
+		Namespace:             rq.Namespace,
+		Agent:                 rq.Workload,
+		ServiceName:           rq.Service,
+		ServicePortIdentifier: rq.ServicePort,
+		TargetHost:            "127.0.0.1",
+		TargetPort:            int32(rq.Port),
+	}
+	if la := client.GetConfig(ctx).Intercept.LocalAddress; la != "" {
+		spec.TargetHost = la
+	}
+	if spec.Agent == "" {
+		spec.Agent = rq.Name
+	}
+	if spec.TargetPort == 0 {
+		spec.TargetPort = 8080
+	}
+	for _, port := range rq.ExtraPorts {
+		spec.ExtraPorts = append(spec.ExtraPorts, int32(port))
+	}
+	if rq.Duration > 0 {
+		spec.Duration = durationpb.New(rq.Duration)
+	}
+
+	var err error
+	if spec.Mechanism, err = es.Mechanism(); err != nil {
+		return nil, err
+	}
+	if spec.MechanismArgs, err = es.MechanismArgs(); err != nil {
+		return nil, err
+	}
+	env, err := client.LoadEnv(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ir := &connector.CreateInterceptRequest{Spec: spec, MountPoint: rq.MountPoint}
+	if ir.AgentImage, err = es.AgentImage(ctx, env); err != nil {
+		return nil, err
+	}
+	return ir, nil
+}
+
+// Leave removes the intercept with the given name.
+func Leave(ctx context.Context, name string) error {
+	return cliutil.WithStartedConnector(ctx, func(ctx context.Context, connectorClient connector.ConnectorClient) error {
+		r, err := connectorClient.RemoveIntercept(dcontext.WithoutCancel(ctx), &manager.RemoveInterceptRequest2{Name: name})
+		if err != nil {
+			return err
+		}
+		if r.Error != connector.InterceptError_UNSPECIFIED {
+			return &InterceptError{Result: r}
+		}
+		return nil
+	})
+}
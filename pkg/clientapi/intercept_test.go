@@ -0,0 +1,76 @@
+package clientapi
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/client/cli/extensions"
+	"github.com/telepresenceio/telepresence/v2/pkg/filelocation"
+)
+
+// testContext returns a context whose client config is the given config.yml.
+func testContext(t *testing.T, configYml string) context.Context {
+	dir := t.TempDir()
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "config.yml"), []byte(configYml), 0600))
+	ctx := dlog.NewTestContext(t, false)
+	ctx = filelocation.WithAppUserConfigDir(ctx, dir)
+	ctx = filelocation.WithAppSystemConfigDirs(ctx, []string{filepath.Join(dir, "system")})
+	client.ResetConfig(ctx)
+	t.Cleanup(func() { client.ResetConfig(ctx) })
+	return ctx
+}
+
+func TestNewCreateInterceptRequest(t *testing.T) {
+	ctx := testContext(t, `
+images:
+  agentImage: example.com/tel2:test
+intercept:
+  localAddress: 127.0.0.2
+`)
+	es, err := extensions.LoadExtensions(ctx, pflag.NewFlagSet("", pflag.ContinueOnError))
+	require.NoError(t, err)
+
+	ir, err := NewCreateInterceptRequest(ctx, &InterceptRequest{
+		Name:        "echo-default",
+		Namespace:   "default",
+		Workload:    "echo",
+		ServicePort: "http",
+		ExtraPorts:  []uint16{9090},
+		MountPoint:  "/tmp/echo",
+		Duration:    2 * time.Hour,
+	}, es)
+	require.NoError(t, err)
+	spec := ir.Spec
+	assert.Equal(t, "echo-default", spec.Name)
+	assert.Equal(t, "default", spec.Namespace)
+	assert.Equal(t, "echo", spec.Agent)
+	assert.Equal(t, "http", spec.ServicePortIdentifier)
+	assert.Equal(t, "127.0.0.2", spec.TargetHost)
+	assert.Equal(t, int32(8080), spec.TargetPort)
+	assert.Equal(t, []int32{9090}, spec.ExtraPorts)
+	assert.Equal(t, "tcp", spec.Mechanism)
+	assert.Equal(t, "example.com/tel2:test", ir.AgentImage)
+	assert.Equal(t, "/tmp/echo", ir.MountPoint)
+	assert.Equal(t, 2*time.Hour, spec.Duration.AsDuration())
+
+	ir, err = NewCreateInterceptRequest(ctx, &InterceptRequest{Name: "echo", Port: 3000}, es)
+	require.NoError(t, err)
+	assert.Equal(t, "echo", ir.Spec.Agent)
+	assert.Equal(t, int32(3000), ir.Spec.TargetPort)
+	assert.Nil(t, ir.Spec.Duration, "the intercept lasts until it's removed")
+
+	_, err = NewCreateInterceptRequest(ctx, &InterceptRequest{}, es)
+	assert.EqualError(t, err, "an intercept must have a name")
+
+	_, err = NewCreateInterceptRequest(ctx, &InterceptRequest{Name: "echo", Duration: -time.Hour}, es)
+	assert.EqualError(t, err, "the duration of an intercept cannot be negative")
+}
@@ -0,0 +1,53 @@
+package clientapi
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ExecutableEnv is the environment variable that tells a plugin the path of the telepresence
+// executable that runs it.
+const ExecutableEnv = "TELEPRESENCE_EXECUTABLE"
+
+// Plugin is what a plugin is given when it's run by the telepresence CLI.
+//
+// A plugin is an executable named "telepresence-<name>" that is found in the PATH. The command
+// "telepresence <name> <args>" runs it with the given args, unless telepresence has a built-in
+// command with that name.
+type Plugin struct {
+	// Name is the name of the plugin command, i.e. "foo" for a "telepresence-foo" executable
+	Name string
+
+	// Args are the arguments that follow the name of the plugin on the command line
+	Args []string
+
+	// Status is the state of the daemons when the plugin started
+	Status *Status
+}
+
+// RunPlugin is meant to be called from the main function of a plugin that is written in Go. It
+// ensures that this package uses the telepresence executable that runs the plugin, calls the given
+// function with the plugin and the current state of the daemons, and then exits. The exit code is
+// 1 if the function returns an error, which is then printed on stderr.
+func RunPlugin(f func(ctx context.Context, p *Plugin) error) {
+	name := strings.TrimSuffix(filepath.Base(os.Args[0]), filepath.Ext(os.Args[0]))
+	p := &Plugin{Name: strings.TrimPrefix(name, "telepresence-"), Args: os.Args[1:]}
+	ctx := context.Background()
+	err := func() (err error) {
+		if exe := os.Getenv(ExecutableEnv); exe != "" {
+			SetExecutable(exe)
+		}
+		if p.Status, err = GetStatus(ctx); err != nil {
+			return err
+		}
+		return f(ctx, p)
+	}()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "telepresence %s: error: %v\n", p.Name, err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
@@ -0,0 +1,154 @@
+// Package compress contains the gRPC compressor that the tunnel between the client and the
+// traffic-manager uses when compression is enabled. Each message is deflated unless it's small or
+// its content looks like it's already compressed or encrypted, in which case it's stored as is.
+package compress
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+	"math"
+	"sync"
+
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/metadata"
+)
+
+// Name is the name of the compressor, used in the grpc-encoding header.
+const Name = "tp-deflate"
+
+// AcceptHeader is the gRPC response header that the traffic-manager uses to tell a client what
+// compressors it accepts.
+const AcceptHeader = "x-telepresence-accept-compression"
+
+// The first byte of each compressed message tells how the rest of the message is encoded
+const (
+	stored   = byte(0)
+	deflated = byte(1)
+)
+
+// minSize is the size of the smallest message that is worth deflating
+const minSize = 256
+
+// sampleSize is the number of bytes from the end of a message that are used when estimating
+// whether a message is compressible
+const sampleSize = 512
+
+// maxEntropy is the entropy, in bits per byte, above which a sample is considered incompressible
+const maxEntropy = 7.2
+
+type compressor struct {
+	writers sync.Pool
+}
+
+func init() {
+	encoding.RegisterCompressor(&compressor{})
+}
+
+// Accepted returns true if the given response header says that the peer accepts this compressor.
+func Accepted(header metadata.MD) bool {
+	for _, v := range header.Get(AcceptHeader) {
+		if v == Name {
+			return true
+		}
+	}
+	return false
+}
+
+// Incompressible returns true if the content of the given data looks like it's already compressed
+// or encrypted.
+func Incompressible(data []byte) bool {
+	if len(data) > sampleSize {
+		data = data[len(data)-sampleSize:]
+	}
+	if len(data) == 0 {
+		return false
+	}
+	var counts [256]int
+	for _, b := range data {
+		counts[b]++
+	}
+	n := float64(len(data))
+	entropy := 0.0
+	for _, c := range counts {
+		if c > 0 {
+			p := float64(c) / n
+			entropy -= p * math.Log2(p)
+		}
+	}
+	return entropy > maxEntropy
+}
+
+func (c *compressor) Name() string {
+	return Name
+}
+
+func (c *compressor) Compress(w io.Writer) (io.WriteCloser, error) {
+	return &writer{c: c, w: w}, nil
+}
+
+func (c *compressor) Decompress(r io.Reader) (io.Reader, error) {
+	var hdr [1]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, err
+	}
+	switch hdr[0] {
+	case stored:
+		return r, nil
+	case deflated:
+		return flate.NewReader(r), nil
+	default:
+		return nil, fmt.Errorf("%s: invalid message encoding %d", Name, hdr[0])
+	}
+}
+
+// writer buffers a message and encodes it when it's closed.
+type writer struct {
+	c   *compressor
+	w   io.Writer
+	buf bytes.Buffer
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	return w.buf.Write(p)
+}
+
+func (w *writer) Close() error {
+	data := w.buf.Bytes()
+	if len(data) >= minSize && !Incompressible(data) {
+		if zd, ok := w.c.deflate(data); ok {
+			_, err := w.w.Write(zd)
+			return err
+		}
+	}
+	if _, err := w.w.Write([]byte{stored}); err != nil {
+		return err
+	}
+	_, err := w.w.Write(data)
+	return err
+}
+
+// deflate returns the encoded form of the given data, or false if deflating didn't make it smaller.
+func (c *compressor) deflate(data []byte) ([]byte, bool) {
+	var zb bytes.Buffer
+	zb.Grow(len(data))
+	zb.WriteByte(deflated)
+	fw, _ := c.writers.Get().(*flate.Writer)
+	if fw == nil {
+		fw, _ = flate.NewWriter(&zb, flate.BestSpeed)
+	} else {
+		fw.Reset(&zb)
+	}
+	defer c.writers.Put(fw)
+	if _, err := fw.Write(data); err != nil {
+		return nil, false
+	}
+	if err := fw.Close(); err != nil {
+		return nil, false
+	}
+	if zb.Len() > len(data) {
+		return nil, false
+	}
+	return zb.Bytes(), true
+}
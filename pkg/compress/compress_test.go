@@ -0,0 +1,60 @@
+package compress
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/metadata"
+)
+
+func roundTrip(t *testing.T, data []byte) []byte {
+	c := &compressor{}
+	var buf bytes.Buffer
+	w, err := c.Compress(&buf)
+	require.NoError(t, err)
+	_, err = w.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	encoded := append([]byte(nil), buf.Bytes()...)
+
+	r, err := c.Decompress(&buf)
+	require.NoError(t, err)
+	decoded, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, data, decoded)
+	return encoded
+}
+
+func TestCompressor(t *testing.T) {
+	t.Run("text is deflated", func(t *testing.T) {
+		data := []byte(strings.Repeat("GET /api/v1/items HTTP/1.1\r\nHost: example.com\r\n\r\n", 40))
+		encoded := roundTrip(t, data)
+		assert.Equal(t, deflated, encoded[0])
+		assert.Less(t, len(encoded), len(data)/4)
+	})
+	t.Run("random data is stored", func(t *testing.T) {
+		data := make([]byte, 16*1024)
+		rand.New(rand.NewSource(1)).Read(data)
+		encoded := roundTrip(t, data)
+		assert.Equal(t, stored, encoded[0])
+		assert.Len(t, encoded, len(data)+1)
+	})
+	t.Run("small message is stored", func(t *testing.T) {
+		encoded := roundTrip(t, []byte("aaaaaaaaaaaaaaaa"))
+		assert.Equal(t, stored, encoded[0])
+	})
+	t.Run("empty message", func(t *testing.T) {
+		roundTrip(t, []byte{})
+	})
+}
+
+func TestAccepted(t *testing.T) {
+	assert.True(t, Accepted(metadata.Pairs(AcceptHeader, Name)))
+	assert.False(t, Accepted(metadata.Pairs(AcceptHeader, "gzip")))
+	assert.False(t, Accepted(nil))
+}
@@ -19,6 +19,7 @@ const (
 	ReadClosed
 	WriteClosed
 	KeepAlive
+	WindowUpdate
 )
 
 func (c ControlCode) String() string {
@@ -41,6 +42,8 @@ func (c ControlCode) String() string {
 		return "WRITE_CLOSED"
 	case KeepAlive:
 		return "KEEP_ALIVE"
+	case WindowUpdate:
+		return "WINDOW_UPDATE"
 	default:
 		return fmt.Sprintf("** unknown control code: %d **", c)
 	}
@@ -8,18 +8,37 @@ import (
 	"sync/atomic"
 	"time"
 
-	"golang.org/x/sys/unix"
-
 	"github.com/datawire/dlib/dlog"
-	"github.com/datawire/dlib/dtime"
 	rpc "github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/ipproto"
+	"github.com/telepresenceio/telepresence/v2/pkg/tracing"
 )
 
-// The idleDuration controls how long a dialer for a specific proto+from-to address combination remains alive without
+// The connTTL controls how long a dialer for a specific proto+from-to address combination remains alive without
 // reading or writing any messages. The dialer is normally closed by one of the peers.
 const connTTL = time.Minute
+
+// The tcpConnTTL is the connTTL of TCP connections. WebSockets, gRPC streams, and server-sent events often stay
+// silent for much longer than a minute, so a TCP dialer is only closed by its peers, or when the TCP keep-alive
+// of the kernel finds that a peer is gone. The long TTL merely ensures that a dialer that lost its peers is
+// eventually released. It matches the default tcp_keepalive_time of Linux.
+const tcpConnTTL = 2 * time.Hour
 const dialTimeout = 30 * time.Second
 
+// connectTimeout is how long a dialer waits for the peer to answer a Connect, which takes at most
+// the dialTimeout of the peer.
+const connectTimeout = dialTimeout + 10*time.Second
+
+// readBufferSize is the size of the buffers that the dialers read from their connections into
+const readBufferSize = 0x8000
+
+// readBuffers holds the buffers of dialers that have stopped reading so that short-lived
+// connections don't allocate a new one each time.
+var readBuffers = sync.Pool{New: func() interface{} {
+	b := make([]byte, readBufferSize)
+	return &b
+}}
+
 const (
 	notConnected = int32(iota)
 	halfConnected
@@ -42,6 +61,26 @@ type dialer struct {
 	idleLock      sync.Mutex
 	connected     int32
 	writerClosing chan struct{}
+
+	// done is closed when the dialer is closed
+	done chan struct{}
+
+	// halfClosed counts the directions of a TCP connection that are done. The dialer is closed when
+	// both are, so that one peer can still send, e.g. the trailers of a response, after the other
+	// peer has closed its side of the connection.
+	halfClosed int32
+
+	// window limits the messages in flight to the peer, and consumed counts the messages written
+	// to the connection that the peer hasn't been given credit for. The window is nil unless both
+	// peers do flow control, see flowWindow.
+	window   sendWindow
+	consumed int
+
+	// onConnect, when set, is called with the answer of the peer to the Connect, see
+	// HandlerFromConnAwaitingPeer. answered is set once the answer is known.
+	onConnect    func(error)
+	answered     int32
+	connectTimer *time.Timer
 }
 
 // NewDialer creates a new handler that dispatches messages in both directions between the given gRPC server
@@ -54,8 +93,9 @@ func NewDialer(connID ConnID, bidiStream TunnelStream, release func()) Handler {
 		id:            connID,
 		bidiStream:    bidiStream,
 		release:       release,
-		incoming:      make(chan Message, 10),
+		incoming:      make(chan Message, flowWindow),
 		writerClosing: make(chan struct{}),
+		done:          make(chan struct{}),
 		connected:     notConnected,
 	}
 }
@@ -66,66 +106,126 @@ func HandlerFromConn(connID ConnID, bidiStream TunnelStream, release func(), con
 		id:            connID,
 		bidiStream:    bidiStream,
 		release:       release,
-		incoming:      make(chan Message, 10),
+		incoming:      make(chan Message, flowWindow),
 		writerClosing: make(chan struct{}),
+		done:          make(chan struct{}),
 		connected:     halfConnected,
 		conn:          conn,
 	}
 }
 
+// HandlerFromConnAwaitingPeer is like HandlerFromConn, but calls the given function with the
+// answer of the peer to the Connect before anything is relayed: nil when the peer connected, or a
+// *ConnectError when it rejected the connection or didn't answer in time. The handler closes
+// itself after a failure.
+func HandlerFromConnAwaitingPeer(connID ConnID, bidiStream TunnelStream, release func(), conn net.Conn, onConnect func(error)) Handler {
+	h := HandlerFromConn(connID, bidiStream, release, conn).(*dialer)
+	h.onConnect = onConnect
+	return h
+}
+
 func (h *dialer) Start(ctx context.Context) {
 	// Set up the idle timer to close and release this handler when it's been idle for a while.
-	h.idleTimer = time.NewTimer(connTTL)
+	h.idleTimer = time.NewTimer(h.ttl())
 
 	switch h.connected {
 	case notConnected:
-		if h.id.Protocol() == unix.IPPROTO_UDP {
+		if h.id.Protocol() == ipproto.UDP {
 			h.open(ctx)
 		}
 	case halfConnected:
 		// Connection is created by listener on this side. Establish other
 		// side using a control message and start the loops
 		h.connected = connected
-		h.sendTCD(ctx, Connect)
+		if h.onConnect != nil {
+			h.connectTimer = time.AfterFunc(connectTimeout, func() {
+				if h.answer(&ConnectError{Reason: RejectTimeout}) {
+					h.Close(ctx)
+					h.sendTCD(ctx, Disconnect)
+				}
+			})
+		}
+		h.send(ctx, NewControl(h.id, Connect, flowControlPayload))
 	}
 }
 
-func (h *dialer) open(ctx context.Context) ControlCode {
+// answer passes the answer of the peer to the Connect on to onConnect, and returns false if the
+// answer was already known.
+func (h *dialer) answer(err error) bool {
+	if !atomic.CompareAndSwapInt32(&h.answered, 0, 1) {
+		return false
+	}
+	if h.onConnect != nil {
+		if h.connectTimer != nil {
+			h.connectTimer.Stop()
+		}
+		h.onConnect(err)
+	}
+	return true
+}
+
+func (h *dialer) open(ctx context.Context) error {
 	if !atomic.CompareAndSwapInt32(&h.connected, notConnected, connected) {
 		// already connected
-		return ConnectOK
+		return nil
 	}
 	dlog.Debugf(ctx, "   CONN %s, dialing", h.id)
+	_, span := tracing.Start(ctx, "tunnel.dial", "net.transport", h.id.ProtocolString(), "net.peer", h.id.DestinationAddr().String())
 	dialer := net.Dialer{Timeout: dialTimeout}
 	conn, err := dialer.DialContext(ctx, h.id.ProtocolString(), h.id.DestinationAddr().String())
+	span.SetError(err)
+	span.End()
 	if err != nil {
 		dlog.Errorf(ctx, "%s: failed to establish connection: %v", h.id, err)
-		return ConnectReject
+		return err
 	}
 	h.conn = conn
 	dlog.Debugf(ctx, "   CONN %s, dial answered", h.id)
 	go h.writeLoop(ctx)
 	go h.readLoop(ctx)
-	return ConnectOK
+	return nil
 }
 
 func (h *dialer) handleControl(ctx context.Context, cm Control) {
 	dlog.Debugf(ctx, "<- GRPC %s", cm)
 	switch cm.Code() {
 	case Connect:
-		h.sendTCD(ctx, h.open(ctx))
+		if atomic.LoadInt32(&h.connected) == notConnected && supportsFlowControl(cm.Payload()) {
+			h.window = make(sendWindow, flowWindow)
+		}
+		if err := h.open(ctx); err != nil {
+			h.send(ctx, NewControl(h.id, ConnectReject, []byte{byte(RejectReasonOf(err))}))
+		} else if h.window != nil {
+			h.send(ctx, NewControl(h.id, ConnectOK, flowControlPayload))
+		} else {
+			h.sendTCD(ctx, ConnectOK)
+		}
 	case ConnectOK:
+		if !h.answer(nil) {
+			// Too late, the handler has given up on the peer
+			return
+		}
+		if supportsFlowControl(cm.Payload()) {
+			h.window = make(sendWindow, flowWindow)
+		}
 		go h.writeLoop(ctx)
 		go h.readLoop(ctx)
+	case ConnectReject:
+		// The other end was unable to dial the destination
+		reason := RejectUnknown
+		if p := cm.Payload(); len(p) > 0 {
+			reason = RejectReason(p[0])
+		}
+		h.answer(&ConnectError{Reason: reason})
+		h.Close(ctx)
 	case Disconnect:
 		h.Close(ctx)
 		h.sendTCD(ctx, DisconnectOK)
 	case ReadClosed:
-		// If there's nothing more for us to read, then it's time to ask the writer to close.
-		// We do this instead of h.Close() because it will ensure that anything the writeLoop
-		// is already dealing with will be written. Will prevent connections from being
-		// abruptly terminated after the source has sent everything but before we've had
-		// a chance to forward it.
+		// The peer has nothing more to send, so it's time to ask the writer to close the
+		// connection for writing once it has written what the peer sent before. This doesn't
+		// close the dialer, because the peer may still have data to read, such as a response
+		// and its trailers.
 		if atomic.LoadInt32(&h.connected) > 0 {
 			select {
 			case <-h.writerClosing:
@@ -138,6 +238,10 @@ func (h *dialer) handleControl(ctx context.Context, cm Control) {
 		h.Close(ctx)
 	case KeepAlive:
 		h.resetIdle()
+	case WindowUpdate:
+		if h.window != nil {
+			h.window.release(windowCredit(cm))
+		}
 	default:
 		dlog.Errorf(ctx, "%s: unhandled connection control message: %s", h.id, cm)
 	}
@@ -151,7 +255,8 @@ func (h *dialer) HandleMessage(ctx context.Context, dg Message) {
 	}
 	select {
 	case <-ctx.Done():
-		return
+	case <-h.done:
+		// Nothing is written once the dialer is closed
 	case h.incoming <- dg:
 	}
 }
@@ -159,6 +264,7 @@ func (h *dialer) HandleMessage(ctx context.Context, dg Message) {
 // Close will close the underlying TCP/UDP connection
 func (h *dialer) Close(_ context.Context) {
 	if atomic.CompareAndSwapInt32(&h.connected, connected, notConnected) {
+		close(h.done)
 		h.release()
 		if h.conn != nil {
 			_ = h.conn.Close()
@@ -166,8 +272,19 @@ func (h *dialer) Close(_ context.Context) {
 	}
 }
 
+// halfClose records that one direction of a TCP connection is done, and closes the dialer when
+// both are.
+func (h *dialer) halfClose(ctx context.Context) {
+	if atomic.AddInt32(&h.halfClosed, 1) == 2 {
+		h.Close(ctx)
+	}
+}
+
 func (h *dialer) sendTCD(ctx context.Context, code ControlCode) {
-	ctrl := NewControl(h.id, code, nil)
+	h.send(ctx, NewControl(h.id, code, nil))
+}
+
+func (h *dialer) send(ctx context.Context, ctrl Control) {
 	dlog.Debugf(ctx, "-> GRPC %s", ctrl)
 	err := h.bidiStream.Send(ctrl.TunnelMessage())
 	if err != nil {
@@ -176,85 +293,130 @@ func (h *dialer) sendTCD(ctx context.Context, code ControlCode) {
 }
 
 func (h *dialer) readLoop(ctx context.Context) {
-	defer func() {
-		// allow write to drain and perform the close of the connection
-		dtime.SleepWithContext(ctx, 100*time.Millisecond)
-		close(h.incoming)
-	}()
-	b := make([]byte, 0x8000)
+	bp := readBuffers.Get().(*[]byte)
+	defer readBuffers.Put(bp)
+	b := *bp
+
+	// The message is reused for each chunk. This is safe because Send has serialized it when it
+	// returns.
+	cm := &rpc.ConnMessage{ConnId: []byte(h.id)}
 	for ctx.Err() == nil {
 		n, err := h.conn.Read(b)
 		if err != nil {
-			if atomic.LoadInt32(&h.connected) > 0 && ctx.Err() == nil {
-				if err != io.EOF {
-					dlog.Errorf(ctx, "!! CONN %s, conn read: %v", h.id, err)
-				}
+			if err == io.EOF && h.id.Protocol() == ipproto.TCP && atomic.LoadInt32(&h.connected) > 0 {
+				// Let the peer close its connection for writing. What the peer sends is still
+				// written to this connection until the peer has nothing more to send.
+				h.sendTCD(ctx, ReadClosed)
+				h.halfClose(ctx)
+				return
 			}
+			if atomic.LoadInt32(&h.connected) > 0 && ctx.Err() == nil && err != io.EOF {
+				dlog.Errorf(ctx, "!! CONN %s, conn read: %v", h.id, err)
+			}
+			h.Close(ctx)
 			return
 		}
 		if !h.resetIdle() {
 			return
 		}
 		if n > 0 {
-			dlog.Debugf(ctx, "<- CONN %s, len %d", h.id, n)
-			if err = h.bidiStream.Send(NewMessage(h.id, b[:n]).TunnelMessage()); err != nil {
+			if h.window != nil && !h.window.acquire(ctx, h.done) {
+				return
+			}
+			cm.Payload = b[:n]
+			if err = h.bidiStream.Send(cm); err != nil {
 				if ctx.Err() == nil {
 					dlog.Errorf(ctx, "!! GRPC %s, send: %v", h.id, err)
 				}
+				h.Close(ctx)
 				return
 			}
-			dlog.Debugf(ctx, "-> GRPC %s, len %d", h.id, n)
+			dlog.Debugf(ctx, "<- CONN -> GRPC %s, len %d", h.id, n)
 		}
 	}
 }
 
 func (h *dialer) writeLoop(ctx context.Context) {
-	defer h.Close(ctx)
 	for {
 		select {
 		case <-ctx.Done():
+			h.Close(ctx)
+			return
+		case <-h.done:
 			return
 		case <-h.idleTimer.C:
+			h.Close(ctx)
 			return
 		case dg := <-h.incoming:
-			if dg == nil {
-				// h.incoming was closed by the reader and is now drained.
-				if h.id.Protocol() == unix.IPPROTO_TCP {
-					h.sendTCD(ctx, ReadClosed)
-				}
-				return
-			}
-			if !h.resetIdle() {
+			if !h.write(ctx, dg) {
+				h.Close(ctx)
 				return
 			}
-			payload := dg.Payload()
-			pn := len(payload)
-			dlog.Debugf(ctx, "<- GRPC %s, len %d", h.id, pn)
-			for n := 0; n < pn; {
-				wn, err := h.conn.Write(payload[n:])
-				if err != nil {
-					if atomic.LoadInt32(&h.connected) > 0 && ctx.Err() == nil {
-						if h.id.Protocol() == unix.IPPROTO_TCP {
-							h.sendTCD(ctx, WriteClosed)
-						}
-						dlog.Errorf(ctx, "!! CONN %s, write: %v", h.id, err)
+		case <-h.writerClosing:
+			// The messages that the peer sent before it said that it had nothing more to send
+			// are all queued, and they are written before the connection is closed for writing.
+			for drained := false; !drained; {
+				select {
+				case dg := <-h.incoming:
+					if !h.write(ctx, dg) {
+						h.Close(ctx)
+						return
 					}
-					return
+				default:
+					drained = true
 				}
-				dlog.Debugf(ctx, "-> CONN %s, len %d", h.id, wn)
-				n += wn
 			}
-		case <-h.writerClosing:
+			if cw, ok := h.conn.(interface{ CloseWrite() error }); ok {
+				_ = cw.CloseWrite()
+			}
+			h.halfClose(ctx)
 			return
 		}
 	}
 }
 
+// write writes the payload of the given message to the connection, and returns the credit of the
+// messages written to the peer when it has grown to a quarter of the window. It returns false if
+// the dialer must be closed.
+func (h *dialer) write(ctx context.Context, dg Message) bool {
+	if !h.resetIdle() {
+		return false
+	}
+	// A net.Conn doesn't return from Write until all of the payload is written or an
+	// error occurs.
+	payload := dg.Payload()
+	if _, err := h.conn.Write(payload); err != nil {
+		if atomic.LoadInt32(&h.connected) > 0 && ctx.Err() == nil {
+			if h.id.Protocol() == ipproto.TCP {
+				h.sendTCD(ctx, WriteClosed)
+			}
+			dlog.Errorf(ctx, "!! CONN %s, write: %v", h.id, err)
+		}
+		return false
+	}
+	dlog.Debugf(ctx, "<- GRPC -> CONN %s, len %d", h.id, len(payload))
+	if h.window != nil {
+		if h.consumed++; h.consumed >= flowWindow/4 {
+			h.send(ctx, newWindowUpdate(h.id, h.consumed))
+			h.consumed = 0
+		}
+	}
+	return true
+}
+
+// ttl returns how long the dialer remains alive without reading or writing any messages.
+func (h *dialer) ttl() time.Duration {
+	if h.id.Protocol() == ipproto.TCP {
+		return tcpConnTTL
+	}
+	return connTTL
+}
+
 func (h *dialer) resetIdle() bool {
 	h.idleLock.Lock()
 	stopped := h.idleTimer.Stop()
 	if stopped {
-		h.idleTimer.Reset(connTTL)
+		h.idleTimer.Reset(h.ttl())
 	}
 	h.idleLock.Unlock()
 	return stopped
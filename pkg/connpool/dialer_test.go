@@ -0,0 +1,318 @@
+package connpool
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/manager"
+	"github.com/telepresenceio/telepresence/v2/pkg/ipproto"
+)
+
+// fakeStream is a TunnelStream that passes the messages that the dialer sends to a channel
+type fakeStream struct {
+	sent chan Message
+}
+
+func (s *fakeStream) Send(cm *rpc.ConnMessage) error {
+	// The dialer reuses the message, so it must be copied
+	cp := &rpc.ConnMessage{ConnId: append([]byte(nil), cm.ConnId...), Payload: append([]byte(nil), cm.Payload...)}
+	s.sent <- FromConnMessage(cp)
+	return nil
+}
+
+func (s *fakeStream) Recv() (*rpc.ConnMessage, error) {
+	select {}
+}
+
+func (s *fakeStream) next(t *testing.T) Message {
+	t.Helper()
+	select {
+	case msg := <-s.sent:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the dialer to send a message")
+		return nil
+	}
+}
+
+func TestDialer_TTL(t *testing.T) {
+	ip := net.IP{127, 0, 0, 1}
+	tcp := &dialer{id: NewConnID(ipproto.TCP, ip, ip, 1000, 80)}
+	udp := &dialer{id: NewConnID(ipproto.UDP, ip, ip, 1000, 53)}
+	assert.Equal(t, tcpConnTTL, tcp.ttl())
+	assert.Equal(t, connTTL, udp.ttl())
+}
+
+// TestDialer_streaming verifies that the chunks of a long-lived stream, such as the events of a
+// server-sent event stream or the frames of a WebSocket, are passed on as soon as they are written
+// in either direction, without being buffered.
+func TestDialer_streaming(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+
+	client, server := net.Pipe()
+	defer client.Close()
+
+	ip := net.IP{127, 0, 0, 1}
+	id := NewConnID(ipproto.TCP, ip, ip, 1000, 8080)
+	stream := &fakeStream{sent: make(chan Message, 10)}
+	released := make(chan struct{})
+	h := HandlerFromConn(id, stream, func() { close(released) }, server)
+	h.Start(ctx)
+
+	ctrl, ok := stream.next(t).(Control)
+	require.True(t, ok)
+	require.Equal(t, Connect, ctrl.Code())
+	h.HandleMessage(ctx, NewControl(id, ConnectOK, nil))
+
+	for _, event := range []string{"data: one\n\n", "data: two\n\n", "data: three\n\n"} {
+		_, err := client.Write([]byte(event))
+		require.NoError(t, err)
+		msg := stream.next(t)
+		assert.Equal(t, event, string(msg.Payload()))
+	}
+
+	buf := make([]byte, 64)
+	for _, frame := range []string{"\x81\x05hello", "\x81\x05world"} {
+		h.HandleMessage(ctx, NewMessage(id, []byte(frame)))
+		require.NoError(t, client.SetReadDeadline(time.Now().Add(5*time.Second)))
+		n, err := client.Read(buf)
+		require.NoError(t, err)
+		assert.Equal(t, frame, string(buf[:n]))
+	}
+
+	// The stream ends when the peer disconnects
+	h.HandleMessage(ctx, NewControl(id, Disconnect, nil))
+	select {
+	case <-released:
+	case <-time.After(5 * time.Second):
+		t.Fatal("dialer wasn't released")
+	}
+}
+
+func TestDialer_awaitingPeer(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+
+	ip := net.IP{127, 0, 0, 1}
+	id := NewConnID(ipproto.TCP, ip, ip, 1000, 8080)
+	start := func() (Handler, *fakeStream, net.Conn, chan error, chan struct{}) {
+		client, server := net.Pipe()
+		stream := &fakeStream{sent: make(chan Message, 10)}
+		answers := make(chan error, 2)
+		released := make(chan struct{})
+		h := HandlerFromConnAwaitingPeer(id, stream, func() { close(released) }, server, func(err error) {
+			answers <- err
+		})
+		h.Start(ctx)
+		ctrl, ok := stream.next(t).(Control)
+		require.True(t, ok)
+		require.Equal(t, Connect, ctrl.Code())
+		return h, stream, client, answers, released
+	}
+
+	// The reason of a rejection is passed on, and the handler closes
+	h, _, client, answers, released := start()
+	h.HandleMessage(ctx, NewControl(id, ConnectReject, []byte{byte(RejectRefused)}))
+	assert.Equal(t, &ConnectError{Reason: RejectRefused}, <-answers)
+	<-released
+	client.Close()
+
+	// Nothing is relayed until the peer has connected
+	h, stream, client, answers, _ := start()
+	defer client.Close()
+	assert.Empty(t, answers)
+	h.HandleMessage(ctx, NewControl(id, ConnectOK, nil))
+	assert.NoError(t, <-answers)
+	_, err := client.Write([]byte("hello"))
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(stream.next(t).Payload()))
+
+	// A second answer is ignored
+	h.HandleMessage(ctx, NewControl(id, ConnectOK, nil))
+	assert.Empty(t, answers)
+}
+
+func TestDialer_rejectReason(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	port := uint16(l.Addr().(*net.TCPAddr).Port)
+	l.Close()
+
+	ip := net.IP{127, 0, 0, 1}
+	id := NewConnID(ipproto.TCP, ip, ip, 1000, port)
+	stream := &fakeStream{sent: make(chan Message, 10)}
+	h := NewDialer(id, stream, func() {})
+	h.Start(ctx)
+	h.HandleMessage(ctx, NewControl(id, Connect, nil))
+	ctrl, ok := stream.next(t).(Control)
+	require.True(t, ok)
+	assert.Equal(t, ConnectReject, ctrl.Code())
+	assert.Equal(t, []byte{byte(RejectRefused)}, ctrl.Payload())
+}
+
+// link passes the messages that one dialer sends to the other, in the order they were sent
+func link(ctx context.Context, from *fakeStream, to Handler) {
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg := <-from.sent:
+				to.HandleMessage(ctx, msg)
+			}
+		}
+	}()
+}
+
+// tcpPair returns the two ends of a TCP connection
+func tcpPair(t *testing.T) (net.Conn, net.Conn) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := l.Accept()
+		accepted <- conn
+	}()
+	conn, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	return conn, <-accepted
+}
+
+// TestDialer_halfClose verifies that a peer that has closed its connection for writing still
+// receives everything that the other peer sends, such as the trailers of a gRPC response.
+func TestDialer_halfClose(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+
+	// The server reads the whole request before it responds
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		request, _ := ioutil.ReadAll(conn)
+		_, _ = conn.Write([]byte("response to " + string(request)))
+		_, _ = conn.Write([]byte(", trailers"))
+	}()
+
+	client, clientSide := tcpPair(t)
+	defer client.Close()
+
+	ip := net.IP{127, 0, 0, 1}
+	id := NewConnID(ipproto.TCP, ip, ip, 1000, uint16(l.Addr().(*net.TCPAddr).Port))
+	clientStream := &fakeStream{sent: make(chan Message, 10)}
+	serverStream := &fakeStream{sent: make(chan Message, 10)}
+	clientReleased := make(chan struct{})
+	serverReleased := make(chan struct{})
+	ch := HandlerFromConn(id, clientStream, func() { close(clientReleased) }, clientSide)
+	sh := NewDialer(id, serverStream, func() { close(serverReleased) })
+	link(ctx, clientStream, sh)
+	link(ctx, serverStream, ch)
+	sh.Start(ctx)
+	ch.Start(ctx)
+
+	_, err = client.Write([]byte("request"))
+	require.NoError(t, err)
+	require.NoError(t, client.(*net.TCPConn).CloseWrite())
+	require.NoError(t, client.SetReadDeadline(time.Now().Add(5*time.Second)))
+	response, err := ioutil.ReadAll(client)
+	require.NoError(t, err)
+	assert.Equal(t, "response to request, trailers", string(response))
+
+	for _, released := range []chan struct{}{clientReleased, serverReleased} {
+		select {
+		case <-released:
+		case <-time.After(5 * time.Second):
+			t.Fatal("dialer wasn't released")
+		}
+	}
+}
+
+// TestDialer_flowControl verifies that a dialer has at most flowWindow messages in flight to a
+// peer that does flow control, and that it returns credit to the peer as it writes its messages.
+func TestDialer_flowControl(t *testing.T) {
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	defer cancel()
+
+	client, server := net.Pipe()
+	defer client.Close()
+
+	ip := net.IP{127, 0, 0, 1}
+	id := NewConnID(ipproto.TCP, ip, ip, 1000, 8080)
+	stream := &fakeStream{sent: make(chan Message, 2*flowWindow)}
+	h := HandlerFromConn(id, stream, func() {}, server)
+	defer h.Close(ctx)
+	h.Start(ctx)
+
+	ctrl, ok := stream.next(t).(Control)
+	require.True(t, ok)
+	require.Equal(t, Connect, ctrl.Code())
+	assert.True(t, supportsFlowControl(ctrl.Payload()))
+	h.HandleMessage(ctx, NewControl(id, ConnectOK, flowControlPayload))
+
+	// Messages are sent until the window is full
+	go func() {
+		for i := 0; i < flowWindow+8; i++ {
+			if _, err := client.Write([]byte("chunk")); err != nil {
+				return
+			}
+		}
+	}()
+	for i := 0; i < flowWindow; i++ {
+		assert.Equal(t, "chunk", string(stream.next(t).Payload()))
+	}
+	select {
+	case msg := <-stream.sent:
+		t.Fatalf("message %s was sent without credit", msg)
+	case <-time.After(100 * time.Millisecond):
+	}
+	h.HandleMessage(ctx, newWindowUpdate(id, 8))
+	for i := 0; i < 8; i++ {
+		assert.Equal(t, "chunk", string(stream.next(t).Payload()))
+	}
+
+	// A full window of messages is queued without blocking, although nothing reads them yet
+	queued := make(chan struct{})
+	go func() {
+		for i := 0; i < flowWindow; i++ {
+			h.HandleMessage(ctx, NewMessage(id, []byte("x")))
+		}
+		close(queued)
+	}()
+	select {
+	case <-queued:
+	case <-time.After(5 * time.Second):
+		t.Fatal("HandleMessage blocked")
+	}
+
+	// The credit of the messages is returned as they are written
+	buf := make([]byte, flowWindow)
+	for n := 0; n < flowWindow; {
+		require.NoError(t, client.SetReadDeadline(time.Now().Add(5*time.Second)))
+		r, err := client.Read(buf)
+		require.NoError(t, err)
+		n += r
+	}
+	credit := 0
+	for credit < flowWindow {
+		ctrl, ok := stream.next(t).(Control)
+		require.True(t, ok)
+		require.Equal(t, WindowUpdate, ctrl.Code())
+		credit += windowCredit(ctrl)
+	}
+	assert.Equal(t, flowWindow, credit)
+}
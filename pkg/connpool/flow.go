@@ -0,0 +1,70 @@
+package connpool
+
+import (
+	"context"
+	"encoding/binary"
+)
+
+// The connections of a tunnel share one gRPC stream, so a connection whose reader falls behind
+// must not hold back the messages of the other connections. With flow control, each side of a
+// connection has at most flowWindow messages in flight to its peer, and the peer returns credit
+// in a WindowUpdate as it writes the messages to its connection, so a message never has to wait
+// for room in the queue of its dialer. A dialer announces flow control in the payload of its
+// Connect or ConnectOK. Peers that don't announce it are neither sent nor expected to send
+// WindowUpdates, and their messages are queued as they were before.
+
+// flowWindow is the number of messages of a connection that may be in flight to its peer, which
+// at readBufferSize bytes per message is two megabytes.
+const flowWindow = 64
+
+// flowControlVersion is the version of the flow control that a dialer announces
+const flowControlVersion = 1
+
+// flowControlPayload is the payload of the Connect or ConnectOK of a dialer that does flow control
+var flowControlPayload = []byte{flowControlVersion}
+
+// supportsFlowControl returns true if the payload of a Connect or ConnectOK announces flow control
+func supportsFlowControl(payload []byte) bool {
+	return len(payload) > 0 && payload[0] >= flowControlVersion
+}
+
+// newWindowUpdate returns a WindowUpdate that returns the credit of the given number of messages
+func newWindowUpdate(id ConnID, n int) Control {
+	payload := make([]byte, 4)
+	binary.BigEndian.PutUint32(payload, uint32(n))
+	return NewControl(id, WindowUpdate, payload)
+}
+
+// windowCredit returns the number of messages that the given WindowUpdate returns the credit of
+func windowCredit(ctrl Control) int {
+	if p := ctrl.Payload(); len(p) >= 4 {
+		return int(binary.BigEndian.Uint32(p))
+	}
+	return 0
+}
+
+// sendWindow holds one element for each message that is in flight to the peer
+type sendWindow chan struct{}
+
+// acquire waits until there's room for one more message in flight. It returns false if the
+// context or the given channel is done first.
+func (w sendWindow) acquire(ctx context.Context, done <-chan struct{}) bool {
+	select {
+	case w <- struct{}{}:
+		return true
+	case <-ctx.Done():
+	case <-done:
+	}
+	return false
+}
+
+// release returns the credit of n messages. Credit that wasn't acquired is ignored.
+func (w sendWindow) release(n int) {
+	for ; n > 0; n-- {
+		select {
+		case <-w:
+		default:
+			return
+		}
+	}
+}
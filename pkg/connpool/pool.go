@@ -73,6 +73,17 @@ func (p *Pool) Get(ctx context.Context, id ConnID, createHandler HandlerCreator)
 	return handler, false, nil
 }
 
+// ConnIDs returns the ids of all handlers in the pool
+func (p *Pool) ConnIDs() []ConnID {
+	p.lock.Lock()
+	ids := make([]ConnID, 0, len(p.handlers))
+	for id := range p.handlers {
+		ids = append(ids, id)
+	}
+	p.lock.Unlock()
+	return ids
+}
+
 func (p *Pool) CloseAll(ctx context.Context) {
 	p.lock.Lock()
 	handlers := make([]Handler, len(p.handlers))
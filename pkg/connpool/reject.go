@@ -0,0 +1,60 @@
+package connpool
+
+import (
+	"errors"
+	"net"
+	"syscall"
+)
+
+// A RejectReason tells why a peer rejected a Connect. It's the payload of the ConnectReject. Peers
+// that don't send a reason reject with RejectUnknown.
+type RejectReason byte
+
+const (
+	RejectUnknown = RejectReason(iota)
+	RejectRefused
+	RejectNetUnreachable
+	RejectHostUnreachable
+	RejectTimeout
+)
+
+func (r RejectReason) String() string {
+	switch r {
+	case RejectRefused:
+		return "connection refused"
+	case RejectNetUnreachable:
+		return "network is unreachable"
+	case RejectHostUnreachable:
+		return "host is unreachable"
+	case RejectTimeout:
+		return "connection timed out"
+	default:
+		return "connection rejected"
+	}
+}
+
+// RejectReasonOf returns the reason that a dial failed with the given error.
+func RejectReasonOf(err error) RejectReason {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return RejectRefused
+	case errors.Is(err, syscall.ENETUNREACH):
+		return RejectNetUnreachable
+	case errors.Is(err, syscall.EHOSTUNREACH):
+		return RejectHostUnreachable
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return RejectTimeout
+	default:
+		return RejectUnknown
+	}
+}
+
+// ConnectError is the error of a Connect that the peer rejected, or didn't answer in time.
+type ConnectError struct {
+	Reason RejectReason
+}
+
+func (e *ConnectError) Error() string {
+	return e.Reason.String()
+}
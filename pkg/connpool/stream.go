@@ -2,20 +2,32 @@ package connpool
 
 import (
 	"context"
+	"sync"
 	"sync/atomic"
 
 	"github.com/datawire/dlib/dlog"
+	rpc "github.com/telepresenceio/telepresence/rpc/v2/manager"
 	"github.com/telepresenceio/telepresence/v2/pkg/client"
 )
 
 type Stream struct {
 	TunnelStream
+
+	// sendLock serializes the sends of the handlers that share the stream
+	sendLock sync.Mutex
 }
 
 func NewStream(bidiStream TunnelStream) *Stream {
 	return &Stream{TunnelStream: bidiStream}
 }
 
+// Send sends the given message on the stream. It is safe to call Send from several goroutines.
+func (s *Stream) Send(msg *rpc.ConnMessage) error {
+	s.sendLock.Lock()
+	defer s.sendLock.Unlock()
+	return s.TunnelStream.Send(msg)
+}
+
 // ReadLoop reads from the stream and dispatches control messages and messages to the give channels
 func (s *Stream) ReadLoop(ctx context.Context, closing *int32) (<-chan Message, <-chan error) {
 	msgCh := make(chan Message)
@@ -76,7 +88,7 @@ func (s *Stream) handleControl(ctx context.Context, ctrl Control, pool *Pool) {
 			// Only Connect requested from peer may create a new instance at this point
 			return nil, nil
 		}
-		return NewDialer(id, s.TunnelStream, release), nil
+		return NewDialer(id, s, release), nil
 	})
 	if err != nil {
 		dlog.Error(ctx, err)
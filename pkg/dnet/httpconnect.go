@@ -0,0 +1,125 @@
+package dnet
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"time"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/tlspolicy"
+)
+
+// DialHTTPConnect returns a connection to addr that is tunneled through the HTTP proxy at proxyURL
+// using the CONNECT method. The proxy is dialed using TLS, restricted by the given policy, when the
+// scheme of proxyURL is "https", and basic authentication is used when proxyURL contains user info.
+func DialHTTPConnect(ctx context.Context, proxyURL *url.URL, policy *tlspolicy.Policy, addr string) (net.Conn, error) {
+	proxyAddr := proxyURL.Host
+	if proxyURL.Port() == "" {
+		if proxyURL.Scheme == "https" {
+			proxyAddr = net.JoinHostPort(proxyURL.Hostname(), "443")
+		} else {
+			proxyAddr = net.JoinHostPort(proxyURL.Hostname(), "80")
+		}
+	}
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", proxyAddr)
+	if err != nil {
+		return nil, err
+	}
+	if proxyURL.Scheme == "https" {
+		tlsConfig := &tls.Config{ServerName: proxyURL.Hostname()}
+		policy.Apply(tlsConfig)
+		conn = tls.Client(conn, tlsConfig)
+	}
+
+	// Abort the handshake when the context is cancelled
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = conn.SetDeadline(time.Now())
+		case <-done:
+		}
+	}()
+	if dl, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(dl)
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if u := proxyURL.User; u != nil {
+		pw, _ := u.Password()
+		req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(u.Username()+":"+pw)))
+	}
+	if err = req.Write(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("CONNECT %s through proxy %s: %w", addr, proxyURL.Host, err)
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("CONNECT %s through proxy %s: %w", addr, proxyURL.Host, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("CONNECT %s through proxy %s: %s", addr, proxyURL.Host, resp.Status)
+	}
+	_ = conn.SetDeadline(time.Time{})
+	if br.Buffered() > 0 {
+		// The proxy already sent data from the tunnel
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+	return conn, nil
+}
+
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// NewProxyFallbackDialer returns a dialer function (matching the signature required by
+// grpc.WithContextDialer) that dials addresses directly. When a direct dial fails, and the HTTPS
+// proxy that proxyFunc returns for the address is non-nil, the address is instead reached through
+// a CONNECT tunnel of that proxy, see DialHTTPConnect. Once a tunnel has succeeded, subsequent dials
+// use the proxy first.
+func NewProxyFallbackDialer(proxyFunc func(*http.Request) (*url.URL, error), policy *tlspolicy.Policy) func(context.Context, string) (net.Conn, error) {
+	var viaProxy int32
+	return func(ctx context.Context, addr string) (net.Conn, error) {
+		proxyURL, err := proxyFunc(&http.Request{URL: &url.URL{Scheme: "https", Host: addr}})
+		if err != nil || proxyURL == nil {
+			return (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+		}
+		if atomic.LoadInt32(&viaProxy) != 0 {
+			if conn, err := DialHTTPConnect(ctx, proxyURL, policy, addr); err == nil {
+				return conn, nil
+			}
+		}
+		conn, err := (&net.Dialer{Timeout: 5 * time.Second}).DialContext(ctx, "tcp", addr)
+		if err == nil {
+			return conn, nil
+		}
+		dlog.Infof(ctx, "Dial %s failed: %v, trying through proxy %s", addr, err, proxyURL.Host)
+		if conn, err = DialHTTPConnect(ctx, proxyURL, policy, addr); err != nil {
+			return nil, err
+		}
+		atomic.StoreInt32(&viaProxy, 1)
+		return conn, nil
+	}
+}
@@ -0,0 +1,153 @@
+package dnet
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/tlspolicy"
+)
+
+// connectProxy is a minimal HTTP proxy that only supports CONNECT. It's served using TLS when
+// tlsConfig is non-nil.
+func connectProxy(t *testing.T, auth string, tlsConfig *tls.Config) *url.URL {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = l.Close() })
+	scheme := "http"
+	if tlsConfig != nil {
+		l = tls.NewListener(l, tlsConfig)
+		scheme = "https"
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				req, err := http.ReadRequest(bufio.NewReader(conn))
+				if err != nil {
+					return
+				}
+				if auth != "" && req.Header.Get("Proxy-Authorization") != auth {
+					_, _ = io.WriteString(conn, "HTTP/1.1 407 Proxy Authentication Required\r\n\r\n")
+					return
+				}
+				target, err := net.Dial("tcp", req.Host)
+				if err != nil {
+					_, _ = io.WriteString(conn, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+					return
+				}
+				defer target.Close()
+				_, _ = io.WriteString(conn, "HTTP/1.1 200 OK\r\n\r\n")
+				go func() { _, _ = io.Copy(target, conn) }()
+				_, _ = io.Copy(conn, target)
+			}()
+		}
+	}()
+	return &url.URL{Scheme: scheme, Host: l.Addr().String()}
+}
+
+// selfSignedCert returns a certificate for 127.0.0.1 that no one trusts
+func selfSignedCert(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func echoServer(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+func TestDialHTTPConnect(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	echoAddr := echoServer(t)
+
+	policy, err := tlspolicy.Parse("", nil, false)
+	require.NoError(t, err)
+
+	proxyURL := connectProxy(t, "", nil)
+	conn, err := DialHTTPConnect(ctx, proxyURL, policy, echoAddr)
+	require.NoError(t, err)
+	_, err = conn.Write([]byte("hello"))
+	require.NoError(t, err)
+	buf := make([]byte, 5)
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(buf))
+	conn.Close()
+
+	// "dXNlcjpwdw==" is the base64 encoding of "user:pw"
+	authURL := connectProxy(t, "Basic dXNlcjpwdw==", nil)
+	_, err = DialHTTPConnect(ctx, authURL, policy, echoAddr)
+	assert.Error(t, err)
+	authURL.User = url.UserPassword("user", "pw")
+	conn, err = DialHTTPConnect(ctx, authURL, policy, echoAddr)
+	require.NoError(t, err)
+	conn.Close()
+}
+
+func TestDialHTTPConnectTLSPolicy(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	echoAddr := echoServer(t)
+	proxyURL := connectProxy(t, "", &tls.Config{
+		Certificates: []tls.Certificate{selfSignedCert(t)},
+		MaxVersion:   tls.VersionTLS12,
+	})
+
+	// The handshake with a proxy that meets the policy gets as far as verifying its certificate
+	policy, err := tlspolicy.Parse("1.2", nil, false)
+	require.NoError(t, err)
+	_, err = DialHTTPConnect(ctx, proxyURL, policy, echoAddr)
+	var uae x509.UnknownAuthorityError
+	assert.True(t, errors.As(err, &uae), "unexpected error %v", err)
+
+	// The handshake with a proxy that doesn't meet the policy fails before that
+	policy, err = tlspolicy.Parse("1.3", nil, false)
+	require.NoError(t, err)
+	_, err = DialHTTPConnect(ctx, proxyURL, policy, echoAddr)
+	require.Error(t, err)
+	assert.False(t, errors.As(err, &uae), "unexpected error %v", err)
+}
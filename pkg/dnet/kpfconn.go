@@ -32,23 +32,37 @@ type k8sPortForwardDialer struct {
 	kubeKatesClient *kates.Client
 	spdyTransport   http.RoundTripper
 	spdyUpgrader    spdy.Upgrader
+	webSocket       *wsPortForwarder
 
 	// state
 	nextRequestID int64
 	spdyStreamsMu sync.Mutex
 	spdyStreams   map[string]httpstream.Connection // key is "podname.namespace"
+
+	// viaWebSocket is non-zero once a port-forward has fallen back to a WebSocket
+	viaWebSocket int32
 }
 
 // NewK8sPortForwardDialer returns a dialer function (matching the signature required by
 // grpc.WithContextDialer) that dials to a port on a Kubernetes Pod, in the manor of `kubectl
 // port-forward`.  It returns the direct connection to the apiserver; it does not establish a local
 // port being forwarded from or otherwise pump data over the connection.
+//
+// When a proxy between the client and the apiserver refuses the SPDY upgrade that the port-forward
+// needs, the port-forward falls back to a WebSocket, which is then used for all subsequent dials.
 func NewK8sPortForwardDialer(kubeFlags *kates.ConfigFlags, kubeKatesClient *kates.Client) (func(context.Context, string) (net.Conn, error), error) {
 	kubeConfig, err := kubeFlags.ToRESTConfig()
 	if err != nil {
 		return nil, err
 	}
+	dialer, err := newK8sPortForwardDialer(kubeConfig, kubeFlags, kubeKatesClient)
+	if err != nil {
+		return nil, err
+	}
+	return dialer.Dial, nil
+}
 
+func newK8sPortForwardDialer(kubeConfig *rest.Config, kubeFlags *kates.ConfigFlags, kubeKatesClient *kates.Client) (*k8sPortForwardDialer, error) {
 	if err := setKubernetesDefaults(kubeConfig); err != nil {
 		return nil, err
 	}
@@ -62,16 +76,20 @@ func NewK8sPortForwardDialer(kubeFlags *kates.ConfigFlags, kubeKatesClient *kate
 	if err != nil {
 		return nil, err
 	}
-	dialer := &k8sPortForwardDialer{
+	webSocket, err := newWSPortForwarder(kubeConfig)
+	if err != nil {
+		return nil, err
+	}
+	return &k8sPortForwardDialer{
 		kubeFlags:       kubeFlags,
 		kubeRESTClient:  kubeRESTClient,
 		kubeKatesClient: kubeKatesClient,
 		spdyTransport:   spdyTransport,
 		spdyUpgrader:    spdyUpgrader,
+		webSocket:       webSocket,
 
 		spdyStreams: make(map[string]httpstream.Connection),
-	}
-	return dialer.Dial, nil
+	}, nil
 }
 
 // Dial dials a port of something in the cluster.  The address format is
@@ -217,16 +235,41 @@ func (pf *k8sPortForwardDialer) spdyStream(ctx context.Context, pod *kates.Pod)
 	return spdyStream, nil
 }
 
-func (pf *k8sPortForwardDialer) dial(ctx context.Context, pod *kates.Pod, port uint16) (conn *kpfConn, err error) {
+// webSocketDial dials the given port of the given pod using a WebSocket.
+func (pf *k8sPortForwardDialer) webSocketDial(ctx context.Context, pod *kates.Pod, port uint16) (net.Conn, error) {
+	dlog.Debugf(ctx, "k8sPortForwardDialer.webSocketDial(ctx, Pod./%s.%s, %d)", pod.Name, pod.Namespace, port)
+	reqURL := pf.kubeRESTClient.
+		Get().
+		Resource("pods").
+		Namespace(pod.Namespace).
+		Name(pod.Name).
+		SubResource("portforward").
+		Param("ports", strconv.Itoa(int(port))).
+		URL()
+	return pf.webSocket.dial(ctx, reqURL, net.JoinHostPort(pod.Name+"."+pod.Namespace, strconv.Itoa(int(port))))
+}
+
+func (pf *k8sPortForwardDialer) dial(ctx context.Context, pod *kates.Pod, port uint16) (conn net.Conn, err error) {
 	dlog.Debugf(ctx, "k8sPortForwardDialer.dial(ctx, %s.%s, %d)",
 		pod.Name,
 		pod.Namespace,
 		port)
 
+	if atomic.LoadInt32(&pf.viaWebSocket) != 0 {
+		return pf.webSocketDial(ctx, pod, port)
+	}
+
 	// All port-forwards to the same Pod get multiplexed over the same SPDY stream.
 	spdyStream, err := pf.spdyStream(ctx, pod)
 	if err != nil {
-		return nil, err
+		wsConn, wsErr := pf.webSocketDial(ctx, pod, port)
+		if wsErr != nil {
+			dlog.Debugf(ctx, "port-forward using a WebSocket failed: %v", wsErr)
+			return nil, err
+		}
+		dlog.Infof(ctx, "Port-forward using SPDY failed: %v, using WebSockets instead", err)
+		atomic.StoreInt32(&pf.viaWebSocket, 1)
+		return wsConn, nil
 	}
 	defer func() {
 		if err != nil {
@@ -265,7 +308,7 @@ func (pf *k8sPortForwardDialer) dial(ctx context.Context, pod *kates.Pod, port u
 		return nil, fmt.Errorf("create port-forward data stream: %w", err)
 	}
 
-	conn = &kpfConn{
+	kc := &kpfConn{
 		remoteAddr: net.JoinHostPort(pod.Name+"."+pod.Namespace, strconv.FormatInt(int64(port), 10)),
 
 		errorStream: errorStream,
@@ -276,8 +319,8 @@ func (pf *k8sPortForwardDialer) dial(ctx context.Context, pod *kates.Pod, port u
 		readDeadline:  makePipeDeadline(),
 		writeDeadline: makePipeDeadline(),
 	}
-	go conn.oobWorker()
-	return conn, nil
+	go kc.oobWorker()
+	return kc, nil
 }
 
 type kpfConn struct {
@@ -0,0 +1,138 @@
+package dnet
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"time"
+
+	"github.com/lucas-clemente/quic-go"
+
+	"github.com/datawire/dlib/dlog"
+)
+
+// QUICTunnelProtocol is the ALPN protocol of the QUIC tunnels to the traffic-manager.
+const QUICTunnelProtocol = "tunnel.telepresence.io"
+
+// quicIdleTimeout is the time after which a QUIC tunnel without traffic is closed. The tunnels
+// send keep-alives, so a tunnel is only this long without traffic when the peer is gone, e.g.
+// because the network of the laptop changed.
+const quicIdleTimeout = 30 * time.Second
+
+func quicConfig() *quic.Config {
+	return &quic.Config{
+		KeepAlive:      true,
+		MaxIdleTimeout: quicIdleTimeout,
+	}
+}
+
+// quicConn is a net.Conn that reads and writes a stream of a QUIC session. Closing it closes the
+// session.
+type quicConn struct {
+	quic.Stream
+	session quic.Session
+}
+
+func (c *quicConn) LocalAddr() net.Addr {
+	return c.session.LocalAddr()
+}
+
+func (c *quicConn) RemoteAddr() net.Addr {
+	return c.session.RemoteAddr()
+}
+
+func (c *quicConn) Close() error {
+	c.Stream.CancelRead(0)
+	_ = c.Stream.Close()
+	return c.session.CloseWithError(0, "")
+}
+
+// NewQUICTunnelDialer returns a dialer function (matching the signature required by
+// grpc.WithContextDialer) that reaches the traffic-manager through a QUIC tunnel that the
+// traffic-manager serves on the UDP port of an address, see ServeQUICTunnel. A QUIC tunnel has no
+// TCP connection of its own under the connection of the caller, so lost packets don't stall it the
+// way they stall a TCP tunnel on a lossy network. QUIC is always encrypted, so a tlsConfig is
+// required.
+func NewQUICTunnelDialer(tlsConfig *tls.Config) func(context.Context, string) (net.Conn, error) {
+	return func(ctx context.Context, addr string) (net.Conn, error) {
+		tlsConfig := tlsConfig.Clone()
+		tlsConfig.NextProtos = []string{QUICTunnelProtocol}
+		if tlsConfig.ServerName == "" {
+			host, _, err := net.SplitHostPort(addr)
+			if err != nil {
+				return nil, err
+			}
+			tlsConfig.ServerName = host
+		}
+		// The errors are returned as is, so that gRPC can tell that a handshake that timed out
+		// isn't temporary, and give up on the tunnel.
+		session, err := quic.DialAddrContext(ctx, addr, tlsConfig, quicConfig())
+		if err != nil {
+			return nil, err
+		}
+		stream, err := session.OpenStreamSync(ctx)
+		if err != nil {
+			_ = session.CloseWithError(0, "")
+			return nil, err
+		}
+		return &quicConn{Stream: stream, session: session}, nil
+	}
+}
+
+// ServeQUICTunnel serves the QUIC tunnels that the dialers of NewQUICTunnelDialer open on the UDP
+// port of the given address, until the context is done. Each stream of a tunnel is connected to
+// the given target address.
+func ServeQUICTunnel(ctx context.Context, addr string, tlsConfig *tls.Config, target string) error {
+	tlsConfig = tlsConfig.Clone()
+	tlsConfig.NextProtos = []string{QUICTunnelProtocol}
+	l, err := quic.ListenAddr(addr, tlsConfig, quicConfig())
+	if err != nil {
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		_ = l.Close()
+	}()
+	for {
+		session, err := l.Accept(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		go serveQUICSession(ctx, session, target)
+	}
+}
+
+func serveQUICSession(ctx context.Context, session quic.Session, target string) {
+	defer func() {
+		_ = session.CloseWithError(0, "")
+	}()
+	for {
+		stream, err := session.AcceptStream(ctx)
+		if err != nil {
+			return
+		}
+		go func() {
+			conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", target)
+			if err != nil {
+				dlog.Errorf(ctx, "QUIC tunnel: %v", err)
+				stream.CancelRead(0)
+				stream.CancelWrite(0)
+				return
+			}
+			defer conn.Close()
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				_, _ = io.Copy(stream, conn)
+				_ = stream.Close()
+			}()
+			_, _ = io.Copy(conn, stream)
+			_ = conn.Close()
+			<-done
+		}()
+	}
+}
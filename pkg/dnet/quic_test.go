@@ -0,0 +1,57 @@
+package dnet
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+)
+
+func TestQUICTunnel(t *testing.T) {
+	ctx, cancel := context.WithTimeout(dlog.NewTestContext(t, false), 10*time.Second)
+	defer cancel()
+	echoAddr := echoServer(t)
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := pc.LocalAddr().String()
+	pc.Close()
+
+	cert := selfSignedCert(t)
+	served := make(chan error, 1)
+	go func() {
+		served <- ServeQUICTunnel(ctx, addr, &tls.Config{Certificates: []tls.Certificate{cert}}, echoAddr)
+	}()
+
+	x509Cert, err := x509.ParseCertificate(cert.Certificate[0])
+	require.NoError(t, err)
+	certPool := x509.NewCertPool()
+	certPool.AddCert(x509Cert)
+	dial := NewQUICTunnelDialer(&tls.Config{RootCAs: certPool})
+	conn, err := dial(ctx, addr)
+	require.NoError(t, err)
+	assertEcho(t, conn, "hello")
+	assertEcho(t, conn, "again")
+	assert.Equal(t, addr, conn.RemoteAddr().String())
+	conn.Close()
+
+	// Each tunnel has a connection of its own
+	conn, err = dial(ctx, addr)
+	require.NoError(t, err)
+	assertEcho(t, conn, "another")
+	conn.Close()
+
+	// A traffic-manager that can't be verified is refused
+	_, err = NewQUICTunnelDialer(&tls.Config{})(ctx, addr)
+	assert.Error(t, err)
+
+	cancel()
+	assert.NoError(t, <-served)
+}
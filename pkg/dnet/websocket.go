@@ -0,0 +1,175 @@
+package dnet
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/net/websocket"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/tlspolicy"
+)
+
+const (
+	// WebSocketTunnelPath is the path where the traffic-manager serves WebSocket tunnels to its
+	// gRPC ports.
+	WebSocketTunnelPath = "/tunnel"
+
+	// WebSocketTunnelProtocol is the WebSocket subprotocol of the tunnels to the traffic-manager.
+	WebSocketTunnelProtocol = "tunnel.telepresence.io"
+)
+
+// DialWebSocket opens a WebSocket using the given subprotocol to the given ws or wss URL. The wss
+// handshake uses the given TLS config. When proxyFunc returns a proxy for the URL, the WebSocket is
+// opened through a CONNECT tunnel of that proxy, see DialHTTPConnect.
+func DialWebSocket(
+	ctx context.Context,
+	u *url.URL,
+	protocol string,
+	header http.Header,
+	tlsConfig *tls.Config,
+	proxyFunc func(*http.Request) (*url.URL, error),
+	policy *tlspolicy.Policy,
+) (*websocket.Conn, error) {
+	addr := u.Host
+	if u.Port() == "" {
+		if u.Scheme == "wss" {
+			addr = net.JoinHostPort(u.Hostname(), "443")
+		} else {
+			addr = net.JoinHostPort(u.Hostname(), "80")
+		}
+	}
+	httpScheme := "http"
+	if u.Scheme == "wss" {
+		httpScheme = "https"
+	}
+
+	var proxyURL *url.URL
+	if proxyFunc != nil {
+		var err error
+		if proxyURL, err = proxyFunc(&http.Request{URL: &url.URL{Scheme: httpScheme, Host: addr}}); err != nil {
+			return nil, err
+		}
+	}
+	var conn net.Conn
+	var err error
+	if proxyURL != nil {
+		conn, err = DialHTTPConnect(ctx, proxyURL, policy, addr)
+	} else {
+		conn, err = (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "wss" {
+		if tlsConfig == nil {
+			tlsConfig = &tls.Config{}
+		} else {
+			tlsConfig = tlsConfig.Clone()
+		}
+		if tlsConfig.ServerName == "" {
+			tlsConfig.ServerName = u.Hostname()
+		}
+		conn = tls.Client(conn, tlsConfig)
+	}
+
+	// Abort the handshake when the context is cancelled
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = conn.SetDeadline(time.Now())
+		case <-done:
+		}
+	}()
+	if dl, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(dl)
+	}
+
+	config := &websocket.Config{
+		Location: u,
+		Origin:   &url.URL{Scheme: httpScheme, Host: u.Host},
+		Protocol: []string{protocol},
+		Version:  websocket.ProtocolVersionHybi13,
+		Header:   header,
+	}
+	if config.Header == nil {
+		config.Header = make(http.Header)
+	}
+	ws, err := websocket.NewClient(config, conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("WebSocket %s: %w", u.Redacted(), err)
+	}
+	_ = conn.SetDeadline(time.Time{})
+	ws.PayloadType = websocket.BinaryFrame
+	return ws, nil
+}
+
+// NewWebSocketTunnelDialer returns a dialer function (matching the signature required by
+// grpc.WithContextDialer) that reaches the traffic-manager at an address through a WebSocket tunnel
+// that the traffic-manager serves on that address. The tunnel uses wss when tlsConfig is non-nil.
+// When toTLSPort is true, the tunnel leads to the port where the traffic-manager serves mutual TLS,
+// so that the TLS handshake is made end to end. Proxies are used as by DialWebSocket.
+func NewWebSocketTunnelDialer(
+	tlsConfig *tls.Config,
+	toTLSPort bool,
+	proxyFunc func(*http.Request) (*url.URL, error),
+	policy *tlspolicy.Policy,
+) func(context.Context, string) (net.Conn, error) {
+	return func(ctx context.Context, addr string) (net.Conn, error) {
+		u := &url.URL{Scheme: "ws", Host: addr, Path: WebSocketTunnelPath}
+		if tlsConfig != nil {
+			u.Scheme = "wss"
+		}
+		if toTLSPort {
+			u.RawQuery = "mtls=true"
+		}
+		return DialWebSocket(ctx, u, WebSocketTunnelProtocol, nil, tlsConfig, proxyFunc, policy)
+	}
+}
+
+// WebSocketTunnelHandler returns a handler that serves the WebSocket tunnels that the dialers of
+// NewWebSocketTunnelDialer open. Each tunnel is connected to the address that target returns for
+// the request of the tunnel.
+func WebSocketTunnelHandler(target func(*http.Request) string) http.Handler {
+	return websocket.Server{
+		Handshake: func(config *websocket.Config, r *http.Request) error {
+			for _, p := range config.Protocol {
+				if p == WebSocketTunnelProtocol {
+					config.Protocol = []string{p}
+					return nil
+				}
+			}
+			return fmt.Errorf("the %q subprotocol is required", WebSocketTunnelProtocol)
+		},
+		Handler: func(ws *websocket.Conn) {
+			defer ws.Close()
+			ctx := ws.Request().Context()
+			ws.PayloadType = websocket.BinaryFrame
+			addr := target(ws.Request())
+			conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+			if err != nil {
+				dlog.Errorf(ctx, "WebSocket tunnel: %v", err)
+				return
+			}
+			defer conn.Close()
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				_, _ = io.Copy(ws, conn)
+				_ = ws.Close()
+			}()
+			_, _ = io.Copy(conn, ws)
+			_ = conn.Close()
+			<-done
+		},
+	}
+}
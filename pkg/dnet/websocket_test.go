@@ -0,0 +1,190 @@
+package dnet
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/websocket"
+	"k8s.io/client-go/rest"
+
+	"github.com/datawire/ambassador/pkg/kates"
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/tlspolicy"
+)
+
+func assertEcho(t *testing.T, conn net.Conn, msg string) {
+	t.Helper()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err := conn.Write([]byte(msg))
+	require.NoError(t, err)
+	buf := make([]byte, len(msg))
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	assert.Equal(t, msg, string(buf))
+}
+
+func TestWebSocketTunnel(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	echoAddr := echoServer(t)
+	policy, err := tlspolicy.Parse("", nil, false)
+	require.NoError(t, err)
+
+	var mtls int32
+	handler := WebSocketTunnelHandler(func(r *http.Request) string {
+		if r.URL.Query().Get("mtls") == "true" {
+			atomic.AddInt32(&mtls, 1)
+		}
+		return echoAddr
+	})
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+	addr := srv.Listener.Addr().String()
+
+	conn, err := NewWebSocketTunnelDialer(nil, false, nil, policy)(ctx, addr)
+	require.NoError(t, err)
+	assertEcho(t, conn, "hello")
+	conn.Close()
+
+	// Through a CONNECT proxy, to the TLS port
+	proxyURL := connectProxy(t, "", nil)
+	conn, err = NewWebSocketTunnelDialer(nil, true, http.ProxyURL(proxyURL), policy)(ctx, addr)
+	require.NoError(t, err)
+	assertEcho(t, conn, "proxied")
+	conn.Close()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&mtls))
+
+	// Using TLS
+	tlsSrv := httptest.NewTLSServer(handler)
+	defer tlsSrv.Close()
+	certPool := x509.NewCertPool()
+	certPool.AddCert(tlsSrv.Certificate())
+	tlsConfig := tlsSrv.Client().Transport.(*http.Transport).TLSClientConfig
+	tlsConfig.RootCAs = certPool
+	conn, err = NewWebSocketTunnelDialer(tlsConfig, false, nil, policy)(ctx, tlsSrv.Listener.Addr().String())
+	require.NoError(t, err)
+	assertEcho(t, conn, "secure")
+	conn.Close()
+
+	// Other subprotocols are refused
+	_, err = DialWebSocket(ctx, &url.URL{Scheme: "ws", Host: addr, Path: WebSocketTunnelPath}, "other", nil, nil, nil, policy)
+	assert.Error(t, err)
+}
+
+// portForwardAPIServer is an API server that refuses the SPDY upgrade of port-forwards, like a
+// proxy that blocks it would, and serves port-forwards using WebSockets to the ports of localhost.
+func portForwardAPIServer(t *testing.T, token string) *httptest.Server {
+	wsHandler := websocket.Server{
+		Handshake: func(config *websocket.Config, r *http.Request) error {
+			config.Protocol = []string{portForwardProtocolV4}
+			return nil
+		},
+		Handler: func(ws *websocket.Conn) {
+			defer ws.Close()
+			port, _ := strconv.Atoi(ws.Request().URL.Query().Get("ports"))
+			portBytes := make([]byte, 2)
+			binary.LittleEndian.PutUint16(portBytes, uint16(port))
+			for _, ch := range []byte{wsDataChannel, wsErrorChannel} {
+				if websocket.Message.Send(ws, append([]byte{ch}, portBytes...)) != nil {
+					return
+				}
+			}
+			conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+			if err != nil {
+				_ = websocket.Message.Send(ws, append([]byte{wsErrorChannel}, err.Error()...))
+				return
+			}
+			defer conn.Close()
+			go func() {
+				buf := make([]byte, 1024)
+				for {
+					n, err := conn.Read(buf)
+					if err != nil {
+						_ = ws.Close()
+						return
+					}
+					if websocket.Message.Send(ws, append([]byte{wsDataChannel}, buf[:n]...)) != nil {
+						return
+					}
+				}
+			}()
+			for {
+				var msg []byte
+				if websocket.Message.Receive(ws, &msg) != nil {
+					return
+				}
+				if len(msg) > 1 && msg[0] == wsDataChannel {
+					if _, err := conn.Write(msg[1:]); err != nil {
+						return
+					}
+				}
+			}
+		},
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Header.Get("Authorization") != "Bearer "+token:
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+		case r.URL.Path != "/api/v1/namespaces/ns/pods/pod/portforward":
+			http.NotFound(w, r)
+		case r.Method == http.MethodGet:
+			wsHandler.ServeHTTP(w, r)
+		default:
+			http.Error(w, "upgrade refused", http.StatusBadRequest)
+		}
+	}))
+}
+
+func TestK8sPortForwardWebSocketFallback(t *testing.T) {
+	ctx, cancel := context.WithTimeout(dlog.NewTestContext(t, false), 10*time.Second)
+	defer cancel()
+	srv := portForwardAPIServer(t, "token")
+	defer srv.Close()
+
+	pf, err := newK8sPortForwardDialer(&rest.Config{Host: srv.URL, BearerToken: "token"}, nil, nil)
+	require.NoError(t, err)
+	pod := &kates.Pod{ObjectMeta: kates.ObjectMeta{Name: "pod", Namespace: "ns"}}
+	_, echoPort, err := net.SplitHostPort(echoServer(t))
+	require.NoError(t, err)
+	port, err := strconv.Atoi(echoPort)
+	require.NoError(t, err)
+
+	conn, err := pf.dial(ctx, pod, uint16(port))
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&pf.viaWebSocket))
+	assertEcho(t, conn, "hello")
+	assertEcho(t, conn, "again")
+	assert.Equal(t, "pod.ns:"+echoPort, conn.RemoteAddr().String())
+	conn.Close()
+
+	// Errors of the port-forward are reported by Read
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	closedPort := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+	conn, err = pf.dial(ctx, pod, uint16(closedPort))
+	require.NoError(t, err)
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Read(make([]byte, 10))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "error stream")
+	conn.Close()
+
+	// The port-forward fails when neither SPDY nor WebSockets are accepted
+	pf, err = newK8sPortForwardDialer(&rest.Config{Host: srv.URL, BearerToken: "wrong"}, nil, nil)
+	require.NoError(t, err)
+	_, err = pf.dial(ctx, pod, uint16(port))
+	assert.Error(t, err)
+}
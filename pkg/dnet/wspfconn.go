@@ -0,0 +1,182 @@
+package dnet
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"golang.org/x/net/websocket"
+	"k8s.io/client-go/rest"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/tlspolicy"
+)
+
+// The port-forward WebSocket protocol of the Kubernetes API server. There are two channels per
+// forwarded port: one for the data and one for errors. The first byte of each message is the
+// channel, and the first message of the server on each channel is the port number.
+const (
+	portForwardProtocolV4 = "v4.channel.k8s.io"
+	wsDataChannel         = 0
+	wsErrorChannel        = 1
+)
+
+// wsPortForwarder port-forwards using WebSockets, which get through proxies that refuse the SPDY
+// upgrade that the port-forwards of kubectl use.
+type wsPortForwarder struct {
+	tlsConfig *tls.Config
+	proxyFunc func(*http.Request) (*url.URL, error)
+	policy    *tlspolicy.Policy
+
+	// authRT is the round-tripper that adds the authentication of the kubeconfig to a request.
+	authRT http.RoundTripper
+}
+
+func newWSPortForwarder(kubeConfig *rest.Config) (*wsPortForwarder, error) {
+	tlsConfig, err := rest.TLSConfigFor(kubeConfig)
+	if err != nil {
+		return nil, err
+	}
+	authRT, err := rest.HTTPWrappersForConfig(kubeConfig, roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		return nil, &capturedHeader{header: r.Header}
+	}))
+	if err != nil {
+		return nil, err
+	}
+	proxyFunc := kubeConfig.Proxy
+	if proxyFunc == nil {
+		proxyFunc = http.ProxyFromEnvironment
+	}
+	// The API server is reached using the TLS config of the kubeconfig. The policy only applies
+	// to an https proxy, and is the default one, just like for the SPDY port-forwards of client-go.
+	policy, err := tlspolicy.Parse("", nil, false)
+	if err != nil {
+		return nil, err
+	}
+	return &wsPortForwarder{tlsConfig: tlsConfig, proxyFunc: proxyFunc, policy: policy, authRT: authRT}, nil
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+type capturedHeader struct {
+	header http.Header
+}
+
+func (c *capturedHeader) Error() string {
+	return "captured header"
+}
+
+// header returns the header of a request to the given URL, with the authentication of the
+// kubeconfig.
+func (pf *wsPortForwarder) header(u *url.URL) (http.Header, error) {
+	_, err := pf.authRT.RoundTrip(&http.Request{Method: http.MethodGet, URL: u, Header: make(http.Header)})
+	var ch *capturedHeader
+	if errors.As(err, &ch) {
+		return ch.header, nil
+	}
+	if err == nil {
+		err = errors.New("unable to get the authentication header")
+	}
+	return nil, err
+}
+
+// dial opens a port-forward WebSocket to the given portforward URL of a pod, which must have a
+// single "ports" parameter.
+func (pf *wsPortForwarder) dial(ctx context.Context, u *url.URL, remoteAddr string) (net.Conn, error) {
+	header, err := pf.header(u)
+	if err != nil {
+		return nil, err
+	}
+	wsURL := *u
+	if wsURL.Scheme == "https" {
+		wsURL.Scheme = "wss"
+	} else {
+		wsURL.Scheme = "ws"
+	}
+	ws, err := DialWebSocket(ctx, &wsURL, portForwardProtocolV4, header, pf.tlsConfig, pf.proxyFunc, pf.policy)
+	if err != nil {
+		return nil, err
+	}
+	return &wsPortForwardConn{Conn: ws, remoteAddr: remoteAddr}, nil
+}
+
+type wsPortForwardConn struct {
+	*websocket.Conn
+	remoteAddr string
+
+	readMu   sync.Mutex
+	buf      []byte
+	portRead [2]bool
+
+	writeMu sync.Mutex
+}
+
+// Read implements net.Conn.
+func (c *wsPortForwardConn) Read(b []byte) (int, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	for len(c.buf) == 0 {
+		var msg []byte
+		if err := websocket.Message.Receive(c.Conn, &msg); err != nil {
+			return 0, err
+		}
+		if len(msg) == 0 {
+			continue
+		}
+		ch, data := msg[0], msg[1:]
+		if ch > wsErrorChannel {
+			return 0, fmt.Errorf("port-forward message on unknown channel %d", ch)
+		}
+		if !c.portRead[ch] {
+			if len(data) < 2 {
+				return 0, errors.New("port-forward message without port")
+			}
+			c.portRead[ch] = true
+			data = data[2:]
+		}
+		if ch == wsErrorChannel {
+			if len(data) > 0 {
+				return 0, fmt.Errorf("error stream: %s", data)
+			}
+			continue
+		}
+		c.buf = data
+	}
+	n := copy(b, c.buf)
+	c.buf = c.buf[n:]
+	return n, nil
+}
+
+// Write implements net.Conn.
+func (c *wsPortForwardConn) Write(b []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if err := websocket.Message.Send(c.Conn, append([]byte{wsDataChannel}, b...)); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// LocalAddr implements net.Conn.
+func (c *wsPortForwardConn) LocalAddr() net.Addr {
+	return addr{
+		net:  "kubectl-port-forward",
+		addr: "client",
+	}
+}
+
+// RemoteAddr implements net.Conn.
+func (c *wsPortForwardConn) RemoteAddr() net.Addr {
+	return addr{
+		net:  "kubectl-port-forward",
+		addr: c.remoteAddr,
+	}
+}
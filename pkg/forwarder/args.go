@@ -0,0 +1,133 @@
+package forwarder
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MechanismArgs are the parsed mechanism arguments of an intercept that uses the "tcp" mechanism.
+type MechanismArgs struct {
+	// Faults are the faults to inject, or nil when no faults should be injected
+	Faults *Faults
+
+	// ForwardedHeaders adds X-Forwarded-For, X-Real-IP, and Forwarded headers that identify the
+	// in-cluster caller to the HTTP/1 requests of the intercepted connections
+	ForwardedHeaders bool
+
+	// ProxyProtocol sends a PROXY protocol v1 header that identifies the in-cluster caller at the
+	// start of each intercepted connection
+	ProxyProtocol bool
+
+	// nonHTTP is the name of the protocol of the intercepted port when it isn't HTTP and the
+	// arguments asked for HTTP features, which have then been disabled
+	nonHTTP string
+}
+
+// ParseMechanismArgs parses the mechanism arguments of an intercept. It returns nil when the
+// arguments don't ask for anything beyond plain forwarding. Arguments that this agent doesn't know,
+// e.g. because they were added in a later version, are ignored and returned as the second value,
+// but a known argument with an invalid value is an error.
+func ParseMechanismArgs(args []string) (*MechanismArgs, []string, error) {
+	ma := MechanismArgs{}
+	ft := Faults{}
+	var ignored []string
+	for _, arg := range args {
+		kv := strings.SplitN(strings.TrimPrefix(arg, "--"), "=", 2)
+		if len(kv) != 2 || !strings.HasPrefix(arg, "--") {
+			ignored = append(ignored, arg)
+			continue
+		}
+		var err error
+		switch kv[0] {
+		case "latency":
+			if ft.Latency, err = time.ParseDuration(kv[1]); err == nil && ft.Latency < 0 {
+				err = errors.New("the latency is negative")
+			}
+		case "error-rate":
+			ft.ErrorRate, err = parseRate(kv[1])
+		case "reset-rate":
+			ft.ResetRate, err = parseRate(kv[1])
+		case "forwarded-headers":
+			ma.ForwardedHeaders, err = strconv.ParseBool(kv[1])
+		case "proxy-protocol":
+			ma.ProxyProtocol, err = strconv.ParseBool(kv[1])
+		default:
+			ignored = append(ignored, arg)
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid mechanism argument %q: %w", arg, err)
+		}
+	}
+	if ft.ErrorRate+ft.ResetRate > 1 {
+		return nil, nil, fmt.Errorf("the sum of the error rate and the reset rate is greater than 1")
+	}
+	if ft != (Faults{}) {
+		ma.Faults = &ft
+	}
+	if ma == (MechanismArgs{}) {
+		return nil, ignored, nil
+	}
+	return &ma, ignored, nil
+}
+
+// String describes the arguments in a way that suits the MechanismArgsDesc of an intercept
+func (ma *MechanismArgs) String() string {
+	var descs []string
+	if ma.Faults != nil {
+		descs = append(descs, ma.Faults.String())
+	}
+	if ma.ForwardedHeaders {
+		descs = append(descs, "forwarded headers")
+	}
+	if ma.ProxyProtocol {
+		descs = append(descs, "PROXY protocol")
+	}
+	if ma.nonHTTP != "" {
+		descs = append(descs, "no HTTP features because the port carries "+ma.nonHTTP)
+	}
+	return strings.Join(descs, ", ")
+}
+
+// withoutHTTP returns the arguments that apply to a port that carries the given protocol, which
+// isn't HTTP. Connections that would be answered with an HTTP error are reset instead, and no
+// forwarded headers are added, so that the streams aren't sniffed for HTTP requests.
+func (ma *MechanismArgs) withoutHTTP(protocol string) *MechanismArgs {
+	if ma == nil || !ma.ForwardedHeaders && (ma.Faults == nil || ma.Faults.ErrorRate == 0) {
+		return ma
+	}
+	wa := *ma
+	if ma.Faults != nil && ma.Faults.ErrorRate > 0 {
+		ft := *ma.Faults
+		ft.ResetRate += ft.ErrorRate
+		ft.ErrorRate = 0
+		wa.Faults = &ft
+	}
+	wa.ForwardedHeaders = false
+	wa.nonHTTP = protocol
+	return &wa
+}
+
+// wrap applies the arguments to the given intercepted connection. The connection is closed and nil
+// is returned when a fault consumed it, otherwise the connection that should be forwarded is
+// returned.
+func (ma *MechanismArgs) wrap(conn *net.TCPConn) net.Conn {
+	if ma == nil {
+		return conn
+	}
+	c := ma.Faults.inject(conn)
+	if c == nil {
+		return nil
+	}
+	if ma.ForwardedHeaders {
+		c = newForwardedConn(c)
+	}
+	if ma.ProxyProtocol {
+		// The PROXY protocol header must precede everything else, so this wrapper goes last
+		c = newProxyProtocolConn(c)
+	}
+	return c
+}
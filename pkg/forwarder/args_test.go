@@ -0,0 +1,62 @@
+package forwarder
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMechanismArgs(t *testing.T) {
+	// The CLI always passes the flags of the tcp mechanism, so zero values mean plain forwarding
+	ma, ignored, err := ParseMechanismArgs([]string{
+		"--latency=0s", "--error-rate=0", "--reset-rate=0", "--forwarded-headers=false", "--proxy-protocol=false",
+	})
+	require.NoError(t, err)
+	assert.Nil(t, ma)
+	assert.Empty(t, ignored)
+
+	ma, _, err = ParseMechanismArgs(nil)
+	require.NoError(t, err)
+	assert.Nil(t, ma)
+
+	ma, _, err = ParseMechanismArgs([]string{"--latency=200ms", "--error-rate=0.1", "--reset-rate=0.05"})
+	require.NoError(t, err)
+	assert.Equal(t, &MechanismArgs{Faults: &Faults{Latency: 200 * time.Millisecond, ErrorRate: 0.1, ResetRate: 0.05}}, ma)
+	assert.Equal(t, "200ms latency, 10% errors, 5% resets", ma.String())
+
+	ma, _, err = ParseMechanismArgs([]string{"--latency=0s", "--forwarded-headers=true", "--proxy-protocol=true"})
+	require.NoError(t, err)
+	assert.Equal(t, &MechanismArgs{ForwardedHeaders: true, ProxyProtocol: true}, ma)
+	assert.Equal(t, "forwarded headers, PROXY protocol", ma.String())
+
+	// Arguments of other versions of the agent are ignored
+	ma, ignored, err = ParseMechanismArgs([]string{"--reset-rate=0.5", "--match=x-user=me", "latency=1s"})
+	require.NoError(t, err)
+	assert.Equal(t, &MechanismArgs{Faults: &Faults{ResetRate: 0.5}}, ma)
+	assert.Equal(t, []string{"--match=x-user=me", "latency=1s"}, ignored)
+
+	// The HTTP features are disabled on a port that is known to carry some other protocol
+	f := NewForwarder(nil, "", 6379)
+	ma, _, err = f.ParseMechanismArgs([]string{"--error-rate=0.25", "--reset-rate=0.25", "--forwarded-headers=true"})
+	require.NoError(t, err)
+	assert.Equal(t, 0.0, ma.Faults.ErrorRate)
+	assert.Equal(t, 0.5, ma.Faults.ResetRate)
+	assert.False(t, ma.ForwardedHeaders)
+	assert.Equal(t, "50% resets, no HTTP features because the port carries redis", ma.String())
+	f.SetAppProtocol("http")
+	ma, _, err = f.ParseMechanismArgs([]string{"--forwarded-headers=true"})
+	require.NoError(t, err)
+	assert.Equal(t, &MechanismArgs{ForwardedHeaders: true}, ma)
+
+	for _, args := range [][]string{
+		{"--latency=-1s"},
+		{"--error-rate=1.5"},
+		{"--error-rate=0.6", "--reset-rate=0.6"},
+		{"--proxy-protocol=maybe"},
+	} {
+		_, _, err = ParseMechanismArgs(args)
+		assert.Error(t, err, "%q", args)
+	}
+}
@@ -0,0 +1,255 @@
+package forwarder
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"strconv"
+	"strings"
+)
+
+// The forwarder dials the local handler from a client that isn't the in-cluster caller, so handlers
+// that depend on the identity of the caller need to be told who it is. The wrappers in this file do
+// that by either adding forwarded headers to the HTTP/1 requests of a connection, or by sending a
+// PROXY protocol header before the data of a connection.
+
+// prefixConn is a connection that returns a prefix before the data that is read from it.
+type prefixConn struct {
+	net.Conn
+	prefix []byte
+}
+
+func (c *prefixConn) Read(b []byte) (int, error) {
+	if len(c.prefix) > 0 {
+		n := copy(b, c.prefix)
+		c.prefix = c.prefix[n:]
+		return n, nil
+	}
+	return c.Conn.Read(b)
+}
+
+func (c *prefixConn) CloseWrite() error {
+	return closeWrite(c.Conn)
+}
+
+// closeWrite closes the given connection for writing, so that the caller that the connection was
+// accepted from knows that nothing more is coming, while the caller can still send. A connection
+// that can't be half-closed is left as is.
+func closeWrite(conn net.Conn) error {
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
+
+func newProxyProtocolConn(conn net.Conn) net.Conn {
+	return &prefixConn{Conn: conn, prefix: []byte(proxyProtocolHeader(conn.RemoteAddr(), conn.LocalAddr()))}
+}
+
+// proxyProtocolHeader returns the PROXY protocol v1 header for a connection from src to dst
+func proxyProtocolHeader(src, dst net.Addr) string {
+	srcIP, srcPort, err1 := splitAddr(src)
+	dstIP, dstPort, err2 := splitAddr(dst)
+	if err1 != nil || err2 != nil {
+		return "PROXY UNKNOWN\r\n"
+	}
+	if srcIP.To4() != nil && dstIP.To4() != nil {
+		return fmt.Sprintf("PROXY TCP4 %s %s %d %d\r\n", srcIP, dstIP, srcPort, dstPort)
+	}
+	return fmt.Sprintf("PROXY TCP6 %s %s %d %d\r\n", ip6String(srcIP), ip6String(dstIP), srcPort, dstPort)
+}
+
+// ip6String formats the given IP as an IPv6 address, using the IPv4-mapped form for IPv4 addresses
+func ip6String(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return "::ffff:" + ip4.String()
+	}
+	return ip.String()
+}
+
+func splitAddr(addr net.Addr) (net.IP, int, error) {
+	if addr == nil {
+		return nil, 0, fmt.Errorf("no address")
+	}
+	host, portStr, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return nil, 0, err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, 0, fmt.Errorf("invalid IP %q", host)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, 0, err
+	}
+	return ip, port, nil
+}
+
+// forwardedConn is a connection that adds forwarded headers to the HTTP/1 requests that are read
+// from it.
+type forwardedConn struct {
+	net.Conn
+	pr *io.PipeReader
+}
+
+func newForwardedConn(conn net.Conn) net.Conn {
+	caller := ""
+	if ip, _, err := splitAddr(conn.RemoteAddr()); err == nil {
+		caller = ip.String()
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		_ = pw.CloseWithError(forwardHeaders(conn, pw, caller))
+	}()
+	return &forwardedConn{Conn: conn, pr: pr}
+}
+
+func (c *forwardedConn) Read(b []byte) (int, error) {
+	return c.pr.Read(b)
+}
+
+func (c *forwardedConn) CloseWrite() error {
+	return closeWrite(c.Conn)
+}
+
+func (c *forwardedConn) Close() error {
+	_ = c.pr.Close()
+	return c.Conn.Close()
+}
+
+var httpMethods = []string{
+	http.MethodGet,
+	http.MethodHead,
+	http.MethodPost,
+	http.MethodPut,
+	http.MethodPatch,
+	http.MethodDelete,
+	http.MethodConnect,
+	http.MethodOptions,
+	http.MethodTrace,
+}
+
+// startsWithRequest peeks at the buffered data of the reader to tell if it starts with an HTTP/1
+// request. It returns io.EOF when the reader has no more data.
+func startsWithRequest(br *bufio.Reader) (bool, error) {
+	if _, err := br.Peek(1); err != nil {
+		return false, err
+	}
+	n := br.Buffered()
+	if n > len(http.MethodOptions)+1 {
+		n = len(http.MethodOptions) + 1
+	}
+	start, _ := br.Peek(n)
+	for _, m := range httpMethods {
+		if bytes.HasPrefix(start, []byte(m+" ")) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// forwardHeaders copies the data from r to w, adding forwarded headers that identify the given
+// caller to each HTTP/1 request. Data that isn't HTTP/1, such as HTTP/2 or the data that follows
+// a protocol upgrade, is copied as is.
+func forwardHeaders(r io.Reader, w io.Writer, caller string) error {
+	br := bufio.NewReader(r)
+	for {
+		isRequest, err := startsWithRequest(br)
+		if err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			return err
+		}
+		if !isRequest {
+			_, err = io.Copy(w, br)
+			return err
+		}
+		req, err := http.ReadRequest(br)
+		if err != nil {
+			return err
+		}
+		if caller != "" {
+			addForwardedHeaders(req.Header, caller)
+		}
+		if err = writeRequest(w, req); err != nil {
+			return err
+		}
+		if req.Method == http.MethodConnect || req.Header.Get("Upgrade") != "" {
+			_, err = io.Copy(w, br)
+			return err
+		}
+	}
+}
+
+func addForwardedHeaders(h http.Header, caller string) {
+	appendHeader := func(key, value string) {
+		if prior := h.Get(key); prior != "" {
+			value = prior + ", " + value
+		}
+		h.Set(key, value)
+	}
+	appendHeader("X-Forwarded-For", caller)
+	if h.Get("X-Real-IP") == "" {
+		h.Set("X-Real-IP", caller)
+	}
+	if strings.Contains(caller, ":") {
+		// IPv6 addresses must be bracketed and quoted, see RFC 7239
+		appendHeader("Forwarded", `for="[`+caller+`]"`)
+	} else {
+		appendHeader("Forwarded", "for="+caller)
+	}
+}
+
+// writeRequest writes a request that was read using http.ReadRequest as close to its original form
+// as possible. The body is streamed as it's read, so that slow uploads aren't held back.
+func writeRequest(w io.Writer, req *http.Request) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "%s %s %s\r\n", req.Method, req.RequestURI, req.Proto)
+	if req.Host != "" {
+		fmt.Fprintf(bw, "Host: %s\r\n", req.Host)
+	}
+	chunked := len(req.TransferEncoding) > 0 && req.TransferEncoding[0] == "chunked"
+	if chunked {
+		bw.WriteString("Transfer-Encoding: chunked\r\n")
+		if len(req.Trailer) > 0 {
+			keys := make([]string, 0, len(req.Trailer))
+			for k := range req.Trailer {
+				keys = append(keys, k)
+			}
+			fmt.Fprintf(bw, "Trailer: %s\r\n", strings.Join(keys, ", "))
+		}
+	} else if req.ContentLength > 0 {
+		req.Header.Set("Content-Length", strconv.FormatInt(req.ContentLength, 10))
+	}
+	if err := req.Header.Write(bw); err != nil {
+		return err
+	}
+	bw.WriteString("\r\n")
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+
+	if !chunked {
+		_, err := io.Copy(w, req.Body)
+		return err
+	}
+	cw := httputil.NewChunkedWriter(w)
+	if _, err := io.Copy(cw, req.Body); err != nil {
+		return err
+	}
+	if err := cw.Close(); err != nil {
+		return err
+	}
+	// The trailers are known once the body has been read
+	if err := req.Trailer.Write(bw); err != nil {
+		return err
+	}
+	bw.WriteString("\r\n")
+	return bw.Flush()
+}
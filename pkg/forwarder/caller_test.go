@@ -0,0 +1,71 @@
+package forwarder
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProxyProtocolHeader(t *testing.T) {
+	src := &net.TCPAddr{IP: net.IP{10, 1, 2, 3}, Port: 41234}
+	dst := &net.TCPAddr{IP: net.IP{10, 1, 9, 9}, Port: 8080}
+	assert.Equal(t, "PROXY TCP4 10.1.2.3 10.1.9.9 41234 8080\r\n", proxyProtocolHeader(src, dst))
+
+	src6 := &net.TCPAddr{IP: net.ParseIP("fd00::3"), Port: 41234}
+	dst6 := &net.TCPAddr{IP: net.ParseIP("fd00::9"), Port: 8080}
+	assert.Equal(t, "PROXY TCP6 fd00::3 fd00::9 41234 8080\r\n", proxyProtocolHeader(src6, dst6))
+
+	assert.Equal(t, "PROXY TCP6 fd00::3 ::ffff:10.1.9.9 41234 8080\r\n", proxyProtocolHeader(src6, dst))
+
+	assert.Equal(t, "PROXY UNKNOWN\r\n", proxyProtocolHeader(nil, dst))
+}
+
+func TestForwardHeaders(t *testing.T) {
+	tests := []struct {
+		name   string
+		in     string
+		caller string
+		out    string
+	}{
+		{
+			name:   "keep-alive",
+			caller: "10.1.2.3",
+			in: "GET /a HTTP/1.1\r\nHost: svc\r\n\r\n" +
+				"POST /b HTTP/1.1\r\nHost: svc\r\nContent-Length: 5\r\nX-Forwarded-For: 192.168.0.1\r\n\r\nhello",
+			out: "GET /a HTTP/1.1\r\nHost: svc\r\nForwarded: for=10.1.2.3\r\nX-Forwarded-For: 10.1.2.3\r\nX-Real-Ip: 10.1.2.3\r\n\r\n" +
+				"POST /b HTTP/1.1\r\nHost: svc\r\nContent-Length: 5\r\nForwarded: for=10.1.2.3\r\nX-Forwarded-For: 192.168.0.1, 10.1.2.3\r\nX-Real-Ip: 10.1.2.3\r\n\r\nhello",
+		},
+		{
+			name:   "chunked",
+			caller: "fd00::3",
+			in:     "PUT /c HTTP/1.1\r\nHost: svc\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n0\r\n\r\n",
+			out: "PUT /c HTTP/1.1\r\nHost: svc\r\nTransfer-Encoding: chunked\r\n" +
+				"Forwarded: for=\"[fd00::3]\"\r\nX-Forwarded-For: fd00::3\r\nX-Real-Ip: fd00::3\r\n\r\n5\r\nhello\r\n0\r\n\r\n",
+		},
+		{
+			name:   "upgrade",
+			caller: "10.1.2.3",
+			in:     "GET /ws HTTP/1.1\r\nHost: svc\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\nGET /not-a-request",
+			out: "GET /ws HTTP/1.1\r\nHost: svc\r\nConnection: Upgrade\r\nForwarded: for=10.1.2.3\r\nUpgrade: websocket\r\n" +
+				"X-Forwarded-For: 10.1.2.3\r\nX-Real-Ip: 10.1.2.3\r\n\r\nGET /not-a-request",
+		},
+		{
+			name:   "not http",
+			caller: "10.1.2.3",
+			in:     "*1\r\n$4\r\nPING\r\n",
+			out:    "*1\r\n$4\r\nPING\r\n",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			out := &bytes.Buffer{}
+			require.NoError(t, forwardHeaders(strings.NewReader(tt.in), out, tt.caller))
+			assert.Equal(t, tt.out, out.String())
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package forwarder
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Faults are the faults that the forwarder injects into the intercepted connections, so that the
+// resilience of the clients of the intercepted service can be tested. They are configured using the
+// --tcp-latency, --tcp-error-rate, and --tcp-reset-rate flags of "telepresence intercept", and are
+// also injected into the connections that fall back to the intercepted container while the
+// intercept has no tunnel to the client.
+type Faults struct {
+	// Latency is added to the data that the client sends after having been idle
+	Latency time.Duration
+
+	// ErrorRate is the fraction of the connections that are answered with an HTTP 503 response
+	// instead of being forwarded. A connection that doesn't start with an HTTP/1 request is reset
+	// instead, so that no other protocol gets an HTTP response.
+	ErrorRate float64
+
+	// ResetRate is the fraction of the connections that are reset instead of being forwarded
+	ResetRate float64
+}
+
+const errorResponse = "HTTP/1.1 503 Service Unavailable\r\nContent-Length: 0\r\nConnection: close\r\n\r\n"
+
+// requestTimeout is how long a connection that gets an error may take to send the start of its
+// request, and to close its side of the connection once it has been answered.
+var requestTimeout = time.Second
+
+func parseRate(s string) (float64, error) {
+	r, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+	if r < 0 || r > 1 {
+		return 0, errors.New("the rate must be between 0 and 1")
+	}
+	return r, nil
+}
+
+// String describes the faults in a way that suits the MechanismArgsDesc of an intercept
+func (ft *Faults) String() string {
+	var descs []string
+	if ft.Latency > 0 {
+		descs = append(descs, fmt.Sprintf("%s latency", ft.Latency))
+	}
+	if ft.ErrorRate > 0 {
+		descs = append(descs, fmt.Sprintf("%g%% errors", ft.ErrorRate*100))
+	}
+	if ft.ResetRate > 0 {
+		descs = append(descs, fmt.Sprintf("%g%% resets", ft.ResetRate*100))
+	}
+	return strings.Join(descs, ", ")
+}
+
+// inject injects faults into the given connection. The connection is closed and nil is returned
+// when it was answered with an error or reset, otherwise the connection that should be forwarded
+// is returned.
+func (ft *Faults) inject(conn *net.TCPConn) net.Conn {
+	if ft == nil {
+		return conn
+	}
+	r := rand.Float64()
+	switch {
+	case r < ft.ResetRate:
+		reset(conn)
+		return nil
+	case r < ft.ResetRate+ft.ErrorRate:
+		if !startsWithHTTPRequest(conn) {
+			reset(conn)
+			return nil
+		}
+		_, _ = conn.Write([]byte(errorResponse))
+
+		// Closing a connection that has unread data resets it, and the client might then lose the
+		// response, so the rest of the request is discarded until the client closes its side.
+		_ = conn.CloseWrite()
+		_ = conn.SetReadDeadline(time.Now().Add(requestTimeout))
+		_, _ = io.Copy(ioutil.Discard, conn)
+		_ = conn.Close()
+		return nil
+	case ft.Latency > 0:
+		return &latencyConn{Conn: conn, latency: ft.Latency}
+	default:
+		return conn
+	}
+}
+
+func reset(conn *net.TCPConn) {
+	// A zero linger makes Close send a RST rather than a FIN
+	_ = conn.SetLinger(0)
+	_ = conn.Close()
+}
+
+// startsWithHTTPRequest reads the start of the data that the client sends and returns true if it's
+// the method of an HTTP/1 request line. It returns false for protocols where the server speaks
+// first, because their clients send nothing within the requestTimeout.
+func startsWithHTTPRequest(conn net.Conn) bool {
+	_ = conn.SetReadDeadline(time.Now().Add(requestTimeout))
+	defer func() {
+		_ = conn.SetReadDeadline(time.Time{})
+	}()
+	buf := make([]byte, 0, len(http.MethodOptions)+1)
+	for len(buf) < cap(buf) {
+		n, err := conn.Read(buf[len(buf):cap(buf)])
+		buf = buf[:len(buf)+n]
+		if i := bytes.IndexByte(buf, ' '); i >= 0 {
+			for _, m := range httpMethods {
+				if string(buf[:i]) == m {
+					return true
+				}
+			}
+			return false
+		}
+		if err != nil {
+			return false
+		}
+	}
+	return false
+}
+
+// latencyConn delays the data that is read from the connection after the connection has been
+// idle, so that each request of a client, rather than each chunk of it, gets the latency.
+type latencyConn struct {
+	net.Conn
+	latency  time.Duration
+	lastRead time.Time
+}
+
+func (c *latencyConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		now := time.Now()
+		if now.Sub(c.lastRead) > c.latency {
+			time.Sleep(c.latency)
+			now = time.Now()
+		}
+		c.lastRead = now
+	}
+	return n, err
+}
+
+func (c *latencyConn) CloseWrite() error {
+	return closeWrite(c.Conn)
+}
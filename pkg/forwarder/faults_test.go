@@ -0,0 +1,183 @@
+package forwarder
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/telepresenceio/telepresence/rpc/v2/manager"
+)
+
+// tcpPair returns the client and the server side of a TCP connection on the loopback interface.
+func tcpPair(t *testing.T) (net.Conn, *net.TCPConn) {
+	l, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer l.Close()
+	cc, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = cc.Close() })
+	sc, err := l.AcceptTCP()
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = sc.Close() })
+	return cc, sc
+}
+
+// injectAsync injects the faults into the server side of a new connection and returns the client
+// side along with a channel that receives the result of the injection.
+func injectAsync(t *testing.T, ft *Faults) (net.Conn, <-chan net.Conn) {
+	cc, sc := tcpPair(t)
+	result := make(chan net.Conn, 1)
+	go func() {
+		result <- ft.inject(sc)
+	}()
+	return cc, result
+}
+
+func setRequestTimeout(t *testing.T, d time.Duration) {
+	saved := requestTimeout
+	requestTimeout = d
+	t.Cleanup(func() { requestTimeout = saved })
+}
+
+func TestInjectReset(t *testing.T) {
+	cc, result := injectAsync(t, &Faults{ResetRate: 1})
+	assert.Nil(t, <-result)
+	_ = cc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	data, err := ioutil.ReadAll(cc)
+	assert.Error(t, err, "the connection is reset")
+	assert.Empty(t, data)
+}
+
+func TestInjectErrorHTTP(t *testing.T) {
+	setRequestTimeout(t, 5*time.Second)
+	cc, result := injectAsync(t, &Faults{ErrorRate: 1})
+	_, err := cc.Write([]byte("POST /orders HTTP/1.1\r\nHost: svc\r\nContent-Length: 5\r\n\r\nhello"))
+	require.NoError(t, err)
+	_ = cc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	data, err := ioutil.ReadAll(cc)
+	assert.NoError(t, err)
+	assert.Equal(t, errorResponse, string(data))
+	_ = cc.Close()
+	assert.Nil(t, <-result)
+}
+
+func TestInjectErrorNotHTTP(t *testing.T) {
+	setRequestTimeout(t, 100*time.Millisecond)
+	for name, hello := range map[string]string{
+		"TLS":          "\x16\x03\x01\x02\x00\x01\x00\x01\xfc\x03\x03",
+		"HTTP/2":       "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n",
+		"server-first": "",
+	} {
+		t.Run(name, func(t *testing.T) {
+			cc, result := injectAsync(t, &Faults{ErrorRate: 1})
+			if hello != "" {
+				_, err := cc.Write([]byte(hello))
+				require.NoError(t, err)
+			}
+			assert.Nil(t, <-result)
+			_ = cc.SetReadDeadline(time.Now().Add(5 * time.Second))
+			data, _ := ioutil.ReadAll(cc)
+			assert.Empty(t, data, "no HTTP response is written to other protocols")
+		})
+	}
+}
+
+func TestInjectLatency(t *testing.T) {
+	cc, result := injectAsync(t, &Faults{Latency: 200 * time.Millisecond})
+	conn := <-result
+	require.IsType(t, &latencyConn{}, conn)
+
+	// The first chunk is delayed
+	start := time.Now()
+	_, err := cc.Write([]byte("ping"))
+	require.NoError(t, err)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, int64(time.Since(start)), int64(200*time.Millisecond))
+
+	// A chunk that follows right after it isn't
+	start = time.Now()
+	_, err = cc.Write([]byte("pong"))
+	require.NoError(t, err)
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	assert.Less(t, int64(time.Since(start)), int64(200*time.Millisecond))
+
+	// A chunk that follows after the connection has been idle is delayed again
+	time.Sleep(300 * time.Millisecond)
+	start = time.Now()
+	_, err = cc.Write([]byte("ping"))
+	require.NoError(t, err)
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, int64(time.Since(start)), int64(200*time.Millisecond))
+}
+
+func TestInjectNone(t *testing.T) {
+	var ft *Faults
+	_, sc := tcpPair(t)
+	assert.Equal(t, net.Conn(sc), ft.inject(sc))
+	assert.Equal(t, net.Conn(sc), (&Faults{}).inject(sc))
+}
+
+func TestFallbackFaults(t *testing.T) {
+	// The connection handlers outlive the test, so they don't log to it
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The intercepted container answers everything with "ok"
+	app, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer app.Close()
+	go func() {
+		for {
+			conn, err := app.Accept()
+			if err != nil {
+				return
+			}
+			_, _ = conn.Write([]byte("ok"))
+			_ = conn.Close()
+		}
+	}()
+	appAddr := app.Addr().(*net.TCPAddr)
+
+	f := NewForwarder(&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}, "127.0.0.1", int32(appAddr.Port))
+	l, err := f.Listen(ctx)
+	require.NoError(t, err)
+	go func() {
+		_ = f.ServeListener(ctx, l)
+	}()
+	get := func() ([]byte, error) {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		require.NoError(t, err)
+		defer conn.Close()
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		return ioutil.ReadAll(conn)
+	}
+
+	data, err := get()
+	require.NoError(t, err)
+	assert.Equal(t, "ok", string(data))
+
+	// The intercept has no tunnel because the forwarder has no manager, so its connections fall
+	// back to the intercepted container, and are reset.
+	f.SetIntercepting(&manager.InterceptInfo{
+		Id:   "intercept-01",
+		Spec: &manager.InterceptSpec{Name: "cept", MechanismArgs: []string{"--reset-rate=1"}},
+	})
+	data, err = get()
+	assert.Error(t, err)
+	assert.Empty(t, data)
+
+	f.SetIntercepting(nil)
+	data, err = get()
+	require.NoError(t, err)
+	assert.Equal(t, "ok", string(data))
+}
@@ -26,19 +26,48 @@ type Forwarder struct {
 	targetHost string
 	targetPort int32
 
+	// protocol is the protocol of the target port, as determined by DetectProtocol, and
+	// protocolName is the name of that protocol
+	protocol     string
+	protocolName string
+
 	manager     manager.ManagerClient
 	sessionInfo *manager.SessionInfo
 
 	intercept *manager.InterceptInfo
+	args      *MechanismArgs
 	tunnel    manager.Manager_AgentTunnelClient
+
+	// stream is the tunnel as shared by the handlers of the intercepted connections
+	stream *connpool.Stream
 }
 
 func NewForwarder(listen *net.TCPAddr, targetHost string, targetPort int32) *Forwarder {
-	return &Forwarder{
+	f := &Forwarder{
 		listenAddr: listen,
 		targetHost: targetHost,
 		targetPort: targetPort,
 	}
+	f.protocol, f.protocolName = DetectProtocol("", targetPort)
+	return f
+}
+
+// SetAppProtocol tells the forwarder the application protocol of the intercepted port, as given by
+// the appProtocol or the name of the service port. The HTTP features of the intercepts are disabled
+// when the port doesn't carry HTTP. It must be called before the forwarder is served.
+func (f *Forwarder) SetAppProtocol(appProtocol string) {
+	f.protocol, f.protocolName = DetectProtocol(appProtocol, f.targetPort)
+}
+
+// ParseMechanismArgs parses the mechanism arguments of an intercept like the package function of
+// the same name, and then disables the HTTP features that they ask for if the target port is known
+// to carry some other protocol.
+func (f *Forwarder) ParseMechanismArgs(args []string) (*MechanismArgs, []string, error) {
+	ma, ignored, err := ParseMechanismArgs(args)
+	if err == nil && f.protocol == ProtocolTCP {
+		ma = ma.withoutHTTP(f.protocolName)
+	}
+	return ma, ignored, err
 }
 
 func (f *Forwarder) SetManager(sessionInfo *manager.SessionInfo, manager manager.ManagerClient) {
@@ -47,6 +76,7 @@ func (f *Forwarder) SetManager(sessionInfo *manager.SessionInfo, manager manager
 	f.sessionInfo = sessionInfo
 	f.manager = manager
 	f.tunnel = nil // any existing tunnel is lost when a reconnect happens
+	f.stream = nil
 }
 
 func (f *Forwarder) Serve(ctx context.Context) error {
@@ -159,6 +189,7 @@ func (f *Forwarder) SetIntercepting(intercept *manager.InterceptInfo) {
 	if f.tunnel != nil {
 		_ = f.tunnel.CloseSend()
 		f.tunnel = nil
+		f.stream = nil
 	}
 	f.tCancel()
 
@@ -166,15 +197,27 @@ func (f *Forwarder) SetIntercepting(intercept *manager.InterceptInfo) {
 	f.tCtx, f.tCancel = context.WithCancel(f.lCtx)
 	if intercept != nil {
 		if f.manager != nil {
-			tunnel, err := f.startManagerTunnel(f.tCtx, intercept.ClientSession)
+			tunnel, stream, err := f.startManagerTunnel(f.tCtx, intercept.ClientSession)
 			if err != nil {
 				dlog.Error(f.tCtx, err)
 				return
 			}
 			f.tunnel = tunnel
+			f.stream = stream
 		}
 	}
 	f.intercept = intercept
+	f.args = nil
+	if intercept != nil {
+		args, ignored, err := f.ParseMechanismArgs(intercept.Spec.MechanismArgs)
+		if err != nil {
+			dlog.Errorf(f.tCtx, "Ignoring mechanism arguments: %v", err)
+		}
+		if len(ignored) > 0 {
+			dlog.Warnf(f.tCtx, "Ignoring unknown mechanism arguments %q", ignored)
+		}
+		f.args = args
+	}
 }
 
 func (f *Forwarder) forwardConn(clientConn *net.TCPConn) error {
@@ -183,10 +226,24 @@ func (f *Forwarder) forwardConn(clientConn *net.TCPConn) error {
 	targetHost := f.targetHost
 	targetPort := f.targetPort
 	intercept := f.intercept
-	tunnel := f.tunnel
+	args := f.args
+	stream := f.stream
 	f.mu.Unlock()
-	if tunnel != nil {
-		return f.interceptConn(ctx, clientConn, intercept, tunnel)
+	if stream != nil {
+		conn := args.wrap(clientConn)
+		if conn == nil {
+			return nil
+		}
+		return f.interceptConn(ctx, conn, intercept, stream)
+	}
+
+	// The connections of an intercept that has no tunnel to the client fall back to the
+	// intercepted container, and get the faults of the intercept all the same.
+	var src net.Conn = clientConn
+	if intercept != nil && args != nil {
+		if src = args.Faults.inject(clientConn); src == nil {
+			return nil
+		}
 	}
 
 	targetAddr, err := net.ResolveTCPAddr("tcp", fmt.Sprintf("%s:%d", targetHost, targetPort))
@@ -211,7 +268,7 @@ func (f *Forwarder) forwardConn(clientConn *net.TCPConn) error {
 	done := make(chan struct{})
 
 	go func() {
-		if _, err := io.Copy(targetConn, clientConn); err != nil {
+		if _, err := io.Copy(targetConn, src); err != nil {
 			dlog.Debugf(ctx, "Error clientConn->targetConn: %+v", err)
 		}
 		_ = targetConn.CloseWrite()
@@ -237,11 +294,11 @@ func (f *Forwarder) forwardConn(clientConn *net.TCPConn) error {
 	return nil
 }
 
-func (f *Forwarder) startManagerTunnel(ctx context.Context, clientSession *manager.SessionInfo) (manager.Manager_AgentTunnelClient, error) {
+func (f *Forwarder) startManagerTunnel(ctx context.Context, clientSession *manager.SessionInfo) (manager.Manager_AgentTunnelClient, *connpool.Stream, error) {
 	tunnel, err := f.manager.AgentTunnel(ctx)
 	if err != nil {
 		err = fmt.Errorf("call to AgentTunnel() failed: %v", err)
-		return nil, err
+		return nil, nil, err
 	}
 	defer func() {
 		if err != nil {
@@ -251,17 +308,18 @@ func (f *Forwarder) startManagerTunnel(ctx context.Context, clientSession *manag
 
 	if err = tunnel.Send(connpool.SessionInfoControl(f.sessionInfo).TunnelMessage()); err != nil {
 		err = fmt.Errorf("failed to send agent sessionID: %s", err)
-		return nil, err
+		return nil, nil, err
 	}
 	if err = tunnel.Send(connpool.SessionInfoControl(clientSession).TunnelMessage()); err != nil {
 		err = fmt.Errorf("failed to send client sessionID: %s", err)
-		return nil, err
+		return nil, nil, err
 	}
 
+	stream := connpool.NewStream(tunnel)
 	go func() {
 		pool := connpool.GetPool(ctx)
 		closing := int32(0)
-		msgCh, errCh := connpool.NewStream(tunnel).ReadLoop(ctx, &closing)
+		msgCh, errCh := stream.ReadLoop(ctx, &closing)
 		for {
 			select {
 			case <-ctx.Done():
@@ -276,7 +334,7 @@ func (f *Forwarder) startManagerTunnel(ctx context.Context, clientSession *manag
 				}
 				id := msg.ID()
 				handler, _, err := pool.Get(ctx, id, func(ctx context.Context, release func()) (connpool.Handler, error) {
-					return connpool.NewDialer(id, tunnel, release), nil
+					return connpool.NewDialer(id, stream, release), nil
 				})
 				if err != nil {
 					dlog.Error(ctx, err)
@@ -286,7 +344,7 @@ func (f *Forwarder) startManagerTunnel(ctx context.Context, clientSession *manag
 			}
 		}
 	}()
-	return tunnel, nil
+	return tunnel, stream, nil
 }
 
 func (f *Forwarder) interceptConn(ctx context.Context, conn net.Conn, iCept *manager.InterceptInfo, tunnel connpool.TunnelStream) error {
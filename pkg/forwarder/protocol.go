@@ -0,0 +1,99 @@
+package forwarder
+
+import "strings"
+
+// The protocols that DetectProtocol distinguishes between
+const (
+	ProtocolHTTP    = "http"
+	ProtocolTCP     = "tcp"
+	ProtocolUnknown = ""
+)
+
+// httpProtocols are the names of application protocols that are carried over HTTP
+var httpProtocols = map[string]bool{
+	"http":     true,
+	"http2":    true,
+	"grpc":     true,
+	"grpc-web": true,
+	"h2c":      true,
+	"ws":       true,
+}
+
+// tcpProtocols are the names of well known application protocols that aren't HTTP. HTTP over TLS is
+// among them, because the agent can't see the requests.
+var tcpProtocols = map[string]bool{
+	"tcp":        true,
+	"tls":        true,
+	"https":      true,
+	"wss":        true,
+	"mongo":      true,
+	"mongodb":    true,
+	"mysql":      true,
+	"postgres":   true,
+	"postgresql": true,
+	"redis":      true,
+	"amqp":       true,
+	"amqps":      true,
+	"kafka":      true,
+	"memcached":  true,
+	"mqtt":       true,
+	"nats":       true,
+	"cassandra":  true,
+	"zookeeper":  true,
+}
+
+// tcpPorts are the default ports of well known protocols that aren't HTTP
+var tcpPorts = map[int32]string{
+	2181:  "zookeeper",
+	3306:  "mysql",
+	4222:  "nats",
+	5432:  "postgres",
+	5672:  "amqp",
+	6379:  "redis",
+	9042:  "cassandra",
+	9092:  "kafka",
+	11211: "memcached",
+	27017: "mongodb",
+}
+
+// DetectProtocol determines if the intercepted port carries HTTP, in which case ProtocolHTTP is
+// returned, or some other protocol, in which case ProtocolTCP is returned. The given application
+// protocol is the appProtocol of the service port, or its name when it has none. Names that follow
+// the "<protocol>[-<suffix>]" convention and names that are qualified with a domain, such as
+// "kubernetes.io/h2c", are recognized. Lastly, the given port number is used if it's the default
+// port of a well known protocol. ProtocolUnknown is returned when the protocol can't be determined.
+//
+// The name of the protocol is also returned, so that it can be included in messages.
+func DetectProtocol(appProtocol string, port int32) (string, string) {
+	name := strings.ToLower(appProtocol)
+	if i := strings.LastIndexByte(name, '/'); i >= 0 {
+		name = name[i+1:]
+	}
+	if i := strings.IndexByte(name, '-'); i > 0 && !httpProtocols[name] {
+		name = name[:i]
+	}
+	if p := protocolOf(name); p != ProtocolUnknown {
+		return p, name
+	}
+	if name, ok := tcpPorts[port]; ok {
+		return ProtocolTCP, name
+	}
+	return ProtocolUnknown, ""
+}
+
+// ProtocolOf returns ProtocolHTTP or ProtocolTCP for a protocol name like "grpc" or "redis", or
+// ProtocolUnknown when the name isn't known.
+func ProtocolOf(name string) string {
+	return protocolOf(strings.ToLower(name))
+}
+
+func protocolOf(name string) string {
+	switch {
+	case httpProtocols[name]:
+		return ProtocolHTTP
+	case tcpProtocols[name]:
+		return ProtocolTCP
+	default:
+		return ProtocolUnknown
+	}
+}
@@ -0,0 +1,33 @@
+package forwarder
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDetectProtocol(t *testing.T) {
+	tests := []struct {
+		name        string
+		appProtocol string
+		port        int32
+		protocol    string
+		appName     string
+	}{
+		{"appProtocol", "kubernetes.io/h2c", 80, ProtocolHTTP, "h2c"},
+		{"appProtocol redis", "redis", 80, ProtocolTCP, "redis"},
+		{"name", "http", 8080, ProtocolHTTP, "http"},
+		{"name with suffix", "mysql-primary", 3307, ProtocolTCP, "mysql"},
+		{"grpc-web", "grpc-web", 8080, ProtocolHTTP, "grpc-web"},
+		{"well known port", "db", 5432, ProtocolTCP, "postgres"},
+		{"no name", "", 6379, ProtocolTCP, "redis"},
+		{"unknown", "api", 8080, ProtocolUnknown, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			protocol, appName := DetectProtocol(tt.appProtocol, tt.port)
+			assert.Equal(t, tt.protocol, protocol)
+			assert.Equal(t, tt.appName, appName)
+		})
+	}
+}
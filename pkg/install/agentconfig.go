@@ -0,0 +1,127 @@
+package install
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/forwarder"
+)
+
+// The pod annotations that override the settings of the traffic-agent of a workload
+const (
+	// AgentPortAnnotation is the port that the traffic-agent listens to, for workloads where the
+	// default port of the agent is taken
+	AgentPortAnnotation = DomainPrefix + "inject-agent-port"
+
+	// AgentLogLevelAnnotation is the log level of the traffic-agent
+	AgentLogLevelAnnotation = DomainPrefix + "inject-agent-log-level"
+
+	// AppProtocolAnnotation is the application protocol of the intercepted port, e.g. "http" or
+	// "redis", for workloads where it can't be determined from the service port
+	AppProtocolAnnotation = DomainPrefix + "inject-app-protocol"
+
+	// AgentMountsAnnotation is a comma separated list of the names of the volumes of the app
+	// container that the traffic-agent shares with intercepting clients. All volumes are shared
+	// when the annotation is absent, and none when it's empty.
+	AgentMountsAnnotation = DomainPrefix + "inject-agent-mounts"
+)
+
+var agentLogLevels = map[string]bool{
+	"trace":   true,
+	"debug":   true,
+	"info":    true,
+	"warning": true,
+	"warn":    true,
+	"error":   true,
+}
+
+// AgentOverrides are the settings of the traffic-agent that the annotations of a pod override
+type AgentOverrides struct {
+	// Port is the port of the traffic-agent, or zero when it's not overridden
+	Port int32
+
+	// LogLevel is the log level of the traffic-agent, or empty when it's not overridden
+	LogLevel string
+
+	// AppProtocol is the application protocol of the intercepted port, or empty when it's not
+	// overridden
+	AppProtocol string
+
+	// Mounts are the names of the volumes to share, or nil when all volumes are shared
+	Mounts []string
+}
+
+// GetAgentOverrides returns the overrides that the given pod annotations declare.
+func GetAgentOverrides(annotations map[string]string) (*AgentOverrides, error) {
+	o := &AgentOverrides{}
+	if v, ok := annotations[AgentPortAnnotation]; ok {
+		port, err := strconv.ParseUint(v, 10, 16)
+		if err != nil || port == 0 {
+			return nil, fmt.Errorf("annotation %s: %q is not a valid port number", AgentPortAnnotation, v)
+		}
+		o.Port = int32(port)
+	}
+	if v, ok := annotations[AgentLogLevelAnnotation]; ok {
+		v = strings.ToLower(v)
+		if !agentLogLevels[v] {
+			return nil, fmt.Errorf("annotation %s: %q is not a valid log level", AgentLogLevelAnnotation, v)
+		}
+		o.LogLevel = v
+	}
+	if v, ok := annotations[AppProtocolAnnotation]; ok {
+		v = strings.ToLower(v)
+		if forwarder.ProtocolOf(v) == forwarder.ProtocolUnknown {
+			return nil, fmt.Errorf("annotation %s: %q is not a known protocol", AppProtocolAnnotation, v)
+		}
+		o.AppProtocol = v
+	}
+	if v, ok := annotations[AgentMountsAnnotation]; ok {
+		o.Mounts = []string{}
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				o.Mounts = append(o.Mounts, name)
+			}
+		}
+	}
+	return o, nil
+}
+
+// AppContainer returns the given app container when all its volumes are shared, or else a copy of
+// it that only mounts the volumes that are shared.
+func (o *AgentOverrides) AppContainer(cn *corev1.Container) *corev1.Container {
+	if o.Mounts == nil {
+		return cn
+	}
+	cc := *cn
+	cc.VolumeMounts = nil
+	for _, vm := range cn.VolumeMounts {
+		for _, name := range o.Mounts {
+			if vm.Name == name {
+				cc.VolumeMounts = append(cc.VolumeMounts, vm)
+				break
+			}
+		}
+	}
+	return &cc
+}
+
+// Apply applies the port, log level, and protocol overrides to the given traffic-agent container. It
+// must be applied before the container is adapted to the pod network, which may change the port
+// again.
+func (o *AgentOverrides) Apply(agentContainer *corev1.Container) {
+	if o.Port != 0 {
+		agentContainer.Ports[0].ContainerPort = o.Port
+		if o.Port != DefaultAgentPort {
+			setEnv(agentContainer, corev1.EnvVar{Name: "AGENT_PORT", Value: strconv.Itoa(int(o.Port))})
+		}
+	}
+	if o.LogLevel != "" {
+		setEnv(agentContainer, corev1.EnvVar{Name: "LOG_LEVEL", Value: o.LogLevel})
+	}
+	if o.AppProtocol != "" {
+		setEnv(agentContainer, corev1.EnvVar{Name: "APP_PROTOCOL", Value: o.AppProtocol})
+	}
+}
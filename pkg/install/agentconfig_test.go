@@ -0,0 +1,85 @@
+package install
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/forwarder"
+)
+
+func TestGetAgentOverrides(t *testing.T) {
+	o, err := GetAgentOverrides(nil)
+	require.NoError(t, err)
+	assert.Equal(t, &AgentOverrides{}, o)
+
+	o, err = GetAgentOverrides(map[string]string{
+		AgentPortAnnotation:     "9901",
+		AgentLogLevelAnnotation: "Info",
+		AppProtocolAnnotation:   "redis",
+		AgentMountsAnnotation:   "config, data",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, &AgentOverrides{Port: 9901, LogLevel: "info", AppProtocol: "redis", Mounts: []string{"config", "data"}}, o)
+	assert.Equal(t, forwarder.ProtocolTCP, forwarder.ProtocolOf(o.AppProtocol))
+
+	// An empty list of mounts shares no volumes
+	o, err = GetAgentOverrides(map[string]string{AgentMountsAnnotation: ""})
+	require.NoError(t, err)
+	assert.Equal(t, []string{}, o.Mounts)
+
+	for k, v := range map[string]string{
+		AgentPortAnnotation:     "99000",
+		AgentLogLevelAnnotation: "verbose",
+		AppProtocolAnnotation:   "carrier-pigeon",
+	} {
+		_, err = GetAgentOverrides(map[string]string{k: v})
+		assert.Error(t, err, k)
+	}
+}
+
+func TestAgentOverrides(t *testing.T) {
+	app := &corev1.Container{
+		Name: "app",
+		VolumeMounts: []corev1.VolumeMount{
+			{Name: "config", MountPath: "/etc/app"},
+			{Name: "data", MountPath: "/var/lib/app"},
+		},
+	}
+	o := &AgentOverrides{}
+	assert.Same(t, app, o.AppContainer(app))
+
+	o = &AgentOverrides{Port: 9901, LogLevel: "info", Mounts: []string{"data"}}
+	shared := o.AppContainer(app)
+	assert.Equal(t, []corev1.VolumeMount{{Name: "data", MountPath: "/var/lib/app"}}, shared.VolumeMounts)
+	assert.Len(t, app.VolumeMounts, 2, "the app container must not change")
+
+	agent := AgentContainer("echo", "tel2", shared, corev1.ContainerPort{Name: "http", ContainerPort: DefaultAgentPort}, 8080, "ambassador")
+	o.Apply(&agent)
+	assert.Equal(t, int32(9901), agent.Ports[0].ContainerPort)
+	ev, ok := envValue(&agent, "AGENT_PORT")
+	require.True(t, ok)
+	assert.Equal(t, "9901", ev.Value)
+	ev, ok = envValue(&agent, "LOG_LEVEL")
+	require.True(t, ok)
+	assert.Equal(t, "info", ev.Value)
+	ev, ok = envValue(&agent, envPrefix+"TELEPRESENCE_MOUNTS")
+	require.True(t, ok)
+	assert.Equal(t, "/var/lib/app", ev.Value)
+
+	// The protocol of the annotation overrides the one of the service port
+	SetAgentAppProtocol(&agent, &corev1.ServicePort{Name: "api", Port: 80})
+	_, ok = envValue(&agent, "APP_PROTOCOL")
+	assert.False(t, ok, "the name of the port isn't a protocol")
+	SetAgentAppProtocol(&agent, &corev1.ServicePort{Name: "http", Port: 80})
+	ev, ok = envValue(&agent, "APP_PROTOCOL")
+	require.True(t, ok)
+	assert.Equal(t, "http", ev.Value)
+	o = &AgentOverrides{AppProtocol: "redis"}
+	o.Apply(&agent)
+	ev, ok = envValue(&agent, "APP_PROTOCOL")
+	require.True(t, ok)
+	assert.Equal(t, "redis", ev.Value)
+}
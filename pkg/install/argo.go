@@ -0,0 +1,32 @@
+package install
+
+import (
+	errors2 "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/rest"
+)
+
+// ArgoRolloutAPIVersion is the API version of the Argo Rollouts Rollout kind
+const ArgoRolloutAPIVersion = "argoproj.io/v1alpha1"
+
+// IsArgoRollouts returns true if the cluster serves the Argo Rollouts API. Other Argo projects
+// serve the same API version, so it's the rollouts resource that is looked for.
+func IsArgoRollouts(config *rest.Config) (bool, error) {
+	dc, err := discovery.NewDiscoveryClientForConfig(config)
+	if err != nil {
+		return false, err
+	}
+	rl, err := dc.ServerResourcesForGroupVersion(ArgoRolloutAPIVersion)
+	if err != nil {
+		if errors2.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	for _, r := range rl.APIResources {
+		if r.Name == "rollouts" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -9,7 +9,60 @@ const (
 	ServicePortAnnotation     = DomainPrefix + "inject-service-port"
 	ManagerAppName            = "traffic-manager"
 	ManagerPortHTTP           = 8081
+	ManagerPortHTTPS          = 8082
+	ManagerTLSName            = "traffic-manager-tls"
+	ManagerClientTLSName      = "traffic-manager-client-tls"
+	InterceptPolicyName       = "traffic-manager-intercept-policy"
+	AuthTokensName            = "traffic-manager-auth-tokens"
 	MutatorWebhookPortHTTPS   = 8443
 	MutatorWebhookTLSName     = "mutator-webhook-tls"
 	TelAppMountPoint          = "/tel_app_mounts"
+
+	// ManagedByLabel tells what created a resource. Resources that the traffic-manager
+	// installation of the user daemon creates have the value ManagedByTelepresence, and
+	// resources of a Helm release have the value "Helm".
+	ManagedByLabel        = "app.kubernetes.io/managed-by"
+	ManagedByTelepresence = "telepresence"
+
+	// ManagerNamespaceLabel holds the namespace of the traffic-manager that a resource was created
+	// for, so that the resources of different installations in one cluster can be told apart and
+	// removed together.
+	ManagerNamespaceLabel = DomainPrefix + "manager-namespace"
+
+	// ManagerSelector selects the Deployment and the pods of a traffic-manager
+	ManagerSelector = "app=" + ManagerAppName + ",telepresence=manager"
+
+	// InterceptAnnotation set to InterceptForbidden on a workload, or on its pod template, marks the
+	// workload as one that must never be intercepted. The traffic-manager won't inject a
+	// traffic-agent into its pods nor accept intercepts of it, and clients refuse to intercept it.
+	InterceptAnnotation = DomainPrefix + "intercept"
+	InterceptForbidden  = "forbidden"
+
+	// JobCopyLabel selects the pods of a copy of a Job or CronJob that "telepresence intercept-job"
+	// creates. It's the only label of those pods, so that they're never selected by the services
+	// of the job or adopted by the job itself.
+	JobCopyLabel = DomainPrefix + "job-copy"
+
+	// JobCopyOfAnnotation tells which Job or CronJob a job copy was created from, as "<kind>/<name>"
+	JobCopyOfAnnotation = DomainPrefix + "job-copy-of"
+
+	// JobCopySessionAnnotation holds the session ID of the client that created a job copy. The
+	// traffic-manager removes the copies of sessions that have ended.
+	JobCopySessionAnnotation = DomainPrefix + "job-copy-session"
+
+	// JobCopyResumeAnnotation is "true" when the CronJob of a job copy was suspended for the
+	// duration of the copy, and must be resumed when the copy is removed.
+	JobCopyResumeAnnotation = DomainPrefix + "job-copy-resume"
 )
+
+// OwnerLabels returns the labels of the resources that are created for the traffic-manager in the
+// given namespace, with the given extra labels added.
+func OwnerLabels(managerNamespace string, extra map[string]string) map[string]string {
+	labels := make(map[string]string, len(extra)+2)
+	for k, v := range extra {
+		labels[k] = v
+	}
+	labels[ManagedByLabel] = ManagedByTelepresence
+	labels[ManagerNamespaceLabel] = managerNamespace
+	return labels
+}
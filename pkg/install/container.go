@@ -8,6 +8,7 @@ import (
 	corev1 "k8s.io/api/core/v1"
 
 	"github.com/datawire/ambassador/pkg/kates"
+	"github.com/telepresenceio/telepresence/v2/pkg/forwarder"
 )
 
 const envPrefix = "TEL_APP_"
@@ -123,6 +124,31 @@ func agentVolumeMounts(mounts []corev1.VolumeMount) []corev1.VolumeMount {
 	return agentMounts
 }
 
+// MakeAgentMountsReadOnly makes the given agent container share the volumes of the app container
+// read-only, and tells the agent to refuse all writes to them.
+func MakeAgentMountsReadOnly(agentContainer *corev1.Container) {
+	for i := range agentContainer.VolumeMounts {
+		agentContainer.VolumeMounts[i].ReadOnly = true
+	}
+	agentContainer.Env = append(agentContainer.Env, corev1.EnvVar{
+		Name:  "APP_MOUNTS_READ_ONLY",
+		Value: "true",
+	})
+}
+
+// SetAgentAppProtocol tells the given agent container the application protocol of the given service
+// port, which is its appProtocol, or else its name, when it names a known protocol. The agent uses
+// it to tell whether the port carries HTTP.
+func SetAgentAppProtocol(agentContainer *corev1.Container, svcPort *corev1.ServicePort) {
+	protocol := svcPort.Name
+	if svcPort.AppProtocol != nil && *svcPort.AppProtocol != "" {
+		protocol = *svcPort.AppProtocol
+	}
+	if p, _ := forwarder.DetectProtocol(protocol, 0); p != forwarder.ProtocolUnknown {
+		setEnv(agentContainer, corev1.EnvVar{Name: "APP_PROTOCOL", Value: protocol})
+	}
+}
+
 func appEnvironment(appContainer *kates.Container) []corev1.EnvVar {
 	envCopy := make([]corev1.EnvVar, len(appContainer.Env)+1)
 	for i, ev := range appContainer.Env {
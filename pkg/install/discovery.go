@@ -59,7 +59,13 @@ func FindMatchingServices(c context.Context, client *kates.Client, portNameOrNum
 	if err := client.List(c, kates.Query{Name: svcName, Kind: "Service", Namespace: namespace}, &svcs); err != nil {
 		return nil, err
 	}
+	return FilterMatchingServices(svcs, portNameOrNumber, svcName, labels), nil
+}
 
+// FilterMatchingServices returns the services from the given slice that have the given name, unless
+// it's empty, a selector that matches the given labels, and a port with the given name or number,
+// unless it's empty.
+func FilterMatchingServices(svcs []*kates.Service, portNameOrNumber, svcName string, labels map[string]string) []*kates.Service {
 	// Returns true if selector is completely included in labels
 	labelsMatch := func(selector map[string]string) bool {
 		if len(selector) == 0 || len(labels) < len(selector) {
@@ -79,7 +85,7 @@ func FindMatchingServices(c context.Context, client *kates.Client, portNameOrNum
 			matching = append(matching, svc)
 		}
 	}
-	return matching, nil
+	return matching
 }
 
 func FindMatchingService(c context.Context, client *kates.Client, portNameOrNumber, svcName, namespace string, labels map[string]string) (*kates.Service, error) {
@@ -147,7 +153,15 @@ Please specify the Service port you want to intercept by passing the --port=loca
 				}
 			}
 		}
-	} else {
+		if n, ok := ParseNumericPortName(portName); ok && matchingContainer == nil {
+			// The service was redirected to a traffic-agent that isn't injected into these
+			// containers yet, so the port is the number that the service referred to before.
+			numPort := *port
+			numPort.TargetPort = intstr.FromInt(int(n))
+			port = &numPort
+		}
+	}
+	if port.TargetPort.Type == intstr.Int {
 		portNum := port.TargetPort.IntVal
 		// Here we are using containerPortIndex <=0 instead of matchingContainer == nil because if a
 		// container has no ports, we want to use it but we don't want
@@ -170,6 +184,12 @@ Please specify the Service port you want to intercept by passing the --port=loca
 				}
 			}
 		}
+		if matchingServicePort == nil && len(cns) == 1 {
+			// The port isn't declared, but the only container is the one that listens to it
+			matchingServicePort = port
+			matchingContainer = &cns[0]
+			containerPortIndex = -1
+		}
 	}
 
 	if matchingServicePort == nil {
@@ -177,3 +197,27 @@ Please specify the Service port you want to intercept by passing the --port=loca
 	}
 	return matchingServicePort, matchingContainer, containerPortIndex, nil
 }
+
+// numericPortPrefix is the prefix of the NumericPortName of a port
+const numericPortPrefix = "tx-"
+
+// NumericPortName returns the name of the traffic-agent port that takes over the given app port
+// from a service that refers to it by number. A numeric port can't be taken over by renaming the
+// port of the app container, so the service is made to refer to the agent port by this name
+// instead.
+func NumericPortName(port int32) string {
+	return numericPortPrefix + strconv.Itoa(int(port))
+}
+
+// ParseNumericPortName returns the app port of the given NumericPortName, or false if the name
+// isn't one.
+func ParseNumericPortName(name string) (int32, bool) {
+	if !strings.HasPrefix(name, numericPortPrefix) {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(name[len(numericPortPrefix):], 10, 16)
+	if err != nil || n == 0 {
+		return 0, false
+	}
+	return int32(n), true
+}
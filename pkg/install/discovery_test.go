@@ -0,0 +1,47 @@
+package install
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	"github.com/datawire/ambassador/pkg/kates"
+)
+
+func TestNumericPortName(t *testing.T) {
+	assert.Equal(t, "tx-8080", NumericPortName(8080))
+	n, ok := ParseNumericPortName("tx-8080")
+	assert.True(t, ok)
+	assert.Equal(t, int32(8080), n)
+	for _, name := range []string{"http", "tx-", "tx-0", "tx-http", "tx-70000"} {
+		_, ok = ParseNumericPortName(name)
+		assert.False(t, ok, name)
+	}
+}
+
+func TestFindMatchingPortOfNumericPortName(t *testing.T) {
+	cns := []corev1.Container{
+		{Name: "sidecar", Ports: []corev1.ContainerPort{{ContainerPort: 9000}}},
+		{Name: "hello", Ports: []corev1.ContainerPort{{ContainerPort: 8080}}},
+	}
+	svc := &kates.Service{}
+	svc.Spec.Ports = []kates.ServicePort{{Name: "http", Port: 80, TargetPort: intstr.FromString(NumericPortName(8080))}}
+
+	// The service was redirected to an agent that isn't in the containers yet
+	sp, cn, pi, err := FindMatchingPort(cns, "", svc)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", cn.Name)
+	assert.Equal(t, 0, pi)
+	assert.Equal(t, intstr.FromInt(8080), sp.TargetPort)
+	assert.Equal(t, intstr.FromString("tx-8080"), svc.Spec.Ports[0].TargetPort, "the service isn't modified")
+
+	// The agent is in the containers
+	cns = append(cns, corev1.Container{Name: AgentContainerName, Ports: []corev1.ContainerPort{{Name: "tx-8080", ContainerPort: 9900}}})
+	sp, cn, _, err = FindMatchingPort(cns, "", svc)
+	require.NoError(t, err)
+	assert.Equal(t, AgentContainerName, cn.Name)
+	assert.Equal(t, intstr.FromString("tx-8080"), sp.TargetPort)
+}
@@ -0,0 +1,35 @@
+package install
+
+import (
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/datawire/ambassador/pkg/kates"
+)
+
+// InterceptsForbidden returns true if the InterceptAnnotation of the given workload, or of its pod
+// template, is InterceptForbidden.
+func InterceptsForbidden(obj kates.Object) bool {
+	if obj.GetAnnotations()[InterceptAnnotation] == InterceptForbidden {
+		return true
+	}
+	var tplAnnotations map[string]string
+	switch o := obj.(type) {
+	case *kates.Deployment:
+		tplAnnotations = o.Spec.Template.Annotations
+	case *kates.ReplicaSet:
+		tplAnnotations = o.Spec.Template.Annotations
+	case *kates.StatefulSet:
+		tplAnnotations = o.Spec.Template.Annotations
+	case *kates.Unstructured:
+		// DeploymentConfigs, Knative Services, and workloads that the traffic-manager reads
+		tplAnnotations, _, _ = unstructured.NestedStringMap(o.Object, "spec", "template", "metadata", "annotations")
+	}
+	return tplAnnotations[InterceptAnnotation] == InterceptForbidden
+}
+
+// InterceptsForbiddenError returns the error that explains that the given workload can't be
+// intercepted because InterceptsForbidden is true for it.
+func InterceptsForbiddenError(obj kates.Object) error {
+	return ObjErrorf(obj, "cannot be intercepted, because its owners have forbidden it using the annotation %s: %s",
+		InterceptAnnotation, InterceptForbidden)
+}
@@ -0,0 +1,37 @@
+package install
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/datawire/ambassador/pkg/kates"
+)
+
+func TestInterceptsForbidden(t *testing.T) {
+	forbidden := map[string]string{InterceptAnnotation: InterceptForbidden}
+
+	dep := &kates.Deployment{}
+	assert.False(t, InterceptsForbidden(dep))
+	dep.Annotations = forbidden
+	assert.True(t, InterceptsForbidden(dep))
+
+	sts := &kates.StatefulSet{}
+	sts.Spec.Template.Annotations = map[string]string{InterceptAnnotation: "allowed"}
+	assert.False(t, InterceptsForbidden(sts))
+	sts.Spec.Template.Annotations = forbidden
+	assert.True(t, InterceptsForbidden(sts))
+
+	dc := &kates.Unstructured{Object: map[string]interface{}{
+		"kind": "DeploymentConfig",
+		"spec": map[string]interface{}{
+			"template": map[string]interface{}{
+				"metadata": map[string]interface{}{
+					"annotations": map[string]interface{}{InterceptAnnotation: InterceptForbidden},
+				},
+			},
+		},
+	}}
+	assert.True(t, InterceptsForbidden(dc))
+	assert.Contains(t, InterceptsForbiddenError(dc).Error(), InterceptAnnotation+": "+InterceptForbidden)
+}
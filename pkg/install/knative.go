@@ -0,0 +1,27 @@
+package install
+
+import (
+	"k8s.io/client-go/rest"
+)
+
+const (
+	// KnativeServiceAPIVersion is the API version of the Knative Serving Service kind
+	KnativeServiceAPIVersion = "serving.knative.dev/v1"
+
+	// KnativeServiceLabel is the label that Knative adds to the pods of the revisions of a Knative
+	// Service. Its value is the name of that Knative Service.
+	KnativeServiceLabel = "serving.knative.dev/service"
+
+	// KnativeMinScaleAnnotation is the revision template annotation that tells the Knative
+	// autoscaler how many pods a revision must keep when it's idle.
+	KnativeMinScaleAnnotation = "autoscaling.knative.dev/minScale"
+
+	// KnativeQueueProxyName is the name of the container that Knative adds to the pods of a revision
+	// to proxy the traffic to the user container.
+	KnativeQueueProxyName = "queue-proxy"
+)
+
+// IsKnative returns true if the cluster serves the Knative Serving API.
+func IsKnative(config *rest.Config) (bool, error) {
+	return servesAPI(config, KnativeServiceAPIVersion)
+}
@@ -0,0 +1,59 @@
+package install
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	errors2 "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/rest"
+)
+
+// DeploymentConfigAPIVersion is the API version of the OpenShift DeploymentConfig kind
+const DeploymentConfigAPIVersion = "apps.openshift.io/v1"
+
+// IsOpenShift returns true if the cluster serves the OpenShift apps API.
+func IsOpenShift(config *rest.Config) (bool, error) {
+	return servesAPI(config, DeploymentConfigAPIVersion)
+}
+
+// servesAPI returns true if the cluster serves the given API version.
+func servesAPI(config *rest.Config, apiVersion string) (bool, error) {
+	dc, err := discovery.NewDiscoveryClientForConfig(config)
+	if err != nil {
+		return false, err
+	}
+	if _, err = dc.ServerResourcesForGroupVersion(apiVersion); err != nil {
+		if errors2.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// RestrictedSecurityContext returns a security context for the traffic-agent that the OpenShift
+// restricted SCCs accept. No user ID is set, so the agent runs with the UID that the SCC assigns.
+func RestrictedSecurityContext() *corev1.SecurityContext {
+	no := false
+	yes := true
+	return &corev1.SecurityContext{
+		AllowPrivilegeEscalation: &no,
+		RunAsNonRoot:             &yes,
+		Capabilities: &corev1.Capabilities{
+			Drop: []corev1.Capability{"ALL"},
+		},
+		SeccompProfile: &corev1.SeccompProfile{
+			Type: corev1.SeccompProfileTypeRuntimeDefault,
+		},
+	}
+}
+
+// SetAgentSecurityContext sets the RestrictedSecurityContext on the traffic-agent container of the
+// given pod template.
+func SetAgentSecurityContext(tplSpec *corev1.PodTemplateSpec) {
+	cns := tplSpec.Spec.Containers
+	for i := range cns {
+		if cns[i].Name == AgentContainerName {
+			cns[i].SecurityContext = RestrictedSecurityContext()
+		}
+	}
+}
@@ -0,0 +1,297 @@
+package install
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// The well-known node labels that hold the operating system and the architecture of a node, and
+// the deprecated labels that older nodes have instead
+const (
+	NodeOSLabel       = "kubernetes.io/os"
+	NodeArchLabel     = "kubernetes.io/arch"
+	BetaNodeOSLabel   = "beta.kubernetes.io/os"
+	BetaNodeArchLabel = "beta.kubernetes.io/arch"
+)
+
+// AgentOS is the operating system that the traffic-agent image runs on
+const AgentOS = "linux"
+
+// AgentArchitectures are the architectures that the traffic-agent image is built for. They must
+// match the platforms that build-aux/main.mk publishes the image for.
+var AgentArchitectures = []string{"amd64", "arm64"}
+
+// AgentImageVariantsEnv is the environment variable of the traffic-manager that holds the image
+// variants, see ParseAgentImageVariants
+const AgentImageVariantsEnv = "TELEPRESENCE_AGENT_IMAGE_VARIANTS"
+
+// ParseAgentImageVariants parses "<arch>=<image>" pairs that name the agent image to use for pods
+// that can only run on nodes with the given architecture, and returns the images by architecture,
+// with the registry prepended to them.
+func ParseAgentImageVariants(registry string, variants []string) (map[string]string, error) {
+	var images map[string]string
+	for _, v := range variants {
+		eq := strings.IndexByte(v, '=')
+		if eq <= 0 || eq == len(v)-1 {
+			return nil, fmt.Errorf("invalid %s entry %q, expected <arch>=<image>", AgentImageVariantsEnv, v)
+		}
+		if images == nil {
+			images = make(map[string]string)
+		}
+		images[v[:eq]] = registry + "/" + v[eq+1:]
+	}
+	return images, nil
+}
+
+// AgentImageArchitectures returns the node architectures that the traffic-agent image or one of
+// the given variants is available for
+func AgentImageArchitectures(variants map[string]string) []string {
+	var extra []string
+	for arch := range variants {
+		if !containsAny(AgentArchitectures, arch) {
+			extra = append(extra, arch)
+		}
+	}
+	sort.Strings(extra)
+	return append(append([]string{}, AgentArchitectures...), extra...)
+}
+
+// CheckAgentPlatform returns an error if a pod with the given spec can only be scheduled on nodes
+// that the traffic-agent can't run on, because of their operating system or because their
+// architecture isn't one of the given architectures. A traffic-agent on such a node would never
+// start, so it's better to not inject it at all. The nodes are the nodes of the cluster, or nil
+// when they can't be listed, see podNodeLabelValues.
+func CheckAgentPlatform(spec *corev1.PodSpec, archs []string, nodes []corev1.Node) error {
+	if oss := podNodeLabelValues(spec, nodes, NodeOSLabel, BetaNodeOSLabel); oss != nil && !containsAny(oss, AgentOS) {
+		return fmt.Errorf("the pod can only run on %s nodes, but the %s only runs on %s nodes; "+
+			"workloads on nodes with another operating system can't be intercepted",
+			strings.Join(oss, " or "), AgentContainerName, AgentOS)
+	}
+	if as := podNodeLabelValues(spec, nodes, NodeArchLabel, BetaNodeArchLabel); as != nil && !containsAny(as, archs...) {
+		return fmt.Errorf("the pod can only run on %s nodes, but the %s image is only available for %s; "+
+			"add an image variant for the architecture to the traffic-manager",
+			strings.Join(as, " or "), AgentContainerName, strings.Join(archs, " and "))
+	}
+	return nil
+}
+
+// AgentImageForPod returns the image variant for the architecture that a pod with the given spec
+// is restricted to, or the given image when the pod isn't restricted to one architecture or
+// when there's no variant for it. The image is then expected to be a multi-architecture image.
+func AgentImageForPod(spec *corev1.PodSpec, image string, variants map[string]string, nodes []corev1.Node) string {
+	if len(variants) == 0 {
+		return image
+	}
+	if as := podNodeLabelValues(spec, nodes, NodeArchLabel, BetaNodeArchLabel); len(as) == 1 {
+		if variant, ok := variants[as[0]]; ok {
+			return variant
+		}
+	}
+	return image
+}
+
+// podNodeLabelValues returns the values of the given node label, or of its deprecated alias, that
+// a pod with the given spec is restricted to, or nil when the pod can be scheduled regardless of
+// the label.
+//
+// When the nodes of the cluster are known, they are the values of the nodes that the pod can be
+// scheduled on according to its nodeName, nodeSelector, required node affinity, and tolerations.
+// Otherwise, they are derived from the nodeSelector and the In expressions of the required node
+// affinity of the pod.
+func podNodeLabelValues(spec *corev1.PodSpec, nodes []corev1.Node, label, betaLabel string) []string {
+	var values []string
+	fits := false
+	for i := range nodes {
+		node := &nodes[i]
+		if !podFitsNode(spec, node) {
+			continue
+		}
+		fits = true
+		v, ok := node.Labels[label]
+		if !ok {
+			if v, ok = node.Labels[betaLabel]; !ok {
+				// A node without the label can be anything
+				return nil
+			}
+		}
+		if !containsAny(values, v) {
+			values = append(values, v)
+		}
+	}
+	if fits {
+		return values
+	}
+	values = nodeLabelValues(spec, label)
+	if betaValues := nodeLabelValues(spec, betaLabel); betaValues != nil {
+		if values == nil {
+			return betaValues
+		}
+		return intersect(values, betaValues)
+	}
+	return values
+}
+
+// podFitsNode returns true if the scheduler may place a pod with the given spec on the given node.
+// Only the constraints of the pod are checked, not the resources of the node.
+func podFitsNode(spec *corev1.PodSpec, node *corev1.Node) bool {
+	if spec.NodeName != "" {
+		// The pod bypasses the scheduler
+		return spec.NodeName == node.Name
+	}
+	if node.Spec.Unschedulable {
+		return false
+	}
+	for k, v := range spec.NodeSelector {
+		if node.Labels[k] != v {
+			return false
+		}
+	}
+	for i := range node.Spec.Taints {
+		taint := &node.Spec.Taints[i]
+		if taint.Effect == corev1.TaintEffectPreferNoSchedule {
+			continue
+		}
+		tolerated := false
+		for j := range spec.Tolerations {
+			if spec.Tolerations[j].ToleratesTaint(taint) {
+				tolerated = true
+				break
+			}
+		}
+		if !tolerated {
+			return false
+		}
+	}
+	if a := spec.Affinity; a != nil && a.NodeAffinity != nil {
+		if sel := a.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution; sel != nil {
+			// The terms are ORed
+			for i := range sel.NodeSelectorTerms {
+				if termMatchesNode(&sel.NodeSelectorTerms[i], node) {
+					return true
+				}
+			}
+			return false
+		}
+	}
+	return true
+}
+
+// termMatchesNode returns true if the given node matches all expressions and fields of the given
+// term. A term without expressions and fields matches no node.
+func termMatchesNode(term *corev1.NodeSelectorTerm, node *corev1.Node) bool {
+	if len(term.MatchExpressions) == 0 && len(term.MatchFields) == 0 {
+		return false
+	}
+	for i := range term.MatchExpressions {
+		if !requirementMatches(&term.MatchExpressions[i], node.Labels) {
+			return false
+		}
+	}
+	for i := range term.MatchFields {
+		// metadata.name is the only field that the scheduler supports
+		req := &term.MatchFields[i]
+		if req.Key != "metadata.name" || !requirementMatches(req, map[string]string{req.Key: node.Name}) {
+			return false
+		}
+	}
+	return true
+}
+
+func requirementMatches(req *corev1.NodeSelectorRequirement, labels map[string]string) bool {
+	v, ok := labels[req.Key]
+	switch req.Operator {
+	case corev1.NodeSelectorOpIn:
+		return ok && containsAny(req.Values, v)
+	case corev1.NodeSelectorOpNotIn:
+		return !ok || !containsAny(req.Values, v)
+	case corev1.NodeSelectorOpExists:
+		return ok
+	case corev1.NodeSelectorOpDoesNotExist:
+		return !ok
+	case corev1.NodeSelectorOpGt, corev1.NodeSelectorOpLt:
+		if !ok || len(req.Values) != 1 {
+			return false
+		}
+		nv, err1 := strconv.ParseInt(v, 10, 64)
+		rv, err2 := strconv.ParseInt(req.Values[0], 10, 64)
+		if err1 != nil || err2 != nil {
+			return false
+		}
+		if req.Operator == corev1.NodeSelectorOpGt {
+			return nv > rv
+		}
+		return nv < rv
+	default:
+		return false
+	}
+}
+
+// nodeLabelValues returns the values of the given node label that the nodeSelector and the
+// required node affinity of the given pod spec restrict the pod to, or nil when the pod can be
+// scheduled regardless of the label.
+func nodeLabelValues(spec *corev1.PodSpec, label string) []string {
+	var values []string
+	if v, ok := spec.NodeSelector[label]; ok {
+		values = []string{v}
+	}
+	if a := spec.Affinity; a != nil && a.NodeAffinity != nil {
+		if sel := a.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution; sel != nil && len(sel.NodeSelectorTerms) > 0 {
+			// The terms are ORed, so the affinity only restricts the label when all terms do
+			var termValues []string
+			for i := range sel.NodeSelectorTerms {
+				tv := termLabelValues(&sel.NodeSelectorTerms[i], label)
+				if tv == nil {
+					return values
+				}
+				termValues = append(termValues, tv...)
+			}
+			if values == nil {
+				return termValues
+			}
+			return intersect(values, termValues)
+		}
+	}
+	return values
+}
+
+// termLabelValues returns the values of the given label that the given term restricts the label
+// to, or nil when the term doesn't restrict it. The expressions of a term are ANDed.
+func termLabelValues(term *corev1.NodeSelectorTerm, label string) []string {
+	var values []string
+	for _, expr := range term.MatchExpressions {
+		if expr.Key != label || expr.Operator != corev1.NodeSelectorOpIn {
+			continue
+		}
+		if values == nil {
+			values = expr.Values
+		} else {
+			values = intersect(values, expr.Values)
+		}
+	}
+	return values
+}
+
+func intersect(a, b []string) []string {
+	r := []string{}
+	for _, v := range a {
+		if containsAny(b, v) {
+			r = append(r, v)
+		}
+	}
+	return r
+}
+
+func containsAny(values []string, wanted ...string) bool {
+	for _, v := range values {
+		for _, w := range wanted {
+			if v == w {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,146 @@
+package install
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func archAffinity(terms ...[]string) *corev1.Affinity {
+	sel := &corev1.NodeSelector{}
+	for _, values := range terms {
+		term := corev1.NodeSelectorTerm{}
+		if values != nil {
+			term.MatchExpressions = []corev1.NodeSelectorRequirement{{
+				Key:      NodeArchLabel,
+				Operator: corev1.NodeSelectorOpIn,
+				Values:   values,
+			}}
+		}
+		sel.NodeSelectorTerms = append(sel.NodeSelectorTerms, term)
+	}
+	return &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{RequiredDuringSchedulingIgnoredDuringExecution: sel}}
+}
+
+func TestCheckAgentPlatform(t *testing.T) {
+	tests := []struct {
+		name string
+		spec corev1.PodSpec
+		ok   bool
+	}{
+		{"unrestricted", corev1.PodSpec{}, true},
+		{"linux", corev1.PodSpec{NodeSelector: map[string]string{NodeOSLabel: "linux"}}, true},
+		{"windows", corev1.PodSpec{NodeSelector: map[string]string{NodeOSLabel: "windows"}}, false},
+		{"arm64", corev1.PodSpec{NodeSelector: map[string]string{NodeArchLabel: "arm64"}}, true},
+		{"s390x", corev1.PodSpec{NodeSelector: map[string]string{NodeArchLabel: "s390x"}}, false},
+		{"affinity s390x", corev1.PodSpec{Affinity: archAffinity([]string{"s390x"})}, false},
+		{"affinity s390x or amd64", corev1.PodSpec{Affinity: archAffinity([]string{"s390x"}, []string{"amd64"})}, true},
+		{"affinity s390x or any", corev1.PodSpec{Affinity: archAffinity([]string{"s390x"}, nil)}, true},
+		{"beta s390x", corev1.PodSpec{NodeSelector: map[string]string{BetaNodeArchLabel: "s390x"}}, false},
+		{"beta windows", corev1.PodSpec{NodeSelector: map[string]string{BetaNodeOSLabel: "windows"}}, false},
+		{"selector and affinity", corev1.PodSpec{
+			NodeSelector: map[string]string{NodeArchLabel: "s390x"},
+			Affinity:     archAffinity([]string{"s390x", "amd64"}),
+		}, false},
+	}
+	for _, tt := range tests {
+		err := CheckAgentPlatform(&tt.spec, AgentArchitectures, nil)
+		if tt.ok {
+			assert.NoError(t, err, tt.name)
+		} else {
+			assert.Error(t, err, tt.name)
+		}
+	}
+}
+
+func TestAgentImageForPod(t *testing.T) {
+	variants := map[string]string{"arm64": "tel2-arm64:2.3.6"}
+	assert.Equal(t, "tel2:2.3.6", AgentImageForPod(&corev1.PodSpec{}, "tel2:2.3.6", variants, nil))
+	assert.Equal(t, "tel2-arm64:2.3.6", AgentImageForPod(&corev1.PodSpec{
+		NodeSelector: map[string]string{NodeArchLabel: "arm64"},
+	}, "tel2:2.3.6", variants, nil))
+	assert.Equal(t, "tel2:2.3.6", AgentImageForPod(&corev1.PodSpec{
+		Affinity: archAffinity([]string{"arm64", "amd64"}),
+	}, "tel2:2.3.6", variants, nil))
+
+	// An unrestricted pod in a cluster that only has arm64 nodes
+	nodes := []corev1.Node{node("a", map[string]string{NodeArchLabel: "arm64"}), node("b", map[string]string{BetaNodeArchLabel: "arm64"})}
+	assert.Equal(t, "tel2-arm64:2.3.6", AgentImageForPod(&corev1.PodSpec{}, "tel2:2.3.6", variants, nodes))
+}
+
+func node(name string, labels map[string]string) corev1.Node {
+	n := corev1.Node{}
+	n.Name = name
+	n.Labels = labels
+	return n
+}
+
+func TestCheckAgentPlatformNodes(t *testing.T) {
+	amd := node("amd", map[string]string{NodeOSLabel: "linux", NodeArchLabel: "amd64", "pool": "default"})
+	s390x := node("s390x", map[string]string{NodeOSLabel: "linux", NodeArchLabel: "s390x", "pool": "mainframe"})
+	win := node("win", map[string]string{BetaNodeOSLabel: "windows", BetaNodeArchLabel: "amd64", "pool": "windows"})
+	win.Spec.Taints = []corev1.Taint{{Key: "os", Value: "windows", Effect: corev1.TaintEffectNoSchedule}}
+	cordoned := node("cordoned", map[string]string{NodeOSLabel: "linux", NodeArchLabel: "arm64", "pool": "default"})
+	cordoned.Spec.Unschedulable = true
+	nodes := []corev1.Node{amd, s390x, win, cordoned}
+
+	notIn := func(key string, values ...string) *corev1.Affinity {
+		return &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+			NodeSelectorTerms: []corev1.NodeSelectorTerm{{MatchExpressions: []corev1.NodeSelectorRequirement{{
+				Key: key, Operator: corev1.NodeSelectorOpNotIn, Values: values,
+			}}}},
+		}}}
+	}
+	windowsToleration := []corev1.Toleration{{Key: "os", Operator: corev1.TolerationOpEqual, Value: "windows", Effect: corev1.TaintEffectNoSchedule}}
+
+	tests := []struct {
+		name string
+		spec corev1.PodSpec
+		err  string
+	}{
+		{"unrestricted", corev1.PodSpec{}, ""},
+		{"pool", corev1.PodSpec{NodeSelector: map[string]string{"pool": "mainframe"}}, "can only run on s390x nodes"},
+		{"node name", corev1.PodSpec{NodeName: "s390x"}, "can only run on s390x nodes"},
+		{"not in", corev1.PodSpec{Affinity: notIn("pool", "default")}, "can only run on s390x nodes"},
+		{"not in, tolerating windows", corev1.PodSpec{Affinity: notIn("pool", "default"), Tolerations: windowsToleration}, ""},
+		{"windows", corev1.PodSpec{NodeSelector: map[string]string{"pool": "windows"}, Tolerations: windowsToleration}, "can only run on windows nodes"},
+		{"untolerated windows", corev1.PodSpec{NodeSelector: map[string]string{"pool": "windows"}}, ""},
+		{"field", corev1.PodSpec{Affinity: &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{NodeSelectorTerms: []corev1.NodeSelectorTerm{{
+				MatchFields: []corev1.NodeSelectorRequirement{{Key: "metadata.name", Operator: corev1.NodeSelectorOpIn, Values: []string{"s390x"}}},
+			}}},
+		}}}, "can only run on s390x nodes"},
+	}
+	for _, tt := range tests {
+		err := CheckAgentPlatform(&tt.spec, AgentArchitectures, nodes)
+		if tt.err == "" {
+			assert.NoError(t, err, tt.name)
+		} else if assert.Error(t, err, tt.name) {
+			assert.Contains(t, err.Error(), tt.err, tt.name)
+		}
+	}
+
+	// A variant for the architecture makes the pod acceptable
+	assert.NoError(t, CheckAgentPlatform(&corev1.PodSpec{NodeName: "s390x"},
+		AgentImageArchitectures(map[string]string{"s390x": "tel2-s390x"}), nodes))
+}
+
+func TestParseAgentImageVariants(t *testing.T) {
+	images, err := ParseAgentImageVariants("docker.io/datawire", []string{"s390x=tel2-s390x:2.3.6", "arm64=tel2-arm64:2.3.6"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"s390x": "docker.io/datawire/tel2-s390x:2.3.6",
+		"arm64": "docker.io/datawire/tel2-arm64:2.3.6",
+	}, images)
+	assert.Equal(t, []string{"amd64", "arm64", "s390x"}, AgentImageArchitectures(images))
+
+	images, err = ParseAgentImageVariants("docker.io/datawire", nil)
+	assert.NoError(t, err)
+	assert.Nil(t, images)
+
+	for _, v := range []string{"s390x", "=tel2", "s390x="} {
+		_, err = ParseAgentImageVariants("docker.io/datawire", []string{v})
+		assert.Error(t, err, v)
+	}
+}
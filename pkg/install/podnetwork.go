@@ -0,0 +1,127 @@
+package install
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// MultusNetworksAnnotation is the pod annotation that Multus uses to attach additional networks
+const MultusNetworksAnnotation = "k8s.v1.cni.cncf.io/networks"
+
+// DefaultAgentPort is the port that the traffic-agent listens on unless told otherwise
+const DefaultAgentPort = 9900
+
+// hostNetworkPorts is the number of ports, starting with the DefaultAgentPort, that the
+// traffic-agent of a pod that uses the network of its node can choose from.
+const hostNetworkPorts = 100
+
+// AdaptAgentToPodNetwork adapts the traffic-agent container to the network of the pod that it is
+// added to. Pods that use the network of their node share the ports with everything else on that
+// node, and unless their dnsPolicy is ClusterFirstWithHostNet, they can't resolve the names of
+// cluster services. Pods with additional networks attached by Multus may have an app that listens
+// on the pod IP only. An error is returned when the traffic of the pod can't be intercepted.
+//
+// The name is the name of the agent. It is used to spread the agents of different workloads that
+// use the node network over different ports, so that they can run on the same node. The managerIP
+// is the cluster IP of the traffic-manager service, used when the pod can't resolve its name.
+func AdaptAgentToPodNetwork(agent *corev1.Container, name string, annotations map[string]string, spec *corev1.PodSpec, managerIP string) error {
+	if len(agent.Ports) == 0 {
+		return fmt.Errorf("the %s has no port", AgentContainerName)
+	}
+	agentPort := &agent.Ports[0]
+	used := make(map[int32]string)
+	for i := range spec.Containers {
+		cn := &spec.Containers[i]
+		if cn.Name == AgentContainerName {
+			continue
+		}
+		for _, p := range cn.Ports {
+			used[p.ContainerPort] = cn.Name
+			if p.HostPort != 0 {
+				used[p.HostPort] = cn.Name
+			}
+		}
+	}
+
+	if spec.HostNetwork {
+		port, err := hostNetworkAgentPort(name, agentPort.ContainerPort, used)
+		if err != nil {
+			return err
+		}
+		agentPort.ContainerPort = port
+	} else if cn, ok := used[agentPort.ContainerPort]; ok {
+		return fmt.Errorf("port %d of container %q collides with the port of the %s",
+			agentPort.ContainerPort, cn, AgentContainerName)
+	}
+	if agentPort.ContainerPort != DefaultAgentPort {
+		setEnv(agent, corev1.EnvVar{Name: "AGENT_PORT", Value: strconv.Itoa(int(agentPort.ContainerPort))})
+	}
+
+	// A service routes traffic to the pod IP, which is the IP of the node for pods that use the
+	// node network, and the IP on the cluster network for pods with additional networks. Forward
+	// to that IP rather than to localhost, since the app might not listen on the loopback interface.
+	if _, multus := annotations[MultusNetworksAnnotation]; spec.HostNetwork || multus {
+		setEnv(agent, corev1.EnvVar{
+			Name: "APP_HOST",
+			ValueFrom: &corev1.EnvVarSource{
+				FieldRef: &corev1.ObjectFieldSelector{
+					FieldPath: "status.podIP",
+				},
+			},
+		})
+	}
+
+	if !ResolvesClusterNames(spec) {
+		if managerIP == "" {
+			return fmt.Errorf("the pod can't resolve the name of the %s service (hostNetwork: %t, dnsPolicy: %s), "+
+				"and its cluster IP is unknown", ManagerAppName, spec.HostNetwork, spec.DNSPolicy)
+		}
+		setEnv(agent, corev1.EnvVar{Name: "MANAGER_HOST", Value: managerIP})
+	}
+	return nil
+}
+
+// hostNetworkAgentPort returns the port that the traffic-agent uses in a pod that uses the network
+// of its node. The port is chosen from a range that starts with the given port, using the name of
+// the agent to pick the first candidate, and skipping the ports that are used by the pod.
+func hostNetworkAgentPort(name string, port int32, used map[int32]string) (int32, error) {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(name))
+	offset := int32(h.Sum32() % hostNetworkPorts)
+	for i := int32(0); i < hostNetworkPorts; i++ {
+		candidate := port + (offset+i)%hostNetworkPorts
+		if _, ok := used[candidate]; !ok {
+			return candidate, nil
+		}
+	}
+	return 0, fmt.Errorf("the pod uses the network of its node and all ports in the range %d-%d that the %s can use are taken",
+		port, port+hostNetworkPorts-1, AgentContainerName)
+}
+
+// ResolvesClusterNames returns true if the DNS of a pod with the given spec resolves the
+// names of cluster services.
+func ResolvesClusterNames(spec *corev1.PodSpec) bool {
+	switch spec.DNSPolicy {
+	case corev1.DNSClusterFirstWithHostNet:
+		return true
+	case "", corev1.DNSClusterFirst:
+		// ClusterFirst falls back to Default for pods that use the network of their node
+		return !spec.HostNetwork
+	default:
+		return false
+	}
+}
+
+// setEnv sets the given environment variable in the container, replacing an existing one with the same name
+func setEnv(cn *corev1.Container, ev corev1.EnvVar) {
+	for i := range cn.Env {
+		if cn.Env[i].Name == ev.Name {
+			cn.Env[i] = ev
+			return
+		}
+	}
+	cn.Env = append(cn.Env, ev)
+}
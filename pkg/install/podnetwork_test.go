@@ -0,0 +1,104 @@
+package install
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func envValue(cn *corev1.Container, name string) (*corev1.EnvVar, bool) {
+	for i := range cn.Env {
+		if cn.Env[i].Name == name {
+			return &cn.Env[i], true
+		}
+	}
+	return nil, false
+}
+
+func TestAdaptAgentToPodNetwork(t *testing.T) {
+	newAgent := func() *corev1.Container {
+		return &corev1.Container{
+			Name:  AgentContainerName,
+			Ports: []corev1.ContainerPort{{Name: "http", ContainerPort: DefaultAgentPort}},
+			Env:   []corev1.EnvVar{{Name: "MANAGER_HOST", Value: ManagerAppName + ".ambassador"}},
+		}
+	}
+	app := corev1.Container{Name: "app", Ports: []corev1.ContainerPort{{Name: "http", ContainerPort: 8080}}}
+
+	t.Run("pod network", func(t *testing.T) {
+		agent := newAgent()
+		spec := &corev1.PodSpec{Containers: []corev1.Container{app}}
+		require.NoError(t, AdaptAgentToPodNetwork(agent, "echo", nil, spec, ""))
+		assert.Equal(t, newAgent(), agent)
+	})
+
+	t.Run("port collision", func(t *testing.T) {
+		other := corev1.Container{Name: "other", Ports: []corev1.ContainerPort{{ContainerPort: DefaultAgentPort}}}
+		spec := &corev1.PodSpec{Containers: []corev1.Container{app, other}}
+		err := AdaptAgentToPodNetwork(newAgent(), "echo", nil, spec, "")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), `container "other"`)
+	})
+
+	t.Run("host network", func(t *testing.T) {
+		agent := newAgent()
+		spec := &corev1.PodSpec{HostNetwork: true, Containers: []corev1.Container{app}}
+		err := AdaptAgentToPodNetwork(agent, "echo", nil, spec, "")
+		require.Error(t, err, "the manager name can't be resolved and its IP is unknown")
+
+		agent = newAgent()
+		require.NoError(t, AdaptAgentToPodNetwork(agent, "echo", nil, spec, "10.96.0.10"))
+		port := agent.Ports[0].ContainerPort
+		assert.True(t, port >= DefaultAgentPort && port < DefaultAgentPort+hostNetworkPorts)
+		if port != DefaultAgentPort {
+			ev, ok := envValue(agent, "AGENT_PORT")
+			require.True(t, ok)
+			assert.Equal(t, strconv.Itoa(int(port)), ev.Value)
+		}
+		ev, ok := envValue(agent, "MANAGER_HOST")
+		require.True(t, ok)
+		assert.Equal(t, "10.96.0.10", ev.Value)
+		ev, ok = envValue(agent, "APP_HOST")
+		require.True(t, ok)
+		assert.Equal(t, "status.podIP", ev.ValueFrom.FieldRef.FieldPath)
+
+		// With ClusterFirstWithHostNet, the pod can resolve the name of the manager
+		agent = newAgent()
+		spec.DNSPolicy = corev1.DNSClusterFirstWithHostNet
+		require.NoError(t, AdaptAgentToPodNetwork(agent, "echo", nil, spec, ""))
+		ev, _ = envValue(agent, "MANAGER_HOST")
+		assert.Equal(t, ManagerAppName+".ambassador", ev.Value)
+	})
+
+	t.Run("multus", func(t *testing.T) {
+		agent := newAgent()
+		spec := &corev1.PodSpec{Containers: []corev1.Container{app}}
+		require.NoError(t, AdaptAgentToPodNetwork(agent, "echo", map[string]string{MultusNetworksAnnotation: "macvlan-conf"}, spec, ""))
+		assert.Equal(t, DefaultAgentPort, int(agent.Ports[0].ContainerPort))
+		_, ok := envValue(agent, "APP_HOST")
+		assert.True(t, ok)
+	})
+}
+
+func TestHostNetworkAgentPort(t *testing.T) {
+	port, err := hostNetworkAgentPort("echo", DefaultAgentPort, nil)
+	require.NoError(t, err)
+
+	// The same name always gives the same port, and used ports are skipped
+	again, err := hostNetworkAgentPort("echo", DefaultAgentPort, map[int32]string{})
+	require.NoError(t, err)
+	assert.Equal(t, port, again)
+	next, err := hostNetworkAgentPort("echo", DefaultAgentPort, map[int32]string{port: "app"})
+	require.NoError(t, err)
+	assert.NotEqual(t, port, next)
+
+	used := make(map[int32]string, hostNetworkPorts)
+	for i := int32(0); i < hostNetworkPorts; i++ {
+		used[DefaultAgentPort+i] = "app"
+	}
+	_, err = hostNetworkAgentPort("echo", DefaultAgentPort, used)
+	assert.Error(t, err)
+}
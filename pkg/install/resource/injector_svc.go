@@ -18,7 +18,8 @@ var _ Instance = AgentInjectorSvc
 func (ri injectorSvc) service(ctx context.Context) *kates.Service {
 	svc := new(kates.Service)
 	svc.TypeMeta = kates.TypeMeta{
-		Kind: "Service",
+		Kind:       "Service",
+		APIVersion: "v1",
 	}
 	svc.ObjectMeta = kates.ObjectMeta{
 		Namespace: getScope(ctx).namespace,
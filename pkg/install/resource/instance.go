@@ -9,6 +9,7 @@ import (
 	"github.com/datawire/ambassador/pkg/kates"
 	"github.com/datawire/dlib/dlog"
 	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/install"
 )
 
 // An Instance exposes CRUD operations for a k8s resource such as
@@ -26,11 +27,17 @@ type scope struct {
 	namespace  string
 	clusterID  string
 	tmSelector map[string]string
+	labels     map[string]string
 	client     *kates.Client
 	env        *client.Env
 	caPem      []byte
 	crtPem     []byte
 	keyPem     []byte
+
+	// render makes create collect the objects in rendered instead of creating them
+	render          bool
+	rendered        []kates.Object
+	previousSecrets map[string]map[string][]byte
 }
 
 // The scope is available through the context
@@ -88,9 +95,32 @@ func logName(obj kates.Object) string {
 	return fmt.Sprintf("%s %s.%s", obj.GetObjectKind().GroupVersionKind().Kind, obj.GetName(), obj.GetNamespace())
 }
 
+// addLabels adds the labels of the scope to the given resource. The labels of the resource are
+// copied first, because they may be shared with a selector.
+func addLabels(ctx context.Context, resource kates.Object) {
+	sc := getScope(ctx)
+	if len(sc.labels) == 0 {
+		return
+	}
+	labels := make(map[string]string, len(resource.GetLabels())+len(sc.labels))
+	for k, v := range resource.GetLabels() {
+		labels[k] = v
+	}
+	for k, v := range sc.labels {
+		labels[k] = v
+	}
+	resource.SetLabels(labels)
+}
+
 func create(ctx context.Context, resource kates.Object) error {
+	sc := getScope(ctx)
+	addLabels(ctx, resource)
+	if sc.render {
+		sc.rendered = append(sc.rendered, resource)
+		return nil
+	}
 	dlog.Infof(ctx, "Creating %s", logName(resource))
-	if err := getScope(ctx).client.Create(ctx, resource, nil); err != nil {
+	if err := sc.client.Create(ctx, resource, nil); err != nil {
 		return fmt.Errorf("failed to create %s: %w", logName(resource), err)
 	}
 	return nil
@@ -139,7 +169,7 @@ func remove(ctx context.Context, resource kates.Object) error {
 
 func isManagedByHelm(ctx context.Context, resource kates.Object) bool {
 	labels := resource.GetLabels()
-	if manager, ok := labels["app.kubernetes.io/managed-by"]; ok {
+	if manager, ok := labels[install.ManagedByLabel]; ok {
 		return manager == "Helm"
 	}
 	return false
@@ -30,7 +30,9 @@ func (ri mwhSecret) secret(ctx context.Context) *kates.Secret {
 
 func (ri mwhSecret) Create(ctx context.Context) (err error) {
 	sc := getScope(ctx)
-	if sc.crtPem, sc.keyPem, sc.caPem, err = install.GenerateKeys(sc.namespace); err != nil {
+	if prev := sc.previousSecrets[install.MutatorWebhookTLSName]; prev != nil {
+		sc.crtPem, sc.keyPem, sc.caPem = prev["crt.pem"], prev["key.pem"], prev["ca.pem"]
+	} else if sc.crtPem, sc.keyPem, sc.caPem, err = install.GenerateKeys(sc.namespace); err != nil {
 		return err
 	}
 	sec := ri.secret(ctx)
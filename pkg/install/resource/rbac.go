@@ -0,0 +1,255 @@
+package resource
+
+import (
+	"fmt"
+
+	rbac "k8s.io/api/rbac/v1"
+
+	"github.com/datawire/ambassador/pkg/kates"
+	"github.com/telepresenceio/telepresence/v2/pkg/install"
+)
+
+// managerClusterRules are the rules of the ClusterRole that the traffic-manager is bound to.
+func managerClusterRules() []rbac.PolicyRule {
+	return []rbac.PolicyRule{
+		{
+			Verbs:     []string{"get", "list"},
+			APIGroups: []string{""},
+			Resources: []string{"services"},
+		},
+		{
+			Verbs:     []string{"list", "get", "watch"},
+			APIGroups: []string{""},
+			Resources: []string{"nodes"},
+		},
+		{
+			Verbs:     []string{"list", "get", "watch"},
+			APIGroups: []string{""},
+			Resources: []string{"pods"},
+		},
+		// Needed to verify the identity of clients when an intercept policy is used
+		{
+			Verbs:     []string{"create"},
+			APIGroups: []string{"authentication.k8s.io"},
+			Resources: []string{"tokenreviews"},
+		},
+		// Needed to record Events that explain intercepts on the intercepted workloads, and to refuse
+		// intercepts of workloads that forbid them
+		{
+			Verbs:     []string{"get"},
+			APIGroups: []string{"apps"},
+			Resources: []string{"deployments", "replicasets", "statefulsets"},
+		},
+		{
+			Verbs:     []string{"get"},
+			APIGroups: []string{""},
+			Resources: []string{"replicationcontrollers"},
+		},
+		{
+			Verbs:     []string{"get"},
+			APIGroups: []string{"apps.openshift.io"},
+			Resources: []string{"deploymentconfigs"},
+		},
+		{
+			Verbs:     []string{"get"},
+			APIGroups: []string{"serving.knative.dev"},
+			Resources: []string{"services"},
+		},
+		{
+			Verbs:     []string{"get"},
+			APIGroups: []string{"argoproj.io"},
+			Resources: []string{"rollouts"},
+		},
+		{
+			Verbs:     []string{"create"},
+			APIGroups: []string{""},
+			Resources: []string{"events"},
+		},
+	}
+}
+
+// managerJobCopyRules are the rules that the traffic-manager needs to remove the copies of jobs
+// that clients left behind. They're never granted cluster wide, only in the namespaces where
+// copies are allowed to be removed.
+func managerJobCopyRules() []rbac.PolicyRule {
+	return []rbac.PolicyRule{
+		{
+			Verbs:     []string{"list", "delete"},
+			APIGroups: []string{"apps"},
+			Resources: []string{"deployments"},
+		},
+		{
+			Verbs:     []string{"patch"},
+			APIGroups: []string{"batch"},
+			Resources: []string{"cronjobs"},
+		},
+	}
+}
+
+// managerRules are the rules of the Role that the traffic-manager is bound to in its own namespace.
+func managerRules() []rbac.PolicyRule {
+	return []rbac.PolicyRule{
+		{
+			Verbs:     []string{"create"},
+			APIGroups: []string{""},
+			Resources: []string{"services"},
+		},
+		{
+			// The usage report is saved in a ConfigMap
+			Verbs:     []string{"get", "create", "update"},
+			APIGroups: []string{""},
+			Resources: []string{"configmaps"},
+		},
+	}
+}
+
+// clientDiscoveryRules are the rules a developer needs to discover namespaces and services.
+func clientDiscoveryRules(includeNamespaces bool) []rbac.PolicyRule {
+	rules := []rbac.PolicyRule{
+		{
+			Verbs:     []string{"get", "list", "watch"},
+			APIGroups: []string{""},
+			Resources: []string{"services"},
+		},
+	}
+	if includeNamespaces {
+		rules = append([]rbac.PolicyRule{{
+			Verbs:     []string{"get", "list", "watch"},
+			APIGroups: []string{""},
+			Resources: []string{"namespaces"},
+		}}, rules...)
+	}
+	return rules
+}
+
+// clientInterceptRules are the rules a developer needs in order to intercept workloads. They
+// must be kept in sync with "telepresence.clientRbacInterceptRules" in the Helm chart.
+func clientInterceptRules() []rbac.PolicyRule {
+	return []rbac.PolicyRule{
+		{
+			Verbs:     []string{"get", "list", "create", "watch", "delete"},
+			APIGroups: []string{""},
+			Resources: []string{"pods"},
+		},
+		{
+			Verbs:     []string{"update"},
+			APIGroups: []string{""},
+			Resources: []string{"services"},
+		},
+		{
+			Verbs:     []string{"create"},
+			APIGroups: []string{""},
+			Resources: []string{"pods/portforward"},
+		},
+		{
+			Verbs:     []string{"get", "list", "update"},
+			APIGroups: []string{"apps"},
+			Resources: []string{"deployments", "replicasets", "statefulsets"},
+		},
+		{
+			Verbs:     []string{"*"},
+			APIGroups: []string{"getambassador.io"},
+			Resources: []string{"hosts", "mappings"},
+		},
+		{
+			Verbs:     []string{"get", "list", "watch"},
+			APIGroups: []string{""},
+			Resources: []string{"endpoints"},
+		},
+	}
+}
+
+// clientManagerAccessRules are the rules a developer needs in the traffic-manager namespace to
+// reach the traffic-manager when the developer has no cluster wide permissions.
+func clientManagerAccessRules() []rbac.PolicyRule {
+	return []rbac.PolicyRule{
+		{
+			Verbs:     []string{"get", "list"},
+			APIGroups: []string{""},
+			Resources: []string{"pods", "services"},
+		},
+		{
+			Verbs:     []string{"create"},
+			APIGroups: []string{""},
+			Resources: []string{"pods/portforward"},
+		},
+	}
+}
+
+// RBACOptions controls what RBACObjects returns.
+type RBACOptions struct {
+	// ManagerNamespace is the namespace where the traffic-manager is installed
+	ManagerNamespace string
+
+	// Namespaces, when not empty, restricts the developer and the traffic-manager to the given
+	// namespaces. No cluster wide permissions are then generated.
+	Namespaces []string
+
+	// JobCopyNamespaces are the namespaces where a traffic-manager that isn't namespaced may
+	// remove the copies of jobs that clients left behind. A namespaced traffic-manager may remove
+	// them in all its Namespaces.
+	JobCopyNamespaces []string
+
+	// Developer includes the roles needed by a developer
+	Developer bool
+
+	// Manager includes the roles needed by the traffic-manager
+	Manager bool
+}
+
+// RBACObjects returns the minimal set of ClusterRoles and Roles needed by a developer and/or
+// the traffic-manager. Bindings are not included since the subjects are site specific.
+func RBACObjects(opts *RBACOptions) []kates.Object {
+	var objs []kates.Object
+	cr := func(name string, rules []rbac.PolicyRule) {
+		objs = append(objs, &kates.ClusterRole{
+			TypeMeta:   kates.TypeMeta{Kind: "ClusterRole", APIVersion: "rbac.authorization.k8s.io/v1"},
+			ObjectMeta: kates.ObjectMeta{Name: name},
+			Rules:      rules,
+		})
+	}
+	r := func(name, namespace string, rules []rbac.PolicyRule) {
+		objs = append(objs, &kates.Role{
+			TypeMeta:   kates.TypeMeta{Kind: "Role", APIVersion: "rbac.authorization.k8s.io/v1"},
+			ObjectMeta: kates.ObjectMeta{Name: name, Namespace: namespace},
+			Rules:      rules,
+		})
+	}
+
+	mgrName := fmt.Sprintf("%s-%s", install.ManagerAppName, opts.ManagerNamespace)
+	const devName = "telepresence-developer"
+	namespaced := len(opts.Namespaces) > 0
+	if opts.Manager {
+		if namespaced {
+			// Nodes are cluster scoped, so a namespaced traffic-manager will not be able to
+			// use them when determining the cluster subnets.
+			var rules []rbac.PolicyRule
+			for _, rule := range managerClusterRules() {
+				if rule.Resources[0] != "nodes" {
+					rules = append(rules, rule)
+				}
+			}
+			rules = append(rules, managerJobCopyRules()...)
+			for _, ns := range opts.Namespaces {
+				r(mgrName, ns, rules)
+			}
+		} else {
+			cr(mgrName, managerClusterRules())
+			for _, ns := range opts.JobCopyNamespaces {
+				r(mgrName+"-job-copies", ns, managerJobCopyRules())
+			}
+		}
+		r(mgrName, opts.ManagerNamespace, managerRules())
+	}
+	if opts.Developer {
+		if namespaced {
+			for _, ns := range opts.Namespaces {
+				r(devName, ns, append(clientDiscoveryRules(false), clientInterceptRules()...))
+			}
+			r(devName, opts.ManagerNamespace, clientManagerAccessRules())
+		} else {
+			cr(devName, append(clientDiscoveryRules(true), clientInterceptRules()...))
+		}
+	}
+	return objs
+}
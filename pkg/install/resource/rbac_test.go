@@ -0,0 +1,49 @@
+package resource
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/datawire/ambassador/pkg/kates"
+)
+
+func TestRBACObjects(t *testing.T) {
+	objs := RBACObjects(&RBACOptions{ManagerNamespace: "ambassador", Developer: true, Manager: true})
+	kinds := make([]string, len(objs))
+	for i, obj := range objs {
+		kinds[i] = obj.GetObjectKind().GroupVersionKind().Kind + "/" + obj.GetNamespace() + "/" + obj.GetName()
+	}
+	assert.Equal(t, []string{
+		"ClusterRole//traffic-manager-ambassador",
+		"Role/ambassador/traffic-manager-ambassador",
+		"ClusterRole//telepresence-developer",
+	}, kinds)
+	for _, rule := range objs[0].(*kates.ClusterRole).Rules {
+		assert.NotContains(t, rule.Verbs, "delete", "the cluster wide role must not delete anything")
+		assert.NotContains(t, rule.Verbs, "patch", "the cluster wide role must not patch anything")
+	}
+
+	objs = RBACObjects(&RBACOptions{ManagerNamespace: "ambassador", JobCopyNamespaces: []string{"batch"}, Manager: true})
+	if assert.Len(t, objs, 3) {
+		role, ok := objs[1].(*kates.Role)
+		if assert.True(t, ok) {
+			assert.Equal(t, "batch", role.Namespace)
+			assert.Equal(t, "traffic-manager-ambassador-job-copies", role.Name)
+			assert.Equal(t, managerJobCopyRules(), role.Rules)
+		}
+	}
+
+	objs = RBACObjects(&RBACOptions{ManagerNamespace: "ambassador", Namespaces: []string{"a", "b"}, Developer: true, Manager: true})
+	for _, obj := range objs {
+		role, ok := obj.(*kates.Role)
+		if !assert.True(t, ok, "namespaced mode must not produce cluster wide roles") {
+			continue
+		}
+		for _, rule := range role.Rules {
+			assert.NotContains(t, rule.Resources, "nodes")
+			assert.NotContains(t, rule.Resources, "namespaces")
+		}
+	}
+	assert.Len(t, objs, 6)
+}
@@ -19,13 +19,32 @@ func GetTrafficManagerResources() Instances {
 		NewTrafficManagerRole(),
 		TrafficManagerRoleBinding,
 		MutatorWebhookSecret,
+		TrafficManagerTLSSecrets,
 		NewTrafficManagerDeployment(),
-		TrafficManagerSvc,
+		NewTrafficManagerSvc(),
 		AgentInjectorSvc,
 		AgentInjectorWebhook,
 	}
 }
 
+// trafficManagerResourcesToCreate returns the resources of GetTrafficManagerResources, except for
+// those of the agent injector webhook when the agent is added to workloads by the connector.
+func trafficManagerResourcesToCreate(ctx context.Context) Instances {
+	all := GetTrafficManagerResources()
+	if cl.GetConfig(ctx).Cluster.AgentInjection != cl.AgentInjectionPatch {
+		return all
+	}
+	ris := make(Instances, 0, len(all))
+	for _, in := range all {
+		switch in {
+		case MutatorWebhookSecret, AgentInjectorSvc, AgentInjectorWebhook:
+		default:
+			ris = append(ris, in)
+		}
+	}
+	return ris
+}
+
 func EnsureTrafficManager(ctx context.Context, client *kates.Client, namespace, clusterID string, env *cl.Env) error {
 	ctx = withScope(ctx, &scope{
 		namespace: namespace,
@@ -34,10 +53,11 @@ func EnsureTrafficManager(ctx context.Context, client *kates.Client, namespace,
 			"app":          install.ManagerAppName,
 			"telepresence": telName,
 		},
+		labels: install.OwnerLabels(namespace, cl.GetConfig(ctx).Cluster.Labels),
 		client: client,
 		env:    env,
 	})
-	return GetTrafficManagerResources().Ensure(ctx)
+	return trafficManagerResourcesToCreate(ctx).Ensure(ctx)
 }
 
 func DeleteTrafficManager(ctx context.Context, client *kates.Client, namespace string, env *cl.Env) error {
@@ -48,3 +68,40 @@ func DeleteTrafficManager(ctx context.Context, client *kates.Client, namespace s
 	})
 	return GetTrafficManagerResources().Delete(ctx)
 }
+
+// RenderOptions controls what RenderTrafficManager returns.
+type RenderOptions struct {
+	// Namespace is the namespace of the traffic-manager
+	Namespace string
+
+	// ClusterID is passed to the traffic-manager. It identifies the cluster when a license is used.
+	ClusterID string
+
+	// PreviousSecrets are the data of the secrets of an earlier rendering, by secret name. They
+	// are reused so that rendering again yields the same keys and certificates.
+	PreviousSecrets map[string]map[string][]byte
+}
+
+// RenderTrafficManager returns the objects that EnsureTrafficManager creates when the cluster
+// lacks a traffic-manager, in the order that they are created. The cluster isn't contacted.
+func RenderTrafficManager(ctx context.Context, opts *RenderOptions, env *cl.Env) ([]kates.Object, error) {
+	sc := &scope{
+		namespace: opts.Namespace,
+		clusterID: opts.ClusterID,
+		tmSelector: map[string]string{
+			"app":          install.ManagerAppName,
+			"telepresence": telName,
+		},
+		labels:          install.OwnerLabels(opts.Namespace, cl.GetConfig(ctx).Cluster.Labels),
+		env:             env,
+		render:          true,
+		previousSecrets: opts.PreviousSecrets,
+	}
+	ctx = withScope(ctx, sc)
+	for _, in := range trafficManagerResourcesToCreate(ctx) {
+		if err := in.Create(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return sc.rendered, nil
+}
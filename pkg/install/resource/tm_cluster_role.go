@@ -4,7 +4,6 @@ import (
 	"context"
 	"fmt"
 
-	rbac "k8s.io/api/rbac/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 
 	"github.com/datawire/ambassador/pkg/kates"
@@ -34,23 +33,7 @@ func (ri tmClusterRole) clusterRole(ctx context.Context) *kates.ClusterRole {
 
 func (ri tmClusterRole) desiredClusterRole(ctx context.Context) *kates.ClusterRole {
 	cl := ri.clusterRole(ctx)
-	cl.Rules = []rbac.PolicyRule{
-		{
-			Verbs:     []string{"get", "list"},
-			APIGroups: []string{""},
-			Resources: []string{"services"},
-		},
-		{
-			Verbs:     []string{"list", "get", "watch"},
-			APIGroups: []string{""},
-			Resources: []string{"nodes"},
-		},
-		{
-			Verbs:     []string{"list", "get", "watch"},
-			APIGroups: []string{""},
-			Resources: []string{"pods"},
-		},
-	}
+	cl.Rules = managerClusterRules()
 	return cl
 }
 
@@ -3,6 +3,7 @@ package resource
 import (
 	"context"
 	"fmt"
+	"strconv"
 	"strings"
 
 	appsv1 "k8s.io/api/apps/v1"
@@ -28,7 +29,8 @@ func NewTrafficManagerDeployment() Instance {
 func (ri *tmDeployment) deployment(ctx context.Context) *kates.Deployment {
 	dep := new(kates.Deployment)
 	dep.TypeMeta = kates.TypeMeta{
-		Kind: "Deployment",
+		Kind:       "Deployment",
+		APIVersion: "apps/v1",
 	}
 	sc := getScope(ctx)
 	dep.ObjectMeta = kates.ObjectMeta{
@@ -62,6 +64,12 @@ func (ri *tmDeployment) desiredDeployment(ctx context.Context) *kates.Deployment
 			},
 		},
 	}
+	if tlsConfig := client.GetConfig(ctx).TLS; tlsConfig.FIPS || tlsConfig.MinVersion != "" || len(tlsConfig.CipherSuites) > 0 {
+		containerEnv = append(containerEnv,
+			corev1.EnvVar{Name: "TLS_MIN_VERSION", Value: tlsConfig.MinVersion},
+			corev1.EnvVar{Name: "TLS_CIPHER_SUITES", Value: strings.Join(tlsConfig.CipherSuites, ",")},
+			corev1.EnvVar{Name: "TLS_FIPS", Value: strconv.FormatBool(tlsConfig.FIPS)})
+	}
 	if imgConfig.WebhookAgentImage != "" {
 		image := fmt.Sprintf("%s/%s", imgConfig.WebhookRegistry, imgConfig.WebhookAgentImage)
 		containerEnv = append(containerEnv, corev1.EnvVar{Name: "TELEPRESENCE_AGENT_IMAGE", Value: image})
@@ -87,6 +95,33 @@ func (ri *tmDeployment) desiredDeployment(ctx context.Context) *kates.Deployment
 				},
 			},
 		},
+		{
+			Name: "manager-tls",
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName: install.ManagerTLSName,
+					Optional:   &optional,
+				},
+			},
+		},
+		{
+			Name: "intercept-policy",
+			VolumeSource: corev1.VolumeSource{
+				ConfigMap: &corev1.ConfigMapVolumeSource{
+					LocalObjectReference: corev1.LocalObjectReference{Name: install.InterceptPolicyName},
+					Optional:             &optional,
+				},
+			},
+		},
+		{
+			Name: "auth-tokens",
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName: install.AuthTokensName,
+					Optional:   &optional,
+				},
+			},
+		},
 	}
 	volumeMounts := []corev1.VolumeMount{
 		{
@@ -99,6 +134,21 @@ func (ri *tmDeployment) desiredDeployment(ctx context.Context) *kates.Deployment
 			ReadOnly:  true,
 			MountPath: "/var/run/secrets/tls",
 		},
+		{
+			Name:      "manager-tls",
+			ReadOnly:  true,
+			MountPath: "/var/run/secrets/manager-tls",
+		},
+		{
+			Name:      "intercept-policy",
+			ReadOnly:  true,
+			MountPath: "/etc/traffic-manager",
+		},
+		{
+			Name:      "auth-tokens",
+			ReadOnly:  true,
+			MountPath: "/var/run/secrets/auth-tokens",
+		},
 	}
 
 	dep := ri.deployment(ctx)
@@ -123,6 +173,10 @@ func (ri *tmDeployment) desiredDeployment(ctx context.Context) *kates.Deployment
 								Name:          "api",
 								ContainerPort: install.ManagerPortHTTP,
 							},
+							{
+								Name:          "grpc-tls",
+								ContainerPort: install.ManagerPortHTTPS,
+							},
 							{
 								Name:          "https",
 								ContainerPort: install.MutatorWebhookPortHTTPS,
@@ -182,6 +236,7 @@ func (ri *tmDeployment) Update(ctx context.Context) error {
 
 	dep := ri.desiredDeployment(ctx)
 	dep.ResourceVersion = ri.found.ResourceVersion
+	addLabels(ctx, dep)
 	dlog.Infof(ctx, "Updating %s. Image: %s", logName(dep), imageName)
 	if err := getScope(ctx).client.Update(ctx, dep, dep); err != nil {
 		return fmt.Errorf("failed to update %s: %w", logName(dep), err)
@@ -35,13 +35,7 @@ func (ri tmRole) role(ctx context.Context) *kates.Role {
 
 func (ri tmRole) desiredRole(ctx context.Context) *kates.Role {
 	cl := ri.role(ctx)
-	cl.Rules = []rbac.PolicyRule{
-		{
-			Verbs:     []string{"create"},
-			APIGroups: []string{""},
-			Resources: []string{"services"},
-		},
-	}
+	cl.Rules = managerRules()
 	return cl
 }
 
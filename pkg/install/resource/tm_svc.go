@@ -2,23 +2,28 @@ package resource
 
 import (
 	"context"
+	"fmt"
 
 	"k8s.io/apimachinery/pkg/util/intstr"
 
 	"github.com/datawire/ambassador/pkg/kates"
+	"github.com/datawire/dlib/dlog"
 	"github.com/telepresenceio/telepresence/v2/pkg/install"
 )
 
-type tmSvc int
-
-const TrafficManagerSvc = tmSvc(0)
+type tmSvc struct {
+	found *kates.Service
+}
 
-var _ Instance = TrafficManagerSvc
+func NewTrafficManagerSvc() Instance {
+	return &tmSvc{}
+}
 
-func (ri tmSvc) service(ctx context.Context) *kates.Service {
+func (ri *tmSvc) service(ctx context.Context) *kates.Service {
 	svc := new(kates.Service)
 	svc.TypeMeta = kates.TypeMeta{
-		Kind: "Service",
+		Kind:       "Service",
+		APIVersion: "v1",
 	}
 	svc.ObjectMeta = kates.ObjectMeta{
 		Namespace: getScope(ctx).namespace,
@@ -27,35 +32,80 @@ func (ri tmSvc) service(ctx context.Context) *kates.Service {
 	return svc
 }
 
-func (ri tmSvc) Create(ctx context.Context) error {
+func (ri *tmSvc) desiredPorts() []kates.ServicePort {
+	return []kates.ServicePort{
+		{
+			Name: "api",
+			Port: install.ManagerPortHTTP,
+			TargetPort: kates.IntOrString{
+				Type:   intstr.String,
+				StrVal: "api",
+			},
+		},
+		{
+			Name: "grpc-tls",
+			Port: install.ManagerPortHTTPS,
+			TargetPort: kates.IntOrString{
+				Type:   intstr.String,
+				StrVal: "grpc-tls",
+			},
+		},
+	}
+}
+
+func (ri *tmSvc) Create(ctx context.Context) error {
 	svc := ri.service(ctx)
 	svc.Spec = kates.ServiceSpec{
 		Type:      "ClusterIP",
 		ClusterIP: "None",
 		Selector:  getScope(ctx).tmSelector,
-		Ports: []kates.ServicePort{
-			{
-				Name: "api",
-				Port: install.ManagerPortHTTP,
-				TargetPort: kates.IntOrString{
-					Type:   intstr.String,
-					StrVal: "api",
-				},
-			},
-		},
+		Ports:     ri.desiredPorts(),
 	}
 	return create(ctx, svc)
 }
 
-func (ri tmSvc) Exists(ctx context.Context) (bool, error) {
-	return exists(ctx, ri.service(ctx))
+func (ri *tmSvc) Exists(ctx context.Context) (bool, error) {
+	found, err := find(ctx, ri.service(ctx))
+	if err != nil {
+		return false, err
+	}
+	if found == nil {
+		return false, nil
+	}
+	ri.found = found.(*kates.Service)
+	return true, nil
 }
 
-func (ri tmSvc) Delete(ctx context.Context) error {
+func (ri *tmSvc) Delete(ctx context.Context) error {
 	return remove(ctx, ri.service(ctx))
 }
 
-func (ri tmSvc) Update(_ context.Context) error {
-	// Noop
+// Update adds ports that are missing in a service that was created by an older version
+func (ri *tmSvc) Update(ctx context.Context) error {
+	if ri.found == nil || isManagedByHelm(ctx, ri.found) {
+		return nil
+	}
+	svc := ri.found
+	changed := false
+	for _, dp := range ri.desiredPorts() {
+		present := false
+		for _, p := range svc.Spec.Ports {
+			if p.Name == dp.Name {
+				present = true
+				break
+			}
+		}
+		if !present {
+			svc.Spec.Ports = append(svc.Spec.Ports, dp)
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	dlog.Infof(ctx, "Updating %s", logName(svc))
+	if err := getScope(ctx).client.Update(ctx, svc, svc); err != nil {
+		return fmt.Errorf("failed to update %s: %w", logName(svc), err)
+	}
 	return nil
 }
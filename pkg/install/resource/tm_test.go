@@ -0,0 +1,64 @@
+package resource
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	admreg "k8s.io/api/admissionregistration/v1"
+
+	"github.com/datawire/ambassador/pkg/kates"
+	"github.com/datawire/dlib/dlog"
+	cl "github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/install"
+)
+
+func TestRenderTrafficManager(t *testing.T) {
+	ctx := dlog.NewTestContext(t, false)
+	opts := &RenderOptions{Namespace: "ambassador"}
+	objs, err := RenderTrafficManager(ctx, opts, &cl.Env{})
+	require.NoError(t, err)
+
+	kinds := make([]string, len(objs))
+	var secret *kates.Secret
+	var webhook *admreg.MutatingWebhookConfiguration
+	for i, obj := range objs {
+		kinds[i] = obj.GetObjectKind().GroupVersionKind().Kind + "/" + obj.GetNamespace() + "/" + obj.GetName()
+		switch o := obj.(type) {
+		case *kates.Secret:
+			if o.Name == install.MutatorWebhookTLSName {
+				secret = o
+			}
+		case *admreg.MutatingWebhookConfiguration:
+			webhook = o
+		}
+	}
+	assert.Contains(t, kinds, "Deployment/ambassador/"+install.ManagerAppName)
+	assert.Contains(t, kinds, "Service/ambassador/"+install.ManagerAppName)
+	assert.Contains(t, kinds, "Service/ambassador/"+install.AgentInjectorName)
+	for _, kind := range kinds {
+		assert.NotRegexp(t, "^/", kind, "all objects must have a kind")
+	}
+	for _, obj := range objs {
+		assert.Equal(t, "ambassador", obj.GetLabels()[install.ManagerNamespaceLabel], "all objects must have owner labels")
+		if dep, ok := obj.(*kates.Deployment); ok {
+			// The owner labels must not leak into the selector
+			assert.NotContains(t, dep.Spec.Selector.MatchLabels, install.ManagerNamespaceLabel)
+		}
+	}
+	require.NotNil(t, secret)
+	require.NotNil(t, webhook)
+	require.Len(t, webhook.Webhooks, 1)
+	assert.Equal(t, secret.Data["ca.pem"], webhook.Webhooks[0].ClientConfig.CABundle)
+
+	// Rendering with the secrets of an earlier rendering yields the same secrets
+	opts.PreviousSecrets = map[string]map[string][]byte{install.MutatorWebhookTLSName: secret.Data}
+	again, err := RenderTrafficManager(ctx, opts, &cl.Env{})
+	require.NoError(t, err)
+	require.Len(t, again, len(objs))
+	for _, obj := range again {
+		if s, ok := obj.(*kates.Secret); ok && s.Name == install.MutatorWebhookTLSName {
+			assert.Equal(t, secret.Data, s.Data)
+		}
+	}
+}
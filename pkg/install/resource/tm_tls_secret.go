@@ -0,0 +1,76 @@
+package resource
+
+import (
+	"context"
+
+	"github.com/datawire/ambassador/pkg/kates"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/install"
+)
+
+// tmTLSSecrets manages the two secrets that are used when the connector and the traffic-manager
+// use mutual TLS. The secrets are only created when mutual TLS is enabled in the client config,
+// but they are always deleted when the traffic-manager is uninstalled.
+type tmTLSSecrets int
+
+const TrafficManagerTLSSecrets = tmTLSSecrets(0)
+
+var _ Instance = TrafficManagerTLSSecrets
+
+func (ri tmTLSSecrets) secret(ctx context.Context, name string) *kates.Secret {
+	sec := new(kates.Secret)
+	sec.TypeMeta = kates.TypeMeta{
+		Kind:       "Secret",
+		APIVersion: "v1",
+	}
+	sec.ObjectMeta = kates.ObjectMeta{
+		Namespace: getScope(ctx).namespace,
+		Name:      name,
+	}
+	return sec
+}
+
+func (ri tmTLSSecrets) Create(ctx context.Context) error {
+	if !client.GetConfig(ctx).TLS.ManagerMTLS {
+		return nil
+	}
+	sc := getScope(ctx)
+	serverData, clientData := sc.previousSecrets[install.ManagerTLSName], sc.previousSecrets[install.ManagerClientTLSName]
+	if serverData == nil || clientData == nil {
+		var err error
+		if serverData, clientData, err = install.GenerateManagerKeys(sc.namespace); err != nil {
+			return err
+		}
+	}
+	sec := ri.secret(ctx, install.ManagerTLSName)
+	sec.Type = "kubernetes.io/tls"
+	sec.Data = serverData
+	if err := create(ctx, sec); err != nil {
+		return err
+	}
+	sec = ri.secret(ctx, install.ManagerClientTLSName)
+	sec.Type = "kubernetes.io/tls"
+	sec.Data = clientData
+	return create(ctx, sec)
+}
+
+func (ri tmTLSSecrets) Exists(ctx context.Context) (bool, error) {
+	if !client.GetConfig(ctx).TLS.ManagerMTLS {
+		// Pretend that they exist so that Create isn't called
+		return true, nil
+	}
+	// The secrets are created and deleted together, so it's enough to check the server secret.
+	return exists(ctx, ri.secret(ctx, install.ManagerTLSName))
+}
+
+func (ri tmTLSSecrets) Delete(ctx context.Context) error {
+	if err := remove(ctx, ri.secret(ctx, install.ManagerClientTLSName)); err != nil {
+		return err
+	}
+	return remove(ctx, ri.secret(ctx, install.ManagerTLSName))
+}
+
+func (ri tmTLSSecrets) Update(_ context.Context) error {
+	// Noop
+	return nil
+}
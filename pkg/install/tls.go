@@ -92,3 +92,93 @@ func ToPEM(file, keyType string, data []byte) ([]byte, error) {
 	}
 	return wrt.Bytes(), nil
 }
+
+// GenerateManagerKeys creates a CA and a server and client certificate pair signed by that CA. They
+// are used when the connector and the traffic-manager communicate using mutual TLS. The returned maps
+// use the same keys as a cert-manager issued secret (ca.crt, tls.crt, and tls.key) so that secrets
+// provisioned by an existing cluster CA can be used in place of the generated ones.
+func GenerateManagerKeys(mgrNamespace string) (serverData, clientData map[string][]byte, err error) {
+	now := time.Now()
+	caCert := &x509.Certificate{
+		SerialNumber: big.NewInt(now.UnixNano()),
+		Subject: pkix.Name{
+			CommonName:   "traffic-manager-ca",
+			Organization: []string{"getambassador.io"},
+		},
+		NotBefore:             now,
+		NotAfter:              now.AddDate(10, 0, 0),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caPrivKey, err := rsa.GenerateKey(cryptorand.Reader, 4096)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate CA private key: %w", err)
+	}
+	caBytes, err := x509.CreateCertificate(cryptorand.Reader, caCert, caCert, &caPrivKey.PublicKey, caPrivKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate CA certificate: %w", err)
+	}
+	caPem, err := ToPEM("ca.crt", "CERTIFICATE", caBytes)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	commonName := fmt.Sprintf("%s.%s.svc", ManagerAppName, mgrNamespace)
+	server := &x509.Certificate{
+		DNSNames:     []string{ManagerAppName, ManagerAppName + "." + mgrNamespace, commonName},
+		SerialNumber: big.NewInt(now.UnixNano() + 1),
+		Subject: pkix.Name{
+			CommonName:   commonName,
+			Organization: []string{"getambassador.io"},
+		},
+		NotBefore:   now,
+		NotAfter:    now.AddDate(10, 0, 0),
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		KeyUsage:    x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+	}
+	if serverData, err = signedKeyPair(server, caCert, caPrivKey, caPem); err != nil {
+		return nil, nil, err
+	}
+
+	client := &x509.Certificate{
+		SerialNumber: big.NewInt(now.UnixNano() + 2),
+		Subject: pkix.Name{
+			CommonName:   "telepresence-client",
+			Organization: []string{"getambassador.io"},
+		},
+		NotBefore:   now,
+		NotAfter:    now.AddDate(10, 0, 0),
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		KeyUsage:    x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+	}
+	if clientData, err = signedKeyPair(client, caCert, caPrivKey, caPem); err != nil {
+		return nil, nil, err
+	}
+	return serverData, clientData, nil
+}
+
+func signedKeyPair(cert, caCert *x509.Certificate, caPrivKey *rsa.PrivateKey, caPem []byte) (map[string][]byte, error) {
+	privKey, err := rsa.GenerateKey(cryptorand.Reader, 4096)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate private key for %s: %w", cert.Subject.CommonName, err)
+	}
+	cert.SubjectKeyId = bigIntHash(privKey.N)
+	certBytes, err := x509.CreateCertificate(cryptorand.Reader, cert, caCert, &privKey.PublicKey, caPrivKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign the certificate for %s: %w", cert.Subject.CommonName, err)
+	}
+	keyPem, err := ToPEM("tls.key", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(privKey))
+	if err != nil {
+		return nil, err
+	}
+	crtPem, err := ToPEM("tls.crt", "CERTIFICATE", certBytes)
+	if err != nil {
+		return nil, err
+	}
+	return map[string][]byte{
+		"ca.crt":  caPem,
+		"tls.crt": crtPem,
+		"tls.key": keyPem,
+	}, nil
+}
@@ -4,6 +4,8 @@ import (
 	"fmt"
 
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
 
 	"github.com/datawire/ambassador/pkg/kates"
 )
@@ -21,6 +23,21 @@ func GetPodTemplateFromObject(obj kates.Object) (*kates.PodTemplateSpec, error)
 	case "StatefulSet":
 		statefulSet := obj.(*kates.StatefulSet)
 		tplSpec = &statefulSet.Spec.Template
+	case "DeploymentConfig", "Rollout":
+		// DeploymentConfigs and Argo Rollouts are unstructured, so the template returned here is a
+		// copy. Changes to it will not be reflected in the object.
+		u, ok := obj.(*kates.Unstructured)
+		if !ok {
+			return nil, ObjErrorf(obj, "unexpected type %T for a %s", obj, kind)
+		}
+		tpl, _, err := unstructured.NestedMap(u.Object, "spec", "template")
+		if err != nil {
+			return nil, ObjErrorf(obj, "unable to get pod template: %v", err)
+		}
+		tplSpec = &kates.PodTemplateSpec{}
+		if err = runtime.DefaultUnstructuredConverter.FromUnstructured(tpl, tplSpec); err != nil {
+			return nil, ObjErrorf(obj, "unable to parse pod template: %v", err)
+		}
 	default:
 		return nil, ObjErrorf(obj, "unsupported workload kind %q", kind)
 	}
@@ -0,0 +1,11 @@
+// Package ipproto contains the numbers of the IP protocols that Telepresence handles. They're the
+// same on all platforms, unlike the constants of golang.org/x/sys/unix, which don't exist on
+// Windows.
+package ipproto
+
+const (
+	ICMP   = 1
+	TCP    = 6
+	UDP    = 17
+	ICMPV6 = 58
+)
@@ -0,0 +1,69 @@
+package itest
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/datawire/dlib/dexec"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/filelocation"
+)
+
+// Run runs the given command and returns an error that includes its stderr if it fails.
+func Run(ctx context.Context, args ...string) error {
+	return client.RunError(dexec.CommandContext(ctx, args[0], args[1:]...).Run())
+}
+
+// Output runs the given command and returns its output.
+func Output(ctx context.Context, args ...string) (string, error) {
+	cmd := dexec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.DisableLogging = true
+	out, err := cmd.Output()
+	return string(out), client.RunError(err)
+}
+
+// Telepresence runs the telepresence executable with the given arguments and returns its trimmed
+// stdout and stderr. The config and log directories of the context are passed on to it, so a
+// test can give it a config.yml with WithConfig.
+func (h *Harness) Telepresence(ctx context.Context, args ...string) (string, string) {
+	var stdout, stderr strings.Builder
+
+	configDir, _ := filelocation.AppUserConfigDir(ctx)
+	logDir, _ := filelocation.AppUserLogDir(ctx)
+
+	cmd := dexec.CommandContext(ctx, h.Executable, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	cmd.Env = append(os.Environ(),
+		"DEV_TELEPRESENCE_CONFIG_DIR="+configDir,
+		"DEV_TELEPRESENCE_LOG_DIR="+logDir)
+
+	_ = cmd.Run()
+
+	return strings.TrimSpace(stdout.String()), strings.TrimSpace(stderr.String())
+}
+
+// WithConfig writes the given config.yml, with the registry of the harness prepended, to the
+// given directory, and returns a context that has it as the config directory.
+func (h *Harness) WithConfig(ctx context.Context, configDir, configYml string) (context.Context, error) {
+	registry := fmt.Sprintf("images:\n  registry: %s\n  webhookRegistry: %s\n", h.Registry, h.Registry)
+	if strings.Contains(configYml, "images:") {
+		// The test configures the images itself
+		registry = ""
+	}
+	return SetConfig(ctx, configDir, registry+configYml)
+}
+
+// SetConfig clears the config and writes the given config.yml to the given directory. It returns
+// a context that has the directory as the config directory.
+func SetConfig(ctx context.Context, configDir, configYml string) (context.Context, error) {
+	client.ResetConfig(ctx)
+	if err := ioutil.WriteFile(filepath.Join(configDir, "config.yml"), []byte(configYml), 0644); err != nil {
+		return ctx, err
+	}
+	return filelocation.WithAppUserConfigDir(ctx, configDir), nil
+}
@@ -0,0 +1,214 @@
+package itest
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/clientcmd/api"
+
+	"github.com/datawire/ambassador/pkg/dtest"
+	"github.com/datawire/dlib/dexec"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+)
+
+// ClusterProvider is what provides the cluster of the harness.
+type ClusterProvider string
+
+const (
+	// ClusterDtest is the k3s cluster that dtest runs in a docker container
+	ClusterDtest = ClusterProvider("dtest")
+
+	// ClusterKind is a kind cluster. The traffic-manager image is loaded into its nodes, so the
+	// registry is only used for the base image of the build.
+	ClusterKind = ClusterProvider("kind")
+
+	// ClusterExisting is the cluster of the current context of the KUBECONFIG. It must be able to
+	// pull from the TELEPRESENCE_REGISTRY.
+	ClusterExisting = ClusterProvider("existing")
+)
+
+// ParseClusterProvider returns the provider of the given name. The empty string gives ClusterDtest.
+func ParseClusterProvider(name string) (ClusterProvider, error) {
+	switch p := ClusterProvider(strings.ToLower(strings.TrimSpace(name))); p {
+	case "":
+		return ClusterDtest, nil
+	case ClusterDtest, ClusterKind, ClusterExisting:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown cluster provider %q, expected %s, %s, or %s", name, ClusterDtest, ClusterKind, ClusterExisting)
+	}
+}
+
+type cluster interface {
+	// start starts the cluster, unless it's running, and returns its kubeconfig
+	start(ctx context.Context) (*api.Config, error)
+
+	// stop stops the cluster if it was started by start
+	stop(ctx context.Context) error
+}
+
+// imageLoader is implemented by clusters that images can be loaded into without a registry.
+type imageLoader interface {
+	loadImage(ctx context.Context, image string) error
+}
+
+func (h *Harness) startCluster(ctx context.Context) error {
+	cfg, err := h.cluster.start(ctx)
+	if err != nil {
+		return err
+	}
+	cfg, err = defaultContextConfig(cfg)
+	if err != nil {
+		return err
+	}
+	dir, err := ioutil.TempDir("", "itest-")
+	if err != nil {
+		return err
+	}
+	kubeconfig := filepath.Join(dir, "kubeconfig")
+	if err = clientcmd.WriteToFile(*cfg, kubeconfig); err != nil {
+		_ = os.RemoveAll(dir)
+		return err
+	}
+	h.Kubeconfig = kubeconfig
+	return os.Setenv("KUBECONFIG", h.Kubeconfig)
+}
+
+// defaultContextConfig returns a self-contained copy of the current context of the given config,
+// with the context and its cluster named DefaultContext, so that tests don't depend on what the
+// provider calls them.
+func defaultContextConfig(cfg *api.Config) (*api.Config, error) {
+	cfg = cfg.DeepCopy()
+	if err := api.MinifyConfig(cfg); err != nil {
+		return nil, err
+	}
+	if err := api.FlattenConfig(cfg); err != nil {
+		return nil, err
+	}
+	kc := cfg.Contexts[cfg.CurrentContext]
+	cl := cfg.Clusters[kc.Cluster]
+	kc.Cluster = DefaultContext
+	cfg.Contexts = map[string]*api.Context{DefaultContext: kc}
+	cfg.Clusters = map[string]*api.Cluster{DefaultContext: cl}
+	cfg.CurrentContext = DefaultContext
+	return cfg, nil
+}
+
+type dtestCluster struct{}
+
+func (dtestCluster) start(ctx context.Context) (*api.Config, error) {
+	return clientcmd.LoadFromFile(dtest.Kubeconfig(ctx))
+}
+
+func (dtestCluster) stop(context.Context) error {
+	// The dtest cluster is shared by the tests of all packages and is left running
+	return nil
+}
+
+type kindCluster struct {
+	name    string
+	created bool
+}
+
+func (kc *kindCluster) start(ctx context.Context) (*api.Config, error) {
+	out, err := Output(ctx, "kind", "get", "clusters")
+	if err != nil {
+		return nil, err
+	}
+	exists := false
+	for _, name := range strings.Fields(out) {
+		if name == kc.name {
+			exists = true
+			break
+		}
+	}
+	if !exists {
+		if err = Run(ctx, "kind", "create", "cluster", "--name", kc.name, "--wait", "5m"); err != nil {
+			return nil, err
+		}
+		kc.created = true
+	}
+	if out, err = Output(ctx, "kind", "get", "kubeconfig", "--name", kc.name); err != nil {
+		return nil, err
+	}
+	return clientcmd.Load([]byte(out))
+}
+
+func (kc *kindCluster) stop(ctx context.Context) error {
+	if !kc.created {
+		return nil
+	}
+	return Run(ctx, "kind", "delete", "cluster", "--name", kc.name)
+}
+
+func (kc *kindCluster) loadImage(ctx context.Context, image string) error {
+	return Run(ctx, "kind", "load", "docker-image", image, "--name", kc.name)
+}
+
+type existingCluster struct{}
+
+func (existingCluster) start(context.Context) (*api.Config, error) {
+	cfg, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		clientcmd.NewDefaultClientConfigLoadingRules(), &clientcmd.ConfigOverrides{}).RawConfig()
+	if err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+func (existingCluster) stop(context.Context) error {
+	return nil
+}
+
+// buildExecutable builds the telepresence executable into the build-output directory.
+func (h *Harness) buildExecutable(ctx context.Context) (string, error) {
+	executable := filepath.Join(h.RootDir, "build-output", "bin", "telepresence")
+	cmd := dexec.CommandContext(ctx, "go", "build", "-ldflags",
+		fmt.Sprintf("-X=github.com/telepresenceio/telepresence/v2/pkg/version.Version=%s", h.Version),
+		"-o", executable, "./cmd/telepresence")
+	cmd.Dir = h.RootDir
+	return executable, client.RunError(cmd.Run())
+}
+
+// publishImage builds the traffic-manager image and either loads it into the cluster or pushes it
+// to the registry.
+func (h *Harness) publishImage(ctx context.Context) error {
+	il, ok := h.cluster.(imageLoader)
+	if !ok {
+		return h.make(ctx, "push-image")
+	}
+	if err := h.make(ctx, "image"); err != nil {
+		return err
+	}
+	return il.loadImage(ctx, fmt.Sprintf("%s/tel2:%s", h.Registry, h.ImageTag()))
+}
+
+func (h *Harness) make(ctx context.Context, target string) error {
+	cmd := dexec.CommandContext(ctx, "make", target)
+	cmd.Dir = h.RootDir
+
+	// Go sets a lot of variables that we don't want to pass on to the ko executable. If we do,
+	// then it builds for the platform indicated by those variables. The image is only built for the
+	// platform of the test cluster.
+	cmd.Env = []string{
+		"TELEPRESENCE_VERSION=" + h.Version,
+		"TELEPRESENCE_REGISTRY=" + h.Registry,
+		"TELEPRESENCE_PLATFORMS=linux/" + runtime.GOARCH,
+	}
+	includeEnv := []string{"KO_DOCKER_REPO=", "HOME=", "PATH=", "LOGNAME=", "TMPDIR=", "MAKELEVEL=", "DOCKER_HOST="}
+	for _, env := range os.Environ() {
+		for _, incl := range includeEnv {
+			if strings.HasPrefix(env, incl) {
+				cmd.Env = append(cmd.Env, env)
+				break
+			}
+		}
+	}
+	return client.RunError(cmd.Run())
+}
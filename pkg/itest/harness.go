@@ -0,0 +1,255 @@
+// Package itest is a harness for end-to-end tests of telepresence. It provides a cluster, builds
+// the telepresence executable and the traffic-manager image from the source tree, and has helpers
+// that install the traffic-manager, create workloads to intercept, and run the CLI.
+//
+// The harness is used by the integration tests of telepresence itself, and can be imported by
+// forks and extensions that want to test against the real thing. A test typically creates the
+// harness in its setup, and closes it in its teardown:
+//
+//	h, err := itest.New(ctx, &itest.Options{})
+//	if err != nil {
+//		t.Fatal(err)
+//	}
+//	defer h.Close(ctx)
+//	require.NoError(t, h.CreateNamespace(ctx, "my-test"))
+//	require.NoError(t, h.ApplyEchoService(ctx, "my-test", "echo"))
+//	stdout, stderr := h.Telepresence(ctx, "connect")
+//
+// What cluster is used is determined by the TELEPRESENCE_TEST_CLUSTER environment variable, see
+// ClusterProvider.
+package itest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/datawire/ambassador/pkg/dtest"
+	"github.com/datawire/dlib/dlog"
+)
+
+// DefaultContext is the name of the context, and of its cluster, in the kubeconfig of the harness,
+// regardless of what the cluster is called by its provider.
+const DefaultContext = "default"
+
+// Options configure the harness. The zero value of each option gives a sensible default.
+type Options struct {
+	// RootDir is the root of the telepresence source tree. The executable and the image are built
+	// from it, and the manifests of the workloads are read from it. It defaults to the
+	// TELEPRESENCE_ROOT environment variable, or to the closest directory above the current
+	// directory that contains the telepresence sources.
+	RootDir string
+
+	// Cluster is what provides the cluster. It defaults to the TELEPRESENCE_TEST_CLUSTER
+	// environment variable, or to ClusterDtest.
+	Cluster ClusterProvider
+
+	// KindClusterName is the name of the kind cluster that is created, or reused if it exists,
+	// when Cluster is ClusterKind. It defaults to "telepresence-itest".
+	KindClusterName string
+
+	// KeepCluster makes Close leave a kind cluster that the harness created. It defaults to
+	// true when the TELEPRESENCE_TEST_KEEP_CLUSTER environment variable is set.
+	KeepCluster bool
+
+	// Version is the version that the executable and the image are built with. It defaults to a
+	// version that is unique to the test process.
+	Version string
+
+	// Registry is the registry of the traffic-manager image. It defaults to the
+	// TELEPRESENCE_REGISTRY environment variable, or to the registry of dtest unless Cluster is
+	// ClusterExisting.
+	Registry string
+
+	// Executable is a telepresence executable to use instead of building one.
+	Executable string
+
+	// SkipImage skips the build of the traffic-manager image. The image of Registry and
+	// Version must then be available to the cluster.
+	SkipImage bool
+}
+
+// Harness is a cluster with a traffic-manager image of a given version, and a telepresence
+// executable of the same version.
+//
+// The harness sets the KUBECONFIG environment variable of the process to its kubeconfig, so that
+// kubectl, helm, and telepresence use the cluster of the harness.
+type Harness struct {
+	// RootDir is the root of the telepresence source tree
+	RootDir string
+
+	// Version is the version of the executable and the image
+	Version string
+
+	// Registry is the registry of the traffic-manager image
+	Registry string
+
+	// Executable is the telepresence executable
+	Executable string
+
+	// Kubeconfig is the kubeconfig file of the cluster. Its current context is DefaultContext.
+	Kubeconfig string
+
+	cluster cluster
+	keep    bool
+}
+
+// New returns a harness with a cluster that is ready for use, an executable, and a
+// traffic-manager image that the cluster can pull.
+func New(ctx context.Context, opts *Options) (*Harness, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	rootDir, err := findRootDir(opts.RootDir)
+	if err != nil {
+		return nil, err
+	}
+	h := &Harness{
+		RootDir:    rootDir,
+		Version:    opts.Version,
+		Registry:   opts.Registry,
+		Executable: opts.Executable,
+		keep:       opts.KeepCluster || os.Getenv("TELEPRESENCE_TEST_KEEP_CLUSTER") != "",
+	}
+	if h.Version == "" {
+		h.Version = fmt.Sprintf("v2.0.0-gotest.%d", os.Getpid())
+	}
+
+	// The tools that the build depends on, such as ko and helm, are installed in tools/bin
+	toolsBinDir := filepath.Join(rootDir, "tools", "bin")
+	if info, err := os.Stat(filepath.Join(toolsBinDir, "ko")); err != nil || !info.Mode().IsRegular() || (info.Mode().Perm()&0100) == 0 {
+		return nil, errors.New("it looks like the ./tools/bin/ko executable wasn't built; be sure to build it with `make` before running the tests")
+	}
+	if err := os.Setenv("PATH", toolsBinDir+string(os.PathListSeparator)+os.Getenv("PATH")); err != nil {
+		return nil, err
+	}
+
+	provider := opts.Cluster
+	if provider == "" {
+		if provider, err = ParseClusterProvider(os.Getenv("TELEPRESENCE_TEST_CLUSTER")); err != nil {
+			return nil, err
+		}
+	}
+	switch provider {
+	case ClusterDtest:
+		h.cluster = &dtestCluster{}
+	case ClusterKind:
+		name := opts.KindClusterName
+		if name == "" {
+			name = "telepresence-itest"
+		}
+		h.cluster = &kindCluster{name: name}
+	case ClusterExisting:
+		h.cluster = &existingCluster{}
+	default:
+		return nil, fmt.Errorf("unknown cluster provider %q", provider)
+	}
+	if h.Registry == "" {
+		h.Registry = os.Getenv("TELEPRESENCE_REGISTRY")
+	}
+	if h.Registry == "" {
+		if provider == ClusterExisting {
+			return nil, errors.New("TELEPRESENCE_REGISTRY must be set to a registry that the cluster can pull from")
+		}
+		h.Registry = dtest.DockerRegistry(ctx)
+	}
+
+	dlog.Infof(ctx, "Starting %s cluster", provider)
+	if err = h.startCluster(ctx); err != nil {
+		return nil, err
+	}
+
+	var wg sync.WaitGroup
+	var buildErr, imageErr error
+	if h.Executable == "" {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h.Executable, buildErr = h.buildExecutable(ctx)
+		}()
+	}
+	if !opts.SkipImage {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			imageErr = h.publishImage(ctx)
+		}()
+	}
+	wg.Wait()
+	if buildErr != nil {
+		h.Close(ctx)
+		return nil, fmt.Errorf("failed to build the telepresence executable: %w", buildErr)
+	}
+	if imageErr != nil {
+		h.Close(ctx)
+		return nil, fmt.Errorf("failed to build the traffic-manager image: %w", imageErr)
+	}
+	return h, nil
+}
+
+// Close removes the kubeconfig of the harness, and deletes the cluster if the harness created it,
+// unless KeepCluster is set.
+func (h *Harness) Close(ctx context.Context) {
+	if h.Kubeconfig != "" {
+		if err := os.RemoveAll(filepath.Dir(h.Kubeconfig)); err != nil {
+			dlog.Errorf(ctx, "failed to remove the kubeconfig: %v", err)
+		}
+	}
+	if h.keep {
+		return
+	}
+	if err := h.cluster.stop(ctx); err != nil {
+		dlog.Errorf(ctx, "failed to stop the cluster: %v", err)
+	}
+}
+
+// ImageTag is the tag of the traffic-manager image, i.e. the version without its "v" prefix.
+func (h *Harness) ImageTag() string {
+	if len(h.Version) > 0 && h.Version[0] == 'v' {
+		return h.Version[1:]
+	}
+	return h.Version
+}
+
+// findRootDir returns the root of the telepresence source tree.
+func findRootDir(dir string) (string, error) {
+	if dir == "" {
+		dir = os.Getenv("TELEPRESENCE_ROOT")
+	}
+	if dir != "" {
+		dir, err := filepath.Abs(dir)
+		if err != nil {
+			return "", err
+		}
+		if !isRootDir(dir) {
+			return "", fmt.Errorf("%s is not the root of the telepresence sources", dir)
+		}
+		return dir, nil
+	}
+	dir, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	for {
+		if isRootDir(dir) {
+			return dir, nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", errors.New("unable to find the root of the telepresence sources, set TELEPRESENCE_ROOT")
+		}
+		dir = parent
+	}
+}
+
+func isRootDir(dir string) bool {
+	for _, sub := range []string{"go.mod", filepath.Join("cmd", "telepresence"), filepath.Join("charts", "telepresence")} {
+		if _, err := os.Stat(filepath.Join(dir, sub)); err != nil {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,62 @@
+package itest
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/tools/clientcmd/api"
+)
+
+func TestParseClusterProvider(t *testing.T) {
+	p, err := ParseClusterProvider("")
+	require.NoError(t, err)
+	assert.Equal(t, ClusterDtest, p)
+
+	p, err = ParseClusterProvider(" Kind ")
+	require.NoError(t, err)
+	assert.Equal(t, ClusterKind, p)
+
+	_, err = ParseClusterProvider("minikube")
+	assert.Error(t, err)
+}
+
+func TestDefaultContextConfig(t *testing.T) {
+	cfg := api.NewConfig()
+	cfg.Clusters["kind-itest"] = &api.Cluster{Server: "https://127.0.0.1:6443"}
+	cfg.Clusters["other"] = &api.Cluster{Server: "https://example.com"}
+	cfg.AuthInfos["kind-itest"] = &api.AuthInfo{Token: "secret"}
+	cfg.Contexts["kind-itest"] = &api.Context{Cluster: "kind-itest", AuthInfo: "kind-itest"}
+	cfg.Contexts["other"] = &api.Context{Cluster: "other"}
+	cfg.CurrentContext = "kind-itest"
+
+	dc, err := defaultContextConfig(cfg)
+	require.NoError(t, err)
+	assert.Equal(t, DefaultContext, dc.CurrentContext)
+	require.Len(t, dc.Contexts, 1)
+	require.Len(t, dc.Clusters, 1)
+	assert.Equal(t, DefaultContext, dc.Contexts[DefaultContext].Cluster)
+	assert.Equal(t, "kind-itest", dc.Contexts[DefaultContext].AuthInfo)
+	assert.Equal(t, "https://127.0.0.1:6443", dc.Clusters[DefaultContext].Server)
+
+	// The given config is left as is
+	assert.Equal(t, "kind-itest", cfg.CurrentContext)
+	assert.Len(t, cfg.Contexts, 2)
+}
+
+func TestFindRootDir(t *testing.T) {
+	root, err := findRootDir("")
+	require.NoError(t, err)
+	assert.Equal(t, root, filepath.Dir(filepath.Dir(mustGetwd(t))))
+
+	_, err = findRootDir(t.TempDir())
+	assert.Error(t, err)
+}
+
+func mustGetwd(t *testing.T) string {
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	return wd
+}
@@ -0,0 +1,185 @@
+package itest
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/datawire/dlib/dexec"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+)
+
+// DeveloperUser is the user, and the context, that SetupDeveloperUser adds to the kubeconfig. It
+// has the minimal RBAC that telepresence needs, as declared in k8s/client_rbac.yaml.
+const DeveloperUser = "telepresence-test-developer"
+
+// EchoImage is the image of the workloads that ApplyEchoService creates. It responds with the
+// request that it received.
+const EchoImage = "jmalloc/echo-server:0.1.0"
+
+// Kubectl runs kubectl in the given namespace.
+func (h *Harness) Kubectl(ctx context.Context, namespace string, args ...string) error {
+	return Run(ctx, append([]string{"kubectl", "--namespace", namespace}, args...)...)
+}
+
+// KubectlOut runs kubectl in the given namespace and returns its output.
+func (h *Harness) KubectlOut(ctx context.Context, namespace string, args ...string) (string, error) {
+	return Output(ctx, append([]string{"kubectl", "--namespace", namespace}, args...)...)
+}
+
+// CreateNamespace creates the given namespace.
+func (h *Harness) CreateNamespace(ctx context.Context, namespace string) error {
+	return Run(ctx, "kubectl", "--context", DefaultContext, "create", "namespace", namespace)
+}
+
+// DeleteNamespace deletes the given namespace.
+func (h *Harness) DeleteNamespace(ctx context.Context, namespace string) error {
+	return Run(ctx, "kubectl", "--context", DefaultContext, "delete", "namespace", namespace, "--wait=false")
+}
+
+// developerTokenSecret is the token Secret of the ServiceAccount of the DeveloperUser. Kubernetes
+// 1.24 and later no longer create one for each ServiceAccount, so it's created explicitly.
+const developerTokenSecret = `apiVersion: v1
+kind: Secret
+metadata:
+  name: ` + DeveloperUser + `
+  namespace: default
+  annotations:
+    kubernetes.io/service-account.name: ` + DeveloperUser + `
+type: kubernetes.io/service-account-token
+`
+
+// SetupDeveloperUser applies k8s/client_rbac.yaml and adds the DeveloperUser to the kubeconfig,
+// with the token of the ServiceAccount of the same name. The current context is left unchanged.
+func (h *Harness) SetupDeveloperUser(ctx context.Context) error {
+	if err := Run(ctx, "kubectl", "--context", DefaultContext, "apply", "-f", filepath.Join(h.RootDir, "k8s", "client_rbac.yaml")); err != nil {
+		return err
+	}
+	cmd := dexec.CommandContext(ctx, "kubectl", "--context", DefaultContext, "apply", "-f", "-")
+	cmd.Stdin = strings.NewReader(developerTokenSecret)
+	if err := client.RunError(cmd.Run()); err != nil {
+		return err
+	}
+
+	// The token controller adds the token to the Secret
+	var encToken string
+	err := retry(ctx, 30*time.Second, func() (err error) {
+		encToken, err = Output(ctx, "kubectl", "--context", DefaultContext, "--namespace", "default",
+			"get", "secret", DeveloperUser, "-o", "jsonpath={.data.token}")
+		if err == nil && encToken == "" {
+			err = fmt.Errorf("the Secret %s has no token", DeveloperUser)
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	token, err := base64.StdEncoding.DecodeString(encToken)
+	if err != nil {
+		return err
+	}
+	if err = Run(ctx, "kubectl", "config", "set-credentials", DeveloperUser, "--token", string(token)); err != nil {
+		return err
+	}
+	return Run(ctx, "kubectl", "config", "set-context", DeveloperUser, "--user", DeveloperUser, "--cluster", DefaultContext)
+}
+
+// TeardownDeveloperUser undoes SetupDeveloperUser.
+func (h *Harness) TeardownDeveloperUser(ctx context.Context) {
+	_ = Run(ctx, "kubectl", "--context", DefaultContext, "--namespace", "default", "delete", "secret", DeveloperUser)
+	_ = Run(ctx, "kubectl", "--context", DefaultContext, "delete", "-f", filepath.Join(h.RootDir, "k8s", "client_rbac.yaml"))
+	_ = Run(ctx, "kubectl", "config", "delete-context", DeveloperUser)
+	_ = Run(ctx, "kubectl", "config", "delete-user", DeveloperUser)
+}
+
+// InstallManager installs the traffic-manager in the given namespace using the Helm chart of the
+// source tree. It's given RBAC for the application namespaces and its own namespace. The extra
+// arguments are passed to helm, e.g. "-f" and a values file.
+func (h *Harness) InstallManager(ctx context.Context, managerNamespace string, appNamespaces []string, extraArgs ...string) error {
+	clusterID, err := Output(ctx, "kubectl", "--context", DefaultContext, "get", "ns", "default", "-o", "jsonpath={.metadata.uid}")
+	if err != nil {
+		return err
+	}
+	namespaces := strings.Join(append(appNamespaces, managerNamespace), ",")
+	args := []string{"helm", "--kube-context", DefaultContext, "install", "traffic-manager",
+		"-n", managerNamespace, filepath.Join(h.RootDir, "charts", "telepresence"),
+		"--set", fmt.Sprintf("clusterId=%s", clusterID),
+		"--set", fmt.Sprintf("image.registry=%s", h.Registry),
+		"--set", fmt.Sprintf("image.tag=%s", h.ImageTag()),
+		"--set", fmt.Sprintf("clientRbac.namespaces={%s}", namespaces),
+		"--set", fmt.Sprintf("managerRbac.namespaces={%s}", namespaces),
+	}
+	return Run(ctx, append(args, extraArgs...)...)
+}
+
+// UninstallManager uninstalls the traffic-manager that InstallManager installed.
+func (h *Harness) UninstallManager(ctx context.Context, managerNamespace string) error {
+	return Run(ctx, "helm", "--kube-context", DefaultContext, "uninstall", "traffic-manager", "-n", managerNamespace)
+}
+
+// ApplyApp applies the manifest k8s/<name>.yaml of the source tree to the given namespace and
+// waits until the service that it declares responds on the given port.
+func (h *Harness) ApplyApp(ctx context.Context, namespace, name, svcName string, port int) error {
+	err := h.Kubectl(ctx, namespace, "apply", "-f", filepath.Join(h.RootDir, "k8s", name+".yaml"), "--context", DefaultContext)
+	if err != nil {
+		return fmt.Errorf("failed to deploy %s: %w", name, err)
+	}
+	return h.WaitForService(ctx, namespace, svcName, port)
+}
+
+// ApplyEchoService creates a Deployment of the EchoImage in the given namespace, exposes it as a
+// service on port 80, and waits until the service responds.
+func (h *Harness) ApplyEchoService(ctx context.Context, namespace, name string) error {
+	err := h.Kubectl(ctx, namespace, "--context", DefaultContext, "create", "deploy", name, "--image", EchoImage)
+	if err != nil {
+		return fmt.Errorf("failed to create deployment %s: %w", name, err)
+	}
+	err = h.Kubectl(ctx, namespace, "--context", DefaultContext, "expose", "deploy", name, "--port", "80", "--target-port", "8080")
+	if err != nil {
+		return fmt.Errorf("failed to expose deployment %s: %w", name, err)
+	}
+	return h.WaitForService(ctx, namespace, name, 80)
+}
+
+// WaitForService waits until the given service responds to a request from within the cluster.
+func (h *Harness) WaitForService(ctx context.Context, namespace, name string, port int) error {
+	// Since this function can be called multiple times in parallel
+	// we add the name of the service to the title of the pod so they
+	// can run at the same time. We strip out any characters that we
+	// can't use in a name in k8s.
+	reg := regexp.MustCompile("[^a-zA-Z0-9-]+")
+	k8sSafeName := reg.ReplaceAllString(name, "")
+	containerName := fmt.Sprintf("curl-%s-from-cluster", k8sSafeName)
+	err := retry(ctx, 120*time.Second, func() error {
+		return h.Kubectl(ctx, namespace, "run", containerName, "--context", DefaultContext, "--rm", "-it",
+			"--image=docker.io/pstauffer/curl", "--restart=Never", "--",
+			"curl", "--silent", "--output", "/dev/null",
+			fmt.Sprintf("http://%s.%s:%d", name, namespace, port),
+		)
+	})
+	if err != nil {
+		return fmt.Errorf("timed out waiting for %s service: %w", name, err)
+	}
+	return nil
+}
+
+// retry calls f once a second until it succeeds or the timeout expires, and returns the last error.
+func retry(ctx context.Context, timeout time.Duration, f func() error) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	for {
+		err := f()
+		if err == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(time.Second):
+		}
+	}
+}
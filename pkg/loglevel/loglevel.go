@@ -0,0 +1,108 @@
+// Package loglevel implements log level overrides for the subsystems of the Telepresence processes.
+// A subsystem is assigned to a context using WithSubsystem, and all entries logged using that
+// context, or a context derived from it, are then filtered using the level of that subsystem.
+package loglevel
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/datawire/dlib/dlog"
+)
+
+// SubsystemField is the name of the log field that holds the subsystem
+const SubsystemField = "subsystem"
+
+// The subsystems that can be given levels of their own
+const (
+	DNS      = "dns"
+	Routing  = "routing"
+	Tunnel   = "tunnel"
+	IPC      = "ipc"
+	K8sWatch = "k8s-watch"
+)
+
+// Subsystems are all known subsystems
+var Subsystems = []string{DNS, Routing, Tunnel, IPC, K8sWatch}
+
+// WithSubsystem returns a context that tags log entries with the given subsystem
+func WithSubsystem(ctx context.Context, subsystem string) context.Context {
+	return dlog.WithField(ctx, SubsystemField, subsystem)
+}
+
+// IsSubsystem returns true if the given name is a known subsystem
+func IsSubsystem(name string) bool {
+	for _, s := range Subsystems {
+		if s == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Parse parses a comma separated list of <subsystem>=<level> pairs, e.g. "dns=debug,tunnel=warn"
+func Parse(s string) (map[string]logrus.Level, error) {
+	levels := make(map[string]logrus.Level)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		eq := strings.IndexByte(pair, '=')
+		if eq < 0 {
+			return nil, fmt.Errorf("invalid subsystem level %q, expected <subsystem>=<level>", pair)
+		}
+		name := strings.TrimSpace(pair[:eq])
+		if !IsSubsystem(name) {
+			return nil, fmt.Errorf("unknown subsystem %q, expected one of %s", name, strings.Join(Subsystems, ", "))
+		}
+		level, err := logrus.ParseLevel(strings.TrimSpace(pair[eq+1:]))
+		if err != nil {
+			return nil, err
+		}
+		levels[name] = level
+	}
+	return levels, nil
+}
+
+// FilteringFormatter is a logrus.Formatter that drops the entries of subsystems that are logged
+// below their subsystem's level. Since logrus drops entries below the logger's level before they
+// reach the formatter, the logger must be set to the level given by MaxLevel.
+type FilteringFormatter struct {
+	logrus.Formatter
+	Level      logrus.Level
+	Subsystems map[string]logrus.Level
+}
+
+// MaxLevel returns the most verbose level of the default level and the subsystem levels
+func (f *FilteringFormatter) MaxLevel() logrus.Level {
+	max := f.Level
+	for _, l := range f.Subsystems {
+		if l > max {
+			max = l
+		}
+	}
+	return max
+}
+
+// Enabled returns true if the given entry should be logged
+func (f *FilteringFormatter) Enabled(entry *logrus.Entry) bool {
+	level := f.Level
+	if sub, ok := entry.Data[SubsystemField].(string); ok {
+		if l, ok := f.Subsystems[sub]; ok {
+			level = l
+		}
+	}
+	return entry.Level <= level
+}
+
+// Format implements logrus.Formatter. Entries that aren't enabled produce no output.
+func (f *FilteringFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	if !f.Enabled(entry) {
+		return nil, nil
+	}
+	return f.Formatter.Format(entry)
+}
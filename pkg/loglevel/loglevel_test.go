@@ -0,0 +1,54 @@
+package loglevel
+
+import (
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	levels, err := Parse("")
+	require.NoError(t, err)
+	assert.Empty(t, levels)
+
+	levels, err = Parse(" dns=debug, tunnel = warn ,")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]logrus.Level{DNS: logrus.DebugLevel, Tunnel: logrus.WarnLevel}, levels)
+
+	_, err = Parse("dns")
+	assert.Error(t, err)
+
+	_, err = Parse("kubernetes=debug")
+	assert.Error(t, err)
+
+	_, err = Parse("dns=loud")
+	assert.Error(t, err)
+}
+
+func TestFilteringFormatter(t *testing.T) {
+	f := &FilteringFormatter{
+		Formatter:  &logrus.TextFormatter{},
+		Level:      logrus.InfoLevel,
+		Subsystems: map[string]logrus.Level{DNS: logrus.TraceLevel, Tunnel: logrus.ErrorLevel},
+	}
+	assert.Equal(t, logrus.TraceLevel, f.MaxLevel())
+
+	entry := func(level logrus.Level, subsystem string) *logrus.Entry {
+		e := &logrus.Entry{Level: level, Data: logrus.Fields{}}
+		if subsystem != "" {
+			e.Data[SubsystemField] = subsystem
+		}
+		return e
+	}
+	assert.True(t, f.Enabled(entry(logrus.InfoLevel, "")))
+	assert.False(t, f.Enabled(entry(logrus.DebugLevel, "")))
+	assert.True(t, f.Enabled(entry(logrus.TraceLevel, DNS)))
+	assert.False(t, f.Enabled(entry(logrus.WarnLevel, Tunnel)))
+	assert.False(t, f.Enabled(entry(logrus.DebugLevel, Routing)))
+
+	data, err := f.Format(entry(logrus.WarnLevel, Tunnel))
+	require.NoError(t, err)
+	assert.Nil(t, data)
+}
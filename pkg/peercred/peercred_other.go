@@ -0,0 +1,15 @@
+// +build !linux,!darwin
+
+package peercred
+
+import (
+	"errors"
+	"net"
+	"runtime"
+)
+
+// PeerUID returns the uid of the process at the other end of the given connection. Peer
+// credentials are only supported on Linux and macOS.
+func PeerUID(_ *net.UnixConn) (int, error) {
+	return 0, errors.New("peer credentials are not supported on " + runtime.GOOS)
+}
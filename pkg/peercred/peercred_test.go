@@ -0,0 +1,33 @@
+// +build linux darwin
+
+package peercred
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPeerUID(t *testing.T) {
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: filepath.Join(t.TempDir(), "socket"), Net: "unix"})
+	require.NoError(t, err)
+	defer l.Close()
+
+	go func() {
+		if conn, err := net.Dial("unix", l.Addr().String()); err == nil {
+			defer conn.Close()
+			_, _ = conn.Read(make([]byte, 1))
+		}
+	}()
+	conn, err := l.AcceptUnix()
+	require.NoError(t, err)
+	defer conn.Close()
+
+	uid, err := PeerUID(conn)
+	require.NoError(t, err)
+	assert.Equal(t, os.Getuid(), uid)
+}
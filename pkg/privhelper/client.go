@@ -0,0 +1,158 @@
+// +build linux darwin
+
+package privhelper
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// Available returns true if the privileged helper socket exists.
+func Available() bool {
+	s, err := os.Stat(SocketName)
+	return err == nil && s.Mode()&os.ModeSocket != 0
+}
+
+// Client sends requests to the privileged helper. It implements tun.Privileged.
+type Client struct {
+	socket string
+}
+
+func NewClient() *Client {
+	return &Client{socket: SocketName}
+}
+
+// call sends the request and returns the response together with the file that was passed
+// along with it, if any.
+func (c *Client) call(ctx context.Context, req *request) (*response, *os.File, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "unix", c.socket)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to connect to the privileged helper: %w", err)
+	}
+	defer conn.Close()
+	uc := conn.(*net.UnixConn)
+	if dl, ok := ctx.Deadline(); ok {
+		_ = uc.SetDeadline(dl)
+	}
+
+	data, err := json.Marshal(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err = uc.Write(data); err != nil {
+		return nil, nil, err
+	}
+	_ = uc.CloseWrite()
+
+	buf := make([]byte, 4096)
+	oob := make([]byte, unix.CmsgSpace(4))
+	n, oobn, _, _, err := uc.ReadMsgUnix(buf, oob)
+	if err != nil {
+		return nil, nil, err
+	}
+	var file *os.File
+	if oobn > 0 {
+		msgs, err := unix.ParseSocketControlMessage(oob[:oobn])
+		if err != nil {
+			return nil, nil, err
+		}
+		for i := range msgs {
+			fds, err := unix.ParseUnixRights(&msgs[i])
+			if err == nil && len(fds) > 0 {
+				file = os.NewFile(uintptr(fds[0]), req.Op)
+				break
+			}
+		}
+	}
+	rsp := &response{}
+	if err = json.Unmarshal(buf[:n], rsp); err != nil {
+		if file != nil {
+			_ = file.Close()
+		}
+		return nil, nil, err
+	}
+	if rsp.Error != "" {
+		if file != nil {
+			_ = file.Close()
+		}
+		return nil, nil, fmt.Errorf("privileged helper: %s", rsp.Error)
+	}
+	return rsp, file, nil
+}
+
+func (c *Client) OpenTun(ctx context.Context) (*os.File, string, int32, error) {
+	rsp, file, err := c.call(ctx, &request{Op: opOpenTun})
+	if err != nil {
+		return nil, "", 0, err
+	}
+	if file == nil {
+		return nil, "", 0, errors.New("privileged helper did not pass a TUN device")
+	}
+	return file, rsp.Device, rsp.Index, nil
+}
+
+func (c *Client) AddSubnet(ctx context.Context, name string, subnet *net.IPNet) error {
+	_, _, err := c.call(ctx, &request{Op: opAddSubnet, Device: name, Subnet: subnet.String()})
+	return err
+}
+
+func (c *Client) RemoveSubnet(ctx context.Context, name string, subnet *net.IPNet) error {
+	_, _, err := c.call(ctx, &request{Op: opRemoveSubnet, Device: name, Subnet: subnet.String()})
+	return err
+}
+
+func (c *Client) AddRoute(ctx context.Context, name string, subnet *net.IPNet) error {
+	_, _, err := c.call(ctx, &request{Op: opAddRoute, Device: name, Subnet: subnet.String()})
+	return err
+}
+
+func (c *Client) RemoveRoute(ctx context.Context, name string, subnet *net.IPNet) error {
+	_, _, err := c.call(ctx, &request{Op: opRemoveRoute, Device: name, Subnet: subnet.String()})
+	return err
+}
+
+func (c *Client) SetMTU(ctx context.Context, name string, mtu int) error {
+	_, _, err := c.call(ctx, &request{Op: opSetMTU, Device: name, MTU: mtu})
+	return err
+}
+
+// WriteResolver writes a file with the given name and content to /etc/resolver. Only the files
+// that telepresence creates, i.e. "telepresence.local" and "telepresence.<domain>.local", can be
+// written.
+func (c *Client) WriteResolver(ctx context.Context, name string, content []byte) error {
+	_, _, err := c.call(ctx, &request{Op: opWriteResolver, File: name, Content: string(content)})
+	return err
+}
+
+// RemoveResolver removes a file that was written using WriteResolver.
+func (c *Client) RemoveResolver(ctx context.Context, name string) error {
+	_, _, err := c.call(ctx, &request{Op: opRemoveResolver, File: name})
+	return err
+}
+
+// FlushDNS flushes the DNS cache of the host.
+func (c *Client) FlushDNS(ctx context.Context) error {
+	_, _, err := c.call(ctx, &request{Op: opFlushDNS})
+	return err
+}
+
+// ListenDaemon returns a listener for the daemon socket. The socket is created by the helper
+// since an unprivileged process isn't allowed to create it.
+func (c *Client) ListenDaemon(ctx context.Context) (net.Listener, error) {
+	_, file, err := c.call(ctx, &request{Op: opListenDaemon})
+	if err != nil {
+		return nil, err
+	}
+	if file == nil {
+		return nil, errors.New("privileged helper did not pass a listener")
+	}
+	defer file.Close()
+	return net.FileListener(file)
+}
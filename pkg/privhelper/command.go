@@ -0,0 +1,46 @@
+package privhelper
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/spf13/cobra"
+)
+
+// Command returns the telepresence sub-command "helper-foreground". It's normally started by a
+// systemd unit on Linux and by a launchd daemon on macOS, but the telepresence binary can also be
+// installed setuid root, in which case the allowed uid is the real uid of the invoking user.
+func Command() *cobra.Command {
+	return &cobra.Command{
+		Use:    "helper-foreground [uid]",
+		Short:  "Launch the Telepresence privileged helper in the foreground (debug)",
+		Args:   cobra.MaximumNArgs(1),
+		Hidden: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			uid, err := allowedUID(args, os.Getuid(), os.Geteuid())
+			if err != nil {
+				return err
+			}
+			return Serve(cmd.Context(), uid)
+		},
+	}
+}
+
+// allowedUID returns the uid that the helper serves, given the arguments of the command and the
+// real and effective uid of the process. Only root may serve another user. The argument is ignored
+// when the binary is setuid, since the invoking user could otherwise name any uid.
+func allowedUID(args []string, uid, euid int) (int, error) {
+	if len(args) == 0 || euid != uid {
+		return uid, nil
+	}
+	if uid != 0 {
+		return 0, errors.New("only root may choose the uid that the privileged helper serves")
+	}
+	allowed, err := strconv.Atoi(args[0])
+	if err != nil {
+		return 0, fmt.Errorf("invalid uid %q: %w", args[0], err)
+	}
+	return allowed, nil
+}
@@ -0,0 +1,27 @@
+package privhelper
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAllowedUID(t *testing.T) {
+	uid, err := allowedUID(nil, 1000, 1000)
+	assert.NoError(t, err)
+	assert.Equal(t, 1000, uid)
+
+	uid, err = allowedUID([]string{"1000"}, 0, 0)
+	assert.NoError(t, err, "root chooses the uid, e.g. in the systemd unit")
+	assert.Equal(t, 1000, uid)
+
+	_, err = allowedUID([]string{"1001"}, 1000, 1000)
+	assert.EqualError(t, err, "only root may choose the uid that the privileged helper serves")
+
+	uid, err = allowedUID([]string{"1001"}, 1000, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 1000, uid, "a setuid helper serves the invoking user")
+
+	_, err = allowedUID([]string{"alice"}, 0, 0)
+	assert.Error(t, err)
+}
@@ -0,0 +1,43 @@
+// Package privhelper contains a small privileged helper that performs the few operations that
+// require root privileges on behalf of an unprivileged daemon. The helper listens to a unix socket
+// and only accepts requests from processes owned by the user that it was installed for.
+//
+// Each request is sent on a connection of its own. The request and the response are JSON encoded
+// and file descriptors, such as the TUN device, are passed using SCM_RIGHTS.
+package privhelper
+
+// SocketName is the path used when communicating with the privileged helper
+const SocketName = "/var/run/telepresence-helper.socket"
+
+const (
+	opOpenTun      = "open-tun"
+	opAddSubnet    = "add-subnet"
+	opRemoveSubnet = "remove-subnet"
+	opAddRoute     = "add-route"
+	opRemoveRoute  = "remove-route"
+	opSetMTU       = "set-mtu"
+	opListenDaemon = "listen-daemon"
+
+	// The resolver operations are only used on darwin, where the DNS configuration is in files
+	// under /etc/resolver.
+	opWriteResolver  = "write-resolver"
+	opRemoveResolver = "remove-resolver"
+	opFlushDNS       = "flush-dns"
+)
+
+type request struct {
+	Op     string `json:"op"`
+	Device string `json:"device,omitempty"`
+	Subnet string `json:"subnet,omitempty"`
+	MTU    int    `json:"mtu,omitempty"`
+
+	// File and Content are the name and content of a resolver file
+	File    string `json:"file,omitempty"`
+	Content string `json:"content,omitempty"`
+}
+
+type response struct {
+	Error  string `json:"error,omitempty"`
+	Device string `json:"device,omitempty"`
+	Index  int32  `json:"index,omitempty"`
+}
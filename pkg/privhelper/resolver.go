@@ -0,0 +1,45 @@
+package privhelper
+
+import (
+	"bufio"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// resolverDir is the directory of the macOS resolver files, see "man 5 resolver".
+const resolverDir = "/etc/resolver"
+
+// maxResolverSize is the maximum size of a resolver file written by the helper.
+const maxResolverSize = 4096
+
+var resolverFileRx = regexp.MustCompile(`^telepresence(\.[A-Za-z0-9_-]+)*\.local$`)
+
+// checkResolverFile ensures that only the resolver files that telepresence creates, i.e.
+// "telepresence.local" and "telepresence.<domain>.local", can be written or removed.
+func checkResolverFile(name string) error {
+	if !resolverFileRx.MatchString(name) {
+		return fmt.Errorf("%q is not a telepresence resolver file", name)
+	}
+	return nil
+}
+
+// checkResolverContent ensures that a resolver file only contains the keys that telepresence uses.
+func checkResolverContent(content string) error {
+	if len(content) > maxResolverSize {
+		return fmt.Errorf("resolver file is larger than %d bytes", maxResolverSize)
+	}
+	sc := bufio.NewScanner(strings.NewReader(content))
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		switch fields[0] {
+		case "port", "domain", "nameserver", "search":
+		default:
+			return fmt.Errorf("%q is not an allowed resolver key", fields[0])
+		}
+	}
+	return sc.Err()
+}
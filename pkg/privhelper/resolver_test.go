@@ -0,0 +1,41 @@
+package privhelper
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckResolverFile(t *testing.T) {
+	for _, name := range []string{
+		"telepresence.local",
+		"telepresence.default.local",
+		"telepresence.tel2-search.local",
+		"telepresence.example.com.local",
+	} {
+		assert.NoError(t, checkResolverFile(name), name)
+	}
+	for _, name := range []string{
+		"",
+		"resolv.conf",
+		"telepresence",
+		"telepresence..local",
+		"telepresence.local/../../hosts",
+		"../telepresence.local",
+		"telepresence.a b.local",
+	} {
+		assert.Error(t, checkResolverFile(name), name)
+	}
+}
+
+func TestCheckResolverContent(t *testing.T) {
+	assert.NoError(t, checkResolverContent(`# Generated by telepresence
+port 53
+domain cluster.local
+nameserver 127.0.0.1
+search default cluster.local
+`))
+	assert.EqualError(t, checkResolverContent("port 53\noptions ndots:5\n"), `"options" is not an allowed resolver key`)
+	assert.Error(t, checkResolverContent(strings.Repeat("# padding\n", maxResolverSize/10+1)))
+}
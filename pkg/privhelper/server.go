@@ -0,0 +1,253 @@
+// +build linux darwin
+
+package privhelper
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/client"
+	"github.com/telepresenceio/telepresence/v2/pkg/peercred"
+	"github.com/telepresenceio/telepresence/v2/pkg/tun"
+)
+
+// Serve listens on SocketName and performs privileged operations on behalf of processes owned by
+// the given uid. It must run as root.
+func Serve(ctx context.Context, uid int) error {
+	if os.Geteuid() != 0 {
+		return errors.New("the privileged helper must run as root")
+	}
+	if err := removeStaleSocket(SocketName); err != nil {
+		return err
+	}
+	origUmask := unix.Umask(0)
+	l, err := net.Listen("unix", SocketName)
+	unix.Umask(origUmask)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = l.Close()
+		_ = os.Remove(SocketName)
+	}()
+
+	dlog.Infof(ctx, "Privileged helper listening on %s, accepting requests from uid %d", SocketName, uid)
+	return newServer(uid).serve(ctx, l.(*net.UnixListener))
+}
+
+// server performs the requests of the processes of one user. It remembers the TUN devices that it
+// has opened, so that no other network interface of the host can be configured through it.
+type server struct {
+	uid          int
+	daemonSocket string
+
+	devicesLock sync.Mutex
+	devices     map[string]struct{}
+}
+
+func newServer(uid int) *server {
+	return &server{
+		uid:          uid,
+		daemonSocket: client.DaemonSocketName,
+		devices:      make(map[string]struct{}),
+	}
+}
+
+func (s *server) serve(ctx context.Context, l *net.UnixListener) error {
+	go func() {
+		<-ctx.Done()
+		_ = l.Close()
+	}()
+	for {
+		conn, err := l.AcceptUnix()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		go s.handle(ctx, conn)
+	}
+}
+
+func (s *server) handle(ctx context.Context, conn *net.UnixConn) {
+	defer conn.Close()
+	var file *os.File
+	rsp, err := func() (*response, error) {
+		if err := checkPeer(conn, s.uid); err != nil {
+			return nil, err
+		}
+		req := &request{}
+		if err := json.NewDecoder(conn).Decode(req); err != nil {
+			return nil, err
+		}
+		dlog.Debugf(ctx, "privileged helper request %s %s%s", req.Op, req.Device, req.File)
+		var rsp *response
+		var err error
+		rsp, file, err = s.perform(ctx, req)
+		return rsp, err
+	}()
+	if err != nil {
+		dlog.Error(ctx, err)
+		rsp = &response{Error: err.Error()}
+	}
+	data, err := json.Marshal(rsp)
+	if err != nil {
+		dlog.Error(ctx, err)
+		return
+	}
+	var oob []byte
+	if file != nil {
+		defer file.Close()
+		oob = unix.UnixRights(int(file.Fd()))
+	}
+	if _, _, err = conn.WriteMsgUnix(data, oob, nil); err != nil {
+		dlog.Error(ctx, err)
+	}
+}
+
+// checkPeer ensures that the peer process is owned by the given uid or by root
+func checkPeer(conn *net.UnixConn, uid int) error {
+	peer, err := peercred.PeerUID(conn)
+	if err != nil {
+		return err
+	}
+	return checkUID(peer, uid)
+}
+
+func checkUID(peer, uid int) error {
+	if peer != uid && peer != 0 {
+		return fmt.Errorf("uid %d is not allowed to use the privileged helper", peer)
+	}
+	return nil
+}
+
+// device returns the device with the given name, provided that it was opened by this helper
+func (s *server) device(name string) (*tun.Device, error) {
+	s.devicesLock.Lock()
+	_, ok := s.devices[name]
+	s.devicesLock.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("%q is not a telepresence device", name)
+	}
+	return tun.DeviceByName(name)
+}
+
+// parseSubnet parses a subnet in CIDR notation. Unlike net.ParseCIDR, it retains the address of
+// the subnet, e.g. 10.0.0.1/24 isn't turned into 10.0.0.0/24.
+func parseSubnet(s string) (*net.IPNet, error) {
+	ip, subnet, err := net.ParseCIDR(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(subnet.IP) == net.IPv4len {
+		ip = ip.To4()
+	}
+	return &net.IPNet{IP: ip, Mask: subnet.Mask}, nil
+}
+
+func (s *server) perform(ctx context.Context, req *request) (*response, *os.File, error) {
+	switch req.Op {
+	case opOpenTun:
+		dev, err := tun.OpenTun()
+		if err != nil {
+			return nil, nil, err
+		}
+		iface, err := net.InterfaceByName(dev.Name())
+		if err != nil {
+			_ = dev.Close()
+			return nil, nil, err
+		}
+		s.devicesLock.Lock()
+		s.devices[dev.Name()] = struct{}{}
+		s.devicesLock.Unlock()
+		return &response{Device: dev.Name(), Index: int32(iface.Index)}, dev.File, nil
+	case opAddSubnet, opRemoveSubnet, opAddRoute, opRemoveRoute:
+		dev, err := s.device(req.Device)
+		if err != nil {
+			return nil, nil, err
+		}
+		subnet, err := parseSubnet(req.Subnet)
+		if err != nil {
+			return nil, nil, err
+		}
+		switch req.Op {
+		case opAddSubnet:
+			err = dev.AddSubnet(ctx, subnet)
+		case opRemoveSubnet:
+			err = dev.RemoveSubnet(ctx, subnet)
+		case opAddRoute:
+			err = dev.AddRoute(ctx, subnet)
+		default:
+			err = dev.RemoveRoute(ctx, subnet)
+		}
+		return &response{}, nil, err
+	case opSetMTU:
+		dev, err := s.device(req.Device)
+		if err != nil {
+			return nil, nil, err
+		}
+		return &response{}, nil, dev.SetMTU(req.MTU)
+	case opWriteResolver:
+		if err := checkResolverFile(req.File); err != nil {
+			return nil, nil, err
+		}
+		if err := checkResolverContent(req.Content); err != nil {
+			return nil, nil, err
+		}
+		return &response{}, nil, writeResolver(req.File, req.Content)
+	case opRemoveResolver:
+		if err := checkResolverFile(req.File); err != nil {
+			return nil, nil, err
+		}
+		return &response{}, nil, removeResolver(req.File)
+	case opFlushDNS:
+		return &response{}, nil, flushDNS(ctx)
+	case opListenDaemon:
+		if err := removeStaleSocket(s.daemonSocket); err != nil {
+			return nil, nil, err
+		}
+		origUmask := unix.Umask(0)
+		l, err := net.Listen("unix", s.daemonSocket)
+		unix.Umask(origUmask)
+		if err != nil {
+			return nil, nil, err
+		}
+		ul := l.(*net.UnixListener)
+		// The daemon owns the socket from now on
+		ul.SetUnlinkOnClose(false)
+		file, err := ul.File()
+		_ = ul.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+		return &response{}, file, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown operation %q", req.Op)
+	}
+}
+
+// removeStaleSocket removes the given socket if it exists but nothing is listening to it
+func removeStaleSocket(path string) error {
+	if !client.SocketExists(path) {
+		return nil
+	}
+	conn, err := net.Dial("unix", path)
+	if err == nil {
+		_ = conn.Close()
+		return fmt.Errorf("socket %q is in use", path)
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return os.Remove(path)
+	}
+	return err
+}
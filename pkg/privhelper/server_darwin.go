@@ -0,0 +1,26 @@
+package privhelper
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/client/daemon/dns"
+)
+
+func writeResolver(name, content string) error {
+	if err := os.MkdirAll(resolverDir, 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(resolverDir, name), []byte(content), 0644)
+}
+
+func removeResolver(name string) error {
+	return os.Remove(filepath.Join(resolverDir, name))
+}
+
+func flushDNS(ctx context.Context) error {
+	dns.Flush(ctx)
+	return nil
+}
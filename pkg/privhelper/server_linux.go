@@ -0,0 +1,22 @@
+package privhelper
+
+import (
+	"context"
+	"errors"
+)
+
+// errNoResolver is returned by the resolver operations, which are only used on darwin. Linux DNS is
+// configured through systemd-resolved, which the daemon is allowed to use by a polkit rule.
+var errNoResolver = errors.New("resolver files are not used on linux")
+
+func writeResolver(_, _ string) error {
+	return errNoResolver
+}
+
+func removeResolver(_ string) error {
+	return errNoResolver
+}
+
+func flushDNS(_ context.Context) error {
+	return errNoResolver
+}
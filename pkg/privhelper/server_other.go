@@ -0,0 +1,14 @@
+// +build !linux,!darwin
+
+package privhelper
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+)
+
+// Serve is not yet implemented on this platform
+func Serve(_ context.Context, _ int) error {
+	return fmt.Errorf("the privileged helper is not supported on %s", runtime.GOOS)
+}
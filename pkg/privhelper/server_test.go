@@ -0,0 +1,106 @@
+// +build linux darwin
+
+package privhelper
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/datawire/dlib/dlog"
+)
+
+// testServer starts a helper that listens to a socket in a temporary directory, and returns a
+// client that talks to it.
+func testServer(t *testing.T) (context.Context, *server, *Client) {
+	dir := t.TempDir()
+	socket := filepath.Join(dir, "helper.socket")
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: socket, Net: "unix"})
+	require.NoError(t, err)
+
+	s := newServer(os.Getuid())
+	s.daemonSocket = filepath.Join(dir, "daemon.socket")
+	ctx, cancel := context.WithCancel(dlog.NewTestContext(t, false))
+	done := make(chan error, 1)
+	go func() {
+		done <- s.serve(ctx, l)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		assert.NoError(t, <-done)
+	})
+	return ctx, s, &Client{socket: socket}
+}
+
+func TestServer_listenDaemon(t *testing.T) {
+	ctx, s, c := testServer(t)
+	l, err := c.ListenDaemon(ctx)
+	require.NoError(t, err)
+	defer l.Close()
+
+	go func() {
+		if conn, err := net.Dial("unix", s.daemonSocket); err == nil {
+			_, _ = conn.Write([]byte("hello"))
+			_ = conn.Close()
+		}
+	}()
+	conn, err := l.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+	buf := make([]byte, 5)
+	_, err = conn.Read(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(buf))
+
+	// The socket is in use, so a second daemon can't listen to it
+	_, err = c.ListenDaemon(ctx)
+	assert.Error(t, err)
+}
+
+func TestServer_rejects(t *testing.T) {
+	ctx, _, c := testServer(t)
+	_, subnet, _ := net.ParseCIDR("192.0.2.0/24")
+
+	err := c.AddSubnet(ctx, "eth0", subnet)
+	assert.EqualError(t, err, `privileged helper: "eth0" is not a telepresence device`)
+
+	err = c.SetMTU(ctx, "lo", 1500)
+	assert.EqualError(t, err, `privileged helper: "lo" is not a telepresence device`)
+
+	err = c.WriteResolver(ctx, "../hosts", []byte("nameserver 127.0.0.1\n"))
+	assert.EqualError(t, err, `privileged helper: "../hosts" is not a telepresence resolver file`)
+
+	err = c.WriteResolver(ctx, "telepresence.local", []byte("options ndots:5\n"))
+	assert.EqualError(t, err, `privileged helper: "options" is not an allowed resolver key`)
+
+	err = c.RemoveResolver(ctx, "resolv.conf")
+	assert.EqualError(t, err, `privileged helper: "resolv.conf" is not a telepresence resolver file`)
+
+	_, _, err = c.call(ctx, &request{Op: "reboot"})
+	assert.EqualError(t, err, `privileged helper: unknown operation "reboot"`)
+}
+
+func TestCheckUID(t *testing.T) {
+	assert.NoError(t, checkUID(1000, 1000))
+	assert.NoError(t, checkUID(0, 1000))
+	assert.EqualError(t, checkUID(1001, 1000), "uid 1001 is not allowed to use the privileged helper")
+}
+
+func TestParseSubnet(t *testing.T) {
+	subnet, err := parseSubnet("10.0.0.1/24")
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.1/24", subnet.String())
+	assert.Len(t, subnet.IP, net.IPv4len)
+
+	subnet, err = parseSubnet("fd00::1/64")
+	require.NoError(t, err)
+	assert.Equal(t, "fd00::1/64", subnet.String())
+
+	_, err = parseSubnet("10.0.0.1")
+	assert.Error(t, err)
+}
@@ -0,0 +1,156 @@
+// Package propagation helps the handler of a personal intercept propagate the headers that the
+// intercept matches to the downstream calls that it makes. A request that is routed to the handler
+// because of such a header will then be routed to other intercepted services of the same developer
+// as it travels through a chain of services.
+//
+// The package only uses the standard library, so that it can be used by any Go program:
+//
+//	p, err := propagation.FromEnvironment(ctx)
+//	...
+//	http.Handle("/", p.Middleware(myHandler))
+//	client := &http.Client{Transport: p.Transport(nil)}
+//
+// The myHandler must pass the context of its request on to the requests that it makes with the
+// client, e.g. using http.NewRequestWithContext.
+package propagation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+)
+
+// DefaultHeader is the header that a personal intercept matches when it's created with
+// "--http-match=auto". It's always propagated.
+const DefaultHeader = "x-telepresence-intercept-id"
+
+// MetadataURLEnv is the environment variable that holds the URL of the metadata endpoint that
+// "telepresence intercept" serves to the command that it runs.
+const MetadataURLEnv = "TELEPRESENCE_METADATA_URL"
+
+// Propagator propagates a set of headers from the requests that a handler serves to the requests
+// that it makes.
+type Propagator struct {
+	headers []string
+}
+
+type contextKey struct{}
+
+// New returns a Propagator for DefaultHeader and the given headers.
+func New(headers ...string) *Propagator {
+	seen := map[string]bool{}
+	p := &Propagator{}
+	for _, h := range append([]string{DefaultHeader}, headers...) {
+		if h = http.CanonicalHeaderKey(h); h != "" && !seen[h] {
+			seen[h] = true
+			p.headers = append(p.headers, h)
+		}
+	}
+	sort.Strings(p.headers)
+	return p
+}
+
+// FromEnvironment returns a Propagator for DefaultHeader and the headers of the intercept that the
+// process runs under. The headers are read from the metadata endpoint that MetadataURLEnv points
+// to. When the variable isn't set, e.g. because the process doesn't run under an intercept or it
+// was started by other means than "telepresence intercept", then only DefaultHeader is propagated.
+func FromEnvironment(ctx context.Context) (*Propagator, error) {
+	url := os.Getenv(MetadataURLEnv)
+	if url == "" {
+		return New(), nil
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get the intercept metadata: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unable to get the intercept metadata: %s", resp.Status)
+	}
+	var md struct {
+		Headers map[string]string `json:"headers"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&md); err != nil {
+		return nil, fmt.Errorf("unable to decode the intercept metadata: %w", err)
+	}
+	headers := make([]string, 0, len(md.Headers))
+	for h := range md.Headers {
+		headers = append(headers, h)
+	}
+	return New(headers...), nil
+}
+
+// Headers returns the canonical names of the headers that are propagated.
+func (p *Propagator) Headers() []string {
+	return append([]string(nil), p.headers...)
+}
+
+// Extract returns a context that carries the propagated headers of the given header.
+func (p *Propagator) Extract(ctx context.Context, header http.Header) context.Context {
+	var found http.Header
+	for _, h := range p.headers {
+		if vs := header.Values(h); len(vs) > 0 {
+			if found == nil {
+				found = make(http.Header)
+			}
+			found[h] = append([]string(nil), vs...)
+		}
+	}
+	if found == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, contextKey{}, found)
+}
+
+// Inject adds the headers that the given context carries to the given header. Headers that are
+// already present are left as they are.
+func Inject(ctx context.Context, header http.Header) {
+	found, _ := ctx.Value(contextKey{}).(http.Header)
+	for h, vs := range found {
+		if _, ok := header[h]; !ok {
+			header[h] = append([]string(nil), vs...)
+		}
+	}
+}
+
+// Middleware returns a handler that calls the given handler with a request whose context carries
+// the propagated headers of the request.
+func (p *Propagator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if pctx := p.Extract(ctx, r.Header); pctx != ctx {
+			r = r.WithContext(pctx)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Transport returns a round tripper that adds the headers that the context of a request carries
+// to the request before it's sent by the given round tripper, or by http.DefaultTransport when
+// the given round tripper is nil.
+func (p *Propagator) Transport(rt http.RoundTripper) http.RoundTripper {
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		if found, _ := req.Context().Value(contextKey{}).(http.Header); len(found) > 0 {
+			// A round tripper must not modify the request that it's given
+			req = req.Clone(req.Context())
+			Inject(req.Context(), req.Header)
+		}
+		return rt.RoundTrip(req)
+	})
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
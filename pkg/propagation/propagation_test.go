@@ -0,0 +1,69 @@
+package propagation
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew(t *testing.T) {
+	assert.Equal(t, []string{"X-Telepresence-Intercept-Id"}, New().Headers())
+	assert.Equal(t, []string{"X-Telepresence-Intercept-Id", "X-User"}, New("x-user", "X-USER", "").Headers())
+}
+
+func TestFromEnvironment(t *testing.T) {
+	md := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"id":"abc:echo","headers":{"x-user":"alice","x-tenant":"a.*"}}`)
+	}))
+	defer md.Close()
+
+	defer os.Setenv(MetadataURLEnv, os.Getenv(MetadataURLEnv))
+	os.Unsetenv(MetadataURLEnv)
+	p, err := FromEnvironment(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"X-Telepresence-Intercept-Id"}, p.Headers())
+
+	os.Setenv(MetadataURLEnv, md.URL)
+	p, err = FromEnvironment(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"X-Telepresence-Intercept-Id", "X-Tenant", "X-User"}, p.Headers())
+}
+
+func TestPropagation(t *testing.T) {
+	var downstream http.Header
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		downstream = r.Header.Clone()
+	}))
+	defer backend.Close()
+
+	p := New("x-user")
+	client := &http.Client{Transport: p.Transport(nil)}
+	frontend := httptest.NewServer(p.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, backend.URL, nil)
+		require.NoError(t, err)
+		req.Header.Set("X-User", "kept")
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+	})))
+	defer frontend.Close()
+
+	req, err := http.NewRequest(http.MethodGet, frontend.URL, nil)
+	require.NoError(t, err)
+	req.Header.Set(DefaultHeader, "abc:echo")
+	req.Header.Set("X-User", "alice")
+	req.Header.Set("X-Other", "dropped")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	assert.Equal(t, "abc:echo", downstream.Get(DefaultHeader))
+	assert.Equal(t, "kept", downstream.Get("X-User"))
+	assert.Empty(t, downstream.Get("X-Other"))
+}
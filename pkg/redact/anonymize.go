@@ -0,0 +1,143 @@
+package redact
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// keptNames are names that are the same in all clusters, so they reveal nothing and are kept for
+// readability.
+var keptNames = map[string]bool{
+	"default":         true,
+	"kube-system":     true,
+	"kube-public":     true,
+	"kube-node-lease": true,
+	"localhost":       true,
+	"svc":             true,
+	"cluster":         true,
+	"local":           true,
+}
+
+var (
+	// nameRx matches the tokens that may be names. Dotted tokens are matched as a whole so that
+	// they can be looked up before their parts are.
+	nameRx = regexp.MustCompile(`[A-Za-z0-9_-]+(?:\.[A-Za-z0-9_-]+)*`)
+
+	// urlHostRx matches the host of a URL
+	urlHostRx = regexp.MustCompile(`(?i)(\b[a-z][a-z0-9+.-]*://)([^\s/:"'\]]+|\[[0-9a-f:]+\])`)
+
+	// svcNameRx matches the "<service>.<namespace>.svc" DNS names of cluster services
+	svcNameRx = regexp.MustCompile(`\b([a-z0-9](?:[a-z0-9-]*[a-z0-9])?)\.([a-z0-9](?:[a-z0-9-]*[a-z0-9])?)\.svc\b`)
+)
+
+// Anonymizer replaces names that identify a cluster, such as namespaces, workload names, and the
+// hosts of API server URLs, with a placeholder that is derived from a keyed hash of the name. The
+// same name is always replaced with the same placeholder, so the anonymized text can still be
+// followed, but the key is random, so the names can't be recovered by hashing candidates.
+type Anonymizer struct {
+	key   []byte
+	names map[string]string // name -> kind
+}
+
+// NewAnonymizer creates an Anonymizer that uses the given key, or a random key if it's nil.
+func NewAnonymizer(key []byte) *Anonymizer {
+	if key == nil {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			panic(err)
+		}
+	}
+	return &Anonymizer{key: key, names: make(map[string]string)}
+}
+
+// AddName adds a name that will be anonymized. The kind, e.g. "namespace", becomes the prefix of
+// its placeholder.
+func (a *Anonymizer) AddName(kind, name string) {
+	if name != "" && !keptNames[name] {
+		a.names[name] = kind
+	}
+}
+
+func (a *Anonymizer) placeholder(kind, name string) string {
+	h := hmac.New(sha256.New, a.key)
+	_, _ = h.Write([]byte(name))
+	return kind + "-" + hex.EncodeToString(h.Sum(nil))[:8]
+}
+
+func (a *Anonymizer) replaceName(name string) string {
+	if kind, ok := a.names[name]; ok {
+		return a.placeholder(kind, name)
+	}
+	// Intercept names are "<workload>-<namespace>" unless the intercept was given a name
+	for i := strings.IndexByte(name, '-'); i > 0; i = nextDash(name, i) {
+		left, right := name[:i], name[i+1:]
+		if _, ok := a.names[left]; ok {
+			if _, ok = a.names[right]; ok || keptNames[right] {
+				return a.replaceName(left) + "-" + a.replaceName(right)
+			}
+		}
+	}
+	return name
+}
+
+func nextDash(s string, i int) int {
+	if j := strings.IndexByte(s[i+1:], '-'); j >= 0 {
+		return i + 1 + j
+	}
+	return -1
+}
+
+// String anonymizes the given text. Besides the names that have been added, the hosts of URLs and
+// the service and namespace of "<service>.<namespace>.svc" names are anonymized.
+func (a *Anonymizer) String(s string) string {
+	s = urlHostRx.ReplaceAllStringFunc(s, func(m string) string {
+		sm := urlHostRx.FindStringSubmatch(m)
+		host := sm[2]
+		if keptNames[host] || strings.HasPrefix(host, "127.") || host == "[::1]" {
+			return m
+		}
+		return sm[1] + a.placeholder("host", host)
+	})
+	s = svcNameRx.ReplaceAllStringFunc(s, func(m string) string {
+		sm := svcNameRx.FindStringSubmatch(m)
+		svc, ns := sm[1], sm[2]
+		if _, ok := a.names[svc]; !ok {
+			svc = a.placeholder("service", svc)
+		}
+		if _, ok := a.names[ns]; !ok && !keptNames[ns] {
+			ns = a.placeholder("namespace", ns)
+		}
+		return svc + "." + ns + ".svc"
+	})
+	if len(a.names) == 0 {
+		return s
+	}
+	return nameRx.ReplaceAllStringFunc(s, func(m string) string {
+		if _, ok := a.names[m]; ok || !strings.Contains(m, ".") {
+			return a.replaceName(m)
+		}
+		parts := strings.Split(m, ".")
+		for i, p := range parts {
+			parts[i] = a.replaceName(p)
+		}
+		return strings.Join(parts, ".")
+	})
+}
+
+// Copy copies text from src to dst line by line, anonymizing each line.
+func (a *Anonymizer) Copy(dst io.Writer, src io.Reader) error {
+	sc := bufio.NewScanner(src)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		if _, err := io.WriteString(dst, a.String(sc.Text())+"\n"); err != nil {
+			return err
+		}
+	}
+	return sc.Err()
+}
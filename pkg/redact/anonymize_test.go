@@ -0,0 +1,49 @@
+package redact
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAnonymizer_String(t *testing.T) {
+	a := NewAnonymizer([]byte("key"))
+	a.AddName("namespace", "payments")
+	a.AddName("namespace", "default")
+	a.AddName("workload", "billing-api")
+	ns := a.placeholder("namespace", "payments")
+	wl := a.placeholder("workload", "billing-api")
+
+	assert.Equal(t,
+		`intercept `+wl+`-`+ns+` of `+wl+` in namespace "`+ns+`"`,
+		a.String(`intercept billing-api-payments of billing-api in namespace "payments"`))
+	assert.Equal(t, "billing-apis in default", a.String("billing-apis in default"))
+	assert.Equal(t, wl+"-default", a.String("billing-api-default"))
+
+	// The hosts of URLs are anonymized even when they haven't been added, but local ones are kept
+	host := a.placeholder("host", "k8s.acme.example.com")
+	assert.Equal(t, "server https://"+host+":6443 and http://localhost:8080/",
+		a.String("server https://k8s.acme.example.com:6443 and http://localhost:8080/"))
+
+	// So are the services and namespaces of cluster DNS names
+	assert.Equal(t,
+		wl+"."+ns+".svc.cluster.local and "+a.placeholder("service", "redis")+"."+a.placeholder("namespace", "cache")+".svc",
+		a.String("billing-api.payments.svc.cluster.local and redis.cache.svc"))
+
+	// The placeholders depend on the key
+	assert.NotEqual(t, ns, NewAnonymizer(nil).placeholder("namespace", "payments"))
+}
+
+func TestAnonymizer_Copy(t *testing.T) {
+	a := NewAnonymizer(nil)
+	a.AddName("namespace", "payments")
+	out := &bytes.Buffer{}
+	require.NoError(t, a.Copy(out, strings.NewReader("one payments\ntwo payments\n")))
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(t, lines, 2)
+	assert.NotContains(t, out.String(), "payments")
+	assert.Equal(t, strings.TrimPrefix(lines[0], "one"), strings.TrimPrefix(lines[1], "two"))
+}
@@ -0,0 +1,135 @@
+// Package redact contains the rules used to keep secrets out of environment files, logs, and
+// gathered logs.
+package redact
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Mask is what a redacted value is replaced with
+const Mask = "[REDACTED]"
+
+// defaultKeys are glob patterns matched case-insensitively against environment variable names and
+// HTTP header names. The value of a matching key is considered a secret.
+var defaultKeys = []string{
+	"*TOKEN*",
+	"*SECRET*",
+	"*PASSWORD*",
+	"*PASSWD*",
+	"*API_KEY*",
+	"*APIKEY*",
+	"*PRIVATE_KEY*",
+	"*CREDENTIAL*",
+	"AUTHORIZATION",
+	"PROXY-AUTHORIZATION",
+	"COOKIE",
+	"SET-COOKIE",
+	"X-API-KEY",
+}
+
+// defaultPatterns match secrets in free text. The first sub-match, if any, is kept so that the
+// reader can still tell what was redacted.
+var defaultPatterns = []string{
+	`(?i)((?:proxy-)?authorization\s*[:=]\s*"?)[^"\n]+`,
+	`(?i)((?:set-)?cookie\s*[:=]\s*"?)[^"\n]+`,
+	`(?i)(bearer\s+)[A-Za-z0-9._~+/-]+=*`,
+	`(?i)((?:api[_-]?key|token|secret|password)"?\s*[:=]\s*"?)[^\s",]+`,
+	`eyJ[A-Za-z0-9_-]{8,}\.[A-Za-z0-9_-]{8,}\.[A-Za-z0-9_-]{8,}`,
+}
+
+// Redactor redacts secrets from key/value pairs and from free text.
+type Redactor struct {
+	keys     []string
+	patterns []*regexp.Regexp
+}
+
+var defaultRedactor = func() *Redactor {
+	r, err := New(nil, nil)
+	if err != nil {
+		panic(err)
+	}
+	return r
+}()
+
+// Default returns a Redactor that only uses the built-in rules.
+func Default() *Redactor {
+	return defaultRedactor
+}
+
+// New creates a Redactor that uses the built-in rules extended with the given key glob patterns
+// and regular expressions.
+func New(keys, patterns []string) (*Redactor, error) {
+	r := &Redactor{}
+	for _, k := range append(append([]string{}, defaultKeys...), keys...) {
+		k = strings.ToUpper(k)
+		if _, err := path.Match(k, ""); err != nil {
+			return nil, fmt.Errorf("invalid redact key pattern %q: %w", k, err)
+		}
+		r.keys = append(r.keys, k)
+	}
+	for _, p := range append(append([]string{}, defaultPatterns...), patterns...) {
+		rx, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redact pattern %q: %w", p, err)
+		}
+		r.patterns = append(r.patterns, rx)
+	}
+	return r, nil
+}
+
+// IsSecretKey returns true if the value of the given key should be redacted
+func (r *Redactor) IsSecretKey(key string) bool {
+	key = strings.ToUpper(key)
+	for _, k := range r.keys {
+		if ok, _ := path.Match(k, key); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Value returns the given value, or Mask if the key is a secret key.
+func (r *Redactor) Value(key, value string) string {
+	if value != "" && r.IsSecretKey(key) {
+		return Mask
+	}
+	return value
+}
+
+// Env returns a copy of the given environment where the values of all secret keys are masked.
+func (r *Redactor) Env(env map[string]string) map[string]string {
+	cp := make(map[string]string, len(env))
+	for k, v := range env {
+		cp[k] = r.Value(k, v)
+	}
+	return cp
+}
+
+// String redacts all secrets found in the given text.
+func (r *Redactor) String(s string) string {
+	for _, rx := range r.patterns {
+		if rx.NumSubexp() > 0 {
+			s = rx.ReplaceAllString(s, "${1}"+Mask)
+		} else {
+			s = rx.ReplaceAllString(s, Mask)
+		}
+	}
+	return s
+}
+
+// Copy copies text from src to dst line by line, redacting all secrets found.
+func (r *Redactor) Copy(dst io.Writer, src io.Reader) error {
+	sc := bufio.NewScanner(src)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		if _, err := io.WriteString(dst, r.String(sc.Text())+"\n"); err != nil {
+			return err
+		}
+	}
+	return sc.Err()
+}
@@ -173,3 +173,30 @@ func Covers(a, b *net.IPNet) bool {
 	}
 	return a.Contains(m)
 }
+
+// Exclude returns the subnets that together contain all addresses of subnet a except the ones in
+// subnet b. The result is a itself when the subnets don't overlap and empty when b covers a.
+// Otherwise, it's the sibling of each subnet on the path from a down to b, so excluding a subnet
+// whose prefix is n bits longer than the prefix of a results in n subnets.
+func Exclude(a, b *net.IPNet) []*net.IPNet {
+	if Covers(b, a) {
+		return nil
+	}
+	if !Covers(a, b) {
+		return []*net.IPNet{a}
+	}
+	ip := b.IP.To4()
+	if ip == nil || len(a.Mask) != net.IPv4len {
+		ip = b.IP.To16()
+	}
+	aOnes, bits := a.Mask.Size()
+	bOnes, _ := b.Mask.Size()
+	subnets := make([]*net.IPNet, 0, bOnes-aOnes)
+	for ones := aOnes + 1; ones <= bOnes; ones++ {
+		mask := net.CIDRMask(ones, bits)
+		sibling := ip.Mask(mask)
+		sibling[(ones-1)/8] ^= 0x80 >> uint((ones-1)%8)
+		subnets = append(subnets, &net.IPNet{IP: sibling, Mask: mask})
+	}
+	return subnets
+}
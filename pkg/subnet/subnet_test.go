@@ -187,3 +187,41 @@ func TestUnique(t *testing.T) {
 		})
 	}
 }
+
+func TestExclude(t *testing.T) {
+	cidr := func(s string) *net.IPNet {
+		_, sn, err := net.ParseCIDR(s)
+		require.NoError(t, err)
+		return sn
+	}
+	strs := func(subnets []*net.IPNet) []string {
+		ss := make([]string, len(subnets))
+		for i, sn := range subnets {
+			ss[i] = sn.String()
+		}
+		return ss
+	}
+	assert.Equal(t, []string{"10.43.0.0/16"}, strs(Exclude(cidr("10.43.0.0/16"), cidr("10.44.0.0/24"))))
+	assert.Empty(t, Exclude(cidr("10.43.3.0/24"), cidr("10.43.0.0/16")))
+	assert.Equal(t,
+		[]string{"10.43.0.0/17", "10.43.192.0/18", "10.43.160.0/19", "10.43.144.0/20"},
+		strs(Exclude(cidr("10.43.0.0/16"), cidr("10.43.128.0/20"))))
+	assert.Len(t, Exclude(cidr("100.64.0.0/10"), cidr("100.100.100.100/32")), 22)
+	assert.Equal(t, []string{"fd00::8000:0:0:0/65"}, strs(Exclude(cidr("fd00::/64"), cidr("fd00::/65"))))
+
+	// The excluded subnet and the resulting subnets together are the original subnet
+	a, b := cidr("10.42.0.0/16"), cidr("10.42.3.0/24")
+	ex := Exclude(a, b)
+	for _, ip := range []string{"10.42.0.1", "10.42.2.255", "10.42.4.0", "10.42.255.255"} {
+		n := 0
+		for _, sn := range ex {
+			if sn.Contains(net.ParseIP(ip)) {
+				n++
+			}
+		}
+		assert.Equal(t, 1, n, ip)
+	}
+	for _, sn := range ex {
+		assert.False(t, sn.Contains(net.ParseIP("10.42.3.7")))
+	}
+}
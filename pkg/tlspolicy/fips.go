@@ -0,0 +1,10 @@
+// +build fips
+
+package tlspolicy
+
+// Restrict all TLS configuration to FIPS-approved settings. This requires a Go toolchain with
+// BoringCrypto support, see "make build-fips".
+import _ "crypto/tls/fipsonly"
+
+// FIPSBuild is true when the binary was built in FIPS mode
+const FIPSBuild = true
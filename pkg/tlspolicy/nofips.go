@@ -0,0 +1,6 @@
+// +build !fips
+
+package tlspolicy
+
+// FIPSBuild is true when the binary was built in FIPS mode
+const FIPSBuild = false
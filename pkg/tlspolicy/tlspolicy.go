@@ -0,0 +1,89 @@
+// Package tlspolicy contains the TLS policy that is applied to all TLS connections that the client
+// and the traffic-manager make, and to the TLS servers of the traffic-manager.
+package tlspolicy
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+)
+
+// Policy is the TLS policy to apply to a tls.Config
+type Policy struct {
+	MinVersion   uint16
+	CipherSuites []uint16
+}
+
+// fipsCipherSuites are the FIPS 140-2 approved cipher suites available for TLS 1.2. The
+// cipher suites of TLS 1.3 are not configurable.
+var fipsCipherSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+}
+
+var versions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// Parse creates a Policy from a minimum version such as "1.2" and a list of cipher suite names as
+// returned by tls.CipherSuiteName. The policy is restricted to FIPS approved versions and cipher
+// suites when fips is true or when the binary was built in FIPS mode. An empty minVersion defaults
+// to "1.2".
+func Parse(minVersion string, cipherSuites []string, fips bool) (*Policy, error) {
+	fips = fips || FIPSBuild
+	if minVersion == "" {
+		minVersion = "1.2"
+	}
+	v, ok := versions[strings.TrimPrefix(strings.TrimPrefix(minVersion, "VersionTLS"), "TLS")]
+	if !ok {
+		return nil, fmt.Errorf("unknown TLS version %q", minVersion)
+	}
+	if fips && v < tls.VersionTLS12 {
+		return nil, fmt.Errorf("TLS version %s is not allowed in FIPS mode", minVersion)
+	}
+	p := &Policy{MinVersion: v}
+
+	if len(cipherSuites) == 0 {
+		if fips {
+			p.CipherSuites = fipsCipherSuites
+		}
+		return p, nil
+	}
+	byName := make(map[string]uint16)
+	for _, cs := range tls.CipherSuites() {
+		byName[cs.Name] = cs.ID
+	}
+	for _, name := range cipherSuites {
+		id, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown or insecure cipher suite %q", name)
+		}
+		if fips && !isFIPS(id) {
+			return nil, fmt.Errorf("cipher suite %q is not allowed in FIPS mode", name)
+		}
+		p.CipherSuites = append(p.CipherSuites, id)
+	}
+	return p, nil
+}
+
+func isFIPS(id uint16) bool {
+	for _, fid := range fipsCipherSuites {
+		if id == fid {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply applies the policy to the given config
+func (p *Policy) Apply(cfg *tls.Config) {
+	cfg.MinVersion = p.MinVersion
+	if len(p.CipherSuites) > 0 {
+		cfg.CipherSuites = p.CipherSuites
+	}
+}
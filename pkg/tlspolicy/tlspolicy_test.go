@@ -0,0 +1,104 @@
+package tlspolicy
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	p, err := Parse("", nil, false)
+	require.NoError(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS12), p.MinVersion)
+
+	p, err = Parse("1.3", nil, false)
+	require.NoError(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS13), p.MinVersion)
+
+	p, err = Parse("1.2", nil, true)
+	require.NoError(t, err)
+	assert.Equal(t, fipsCipherSuites, p.CipherSuites)
+
+	p, err = Parse("1.2", []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"}, true)
+	require.NoError(t, err)
+	assert.Equal(t, []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256}, p.CipherSuites)
+
+	_, err = Parse("1.1", nil, true)
+	assert.Error(t, err)
+
+	_, err = Parse("1.2", []string{"TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256"}, true)
+	assert.Error(t, err)
+
+	_, err = Parse("1.2", []string{"NO_SUCH_SUITE"}, false)
+	assert.Error(t, err)
+
+	_, err = Parse("2.0", nil, false)
+	assert.Error(t, err)
+}
+
+// handshake performs a TLS handshake between a server and a client with the given configurations,
+// and returns the version that they agreed on.
+func handshake(t *testing.T, server, client *tls.Config) (uint16, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	server.Certificates = []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}
+	client.InsecureSkipVerify = true
+
+	sc, cc := net.Pipe()
+	defer sc.Close()
+	defer cc.Close()
+	srv := tls.Server(sc, server)
+	go func() { _ = srv.Handshake() }()
+	conn := tls.Client(cc, client)
+	if err = conn.Handshake(); err != nil {
+		return 0, err
+	}
+	return conn.ConnectionState().Version, nil
+}
+
+func TestApply(t *testing.T) {
+	p, err := Parse("1.3", nil, false)
+	require.NoError(t, err)
+
+	// A server that applies the policy refuses clients that don't meet it
+	server := &tls.Config{}
+	p.Apply(server)
+	_, err = handshake(t, server, &tls.Config{MaxVersion: tls.VersionTLS12})
+	assert.Error(t, err)
+
+	// A client that applies the policy refuses servers that don't meet it
+	client := &tls.Config{}
+	p.Apply(client)
+	_, err = handshake(t, &tls.Config{MaxVersion: tls.VersionTLS12}, client)
+	assert.Error(t, err)
+
+	v, err := handshake(t, &tls.Config{}, client)
+	require.NoError(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS13), v)
+
+	// The cipher suites are restricted to those of the policy
+	p, err = Parse("1.2", []string{"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384"}, false)
+	require.NoError(t, err)
+	client = &tls.Config{MaxVersion: tls.VersionTLS12}
+	p.Apply(client)
+	_, err = handshake(t, &tls.Config{CipherSuites: []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256}}, client)
+	assert.Error(t, err)
+	_, err = handshake(t, &tls.Config{}, client)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,363 @@
+package tracing
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/datawire/dlib/dlog"
+	"github.com/telepresenceio/telepresence/v2/pkg/peercred"
+)
+
+const (
+	// fileSuffix is the suffix of the files that spans are recorded in
+	fileSuffix = ".traces"
+
+	// maxFileSize is the size at which a span file is rotated
+	maxFileSize = 8 * 1024 * 1024
+
+	// exportInterval is the maximum time that a span is held before it's sent to the collector
+	exportInterval = 5 * time.Second
+
+	scopeName = "github.com/telepresenceio/telepresence/v2/pkg/tracing"
+)
+
+type exporter struct {
+	service  string
+	path     string
+	file     *os.File
+	size     int64
+	endpoint string
+	spans    chan *Span
+	done     chan struct{}
+}
+
+var currentExporter atomic.Value
+
+func getExporter() *exporter {
+	e, _ := currentExporter.Load().(*exporter)
+	return e
+}
+
+// Init enables export of the spans that end in this process. The spans are attributed to the
+// given service. Unless dir is empty, they are appended to the file <service>.traces in that
+// directory, and unless endpoint is empty, they are sent to an OpenTelemetry collector using
+// OTLP/HTTP. The export ends when the context is cancelled.
+func Init(ctx context.Context, service, dir, endpoint string) error {
+	e := &exporter{
+		service:  service,
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		spans:    make(chan *Span, 512),
+		done:     make(chan struct{}),
+	}
+	if dir != "" {
+		e.path = filepath.Join(dir, service+fileSuffix)
+		if err := e.openFile(); err != nil {
+			return err
+		}
+	}
+	currentExporter.Store(e)
+	go e.run(ctx)
+	return nil
+}
+
+// Wait waits at most timeout for the export started by Init to finish after the cancellation of its
+// context. Short-lived processes use this to ensure that their spans are written before they exit.
+func Wait(timeout time.Duration) {
+	if e := getExporter(); e != nil {
+		select {
+		case <-e.done:
+		case <-time.After(timeout):
+		}
+	}
+}
+
+func (e *exporter) export(s *Span) {
+	select {
+	case e.spans <- s:
+	default:
+		// Never block the traced operation. Dropping a span is better.
+	}
+}
+
+func (e *exporter) run(ctx context.Context) {
+	defer close(e.done)
+	ticker := time.NewTicker(exportInterval)
+	defer ticker.Stop()
+	var batch []json.RawMessage
+	flush := func(ctx context.Context) {
+		if len(batch) > 0 && e.endpoint != "" {
+			if err := e.post(ctx, batch); err != nil {
+				dlog.Debugf(ctx, "unable to export %d spans: %v", len(batch), err)
+			}
+		}
+		batch = nil
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			for {
+				select {
+				case s := <-e.spans:
+					batch = append(batch, e.record(ctx, s))
+				default:
+					fc, cancel := context.WithTimeout(context.Background(), time.Second)
+					flush(fc)
+					cancel()
+					if e.file != nil {
+						_ = e.file.Close()
+					}
+					return
+				}
+			}
+		case s := <-e.spans:
+			batch = append(batch, e.record(ctx, s))
+			if len(batch) >= cap(e.spans)/2 {
+				flush(ctx)
+			}
+		case <-ticker.C:
+			flush(ctx)
+		}
+	}
+}
+
+// record writes the span to the span file and returns its OTLP JSON representation
+func (e *exporter) record(ctx context.Context, s *Span) json.RawMessage {
+	data, err := json.Marshal(s.otlpSpan())
+	if err != nil {
+		// Can't really happen since the span consists of strings only
+		dlog.Error(ctx, err)
+		return nil
+	}
+	if e.file != nil {
+		if e.size > maxFileSize {
+			if err = e.rotate(); err != nil {
+				dlog.Errorf(ctx, "unable to rotate %s: %v", e.path, err)
+			}
+		}
+		if e.file != nil {
+			n, _ := e.file.Write(append(data, '\n'))
+			e.size += int64(n)
+		}
+	}
+	return data
+}
+
+func (e *exporter) openFile() (err error) {
+	if e.file, err = os.OpenFile(e.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600); err != nil {
+		return err
+	}
+	st, err := e.file.Stat()
+	if err == nil {
+		// The root daemon writes its spans to the directory of the user, who must be able to gather
+		// and rotate them.
+		err = peercred.ChownToSudoUser(e.path)
+	}
+	if err != nil {
+		_ = e.file.Close()
+		e.file = nil
+		return err
+	}
+	e.size = st.Size()
+	return nil
+}
+
+// rotate moves the current span file to <service>.traces.old and starts a new one
+func (e *exporter) rotate() error {
+	_ = e.file.Close()
+	e.file = nil
+	if err := os.Rename(e.path, e.path+".old"); err != nil {
+		return err
+	}
+	return e.openFile()
+}
+
+func (e *exporter) post(ctx context.Context, spans []json.RawMessage) error {
+	body, err := json.Marshal(exportRequest(map[string][]json.RawMessage{e.service: spans}))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint+"/v1/traces", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s responded with %s", e.endpoint, resp.Status)
+	}
+	return nil
+}
+
+// Gather reads the span files found in dir and writes them to w as one OTLP JSON document, suitable
+// for import into an OpenTelemetry collector. The number of spans written is returned. A file that
+// can't be read is skipped, and the reason is passed to warn unless warn is nil.
+func Gather(dir string, w io.Writer, warn func(error)) (int, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*"+fileSuffix+"*"))
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	spans := make(map[string][]json.RawMessage)
+	for _, file := range files {
+		base := filepath.Base(file)
+		service := strings.TrimSuffix(strings.TrimSuffix(base, ".old"), fileSuffix)
+		if service+fileSuffix != base && service+fileSuffix+".old" != base {
+			continue
+		}
+		var fileSpans []json.RawMessage
+		n, err := readSpans(file, func(span json.RawMessage) {
+			fileSpans = append(fileSpans, span)
+		})
+		if err != nil {
+			if warn != nil {
+				warn(err)
+			}
+			continue
+		}
+		spans[service] = append(spans[service], fileSpans...)
+		count += n
+	}
+	data, err := json.Marshal(exportRequest(spans))
+	if err != nil {
+		return count, err
+	}
+	_, err = w.Write(data)
+	return count, err
+}
+
+func readSpans(file string, f func(json.RawMessage)) (int, error) {
+	fh, err := os.Open(file)
+	if err != nil {
+		return 0, err
+	}
+	defer fh.Close()
+	count := 0
+	sc := bufio.NewScanner(fh)
+	sc.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for sc.Scan() {
+		line := bytes.TrimSpace(sc.Bytes())
+		if len(line) == 0 || !json.Valid(line) {
+			// A partially written line is the result of a crash. Just skip it.
+			continue
+		}
+		f(append(json.RawMessage(nil), line...))
+		count++
+	}
+	return count, sc.Err()
+}
+
+// The OTLP JSON encoding, see https://github.com/open-telemetry/opentelemetry-proto/blob/main/docs/specification.md#json-protobuf-encoding
+
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpKeyValue struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              Kind           `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            *otlpStatus    `json:"status,omitempty"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope         `json:"scope"`
+	Spans []json.RawMessage `json:"spans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpExportRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+func exportRequest(spansByService map[string][]json.RawMessage) *otlpExportRequest {
+	services := make([]string, 0, len(spansByService))
+	for service := range spansByService {
+		services = append(services, service)
+	}
+	sort.Strings(services)
+	r := &otlpExportRequest{ResourceSpans: make([]otlpResourceSpans, len(services))}
+	for i, service := range services {
+		r.ResourceSpans[i] = otlpResourceSpans{
+			Resource: otlpResource{Attributes: []otlpKeyValue{{Key: "service.name", Value: otlpValue{StringValue: service}}}},
+			ScopeSpans: []otlpScopeSpans{{
+				Scope: otlpScope{Name: scopeName},
+				Spans: spansByService[service],
+			}},
+		}
+	}
+	return r
+}
+
+func (s *Span) otlpSpan() *otlpSpan {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o := &otlpSpan{
+		TraceID:           s.sc.traceID.String(),
+		SpanID:            s.sc.spanID.String(),
+		Name:              s.name,
+		Kind:              s.kind,
+		StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+	}
+	if s.parentID != (SpanID{}) {
+		o.ParentSpanID = s.parentID.String()
+	}
+	if len(s.attributes) > 0 {
+		keys := make([]string, 0, len(s.attributes))
+		for k := range s.attributes {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		o.Attributes = make([]otlpKeyValue, len(keys))
+		for i, k := range keys {
+			o.Attributes[i] = otlpKeyValue{Key: k, Value: otlpValue{StringValue: s.attributes[k]}}
+		}
+	}
+	if s.err != "" {
+		o.Status = &otlpStatus{Code: 2, Message: s.err}
+	}
+	return o
+}
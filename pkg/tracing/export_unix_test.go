@@ -0,0 +1,35 @@
+// +build !windows
+
+package tracing
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChownToSudoUser(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("must run as root")
+	}
+	file := filepath.Join(t.TempDir(), "daemon"+fileSuffix)
+	e := &exporter{path: file}
+	os.Setenv("SUDO_UID", "4711")
+	os.Setenv("SUDO_GID", "4712")
+	defer func() {
+		os.Unsetenv("SUDO_UID")
+		os.Unsetenv("SUDO_GID")
+	}()
+	require.NoError(t, e.openFile())
+	defer e.file.Close()
+	st, err := os.Stat(file)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), st.Mode().Perm())
+	sys := st.Sys().(*syscall.Stat_t)
+	assert.Equal(t, uint32(4711), sys.Uid)
+	assert.Equal(t, uint32(4712), sys.Gid)
+}
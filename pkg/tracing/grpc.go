@@ -0,0 +1,125 @@
+package tracing
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+const traceparentHeader = "traceparent"
+
+// DialOptions returns options that make a gRPC client record a span for each call and propagate
+// the trace context to the server.
+func DialOptions() []grpc.DialOption {
+	return []grpc.DialOption{
+		grpc.WithChainUnaryInterceptor(unaryClientInterceptor),
+		grpc.WithChainStreamInterceptor(streamClientInterceptor),
+	}
+}
+
+// ServerOptions returns options that make a gRPC server record a span for each call that is a
+// child of the span propagated by the client.
+func ServerOptions() []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(unaryServerInterceptor),
+		grpc.ChainStreamInterceptor(streamServerInterceptor),
+	}
+}
+
+func startClientSpan(ctx context.Context, method string) (context.Context, *Span) {
+	ctx, span := start(ctx, method, KindClient, "rpc.system", "grpc")
+	return metadata.AppendToOutgoingContext(ctx, traceparentHeader, span.sc.traceparent()), span
+}
+
+func unaryClientInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	ctx, span := startClientSpan(ctx, method)
+	err := invoker(ctx, method, req, reply, cc, opts...)
+	span.SetError(err)
+	span.End()
+	return err
+}
+
+// tracedClientStream ends the span of a stream when the stream ends, which is when a receive fails,
+// or when the context of the call is cancelled.
+type tracedClientStream struct {
+	grpc.ClientStream
+	span *Span
+	done chan struct{}
+	once sync.Once
+}
+
+func (s *tracedClientStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err != nil {
+		if err != io.EOF {
+			s.span.SetError(err)
+		}
+		s.end()
+	}
+	return err
+}
+
+func (s *tracedClientStream) end() {
+	s.once.Do(func() {
+		s.span.End()
+		close(s.done)
+	})
+}
+
+func streamClientInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	ctx, span := startClientSpan(ctx, method)
+	cs, err := streamer(ctx, desc, cc, method, opts...)
+	if err != nil {
+		span.SetError(err)
+		span.End()
+		return nil, err
+	}
+	ts := &tracedClientStream{ClientStream: cs, span: span, done: make(chan struct{})}
+	go func() {
+		select {
+		case <-ctx.Done():
+			ts.end()
+		case <-ts.done:
+		}
+	}()
+	return ts, nil
+}
+
+func startServerSpan(ctx context.Context, method string) (context.Context, *Span) {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if tps := md.Get(traceparentHeader); len(tps) > 0 {
+			if sc, ok := parseTraceparent(tps[0]); ok {
+				ctx = withRemoteParent(ctx, sc)
+			}
+		}
+	}
+	return start(ctx, method, KindServer, "rpc.system", "grpc")
+}
+
+func unaryServerInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, span := startServerSpan(ctx, info.FullMethod)
+	resp, err := handler(ctx, req)
+	span.SetError(err)
+	span.End()
+	return resp, err
+}
+
+type tracedServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *tracedServerStream) Context() context.Context {
+	return s.ctx
+}
+
+func streamServerInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, span := startServerSpan(ss.Context(), info.FullMethod)
+	err := handler(srv, &tracedServerStream{ServerStream: ss, ctx: ctx})
+	span.SetError(err)
+	span.End()
+	return err
+}
@@ -0,0 +1,169 @@
+// Package tracing records spans using the OpenTelemetry data model and propagates the trace context
+// between the Telepresence processes using the W3C traceparent format.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TraceID identifies a trace
+type TraceID [16]byte
+
+func (t TraceID) String() string {
+	return hex.EncodeToString(t[:])
+}
+
+// SpanID identifies a span within a trace
+type SpanID [8]byte
+
+func (s SpanID) String() string {
+	return hex.EncodeToString(s[:])
+}
+
+// Kind is the span kind as defined by OTLP
+type Kind int
+
+const (
+	KindInternal = Kind(1)
+	KindServer   = Kind(2)
+	KindClient   = Kind(3)
+)
+
+// spanContext is the part of a span that is propagated to other processes
+type spanContext struct {
+	traceID TraceID
+	spanID  SpanID
+}
+
+func (sc spanContext) isValid() bool {
+	return sc.traceID != TraceID{} && sc.spanID != SpanID{}
+}
+
+// Span is a timed operation
+type Span struct {
+	mu         sync.Mutex
+	name       string
+	kind       Kind
+	sc         spanContext
+	parentID   SpanID
+	start      time.Time
+	end        time.Time
+	attributes map[string]string
+	err        string
+}
+
+type spanKey struct{}
+type remoteParentKey struct{}
+
+// SpanFromContext returns the current span of the given context, or nil if there is none.
+func SpanFromContext(ctx context.Context) *Span {
+	if s, ok := ctx.Value(spanKey{}).(*Span); ok {
+		return s
+	}
+	return nil
+}
+
+// withRemoteParent returns a context that makes spans started from it children of a span in
+// another process.
+func withRemoteParent(ctx context.Context, sc spanContext) context.Context {
+	return context.WithValue(ctx, remoteParentKey{}, sc)
+}
+
+func parentOf(ctx context.Context) (spanContext, bool) {
+	if s := SpanFromContext(ctx); s != nil {
+		return s.sc, true
+	}
+	sc, ok := ctx.Value(remoteParentKey{}).(spanContext)
+	return sc, ok
+}
+
+// Start starts a span that is a child of the current span of the given context, or the root of a
+// new trace if there is no current span. The optional attributes are given as key, value pairs.
+// The returned context has the new span as its current span.
+func Start(ctx context.Context, name string, attributes ...string) (context.Context, *Span) {
+	return start(ctx, name, KindInternal, attributes...)
+}
+
+func start(ctx context.Context, name string, kind Kind, attributes ...string) (context.Context, *Span) {
+	s := &Span{
+		name:  name,
+		kind:  kind,
+		start: time.Now(),
+	}
+	if parent, ok := parentOf(ctx); ok {
+		s.sc.traceID = parent.traceID
+		s.parentID = parent.spanID
+	} else {
+		randomize(s.sc.traceID[:])
+	}
+	randomize(s.sc.spanID[:])
+	for i := 0; i+1 < len(attributes); i += 2 {
+		s.SetAttribute(attributes[i], attributes[i+1])
+	}
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+func randomize(b []byte) {
+	// crypto/rand.Read never returns an error on the supported platforms
+	_, _ = rand.Read(b)
+}
+
+// SetAttribute sets an attribute on the span
+func (s *Span) SetAttribute(key, value string) {
+	s.mu.Lock()
+	if s.attributes == nil {
+		s.attributes = make(map[string]string)
+	}
+	s.attributes[key] = value
+	s.mu.Unlock()
+}
+
+// SetError marks the span as failed unless err is nil
+func (s *Span) SetError(err error) {
+	if err != nil {
+		s.mu.Lock()
+		s.err = err.Error()
+		s.mu.Unlock()
+	}
+}
+
+// End ends the span and hands it over to the exporter. Calls after the first one have no effect.
+func (s *Span) End() {
+	s.mu.Lock()
+	if !s.end.IsZero() {
+		s.mu.Unlock()
+		return
+	}
+	s.end = time.Now()
+	s.mu.Unlock()
+	if e := getExporter(); e != nil {
+		e.export(s)
+	}
+}
+
+// traceparent returns the W3C traceparent representation of the span's context
+func (sc spanContext) traceparent() string {
+	return fmt.Sprintf("00-%s-%s-01", sc.traceID, sc.spanID)
+}
+
+// parseTraceparent parses a W3C traceparent header value
+func parseTraceparent(s string) (sc spanContext, ok bool) {
+	parts := strings.Split(s, "-")
+	if len(parts) != 4 || len(parts[0]) != 2 || parts[0] == "ff" ||
+		len(parts[1]) != 2*len(sc.traceID) || len(parts[2]) != 2*len(sc.spanID) {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.traceID[:], []byte(parts[1])); err != nil {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.spanID[:], []byte(parts[2])); err != nil {
+		return sc, false
+	}
+	return sc, sc.isValid()
+}
@@ -0,0 +1,148 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+)
+
+func TestTraceparent(t *testing.T) {
+	_, span := Start(context.Background(), "test")
+	tp := span.sc.traceparent()
+	sc, ok := parseTraceparent(tp)
+	require.True(t, ok)
+	assert.Equal(t, span.sc, sc)
+
+	for _, bad := range []string{
+		"",
+		"00-abc-def-01",
+		"ff-" + span.sc.traceID.String() + "-" + span.sc.spanID.String() + "-01",
+		"00-00000000000000000000000000000000-" + span.sc.spanID.String() + "-01",
+		"00-" + span.sc.traceID.String() + "-zzzzzzzzzzzzzzzz-01",
+	} {
+		_, ok = parseTraceparent(bad)
+		assert.False(t, ok, bad)
+	}
+}
+
+func TestStart_parent(t *testing.T) {
+	ctx, parent := Start(context.Background(), "parent")
+	_, child := Start(ctx, "child", "key", "value")
+	assert.Equal(t, parent.sc.traceID, child.sc.traceID)
+	assert.Equal(t, parent.sc.spanID, child.parentID)
+	assert.NotEqual(t, parent.sc.spanID, child.sc.spanID)
+	assert.Equal(t, map[string]string{"key": "value"}, child.attributes)
+
+	remote := withRemoteParent(context.Background(), parent.sc)
+	_, server := start(remote, "server", KindServer)
+	assert.Equal(t, parent.sc.traceID, server.sc.traceID)
+	assert.Equal(t, parent.sc.spanID, server.parentID)
+}
+
+func TestSpan_otlpSpan(t *testing.T) {
+	_, span := Start(context.Background(), "op", "b", "2", "a", "1")
+	span.SetError(errors.New("boom"))
+	span.End()
+	o := span.otlpSpan()
+	assert.Equal(t, "op", o.Name)
+	assert.Equal(t, KindInternal, o.Kind)
+	assert.Empty(t, o.ParentSpanID)
+	assert.Equal(t, []otlpKeyValue{
+		{Key: "a", Value: otlpValue{StringValue: "1"}},
+		{Key: "b", Value: otlpValue{StringValue: "2"}},
+	}, o.Attributes)
+	require.NotNil(t, o.Status)
+	assert.Equal(t, 2, o.Status.Code)
+	assert.Equal(t, "boom", o.Status.Message)
+}
+
+func TestGather(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "connector"+fileSuffix), []byte(`{"name":"a"}`+"\n"+`{"name":`+"\n"), 0600))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "daemon"+fileSuffix+".old"), []byte(`{"name":"b"}`+"\n"), 0600))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "daemon.log"), []byte("not spans\n"), 0600))
+
+	buf := &bytes.Buffer{}
+	count, err := Gather(dir, buf, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	var req otlpExportRequest
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &req))
+	require.Len(t, req.ResourceSpans, 2)
+	assert.Equal(t, "connector", req.ResourceSpans[0].Resource.Attributes[0].Value.StringValue)
+	assert.Equal(t, "daemon", req.ResourceSpans[1].Resource.Attributes[0].Value.StringValue)
+	assert.JSONEq(t, `{"name":"b"}`, string(req.ResourceSpans[1].ScopeSpans[0].Spans[0]))
+}
+
+func TestGather_unreadable(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "connector"+fileSuffix), []byte(`{"name":"a"}`+"\n"), 0600))
+	// A directory can be opened but not read, even by root
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "daemon"+fileSuffix), 0700))
+
+	var warnings []error
+	buf := &bytes.Buffer{}
+	count, err := Gather(dir, buf, func(err error) { warnings = append(warnings, err) })
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+	assert.Len(t, warnings, 1)
+
+	var req otlpExportRequest
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &req))
+	require.Len(t, req.ResourceSpans, 1)
+	assert.Equal(t, "connector", req.ResourceSpans[0].Resource.Attributes[0].Value.StringValue)
+}
+
+type recvStream struct {
+	grpc.ClientStream
+	err error
+}
+
+func (s *recvStream) RecvMsg(interface{}) error {
+	return s.err
+}
+
+func ended(s *Span) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return !s.end.IsZero()
+}
+
+func TestStreamClientInterceptor(t *testing.T) {
+	var span *Span
+	stream := &recvStream{}
+	streamer := func(ctx context.Context, _ *grpc.StreamDesc, _ *grpc.ClientConn, _ string, _ ...grpc.CallOption) (grpc.ClientStream, error) {
+		span = SpanFromContext(ctx)
+		return stream, nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cs, err := streamClientInterceptor(ctx, &grpc.StreamDesc{}, nil, "/test/Stream", streamer)
+	require.NoError(t, err)
+	require.NoError(t, cs.RecvMsg(nil))
+	assert.False(t, ended(span), "the span covers the whole stream")
+	stream.err = io.EOF
+	assert.Equal(t, io.EOF, cs.RecvMsg(nil))
+	assert.True(t, ended(span))
+	assert.Nil(t, span.otlpSpan().Status, "the end of a stream isn't an error")
+
+	ctx, cancel = context.WithCancel(context.Background())
+	_, err = streamClientInterceptor(ctx, &grpc.StreamDesc{}, nil, "/test/Stream", streamer)
+	require.NoError(t, err)
+	assert.False(t, ended(span))
+	cancel()
+	assert.Eventually(t, func() bool { return ended(span) }, time.Second, 10*time.Millisecond, "a cancelled stream ends the span")
+}
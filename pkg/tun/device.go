@@ -3,9 +3,7 @@ package tun
 import (
 	"context"
 	"net"
-	"unsafe"
-
-	"golang.org/x/sys/unix"
+	"os"
 
 	"github.com/telepresenceio/telepresence/v2/pkg/tun/buffer"
 )
@@ -15,6 +13,31 @@ func OpenTun() (*Device, error) {
 	return openTun()
 }
 
+// Privileged performs the operations that require root privileges on behalf of a process that
+// lacks them, typically by sending requests to a privileged helper.
+type Privileged interface {
+	// OpenTun creates a new TUN device and returns its file, name, and index
+	OpenTun(ctx context.Context) (*os.File, string, int32, error)
+	AddSubnet(ctx context.Context, name string, subnet *net.IPNet) error
+	RemoveSubnet(ctx context.Context, name string, subnet *net.IPNet) error
+	AddRoute(ctx context.Context, name string, subnet *net.IPNet) error
+	RemoveRoute(ctx context.Context, name string, subnet *net.IPNet) error
+	SetMTU(ctx context.Context, name string, mtu int) error
+}
+
+// OpenTunVia creates a new TUN device using the given Privileged. All subsequent operations on the
+// device that require root privileges will also use it.
+func OpenTunVia(ctx context.Context, p Privileged) (*Device, error) {
+	return openTunVia(ctx, p)
+}
+
+// DeviceByName returns a Device for an existing TUN device. The device can be configured, but packets
+// can't be read from or written to it. The privileged helper uses it to configure the devices that
+// it has opened on behalf of another process.
+func DeviceByName(name string) (*Device, error) {
+	return deviceByName(name)
+}
+
 // AddSubnet adds a subnet to this TUN device and creates a route for that subnet which
 // is associated with the device (removing the device will automatically remove the route).
 func (t *Device) AddSubnet(ctx context.Context, subnet *net.IPNet) error {
@@ -27,6 +50,18 @@ func (t *Device) RemoveSubnet(ctx context.Context, subnet *net.IPNet) error {
 	return t.removeSubnet(ctx, subnet)
 }
 
+// AddRoute routes a subnet to this TUN device without assigning an address of the subnet to the
+// device. It's used for subnets with a single address, which would become an address of the
+// workstation if it was assigned to the device.
+func (t *Device) AddRoute(ctx context.Context, subnet *net.IPNet) error {
+	return t.addRoute(ctx, subnet)
+}
+
+// RemoveRoute removes a route that was added using AddRoute.
+func (t *Device) RemoveRoute(ctx context.Context, subnet *net.IPNet) error {
+	return t.removeRoute(ctx, subnet)
+}
+
 // Name returns the name of this device, e.g. "tun0"
 func (t *Device) Name() string {
 	return t.name
@@ -47,16 +82,3 @@ func (t *Device) WritePacket(from *buffer.Data) (int, error) {
 func (t *Device) SetMTU(mtu int) error {
 	return t.setMTU(mtu)
 }
-
-func withSocket(domain int, f func(fd int) error) error {
-	fd, err := unix.Socket(domain, unix.SOCK_DGRAM, 0)
-	if err != nil {
-		return err
-	}
-	defer unix.Close(fd)
-	return f(fd)
-}
-
-func ioctl(socket int, request uint, requestData unsafe.Pointer) error {
-	return unix.IoctlSetInt(socket, request, int(uintptr(requestData)))
-}
@@ -23,6 +23,23 @@ const uTunControlName = "com.apple.net.utun_control"
 type Device struct {
 	*os.File
 	name string
+	priv Privileged
+}
+
+func openTunVia(ctx context.Context, p Privileged) (*Device, error) {
+	file, name, _, err := p.OpenTun(ctx)
+	if err != nil {
+		return nil, err
+	}
+	_ = unix.SetNonblock(int(file.Fd()), true)
+	return &Device{File: file, name: name, priv: p}, nil
+}
+
+func deviceByName(name string) (*Device, error) {
+	if _, err := net.InterfaceByName(name); err != nil {
+		return nil, err
+	}
+	return &Device{name: name}, nil
 }
 
 func openTun() (*Device, error) {
@@ -61,7 +78,10 @@ func openTun() (*Device, error) {
 	}, nil
 }
 
-func (t *Device) addSubnet(_ context.Context, subnet *net.IPNet) error {
+func (t *Device) addSubnet(ctx context.Context, subnet *net.IPNet) error {
+	if t.priv != nil {
+		return t.priv.AddSubnet(ctx, t.name, subnet)
+	}
 	to := make(net.IP, len(subnet.IP))
 	copy(to, subnet.IP)
 	to[len(to)-1] = 1
@@ -73,7 +93,10 @@ func (t *Device) addSubnet(_ context.Context, subnet *net.IPNet) error {
 	})
 }
 
-func (t *Device) removeSubnet(_ context.Context, subnet *net.IPNet) error {
+func (t *Device) removeSubnet(ctx context.Context, subnet *net.IPNet) error {
+	if t.priv != nil {
+		return t.priv.RemoveSubnet(ctx, t.name, subnet)
+	}
 	to := make(net.IP, len(subnet.IP))
 	copy(to, subnet.IP)
 	to[len(to)-1] = 1
@@ -85,7 +108,36 @@ func (t *Device) removeSubnet(_ context.Context, subnet *net.IPNet) error {
 	})
 }
 
+func (t *Device) addRoute(ctx context.Context, subnet *net.IPNet) error {
+	if t.priv != nil {
+		return t.priv.AddRoute(ctx, t.name, subnet)
+	}
+	iface, err := net.InterfaceByName(t.name)
+	if err != nil {
+		return err
+	}
+	return withRouteSocket(func(s int) error {
+		return t.interfaceRouteAdd(s, 1, subnet, iface.Index)
+	})
+}
+
+func (t *Device) removeRoute(ctx context.Context, subnet *net.IPNet) error {
+	if t.priv != nil {
+		return t.priv.RemoveRoute(ctx, t.name, subnet)
+	}
+	iface, err := net.InterfaceByName(t.name)
+	if err != nil {
+		return err
+	}
+	return withRouteSocket(func(s int) error {
+		return t.interfaceRouteClear(s, 1, subnet, iface.Index)
+	})
+}
+
 func (t *Device) setMTU(mtu int) error {
+	if t.priv != nil {
+		return t.priv.SetMTU(context.Background(), t.name, mtu)
+	}
 	return withSocket(unix.AF_INET, func(fd int) error {
 		var ifr unix.IfreqMTU
 		copy(ifr.Name[:], t.name)
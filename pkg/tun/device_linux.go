@@ -2,10 +2,12 @@ package tun
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
 	"os"
 	"runtime"
+	"strconv"
 	"unsafe"
 
 	"golang.org/x/sys/unix"
@@ -20,6 +22,25 @@ type Device struct {
 	*os.File
 	name  string
 	index int32
+	priv  Privileged
+	table int
+}
+
+func openTunVia(ctx context.Context, p Privileged) (*Device, error) {
+	file, name, index, err := p.OpenTun(ctx)
+	if err != nil {
+		return nil, err
+	}
+	_ = unix.SetNonblock(int(file.Fd()), true)
+	return &Device{File: file, name: name, index: index, priv: p}, nil
+}
+
+func deviceByName(name string) (*Device, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, err
+	}
+	return &Device{name: name, index: int32(iface.Index)}, nil
 }
 
 func openTun() (*Device, error) {
@@ -91,20 +112,73 @@ func openTun() (*Device, error) {
 	return &Device{File: os.NewFile(uintptr(fd), devicePath), name: name, index: index}, nil
 }
 
+// SetRoutingTable makes the device route its subnets using the given routing table instead of the
+// main table, so that only packets that a routing rule directs to that table reach the device. It
+// must be called before any subnet is added, and it can't be used when the device was opened using
+// a Privileged.
+func (t *Device) SetRoutingTable(table int) error {
+	if t.priv != nil {
+		return errors.New("a routing table can't be set on a TUN device that is managed by the privileged helper")
+	}
+	t.table = table
+	return nil
+}
+
 func (t *Device) addSubnet(ctx context.Context, subnet *net.IPNet) error {
-	return dexec.CommandContext(ctx, "ip", "a", "add", subnet.String(), "dev", t.name).Run()
+	if t.priv != nil {
+		return t.priv.AddSubnet(ctx, t.name, subnet)
+	}
+	if t.table == 0 {
+		return dexec.CommandContext(ctx, "ip", "a", "add", subnet.String(), "dev", t.name).Run()
+	}
+	if err := dexec.CommandContext(ctx, "ip", "a", "add", subnet.String(), "dev", t.name, "noprefixroute").Run(); err != nil {
+		return err
+	}
+	return dexec.CommandContext(ctx, "ip", "route", "add", subnet.String(), "dev", t.name, "table", strconv.Itoa(t.table)).Run()
 }
 
 func (t *Device) removeSubnet(ctx context.Context, subnet *net.IPNet) error {
+	if t.priv != nil {
+		return t.priv.RemoveSubnet(ctx, t.name, subnet)
+	}
+	if t.table != 0 {
+		// The route isn't tied to the address when it's in another table than main
+		_ = dexec.CommandContext(ctx, "ip", "route", "del", subnet.String(), "dev", t.name, "table", strconv.Itoa(t.table)).Run()
+	}
 	return dexec.CommandContext(ctx, "ip", "a", "del", subnet.String(), "dev", t.name).Run()
 }
 
+func (t *Device) addRoute(ctx context.Context, subnet *net.IPNet) error {
+	if t.priv != nil {
+		return t.priv.AddRoute(ctx, t.name, subnet)
+	}
+	return dexec.CommandContext(ctx, "ip", t.routeArgs("add", subnet)...).Run()
+}
+
+func (t *Device) removeRoute(ctx context.Context, subnet *net.IPNet) error {
+	if t.priv != nil {
+		return t.priv.RemoveRoute(ctx, t.name, subnet)
+	}
+	return dexec.CommandContext(ctx, "ip", t.routeArgs("del", subnet)...).Run()
+}
+
+func (t *Device) routeArgs(verb string, subnet *net.IPNet) []string {
+	args := []string{"route", verb, subnet.String(), "dev", t.name}
+	if t.table != 0 {
+		args = append(args, "table", strconv.Itoa(t.table))
+	}
+	return args
+}
+
 // Index returns the index of this device
 func (t *Device) Index() int32 {
 	return t.index
 }
 
 func (t *Device) setMTU(mtu int) error {
+	if t.priv != nil {
+		return t.priv.SetMTU(context.Background(), t.name, mtu)
+	}
 	return withSocket(unix.AF_INET, func(fd int) error {
 		var mtuRequest struct {
 			name [unix.IFNAMSIZ]byte
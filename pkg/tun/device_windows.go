@@ -0,0 +1,61 @@
+package tun
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	"github.com/telepresenceio/telepresence/v2/pkg/tun/buffer"
+)
+
+// errNoTun is returned by all operations on Windows, which has no TUN device yet. The connector
+// reaches the cluster in proxy mode there, which needs neither a TUN device nor a root daemon.
+var errNoTun = errors.New("TUN devices are not supported on windows, use \"telepresence connect --proxy-via-container\"")
+
+type Device struct {
+	name string
+}
+
+func openTunVia(_ context.Context, _ Privileged) (*Device, error) {
+	return nil, errNoTun
+}
+
+func deviceByName(_ string) (*Device, error) {
+	return nil, errNoTun
+}
+
+func openTun() (*Device, error) {
+	return nil, errNoTun
+}
+
+func (t *Device) Close() error {
+	return errNoTun
+}
+
+func (t *Device) addSubnet(_ context.Context, _ *net.IPNet) error {
+	return errNoTun
+}
+
+func (t *Device) removeSubnet(_ context.Context, _ *net.IPNet) error {
+	return errNoTun
+}
+
+func (t *Device) addRoute(_ context.Context, _ *net.IPNet) error {
+	return errNoTun
+}
+
+func (t *Device) removeRoute(_ context.Context, _ *net.IPNet) error {
+	return errNoTun
+}
+
+func (t *Device) setMTU(_ int) error {
+	return errNoTun
+}
+
+func (t *Device) readPacket(_ *buffer.Data) (int, error) {
+	return 0, errNoTun
+}
+
+func (t *Device) writePacket(_ *buffer.Data) (int, error) {
+	return 0, errNoTun
+}
@@ -67,8 +67,36 @@ func (t *Device) newRouteMessage(rtm, seq int, subnet *net.IPNet, gw net.IP) *ro
 	}
 }
 
+// newInterfaceRouteMessage creates a message for a route that sends the traffic to the subnet
+// directly to the interface with the given index, i.e. like "route add -interface" does.
+func (t *Device) newInterfaceRouteMessage(rtm, seq int, subnet *net.IPNet, ifIndex int) *route.RouteMessage {
+	flags := unix.RTF_UP | unix.RTF_STATIC
+	if ones, bits := subnet.Mask.Size(); ones == bits {
+		flags |= unix.RTF_HOST
+	}
+	return &route.RouteMessage{
+		Version: unix.RTM_VERSION,
+		ID:      uintptr(os.Getpid()),
+		Seq:     seq,
+		Type:    rtm,
+		Flags:   flags,
+		Addrs: []route.Addr{
+			unix.RTAX_DST:     toRouteAddr(subnet.IP),
+			unix.RTAX_GATEWAY: &route.LinkAddr{Index: ifIndex},
+			unix.RTAX_NETMASK: toRouteMask(subnet.Mask),
+		},
+	}
+}
+
 func (t *Device) routeAdd(routeSocket, seq int, r *net.IPNet, gw net.IP) error {
-	m := t.newRouteMessage(unix.RTM_ADD, seq, r, gw)
+	return writeRouteAdd(routeSocket, t.newRouteMessage(unix.RTM_ADD, seq, r, gw))
+}
+
+func (t *Device) interfaceRouteAdd(routeSocket, seq int, r *net.IPNet, ifIndex int) error {
+	return writeRouteAdd(routeSocket, t.newInterfaceRouteMessage(unix.RTM_ADD, seq, r, ifIndex))
+}
+
+func writeRouteAdd(routeSocket int, m *route.RouteMessage) error {
 	wb, err := m.Marshal()
 	if err != nil {
 		return err
@@ -82,7 +110,14 @@ func (t *Device) routeAdd(routeSocket, seq int, r *net.IPNet, gw net.IP) error {
 }
 
 func (t *Device) routeClear(routeSocket, seq int, r *net.IPNet, gw net.IP) error {
-	m := t.newRouteMessage(unix.RTM_DELETE, seq, r, gw)
+	return writeRouteClear(routeSocket, t.newRouteMessage(unix.RTM_DELETE, seq, r, gw))
+}
+
+func (t *Device) interfaceRouteClear(routeSocket, seq int, r *net.IPNet, ifIndex int) error {
+	return writeRouteClear(routeSocket, t.newInterfaceRouteMessage(unix.RTM_DELETE, seq, r, ifIndex))
+}
+
+func writeRouteClear(routeSocket int, m *route.RouteMessage) error {
 	wb, err := m.Marshal()
 	if err != nil {
 		return err
@@ -0,0 +1,331 @@
+// Package usage aggregates how a traffic-manager is used, i.e. who intercepts what and how much
+// traffic the clients tunnel, so that the administrators of a shared cluster can see the adoption
+// and the load without scraping logs.
+package usage
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Retention is how long the tracker retains what it records
+const Retention = 30 * 24 * time.Hour
+
+// resolution is the size of the time slots that the usage is aggregated in
+const resolution = time.Hour
+
+// InterceptCount is the number of intercepts that a user created for a workload.
+type InterceptCount struct {
+	User      string `json:"user"`
+	Namespace string `json:"namespace"`
+	Workload  string `json:"workload"`
+	Count     int    `json:"count"`
+}
+
+// TunnelVolume is the number of bytes that a user sent to and received from the cluster.
+type TunnelVolume struct {
+	User     string `json:"user"`
+	Sent     uint64 `json:"sent"`
+	Received uint64 `json:"received"`
+}
+
+// Report is the usage recorded since a given time.
+type Report struct {
+	// Since is the start of the report. It's never earlier than the start of the tracker.
+	Since time.Time `json:"since"`
+
+	Intercepts []InterceptCount `json:"intercepts"`
+	Tunnels    []TunnelVolume   `json:"tunnels"`
+}
+
+type interceptKey struct {
+	slot      time.Time
+	user      string
+	namespace string
+	workload  string
+}
+
+type volumeKey struct {
+	slot time.Time
+	user string
+}
+
+type volume struct {
+	sent     uint64
+	received uint64
+}
+
+// Counter counts the traffic of one tunnel. Counting doesn't lock, so that the tunnels don't
+// contend with each other. The tracker collects the counts when it reports or saves the usage, and
+// when the counter is closed.
+type Counter struct {
+	sent     uint64 // atomic
+	received uint64 // atomic
+	user     string
+
+	// collected is what the tracker has collected so far. It's guarded by the mutex of the tracker.
+	collected volume
+}
+
+// AddSent counts bytes that the user sent to the cluster.
+func (c *Counter) AddSent(n int) {
+	atomic.AddUint64(&c.sent, uint64(n))
+}
+
+// AddReceived counts bytes that the user received from the cluster.
+func (c *Counter) AddReceived(n int) {
+	atomic.AddUint64(&c.received, uint64(n))
+}
+
+// Tracker records the usage. It is safe for concurrent use.
+type Tracker struct {
+	mu         sync.Mutex
+	started    time.Time
+	intercepts map[interceptKey]int
+	volumes    map[volumeKey]*volume
+	counters   map[*Counter]struct{}
+}
+
+// NewTracker returns a tracker that starts at the given time.
+func NewTracker(now time.Time) *Tracker {
+	return &Tracker{
+		started:    now,
+		intercepts: make(map[interceptKey]int),
+		volumes:    make(map[volumeKey]*volume),
+		counters:   make(map[*Counter]struct{}),
+	}
+}
+
+// AddIntercept records the creation of an intercept.
+func (t *Tracker) AddIntercept(now time.Time, user, namespace, workload string) {
+	key := interceptKey{slot: now.Truncate(resolution), user: user, namespace: namespace, workload: workload}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.intercepts[key]++
+}
+
+// AddVolume records bytes that a user sent to, and received from, the cluster.
+func (t *Tracker) AddVolume(now time.Time, user string, sent, received uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.addVolume(now, user, sent, received)
+}
+
+func (t *Tracker) addVolume(now time.Time, user string, sent, received uint64) {
+	if sent == 0 && received == 0 {
+		return
+	}
+	key := volumeKey{slot: now.Truncate(resolution), user: user}
+	v, ok := t.volumes[key]
+	if !ok {
+		v = &volume{}
+		t.volumes[key] = v
+	}
+	v.sent += sent
+	v.received += received
+}
+
+// NewCounter returns a counter of the traffic of a tunnel of the given user. It must be closed
+// using CloseCounter when the tunnel ends.
+func (t *Tracker) NewCounter(user string) *Counter {
+	c := &Counter{user: user}
+	t.mu.Lock()
+	t.counters[c] = struct{}{}
+	t.mu.Unlock()
+	return c
+}
+
+// CloseCounter collects what the given counter has counted, and forgets the counter.
+func (t *Tracker) CloseCounter(now time.Time, c *Counter) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.collect(now, c)
+	delete(t.counters, c)
+}
+
+// flush collects what the counters of the tunnels have counted. What was counted is recorded in the
+// time slot of the flush.
+func (t *Tracker) flush(now time.Time) {
+	for c := range t.counters {
+		t.collect(now, c)
+	}
+}
+
+func (t *Tracker) collect(now time.Time, c *Counter) {
+	sent, received := atomic.LoadUint64(&c.sent), atomic.LoadUint64(&c.received)
+	t.addVolume(now, c.user, sent-c.collected.sent, received-c.collected.received)
+	c.collected = volume{sent: sent, received: received}
+}
+
+// Prune discards what was recorded before the retention period.
+func (t *Tracker) Prune(now time.Time) {
+	cutoff := now.Add(-Retention)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for k := range t.intercepts {
+		if k.slot.Add(resolution).Before(cutoff) {
+			delete(t.intercepts, k)
+		}
+	}
+	for k := range t.volumes {
+		if k.slot.Add(resolution).Before(cutoff) {
+			delete(t.volumes, k)
+		}
+	}
+	if t.started.Before(cutoff) {
+		t.started = cutoff
+	}
+}
+
+// Report returns the usage recorded since the given time. The usage is aggregated per hour, so
+// the report includes all usage of the hour that since is in. Intercept counts are sorted in
+// descending order of count, and tunnel volumes in descending order of total volume.
+func (t *Tracker) Report(now, since time.Time) *Report {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.flush(now)
+	if since.Before(t.started) {
+		since = t.started
+	}
+	r := &Report{Since: since}
+	sinceSlot := since.Truncate(resolution)
+
+	counts := make(map[InterceptCount]int)
+	for k, n := range t.intercepts {
+		if !k.slot.Before(sinceSlot) {
+			counts[InterceptCount{User: k.user, Namespace: k.namespace, Workload: k.workload}] += n
+		}
+	}
+	for ic, n := range counts {
+		ic.Count = n
+		r.Intercepts = append(r.Intercepts, ic)
+	}
+	sort.Slice(r.Intercepts, func(i, j int) bool {
+		a, b := &r.Intercepts[i], &r.Intercepts[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		if a.User != b.User {
+			return a.User < b.User
+		}
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Workload < b.Workload
+	})
+
+	volumes := make(map[string]*TunnelVolume)
+	for k, v := range t.volumes {
+		if k.slot.Before(sinceSlot) {
+			continue
+		}
+		tv, ok := volumes[k.user]
+		if !ok {
+			tv = &TunnelVolume{User: k.user}
+			volumes[k.user] = tv
+		}
+		tv.Sent += v.sent
+		tv.Received += v.received
+	}
+	for _, tv := range volumes {
+		r.Tunnels = append(r.Tunnels, *tv)
+	}
+	sort.Slice(r.Tunnels, func(i, j int) bool {
+		a, b := &r.Tunnels[i], &r.Tunnels[j]
+		if at, bt := a.Sent+a.Received, b.Sent+b.Received; at != bt {
+			return at > bt
+		}
+		return a.User < b.User
+	})
+	return r
+}
+
+// saved is the format that Save and Load use.
+type saved struct {
+	Started    time.Time        `json:"started"`
+	Intercepts []savedIntercept `json:"intercepts,omitempty"`
+	Tunnels    []savedVolume    `json:"tunnels,omitempty"`
+}
+
+type savedIntercept struct {
+	Slot time.Time `json:"slot"`
+	InterceptCount
+}
+
+type savedVolume struct {
+	Slot time.Time `json:"slot"`
+	TunnelVolume
+}
+
+// Save returns what the tracker has recorded, in a form that Load accepts, after collecting what
+// the counters have counted. The same usage always gives the same result.
+func (t *Tracker) Save(now time.Time) ([]byte, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.flush(now)
+	s := saved{Started: t.started}
+	for k, n := range t.intercepts {
+		s.Intercepts = append(s.Intercepts, savedIntercept{
+			Slot:           k.slot,
+			InterceptCount: InterceptCount{User: k.user, Namespace: k.namespace, Workload: k.workload, Count: n},
+		})
+	}
+	for k, v := range t.volumes {
+		s.Tunnels = append(s.Tunnels, savedVolume{
+			Slot:         k.slot,
+			TunnelVolume: TunnelVolume{User: k.user, Sent: v.sent, Received: v.received},
+		})
+	}
+	sort.Slice(s.Intercepts, func(i, j int) bool {
+		a, b := &s.Intercepts[i], &s.Intercepts[j]
+		if !a.Slot.Equal(b.Slot) {
+			return a.Slot.Before(b.Slot)
+		}
+		if a.User != b.User {
+			return a.User < b.User
+		}
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Workload < b.Workload
+	})
+	sort.Slice(s.Tunnels, func(i, j int) bool {
+		a, b := &s.Tunnels[i], &s.Tunnels[j]
+		if !a.Slot.Equal(b.Slot) {
+			return a.Slot.Before(b.Slot)
+		}
+		return a.User < b.User
+	})
+	return json.Marshal(&s)
+}
+
+// Load adds what a Save of an earlier tracker returned to what the tracker has recorded. The
+// tracker then starts at the start of the earlier tracker.
+func (t *Tracker) Load(data []byte) error {
+	var s saved
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if s.Started.Before(t.started) {
+		t.started = s.Started
+	}
+	for _, si := range s.Intercepts {
+		t.intercepts[interceptKey{slot: si.Slot, user: si.User, namespace: si.Namespace, workload: si.Workload}] += si.Count
+	}
+	for _, sv := range s.Tunnels {
+		key := volumeKey{slot: sv.Slot, user: sv.User}
+		v, ok := t.volumes[key]
+		if !ok {
+			v = &volume{}
+			t.volumes[key] = v
+		}
+		v.sent += sv.Sent
+		v.received += sv.Received
+	}
+	return nil
+}
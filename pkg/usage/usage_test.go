@@ -0,0 +1,104 @@
+package usage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReport(t *testing.T) {
+	start := time.Date(2021, time.June, 1, 10, 0, 0, 0, time.UTC)
+	tr := NewTracker(start)
+	tr.AddIntercept(start.Add(time.Minute), "alice", "default", "echo")
+	tr.AddIntercept(start.Add(2*time.Hour), "bob", "default", "echo")
+	tr.AddIntercept(start.Add(3*time.Hour), "bob", "default", "echo")
+	tr.AddIntercept(start.Add(3*time.Hour), "bob", "dev", "web")
+	tr.AddVolume(start.Add(time.Minute), "alice", 100, 1000)
+	tr.AddVolume(start.Add(2*time.Minute), "alice", 10, 10)
+	tr.AddVolume(start.Add(2*time.Hour), "bob", 1, 2)
+
+	r := tr.Report(start.Add(4*time.Hour), start.Add(-time.Hour))
+	assert.Equal(t, start, r.Since)
+	assert.Equal(t, []InterceptCount{
+		{User: "bob", Namespace: "default", Workload: "echo", Count: 2},
+		{User: "alice", Namespace: "default", Workload: "echo", Count: 1},
+		{User: "bob", Namespace: "dev", Workload: "web", Count: 1},
+	}, r.Intercepts)
+	assert.Equal(t, []TunnelVolume{
+		{User: "alice", Sent: 110, Received: 1010},
+		{User: "bob", Sent: 1, Received: 2},
+	}, r.Tunnels)
+
+	r = tr.Report(start.Add(4*time.Hour), start.Add(90*time.Minute))
+	assert.Equal(t, []InterceptCount{
+		{User: "bob", Namespace: "default", Workload: "echo", Count: 2},
+		{User: "bob", Namespace: "dev", Workload: "web", Count: 1},
+	}, r.Intercepts)
+	assert.Equal(t, []TunnelVolume{{User: "bob", Sent: 1, Received: 2}}, r.Tunnels)
+}
+
+func TestPrune(t *testing.T) {
+	start := time.Date(2021, time.June, 1, 10, 0, 0, 0, time.UTC)
+	tr := NewTracker(start)
+	tr.AddIntercept(start, "alice", "default", "echo")
+	tr.AddVolume(start, "alice", 1, 1)
+	later := start.Add(Retention + 2*time.Hour)
+	tr.AddIntercept(later, "bob", "default", "echo")
+
+	tr.Prune(later)
+	r := tr.Report(later, time.Time{})
+	assert.Equal(t, later.Add(-Retention), r.Since)
+	assert.Equal(t, []InterceptCount{{User: "bob", Namespace: "default", Workload: "echo", Count: 1}}, r.Intercepts)
+	assert.Empty(t, r.Tunnels)
+}
+
+func TestCounter(t *testing.T) {
+	start := time.Date(2021, time.June, 1, 10, 0, 0, 0, time.UTC)
+	tr := NewTracker(start)
+	c := tr.NewCounter("alice")
+	c.AddSent(10)
+	c.AddReceived(100)
+	r := tr.Report(start.Add(time.Minute), time.Time{})
+	assert.Equal(t, []TunnelVolume{{User: "alice", Sent: 10, Received: 100}}, r.Tunnels)
+
+	// Only what's counted after a report is added by the next one
+	c.AddSent(5)
+	tr.CloseCounter(start.Add(2*time.Hour), c)
+	c.AddSent(1000)
+	r = tr.Report(start.Add(3*time.Hour), time.Time{})
+	assert.Equal(t, []TunnelVolume{{User: "alice", Sent: 15, Received: 100}}, r.Tunnels)
+	r = tr.Report(start.Add(3*time.Hour), start.Add(time.Hour))
+	assert.Equal(t, []TunnelVolume{{User: "alice", Sent: 5}}, r.Tunnels, "the count is recorded at the time it's collected")
+}
+
+func TestSaveLoad(t *testing.T) {
+	start := time.Date(2021, time.June, 1, 10, 0, 0, 0, time.UTC)
+	tr := NewTracker(start)
+	tr.AddIntercept(start, "alice", "default", "echo")
+	tr.AddIntercept(start.Add(time.Hour), "bob", "default", "echo")
+	tr.AddVolume(start, "alice", 1, 2)
+	c := tr.NewCounter("bob")
+	c.AddReceived(3)
+	later := start.Add(2 * time.Hour)
+	data, err := tr.Save(later)
+	require.NoError(t, err)
+	again, err := tr.Save(later)
+	require.NoError(t, err)
+	assert.Equal(t, data, again, "the same usage is saved the same way")
+
+	restarted := NewTracker(later)
+	restarted.AddIntercept(later, "bob", "default", "echo")
+	require.NoError(t, restarted.Load(data))
+	r := restarted.Report(later, time.Time{})
+	assert.Equal(t, start, r.Since, "the restarted tracker starts when the saved one started")
+	assert.Equal(t, []InterceptCount{
+		{User: "bob", Namespace: "default", Workload: "echo", Count: 2},
+		{User: "alice", Namespace: "default", Workload: "echo", Count: 1},
+	}, r.Intercepts)
+	assert.Equal(t, []TunnelVolume{
+		{User: "alice", Sent: 1, Received: 2},
+		{User: "bob", Received: 3},
+	}, r.Tunnels)
+}
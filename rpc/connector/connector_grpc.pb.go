@@ -636,3 +636,416 @@ var _Connector_serviceDesc = grpc.ServiceDesc{
 	},
 	Metadata: "rpc/connector/connector.proto",
 }
+
+// ForwardsClient is the client API for Forwards service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type ForwardsClient interface {
+	// Adds a forward. Requires having already called Connect. Adding a forward that already exists
+	// is a no-op, and adding a forward of a local port that is forwarded elsewhere fails with
+	// ALREADY_EXISTS.
+	AddForward(ctx context.Context, in *Forward, opts ...grpc.CallOption) (*empty.Empty, error)
+	// Removes the forward of a local port. Fails with NOT_FOUND if the port isn't forwarded.
+	RemoveForward(ctx context.Context, in *ForwardPort, opts ...grpc.CallOption) (*empty.Empty, error)
+	// Returns the forwards, ordered by local port.
+	ListForwards(ctx context.Context, in *empty.Empty, opts ...grpc.CallOption) (*ForwardList, error)
+}
+
+type forwardsClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewForwardsClient(cc grpc.ClientConnInterface) ForwardsClient {
+	return &forwardsClient{cc}
+}
+
+func (c *forwardsClient) AddForward(ctx context.Context, in *Forward, opts ...grpc.CallOption) (*empty.Empty, error) {
+	out := new(empty.Empty)
+	err := c.cc.Invoke(ctx, "/telepresence.connector.Forwards/AddForward", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *forwardsClient) RemoveForward(ctx context.Context, in *ForwardPort, opts ...grpc.CallOption) (*empty.Empty, error) {
+	out := new(empty.Empty)
+	err := c.cc.Invoke(ctx, "/telepresence.connector.Forwards/RemoveForward", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *forwardsClient) ListForwards(ctx context.Context, in *empty.Empty, opts ...grpc.CallOption) (*ForwardList, error) {
+	out := new(ForwardList)
+	err := c.cc.Invoke(ctx, "/telepresence.connector.Forwards/ListForwards", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ForwardsServer is the server API for Forwards service.
+// All implementations must embed UnimplementedForwardsServer
+// for forward compatibility
+type ForwardsServer interface {
+	// Adds a forward. Requires having already called Connect. Adding a forward that already exists
+	// is a no-op, and adding a forward of a local port that is forwarded elsewhere fails with
+	// ALREADY_EXISTS.
+	AddForward(context.Context, *Forward) (*empty.Empty, error)
+	// Removes the forward of a local port. Fails with NOT_FOUND if the port isn't forwarded.
+	RemoveForward(context.Context, *ForwardPort) (*empty.Empty, error)
+	// Returns the forwards, ordered by local port.
+	ListForwards(context.Context, *empty.Empty) (*ForwardList, error)
+	mustEmbedUnimplementedForwardsServer()
+}
+
+// UnimplementedForwardsServer must be embedded to have forward compatible implementations.
+type UnimplementedForwardsServer struct {
+}
+
+func (UnimplementedForwardsServer) AddForward(context.Context, *Forward) (*empty.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AddForward not implemented")
+}
+func (UnimplementedForwardsServer) RemoveForward(context.Context, *ForwardPort) (*empty.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RemoveForward not implemented")
+}
+func (UnimplementedForwardsServer) ListForwards(context.Context, *empty.Empty) (*ForwardList, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListForwards not implemented")
+}
+func (UnimplementedForwardsServer) mustEmbedUnimplementedForwardsServer() {}
+
+// UnsafeForwardsServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ForwardsServer will
+// result in compilation errors.
+type UnsafeForwardsServer interface {
+	mustEmbedUnimplementedForwardsServer()
+}
+
+func RegisterForwardsServer(s grpc.ServiceRegistrar, srv ForwardsServer) {
+	s.RegisterService(&_Forwards_serviceDesc, srv)
+}
+
+func _Forwards_AddForward_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Forward)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ForwardsServer).AddForward(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/telepresence.connector.Forwards/AddForward",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ForwardsServer).AddForward(ctx, req.(*Forward))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Forwards_RemoveForward_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ForwardPort)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ForwardsServer).RemoveForward(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/telepresence.connector.Forwards/RemoveForward",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ForwardsServer).RemoveForward(ctx, req.(*ForwardPort))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Forwards_ListForwards_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(empty.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ForwardsServer).ListForwards(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/telepresence.connector.Forwards/ListForwards",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ForwardsServer).ListForwards(ctx, req.(*empty.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var _Forwards_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "telepresence.connector.Forwards",
+	HandlerType: (*ForwardsServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "AddForward",
+			Handler:    _Forwards_AddForward_Handler,
+		},
+		{
+			MethodName: "RemoveForward",
+			Handler:    _Forwards_RemoveForward_Handler,
+		},
+		{
+			MethodName: "ListForwards",
+			Handler:    _Forwards_ListForwards_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "rpc/connector/connector.proto",
+}
+
+// WorkloadsClient is the client API for Workloads service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type WorkloadsClient interface {
+	// Restarts the pods of a workload the way "kubectl rollout restart" does, and returns when the
+	// restarted pods are ready. The pods of a ReplicaSet, which has no rollout, are deleted instead.
+	// Requires having already called Connect.
+	RestartWorkload(ctx context.Context, in *Workload, opts ...grpc.CallOption) (*empty.Empty, error)
+	// Installs the traffic-agent in several workloads, so that they can be intercepted without
+	// waiting for their rollouts. The workloads are changed, and their rollouts awaited, in
+	// parallel. The progress is streamed as each install completes. Requires having already called
+	// Connect.
+	InstallAgents(ctx context.Context, in *InstallAgentsRequest, opts ...grpc.CallOption) (Workloads_InstallAgentsClient, error)
+}
+
+type workloadsClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewWorkloadsClient(cc grpc.ClientConnInterface) WorkloadsClient {
+	return &workloadsClient{cc}
+}
+
+func (c *workloadsClient) RestartWorkload(ctx context.Context, in *Workload, opts ...grpc.CallOption) (*empty.Empty, error) {
+	out := new(empty.Empty)
+	err := c.cc.Invoke(ctx, "/telepresence.connector.Workloads/RestartWorkload", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *workloadsClient) InstallAgents(ctx context.Context, in *InstallAgentsRequest, opts ...grpc.CallOption) (Workloads_InstallAgentsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_Workloads_serviceDesc.Streams[0], "/telepresence.connector.Workloads/InstallAgents", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &workloadsInstallAgentsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Workloads_InstallAgentsClient interface {
+	Recv() (*AgentInstallProgress, error)
+	grpc.ClientStream
+}
+
+type workloadsInstallAgentsClient struct {
+	grpc.ClientStream
+}
+
+func (x *workloadsInstallAgentsClient) Recv() (*AgentInstallProgress, error) {
+	m := new(AgentInstallProgress)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// WorkloadsServer is the server API for Workloads service.
+// All implementations must embed UnimplementedWorkloadsServer
+// for forward compatibility
+type WorkloadsServer interface {
+	// Restarts the pods of a workload the way "kubectl rollout restart" does, and returns when the
+	// restarted pods are ready. The pods of a ReplicaSet, which has no rollout, are deleted instead.
+	// Requires having already called Connect.
+	RestartWorkload(context.Context, *Workload) (*empty.Empty, error)
+	// Installs the traffic-agent in several workloads, so that they can be intercepted without
+	// waiting for their rollouts. The workloads are changed, and their rollouts awaited, in
+	// parallel. The progress is streamed as each install completes. Requires having already called
+	// Connect.
+	InstallAgents(*InstallAgentsRequest, Workloads_InstallAgentsServer) error
+	mustEmbedUnimplementedWorkloadsServer()
+}
+
+// UnimplementedWorkloadsServer must be embedded to have forward compatible implementations.
+type UnimplementedWorkloadsServer struct {
+}
+
+func (UnimplementedWorkloadsServer) RestartWorkload(context.Context, *Workload) (*empty.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RestartWorkload not implemented")
+}
+func (UnimplementedWorkloadsServer) InstallAgents(*InstallAgentsRequest, Workloads_InstallAgentsServer) error {
+	return status.Errorf(codes.Unimplemented, "method InstallAgents not implemented")
+}
+func (UnimplementedWorkloadsServer) mustEmbedUnimplementedWorkloadsServer() {}
+
+// UnsafeWorkloadsServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to WorkloadsServer will
+// result in compilation errors.
+type UnsafeWorkloadsServer interface {
+	mustEmbedUnimplementedWorkloadsServer()
+}
+
+func RegisterWorkloadsServer(s grpc.ServiceRegistrar, srv WorkloadsServer) {
+	s.RegisterService(&_Workloads_serviceDesc, srv)
+}
+
+func _Workloads_RestartWorkload_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Workload)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WorkloadsServer).RestartWorkload(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/telepresence.connector.Workloads/RestartWorkload",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WorkloadsServer).RestartWorkload(ctx, req.(*Workload))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Workloads_InstallAgents_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(InstallAgentsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(WorkloadsServer).InstallAgents(m, &workloadsInstallAgentsServer{stream})
+}
+
+type Workloads_InstallAgentsServer interface {
+	Send(*AgentInstallProgress) error
+	grpc.ServerStream
+}
+
+type workloadsInstallAgentsServer struct {
+	grpc.ServerStream
+}
+
+func (x *workloadsInstallAgentsServer) Send(m *AgentInstallProgress) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+var _Workloads_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "telepresence.connector.Workloads",
+	HandlerType: (*WorkloadsServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "RestartWorkload",
+			Handler:    _Workloads_RestartWorkload_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "InstallAgents",
+			Handler:       _Workloads_InstallAgents_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "rpc/connector/connector.proto",
+}
+
+// InterceptPlansClient is the client API for InterceptPlans service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type InterceptPlansClient interface {
+	// Tells what CreateIntercept would do with the given request, without changing anything.
+	// Requires having already called Connect.
+	PlanIntercept(ctx context.Context, in *CreateInterceptRequest, opts ...grpc.CallOption) (*InterceptPlan, error)
+}
+
+type interceptPlansClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewInterceptPlansClient(cc grpc.ClientConnInterface) InterceptPlansClient {
+	return &interceptPlansClient{cc}
+}
+
+func (c *interceptPlansClient) PlanIntercept(ctx context.Context, in *CreateInterceptRequest, opts ...grpc.CallOption) (*InterceptPlan, error) {
+	out := new(InterceptPlan)
+	err := c.cc.Invoke(ctx, "/telepresence.connector.InterceptPlans/PlanIntercept", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// InterceptPlansServer is the server API for InterceptPlans service.
+// All implementations must embed UnimplementedInterceptPlansServer
+// for forward compatibility
+type InterceptPlansServer interface {
+	// Tells what CreateIntercept would do with the given request, without changing anything.
+	// Requires having already called Connect.
+	PlanIntercept(context.Context, *CreateInterceptRequest) (*InterceptPlan, error)
+	mustEmbedUnimplementedInterceptPlansServer()
+}
+
+// UnimplementedInterceptPlansServer must be embedded to have forward compatible implementations.
+type UnimplementedInterceptPlansServer struct {
+}
+
+func (UnimplementedInterceptPlansServer) PlanIntercept(context.Context, *CreateInterceptRequest) (*InterceptPlan, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PlanIntercept not implemented")
+}
+func (UnimplementedInterceptPlansServer) mustEmbedUnimplementedInterceptPlansServer() {}
+
+// UnsafeInterceptPlansServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to InterceptPlansServer will
+// result in compilation errors.
+type UnsafeInterceptPlansServer interface {
+	mustEmbedUnimplementedInterceptPlansServer()
+}
+
+func RegisterInterceptPlansServer(s grpc.ServiceRegistrar, srv InterceptPlansServer) {
+	s.RegisterService(&_InterceptPlans_serviceDesc, srv)
+}
+
+func _InterceptPlans_PlanIntercept_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateInterceptRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(InterceptPlansServer).PlanIntercept(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/telepresence.connector.InterceptPlans/PlanIntercept",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(InterceptPlansServer).PlanIntercept(ctx, req.(*CreateInterceptRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var _InterceptPlans_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "telepresence.connector.InterceptPlans",
+	HandlerType: (*InterceptPlansServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "PlanIntercept",
+			Handler:    _InterceptPlans_PlanIntercept_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "rpc/connector/connector.proto",
+}
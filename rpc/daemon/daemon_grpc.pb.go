@@ -29,6 +29,22 @@ type DaemonClient interface {
 	SetOutboundInfo(ctx context.Context, in *OutboundInfo, opts ...grpc.CallOption) (*empty.Empty, error)
 	// SetDnsSearchPath sets a new search path.
 	SetDnsSearchPath(ctx context.Context, in *Paths, opts ...grpc.CallOption) (*empty.Empty, error)
+	// AddRoutedProcess makes the daemon route the traffic of the given process, and of the
+	// processes that it starts, to the cluster. It fails with FAILED_PRECONDITION unless the
+	// daemon uses process scoped routing.
+	AddRoutedProcess(ctx context.Context, in *RoutedProcess, opts ...grpc.CallOption) (*empty.Empty, error)
+	// SetClusterDomain tells the daemon the DNS domain of the cluster, so that the DNS resolver
+	// routes the names in that domain to the cluster.
+	SetClusterDomain(ctx context.Context, in *ClusterDomain, opts ...grpc.CallOption) (*empty.Empty, error)
+	// SetExternalRoutes tells the daemon to route the given subnets outside of the cluster
+	// subnets, e.g. the addresses of the nodes and load balancers of the cluster, to the cluster,
+	// and to answer DNS queries for the given host names with the given addresses. Each call
+	// replaces the subnets and hosts of the previous call.
+	SetExternalRoutes(ctx context.Context, in *ExternalRoutes, opts ...grpc.CallOption) (*empty.Empty, error)
+	// ExplainRoute explains how the daemon handles the traffic to the given destination, much
+	// like "ip route get" does for the routing table of the workstation. It fails with
+	// INVALID_ARGUMENT if the destination isn't an IP address with an optional port.
+	ExplainRoute(ctx context.Context, in *RouteDestination, opts ...grpc.CallOption) (*RouteExplanation, error)
 }
 
 type daemonClient struct {
@@ -84,6 +100,42 @@ func (c *daemonClient) SetDnsSearchPath(ctx context.Context, in *Paths, opts ...
 	return out, nil
 }
 
+func (c *daemonClient) AddRoutedProcess(ctx context.Context, in *RoutedProcess, opts ...grpc.CallOption) (*empty.Empty, error) {
+	out := new(empty.Empty)
+	err := c.cc.Invoke(ctx, "/telepresence.daemon.Daemon/AddRoutedProcess", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *daemonClient) SetClusterDomain(ctx context.Context, in *ClusterDomain, opts ...grpc.CallOption) (*empty.Empty, error) {
+	out := new(empty.Empty)
+	err := c.cc.Invoke(ctx, "/telepresence.daemon.Daemon/SetClusterDomain", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *daemonClient) SetExternalRoutes(ctx context.Context, in *ExternalRoutes, opts ...grpc.CallOption) (*empty.Empty, error) {
+	out := new(empty.Empty)
+	err := c.cc.Invoke(ctx, "/telepresence.daemon.Daemon/SetExternalRoutes", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *daemonClient) ExplainRoute(ctx context.Context, in *RouteDestination, opts ...grpc.CallOption) (*RouteExplanation, error) {
+	out := new(RouteExplanation)
+	err := c.cc.Invoke(ctx, "/telepresence.daemon.Daemon/ExplainRoute", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // DaemonServer is the server API for Daemon service.
 // All implementations must embed UnimplementedDaemonServer
 // for forward compatibility
@@ -98,6 +150,22 @@ type DaemonServer interface {
 	SetOutboundInfo(context.Context, *OutboundInfo) (*empty.Empty, error)
 	// SetDnsSearchPath sets a new search path.
 	SetDnsSearchPath(context.Context, *Paths) (*empty.Empty, error)
+	// AddRoutedProcess makes the daemon route the traffic of the given process, and of the
+	// processes that it starts, to the cluster. It fails with FAILED_PRECONDITION unless the
+	// daemon uses process scoped routing.
+	AddRoutedProcess(context.Context, *RoutedProcess) (*empty.Empty, error)
+	// SetClusterDomain tells the daemon the DNS domain of the cluster, so that the DNS resolver
+	// routes the names in that domain to the cluster.
+	SetClusterDomain(context.Context, *ClusterDomain) (*empty.Empty, error)
+	// SetExternalRoutes tells the daemon to route the given subnets outside of the cluster
+	// subnets, e.g. the addresses of the nodes and load balancers of the cluster, to the cluster,
+	// and to answer DNS queries for the given host names with the given addresses. Each call
+	// replaces the subnets and hosts of the previous call.
+	SetExternalRoutes(context.Context, *ExternalRoutes) (*empty.Empty, error)
+	// ExplainRoute explains how the daemon handles the traffic to the given destination, much
+	// like "ip route get" does for the routing table of the workstation. It fails with
+	// INVALID_ARGUMENT if the destination isn't an IP address with an optional port.
+	ExplainRoute(context.Context, *RouteDestination) (*RouteExplanation, error)
 	mustEmbedUnimplementedDaemonServer()
 }
 
@@ -120,6 +188,18 @@ func (UnimplementedDaemonServer) SetOutboundInfo(context.Context, *OutboundInfo)
 func (UnimplementedDaemonServer) SetDnsSearchPath(context.Context, *Paths) (*empty.Empty, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method SetDnsSearchPath not implemented")
 }
+func (UnimplementedDaemonServer) AddRoutedProcess(context.Context, *RoutedProcess) (*empty.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AddRoutedProcess not implemented")
+}
+func (UnimplementedDaemonServer) SetClusterDomain(context.Context, *ClusterDomain) (*empty.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetClusterDomain not implemented")
+}
+func (UnimplementedDaemonServer) SetExternalRoutes(context.Context, *ExternalRoutes) (*empty.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetExternalRoutes not implemented")
+}
+func (UnimplementedDaemonServer) ExplainRoute(context.Context, *RouteDestination) (*RouteExplanation, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ExplainRoute not implemented")
+}
 func (UnimplementedDaemonServer) mustEmbedUnimplementedDaemonServer() {}
 
 // UnsafeDaemonServer may be embedded to opt out of forward compatibility for this service.
@@ -223,6 +303,78 @@ func _Daemon_SetDnsSearchPath_Handler(srv interface{}, ctx context.Context, dec
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Daemon_AddRoutedProcess_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RoutedProcess)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DaemonServer).AddRoutedProcess(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/telepresence.daemon.Daemon/AddRoutedProcess",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DaemonServer).AddRoutedProcess(ctx, req.(*RoutedProcess))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Daemon_SetClusterDomain_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ClusterDomain)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DaemonServer).SetClusterDomain(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/telepresence.daemon.Daemon/SetClusterDomain",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DaemonServer).SetClusterDomain(ctx, req.(*ClusterDomain))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Daemon_SetExternalRoutes_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ExternalRoutes)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DaemonServer).SetExternalRoutes(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/telepresence.daemon.Daemon/SetExternalRoutes",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DaemonServer).SetExternalRoutes(ctx, req.(*ExternalRoutes))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Daemon_ExplainRoute_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RouteDestination)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DaemonServer).ExplainRoute(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/telepresence.daemon.Daemon/ExplainRoute",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DaemonServer).ExplainRoute(ctx, req.(*RouteDestination))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 var _Daemon_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "telepresence.daemon.Daemon",
 	HandlerType: (*DaemonServer)(nil),
@@ -247,6 +399,22 @@ var _Daemon_serviceDesc = grpc.ServiceDesc{
 			MethodName: "SetDnsSearchPath",
 			Handler:    _Daemon_SetDnsSearchPath_Handler,
 		},
+		{
+			MethodName: "AddRoutedProcess",
+			Handler:    _Daemon_AddRoutedProcess_Handler,
+		},
+		{
+			MethodName: "SetClusterDomain",
+			Handler:    _Daemon_SetClusterDomain_Handler,
+		},
+		{
+			MethodName: "SetExternalRoutes",
+			Handler:    _Daemon_SetExternalRoutes_Handler,
+		},
+		{
+			MethodName: "ExplainRoute",
+			Handler:    _Daemon_ExplainRoute_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "rpc/daemon/daemon.proto",